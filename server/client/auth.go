@@ -0,0 +1,60 @@
+package client
+
+import (
+	"context"
+	"net/http"
+	"time"
+)
+
+// User identifies the account behind a session
+type User struct {
+	ID       string `json:"id"`
+	Username string `json:"username"`
+	Role     string `json:"role"`
+}
+
+// Session is the result of a successful login
+type Session struct {
+	Token     string    `json:"token"`
+	ExpiresAt time.Time `json:"expires_at"`
+	User      User      `json:"user"`
+}
+
+// AuthStatus describes whether the current token is valid and whether the server has been set up
+type AuthStatus struct {
+	Authenticated bool       `json:"authenticated"`
+	Initialized   bool       `json:"initialized"`
+	ExpiresAt     *time.Time `json:"expires_at,omitempty"`
+	User          *User      `json:"user,omitempty"`
+}
+
+// Login authenticates with username and password and stores the session token on the client
+func (client *Client) Login(requestContext context.Context, username string, password string) (*Session, error) {
+	var session Session
+	if err := client.doJSON(requestContext, http.MethodPost, "/auth/login", nil, map[string]string{
+		"username": username,
+		"password": password,
+	}, &session); err != nil {
+		return nil, err
+	}
+	client.token = session.Token
+	return &session, nil
+}
+
+// Logout invalidates the current session and clears the token
+func (client *Client) Logout(requestContext context.Context) error {
+	if err := client.doJSON(requestContext, http.MethodPost, "/auth/logout", nil, nil, nil); err != nil {
+		return err
+	}
+	client.token = ""
+	return nil
+}
+
+// AuthStatus reports whether the current token is still valid
+func (client *Client) AuthStatus(requestContext context.Context) (*AuthStatus, error) {
+	var status AuthStatus
+	if err := client.doJSON(requestContext, http.MethodGet, "/auth/status", nil, nil, &status); err != nil {
+		return nil, err
+	}
+	return &status, nil
+}
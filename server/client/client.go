@@ -0,0 +1,162 @@
+// Package client is a typed Go client for the Learning Assistant server API.
+//
+// It reuses the server's own models, so integrators and the command line tools decode the same
+// structs the handlers encode instead of keeping their own copies in sync.
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"lectures/internal/models"
+)
+
+// Resource types returned by the API
+type (
+	Exam              = models.Exam
+	Lecture           = models.Lecture
+	TranscriptSegment = models.TranscriptSegment
+	Tool              = models.Tool
+	Job               = models.Job
+	Upload            = models.Upload
+	ExportPreset      = models.ExportPreset
+	QuizAnswer        = models.QuizAnswer
+	QuizAttempt       = models.QuizAttempt
+)
+
+// Client talks to a Learning Assistant server on behalf of one session
+type Client struct {
+	baseURL    string
+	httpClient *http.Client
+	token      string
+}
+
+// Error is returned for every non-2xx response and carries the server's error envelope
+type Error struct {
+	StatusCode int
+	Code       string
+	Message    string
+	Details    any
+}
+
+func (apiError *Error) Error() string {
+	return fmt.Sprintf("%s (%d): %s", apiError.Code, apiError.StatusCode, apiError.Message)
+}
+
+// IsNotFound reports whether err is an API error with status 404
+func IsNotFound(err error) bool {
+	apiError, ok := err.(*Error)
+	return ok && apiError.StatusCode == http.StatusNotFound
+}
+
+// New creates a client for the server at baseURL (e.g. "http://localhost:3000").
+// A nil httpClient uses a client with a generous timeout suited to chunk uploads
+func New(baseURL string, httpClient *http.Client) *Client {
+	if httpClient == nil {
+		httpClient = &http.Client{Timeout: 5 * time.Minute}
+	}
+	return &Client{
+		baseURL:    strings.TrimRight(baseURL, "/"),
+		httpClient: httpClient,
+	}
+}
+
+// SetToken sets the session token sent with every request
+func (client *Client) SetToken(token string) {
+	client.token = token
+}
+
+// Token returns the current session token
+func (client *Client) Token() string {
+	return client.token
+}
+
+// doJSON sends body encoded as JSON (when not nil) and decodes the response data into result (when not nil)
+func (client *Client) doJSON(requestContext context.Context, method string, path string, query url.Values, body any, result any) error {
+	var bodyReader io.Reader
+	contentType := ""
+	if body != nil {
+		encodedBody, err := json.Marshal(body)
+		if err != nil {
+			return fmt.Errorf("failed to encode request body: %w", err)
+		}
+		bodyReader = bytes.NewReader(encodedBody)
+		contentType = "application/json"
+	}
+	return client.do(requestContext, method, path, query, bodyReader, contentType, result)
+}
+
+// do sends a request to the API and unwraps the {data, meta} envelope into result
+func (client *Client) do(requestContext context.Context, method string, path string, query url.Values, body io.Reader, contentType string, result any) error {
+	response, err := client.send(requestContext, method, path, query, body, contentType)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+
+	if result == nil {
+		io.Copy(io.Discard, response.Body)
+		return nil
+	}
+
+	var envelope struct {
+		Data json.RawMessage `json:"data"`
+	}
+	if err := json.NewDecoder(response.Body).Decode(&envelope); err != nil {
+		return fmt.Errorf("failed to decode response: %w", err)
+	}
+	if len(envelope.Data) == 0 {
+		return nil
+	}
+	if err := json.Unmarshal(envelope.Data, result); err != nil {
+		return fmt.Errorf("failed to decode response data: %w", err)
+	}
+	return nil
+}
+
+// send performs the request and converts error envelopes into *Error; the caller closes the body on success
+func (client *Client) send(requestContext context.Context, method string, path string, query url.Values, body io.Reader, contentType string) (*http.Response, error) {
+	requestURL := client.baseURL + "/api" + path
+	if len(query) > 0 {
+		requestURL += "?" + query.Encode()
+	}
+
+	request, err := http.NewRequestWithContext(requestContext, method, requestURL, body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	// Required by the server's CSRF check on state-changing requests
+	request.Header.Set("X-Requested-With", "XMLHttpRequest")
+	if client.token != "" {
+		request.Header.Set("Authorization", "Bearer "+client.token)
+	}
+	if contentType != "" {
+		request.Header.Set("Content-Type", contentType)
+	}
+
+	response, err := client.httpClient.Do(request)
+	if err != nil {
+		return nil, err
+	}
+
+	if response.StatusCode >= 200 && response.StatusCode < 300 {
+		return response, nil
+	}
+	defer response.Body.Close()
+
+	apiError := &Error{StatusCode: response.StatusCode, Code: "HTTP_ERROR", Message: response.Status}
+	var envelope models.APIError
+	if json.NewDecoder(response.Body).Decode(&envelope) == nil && envelope.Error.Code != "" {
+		apiError.Code = envelope.Error.Code
+		apiError.Message = envelope.Error.Message
+		apiError.Details = envelope.Error.Details
+	}
+	return nil, apiError
+}
@@ -0,0 +1,159 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"lectures/internal/api"
+	"lectures/internal/configuration"
+	"lectures/internal/database"
+	"lectures/internal/jobs"
+	"lectures/internal/llm"
+	"lectures/internal/models"
+	"lectures/internal/tools"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+type staticLLMProvider struct{}
+
+func (provider *staticLLMProvider) Chat(requestContext context.Context, request *llm.ChatRequest) (<-chan llm.ChatResponseChunk, error) {
+	responseChannel := make(chan llm.ChatResponseChunk, 1)
+	responseChannel <- llm.ChatResponseChunk{Text: `{"title": "Client Title", "description": "Client Description"}`}
+	close(responseChannel)
+	return responseChannel, nil
+}
+
+func (provider *staticLLMProvider) Name() string { return "static" }
+
+func TestClient_EndToEnd(tester *testing.T) {
+	temporaryDirectory := tester.TempDir()
+	initializedDatabase, err := database.Initialize(filepath.Join(temporaryDirectory, "test.db"))
+	if err != nil {
+		tester.Fatalf("Failed to initialize database: %v", err)
+	}
+	defer initializedDatabase.Close()
+
+	passwordHash, _ := bcrypt.GenerateFromPassword([]byte("password123"), bcrypt.DefaultCost)
+	initializedDatabase.Exec("INSERT INTO users (id, username, password_hash, role) VALUES (?, ?, ?, ?)", "user-client", "client", string(passwordHash), "teacher")
+
+	config := &configuration.Configuration{
+		Storage:  configuration.StorageConfiguration{DataDirectory: temporaryDirectory},
+		Security: configuration.SecurityConfiguration{Auth: configuration.AuthConfiguration{Type: "session", SessionTimeoutHours: 1}},
+		Safety:   configuration.SafetyConfiguration{MaximumLoginAttempts: 100},
+		Uploads: configuration.UploadsConfiguration{
+			Documents: configuration.DocumentUploadConfiguration{SupportedFormats: []string{"pdf"}},
+		},
+	}
+
+	jobQueue := jobs.NewQueue(initializedDatabase, 1)
+	jobQueue.RegisterHandler("CLIENT_TEST", func(jobContext context.Context, job *models.Job, updateProgress func(int, string, any, models.JobMetrics)) error {
+		updateProgress(50, "Halfway", nil, models.JobMetrics{})
+		job.Result = `{"done": true}`
+		return nil
+	})
+	jobQueue.Start()
+	defer jobQueue.Stop()
+
+	provider := &staticLLMProvider{}
+	toolGenerator := tools.NewToolGenerator(config, provider, nil)
+	apiServer := api.NewServer(config, initializedDatabase, jobQueue, provider, nil, toolGenerator, nil)
+	testServer := httptest.NewServer(apiServer.Handler())
+	defer testServer.Close()
+
+	requestContext, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	apiClient := New(testServer.URL, nil)
+
+	if _, err := apiClient.Login(requestContext, "client", "wrong-password"); err == nil {
+		tester.Fatal("Expected login with a wrong password to fail")
+	} else if apiError, ok := err.(*Error); !ok || apiError.StatusCode != 401 {
+		tester.Fatalf("Expected a 401 API error, got %v", err)
+	}
+
+	session, err := apiClient.Login(requestContext, "client", "password123")
+	if err != nil {
+		tester.Fatalf("Login failed: %v", err)
+	}
+	if session.Token == "" || session.User.ID != "user-client" || apiClient.Token() != session.Token {
+		tester.Fatalf("Unexpected session: %+v", session)
+	}
+
+	exam, err := apiClient.CreateExam(requestContext, CreateExamRequest{Title: "Physics"})
+	if err != nil {
+		tester.Fatalf("CreateExam failed: %v", err)
+	}
+	if _, err := apiClient.GetExam(requestContext, "missing-exam"); !IsNotFound(err) {
+		tester.Fatalf("Expected not found for a missing exam, got %v", err)
+	}
+
+	content := []byte(strings.Repeat("a", 2048))
+	var lastProgress int64
+	uploadID, err := apiClient.UploadFile(requestContext, "notes.pdf", int64(len(content)), bytes.NewReader(content), func(bytesReceived int64, fileSizeBytes int64) {
+		lastProgress = bytesReceived
+	})
+	if err != nil {
+		tester.Fatalf("UploadFile failed: %v", err)
+	}
+	if lastProgress != int64(len(content)) {
+		tester.Errorf("Expected progress to reach %d bytes, got %d", len(content), lastProgress)
+	}
+
+	upload, err := apiClient.GetUpload(requestContext, uploadID)
+	if err != nil {
+		tester.Fatalf("GetUpload failed: %v", err)
+	}
+	if upload.Status != models.UploadStatusStaged || upload.BytesReceived != int64(len(content)) {
+		tester.Errorf("Unexpected upload state: %+v", upload)
+	}
+	defer os.RemoveAll(filepath.Join(os.TempDir(), "lectures-uploads", uploadID))
+
+	lecture, err := apiClient.CreateLecture(requestContext, CreateLectureRequest{
+		ExamID:            exam.ID,
+		Title:             "Lecture 1",
+		DocumentUploadIDs: []string{uploadID},
+	})
+	if err != nil {
+		tester.Fatalf("CreateLecture failed: %v", err)
+	}
+	lectures, err := apiClient.ListLectures(requestContext, exam.ID)
+	if err != nil || len(lectures) != 1 || lectures[0].ID != lecture.ID {
+		tester.Fatalf("Expected the created lecture to be listed, got %+v (%v)", lectures, err)
+	}
+
+	jobID, err := jobQueue.Enqueue("user-client", "CLIENT_TEST", map[string]string{}, exam.ID, "")
+	if err != nil {
+		tester.Fatalf("Failed to enqueue job: %v", err)
+	}
+	var updates []JobUpdate
+	job, err := apiClient.WatchJob(requestContext, jobID, func(update JobUpdate) {
+		updates = append(updates, update)
+	})
+	if err != nil {
+		tester.Fatalf("WatchJob failed: %v", err)
+	}
+	if job.Status != models.JobStatusCompleted || job.Result != `{"done": true}` {
+		tester.Errorf("Unexpected final job: %+v", job)
+	}
+	if len(updates) == 0 || !updates[len(updates)-1].Finished() {
+		tester.Errorf("Expected the last streamed update to be terminal, got %+v", updates)
+	}
+
+	if err := apiClient.Logout(requestContext); err != nil {
+		tester.Fatalf("Logout failed: %v", err)
+	}
+	status, err := apiClient.AuthStatus(requestContext)
+	if err != nil {
+		tester.Fatalf("AuthStatus failed: %v", err)
+	}
+	if status.Authenticated {
+		tester.Error("Expected the session to be gone after logout")
+	}
+}
@@ -0,0 +1,246 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"lectures/internal/models"
+
+	"github.com/gorilla/websocket"
+)
+
+// JobUpdate is one progress event streamed for a background job
+type JobUpdate struct {
+	JobID               string             `json:"id"`
+	Type                string             `json:"type"`
+	Status              string             `json:"status"`
+	Paused              bool               `json:"paused,omitempty"`
+	Progress            int                `json:"progress"`
+	ProgressMessageText string             `json:"progress_message_text"`
+	Metadata            any                `json:"metadata"`
+	CourseID            string             `json:"course_id"`
+	LectureID           string             `json:"lecture_id"`
+	Error               string             `json:"error"`
+	Failure             *models.JobFailure `json:"failure,omitempty"`
+	Result              string             `json:"result"`
+	InputTokens         int                `json:"input_tokens"`
+	OutputTokens        int                `json:"output_tokens"`
+	EstimatedCost       float64            `json:"estimated_cost"`
+}
+
+// Finished reports whether the job reached a terminal status
+func (update JobUpdate) Finished() bool {
+	return isTerminalJobStatus(update.Status)
+}
+
+// ListJobs lists the caller's recent jobs, optionally filtered by exam (course) and lecture
+func (client *Client) ListJobs(requestContext context.Context, courseID string, lectureID string) ([]Job, error) {
+	query := url.Values{}
+	if courseID != "" {
+		query.Set("course_id", courseID)
+	}
+	if lectureID != "" {
+		query.Set("lecture_id", lectureID)
+	}
+	var jobs []Job
+	if err := client.doJSON(requestContext, http.MethodGet, "/jobs", query, nil, &jobs); err != nil {
+		return nil, err
+	}
+	return jobs, nil
+}
+
+// ListLabeledJobs lists the caller's recent jobs carrying a label
+func (client *Client) ListLabeledJobs(requestContext context.Context, label string) ([]Job, error) {
+	var jobs []Job
+	if err := client.doJSON(requestContext, http.MethodGet, "/jobs", url.Values{"label": {label}}, nil, &jobs); err != nil {
+		return nil, err
+	}
+	return jobs, nil
+}
+
+// JobLabel is a label the caller gave their jobs, with how many carry it
+type JobLabel struct {
+	Label string `json:"label"`
+	Jobs  int    `json:"jobs"`
+}
+
+// ListJobLabels lists the labels of the caller's jobs, most used first
+func (client *Client) ListJobLabels(requestContext context.Context) ([]JobLabel, error) {
+	var labels []JobLabel
+	if err := client.doJSON(requestContext, http.MethodGet, "/jobs/labels", nil, nil, &labels); err != nil {
+		return nil, err
+	}
+	return labels, nil
+}
+
+// LabelJob sets the label and note of a job, empty values clearing them
+func (client *Client) LabelJob(requestContext context.Context, jobID string, label string, note string) (*Job, error) {
+	var job Job
+	if err := client.doJSON(requestContext, http.MethodPatch, "/jobs", nil, map[string]any{"job_id": jobID, "label": label, "note": note}, &job); err != nil {
+		return nil, err
+	}
+	return &job, nil
+}
+
+// GetJob returns the current state of a job
+func (client *Client) GetJob(requestContext context.Context, jobID string) (*Job, error) {
+	var job Job
+	if err := client.doJSON(requestContext, http.MethodGet, "/jobs/details", url.Values{"job_id": {jobID}}, nil, &job); err != nil {
+		return nil, err
+	}
+	return &job, nil
+}
+
+// CancelJob cancels a pending or running job
+func (client *Client) CancelJob(requestContext context.Context, jobID string) error {
+	return client.doJSON(requestContext, http.MethodDelete, "/jobs", nil, map[string]any{"job_id": jobID}, nil)
+}
+
+// PauseJob pauses a pending job, or asks a running one to stop at its next page or section
+func (client *Client) PauseJob(requestContext context.Context, jobID string) (*Job, error) {
+	var job Job
+	if err := client.doJSON(requestContext, http.MethodPost, "/jobs/pause", nil, map[string]any{"job_id": jobID}, &job); err != nil {
+		return nil, err
+	}
+	return &job, nil
+}
+
+// ResumeJob lets a paused job continue from where it stopped
+func (client *Client) ResumeJob(requestContext context.Context, jobID string) (*Job, error) {
+	var job Job
+	if err := client.doJSON(requestContext, http.MethodPost, "/jobs/resume", nil, map[string]any{"job_id": jobID}, &job); err != nil {
+		return nil, err
+	}
+	return &job, nil
+}
+
+// ListDeadJobs lists the failed jobs that were not requeued, optionally only those failing with failureCode
+func (client *Client) ListDeadJobs(requestContext context.Context, failureCode string) ([]Job, error) {
+	query := url.Values{}
+	if failureCode != "" {
+		query.Set("code", failureCode)
+	}
+	var deadLetters struct {
+		Jobs []Job `json:"jobs"`
+	}
+	if err := client.doJSON(requestContext, http.MethodGet, "/jobs/dead", query, nil, &deadLetters); err != nil {
+		return nil, err
+	}
+	return deadLetters.Jobs, nil
+}
+
+// RequeueJob clones a failed job into a fresh pending one and returns the new job
+func (client *Client) RequeueJob(requestContext context.Context, jobID string) (*Job, error) {
+	var job Job
+	if err := client.doJSON(requestContext, http.MethodPost, "/jobs/requeue", nil, map[string]any{"job_id": jobID}, &job); err != nil {
+		return nil, err
+	}
+	return &job, nil
+}
+
+// WatchJob streams progress updates for a job over the WebSocket until it completes, fails or is cancelled.
+// onUpdate is called for every update, including the final one; the returned job is re-read once it finished
+func (client *Client) WatchJob(requestContext context.Context, jobID string, onUpdate func(JobUpdate)) (*Job, error) {
+	socketURL, err := url.Parse(client.baseURL + "/api/socket")
+	if err != nil {
+		return nil, fmt.Errorf("invalid base URL: %w", err)
+	}
+	socketURL.Scheme = strings.Replace(socketURL.Scheme, "http", "ws", 1)
+
+	header := http.Header{}
+	if client.token != "" {
+		header.Set("Authorization", "Bearer "+client.token)
+	}
+	connection, _, err := websocket.DefaultDialer.DialContext(requestContext, socketURL.String(), header)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to the job stream: %w", err)
+	}
+	defer connection.Close()
+
+	// Unblock the read loop when the caller gives up
+	stopWatching := make(chan struct{})
+	defer close(stopWatching)
+	go func() {
+		select {
+		case <-requestContext.Done():
+			connection.Close()
+		case <-stopWatching:
+		}
+	}()
+
+	channel := "job:" + jobID
+	if err := connection.WriteJSON(map[string]string{"type": "subscribe", "channel": channel}); err != nil {
+		return nil, fmt.Errorf("failed to subscribe to job: %w", err)
+	}
+
+	for {
+		var message struct {
+			Type    string          `json:"type"`
+			Channel string          `json:"channel"`
+			Payload json.RawMessage `json:"payload"`
+		}
+		if err := connection.ReadJSON(&message); err != nil {
+			if requestContext.Err() != nil {
+				return nil, requestContext.Err()
+			}
+			return nil, fmt.Errorf("job stream closed: %w", err)
+		}
+		if message.Channel != channel {
+			continue
+		}
+
+		switch message.Type {
+		case "subscribed":
+			// The job may have finished before the subscription was in place
+			job, err := client.GetJob(requestContext, jobID)
+			if err != nil {
+				return nil, err
+			}
+			if isTerminalJobStatus(job.Status) {
+				if onUpdate != nil {
+					onUpdate(jobUpdateFromJob(job))
+				}
+				return job, nil
+			}
+		case "job:progress":
+			var update JobUpdate
+			if err := json.Unmarshal(message.Payload, &update); err != nil {
+				continue
+			}
+			if onUpdate != nil {
+				onUpdate(update)
+			}
+			if update.Finished() {
+				return client.GetJob(requestContext, jobID)
+			}
+		}
+	}
+}
+
+func jobUpdateFromJob(job *Job) JobUpdate {
+	return JobUpdate{
+		JobID:               job.ID,
+		Type:                job.Type,
+		Status:              job.Status,
+		Paused:              job.PausedAt != nil,
+		Progress:            job.Progress,
+		ProgressMessageText: job.ProgressMessageText,
+		Metadata:            job.Metadata,
+		CourseID:            job.CourseID,
+		LectureID:           job.LectureID,
+		Error:               job.Error,
+		Failure:             job.Failure,
+		Result:              job.Result,
+		InputTokens:         job.InputTokens,
+		OutputTokens:        job.OutputTokens,
+		EstimatedCost:       job.EstimatedCost,
+	}
+}
+
+func isTerminalJobStatus(status string) bool {
+	return status == models.JobStatusCompleted || status == models.JobStatusFailed || status == models.JobStatusCancelled
+}
@@ -0,0 +1,123 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// CreateExamRequest holds the fields accepted when creating an exam
+type CreateExamRequest struct {
+	Title       string `json:"title"`
+	Description string `json:"description,omitempty"`
+	Language    string `json:"language,omitempty"`
+}
+
+// CreateLectureRequest holds the fields accepted when creating a lecture from staged uploads
+type CreateLectureRequest struct {
+	ExamID            string
+	Title             string
+	Description       string
+	Language          string
+	SpecifiedDate     *time.Time
+	Diarize           *bool    // Nil keeps the server's transcription.diarize setting
+	BuildTypes        []string // Tools ("guide", "flashcard", "quiz", "mindmap") built once transcription and ingestion complete
+	MediaUploadIDs    []string
+	DocumentUploadIDs []string
+}
+
+// CreateExam creates an exam
+func (client *Client) CreateExam(requestContext context.Context, createRequest CreateExamRequest) (*Exam, error) {
+	var exam Exam
+	if err := client.doJSON(requestContext, http.MethodPost, "/exams", nil, createRequest, &exam); err != nil {
+		return nil, err
+	}
+	return &exam, nil
+}
+
+// ListExams lists the caller's exams
+func (client *Client) ListExams(requestContext context.Context) ([]Exam, error) {
+	var exams []Exam
+	if err := client.doJSON(requestContext, http.MethodGet, "/exams", nil, nil, &exams); err != nil {
+		return nil, err
+	}
+	return exams, nil
+}
+
+// GetExam returns one exam
+func (client *Client) GetExam(requestContext context.Context, examID string) (*Exam, error) {
+	var exam Exam
+	if err := client.doJSON(requestContext, http.MethodGet, "/exams/details", url.Values{"exam_id": {examID}}, nil, &exam); err != nil {
+		return nil, err
+	}
+	return &exam, nil
+}
+
+// CreateLecture creates a lecture, binds the staged uploads to it and starts transcription and ingestion
+func (client *Client) CreateLecture(requestContext context.Context, createRequest CreateLectureRequest) (*Lecture, error) {
+	var body bytes.Buffer
+	formWriter := multipart.NewWriter(&body)
+	formWriter.WriteField("exam_id", createRequest.ExamID)
+	formWriter.WriteField("title", createRequest.Title)
+	if createRequest.Description != "" {
+		formWriter.WriteField("description", createRequest.Description)
+	}
+	if createRequest.Language != "" {
+		formWriter.WriteField("language", createRequest.Language)
+	}
+	if createRequest.SpecifiedDate != nil {
+		formWriter.WriteField("specified_date", createRequest.SpecifiedDate.Format(time.RFC3339))
+	}
+	if createRequest.Diarize != nil {
+		formWriter.WriteField("diarize", strconv.FormatBool(*createRequest.Diarize))
+	}
+	for _, buildType := range createRequest.BuildTypes {
+		formWriter.WriteField("build_types", buildType)
+	}
+	for _, uploadID := range createRequest.MediaUploadIDs {
+		formWriter.WriteField("media_upload_ids", uploadID)
+	}
+	for _, uploadID := range createRequest.DocumentUploadIDs {
+		formWriter.WriteField("document_upload_ids", uploadID)
+	}
+	formWriter.Close()
+
+	var lecture Lecture
+	if err := client.do(requestContext, http.MethodPost, "/lectures", nil, &body, formWriter.FormDataContentType(), &lecture); err != nil {
+		return nil, err
+	}
+	return &lecture, nil
+}
+
+// ListLectures lists the lectures of an exam
+func (client *Client) ListLectures(requestContext context.Context, examID string) ([]Lecture, error) {
+	var lectures []Lecture
+	if err := client.doJSON(requestContext, http.MethodGet, "/lectures", url.Values{"exam_id": {examID}}, nil, &lectures); err != nil {
+		return nil, err
+	}
+	return lectures, nil
+}
+
+// GetLecture returns a lecture and its processing status
+func (client *Client) GetLecture(requestContext context.Context, examID string, lectureID string) (*Lecture, error) {
+	var lecture Lecture
+	if err := client.doJSON(requestContext, http.MethodGet, "/lectures/details", url.Values{"exam_id": {examID}, "lecture_id": {lectureID}}, nil, &lecture); err != nil {
+		return nil, err
+	}
+	return &lecture, nil
+}
+
+// GetTranscript returns the transcript segments of a lecture
+func (client *Client) GetTranscript(requestContext context.Context, lectureID string) ([]TranscriptSegment, error) {
+	var transcript struct {
+		Segments []TranscriptSegment `json:"segments"`
+	}
+	if err := client.doJSON(requestContext, http.MethodGet, "/transcripts", url.Values{"lecture_id": {lectureID}}, nil, &transcript); err != nil {
+		return nil, err
+	}
+	return transcript.Segments, nil
+}
@@ -0,0 +1,127 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+)
+
+// CreateToolRequest holds the fields accepted when generating a study tool; empty fields use the exam defaults
+type CreateToolRequest struct {
+	ExamID                  string `json:"exam_id"`
+	LectureID               string `json:"lecture_id"`
+	Type                    string `json:"type"` // "guide", "flashcard", "quiz", "mindmap"
+	Length                  string `json:"length,omitempty"`
+	LanguageCode            string `json:"language_code,omitempty"`
+	EnableDocumentsMatching *bool  `json:"enable_documents_matching,omitempty"`
+	AdherenceThreshold      int    `json:"adherence_threshold,omitempty"`
+	MaximumRetries          int    `json:"maximum_retries,omitempty"`
+	GenerateImages          bool   `json:"generate_images,omitempty"`
+	ModelDocumentsMatching  string `json:"model_documents_matching,omitempty"`
+	ModelStructure          string `json:"model_structure,omitempty"`
+	ModelGeneration         string `json:"model_generation,omitempty"`
+	ModelAdherence          string `json:"model_adherence,omitempty"`
+	ModelPolishing          string `json:"model_polishing,omitempty"`
+}
+
+// ExportToolRequest holds the fields accepted when exporting a tool
+type ExportToolRequest struct {
+	ToolID        string `json:"tool_id"`
+	ExamID        string `json:"exam_id"`
+	Format        string `json:"format"` // "pdf", "docx", "epub", "html", "md", "anki", "csv"
+	Theme         string `json:"theme,omitempty"`
+	IncludeImages *bool  `json:"include_images,omitempty"`
+	IncludeQRCode *bool  `json:"include_qr_code,omitempty"`
+	SelfContained bool   `json:"self_contained,omitempty"`
+}
+
+// CreateTool starts a generation job and returns its ID; watch it with WatchJob
+func (client *Client) CreateTool(requestContext context.Context, createRequest CreateToolRequest) (string, error) {
+	var accepted struct {
+		JobID string `json:"job_id"`
+	}
+	if err := client.doJSON(requestContext, http.MethodPost, "/tools", nil, createRequest, &accepted); err != nil {
+		return "", err
+	}
+	return accepted.JobID, nil
+}
+
+// ListTools lists the tools of an exam, optionally restricted to a lecture and a tool type
+func (client *Client) ListTools(requestContext context.Context, examID string, lectureID string, toolType string) ([]Tool, error) {
+	query := url.Values{}
+	if examID != "" {
+		query.Set("exam_id", examID)
+	}
+	if lectureID != "" {
+		query.Set("lecture_id", lectureID)
+	}
+	if toolType != "" {
+		query.Set("type", toolType)
+	}
+	var tools []Tool
+	if err := client.doJSON(requestContext, http.MethodGet, "/tools", query, nil, &tools); err != nil {
+		return nil, err
+	}
+	return tools, nil
+}
+
+// GetTool returns a tool including its content
+func (client *Client) GetTool(requestContext context.Context, examID string, toolID string) (*Tool, error) {
+	var tool Tool
+	if err := client.doJSON(requestContext, http.MethodGet, "/tools/details", url.Values{"exam_id": {examID}, "tool_id": {toolID}}, nil, &tool); err != nil {
+		return nil, err
+	}
+	return &tool, nil
+}
+
+// ExportTool starts an export job and returns its ID; download the file with DownloadExport once it completes
+func (client *Client) ExportTool(requestContext context.Context, exportRequest ExportToolRequest) (string, error) {
+	var accepted struct {
+		JobID string `json:"job_id"`
+	}
+	if err := client.doJSON(requestContext, http.MethodPost, "/tools/export", nil, exportRequest, &accepted); err != nil {
+		return "", err
+	}
+	return accepted.JobID, nil
+}
+
+// SubmitQuizAttempt grades answers to a quiz, one per question in order, and returns the stored attempt
+func (client *Client) SubmitQuizAttempt(requestContext context.Context, examID string, toolID string, answers []QuizAnswer) (*QuizAttempt, error) {
+	body := map[string]any{"exam_id": examID, "tool_id": toolID, "answers": answers}
+	var attempt QuizAttempt
+	if err := client.doJSON(requestContext, http.MethodPost, "/tools/quiz/attempts", nil, body, &attempt); err != nil {
+		return nil, err
+	}
+	return &attempt, nil
+}
+
+// ListQuizAttempts lists the caller's attempts at a quiz, newest first
+func (client *Client) ListQuizAttempts(requestContext context.Context, examID string, toolID string) ([]QuizAttempt, error) {
+	var attempts []QuizAttempt
+	if err := client.doJSON(requestContext, http.MethodGet, "/tools/quiz/attempts", url.Values{"exam_id": {examID}, "tool_id": {toolID}}, nil, &attempts); err != nil {
+		return nil, err
+	}
+	return attempts, nil
+}
+
+// DownloadExport writes the file produced by a completed export or publish job to destination
+func (client *Client) DownloadExport(requestContext context.Context, job *Job, destination io.Writer) error {
+	var result struct {
+		FilePath string `json:"file_path"`
+	}
+	if err := json.Unmarshal([]byte(job.Result), &result); err != nil || result.FilePath == "" {
+		return fmt.Errorf("job %s has no export file", job.ID)
+	}
+
+	response, err := client.send(requestContext, http.MethodGet, "/exports/download", url.Values{"path": {result.FilePath}}, nil, "")
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+
+	_, err = io.Copy(destination, response.Body)
+	return err
+}
@@ -0,0 +1,102 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+)
+
+// PreparedUpload is a staging session created by PrepareUpload
+type PreparedUpload struct {
+	UploadID       string `json:"upload_id"`
+	ChunkSizeBytes int64  `json:"chunk_size_bytes"`
+}
+
+// PrepareUpload opens a staging session for a file of the given size
+func (client *Client) PrepareUpload(requestContext context.Context, filename string, fileSizeBytes int64) (*PreparedUpload, error) {
+	var prepared PreparedUpload
+	if err := client.doJSON(requestContext, http.MethodPost, "/uploads/prepare", nil, map[string]any{
+		"filename":        filename,
+		"file_size_bytes": fileSizeBytes,
+	}, &prepared); err != nil {
+		return nil, err
+	}
+	return &prepared, nil
+}
+
+// AppendUpload streams one chunk to the staging session and returns the total bytes received so far
+func (client *Client) AppendUpload(requestContext context.Context, uploadID string, chunk io.Reader) (int64, error) {
+	var appended struct {
+		BytesReceived int64 `json:"bytes_received"`
+	}
+	if err := client.do(requestContext, http.MethodPost, "/uploads/append", url.Values{"upload_id": {uploadID}}, chunk, "application/octet-stream", &appended); err != nil {
+		return 0, err
+	}
+	return appended.BytesReceived, nil
+}
+
+// StageUpload finalizes the staging session so it can be bound to a lecture
+func (client *Client) StageUpload(requestContext context.Context, uploadID string) error {
+	return client.doJSON(requestContext, http.MethodPost, "/uploads/stage", nil, map[string]string{"upload_id": uploadID}, nil)
+}
+
+// UploadFile runs prepare, append and stage for a whole file and returns the staged upload ID.
+// onProgress, when not nil, is called after every chunk with the bytes received so far
+func (client *Client) UploadFile(requestContext context.Context, filename string, fileSizeBytes int64, content io.Reader, onProgress func(bytesReceived int64, fileSizeBytes int64)) (string, error) {
+	prepared, err := client.PrepareUpload(requestContext, filename, fileSizeBytes)
+	if err != nil {
+		return "", err
+	}
+
+	chunkSize := prepared.ChunkSizeBytes
+	if chunkSize <= 0 {
+		chunkSize = 10 * 1024 * 1024
+	}
+
+	buffer := make([]byte, chunkSize)
+	var bytesReceived int64
+	for bytesReceived < fileSizeBytes {
+		readCount, readError := io.ReadFull(content, buffer)
+		if readCount > 0 {
+			bytesReceived, err = client.AppendUpload(requestContext, prepared.UploadID, bytes.NewReader(buffer[:readCount]))
+			if err != nil {
+				return "", fmt.Errorf("failed to append chunk: %w", err)
+			}
+			if onProgress != nil {
+				onProgress(bytesReceived, fileSizeBytes)
+			}
+		}
+		if readError == io.EOF || readError == io.ErrUnexpectedEOF {
+			break
+		}
+		if readError != nil {
+			return "", fmt.Errorf("failed to read upload content: %w", readError)
+		}
+	}
+
+	if err := client.StageUpload(requestContext, prepared.UploadID); err != nil {
+		return "", err
+	}
+	return prepared.UploadID, nil
+}
+
+// ListUploads lists the caller's upload sessions that have not been bound to a lecture yet
+func (client *Client) ListUploads(requestContext context.Context) ([]Upload, error) {
+	var uploads []Upload
+	if err := client.doJSON(requestContext, http.MethodGet, "/uploads", nil, nil, &uploads); err != nil {
+		return nil, err
+	}
+	return uploads, nil
+}
+
+// GetUpload returns the persisted progress of one upload session
+func (client *Client) GetUpload(requestContext context.Context, uploadID string) (*Upload, error) {
+	var upload Upload
+	if err := client.doJSON(requestContext, http.MethodGet, "/uploads/details", url.Values{"upload_id": {uploadID}}, nil, &upload); err != nil {
+		return nil, err
+	}
+	return &upload, nil
+}
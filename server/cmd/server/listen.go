@@ -0,0 +1,107 @@
+package main
+
+import (
+	"crypto/tls"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"lectures/internal/configuration"
+
+	"golang.org/x/crypto/acme"
+	"golang.org/x/crypto/acme/autocert"
+)
+
+// Timeouts of the HTTP servers, so idle or slow clients cannot hold connections open. Writes are not bounded, as
+// event streams, WebSockets and downloads of exports last as long as they need
+const (
+	readHeaderTimeout = 10 * time.Second
+	readTimeout       = 10 * time.Minute // Bounds whole request bodies, such as restored backup archives
+	idleTimeout       = 2 * time.Minute
+)
+
+// newHTTPServer returns a server of a handler on an address, with the timeouts above
+func newHTTPServer(address string, handler http.Handler) *http.Server {
+	return &http.Server{
+		Addr:              address,
+		Handler:           handler,
+		ReadHeaderTimeout: readHeaderTimeout,
+		ReadTimeout:       readTimeout,
+		IdleTimeout:       idleTimeout,
+	}
+}
+
+// listenAndServe serves the API over plain HTTP, or over HTTPS when server.tls is configured, along with the
+// port redirecting to it. It only returns when the server fails
+func listenAndServe(loadedConfiguration *configuration.Configuration, handler http.Handler) error {
+	serverConfiguration := loadedConfiguration.Server
+	serverAddress := net.JoinHostPort(serverConfiguration.Host, strconv.Itoa(serverConfiguration.Port))
+	tlsConfiguration := serverConfiguration.TLS
+	if !tlsConfiguration.Enabled() {
+		slog.Info("Server starting", "address", serverAddress)
+		return newHTTPServer(serverAddress, handler).ListenAndServe()
+	}
+
+	httpServer := newHTTPServer(serverAddress, handler)
+	httpServer.TLSConfig = &tls.Config{MinVersion: tls.VersionTLS12}
+	redirectHandler := httpsRedirectHandler(serverConfiguration.Port)
+
+	if tlsConfiguration.CertificateFile != "" || tlsConfiguration.KeyFile != "" {
+		if tlsConfiguration.CertificateFile == "" || tlsConfiguration.KeyFile == "" {
+			return fmt.Errorf("server.tls needs both certificate_file and key_file")
+		}
+		if len(tlsConfiguration.AutocertDomains) > 0 {
+			slog.Warn("Ignoring server.tls.autocert_domains, as certificate files are configured")
+		}
+	} else {
+		cacheDirectory := tlsConfiguration.AutocertCacheDirectory
+		if cacheDirectory == "" {
+			cacheDirectory = filepath.Join(loadedConfiguration.Storage.DataDirectory, "certificates")
+		}
+		certificateManager := &autocert.Manager{
+			Prompt:     autocert.AcceptTOS,
+			HostPolicy: autocert.HostWhitelist(tlsConfiguration.AutocertDomains...),
+			Cache:      autocert.DirCache(cacheDirectory),
+			Email:      tlsConfiguration.AutocertEmail,
+		}
+		if tlsConfiguration.AutocertDirectoryURL != "" {
+			certificateManager.Client = &acme.Client{DirectoryURL: tlsConfiguration.AutocertDirectoryURL}
+		}
+		// Answers TLS-ALPN challenges on the HTTPS port, and HTTP ones on the redirect port
+		httpServer.TLSConfig = certificateManager.TLSConfig()
+		httpServer.TLSConfig.MinVersion = tls.VersionTLS12
+		redirectHandler = certificateManager.HTTPHandler(redirectHandler)
+		slog.Info("Obtaining TLS certificates automatically", "domains", tlsConfiguration.AutocertDomains, "cache", cacheDirectory)
+	}
+
+	if tlsConfiguration.RedirectPort > 0 {
+		redirectAddress := net.JoinHostPort(serverConfiguration.Host, strconv.Itoa(tlsConfiguration.RedirectPort))
+		go func() {
+			slog.Info("HTTPS redirect starting", "address", redirectAddress)
+			if err := newHTTPServer(redirectAddress, redirectHandler).ListenAndServe(); err != nil {
+				slog.Error("HTTPS redirect failed", "error", err)
+			}
+		}()
+	}
+
+	slog.Info("Server starting with TLS", "address", serverAddress)
+	return httpServer.ListenAndServeTLS(tlsConfiguration.CertificateFile, tlsConfiguration.KeyFile)
+}
+
+// httpsRedirectHandler sends plain HTTP requests to the same URL over HTTPS on a port
+func httpsRedirectHandler(httpsPort int) http.Handler {
+	return http.HandlerFunc(func(responseWriter http.ResponseWriter, request *http.Request) {
+		host := request.Host
+		if hostname, _, err := net.SplitHostPort(host); err == nil {
+			host = hostname
+		}
+		if httpsPort != 443 {
+			host = net.JoinHostPort(host, strconv.Itoa(httpsPort))
+		}
+		http.Redirect(responseWriter, request, "https://"+host+request.URL.RequestURI(), http.StatusMovedPermanently)
+	})
+}
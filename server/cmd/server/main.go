@@ -1,30 +1,47 @@
 package main
 
 import (
+	"context"
+	"database/sql"
 	"flag"
-	"fmt"
 	"io"
 	"log"
 	"log/slog"
-	"net/http"
 	"os"
 	"path/filepath"
+	"time"
 
 	"lectures/internal/api"
 	"lectures/internal/configuration"
 	"lectures/internal/database"
 	"lectures/internal/documents"
+	"lectures/internal/embeddings"
 	"lectures/internal/jobs"
 	"lectures/internal/llm"
+	"lectures/internal/mail"
 	"lectures/internal/markdown"
 	"lectures/internal/media"
 	"lectures/internal/models"
 	"lectures/internal/prompts"
+	"lectures/internal/secrets"
+	"lectures/internal/storage"
 	"lectures/internal/tools"
 	"lectures/internal/transcription"
+	"lectures/internal/webhooks"
 )
 
 func main() {
+	// Operator subcommands run against the database and exit without starting the server
+	if len(os.Args) > 1 && os.Args[1] == "queue" {
+		os.Exit(runQueueCommand(os.Args[2:]))
+	}
+	if len(os.Args) > 1 && os.Args[1] == "storage" {
+		os.Exit(runStorageCommand(os.Args[2:]))
+	}
+	if len(os.Args) > 1 && os.Args[1] == "migrate" {
+		os.Exit(runMigrateCommand(os.Args[2:]))
+	}
+
 	// Parse command-line flags
 	configurationPath := flag.String("configuration", "", "Path to configuration file")
 	flag.Parse()
@@ -52,13 +69,19 @@ func main() {
 	}
 
 	// Ensure data directory exists
-	if directoryError := ensureDataDirectory(loadedConfiguration.Storage.DataDirectory); directoryError != nil {
+	storageLayout := storage.NewLayout(loadedConfiguration.Storage)
+	if directoryError := storageLayout.Ensure(); directoryError != nil {
 		log.Fatalf("Failed to create data directory: %v", directoryError)
 	}
 
+	// Media, page images and exports stay in the database unless an object store is configured
+	objectStore, objectStoreError := storage.NewObjectStore(loadedConfiguration.Storage)
+	if objectStoreError != nil {
+		log.Fatalf("Failed to configure object storage: %v", objectStoreError)
+	}
+
 	// Initialize JSON logging to a file
-	logFilePath := filepath.Join(loadedConfiguration.Storage.DataDirectory, "server.log")
-	logFile, fileError := os.OpenFile(logFilePath, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	logFile, fileError := os.OpenFile(storageLayout.LogPath(), os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
 	if fileError != nil {
 		log.Fatalf("Failed to open log file: %v", fileError)
 	}
@@ -71,14 +94,20 @@ func main() {
 	slog.SetDefault(logger)
 
 	// Initialize database
-	databasePath := filepath.Join(loadedConfiguration.Storage.DataDirectory, "database.db")
-	initializedDatabase, databaseError := database.Initialize(databasePath)
+	initializedDatabase, databaseError := database.Initialize(storageLayout.DatabasePath())
 	if databaseError != nil {
 		slog.Error("Failed to initialize database", "error", databaseError)
 		os.Exit(1)
 	}
 	defer initializedDatabase.Close()
 
+	// Older versions stored absolute file paths, which break once the data directory moves
+	if migratedRows, migrationError := storage.MigrateFileReferences(initializedDatabase, storageLayout); migrationError != nil {
+		slog.Warn("Failed to migrate stored file paths", "error", migrationError)
+	} else if migratedRows > 0 {
+		slog.Info("Migrated stored file paths to the data directory", "rows", migratedRows)
+	}
+
 	// Initialize prompt manager
 	promptManager := prompts.NewManager("prompts")
 
@@ -98,8 +127,26 @@ func main() {
 	}
 
 	routingProvider := llm.NewRoutingProvider(defaultProvider)
-	routingProvider.Register("openrouter", openRouterProvider)
-	routingProvider.Register("ollama", ollamaProvider)
+	registeredProviders := map[string]llm.Provider{"openrouter": openRouterProvider, "ollama": ollamaProvider}
+	for providerName, provider := range registeredProviders {
+		routingProvider.Register(providerName, provider)
+	}
+	// Every other provider fails over, since the models of some tasks may be served by a provider other than the
+	// default one
+	if failover := loadedConfiguration.LLM.Failover; failover.Provider != "" {
+		for providerName := range registeredProviders {
+			if providerName == failover.Provider {
+				continue
+			}
+			routingProvider.SetFailover(providerName, llm.Failover{
+				Provider:         failover.Provider,
+				Model:            failover.Model,
+				FailureThreshold: failover.FailureThreshold,
+				OpenDuration:     time.Duration(failover.ProbeSeconds) * time.Second,
+			})
+			slog.Info("LLM failover enabled", "provider", providerName, "failover_provider", failover.Provider, "model", failover.Model)
+		}
+	}
 
 	llmProvider := routingProvider
 
@@ -114,6 +161,27 @@ func main() {
 			llmProvider,
 			transcriptionModel,
 		)
+	case "deepgram":
+		// Deepgram model names (e.g. "nova-2") are only taken from transcription.model, never from the LLM task models
+		transcriptionProvider = transcription.NewDeepgramProvider(
+			loadedConfiguration.Providers.Deepgram.APIKey,
+			loadedConfiguration.Transcription.Model,
+		)
+	case "whisper-api":
+		transcriptionProvider = transcription.NewWhisperProvider(
+			loadedConfiguration.Providers.OpenAI.APIKey,
+			loadedConfiguration.Transcription.Model,
+			loadedConfiguration.Providers.OpenAI.BaseURL,
+			loadedConfiguration.Storage.BinDirectory,
+		)
+	case "whisper-local":
+		// Local model names (e.g. "large-v3") are only taken from transcription.model, never from the LLM task models
+		transcriptionProvider = transcription.NewWhisperLocalProvider(
+			loadedConfiguration.Transcription.Model,
+			loadedConfiguration.Transcription.Device,
+			loadedConfiguration.Providers.HuggingFace.Token,
+			loadedConfiguration.Storage.BinDirectory,
+		)
 	default:
 		slog.Warn("Unknown transcription provider or provider not supporting audio, falling back to openrouter", "provider", loadedConfiguration.Transcription.Provider)
 		transcriptionProvider = transcription.NewOpenRouterTranscriptionProvider(
@@ -131,6 +199,9 @@ func main() {
 	}
 	slog.Info("Document processor initialized", "model", ingestionModel)
 	documentProcessor := documents.NewProcessor(llmProvider, ingestionModel, promptManager, loadedConfiguration.Documents.RenderDPI, loadedConfiguration.Storage.BinDirectory)
+	documentProcessor.SetChunking(loadedConfiguration.Documents.ChunkSizeCharacters, loadedConfiguration.Documents.ChunkOverlapCharacters)
+	documentProcessor.SetExtraction(loadedConfiguration.Documents.ExtractionMethod, loadedConfiguration.Documents.VisionMaximumPages)
+	documentProcessor.SetParallelism(loadedConfiguration.Documents.PageParallelism, loadedConfiguration.LLM.MaximumConcurrentCalls)
 
 	// Initialize markdown converter
 	markdownConverter := markdown.NewConverter(loadedConfiguration.Storage.DataDirectory, loadedConfiguration.Storage.BinDirectory)
@@ -155,24 +226,90 @@ func main() {
 
 	// Initialize tool generator
 	toolGenerator := tools.NewToolGenerator(loadedConfiguration, llmProvider, promptManager)
+	switch loadedConfiguration.ImageGeneration.Provider {
+	case "":
+		// Mnemonic images for flashcards are disabled
+	case "openai":
+		toolGenerator.SetImageProvider(llm.NewOpenAIImageProvider(loadedConfiguration.Providers.OpenAI.APIKey, loadedConfiguration.Providers.OpenAI.BaseURL))
+		slog.Info("Image generation enabled", "provider", "openai", "model", loadedConfiguration.ImageGeneration.Model)
+	default:
+		slog.Warn("Unknown image generation provider, flashcard images are disabled", "provider", loadedConfiguration.ImageGeneration.Provider)
+	}
 
 	// Initialize job queue
-	backgroundJobQueue := jobs.NewQueue(initializedDatabase, 4) // 4 concurrent workers
+	backgroundJobQueue := jobs.NewQueue(initializedDatabase, 4)
+	backgroundJobQueue.SetConcurrency(loadedConfiguration.Jobs.Concurrency, loadedConfiguration.Jobs.Scaling)
+	backgroundJobQueue.SetObjectStore(objectStore)
+	// The handler set decides which job types RegisterHandlers registers, so it is applied first
+	if err := backgroundJobQueue.SetHandlerSet(loadedConfiguration.Jobs.Handlers); err != nil {
+		log.Fatalf("Invalid job handler configuration: %v", err)
+	}
+	backgroundJobQueue.SetCostBudget(jobs.CostBudget{
+		Daily:   loadedConfiguration.Safety.DailyBudgetPerUser,
+		Monthly: loadedConfiguration.Safety.MonthlyBudgetPerUser,
+	})
 
 	// Create API server
 	apiServer := api.NewServer(loadedConfiguration, initializedDatabase, backgroundJobQueue, llmProvider, promptManager, toolGenerator, markdownConverter)
+	apiServer.SetObjectStore(objectStore)
+	apiServer.SetDocumentProcessor(documentProcessor)
+	if loadedConfiguration.SMTP.Host != "" {
+		apiServer.SetMailSender(mail.NewSMTPSender(loadedConfiguration.SMTP))
+	}
+	apiServer.RegisterDependencyCheck("transcription", transcriptionService.CheckDependencies)
+	apiServer.RegisterDependencyCheck("documents", documentProcessor.CheckDependencies)
+	apiServer.RegisterDependencyCheck("exports", markdownConverter.CheckDependencies)
+	apiServer.RegisterDependencyCheck("media", func() error {
+		return media.CheckDependencies(loadedConfiguration.Storage.BinDirectory)
+	})
+
+	// Users may bring their own provider API keys, stored encrypted
+	secretCipher, err := secrets.LoadCipher(loadedConfiguration.Security.EncryptionKey, filepath.Join(storageLayout.Root(), "encryption.key"))
+	if err != nil {
+		slog.Warn("User API keys are disabled", "error", err)
+	} else {
+		apiServer.SetSecretCipher(secretCipher)
+	}
+
+	// Webhook secrets are sealed with the same cipher, so webhooks need it too
+	var webhookDispatcher *webhooks.Dispatcher
+	if secretCipher != nil {
+		webhookDispatcher = webhooks.NewDispatcher(initializedDatabase, loadedConfiguration.Webhooks, secretCipher)
+		apiServer.SetWebhookDispatcher(webhookDispatcher)
+		webhookDispatcher.Start(context.Background())
+	} else {
+		slog.Warn("Webhooks are disabled because no encryption key is available")
+	}
+
+	// Retrieve only the relevant lecture chunks as chat context when an embeddings provider is configured
+	embedder, err := embeddings.NewEmbedder(loadedConfiguration)
+	if err != nil {
+		slog.Warn("Chat retrieval is disabled", "error", err)
+	} else if embedder != nil {
+		apiServer.SetEmbeddingIndex(embeddings.NewIndex(initializedDatabase, embedder, loadedConfiguration.Embeddings.ChunkSizeCharacters))
+		slog.Info("Chat retrieval enabled", "provider", loadedConfiguration.Embeddings.Provider, "model", embedder.Model())
+	}
 
 	// Configure background job updates to broadcast via WebSocket
 	backgroundJobQueue.OnUpdate = func(job *models.Job, update jobs.JobUpdate) {
+		var channels []string
 		if job.LectureID != "" {
-			apiServer.Broadcast("lecture:"+job.LectureID, "job:progress", update)
+			channels = append(channels, "lecture:"+job.LectureID)
 		}
 		if job.CourseID != "" {
-			apiServer.Broadcast("course:"+job.CourseID, "job:progress", update)
+			channels = append(channels, "course:"+job.CourseID)
+		}
+		apiServer.BroadcastEach(append(channels, "user:"+job.UserID), "job:progress", update)
+		if webhookDispatcher != nil {
+			webhookDispatcher.JobUpdated(job, update.Status)
 		}
-		apiServer.Broadcast("user:"+job.UserID, "job:progress", update)
 	}
 
+	// Lines logged while running a job are kept in its log and streamed to the clients following the job
+	slog.SetDefault(slog.New(jobs.NewLogHandler(logger.Handler(), initializedDatabase, func(jobLog models.JobLog) {
+		apiServer.Broadcast("job:"+jobLog.JobID, "job:log", jobLog)
+	})))
+
 	// Register job handlers
 	jobs.RegisterHandlers(
 		backgroundJobQueue,
@@ -182,7 +319,13 @@ func main() {
 		documentProcessor,
 		toolGenerator,
 		markdownConverter,
-		database.CheckLectureReadiness,
+		func(db *sql.DB, lectureID string) {
+			if database.UpdateLectureReadiness(db, lectureID) && webhookDispatcher != nil {
+				webhookDispatcher.LectureReady(lectureID)
+			}
+			// Dashboards show a short recap of every ready lecture
+			jobs.ScheduleLectureRecap(backgroundJobQueue, db, lectureID)
+		},
 		func(channel string, msgType string, payload any) {
 			apiServer.Broadcast(channel, msgType, payload)
 		},
@@ -191,39 +334,10 @@ func main() {
 	backgroundJobQueue.Start()
 
 	// Start HTTP server
-	serverAddress := fmt.Sprintf("%s:%d", loadedConfiguration.Server.Host, loadedConfiguration.Server.Port)
-	slog.Info("Server starting", "address", serverAddress)
 	slog.Info("Data directory", "directory", loadedConfiguration.Storage.DataDirectory)
 
-	if serverError := http.ListenAndServe(serverAddress, apiServer.Handler()); serverError != nil {
+	if serverError := listenAndServe(loadedConfiguration, apiServer.Handler()); serverError != nil {
 		slog.Error("Server failed", "error", serverError)
 		os.Exit(1)
 	}
 }
-
-func ensureDataDirectory(directoryPath string) error {
-	// Expand home directory
-	if len(directoryPath) > 0 && directoryPath[0] == '~' {
-		homeDirectory, homeDirError := os.UserHomeDir()
-		if homeDirError != nil {
-			return homeDirError
-		}
-		directoryPath = filepath.Join(homeDirectory, directoryPath[1:])
-	}
-
-	// Create necessary subdirectories
-	targetDirectories := []string{
-		directoryPath,
-		filepath.Join(directoryPath, "files", "lectures"),
-		filepath.Join(directoryPath, "files", "exports"),
-		filepath.Join(directoryPath, "models"),
-	}
-
-	for _, directory := range targetDirectories {
-		if mkdirError := os.MkdirAll(directory, 0755); mkdirError != nil {
-			return mkdirError
-		}
-	}
-
-	return nil
-}
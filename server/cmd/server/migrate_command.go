@@ -0,0 +1,119 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"text/tabwriter"
+
+	"lectures/internal/configuration"
+	"lectures/internal/database"
+	"lectures/internal/storage"
+)
+
+// runMigrateCommand implements the "migrate" operator subcommands, which show, apply and roll back the versioned
+// schema migrations. Rollbacks are meant for development: the server applies pending migrations when it starts
+func runMigrateCommand(arguments []string) int {
+	flagSet := flag.NewFlagSet("migrate", flag.ContinueOnError)
+	configurationPath := flagSet.String("configuration", "", "Path to configuration file")
+	targetVersion := flagSet.Int("to", -1, "Version to migrate up or roll back to (default: the latest for up, the previous one for down)")
+	flagSet.Usage = func() {
+		fmt.Fprintln(flagSet.Output(), "Usage: server migrate [flags] <status|up|down>")
+		flagSet.PrintDefaults()
+	}
+
+	if err := flagSet.Parse(arguments); err != nil {
+		return 2
+	}
+	if flagSet.NArg() == 0 {
+		flagSet.Usage()
+		return 2
+	}
+
+	finalConfigPath := *configurationPath
+	if finalConfigPath == "" {
+		if _, err := os.Stat("configuration.yaml"); err == nil {
+			finalConfigPath = "configuration.yaml"
+		}
+	}
+
+	loadedConfiguration, loadingError := configuration.Load(finalConfigPath)
+	if loadingError != nil {
+		fmt.Fprintf(os.Stderr, "Failed to load configuration: %v\n", loadingError)
+		return 1
+	}
+
+	// Opened without migrating, so pending migrations can be listed before they are applied
+	openedDatabase, databaseError := database.Open(storage.NewLayout(loadedConfiguration.Storage).DatabasePath())
+	if databaseError != nil {
+		fmt.Fprintf(os.Stderr, "Failed to open database: %v\n", databaseError)
+		return 1
+	}
+	defer openedDatabase.Close()
+
+	switch flagSet.Arg(0) {
+	case "status":
+		states, err := database.MigrationStatus(openedDatabase)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to read migrations: %v\n", err)
+			return 1
+		}
+
+		tableWriter := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(tableWriter, "VERSION\tNAME\tAPPLIED\tREVERSIBLE")
+		for _, state := range states {
+			appliedAt := "pending"
+			if state.AppliedAt != nil {
+				appliedAt = state.AppliedAt.Format("2006-01-02 15:04:05")
+			}
+			fmt.Fprintf(tableWriter, "%d\t%s\t%s\t%t\n", state.Version, state.Name, appliedAt, state.Down != "")
+		}
+		tableWriter.Flush()
+
+	case "up":
+		target := *targetVersion
+		if target < 0 {
+			target = 0
+		}
+		if err := database.Migrate(openedDatabase, target); err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to migrate: %v\n", err)
+			return 1
+		}
+		fmt.Println("Schema is up to date")
+
+	case "down":
+		target := *targetVersion
+		if target < 0 {
+			states, err := database.MigrationStatus(openedDatabase)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Failed to read migrations: %v\n", err)
+				return 1
+			}
+			// Roll back the latest applied migration only
+			target = 0
+			for _, state := range states {
+				if state.AppliedAt != nil {
+					target = state.Version - 1
+				}
+			}
+		}
+
+		reverted, err := database.RollbackMigrations(openedDatabase, target)
+		for _, migration := range reverted {
+			fmt.Printf("Rolled back migration %d (%s)\n", migration.Version, migration.Name)
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to roll back: %v\n", err)
+			return 1
+		}
+		if len(reverted) == 0 {
+			fmt.Println("No migration to roll back")
+		}
+
+	default:
+		flagSet.Usage()
+		return 2
+	}
+
+	return 0
+}
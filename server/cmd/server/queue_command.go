@@ -0,0 +1,116 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"lectures/internal/configuration"
+	"lectures/internal/database"
+	"lectures/internal/jobs"
+	"lectures/internal/storage"
+)
+
+// runQueueCommand implements the "queue" operator subcommands, which act directly on the database
+// so they also work against a running server (pause/resume are picked up on the next worker tick)
+func runQueueCommand(arguments []string) int {
+	flagSet := flag.NewFlagSet("queue", flag.ContinueOnError)
+	configurationPath := flagSet.String("configuration", "", "Path to configuration file")
+	reason := flagSet.String("reason", "", "Failure reason recorded on the job (fail)")
+	minimumAge := flagSet.Duration("older-than", 10*time.Minute, "Only reassign RUNNING jobs started longer ago than this (reassign)")
+	flagSet.Usage = func() {
+		fmt.Fprintln(flagSet.Output(), "Usage: server queue [flags] <status|pause|resume|fail <job_id>|reassign>")
+		flagSet.PrintDefaults()
+	}
+
+	if err := flagSet.Parse(arguments); err != nil {
+		return 2
+	}
+	if flagSet.NArg() == 0 {
+		flagSet.Usage()
+		return 2
+	}
+
+	finalConfigPath := *configurationPath
+	if finalConfigPath == "" {
+		if _, err := os.Stat("configuration.yaml"); err == nil {
+			finalConfigPath = "configuration.yaml"
+		}
+	}
+
+	loadedConfiguration, loadingError := configuration.Load(finalConfigPath)
+	if loadingError != nil {
+		fmt.Fprintf(os.Stderr, "Failed to load configuration: %v\n", loadingError)
+		return 1
+	}
+
+	initializedDatabase, databaseError := database.Initialize(storage.NewLayout(loadedConfiguration.Storage).DatabasePath())
+	if databaseError != nil {
+		fmt.Fprintf(os.Stderr, "Failed to open database: %v\n", databaseError)
+		return 1
+	}
+	defer initializedDatabase.Close()
+
+	// The queue is never started here, so it only issues database updates
+	operatorQueue := jobs.NewQueue(initializedDatabase, 0)
+	operatorQueue.SetConcurrency(loadedConfiguration.Jobs.Concurrency, loadedConfiguration.Jobs.Scaling)
+
+	switch flagSet.Arg(0) {
+	case "status":
+		statistics, err := operatorQueue.Statistics()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to read queue statistics: %v\n", err)
+			return 1
+		}
+
+		fmt.Printf("Intake paused: %t\n\n", operatorQueue.IsPaused())
+		tableWriter := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(tableWriter, "TYPE\tPOOL\tPENDING\tRUNNING")
+		for _, typeStatistics := range statistics {
+			fmt.Fprintf(tableWriter, "%s\t%s\t%d\t%d\n", typeStatistics.Type, typeStatistics.Pool, typeStatistics.Pending, typeStatistics.Running)
+		}
+		tableWriter.Flush()
+
+	case "pause":
+		if err := operatorQueue.Pause(); err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to pause job intake: %v\n", err)
+			return 1
+		}
+		fmt.Println("Job intake paused")
+
+	case "resume":
+		if err := operatorQueue.Resume(); err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to resume job intake: %v\n", err)
+			return 1
+		}
+		fmt.Println("Job intake resumed")
+
+	case "fail":
+		if flagSet.NArg() < 2 {
+			fmt.Fprintln(os.Stderr, "Usage: server queue [-reason text] fail <job_id>")
+			return 2
+		}
+		jobID := flagSet.Arg(1)
+		if err := operatorQueue.ForceFailJob(jobID, *reason); err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to fail job %s: %v\n", jobID, err)
+			return 1
+		}
+		fmt.Printf("Job %s marked as failed\n", jobID)
+
+	case "reassign":
+		reassignedCount, err := operatorQueue.ReassignOrphanedJobs(*minimumAge)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to reassign orphaned jobs: %v\n", err)
+			return 1
+		}
+		fmt.Printf("Reassigned %d orphaned job(s) to PENDING\n", reassignedCount)
+
+	default:
+		flagSet.Usage()
+		return 2
+	}
+
+	return 0
+}
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/tabwriter"
+
+	"lectures/internal/configuration"
+	"lectures/internal/database"
+	"lectures/internal/jobs"
+	"lectures/internal/storage"
+)
+
+// runStorageCommand implements the "storage" operator subcommands, which inspect the data directory layout and
+// move the data directory to another volume. The server must be stopped while it is relocated
+func runStorageCommand(arguments []string) int {
+	flagSet := flag.NewFlagSet("storage", flag.ContinueOnError)
+	configurationPath := flagSet.String("configuration", "", "Path to configuration file")
+	force := flagSet.Bool("force", false, "Relocate even if jobs still report a live worker (relocate)")
+	flagSet.Usage = func() {
+		fmt.Fprintln(flagSet.Output(), "Usage: server storage [flags] <layout|relocate <directory>>")
+		flagSet.PrintDefaults()
+	}
+
+	if err := flagSet.Parse(arguments); err != nil {
+		return 2
+	}
+	if flagSet.NArg() == 0 {
+		flagSet.Usage()
+		return 2
+	}
+
+	finalConfigPath := *configurationPath
+	if finalConfigPath == "" {
+		if _, err := os.Stat("configuration.yaml"); err == nil {
+			finalConfigPath = "configuration.yaml"
+		}
+	}
+
+	loadedConfiguration, loadingError := configuration.Load(finalConfigPath)
+	if loadingError != nil {
+		fmt.Fprintf(os.Stderr, "Failed to load configuration: %v\n", loadingError)
+		return 1
+	}
+	layout := storage.NewLayout(loadedConfiguration.Storage)
+
+	switch flagSet.Arg(0) {
+	case "layout":
+		tableWriter := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(tableWriter, "PART\tPATH")
+		fmt.Fprintf(tableWriter, "data directory\t%s\n", layout.Root())
+		fmt.Fprintf(tableWriter, "database\t%s\n", layout.DatabasePath())
+		fmt.Fprintf(tableWriter, "log\t%s\n", layout.LogPath())
+		fmt.Fprintf(tableWriter, "lectures\t%s\n", layout.LecturesDirectory())
+		fmt.Fprintf(tableWriter, "exports\t%s\n", layout.ExportsDirectory())
+		fmt.Fprintf(tableWriter, "backups\t%s\n", layout.BackupsDirectory())
+		fmt.Fprintf(tableWriter, "objects\t%s\n", layout.ObjectsDirectory())
+		tableWriter.Flush()
+
+	case "relocate":
+		if flagSet.NArg() < 2 {
+			fmt.Fprintln(os.Stderr, "Usage: server storage [-force] relocate <directory>")
+			return 2
+		}
+		return relocateDataDirectory(loadedConfiguration, layout, flagSet.Arg(1), *force)
+
+	default:
+		flagSet.Usage()
+		return 2
+	}
+
+	return 0
+}
+
+// relocateDataDirectory moves the data directory, rewrites the absolute paths stored in the database and saves
+// the new location in the configuration file
+func relocateDataDirectory(loadedConfiguration *configuration.Configuration, oldLayout storage.Layout, newDirectory string, force bool) int {
+	oldRoot, err := filepath.Abs(oldLayout.Root())
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to resolve the data directory: %v\n", err)
+		return 1
+	}
+	newRoot, err := filepath.Abs(newDirectory)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to resolve %s: %v\n", newDirectory, err)
+		return 1
+	}
+
+	// Parts placed by absolute path stay where they are, which breaks them if that path is inside the moved directory
+	layoutParts := map[string]string{
+		"database": loadedConfiguration.Storage.Layout.Database,
+		"log":      loadedConfiguration.Storage.Layout.Log,
+		"lectures": loadedConfiguration.Storage.Layout.Lectures,
+		"exports":  loadedConfiguration.Storage.Layout.Exports,
+		"objects":  loadedConfiguration.Storage.Layout.Objects,
+	}
+	for part, configuredPath := range layoutParts {
+		if filepath.IsAbs(configuredPath) && strings.HasPrefix(filepath.Clean(configuredPath), oldRoot+string(filepath.Separator)) {
+			fmt.Fprintf(os.Stderr, "storage.layout.%s is an absolute path inside the data directory; make it relative before relocating\n", part)
+			return 1
+		}
+	}
+
+	initializedDatabase, err := database.Initialize(oldLayout.DatabasePath())
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to open database: %v\n", err)
+		return 1
+	}
+	liveJobCount, err := jobs.NewQueue(initializedDatabase, 0).CountLiveJobs()
+	if err != nil {
+		initializedDatabase.Close()
+		fmt.Fprintf(os.Stderr, "Failed to check for running jobs: %v\n", err)
+		return 1
+	}
+	if liveJobCount > 0 && !force {
+		initializedDatabase.Close()
+		fmt.Fprintf(os.Stderr, "%d job(s) are running on a live server; stop the server before relocating, or pass -force\n", liveJobCount)
+		return 1
+	}
+	// File references made relative to the data directory follow it without being rewritten
+	_, err = storage.MigrateFileReferences(initializedDatabase, oldLayout)
+	initializedDatabase.Close()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to migrate stored file paths: %v\n", err)
+		return 1
+	}
+
+	fmt.Printf("Moving %s to %s\n", oldRoot, newRoot)
+	if err := storage.MoveDataDirectory(oldRoot, newRoot); err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to move the data directory: %v\n", err)
+		return 1
+	}
+
+	loadedConfiguration.Storage.DataDirectory = newRoot
+	newLayout := storage.NewLayout(loadedConfiguration.Storage)
+	initializedDatabase, err = database.Initialize(newLayout.DatabasePath())
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Moved the data directory, but failed to open the database to rewrite its paths: %v\n", err)
+		return 1
+	}
+	rewrittenRows, err := storage.RewriteStoredPaths(initializedDatabase, oldRoot, newRoot)
+	initializedDatabase.Close()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Moved the data directory, but failed to rewrite stored paths: %v\n", err)
+		return 1
+	}
+	fmt.Printf("Rewrote stored paths in %d row(s)\n", rewrittenRows)
+
+	// The configuration file moved along when it was kept inside the data directory
+	configurationPath, _ := filepath.Abs(loadedConfiguration.ConfigurationPath)
+	if relativePath, err := filepath.Rel(oldRoot, configurationPath); err == nil && !strings.HasPrefix(relativePath, "..") {
+		configurationPath = filepath.Join(newRoot, relativePath)
+	}
+	if err := configuration.Save(loadedConfiguration, configurationPath); err != nil {
+		fmt.Fprintf(os.Stderr, "Moved the data directory, but failed to save %s: %v; set storage.data_directory to %s\n", configurationPath, err, newRoot)
+		return 1
+	}
+	fmt.Printf("Saved storage.data_directory = %s in %s\n", newRoot, configurationPath)
+	if os.Getenv("STORAGE_DATA_DIRECTORY") != "" {
+		fmt.Printf("STORAGE_DATA_DIRECTORY overrides the configuration file; set it to %s before starting the server\n", newRoot)
+	}
+	return 0
+}
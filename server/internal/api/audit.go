@@ -0,0 +1,249 @@
+package api
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"hash"
+	"io"
+	"log/slog"
+	"maps"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+
+	"lectures/internal/database"
+	"lectures/internal/models"
+
+	"github.com/gorilla/mux"
+)
+
+// Bounds of a page of the audit log
+const (
+	defaultAuditEventLimit = 100
+	maximumAuditEventLimit = 500
+)
+
+// maximumAuditedBodyBytes bounds how much of a request body is kept in memory to find the fields it changes.
+// Larger bodies, such as uploaded archives, are only digested
+const maximumAuditedBodyBytes = 64 * 1024
+
+// auditedRoute is what the audit log records of the requests to a route
+type auditedRoute struct {
+	action        string
+	resourceType  string
+	resourceField string // Query parameter or body field naming the resource
+}
+
+// auditedRoutes are the routes of the authenticated API recorded in the audit log, by method and path template,
+// whatever their outcome. Sign-ins, password and email changes, API tokens and accounts created by
+// administrators are recorded by their handlers, which know more about them
+var auditedRoutes = map[string]auditedRoute{
+	"DELETE /api/exams":           {models.AuditActionExamDeleted, "exam", "exam_id"},
+	"PUT /api/exams/members":      {models.AuditActionExamMemberChanged, "exam", "exam_id"},
+	"DELETE /api/exams/members":   {models.AuditActionExamMemberRemoved, "exam", "exam_id"},
+	"DELETE /api/lectures":        {models.AuditActionLectureDeleted, "lecture", "lecture_id"},
+	"DELETE /api/media":           {models.AuditActionMediaDeleted, "media", "media_id"},
+	"DELETE /api/documents":       {models.AuditActionDocumentDeleted, "document", "document_id"},
+	"DELETE /api/tools":           {models.AuditActionToolDeleted, "tool", "tool_id"},
+	"DELETE /api/chat/sessions":   {models.AuditActionChatSessionDeleted, "chat_session", "session_id"},
+	"DELETE /api/exports/presets": {models.AuditActionExportPresetDeleted, "export_preset", "preset_id"},
+	"DELETE /api/jobs":            {models.AuditActionJobCancelled, "job", "job_id"},
+
+	"POST /api/tools/export":       {models.AuditActionExportRequested, "tool", "tool_id"},
+	"POST /api/transcripts/export": {models.AuditActionExportRequested, "lecture", "lecture_id"},
+	"POST /api/documents/export":   {models.AuditActionExportRequested, "document", "document_id"},
+	"POST /api/exports/publish":    {models.AuditActionExportRequested, "exam", "exam_id"},
+	"POST /api/offline/bundle":     {models.AuditActionExportRequested, "exam", "exam_id"},
+
+	"PATCH /api/settings":       {models.AuditActionSettingsChanged, "settings", ""},
+	"PUT /api/settings/keys":    {models.AuditActionProviderKeyChanged, "provider_key", "provider"},
+	"DELETE /api/settings/keys": {models.AuditActionProviderKeyChanged, "provider_key", "provider"},
+
+	"PATCH /api/admin/users":                {models.AuditActionAccountUpdated, "user", "user_id"},
+	"DELETE /api/admin/users":               {models.AuditActionAccountDeleted, "user", "user_id"},
+	"PUT /api/admin/users/budget":           {models.AuditActionBudgetChanged, "user", "user_id"},
+	"POST /api/admin/queue/pause":           {models.AuditActionQueuePaused, "", ""},
+	"POST /api/admin/queue/resume":          {models.AuditActionQueueResumed, "", ""},
+	"POST /api/admin/jobs/fail":             {models.AuditActionJobFailed, "job", "job_id"},
+	"POST /api/admin/jobs/reassign":         {models.AuditActionJobsReassigned, "", ""},
+	"PUT /api/admin/system/announcement":    {models.AuditActionAnnouncementChanged, "", ""},
+	"DELETE /api/admin/system/announcement": {models.AuditActionAnnouncementChanged, "", ""},
+	"POST /api/admin/prompt-variants":       {models.AuditActionPromptVariantChanged, "prompt_variant", ""},
+	"PATCH /api/admin/prompt-variants":      {models.AuditActionPromptVariantChanged, "prompt_variant", "id"},
+	"POST /api/admin/database/maintenance":  {models.AuditActionDatabaseMaintained, "", ""},
+	"POST /api/admin/backups":               {models.AuditActionBackupCreated, "", ""},
+	"GET /api/admin/backups/download":       {models.AuditActionBackupDownloaded, "backup", "name"},
+	"POST /api/admin/restore":               {models.AuditActionBackupRestored, "backup", "name"},
+}
+
+// recordAuditEvent adds an event from the address of a request to the audit log, acted by the user of the
+// request unless the event names its actor, and to the audit log of authentication when it is one of its
+// actions. Failing to record it is logged but never fails the request
+func (server *Server) recordAuditEvent(request *http.Request, event models.AuditEvent) {
+	event.IPAddress = clientAddress(request)
+	if event.ActorID == "" {
+		event.ActorID = server.getUserID(request)
+	}
+	if err := database.RecordAuditEvent(server.database, event); err != nil {
+		slog.Warn("Failed to record audit event", "action", event.Action, "actorID", event.ActorID, "error", err)
+	}
+	if models.AuthEventActions[event.Action] {
+		server.recordAuthEvent(authEventOf(event))
+	}
+}
+
+// auditResponseWriter remembers the status a handler answered
+type auditResponseWriter struct {
+	http.ResponseWriter
+	status int
+}
+
+func (writer *auditResponseWriter) WriteHeader(status int) {
+	if writer.status == 0 {
+		writer.status = status
+	}
+	writer.ResponseWriter.WriteHeader(status)
+}
+
+func (writer *auditResponseWriter) Write(data []byte) (int, error) {
+	if writer.status == 0 {
+		writer.status = http.StatusOK
+	}
+	return writer.ResponseWriter.Write(data)
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer
+func (writer *auditResponseWriter) Unwrap() http.ResponseWriter {
+	return writer.ResponseWriter
+}
+
+// auditedBody digests a request body as the handler reads it, keeping its start to find the fields it changes
+type auditedBody struct {
+	io.ReadCloser
+	digest    hash.Hash
+	start     bytes.Buffer
+	readBytes int64
+}
+
+func (body *auditedBody) Read(data []byte) (int, error) {
+	readCount, err := body.ReadCloser.Read(data)
+	body.digest.Write(data[:readCount])
+	if remaining := maximumAuditedBodyBytes - body.start.Len(); remaining > 0 {
+		body.start.Write(data[:min(readCount, remaining)])
+	}
+	body.readBytes += int64(readCount)
+	return readCount, err
+}
+
+// auditMiddleware records the requests to the routes of auditedRoutes once they are handled, with the
+// status answered and a digest of their body. Only the names of the fields a body sets are kept, along with
+// the IDs it names, so passwords and API keys never reach the audit log
+func (server *Server) auditMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(responseWriter http.ResponseWriter, request *http.Request) {
+		route := mux.CurrentRoute(request)
+		if route == nil {
+			next.ServeHTTP(responseWriter, request)
+			return
+		}
+		pathTemplate, _ := route.GetPathTemplate()
+		audited, found := auditedRoutes[request.Method+" "+pathTemplate]
+		if !found {
+			next.ServeHTTP(responseWriter, request)
+			return
+		}
+
+		body := &auditedBody{ReadCloser: request.Body, digest: sha256.New()}
+		request.Body = body
+		auditWriter := &auditResponseWriter{ResponseWriter: responseWriter}
+		next.ServeHTTP(auditWriter, request)
+
+		event := models.AuditEvent{
+			Action:       audited.action,
+			ResourceType: audited.resourceType,
+			Status:       auditWriter.status,
+			Details:      map[string]any{},
+		}
+		if event.Status == 0 {
+			event.Status = http.StatusOK
+		}
+		if body.readBytes > 0 {
+			event.PayloadDigest = hex.EncodeToString(body.digest.Sum(nil))
+		}
+
+		fields := map[string]any{}
+		for name, values := range request.URL.Query() {
+			if len(values) > 0 {
+				fields[name] = values[0]
+			}
+		}
+		if body.readBytes > 0 && body.readBytes <= maximumAuditedBodyBytes {
+			var bodyFields map[string]any
+			if json.Unmarshal(body.start.Bytes(), &bodyFields) == nil {
+				event.Details["fields"] = slices.Sorted(maps.Keys(bodyFields))
+				for name, value := range bodyFields {
+					fields[name] = value
+				}
+			}
+		}
+		if audited.resourceField != "" {
+			if resourceID, isString := fields[audited.resourceField].(string); isString {
+				event.ResourceID = resourceID
+			}
+		}
+		for name, value := range fields {
+			if strings.HasSuffix(name, "_id") || strings.HasSuffix(name, "_ids") {
+				event.Details[name] = value
+			}
+		}
+		if len(event.Details) == 0 {
+			event.Details = nil
+		}
+		server.recordAuditEvent(request, event)
+	})
+}
+
+// handleListAuditEvents lists the audit log, newest first, optionally of an "actor_id", an "actor_username",
+// an "ip_address", an "action", a "resource_type" or a "resource_id"
+func (server *Server) handleListAuditEvents(responseWriter http.ResponseWriter, request *http.Request) {
+	if !server.requireAdmin(responseWriter, request) {
+		return
+	}
+
+	query := request.URL.Query()
+	filter := database.AuditEventFilter{
+		ActorID:       query.Get("actor_id"),
+		ActorUsername: query.Get("actor_username"),
+		IPAddress:     query.Get("ip_address"),
+		Action:        query.Get("action"),
+		ResourceType:  query.Get("resource_type"),
+		ResourceID:    query.Get("resource_id"),
+		Limit:         defaultAuditEventLimit,
+	}
+	if limitValue := query.Get("limit"); limitValue != "" {
+		limit, err := strconv.Atoi(limitValue)
+		if err != nil || limit < 1 || limit > maximumAuditEventLimit {
+			server.writeError(responseWriter, http.StatusBadRequest, "VALIDATION_ERROR", fmt.Sprintf("limit must be between 1 and %d", maximumAuditEventLimit), nil)
+			return
+		}
+		filter.Limit = limit
+	}
+	if beforeValue := query.Get("before_id"); beforeValue != "" {
+		beforeID, err := strconv.ParseInt(beforeValue, 10, 64)
+		if err != nil || beforeID < 1 {
+			server.writeError(responseWriter, http.StatusBadRequest, "VALIDATION_ERROR", "before_id must be an event ID", nil)
+			return
+		}
+		filter.BeforeID = beforeID
+	}
+
+	events, err := database.ListAuditEvents(server.database, filter)
+	if err != nil {
+		server.writeError(responseWriter, http.StatusInternalServerError, "DATABASE_ERROR", "Failed to list audit events", nil)
+		return
+	}
+	server.writeJSON(responseWriter, http.StatusOK, events)
+}
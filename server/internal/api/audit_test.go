@@ -0,0 +1,87 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"lectures/internal/models"
+)
+
+func TestHandleAuditLog(t *testing.T) {
+	server, userID, sessionID, cleanup := setupUniqueExtraTestEnv(t, "audit_log")
+	defer cleanup()
+	server.database.Exec("UPDATE users SET role = 'admin' WHERE id = ?", userID)
+
+	sendRequest := func(token string, method string, target string, body map[string]any) *httptest.ResponseRecorder {
+		encodedBody, _ := json.Marshal(body)
+		req := httptest.NewRequest(method, target, bytes.NewBuffer(encodedBody))
+		req.RemoteAddr = "192.0.2.10:1234"
+		req.Header.Set("Authorization", "Bearer "+token)
+		req.Header.Set("X-Requested-With", "XMLHttpRequest")
+		rr := httptest.NewRecorder()
+		server.Handler().ServeHTTP(rr, req)
+		return rr
+	}
+	listEvents := func(query string) []models.AuditEvent {
+		rr := sendRequest(sessionID, "GET", "/api/admin/audit?"+query, nil)
+		if rr.Code != http.StatusOK {
+			t.Fatalf("Expected status 200 listing the audit log, got %d: %s", rr.Code, rr.Body.String())
+		}
+		var events struct {
+			Data []models.AuditEvent `json:"data"`
+		}
+		json.Unmarshal(rr.Body.Bytes(), &events)
+		return events.Data
+	}
+
+	// Deletions are recorded with their resource, outcome and a digest of the request
+	server.database.Exec("INSERT INTO exams (id, user_id, title) VALUES ('exam-audited', ?, 'Audited')", userID)
+	if rr := sendRequest(sessionID, "DELETE", "/api/exams", map[string]any{"exam_id": "exam-audited"}); rr.Code != http.StatusOK {
+		t.Fatalf("Expected status 200 deleting the exam, got %d: %s", rr.Code, rr.Body.String())
+	}
+	events := listEvents("action=exam_deleted")
+	if len(events) != 1 {
+		t.Fatalf("Expected one exam deletion in the audit log, got %+v", events)
+	}
+	if event := events[0]; event.ResourceType != "exam" || event.ResourceID != "exam-audited" || event.Status != http.StatusOK ||
+		event.ActorID != userID || event.IPAddress != "192.0.2.10" || len(event.PayloadDigest) != 64 {
+		t.Errorf("Expected the resource, outcome, actor, address and digest of the deletion, got %+v", event)
+	}
+
+	// Bodies are reduced to the names of their fields, so secrets are not kept
+	server.database.Exec("INSERT INTO users (id, username, password_hash, role) VALUES ('audited-user', 'audited_user', 'hash', 'teacher')")
+	if rr := sendRequest(sessionID, "PATCH", "/api/admin/users", map[string]any{"user_id": "audited-user", "password": "secret-password-123"}); rr.Code != http.StatusOK {
+		t.Fatalf("Expected status 200 updating the user, got %d: %s", rr.Code, rr.Body.String())
+	}
+	rr := sendRequest(sessionID, "GET", "/api/admin/audit?resource_type=user&resource_id=audited-user", nil)
+	if strings.Contains(rr.Body.String(), "secret-password-123") {
+		t.Errorf("Expected no password in the audit log, got %s", rr.Body.String())
+	}
+	events = listEvents("action=account_updated&resource_id=audited-user")
+	if len(events) != 1 || fmt.Sprint(events[0].Details["fields"]) != "[password user_id]" {
+		t.Errorf("Expected the updated fields in the audit log, got %+v", events)
+	}
+
+	// Failed requests are recorded too, and so are denied ones
+	sendRequest(sessionID, "DELETE", "/api/exams", map[string]any{"exam_id": "exam-missing"})
+	if events := listEvents("resource_id=exam-missing"); len(events) != 1 || events[0].Status != http.StatusNotFound {
+		t.Errorf("Expected the failed deletion in the audit log, got %+v", events)
+	}
+	server.database.Exec("UPDATE users SET role = 'teacher' WHERE id = ?", userID)
+	if rr := sendRequest(sessionID, "GET", "/api/admin/users", nil); rr.Code != http.StatusForbidden {
+		t.Fatalf("Expected status 403 for a teacher, got %d: %s", rr.Code, rr.Body.String())
+	}
+	server.database.Exec("UPDATE users SET role = 'admin' WHERE id = ?", userID)
+	if events := listEvents("action=access_denied"); len(events) != 1 || events[0].ActorID != userID || events[0].Status != http.StatusForbidden {
+		t.Errorf("Expected the denied request in the audit log, got %+v", events)
+	}
+
+	if rr := sendRequest(sessionID, "GET", "/api/admin/audit?limit=0", nil); rr.Code != http.StatusBadRequest {
+		t.Errorf("Expected status 400 for an invalid limit, got %d", rr.Code)
+	}
+}
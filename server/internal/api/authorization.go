@@ -0,0 +1,86 @@
+package api
+
+import (
+	"database/sql"
+	"fmt"
+	"net/http"
+
+	"lectures/internal/models"
+)
+
+// examRoleRanks orders the roles on an exam, matching the rank column of the exam_access view
+var examRoleRanks = map[string]int{
+	models.ExamRoleViewer:    1,
+	models.ExamRoleGenerator: 2,
+	models.ExamRoleManager:   3,
+	models.ExamRoleOwner:     4,
+}
+
+// examAccess returns an SQL condition on the exams table, taking the user ID as its only argument, that holds
+// for the exams the user has at least the given role on. Queries joining exams use it instead of comparing
+// exams.user_id, so shared exams are found the same way as owned ones
+func examAccess(minimumRole string) string {
+	return fmt.Sprintf("exams.id IN (SELECT exam_id FROM exam_access WHERE user_id = ? AND rank >= %d)", examRoleRanks[minimumRole])
+}
+
+// examRole returns the role of a user on an exam: "owner", the role the exam was shared with them with, or an
+// empty string when they have no access to it
+func (server *Server) examRole(userID string, examID string) (string, error) {
+	var role string
+	err := server.database.QueryRow("SELECT role FROM exam_access WHERE exam_id = ? AND user_id = ? ORDER BY rank DESC LIMIT 1", examID, userID).Scan(&role)
+	if err == sql.ErrNoRows {
+		return "", nil
+	}
+	return role, err
+}
+
+// authorizeExam checks that the user of a request has at least the given role on an exam. It answers 404 when
+// they have no access, so exams of others are not revealed, and 403 when their role does not allow the request
+func (server *Server) authorizeExam(responseWriter http.ResponseWriter, request *http.Request, examID string, minimumRole string) bool {
+	role, err := server.examRole(server.getUserID(request), examID)
+	if err != nil {
+		server.writeError(responseWriter, http.StatusInternalServerError, "DATABASE_ERROR", "Failed to verify exam access", nil)
+		return false
+	}
+	if role == "" {
+		server.writeError(responseWriter, http.StatusNotFound, "NOT_FOUND", "Exam not found", nil)
+		return false
+	}
+	if examRoleRanks[role] < examRoleRanks[minimumRole] {
+		server.writeError(responseWriter, http.StatusForbidden, "FORBIDDEN", "Your role on this exam does not allow this", map[string]string{
+			"role":          role,
+			"required_role": minimumRole,
+		})
+		return false
+	}
+	return true
+}
+
+// authorizeLecture checks the role of the user of a request on the exam of a lecture, see authorizeExam, and
+// returns the ID of that exam
+func (server *Server) authorizeLecture(responseWriter http.ResponseWriter, request *http.Request, lectureID string, minimumRole string) (string, bool) {
+	var examID string
+	err := server.database.QueryRow("SELECT exam_id FROM lectures WHERE id = ?", lectureID).Scan(&examID)
+	if err == sql.ErrNoRows {
+		server.writeError(responseWriter, http.StatusNotFound, "NOT_FOUND", "Lecture not found", nil)
+		return "", false
+	}
+	if err != nil {
+		server.writeError(responseWriter, http.StatusInternalServerError, "DATABASE_ERROR", "Failed to verify lecture", nil)
+		return "", false
+	}
+	return examID, server.authorizeExam(responseWriter, request, examID, minimumRole)
+}
+
+// chatSessionAccess returns an SQL condition on the chat_sessions and exams tables, taking the user ID twice, that
+// holds for the sessions the user started on an exam they have at least the given role on. Sessions without a
+// user were started by the owner before exams could be shared
+func chatSessionAccess(minimumRole string) string {
+	return "COALESCE(chat_sessions.user_id, exams.user_id) = ? AND " + examAccess(minimumRole)
+}
+
+// visibleChatSessionAccess is chatSessionAccess extended to every session of the exams whose owner shares chat
+// sessions with the members. It decides who reads a session, while only its starter continues or changes it
+func visibleChatSessionAccess(minimumRole string) string {
+	return "(COALESCE(chat_sessions.user_id, exams.user_id) = ? OR COALESCE(json_extract(exams.collaboration, '$.shared_chat_sessions'), 1) = 1) AND " + examAccess(minimumRole)
+}
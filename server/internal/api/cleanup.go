@@ -5,18 +5,28 @@ import (
 	"os"
 	"path/filepath"
 	"time"
+
+	"lectures/internal/database"
+	"lectures/internal/jobs"
 )
 
 // StartStagingCleanupWorker runs a background task to clean up old temp directories
 func (server *Server) StartStagingCleanupWorker() {
+	// Resume upload accounting from whatever survived in the staging area
+	server.reconcileUploads()
+
 	ticker := time.NewTicker(1 * time.Hour)
 	go func() {
 		for range ticker.C {
 			cleanupTempDir(filepath.Join(os.TempDir(), "lectures-uploads"), "upload")
+			server.reconcileUploads()
 			cleanupTempDir(filepath.Join(os.TempDir(), "lectures-jobs"), "job")
 			cleanupTempDir(filepath.Join(os.TempDir(), "lectures-documents"), "document")
 			cleanupTempDir(filepath.Join(os.TempDir(), "lectures-exports"), "export")
 			cleanupTempFiles(filepath.Join(os.TempDir(), "lectures-media-cache"), "media-cache")
+			server.expireDeadJobs()
+			server.maintainDatabaseIfDue()
+			server.scheduleBackupIfDue()
 		}
 	}()
 	slog.Info("Staging cleanup worker started")
@@ -97,3 +107,47 @@ func cleanupTempFiles(dir string, label string) {
 		slog.Info("Temp file cleanup completed", "type", label, "deleted_files", deletedCount)
 	}
 }
+
+// reconcileUploads syncs persisted upload sessions with the staging area, dropping sessions whose data is gone
+func (server *Server) reconcileUploads() {
+	uploads, err := database.ListAllUploads(server.database)
+	if err != nil {
+		slog.Error("Failed to list uploads for reconciliation", "error", err)
+		return
+	}
+
+	removedCount := 0
+	for _, upload := range uploads {
+		info, statErr := os.Stat(filepath.Join(os.TempDir(), "lectures-uploads", upload.ID, "upload.data"))
+		if statErr != nil {
+			if _, deleteErr := server.database.Exec("DELETE FROM uploads WHERE id = ?", upload.ID); deleteErr == nil {
+				removedCount++
+			}
+			continue
+		}
+		if info.Size() != upload.BytesReceived {
+			if _, updateErr := server.database.Exec("UPDATE uploads SET bytes_received = ?, updated_at = ? WHERE id = ?", info.Size(), time.Now(), upload.ID); updateErr != nil {
+				slog.Warn("Failed to reconcile upload progress", "uploadID", upload.ID, "error", updateErr)
+			}
+		}
+	}
+
+	if removedCount > 0 {
+		slog.Info("Upload reconciliation completed", "removed_sessions", removedCount)
+	}
+}
+
+// expireDeadJobs takes the failed jobs kept in the dead-letter queue for longer than the configured retention out
+// of it
+func (server *Server) expireDeadJobs() {
+	retention := jobs.DefaultDeadJobRetention
+	if retentionDays := server.configuration.Jobs.DeadLetterRetentionDays; retentionDays < 0 {
+		return
+	} else if retentionDays > 0 {
+		retention = time.Duration(retentionDays) * 24 * time.Hour
+	}
+
+	if _, err := server.jobQueue.ExpireDeadJobs(retention); err != nil {
+		slog.Error("Failed to expire dead jobs", "error", err)
+	}
+}
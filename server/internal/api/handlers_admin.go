@@ -0,0 +1,467 @@
+package api
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"lectures/internal/database"
+	"lectures/internal/llm"
+	"lectures/internal/models"
+	"lectures/internal/privacy"
+	"lectures/internal/prompts"
+)
+
+// requireAdmin verifies the authenticated user has the admin role, writing an error response otherwise
+func (server *Server) requireAdmin(responseWriter http.ResponseWriter, request *http.Request) bool {
+	userID := server.getUserID(request)
+
+	var role string
+	err := server.database.QueryRow("SELECT role FROM users WHERE id = ?", userID).Scan(&role)
+	if err != nil {
+		server.writeError(responseWriter, http.StatusUnauthorized, "AUTHENTICATION_ERROR", "Failed to verify user role", nil)
+		return false
+	}
+
+	if role != "admin" {
+		server.writeError(responseWriter, http.StatusForbidden, "FORBIDDEN", "Administrator privileges required", nil)
+		return false
+	}
+
+	return true
+}
+
+// handleGetQueueStatus reports pending/running job counts per type, the worker pools and whether intake is paused
+func (server *Server) handleGetQueueStatus(responseWriter http.ResponseWriter, request *http.Request) {
+	if !server.requireAdmin(responseWriter, request) {
+		return
+	}
+
+	statistics, err := server.jobQueue.Statistics()
+	if err != nil {
+		server.writeError(responseWriter, http.StatusInternalServerError, "DATABASE_ERROR", "Failed to read queue statistics", nil)
+		return
+	}
+
+	server.writeJSON(responseWriter, http.StatusOK, map[string]any{
+		"paused": server.jobQueue.IsPaused(),
+		"types":  statistics,
+		"pools":  server.jobQueue.PoolStatistics(),
+	})
+}
+
+// handlePauseQueue stops workers from picking up new jobs
+func (server *Server) handlePauseQueue(responseWriter http.ResponseWriter, request *http.Request) {
+	if !server.requireAdmin(responseWriter, request) {
+		return
+	}
+
+	if err := server.jobQueue.Pause(); err != nil {
+		server.writeError(responseWriter, http.StatusInternalServerError, "DATABASE_ERROR", "Failed to pause job intake", nil)
+		return
+	}
+
+	server.broadcastSystemStatus()
+	server.writeJSON(responseWriter, http.StatusOK, map[string]any{"paused": true})
+}
+
+// handleResumeQueue lets workers pick up pending jobs again
+func (server *Server) handleResumeQueue(responseWriter http.ResponseWriter, request *http.Request) {
+	if !server.requireAdmin(responseWriter, request) {
+		return
+	}
+
+	if err := server.jobQueue.Resume(); err != nil {
+		server.writeError(responseWriter, http.StatusInternalServerError, "DATABASE_ERROR", "Failed to resume job intake", nil)
+		return
+	}
+
+	server.broadcastSystemStatus()
+	server.writeJSON(responseWriter, http.StatusOK, map[string]any{"paused": false})
+}
+
+// handleForceFailJob marks a stuck job as failed regardless of its owner
+func (server *Server) handleForceFailJob(responseWriter http.ResponseWriter, request *http.Request) {
+	if !server.requireAdmin(responseWriter, request) {
+		return
+	}
+
+	var failRequest struct {
+		JobID  string `json:"job_id"`
+		Reason string `json:"reason"`
+	}
+	if err := json.NewDecoder(request.Body).Decode(&failRequest); err != nil {
+		server.writeError(responseWriter, http.StatusBadRequest, "VALIDATION_ERROR", "Invalid body", nil)
+		return
+	}
+
+	if failRequest.JobID == "" {
+		server.writeError(responseWriter, http.StatusBadRequest, "VALIDATION_ERROR", "job_id is required", nil)
+		return
+	}
+
+	err := server.jobQueue.ForceFailJob(failRequest.JobID, failRequest.Reason)
+	if err == sql.ErrNoRows {
+		server.writeError(responseWriter, http.StatusNotFound, "NOT_FOUND", "Job not found", nil)
+		return
+	}
+	if err != nil {
+		server.writeError(responseWriter, http.StatusConflict, "INVALID_STATE", err.Error(), nil)
+		return
+	}
+
+	server.writeJSON(responseWriter, http.StatusOK, map[string]string{"message": "Job marked as failed"})
+}
+
+// handleReassignOrphanedJobs moves RUNNING jobs without a live worker back to PENDING
+func (server *Server) handleReassignOrphanedJobs(responseWriter http.ResponseWriter, request *http.Request) {
+	if !server.requireAdmin(responseWriter, request) {
+		return
+	}
+
+	var reassignRequest struct {
+		MinimumAgeSeconds int `json:"minimum_age_seconds"`
+	}
+	if request.ContentLength > 0 {
+		if err := json.NewDecoder(request.Body).Decode(&reassignRequest); err != nil {
+			server.writeError(responseWriter, http.StatusBadRequest, "VALIDATION_ERROR", "Invalid body", nil)
+			return
+		}
+	}
+
+	if reassignRequest.MinimumAgeSeconds < 0 {
+		server.writeError(responseWriter, http.StatusBadRequest, "VALIDATION_ERROR", "minimum_age_seconds must not be negative", nil)
+		return
+	}
+
+	reassignedCount, err := server.jobQueue.ReassignOrphanedJobs(time.Duration(reassignRequest.MinimumAgeSeconds) * time.Second)
+	if err != nil {
+		server.writeError(responseWriter, http.StatusInternalServerError, "DATABASE_ERROR", "Failed to reassign orphaned jobs", nil)
+		return
+	}
+
+	server.writeJSON(responseWriter, http.StatusOK, map[string]int{"reassigned": reassignedCount})
+}
+
+// handleSetSystemAnnouncement publishes an announcement banner to all users
+func (server *Server) handleSetSystemAnnouncement(responseWriter http.ResponseWriter, request *http.Request) {
+	if !server.requireAdmin(responseWriter, request) {
+		return
+	}
+
+	var announcement models.SystemAnnouncement
+	if err := json.NewDecoder(request.Body).Decode(&announcement); err != nil {
+		server.writeError(responseWriter, http.StatusBadRequest, "VALIDATION_ERROR", "Invalid body", nil)
+		return
+	}
+
+	announcement.Message = strings.TrimSpace(announcement.Message)
+	if announcement.Message == "" {
+		server.writeError(responseWriter, http.StatusBadRequest, "VALIDATION_ERROR", "message is required", nil)
+		return
+	}
+	if announcement.Kind == "" {
+		announcement.Kind = models.AnnouncementKindInfo
+	}
+	if announcement.Level == "" {
+		announcement.Level = models.AnnouncementLevelInfo
+	}
+
+	switch announcement.Kind {
+	case models.AnnouncementKindMaintenance, models.AnnouncementKindOutage, models.AnnouncementKindBudget, models.AnnouncementKindInfo:
+	default:
+		server.writeError(responseWriter, http.StatusBadRequest, "VALIDATION_ERROR", "kind must be one of maintenance, outage, budget, info", nil)
+		return
+	}
+	switch announcement.Level {
+	case models.AnnouncementLevelInfo, models.AnnouncementLevelWarning, models.AnnouncementLevelCritical:
+	default:
+		server.writeError(responseWriter, http.StatusBadRequest, "VALIDATION_ERROR", "level must be one of info, warning, critical", nil)
+		return
+	}
+	if announcement.StartsAt != nil && announcement.EndsAt != nil && !announcement.EndsAt.After(*announcement.StartsAt) {
+		server.writeError(responseWriter, http.StatusBadRequest, "VALIDATION_ERROR", "ends_at must be after starts_at", nil)
+		return
+	}
+
+	announcement.UpdatedAt = time.Now()
+	valueJSON, _ := json.Marshal(announcement)
+	_, err := server.database.Exec(`
+		INSERT INTO settings (key, value, updated_at)
+		VALUES (?, ?, ?)
+		ON CONFLICT(key) DO UPDATE SET value = excluded.value, updated_at = excluded.updated_at
+	`, systemAnnouncementSettingKey, string(valueJSON), time.Now())
+	if err != nil {
+		server.writeError(responseWriter, http.StatusInternalServerError, "DATABASE_ERROR", "Failed to store announcement", nil)
+		return
+	}
+
+	server.broadcastSystemStatus()
+	server.writeJSON(responseWriter, http.StatusOK, announcement)
+}
+
+// handleClearSystemAnnouncement removes the announcement banner
+func (server *Server) handleClearSystemAnnouncement(responseWriter http.ResponseWriter, request *http.Request) {
+	if !server.requireAdmin(responseWriter, request) {
+		return
+	}
+
+	if _, err := server.database.Exec("DELETE FROM settings WHERE key = ?", systemAnnouncementSettingKey); err != nil {
+		server.writeError(responseWriter, http.StatusInternalServerError, "DATABASE_ERROR", "Failed to clear announcement", nil)
+		return
+	}
+
+	server.broadcastSystemStatus()
+	server.writeJSON(responseWriter, http.StatusOK, map[string]string{"message": "Announcement cleared"})
+}
+
+// handleGetUsageStatistics reports aggregated usage for the deployment under the configured privacy policy
+func (server *Server) handleGetUsageStatistics(responseWriter http.ResponseWriter, request *http.Request) {
+	if !server.requireAdmin(responseWriter, request) {
+		return
+	}
+
+	policy := privacy.NewPolicy(server.configuration.Privacy.UsageStatistics)
+	if policy.Name == privacy.PolicyDisabled {
+		server.writeError(responseWriter, http.StatusForbidden, "STATISTICS_DISABLED", "Usage statistics are disabled by the privacy policy", nil)
+		return
+	}
+
+	windowDays := 30
+	if daysValue := request.URL.Query().Get("days"); daysValue != "" {
+		parsedDays, err := strconv.Atoi(daysValue)
+		if err != nil || parsedDays < 1 || parsedDays > 365 {
+			server.writeError(responseWriter, http.StatusBadRequest, "VALIDATION_ERROR", "days must be between 1 and 365", nil)
+			return
+		}
+		windowDays = parsedDays
+	}
+	// Windows end at the start of the current UTC day, so the figures of a window stay the same all day and
+	// comparing two requests cannot isolate what one user did in between
+	until := time.Now().UTC().Truncate(24 * time.Hour)
+	since := until.AddDate(0, 0, -windowDays)
+
+	var budget *database.PrivacyBudget
+	if policy.Name == privacy.PolicyPrivate {
+		noiseKey, err := database.PrivacyNoiseKey(server.database)
+		if err != nil {
+			server.writeError(responseWriter, http.StatusInternalServerError, "DATABASE_ERROR", "Failed to read the privacy noise key", nil)
+			return
+		}
+		day := until.Format("2006-01-02")
+		window := fmt.Sprintf("%s/%d", day, windowDays)
+		spending, allowed, err := database.SpendPrivacyBudget(server.database, day, window, policy.ReleaseCost(usageStatisticsFigureGroups), policy.EpsilonBudget)
+		if err != nil {
+			server.writeError(responseWriter, http.StatusInternalServerError, "DATABASE_ERROR", "Failed to account for the privacy budget", nil)
+			return
+		}
+		if !allowed {
+			server.writeError(responseWriter, http.StatusTooManyRequests, "PRIVACY_BUDGET_EXHAUSTED", "The privacy budget of today is spent; windows already released today can still be read", map[string]any{
+				"epsilon_spent":    spending.Spent,
+				"epsilon_budget":   policy.EpsilonBudget,
+				"released_windows": spending.Windows,
+			})
+			return
+		}
+		budget = &spending
+		policy = policy.ForWindow(noiseKey, window)
+	}
+
+	jobEvents, err := database.ListJobUsage(server.database, since, until)
+	if err != nil {
+		server.writeError(responseWriter, http.StatusInternalServerError, "DATABASE_ERROR", "Failed to read job usage", nil)
+		return
+	}
+	toolEvents, err := database.ListToolUsage(server.database, since, until)
+	if err != nil {
+		server.writeError(responseWriter, http.StatusInternalServerError, "DATABASE_ERROR", "Failed to read tool usage", nil)
+		return
+	}
+
+	var totalUsers int
+	server.database.QueryRow("SELECT COUNT(*) FROM users").Scan(&totalUsers)
+
+	jobsByType := privacy.NewTally()
+	dailyActivity := privacy.NewTally()
+	costsByUser := make(map[string]float64)
+	for _, event := range jobEvents {
+		jobsByType.Add(event.Type, event.UserID, event.EstimatedCost)
+		dailyActivity.Add(event.CreatedAt.UTC().Format("2006-01-02"), event.UserID, event.EstimatedCost)
+		costsByUser[event.UserID] += event.EstimatedCost
+	}
+	toolsByType := privacy.NewTally()
+	for _, event := range toolEvents {
+		toolsByType.Add(event.Type, event.UserID, event.EstimatedCost)
+	}
+
+	jobFigures, suppressedJobBuckets := policy.Release("jobs_by_type", jobsByType)
+	toolFigures, suppressedToolBuckets := policy.Release("tools_by_type", toolsByType)
+	dailyFigures, suppressedDays := policy.Release("daily_activity", dailyActivity)
+
+	statistics := map[string]any{
+		"policy":             policy.Name,
+		"minimum_group_size": policy.MinimumGroupSize,
+		"window_days":        windowDays,
+		"window_start":       since,
+		"window_end":         until,
+		"users": map[string]int{
+			"total":  policy.Count("users_total", totalUsers),
+			"active": policy.Count("users_active", len(costsByUser)),
+		},
+		"jobs_by_type":         jobFigures,
+		"tools_by_type":        toolFigures,
+		"daily_activity":       dailyFigures,
+		"estimated_cost_total": policy.Total("estimated_cost_total", costsByUser),
+		"suppressed_buckets":   suppressedJobBuckets + suppressedToolBuckets + suppressedDays,
+		"workers":              server.jobQueue.PoolStatistics(),
+	}
+	if budget != nil {
+		statistics["epsilon_spent"] = budget.Spent
+		statistics["epsilon_budget"] = policy.EpsilonBudget
+	}
+	server.writeJSON(responseWriter, http.StatusOK, statistics)
+}
+
+// usageStatisticsFigureGroups is the number of noised groups of figures the usage statistics release: the two
+// user counts, jobs, tools and daily activity, and the cost total
+const usageStatisticsFigureGroups = 6
+
+// handleListPromptVariants lists the registered prompt variants together with the statistics of every variant,
+// the prompt files included, so experiments can be compared. An optional prompt_path narrows both to one prompt
+func (server *Server) handleListPromptVariants(responseWriter http.ResponseWriter, request *http.Request) {
+	if !server.requireAdmin(responseWriter, request) {
+		return
+	}
+
+	promptPath := request.URL.Query().Get("prompt_path")
+	variants, err := prompts.ListPromptVariants(server.database, promptPath, false)
+	if err != nil {
+		server.writeError(responseWriter, http.StatusInternalServerError, "DATABASE_ERROR", "Failed to list prompt variants", nil)
+		return
+	}
+	statistics, err := prompts.PromptVariantStatistics(server.database, promptPath)
+	if err != nil {
+		server.writeError(responseWriter, http.StatusInternalServerError, "DATABASE_ERROR", "Failed to compute prompt variant statistics", nil)
+		return
+	}
+
+	server.writeJSON(responseWriter, http.StatusOK, map[string]any{
+		"experiments_enabled": server.configuration.LLM.PromptExperiments,
+		"variants":            variants,
+		"statistics":          statistics,
+	})
+}
+
+// handleCreatePromptVariant registers an alternative template for an experimental prompt
+func (server *Server) handleCreatePromptVariant(responseWriter http.ResponseWriter, request *http.Request) {
+	if !server.requireAdmin(responseWriter, request) {
+		return
+	}
+
+	var createRequest struct {
+		PromptPath string   `json:"prompt_path"`
+		Name       string   `json:"name"`
+		Content    string   `json:"content"`
+		Weight     *float64 `json:"weight"` // Omitted for the weight of the prompt file; 0 keeps the variant from being drawn
+	}
+	if err := json.NewDecoder(request.Body).Decode(&createRequest); err != nil {
+		server.writeError(responseWriter, http.StatusBadRequest, "VALIDATION_ERROR", "Invalid request body", nil)
+		return
+	}
+
+	variant := models.PromptVariant{
+		PromptPath: createRequest.PromptPath,
+		Name:       strings.TrimSpace(createRequest.Name),
+		Content:    createRequest.Content,
+		Weight:     prompts.BaselineVariantWeight,
+	}
+	if createRequest.Weight != nil {
+		variant.Weight = *createRequest.Weight
+	}
+	if variant.PromptPath == "" || variant.Name == "" || strings.TrimSpace(variant.Content) == "" {
+		server.writeError(responseWriter, http.StatusBadRequest, "VALIDATION_ERROR", "prompt_path, name and content are required", nil)
+		return
+	}
+	if !prompts.IsExperimentalPrompt(variant.PromptPath) {
+		server.writeError(responseWriter, http.StatusBadRequest, "VALIDATION_ERROR", "Prompt does not support experiments: "+variant.PromptPath, nil)
+		return
+	}
+	if variant.Weight < 0 {
+		server.writeError(responseWriter, http.StatusBadRequest, "VALIDATION_ERROR", "weight cannot be negative", nil)
+		return
+	}
+
+	if err := prompts.CreatePromptVariant(server.database, &variant); err != nil {
+		server.writeError(responseWriter, http.StatusInternalServerError, "DATABASE_ERROR", "Failed to register prompt variant", nil)
+		return
+	}
+
+	server.writeJSON(responseWriter, http.StatusCreated, variant)
+}
+
+// handleUpdatePromptVariant retires or reactivates a prompt variant, or changes its weight
+func (server *Server) handleUpdatePromptVariant(responseWriter http.ResponseWriter, request *http.Request) {
+	if !server.requireAdmin(responseWriter, request) {
+		return
+	}
+
+	var updateRequest struct {
+		ID     string   `json:"id"`
+		Active *bool    `json:"active"`
+		Weight *float64 `json:"weight"`
+	}
+	if err := json.NewDecoder(request.Body).Decode(&updateRequest); err != nil {
+		server.writeError(responseWriter, http.StatusBadRequest, "VALIDATION_ERROR", "Invalid request body", nil)
+		return
+	}
+
+	if updateRequest.ID == "" {
+		server.writeError(responseWriter, http.StatusBadRequest, "VALIDATION_ERROR", "id is required", nil)
+		return
+	}
+	if updateRequest.Weight != nil && *updateRequest.Weight < 0 {
+		server.writeError(responseWriter, http.StatusBadRequest, "VALIDATION_ERROR", "weight cannot be negative", nil)
+		return
+	}
+
+	err := prompts.UpdatePromptVariant(server.database, updateRequest.ID, updateRequest.Active, updateRequest.Weight)
+	if err == sql.ErrNoRows {
+		server.writeError(responseWriter, http.StatusNotFound, "NOT_FOUND", "Prompt variant not found", nil)
+		return
+	}
+	if err != nil {
+		server.writeError(responseWriter, http.StatusInternalServerError, "DATABASE_ERROR", "Failed to update prompt variant", nil)
+		return
+	}
+
+	server.writeJSON(responseWriter, http.StatusOK, map[string]string{"message": "Prompt variant updated"})
+}
+
+// providerReporter is implemented by the routing provider, which counts the calls each provider served and
+// fails over the providers that are down
+type providerReporter interface {
+	FailoverStatuses() []llm.FailoverStatus
+	ProviderCalls() []llm.ProviderCalls
+}
+
+// handleGetLLMProviders reports the calls each LLM provider served since the server started and the state of
+// the circuit of every provider with a failover
+func (server *Server) handleGetLLMProviders(responseWriter http.ResponseWriter, request *http.Request) {
+	if !server.requireAdmin(responseWriter, request) {
+		return
+	}
+
+	report := map[string]any{
+		"calls":     []llm.ProviderCalls{},
+		"failovers": []llm.FailoverStatus{},
+	}
+	if reporter, isReporter := server.llmProvider.(providerReporter); isReporter {
+		report["calls"] = reporter.ProviderCalls()
+		report["failovers"] = reporter.FailoverStatuses()
+	}
+	server.writeJSON(responseWriter, http.StatusOK, report)
+}
@@ -0,0 +1,164 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"lectures/internal/models"
+	"lectures/internal/prompts"
+)
+
+func TestHandleSystemAnnouncement(t *testing.T) {
+	server, userID, sessionID, cleanup := setupUniqueExtraTestEnv(t, "announcement")
+	defer cleanup()
+
+	sendRequest := func(method, path string, payload any) *httptest.ResponseRecorder {
+		var body []byte
+		if payload != nil {
+			body, _ = json.Marshal(payload)
+		}
+		req := httptest.NewRequest(method, path, bytes.NewBuffer(body))
+		req.Header.Set("Authorization", "Bearer "+sessionID)
+		req.Header.Set("X-Requested-With", "XMLHttpRequest")
+		rr := httptest.NewRecorder()
+		server.Handler().ServeHTTP(rr, req)
+		return rr
+	}
+
+	announcement := map[string]any{"kind": "maintenance", "level": "warning", "message": "Upgrading storage tonight"}
+	if rr := sendRequest("PUT", "/api/admin/system/announcement", announcement); rr.Code != http.StatusForbidden {
+		t.Fatalf("Expected status 403 for non-admin, got %d", rr.Code)
+	}
+
+	server.database.Exec("UPDATE users SET role = 'admin' WHERE id = ?", userID)
+
+	if rr := sendRequest("PUT", "/api/admin/system/announcement", map[string]any{"kind": "party", "message": "x"}); rr.Code != http.StatusBadRequest {
+		t.Errorf("Expected status 400 for unknown kind, got %d", rr.Code)
+	}
+	if rr := sendRequest("PUT", "/api/admin/system/announcement", announcement); rr.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", rr.Code, rr.Body.String())
+	}
+
+	readStatus := func() (string, bool) {
+		rr := sendRequest("GET", "/api/system/status", nil)
+		var status struct {
+			Data struct {
+				Announcement *struct {
+					Message string `json:"message"`
+				} `json:"announcement"`
+				QueuePaused bool `json:"queue_paused"`
+			} `json:"data"`
+		}
+		json.Unmarshal(rr.Body.Bytes(), &status)
+		if status.Data.Announcement == nil {
+			return "", status.Data.QueuePaused
+		}
+		return status.Data.Announcement.Message, status.Data.QueuePaused
+	}
+
+	if message, _ := readStatus(); message != "Upgrading storage tonight" {
+		t.Errorf("Expected announcement in status, got %q", message)
+	}
+
+	sendRequest("POST", "/api/admin/queue/pause", nil)
+	if _, paused := readStatus(); !paused {
+		t.Error("Expected queue_paused to be reported")
+	}
+
+	expired := time.Now().Add(-time.Minute)
+	sendRequest("PUT", "/api/admin/system/announcement", map[string]any{"message": "Old outage", "kind": "outage", "ends_at": expired})
+	if message, _ := readStatus(); message != "" {
+		t.Errorf("Expected expired announcement to be hidden, got %q", message)
+	}
+
+	if rr := sendRequest("DELETE", "/api/admin/system/announcement", nil); rr.Code != http.StatusOK {
+		t.Errorf("Expected status 200 on clear, got %d", rr.Code)
+	}
+}
+
+func TestHandlePromptVariantsAndToolRating(t *testing.T) {
+	server, userID, sessionID, cleanup := setupUniqueExtraTestEnv(t, "experiments")
+	defer cleanup()
+
+	server.database.Exec("INSERT INTO exams (id, user_id, title) VALUES ('experiment-exam', ?, 'Physics')", userID)
+	server.database.Exec("INSERT INTO tools (id, exam_id, type, title, content) VALUES ('experiment-tool', 'experiment-exam', 'guide', 'Guide', '# Guide')")
+
+	sendRequest := func(method string, path string, body any) *httptest.ResponseRecorder {
+		encodedBody, _ := json.Marshal(body)
+		req := httptest.NewRequest(method, path, bytes.NewBuffer(encodedBody))
+		req.Header.Set("Authorization", "Bearer "+sessionID)
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("X-Requested-With", "XMLHttpRequest")
+		rr := httptest.NewRecorder()
+		server.Handler().ServeHTTP(rr, req)
+		return rr
+	}
+
+	variantBody := map[string]any{"prompt_path": prompts.PromptStudyGuideSectionGeneration, "name": "shorter", "content": "Write {{section_title}}"}
+	if rr := sendRequest("POST", "/api/admin/prompt-variants", variantBody); rr.Code != http.StatusForbidden {
+		t.Errorf("Expected non-admins to be refused, got %d", rr.Code)
+	}
+	server.database.Exec("UPDATE users SET role = 'admin' WHERE id = ?", userID)
+
+	if rr := sendRequest("POST", "/api/admin/prompt-variants", map[string]any{"prompt_path": prompts.PromptLatexInstructions, "name": "x", "content": "x"}); rr.Code != http.StatusBadRequest {
+		t.Errorf("Expected a non-experimental prompt to be refused, got %d", rr.Code)
+	}
+	rr := sendRequest("POST", "/api/admin/prompt-variants", variantBody)
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("Expected status 201, got %d. Body: %s", rr.Code, rr.Body.String())
+	}
+	var created struct {
+		Data models.PromptVariant `json:"data"`
+	}
+	json.Unmarshal(rr.Body.Bytes(), &created)
+	if created.Data.ID == "" || created.Data.Weight != 1 || !created.Data.Active {
+		t.Errorf("Unexpected variant: %+v", created.Data)
+	}
+
+	server.database.Exec("INSERT INTO prompt_assignments (job_id, prompt_path, variant_id, tool_id, adherence_score_total, adherence_score_count) VALUES ('experiment-job', ?, ?, 'experiment-tool', 150, 2)", prompts.PromptStudyGuideSectionGeneration, created.Data.ID)
+
+	if rr := sendRequest("PUT", "/api/tools/feedback", map[string]any{"tool_id": "experiment-tool", "exam_id": "experiment-exam", "rating": 6}); rr.Code != http.StatusBadRequest {
+		t.Errorf("Expected an out-of-range rating to be refused, got %d", rr.Code)
+	}
+	for _, rating := range []int{2, 5} {
+		if rr := sendRequest("PUT", "/api/tools/feedback", map[string]any{"tool_id": "experiment-tool", "exam_id": "experiment-exam", "rating": rating}); rr.Code != http.StatusOK {
+			t.Fatalf("Expected status 200 for the rating, got %d. Body: %s", rr.Code, rr.Body.String())
+		}
+	}
+
+	if rr := sendRequest("PATCH", "/api/admin/prompt-variants", map[string]any{"id": created.Data.ID, "active": false}); rr.Code != http.StatusOK {
+		t.Errorf("Expected the variant to be retired, got %d", rr.Code)
+	}
+
+	rr = sendRequest("GET", "/api/admin/prompt-variants?prompt_path="+prompts.PromptStudyGuideSectionGeneration, nil)
+	var listing struct {
+		Data struct {
+			Variants   []models.PromptVariant           `json:"variants"`
+			Statistics []models.PromptVariantStatistics `json:"statistics"`
+		} `json:"data"`
+	}
+	json.Unmarshal(rr.Body.Bytes(), &listing)
+	if len(listing.Data.Variants) != 1 || listing.Data.Variants[0].Active {
+		t.Errorf("Expected the retired variant to be listed, got %+v", listing.Data.Variants)
+	}
+	if len(listing.Data.Statistics) != 2 {
+		t.Fatalf("Expected baseline and variant statistics, got %+v", listing.Data.Statistics)
+	}
+	variantStatistics := listing.Data.Statistics[1]
+	if variantStatistics.AverageAdherence != 75 || variantStatistics.Ratings != 1 || variantStatistics.AverageRating != 5 {
+		t.Errorf("Expected the latest rating and the recorded adherence, got %+v", variantStatistics)
+	}
+
+	rr = sendRequest("POST", "/api/admin/prompt-variants", map[string]any{"prompt_path": prompts.PromptStudyGuideSectionGeneration, "name": "paused", "content": "Write {{section_title}}", "weight": 0})
+	var unweighted struct {
+		Data models.PromptVariant `json:"data"`
+	}
+	json.Unmarshal(rr.Body.Bytes(), &unweighted)
+	if rr.Code != http.StatusCreated || unweighted.Data.Weight != 0 {
+		t.Errorf("Expected a weight of 0 to be kept, got %d. Body: %s", rr.Code, rr.Body.String())
+	}
+}
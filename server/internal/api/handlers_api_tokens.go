@@ -0,0 +1,219 @@
+package api
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"lectures/internal/models"
+
+	gonanoid "github.com/matoous/go-nanoid/v2"
+)
+
+// apiTokenPrefix starts every API token, telling them apart from session tokens in Authorization headers
+const apiTokenPrefix = "lat_"
+
+// maximumAPITokenNameLength bounds the names users give their API tokens
+const maximumAPITokenNameLength = 100
+
+// hashToken returns the SHA-256 of an API or password reset token as stored. Tokens are random, so a fast hash
+// is enough
+func hashToken(token string) string {
+	digest := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(digest[:])
+}
+
+// bearerAPIToken returns the API token of the Authorization header of a request, or an empty string when it
+// carries none, such as for session tokens
+func bearerAPIToken(request *http.Request) string {
+	token, found := strings.CutPrefix(request.Header.Get("Authorization"), "Bearer ")
+	if !found || !strings.HasPrefix(token, apiTokenPrefix) {
+		return ""
+	}
+	return token
+}
+
+// authenticateAPIToken returns the user and role of the API token of a request, after checking that it is
+// valid and that its scopes allow the route. It writes the error and returns false otherwise
+func (server *Server) authenticateAPIToken(responseWriter http.ResponseWriter, request *http.Request, token string) (string, string, bool) {
+	var tokenID, userID, userRole, rawScopes string
+	var expiresAt sql.NullTime
+	var passwordChangeRequired bool
+	err := server.database.QueryRow(`
+		SELECT api_tokens.id, api_tokens.user_id, users.role, api_tokens.scopes, api_tokens.expires_at, users.password_change_required
+		FROM api_tokens
+		JOIN users ON api_tokens.user_id = users.id
+		WHERE api_tokens.token_hash = ?
+	`, hashToken(token)).Scan(&tokenID, &userID, &userRole, &rawScopes, &expiresAt, &passwordChangeRequired)
+	if err != nil {
+		server.writeError(responseWriter, http.StatusUnauthorized, "AUTHENTICATION_ERROR", "Invalid API token", nil)
+		return "", "", false
+	}
+	if expiresAt.Valid && time.Now().After(expiresAt.Time) {
+		server.writeError(responseWriter, http.StatusUnauthorized, "AUTHENTICATION_ERROR", "API token expired", nil)
+		return "", "", false
+	}
+	// Passwords are changed from a session, so an account that must change its password cannot use its tokens
+	if passwordChangeRequired {
+		server.writeError(responseWriter, http.StatusForbidden, "PASSWORD_CHANGE_REQUIRED", "You must change your password first", nil)
+		return "", "", false
+	}
+
+	var scopes []string
+	json.Unmarshal([]byte(rawScopes), &scopes)
+	scope := requiredTokenScope(request)
+	if scope == "" {
+		server.writeError(responseWriter, http.StatusForbidden, "FORBIDDEN", "API tokens cannot use this route", nil)
+		return "", "", false
+	}
+	if !tokenHasScope(scopes, scope) {
+		server.writeError(responseWriter, http.StatusForbidden, "FORBIDDEN", "The scopes of this API token do not allow this", map[string]any{
+			"scopes":         scopes,
+			"required_scope": scope,
+		})
+		return "", "", false
+	}
+
+	_, _ = server.database.Exec("UPDATE api_tokens SET last_used_at = ? WHERE id = ?", time.Now(), tokenID)
+	return userID, userRole, true
+}
+
+// handleCreateAPIToken creates a personal access token with scopes, such as "lectures:write", and an optional
+// lifetime in days. The token itself is only returned here
+func (server *Server) handleCreateAPIToken(responseWriter http.ResponseWriter, request *http.Request) {
+	var createRequest struct {
+		Name          string   `json:"name"`
+		Scopes        []string `json:"scopes"`
+		ExpiresInDays int      `json:"expires_in_days"` // Valid until revoked when 0
+	}
+	if err := json.NewDecoder(request.Body).Decode(&createRequest); err != nil {
+		server.writeError(responseWriter, http.StatusBadRequest, "VALIDATION_ERROR", "Invalid request body", nil)
+		return
+	}
+
+	createRequest.Name = strings.TrimSpace(createRequest.Name)
+	if createRequest.Name == "" || len(createRequest.Name) > maximumAPITokenNameLength {
+		server.writeError(responseWriter, http.StatusBadRequest, "VALIDATION_ERROR", "name is required and at most 100 characters", nil)
+		return
+	}
+	if len(createRequest.Scopes) == 0 {
+		server.writeError(responseWriter, http.StatusBadRequest, "VALIDATION_ERROR", "At least one scope is required", nil)
+		return
+	}
+	for _, scope := range createRequest.Scopes {
+		if !isTokenScope(scope) {
+			server.writeError(responseWriter, http.StatusBadRequest, "VALIDATION_ERROR", "Unknown scope: "+scope, nil)
+			return
+		}
+	}
+	if createRequest.ExpiresInDays < 0 {
+		server.writeError(responseWriter, http.StatusBadRequest, "VALIDATION_ERROR", "expires_in_days cannot be negative", nil)
+		return
+	}
+
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		server.writeError(responseWriter, http.StatusInternalServerError, "AUTHENTICATION_ERROR", "Failed to generate token", nil)
+		return
+	}
+	token := apiTokenPrefix + base64.RawURLEncoding.EncodeToString(secret)
+
+	userID := server.getUserID(request)
+	tokenID, _ := gonanoid.New()
+	apiToken := models.APIToken{
+		ID:        tokenID,
+		Name:      createRequest.Name,
+		Prefix:    token[:len(apiTokenPrefix)+8],
+		Scopes:    slices.Compact(slices.Sorted(slices.Values(createRequest.Scopes))),
+		CreatedAt: time.Now(),
+	}
+	if createRequest.ExpiresInDays > 0 {
+		expiresAt := apiToken.CreatedAt.AddDate(0, 0, createRequest.ExpiresInDays)
+		apiToken.ExpiresAt = &expiresAt
+	}
+
+	scopes, _ := json.Marshal(apiToken.Scopes)
+	_, err := server.database.Exec(`
+		INSERT INTO api_tokens (id, user_id, name, token_hash, prefix, scopes, created_at, expires_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+	`, apiToken.ID, userID, apiToken.Name, hashToken(token), apiToken.Prefix, string(scopes), apiToken.CreatedAt, apiToken.ExpiresAt)
+	if err != nil {
+		server.writeError(responseWriter, http.StatusInternalServerError, "DATABASE_ERROR", "Failed to create token", nil)
+		return
+	}
+	server.recordAuditEvent(request, models.AuditEvent{Action: models.AuditActionAPITokenCreated, ResourceType: "api_token", ResourceID: apiToken.ID, Details: map[string]any{"scopes": apiToken.Scopes}})
+	slog.Info("API token created", "userID", userID, "tokenID", apiToken.ID, "scopes", apiToken.Scopes)
+
+	server.writeJSON(responseWriter, http.StatusCreated, map[string]any{
+		"token":     token,
+		"api_token": apiToken,
+	})
+}
+
+// handleListAPITokens lists the API tokens of the current user, without the tokens themselves
+func (server *Server) handleListAPITokens(responseWriter http.ResponseWriter, request *http.Request) {
+	tokenRows, err := server.database.Query(`
+		SELECT id, name, prefix, scopes, created_at, last_used_at, expires_at
+		FROM api_tokens
+		WHERE user_id = ?
+		ORDER BY created_at DESC
+	`, server.getUserID(request))
+	if err != nil {
+		server.writeError(responseWriter, http.StatusInternalServerError, "DATABASE_ERROR", "Failed to list tokens", nil)
+		return
+	}
+	defer tokenRows.Close()
+
+	apiTokens := []models.APIToken{}
+	for tokenRows.Next() {
+		var apiToken models.APIToken
+		var rawScopes string
+		var lastUsedAt, expiresAt sql.NullTime
+		if err := tokenRows.Scan(&apiToken.ID, &apiToken.Name, &apiToken.Prefix, &rawScopes, &apiToken.CreatedAt, &lastUsedAt, &expiresAt); err != nil {
+			server.writeError(responseWriter, http.StatusInternalServerError, "DATABASE_ERROR", "Failed to scan token", nil)
+			return
+		}
+		json.Unmarshal([]byte(rawScopes), &apiToken.Scopes)
+		if lastUsedAt.Valid {
+			apiToken.LastUsedAt = &lastUsedAt.Time
+		}
+		if expiresAt.Valid {
+			apiToken.ExpiresAt = &expiresAt.Time
+		}
+		apiTokens = append(apiTokens, apiToken)
+	}
+
+	server.writeJSON(responseWriter, http.StatusOK, apiTokens)
+}
+
+// handleRevokeAPIToken deletes an API token of the current user, which stops working at once
+func (server *Server) handleRevokeAPIToken(responseWriter http.ResponseWriter, request *http.Request) {
+	var revokeRequest struct {
+		TokenID string `json:"token_id"`
+	}
+	if err := json.NewDecoder(request.Body).Decode(&revokeRequest); err != nil || revokeRequest.TokenID == "" {
+		server.writeError(responseWriter, http.StatusBadRequest, "VALIDATION_ERROR", "token_id is required", nil)
+		return
+	}
+
+	result, err := server.database.Exec("DELETE FROM api_tokens WHERE id = ? AND user_id = ?", revokeRequest.TokenID, server.getUserID(request))
+	if err != nil {
+		server.writeError(responseWriter, http.StatusInternalServerError, "DATABASE_ERROR", "Failed to revoke token", nil)
+		return
+	}
+	if rowsAffected, _ := result.RowsAffected(); rowsAffected == 0 {
+		server.writeError(responseWriter, http.StatusNotFound, "NOT_FOUND", "Token not found", nil)
+		return
+	}
+	server.recordAuditEvent(request, models.AuditEvent{Action: models.AuditActionAPITokenRevoked, ResourceType: "api_token", ResourceID: revokeRequest.TokenID})
+
+	server.writeJSON(responseWriter, http.StatusOK, map[string]string{"message": "Token revoked"})
+}
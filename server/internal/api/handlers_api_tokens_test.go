@@ -0,0 +1,82 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"lectures/internal/models"
+)
+
+func TestHandleAPITokens(t *testing.T) {
+	server, _, sessionID, cleanup := setupUniqueExtraTestEnv(t, "api_tokens")
+	defer cleanup()
+
+	sendRequest := func(authorization string, method string, target string, body map[string]any) *httptest.ResponseRecorder {
+		encodedBody, _ := json.Marshal(body)
+		req := httptest.NewRequest(method, target, bytes.NewBuffer(encodedBody))
+		req.Header.Set("Authorization", "Bearer "+authorization)
+		req.Header.Set("Content-Type", "application/json")
+		if authorization == sessionID {
+			req.Header.Set("X-Requested-With", "XMLHttpRequest")
+		}
+		rr := httptest.NewRecorder()
+		server.Handler().ServeHTTP(rr, req)
+		return rr
+	}
+	createToken := func(scopes ...string) (string, string) {
+		rr := sendRequest(sessionID, "POST", "/api/auth/tokens", map[string]any{"name": "CI uploads", "scopes": scopes, "expires_in_days": 30})
+		if rr.Code != http.StatusCreated {
+			t.Fatalf("Expected status 201 creating a token, got %d: %s", rr.Code, rr.Body.String())
+		}
+		var created struct {
+			Data struct {
+				Token    string          `json:"token"`
+				APIToken models.APIToken `json:"api_token"`
+			} `json:"data"`
+		}
+		json.Unmarshal(rr.Body.Bytes(), &created)
+		return created.Data.Token, created.Data.APIToken.ID
+	}
+
+	if rr := sendRequest(sessionID, "POST", "/api/auth/tokens", map[string]any{"name": "Bad", "scopes": []string{"settings:write"}}); rr.Code != http.StatusBadRequest {
+		t.Errorf("Expected status 400 for an unknown scope, got %d", rr.Code)
+	}
+
+	examsToken, examsTokenID := createToken("exams:write")
+	lecturesToken, _ := createToken("lectures:read")
+	var storedHash string
+	server.database.QueryRow("SELECT token_hash FROM api_tokens WHERE id = ?", examsTokenID).Scan(&storedHash)
+	if storedHash == "" || storedHash == examsToken || storedHash != hashToken(examsToken) {
+		t.Errorf("Expected only the hash of the token stored, got %q", storedHash)
+	}
+
+	// Writing grants reading, and scripts send no CSRF header
+	if rr := sendRequest(examsToken, "GET", "/api/exams", nil); rr.Code != http.StatusOK {
+		t.Errorf("Expected status 200 listing exams with the token, got %d: %s", rr.Code, rr.Body.String())
+	}
+	if rr := sendRequest(examsToken, "POST", "/api/exams", map[string]any{"title": "Scripted exam"}); rr.Code != http.StatusCreated {
+		t.Errorf("Expected status 201 creating an exam with the token, got %d: %s", rr.Code, rr.Body.String())
+	}
+	rr := sendRequest(lecturesToken, "GET", "/api/exams", nil)
+	if rr.Code != http.StatusForbidden || !strings.Contains(rr.Body.String(), "exams:read") {
+		t.Errorf("Expected status 403 requiring exams:read, got %d: %s", rr.Code, rr.Body.String())
+	}
+	if rr := sendRequest(examsToken, "GET", "/api/auth/tokens", nil); rr.Code != http.StatusForbidden {
+		t.Errorf("Expected status 403 managing tokens with a token, got %d", rr.Code)
+	}
+
+	rr = sendRequest(sessionID, "GET", "/api/auth/tokens", nil)
+	if !strings.Contains(rr.Body.String(), `"prefix": "lat_`) || strings.Contains(rr.Body.String(), examsToken) || !strings.Contains(rr.Body.String(), `"last_used_at"`) {
+		t.Errorf("Expected the tokens listed by prefix with their last use, got %s", rr.Body.String())
+	}
+	if rr := sendRequest(sessionID, "DELETE", "/api/auth/tokens", map[string]any{"token_id": examsTokenID}); rr.Code != http.StatusOK {
+		t.Errorf("Expected status 200 revoking the token, got %d", rr.Code)
+	}
+	if rr := sendRequest(examsToken, "GET", "/api/exams", nil); rr.Code != http.StatusUnauthorized {
+		t.Errorf("Expected status 401 with a revoked token, got %d", rr.Code)
+	}
+}
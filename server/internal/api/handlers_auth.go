@@ -1,12 +1,14 @@
 package api
 
 import (
-	"database/sql"
 	"encoding/json"
+	"log/slog"
 	"net/http"
+	"strings"
 	"time"
 
 	"lectures/internal/configuration"
+	"lectures/internal/database"
 	"lectures/internal/llm"
 	"lectures/internal/models"
 
@@ -88,8 +90,8 @@ func (server *Server) handleAuthSetup(responseWriter http.ResponseWriter, reques
 	userID, _ := gonanoid.New()
 	_, databaseError = server.database.Exec(`
 		INSERT INTO users (id, username, password_hash, role, created_at, updated_at)
-		VALUES (?, ?, ?, 'admin', ?, ?)
-	`, userID, setupRequest.Username, string(passwordHash), time.Now(), time.Now())
+		VALUES (?, ?, ?, ?, ?, ?)
+	`, userID, setupRequest.Username, string(passwordHash), models.UserRoleAdmin, time.Now(), time.Now())
 
 	if databaseError != nil {
 		server.writeError(responseWriter, http.StatusInternalServerError, "DATABASE_ERROR", "Failed to create initial user", nil)
@@ -97,38 +99,21 @@ func (server *Server) handleAuthSetup(responseWriter http.ResponseWriter, reques
 	}
 
 	// Create session for auto-login
-	sessionID, _ := gonanoid.New()
-	expiresAt := time.Now().Add(time.Duration(server.configuration.Security.Auth.SessionTimeoutHours) * time.Hour)
-
-	_, databaseError = server.database.Exec(`
-		INSERT INTO auth_sessions (id, user_id, created_at, last_activity, expires_at)
-		VALUES (?, ?, ?, ?, ?)
-	`, sessionID, userID, time.Now(), time.Now(), expiresAt)
-
+	sessionID, expiresAt, databaseError := server.startSession(responseWriter, userID)
 	if databaseError != nil {
 		server.writeError(responseWriter, http.StatusInternalServerError, "DATABASE_ERROR", "Failed to create session", nil)
 		return
 	}
 
-	// Set cookie
-	http.SetCookie(responseWriter, &http.Cookie{
-		Name:     "session_token",
-		Value:    sessionID,
-		Path:     "/",
-		Expires:  expiresAt,
-		HttpOnly: true,
-		Secure:   server.configuration.Security.Auth.RequireHTTPS,
-		SameSite: http.SameSiteLaxMode,
-	})
-
 	server.writeJSON(responseWriter, http.StatusOK, map[string]any{
 		"token":      sessionID,
 		"expires_at": expiresAt.Format(time.RFC3339),
 		"user": map[string]string{
 			"id":       userID,
 			"username": setupRequest.Username,
-			"role":     "admin",
+			"role":     models.UserRoleAdmin,
 		},
+		"permissions": rolePermissions[models.UserRoleAdmin],
 	})
 }
 
@@ -137,6 +122,7 @@ func (server *Server) handleAuthRegister(responseWriter http.ResponseWriter, req
 	var registerRequest struct {
 		Username string `json:"username"`
 		Password string `json:"password"`
+		Email    string `json:"email"` // Optional, where password reset links are sent
 	}
 
 	if decodeError := json.NewDecoder(request.Body).Decode(&registerRequest); decodeError != nil {
@@ -162,6 +148,12 @@ func (server *Server) handleAuthRegister(responseWriter http.ResponseWriter, req
 		return
 	}
 
+	// Behind single sign-on, accounts come from the identity provider
+	if server.oidcEnabled() {
+		server.writeError(responseWriter, http.StatusForbidden, "REGISTRATION_DISABLED", "Accounts are created by signing in with single sign-on", nil)
+		return
+	}
+
 	// Check if username is taken
 	var exists bool
 	server.database.QueryRow("SELECT EXISTS(SELECT 1 FROM users WHERE username = ?)", registerRequest.Username).Scan(&exists)
@@ -170,32 +162,54 @@ func (server *Server) handleAuthRegister(responseWriter http.ResponseWriter, req
 		return
 	}
 
+	var email any
+	if strings.TrimSpace(registerRequest.Email) != "" {
+		address, err := normalizeEmail(registerRequest.Email)
+		if err != nil {
+			server.writeError(responseWriter, http.StatusBadRequest, "VALIDATION_ERROR", err.Error(), nil)
+			return
+		}
+		server.database.QueryRow("SELECT EXISTS(SELECT 1 FROM users WHERE email = ? COLLATE NOCASE)", address).Scan(&exists)
+		if exists {
+			server.writeError(responseWriter, http.StatusConflict, "EMAIL_TAKEN", "Email address is already used by another account", nil)
+			return
+		}
+		email = address
+	}
+
 	passwordHash, err := bcrypt.GenerateFromPassword([]byte(registerRequest.Password), bcrypt.DefaultCost)
 	if err != nil {
 		server.writeError(responseWriter, http.StatusInternalServerError, "AUTHENTICATION_ERROR", "Failed to hash password", nil)
 		return
 	}
 
+	// Self-registered accounts never become administrators
+	role := server.configuration.Security.Auth.RegistrationRole
+	if role != models.UserRoleStudent {
+		role = models.UserRoleTeacher
+	}
+
 	userID, _ := gonanoid.New()
 	_, err = server.database.Exec(`
-		INSERT INTO users (id, username, password_hash, role, created_at, updated_at)
-		VALUES (?, ?, ?, 'user', ?, ?)
-	`, userID, registerRequest.Username, string(passwordHash), time.Now(), time.Now())
+		INSERT INTO users (id, username, password_hash, role, email, created_at, updated_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?)
+	`, userID, registerRequest.Username, string(passwordHash), role, email, time.Now(), time.Now())
 
 	if err != nil {
 		server.writeError(responseWriter, http.StatusInternalServerError, "DATABASE_ERROR", "Failed to create user", nil)
 		return
 	}
+	server.recordAuditEvent(request, models.AuditEvent{Action: models.AuditActionAccountCreated, ActorID: userID, ActorUsername: registerRequest.Username, ResourceType: "user", ResourceID: userID, Details: map[string]any{"role": role}})
 
 	server.writeJSON(responseWriter, http.StatusCreated, map[string]string{"message": "Account created successfully. You can now log in."})
 }
 
-// handleAuthLogin authenticates user and creates a session
-func (server *Server) handleAuthLogin(responseWriter http.ResponseWriter, request *http.Request) {
-	// Rate Limiting
-	clientIP := request.RemoteAddr
+// allowLoginAttempt records an attempt under a key, such as the address of a client, and reports whether it
+// stays within the attempts allowed per hour
+func (server *Server) allowLoginAttempt(key string) bool {
 	server.loginAttemptsMutex.Lock()
-	attempts := server.loginAttempts[clientIP]
+	defer server.loginAttemptsMutex.Unlock()
+	attempts := server.loginAttempts[key]
 	currentTime := time.Now()
 
 	// Clean old attempts
@@ -212,14 +226,16 @@ func (server *Server) handleAuthLogin(responseWriter http.ResponseWriter, reques
 	}
 
 	if len(validAttempts) >= limit {
-		server.loginAttemptsMutex.Unlock()
-		server.writeError(responseWriter, http.StatusTooManyRequests, "RATE_LIMIT", "Too many login attempts. Please try again later.", nil)
-		return
+		server.loginAttempts[key] = validAttempts
+		return false
 	}
+	server.loginAttempts[key] = append(validAttempts, currentTime)
+	return true
+}
 
-	server.loginAttempts[clientIP] = append(validAttempts, currentTime)
-	server.loginAttemptsMutex.Unlock()
-
+// handleAuthLogin authenticates user and creates a session. Failed logins lock out their address, and their
+// username, for a while once there are too many
+func (server *Server) handleAuthLogin(responseWriter http.ResponseWriter, request *http.Request) {
 	var loginRequest struct {
 		Username string `json:"username"`
 		Password string `json:"password"`
@@ -230,57 +246,86 @@ func (server *Server) handleAuthLogin(responseWriter http.ResponseWriter, reques
 		return
 	}
 
-	var user models.User
-	databaseError := server.database.QueryRow("SELECT id, username, password_hash, role FROM users WHERE username = ?", loginRequest.Username).Scan(&user.ID, &user.Username, &user.PasswordHash, &user.Role)
-	if databaseError == sql.ErrNoRows {
-		server.writeError(responseWriter, http.StatusUnauthorized, "AUTHENTICATION_ERROR", "Invalid username or password", nil)
+	// Rate Limiting
+	clientIP := clientAddress(request)
+	usernamePolicy, addressPolicy := server.loginLockoutPolicies()
+	addressLockedUntil, err := database.AddressLockedUntil(server.database, clientIP, addressPolicy)
+	if err != nil {
+		server.writeError(responseWriter, http.StatusInternalServerError, "DATABASE_ERROR", "Failed to check login attempts", nil)
+		return
+	}
+	if !addressLockedUntil.IsZero() {
+		server.recordAuditEvent(request, models.AuditEvent{Action: models.AuditActionLoginBlocked, ActorUsername: loginRequest.Username, Details: map[string]any{"reason": "address"}})
+		server.writeLoginLocked(responseWriter, "RATE_LIMIT", "Too many login attempts. Please try again later.", addressLockedUntil)
+		return
+	}
+	usernameLockedUntil, err := database.UsernameLockedUntil(server.database, loginRequest.Username, usernamePolicy)
+	if err != nil {
+		server.writeError(responseWriter, http.StatusInternalServerError, "DATABASE_ERROR", "Failed to check login attempts", nil)
+		return
+	}
+	if !usernameLockedUntil.IsZero() {
+		server.recordAuditEvent(request, models.AuditEvent{Action: models.AuditActionLoginBlocked, ActorUsername: loginRequest.Username, Details: map[string]any{"reason": "username"}})
+		server.writeLoginLocked(responseWriter, "ACCOUNT_LOCKED", "Too many failed logins for this account. Please try again later.", usernameLockedUntil)
 		return
 	}
 
-	if passwordMatchError := bcrypt.CompareHashAndPassword([]byte(user.PasswordHash), []byte(loginRequest.Password)); passwordMatchError != nil {
+	var user models.User
+	databaseError := server.database.QueryRow("SELECT id, username, password_hash, role, password_change_required FROM users WHERE username = ?", loginRequest.Username).Scan(&user.ID, &user.Username, &user.PasswordHash, &user.Role, &user.PasswordChangeRequired)
+	passwordMatches := databaseError == nil && bcrypt.CompareHashAndPassword([]byte(user.PasswordHash), []byte(loginRequest.Password)) == nil
+	if err := database.RecordLoginAttempt(server.database, loginRequest.Username, clientIP, passwordMatches); err != nil {
+		slog.Warn("Failed to record login attempt", "error", err)
+	}
+	if !passwordMatches {
+		server.recordAuditEvent(request, models.AuditEvent{Action: models.AuditActionLoginFailed, ActorID: user.ID, ActorUsername: loginRequest.Username})
 		server.writeError(responseWriter, http.StatusUnauthorized, "AUTHENTICATION_ERROR", "Invalid username or password", nil)
 		return
 	}
 
 	// Create session
+	sessionID, expiresAt, databaseError := server.startSession(responseWriter, user.ID)
+	if databaseError != nil {
+		server.writeError(responseWriter, http.StatusInternalServerError, "DATABASE_ERROR", "Failed to create session", nil)
+		return
+	}
+	server.recordAuditEvent(request, models.AuditEvent{Action: models.AuditActionLoginSucceeded, ActorID: user.ID, ActorUsername: user.Username})
+
+	server.writeJSON(responseWriter, http.StatusOK, map[string]any{
+		"token":      sessionID,
+		"expires_at": expiresAt.Format(time.RFC3339),
+		"user": map[string]string{
+			"id":       user.ID,
+			"username": user.Username,
+			"role":     user.Role,
+		},
+		"permissions":              rolePermissions[user.Role],
+		"password_change_required": user.PasswordChangeRequired,
+	})
+}
+
+// startSession signs a user in, storing a new session and setting its cookie
+func (server *Server) startSession(responseWriter http.ResponseWriter, userID string) (string, time.Time, error) {
 	sessionID, _ := gonanoid.New()
 	expiresAt := time.Now().Add(time.Duration(server.configuration.Security.Auth.SessionTimeoutHours) * time.Hour)
 
-	_, databaseError = server.database.Exec(`
+	_, err := server.database.Exec(`
 		INSERT INTO auth_sessions (id, user_id, created_at, last_activity, expires_at)
 		VALUES (?, ?, ?, ?, ?)
-	`, sessionID, user.ID, time.Now(), time.Now(), expiresAt)
-
-	if databaseError != nil {
-		server.writeError(responseWriter, http.StatusInternalServerError, "DATABASE_ERROR", "Failed to create session", nil)
-		return
+	`, sessionID, userID, time.Now(), time.Now(), expiresAt)
+	if err != nil {
+		return "", time.Time{}, err
 	}
 
-	// Reset attempts on success
-	server.loginAttemptsMutex.Lock()
-	delete(server.loginAttempts, clientIP)
-	server.loginAttemptsMutex.Unlock()
-
-	// Set cookie
 	http.SetCookie(responseWriter, &http.Cookie{
 		Name:     "session_token",
 		Value:    sessionID,
 		Path:     "/",
 		Expires:  expiresAt,
 		HttpOnly: true,
-		Secure:   server.configuration.Security.Auth.RequireHTTPS,
+		Secure:   server.configuration.Security.Auth.RequireHTTPS || server.configuration.Server.TLS.Enabled(),
 		SameSite: http.SameSiteLaxMode,
 	})
-
-	server.writeJSON(responseWriter, http.StatusOK, map[string]any{
-		"token":      sessionID,
-		"expires_at": expiresAt.Format(time.RFC3339),
-		"user": map[string]string{
-			"id":       user.ID,
-			"username": user.Username,
-			"role":     user.Role,
-		},
-	})
+	return sessionID, expiresAt, nil
 }
 
 // handleAuthLogout invalidates current session
@@ -289,6 +334,7 @@ func (server *Server) handleAuthLogout(responseWriter http.ResponseWriter, reque
 	if sessionToken != "" {
 		server.database.Exec("DELETE FROM auth_sessions WHERE id = ?", sessionToken)
 	}
+	server.recordAuditEvent(request, models.AuditEvent{Action: models.AuditActionLogout})
 
 	// Clear cookie
 	http.SetCookie(responseWriter, &http.Cookie{
@@ -308,29 +354,34 @@ func (server *Server) handleAuthStatus(responseWriter http.ResponseWriter, reque
 	var userCount int
 	server.database.QueryRow("SELECT COUNT(*) FROM users").Scan(&userCount)
 	initialized := userCount > 0
+	// Login pages offer single sign-on, through /api/auth/oidc/login, when it is enabled
+	oidc := server.oidcEnabled()
 
 	sessionToken := server.getSessionToken(request)
 	if sessionToken == "" {
 		server.writeJSON(responseWriter, http.StatusOK, map[string]any{
 			"authenticated": false,
 			"initialized":   initialized,
+			"oidc":          oidc,
 		})
 		return
 	}
 
 	var userID, username, role string
 	var expiresAt time.Time
+	var passwordChangeRequired bool
 	databaseError := server.database.QueryRow(`
-		SELECT auth_sessions.expires_at, users.id, users.username, users.role
+		SELECT auth_sessions.expires_at, users.id, users.username, users.role, users.password_change_required
 		FROM auth_sessions
 		JOIN users ON auth_sessions.user_id = users.id
 		WHERE auth_sessions.id = ?
-	`, sessionToken).Scan(&expiresAt, &userID, &username, &role)
+	`, sessionToken).Scan(&expiresAt, &userID, &username, &role, &passwordChangeRequired)
 
 	if databaseError != nil || time.Now().After(expiresAt) {
 		server.writeJSON(responseWriter, http.StatusOK, map[string]any{
 			"authenticated": false,
 			"initialized":   initialized,
+			"oidc":          oidc,
 		})
 		return
 	}
@@ -338,12 +389,15 @@ func (server *Server) handleAuthStatus(responseWriter http.ResponseWriter, reque
 	server.writeJSON(responseWriter, http.StatusOK, map[string]any{
 		"authenticated": true,
 		"initialized":   initialized,
+		"oidc":          oidc,
 		"expires_at":    expiresAt.Format(time.RFC3339),
 		"user": map[string]string{
 			"id":       userID,
 			"username": username,
 			"role":     role,
 		},
+		"permissions":              rolePermissions[role],
+		"password_change_required": passwordChangeRequired,
 	})
 }
 
@@ -384,11 +438,12 @@ func (server *Server) handleAuthChangePassword(responseWriter http.ResponseWrite
 		return
 	}
 
-	_, err = server.database.Exec("UPDATE users SET password_hash = ?, updated_at = ? WHERE id = ?", string(newHash), time.Now(), userID)
+	_, err = server.database.Exec("UPDATE users SET password_hash = ?, password_change_required = 0, updated_at = ? WHERE id = ?", string(newHash), time.Now(), userID)
 	if err != nil {
 		server.writeError(responseWriter, http.StatusInternalServerError, "DATABASE_ERROR", "Failed to update password", nil)
 		return
 	}
+	server.recordAuditEvent(request, models.AuditEvent{Action: models.AuditActionPasswordChanged, ResourceType: "user", ResourceID: userID})
 
 	server.writeJSON(responseWriter, http.StatusOK, map[string]string{"message": "Password updated successfully"})
 }
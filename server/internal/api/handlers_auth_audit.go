@@ -0,0 +1,185 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"maps"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"lectures/internal/database"
+	"lectures/internal/models"
+)
+
+// Bounds of a page of the audit log of authentication
+const (
+	defaultAuthEventLimit = 100
+	maximumAuthEventLimit = 500
+)
+
+// loginAttemptRetention is how long login attempts are kept for lockouts, well beyond any lockout window
+const loginAttemptRetention = 30 * 24 * time.Hour
+
+// clientAddress returns the IP address a request comes from, without its port
+func clientAddress(request *http.Request) string {
+	host, _, err := net.SplitHostPort(request.RemoteAddr)
+	if err != nil {
+		return request.RemoteAddr
+	}
+	return host
+}
+
+// loginLockoutPolicies returns when failed logins lock out a username and when they lock out an address
+func (server *Server) loginLockoutPolicies() (database.LoginLockoutPolicy, database.LoginLockoutPolicy) {
+	safety := server.configuration.Safety
+	usernamePolicy := database.LoginLockoutPolicy{MaximumFailures: safety.MaximumFailedLoginsPerUsername, Window: time.Duration(safety.LoginLockoutMinutes) * time.Minute}
+	if usernamePolicy.MaximumFailures <= 0 {
+		usernamePolicy.MaximumFailures = 5
+	}
+	if usernamePolicy.Window <= 0 {
+		usernamePolicy.Window = 15 * time.Minute
+	}
+	addressPolicy := database.LoginLockoutPolicy{MaximumFailures: safety.MaximumLoginAttempts, Window: time.Hour}
+	if addressPolicy.MaximumFailures <= 0 {
+		addressPolicy.MaximumFailures = 1000 // Sane high default if not configured
+	}
+	return usernamePolicy, addressPolicy
+}
+
+// authEventOf is the entry of the audit log of authentication for an event of the audit log. Its account is the
+// user the event was on, or else its actor, and an administrator acting on another account is recorded as "by"
+func authEventOf(event models.AuditEvent) models.AuthEvent {
+	authEvent := models.AuthEvent{Event: event.Action, UserID: event.ActorID, IPAddress: event.IPAddress, Details: map[string]any{}}
+	maps.Copy(authEvent.Details, event.Details)
+	switch event.ResourceType {
+	case "user":
+		authEvent.UserID = event.ResourceID
+	case "api_token":
+		authEvent.Details["token_id"] = event.ResourceID
+	}
+	if event.ActorID != "" && event.ActorID != authEvent.UserID {
+		authEvent.Details["by"] = event.ActorID
+	} else {
+		authEvent.Username = event.ActorUsername
+	}
+	return authEvent
+}
+
+// recordAuthEvent adds an event to the audit log of authentication. Failing to record it is logged but never
+// fails the request
+func (server *Server) recordAuthEvent(event models.AuthEvent) {
+	if err := database.RecordAuthEvent(server.database, event); err != nil {
+		slog.Warn("Failed to record authentication event", "event", event.Event, "userID", event.UserID, "error", err)
+	}
+}
+
+// writeLoginLocked answers a login refused because its username or address is locked out, telling clients
+// when to try again
+func (server *Server) writeLoginLocked(responseWriter http.ResponseWriter, code string, message string, lockedUntil time.Time) {
+	responseWriter.Header().Set("Retry-After", strconv.Itoa(int(time.Until(lockedUntil).Seconds())+1))
+	server.writeError(responseWriter, http.StatusTooManyRequests, code, message, map[string]any{
+		"locked_until": lockedUntil.Format(time.RFC3339),
+	})
+}
+
+// handleListAuthEvents lists the audit log of sign-ins, lockouts and changes to the security of accounts,
+// newest first, optionally of a "user_id", "username", "ip_address" or "event"
+func (server *Server) handleListAuthEvents(responseWriter http.ResponseWriter, request *http.Request) {
+	if !server.requireAdmin(responseWriter, request) {
+		return
+	}
+
+	query := request.URL.Query()
+	filter := database.AuthEventFilter{
+		UserID:    query.Get("user_id"),
+		Username:  query.Get("username"),
+		IPAddress: query.Get("ip_address"),
+		Event:     query.Get("event"),
+		Limit:     defaultAuthEventLimit,
+	}
+	if limitValue := query.Get("limit"); limitValue != "" {
+		limit, err := strconv.Atoi(limitValue)
+		if err != nil || limit < 1 || limit > maximumAuthEventLimit {
+			server.writeError(responseWriter, http.StatusBadRequest, "VALIDATION_ERROR", fmt.Sprintf("limit must be between 1 and %d", maximumAuthEventLimit), nil)
+			return
+		}
+		filter.Limit = limit
+	}
+	if beforeValue := query.Get("before_id"); beforeValue != "" {
+		beforeID, err := strconv.ParseInt(beforeValue, 10, 64)
+		if err != nil || beforeID < 1 {
+			server.writeError(responseWriter, http.StatusBadRequest, "VALIDATION_ERROR", "before_id must be an event ID", nil)
+			return
+		}
+		filter.BeforeID = beforeID
+	}
+
+	events, err := database.ListAuthEvents(server.database, filter)
+	if err != nil {
+		server.writeError(responseWriter, http.StatusInternalServerError, "DATABASE_ERROR", "Failed to list authentication events", nil)
+		return
+	}
+	server.writeJSON(responseWriter, http.StatusOK, events)
+}
+
+// handleListLoginLockouts lists the usernames and the addresses currently refused logins, with until when
+func (server *Server) handleListLoginLockouts(responseWriter http.ResponseWriter, request *http.Request) {
+	if !server.requireAdmin(responseWriter, request) {
+		return
+	}
+
+	usernamePolicy, addressPolicy := server.loginLockoutPolicies()
+	lockouts, err := database.ListLoginLockouts(server.database, usernamePolicy, addressPolicy)
+	if err != nil {
+		server.writeError(responseWriter, http.StatusInternalServerError, "DATABASE_ERROR", "Failed to list lockouts", nil)
+		return
+	}
+	server.writeJSON(responseWriter, http.StatusOK, lockouts)
+}
+
+// handleUnlockLogin lifts the lockout of a "username", of an "ip_address", or both, by no longer counting their
+// failed logins
+func (server *Server) handleUnlockLogin(responseWriter http.ResponseWriter, request *http.Request) {
+	if !server.requireAdmin(responseWriter, request) {
+		return
+	}
+
+	var unlockRequest struct {
+		Username  string `json:"username"`
+		IPAddress string `json:"ip_address"`
+	}
+	if err := json.NewDecoder(request.Body).Decode(&unlockRequest); err != nil {
+		server.writeError(responseWriter, http.StatusBadRequest, "VALIDATION_ERROR", "Invalid request body", nil)
+		return
+	}
+	unlockRequest.IPAddress = strings.TrimSpace(unlockRequest.IPAddress)
+	if unlockRequest.Username == "" && unlockRequest.IPAddress == "" {
+		server.writeError(responseWriter, http.StatusBadRequest, "VALIDATION_ERROR", "username or ip_address is required", nil)
+		return
+	}
+
+	clearedAttempts, err := database.ClearFailedLogins(server.database, unlockRequest.Username, unlockRequest.IPAddress)
+	if err != nil {
+		server.writeError(responseWriter, http.StatusInternalServerError, "DATABASE_ERROR", "Failed to unlock", nil)
+		return
+	}
+
+	event := models.AuditEvent{Action: models.AuditActionAccountUnlocked, Details: map[string]any{"cleared_attempts": clearedAttempts}}
+	if unlockRequest.Username != "" {
+		event.Details["username"] = unlockRequest.Username
+		if server.database.QueryRow("SELECT id FROM users WHERE username = ?", unlockRequest.Username).Scan(&event.ResourceID) == nil {
+			event.ResourceType = "user"
+		}
+	}
+	if unlockRequest.IPAddress != "" {
+		event.Details["unlocked_ip_address"] = unlockRequest.IPAddress
+	}
+	server.recordAuditEvent(request, event)
+	slog.Info("Administrator unlocked logins", "adminID", server.getUserID(request), "username", unlockRequest.Username, "ipAddress", unlockRequest.IPAddress, "clearedAttempts", clearedAttempts)
+
+	server.writeJSON(responseWriter, http.StatusOK, map[string]any{"cleared_attempts": clearedAttempts})
+}
@@ -0,0 +1,138 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"lectures/internal/models"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+func TestHandleLoginLockout(t *testing.T) {
+	server, userID, sessionID, cleanup := setupUniqueExtraTestEnv(t, "login_lockout")
+	defer cleanup()
+	server.database.Exec("UPDATE users SET role = 'admin' WHERE id = ?", userID)
+	server.configuration.Safety.MaximumFailedLoginsPerUsername = 3
+	server.configuration.Safety.MaximumLoginAttempts = 5
+
+	passwordHash, _ := bcrypt.GenerateFromPassword([]byte("victim-password"), bcrypt.DefaultCost)
+	server.database.Exec("INSERT INTO users (id, username, password_hash, role) VALUES ('victim-lockout', 'victim_lockout', ?, 'teacher')", string(passwordHash))
+
+	login := func(remoteAddress string, username string, password string) *httptest.ResponseRecorder {
+		encodedBody, _ := json.Marshal(map[string]string{"username": username, "password": password})
+		req := httptest.NewRequest("POST", "/api/auth/login", bytes.NewBuffer(encodedBody))
+		req.RemoteAddr = remoteAddress
+		rr := httptest.NewRecorder()
+		server.Handler().ServeHTTP(rr, req)
+		return rr
+	}
+	sendAdminRequest := func(method string, target string, body map[string]any) *httptest.ResponseRecorder {
+		encodedBody, _ := json.Marshal(body)
+		req := httptest.NewRequest(method, target, bytes.NewBuffer(encodedBody))
+		req.Header.Set("Authorization", "Bearer "+sessionID)
+		req.Header.Set("X-Requested-With", "XMLHttpRequest")
+		rr := httptest.NewRecorder()
+		server.Handler().ServeHTTP(rr, req)
+		return rr
+	}
+
+	// Failures from several addresses add up for the username, and lock out even the right password
+	for attempt := range 3 {
+		if rr := login(fmt.Sprintf("198.51.100.%d:4000", attempt), "victim_lockout", "wrong-password"); rr.Code != http.StatusUnauthorized {
+			t.Fatalf("Expected status 401 for a wrong password, got %d", rr.Code)
+		}
+	}
+	rr := login("198.51.100.9:4000", "victim_lockout", "victim-password")
+	if rr.Code != http.StatusTooManyRequests || !strings.Contains(rr.Body.String(), "ACCOUNT_LOCKED") || rr.Header().Get("Retry-After") == "" {
+		t.Fatalf("Expected status 429 ACCOUNT_LOCKED with Retry-After, got %d: %s", rr.Code, rr.Body.String())
+	}
+
+	rr = sendAdminRequest("GET", "/api/admin/auth/lockouts", nil)
+	if rr.Code != http.StatusOK || !strings.Contains(rr.Body.String(), `"username": "victim_lockout"`) {
+		t.Errorf("Expected the username listed as locked out, got %d: %s", rr.Code, rr.Body.String())
+	}
+	if rr := sendAdminRequest("POST", "/api/admin/auth/unlock", map[string]any{"username": "victim_lockout"}); rr.Code != http.StatusOK || !strings.Contains(rr.Body.String(), `"cleared_attempts": 3`) {
+		t.Fatalf("Expected status 200 unlocking the username, got %d: %s", rr.Code, rr.Body.String())
+	}
+	if rr := login("198.51.100.9:4000", "victim_lockout", "victim-password"); rr.Code != http.StatusOK {
+		t.Fatalf("Expected status 200 logging in once unlocked, got %d: %s", rr.Code, rr.Body.String())
+	}
+
+	// An address trying many usernames is locked out, whatever the username
+	for attempt := range 5 {
+		login("203.0.113.7:5000", fmt.Sprintf("guess_%d", attempt), "wrong-password")
+	}
+	if rr := login("203.0.113.7:5001", "victim_lockout", "victim-password"); rr.Code != http.StatusTooManyRequests || !strings.Contains(rr.Body.String(), "RATE_LIMIT") {
+		t.Errorf("Expected status 429 RATE_LIMIT for the address, got %d: %s", rr.Code, rr.Body.String())
+	}
+	if rr := login("203.0.113.8:5000", "victim_lockout", "victim-password"); rr.Code != http.StatusOK {
+		t.Errorf("Expected status 200 from another address, got %d: %s", rr.Code, rr.Body.String())
+	}
+
+	// Logging into one's own account does not reset the failures of the address
+	server.database.Exec("INSERT INTO users (id, username, password_hash, role) VALUES ('sprayer-lockout', 'sprayer_lockout', ?, 'teacher')", string(passwordHash))
+	for attempt := range 4 {
+		login("203.0.113.9:5000", fmt.Sprintf("spray_%d", attempt), "wrong-password")
+	}
+	if rr := login("203.0.113.9:5000", "sprayer_lockout", "victim-password"); rr.Code != http.StatusOK {
+		t.Fatalf("Expected status 200 for the address's own account, got %d: %s", rr.Code, rr.Body.String())
+	}
+	login("203.0.113.9:5000", "spray_4", "wrong-password")
+	if rr := login("203.0.113.9:5000", "sprayer_lockout", "victim-password"); rr.Code != http.StatusTooManyRequests || !strings.Contains(rr.Body.String(), "RATE_LIMIT") {
+		t.Errorf("Expected the address to stay locked out despite its successful login, got %d: %s", rr.Code, rr.Body.String())
+	}
+
+	rr = sendAdminRequest("GET", "/api/admin/audit?actor_username=victim_lockout", nil)
+	var events struct {
+		Data []models.AuditEvent `json:"data"`
+	}
+	json.Unmarshal(rr.Body.Bytes(), &events)
+	var recorded []string
+	for _, event := range events.Data {
+		recorded = append(recorded, event.Action)
+	}
+	expected := []string{"login_succeeded", "login_blocked", "login_succeeded", "login_blocked", "login_failed", "login_failed", "login_failed"}
+	if strings.Join(recorded, ",") != strings.Join(expected, ",") {
+		t.Errorf("Expected the audit log %v, got %v", expected, recorded)
+	}
+	if len(events.Data) > 0 && (events.Data[0].IPAddress != "203.0.113.8" || events.Data[0].ActorID != "victim-lockout") {
+		t.Errorf("Expected the address and account of the latest event, got %+v", events.Data[0])
+	}
+
+	// Unlocking is acted by the administrator, on the account
+	rr = sendAdminRequest("GET", "/api/admin/audit?action=account_unlocked", nil)
+	json.Unmarshal(rr.Body.Bytes(), &events)
+	if len(events.Data) != 1 || events.Data[0].ActorID != userID || events.Data[0].ResourceID != "victim-lockout" {
+		t.Errorf("Expected the unlock by the administrator in the audit log, got %+v", events.Data)
+	}
+
+	// The audit log of authentication keeps the same events, the unlock under the account it was on
+	rr = sendAdminRequest("GET", "/api/admin/auth/events?username=victim_lockout", nil)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Expected status 200 listing the authentication events, got %d: %s", rr.Code, rr.Body.String())
+	}
+	var authEvents struct {
+		Data []models.AuthEvent `json:"data"`
+	}
+	json.Unmarshal(rr.Body.Bytes(), &authEvents)
+	recorded = nil
+	for _, event := range authEvents.Data {
+		recorded = append(recorded, event.Event)
+	}
+	expected = []string{"login_succeeded", "login_blocked", "login_succeeded", "account_unlocked", "login_blocked", "login_failed", "login_failed", "login_failed"}
+	if strings.Join(recorded, ",") != strings.Join(expected, ",") {
+		t.Errorf("Expected the authentication events %v, got %v", expected, recorded)
+	}
+	if len(authEvents.Data) > 3 && authEvents.Data[3].Details["by"] != userID {
+		t.Errorf("Expected the administrator who unlocked the account, got %+v", authEvents.Data[3])
+	}
+	if rr := sendAdminRequest("GET", "/api/admin/auth/events?limit=0", nil); rr.Code != http.StatusBadRequest {
+		t.Errorf("Expected status 400 for an invalid limit, got %d", rr.Code)
+	}
+}
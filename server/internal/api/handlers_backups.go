@@ -0,0 +1,209 @@
+package api
+
+import (
+	"encoding/json"
+	"io"
+	"log/slog"
+	"mime"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+
+	"lectures/internal/database"
+	"lectures/internal/jobs"
+	"lectures/internal/models"
+	"lectures/internal/storage"
+)
+
+// backupRestore lets a single restore run at a time
+type backupRestore struct {
+	mutex sync.Mutex
+}
+
+// scheduleBackupIfDue queues a backup once the configured interval elapsed since the latest archive
+func (server *Server) scheduleBackupIfDue() {
+	isDue, err := jobs.IsBackupDue(server.database, server.configuration)
+	if err != nil {
+		slog.Error("Failed to check backup schedule", "error", err)
+		return
+	}
+	if !isDue {
+		return
+	}
+	if _, err := server.jobQueue.Enqueue("", models.JobTypeBackup, map[string]string{"trigger": "schedule"}, "", ""); err != nil {
+		slog.Error("Failed to queue scheduled backup", "error", err)
+	}
+}
+
+// handleListBackups lists the backup archives of the backups directory, newest first
+func (server *Server) handleListBackups(responseWriter http.ResponseWriter, request *http.Request) {
+	if !server.requireAdmin(responseWriter, request) {
+		return
+	}
+
+	archives, err := storage.ListBackups(storage.NewLayout(server.configuration.Storage))
+	if err != nil {
+		server.writeError(responseWriter, http.StatusInternalServerError, "BACKUP_ERROR", "Failed to list backups", nil)
+		return
+	}
+	server.writeJSON(responseWriter, http.StatusOK, archives)
+}
+
+// handleCreateBackup queues a backup of the database and files now
+func (server *Server) handleCreateBackup(responseWriter http.ResponseWriter, request *http.Request) {
+	if !server.requireAdmin(responseWriter, request) {
+		return
+	}
+
+	jobID, err := server.jobQueue.Enqueue(server.getUserID(request), models.JobTypeBackup, map[string]string{"trigger": "request"}, "", "")
+	if err != nil {
+		server.writeEnqueueError(responseWriter, err, "Failed to enqueue backup job")
+		return
+	}
+
+	server.writeJSON(responseWriter, http.StatusAccepted, map[string]string{
+		"job_id":  jobID,
+		"message": "Backup job created",
+	})
+}
+
+// handleDownloadBackup serves a backup archive, to keep it off the server or restore it on another one
+func (server *Server) handleDownloadBackup(responseWriter http.ResponseWriter, request *http.Request) {
+	if !server.requireAdmin(responseWriter, request) {
+		return
+	}
+
+	name := request.URL.Query().Get("name")
+	archivePath, err := storage.BackupPath(storage.NewLayout(server.configuration.Storage), name)
+	if err != nil {
+		server.writeError(responseWriter, http.StatusBadRequest, "VALIDATION_ERROR", err.Error(), nil)
+		return
+	}
+	if _, err := os.Stat(archivePath); err != nil {
+		server.writeError(responseWriter, http.StatusNotFound, "NOT_FOUND", "Backup not found", nil)
+		return
+	}
+
+	responseWriter.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": name}))
+	responseWriter.Header().Set("Content-Type", "application/gzip")
+	http.ServeFile(responseWriter, request, archivePath)
+}
+
+// handleRestoreBackup replaces the database and files with those of a backup archive: one of the backups
+// directory named by a JSON body {"name"}, or one uploaded as the "archive" field of a multipart form. The
+// archive is unpacked and its database checked and migrated before anything is replaced, and the restore is
+// refused while jobs are running. Job intake is paused during the restore
+func (server *Server) handleRestoreBackup(responseWriter http.ResponseWriter, request *http.Request) {
+	if !server.requireAdmin(responseWriter, request) {
+		return
+	}
+
+	if !server.backupRestore.mutex.TryLock() {
+		server.writeError(responseWriter, http.StatusConflict, "RESTORE_IN_PROGRESS", "Another restore is in progress", nil)
+		return
+	}
+	defer server.backupRestore.mutex.Unlock()
+
+	var runningJobs int
+	server.database.QueryRow("SELECT COUNT(*) FROM jobs WHERE status = ?", models.JobStatusRunning).Scan(&runningJobs)
+	if runningJobs > 0 {
+		server.writeError(responseWriter, http.StatusConflict, "JOBS_RUNNING", "Jobs are running; pause the queue and wait for them to finish before restoring", map[string]int{"running_jobs": runningJobs})
+		return
+	}
+
+	archiveReader, archiveName, ok := server.openRestoredArchive(responseWriter, request)
+	if !ok {
+		return
+	}
+	defer archiveReader.Close()
+
+	wasPaused := server.jobQueue.IsPaused()
+	if !wasPaused {
+		if err := server.jobQueue.Pause(); err != nil {
+			server.writeError(responseWriter, http.StatusInternalServerError, "RESTORE_ERROR", "Failed to pause job intake", nil)
+			return
+		}
+		defer server.jobQueue.Resume()
+	}
+
+	layout := storage.NewLayout(server.configuration.Storage)
+	extracted, err := storage.ExtractBackupArchive(archiveReader, layout)
+	if err != nil {
+		server.writeError(responseWriter, http.StatusBadRequest, "INVALID_BACKUP", err.Error(), nil)
+		return
+	}
+	defer extracted.Discard()
+
+	if err := database.ValidateSnapshot(extracted.DatabasePath); err != nil {
+		server.writeError(responseWriter, http.StatusBadRequest, "INVALID_BACKUP", err.Error(), nil)
+		return
+	}
+	if err := database.RestoreSnapshot(server.database, extracted.DatabasePath); err != nil {
+		slog.Error("Failed to restore database from backup", "archive", archiveName, "error", err)
+		server.writeError(responseWriter, http.StatusInternalServerError, "RESTORE_ERROR", "Failed to restore the database; it was left unchanged", err.Error())
+		return
+	}
+	if err := extracted.ApplyFiles(); err != nil {
+		slog.Error("Failed to restore files from backup", "archive", archiveName, "error", err)
+		server.writeError(responseWriter, http.StatusInternalServerError, "RESTORE_ERROR", "The database was restored but its files could not be replaced", err.Error())
+		return
+	}
+
+	// The queue keeps its pause flag across the restore rather than taking the backup's
+	if wasPaused {
+		server.jobQueue.Pause()
+	}
+	server.loadSettingsFromDatabase()
+	slog.Info("Restored backup", "archive", archiveName, "created_at", extracted.Manifest.CreatedAt, "files", extracted.Manifest.Files)
+
+	server.writeJSON(responseWriter, http.StatusOK, map[string]any{
+		"name":           archiveName,
+		"created_at":     extracted.Manifest.CreatedAt,
+		"schema_version": extracted.Manifest.SchemaVersion,
+		"files":          extracted.Manifest.Files,
+		"message":        "Backup restored. Sessions are those of the backup, so you may need to log in again.",
+	})
+}
+
+// openRestoredArchive opens the archive a restore request names or uploads, answering 400 or 404 when there is
+// none. Uploads are streamed rather than stored first
+func (server *Server) openRestoredArchive(responseWriter http.ResponseWriter, request *http.Request) (io.ReadCloser, string, bool) {
+	if strings.HasPrefix(request.Header.Get("Content-Type"), "multipart/form-data") {
+		multipartReader, err := request.MultipartReader()
+		if err != nil {
+			server.writeError(responseWriter, http.StatusBadRequest, "VALIDATION_ERROR", "Invalid multipart body", nil)
+			return nil, "", false
+		}
+		for {
+			part, err := multipartReader.NextPart()
+			if err != nil {
+				server.writeError(responseWriter, http.StatusBadRequest, "VALIDATION_ERROR", "archive file is required", nil)
+				return nil, "", false
+			}
+			if part.FormName() == "archive" {
+				return part, part.FileName(), true
+			}
+			part.Close()
+		}
+	}
+
+	var restoreRequest struct {
+		Name string `json:"name"`
+	}
+	if err := json.NewDecoder(request.Body).Decode(&restoreRequest); err != nil {
+		server.writeError(responseWriter, http.StatusBadRequest, "VALIDATION_ERROR", "Invalid body", nil)
+		return nil, "", false
+	}
+	archivePath, err := storage.BackupPath(storage.NewLayout(server.configuration.Storage), restoreRequest.Name)
+	if err != nil {
+		server.writeError(responseWriter, http.StatusBadRequest, "VALIDATION_ERROR", err.Error(), nil)
+		return nil, "", false
+	}
+	archiveFile, err := os.Open(archivePath)
+	if err != nil {
+		server.writeError(responseWriter, http.StatusNotFound, "NOT_FOUND", "Backup not found", nil)
+		return nil, "", false
+	}
+	return archiveFile, restoreRequest.Name, true
+}
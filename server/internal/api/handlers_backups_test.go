@@ -0,0 +1,80 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"lectures/internal/database"
+	"lectures/internal/storage"
+)
+
+func TestHandleRestoreBackup(t *testing.T) {
+	server, userID, sessionID, cleanup := setupUniqueExtraTestEnv(t, "restore_backup")
+	defer cleanup()
+
+	sendRequest := func(body string) (int, string) {
+		req := httptest.NewRequest("POST", "/api/admin/restore", strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer "+sessionID)
+		req.Header.Set("X-Requested-With", "XMLHttpRequest")
+		req.Header.Set("Content-Type", "application/json")
+		rr := httptest.NewRecorder()
+		server.Handler().ServeHTTP(rr, req)
+		return rr.Code, rr.Body.String()
+	}
+
+	if code, _ := sendRequest(`{"name":"backup-20260101-000000.tar.gz"}`); code != http.StatusForbidden {
+		t.Errorf("Expected users to be refused restores, got %d", code)
+	}
+	server.database.Exec("UPDATE users SET role = 'admin' WHERE id = ?", userID)
+	server.database.Exec("INSERT INTO exams (id, user_id, title) VALUES ('restored-exam', ?, 'Optics')", userID)
+
+	layout := storage.NewLayout(server.configuration.Storage)
+	os.MkdirAll(layout.BackupsDirectory(), 0755)
+	snapshotPath := filepath.Join(t.TempDir(), "snapshot.db")
+	if err := database.Snapshot(server.database, snapshotPath); err != nil {
+		t.Fatalf("Snapshot failed: %v", err)
+	}
+	archiveName := storage.BackupArchiveName(time.Now())
+	if _, err := storage.CreateBackupArchive(filepath.Join(layout.BackupsDirectory(), archiveName), layout, snapshotPath, storage.BackupManifest{CreatedAt: time.Now()}, nil); err != nil {
+		t.Fatalf("CreateBackupArchive failed: %v", err)
+	}
+	os.WriteFile(filepath.Join(layout.BackupsDirectory(), "backup-20260101-000000.tar.gz"), []byte("not an archive"), 0644)
+
+	server.database.Exec("UPDATE exams SET title = 'Acoustics' WHERE id = 'restored-exam'")
+	server.database.Exec("INSERT INTO exams (id, user_id, title) VALUES ('later-exam', ?, 'Mechanics')", userID)
+
+	if code, _ := sendRequest(`{"name":"../test.db"}`); code != http.StatusBadRequest {
+		t.Errorf("Expected a name outside the backups directory to be refused, got %d", code)
+	}
+	if code, body := sendRequest(`{"name":"backup-20260101-000000.tar.gz"}`); code != http.StatusBadRequest || !strings.Contains(body, "INVALID_BACKUP") {
+		t.Errorf("Expected a corrupted archive to be refused, got %d: %s", code, body)
+	}
+
+	server.database.Exec("INSERT INTO jobs (id, user_id, type, status, payload) VALUES ('running-job', ?, 'BUILD_MATERIAL', 'RUNNING', '{}')", userID)
+	if code, _ := sendRequest(`{"name":"` + archiveName + `"}`); code != http.StatusConflict {
+		t.Errorf("Expected the restore to wait for running jobs, got %d", code)
+	}
+	server.database.Exec("DELETE FROM jobs WHERE id = 'running-job'")
+
+	if code, body := sendRequest(`{"name":"` + archiveName + `"}`); code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", code, body)
+	}
+	var title string
+	server.database.QueryRow("SELECT title FROM exams WHERE id = 'restored-exam'").Scan(&title)
+	if title != "Optics" {
+		t.Errorf("Expected the exam title of the backup, got %q", title)
+	}
+	var laterExams int
+	server.database.QueryRow("SELECT COUNT(*) FROM exams WHERE id = 'later-exam'").Scan(&laterExams)
+	if laterExams != 0 {
+		t.Error("Expected the exam created after the backup to be gone")
+	}
+	if server.jobQueue.IsPaused() {
+		t.Error("Expected job intake to resume after the restore")
+	}
+}
@@ -0,0 +1,234 @@
+package api
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	gonanoid "github.com/matoous/go-nanoid/v2"
+
+	"lectures/internal/database"
+	"lectures/internal/models"
+)
+
+// maximumBookmarkNameLength bounds the name of a lecture bookmark
+const maximumBookmarkNameLength = 200
+
+// Sources a tool can be generated from: the whole lecture, or the moments of it the caller bookmarked
+const (
+	toolSourceLecture   = "lecture"
+	toolSourceBookmarks = "bookmarks"
+)
+
+// ownBookmarkDecks keeps the decks built from the bookmarks of other members out of a query on tools; its argument
+// is the ID of the user asking, as bookmarks are personal
+const ownBookmarkDecks = "COALESCE(tools.bookmark_user_id, '') IN ('', ?)"
+
+// handleListBookmarks lists the bookmarks the caller placed on a lecture, in the order of the lecture
+func (server *Server) handleListBookmarks(responseWriter http.ResponseWriter, request *http.Request) {
+	lectureID := request.URL.Query().Get("lecture_id")
+	if lectureID == "" {
+		server.writeError(responseWriter, http.StatusBadRequest, "VALIDATION_ERROR", "lecture_id is required", nil)
+		return
+	}
+	if _, authorized := server.authorizeLecture(responseWriter, request, lectureID, models.ExamRoleViewer); !authorized {
+		return
+	}
+
+	bookmarks, err := database.ListLectureBookmarks(server.database, lectureID, server.getUserID(request))
+	if err != nil {
+		server.writeError(responseWriter, http.StatusInternalServerError, "DATABASE_ERROR", "Failed to list bookmarks", nil)
+		return
+	}
+	server.writeJSON(responseWriter, http.StatusOK, bookmarks)
+}
+
+// handleCreateBookmark places a named bookmark at a millisecond of a lecture. Bookmarks are personal: anyone who
+// can view the lecture can place their own, and only they see them
+func (server *Server) handleCreateBookmark(responseWriter http.ResponseWriter, request *http.Request) {
+	var createRequest struct {
+		LectureID   string `json:"lecture_id"`
+		Name        string `json:"name"`
+		Millisecond *int64 `json:"millisecond"`
+	}
+	if err := json.NewDecoder(request.Body).Decode(&createRequest); err != nil {
+		server.writeError(responseWriter, http.StatusBadRequest, "VALIDATION_ERROR", "Invalid request body", nil)
+		return
+	}
+	if createRequest.LectureID == "" || createRequest.Millisecond == nil {
+		server.writeError(responseWriter, http.StatusBadRequest, "VALIDATION_ERROR", "lecture_id and millisecond are required", nil)
+		return
+	}
+	name, ok := server.validateBookmark(responseWriter, createRequest.Name, *createRequest.Millisecond)
+	if !ok {
+		return
+	}
+	if _, authorized := server.authorizeLecture(responseWriter, request, createRequest.LectureID, models.ExamRoleViewer); !authorized {
+		return
+	}
+
+	bookmarkID, _ := gonanoid.New()
+	now := time.Now()
+	bookmark := models.LectureBookmark{
+		ID:          bookmarkID,
+		LectureID:   createRequest.LectureID,
+		UserID:      server.getUserID(request),
+		Name:        name,
+		Millisecond: *createRequest.Millisecond,
+		CreatedAt:   now,
+		UpdatedAt:   now,
+	}
+	if _, err := server.database.Exec(`
+		INSERT INTO lecture_bookmarks (id, lecture_id, user_id, name, millisecond, created_at, updated_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?)
+	`, bookmark.ID, bookmark.LectureID, bookmark.UserID, bookmark.Name, bookmark.Millisecond, now, now); err != nil {
+		server.writeError(responseWriter, http.StatusInternalServerError, "DATABASE_ERROR", "Failed to create bookmark", nil)
+		return
+	}
+	server.writeJSON(responseWriter, http.StatusCreated, bookmark)
+}
+
+// handleUpdateBookmark renames or moves one of the caller's bookmarks; fields left out are kept
+func (server *Server) handleUpdateBookmark(responseWriter http.ResponseWriter, request *http.Request) {
+	var updateRequest struct {
+		BookmarkID  string  `json:"bookmark_id"`
+		Name        *string `json:"name"`
+		Millisecond *int64  `json:"millisecond"`
+	}
+	if err := json.NewDecoder(request.Body).Decode(&updateRequest); err != nil {
+		server.writeError(responseWriter, http.StatusBadRequest, "VALIDATION_ERROR", "Invalid request body", nil)
+		return
+	}
+	if updateRequest.BookmarkID == "" {
+		server.writeError(responseWriter, http.StatusBadRequest, "VALIDATION_ERROR", "bookmark_id is required", nil)
+		return
+	}
+
+	bookmark, found := server.getOwnBookmark(responseWriter, request, updateRequest.BookmarkID)
+	if !found {
+		return
+	}
+	if updateRequest.Name != nil {
+		bookmark.Name = *updateRequest.Name
+	}
+	if updateRequest.Millisecond != nil {
+		bookmark.Millisecond = *updateRequest.Millisecond
+	}
+	name, ok := server.validateBookmark(responseWriter, bookmark.Name, bookmark.Millisecond)
+	if !ok {
+		return
+	}
+	bookmark.Name = name
+	bookmark.UpdatedAt = time.Now()
+
+	if _, err := server.database.Exec("UPDATE lecture_bookmarks SET name = ?, millisecond = ?, updated_at = ? WHERE id = ?",
+		bookmark.Name, bookmark.Millisecond, bookmark.UpdatedAt, bookmark.ID); err != nil {
+		server.writeError(responseWriter, http.StatusInternalServerError, "DATABASE_ERROR", "Failed to update bookmark", nil)
+		return
+	}
+	server.writeJSON(responseWriter, http.StatusOK, bookmark)
+}
+
+// handleDeleteBookmark removes one of the caller's bookmarks
+func (server *Server) handleDeleteBookmark(responseWriter http.ResponseWriter, request *http.Request) {
+	var deleteRequest struct {
+		BookmarkID string `json:"bookmark_id"`
+	}
+	if err := json.NewDecoder(request.Body).Decode(&deleteRequest); err != nil {
+		server.writeError(responseWriter, http.StatusBadRequest, "VALIDATION_ERROR", "Invalid request body", nil)
+		return
+	}
+	if deleteRequest.BookmarkID == "" {
+		server.writeError(responseWriter, http.StatusBadRequest, "VALIDATION_ERROR", "bookmark_id is required", nil)
+		return
+	}
+
+	bookmark, found := server.getOwnBookmark(responseWriter, request, deleteRequest.BookmarkID)
+	if !found {
+		return
+	}
+	if _, err := server.database.Exec("DELETE FROM lecture_bookmarks WHERE id = ?", bookmark.ID); err != nil {
+		server.writeError(responseWriter, http.StatusInternalServerError, "DATABASE_ERROR", "Failed to delete bookmark", nil)
+		return
+	}
+	server.writeJSON(responseWriter, http.StatusOK, map[string]string{"message": "Bookmark deleted"})
+}
+
+// getOwnBookmark loads a bookmark of the caller on a lecture they can still view, answering 404 otherwise
+func (server *Server) getOwnBookmark(responseWriter http.ResponseWriter, request *http.Request, bookmarkID string) (models.LectureBookmark, bool) {
+	var bookmark models.LectureBookmark
+	err := server.database.QueryRow(`
+		SELECT lecture_bookmarks.id, lecture_bookmarks.lecture_id, lecture_bookmarks.user_id, lecture_bookmarks.name,
+			lecture_bookmarks.millisecond, lecture_bookmarks.created_at, lecture_bookmarks.updated_at
+		FROM lecture_bookmarks
+		JOIN lectures ON lecture_bookmarks.lecture_id = lectures.id
+		JOIN exams ON lectures.exam_id = exams.id
+		WHERE lecture_bookmarks.id = ? AND lecture_bookmarks.user_id = ? AND `+examAccess(models.ExamRoleViewer)+`
+	`, bookmarkID, server.getUserID(request), server.getUserID(request)).Scan(
+		&bookmark.ID, &bookmark.LectureID, &bookmark.UserID, &bookmark.Name, &bookmark.Millisecond, &bookmark.CreatedAt, &bookmark.UpdatedAt,
+	)
+	if err == sql.ErrNoRows {
+		server.writeError(responseWriter, http.StatusNotFound, "NOT_FOUND", "Bookmark not found", nil)
+		return bookmark, false
+	}
+	if err != nil {
+		server.writeError(responseWriter, http.StatusInternalServerError, "DATABASE_ERROR", "Failed to get bookmark", nil)
+		return bookmark, false
+	}
+	return bookmark, true
+}
+
+// validateBookmark checks the name and position of a bookmark and returns the trimmed name
+func (server *Server) validateBookmark(responseWriter http.ResponseWriter, name string, millisecond int64) (string, bool) {
+	name = strings.TrimSpace(name)
+	if name == "" || utf8.RuneCountInString(name) > maximumBookmarkNameLength {
+		server.writeError(responseWriter, http.StatusBadRequest, "VALIDATION_ERROR", fmt.Sprintf("name is required and must be at most %d characters", maximumBookmarkNameLength), nil)
+		return "", false
+	}
+	if millisecond < 0 {
+		server.writeError(responseWriter, http.StatusBadRequest, "VALIDATION_ERROR", "millisecond must not be negative", nil)
+		return "", false
+	}
+	return name, true
+}
+
+// validateBookmarkSource checks that a tool can be generated from the caller's bookmarks of a lecture: only
+// flashcards can, from a completed transcript and at least one bookmark
+func (server *Server) validateBookmarkSource(responseWriter http.ResponseWriter, toolType string, lectureID string, userID string) bool {
+	if toolType != "flashcard" {
+		server.writeError(responseWriter, http.StatusBadRequest, "VALIDATION_ERROR", "Only flashcards can be generated from bookmarks", nil)
+		return false
+	}
+
+	var transcriptStatus string
+	server.database.QueryRow("SELECT status FROM transcripts WHERE lecture_id = ?", lectureID).Scan(&transcriptStatus)
+	if transcriptStatus != "completed" {
+		server.writeError(responseWriter, http.StatusConflict, "TRANSCRIPT_NOT_READY", "The transcript must be completed to generate from bookmarks", nil)
+		return false
+	}
+
+	var bookmarkCount int
+	server.database.QueryRow("SELECT COUNT(*) FROM lecture_bookmarks WHERE lecture_id = ? AND user_id = ?", lectureID, userID).Scan(&bookmarkCount)
+	if bookmarkCount == 0 {
+		server.writeError(responseWriter, http.StatusBadRequest, "VALIDATION_ERROR", "You have no bookmarks on this lecture", nil)
+		return false
+	}
+	return true
+}
+
+// anchorBookmarks sets on each bookmark the transcript segment spoken at its millisecond: the last segment
+// starting at or before it. Segments must be in the order of the lecture
+func anchorBookmarks(bookmarks []models.LectureBookmark, segmentIDs []int, segmentStarts []int64) {
+	for index := range bookmarks {
+		for segmentIndex, start := range segmentStarts {
+			if start > bookmarks[index].Millisecond {
+				break
+			}
+			bookmarks[index].SegmentID = segmentIDs[segmentIndex]
+		}
+	}
+}
@@ -0,0 +1,161 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"lectures/internal/models"
+)
+
+func TestHandleLectureBookmarks(t *testing.T) {
+	server, userID, sessionID, cleanup := setupUniqueExtraTestEnv(t, "lecture_bookmarks")
+	defer cleanup()
+
+	server.database.Exec("INSERT INTO exams (id, user_id, title) VALUES ('bookmark-exam', ?, 'Optics')", userID)
+	server.database.Exec("INSERT INTO lectures (id, exam_id, title, status) VALUES ('bookmark-lecture', 'bookmark-exam', 'Lenses', 'ready')")
+	server.database.Exec("INSERT INTO transcripts (id, lecture_id, status) VALUES ('bookmark-transcript', 'bookmark-lecture', 'completed')")
+	server.database.Exec("INSERT INTO transcript_segments (id, transcript_id, text, start_millisecond, end_millisecond) VALUES (1, 'bookmark-transcript', 'Light bends.', 0, 30000)")
+	server.database.Exec("INSERT INTO transcript_segments (id, transcript_id, text, start_millisecond, end_millisecond) VALUES (2, 'bookmark-transcript', 'Snell law.', 30000, 60000)")
+	server.database.Exec("INSERT INTO users (id, username, password_hash) VALUES ('bookmark-stranger', 'stranger', 'hash')")
+	server.database.Exec("INSERT INTO lecture_bookmarks (id, lecture_id, user_id, name, millisecond) VALUES ('foreign', 'bookmark-lecture', 'bookmark-stranger', 'Not mine', 1000)")
+
+	sendRequest := func(method string, target string, body string) (int, []byte) {
+		req := httptest.NewRequest(method, target, strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer "+sessionID)
+		req.Header.Set("X-Requested-With", "XMLHttpRequest")
+		req.Header.Set("Content-Type", "application/json")
+		rr := httptest.NewRecorder()
+		server.Handler().ServeHTTP(rr, req)
+		return rr.Code, rr.Body.Bytes()
+	}
+
+	if code, _ := sendRequest("POST", "/api/lectures/bookmarks", `{"lecture_id":"bookmark-lecture","name":"  ","millisecond":100}`); code != http.StatusBadRequest {
+		t.Errorf("Expected a bookmark without a name to be refused, got %d", code)
+	}
+	if code, _ := sendRequest("POST", "/api/lectures/bookmarks", `{"lecture_id":"bookmark-lecture","name":"Snell"}`); code != http.StatusBadRequest {
+		t.Errorf("Expected a bookmark without a millisecond to be refused, got %d", code)
+	}
+
+	// Only flashcards, from a user with bookmarks, can be generated from bookmarks
+	toolRequest := `{"exam_id":"bookmark-exam","lecture_id":"bookmark-lecture","type":"flashcard","language_code":"en","source":"bookmarks"}`
+	if code, body := sendRequest("POST", "/api/tools", toolRequest); code != http.StatusBadRequest || !strings.Contains(string(body), "no bookmarks") {
+		t.Errorf("Expected generating from no bookmarks to be refused, got %d: %s", code, body)
+	}
+
+	code, body := sendRequest("POST", "/api/lectures/bookmarks", `{"lecture_id":"bookmark-lecture","name":" Snell's law ","millisecond":45000}`)
+	if code != http.StatusCreated {
+		t.Fatalf("Expected status 201, got %d: %s", code, body)
+	}
+	var created struct {
+		Data models.LectureBookmark `json:"data"`
+	}
+	json.Unmarshal(body, &created)
+	if created.Data.Name != "Snell's law" || created.Data.UserID != userID {
+		t.Errorf("Expected the trimmed bookmark of the caller, got %+v", created.Data)
+	}
+
+	_, body = sendRequest("GET", "/api/transcripts?lecture_id=bookmark-lecture", "")
+	var transcript struct {
+		Data struct {
+			Bookmarks []models.LectureBookmark `json:"bookmarks"`
+		} `json:"data"`
+	}
+	json.Unmarshal(body, &transcript)
+	if len(transcript.Data.Bookmarks) != 1 || transcript.Data.Bookmarks[0].SegmentID != 2 {
+		t.Errorf("Expected only the caller's bookmark, anchored to the segment spoken at it, got %+v", transcript.Data.Bookmarks)
+	}
+
+	if code, _ := sendRequest("PATCH", "/api/lectures/bookmarks", `{"bookmark_id":"foreign","name":"Mine now"}`); code != http.StatusNotFound {
+		t.Errorf("Expected the bookmarks of other users to be hidden, got %d", code)
+	}
+	code, body = sendRequest("PATCH", "/api/lectures/bookmarks", `{"bookmark_id":"`+created.Data.ID+`","millisecond":5000}`)
+	var updated struct {
+		Data models.LectureBookmark `json:"data"`
+	}
+	json.Unmarshal(body, &updated)
+	if code != http.StatusOK || updated.Data.Millisecond != 5000 || updated.Data.Name != "Snell's law" {
+		t.Errorf("Expected the bookmark moved and its name kept, got %d: %+v", code, updated.Data)
+	}
+
+	if code, body := sendRequest("POST", "/api/tools", `{"exam_id":"bookmark-exam","lecture_id":"bookmark-lecture","type":"quiz","language_code":"en","source":"bookmarks"}`); code != http.StatusBadRequest || !strings.Contains(string(body), "Only flashcards") {
+		t.Errorf("Expected quizzes from bookmarks to be refused, got %d: %s", code, body)
+	}
+	server.database.Exec("INSERT INTO tools (id, exam_id, lecture_id, type, title, content) VALUES ('lecture-deck', 'bookmark-exam', 'bookmark-lecture', 'flashcard', 'Lenses', '[]')")
+	server.database.Exec("INSERT INTO tools (id, exam_id, lecture_id, type, title, content, bookmark_user_id) VALUES ('stranger-deck', 'bookmark-exam', 'bookmark-lecture', 'flashcard', 'Not mine', '[]', 'bookmark-stranger')")
+	server.database.Exec("INSERT INTO tools (id, exam_id, lecture_id, type, title, content, bookmark_user_id) VALUES ('bookmark-deck', 'bookmark-exam', 'bookmark-lecture', 'flashcard', 'Snell', '[]', ?)", userID)
+	if code, body := sendRequest("POST", "/api/tools", toolRequest); code != http.StatusAccepted {
+		t.Errorf("Expected flashcards from bookmarks to be queued, got %d: %s", code, body)
+	}
+	var remainingDecks []string
+	rows, _ := server.database.Query("SELECT id FROM tools WHERE lecture_id = 'bookmark-lecture' ORDER BY id")
+	for rows.Next() {
+		var toolID string
+		rows.Scan(&toolID)
+		remainingDecks = append(remainingDecks, toolID)
+	}
+	rows.Close()
+	if strings.Join(remainingDecks, ",") != "lecture-deck,stranger-deck" {
+		t.Errorf("Expected only the caller's previous bookmark deck to be replaced, got %v", remainingDecks)
+	}
+	var payload string
+	server.database.QueryRow("SELECT payload FROM jobs WHERE type = ? AND user_id = ?", models.JobTypeBuildMaterial, userID).Scan(&payload)
+	if !strings.Contains(payload, `"source":"bookmarks"`) {
+		t.Errorf("Expected the build to generate from bookmarks, got %s", payload)
+	}
+
+	if code, _ := sendRequest("DELETE", "/api/lectures/bookmarks", `{"bookmark_id":"`+created.Data.ID+`"}`); code != http.StatusOK {
+		t.Errorf("Expected the bookmark to be deleted, got %d", code)
+	}
+	_, body = sendRequest("GET", "/api/lectures/bookmarks?lecture_id=bookmark-lecture", "")
+	if !strings.Contains(string(body), `"data": []`) {
+		t.Errorf("Expected no bookmarks left, got %s", body)
+	}
+}
+
+func TestHandleBookmarkDecksArePersonal(t *testing.T) {
+	server, userID, sessionID, cleanup := setupUniqueExtraTestEnv(t, "bookmark_decks")
+	defer cleanup()
+
+	server.database.Exec("INSERT INTO exams (id, user_id, title) VALUES ('deck-exam', ?, 'Optics')", userID)
+	server.database.Exec("INSERT INTO lectures (id, exam_id, title, status) VALUES ('deck-lecture', 'deck-exam', 'Lenses', 'ready')")
+	server.database.Exec("INSERT INTO users (id, username, password_hash) VALUES ('deck-member', 'deck_member', 'hash')")
+	server.database.Exec("INSERT INTO exam_members (exam_id, user_id, role) VALUES ('deck-exam', 'deck-member', 'viewer')")
+	memberSessionID := "deck-member-session"
+	server.database.Exec("INSERT INTO auth_sessions (id, user_id, created_at, last_activity, expires_at) VALUES (?, ?, ?, ?, ?)", memberSessionID, "deck-member", time.Now(), time.Now(), time.Now().Add(time.Hour))
+	server.database.Exec("INSERT INTO tools (id, exam_id, lecture_id, type, title, language_code, content) VALUES ('shared-deck', 'deck-exam', 'deck-lecture', 'flashcard', 'Lenses', 'en', '[]')")
+	server.database.Exec("INSERT INTO tools (id, exam_id, lecture_id, type, title, language_code, content, bookmark_user_id) VALUES ('owner-deck', 'deck-exam', 'deck-lecture', 'flashcard', 'Snell', 'en', '[]', ?)", userID)
+	server.database.Exec("INSERT INTO tool_versions (id, tool_id, exam_id, lecture_id, type, title, content, reason, bookmark_user_id) VALUES ('owner-deck-version', 'owner-deck', 'deck-exam', 'deck-lecture', 'flashcard', 'Snell', '[]', 'updated', ?)", userID)
+
+	sendRequest := func(session string, target string) (int, string) {
+		req := httptest.NewRequest("GET", target, nil)
+		req.Header.Set("Authorization", "Bearer "+session)
+		rr := httptest.NewRecorder()
+		server.Handler().ServeHTTP(rr, req)
+		return rr.Code, rr.Body.String()
+	}
+
+	// Another member of the exam sees the lecture's deck but not the decks built from someone's bookmarks
+	if _, body := sendRequest(memberSessionID, "/api/tools?exam_id=deck-exam"); !strings.Contains(body, "shared-deck") || strings.Contains(body, "owner-deck") {
+		t.Errorf("Expected only the shared deck to be listed for the member, got %s", body)
+	}
+	if code, _ := sendRequest(memberSessionID, "/api/tools/details?exam_id=deck-exam&tool_id=owner-deck"); code != http.StatusNotFound {
+		t.Errorf("Expected the bookmark deck of another user to be hidden, got %d", code)
+	}
+	if _, body := sendRequest(memberSessionID, "/api/tools/versions?exam_id=deck-exam"); strings.Contains(body, "owner-deck-version") {
+		t.Errorf("Expected the versions of the bookmark deck to be hidden, got %s", body)
+	}
+	if code, _ := sendRequest(memberSessionID, "/api/tools/versions/diff?exam_id=deck-exam&version_id=owner-deck-version"); code != http.StatusNotFound {
+		t.Errorf("Expected the version of another user's bookmark deck not to be compared, got %d", code)
+	}
+
+	if _, body := sendRequest(sessionID, "/api/tools?exam_id=deck-exam"); !strings.Contains(body, "owner-deck") {
+		t.Errorf("Expected the owner of the bookmarks to see their deck, got %s", body)
+	}
+	if _, body := sendRequest(sessionID, "/api/tools/versions?exam_id=deck-exam"); !strings.Contains(body, "owner-deck-version") {
+		t.Errorf("Expected the owner of the bookmarks to see the versions of their deck, got %s", body)
+	}
+}
@@ -0,0 +1,132 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+
+	"lectures/internal/jobs"
+	"lectures/internal/models"
+)
+
+// writeEnqueueError answers a request whose job could not be queued, telling the user when their cost budget
+// or the handler set of the server is what refused it
+func (server *Server) writeEnqueueError(responseWriter http.ResponseWriter, err error, message string) {
+	var budgetError *jobs.BudgetExceededError
+	if errors.As(err, &budgetError) {
+		message := "Your " + budgetError.Period + " spending limit was reached"
+		if budgetError.Expected > 0 {
+			message = "This job would exceed your " + budgetError.Period + " spending limit"
+		}
+		server.writeError(responseWriter, http.StatusPaymentRequired, "BUDGET_EXCEEDED", message, map[string]any{
+			"period":   budgetError.Period,
+			"limit":    budgetError.Limit,
+			"spent":    budgetError.Spent,
+			"expected": budgetError.Expected,
+		})
+		return
+	}
+	if errors.Is(err, jobs.ErrJobTypeDisabled) {
+		server.writeError(responseWriter, http.StatusNotImplemented, "JOB_TYPE_DISABLED", "This kind of job is disabled on this server", nil)
+		return
+	}
+	server.writeError(responseWriter, http.StatusInternalServerError, "BACKGROUND_JOB_ERROR", message, nil)
+}
+
+// billedUserID returns the user the spend of userID in examID is charged to: the exam owner for shared exams, or
+// the caller when the server runs without a job queue
+func (server *Server) billedUserID(userID string, examID string) string {
+	if server.jobQueue == nil {
+		return userID
+	}
+	return server.jobQueue.BilledUserID(userID, examID)
+}
+
+// checkCostBudget reports whether billedUserID has budget left; without a job queue there is no budget to check
+func (server *Server) checkCostBudget(billedUserID string) error {
+	if server.jobQueue == nil {
+		return nil
+	}
+	return server.jobQueue.CheckCostBudget(billedUserID)
+}
+
+// recordRequestCost adds the spend of a request made outside a job to the cost ledger of billedUserID, when the
+// server has a job queue keeping one
+func (server *Server) recordRequestCost(billedUserID string, referenceID string, costType string, amount float64) error {
+	if server.jobQueue == nil {
+		return nil
+	}
+	return server.jobQueue.RecordRequestCost(billedUserID, referenceID, costType, amount)
+}
+
+// polishTitleDescription cleans a title and description with the model and records what it spent in the cost ledger
+// of the billed user, under the ID of the exam or lecture they describe. They are kept as written once that user's
+// budget is spent
+func (server *Server) polishTitleDescription(requestContext context.Context, billedUserID string, referenceID string, title string, description string) (string, string, models.JobMetrics, error) {
+	if errors.Is(server.checkCostBudget(billedUserID), jobs.ErrBudgetExceeded) {
+		return title, description, models.JobMetrics{}, nil
+	}
+	cleanedTitle, cleanedDescription, metrics, err := server.toolGenerator.CorrectProjectTitleDescription(requestContext, title, description, "")
+	if ledgerError := server.recordRequestCost(billedUserID, referenceID, jobs.CostTypeTitlePolish, metrics.EstimatedCost); ledgerError != nil {
+		slog.Warn("Failed to record title polish cost", "referenceID", referenceID, "error", ledgerError)
+	}
+	return cleanedTitle, cleanedDescription, metrics, err
+}
+
+// handleGetCostBudget reports the cost budget of the authenticated user and what they spent of it
+func (server *Server) handleGetCostBudget(responseWriter http.ResponseWriter, request *http.Request) {
+	status, err := server.jobQueue.UserCostBudget(server.getUserID(request))
+	if err != nil {
+		server.writeError(responseWriter, http.StatusInternalServerError, "DATABASE_ERROR", "Failed to read cost budget", nil)
+		return
+	}
+	server.writeJSON(responseWriter, http.StatusOK, status)
+}
+
+// handleSetUserCostBudget overrides the default cost budget of a user. A limit left out or null restores the
+// default from the configuration, and 0 makes it unlimited
+func (server *Server) handleSetUserCostBudget(responseWriter http.ResponseWriter, request *http.Request) {
+	if !server.requireAdmin(responseWriter, request) {
+		return
+	}
+
+	var budgetRequest struct {
+		UserID        string   `json:"user_id"`
+		DailyBudget   *float64 `json:"daily_budget"`
+		MonthlyBudget *float64 `json:"monthly_budget"`
+	}
+	if err := json.NewDecoder(request.Body).Decode(&budgetRequest); err != nil {
+		server.writeError(responseWriter, http.StatusBadRequest, "VALIDATION_ERROR", "Invalid body", nil)
+		return
+	}
+	if budgetRequest.UserID == "" {
+		server.writeError(responseWriter, http.StatusBadRequest, "VALIDATION_ERROR", "user_id is required", nil)
+		return
+	}
+	if (budgetRequest.DailyBudget != nil && *budgetRequest.DailyBudget < 0) || (budgetRequest.MonthlyBudget != nil && *budgetRequest.MonthlyBudget < 0) {
+		server.writeError(responseWriter, http.StatusBadRequest, "VALIDATION_ERROR", "Budgets cannot be negative", nil)
+		return
+	}
+
+	result, err := server.database.Exec(
+		"UPDATE users SET daily_budget = ?, monthly_budget = ?, updated_at = CURRENT_TIMESTAMP WHERE id = ?",
+		budgetRequest.DailyBudget, budgetRequest.MonthlyBudget, budgetRequest.UserID,
+	)
+	if err != nil {
+		server.writeError(responseWriter, http.StatusInternalServerError, "DATABASE_ERROR", "Failed to update cost budget", nil)
+		return
+	}
+	if affectedRows, _ := result.RowsAffected(); affectedRows == 0 {
+		server.writeError(responseWriter, http.StatusNotFound, "NOT_FOUND", "User not found", nil)
+		return
+	}
+
+	status, err := server.jobQueue.UserCostBudget(budgetRequest.UserID)
+	if err != nil {
+		server.writeError(responseWriter, http.StatusInternalServerError, "DATABASE_ERROR", "Failed to read cost budget", nil)
+		return
+	}
+	server.writeJSON(responseWriter, http.StatusOK, status)
+}
@@ -0,0 +1,86 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"lectures/internal/jobs"
+)
+
+func TestHandleCostBudget(t *testing.T) {
+	server, userID, sessionID, cleanup := setupUniqueExtraTestEnv(t, "budget")
+	defer cleanup()
+
+	server.database.Exec("INSERT INTO exams (id, user_id, title) VALUES ('budget-exam', ?, 'Optics')", userID)
+	server.database.Exec("INSERT INTO cost_ledger (user_id, job_id, job_type, amount, recorded_at) VALUES (?, 'spent-job', 'BUILD_MATERIAL', 2.5, ?)", userID, time.Now())
+	server.jobQueue.SetCostBudget(jobs.CostBudget{Daily: 2.0})
+
+	sendRequest := func(method string, path string, body map[string]any) *httptest.ResponseRecorder {
+		encodedBody, _ := json.Marshal(body)
+		req := httptest.NewRequest(method, path, bytes.NewBuffer(encodedBody))
+		req.Header.Set("Authorization", "Bearer "+sessionID)
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("X-Requested-With", "XMLHttpRequest")
+		rr := httptest.NewRecorder()
+		server.Handler().ServeHTTP(rr, req)
+		return rr
+	}
+
+	rr := sendRequest("POST", "/api/exams/suggest", map[string]any{"exam_id": "budget-exam"})
+	if rr.Code != http.StatusPaymentRequired || !strings.Contains(rr.Body.String(), "BUDGET_EXCEEDED") {
+		t.Fatalf("Expected status 402 over budget, got %d. Body: %s", rr.Code, rr.Body.String())
+	}
+
+	rr = sendRequest("GET", "/api/budget", nil)
+	var budgetResponse struct {
+		Data jobs.CostBudgetStatus `json:"data"`
+	}
+	json.Unmarshal(rr.Body.Bytes(), &budgetResponse)
+	if rr.Code != http.StatusOK || budgetResponse.Data.DailySpent != 2.5 || budgetResponse.Data.Budget.Daily != 2.0 {
+		t.Errorf("Expected $2.50 spent of $2.00, got %d: %s", rr.Code, rr.Body.String())
+	}
+
+	if rr := sendRequest("PUT", "/api/admin/users/budget", map[string]any{"user_id": userID, "daily_budget": 5}); rr.Code != http.StatusForbidden {
+		t.Errorf("Expected users to be refused setting budgets, got %d", rr.Code)
+	}
+	server.database.Exec("UPDATE users SET role = 'admin' WHERE id = ?", userID)
+	if rr := sendRequest("PUT", "/api/admin/users/budget", map[string]any{"user_id": userID, "daily_budget": -1}); rr.Code != http.StatusBadRequest {
+		t.Errorf("Expected status 400 for a negative budget, got %d", rr.Code)
+	}
+	if rr := sendRequest("PUT", "/api/admin/users/budget", map[string]any{"user_id": userID, "daily_budget": 5}); rr.Code != http.StatusOK {
+		t.Fatalf("Expected status 200 raising the budget, got %d. Body: %s", rr.Code, rr.Body.String())
+	}
+	if rr := sendRequest("POST", "/api/exams/suggest", map[string]any{"exam_id": "budget-exam"}); rr.Code != http.StatusAccepted {
+		t.Errorf("Expected status 202 within the raised budget, got %d. Body: %s", rr.Code, rr.Body.String())
+	}
+}
+
+func TestHandleCreateExam_WithoutJobQueue(t *testing.T) {
+	server, userID, sessionID, cleanup := setupUniqueExtraTestEnv(t, "budget_noqueue")
+	defer cleanup()
+
+	// Title polishing is charged through the queue's ledger, which a server may run without
+	server = NewServer(server.configuration, server.database, nil, server.llmProvider, nil, server.toolGenerator, &MockMarkdownConverter{})
+
+	body, _ := json.Marshal(map[string]string{"title": "Optics", "description": "Lenses and mirrors"})
+	req := httptest.NewRequest("POST", "/api/exams", bytes.NewBuffer(body))
+	req.Header.Set("Authorization", "Bearer "+sessionID)
+	req.Header.Set("X-Requested-With", "XMLHttpRequest")
+	req.Header.Set("Content-Type", "application/json")
+	rr := httptest.NewRecorder()
+	server.Handler().ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("Expected status 201, got %d. Body: %s", rr.Code, rr.Body.String())
+	}
+	var examCount int
+	server.database.QueryRow("SELECT COUNT(*) FROM exams WHERE user_id = ?", userID).Scan(&examCount)
+	if examCount != 1 {
+		t.Errorf("Expected the exam to be created, found %d", examCount)
+	}
+}
@@ -10,6 +10,8 @@ import (
 	"strings"
 	"time"
 
+	"lectures/internal/documents"
+	"lectures/internal/embeddings"
 	"lectures/internal/llm"
 	"lectures/internal/markdown"
 	"lectures/internal/models"
@@ -21,8 +23,9 @@ import (
 // handleCreateChatSession creates a new chat session for an exam
 func (server *Server) handleCreateChatSession(responseWriter http.ResponseWriter, request *http.Request) {
 	var createSessionRequest struct {
-		ExamID string `json:"exam_id"`
-		Title  string `json:"title"`
+		ExamID   string                    `json:"exam_id"`
+		Title    string                    `json:"title"`
+		Sampling models.SamplingParameters `json:"sampling"`
 	}
 
 	if decodeError := json.NewDecoder(request.Body).Decode(&createSessionRequest); decodeError != nil {
@@ -34,14 +37,14 @@ func (server *Server) handleCreateChatSession(responseWriter http.ResponseWriter
 		server.writeError(responseWriter, http.StatusBadRequest, "VALIDATION_ERROR", "exam_id is required", nil)
 		return
 	}
+	if err := createSessionRequest.Sampling.Validate(); err != nil {
+		server.writeError(responseWriter, http.StatusBadRequest, "VALIDATION_ERROR", err.Error(), nil)
+		return
+	}
 
 	userID := server.getUserID(request)
 
-	// Verify exam exists and belongs to user
-	var examExists bool
-	databaseError := server.database.QueryRow("SELECT EXISTS(SELECT 1 FROM exams WHERE id = ? AND user_id = ?)", createSessionRequest.ExamID, userID).Scan(&examExists)
-	if databaseError != nil || !examExists {
-		server.writeError(responseWriter, http.StatusNotFound, "NOT_FOUND", "Exam not found", nil)
+	if !server.authorizeExam(responseWriter, request, createSessionRequest.ExamID, models.ExamRoleGenerator) {
 		return
 	}
 
@@ -49,7 +52,9 @@ func (server *Server) handleCreateChatSession(responseWriter http.ResponseWriter
 	session := models.ChatSession{
 		ID:        sessionID,
 		ExamID:    createSessionRequest.ExamID,
+		UserID:    userID,
 		Title:     createSessionRequest.Title,
+		Sampling:  createSessionRequest.Sampling,
 		CreatedAt: time.Now(),
 		UpdatedAt: time.Now(),
 	}
@@ -62,9 +67,9 @@ func (server *Server) handleCreateChatSession(responseWriter http.ResponseWriter
 	defer databaseTransaction.Rollback()
 
 	_, databaseError = databaseTransaction.Exec(`
-		INSERT INTO chat_sessions (id, exam_id, title, created_at, updated_at)
-		VALUES (?, ?, ?, ?, ?)
-	`, session.ID, session.ExamID, session.Title, session.CreatedAt, session.UpdatedAt)
+		INSERT INTO chat_sessions (id, exam_id, user_id, title, sampling, created_at, updated_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?)
+	`, session.ID, session.ExamID, userID, session.Title, encodeSampling(session.Sampling), session.CreatedAt, session.UpdatedAt)
 
 	if databaseError != nil {
 		server.writeError(responseWriter, http.StatusInternalServerError, "DATABASE_ERROR", "Failed to create chat session", nil)
@@ -90,7 +95,8 @@ func (server *Server) handleCreateChatSession(responseWriter http.ResponseWriter
 	server.writeJSON(responseWriter, http.StatusCreated, session)
 }
 
-// handleListChatSessions lists all chat sessions for an exam (must belong to the user)
+// handleListChatSessions lists the chat sessions the user started on an exam, and those of the other members
+// when its owner shares chat sessions
 func (server *Server) handleListChatSessions(responseWriter http.ResponseWriter, request *http.Request) {
 	examID := request.URL.Query().Get("exam_id")
 	if examID == "" {
@@ -99,14 +105,19 @@ func (server *Server) handleListChatSessions(responseWriter http.ResponseWriter,
 	}
 
 	userID := server.getUserID(request)
+	// Sessions are paged by creation, newest first: their last activity changes while a client pages through them
+	page, err := parseListPage(request, "chat_sessions.created_at", "chat_sessions.id", true)
+	if err != nil {
+		server.writeError(responseWriter, http.StatusBadRequest, "VALIDATION_ERROR", err.Error(), nil)
+		return
+	}
+	condition, conditionArgs := page.condition()
 
 	sessionRows, databaseError := server.database.Query(`
-		SELECT chat_sessions.id, chat_sessions.exam_id, chat_sessions.title, chat_sessions.estimated_cost, chat_sessions.created_at, chat_sessions.updated_at
+		SELECT chat_sessions.id, chat_sessions.exam_id, COALESCE(chat_sessions.user_id, exams.user_id), chat_sessions.title, chat_sessions.estimated_cost, chat_sessions.sampling, chat_sessions.created_at, chat_sessions.updated_at, `+page.sortValue()+`
 		FROM chat_sessions
 		JOIN exams ON chat_sessions.exam_id = exams.id
-		WHERE chat_sessions.exam_id = ? AND exams.user_id = ?
-		ORDER BY chat_sessions.updated_at DESC
-	`, examID, userID)
+		WHERE chat_sessions.exam_id = ? AND `+visibleChatSessionAccess(models.ExamRoleViewer)+condition+page.orderBy(), append([]any{examID, userID, userID}, conditionArgs...)...)
 	if databaseError != nil {
 		server.writeError(responseWriter, http.StatusInternalServerError, "DATABASE_ERROR", "Failed to list chat sessions", nil)
 		return
@@ -114,15 +125,21 @@ func (server *Server) handleListChatSessions(responseWriter http.ResponseWriter,
 	defer sessionRows.Close()
 
 	var sessions []models.ChatSession
+	var cursors []pageCursor
 	for sessionRows.Next() {
 		var session models.ChatSession
-		if scanError := sessionRows.Scan(&session.ID, &session.ExamID, &session.Title, &session.EstimatedCost, &session.CreatedAt, &session.UpdatedAt); scanError != nil {
+		var samplingJSON sql.NullString
+		var sortValue string
+		if scanError := sessionRows.Scan(&session.ID, &session.ExamID, &session.UserID, &session.Title, &session.EstimatedCost, &samplingJSON, &session.CreatedAt, &session.UpdatedAt, &sortValue); scanError != nil {
 			continue
 		}
+		session.Sampling = decodeSampling(samplingJSON)
 		sessions = append(sessions, session)
+		cursors = append(cursors, pageCursor{SortValue: sortValue, ID: session.ID})
 	}
 
-	server.writeJSON(responseWriter, http.StatusOK, sessions)
+	sessions, nextCursor := paginate(page, sessions, cursors)
+	server.writePage(responseWriter, http.StatusOK, sessions, nextCursor)
 }
 
 // handleGetChatSession retrieves a specific session and its messages
@@ -134,16 +151,23 @@ func (server *Server) handleGetChatSession(responseWriter http.ResponseWriter, r
 		server.writeError(responseWriter, http.StatusBadRequest, "VALIDATION_ERROR", "session_id and exam_id are required", nil)
 		return
 	}
+	// The page applies to the messages, oldest first
+	page, pageError := parseListPage(request, "created_at", "id", false)
+	if pageError != nil {
+		server.writeError(responseWriter, http.StatusBadRequest, "VALIDATION_ERROR", pageError.Error(), nil)
+		return
+	}
 
 	userID := server.getUserID(request)
 
 	var session models.ChatSession
+	var samplingJSON sql.NullString
 	databaseError := server.database.QueryRow(`
-		SELECT chat_sessions.id, chat_sessions.exam_id, chat_sessions.title, chat_sessions.estimated_cost, chat_sessions.created_at, chat_sessions.updated_at
+		SELECT chat_sessions.id, chat_sessions.exam_id, COALESCE(chat_sessions.user_id, exams.user_id), chat_sessions.title, chat_sessions.estimated_cost, chat_sessions.sampling, chat_sessions.created_at, chat_sessions.updated_at
 		FROM chat_sessions
 		JOIN exams ON chat_sessions.exam_id = exams.id
-		WHERE chat_sessions.id = ? AND chat_sessions.exam_id = ? AND exams.user_id = ?
-	`, sessionID, examID, userID).Scan(&session.ID, &session.ExamID, &session.Title, &session.EstimatedCost, &session.CreatedAt, &session.UpdatedAt)
+		WHERE chat_sessions.id = ? AND chat_sessions.exam_id = ? AND `+visibleChatSessionAccess(models.ExamRoleViewer)+`
+	`, sessionID, examID, userID, userID).Scan(&session.ID, &session.ExamID, &session.UserID, &session.Title, &session.EstimatedCost, &samplingJSON, &session.CreatedAt, &session.UpdatedAt)
 
 	if databaseError == sql.ErrNoRows {
 		server.writeError(responseWriter, http.StatusNotFound, "NOT_FOUND", "Chat session not found in this exam", nil)
@@ -153,6 +177,7 @@ func (server *Server) handleGetChatSession(responseWriter http.ResponseWriter, r
 		server.writeError(responseWriter, http.StatusInternalServerError, "DATABASE_ERROR", "Failed to get chat session", nil)
 		return
 	}
+	session.Sampling = decodeSampling(samplingJSON)
 
 	// Get context configuration
 	var includedLectureIDsJSON, usedLectureIDsJSON, includedToolIDsJSON string
@@ -170,12 +195,11 @@ func (server *Server) handleGetChatSession(responseWriter http.ResponseWriter, r
 	}
 
 	// Get messages
+	condition, conditionArgs := page.condition()
 	messageRows, databaseError := server.database.Query(`
-		SELECT id, session_id, role, content, model_used, metadata, input_tokens, output_tokens, estimated_cost, created_at
+		SELECT id, session_id, role, content, model_used, metadata, input_tokens, output_tokens, estimated_cost, created_at, `+page.sortValue()+`
 		FROM chat_messages
-		WHERE session_id = ?
-		ORDER BY created_at ASC
-	`, sessionID)
+		WHERE session_id = ?`+condition+page.orderBy(), append([]any{sessionID}, conditionArgs...)...)
 	if databaseError != nil {
 		server.writeError(responseWriter, http.StatusInternalServerError, "DATABASE_ERROR", "Failed to get messages", nil)
 		return
@@ -193,11 +217,15 @@ func (server *Server) handleGetChatSession(responseWriter http.ResponseWriter, r
 		languageCode = server.configuration.LLM.Language
 	}
 
+	citationsByMessage := server.getSessionCitations(sessionID)
+
 	var messages []models.ChatMessage
+	var cursors []pageCursor
 	for messageRows.Next() {
 		var message models.ChatMessage
 		var modelUsed, metadataJSON sql.NullString
-		if scanError := messageRows.Scan(&message.ID, &message.SessionID, &message.Role, &message.Content, &modelUsed, &metadataJSON, &message.InputTokens, &message.OutputTokens, &message.EstimatedCost, &message.CreatedAt); scanError != nil {
+		var sortValue string
+		if scanError := messageRows.Scan(&message.ID, &message.SessionID, &message.Role, &message.Content, &modelUsed, &metadataJSON, &message.InputTokens, &message.OutputTokens, &message.EstimatedCost, &message.CreatedAt, &sortValue); scanError != nil {
 			slog.Error("Failed to scan chat message", "sessionID", sessionID, "error", scanError)
 			continue
 		}
@@ -208,6 +236,7 @@ func (server *Server) handleGetChatSession(responseWriter http.ResponseWriter, r
 		if metadataJSON.Valid {
 			message.Metadata = metadataJSON.String
 		}
+		message.Citations = citationsByMessage[message.ID]
 
 		// Process content: convert RAW Markdown to final version at runtime
 		processedContent := message.Content
@@ -252,11 +281,13 @@ func (server *Server) handleGetChatSession(responseWriter http.ResponseWriter, r
 		}
 
 		messages = append(messages, message)
+		cursors = append(cursors, pageCursor{SortValue: sortValue, ID: message.ID})
 	}
+	messages, nextCursor := paginate(page, messages, cursors)
 
 	slog.Info("Retrieved chat messages", "sessionID", sessionID, "count", len(messages))
 
-	server.writeJSON(responseWriter, http.StatusOK, map[string]any{
+	server.writePage(responseWriter, http.StatusOK, map[string]any{
 		"session": session,
 		"context": map[string]any{
 			"included_lecture_ids": contextIncludedLectureIDs,
@@ -264,7 +295,7 @@ func (server *Server) handleGetChatSession(responseWriter http.ResponseWriter, r
 			"included_tool_ids":    contextIncludedToolIDs,
 		},
 		"messages": messages,
-	})
+	}, nextCursor)
 }
 
 // handleDeleteChatSession deletes a chat session
@@ -287,10 +318,12 @@ func (server *Server) handleDeleteChatSession(responseWriter http.ResponseWriter
 
 	result, databaseError := server.database.Exec(`
 		DELETE FROM chat_sessions 
-		WHERE id = ? AND exam_id = ? AND EXISTS (
-			SELECT 1 FROM exams WHERE id = ? AND user_id = ?
+		WHERE id = ? AND exam_id = ? AND id IN (
+			SELECT chat_sessions.id FROM chat_sessions
+			JOIN exams ON chat_sessions.exam_id = exams.id
+			WHERE `+chatSessionAccess(models.ExamRoleViewer)+`
 		)
-	`, deleteRequest.SessionID, deleteRequest.ExamID, deleteRequest.ExamID, userID)
+	`, deleteRequest.SessionID, deleteRequest.ExamID, userID, userID)
 	if databaseError != nil {
 		server.writeError(responseWriter, http.StatusInternalServerError, "DATABASE_ERROR", "Failed to delete chat session", nil)
 		return
@@ -308,9 +341,10 @@ func (server *Server) handleDeleteChatSession(responseWriter http.ResponseWriter
 // handleUpdateChatContext updates which materials are included in the chat session
 func (server *Server) handleUpdateChatContext(responseWriter http.ResponseWriter, request *http.Request) {
 	var updateContextRequest struct {
-		SessionID          string   `json:"session_id"`
-		IncludedLectureIDs []string `json:"included_lecture_ids"`
-		IncludedToolIDs    []string `json:"included_tool_ids"`
+		SessionID          string                     `json:"session_id"`
+		IncludedLectureIDs []string                   `json:"included_lecture_ids"`
+		IncludedToolIDs    []string                   `json:"included_tool_ids"`
+		Sampling           *models.SamplingParameters `json:"sampling"` // Replaces the sampling of the session when given
 	}
 
 	if decodeError := json.NewDecoder(request.Body).Decode(&updateContextRequest); decodeError != nil {
@@ -322,6 +356,12 @@ func (server *Server) handleUpdateChatContext(responseWriter http.ResponseWriter
 		server.writeError(responseWriter, http.StatusBadRequest, "VALIDATION_ERROR", "session_id is required", nil)
 		return
 	}
+	if updateContextRequest.Sampling != nil {
+		if err := updateContextRequest.Sampling.Validate(); err != nil {
+			server.writeError(responseWriter, http.StatusBadRequest, "VALIDATION_ERROR", err.Error(), nil)
+			return
+		}
+	}
 
 	userID := server.getUserID(request)
 
@@ -330,8 +370,8 @@ func (server *Server) handleUpdateChatContext(responseWriter http.ResponseWriter
 	err := server.database.QueryRow(`
 		SELECT chat_sessions.exam_id FROM chat_sessions 
 		JOIN exams ON chat_sessions.exam_id = exams.id
-		WHERE chat_sessions.id = ? AND exams.user_id = ?
-	`, updateContextRequest.SessionID, userID).Scan(&examID)
+		WHERE chat_sessions.id = ? AND `+chatSessionAccess(models.ExamRoleGenerator)+`
+	`, updateContextRequest.SessionID, userID, userID).Scan(&examID)
 	if err != nil {
 		server.writeError(responseWriter, http.StatusNotFound, "NOT_FOUND", "Chat session not found", nil)
 		return
@@ -371,14 +411,23 @@ func (server *Server) handleUpdateChatContext(responseWriter http.ResponseWriter
 		return
 	}
 
+	if updateContextRequest.Sampling != nil {
+		_, databaseError = server.database.Exec("UPDATE chat_sessions SET sampling = ?, updated_at = ? WHERE id = ?", encodeSampling(*updateContextRequest.Sampling), time.Now(), updateContextRequest.SessionID)
+		if databaseError != nil {
+			server.writeError(responseWriter, http.StatusInternalServerError, "DATABASE_ERROR", "Failed to update chat sampling", nil)
+			return
+		}
+	}
+
 	server.writeJSON(responseWriter, http.StatusOK, map[string]string{"message": "Chat context updated successfully"})
 }
 
 // handleSendMessage adds a user message and triggers the AI response
 func (server *Server) handleSendMessage(responseWriter http.ResponseWriter, request *http.Request) {
 	var sendMessageRequest struct {
-		SessionID string `json:"session_id"`
-		Content   string `json:"content"`
+		SessionID string                    `json:"session_id"`
+		Content   string                    `json:"content"`
+		Sampling  models.SamplingParameters `json:"sampling"` // Overrides the sampling of the session for this reply
 	}
 
 	if decodeError := json.NewDecoder(request.Body).Decode(&sendMessageRequest); decodeError != nil {
@@ -390,20 +439,26 @@ func (server *Server) handleSendMessage(responseWriter http.ResponseWriter, requ
 		server.writeError(responseWriter, http.StatusBadRequest, "VALIDATION_ERROR", "session_id and content are required", nil)
 		return
 	}
+	if err := sendMessageRequest.Sampling.Validate(); err != nil {
+		server.writeError(responseWriter, http.StatusBadRequest, "VALIDATION_ERROR", err.Error(), nil)
+		return
+	}
 
 	userID := server.getUserID(request)
 
 	// 1. Verify session and save user message
 	var session models.ChatSession
+	var samplingJSON sql.NullString
 	databaseError := server.database.QueryRow(`
-		SELECT chat_sessions.id, chat_sessions.exam_id FROM chat_sessions 
+		SELECT chat_sessions.id, chat_sessions.exam_id, chat_sessions.sampling FROM chat_sessions 
 		JOIN exams ON chat_sessions.exam_id = exams.id
-		WHERE chat_sessions.id = ? AND exams.user_id = ?
-	`, sendMessageRequest.SessionID, userID).Scan(&session.ID, &session.ExamID)
+		WHERE chat_sessions.id = ? AND `+chatSessionAccess(models.ExamRoleGenerator)+`
+	`, sendMessageRequest.SessionID, userID, userID).Scan(&session.ID, &session.ExamID, &samplingJSON)
 	if databaseError != nil {
 		server.writeError(responseWriter, http.StatusNotFound, "NOT_FOUND", "Chat session not found", nil)
 		return
 	}
+	sampling := decodeSampling(samplingJSON).Merge(sendMessageRequest.Sampling)
 
 	userMsgID, _ := gonanoid.New()
 	userMessage := models.ChatMessage{
@@ -498,10 +553,28 @@ func (server *Server) handleSendMessage(responseWriter http.ResponseWriter, requ
 		languageCode = server.configuration.LLM.Language
 	}
 
-	lectureContext := server.getLectureContext(sendMessageRequest.SessionID, languageCode)
+	if !server.restoreLectureTranscripts(responseWriter, server.chatContextLectureIDs(sendMessageRequest.SessionID)...) {
+		return
+	}
 
-	// 3. Trigger async AI response
-	go server.processAIResponse(sendMessageRequest.SessionID, messages, lectureContext)
+	// 3. Trigger async AI response, outliving the request but made for its user so their own API key is used
+	responseContext := llm.WithUser(context.Background(), server.getUserID(request))
+	if server.embeddingIndex != nil {
+		// Embedding the question and indexing new lectures can take a while, so it happens off the request
+		go func() {
+			lectureContext, sourceCitations, err := server.getRetrievedContext(responseContext, sendMessageRequest.SessionID, sendMessageRequest.Content, languageCode)
+			if err != nil {
+				slog.Warn("Chat retrieval failed, sending the full lecture context", "sessionID", sendMessageRequest.SessionID, "error", err)
+			}
+			if lectureContext == "" {
+				lectureContext = server.getLectureContext(sendMessageRequest.SessionID, languageCode)
+			}
+			server.processAIResponse(responseContext, sendMessageRequest.SessionID, messages, lectureContext, sourceCitations, sampling)
+		}()
+	} else {
+		lectureContext := server.getLectureContext(sendMessageRequest.SessionID, languageCode)
+		go server.processAIResponse(responseContext, sendMessageRequest.SessionID, messages, lectureContext, nil, sampling)
+	}
 
 	// Update user message with metadata in DB
 	_, _ = server.database.Exec(`
@@ -540,32 +613,38 @@ func (server *Server) getChatHistory(sessionID string) []llm.Message {
 	return messages
 }
 
-func (server *Server) getLectureContext(sessionID string, languageCode string) string {
-	var includedLectureIDsJSON string
-	var usedLectureIDsJSON string
+// chatContextLectureIDs returns the unique lectures that are included in or were already used by a chat session
+func (server *Server) chatContextLectureIDs(sessionID string) []string {
+	// used_lecture_ids stays NULL until the first message is sent
+	var includedLectureIDsJSON, usedLectureIDsJSON sql.NullString
 	databaseError := server.database.QueryRow(`
 		SELECT included_lecture_ids, used_lecture_ids 
 		FROM chat_context_configuration 
 		WHERE session_id = ?
 	`, sessionID).Scan(&includedLectureIDsJSON, &usedLectureIDsJSON)
 	if databaseError != nil {
-		return ""
+		return nil
 	}
 
 	var includedIDs, usedIDs []string
-	json.Unmarshal([]byte(includedLectureIDsJSON), &includedIDs)
-	json.Unmarshal([]byte(usedLectureIDsJSON), &usedIDs)
+	json.Unmarshal([]byte(includedLectureIDsJSON.String), &includedIDs)
+	json.Unmarshal([]byte(usedLectureIDsJSON.String), &usedIDs)
 
 	// Combine both sets into a unique list
 	allIDsMap := make(map[string]bool)
-	for _, id := range includedIDs {
-		allIDsMap[id] = true
-	}
-	for _, id := range usedIDs {
-		allIDsMap[id] = true
+	var lectureIDs []string
+	for _, id := range append(includedIDs, usedIDs...) {
+		if !allIDsMap[id] {
+			allIDsMap[id] = true
+			lectureIDs = append(lectureIDs, id)
+		}
 	}
+	return lectureIDs
+}
 
-	if len(allIDsMap) == 0 {
+func (server *Server) getLectureContext(sessionID string, languageCode string) string {
+	lectureIDs := server.chatContextLectureIDs(sessionID)
+	if len(lectureIDs) == 0 {
 		return ""
 	}
 
@@ -574,7 +653,7 @@ func (server *Server) getLectureContext(sessionID string, languageCode string) s
 	rootNode := &markdown.Node{Type: markdown.NodeDocument}
 
 	// Iterate through the combined unique IDs
-	for lectureID := range allIDsMap {
+	for _, lectureID := range lectureIDs {
 		var title string
 		server.database.QueryRow("SELECT title FROM lectures WHERE id = ?", lectureID).Scan(&title)
 
@@ -608,46 +687,170 @@ func (server *Server) getLectureContext(sessionID string, languageCode string) s
 		}
 
 		// Add reference documents
-		documentRows, databaseError := server.database.Query(`
-			SELECT reference_documents.title, reference_pages.page_number, reference_pages.extracted_text
-			FROM reference_documents
-			JOIN reference_pages ON reference_documents.id = reference_pages.document_id
-			WHERE reference_documents.lecture_id = ?
-			ORDER BY reference_documents.id, reference_pages.page_number ASC
-		`, lectureID)
+		referenceChunks, databaseError := documents.ListLectureReferenceChunks(server.database, lectureID)
 		if databaseError == nil {
-			currentDocTitle := ""
-			for documentRows.Next() {
-				var title, text string
-				var pageNumber int
-				if scanError := documentRows.Scan(&title, &pageNumber, &text); scanError == nil {
-					if title != currentDocTitle {
-						rootNode.Children = append(rootNode.Children, &markdown.Node{
-							Type:    markdown.NodeHeading,
-							Level:   2,
-							Content: "Reference File: " + title,
-						})
-						currentDocTitle = title
-					}
+			currentDocumentID := ""
+			for _, chunk := range referenceChunks {
+				if chunk.DocumentID != currentDocumentID {
 					rootNode.Children = append(rootNode.Children, &markdown.Node{
 						Type:    markdown.NodeHeading,
-						Level:   3,
-						Content: fmt.Sprintf("Page %d", pageNumber),
-					})
-					rootNode.Children = append(rootNode.Children, &markdown.Node{
-						Type:    markdown.NodeParagraph,
-						Content: strings.TrimSpace(text),
+						Level:   2,
+						Content: "Reference File: " + chunk.DocumentTitle,
 					})
+					currentDocumentID = chunk.DocumentID
 				}
+				rootNode.Children = append(rootNode.Children, &markdown.Node{
+					Type:    markdown.NodeHeading,
+					Level:   3,
+					Content: chunk.PageLabel(),
+				})
+				rootNode.Children = append(rootNode.Children, &markdown.Node{
+					Type:    markdown.NodeParagraph,
+					Content: chunk.Content,
+				})
 			}
-			documentRows.Close()
 		}
 	}
 
 	return markdownReconstructor.Reconstruct(rootNode)
 }
 
-func (server *Server) processAIResponse(sessionID string, history []llm.Message, lectureContext string) {
+// getRetrievedContext builds the chat context from the lecture chunks most similar to the question, together with
+// the citations pointing back at them. Lectures are indexed on first use
+func (server *Server) getRetrievedContext(requestContext context.Context, sessionID string, question string, languageCode string) (string, []models.ChatCitation, error) {
+	lectureIDs := server.chatContextLectureIDs(sessionID)
+	if len(lectureIDs) == 0 {
+		return "", nil, nil
+	}
+
+	for _, lectureID := range lectureIDs {
+		if err := server.embeddingIndex.EnsureLecture(requestContext, lectureID); err != nil {
+			return "", nil, fmt.Errorf("failed to index lecture %s: %w", lectureID, err)
+		}
+	}
+
+	topK := server.configuration.Embeddings.TopK
+	if topK <= 0 {
+		topK = embeddings.DefaultTopK
+	}
+	results, err := server.embeddingIndex.Search(requestContext, lectureIDs, question, topK)
+	if err != nil {
+		return "", nil, err
+	}
+	if len(results) == 0 {
+		return "", nil, nil
+	}
+
+	lectureTitles := make(map[string]string)
+	for _, lectureID := range lectureIDs {
+		var title string
+		server.database.QueryRow("SELECT title FROM lectures WHERE id = ?", lectureID).Scan(&title)
+		lectureTitles[lectureID] = title
+	}
+
+	markdownReconstructor := markdown.NewReconstructor()
+	markdownReconstructor.Language = languageCode
+	rootNode := &markdown.Node{Type: markdown.NodeDocument}
+
+	citations := make([]models.ChatCitation, 0, len(results))
+	currentLectureID := ""
+	for _, result := range results {
+		if result.LectureID != currentLectureID {
+			rootNode.Children = append(rootNode.Children, &markdown.Node{
+				Type:    markdown.NodeHeading,
+				Level:   1,
+				Content: lectureTitles[result.LectureID],
+			})
+			currentLectureID = result.LectureID
+		}
+		rootNode.Children = append(rootNode.Children, &markdown.Node{
+			Type:    markdown.NodeHeading,
+			Level:   2,
+			Content: result.Label,
+		}, &markdown.Node{
+			Type:    markdown.NodeParagraph,
+			Content: result.Content,
+		})
+
+		snippet := result.Content
+		if runes := []rune(snippet); len(runes) > maximumCitationSnippetCharacters {
+			snippet = strings.TrimSpace(string(runes[:maximumCitationSnippetCharacters])) + "..."
+		}
+		citations = append(citations, models.ChatCitation{
+			SourceType:       result.SourceType,
+			SourceID:         result.SourceID,
+			LectureID:        result.LectureID,
+			Label:            result.Label,
+			PageNumber:       result.PageNumber,
+			StartMillisecond: result.StartMillisecond,
+			EndMillisecond:   result.EndMillisecond,
+			Snippet:          snippet,
+			Score:            result.Score,
+		})
+	}
+
+	return markdownReconstructor.Reconstruct(rootNode), citations, nil
+}
+
+// maximumCitationSnippetCharacters bounds the excerpt stored with each chat citation
+const maximumCitationSnippetCharacters = 300
+
+// saveChatCitations stores the sources an assistant message was grounded in
+func (server *Server) saveChatCitations(messageID string, citations []models.ChatCitation) {
+	for _, citation := range citations {
+		locationType := "segment_range"
+		if citation.SourceType == embeddings.SourceSlide {
+			locationType = "page"
+		}
+		locationData, _ := json.Marshal(map[string]any{
+			"lecture_id":        citation.LectureID,
+			"label":             citation.Label,
+			"page_number":       citation.PageNumber,
+			"start_millisecond": citation.StartMillisecond,
+			"end_millisecond":   citation.EndMillisecond,
+			"score":             citation.Score,
+		})
+		_, err := server.database.Exec(`
+			INSERT INTO chat_citations (message_id, source_type, source_id, location_type, location_data, snippet)
+			VALUES (?, ?, ?, ?, ?, ?)
+		`, messageID, citation.SourceType, citation.SourceID, locationType, string(locationData), citation.Snippet)
+		if err != nil {
+			slog.Warn("Failed to save chat citation", "messageID", messageID, "error", err)
+		}
+	}
+}
+
+// getSessionCitations loads the citations of every message in a chat session, keyed by message ID
+func (server *Server) getSessionCitations(sessionID string) map[string][]models.ChatCitation {
+	citationRows, err := server.database.Query(`
+		SELECT chat_citations.message_id, chat_citations.source_type, chat_citations.source_id, chat_citations.location_data, chat_citations.snippet
+		FROM chat_citations
+		JOIN chat_messages ON chat_citations.message_id = chat_messages.id
+		WHERE chat_messages.session_id = ?
+		ORDER BY chat_citations.id ASC
+	`, sessionID)
+	if err != nil {
+		slog.Error("Failed to query chat citations", "sessionID", sessionID, "error", err)
+		return nil
+	}
+	defer citationRows.Close()
+
+	citationsByMessage := make(map[string][]models.ChatCitation)
+	for citationRows.Next() {
+		var messageID, locationData string
+		var snippet sql.NullString
+		var citation models.ChatCitation
+		if err := citationRows.Scan(&messageID, &citation.SourceType, &citation.SourceID, &locationData, &snippet); err != nil {
+			continue
+		}
+		json.Unmarshal([]byte(locationData), &citation)
+		citation.Snippet = snippet.String
+		citationsByMessage[messageID] = append(citationsByMessage[messageID], citation)
+	}
+	return citationsByMessage
+}
+
+func (server *Server) processAIResponse(responseContext context.Context, sessionID string, history []llm.Message, lectureContext string, sourceCitations []models.ChatCitation, sampling models.SamplingParameters) {
 	// Fetch language code for the session
 	var languageCode string
 	err := server.database.QueryRow(`
@@ -718,35 +921,46 @@ func (server *Server) processAIResponse(sessionID string, history []llm.Message,
 
 	model := server.configuration.LLM.Model
 
-	responseChannel, chatError := server.llmProvider.Chat(context.Background(), &llm.ChatRequest{
+	chatRequest := &llm.ChatRequest{
 		Model:     model,
 		Messages:  fullMessages,
 		Stream:    true,
 		SessionID: sessionID,
 		MaxTokens: 16384,
-	})
+	}
+	chatRequest.ApplySampling(sampling)
+	estimatedTokens, guardError := llm.GuardRequest(responseContext, server.llmProvider, chatRequest)
+	if guardError != nil {
+		slog.Error("Chat prompt does not fit the context window", "model", model, "error", guardError)
+		server.broadcastChatEvent(sessionID, "chat:error", map[string]string{"error": "The conversation is too long for the model, start a new session or include fewer lectures"})
+		return
+	}
+
+	responseChannel, chatError := server.llmProvider.Chat(responseContext, chatRequest)
 
 	if chatError != nil {
 		slog.Error("LLM chat failed", "error", chatError)
-		server.wsHub.Broadcast(WSMessage{
-			Type:      "chat:error",
-			Channel:   "chat:" + sessionID,
-			Payload:   map[string]string{"error": "Failed to generate response"},
-			Timestamp: time.Now().Format(time.RFC3339),
-		})
+		server.broadcastChatEvent(sessionID, "chat:error", map[string]string{"error": "Failed to generate response"})
 		return
 	}
 
-	var totalMetrics models.JobMetrics
+	// The assistant message ID is allocated up front so streamed tokens and the
+	// final chat:complete payload can be correlated by the client
+	assistantMsgID, _ := gonanoid.New()
+	server.broadcastChatEvent(sessionID, "chat:start", map[string]string{
+		"message_id": assistantMsgID,
+		"model":      model,
+	})
+
+	totalMetrics := models.JobMetrics{EstimatedInputTokens: estimatedTokens}
 	var completeResponseBuilder strings.Builder
+	tokenSequence := 0
 	for chunk := range responseChannel {
 		if chunk.Error != nil {
 			slog.Error("LLM stream error", "error", chunk.Error)
-			server.wsHub.Broadcast(WSMessage{
-				Type:      "chat:error",
-				Channel:   "chat:" + sessionID,
-				Payload:   map[string]string{"error": "Stream interrupted"},
-				Timestamp: time.Now().Format(time.RFC3339),
+			server.broadcastChatEvent(sessionID, "chat:error", map[string]string{
+				"error":      "Stream interrupted",
+				"message_id": assistantMsgID,
 			})
 			break
 		}
@@ -756,16 +970,19 @@ func (server *Server) processAIResponse(sessionID string, history []llm.Message,
 		totalMetrics.OutputTokens += chunk.OutputTokens
 		totalMetrics.EstimatedCost += chunk.Cost
 
+		// Usage-only chunks carry no text, there is nothing to render
+		if chunk.Text == "" {
+			continue
+		}
+
 		// Broadcast token via WebSocket
-		server.wsHub.Broadcast(WSMessage{
-			Type:    "chat:token",
-			Channel: "chat:" + sessionID,
-			Payload: map[string]string{
-				"token":            chunk.Text,
-				"accumulated_text": completeResponseBuilder.String(),
-			},
-			Timestamp: time.Now().Format(time.RFC3339),
+		server.broadcastChatEvent(sessionID, "chat:token", map[string]any{
+			"message_id":       assistantMsgID,
+			"sequence":         tokenSequence,
+			"token":            chunk.Text,
+			"accumulated_text": completeResponseBuilder.String(),
 		})
+		tokenSequence++
 	}
 
 	// Post-process response: Parse citations and convert to standard footnotes
@@ -775,10 +992,11 @@ func (server *Server) processAIResponse(sessionID string, history []llm.Message,
 
 	// Improve footnotes using AI if we have citations
 	if len(citations) > 0 {
-		updatedCitations, footnoteMetrics, err := server.toolGenerator.ProcessFootnotesAI(context.Background(), citations, languageCode, models.GenerationOptions{})
+		updatedCitations, footnoteMetrics, err := server.toolGenerator.ProcessFootnotesAI(responseContext, citations, languageCode, models.GenerationOptions{})
 		totalMetrics.InputTokens += footnoteMetrics.InputTokens
 		totalMetrics.OutputTokens += footnoteMetrics.OutputTokens
 		totalMetrics.EstimatedCost += footnoteMetrics.EstimatedCost
+		totalMetrics.EstimatedInputTokens += footnoteMetrics.EstimatedInputTokens
 		if err == nil {
 			citations = updatedCitations
 		}
@@ -792,12 +1010,12 @@ func (server *Server) processAIResponse(sessionID string, history []llm.Message,
 	slog.Info("Chat AI response completed",
 		"sessionID", sessionID,
 		"input_tokens", totalMetrics.InputTokens,
+		"estimated_input_tokens", totalMetrics.EstimatedInputTokens,
 		"output_tokens", totalMetrics.OutputTokens,
 		"estimated_cost_usd", totalMetrics.EstimatedCost)
 
 	// Save complete response (RAW version with triple braces)
 	metadataJSON, _ := json.Marshal(citations)
-	assistantMsgID, _ := gonanoid.New()
 	assistantMessage := models.ChatMessage{
 		ID:            assistantMsgID,
 		SessionID:     sessionID,
@@ -808,6 +1026,7 @@ func (server *Server) processAIResponse(sessionID string, history []llm.Message,
 		InputTokens:   totalMetrics.InputTokens,
 		OutputTokens:  totalMetrics.OutputTokens,
 		EstimatedCost: totalMetrics.EstimatedCost,
+		Citations:     sourceCitations,
 		CreatedAt:     time.Now(),
 	}
 
@@ -818,6 +1037,8 @@ func (server *Server) processAIResponse(sessionID string, history []llm.Message,
 
 	if databaseError != nil {
 		slog.Error("Failed to save assistant message", "error", databaseError)
+	} else {
+		server.saveChatCitations(assistantMessage.ID, sourceCitations)
 	}
 
 	// Update session total cost
@@ -837,10 +1058,33 @@ func (server *Server) processAIResponse(sessionID string, history []llm.Message,
 	}
 
 	// Broadcast complete message
+	server.broadcastChatEvent(sessionID, "chat:complete", assistantMessage)
+}
+
+// broadcastChatEvent publishes a chat streaming event on the session's WebSocket channel
+func (server *Server) broadcastChatEvent(sessionID string, eventType string, payload any) {
 	server.wsHub.Broadcast(WSMessage{
-		Type:      "chat:complete",
+		Type:      eventType,
 		Channel:   "chat:" + sessionID,
-		Payload:   assistantMessage,
+		Payload:   payload,
 		Timestamp: time.Now().Format(time.RFC3339),
 	})
 }
+
+// encodeSampling stores the sampling parameters of a chat session, NULL when none is set
+func encodeSampling(sampling models.SamplingParameters) any {
+	if sampling.IsZero() {
+		return nil
+	}
+	samplingJSON, _ := json.Marshal(sampling)
+	return string(samplingJSON)
+}
+
+// decodeSampling reads the sampling parameters of a chat session, none being set when they are NULL or malformed
+func decodeSampling(samplingJSON sql.NullString) models.SamplingParameters {
+	var sampling models.SamplingParameters
+	if samplingJSON.Valid && samplingJSON.String != "" {
+		json.Unmarshal([]byte(samplingJSON.String), &sampling)
+	}
+	return sampling
+}
@@ -0,0 +1,145 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"lectures/internal/embeddings"
+	"lectures/internal/models"
+)
+
+// keywordEmbedder places texts on two axes depending on which keyword they mention
+type keywordEmbedder struct{}
+
+func (keywordEmbedder) Model() string { return "keyword" }
+
+func (keywordEmbedder) Embed(_ context.Context, texts []string) ([][]float32, error) {
+	vectors := make([][]float32, len(texts))
+	for index, text := range texts {
+		vectors[index] = []float32{0.1, 0.1}
+		if strings.Contains(strings.ToLower(text), "mitochondria") {
+			vectors[index][0] = 1
+		}
+		if strings.Contains(strings.ToLower(text), "exam") {
+			vectors[index][1] = 1
+		}
+	}
+	return vectors, nil
+}
+
+func TestHandleChatRetrievalCitations(t *testing.T) {
+	server, userID, sessionID, cleanup := setupUniqueExtraTestEnv(t, "retrieval")
+	defer cleanup()
+	server.SetEmbeddingIndex(embeddings.NewIndex(server.database, keywordEmbedder{}, 0))
+
+	server.database.Exec("INSERT INTO exams (id, user_id, title) VALUES ('retrieval-exam', ?, 'Biology')", userID)
+	server.database.Exec("INSERT INTO lectures (id, exam_id, title, status) VALUES ('retrieval-lecture', 'retrieval-exam', 'Cells', 'ready')")
+	server.database.Exec("INSERT INTO transcripts (id, lecture_id, status) VALUES ('retrieval-transcript', 'retrieval-lecture', 'completed')")
+	server.database.Exec("INSERT INTO transcript_segments (transcript_id, start_millisecond, end_millisecond, text) VALUES ('retrieval-transcript', 0, 5000, 'The exam is on Friday.')")
+	server.database.Exec("INSERT INTO reference_documents (id, lecture_id, document_type, title, file_path, page_count) VALUES ('retrieval-document', 'retrieval-lecture', 'pdf', 'slides.pdf', '/tmp/slides.pdf', 1)")
+	server.database.Exec("INSERT INTO reference_pages (document_id, page_number, image_path, extracted_text) VALUES ('retrieval-document', 2, '/tmp/page.png', 'Mitochondria produce most of the energy of the cell.')")
+	server.database.Exec("INSERT INTO chat_sessions (id, exam_id, title) VALUES ('retrieval-chat', 'retrieval-exam', 'Questions')")
+	server.database.Exec("INSERT INTO chat_context_configuration (session_id, included_lecture_ids, included_tool_ids) VALUES ('retrieval-chat', '[\"retrieval-lecture\"]', '[]')")
+
+	lectureContext, citations, err := server.getRetrievedContext(context.Background(), "retrieval-chat", "What do mitochondria do?", "en-US")
+	if err != nil {
+		t.Fatalf("Retrieval failed: %v", err)
+	}
+	if len(citations) != 2 || citations[0].SourceType != "slide" || citations[0].PageNumber != 2 || citations[0].Label != "slides.pdf, page 2" {
+		t.Fatalf("Expected the slide to be cited first, got %+v", citations)
+	}
+	if !strings.Contains(lectureContext, "Mitochondria produce") || !strings.Contains(lectureContext, "slides.pdf, page 2") {
+		t.Errorf("Expected the retrieved chunk in the context, got %q", lectureContext)
+	}
+
+	server.database.Exec("INSERT INTO chat_messages (id, session_id, role, content) VALUES ('retrieval-message', 'retrieval-chat', 'assistant', 'They produce energy.')")
+	server.saveChatCitations("retrieval-message", citations)
+
+	req := httptest.NewRequest("GET", "/api/chat/sessions/details?session_id=retrieval-chat&exam_id=retrieval-exam", nil)
+	req.Header.Set("Authorization", "Bearer "+sessionID)
+	rr := httptest.NewRecorder()
+	server.Handler().ServeHTTP(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d. Body: %s", rr.Code, rr.Body.String())
+	}
+
+	var response struct {
+		Data struct {
+			Messages []models.ChatMessage `json:"messages"`
+		} `json:"data"`
+	}
+	json.Unmarshal(rr.Body.Bytes(), &response)
+	if len(response.Data.Messages) != 1 || len(response.Data.Messages[0].Citations) != 2 {
+		t.Fatalf("Expected the message to carry 2 citations, got %+v", response.Data.Messages)
+	}
+	storedCitation := response.Data.Messages[0].Citations[0]
+	if storedCitation.SourceID != "retrieval-document" || storedCitation.LectureID != "retrieval-lecture" || storedCitation.PageNumber != 2 || storedCitation.Snippet == "" {
+		t.Errorf("Unexpected stored citation: %+v", storedCitation)
+	}
+}
+
+func TestHandleSamplingParameters(t *testing.T) {
+	server, userID, sessionID, cleanup := setupUniqueExtraTestEnv(t, "sampling")
+	defer cleanup()
+
+	server.database.Exec("INSERT INTO exams (id, user_id, title) VALUES ('sampling-exam', ?, 'Optics')", userID)
+	server.database.Exec("INSERT INTO lectures (id, exam_id, title, status) VALUES ('sampling-lecture', 'sampling-exam', 'Lenses', 'ready')")
+
+	sendRequest := func(method string, target string, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, target, strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer "+sessionID)
+		req.Header.Set("X-Requested-With", "XMLHttpRequest")
+		req.Header.Set("Content-Type", "application/json")
+		rr := httptest.NewRecorder()
+		server.Handler().ServeHTTP(rr, req)
+		return rr
+	}
+
+	if response := sendRequest("POST", "/api/chat/sessions", `{"exam_id":"sampling-exam","sampling":{"temperature":3}}`); response.Code != http.StatusBadRequest {
+		t.Errorf("Expected a temperature above 2 refused, got %d", response.Code)
+	}
+	response := sendRequest("POST", "/api/chat/sessions", `{"exam_id":"sampling-exam","sampling":{"temperature":0,"seed":42}}`)
+	if response.Code != http.StatusCreated {
+		t.Fatalf("Expected the session created, got %d: %s", response.Code, response.Body.String())
+	}
+	var createResponse struct {
+		Data models.ChatSession `json:"data"`
+	}
+	json.Unmarshal(response.Body.Bytes(), &createResponse)
+	chatID := createResponse.Data.ID
+
+	if response := sendRequest("PATCH", "/api/chat/sessions/context", `{"session_id":"`+chatID+`","sampling":{"top_p":0}}`); response.Code != http.StatusBadRequest {
+		t.Errorf("Expected a top_p of 0 refused, got %d", response.Code)
+	}
+	if response := sendRequest("PATCH", "/api/chat/sessions/context", `{"session_id":"`+chatID+`","sampling":{"temperature":0,"seed":42,"max_tokens":800}}`); response.Code != http.StatusOK {
+		t.Errorf("Expected the sampling updated, got %d: %s", response.Code, response.Body.String())
+	}
+	response = sendRequest("GET", "/api/chat/sessions/details?session_id="+chatID+"&exam_id=sampling-exam", "")
+	var detailsResponse struct {
+		Data struct {
+			Session models.ChatSession `json:"session"`
+		} `json:"data"`
+	}
+	json.Unmarshal(response.Body.Bytes(), &detailsResponse)
+	sampling := detailsResponse.Data.Session.Sampling
+	if sampling.Temperature == nil || *sampling.Temperature != 0 || sampling.Seed == nil || *sampling.Seed != 42 || sampling.MaxTokens != 800 {
+		t.Errorf("Expected temperature 0, seed 42 and max_tokens 800, got %+v", sampling)
+	}
+
+	if response := sendRequest("POST", "/api/tools", `{"exam_id":"sampling-exam","lecture_id":"sampling-lecture","language_code":"en","sampling":{"max_tokens":-1}}`); response.Code != http.StatusBadRequest {
+		t.Errorf("Expected negative max_tokens refused, got %d", response.Code)
+	}
+	response = sendRequest("POST", "/api/tools", `{"exam_id":"sampling-exam","lecture_id":"sampling-lecture","language_code":"en","sampling":{"temperature":0.2,"seed":7}}`)
+	if response.Code != http.StatusAccepted {
+		t.Fatalf("Expected the build queued, got %d: %s", response.Code, response.Body.String())
+	}
+	var payload string
+	server.database.QueryRow("SELECT payload FROM jobs WHERE type = 'BUILD_MATERIAL' AND lecture_id = 'sampling-lecture'").Scan(&payload)
+	if !strings.Contains(payload, `"sampling":{"temperature":0.2,"seed":7}`) {
+		t.Errorf("Expected the sampling in the job payload, got %s", payload)
+	}
+}
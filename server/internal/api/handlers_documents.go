@@ -6,10 +6,14 @@ import (
 	"fmt"
 	"log/slog"
 	"net/http"
+	"net/url"
 	"strconv"
+	"strings"
 	"time"
 
+	"lectures/internal/documents"
 	"lectures/internal/models"
+	"lectures/internal/netguard"
 )
 
 // handleListDocuments lists all reference documents for a lecture
@@ -21,14 +25,19 @@ func (server *Server) handleListDocuments(responseWriter http.ResponseWriter, re
 	}
 
 	userID := server.getUserID(request)
+	page, err := parseListPage(request, "reference_documents.created_at", "reference_documents.id", false)
+	if err != nil {
+		server.writeError(responseWriter, http.StatusBadRequest, "VALIDATION_ERROR", err.Error(), nil)
+		return
+	}
+	condition, conditionArgs := page.condition()
 
 	documentRows, databaseError := server.database.Query(`
-		SELECT reference_documents.id, reference_documents.lecture_id, reference_documents.document_type, reference_documents.title, reference_documents.file_path, reference_documents.page_count, reference_documents.extraction_status, reference_documents.estimated_cost, reference_documents.created_at, reference_documents.updated_at
+		SELECT reference_documents.id, reference_documents.lecture_id, reference_documents.document_type, reference_documents.title, reference_documents.file_path, reference_documents.page_count, reference_documents.extraction_status, COALESCE(reference_documents.extraction_method, ''), reference_documents.extraction_metadata, reference_documents.estimated_cost, COALESCE(reference_documents.source_url, ''), COALESCE(reference_documents.language, ''), COALESCE(reference_documents.detected_language, ''), reference_documents.created_at, reference_documents.updated_at, `+page.sortValue()+`
 		FROM reference_documents
 		JOIN lectures ON reference_documents.lecture_id = lectures.id
 		JOIN exams ON lectures.exam_id = exams.id
-		WHERE reference_documents.lecture_id = ? AND exams.user_id = ?
-	`, lectureID, userID)
+		WHERE reference_documents.lecture_id = ? AND `+examAccess(models.ExamRoleViewer)+condition+page.orderBy(), append([]any{lectureID, userID}, conditionArgs...)...)
 	if databaseError != nil {
 		server.writeError(responseWriter, http.StatusInternalServerError, "DATABASE_ERROR", "Failed to list documents", nil)
 		return
@@ -36,15 +45,21 @@ func (server *Server) handleListDocuments(responseWriter http.ResponseWriter, re
 	defer documentRows.Close()
 
 	var documentsList = []models.ReferenceDocument{}
+	var cursors []pageCursor
 	for documentRows.Next() {
 		var document models.ReferenceDocument
-		if err := documentRows.Scan(&document.ID, &document.LectureID, &document.DocumentType, &document.Title, &document.FilePath, &document.PageCount, &document.ExtractionStatus, &document.EstimatedCost, &document.CreatedAt, &document.UpdatedAt); err != nil {
+		var extractionMetadata sql.NullString
+		var sortValue string
+		if err := documentRows.Scan(&document.ID, &document.LectureID, &document.DocumentType, &document.Title, &document.FilePath, &document.PageCount, &document.ExtractionStatus, &document.ExtractionMethod, &extractionMetadata, &document.EstimatedCost, &document.SourceURL, &document.Language, &document.DetectedLanguage, &document.CreatedAt, &document.UpdatedAt, &sortValue); err != nil {
 			continue
 		}
+		document.ExtractionMetadata = decodeExtractionMetadata(extractionMetadata)
 		documentsList = append(documentsList, document)
+		cursors = append(cursors, pageCursor{SortValue: sortValue, ID: document.ID})
 	}
 
-	server.writeJSON(responseWriter, http.StatusOK, documentsList)
+	documentsList, nextCursor := paginate(page, documentsList, cursors)
+	server.writePage(responseWriter, http.StatusOK, documentsList, nextCursor)
 }
 
 // handleGetDocument retrieves a specific document metadata
@@ -60,13 +75,14 @@ func (server *Server) handleGetDocument(responseWriter http.ResponseWriter, requ
 	userID := server.getUserID(request)
 
 	var document models.ReferenceDocument
+	var extractionMetadata sql.NullString
 	err := server.database.QueryRow(`
-		SELECT reference_documents.id, reference_documents.lecture_id, reference_documents.document_type, reference_documents.title, reference_documents.file_path, reference_documents.page_count, reference_documents.extraction_status, reference_documents.estimated_cost, reference_documents.created_at, reference_documents.updated_at
+		SELECT reference_documents.id, reference_documents.lecture_id, reference_documents.document_type, reference_documents.title, reference_documents.file_path, reference_documents.page_count, reference_documents.extraction_status, COALESCE(reference_documents.extraction_method, ''), reference_documents.extraction_metadata, reference_documents.estimated_cost, COALESCE(reference_documents.source_url, ''), COALESCE(reference_documents.language, ''), COALESCE(reference_documents.detected_language, ''), reference_documents.created_at, reference_documents.updated_at
 		FROM reference_documents
 		JOIN lectures ON reference_documents.lecture_id = lectures.id
 		JOIN exams ON lectures.exam_id = exams.id
-		WHERE reference_documents.id = ? AND reference_documents.lecture_id = ? AND exams.user_id = ?
-	`, documentID, lectureID, userID).Scan(&document.ID, &document.LectureID, &document.DocumentType, &document.Title, &document.FilePath, &document.PageCount, &document.ExtractionStatus, &document.EstimatedCost, &document.CreatedAt, &document.UpdatedAt)
+		WHERE reference_documents.id = ? AND reference_documents.lecture_id = ? AND `+examAccess(models.ExamRoleViewer)+`
+	`, documentID, lectureID, userID).Scan(&document.ID, &document.LectureID, &document.DocumentType, &document.Title, &document.FilePath, &document.PageCount, &document.ExtractionStatus, &document.ExtractionMethod, &extractionMetadata, &document.EstimatedCost, &document.SourceURL, &document.Language, &document.DetectedLanguage, &document.CreatedAt, &document.UpdatedAt)
 
 	if err == sql.ErrNoRows {
 		server.writeError(responseWriter, http.StatusNotFound, "NOT_FOUND", "Document not found in this lecture", nil)
@@ -76,10 +92,23 @@ func (server *Server) handleGetDocument(responseWriter http.ResponseWriter, requ
 		server.writeError(responseWriter, http.StatusInternalServerError, "DATABASE_ERROR", "Failed to get document", nil)
 		return
 	}
+	document.ExtractionMetadata = decodeExtractionMetadata(extractionMetadata)
 
 	server.writeJSON(responseWriter, http.StatusOK, document)
 }
 
+// decodeExtractionMetadata reads the stored extraction metadata of a document, nil until it has been extracted
+func decodeExtractionMetadata(extractionMetadata sql.NullString) *models.DocumentExtractionMetadata {
+	if !extractionMetadata.Valid || extractionMetadata.String == "" {
+		return nil
+	}
+	var metadata models.DocumentExtractionMetadata
+	if json.Unmarshal([]byte(extractionMetadata.String), &metadata) != nil {
+		return nil
+	}
+	return &metadata
+}
+
 // handleGetDocumentPages lists all pages for a document
 func (server *Server) handleGetDocumentPages(responseWriter http.ResponseWriter, request *http.Request) {
 	documentID := request.URL.Query().Get("document_id")
@@ -99,7 +128,7 @@ func (server *Server) handleGetDocumentPages(responseWriter http.ResponseWriter,
 			SELECT 1 FROM reference_documents 
 			JOIN lectures ON reference_documents.lecture_id = lectures.id
 			JOIN exams ON lectures.exam_id = exams.id
-			WHERE reference_documents.id = ? AND reference_documents.lecture_id = ? AND exams.user_id = ?
+			WHERE reference_documents.id = ? AND reference_documents.lecture_id = ? AND `+examAccess(models.ExamRoleViewer)+`
 		)
 	`, documentID, lectureID, userID).Scan(&exists)
 	if err != nil || !exists {
@@ -108,7 +137,7 @@ func (server *Server) handleGetDocumentPages(responseWriter http.ResponseWriter,
 	}
 
 	pageRows, databaseError := server.database.Query(`
-		SELECT id, document_id, page_number, image_path, extracted_text
+		SELECT id, document_id, page_number, image_path, extracted_text, metadata, COALESCE(extraction_source, ''), COALESCE(language, '')
 		FROM reference_pages
 		WHERE document_id = ?
 		ORDER BY page_number ASC
@@ -120,25 +149,34 @@ func (server *Server) handleGetDocumentPages(responseWriter http.ResponseWriter,
 	defer pageRows.Close()
 
 	type pageResponse struct {
-		ID            string `json:"id"`
-		DocumentID    string `json:"document_id"`
-		PageNumber    int    `json:"page_number"`
-		ImagePath     string `json:"image_path"`
-		ExtractedText string `json:"extracted_text"`
-		ExtractedHTML string `json:"extracted_html"`
+		ID               string                        `json:"id"`
+		DocumentID       string                        `json:"document_id"`
+		PageNumber       int                           `json:"page_number"`
+		ImagePath        string                        `json:"image_path"`
+		ExtractedText    string                        `json:"extracted_text"`
+		ExtractedHTML    string                        `json:"extracted_html"`
+		Metadata         *models.ReferencePageMetadata `json:"metadata,omitempty"`
+		ExtractionSource string                        `json:"extraction_source,omitempty"`
+		Language         string                        `json:"language,omitempty"`
 	}
 
 	var pages []pageResponse
 	for pageRows.Next() {
 		var page models.ReferencePage
-		var extractedText sql.NullString
-		if err := pageRows.Scan(&page.ID, &page.DocumentID, &page.PageNumber, &page.ImagePath, &extractedText); err != nil {
+		var extractedText, metadataJSON sql.NullString
+		if err := pageRows.Scan(&page.ID, &page.DocumentID, &page.PageNumber, &page.ImagePath, &extractedText, &metadataJSON, &page.ExtractionSource, &page.Language); err != nil {
 			continue
 		}
 
 		if extractedText.Valid {
 			page.ExtractedText = extractedText.String
 		}
+		if metadataJSON.Valid && metadataJSON.String != "" {
+			var metadata models.ReferencePageMetadata
+			if json.Unmarshal([]byte(metadataJSON.String), &metadata) == nil {
+				page.Metadata = &metadata
+			}
+		}
 
 		// Convert extracted text to HTML
 		htmlContent := page.ExtractedText
@@ -150,18 +188,74 @@ func (server *Server) handleGetDocumentPages(responseWriter http.ResponseWriter,
 		}
 
 		pages = append(pages, pageResponse{
-			ID:            strconv.Itoa(page.ID),
-			DocumentID:    page.DocumentID,
-			PageNumber:    page.PageNumber,
-			ImagePath:     page.ImagePath,
-			ExtractedText: page.ExtractedText,
-			ExtractedHTML: htmlContent,
+			ID:               strconv.Itoa(page.ID),
+			DocumentID:       page.DocumentID,
+			PageNumber:       page.PageNumber,
+			ImagePath:        page.ImagePath,
+			ExtractedText:    page.ExtractedText,
+			ExtractedHTML:    htmlContent,
+			Metadata:         page.Metadata,
+			ExtractionSource: page.ExtractionSource,
+			Language:         page.Language,
 		})
 	}
 
 	server.writeJSON(responseWriter, http.StatusOK, pages)
 }
 
+// handleGetDocumentChunks lists the semantic chunks the extracted text of a document was split into
+func (server *Server) handleGetDocumentChunks(responseWriter http.ResponseWriter, request *http.Request) {
+	documentID := request.URL.Query().Get("document_id")
+	lectureID := request.URL.Query().Get("lecture_id")
+
+	if documentID == "" || lectureID == "" {
+		server.writeError(responseWriter, http.StatusBadRequest, "VALIDATION_ERROR", "document_id and lecture_id are required", nil)
+		return
+	}
+
+	userID := server.getUserID(request)
+
+	// Verify document belongs to lecture and user
+	var exists bool
+	err := server.database.QueryRow(`
+		SELECT EXISTS(
+			SELECT 1 FROM reference_documents 
+			JOIN lectures ON reference_documents.lecture_id = lectures.id
+			JOIN exams ON lectures.exam_id = exams.id
+			WHERE reference_documents.id = ? AND reference_documents.lecture_id = ? AND `+examAccess(models.ExamRoleViewer)+`
+		)
+	`, documentID, lectureID, userID).Scan(&exists)
+	if err != nil || !exists {
+		server.writeError(responseWriter, http.StatusNotFound, "NOT_FOUND", "Document not found in this lecture", nil)
+		return
+	}
+
+	chunkRows, databaseError := server.database.Query(`
+		SELECT id, document_id, chunk_index, start_page, end_page, heading, content
+		FROM reference_chunks
+		WHERE document_id = ?
+		ORDER BY chunk_index ASC
+	`, documentID)
+	if databaseError != nil {
+		server.writeError(responseWriter, http.StatusInternalServerError, "DATABASE_ERROR", "Failed to list chunks", nil)
+		return
+	}
+	defer chunkRows.Close()
+
+	chunks := []models.ReferenceChunk{}
+	for chunkRows.Next() {
+		var chunk models.ReferenceChunk
+		var heading sql.NullString
+		if err := chunkRows.Scan(&chunk.ID, &chunk.DocumentID, &chunk.ChunkIndex, &chunk.StartPage, &chunk.EndPage, &heading, &chunk.Content); err != nil {
+			continue
+		}
+		chunk.Heading = heading.String
+		chunks = append(chunks, chunk)
+	}
+
+	server.writeJSON(responseWriter, http.StatusOK, chunks)
+}
+
 // handleGetPageImage serves the actual image file for a page
 func (server *Server) handleGetPageImage(responseWriter http.ResponseWriter, request *http.Request) {
 	documentID := request.URL.Query().Get("document_id")
@@ -177,10 +271,10 @@ func (server *Server) handleGetPageImage(responseWriter http.ResponseWriter, req
 
 	// Try multiple token sources with validation (cookie -> header -> query param)
 	// This handles cases where old cookies exist but the current session uses a different token
-	sessionToken := server.getValidSessionToken(request)
-	if sessionToken == "" {
+	sessionToken, passwordChangeRequired := server.getValidSessionToken(request)
+	if sessionToken == "" || passwordChangeRequired {
 		slog.Warn("Page image request without valid session token", "document_id", documentID, "lecture_id", lectureID)
-		server.writeError(responseWriter, http.StatusUnauthorized, "AUTHENTICATION_ERROR", "Authentication required", nil)
+		server.writeSessionError(responseWriter, passwordChangeRequired)
 		return
 	}
 
@@ -207,7 +301,7 @@ func (server *Server) handleGetPageImage(responseWriter http.ResponseWriter, req
 			SELECT 1 FROM reference_documents
 			JOIN lectures ON reference_documents.lecture_id = lectures.id
 			JOIN exams ON lectures.exam_id = exams.id
-			WHERE reference_documents.id = ? AND reference_documents.lecture_id = ? AND exams.user_id = ?
+			WHERE reference_documents.id = ? AND reference_documents.lecture_id = ? AND `+examAccess(models.ExamRoleViewer)+`
 		)
 	`, documentID, lectureID, userID).Scan(&exists)
 	if err != nil {
@@ -223,13 +317,13 @@ func (server *Server) handleGetPageImage(responseWriter http.ResponseWriter, req
 
 	pageNumber, _ := strconv.Atoi(pageNumberString)
 
-	var imagePath string
+	var imagePath, objectKey string
 	var imageData []byte
 	err = server.database.QueryRow(`
-		SELECT image_path, image_data
+		SELECT image_path, image_data, COALESCE(object_key, '')
 		FROM reference_pages
 		WHERE document_id = ? AND page_number = ?
-	`, documentID, pageNumber).Scan(&imagePath, &imageData)
+	`, documentID, pageNumber).Scan(&imagePath, &imageData, &objectKey)
 
 	if err == sql.ErrNoRows {
 		slog.Warn("Page not found in database", "document_id", documentID, "page_number", pageNumber)
@@ -244,7 +338,13 @@ func (server *Server) handleGetPageImage(responseWriter http.ResponseWriter, req
 
 	slog.Info("Page found", "document_id", documentID, "page_number", pageNumber, "image_path", imagePath, "image_data_size", len(imageData))
 
-	// Serve from DB BLOB if available (works after backup/restore)
+	// Page images in an object store that presigns URLs are downloaded from it directly
+	imageData, loaded := server.loadStoredContent(responseWriter, request, imageData, objectKey, fmt.Sprintf("page_%d.png", pageNumber), true)
+	if !loaded {
+		return
+	}
+
+	// Serve from DB BLOB or object store if available (works after backup/restore)
 	if len(imageData) > 0 {
 		slog.Info("Serving image from database BLOB", "document_id", documentID, "page_number", pageNumber, "size_bytes", len(imageData))
 		responseWriter.Header().Set("Content-Type", "image/png")
@@ -259,6 +359,163 @@ func (server *Server) handleGetPageImage(responseWriter http.ResponseWriter, req
 	server.writeError(responseWriter, http.StatusNotFound, "IMAGE_NOT_FOUND", "Page image not found. The image may not have been processed or stored correctly.", nil)
 }
 
+// handleCreateDocumentFromURL adds a webpage to a lecture as a reference document. The page is fetched, stripped
+// of its boilerplate and paginated by a background job, which records the URL as the document's source
+func (server *Server) handleCreateDocumentFromURL(responseWriter http.ResponseWriter, request *http.Request) {
+	var urlRequest struct {
+		LectureID string `json:"lecture_id"`
+		ExamID    string `json:"exam_id"`
+		URL       string `json:"url"`
+		Title     string `json:"title"` // Optional; defaults to the title of the page
+	}
+	if err := json.NewDecoder(request.Body).Decode(&urlRequest); err != nil {
+		server.writeError(responseWriter, http.StatusBadRequest, "VALIDATION_ERROR", "Invalid request body", nil)
+		return
+	}
+
+	if urlRequest.LectureID == "" || urlRequest.ExamID == "" || urlRequest.URL == "" {
+		server.writeError(responseWriter, http.StatusBadRequest, "VALIDATION_ERROR", "lecture_id, exam_id and url are required", nil)
+		return
+	}
+
+	pageURL, err := url.Parse(strings.TrimSpace(urlRequest.URL))
+	if err != nil || (pageURL.Scheme != "http" && pageURL.Scheme != "https") || pageURL.Host == "" {
+		server.writeError(responseWriter, http.StatusBadRequest, "VALIDATION_ERROR", "url must be an absolute http or https address", nil)
+		return
+	}
+	// Host names are checked again once resolved, when the page is fetched
+	if !server.configuration.Uploads.Documents.AllowPrivateNetworks && netguard.CheckURL(pageURL) != nil {
+		server.writeError(responseWriter, http.StatusBadRequest, "VALIDATION_ERROR", "url must not point to a private network address", nil)
+		return
+	}
+
+	userID := server.getUserID(request)
+
+	if !server.authorizeExam(responseWriter, request, urlRequest.ExamID, models.ExamRoleManager) {
+		return
+	}
+
+	var language string
+	err = server.database.QueryRow(`
+		SELECT COALESCE(lectures.language, '') FROM lectures
+		JOIN exams ON lectures.exam_id = exams.id
+		WHERE lectures.id = ? AND lectures.exam_id = ? AND `+examAccess(models.ExamRoleManager)+`
+	`, urlRequest.LectureID, urlRequest.ExamID, userID).Scan(&language)
+	if err == sql.ErrNoRows {
+		server.writeError(responseWriter, http.StatusNotFound, "NOT_FOUND", "Lecture not found", nil)
+		return
+	}
+	if err != nil {
+		server.writeError(responseWriter, http.StatusInternalServerError, "DATABASE_ERROR", "Failed to verify lecture", nil)
+		return
+	}
+
+	// The lecture is not ready until the new document is ingested
+	_, err = server.database.Exec("UPDATE lectures SET status = 'processing', updated_at = ? WHERE id = ?", time.Now(), urlRequest.LectureID)
+	if err != nil {
+		server.writeError(responseWriter, http.StatusInternalServerError, "DATABASE_ERROR", "Failed to update lecture status", nil)
+		return
+	}
+
+	jobID, err := server.jobQueue.Enqueue(userID, models.JobTypeIngestURL, map[string]string{
+		"lecture_id":    urlRequest.LectureID,
+		"url":           pageURL.String(),
+		"title":         strings.TrimSpace(urlRequest.Title),
+		"language_code": language,
+	}, urlRequest.ExamID, urlRequest.LectureID)
+	if err != nil {
+		server.writeEnqueueError(responseWriter, err, "Failed to enqueue webpage ingestion")
+		return
+	}
+
+	server.writeJSON(responseWriter, http.StatusAccepted, map[string]string{
+		"job_id":  jobID,
+		"message": "Webpage ingestion job created",
+	})
+}
+
+// handleUpdateDocumentExtraction sets how a document's pages are read, and optionally the language they are read
+// in, and extracts it again
+func (server *Server) handleUpdateDocumentExtraction(responseWriter http.ResponseWriter, request *http.Request) {
+	var updateRequest struct {
+		DocumentID       string  `json:"document_id"`
+		LectureID        string  `json:"lecture_id"`
+		ExtractionMethod string  `json:"extraction_method"` // "vision", "ocr", "auto" or "math"; empty follows documents.extraction_method
+		Language         *string `json:"language"`          // Language code of the document; empty detects it again, absent keeps it
+	}
+	if err := json.NewDecoder(request.Body).Decode(&updateRequest); err != nil {
+		server.writeError(responseWriter, http.StatusBadRequest, "VALIDATION_ERROR", "Invalid request body", nil)
+		return
+	}
+
+	if updateRequest.DocumentID == "" || updateRequest.LectureID == "" {
+		server.writeError(responseWriter, http.StatusBadRequest, "VALIDATION_ERROR", "document_id and lecture_id are required", nil)
+		return
+	}
+	if updateRequest.ExtractionMethod != "" && !documents.IsExtractionMethod(updateRequest.ExtractionMethod) {
+		server.writeError(responseWriter, http.StatusBadRequest, "VALIDATION_ERROR", "extraction_method must be vision, ocr, auto or math", nil)
+		return
+	}
+	if updateRequest.Language != nil && *updateRequest.Language != "" && !bcp47Regex.MatchString(*updateRequest.Language) {
+		server.writeError(responseWriter, http.StatusBadRequest, "VALIDATION_ERROR", "Invalid language format (BCP-47 required)", nil)
+		return
+	}
+
+	userID := server.getUserID(request)
+
+	if _, authorized := server.authorizeLecture(responseWriter, request, updateRequest.LectureID, models.ExamRoleManager); !authorized {
+		return
+	}
+
+	var examID, extractionStatus, language string
+	err := server.database.QueryRow(`
+		SELECT lectures.exam_id, reference_documents.extraction_status, COALESCE(lectures.language, '') FROM reference_documents
+		JOIN lectures ON reference_documents.lecture_id = lectures.id
+		JOIN exams ON lectures.exam_id = exams.id
+		WHERE reference_documents.id = ? AND reference_documents.lecture_id = ? AND `+examAccess(models.ExamRoleManager)+`
+	`, updateRequest.DocumentID, updateRequest.LectureID, userID).Scan(&examID, &extractionStatus, &language)
+	if err == sql.ErrNoRows {
+		server.writeError(responseWriter, http.StatusNotFound, "NOT_FOUND", "Document not found", nil)
+		return
+	}
+	if err != nil {
+		server.writeError(responseWriter, http.StatusInternalServerError, "DATABASE_ERROR", "Failed to verify document", nil)
+		return
+	}
+	if extractionStatus == "processing" || extractionStatus == "pending" {
+		server.writeError(responseWriter, http.StatusConflict, "DOCUMENT_NOT_READY", "The document is still being extracted", nil)
+		return
+	}
+
+	// The lecture is not ready until the document is extracted again
+	_, err = server.database.Exec("UPDATE reference_documents SET extraction_method = NULLIF(?, ''), extraction_status = 'pending', updated_at = ? WHERE id = ?", updateRequest.ExtractionMethod, time.Now(), updateRequest.DocumentID)
+	if err == nil && updateRequest.Language != nil {
+		_, err = server.database.Exec("UPDATE reference_documents SET language = NULLIF(?, '') WHERE id = ?", *updateRequest.Language, updateRequest.DocumentID)
+	}
+	if err == nil {
+		_, err = server.database.Exec("UPDATE lectures SET status = 'processing', updated_at = ? WHERE id = ?", time.Now(), updateRequest.LectureID)
+	}
+	if err != nil {
+		server.writeError(responseWriter, http.StatusInternalServerError, "DATABASE_ERROR", "Failed to update document", nil)
+		return
+	}
+
+	jobID, err := server.jobQueue.Enqueue(userID, models.JobTypeIngestDocuments, map[string]string{
+		"lecture_id":    updateRequest.LectureID,
+		"document_id":   updateRequest.DocumentID,
+		"language_code": language,
+	}, examID, updateRequest.LectureID)
+	if err != nil {
+		server.writeEnqueueError(responseWriter, err, "Failed to enqueue document extraction")
+		return
+	}
+
+	server.writeJSON(responseWriter, http.StatusAccepted, map[string]string{
+		"job_id":  jobID,
+		"message": "Document extraction job created",
+	})
+}
+
 // handleDeleteDocument deletes a specific reference document and its files
 func (server *Server) handleDeleteDocument(responseWriter http.ResponseWriter, request *http.Request) {
 	var deleteRequest struct {
@@ -277,14 +534,19 @@ func (server *Server) handleDeleteDocument(responseWriter http.ResponseWriter, r
 
 	userID := server.getUserID(request)
 
+	examID, authorized := server.authorizeLecture(responseWriter, request, deleteRequest.LectureID, models.ExamRoleManager)
+	if !authorized {
+		return
+	}
+
 	// Get file path and verify ownership
-	var filePath string
+	var filePath, title string
 	err := server.database.QueryRow(`
-		SELECT reference_documents.file_path FROM reference_documents 
+		SELECT reference_documents.file_path, reference_documents.title FROM reference_documents 
 		JOIN lectures ON reference_documents.lecture_id = lectures.id
 		JOIN exams ON lectures.exam_id = exams.id
-		WHERE reference_documents.id = ? AND reference_documents.lecture_id = ? AND exams.user_id = ?
-	`, deleteRequest.DocumentID, deleteRequest.LectureID, userID).Scan(&filePath)
+		WHERE reference_documents.id = ? AND reference_documents.lecture_id = ? AND `+examAccess(models.ExamRoleManager)+`
+	`, deleteRequest.DocumentID, deleteRequest.LectureID, userID).Scan(&filePath, &title)
 
 	if err == sql.ErrNoRows {
 		server.writeError(responseWriter, http.StatusNotFound, "NOT_FOUND", "Document not found", nil)
@@ -302,6 +564,15 @@ func (server *Server) handleDeleteDocument(responseWriter http.ResponseWriter, r
 		return
 	}
 
+	server.recordEvent(request, models.ResourceEvent{
+		ExamID:       examID,
+		LectureID:    deleteRequest.LectureID,
+		ResourceType: models.ResourceTypeDocument,
+		ResourceID:   deleteRequest.DocumentID,
+		Action:       models.ResourceActionRemoved,
+		Summary:      fmt.Sprintf("Removed the document \"%s\"", title),
+	})
+
 	server.writeJSON(responseWriter, http.StatusOK, map[string]string{"message": "Document deleted successfully"})
 }
 
@@ -324,7 +595,7 @@ func (server *Server) handleGetPageHTML(responseWriter http.ResponseWriter, requ
 		SELECT reference_documents.title FROM reference_documents 
 		JOIN lectures ON reference_documents.lecture_id = lectures.id
 		JOIN exams ON lectures.exam_id = exams.id
-		WHERE reference_documents.id = ? AND reference_documents.lecture_id = ? AND exams.user_id = ?
+		WHERE reference_documents.id = ? AND reference_documents.lecture_id = ? AND `+examAccess(models.ExamRoleViewer)+`
 	`, documentID, lectureID, userID).Scan(&docTitle)
 
 	if err == sql.ErrNoRows {
@@ -0,0 +1,123 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"lectures/internal/models"
+)
+
+func TestHandleCreateDocumentFromURL(t *testing.T) {
+	server, userID, sessionID, cleanup := setupUniqueExtraTestEnv(t, "fromurl")
+	defer cleanup()
+
+	pageServer := httptest.NewServer(http.NotFoundHandler())
+	defer pageServer.Close()
+
+	server.database.Exec("INSERT INTO exams (id, user_id, title) VALUES ('url-exam', ?, 'History')", userID)
+	server.database.Exec("INSERT INTO lectures (id, exam_id, title, status) VALUES ('url-lecture', 'url-exam', 'Rome', 'ready')")
+
+	sendRequest := func(body map[string]string) *httptest.ResponseRecorder {
+		encodedBody, _ := json.Marshal(body)
+		req := httptest.NewRequest("POST", "/api/lectures/documents/from-url", bytes.NewBuffer(encodedBody))
+		req.Header.Set("Authorization", "Bearer "+sessionID)
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("X-Requested-With", "XMLHttpRequest")
+		rr := httptest.NewRecorder()
+		server.Handler().ServeHTTP(rr, req)
+		return rr
+	}
+
+	for _, invalidURL := range []string{"", "ftp://example.com/notes", "/relative/page", "https://"} {
+		if rr := sendRequest(map[string]string{"lecture_id": "url-lecture", "exam_id": "url-exam", "url": invalidURL}); rr.Code != http.StatusBadRequest {
+			t.Errorf("Expected status 400 for url %q, got %d", invalidURL, rr.Code)
+		}
+	}
+	if rr := sendRequest(map[string]string{"lecture_id": "url-lecture", "exam_id": "other-exam", "url": "https://example.edu/empire"}); rr.Code != http.StatusNotFound {
+		t.Errorf("Expected status 404 for a lecture outside the exam, got %d", rr.Code)
+	}
+
+	if rr := sendRequest(map[string]string{"lecture_id": "url-lecture", "exam_id": "url-exam", "url": pageServer.URL + "/empire"}); rr.Code != http.StatusBadRequest {
+		t.Errorf("Expected status 400 for a loopback page, got %d", rr.Code)
+	}
+
+	server.configuration.Uploads.Documents.AllowPrivateNetworks = true
+	rr := sendRequest(map[string]string{"lecture_id": "url-lecture", "exam_id": "url-exam", "url": pageServer.URL + "/empire"})
+	if rr.Code != http.StatusAccepted {
+		t.Fatalf("Expected status 202, got %d. Body: %s", rr.Code, rr.Body.String())
+	}
+
+	var jobType, payload string
+	if err := server.database.QueryRow("SELECT type, payload FROM jobs WHERE lecture_id = 'url-lecture'").Scan(&jobType, &payload); err != nil {
+		t.Fatalf("Expected an ingestion job: %v", err)
+	}
+	if jobType != models.JobTypeIngestURL || !strings.Contains(payload, pageServer.URL+"/empire") {
+		t.Errorf("Unexpected job %s with payload %s", jobType, payload)
+	}
+}
+
+func TestHandleUpdateDocumentExtraction(t *testing.T) {
+	server, userID, sessionID, cleanup := setupUniqueExtraTestEnv(t, "extraction")
+	defer cleanup()
+
+	server.database.Exec("INSERT INTO exams (id, user_id, title) VALUES ('ocr-exam', ?, 'Archives')", userID)
+	server.database.Exec("INSERT INTO lectures (id, exam_id, title, status, language) VALUES ('ocr-lecture', 'ocr-exam', 'Scans', 'ready', 'it')")
+	server.database.Exec("INSERT INTO reference_documents (id, lecture_id, document_type, title, file_path, page_count, extraction_status, extraction_metadata) VALUES ('scan', 'ocr-lecture', 'pdf', 'Scan', 'scan.pdf', 2, 'completed', '{\"method\":\"ocr\",\"ocr_pages\":2,\"reason\":\"OCR was requested\"}')")
+	server.database.Exec("INSERT INTO reference_documents (id, lecture_id, document_type, title, file_path, page_count, extraction_status) VALUES ('busy', 'ocr-lecture', 'pdf', 'Busy', 'busy.pdf', 0, 'processing')")
+
+	sendRequest := func(body map[string]string) *httptest.ResponseRecorder {
+		encodedBody, _ := json.Marshal(body)
+		req := httptest.NewRequest("PATCH", "/api/documents", bytes.NewBuffer(encodedBody))
+		req.Header.Set("Authorization", "Bearer "+sessionID)
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("X-Requested-With", "XMLHttpRequest")
+		rr := httptest.NewRecorder()
+		server.Handler().ServeHTTP(rr, req)
+		return rr
+	}
+
+	// The stored metadata is returned with the document
+	req := httptest.NewRequest("GET", "/api/documents/details?document_id=scan&lecture_id=ocr-lecture", nil)
+	req.Header.Set("Authorization", "Bearer "+sessionID)
+	rr := httptest.NewRecorder()
+	server.Handler().ServeHTTP(rr, req)
+	var documentResponse struct {
+		Data models.ReferenceDocument `json:"data"`
+	}
+	json.Unmarshal(rr.Body.Bytes(), &documentResponse)
+	if metadata := documentResponse.Data.ExtractionMetadata; metadata == nil || metadata.OCRPages != 2 || metadata.Reason != "OCR was requested" {
+		t.Errorf("Expected the extraction metadata in the document details, got %s", rr.Body.String())
+	}
+
+	if rr := sendRequest(map[string]string{"document_id": "scan", "lecture_id": "ocr-lecture", "extraction_method": "tesseract"}); rr.Code != http.StatusBadRequest {
+		t.Errorf("Expected status 400 for an unknown method, got %d", rr.Code)
+	}
+	if rr := sendRequest(map[string]string{"document_id": "scan", "lecture_id": "ocr-lecture", "language": "German"}); rr.Code != http.StatusBadRequest {
+		t.Errorf("Expected status 400 for a language that is not a language code, got %d", rr.Code)
+	}
+	if rr := sendRequest(map[string]string{"document_id": "busy", "lecture_id": "ocr-lecture", "extraction_method": "ocr"}); rr.Code != http.StatusConflict {
+		t.Errorf("Expected status 409 for a document being extracted, got %d", rr.Code)
+	}
+
+	rr = sendRequest(map[string]string{"document_id": "scan", "lecture_id": "ocr-lecture", "extraction_method": "auto", "language": "de"})
+	if rr.Code != http.StatusAccepted {
+		t.Fatalf("Expected status 202, got %d. Body: %s", rr.Code, rr.Body.String())
+	}
+
+	var extractionMethod, extractionStatus, documentLanguage string
+	server.database.QueryRow("SELECT extraction_method, extraction_status, language FROM reference_documents WHERE id = 'scan'").Scan(&extractionMethod, &extractionStatus, &documentLanguage)
+	if extractionMethod != "auto" || extractionStatus != "pending" || documentLanguage != "de" {
+		t.Errorf("Expected the method and language to be stored and the document to be pending, got %s, %s and %s", extractionMethod, documentLanguage, extractionStatus)
+	}
+	var jobType, payload string
+	if err := server.database.QueryRow("SELECT type, payload FROM jobs WHERE lecture_id = 'ocr-lecture'").Scan(&jobType, &payload); err != nil {
+		t.Fatalf("Expected an ingestion job: %v", err)
+	}
+	if jobType != models.JobTypeIngestDocuments || !strings.Contains(payload, `"document_id":"scan"`) || !strings.Contains(payload, `"language_code":"it"`) {
+		t.Errorf("Unexpected job %s with payload %s", jobType, payload)
+	}
+}
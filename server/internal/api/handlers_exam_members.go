@@ -0,0 +1,161 @@
+package api
+
+import (
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"time"
+
+	"lectures/internal/models"
+)
+
+// handleListExamMembers lists the owner of an exam followed by the users it is shared with
+func (server *Server) handleListExamMembers(responseWriter http.ResponseWriter, request *http.Request) {
+	examID := request.URL.Query().Get("exam_id")
+	if examID == "" {
+		server.writeError(responseWriter, http.StatusBadRequest, "VALIDATION_ERROR", "exam_id is required", nil)
+		return
+	}
+
+	if !server.authorizeExam(responseWriter, request, examID, models.ExamRoleViewer) {
+		return
+	}
+
+	owner := models.ExamMember{Role: models.ExamRoleOwner}
+	err := server.database.QueryRow(`
+		SELECT users.id, users.username, exams.created_at
+		FROM exams
+		JOIN users ON exams.user_id = users.id
+		WHERE exams.id = ?
+	`, examID).Scan(&owner.UserID, &owner.Username, &owner.CreatedAt)
+	if err != nil {
+		server.writeError(responseWriter, http.StatusInternalServerError, "DATABASE_ERROR", "Failed to get exam owner", nil)
+		return
+	}
+
+	memberRows, err := server.database.Query(`
+		SELECT users.id, users.username, exam_members.role, exam_members.created_at
+		FROM exam_members
+		JOIN users ON exam_members.user_id = users.id
+		WHERE exam_members.exam_id = ?
+		ORDER BY exam_members.created_at, users.username
+	`, examID)
+	if err != nil {
+		server.writeError(responseWriter, http.StatusInternalServerError, "DATABASE_ERROR", "Failed to list exam members", nil)
+		return
+	}
+	defer memberRows.Close()
+
+	members := []models.ExamMember{owner}
+	for memberRows.Next() {
+		var member models.ExamMember
+		if err := memberRows.Scan(&member.UserID, &member.Username, &member.Role, &member.CreatedAt); err != nil {
+			server.writeError(responseWriter, http.StatusInternalServerError, "DATABASE_ERROR", "Failed to scan exam member", nil)
+			return
+		}
+		members = append(members, member)
+	}
+
+	server.writeJSON(responseWriter, http.StatusOK, members)
+}
+
+// handleSetExamMember shares an exam with a user, found by username, or changes the role they have on it
+func (server *Server) handleSetExamMember(responseWriter http.ResponseWriter, request *http.Request) {
+	var memberRequest struct {
+		ExamID   string `json:"exam_id"`
+		Username string `json:"username"`
+		Role     string `json:"role"`
+	}
+	if err := json.NewDecoder(request.Body).Decode(&memberRequest); err != nil {
+		server.writeError(responseWriter, http.StatusBadRequest, "VALIDATION_ERROR", "Invalid body", nil)
+		return
+	}
+
+	memberRequest.Username = strings.TrimSpace(memberRequest.Username)
+	if memberRequest.ExamID == "" || memberRequest.Username == "" {
+		server.writeError(responseWriter, http.StatusBadRequest, "VALIDATION_ERROR", "exam_id and username are required", nil)
+		return
+	}
+	switch memberRequest.Role {
+	case models.ExamRoleViewer, models.ExamRoleGenerator, models.ExamRoleManager:
+	default:
+		server.writeError(responseWriter, http.StatusBadRequest, "VALIDATION_ERROR", "role must be one of: viewer, generator, manager", nil)
+		return
+	}
+
+	if !server.authorizeExam(responseWriter, request, memberRequest.ExamID, models.ExamRoleManager) {
+		return
+	}
+
+	member := models.ExamMember{Username: memberRequest.Username, Role: memberRequest.Role, CreatedAt: time.Now()}
+	err := server.database.QueryRow("SELECT id FROM users WHERE username = ?", memberRequest.Username).Scan(&member.UserID)
+	if err == sql.ErrNoRows {
+		server.writeError(responseWriter, http.StatusNotFound, "NOT_FOUND", "User not found", nil)
+		return
+	}
+	if err != nil {
+		server.writeError(responseWriter, http.StatusInternalServerError, "DATABASE_ERROR", "Failed to find user", nil)
+		return
+	}
+
+	var isOwner bool
+	server.database.QueryRow("SELECT EXISTS(SELECT 1 FROM exams WHERE id = ? AND user_id = ?)", memberRequest.ExamID, member.UserID).Scan(&isOwner)
+	if isOwner {
+		server.writeError(responseWriter, http.StatusBadRequest, "VALIDATION_ERROR", "The owner of an exam cannot be given a role on it", nil)
+		return
+	}
+
+	// Changing a role keeps the date the exam was first shared
+	_, err = server.database.Exec(`
+		INSERT INTO exam_members (exam_id, user_id, role, created_at) VALUES (?, ?, ?, ?)
+		ON CONFLICT(exam_id, user_id) DO UPDATE SET role = excluded.role
+	`, memberRequest.ExamID, member.UserID, member.Role, member.CreatedAt)
+	if err != nil {
+		server.writeError(responseWriter, http.StatusInternalServerError, "DATABASE_ERROR", "Failed to share exam", nil)
+		return
+	}
+	server.database.QueryRow("SELECT created_at FROM exam_members WHERE exam_id = ? AND user_id = ?", memberRequest.ExamID, member.UserID).Scan(&member.CreatedAt)
+
+	server.writeJSON(responseWriter, http.StatusOK, member)
+}
+
+// handleDeleteExamMember stops sharing an exam with a user. Managers remove anyone but the owner, and every
+// member can leave an exam shared with them
+func (server *Server) handleDeleteExamMember(responseWriter http.ResponseWriter, request *http.Request) {
+	var deleteRequest struct {
+		ExamID string `json:"exam_id"`
+		UserID string `json:"user_id"`
+	}
+	if err := json.NewDecoder(request.Body).Decode(&deleteRequest); err != nil {
+		server.writeError(responseWriter, http.StatusBadRequest, "VALIDATION_ERROR", "Invalid body", nil)
+		return
+	}
+
+	if deleteRequest.ExamID == "" || deleteRequest.UserID == "" {
+		server.writeError(responseWriter, http.StatusBadRequest, "VALIDATION_ERROR", "exam_id and user_id are required", nil)
+		return
+	}
+
+	minimumRole := models.ExamRoleManager
+	if deleteRequest.UserID == server.getUserID(request) {
+		minimumRole = models.ExamRoleViewer
+	}
+	if !server.authorizeExam(responseWriter, request, deleteRequest.ExamID, minimumRole) {
+		return
+	}
+
+	result, err := server.database.Exec("DELETE FROM exam_members WHERE exam_id = ? AND user_id = ?", deleteRequest.ExamID, deleteRequest.UserID)
+	if err != nil {
+		server.writeError(responseWriter, http.StatusInternalServerError, "DATABASE_ERROR", "Failed to remove exam member", nil)
+		return
+	}
+
+	rowsAffected, _ := result.RowsAffected()
+	if rowsAffected == 0 {
+		server.writeError(responseWriter, http.StatusNotFound, "NOT_FOUND", "Exam member not found", nil)
+		return
+	}
+
+	server.writeJSON(responseWriter, http.StatusOK, map[string]string{"message": "Exam member removed successfully"})
+}
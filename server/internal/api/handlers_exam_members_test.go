@@ -0,0 +1,189 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"lectures/internal/models"
+
+	gonanoid "github.com/matoous/go-nanoid/v2"
+)
+
+func TestHandleExamMembers(t *testing.T) {
+	server, userID, sessionID, cleanup := setupUniqueExtraTestEnv(t, "exam_members")
+	defer cleanup()
+
+	memberSessionID := gonanoid.Must()
+	server.database.Exec("INSERT INTO users (id, username, password_hash, role) VALUES ('member-user', 'member', 'x', 'teacher')")
+	server.database.Exec("INSERT INTO auth_sessions (id, user_id, created_at, last_activity, expires_at) VALUES (?, ?, ?, ?, ?)", memberSessionID, "member-user", time.Now(), time.Now(), time.Now().Add(1*time.Hour))
+	// Chats stay private and members pay for their own jobs, the collaboration being tested on its own
+	server.database.Exec(`INSERT INTO exams (id, user_id, title, collaboration) VALUES ('shared-exam', ?, 'Shared Exam', '{"shared_chat_sessions": false, "owner_pays_jobs": false}')`, userID)
+
+	sendRequest := func(session string, method string, target string, body map[string]any) *httptest.ResponseRecorder {
+		encodedBody, _ := json.Marshal(body)
+		req := httptest.NewRequest(method, target, bytes.NewBuffer(encodedBody))
+		req.Header.Set("Authorization", "Bearer "+session)
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("X-Requested-With", "XMLHttpRequest")
+		rr := httptest.NewRecorder()
+		server.Handler().ServeHTTP(rr, req)
+		return rr
+	}
+	shareAs := func(role string) {
+		rr := sendRequest(sessionID, "PUT", "/api/exams/members", map[string]any{"exam_id": "shared-exam", "username": "member", "role": role})
+		if rr.Code != http.StatusOK {
+			t.Fatalf("Expected status 200 sharing the exam as %s, got %d: %s", role, rr.Code, rr.Body.String())
+		}
+	}
+
+	if rr := sendRequest(memberSessionID, "GET", "/api/exams/details?exam_id=shared-exam", nil); rr.Code != http.StatusNotFound {
+		t.Errorf("Expected status 404 before the exam is shared, got %d", rr.Code)
+	}
+	if rr := sendRequest(memberSessionID, "PUT", "/api/exams/members", map[string]any{"exam_id": "shared-exam", "username": "member", "role": "manager"}); rr.Code != http.StatusNotFound {
+		t.Errorf("Expected status 404 sharing an exam of someone else, got %d", rr.Code)
+	}
+	if rr := sendRequest(sessionID, "PUT", "/api/exams/members", map[string]any{"exam_id": "shared-exam", "username": "userexam_members", "role": "viewer"}); rr.Code != http.StatusBadRequest {
+		t.Errorf("Expected status 400 giving the owner a role, got %d", rr.Code)
+	}
+
+	t.Run("Viewer reads but cannot generate or edit", func(t *testing.T) {
+		shareAs(models.ExamRoleViewer)
+
+		rr := sendRequest(memberSessionID, "GET", "/api/exams", nil)
+		if !strings.Contains(rr.Body.String(), "shared-exam") || !strings.Contains(rr.Body.String(), `"role": "viewer"`) {
+			t.Errorf("Expected the shared exam listed with the viewer role, got %s", rr.Body.String())
+		}
+		rr = sendRequest(memberSessionID, "POST", "/api/exams/suggest", map[string]any{"exam_id": "shared-exam"})
+		if rr.Code != http.StatusForbidden || !strings.Contains(rr.Body.String(), "generator") {
+			t.Errorf("Expected status 403 requiring the generator role, got %d: %s", rr.Code, rr.Body.String())
+		}
+		if rr := sendRequest(memberSessionID, "PATCH", "/api/exams", map[string]any{"exam_id": "shared-exam", "metadata_fields": []any{}}); rr.Code != http.StatusForbidden {
+			t.Errorf("Expected status 403 editing as a viewer, got %d", rr.Code)
+		}
+	})
+
+	t.Run("Generator queues jobs and keeps its chats private", func(t *testing.T) {
+		shareAs(models.ExamRoleGenerator)
+
+		if rr := sendRequest(memberSessionID, "POST", "/api/exams/suggest", map[string]any{"exam_id": "shared-exam"}); rr.Code != http.StatusAccepted {
+			t.Errorf("Expected status 202 queuing a job as a generator, got %d: %s", rr.Code, rr.Body.String())
+		}
+		var jobUserID string
+		server.database.QueryRow("SELECT user_id FROM jobs WHERE course_id = 'shared-exam'").Scan(&jobUserID)
+		if jobUserID != "member-user" {
+			t.Errorf("Expected the job charged to the generator, got %q", jobUserID)
+		}
+
+		rr := sendRequest(memberSessionID, "POST", "/api/chat/sessions", map[string]any{"exam_id": "shared-exam", "title": "Member chat"})
+		if rr.Code != http.StatusCreated {
+			t.Fatalf("Expected status 201 starting a chat, got %d: %s", rr.Code, rr.Body.String())
+		}
+		if rr := sendRequest(sessionID, "GET", "/api/chat/sessions?exam_id=shared-exam", nil); strings.Contains(rr.Body.String(), "Member chat") {
+			t.Errorf("Expected the chat of the member hidden from the owner, got %s", rr.Body.String())
+		}
+		if rr := sendRequest(memberSessionID, "GET", "/api/chat/sessions?exam_id=shared-exam", nil); !strings.Contains(rr.Body.String(), "Member chat") {
+			t.Errorf("Expected the member to see their chat, got %s", rr.Body.String())
+		}
+		if rr := sendRequest(memberSessionID, "DELETE", "/api/exams", map[string]any{"exam_id": "shared-exam"}); rr.Code != http.StatusForbidden {
+			t.Errorf("Expected status 403 deleting as a generator, got %d", rr.Code)
+		}
+	})
+
+	t.Run("Manager edits and a member can leave", func(t *testing.T) {
+		shareAs(models.ExamRoleManager)
+
+		if rr := sendRequest(memberSessionID, "PATCH", "/api/exams", map[string]any{"exam_id": "shared-exam", "metadata_fields": []any{}}); rr.Code != http.StatusOK {
+			t.Errorf("Expected status 200 editing as a manager, got %d: %s", rr.Code, rr.Body.String())
+		}
+		rr := sendRequest(memberSessionID, "GET", "/api/exams/members?exam_id=shared-exam", nil)
+		if !strings.Contains(rr.Body.String(), `"role": "owner"`) || !strings.Contains(rr.Body.String(), `"role": "manager"`) {
+			t.Errorf("Expected the owner and the manager listed, got %s", rr.Body.String())
+		}
+
+		if rr := sendRequest(memberSessionID, "DELETE", "/api/exams/members", map[string]any{"exam_id": "shared-exam", "user_id": "member-user"}); rr.Code != http.StatusOK {
+			t.Errorf("Expected status 200 leaving the exam, got %d", rr.Code)
+		}
+		if rr := sendRequest(memberSessionID, "GET", "/api/exams/details?exam_id=shared-exam", nil); rr.Code != http.StatusNotFound {
+			t.Errorf("Expected status 404 after leaving the exam, got %d", rr.Code)
+		}
+	})
+}
+
+func TestHandleExamCollaboration(t *testing.T) {
+	server, userID, sessionID, cleanup := setupUniqueExtraTestEnv(t, "exam_collaboration")
+	defer cleanup()
+
+	memberSessionID := gonanoid.Must()
+	server.database.Exec("INSERT INTO users (id, username, password_hash, role) VALUES ('member-user', 'member', 'x', 'teacher')")
+	server.database.Exec("INSERT INTO auth_sessions (id, user_id, created_at, last_activity, expires_at) VALUES (?, ?, ?, ?, ?)", memberSessionID, "member-user", time.Now(), time.Now(), time.Now().Add(1*time.Hour))
+	server.database.Exec("INSERT INTO exams (id, user_id, title) VALUES ('shared-exam', ?, 'Shared Exam')", userID)
+	server.database.Exec("INSERT INTO exam_members (exam_id, user_id, role) VALUES ('shared-exam', 'member-user', 'manager')")
+
+	sendRequest := func(session string, method string, target string, body map[string]any) *httptest.ResponseRecorder {
+		encodedBody, _ := json.Marshal(body)
+		req := httptest.NewRequest(method, target, bytes.NewBuffer(encodedBody))
+		req.Header.Set("Authorization", "Bearer "+session)
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("X-Requested-With", "XMLHttpRequest")
+		rr := httptest.NewRecorder()
+		server.Handler().ServeHTTP(rr, req)
+		return rr
+	}
+
+	rr := sendRequest(memberSessionID, "POST", "/api/chat/sessions", map[string]any{"exam_id": "shared-exam", "title": "Member chat"})
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("Expected status 201 starting a chat, got %d: %s", rr.Code, rr.Body.String())
+	}
+	var created struct {
+		Data models.ChatSession `json:"data"`
+	}
+	json.Unmarshal(rr.Body.Bytes(), &created)
+
+	// Exams share their chats and charge the jobs of members to the owner unless the owner opts out
+	rr = sendRequest(sessionID, "GET", "/api/chat/sessions?exam_id=shared-exam", nil)
+	if !strings.Contains(rr.Body.String(), "Member chat") || !strings.Contains(rr.Body.String(), `"user_id": "member-user"`) {
+		t.Errorf("Expected the shared chat of the member listed with its starter, got %s", rr.Body.String())
+	}
+	if rr := sendRequest(sessionID, "GET", "/api/chat/sessions/details?exam_id=shared-exam&session_id="+created.Data.ID, nil); rr.Code != http.StatusOK {
+		t.Errorf("Expected status 200 reading a shared chat, got %d: %s", rr.Code, rr.Body.String())
+	}
+	if rr := sendRequest(sessionID, "DELETE", "/api/chat/sessions", map[string]any{"exam_id": "shared-exam", "session_id": created.Data.ID}); rr.Code != http.StatusNotFound {
+		t.Errorf("Expected status 404 deleting the chat of another member, got %d", rr.Code)
+	}
+
+	if rr := sendRequest(memberSessionID, "POST", "/api/exams/suggest", map[string]any{"exam_id": "shared-exam"}); rr.Code != http.StatusAccepted {
+		t.Fatalf("Expected status 202 queuing a job as a member, got %d: %s", rr.Code, rr.Body.String())
+	}
+	var jobUserID, billedUserID string
+	server.database.QueryRow("SELECT user_id, billed_user_id FROM jobs WHERE course_id = 'shared-exam'").Scan(&jobUserID, &billedUserID)
+	if jobUserID != "member-user" || billedUserID != userID {
+		t.Errorf("Expected the job of the member billed to the owner, got %q billed to %q", jobUserID, billedUserID)
+	}
+	// Usage counts the job for the owner who pays for it, not for the member who queued it
+	if rr := sendRequest(sessionID, "GET", "/api/usage?group_by=exam", nil); !strings.Contains(rr.Body.String(), `"jobs": 1`) {
+		t.Errorf("Expected the job of the member in the usage of the owner, got %s", rr.Body.String())
+	}
+	if rr := sendRequest(memberSessionID, "GET", "/api/usage?group_by=exam", nil); strings.Contains(rr.Body.String(), `"jobs": 1`) {
+		t.Errorf("Expected the job paid by the owner left out of the usage of the member, got %s", rr.Body.String())
+	}
+
+	collaboration := map[string]any{"shared_chat_sessions": false, "owner_pays_jobs": false}
+	if rr := sendRequest(memberSessionID, "PATCH", "/api/exams", map[string]any{"exam_id": "shared-exam", "collaboration": collaboration}); rr.Code != http.StatusForbidden {
+		t.Errorf("Expected status 403 changing the collaboration as a manager, got %d", rr.Code)
+	}
+	rr = sendRequest(sessionID, "PATCH", "/api/exams", map[string]any{"exam_id": "shared-exam", "collaboration": collaboration})
+	if rr.Code != http.StatusOK || !strings.Contains(rr.Body.String(), `"owner_pays_jobs": false`) {
+		t.Fatalf("Expected status 200 with the collaboration, got %d: %s", rr.Code, rr.Body.String())
+	}
+	if rr := sendRequest(sessionID, "GET", "/api/chat/sessions?exam_id=shared-exam", nil); strings.Contains(rr.Body.String(), "Member chat") {
+		t.Errorf("Expected the chat of the member private once sharing is off, got %s", rr.Body.String())
+	}
+	if billedUserID := server.jobQueue.BilledUserID("member-user", "shared-exam"); billedUserID != "member-user" {
+		t.Errorf("Expected jobs of members charged to themselves once the owner stops paying, got %q", billedUserID)
+	}
+}
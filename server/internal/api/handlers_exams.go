@@ -3,10 +3,12 @@ package api
 import (
 	"database/sql"
 	"encoding/json"
+	"fmt"
 	"log/slog"
 	"net/http"
 	"time"
 
+	"lectures/internal/database"
 	"lectures/internal/models"
 
 	gonanoid "github.com/matoous/go-nanoid/v2"
@@ -15,10 +17,14 @@ import (
 // handleCreateExam creates a new exam
 func (server *Server) handleCreateExam(responseWriter http.ResponseWriter, request *http.Request) {
 	var createExamRequest struct {
-		Title       string `json:"title"`
-		Description string `json:"description"`
-		Language    string `json:"language"`
+		Title              string                        `json:"title"`
+		Description        string                        `json:"description"`
+		Language           string                        `json:"language"`
+		GenerationDefaults models.ExamGenerationDefaults `json:"generation_defaults"`
+		Collaboration      models.ExamCollaboration      `json:"collaboration"`
+		MetadataFields     []models.MetadataField        `json:"metadata_fields"`
 	}
+	createExamRequest.Collaboration = models.DefaultExamCollaboration()
 
 	if err := json.NewDecoder(request.Body).Decode(&createExamRequest); err != nil {
 		server.writeError(responseWriter, http.StatusBadRequest, "VALIDATION_ERROR", "Invalid request body", nil)
@@ -30,8 +36,23 @@ func (server *Server) handleCreateExam(responseWriter http.ResponseWriter, reque
 		return
 	}
 
+	if validationMessage := validateGenerationDefaults(createExamRequest.GenerationDefaults, "generation_defaults"); validationMessage != "" {
+		server.writeError(responseWriter, http.StatusBadRequest, "VALIDATION_ERROR", validationMessage, nil)
+		return
+	}
+	if validationMessage := models.ValidateMetadataFields(createExamRequest.MetadataFields); validationMessage != "" {
+		server.writeError(responseWriter, http.StatusBadRequest, "VALIDATION_ERROR", validationMessage, nil)
+		return
+	}
+	if createExamRequest.MetadataFields == nil {
+		createExamRequest.MetadataFields = []models.MetadataField{}
+	}
+
+	userID := server.getUserID(request)
+	examID, _ := gonanoid.New()
+
 	// Clean title and description
-	title, description, metrics, err := server.toolGenerator.CorrectProjectTitleDescription(request.Context(), createExamRequest.Title, createExamRequest.Description, "")
+	title, description, metrics, err := server.polishTitleDescription(request.Context(), userID, examID, createExamRequest.Title, createExamRequest.Description)
 	if err != nil {
 		slog.Error("Failed to polish exam title/description", "error", err)
 	} else {
@@ -41,24 +62,28 @@ func (server *Server) handleCreateExam(responseWriter http.ResponseWriter, reque
 			"estimated_cost_usd", metrics.EstimatedCost)
 	}
 
-	userID := server.getUserID(request)
-
-	examID, _ := gonanoid.New()
 	exam := models.Exam{
-		ID:            examID,
-		UserID:        userID,
-		Title:         title,
-		Description:   description,
-		Language:      createExamRequest.Language,
-		EstimatedCost: metrics.EstimatedCost,
-		CreatedAt:     time.Now(),
-		UpdatedAt:     time.Now(),
-	}
-
+		ID:                 examID,
+		UserID:             userID,
+		Title:              title,
+		Description:        description,
+		Language:           createExamRequest.Language,
+		GenerationDefaults: createExamRequest.GenerationDefaults,
+		Collaboration:      createExamRequest.Collaboration,
+		MetadataFields:     createExamRequest.MetadataFields,
+		EstimatedCost:      metrics.EstimatedCost,
+		Role:               models.ExamRoleOwner,
+		CreatedAt:          time.Now(),
+		UpdatedAt:          time.Now(),
+	}
+
+	generationDefaults, _ := json.Marshal(exam.GenerationDefaults)
+	collaboration, _ := json.Marshal(exam.Collaboration)
+	metadataFields, _ := json.Marshal(exam.MetadataFields)
 	_, err = server.database.Exec(`
-		INSERT INTO exams (id, user_id, title, description, language, estimated_cost, created_at, updated_at)
-		VALUES (?, ?, ?, ?, ?, ?, ?, ?)
-	`, exam.ID, exam.UserID, exam.Title, exam.Description, exam.Language, exam.EstimatedCost, exam.CreatedAt, exam.UpdatedAt)
+		INSERT INTO exams (id, user_id, title, description, language, generation_defaults, collaboration, metadata_fields, estimated_cost, created_at, updated_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+	`, exam.ID, exam.UserID, exam.Title, exam.Description, exam.Language, string(generationDefaults), string(collaboration), string(metadataFields), exam.EstimatedCost, exam.CreatedAt, exam.UpdatedAt)
 
 	if err != nil {
 		server.writeError(responseWriter, http.StatusInternalServerError, "DATABASE_ERROR", "Failed to create exam", nil)
@@ -68,32 +93,41 @@ func (server *Server) handleCreateExam(responseWriter http.ResponseWriter, reque
 	server.writeJSON(responseWriter, http.StatusCreated, exam)
 }
 
-// handleListExams lists all exams for the current user
+// examResponse is an exam with its description rendered as HTML
+type examResponse struct {
+	models.Exam
+	DescriptionHTML string `json:"description_html"`
+}
+
+// handleListExams lists the exams the current user owns or was given access to, with their role on each
 func (server *Server) handleListExams(responseWriter http.ResponseWriter, request *http.Request) {
 	userID := server.getUserID(request)
+	page, err := parseListPage(request, "exams.created_at", "exams.id", true)
+	if err != nil {
+		server.writeError(responseWriter, http.StatusBadRequest, "VALIDATION_ERROR", err.Error(), nil)
+		return
+	}
+	condition, conditionArgs := page.condition()
 
 	examRows, databaseError := server.database.Query(`
-		SELECT id, user_id, title, description, language, estimated_cost, created_at, updated_at
+		SELECT id, user_id, title, description, language, generation_defaults, collaboration, metadata_fields, estimated_cost, created_at, updated_at,
+		       (SELECT role FROM exam_access WHERE exam_access.exam_id = exams.id AND exam_access.user_id = ? ORDER BY rank DESC LIMIT 1),
+		       `+page.sortValue()+`
 		FROM exams
-		WHERE user_id = ?
-		ORDER BY created_at DESC
-	`, userID)
+		WHERE `+examAccess(models.ExamRoleViewer)+condition+page.orderBy(), append([]any{userID, userID}, conditionArgs...)...)
 	if databaseError != nil {
 		server.writeError(responseWriter, http.StatusInternalServerError, "DATABASE_ERROR", "Failed to list exams", nil)
 		return
 	}
 	defer examRows.Close()
 
-	type examResponse struct {
-		models.Exam
-		DescriptionHTML string `json:"description_html"`
-	}
-
 	exams := []examResponse{}
+	var cursors []pageCursor
 	for examRows.Next() {
 		var exam models.Exam
-		var description, language sql.NullString
-		if err := examRows.Scan(&exam.ID, &exam.UserID, &exam.Title, &description, &language, &exam.EstimatedCost, &exam.CreatedAt, &exam.UpdatedAt); err != nil {
+		var description, language, generationDefaults, collaboration, metadataFields sql.NullString
+		var sortValue string
+		if err := examRows.Scan(&exam.ID, &exam.UserID, &exam.Title, &description, &language, &generationDefaults, &collaboration, &metadataFields, &exam.EstimatedCost, &exam.CreatedAt, &exam.UpdatedAt, &exam.Role, &sortValue); err != nil {
 			server.writeError(responseWriter, http.StatusInternalServerError, "DATABASE_ERROR", "Failed to scan exam", nil)
 			return
 		}
@@ -103,6 +137,9 @@ func (server *Server) handleListExams(responseWriter http.ResponseWriter, reques
 		if language.Valid {
 			exam.Language = language.String
 		}
+		exam.GenerationDefaults = database.ParseExamGenerationDefaults(generationDefaults)
+		exam.Collaboration = database.ParseExamCollaboration(collaboration)
+		exam.MetadataFields = database.ParseMetadataFields(metadataFields)
 
 		// Convert description to HTML
 		response := examResponse{Exam: exam}
@@ -116,9 +153,11 @@ func (server *Server) handleListExams(responseWriter http.ResponseWriter, reques
 		}
 
 		exams = append(exams, response)
+		cursors = append(cursors, pageCursor{SortValue: sortValue, ID: exam.ID})
 	}
 
-	server.writeJSON(responseWriter, http.StatusOK, exams)
+	exams, nextCursor := paginate(page, exams, cursors)
+	server.writePage(responseWriter, http.StatusOK, exams, nextCursor)
 }
 
 // handleGetExam retrieves a specific exam for the current user
@@ -132,12 +171,13 @@ func (server *Server) handleGetExam(responseWriter http.ResponseWriter, request
 	userID := server.getUserID(request)
 
 	var exam models.Exam
-	var description, language sql.NullString
+	var description, language, generationDefaults, collaboration, metadataFields sql.NullString
 	err := server.database.QueryRow(`
-		SELECT id, user_id, title, description, language, estimated_cost, created_at, updated_at
+		SELECT id, user_id, title, description, language, generation_defaults, collaboration, metadata_fields, estimated_cost, created_at, updated_at,
+		       (SELECT role FROM exam_access WHERE exam_access.exam_id = exams.id AND exam_access.user_id = ? ORDER BY rank DESC LIMIT 1)
 		FROM exams
-		WHERE id = ? AND user_id = ?
-	`, examID, userID).Scan(&exam.ID, &exam.UserID, &exam.Title, &description, &language, &exam.EstimatedCost, &exam.CreatedAt, &exam.UpdatedAt)
+		WHERE id = ? AND `+examAccess(models.ExamRoleViewer)+`
+	`, userID, examID, userID).Scan(&exam.ID, &exam.UserID, &exam.Title, &description, &language, &generationDefaults, &collaboration, &metadataFields, &exam.EstimatedCost, &exam.CreatedAt, &exam.UpdatedAt, &exam.Role)
 
 	if description.Valid {
 		exam.Description = description.String
@@ -145,6 +185,9 @@ func (server *Server) handleGetExam(responseWriter http.ResponseWriter, request
 	if language.Valid {
 		exam.Language = language.String
 	}
+	exam.GenerationDefaults = database.ParseExamGenerationDefaults(generationDefaults)
+	exam.Collaboration = database.ParseExamCollaboration(collaboration)
+	exam.MetadataFields = database.ParseMetadataFields(metadataFields)
 
 	if err == sql.ErrNoRows {
 		server.writeError(responseWriter, http.StatusNotFound, "NOT_FOUND", "Exam not found", nil)
@@ -156,11 +199,6 @@ func (server *Server) handleGetExam(responseWriter http.ResponseWriter, request
 	}
 
 	// Convert description to HTML
-	type examResponse struct {
-		models.Exam
-		DescriptionHTML string `json:"description_html"`
-	}
-
 	response := examResponse{Exam: exam}
 	if exam.Description != "" {
 		htmlContent, err := server.markdownConverter.MarkdownToHTML(exam.Description)
@@ -177,9 +215,12 @@ func (server *Server) handleGetExam(responseWriter http.ResponseWriter, request
 // handleUpdateExam updates an exam owned by the user
 func (server *Server) handleUpdateExam(responseWriter http.ResponseWriter, request *http.Request) {
 	var updateExamRequest struct {
-		ExamID      string  `json:"exam_id"`
-		Title       *string `json:"title"`
-		Description *string `json:"description"`
+		ExamID             string                         `json:"exam_id"`
+		Title              *string                        `json:"title"`
+		Description        *string                        `json:"description"`
+		GenerationDefaults *models.ExamGenerationDefaults `json:"generation_defaults"` // Replaces the stored defaults as a whole
+		Collaboration      *models.ExamCollaboration      `json:"collaboration"`       // Only the owner changes it
+		MetadataFields     *[]models.MetadataField        `json:"metadata_fields"`     // Replaces the stored fields as a whole
 	}
 
 	if err := json.NewDecoder(request.Body).Decode(&updateExamRequest); err != nil {
@@ -192,13 +233,25 @@ func (server *Server) handleUpdateExam(responseWriter http.ResponseWriter, reque
 		return
 	}
 
-	userID := server.getUserID(request)
+	if updateExamRequest.GenerationDefaults != nil {
+		if validationMessage := validateGenerationDefaults(*updateExamRequest.GenerationDefaults, "generation_defaults"); validationMessage != "" {
+			server.writeError(responseWriter, http.StatusBadRequest, "VALIDATION_ERROR", validationMessage, nil)
+			return
+		}
+	}
+	if updateExamRequest.MetadataFields != nil {
+		if validationMessage := models.ValidateMetadataFields(*updateExamRequest.MetadataFields); validationMessage != "" {
+			server.writeError(responseWriter, http.StatusBadRequest, "VALIDATION_ERROR", validationMessage, nil)
+			return
+		}
+	}
 
-	// Check if exam exists and belongs to user
-	var exists bool
-	err := server.database.QueryRow("SELECT EXISTS(SELECT 1 FROM exams WHERE id = ? AND user_id = ?)", updateExamRequest.ExamID, userID).Scan(&exists)
-	if err != nil || !exists {
-		server.writeError(responseWriter, http.StatusNotFound, "NOT_FOUND", "Exam not found", nil)
+	// Managers share the exam but cannot decide what its owner pays for or shares of theirs
+	minimumRole := models.ExamRoleManager
+	if updateExamRequest.Collaboration != nil {
+		minimumRole = models.ExamRoleOwner
+	}
+	if !server.authorizeExam(responseWriter, request, updateExamRequest.ExamID, minimumRole) {
 		return
 	}
 
@@ -207,12 +260,16 @@ func (server *Server) handleUpdateExam(responseWriter http.ResponseWriter, reque
 	query := "UPDATE exams SET updated_at = ?"
 	updates = append(updates, time.Now())
 
+	// The previous title goes into the history, so a rename can be undone
+	var currentTitle string
+	var changedFields []string
+	server.database.QueryRow("SELECT title FROM exams WHERE id = ?", updateExamRequest.ExamID).Scan(&currentTitle)
+	newTitle := currentTitle
+
 	if updateExamRequest.Title != nil || updateExamRequest.Description != nil {
-		currentTitle := ""
 		currentDescription := ""
-		server.database.QueryRow("SELECT title, description FROM exams WHERE id = ? AND user_id = ?", updateExamRequest.ExamID, userID).Scan(&currentTitle, &currentDescription)
+		server.database.QueryRow("SELECT description FROM exams WHERE id = ?", updateExamRequest.ExamID).Scan(&currentDescription)
 
-		newTitle := currentTitle
 		if updateExamRequest.Title != nil {
 			newTitle = *updateExamRequest.Title
 		}
@@ -221,7 +278,8 @@ func (server *Server) handleUpdateExam(responseWriter http.ResponseWriter, reque
 			newDescription = *updateExamRequest.Description
 		}
 
-		cleanedTitle, cleanedDescription, metrics, err := server.toolGenerator.CorrectProjectTitleDescription(request.Context(), newTitle, newDescription, "")
+		billedUserID := server.billedUserID(server.getUserID(request), updateExamRequest.ExamID)
+		cleanedTitle, cleanedDescription, metrics, err := server.polishTitleDescription(request.Context(), billedUserID, updateExamRequest.ExamID, newTitle, newDescription)
 		if err != nil {
 			slog.Error("Failed to polish updated exam title/description", "examID", updateExamRequest.ExamID, "error", err)
 			cleanedTitle = newTitle
@@ -234,39 +292,85 @@ func (server *Server) handleUpdateExam(responseWriter http.ResponseWriter, reque
 				"estimated_cost_usd", metrics.EstimatedCost)
 		}
 
+		newTitle = currentTitle
 		if updateExamRequest.Title != nil {
 			query += ", title = ?"
 			updates = append(updates, cleanedTitle)
+			newTitle = cleanedTitle
 		}
 		if updateExamRequest.Description != nil {
 			query += ", description = ?"
 			updates = append(updates, cleanedDescription)
+			if cleanedDescription != currentDescription {
+				changedFields = append(changedFields, "description")
+			}
 		}
 		query += ", estimated_cost = estimated_cost + ?"
 		updates = append(updates, metrics.EstimatedCost)
 	}
 
-	query += " WHERE id = ? AND user_id = ?"
-	updates = append(updates, updateExamRequest.ExamID, userID)
+	if updateExamRequest.GenerationDefaults != nil {
+		generationDefaults, _ := json.Marshal(updateExamRequest.GenerationDefaults)
+		query += ", generation_defaults = ?"
+		updates = append(updates, string(generationDefaults))
+		changedFields = append(changedFields, "generation defaults")
+	}
+	if updateExamRequest.Collaboration != nil {
+		collaboration, _ := json.Marshal(updateExamRequest.Collaboration)
+		query += ", collaboration = ?"
+		updates = append(updates, string(collaboration))
+		changedFields = append(changedFields, "collaboration settings")
+	}
+	if updateExamRequest.MetadataFields != nil {
+		metadataFields, _ := json.Marshal(updateExamRequest.MetadataFields)
+		query += ", metadata_fields = ?"
+		updates = append(updates, string(metadataFields))
+		changedFields = append(changedFields, "metadata fields")
+	}
+
+	query += " WHERE id = ?"
+	updates = append(updates, updateExamRequest.ExamID)
 
-	_, err = server.database.Exec(query, updates...)
+	_, err := server.database.Exec(query, updates...)
 	if err != nil {
 		server.writeError(responseWriter, http.StatusInternalServerError, "DATABASE_ERROR", "Failed to update exam", nil)
 		return
 	}
 
+	if action, summary := describeUpdate("exam", currentTitle, newTitle, changedFields); action != "" {
+		event := models.ResourceEvent{
+			ExamID:       updateExamRequest.ExamID,
+			ResourceType: models.ResourceTypeExam,
+			ResourceID:   updateExamRequest.ExamID,
+			Action:       action,
+			Summary:      summary,
+		}
+		if action == models.ResourceActionRenamed {
+			event.Undo = &models.ResourceEventUndo{
+				Description: fmt.Sprintf("Rename the exam back to \"%s\"", currentTitle),
+				Method:      http.MethodPatch,
+				Path:        "/api/exams",
+				Body:        map[string]any{"exam_id": updateExamRequest.ExamID, "title": currentTitle},
+			}
+		}
+		server.recordEvent(request, event)
+	}
+
 	// Fetch updated exam
 	var exam models.Exam
-	var description sql.NullString
+	var description, generationDefaults, collaboration, metadataFields sql.NullString
 	err = server.database.QueryRow(`
-		SELECT id, user_id, title, description, created_at, updated_at
+		SELECT id, user_id, title, description, generation_defaults, collaboration, metadata_fields, created_at, updated_at
 		FROM exams
-		WHERE id = ? AND user_id = ?
-	`, updateExamRequest.ExamID, userID).Scan(&exam.ID, &exam.UserID, &exam.Title, &description, &exam.CreatedAt, &exam.UpdatedAt)
+		WHERE id = ?
+	`, updateExamRequest.ExamID).Scan(&exam.ID, &exam.UserID, &exam.Title, &description, &generationDefaults, &collaboration, &metadataFields, &exam.CreatedAt, &exam.UpdatedAt)
 
 	if description.Valid {
 		exam.Description = description.String
 	}
+	exam.GenerationDefaults = database.ParseExamGenerationDefaults(generationDefaults)
+	exam.Collaboration = database.ParseExamCollaboration(collaboration)
+	exam.MetadataFields = database.ParseMetadataFields(metadataFields)
 
 	if err != nil {
 		server.writeError(responseWriter, http.StatusInternalServerError, "DATABASE_ERROR", "Failed to fetch updated exam", nil)
@@ -276,6 +380,26 @@ func (server *Server) handleUpdateExam(responseWriter http.ResponseWriter, reque
 	server.writeJSON(responseWriter, http.StatusOK, exam)
 }
 
+// validateGenerationDefaults checks generation defaults, those of an exam or the settings of a preset, returning
+// a message naming the first invalid field within field
+func validateGenerationDefaults(defaults models.ExamGenerationDefaults, field string) string {
+	if defaults.LanguageCode != "" && !bcp47Regex.MatchString(defaults.LanguageCode) {
+		return "Invalid " + field + ".language_code format (BCP-47 required)"
+	}
+	switch defaults.Length {
+	case "", "short", "medium", "long", "comprehensive":
+	default:
+		return field + ".length must be one of short, medium, long, comprehensive"
+	}
+	if defaults.AdherenceThreshold != nil && (*defaults.AdherenceThreshold < 0 || *defaults.AdherenceThreshold > 100) {
+		return field + ".adherence_threshold must be between 0 and 100"
+	}
+	if defaults.MaximumRetries != nil && *defaults.MaximumRetries < 0 {
+		return field + ".maximum_retries must not be negative"
+	}
+	return ""
+}
+
 // handleDeleteExam deletes an exam and all associated data
 func (server *Server) handleDeleteExam(responseWriter http.ResponseWriter, request *http.Request) {
 	var deleteRequest struct {
@@ -291,14 +415,12 @@ func (server *Server) handleDeleteExam(responseWriter http.ResponseWriter, reque
 		return
 	}
 
-	userID := server.getUserID(request)
+	if !server.authorizeExam(responseWriter, request, deleteRequest.ExamID, models.ExamRoleManager) {
+		return
+	}
 
 	// 1. Get all lecture IDs for this exam to clean up files later
-	lectureRows, queryError := server.database.Query(`
-		SELECT lectures.id FROM lectures 
-		JOIN exams ON lectures.exam_id = exams.id
-		WHERE exams.id = ? AND exams.user_id = ?
-	`, deleteRequest.ExamID, userID)
+	lectureRows, queryError := server.database.Query("SELECT id FROM lectures WHERE exam_id = ?", deleteRequest.ExamID)
 
 	var lectureIdentifiers []string
 	if queryError == nil {
@@ -310,8 +432,9 @@ func (server *Server) handleDeleteExam(responseWriter http.ResponseWriter, reque
 		}
 		lectureRows.Close()
 	}
+	objectKeys := server.lectureObjectKeys("lectures.exam_id = ?", deleteRequest.ExamID)
 	// 2. Delete from database
-	result, err := server.database.Exec("DELETE FROM exams WHERE id = ? AND user_id = ?", deleteRequest.ExamID, userID)
+	result, err := server.database.Exec("DELETE FROM exams WHERE id = ?", deleteRequest.ExamID)
 	if err != nil {
 		server.writeError(responseWriter, http.StatusInternalServerError, "DATABASE_ERROR", "Failed to delete exam", nil)
 		return
@@ -322,6 +445,7 @@ func (server *Server) handleDeleteExam(responseWriter http.ResponseWriter, reque
 		server.writeError(responseWriter, http.StatusNotFound, "NOT_FOUND", "Exam not found", nil)
 		return
 	}
+	server.deleteStoredObjects(objectKeys)
 
 	server.writeJSON(responseWriter, http.StatusOK, map[string]string{"message": "Exam deleted successfully"})
 }
@@ -336,13 +460,10 @@ func (server *Server) handleExamSearch(responseWriter http.ResponseWriter, reque
 		return
 	}
 
-	userID := server.getUserID(request)
-
-	// Verify exam ownership
-	var exists bool
-	err := server.database.QueryRow("SELECT EXISTS(SELECT 1 FROM exams WHERE id = ? AND user_id = ?)", examID, userID).Scan(&exists)
-	if err != nil || !exists {
-		server.writeError(responseWriter, http.StatusNotFound, "NOT_FOUND", "Exam not found", nil)
+	if !server.authorizeExam(responseWriter, request, examID, models.ExamRoleViewer) {
+		return
+	}
+	if !server.restoreExamTranscripts(responseWriter, examID) {
 		return
 	}
 
@@ -361,10 +482,9 @@ func (server *Server) handleExamSearch(responseWriter http.ResponseWriter, reque
 		FROM transcript_segments
 		JOIN transcripts ON transcript_segments.transcript_id = transcripts.id
 		JOIN lectures ON transcripts.lecture_id = lectures.id
-		JOIN exams ON lectures.exam_id = exams.id
-		WHERE lectures.exam_id = ? AND exams.user_id = ? AND transcript_segments.text LIKE ?
+		WHERE lectures.exam_id = ? AND transcript_segments.text LIKE ?
 		LIMIT 50
-	`, examID, userID, "%"+query+"%")
+	`, examID, "%"+query+"%")
 
 	if err == nil {
 		for transcriptRows.Next() {
@@ -389,10 +509,9 @@ func (server *Server) handleExamSearch(responseWriter http.ResponseWriter, reque
 		FROM reference_pages
 		JOIN reference_documents ON reference_pages.document_id = reference_documents.id
 		JOIN lectures ON reference_documents.lecture_id = lectures.id
-		JOIN exams ON lectures.exam_id = exams.id
-		WHERE lectures.exam_id = ? AND exams.user_id = ? AND reference_pages.extracted_text LIKE ?
+		WHERE lectures.exam_id = ? AND reference_pages.extracted_text LIKE ?
 		LIMIT 50
-	`, examID, userID, "%"+query+"%")
+	`, examID, "%"+query+"%")
 
 	if err == nil {
 		for documentRows.Next() {
@@ -431,11 +550,7 @@ func (server *Server) handleExamSuggest(responseWriter http.ResponseWriter, requ
 
 	userID := server.getUserID(request)
 
-	// Verify exam ownership
-	var exists bool
-	err := server.database.QueryRow("SELECT EXISTS(SELECT 1 FROM exams WHERE id = ? AND user_id = ?)", suggestRequest.ExamID, userID).Scan(&exists)
-	if err != nil || !exists {
-		server.writeError(responseWriter, http.StatusNotFound, "NOT_FOUND", "Exam not found", nil)
+	if !server.authorizeExam(responseWriter, request, suggestRequest.ExamID, models.ExamRoleGenerator) {
 		return
 	}
 
@@ -444,7 +559,7 @@ func (server *Server) handleExamSuggest(responseWriter http.ResponseWriter, requ
 	}, suggestRequest.ExamID, "")
 
 	if err != nil {
-		server.writeError(responseWriter, http.StatusInternalServerError, "BACKGROUND_JOB_ERROR", "Failed to enqueue suggest job", nil)
+		server.writeEnqueueError(responseWriter, err, "Failed to enqueue suggest job")
 		return
 	}
 
@@ -462,13 +577,7 @@ func (server *Server) handleGetExamConcepts(responseWriter http.ResponseWriter,
 		return
 	}
 
-	userID := server.getUserID(request)
-
-	// Verify ownership
-	var exists bool
-	err := server.database.QueryRow("SELECT EXISTS(SELECT 1 FROM exams WHERE id = ? AND user_id = ?)", examID, userID).Scan(&exists)
-	if err != nil || !exists {
-		server.writeError(responseWriter, http.StatusNotFound, "NOT_FOUND", "Exam not found", nil)
+	if !server.authorizeExam(responseWriter, request, examID, models.ExamRoleViewer) {
 		return
 	}
 
@@ -497,3 +606,77 @@ func (server *Server) handleGetExamConcepts(responseWriter http.ResponseWriter,
 
 	server.writeJSON(responseWriter, http.StatusOK, concepts)
 }
+
+// handleAnalyzeExamDuplicates triggers a job that finds the material covered in more than one lecture of an exam
+func (server *Server) handleAnalyzeExamDuplicates(responseWriter http.ResponseWriter, request *http.Request) {
+	var analyzeRequest struct {
+		ExamID string `json:"exam_id"`
+	}
+	if err := json.NewDecoder(request.Body).Decode(&analyzeRequest); err != nil {
+		server.writeError(responseWriter, http.StatusBadRequest, "VALIDATION_ERROR", "Invalid request body", nil)
+		return
+	}
+
+	if analyzeRequest.ExamID == "" {
+		server.writeError(responseWriter, http.StatusBadRequest, "VALIDATION_ERROR", "exam_id is required", nil)
+		return
+	}
+
+	userID := server.getUserID(request)
+
+	if !server.authorizeExam(responseWriter, request, analyzeRequest.ExamID, models.ExamRoleGenerator) {
+		return
+	}
+
+	jobID, err := server.jobQueue.Enqueue(userID, models.JobTypeAnalyzeDuplicates, map[string]string{
+		"exam_id": analyzeRequest.ExamID,
+	}, analyzeRequest.ExamID, "")
+	if err != nil {
+		server.writeEnqueueError(responseWriter, err, "Failed to enqueue duplicate analysis job")
+		return
+	}
+
+	server.writeJSON(responseWriter, http.StatusAccepted, map[string]string{
+		"job_id":  jobID,
+		"message": "Duplicate analysis job created",
+	})
+}
+
+// handleGetExamDuplicates lists the material covered in more than one lecture of an exam, as found by the last
+// duplicate analysis, each with the occurrence to cite
+func (server *Server) handleGetExamDuplicates(responseWriter http.ResponseWriter, request *http.Request) {
+	examID := request.URL.Query().Get("exam_id")
+	if examID == "" {
+		server.writeError(responseWriter, http.StatusBadRequest, "VALIDATION_ERROR", "exam_id is required", nil)
+		return
+	}
+
+	if !server.authorizeExam(responseWriter, request, examID, models.ExamRoleViewer) {
+		return
+	}
+
+	rows, err := server.database.Query(`
+		SELECT id, exam_id, topic, similarity, occurrences, canonical_index, created_at
+		FROM content_overlaps
+		WHERE exam_id = ?
+		ORDER BY id ASC
+	`, examID)
+	if err != nil {
+		server.writeError(responseWriter, http.StatusInternalServerError, "DATABASE_ERROR", "Failed to retrieve duplicate content", nil)
+		return
+	}
+	defer rows.Close()
+
+	overlaps := []models.ContentOverlap{}
+	for rows.Next() {
+		var overlap models.ContentOverlap
+		var occurrencesJSON string
+		if err := rows.Scan(&overlap.ID, &overlap.ExamID, &overlap.Topic, &overlap.Similarity, &occurrencesJSON, &overlap.CanonicalIndex, &overlap.CreatedAt); err != nil {
+			continue
+		}
+		json.Unmarshal([]byte(occurrencesJSON), &overlap.Occurrences)
+		overlaps = append(overlaps, overlap)
+	}
+
+	server.writeJSON(responseWriter, http.StatusOK, overlaps)
+}
@@ -0,0 +1,146 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"lectures/internal/database"
+	"lectures/internal/models"
+)
+
+func TestHandleExamGenerationDefaults(t *testing.T) {
+	server, _, sessionID, cleanup := setupUniqueExtraTestEnv(t, "examdefaults")
+	defer cleanup()
+
+	sendRequest := func(method, path string, payload any) *httptest.ResponseRecorder {
+		var body []byte
+		if payload != nil {
+			body, _ = json.Marshal(payload)
+		}
+		req := httptest.NewRequest(method, path, bytes.NewBuffer(body))
+		req.Header.Set("Authorization", "Bearer "+sessionID)
+		req.Header.Set("X-Requested-With", "XMLHttpRequest")
+		rr := httptest.NewRecorder()
+		server.Handler().ServeHTTP(rr, req)
+		return rr
+	}
+
+	rr := sendRequest("POST", "/api/exams", map[string]any{
+		"title":               "Physics",
+		"generation_defaults": map[string]any{"language_code": "it-IT", "length": "short"},
+	})
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("Expected status 201, got %d: %s", rr.Code, rr.Body.String())
+	}
+	var created struct {
+		Data struct {
+			ID string `json:"id"`
+		} `json:"data"`
+	}
+	json.Unmarshal(rr.Body.Bytes(), &created)
+
+	rr = sendRequest("GET", "/api/exams/details?exam_id="+created.Data.ID, nil)
+	var fetched struct {
+		Data struct {
+			GenerationDefaults struct {
+				LanguageCode string `json:"language_code"`
+				Length       string `json:"length"`
+			} `json:"generation_defaults"`
+		} `json:"data"`
+	}
+	json.Unmarshal(rr.Body.Bytes(), &fetched)
+	if fetched.Data.GenerationDefaults.LanguageCode != "it-IT" || fetched.Data.GenerationDefaults.Length != "short" {
+		t.Errorf("Expected stored defaults it-IT/short, got %+v", fetched.Data.GenerationDefaults)
+	}
+
+	rr = sendRequest("PATCH", "/api/exams", map[string]any{
+		"exam_id":             created.Data.ID,
+		"generation_defaults": map[string]any{"length": "enormous"},
+	})
+	if rr.Code != http.StatusBadRequest {
+		t.Errorf("Expected status 400 for invalid length, got %d", rr.Code)
+	}
+
+	rr = sendRequest("PATCH", "/api/exams", map[string]any{
+		"exam_id":             created.Data.ID,
+		"generation_defaults": map[string]any{"length": "long"},
+	})
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", rr.Code, rr.Body.String())
+	}
+
+	defaults, err := database.GetExamGenerationDefaults(server.database, created.Data.ID)
+	if err != nil {
+		t.Fatalf("Failed to load defaults: %v", err)
+	}
+	if defaults.Length != "long" || defaults.LanguageCode != "" {
+		t.Errorf("Expected defaults to be replaced as a whole, got %+v", defaults)
+	}
+}
+
+func TestHandleMetadataFields(t *testing.T) {
+	server, _, sessionID, cleanup := setupUniqueExtraTestEnv(t, "metadata")
+	defer cleanup()
+
+	sendRequest := func(method string, path string, body any) (int, map[string]any) {
+		bodyBytes, _ := json.Marshal(body)
+		req := httptest.NewRequest(method, path, bytes.NewBuffer(bodyBytes))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Authorization", "Bearer "+sessionID)
+		req.Header.Set("X-Requested-With", "XMLHttpRequest")
+		rr := httptest.NewRecorder()
+		server.Handler().ServeHTTP(rr, req)
+		var response struct {
+			Data map[string]any `json:"data"`
+		}
+		json.Unmarshal(rr.Body.Bytes(), &response)
+		return rr.Code, response.Data
+	}
+
+	code, exam := sendRequest("POST", "/api/exams", map[string]any{
+		"title": "Optics",
+		"metadata_fields": []models.MetadataField{
+			{Name: "Professor", Value: "Dr. Lens"},
+			{Name: "Course code", Value: "PHY-201"},
+		},
+	})
+	if code != http.StatusCreated {
+		t.Fatalf("Expected status 201, got %d", code)
+	}
+	examID := exam["id"].(string)
+	if fields := exam["metadata_fields"].([]any); len(fields) != 2 {
+		t.Errorf("Expected the 2 metadata fields back, got %v", fields)
+	}
+
+	code, _ = sendRequest("PATCH", "/api/exams", map[string]any{
+		"exam_id":         examID,
+		"metadata_fields": []models.MetadataField{{Name: "Semester", Value: "Fall"}, {Name: "semester", Value: "Spring"}},
+	})
+	if code != http.StatusBadRequest {
+		t.Errorf("Expected status 400 for a field defined twice, got %d", code)
+	}
+
+	server.database.Exec("INSERT INTO lectures (id, exam_id, title, status) VALUES ('metadata-lecture', ?, 'Lenses', 'ready')", examID)
+	code, lecture := sendRequest("PATCH", "/api/lectures", map[string]any{
+		"lecture_id":      "metadata-lecture",
+		"exam_id":         examID,
+		"metadata_fields": []models.MetadataField{{Name: "Professor", Value: "Dr. Mirror"}, {Name: "Course code", Value: ""}},
+	})
+	if code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d", code)
+	}
+	if fields := lecture["metadata_fields"].([]any); len(fields) != 2 {
+		t.Errorf("Expected the lecture fields back, got %v", fields)
+	}
+
+	fields, err := database.GetMetadataFields(server.database, examID, "metadata-lecture")
+	if err != nil {
+		t.Fatalf("GetMetadataFields failed: %v", err)
+	}
+	if len(fields) != 1 || fields[0].Name != "Professor" || fields[0].Value != "Dr. Mirror" {
+		t.Errorf("Expected the lecture professor to replace the exam's and the empty course code to hide it, got %+v", fields)
+	}
+}
@@ -0,0 +1,265 @@
+package api
+
+import (
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"regexp"
+	"time"
+
+	"lectures/internal/models"
+
+	gonanoid "github.com/matoous/go-nanoid/v2"
+)
+
+// supportedExportFormats lists the formats accepted by the publish handlers
+var supportedExportFormats = map[string]bool{"pdf": true, "docx": true, "epub": true, "html": true, "md": true, "anki": true, "csv": true, "apkg": true}
+
+// exportThemePattern restricts theme names to safe template suffixes
+var exportThemePattern = regexp.MustCompile(`^[a-z0-9-]*$`)
+
+// handleCreateExportPreset saves a named export preset for the user, optionally scoped to one exam
+func (server *Server) handleCreateExportPreset(responseWriter http.ResponseWriter, request *http.Request) {
+	var createRequest struct {
+		ExamID        string   `json:"exam_id"`
+		Name          string   `json:"name"`
+		Formats       []string `json:"formats"`
+		Theme         string   `json:"theme"`
+		IncludeImages *bool    `json:"include_images"`
+		IncludeQRCode bool     `json:"include_qr_code"`
+	}
+	if err := json.NewDecoder(request.Body).Decode(&createRequest); err != nil {
+		server.writeError(responseWriter, http.StatusBadRequest, "VALIDATION_ERROR", "Invalid request body", nil)
+		return
+	}
+
+	if createRequest.Name == "" {
+		server.writeError(responseWriter, http.StatusBadRequest, "VALIDATION_ERROR", "name is required", nil)
+		return
+	}
+	if message := validateExportOptions(createRequest.Formats, createRequest.Theme); message != "" {
+		server.writeError(responseWriter, http.StatusBadRequest, "VALIDATION_ERROR", message, nil)
+		return
+	}
+
+	userID := server.getUserID(request)
+	if createRequest.ExamID != "" && !server.authorizeExam(responseWriter, request, createRequest.ExamID, models.ExamRoleGenerator) {
+		return
+	}
+
+	preset := models.ExportPreset{
+		UserID:        userID,
+		ExamID:        createRequest.ExamID,
+		Name:          createRequest.Name,
+		Formats:       createRequest.Formats,
+		Theme:         createRequest.Theme,
+		IncludeImages: createRequest.IncludeImages == nil || *createRequest.IncludeImages,
+		IncludeQRCode: createRequest.IncludeQRCode,
+		CreatedAt:     time.Now(),
+		UpdatedAt:     time.Now(),
+	}
+	preset.ID, _ = gonanoid.New()
+
+	var examIDValue any
+	if preset.ExamID != "" {
+		examIDValue = preset.ExamID
+	}
+	formatsJSON, _ := json.Marshal(preset.Formats)
+	_, err := server.database.Exec(`
+		INSERT INTO export_presets (id, user_id, exam_id, name, formats, theme, include_images, include_qr_code, created_at, updated_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+	`, preset.ID, preset.UserID, examIDValue, preset.Name, string(formatsJSON), preset.Theme, preset.IncludeImages, preset.IncludeQRCode, preset.CreatedAt, preset.UpdatedAt)
+	if err != nil {
+		server.writeError(responseWriter, http.StatusInternalServerError, "DATABASE_ERROR", "Failed to save export preset", nil)
+		return
+	}
+
+	server.writeJSON(responseWriter, http.StatusCreated, preset)
+}
+
+// handleListExportPresets lists the user's global presets plus those scoped to the given exam
+func (server *Server) handleListExportPresets(responseWriter http.ResponseWriter, request *http.Request) {
+	userID := server.getUserID(request)
+	examID := request.URL.Query().Get("exam_id")
+
+	rows, err := server.database.Query(`
+		SELECT id, user_id, exam_id, name, formats, theme, include_images, include_qr_code, created_at, updated_at
+		FROM export_presets
+		WHERE user_id = ? AND (exam_id IS NULL OR exam_id = ?)
+		ORDER BY name ASC
+	`, userID, examID)
+	if err != nil {
+		server.writeError(responseWriter, http.StatusInternalServerError, "DATABASE_ERROR", "Failed to list export presets", nil)
+		return
+	}
+	defer rows.Close()
+
+	presets := []models.ExportPreset{}
+	for rows.Next() {
+		preset, scanErr := scanExportPreset(rows)
+		if scanErr != nil {
+			server.writeError(responseWriter, http.StatusInternalServerError, "DATABASE_ERROR", "Failed to scan export preset", nil)
+			return
+		}
+		presets = append(presets, preset)
+	}
+
+	server.writeJSON(responseWriter, http.StatusOK, presets)
+}
+
+// handleDeleteExportPreset removes one of the user's export presets
+func (server *Server) handleDeleteExportPreset(responseWriter http.ResponseWriter, request *http.Request) {
+	var deleteRequest struct {
+		PresetID string `json:"preset_id"`
+	}
+	if err := json.NewDecoder(request.Body).Decode(&deleteRequest); err != nil || deleteRequest.PresetID == "" {
+		server.writeError(responseWriter, http.StatusBadRequest, "VALIDATION_ERROR", "preset_id is required", nil)
+		return
+	}
+
+	result, err := server.database.Exec("DELETE FROM export_presets WHERE id = ? AND user_id = ?", deleteRequest.PresetID, server.getUserID(request))
+	if err != nil {
+		server.writeError(responseWriter, http.StatusInternalServerError, "DATABASE_ERROR", "Failed to delete export preset", nil)
+		return
+	}
+	if rowsAffected, _ := result.RowsAffected(); rowsAffected == 0 {
+		server.writeError(responseWriter, http.StatusNotFound, "NOT_FOUND", "Export preset not found", nil)
+		return
+	}
+
+	server.writeJSON(responseWriter, http.StatusOK, map[string]string{"message": "Export preset deleted successfully"})
+}
+
+// handlePublishBundle exports a tool, or every tool in an exam, in several formats with a single job
+func (server *Server) handlePublishBundle(responseWriter http.ResponseWriter, request *http.Request) {
+	var publishRequest struct {
+		ExamID        string   `json:"exam_id"`
+		ToolID        string   `json:"tool_id"` // Empty publishes every tool in the exam
+		PresetID      string   `json:"preset_id"`
+		Formats       []string `json:"formats"`
+		Theme         string   `json:"theme"`
+		IncludeImages *bool    `json:"include_images"`
+		IncludeQRCode bool     `json:"include_qr_code"`
+		SelfContained bool     `json:"self_contained"`
+	}
+	if err := json.NewDecoder(request.Body).Decode(&publishRequest); err != nil {
+		server.writeError(responseWriter, http.StatusBadRequest, "VALIDATION_ERROR", "Invalid request body", nil)
+		return
+	}
+
+	if publishRequest.ExamID == "" {
+		server.writeError(responseWriter, http.StatusBadRequest, "VALIDATION_ERROR", "exam_id is required", nil)
+		return
+	}
+
+	userID := server.getUserID(request)
+	if !server.authorizeExam(responseWriter, request, publishRequest.ExamID, models.ExamRoleGenerator) {
+		return
+	}
+
+	formats := publishRequest.Formats
+	theme := publishRequest.Theme
+	includeImages := publishRequest.IncludeImages == nil || *publishRequest.IncludeImages
+	includeQRCode := publishRequest.IncludeQRCode
+
+	// A preset supplies the formats and options wholesale
+	if publishRequest.PresetID != "" {
+		preset, err := scanExportPreset(server.database.QueryRow(`
+			SELECT id, user_id, exam_id, name, formats, theme, include_images, include_qr_code, created_at, updated_at
+			FROM export_presets
+			WHERE id = ? AND user_id = ? AND (exam_id IS NULL OR exam_id = ?)
+		`, publishRequest.PresetID, userID, publishRequest.ExamID))
+		if err == sql.ErrNoRows {
+			server.writeError(responseWriter, http.StatusNotFound, "NOT_FOUND", "Export preset not found", nil)
+			return
+		}
+		if err != nil {
+			server.writeError(responseWriter, http.StatusInternalServerError, "DATABASE_ERROR", "Failed to load export preset", nil)
+			return
+		}
+		formats, theme, includeImages, includeQRCode = preset.Formats, preset.Theme, preset.IncludeImages, preset.IncludeQRCode
+	}
+
+	if message := validateExportOptions(formats, theme); message != "" {
+		server.writeError(responseWriter, http.StatusBadRequest, "VALIDATION_ERROR", message, nil)
+		return
+	}
+
+	var toolIDs []string
+	if publishRequest.ToolID != "" {
+		var toolID string
+		if err := server.database.QueryRow("SELECT id FROM tools WHERE id = ? AND exam_id = ? AND "+ownBookmarkDecks, publishRequest.ToolID, publishRequest.ExamID, server.getUserID(request)).Scan(&toolID); err != nil {
+			server.writeError(responseWriter, http.StatusNotFound, "NOT_FOUND", "Tool not found in this exam", nil)
+			return
+		}
+		toolIDs = append(toolIDs, toolID)
+	} else {
+		rows, err := server.database.Query("SELECT id FROM tools WHERE exam_id = ? AND "+ownBookmarkDecks+" ORDER BY created_at ASC", publishRequest.ExamID, server.getUserID(request))
+		if err != nil {
+			server.writeError(responseWriter, http.StatusInternalServerError, "DATABASE_ERROR", "Failed to list tools", nil)
+			return
+		}
+		for rows.Next() {
+			var toolID string
+			if rows.Scan(&toolID) == nil {
+				toolIDs = append(toolIDs, toolID)
+			}
+		}
+		rows.Close()
+	}
+
+	if len(toolIDs) == 0 {
+		server.writeError(responseWriter, http.StatusBadRequest, "VALIDATION_ERROR", "There are no tools to publish in this exam", nil)
+		return
+	}
+
+	jobIdentifier, enqueuingError := server.jobQueue.Enqueue(userID, models.JobTypePublishBundle, map[string]any{
+		"exam_id":         publishRequest.ExamID,
+		"tool_ids":        toolIDs,
+		"formats":         formats,
+		"theme":           theme,
+		"include_images":  includeImages,
+		"include_qr_code": includeQRCode,
+		"self_contained":  publishRequest.SelfContained,
+	}, publishRequest.ExamID, "")
+	if enqueuingError != nil {
+		server.writeError(responseWriter, http.StatusInternalServerError, "BACKGROUND_JOB_ERROR", "Failed to create publish job", nil)
+		return
+	}
+
+	server.writeJSON(responseWriter, http.StatusAccepted, map[string]any{
+		"job_id":       jobIdentifier,
+		"tool_count":   len(toolIDs),
+		"export_count": len(toolIDs) * len(formats),
+		"message":      "Publish job created",
+	})
+}
+
+// validateExportOptions returns a validation message for unusable formats or themes, or an empty string
+func validateExportOptions(formats []string, theme string) string {
+	if len(formats) == 0 {
+		return "At least one format is required"
+	}
+	for _, format := range formats {
+		if !supportedExportFormats[format] {
+			return "Unsupported export format: " + format
+		}
+	}
+	if !exportThemePattern.MatchString(theme) {
+		return "Theme may only contain lowercase letters, digits and dashes"
+	}
+	return ""
+}
+
+func scanExportPreset(scanner interface{ Scan(...any) error }) (models.ExportPreset, error) {
+	var preset models.ExportPreset
+	var examID, theme sql.NullString
+	var formatsJSON string
+	if err := scanner.Scan(&preset.ID, &preset.UserID, &examID, &preset.Name, &formatsJSON, &theme, &preset.IncludeImages, &preset.IncludeQRCode, &preset.CreatedAt, &preset.UpdatedAt); err != nil {
+		return models.ExportPreset{}, err
+	}
+	preset.ExamID = examID.String
+	preset.Theme = theme.String
+	json.Unmarshal([]byte(formatsJSON), &preset.Formats)
+	return preset, nil
+}
@@ -0,0 +1,89 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestHandleExportPresetsAndPublish(t *testing.T) {
+	server, userID, sessionID, cleanup := setupUniqueExtraTestEnv(t, "exportpresets")
+	defer cleanup()
+
+	sendRequest := func(method, path string, payload any) *httptest.ResponseRecorder {
+		var body []byte
+		if payload != nil {
+			body, _ = json.Marshal(payload)
+		}
+		req := httptest.NewRequest(method, path, bytes.NewBuffer(body))
+		req.Header.Set("Authorization", "Bearer "+sessionID)
+		req.Header.Set("X-Requested-With", "XMLHttpRequest")
+		rr := httptest.NewRecorder()
+		server.Handler().ServeHTTP(rr, req)
+		return rr
+	}
+
+	examID := "exam-presets"
+	_, _ = server.database.Exec("INSERT INTO exams (id, user_id, title) VALUES (?, ?, ?)", examID, userID, "Preset Exam")
+
+	if rr := sendRequest("POST", "/api/exports/presets", map[string]any{"name": "Bad", "formats": []string{"exe"}}); rr.Code != http.StatusBadRequest {
+		t.Errorf("Expected status 400 for unsupported format, got %d", rr.Code)
+	}
+	if rr := sendRequest("POST", "/api/exports/presets", map[string]any{"name": "Bad", "formats": []string{"pdf"}, "theme": "../etc"}); rr.Code != http.StatusBadRequest {
+		t.Errorf("Expected status 400 for unsafe theme, got %d", rr.Code)
+	}
+
+	rr := sendRequest("POST", "/api/exports/presets", map[string]any{"name": "Print and study", "exam_id": examID, "formats": []string{"pdf", "anki"}, "theme": "compact"})
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("Expected status 201, got %d: %s", rr.Code, rr.Body.String())
+	}
+	var created struct {
+		Data struct {
+			ID string `json:"id"`
+		} `json:"data"`
+	}
+	json.Unmarshal(rr.Body.Bytes(), &created)
+
+	var listed struct {
+		Data []struct {
+			Name    string   `json:"name"`
+			Formats []string `json:"formats"`
+		} `json:"data"`
+	}
+	json.Unmarshal(sendRequest("GET", "/api/exports/presets?exam_id="+examID, nil).Body.Bytes(), &listed)
+	if len(listed.Data) != 1 || listed.Data[0].Name != "Print and study" || len(listed.Data[0].Formats) != 2 {
+		t.Errorf("Unexpected presets: %+v", listed.Data)
+	}
+
+	if rr := sendRequest("POST", "/api/exports/publish", map[string]any{"exam_id": examID, "preset_id": created.Data.ID}); rr.Code != http.StatusBadRequest {
+		t.Errorf("Expected status 400 for an exam without tools, got %d", rr.Code)
+	}
+
+	server.jobQueue.Stop()
+	_, _ = server.database.Exec("INSERT INTO tools (id, exam_id, type, title, content) VALUES ('tool-a', ?, 'guide', 'A', 'a'), ('tool-b', ?, 'guide', 'B', 'b')", examID, examID)
+	rr = sendRequest("POST", "/api/exports/publish", map[string]any{"exam_id": examID, "preset_id": created.Data.ID})
+	if rr.Code != http.StatusAccepted {
+		t.Fatalf("Expected status 202, got %d: %s", rr.Code, rr.Body.String())
+	}
+	var published struct {
+		Data struct {
+			JobID       string `json:"job_id"`
+			ExportCount int    `json:"export_count"`
+		} `json:"data"`
+	}
+	json.Unmarshal(rr.Body.Bytes(), &published)
+	if published.Data.ExportCount != 4 {
+		t.Errorf("Expected 4 exports, got %d", published.Data.ExportCount)
+	}
+	var jobType string
+	server.database.QueryRow("SELECT type FROM jobs WHERE id = ?", published.Data.JobID).Scan(&jobType)
+	if jobType != "PUBLISH_BUNDLE" {
+		t.Errorf("Expected a PUBLISH_BUNDLE job, got %q", jobType)
+	}
+
+	if rr := sendRequest("DELETE", "/api/exports/presets", map[string]string{"preset_id": created.Data.ID}); rr.Code != http.StatusOK {
+		t.Errorf("Expected status 200 on delete, got %d", rr.Code)
+	}
+}
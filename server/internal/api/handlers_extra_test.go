@@ -35,7 +35,7 @@ func setupUniqueExtraTestEnv(t *testing.T, testName string) (*Server, string, st
 	userID := "user-" + testName
 	sessionID := gonanoid.Must()
 	hash, _ := bcrypt.GenerateFromPassword([]byte("password123"), bcrypt.DefaultCost)
-	_, _ = db.Exec("INSERT INTO users (id, username, password_hash, role) VALUES (?, ?, ?, ?)", userID, "user"+testName, string(hash), "user")
+	_, _ = db.Exec("INSERT INTO users (id, username, password_hash, role) VALUES (?, ?, ?, ?)", userID, "user"+testName, string(hash), "teacher")
 	_, _ = db.Exec("INSERT INTO auth_sessions (id, user_id, created_at, last_activity, expires_at) VALUES (?, ?, ?, ?, ?)", sessionID, userID, time.Now(), time.Now(), time.Now().Add(1*time.Hour))
 
 	config := &configuration.Configuration{
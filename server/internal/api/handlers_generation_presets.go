@@ -0,0 +1,200 @@
+package api
+
+import (
+	"database/sql"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"lectures/internal/database"
+	"lectures/internal/models"
+
+	gonanoid "github.com/matoous/go-nanoid/v2"
+)
+
+// Bounds of the generation presets users save
+const (
+	maximumGenerationPresetsPerUser          = 50
+	maximumGenerationPresetNameLength        = 64
+	maximumGenerationPresetDescriptionLength = 200
+)
+
+// validateGenerationPreset checks the name, settings and sampling parameters of a preset, returning a message
+// for the first invalid one
+func validateGenerationPreset(preset models.GenerationPreset) string {
+	if preset.Name == "" {
+		return "name is required"
+	}
+	if utf8.RuneCountInString(preset.Name) > maximumGenerationPresetNameLength || strings.ContainsAny(preset.Name, "\r\n") {
+		return "name must be a single line of at most 64 characters"
+	}
+	if utf8.RuneCountInString(preset.Description) > maximumGenerationPresetDescriptionLength {
+		return "description cannot be longer than 200 characters"
+	}
+	if validationMessage := validateGenerationDefaults(preset.Settings, "settings"); validationMessage != "" {
+		return validationMessage
+	}
+	if err := preset.Sampling.Validate(); err != nil {
+		return "sampling: " + err.Error()
+	}
+	return ""
+}
+
+// handleListGenerationPresets lists the generation presets of the authenticated user by name
+func (server *Server) handleListGenerationPresets(responseWriter http.ResponseWriter, request *http.Request) {
+	presets, err := database.ListGenerationPresets(server.database, server.getUserID(request))
+	if err != nil {
+		server.writeError(responseWriter, http.StatusInternalServerError, "DATABASE_ERROR", "Failed to list generation presets", nil)
+		return
+	}
+	server.writeJSON(responseWriter, http.StatusOK, presets)
+}
+
+// handleCreateGenerationPreset saves a named set of generation settings of the authenticated user
+func (server *Server) handleCreateGenerationPreset(responseWriter http.ResponseWriter, request *http.Request) {
+	var presetRequest struct {
+		Name        string                        `json:"name"`
+		Description string                        `json:"description"`
+		Settings    models.ExamGenerationDefaults `json:"settings"`
+		Sampling    models.SamplingParameters     `json:"sampling"`
+	}
+	if err := json.NewDecoder(request.Body).Decode(&presetRequest); err != nil {
+		server.writeError(responseWriter, http.StatusBadRequest, "VALIDATION_ERROR", "Invalid body", nil)
+		return
+	}
+	now := time.Now()
+	preset := models.GenerationPreset{
+		Name:        strings.TrimSpace(presetRequest.Name),
+		Description: strings.TrimSpace(presetRequest.Description),
+		Settings:    presetRequest.Settings,
+		Sampling:    presetRequest.Sampling,
+		CreatedAt:   now,
+		UpdatedAt:   now,
+	}
+	if validationMessage := validateGenerationPreset(preset); validationMessage != "" {
+		server.writeError(responseWriter, http.StatusBadRequest, "VALIDATION_ERROR", validationMessage, nil)
+		return
+	}
+
+	userID := server.getUserID(request)
+	var presetCount int
+	if err := server.database.QueryRow("SELECT COUNT(*) FROM generation_presets WHERE user_id = ?", userID).Scan(&presetCount); err != nil {
+		server.writeError(responseWriter, http.StatusInternalServerError, "DATABASE_ERROR", "Failed to count generation presets", nil)
+		return
+	}
+	if presetCount >= maximumGenerationPresetsPerUser {
+		server.writeError(responseWriter, http.StatusConflict, "LIMIT_REACHED", "You cannot save more than 50 generation presets", nil)
+		return
+	}
+	if !server.checkGenerationPresetName(responseWriter, userID, preset) {
+		return
+	}
+
+	presetID, err := gonanoid.New()
+	if err != nil {
+		server.writeError(responseWriter, http.StatusInternalServerError, "INTERNAL_ERROR", "Failed to generate preset ID", nil)
+		return
+	}
+	preset.ID = presetID
+	if err := database.CreateGenerationPreset(server.database, userID, preset); err != nil {
+		server.writeError(responseWriter, http.StatusInternalServerError, "DATABASE_ERROR", "Failed to create generation preset", nil)
+		return
+	}
+	slog.Info("User saved a generation preset", "userID", userID, "presetID", presetID, "name", preset.Name)
+
+	server.writeJSON(responseWriter, http.StatusCreated, preset)
+}
+
+// handleUpdateGenerationPreset renames a preset of the authenticated user or replaces its description, settings
+// or sampling parameters. Fields left out are kept; settings and sampling are replaced as a whole
+func (server *Server) handleUpdateGenerationPreset(responseWriter http.ResponseWriter, request *http.Request) {
+	var updateRequest struct {
+		PresetID    string                         `json:"preset_id"`
+		Name        *string                        `json:"name"`
+		Description *string                        `json:"description"`
+		Settings    *models.ExamGenerationDefaults `json:"settings"`
+		Sampling    *models.SamplingParameters     `json:"sampling"`
+	}
+	if err := json.NewDecoder(request.Body).Decode(&updateRequest); err != nil {
+		server.writeError(responseWriter, http.StatusBadRequest, "VALIDATION_ERROR", "Invalid body", nil)
+		return
+	}
+
+	userID := server.getUserID(request)
+	preset, err := database.GetGenerationPreset(server.database, userID, updateRequest.PresetID)
+	if err == sql.ErrNoRows {
+		server.writeError(responseWriter, http.StatusNotFound, "NOT_FOUND", "Generation preset not found", nil)
+		return
+	}
+	if err != nil {
+		server.writeError(responseWriter, http.StatusInternalServerError, "DATABASE_ERROR", "Failed to get generation preset", nil)
+		return
+	}
+
+	if updateRequest.Name != nil {
+		preset.Name = strings.TrimSpace(*updateRequest.Name)
+	}
+	if updateRequest.Description != nil {
+		preset.Description = strings.TrimSpace(*updateRequest.Description)
+	}
+	if updateRequest.Settings != nil {
+		preset.Settings = *updateRequest.Settings
+	}
+	if updateRequest.Sampling != nil {
+		preset.Sampling = *updateRequest.Sampling
+	}
+	if validationMessage := validateGenerationPreset(preset); validationMessage != "" {
+		server.writeError(responseWriter, http.StatusBadRequest, "VALIDATION_ERROR", validationMessage, nil)
+		return
+	}
+	if !server.checkGenerationPresetName(responseWriter, userID, preset) {
+		return
+	}
+	preset.UpdatedAt = time.Now()
+
+	if err := database.UpdateGenerationPreset(server.database, userID, preset); err != nil {
+		server.writeError(responseWriter, http.StatusInternalServerError, "DATABASE_ERROR", "Failed to update generation preset", nil)
+		return
+	}
+	server.writeJSON(responseWriter, http.StatusOK, preset)
+}
+
+// handleDeleteGenerationPreset removes a preset of the authenticated user. Builds already queued with it keep
+// the settings it had when they were queued
+func (server *Server) handleDeleteGenerationPreset(responseWriter http.ResponseWriter, request *http.Request) {
+	var deleteRequest struct {
+		PresetID string `json:"preset_id"`
+	}
+	if err := json.NewDecoder(request.Body).Decode(&deleteRequest); err != nil {
+		server.writeError(responseWriter, http.StatusBadRequest, "VALIDATION_ERROR", "Invalid body", nil)
+		return
+	}
+
+	deleted, err := database.DeleteGenerationPreset(server.database, server.getUserID(request), deleteRequest.PresetID)
+	if err != nil {
+		server.writeError(responseWriter, http.StatusInternalServerError, "DATABASE_ERROR", "Failed to delete generation preset", nil)
+		return
+	}
+	if !deleted {
+		server.writeError(responseWriter, http.StatusNotFound, "NOT_FOUND", "Generation preset not found", nil)
+		return
+	}
+	server.writeJSON(responseWriter, http.StatusOK, map[string]string{"message": "Generation preset deleted"})
+}
+
+// checkGenerationPresetName writes the error of a preset whose name another preset of the user already has
+func (server *Server) checkGenerationPresetName(responseWriter http.ResponseWriter, userID string, preset models.GenerationPreset) bool {
+	taken, err := database.GenerationPresetNameTaken(server.database, userID, preset.Name, preset.ID)
+	if err != nil {
+		server.writeError(responseWriter, http.StatusInternalServerError, "DATABASE_ERROR", "Failed to check generation preset name", nil)
+		return false
+	}
+	if taken {
+		server.writeError(responseWriter, http.StatusConflict, "PRESET_NAME_TAKEN", "You already have a generation preset with this name", nil)
+		return false
+	}
+	return true
+}
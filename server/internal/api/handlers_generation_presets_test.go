@@ -0,0 +1,116 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"lectures/internal/models"
+)
+
+func TestGenerationPresets(t *testing.T) {
+	server, userID, sessionID, cleanup := setupUniqueExtraTestEnv(t, "generation_presets")
+	defer cleanup()
+
+	server.database.Exec("INSERT INTO exams (id, user_id, title, generation_defaults) VALUES ('preset-exam', ?, 'Optics', '{\"length\":\"long\",\"maximum_retries\":2}')", userID)
+	server.database.Exec("INSERT INTO lectures (id, exam_id, title, status) VALUES ('preset-lecture', 'preset-exam', 'Lenses', 'ready')")
+	server.database.Exec("INSERT INTO lectures (id, exam_id, title, status) VALUES ('draft-lecture', 'preset-exam', 'Mirrors', 'ready')")
+
+	sendRequest := func(method string, target string, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, target, strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer "+sessionID)
+		req.Header.Set("X-Requested-With", "XMLHttpRequest")
+		req.Header.Set("Content-Type", "application/json")
+		rr := httptest.NewRecorder()
+		server.Handler().ServeHTTP(rr, req)
+		return rr
+	}
+
+	if response := sendRequest("POST", "/api/tools/presets", `{"name":"Broken","settings":{"adherence_threshold":150}}`); response.Code != http.StatusBadRequest || !strings.Contains(response.Body.String(), "settings.adherence_threshold") {
+		t.Errorf("Expected an out of range threshold refused, got %d: %s", response.Code, response.Body.String())
+	}
+	response := sendRequest("POST", "/api/tools/presets", `{"name":" Thorough ","settings":{"adherence_threshold":90,"maximum_retries":5,"model_generation":"big-model"},"sampling":{"temperature":0.1}}`)
+	if response.Code != http.StatusCreated {
+		t.Fatalf("Expected the preset created, got %d: %s", response.Code, response.Body.String())
+	}
+	var createResponse struct {
+		Data models.GenerationPreset `json:"data"`
+	}
+	json.Unmarshal(response.Body.Bytes(), &createResponse)
+	preset := createResponse.Data
+	if preset.ID == "" || preset.Name != "Thorough" || preset.Settings.AdherenceThreshold == nil || *preset.Settings.AdherenceThreshold != 90 {
+		t.Errorf("Unexpected preset: %+v", preset)
+	}
+	if response := sendRequest("POST", "/api/tools/presets", `{"name":"Thorough"}`); response.Code != http.StatusConflict || !strings.Contains(response.Body.String(), "PRESET_NAME_TAKEN") {
+		t.Errorf("Expected a duplicate name refused, got %d: %s", response.Code, response.Body.String())
+	}
+
+	response = sendRequest("PATCH", "/api/tools/presets", `{"preset_id":"`+preset.ID+`","description":"Slow but careful"}`)
+	var updateResponse struct {
+		Data models.GenerationPreset `json:"data"`
+	}
+	json.Unmarshal(response.Body.Bytes(), &updateResponse)
+	if response.Code != http.StatusOK || updateResponse.Data.Description != "Slow but careful" || updateResponse.Data.Settings.MaximumRetries == nil || *updateResponse.Data.Settings.MaximumRetries != 5 {
+		t.Errorf("Expected the description changed and the settings kept, got %d: %s", response.Code, response.Body.String())
+	}
+
+	// The request wins over the preset, which wins over the exam's defaults
+	if response := sendRequest("POST", "/api/tools", `{"exam_id":"preset-exam","lecture_id":"preset-lecture","language_code":"en","preset_id":"missing"}`); response.Code != http.StatusNotFound {
+		t.Errorf("Expected an unknown preset refused, got %d", response.Code)
+	}
+	response = sendRequest("POST", "/api/tools", `{"exam_id":"preset-exam","lecture_id":"preset-lecture","language_code":"en","adherence_threshold":60,"sampling":{"seed":3},"preset_id":"`+preset.ID+`"}`)
+	if response.Code != http.StatusAccepted {
+		t.Fatalf("Expected the build queued, got %d: %s", response.Code, response.Body.String())
+	}
+	var payload struct {
+		Length             string                    `json:"length"`
+		AdherenceThreshold string                    `json:"adherence_threshold"`
+		MaximumRetries     string                    `json:"maximum_retries"`
+		ModelGeneration    string                    `json:"model_generation"`
+		PresetID           string                    `json:"preset_id"`
+		Sampling           models.SamplingParameters `json:"sampling"`
+	}
+	var rawPayload string
+	server.database.QueryRow("SELECT payload FROM jobs WHERE type = 'BUILD_MATERIAL' AND lecture_id = 'preset-lecture'").Scan(&rawPayload)
+	json.Unmarshal([]byte(rawPayload), &payload)
+	if payload.Length != "long" || payload.AdherenceThreshold != "60" || payload.MaximumRetries != "5" || payload.ModelGeneration != "big-model" || payload.PresetID != preset.ID {
+		t.Errorf("Unexpected payload: %s", rawPayload)
+	}
+	if payload.Sampling.Temperature == nil || *payload.Sampling.Temperature != 0.1 || payload.Sampling.Seed == nil || *payload.Sampling.Seed != 3 {
+		t.Errorf("Expected the sampling of the preset and of the request, got %+v", payload.Sampling)
+	}
+
+	// A preset can set no retries and a threshold accepting every section over the exam's defaults
+	response = sendRequest("POST", "/api/tools/presets", `{"name":"Draft","settings":{"adherence_threshold":0,"maximum_retries":0}}`)
+	var draftResponse struct {
+		Data models.GenerationPreset `json:"data"`
+	}
+	json.Unmarshal(response.Body.Bytes(), &draftResponse)
+	if response.Code != http.StatusCreated || draftResponse.Data.Settings.MaximumRetries == nil || *draftResponse.Data.Settings.MaximumRetries != 0 {
+		t.Fatalf("Expected the draft preset created with no retries, got %d: %s", response.Code, response.Body.String())
+	}
+	if response := sendRequest("POST", "/api/tools", `{"exam_id":"preset-exam","lecture_id":"draft-lecture","language_code":"en","preset_id":"`+draftResponse.Data.ID+`"}`); response.Code != http.StatusAccepted {
+		t.Fatalf("Expected the draft build queued, got %d: %s", response.Code, response.Body.String())
+	}
+	server.database.QueryRow("SELECT payload FROM jobs WHERE type = 'BUILD_MATERIAL' AND lecture_id = 'draft-lecture'").Scan(&rawPayload)
+	payload.AdherenceThreshold, payload.MaximumRetries = "", ""
+	json.Unmarshal([]byte(rawPayload), &payload)
+	if payload.AdherenceThreshold != "0" || payload.MaximumRetries != "0" {
+		t.Errorf("Expected the zeros of the preset kept, got %s", rawPayload)
+	}
+	sendRequest("DELETE", "/api/tools/presets", `{"preset_id":"`+draftResponse.Data.ID+`"}`)
+
+	if response := sendRequest("DELETE", "/api/tools/presets", `{"preset_id":"`+preset.ID+`"}`); response.Code != http.StatusOK {
+		t.Errorf("Expected the preset deleted, got %d", response.Code)
+	}
+	response = sendRequest("GET", "/api/tools/presets", "")
+	var listResponse struct {
+		Data []models.GenerationPreset `json:"data"`
+	}
+	json.Unmarshal(response.Body.Bytes(), &listResponse)
+	if response.Code != http.StatusOK || listResponse.Data == nil || len(listResponse.Data) != 0 {
+		t.Errorf("Expected no preset left, got %d: %s", response.Code, response.Body.String())
+	}
+}
@@ -0,0 +1,212 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"lectures/internal/llm"
+)
+
+// Statuses of the health report and its components
+const (
+	healthStatusOK          = "ok"
+	healthStatusDegraded    = "degraded"    // A dependency is missing: the jobs needing it fail, the rest works
+	healthStatusUnavailable = "unavailable" // A critical component failed: requests cannot be served
+)
+
+// healthCheckTimeout bounds each component check so a hanging database or provider cannot stall the probe
+const healthCheckTimeout = 5 * time.Second
+
+// providerCheckInterval is how long the reachability of the LLM provider is remembered, so frequent readiness
+// probes do not each call the provider
+const providerCheckInterval = 30 * time.Second
+
+// providerCheckTasks are the LLM tasks whose models must be reachable for the server to be ready
+var providerCheckTasks = []string{"documents_ingestion", "content_generation", "content_polishing"}
+
+// componentHealth is the outcome of checking one component
+type componentHealth struct {
+	Status    string `json:"status"`
+	Critical  bool   `json:"critical"`
+	LatencyMs int64  `json:"latency_ms"`
+	Error     string `json:"error,omitempty"`
+}
+
+// healthReport is the body of /healthz and /readyz
+type healthReport struct {
+	Status     string                     `json:"status"`
+	CheckedAt  time.Time                  `json:"checked_at"`
+	Components map[string]componentHealth `json:"components"`
+}
+
+// healthCheck checks one component, failing the whole report when critical
+type healthCheck struct {
+	name     string
+	critical bool
+	check    func(checkContext context.Context) error
+}
+
+// providerHealth remembers the last reachability check of the LLM provider
+type providerHealth struct {
+	mutex     sync.Mutex
+	checkedAt time.Time
+	err       error
+}
+
+// RegisterDependencyCheck adds an external dependency, such as the binaries a pipeline runs, to the readiness
+// report. A failing dependency degrades the report without making the server unavailable
+func (server *Server) RegisterDependencyCheck(name string, check func() error) {
+	server.dependencyChecks = append(server.dependencyChecks, healthCheck{
+		name: name,
+		check: func(checkContext context.Context) error {
+			return check()
+		},
+	})
+}
+
+// handleLiveness reports whether the server can serve requests at all: its database answers and its data
+// directory is writable
+func (server *Server) handleLiveness(responseWriter http.ResponseWriter, request *http.Request) {
+	server.writeHealthReport(responseWriter, request, server.livenessChecks())
+}
+
+// handleReadiness reports whether the server can do its work: the liveness checks, the LLM provider being
+// reachable, no provider being failed over and the external dependencies being installed
+func (server *Server) handleReadiness(responseWriter http.ResponseWriter, request *http.Request) {
+	checks := append(server.livenessChecks(), healthCheck{name: "llm_provider", critical: true, check: server.checkLLMProvider})
+	if reporter, isReporter := server.llmProvider.(providerReporter); isReporter && len(reporter.FailoverStatuses()) > 0 {
+		checks = append(checks, healthCheck{name: "llm_failover", check: server.checkLLMFailover})
+	}
+	checks = append(checks, server.dependencyChecks...)
+	server.writeHealthReport(responseWriter, request, checks)
+}
+
+func (server *Server) livenessChecks() []healthCheck {
+	return []healthCheck{
+		{name: "database", critical: true, check: func(checkContext context.Context) error {
+			return server.database.PingContext(checkContext)
+		}},
+		{name: "data_directory", critical: true, check: server.checkDataDirectory},
+	}
+}
+
+// writeHealthReport runs the checks concurrently and answers 503 when a critical one failed
+func (server *Server) writeHealthReport(responseWriter http.ResponseWriter, request *http.Request, checks []healthCheck) {
+	report := healthReport{
+		Status:     healthStatusOK,
+		CheckedAt:  time.Now(),
+		Components: make(map[string]componentHealth, len(checks)),
+	}
+
+	var mutex sync.Mutex
+	var waitGroup sync.WaitGroup
+	for _, check := range checks {
+		waitGroup.Add(1)
+		go func(check healthCheck) {
+			defer waitGroup.Done()
+			checkContext, cancel := context.WithTimeout(request.Context(), healthCheckTimeout)
+			defer cancel()
+
+			startedAt := time.Now()
+			err := check.check(checkContext)
+			component := componentHealth{Status: healthStatusOK, Critical: check.critical, LatencyMs: time.Since(startedAt).Milliseconds()}
+			if err != nil {
+				component.Status = healthStatusDegraded
+				if check.critical {
+					component.Status = healthStatusUnavailable
+				}
+				component.Error = err.Error()
+			}
+
+			mutex.Lock()
+			report.Components[check.name] = component
+			mutex.Unlock()
+		}(check)
+	}
+	waitGroup.Wait()
+
+	for _, component := range report.Components {
+		switch component.Status {
+		case healthStatusUnavailable:
+			report.Status = healthStatusUnavailable
+		case healthStatusDegraded:
+			if report.Status == healthStatusOK {
+				report.Status = healthStatusDegraded
+			}
+		}
+	}
+
+	statusCode := http.StatusOK
+	if report.Status == healthStatusUnavailable {
+		statusCode = http.StatusServiceUnavailable
+	}
+	responseWriter.Header().Set("Cache-Control", "no-store")
+	server.writeJSON(responseWriter, statusCode, report)
+}
+
+// checkDataDirectory creates and removes a file in the data directory
+func (server *Server) checkDataDirectory(checkContext context.Context) error {
+	probeFile, err := os.CreateTemp(server.configuration.Storage.DataDirectory, ".health-*")
+	if err != nil {
+		return err
+	}
+	probeFile.Close()
+	return os.Remove(probeFile.Name())
+}
+
+// checkLLMProvider runs the preflight check of the provider for the models of the main tasks, with the
+// operator's API key. The outcome is reused for providerCheckInterval
+func (server *Server) checkLLMProvider(checkContext context.Context) error {
+	preflighter, supportsPreflight := server.llmProvider.(llm.Preflighter)
+	if !supportsPreflight {
+		return nil
+	}
+
+	server.providerHealth.mutex.Lock()
+	defer server.providerHealth.mutex.Unlock()
+	if !server.providerHealth.checkedAt.IsZero() && time.Since(server.providerHealth.checkedAt) < providerCheckInterval {
+		return server.providerHealth.err
+	}
+
+	checkedModels := make(map[string]bool)
+	var err error
+	for _, task := range providerCheckTasks {
+		model := server.configuration.LLM.GetModelForTask(task)
+		if model == "" || checkedModels[model] {
+			continue
+		}
+		checkedModels[model] = true
+		if err = preflighter.Preflight(checkContext, model); err != nil {
+			break
+		}
+	}
+
+	// A probe cancelled by its caller says nothing about the provider and is not remembered
+	if checkContext.Err() == context.Canceled {
+		return err
+	}
+	server.providerHealth.checkedAt = time.Now()
+	server.providerHealth.err = err
+	return err
+}
+
+// checkLLMFailover fails while a provider is down and its calls are served by its failover provider
+func (server *Server) checkLLMFailover(checkContext context.Context) error {
+	reporter := server.llmProvider.(providerReporter)
+	var failedOver []string
+	for _, status := range reporter.FailoverStatuses() {
+		if status.State != llm.CircuitClosed {
+			failedOver = append(failedOver, fmt.Sprintf("%s is down, calls are served by %s (%s)", status.Provider, status.FailoverProvider, status.FailoverModel))
+		}
+	}
+	if len(failedOver) > 0 {
+		return errors.New(strings.Join(failedOver, "; "))
+	}
+	return nil
+}
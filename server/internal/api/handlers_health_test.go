@@ -0,0 +1,64 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// preflightingLLMProvider is a MockLLMProvider whose preflight check fails with the given error
+type preflightingLLMProvider struct {
+	MockLLMProvider
+	preflightError error
+	preflights     int
+}
+
+func (provider *preflightingLLMProvider) Preflight(jobContext context.Context, model string) error {
+	provider.preflights++
+	return provider.preflightError
+}
+
+func TestHandleHealthProbes(t *testing.T) {
+	server, _, _, cleanup := setupUniqueExtraTestEnv(t, "health_probes")
+	defer cleanup()
+	server.configuration.LLM.Model = "openrouter:test-model"
+
+	probe := func(target string) (int, healthReport) {
+		req := httptest.NewRequest("GET", target, nil)
+		rr := httptest.NewRecorder()
+		server.Handler().ServeHTTP(rr, req)
+		var response struct {
+			Data healthReport `json:"data"`
+		}
+		json.Unmarshal(rr.Body.Bytes(), &response)
+		return rr.Code, response.Data
+	}
+
+	code, report := probe("/healthz")
+	if code != http.StatusOK || report.Status != "ok" || report.Components["database"].Status != "ok" || report.Components["data_directory"].Status != "ok" {
+		t.Fatalf("Expected a healthy liveness report without authentication, got %d: %+v", code, report)
+	}
+	if _, checked := report.Components["llm_provider"]; checked {
+		t.Errorf("Expected the liveness probe not to call the provider")
+	}
+
+	server.RegisterDependencyCheck("exports", func() error { return errors.New("pandoc not found") })
+	code, report = probe("/readyz")
+	if code != http.StatusOK || report.Status != "degraded" || report.Components["exports"].Error != "pandoc not found" {
+		t.Errorf("Expected a missing dependency to degrade readiness, got %d: %+v", code, report)
+	}
+
+	provider := &preflightingLLMProvider{preflightError: errors.New("OpenRouter is not reachable")}
+	server.llmProvider = provider
+	code, report = probe("/readyz")
+	if code != http.StatusServiceUnavailable || report.Status != "unavailable" || report.Components["llm_provider"].Status != "unavailable" {
+		t.Errorf("Expected an unreachable provider to fail readiness, got %d: %+v", code, report)
+	}
+	probe("/readyz")
+	if provider.preflights != 1 {
+		t.Errorf("Expected the provider check to be reused between probes, got %d preflights", provider.preflights)
+	}
+}
@@ -0,0 +1,331 @@
+package api
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"lectures/internal/database"
+	"lectures/internal/markdown"
+	"lectures/internal/models"
+)
+
+// Bounds of a page of the history of an exam
+const (
+	defaultHistoryLimit = 100
+	maximumHistoryLimit = 500
+)
+
+// recordEvent adds an event made by the user of the request to the history of its exam. The history is a
+// convenience, so failing to record it never fails the change
+func (server *Server) recordEvent(request *http.Request, event models.ResourceEvent) {
+	event.UserID = server.getUserID(request)
+	if err := database.RecordResourceEvent(server.database, event); err != nil {
+		slog.Warn("Failed to record history event", "examID", event.ExamID, "resourceType", event.ResourceType, "action", event.Action, "error", err)
+	}
+}
+
+// saveToolVersion keeps the content of a tool about to be replaced, returning an empty ID when it could not
+func (server *Server) saveToolVersion(toolID string, reason string) string {
+	versionID, err := database.SaveToolVersion(server.database, toolID, reason)
+	if err != nil {
+		slog.Warn("Failed to keep tool version", "toolID", toolID, "reason", reason, "error", err)
+		return ""
+	}
+	return versionID
+}
+
+// restoreToolVersionUndo is the undo of a change whose replaced tool content was kept as the given version
+func restoreToolVersionUndo(examID string, versionID string, description string) *models.ResourceEventUndo {
+	if versionID == "" {
+		return nil
+	}
+	return &models.ResourceEventUndo{
+		Description: description,
+		Method:      http.MethodPost,
+		Path:        "/api/tools/versions/restore",
+		Body:        map[string]any{"exam_id": examID, "version_id": versionID},
+	}
+}
+
+// handleGetExamHistory lists what happened to an exam, its lectures and their documents, media, transcripts,
+// tools and exports, newest first, optionally for one lecture or resource
+func (server *Server) handleGetExamHistory(responseWriter http.ResponseWriter, request *http.Request) {
+	query := request.URL.Query()
+	examID := query.Get("exam_id")
+	if examID == "" {
+		server.writeError(responseWriter, http.StatusBadRequest, "VALIDATION_ERROR", "exam_id is required", nil)
+		return
+	}
+
+	filter := database.ResourceEventFilter{
+		LectureID:    query.Get("lecture_id"),
+		ResourceType: query.Get("resource_type"),
+		ResourceID:   query.Get("resource_id"),
+		Limit:        defaultHistoryLimit,
+	}
+	if limitValue := query.Get("limit"); limitValue != "" {
+		limit, err := strconv.Atoi(limitValue)
+		if err != nil || limit < 1 || limit > maximumHistoryLimit {
+			server.writeError(responseWriter, http.StatusBadRequest, "VALIDATION_ERROR", fmt.Sprintf("limit must be between 1 and %d", maximumHistoryLimit), nil)
+			return
+		}
+		filter.Limit = limit
+	}
+	if beforeValue := query.Get("before_id"); beforeValue != "" {
+		beforeID, err := strconv.ParseInt(beforeValue, 10, 64)
+		if err != nil || beforeID < 1 {
+			server.writeError(responseWriter, http.StatusBadRequest, "VALIDATION_ERROR", "before_id must be an event ID", nil)
+			return
+		}
+		filter.BeforeID = beforeID
+	}
+
+	if !server.authorizeExam(responseWriter, request, examID, models.ExamRoleViewer) {
+		return
+	}
+
+	events, err := database.ListResourceEvents(server.database, examID, filter)
+	if err != nil {
+		server.writeError(responseWriter, http.StatusInternalServerError, "DATABASE_ERROR", "Failed to list history", nil)
+		return
+	}
+
+	server.writeJSON(responseWriter, http.StatusOK, events)
+}
+
+// canSeeToolVersion reports whether a kept version belongs to examID and, for a deck built from bookmarks, to the
+// caller's own bookmarks
+func (server *Server) canSeeToolVersion(request *http.Request, version models.ToolVersion, examID string) bool {
+	return version.ExamID == examID && (version.BookmarkUserID == "" || version.BookmarkUserID == server.getUserID(request))
+}
+
+// handleListToolVersions lists the kept versions of the tools of an exam, optionally of a lecture and a type
+func (server *Server) handleListToolVersions(responseWriter http.ResponseWriter, request *http.Request) {
+	examID := request.URL.Query().Get("exam_id")
+	if examID == "" {
+		server.writeError(responseWriter, http.StatusBadRequest, "VALIDATION_ERROR", "exam_id is required", nil)
+		return
+	}
+
+	if !server.authorizeExam(responseWriter, request, examID, models.ExamRoleViewer) {
+		return
+	}
+
+	versions, err := database.ListToolVersions(server.database, examID, server.getUserID(request), request.URL.Query().Get("lecture_id"), request.URL.Query().Get("type"))
+	if err != nil {
+		server.writeError(responseWriter, http.StatusInternalServerError, "DATABASE_ERROR", "Failed to list tool versions", nil)
+		return
+	}
+
+	server.writeJSON(responseWriter, http.StatusOK, versions)
+}
+
+// handleRestoreToolVersion puts a kept version back as the tool of its lecture and type. The content it
+// replaces is kept as a version in turn, so restoring can be undone; a tool deleted since is recreated. It is
+// also served as POST /api/tools/rollback
+func (server *Server) handleRestoreToolVersion(responseWriter http.ResponseWriter, request *http.Request) {
+	var restoreRequest struct {
+		ExamID    string `json:"exam_id"`
+		VersionID string `json:"version_id"`
+	}
+	if err := json.NewDecoder(request.Body).Decode(&restoreRequest); err != nil {
+		server.writeError(responseWriter, http.StatusBadRequest, "VALIDATION_ERROR", "Invalid body", nil)
+		return
+	}
+
+	if restoreRequest.ExamID == "" || restoreRequest.VersionID == "" {
+		server.writeError(responseWriter, http.StatusBadRequest, "VALIDATION_ERROR", "exam_id and version_id are required", nil)
+		return
+	}
+
+	if !server.authorizeExam(responseWriter, request, restoreRequest.ExamID, models.ExamRoleManager) {
+		return
+	}
+
+	version, err := database.GetToolVersion(server.database, restoreRequest.VersionID)
+	if err == sql.ErrNoRows || (err == nil && !server.canSeeToolVersion(request, version, restoreRequest.ExamID)) {
+		server.writeError(responseWriter, http.StatusNotFound, "NOT_FOUND", "Tool version not found in this exam", nil)
+		return
+	}
+	if err != nil {
+		server.writeError(responseWriter, http.StatusInternalServerError, "DATABASE_ERROR", "Failed to get tool version", nil)
+		return
+	}
+
+	transaction, err := server.database.Begin()
+	if err != nil {
+		server.writeError(responseWriter, http.StatusInternalServerError, "DATABASE_ERROR", "Failed to restore tool version", nil)
+		return
+	}
+	defer transaction.Rollback()
+
+	var toolID, replacedVersionID string
+	var purgedExportKeys []string
+	transaction.QueryRow(`
+		SELECT id FROM tools
+		WHERE exam_id = ? AND COALESCE(lecture_id, '') = ? AND type = ? AND COALESCE(bookmark_user_id, '') = ?
+		ORDER BY created_at DESC LIMIT 1
+	`, version.ExamID, version.LectureID, version.Type, version.BookmarkUserID).Scan(&toolID)
+	if toolID != "" {
+		replacedVersionID, err = database.SaveToolVersion(transaction, toolID, models.ResourceActionRestored)
+		if err == nil {
+			_, err = transaction.Exec("UPDATE tools SET title = ?, language_code = ?, content = ?, updated_at = ? WHERE id = ?",
+				version.Title, version.LanguageCode, version.Content, time.Now(), toolID)
+		}
+		if err == nil {
+			purgedExportKeys, err = database.InvalidateToolContent(transaction, toolID)
+		}
+	} else {
+		toolID = version.ToolID
+		_, err = transaction.Exec(`
+			INSERT INTO tools (id, exam_id, lecture_id, type, title, language_code, content, bookmark_user_id, created_at, updated_at)
+			VALUES (?, ?, NULLIF(?, ''), ?, ?, ?, ?, NULLIF(?, ''), ?, ?)
+		`, toolID, version.ExamID, version.LectureID, version.Type, version.Title, version.LanguageCode, version.Content, version.BookmarkUserID, time.Now(), time.Now())
+	}
+	if err == nil {
+		err = transaction.Commit()
+	}
+	if err != nil {
+		server.writeError(responseWriter, http.StatusInternalServerError, "DATABASE_ERROR", "Failed to restore tool version", nil)
+		return
+	}
+	server.deleteStoredObjects(purgedExportKeys)
+
+	server.recordEvent(request, models.ResourceEvent{
+		ExamID:       version.ExamID,
+		LectureID:    version.LectureID,
+		ResourceType: models.ResourceTypeTool,
+		ResourceID:   toolID,
+		Action:       models.ResourceActionRestored,
+		Summary:      fmt.Sprintf("Restored the %s \"%s\" as it was on %s", version.Type, version.Title, version.CreatedAt.Format("2006-01-02 15:04")),
+		VersionID:    replacedVersionID,
+		Undo:         restoreToolVersionUndo(version.ExamID, replacedVersionID, "Put back the content the restore replaced"),
+	})
+
+	server.writeJSON(responseWriter, http.StatusOK, map[string]string{
+		"tool_id":             toolID,
+		"version_id":          version.ID,
+		"replaced_version_id": replacedVersionID,
+	})
+}
+
+// handleDiffToolVersion compares a kept version with another version of the same lecture and type, given as
+// against, or by default with the current tool of the version's lecture, type and bookmarks. Flashcards and quizzes are compared as the Markdown they export
+// to, one section per card or question
+func (server *Server) handleDiffToolVersion(responseWriter http.ResponseWriter, request *http.Request) {
+	examID := request.URL.Query().Get("exam_id")
+	versionID := request.URL.Query().Get("version_id")
+	againstID := request.URL.Query().Get("against")
+	if examID == "" || versionID == "" {
+		server.writeError(responseWriter, http.StatusBadRequest, "VALIDATION_ERROR", "exam_id and version_id are required", nil)
+		return
+	}
+
+	if !server.authorizeExam(responseWriter, request, examID, models.ExamRoleViewer) {
+		return
+	}
+
+	version, err := database.GetToolVersion(server.database, versionID)
+	if err == sql.ErrNoRows || (err == nil && !server.canSeeToolVersion(request, version, examID)) {
+		server.writeError(responseWriter, http.StatusNotFound, "NOT_FOUND", "Tool version not found in this exam", nil)
+		return
+	}
+	if err != nil {
+		server.writeError(responseWriter, http.StatusInternalServerError, "DATABASE_ERROR", "Failed to get tool version", nil)
+		return
+	}
+
+	var against models.ToolVersion
+	if againstID != "" {
+		against, err = database.GetToolVersion(server.database, againstID)
+		if err == sql.ErrNoRows || (err == nil && !server.canSeeToolVersion(request, against, examID)) {
+			server.writeError(responseWriter, http.StatusNotFound, "NOT_FOUND", "Tool version to compare against not found in this exam", nil)
+			return
+		}
+		if err != nil {
+			server.writeError(responseWriter, http.StatusInternalServerError, "DATABASE_ERROR", "Failed to get tool version", nil)
+			return
+		}
+		if against.LectureID != version.LectureID || against.Type != version.Type || against.BookmarkUserID != version.BookmarkUserID {
+			server.writeError(responseWriter, http.StatusBadRequest, "VALIDATION_ERROR", "Only versions of the same lecture and tool type can be compared", nil)
+			return
+		}
+	} else {
+		// A bookmark deck and the lecture's deck share their lecture and type, so the current tool is the one
+		// the version was kept from, or the one replacing it for the same bookmarks
+		var languageCode sql.NullString
+		err = server.database.QueryRow(`
+			SELECT id, title, language_code, content FROM tools
+			WHERE exam_id = ? AND COALESCE(lecture_id, '') = ? AND type = ? AND COALESCE(bookmark_user_id, '') = ?
+			ORDER BY id = ? DESC, created_at DESC LIMIT 1
+		`, version.ExamID, version.LectureID, version.Type, version.BookmarkUserID, version.ToolID).Scan(&against.ToolID, &against.Title, &languageCode, &against.Content)
+		if err == sql.ErrNoRows {
+			server.writeError(responseWriter, http.StatusNotFound, "NOT_FOUND", "The tool of this version was deleted; compare it against another version", nil)
+			return
+		}
+		if err != nil {
+			server.writeError(responseWriter, http.StatusInternalServerError, "DATABASE_ERROR", "Failed to get tool", nil)
+			return
+		}
+		against.LanguageCode = languageCode.String
+	}
+
+	blocks := markdown.Diff(toolVersionMarkdown(version), toolVersionMarkdown(against))
+	counts := map[string]int{markdown.DiffEqual: 0, markdown.DiffAdded: 0, markdown.DiffRemoved: 0}
+	for _, block := range blocks {
+		counts[block.Operation]++
+	}
+
+	server.writeJSON(responseWriter, http.StatusOK, map[string]any{
+		"version_id":         version.ID,
+		"against_version_id": against.ID,
+		"against_tool_id":    against.ToolID,
+		"type":               version.Type,
+		"blocks":             blocks,
+		"added":              counts[markdown.DiffAdded],
+		"removed":            counts[markdown.DiffRemoved],
+		"unchanged":          counts[markdown.DiffEqual],
+	})
+}
+
+// toolVersionMarkdown is the Markdown a version of a tool is compared as
+func toolVersionMarkdown(version models.ToolVersion) string {
+	switch version.Type {
+	case "flashcard":
+		return markdown.FlashcardsToMarkdown(version.Title, version.Content)
+	case "quiz":
+		return markdown.QuizToMarkdown(version.Title, version.Content, version.LanguageCode)
+	case models.ToolTypeMindmap:
+		return markdown.MindmapToMarkdown(version.Title, version.Content, version.LanguageCode)
+	case models.ToolTypeMockExam:
+		return markdown.MockExamToMarkdown(version.Title, version.Content, version.LanguageCode)
+	default:
+		return version.Content
+	}
+}
+
+// describeUpdate names the change made to an exam or a lecture: a rename, mentioning the other fields changed
+// along with the title, or an update of the listed fields. It returns an empty action when nothing changed
+func describeUpdate(kind string, previousTitle string, newTitle string, changedFields []string) (string, string) {
+	fields := strings.Join(changedFields, ", ")
+	if index := strings.LastIndex(fields, ", "); index >= 0 {
+		fields = fields[:index] + " and " + fields[index+2:]
+	}
+	if newTitle != previousTitle {
+		summary := fmt.Sprintf("Renamed the %s \"%s\" to \"%s\"", kind, previousTitle, newTitle)
+		if fields != "" {
+			summary += ", changing its " + fields
+		}
+		return models.ResourceActionRenamed, summary
+	}
+	if fields == "" {
+		return "", ""
+	}
+	return models.ResourceActionUpdated, fmt.Sprintf("Changed the %s of the %s \"%s\"", fields, kind, previousTitle)
+}
@@ -0,0 +1,167 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"lectures/internal/markdown"
+	"lectures/internal/models"
+)
+
+func TestHandleExamHistory(t *testing.T) {
+	server, userID, sessionID, cleanup := setupUniqueExtraTestEnv(t, "exam_history")
+	defer cleanup()
+
+	server.database.Exec("INSERT INTO exams (id, user_id, title) VALUES ('history-exam', ?, 'Optics')", userID)
+	server.database.Exec("INSERT INTO lectures (id, exam_id, title, status) VALUES ('history-lecture', 'history-exam', 'Lenses', 'ready')")
+	server.database.Exec(`INSERT INTO tools (id, exam_id, lecture_id, type, title, content) VALUES ('history-guide', 'history-exam', 'history-lecture', 'guide', 'Lenses guide', '# Original guide')`)
+
+	sendRequest := func(method string, target string, body any) *httptest.ResponseRecorder {
+		var bodyReader io.Reader
+		if body != nil {
+			bodyBytes, _ := json.Marshal(body)
+			bodyReader = bytes.NewReader(bodyBytes)
+		}
+		req := httptest.NewRequest(method, target, bodyReader)
+		req.Header.Set("Authorization", "Bearer "+sessionID)
+		req.Header.Set("X-Requested-With", "XMLHttpRequest")
+		req.Header.Set("Content-Type", "application/json")
+		rr := httptest.NewRecorder()
+		server.Handler().ServeHTTP(rr, req)
+		return rr
+	}
+	getHistory := func(query string) []models.ResourceEvent {
+		rr := sendRequest("GET", "/api/exams/history?exam_id=history-exam"+query, nil)
+		if rr.Code != http.StatusOK {
+			t.Fatalf("Expected status 200 listing the history, got %d: %s", rr.Code, rr.Body.String())
+		}
+		var response struct {
+			Data []models.ResourceEvent `json:"data"`
+		}
+		json.Unmarshal(rr.Body.Bytes(), &response)
+		return response.Data
+	}
+	undo := func(event models.ResourceEvent) {
+		if event.Undo == nil {
+			t.Fatalf("Expected an undo for %s %s", event.ResourceType, event.Action)
+		}
+		if rr := sendRequest(event.Undo.Method, event.Undo.Path, event.Undo.Body); rr.Code != http.StatusOK {
+			t.Fatalf("Expected the undo to succeed, got %d: %s", rr.Code, rr.Body.String())
+		}
+	}
+	toolContent := func() string {
+		var content string
+		server.database.QueryRow("SELECT content FROM tools WHERE lecture_id = 'history-lecture' AND type = 'guide'").Scan(&content)
+		return content
+	}
+
+	if rr := sendRequest("PATCH", "/api/lectures", map[string]any{"exam_id": "history-exam", "lecture_id": "history-lecture", "title": "Thin lenses"}); rr.Code != http.StatusOK {
+		t.Fatalf("Expected status 200 renaming the lecture, got %d", rr.Code)
+	}
+	events := getHistory("&lecture_id=history-lecture")
+	if len(events) != 1 || events[0].Action != models.ResourceActionRenamed || events[0].Username != "userexam_history" {
+		t.Fatalf("Expected the rename by its user, got %+v", events)
+	}
+	if events[0].Undo == nil || events[0].Undo.Body["title"] != "Lenses" {
+		t.Errorf("Expected the undo to rename the lecture back, got %+v", events[0].Undo)
+	}
+
+	t.Run("Tool edits can be undone", func(t *testing.T) {
+		if rr := sendRequest("PATCH", "/api/tools/details", map[string]any{"exam_id": "history-exam", "tool_id": "history-guide", "content": "# Edited guide"}); rr.Code != http.StatusOK {
+			t.Fatalf("Expected status 200 editing the tool, got %d", rr.Code)
+		}
+		events := getHistory("&resource_type=tool")
+		if len(events) != 1 || events[0].Action != models.ResourceActionEdited || events[0].VersionID == "" {
+			t.Fatalf("Expected the edit with the replaced version, got %+v", events)
+		}
+
+		undo(events[0])
+		if content := toolContent(); content != "# Original guide" {
+			t.Errorf("Expected the original guide back, got %q", content)
+		}
+		if events := getHistory("&resource_type=tool"); len(events) != 2 || events[0].Action != models.ResourceActionRestored || events[0].Undo == nil {
+			t.Errorf("Expected the restore recorded first with its own undo, got %+v", events)
+		}
+	})
+
+	t.Run("Deleted tools can be restored", func(t *testing.T) {
+		if rr := sendRequest("DELETE", "/api/tools", map[string]any{"exam_id": "history-exam", "tool_id": "history-guide"}); rr.Code != http.StatusOK {
+			t.Fatalf("Expected status 200 deleting the tool, got %d", rr.Code)
+		}
+		events := getHistory("&resource_type=tool&limit=1")
+		if len(events) != 1 || events[0].Action != models.ResourceActionDeleted {
+			t.Fatalf("Expected the deletion first, got %+v", events)
+		}
+
+		undo(events[0])
+		if content := toolContent(); content != "# Original guide" {
+			t.Errorf("Expected the deleted guide recreated, got %q", content)
+		}
+
+		rr := sendRequest("GET", "/api/tools/versions?exam_id=history-exam&lecture_id=history-lecture", nil)
+		if rr.Code != http.StatusOK || !strings.Contains(rr.Body.String(), `"reason": "deleted"`) || strings.Contains(rr.Body.String(), `"content"`) {
+			t.Errorf("Expected the versions listed without their content, got %d: %s", rr.Code, rr.Body.String())
+		}
+	})
+
+	t.Run("Versions are compared with the current tool", func(t *testing.T) {
+		var editedVersionID string
+		server.database.QueryRow("SELECT id FROM tool_versions WHERE content = '# Edited guide'").Scan(&editedVersionID)
+
+		// A newer tool of the same lecture and type built from bookmarks is not the current tool of the version
+		server.database.Exec("INSERT INTO tools (id, exam_id, lecture_id, type, title, content, bookmark_user_id, created_at) VALUES ('history-bookmarks', 'history-exam', 'history-lecture', 'guide', 'Bookmarks', '# Bookmarks', ?, ?)", userID, time.Now().Add(time.Hour))
+
+		rr := sendRequest("GET", "/api/tools/versions/diff?exam_id=history-exam&version_id="+editedVersionID, nil)
+		if rr.Code != http.StatusOK {
+			t.Fatalf("Expected status 200 comparing the version, got %d: %s", rr.Code, rr.Body.String())
+		}
+		var response struct {
+			Data struct {
+				AgainstToolID string               `json:"against_tool_id"`
+				Blocks        []markdown.DiffBlock `json:"blocks"`
+				Added         int                  `json:"added"`
+				Removed       int                  `json:"removed"`
+			} `json:"data"`
+		}
+		json.Unmarshal(rr.Body.Bytes(), &response)
+		if response.Data.AgainstToolID != "history-guide" || response.Data.Added != 1 || response.Data.Removed != 1 {
+			t.Fatalf("Expected one heading replaced in the current guide, got %+v", response.Data)
+		}
+		if block := response.Data.Blocks[0]; block.Operation != markdown.DiffRemoved || block.Content != "# Edited guide" {
+			t.Errorf("Expected the edited heading removed first, got %+v", block)
+		}
+
+		if rr := sendRequest("GET", "/api/tools/versions/diff?exam_id=history-exam&version_id="+editedVersionID+"&against=missing", nil); rr.Code != http.StatusNotFound {
+			t.Errorf("Expected status 404 against an unknown version, got %d", rr.Code)
+		}
+
+		server.database.Exec("DELETE FROM tools WHERE id = 'history-bookmarks'")
+		if rr := sendRequest("POST", "/api/tools/rollback", map[string]any{"exam_id": "history-exam", "version_id": editedVersionID}); rr.Code != http.StatusOK {
+			t.Fatalf("Expected status 200 rolling back, got %d: %s", rr.Code, rr.Body.String())
+		}
+		if content := toolContent(); content != "# Edited guide" {
+			t.Errorf("Expected the rollback to put the edited guide back, got %q", content)
+		}
+	})
+
+	t.Run("Paging and access", func(t *testing.T) {
+		all := getHistory("")
+		older := getHistory(fmt.Sprintf("&before_id=%d", all[0].ID))
+		if len(older) != len(all)-1 || older[0].ID != all[1].ID {
+			t.Errorf("Expected the events older than the newest, got %d of %d", len(older), len(all))
+		}
+		if rr := sendRequest("GET", "/api/exams/history?exam_id=history-exam&limit=0", nil); rr.Code != http.StatusBadRequest {
+			t.Errorf("Expected status 400 for a zero limit, got %d", rr.Code)
+		}
+		if rr := sendRequest("POST", "/api/tools/versions/restore", map[string]any{"exam_id": "history-exam", "version_id": "missing"}); rr.Code != http.StatusNotFound {
+			t.Errorf("Expected status 404 for an unknown version, got %d", rr.Code)
+		}
+	})
+}
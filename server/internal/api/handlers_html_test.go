@@ -34,7 +34,7 @@ func setupHTMLTestEnv(t *testing.T) (*Server, string, string, func()) {
 	// Create user and session
 	userID := "user-123"
 	sessionID := gonanoid.Must()
-	_, _ = db.Exec("INSERT INTO users (id, username, password_hash, role) VALUES (?, ?, ?, ?)", userID, "testuser", "hash", "user")
+	_, _ = db.Exec("INSERT INTO users (id, username, password_hash, role) VALUES (?, ?, ?, ?)", userID, "testuser", "hash", "teacher")
 	_, _ = db.Exec("INSERT INTO auth_sessions (id, user_id, created_at, last_activity, expires_at) VALUES (?, ?, ?, ?, ?)", sessionID, userID, time.Now(), time.Now(), time.Now().Add(1*time.Hour))
 
 	config := &configuration.Configuration{
@@ -313,3 +313,43 @@ func TestHandleGetToolHTML_Quiz(t *testing.T) {
 		t.Errorf("Expected 2 options, got %d", len(apiResponse.Data.Content[0].OptionsHTML))
 	}
 }
+
+func TestHandleGetToolHTML_Mindmap(t *testing.T) {
+	server, _, sessionID, cleanup := setupHTMLTestEnv(t)
+	defer cleanup()
+
+	content := `{"nodes": [{"id": "a", "label": "Optics", "description": "The study of **light**."}, {"id": "b", "label": "Lenses", "description": ""}], "edges": [{"from": "a", "to": "b", "label": "studies"}]}`
+	_, _ = server.database.Exec("INSERT INTO exams (id, user_id, title) VALUES ('exam-1', 'user-123', 'Test Exam')")
+	if _, err := server.database.Exec("INSERT INTO tools (id, exam_id, type, title, content) VALUES ('map-1', 'exam-1', 'mindmap', 'Optics', ?)", content); err != nil {
+		t.Fatalf("Expected the tools table to accept mindmaps: %v", err)
+	}
+
+	req := httptest.NewRequest("GET", "/api/tools/html?tool_id=map-1&exam_id=exam-1", nil)
+	req.Header.Set("Authorization", "Bearer "+sessionID)
+	req.Header.Set("X-Requested-With", "XMLHttpRequest")
+	rr := httptest.NewRecorder()
+	server.Handler().ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", rr.Code, rr.Body.String())
+	}
+	var apiResponse struct {
+		Data struct {
+			Content struct {
+				Mermaid string `json:"mermaid"`
+				Nodes   []struct {
+					Label           string `json:"label"`
+					DescriptionHTML string `json:"description_html"`
+				} `json:"nodes"`
+			} `json:"content"`
+		} `json:"data"`
+	}
+	json.NewDecoder(rr.Body).Decode(&apiResponse)
+
+	if !strings.Contains(apiResponse.Data.Content.Mermaid, `n1 -->|"studies"| n2`) {
+		t.Errorf("Expected the relation in the diagram, got:\n%s", apiResponse.Data.Content.Mermaid)
+	}
+	if len(apiResponse.Data.Content.Nodes) != 2 || !strings.Contains(apiResponse.Data.Content.Nodes[0].DescriptionHTML, "light") {
+		t.Errorf("Expected both concepts with their descriptions, got %+v", apiResponse.Data.Content.Nodes)
+	}
+}
@@ -1,16 +1,20 @@
 package api
 
 import (
+	"database/sql"
 	"encoding/json"
 	"net/http"
+	"strings"
+	"time"
 
+	"lectures/internal/media"
 	"lectures/internal/models"
 )
 
-// handleImport handles requests to import files from external providers (Google Drive, Dropbox, etc.)
+// handleImport handles requests to import files from external providers (Google Drive, YouTube, etc.)
 func (server *Server) handleImport(responseWriter http.ResponseWriter, request *http.Request) {
 	var importRequest struct {
-		Source   string          `json:"source"`   // e.g., "google_drive", "dropbox"
+		Source   string          `json:"source"`   // e.g., "google_drive", "youtube"
 		Filename string          `json:"filename"` // Optional override
 		Data     json.RawMessage `json:"data"`     // Provider-specific data
 	}
@@ -53,6 +57,58 @@ func (server *Server) handleImport(responseWriter http.ResponseWriter, request *
 			"filename":    importRequest.Filename,
 		}, "", "")
 
+	case "youtube":
+		// The audio is added to an existing lecture, whose captions language hint is its language
+		var youtubeData struct {
+			URL         string `json:"url"`
+			LectureID   string `json:"lecture_id"`
+			ExamID      string `json:"exam_id"`
+			UseCaptions *bool  `json:"use_captions"` // Defaults to true
+		}
+		if err := json.Unmarshal(importRequest.Data, &youtubeData); err != nil {
+			server.writeError(responseWriter, http.StatusBadRequest, "VALIDATION_ERROR", "Invalid data for youtube source", nil)
+			return
+		}
+
+		if youtubeData.URL == "" || youtubeData.LectureID == "" || youtubeData.ExamID == "" {
+			server.writeError(responseWriter, http.StatusBadRequest, "VALIDATION_ERROR", "url, lecture_id and exam_id are required for youtube", nil)
+			return
+		}
+		youtubeData.URL = strings.TrimSpace(youtubeData.URL)
+		if !media.IsYouTubeURL(youtubeData.URL) {
+			server.writeError(responseWriter, http.StatusBadRequest, "VALIDATION_ERROR", "url must be a YouTube video address", nil)
+			return
+		}
+
+		var language string
+		err := server.database.QueryRow(`
+			SELECT COALESCE(lectures.language, '') FROM lectures
+			JOIN exams ON lectures.exam_id = exams.id
+			WHERE lectures.id = ? AND lectures.exam_id = ? AND `+examAccess(models.ExamRoleManager)+`
+		`, youtubeData.LectureID, youtubeData.ExamID, userID).Scan(&language)
+		if err == sql.ErrNoRows {
+			server.writeError(responseWriter, http.StatusNotFound, "NOT_FOUND", "Lecture not found", nil)
+			return
+		}
+		if err != nil {
+			server.writeError(responseWriter, http.StatusInternalServerError, "DATABASE_ERROR", "Failed to verify lecture", nil)
+			return
+		}
+
+		// The lecture is not ready until the video is imported and transcribed
+		if _, err := server.database.Exec("UPDATE lectures SET status = 'processing', updated_at = ? WHERE id = ?", time.Now(), youtubeData.LectureID); err != nil {
+			server.writeError(responseWriter, http.StatusInternalServerError, "DATABASE_ERROR", "Failed to update lecture status", nil)
+			return
+		}
+
+		useCaptions := youtubeData.UseCaptions == nil || *youtubeData.UseCaptions
+		jobIdentifier, enqueuingError = server.jobQueue.Enqueue(userID, models.JobTypeImportYouTube, map[string]any{
+			"lecture_id":    youtubeData.LectureID,
+			"url":           youtubeData.URL,
+			"use_captions":  useCaptions,
+			"language_code": language,
+		}, youtubeData.ExamID, youtubeData.LectureID)
+
 	// Future providers can be added here
 	// case "dropbox":
 	//     ...
@@ -63,7 +119,7 @@ func (server *Server) handleImport(responseWriter http.ResponseWriter, request *
 	}
 
 	if enqueuingError != nil {
-		server.writeError(responseWriter, http.StatusInternalServerError, "BACKGROUND_JOB_ERROR", "Failed to enqueue download job", nil)
+		server.writeEnqueueError(responseWriter, enqueuingError, "Failed to enqueue download job")
 		return
 	}
 
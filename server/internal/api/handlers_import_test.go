@@ -37,7 +37,7 @@ func setupImportTestEnv(t *testing.T) (*Server, *jobs.Queue, string, func()) {
 	// 3. User & Session
 	userID := "test-user-id"
 	_ = gonanoid.Must()
-	_, _ = db.Exec("INSERT INTO users (id, username, password_hash, role) VALUES (?, ?, ?, ?)", userID, "testuser", "hash", "user")
+	_, _ = db.Exec("INSERT INTO users (id, username, password_hash, role) VALUES (?, ?, ?, ?)", userID, "testuser", "hash", "teacher")
 
 	// 4. Job Queue
 	jobQueue := jobs.NewQueue(db, 1) // 1 worker
@@ -172,3 +172,62 @@ func TestHandleImport_GoogleDrive(t *testing.T) {
 		})
 	}
 }
+
+func TestHandleImport_YouTube(t *testing.T) {
+	server, _, userID, cleanup := setupImportTestEnv(t)
+	defer cleanup()
+
+	server.database.Exec("INSERT INTO exams (id, user_id, title) VALUES ('exam-1', ?, 'Physics')", userID)
+	server.database.Exec("INSERT INTO lectures (id, exam_id, title, status, language) VALUES ('lecture-1', 'exam-1', 'Heat', 'ready', 'en')")
+
+	tests := []struct {
+		name           string
+		data           map[string]any
+		expectedStatus int
+	}{
+		{"Valid YouTube Request", map[string]any{"url": " https://www.youtube.com/watch?v=abc ", "lecture_id": "lecture-1", "exam_id": "exam-1", "use_captions": false}, http.StatusAccepted},
+		{"Not A YouTube URL", map[string]any{"url": "https://example.com/video", "lecture_id": "lecture-1", "exam_id": "exam-1"}, http.StatusBadRequest},
+		{"Missing Lecture", map[string]any{"url": "https://youtu.be/abc", "exam_id": "exam-1"}, http.StatusBadRequest},
+		{"Unknown Lecture", map[string]any{"url": "https://youtu.be/abc", "lecture_id": "lecture-2", "exam_id": "exam-1"}, http.StatusNotFound},
+	}
+
+	for _, testCase := range tests {
+		t.Run(testCase.name, func(t *testing.T) {
+			requestBody, _ := json.Marshal(map[string]any{"source": "youtube", "data": testCase.data})
+			importRequest, _ := http.NewRequest("POST", "/api/uploads/import", bytes.NewBuffer(requestBody))
+			importRequest = importRequest.WithContext(context.WithValue(importRequest.Context(), userIDKey, userID))
+
+			responseRecorder := httptest.NewRecorder()
+			server.handleImport(responseRecorder, importRequest)
+
+			if responseRecorder.Code != testCase.expectedStatus {
+				t.Fatalf("Expected status %d, got %d. Body: %s", testCase.expectedStatus, responseRecorder.Code, responseRecorder.Body.String())
+			}
+			if testCase.expectedStatus != http.StatusAccepted {
+				return
+			}
+
+			var apiResponse struct {
+				Data struct {
+					JobID string `json:"job_id"`
+				} `json:"data"`
+			}
+			json.Unmarshal(responseRecorder.Body.Bytes(), &apiResponse)
+			job, err := server.jobQueue.GetJob(apiResponse.Data.JobID)
+			if err != nil {
+				t.Fatalf("Failed to get job: %v", err)
+			}
+			var payload map[string]any
+			json.Unmarshal([]byte(job.Payload), &payload)
+			if job.Type != models.JobTypeImportYouTube || job.LectureID != "lecture-1" || payload["url"] != "https://www.youtube.com/watch?v=abc" || payload["use_captions"] != false || payload["language_code"] != "en" {
+				t.Errorf("Unexpected job: %+v", job)
+			}
+
+			var status string
+			server.database.QueryRow("SELECT status FROM lectures WHERE id = 'lecture-1'").Scan(&status)
+			if status != "processing" {
+				t.Errorf("Expected the lecture to be processing, got %s", status)
+			}
+		})
+	}
+}
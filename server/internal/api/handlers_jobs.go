@@ -3,18 +3,83 @@ package api
 import (
 	"database/sql"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"net/http"
+	"strconv"
+	"strings"
 	"time"
+	"unicode/utf8"
+
+	"lectures/internal/database"
+	"lectures/internal/jobs"
+	"lectures/internal/models"
+)
+
+// Limits of the label and note users attach to their jobs
+const (
+	maximumJobLabelLength = 64
+	maximumJobNoteLength  = 1000
 )
 
-// handleListJobs lists recent background jobs for the current user
+// How long requests following a job log wait for a new line, and how often they look for one
+const (
+	defaultJobLogFollowWait = 25 * time.Second
+	maximumJobLogFollowWait = 60 * time.Second
+	jobLogFollowInterval    = 500 * time.Millisecond
+)
+
+// jobSortColumns are the fields jobs may be listed by
+var jobSortColumns = map[string]string{
+	"created_at":   "created_at",
+	"started_at":   "COALESCE(started_at, '')",
+	"completed_at": "COALESCE(completed_at, '')",
+	"type":         "type",
+	"status":       "status",
+}
+
+// handleListJobs lists the background jobs of the current user, newest first unless sorted otherwise, filtered
+// by exam, lecture, label, statuses, types and creation time
 func (server *Server) handleListJobs(responseWriter http.ResponseWriter, request *http.Request) {
 	userID := server.getUserID(request)
 	courseIDParam := request.URL.Query().Get("course_id")
 	lectureIDParam := request.URL.Query().Get("lecture_id")
+	labelParam := strings.TrimSpace(request.URL.Query().Get("label"))
+	statuses := listParameter(request, "status")
+	for index, status := range statuses {
+		statuses[index] = strings.ToUpper(status)
+		switch statuses[index] {
+		case models.JobStatusPending, models.JobStatusRunning, models.JobStatusCompleted, models.JobStatusFailed, models.JobStatusCancelled:
+		default:
+			server.writeError(responseWriter, http.StatusBadRequest, "VALIDATION_ERROR", "status must list PENDING, RUNNING, COMPLETED, FAILED or CANCELLED", nil)
+			return
+		}
+	}
+	createdAfter, err := parseTimeParameter(request, "created_after")
+	if err != nil {
+		server.writeError(responseWriter, http.StatusBadRequest, "VALIDATION_ERROR", err.Error(), nil)
+		return
+	}
+	createdBefore, err := parseTimeParameter(request, "created_before")
+	if err != nil {
+		server.writeError(responseWriter, http.StatusBadRequest, "VALIDATION_ERROR", err.Error(), nil)
+		return
+	}
+	sortColumn, descending, err := parseListSort(request, jobSortColumns, "created_at:desc")
+	if err != nil {
+		server.writeError(responseWriter, http.StatusBadRequest, "VALIDATION_ERROR", err.Error(), nil)
+		return
+	}
+	page, err := parseListPage(request, sortColumn, "id", descending)
+	if err != nil {
+		server.writeError(responseWriter, http.StatusBadRequest, "VALIDATION_ERROR", err.Error(), nil)
+		return
+	}
 
 	query := `
-		SELECT id, type, status, progress, progress_message_text, payload, result, course_id, lecture_id, input_tokens, output_tokens, estimated_cost, created_at
+		SELECT id, type, status, COALESCE(priority, 'normal'), progress, progress_message_text, payload, result, failure, course_id, lecture_id, input_tokens, output_tokens, estimated_cost, created_at,
+			(SELECT json_group_array(depends_on_job_id) FROM job_dependencies WHERE job_id = jobs.id), paused_at IS NOT NULL,
+			COALESCE(label, ''), COALESCE(note, ''), ` + page.sortValue() + `
 		FROM jobs
 		WHERE user_id = ?
 	`
@@ -28,8 +93,32 @@ func (server *Server) handleListJobs(responseWriter http.ResponseWriter, request
 		query += " AND lecture_id = ?"
 		args = append(args, lectureIDParam)
 	}
+	if labelParam != "" {
+		query += " AND label = ?"
+		args = append(args, labelParam)
+	}
+	if len(statuses) > 0 {
+		condition, conditionArgs := anyOf("status", statuses)
+		query += condition
+		args = append(args, conditionArgs...)
+	}
+	if types := listParameter(request, "type"); len(types) > 0 {
+		condition, conditionArgs := anyOf("type", types)
+		query += condition
+		args = append(args, conditionArgs...)
+	}
+	if !createdAfter.IsZero() {
+		query += " AND created_at >= ?"
+		args = append(args, createdAfter)
+	}
+	if !createdBefore.IsZero() {
+		query += " AND created_at < ?"
+		args = append(args, createdBefore)
+	}
 
-	query += " ORDER BY created_at DESC LIMIT 100"
+	condition, conditionArgs := page.condition()
+	query += condition + page.orderBy()
+	args = append(args, conditionArgs...)
 
 	jobRows, databaseError := server.database.Query(query, args...)
 	if databaseError != nil {
@@ -39,14 +128,16 @@ func (server *Server) handleListJobs(responseWriter http.ResponseWriter, request
 	defer jobRows.Close()
 
 	var jobsList = []map[string]any{}
+	var cursors []pageCursor
 	for jobRows.Next() {
-		var id, jobType, status, progressMsg, payload, result string
-		var courseID, lectureID sql.NullString
+		var id, jobType, status, priority, progressMsg, payload, result, label, note, sortValue string
+		var failureJSON, courseID, lectureID, dependsOnJSON sql.NullString
 		var progress, inputTokens, outputTokens int
 		var estimatedCost float64
+		var paused bool
 		var createdAt string
 
-		if err := jobRows.Scan(&id, &jobType, &status, &progress, &progressMsg, &payload, &result, &courseID, &lectureID, &inputTokens, &outputTokens, &estimatedCost, &createdAt); err != nil {
+		if err := jobRows.Scan(&id, &jobType, &status, &priority, &progress, &progressMsg, &payload, &result, &failureJSON, &courseID, &lectureID, &inputTokens, &outputTokens, &estimatedCost, &createdAt, &dependsOnJSON, &paused, &label, &note, &sortValue); err != nil {
 			continue
 		}
 
@@ -54,6 +145,7 @@ func (server *Server) handleListJobs(responseWriter http.ResponseWriter, request
 			"id":                    id,
 			"type":                  jobType,
 			"status":                status,
+			"priority":              priority,
 			"progress":              progress,
 			"progress_message_text": progressMsg,
 			"payload":               payload,
@@ -64,17 +156,38 @@ func (server *Server) handleListJobs(responseWriter http.ResponseWriter, request
 			"created_at":            createdAt,
 		}
 
+		if failureJSON.Valid {
+			var failure models.JobFailure
+			if json.Unmarshal([]byte(failureJSON.String), &failure) == nil {
+				jobData["failure"] = failure
+			}
+		}
 		if courseID.Valid {
 			jobData["course_id"] = courseID.String
 		}
 		if lectureID.Valid {
 			jobData["lecture_id"] = lectureID.String
 		}
+		var dependsOn []string
+		if dependsOnJSON.Valid && json.Unmarshal([]byte(dependsOnJSON.String), &dependsOn) == nil && len(dependsOn) > 0 {
+			jobData["depends_on"] = dependsOn
+		}
+		if paused {
+			jobData["paused"] = true
+		}
+		if label != "" {
+			jobData["label"] = label
+		}
+		if note != "" {
+			jobData["note"] = note
+		}
 
 		jobsList = append(jobsList, jobData)
+		cursors = append(cursors, pageCursor{SortValue: sortValue, ID: id})
 	}
 
-	server.writeJSON(responseWriter, http.StatusOK, jobsList)
+	jobsList, nextCursor := paginate(page, jobsList, cursors)
+	server.writePage(responseWriter, http.StatusOK, jobsList, nextCursor)
 }
 
 // handleGetJob retrieves detailed status of a specific job
@@ -96,6 +209,165 @@ func (server *Server) handleGetJob(responseWriter http.ResponseWriter, request *
 	server.writeJSON(responseWriter, http.StatusOK, job)
 }
 
+// handleGetJobLogs returns the lines logged while running one of the caller's jobs after the "after" sequence,
+// oldest first. With "follow", a request finding no new line waits for one for up to "wait" seconds while the
+// job is pending or running, so clients can tail a job by asking again from the last sequence they got
+func (server *Server) handleGetJobLogs(responseWriter http.ResponseWriter, request *http.Request) {
+	query := request.URL.Query()
+	jobID := query.Get("job_id")
+	if jobID == "" {
+		server.writeError(responseWriter, http.StatusBadRequest, "VALIDATION_ERROR", "job_id is required", nil)
+		return
+	}
+	var afterSequence int64
+	if afterValue := query.Get("after"); afterValue != "" {
+		parsed, err := strconv.ParseInt(afterValue, 10, 64)
+		if err != nil || parsed < 0 {
+			server.writeError(responseWriter, http.StatusBadRequest, "VALIDATION_ERROR", "after must be a sequence returned by this endpoint", nil)
+			return
+		}
+		afterSequence = parsed
+	}
+	limit := defaultPageSize
+	if limitValue := query.Get("limit"); limitValue != "" {
+		parsed, err := strconv.Atoi(limitValue)
+		if err != nil || parsed < 1 || parsed > maximumPageSize {
+			server.writeError(responseWriter, http.StatusBadRequest, "VALIDATION_ERROR", fmt.Sprintf("limit must be between 1 and %d", maximumPageSize), nil)
+			return
+		}
+		limit = parsed
+	}
+	follow := query.Get("follow") == "true"
+	wait := defaultJobLogFollowWait
+	if waitValue := query.Get("wait"); waitValue != "" {
+		seconds, err := strconv.Atoi(waitValue)
+		if err != nil || seconds < 1 || seconds > int(maximumJobLogFollowWait/time.Second) {
+			server.writeError(responseWriter, http.StatusBadRequest, "VALIDATION_ERROR", fmt.Sprintf("wait must be between 1 and %d seconds", int(maximumJobLogFollowWait/time.Second)), nil)
+			return
+		}
+		wait = time.Duration(seconds) * time.Second
+	}
+
+	job, err := server.jobQueue.GetJob(jobID)
+	if err != nil || job.UserID != server.getUserID(request) {
+		server.writeError(responseWriter, http.StatusNotFound, "NOT_FOUND", "Job not found", nil)
+		return
+	}
+
+	deadline := time.Now().Add(wait)
+	for {
+		jobLogs, err := database.ListJobLogs(server.database, jobID, afterSequence, limit)
+		if err != nil {
+			server.writeError(responseWriter, http.StatusInternalServerError, "DATABASE_ERROR", "Failed to list job logs", nil)
+			return
+		}
+		finished := job.Status != models.JobStatusPending && job.Status != models.JobStatusRunning
+		if len(jobLogs) > 0 || !follow || finished || time.Now().After(deadline) {
+			nextSequence := afterSequence
+			if len(jobLogs) > 0 {
+				nextSequence = jobLogs[len(jobLogs)-1].Sequence
+			}
+			server.writeJSON(responseWriter, http.StatusOK, map[string]any{
+				"logs":       jobLogs,
+				"next_after": nextSequence,
+				"job_status": job.Status,
+			})
+			return
+		}
+
+		select {
+		case <-request.Context().Done():
+			return
+		case <-time.After(jobLogFollowInterval):
+		}
+		if refreshedJob, err := server.jobQueue.GetJob(jobID); err == nil {
+			job = refreshedJob
+		}
+	}
+}
+
+// handleUpdateJob sets or clears the label and note of one of the caller's jobs. Fields left out are kept and
+// empty ones cleared
+func (server *Server) handleUpdateJob(responseWriter http.ResponseWriter, request *http.Request) {
+	var updateRequest struct {
+		JobID string  `json:"job_id"`
+		Label *string `json:"label"`
+		Note  *string `json:"note"`
+	}
+	if err := json.NewDecoder(request.Body).Decode(&updateRequest); err != nil {
+		server.writeError(responseWriter, http.StatusBadRequest, "VALIDATION_ERROR", "Invalid body", nil)
+		return
+	}
+	if updateRequest.JobID == "" {
+		server.writeError(responseWriter, http.StatusBadRequest, "VALIDATION_ERROR", "job_id is required", nil)
+		return
+	}
+
+	job, err := server.jobQueue.GetJob(updateRequest.JobID)
+	if err != nil || job.UserID != server.getUserID(request) {
+		server.writeError(responseWriter, http.StatusNotFound, "NOT_FOUND", "Job not found", nil)
+		return
+	}
+
+	label, note := job.Label, job.Note
+	if updateRequest.Label != nil {
+		label = strings.TrimSpace(*updateRequest.Label)
+	}
+	if updateRequest.Note != nil {
+		note = strings.TrimSpace(*updateRequest.Note)
+	}
+	if utf8.RuneCountInString(label) > maximumJobLabelLength || strings.ContainsAny(label, "\r\n") {
+		server.writeError(responseWriter, http.StatusBadRequest, "VALIDATION_ERROR", fmt.Sprintf("label must be a single line of at most %d characters", maximumJobLabelLength), nil)
+		return
+	}
+	if utf8.RuneCountInString(note) > maximumJobNoteLength {
+		server.writeError(responseWriter, http.StatusBadRequest, "VALIDATION_ERROR", fmt.Sprintf("note must be at most %d characters", maximumJobNoteLength), nil)
+		return
+	}
+
+	if _, err := server.database.Exec("UPDATE jobs SET label = NULLIF(?, ''), note = NULLIF(?, '') WHERE id = ?", label, note, job.ID); err != nil {
+		server.writeError(responseWriter, http.StatusInternalServerError, "DATABASE_ERROR", "Failed to update job", nil)
+		return
+	}
+
+	job, err = server.jobQueue.GetJob(job.ID)
+	if err != nil {
+		server.writeError(responseWriter, http.StatusInternalServerError, "DATABASE_ERROR", "Failed to read job", nil)
+		return
+	}
+	server.writeJSON(responseWriter, http.StatusOK, job)
+}
+
+// handleListJobLabels lists the labels the caller gave their jobs with how many jobs carry each, most used first
+func (server *Server) handleListJobLabels(responseWriter http.ResponseWriter, request *http.Request) {
+	labelRows, err := server.database.Query(`
+		SELECT label, COUNT(*) FROM jobs
+		WHERE user_id = ? AND label IS NOT NULL
+		GROUP BY label
+		ORDER BY COUNT(*) DESC, label
+	`, server.getUserID(request))
+	if err != nil {
+		server.writeError(responseWriter, http.StatusInternalServerError, "DATABASE_ERROR", "Failed to list job labels", nil)
+		return
+	}
+	defer labelRows.Close()
+
+	labels := []map[string]any{}
+	for labelRows.Next() {
+		var label string
+		var jobCount int
+		if labelRows.Scan(&label, &jobCount) == nil {
+			labels = append(labels, map[string]any{"label": label, "jobs": jobCount})
+		}
+	}
+	server.writeJSON(responseWriter, http.StatusOK, labels)
+}
+
+// handleListJobTypes lists the job types this server runs, as its handler set allows
+func (server *Server) handleListJobTypes(responseWriter http.ResponseWriter, request *http.Request) {
+	server.writeJSON(responseWriter, http.StatusOK, server.jobQueue.JobTypes())
+}
+
 // handleCancelJob requests cancellation of a running job
 func (server *Server) handleCancelJob(responseWriter http.ResponseWriter, request *http.Request) {
 	var cancelRequest struct {
@@ -149,3 +421,114 @@ func (server *Server) handleCancelJob(responseWriter http.ResponseWriter, reques
 
 	server.writeJSON(responseWriter, http.StatusOK, map[string]string{"message": "Job cancellation requested"})
 }
+
+// handlePauseJob pauses a pending job, or asks a running one to stop at its next page or section
+func (server *Server) handlePauseJob(responseWriter http.ResponseWriter, request *http.Request) {
+	server.changeJobPause(responseWriter, request, true)
+}
+
+// handleResumeJob lets a paused job continue from where it stopped
+func (server *Server) handleResumeJob(responseWriter http.ResponseWriter, request *http.Request) {
+	server.changeJobPause(responseWriter, request, false)
+}
+
+func (server *Server) changeJobPause(responseWriter http.ResponseWriter, request *http.Request, pause bool) {
+	var pauseRequest struct {
+		JobID string `json:"job_id"`
+	}
+	if err := json.NewDecoder(request.Body).Decode(&pauseRequest); err != nil {
+		server.writeError(responseWriter, http.StatusBadRequest, "VALIDATION_ERROR", "Invalid body", nil)
+		return
+	}
+	if pauseRequest.JobID == "" {
+		server.writeError(responseWriter, http.StatusBadRequest, "VALIDATION_ERROR", "job_id is required", nil)
+		return
+	}
+
+	job, err := server.jobQueue.GetJob(pauseRequest.JobID)
+	if err != nil || job.UserID != server.getUserID(request) {
+		server.writeError(responseWriter, http.StatusNotFound, "NOT_FOUND", "Job not found", nil)
+		return
+	}
+
+	if pause {
+		err = server.jobQueue.PauseJob(job.ID)
+	} else {
+		err = server.jobQueue.ResumeJob(job.ID)
+	}
+	if err != nil {
+		server.writeError(responseWriter, http.StatusConflict, "INVALID_STATE", err.Error(), nil)
+		return
+	}
+
+	job, err = server.jobQueue.GetJob(job.ID)
+	if err != nil {
+		server.writeError(responseWriter, http.StatusInternalServerError, "DATABASE_ERROR", "Failed to read job", nil)
+		return
+	}
+	server.writeJSON(responseWriter, http.StatusOK, job)
+}
+
+// handleListDeadJobs lists the caller's failed jobs that were not requeued, optionally only those failing with a
+// failure code, with the number of jobs per code
+func (server *Server) handleListDeadJobs(responseWriter http.ResponseWriter, request *http.Request) {
+	failureCode := request.URL.Query().Get("code")
+
+	deadJobs, err := server.jobQueue.ListDeadJobs(server.getUserID(request))
+	if err != nil {
+		server.writeError(responseWriter, http.StatusInternalServerError, "DATABASE_ERROR", "Failed to list dead jobs", nil)
+		return
+	}
+
+	failureCounts := map[string]int{}
+	listedJobs := []*models.Job{}
+	for _, job := range deadJobs {
+		failureCounts[job.Failure.Code]++
+		if failureCode == "" || job.Failure.Code == failureCode {
+			listedJobs = append(listedJobs, job)
+		}
+	}
+
+	server.writeJSON(responseWriter, http.StatusOK, map[string]any{
+		"jobs":           listedJobs,
+		"failure_counts": failureCounts,
+	})
+}
+
+// handleRequeueJob clones a failed job into a fresh pending one and returns the new job
+func (server *Server) handleRequeueJob(responseWriter http.ResponseWriter, request *http.Request) {
+	var requeueRequest struct {
+		JobID string `json:"job_id"`
+	}
+	if err := json.NewDecoder(request.Body).Decode(&requeueRequest); err != nil {
+		server.writeError(responseWriter, http.StatusBadRequest, "VALIDATION_ERROR", "Invalid body", nil)
+		return
+	}
+	if requeueRequest.JobID == "" {
+		server.writeError(responseWriter, http.StatusBadRequest, "VALIDATION_ERROR", "job_id is required", nil)
+		return
+	}
+
+	job, err := server.jobQueue.GetJob(requeueRequest.JobID)
+	if err != nil || job.UserID != server.getUserID(request) {
+		server.writeError(responseWriter, http.StatusNotFound, "NOT_FOUND", "Job not found", nil)
+		return
+	}
+
+	requeuedJobID, err := server.jobQueue.RequeueJob(job.ID)
+	if errors.Is(err, jobs.ErrBudgetExceeded) {
+		server.writeEnqueueError(responseWriter, err, "Failed to requeue job")
+		return
+	}
+	if err != nil {
+		server.writeError(responseWriter, http.StatusConflict, "INVALID_STATE", err.Error(), nil)
+		return
+	}
+
+	requeuedJob, err := server.jobQueue.GetJob(requeuedJobID)
+	if err != nil {
+		server.writeError(responseWriter, http.StatusInternalServerError, "DATABASE_ERROR", "Failed to read job", nil)
+		return
+	}
+	server.writeJSON(responseWriter, http.StatusCreated, requeuedJob)
+}
@@ -0,0 +1,176 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"lectures/internal/jobs"
+	"lectures/internal/models"
+)
+
+func TestHandleJobLabels(t *testing.T) {
+	server, userID, sessionID, cleanup := setupUniqueExtraTestEnv(t, "job_labels")
+	defer cleanup()
+
+	server.database.Exec("INSERT INTO jobs (id, user_id, type, status, payload, result, progress_message_text) VALUES ('failed-build', ?, 'BUILD_MATERIAL', 'FAILED', '{}', '', '')", userID)
+	server.database.Exec("INSERT INTO jobs (id, user_id, type, status, payload, result, progress_message_text) VALUES ('other-build', ?, 'BUILD_MATERIAL', 'COMPLETED', '{}', '', '')", userID)
+	server.database.Exec("INSERT INTO jobs (id, user_id, type, status, payload, label) VALUES ('foreign-build', 'someone-else', 'BUILD_MATERIAL', 'COMPLETED', '{}', 'transcript fix')")
+
+	sendRequest := func(method string, target string, body string) (int, []byte) {
+		req := httptest.NewRequest(method, target, strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer "+sessionID)
+		req.Header.Set("X-Requested-With", "XMLHttpRequest")
+		req.Header.Set("Content-Type", "application/json")
+		rr := httptest.NewRecorder()
+		server.Handler().ServeHTTP(rr, req)
+		return rr.Code, rr.Body.Bytes()
+	}
+
+	code, body := sendRequest("PATCH", "/api/jobs", `{"job_id":"failed-build","label":" transcript fix ","note":"Regenerating after transcript fix"}`)
+	if code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", code, body)
+	}
+	var labeled struct {
+		Data models.Job `json:"data"`
+	}
+	json.Unmarshal(body, &labeled)
+	if labeled.Data.Label != "transcript fix" || labeled.Data.Note != "Regenerating after transcript fix" {
+		t.Errorf("Expected the trimmed label and the note, got %+v", labeled.Data)
+	}
+
+	if code, _ := sendRequest("PATCH", "/api/jobs", `{"job_id":"foreign-build","label":"mine"}`); code != http.StatusNotFound {
+		t.Errorf("Expected the jobs of other users to be hidden, got %d", code)
+	}
+	if code, _ := sendRequest("PATCH", "/api/jobs", `{"job_id":"other-build","label":"`+strings.Repeat("x", 65)+`"}`); code != http.StatusBadRequest {
+		t.Errorf("Expected an overlong label to be refused, got %d", code)
+	}
+
+	code, body = sendRequest("GET", "/api/jobs?label=transcript+fix", "")
+	var listed struct {
+		Data []map[string]any `json:"data"`
+	}
+	json.Unmarshal(body, &listed)
+	if code != http.StatusOK || len(listed.Data) != 1 || listed.Data[0]["id"] != "failed-build" || listed.Data[0]["note"] != "Regenerating after transcript fix" {
+		t.Errorf("Expected only the labeled job listed with its note, got %d: %s", code, body)
+	}
+
+	// A requeued job keeps the label of the failed one
+	code, body = sendRequest("POST", "/api/jobs/requeue", `{"job_id":"failed-build"}`)
+	if code != http.StatusCreated {
+		t.Fatalf("Expected status 201, got %d: %s", code, body)
+	}
+	var requeued struct {
+		Data models.Job `json:"data"`
+	}
+	json.Unmarshal(body, &requeued)
+	if requeued.Data.Label != "transcript fix" || requeued.Data.Note == "" {
+		t.Errorf("Expected the requeued job to keep the label and note, got %+v", requeued.Data)
+	}
+
+	_, body = sendRequest("GET", "/api/jobs/labels", "")
+	var labels struct {
+		Data []map[string]any `json:"data"`
+	}
+	json.Unmarshal(body, &labels)
+	if len(labels.Data) != 1 || labels.Data[0]["label"] != "transcript fix" || labels.Data[0]["jobs"] != float64(2) {
+		t.Errorf("Expected one label on two jobs, got %s", body)
+	}
+
+	// Labels are cleared with an empty value, and fields left out are kept
+	sendRequest("PATCH", "/api/jobs", `{"job_id":"failed-build","label":""}`)
+	job, _ := server.jobQueue.GetJob("failed-build")
+	if job.Label != "" || job.Note == "" {
+		t.Errorf("Expected the label cleared and the note kept, got %+v", job)
+	}
+}
+
+func TestJobLogs(t *testing.T) {
+	server, userID, sessionID, cleanup := setupUniqueExtraTestEnv(t, "job_logs")
+	defer cleanup()
+
+	server.database.Exec("INSERT INTO jobs (id, user_id, type, status, payload, created_at) VALUES ('logged-job', ?, 'BUILD_MATERIAL', 'RUNNING', '{}', ?)", userID, time.Now())
+
+	// Lines reach the log through the default logger, as the job handlers log them
+	broadcasts := make(chan models.JobLog, 10)
+	previousLogger := slog.Default()
+	slog.SetDefault(slog.New(jobs.NewLogHandler(slog.NewTextHandler(io.Discard, nil), server.database, func(jobLog models.JobLog) {
+		broadcasts <- jobLog
+	})))
+	defer slog.SetDefault(previousLogger)
+
+	jobContext := jobs.WithJobID(context.Background(), "logged-job")
+	slog.WarnContext(jobContext, "Section rejected, retrying", "section", "Entropy", "score", 40, "error", errors.New("low adherence"))
+	slog.Info("Job paused", "jobID", "logged-job")
+	slog.Info("Unrelated line")
+	for range 2 {
+		select {
+		case <-broadcasts:
+		case <-time.After(5 * time.Second):
+			t.Fatal("Expected the job's lines to be recorded")
+		}
+	}
+
+	getLogs := func(query string) (int, map[string]any) {
+		req := httptest.NewRequest("GET", "/api/jobs/logs?"+query, nil)
+		req.Header.Set("Authorization", "Bearer "+sessionID)
+		rr := httptest.NewRecorder()
+		server.Handler().ServeHTTP(rr, req)
+		var response struct {
+			Data map[string]any `json:"data"`
+		}
+		json.Unmarshal(rr.Body.Bytes(), &response)
+		return rr.Code, response.Data
+	}
+
+	status, data := getLogs("job_id=logged-job")
+	logs, _ := data["logs"].([]any)
+	if status != http.StatusOK || len(logs) != 2 {
+		t.Fatalf("Expected the two lines of the job, got %d: %v", status, data)
+	}
+	first := logs[0].(map[string]any)
+	attributes, _ := first["attributes"].(map[string]any)
+	if first["level"] != "WARN" || first["message"] != "Section rejected, retrying" || attributes["score"] != float64(40) || attributes["error"] != "low adherence" {
+		t.Errorf("Unexpected first line: %v", first)
+	}
+	if second := logs[1].(map[string]any); second["message"] != "Job paused" || second["attributes"] != nil {
+		t.Errorf("Expected the jobID attribute to be left out of the second line, got %v", second)
+	}
+
+	// Following from the last sequence waits for the next line of the running job
+	nextAfter := int64(data["next_after"].(float64))
+	followed := make(chan map[string]any, 1)
+	go func() {
+		_, data := getLogs(fmt.Sprintf("job_id=logged-job&after=%d&follow=true&wait=5", nextAfter))
+		followed <- data
+	}()
+	time.Sleep(700 * time.Millisecond)
+	slog.InfoContext(jobContext, "Generation complete")
+	select {
+	case data := <-followed:
+		logs, _ := data["logs"].([]any)
+		if len(logs) != 1 || logs[0].(map[string]any)["message"] != "Generation complete" {
+			t.Errorf("Expected the followed line, got %v", data)
+		}
+	case <-time.After(8 * time.Second):
+		t.Fatal("Expected the follow request to return the new line")
+	}
+
+	// A finished job returns at once, and unknown jobs have no log
+	server.database.Exec("UPDATE jobs SET status = 'COMPLETED' WHERE id = 'logged-job'")
+	startedAt := time.Now()
+	if status, data := getLogs("job_id=logged-job&after=1000000&follow=true&wait=5"); status != http.StatusOK || data["job_status"] != "COMPLETED" || time.Since(startedAt) > 2*time.Second {
+		t.Errorf("Expected a finished job not to be waited for, got %d %v after %v", status, data, time.Since(startedAt))
+	}
+	if status, _ := getLogs("job_id=missing-job"); status != http.StatusNotFound {
+		t.Errorf("Expected an unknown job to have no log, got %d", status)
+	}
+}
@@ -1,25 +1,35 @@
 package api
 
 import (
+	"context"
 	"database/sql"
 	"encoding/json"
 	"fmt"
 	"io"
 	"log/slog"
+	"mime"
 	"mime/multipart"
 	"net/http"
 	"os"
 	"path/filepath"
+	"slices"
+	"strconv"
 	"strings"
 	"time"
 
+	"lectures/internal/database"
+	"lectures/internal/embeddings"
+	"lectures/internal/jobs"
 	"lectures/internal/media"
 	"lectures/internal/models"
+	"lectures/internal/storage"
 
 	gonanoid "github.com/matoous/go-nanoid/v2"
 )
 
-// handleCreateLecture creates a new lecture and binds staged uploads to it
+// handleCreateLecture creates a new lecture and binds staged uploads to it. With a "lecture_id" it fills in a
+// planned lecture of the exam instead, keeping the title, description, date and metadata fields it was planned
+// with unless the form sets them
 func (server *Server) handleCreateLecture(responseWriter http.ResponseWriter, request *http.Request) {
 	// Support upload progress tracking for direct multipart uploads
 	uploadID := request.URL.Query().Get("upload_id")
@@ -49,14 +59,91 @@ func (server *Server) handleCreateLecture(responseWriter http.ResponseWriter, re
 		return
 	}
 
+	if !server.authorizeExam(responseWriter, request, examID, models.ExamRoleManager) {
+		return
+	}
+
+	var plannedLecture *models.Lecture
+	if plannedLectureID := request.FormValue("lecture_id"); plannedLectureID != "" {
+		var planned models.Lecture
+		var plannedDescription, plannedMetadataFields sql.NullString
+		var plannedDate sql.NullTime
+		err := server.database.QueryRow(`
+			SELECT id, title, description, specified_date, metadata_fields, created_at
+			FROM lectures WHERE id = ? AND exam_id = ? AND status = 'planned'
+		`, plannedLectureID, examID).Scan(&planned.ID, &planned.Title, &plannedDescription, &plannedDate, &plannedMetadataFields, &planned.CreatedAt)
+		if err != nil {
+			server.writeError(responseWriter, http.StatusNotFound, "NOT_FOUND", "Planned lecture not found", nil)
+			return
+		}
+		planned.Description = plannedDescription.String
+		if plannedDate.Valid {
+			planned.SpecifiedDate = &plannedDate.Time
+		}
+		planned.MetadataFields = []models.MetadataField{}
+		if plannedMetadataFields.Valid && plannedMetadataFields.String != "" {
+			json.Unmarshal([]byte(plannedMetadataFields.String), &planned.MetadataFields)
+		}
+		plannedLecture = &planned
+	}
+
 	title := request.FormValue("title")
+	if title == "" && plannedLecture != nil {
+		title = plannedLecture.Title
+	}
 	if title == "" {
 		server.writeError(responseWriter, http.StatusBadRequest, "VALIDATION_ERROR", "Title is required", nil)
 		return
 	}
 
 	description := request.FormValue("description")
+	if description == "" && plannedLecture != nil {
+		description = plannedLecture.Description
+	}
 	language := request.FormValue("language")
+
+	// Optional speaker diarization toggle; when omitted the transcription.diarize setting applies
+	transcriptionPayload := map[string]any{}
+	if diarizeValue := request.FormValue("diarize"); diarizeValue != "" {
+		diarize, err := strconv.ParseBool(diarizeValue)
+		if err != nil {
+			server.writeError(responseWriter, http.StatusBadRequest, "VALIDATION_ERROR", "diarize must be true or false", nil)
+			return
+		}
+		transcriptionPayload["diarize"] = diarize
+	}
+	// Optional tools built as soon as transcription and ingestion complete, with the exam's generation defaults
+	var buildTypes []string
+	for _, buildTypesValue := range request.Form["build_types"] {
+		for _, buildType := range strings.Split(buildTypesValue, ",") {
+			buildType = strings.TrimSpace(buildType)
+			if buildType == "" {
+				continue
+			}
+			if buildType != "guide" && buildType != "flashcard" && buildType != "quiz" && buildType != models.ToolTypeMindmap {
+				server.writeError(responseWriter, http.StatusBadRequest, "VALIDATION_ERROR", "build_types must be guide, flashcard, quiz or mindmap", nil)
+				return
+			}
+			if !slices.Contains(buildTypes, buildType) {
+				buildTypes = append(buildTypes, buildType)
+			}
+		}
+	}
+	// Optional metadata fields as a JSON array of {"name", "value"}, on top of those of the exam
+	metadataFields := []models.MetadataField{}
+	if plannedLecture != nil {
+		metadataFields = plannedLecture.MetadataFields
+	}
+	if metadataFieldsValue := request.FormValue("metadata_fields"); metadataFieldsValue != "" {
+		if err := json.Unmarshal([]byte(metadataFieldsValue), &metadataFields); err != nil {
+			server.writeError(responseWriter, http.StatusBadRequest, "VALIDATION_ERROR", "metadata_fields must be a JSON array of name and value pairs", nil)
+			return
+		}
+		if validationMessage := models.ValidateMetadataFields(metadataFields); validationMessage != "" {
+			server.writeError(responseWriter, http.StatusBadRequest, "VALIDATION_ERROR", validationMessage, nil)
+			return
+		}
+	}
 	specifiedDateStr := request.FormValue("specified_date")
 	var specifiedDate *time.Time
 	if specifiedDateStr != "" {
@@ -66,19 +153,50 @@ func (server *Server) handleCreateLecture(responseWriter http.ResponseWriter, re
 			specifiedDate = &parsedDate
 		}
 	}
+	if specifiedDate == nil && plannedLecture != nil {
+		specifiedDate = plannedLecture.SpecifiedDate
+	}
+
+	userID := server.getUserID(request)
+
+	// A lecture whose processing could not be queued is not created
+	if err := server.checkCostBudget(server.billedUserID(userID, examID)); err != nil {
+		server.writeEnqueueError(responseWriter, err, "Failed to check cost budget")
+		return
+	}
+
+	for _, uploadID := range slices.Concat(request.Form["media_upload_ids"], request.Form["document_upload_ids"]) {
+		if !server.ownsUpload(request, uploadID) {
+			server.writeError(responseWriter, http.StatusNotFound, "NOT_FOUND", "Staged file not found: "+uploadID, nil)
+			return
+		}
+	}
+
+	// Files sent along with the form skip the staging endpoints, so they are checked against the quotas here
+	var directUploadBytes int64
+	for _, fileHeader := range slices.Concat(request.MultipartForm.File["media"], request.MultipartForm.File["documents"]) {
+		directUploadBytes += fileHeader.Size
+	}
+	if directUploadBytes > 0 && !server.checkStorageQuota(responseWriter, request, "", directUploadBytes) {
+		return
+	}
+
+	// The lecture is the planned one when it was listed, and its ID is the one the spend of the polish is recorded under
+	lectureID, _ := gonanoid.New()
+	createdAt := time.Now()
+	if plannedLecture != nil {
+		lectureID, createdAt = plannedLecture.ID, plannedLecture.CreatedAt
+	}
 
 	// Clean title and description
-	cleanedTitle, cleanedDescription, metrics, _ := server.toolGenerator.CorrectProjectTitleDescription(request.Context(), title, description, "")
+	cleanedTitle, cleanedDescription, metrics, _ := server.polishTitleDescription(request.Context(), server.billedUserID(userID, examID), lectureID, title, description)
 	slog.Info("Lecture title/description polished",
 		"input_tokens", metrics.InputTokens,
 		"output_tokens", metrics.OutputTokens,
 		"estimated_cost_usd", metrics.EstimatedCost)
 
-	userID := server.getUserID(request)
-
-	// Verify exam exists and belongs to user
 	var examLanguage sql.NullString
-	err := server.database.QueryRow("SELECT language FROM exams WHERE id = ? AND user_id = ?", examID, userID).Scan(&examLanguage)
+	err := server.database.QueryRow("SELECT language FROM exams WHERE id = ?", examID).Scan(&examLanguage)
 	if err != nil {
 		server.writeError(responseWriter, http.StatusNotFound, "NOT_FOUND", "Exam not found", nil)
 		return
@@ -89,19 +207,19 @@ func (server *Server) handleCreateLecture(responseWriter http.ResponseWriter, re
 		language = examLanguage.String
 	}
 
-	// 1. Create the Lecture
-	lectureID, _ := gonanoid.New()
+	// 1. Create the Lecture, or fill in the planned one where it was listed
 	lecture := models.Lecture{
-		ID:            lectureID,
-		ExamID:        examID,
-		Title:         cleanedTitle,
-		Description:   cleanedDescription,
-		SpecifiedDate: specifiedDate,
-		Language:      language,
-		Status:        "processing",
-		EstimatedCost: metrics.EstimatedCost,
-		CreatedAt:     time.Now(),
-		UpdatedAt:     time.Now(),
+		ID:             lectureID,
+		ExamID:         examID,
+		Title:          cleanedTitle,
+		Description:    cleanedDescription,
+		SpecifiedDate:  specifiedDate,
+		Language:       language,
+		Status:         "processing",
+		MetadataFields: metadataFields,
+		EstimatedCost:  metrics.EstimatedCost,
+		CreatedAt:      createdAt,
+		UpdatedAt:      time.Now(),
 	}
 
 	transaction, err := server.database.Begin()
@@ -111,11 +229,27 @@ func (server *Server) handleCreateLecture(responseWriter http.ResponseWriter, re
 	}
 	defer transaction.Rollback()
 
-	_, err = transaction.Exec(`
-		INSERT INTO lectures (id, exam_id, title, description, specified_date, language, status, estimated_cost, created_at, updated_at)
-		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
-	`, lecture.ID, lecture.ExamID, lecture.Title, lecture.Description, lecture.SpecifiedDate, lecture.Language, lecture.Status, lecture.EstimatedCost, lecture.CreatedAt, lecture.UpdatedAt)
-
+	metadataFieldsJSON, _ := json.Marshal(lecture.MetadataFields)
+	if plannedLecture != nil {
+		// Only a lecture still planned is filled in, so two requests cannot both claim it
+		var result sql.Result
+		result, err = transaction.Exec(`
+			UPDATE lectures SET title = ?, description = ?, specified_date = ?, language = ?, status = ?, metadata_fields = ?,
+				estimated_cost = estimated_cost + ?, updated_at = ?
+			WHERE id = ? AND status = 'planned'
+		`, lecture.Title, lecture.Description, lecture.SpecifiedDate, lecture.Language, lecture.Status, string(metadataFieldsJSON), lecture.EstimatedCost, lecture.UpdatedAt, lecture.ID)
+		if err == nil {
+			if affectedRows, _ := result.RowsAffected(); affectedRows == 0 {
+				server.writeError(responseWriter, http.StatusConflict, "LECTURE_NOT_PLANNED", "The lecture was filled in by another request", nil)
+				return
+			}
+		}
+	} else {
+		_, err = transaction.Exec(`
+			INSERT INTO lectures (id, exam_id, title, description, specified_date, language, status, metadata_fields, estimated_cost, created_at, updated_at)
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+		`, lecture.ID, lecture.ExamID, lecture.Title, lecture.Description, lecture.SpecifiedDate, lecture.Language, lecture.Status, string(metadataFieldsJSON), lecture.EstimatedCost, lecture.CreatedAt, lecture.UpdatedAt)
+	}
 	if err != nil {
 		server.writeError(responseWriter, http.StatusInternalServerError, "DATABASE_ERROR", "Failed to save lecture", nil)
 		return
@@ -129,7 +263,7 @@ func (server *Server) handleCreateLecture(responseWriter http.ResponseWriter, re
 
 	// 2. Bind Staged Media
 	for uploadIndex, uploadID := range request.Form["media_upload_ids"] {
-		if err := server.commitStagedUpload(transaction, lectureID, uploadID, "media", uploadIndex); err != nil {
+		if err := server.commitStagedUpload(request.Context(), transaction, lectureID, uploadID, "media", uploadIndex); err != nil {
 			server.writeError(responseWriter, http.StatusInternalServerError, "FILE_UPLOAD_ERROR", "Failed to bind media: "+uploadID, nil)
 			return
 		}
@@ -137,7 +271,7 @@ func (server *Server) handleCreateLecture(responseWriter http.ResponseWriter, re
 
 	// 3. Bind Staged Documents
 	for _, uploadID := range request.Form["document_upload_ids"] {
-		if err := server.commitStagedUpload(transaction, lectureID, uploadID, "document", 0); err != nil {
+		if err := server.commitStagedUpload(request.Context(), transaction, lectureID, uploadID, "document", 0); err != nil {
 			server.writeError(responseWriter, http.StatusInternalServerError, "FILE_UPLOAD_ERROR", "Failed to bind document: "+uploadID, nil)
 			return
 		}
@@ -150,7 +284,7 @@ func (server *Server) handleCreateLecture(responseWriter http.ResponseWriter, re
 			server.writeError(responseWriter, http.StatusInternalServerError, "FILE_UPLOAD_ERROR", "Failed to stage media file", nil)
 			return
 		}
-		if err := server.commitStagedUpload(transaction, lectureID, uploadID, "media", len(request.Form["media_upload_ids"])+uploadIndex); err != nil {
+		if err := server.commitStagedUpload(request.Context(), transaction, lectureID, uploadID, "media", len(request.Form["media_upload_ids"])+uploadIndex); err != nil {
 			server.writeError(responseWriter, http.StatusInternalServerError, "FILE_UPLOAD_ERROR", "Failed to process direct media", nil)
 			return
 		}
@@ -161,7 +295,7 @@ func (server *Server) handleCreateLecture(responseWriter http.ResponseWriter, re
 			server.writeError(responseWriter, http.StatusInternalServerError, "FILE_UPLOAD_ERROR", "Failed to stage document file", nil)
 			return
 		}
-		if err := server.commitStagedUpload(transaction, lectureID, uploadID, "document", 0); err != nil {
+		if err := server.commitStagedUpload(request.Context(), transaction, lectureID, uploadID, "document", 0); err != nil {
 			server.writeError(responseWriter, http.StatusInternalServerError, "FILE_UPLOAD_ERROR", "Failed to process direct document", nil)
 			return
 		}
@@ -172,10 +306,45 @@ func (server *Server) handleCreateLecture(responseWriter http.ResponseWriter, re
 		return
 	}
 
+	recordingCount := len(request.Form["media_upload_ids"]) + len(request.MultipartForm.File["media"])
+	documentCount := len(request.Form["document_upload_ids"]) + len(request.MultipartForm.File["documents"])
+	event := models.ResourceEvent{
+		ExamID:       examID,
+		LectureID:    lectureID,
+		ResourceType: models.ResourceTypeLecture,
+		ResourceID:   lectureID,
+		Action:       models.ResourceActionCreated,
+		Summary:      fmt.Sprintf("Created the lecture \"%s\" with %d recordings and %d documents", lecture.Title, recordingCount, documentCount),
+	}
+	if plannedLecture != nil {
+		event.Action = models.ResourceActionUpdated
+		event.Summary = fmt.Sprintf("Filled in the planned lecture \"%s\" with %d recordings and %d documents", lecture.Title, recordingCount, documentCount)
+	}
+	server.recordEvent(request, event)
+
 	// 5. Trigger Async Jobs
-	server.jobQueue.Enqueue(userID, models.JobTypeTranscribeMedia, map[string]string{"lecture_id": lectureID}, examID, lectureID)
-	server.jobQueue.Enqueue(userID, models.JobTypeIngestDocuments, map[string]string{"lecture_id": lectureID, "language_code": language}, examID, lectureID)
+	transcriptionPayload["lecture_id"] = lectureID
+	transcriptionJobID, transcriptionError := server.jobQueue.Enqueue(userID, models.JobTypeTranscribeMedia, transcriptionPayload, examID, lectureID)
+	ingestionJobID, ingestionError := server.jobQueue.Enqueue(userID, models.JobTypeIngestDocuments, map[string]string{"lecture_id": lectureID, "language_code": language}, examID, lectureID)
+
+	// 6. Builds wait for the lecture to be processed; one whose dependencies could not be queued is not queued either
+	if transcriptionError == nil && ingestionError == nil {
+		for _, buildType := range buildTypes {
+			_, err := server.jobQueue.EnqueueAfter(userID, models.JobTypeBuildMaterial, map[string]string{
+				"exam_id":    examID,
+				"lecture_id": lectureID,
+				"type":       buildType,
+			}, examID, lectureID, []string{transcriptionJobID, ingestionJobID})
+			if err != nil {
+				slog.Warn("Failed to enqueue build for new lecture", "lectureID", lectureID, "type", buildType, "error", err)
+			}
+		}
+	}
 
+	if plannedLecture != nil {
+		server.writeJSON(responseWriter, http.StatusOK, lecture)
+		return
+	}
 	server.writeJSON(responseWriter, http.StatusCreated, lecture)
 }
 
@@ -189,6 +358,9 @@ func (server *Server) handleUploadPrepare(responseWriter http.ResponseWriter, re
 		server.writeError(responseWriter, http.StatusBadRequest, "VALIDATION_ERROR", "Invalid body", nil)
 		return
 	}
+	if !server.checkStorageQuota(responseWriter, request, "", prepareRequest.FileSize) {
+		return
+	}
 
 	uploadID, _ := gonanoid.New()
 	uploadDirectory := filepath.Join(os.TempDir(), "lectures-uploads", uploadID)
@@ -200,6 +372,13 @@ func (server *Server) handleUploadPrepare(responseWriter http.ResponseWriter, re
 
 	os.Create(filepath.Join(uploadDirectory, "upload.data"))
 
+	// Persist the session so its progress can be followed from other devices and survives restarts
+	if err := database.CreateUpload(server.database, uploadID, server.getUserID(request), filepath.Base(prepareRequest.Filename), prepareRequest.FileSize); err != nil {
+		slog.Error("Failed to record upload session", "uploadID", uploadID, "error", err)
+		server.writeError(responseWriter, http.StatusInternalServerError, "DATABASE_ERROR", "Failed to create upload session", nil)
+		return
+	}
+
 	server.writeJSON(responseWriter, http.StatusOK, map[string]any{
 		"upload_id":        uploadID,
 		"chunk_size_bytes": 10 * 1024 * 1024,
@@ -214,6 +393,11 @@ func (server *Server) handleUploadAppend(responseWriter http.ResponseWriter, req
 		return
 	}
 
+	if !server.ownsUpload(request, uploadID) {
+		server.writeError(responseWriter, http.StatusNotFound, "NOT_FOUND", "Upload session not found", nil)
+		return
+	}
+
 	uploadDirectory := filepath.Join(os.TempDir(), "lectures-uploads", uploadID)
 
 	// Read metadata to get total expected size for global progress tracking
@@ -252,7 +436,15 @@ func (server *Server) handleUploadAppend(responseWriter http.ResponseWriter, req
 	}
 
 	io.Copy(dataFile, progressReader)
-	server.writeJSON(responseWriter, http.StatusOK, map[string]string{"status": "data_appended"})
+
+	if err := database.RecordUploadProgress(server.database, uploadID, progressReader.BytesRead); err != nil {
+		slog.Warn("Failed to record upload progress", "uploadID", uploadID, "error", err)
+	}
+
+	server.writeJSON(responseWriter, http.StatusOK, map[string]any{
+		"status":         "data_appended",
+		"bytes_received": progressReader.BytesRead,
+	})
 }
 
 // handleUploadStage verifies the staged file via payload ID
@@ -265,6 +457,11 @@ func (server *Server) handleUploadStage(responseWriter http.ResponseWriter, requ
 		return
 	}
 
+	if !server.ownsUpload(request, stageRequest.UploadID) {
+		server.writeError(responseWriter, http.StatusNotFound, "NOT_FOUND", "Staged file not found", nil)
+		return
+	}
+
 	uploadDirectory := filepath.Join(os.TempDir(), "lectures-uploads", stageRequest.UploadID)
 
 	// Verify file exists
@@ -286,12 +483,58 @@ func (server *Server) handleUploadStage(responseWriter http.ResponseWriter, requ
 		return
 	}
 
+	// Checked again with the received size, which sessions without a declared size only learn now. A refused
+	// file could never be bound, so its session is discarded
+	if !server.checkStorageQuota(responseWriter, request, stageRequest.UploadID, info.Size()) {
+		os.RemoveAll(uploadDirectory)
+		if _, err := server.database.Exec("DELETE FROM uploads WHERE id = ?", stageRequest.UploadID); err != nil {
+			slog.Warn("Failed to discard upload over quota", "uploadID", stageRequest.UploadID, "error", err)
+		}
+		return
+	}
+
+	if err := database.MarkUploadStaged(server.database, stageRequest.UploadID, info.Size()); err != nil {
+		slog.Warn("Failed to mark upload as staged", "uploadID", stageRequest.UploadID, "error", err)
+	}
+
 	server.writeJSON(responseWriter, http.StatusOK, map[string]any{
 		"upload_id": stageRequest.UploadID,
 		"status":    "staged",
 	})
 }
 
+// handleListUploads lists the authenticated user's upload sessions that have not been bound yet
+func (server *Server) handleListUploads(responseWriter http.ResponseWriter, request *http.Request) {
+	uploads, err := database.ListUploads(server.database, server.getUserID(request))
+	if err != nil {
+		server.writeError(responseWriter, http.StatusInternalServerError, "DATABASE_ERROR", "Failed to list uploads", nil)
+		return
+	}
+	server.writeJSON(responseWriter, http.StatusOK, uploads)
+}
+
+// handleGetUpload returns the persisted progress of a single upload session
+func (server *Server) handleGetUpload(responseWriter http.ResponseWriter, request *http.Request) {
+	uploadID := request.URL.Query().Get("upload_id")
+	if uploadID == "" {
+		server.writeError(responseWriter, http.StatusBadRequest, "VALIDATION_ERROR", "upload_id is required", nil)
+		return
+	}
+
+	upload, err := database.GetUpload(server.database, uploadID)
+	if err != nil || upload.UserID != server.getUserID(request) {
+		server.writeError(responseWriter, http.StatusNotFound, "NOT_FOUND", "Upload session not found", nil)
+		return
+	}
+	server.writeJSON(responseWriter, http.StatusOK, upload)
+}
+
+// ownsUpload reports whether the caller may touch an upload; a session without a persisted row belongs to no one
+func (server *Server) ownsUpload(request *http.Request, uploadID string) bool {
+	upload, err := database.GetUpload(server.database, uploadID)
+	return err == nil && upload.UserID == server.getUserID(request)
+}
+
 // Internal Helpers (The "Staged Upload Interface")
 
 func (server *Server) stageMultipartFile(fileHeader *multipart.FileHeader) string {
@@ -333,7 +576,7 @@ func (server *Server) stageMultipartFile(fileHeader *multipart.FileHeader) strin
 	return uploadID
 }
 
-func (server *Server) commitStagedUpload(transaction *sql.Tx, lectureID string, uploadID string, targetType string, sequenceOrder int) error {
+func (server *Server) commitStagedUpload(requestContext context.Context, transaction *sql.Tx, lectureID string, uploadID string, targetType string, sequenceOrder int) error {
 	uploadDirectory := filepath.Join(os.TempDir(), "lectures-uploads", uploadID)
 	defer os.RemoveAll(uploadDirectory)
 
@@ -417,10 +660,14 @@ func (server *Server) commitStagedUpload(transaction *sql.Tx, lectureID string,
 			slog.Warn("Failed to extract media duration, setting to 0", "file_id", fileID, "error", err)
 		}
 
+		mediaData, objectKey, storeErr := storage.StoreContent(requestContext, server.objectStore, storage.MediaObjectKey(lectureID, fileID, "."+cleanExtension), fileData, mime.TypeByExtension("."+cleanExtension))
+		if storeErr != nil {
+			return storeErr
+		}
 		_, err = transaction.Exec(`
-			INSERT INTO lecture_media (id, lecture_id, media_type, sequence_order, duration_milliseconds, file_path, original_filename, created_at, file_data)
-			VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)
-		`, fileID, lectureID, mediaType, sequenceOrder, durationMs, logicalPath, safeOriginalFilename, time.Now(), fileData)
+			INSERT INTO lecture_media (id, lecture_id, media_type, sequence_order, duration_milliseconds, file_path, original_filename, created_at, file_data, object_key, size_bytes)
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+		`, fileID, lectureID, mediaType, sequenceOrder, durationMs, logicalPath, safeOriginalFilename, time.Now(), mediaData, objectKey, len(fileData))
 	} else {
 		documentType := cleanExtension
 		// Normalize document type to satisfy database constraints
@@ -449,6 +696,11 @@ func (server *Server) commitStagedUpload(transaction *sql.Tx, lectureID string,
 		return fmt.Errorf("failed to insert metadata: %w", err)
 	}
 
+	// The staged data now lives in the lecture, so the upload session is finished
+	if _, err := transaction.Exec("DELETE FROM uploads WHERE id = ?", uploadID); err != nil {
+		return fmt.Errorf("failed to clear upload session: %w", err)
+	}
+
 	return nil
 }
 
@@ -461,14 +713,18 @@ func (server *Server) handleListLectures(responseWriter http.ResponseWriter, req
 	}
 
 	userID := server.getUserID(request)
+	page, err := parseListPage(request, "lectures.created_at", "lectures.id", true)
+	if err != nil {
+		server.writeError(responseWriter, http.StatusBadRequest, "VALIDATION_ERROR", err.Error(), nil)
+		return
+	}
+	condition, conditionArgs := page.condition()
 
 	lectureRows, databaseError := server.database.Query(`
-		SELECT lectures.id, lectures.exam_id, lectures.title, lectures.description, lectures.specified_date, lectures.language, lectures.status, lectures.estimated_cost, lectures.created_at, lectures.updated_at
+		SELECT lectures.id, lectures.exam_id, lectures.title, lectures.description, lectures.specified_date, lectures.language, lectures.status, lectures.metadata_fields, lectures.estimated_cost, lectures.created_at, lectures.updated_at, `+page.sortValue()+`
 		FROM lectures
 		JOIN exams ON lectures.exam_id = exams.id
-		WHERE lectures.exam_id = ? AND exams.user_id = ?
-		ORDER BY lectures.created_at DESC
-	`, examID, userID)
+		WHERE lectures.exam_id = ? AND `+examAccess(models.ExamRoleViewer)+condition+page.orderBy(), append([]any{examID, userID}, conditionArgs...)...)
 	if databaseError != nil {
 		server.writeError(responseWriter, http.StatusInternalServerError, "DATABASE_ERROR", "Failed to list lectures", nil)
 		return
@@ -476,11 +732,13 @@ func (server *Server) handleListLectures(responseWriter http.ResponseWriter, req
 	defer lectureRows.Close()
 
 	lectures := []models.Lecture{}
+	var cursors []pageCursor
 	for lectureRows.Next() {
 		var lecture models.Lecture
-		var description, language sql.NullString
+		var description, language, metadataFields sql.NullString
 		var specifiedDate sql.NullTime
-		if err := lectureRows.Scan(&lecture.ID, &lecture.ExamID, &lecture.Title, &description, &specifiedDate, &language, &lecture.Status, &lecture.EstimatedCost, &lecture.CreatedAt, &lecture.UpdatedAt); err != nil {
+		var sortValue string
+		if err := lectureRows.Scan(&lecture.ID, &lecture.ExamID, &lecture.Title, &description, &specifiedDate, &language, &lecture.Status, &metadataFields, &lecture.EstimatedCost, &lecture.CreatedAt, &lecture.UpdatedAt, &sortValue); err != nil {
 			server.writeError(responseWriter, http.StatusInternalServerError, "DATABASE_ERROR", "Failed to scan lecture", nil)
 			return
 		}
@@ -493,10 +751,13 @@ func (server *Server) handleListLectures(responseWriter http.ResponseWriter, req
 		if language.Valid {
 			lecture.Language = language.String
 		}
+		lecture.MetadataFields = database.ParseMetadataFields(metadataFields)
 		lectures = append(lectures, lecture)
+		cursors = append(cursors, pageCursor{SortValue: sortValue, ID: lecture.ID})
 	}
 
-	server.writeJSON(responseWriter, http.StatusOK, lectures)
+	lectures, nextCursor := paginate(page, lectures, cursors)
+	server.writePage(responseWriter, http.StatusOK, lectures, nextCursor)
 }
 
 // handleGetLecture retrieves a specific lecture
@@ -512,14 +773,14 @@ func (server *Server) handleGetLecture(responseWriter http.ResponseWriter, reque
 	userID := server.getUserID(request)
 
 	var lecture models.Lecture
-	var description, language sql.NullString
+	var description, language, metadataFields sql.NullString
 	var specifiedDate sql.NullTime
 	err := server.database.QueryRow(`
-		SELECT lectures.id, lectures.exam_id, lectures.title, lectures.description, lectures.specified_date, lectures.language, lectures.status, lectures.estimated_cost, lectures.created_at, lectures.updated_at
+		SELECT lectures.id, lectures.exam_id, lectures.title, lectures.description, lectures.specified_date, lectures.language, lectures.status, lectures.metadata_fields, lectures.estimated_cost, lectures.created_at, lectures.updated_at
 		FROM lectures
 		JOIN exams ON lectures.exam_id = exams.id
-		WHERE lectures.id = ? AND lectures.exam_id = ? AND exams.user_id = ?
-	`, lectureID, examID, userID).Scan(&lecture.ID, &lecture.ExamID, &lecture.Title, &description, &specifiedDate, &language, &lecture.Status, &lecture.EstimatedCost, &lecture.CreatedAt, &lecture.UpdatedAt)
+		WHERE lectures.id = ? AND lectures.exam_id = ? AND `+examAccess(models.ExamRoleViewer)+`
+	`, lectureID, examID, userID).Scan(&lecture.ID, &lecture.ExamID, &lecture.Title, &description, &specifiedDate, &language, &lecture.Status, &metadataFields, &lecture.EstimatedCost, &lecture.CreatedAt, &lecture.UpdatedAt)
 
 	if description.Valid {
 		lecture.Description = description.String
@@ -530,6 +791,7 @@ func (server *Server) handleGetLecture(responseWriter http.ResponseWriter, reque
 	if language.Valid {
 		lecture.Language = language.String
 	}
+	lecture.MetadataFields = database.ParseMetadataFields(metadataFields)
 
 	if err == sql.ErrNoRows {
 		server.writeError(responseWriter, http.StatusNotFound, "NOT_FOUND", "Lecture not found in this exam", nil)
@@ -543,14 +805,131 @@ func (server *Server) handleGetLecture(responseWriter http.ResponseWriter, reque
 	server.writeJSON(responseWriter, http.StatusOK, lecture)
 }
 
+// handleGetLectureReport returns the processing report of a lecture, generated when it became ready
+func (server *Server) handleGetLectureReport(responseWriter http.ResponseWriter, request *http.Request) {
+	lectureID := request.URL.Query().Get("lecture_id")
+	examID := request.URL.Query().Get("exam_id")
+
+	if lectureID == "" || examID == "" {
+		server.writeError(responseWriter, http.StatusBadRequest, "VALIDATION_ERROR", "lecture_id and exam_id are required", nil)
+		return
+	}
+
+	userID := server.getUserID(request)
+
+	var lectureStatus string
+	err := server.database.QueryRow(`
+		SELECT lectures.status
+		FROM lectures
+		JOIN exams ON lectures.exam_id = exams.id
+		WHERE lectures.id = ? AND lectures.exam_id = ? AND `+examAccess(models.ExamRoleViewer)+`
+	`, lectureID, examID, userID).Scan(&lectureStatus)
+	if err == sql.ErrNoRows {
+		server.writeError(responseWriter, http.StatusNotFound, "NOT_FOUND", "Lecture not found in this exam", nil)
+		return
+	}
+	if err != nil {
+		server.writeError(responseWriter, http.StatusInternalServerError, "DATABASE_ERROR", "Failed to get lecture", nil)
+		return
+	}
+
+	report, err := database.GetProcessingReport(server.database, lectureID)
+	if err == sql.ErrNoRows {
+		// Lectures that became ready before reports existed get one on first request
+		if lectureStatus != "ready" {
+			server.writeError(responseWriter, http.StatusNotFound, "NOT_FOUND", "The processing report is available once the lecture is ready", nil)
+			return
+		}
+		report, err = database.SaveProcessingReport(server.database, lectureID)
+	}
+	if err != nil {
+		server.writeError(responseWriter, http.StatusInternalServerError, "DATABASE_ERROR", "Failed to get processing report", nil)
+		return
+	}
+
+	server.writeJSON(responseWriter, http.StatusOK, report)
+}
+
+// handleGetLectureRecap returns the cached recap of a lecture, so dashboards and widgets can show what it covered
+// without building any material. A ready lecture without a recap gets one scheduled and reports "pending"
+func (server *Server) handleGetLectureRecap(responseWriter http.ResponseWriter, request *http.Request) {
+	lectureID := request.URL.Query().Get("lecture_id")
+	examID := request.URL.Query().Get("exam_id")
+
+	if lectureID == "" || examID == "" {
+		server.writeError(responseWriter, http.StatusBadRequest, "VALIDATION_ERROR", "lecture_id and exam_id are required", nil)
+		return
+	}
+
+	userID := server.getUserID(request)
+
+	var lectureStatus string
+	err := server.database.QueryRow(`
+		SELECT lectures.status
+		FROM lectures
+		JOIN exams ON lectures.exam_id = exams.id
+		WHERE lectures.id = ? AND lectures.exam_id = ? AND `+examAccess(models.ExamRoleViewer)+`
+	`, lectureID, examID, userID).Scan(&lectureStatus)
+	if err == sql.ErrNoRows {
+		server.writeError(responseWriter, http.StatusNotFound, "NOT_FOUND", "Lecture not found in this exam", nil)
+		return
+	}
+	if err != nil {
+		server.writeError(responseWriter, http.StatusInternalServerError, "DATABASE_ERROR", "Failed to get lecture", nil)
+		return
+	}
+
+	recap := models.LectureRecap{LectureID: lectureID}
+	var bulletsJSON string
+	var language, model sql.NullString
+	err = server.database.QueryRow(`
+		SELECT bullets, language, model, estimated_cost, created_at FROM lecture_recaps WHERE lecture_id = ?
+	`, lectureID).Scan(&bulletsJSON, &language, &model, &recap.EstimatedCost, &recap.CreatedAt)
+	if err != nil && err != sql.ErrNoRows {
+		server.writeError(responseWriter, http.StatusInternalServerError, "DATABASE_ERROR", "Failed to get lecture recap", nil)
+		return
+	}
+
+	if err == sql.ErrNoRows {
+		response := map[string]any{
+			"lecture_id": lectureID,
+			"status":     "unavailable",
+			"bullets":    []string{},
+		}
+		if lectureStatus == "ready" {
+			if failure := jobs.LectureRecapFailure(server.database, lectureID); failure != "" {
+				// A failed recap is only written again through an explicit retry, so reading it costs nothing
+				response["status"] = "failed"
+				response["error"] = failure
+			} else if roleHasPermission(server.getUserRole(request), models.PermissionRunJobs) {
+				// Lectures that became ready before recaps existed get one on first request by someone allowed to
+				// run the paid job
+				jobs.ScheduleLectureRecap(server.jobQueue, server.database, lectureID)
+				response["status"] = "pending"
+			}
+		}
+		server.writeJSON(responseWriter, http.StatusOK, response)
+		return
+	}
+
+	json.Unmarshal([]byte(bulletsJSON), &recap.Bullets)
+	recap.Language = language.String
+	recap.Model = model.String
+	server.writeJSON(responseWriter, http.StatusOK, struct {
+		models.LectureRecap
+		Status string `json:"status"`
+	}{recap, "ready"})
+}
+
 // handleUpdateLecture updates a lecture
 func (server *Server) handleUpdateLecture(responseWriter http.ResponseWriter, request *http.Request) {
 	var updateRequest struct {
-		LectureID     string  `json:"lecture_id"`
-		ExamID        string  `json:"exam_id"`
-		Title         *string `json:"title"`
-		Description   *string `json:"description"`
-		SpecifiedDate *string `json:"specified_date"`
+		LectureID      string                  `json:"lecture_id"`
+		ExamID         string                  `json:"exam_id"`
+		Title          *string                 `json:"title"`
+		Description    *string                 `json:"description"`
+		SpecifiedDate  *string                 `json:"specified_date"`
+		MetadataFields *[]models.MetadataField `json:"metadata_fields"` // Replaces the stored fields as a whole
 	}
 
 	if err := json.NewDecoder(request.Body).Decode(&updateRequest); err != nil {
@@ -562,16 +941,26 @@ func (server *Server) handleUpdateLecture(responseWriter http.ResponseWriter, re
 		server.writeError(responseWriter, http.StatusBadRequest, "VALIDATION_ERROR", "lecture_id and exam_id are required", nil)
 		return
 	}
+	if updateRequest.MetadataFields != nil {
+		if validationMessage := models.ValidateMetadataFields(*updateRequest.MetadataFields); validationMessage != "" {
+			server.writeError(responseWriter, http.StatusBadRequest, "VALIDATION_ERROR", validationMessage, nil)
+			return
+		}
+	}
 
 	userID := server.getUserID(request)
 
+	if !server.authorizeExam(responseWriter, request, updateRequest.ExamID, models.ExamRoleManager) {
+		return
+	}
+
 	// Check if lecture exists and belongs to exam and user
 	var exists bool
 	err := server.database.QueryRow(`
 		SELECT EXISTS(
 			SELECT 1 FROM lectures 
 			JOIN exams ON lectures.exam_id = exams.id
-			WHERE lectures.id = ? AND lectures.exam_id = ? AND exams.user_id = ?
+			WHERE lectures.id = ? AND lectures.exam_id = ? AND `+examAccess(models.ExamRoleManager)+`
 		)
 	`, updateRequest.LectureID, updateRequest.ExamID, userID).Scan(&exists)
 	if err != nil || !exists {
@@ -584,12 +973,16 @@ func (server *Server) handleUpdateLecture(responseWriter http.ResponseWriter, re
 	query := "UPDATE lectures SET updated_at = ?"
 	updates = append(updates, time.Now())
 
+	// The previous title goes into the history, so a rename can be undone
+	var currentTitle string
+	var changedFields []string
+	server.database.QueryRow("SELECT title FROM lectures WHERE id = ?", updateRequest.LectureID).Scan(&currentTitle)
+	newTitle := currentTitle
+
 	if updateRequest.Title != nil || updateRequest.Description != nil {
-		currentTitle := ""
 		currentDescription := ""
-		server.database.QueryRow("SELECT title, description FROM lectures WHERE id = ?", updateRequest.LectureID).Scan(&currentTitle, &currentDescription)
+		server.database.QueryRow("SELECT description FROM lectures WHERE id = ?", updateRequest.LectureID).Scan(&currentDescription)
 
-		newTitle := currentTitle
 		if updateRequest.Title != nil {
 			newTitle = *updateRequest.Title
 		}
@@ -598,20 +991,25 @@ func (server *Server) handleUpdateLecture(responseWriter http.ResponseWriter, re
 			newDescription = *updateRequest.Description
 		}
 
-		cleanedTitle, cleanedDescription, metrics, _ := server.toolGenerator.CorrectProjectTitleDescription(request.Context(), newTitle, newDescription, "")
+		cleanedTitle, cleanedDescription, metrics, _ := server.polishTitleDescription(request.Context(), server.billedUserID(userID, updateRequest.ExamID), updateRequest.LectureID, newTitle, newDescription)
 		slog.Info("Lecture title/description updated and polished",
 			"lectureID", updateRequest.LectureID,
 			"input_tokens", metrics.InputTokens,
 			"output_tokens", metrics.OutputTokens,
 			"estimated_cost_usd", metrics.EstimatedCost)
 
+		newTitle = currentTitle
 		if updateRequest.Title != nil {
 			query += ", title = ?"
 			updates = append(updates, cleanedTitle)
+			newTitle = cleanedTitle
 		}
 		if updateRequest.Description != nil {
 			query += ", description = ?"
 			updates = append(updates, cleanedDescription)
+			if cleanedDescription != currentDescription {
+				changedFields = append(changedFields, "description")
+			}
 		}
 		query += ", estimated_cost = estimated_cost + ?"
 		updates = append(updates, metrics.EstimatedCost)
@@ -636,6 +1034,13 @@ func (server *Server) handleUpdateLecture(responseWriter http.ResponseWriter, re
 		}
 		query += ", specified_date = ?"
 		updates = append(updates, specifiedDate)
+		changedFields = append(changedFields, "date")
+	}
+	if updateRequest.MetadataFields != nil {
+		metadataFields, _ := json.Marshal(updateRequest.MetadataFields)
+		query += ", metadata_fields = ?"
+		updates = append(updates, string(metadataFields))
+		changedFields = append(changedFields, "metadata fields")
 	}
 
 	query += " WHERE id = ? AND exam_id = ?"
@@ -647,18 +1052,39 @@ func (server *Server) handleUpdateLecture(responseWriter http.ResponseWriter, re
 		return
 	}
 
+	if action, summary := describeUpdate("lecture", currentTitle, newTitle, changedFields); action != "" {
+		event := models.ResourceEvent{
+			ExamID:       updateRequest.ExamID,
+			LectureID:    updateRequest.LectureID,
+			ResourceType: models.ResourceTypeLecture,
+			ResourceID:   updateRequest.LectureID,
+			Action:       action,
+			Summary:      summary,
+		}
+		if action == models.ResourceActionRenamed {
+			event.Undo = &models.ResourceEventUndo{
+				Description: fmt.Sprintf("Rename the lecture back to \"%s\"", currentTitle),
+				Method:      http.MethodPatch,
+				Path:        "/api/lectures",
+				Body:        map[string]any{"exam_id": updateRequest.ExamID, "lecture_id": updateRequest.LectureID, "title": currentTitle},
+			}
+		}
+		server.recordEvent(request, event)
+	}
+
 	// Fetch updated lecture
 	var lecture models.Lecture
-	var description sql.NullString
+	var description, metadataFields sql.NullString
 	err = server.database.QueryRow(`
-		SELECT id, exam_id, title, description, status, created_at, updated_at
+		SELECT id, exam_id, title, description, status, metadata_fields, created_at, updated_at
 		FROM lectures
 		WHERE id = ?
-	`, updateRequest.LectureID).Scan(&lecture.ID, &lecture.ExamID, &lecture.Title, &description, &lecture.Status, &lecture.CreatedAt, &lecture.UpdatedAt)
+	`, updateRequest.LectureID).Scan(&lecture.ID, &lecture.ExamID, &lecture.Title, &description, &lecture.Status, &metadataFields, &lecture.CreatedAt, &lecture.UpdatedAt)
 
 	if description.Valid {
 		lecture.Description = description.String
 	}
+	lecture.MetadataFields = database.ParseMetadataFields(metadataFields)
 
 	if err != nil {
 		server.writeError(responseWriter, http.StatusInternalServerError, "DATABASE_ERROR", "Failed to fetch updated lecture", nil)
@@ -686,14 +1112,18 @@ func (server *Server) handleDeleteLecture(responseWriter http.ResponseWriter, re
 
 	userID := server.getUserID(request)
 
+	if !server.authorizeExam(responseWriter, request, deleteRequest.ExamID, models.ExamRoleManager) {
+		return
+	}
+
 	// Check if lecture is currently processing or belongs to another exam/user
-	var status string
+	var status, title string
 	var currentExamID string
 	err := server.database.QueryRow(`
-		SELECT status, exam_id FROM lectures 
+		SELECT status, exam_id, lectures.title FROM lectures 
 		JOIN exams ON lectures.exam_id = exams.id
-		WHERE lectures.id = ? AND exams.user_id = ?
-	`, deleteRequest.LectureID, userID).Scan(&status, &currentExamID)
+		WHERE lectures.id = ? AND `+examAccess(models.ExamRoleManager)+`
+	`, deleteRequest.LectureID, userID).Scan(&status, &currentExamID, &title)
 
 	if err == sql.ErrNoRows || currentExamID != deleteRequest.ExamID {
 		server.writeError(responseWriter, http.StatusNotFound, "NOT_FOUND", "Lecture not found in this exam", nil)
@@ -717,6 +1147,7 @@ func (server *Server) handleDeleteLecture(responseWriter http.ResponseWriter, re
 	}
 
 	// 2. Delete from database (cascades to lecture_media, transcripts, reference_documents)
+	objectKeys := server.lectureObjectKeys("lectures.id = ?", deleteRequest.LectureID)
 	result, err := server.database.Exec("DELETE FROM lectures WHERE id = ? AND exam_id = ?", deleteRequest.LectureID, deleteRequest.ExamID)
 	if err != nil {
 		server.writeError(responseWriter, http.StatusInternalServerError, "DATABASE_ERROR", "Failed to delete lecture", nil)
@@ -728,6 +1159,16 @@ func (server *Server) handleDeleteLecture(responseWriter http.ResponseWriter, re
 		server.writeError(responseWriter, http.StatusNotFound, "NOT_FOUND", "Lecture not found", nil)
 		return
 	}
+	server.deleteStoredObjects(objectKeys)
+
+	server.recordEvent(request, models.ResourceEvent{
+		ExamID:       deleteRequest.ExamID,
+		LectureID:    deleteRequest.LectureID,
+		ResourceType: models.ResourceTypeLecture,
+		ResourceID:   deleteRequest.LectureID,
+		Action:       models.ResourceActionDeleted,
+		Summary:      fmt.Sprintf("Deleted the lecture \"%s\"", title),
+	})
 
 	server.writeJSON(responseWriter, http.StatusOK, map[string]string{"message": "Lecture deleted successfully"})
 }
@@ -738,6 +1179,7 @@ func (server *Server) handleRetryLectureJob(responseWriter http.ResponseWriter,
 		LectureID string `json:"lecture_id"`
 		ExamID    string `json:"exam_id"`
 		JobType   string `json:"job_type"`
+		Diarize   *bool  `json:"diarize"` // TRANSCRIBE_MEDIA only
 	}
 
 	if err := json.NewDecoder(request.Body).Decode(&retryRequest); err != nil {
@@ -752,12 +1194,16 @@ func (server *Server) handleRetryLectureJob(responseWriter http.ResponseWriter,
 
 	userID := server.getUserID(request)
 
+	if !server.authorizeExam(responseWriter, request, retryRequest.ExamID, models.ExamRoleManager) {
+		return
+	}
+
 	// Verify ownership and get language
 	var language string
 	err := server.database.QueryRow(`
-		SELECT lectures.language FROM lectures 
+		SELECT COALESCE(lectures.language, '') FROM lectures
 		JOIN exams ON lectures.exam_id = exams.id
-		WHERE lectures.id = ? AND lectures.exam_id = ? AND exams.user_id = ?
+		WHERE lectures.id = ? AND lectures.exam_id = ? AND `+examAccess(models.ExamRoleManager)+`
 	`, retryRequest.LectureID, retryRequest.ExamID, userID).Scan(&language)
 
 	if err == sql.ErrNoRows {
@@ -769,6 +1215,24 @@ func (server *Server) handleRetryLectureJob(responseWriter http.ResponseWriter,
 		return
 	}
 
+	// A recap is written from the lecture as it is, so retrying one leaves the lecture status alone
+	if retryRequest.JobType == models.JobTypeGenerateRecap {
+		jobID, err := jobs.RetryLectureRecap(server.jobQueue, server.database, retryRequest.LectureID)
+		if err != nil {
+			server.writeEnqueueError(responseWriter, err, "Failed to enqueue job")
+			return
+		}
+		if jobID == "" {
+			server.writeError(responseWriter, http.StatusConflict, "INVALID_STATE", "The lecture is not ready, or its recap is current or already being written", nil)
+			return
+		}
+		server.writeJSON(responseWriter, http.StatusAccepted, map[string]string{
+			"job_id":  jobID,
+			"message": "Retry job created",
+		})
+		return
+	}
+
 	// Reset lecture status to processing
 	_, err = server.database.Exec("UPDATE lectures SET status = 'processing', updated_at = ? WHERE id = ?", time.Now(), retryRequest.LectureID)
 	if err != nil {
@@ -780,7 +1244,11 @@ func (server *Server) handleRetryLectureJob(responseWriter http.ResponseWriter,
 	var jobID string
 	switch retryRequest.JobType {
 	case models.JobTypeTranscribeMedia:
-		jobID, err = server.jobQueue.Enqueue(userID, models.JobTypeTranscribeMedia, map[string]string{"lecture_id": retryRequest.LectureID}, retryRequest.ExamID, retryRequest.LectureID)
+		transcriptionPayload := map[string]any{"lecture_id": retryRequest.LectureID}
+		if retryRequest.Diarize != nil {
+			transcriptionPayload["diarize"] = *retryRequest.Diarize
+		}
+		jobID, err = server.jobQueue.Enqueue(userID, models.JobTypeTranscribeMedia, transcriptionPayload, retryRequest.ExamID, retryRequest.LectureID)
 	case models.JobTypeIngestDocuments:
 		jobID, err = server.jobQueue.Enqueue(userID, models.JobTypeIngestDocuments, map[string]string{"lecture_id": retryRequest.LectureID, "language_code": language}, retryRequest.ExamID, retryRequest.LectureID)
 	default:
@@ -789,7 +1257,7 @@ func (server *Server) handleRetryLectureJob(responseWriter http.ResponseWriter,
 	}
 
 	if err != nil {
-		server.writeError(responseWriter, http.StatusInternalServerError, "BACKGROUND_JOB_ERROR", "Failed to enqueue job", nil)
+		server.writeEnqueueError(responseWriter, err, "Failed to enqueue job")
 		return
 	}
 
@@ -817,7 +1285,7 @@ func (server *Server) handleGetTranscript(responseWriter http.ResponseWriter, re
 		FROM transcripts 
 		JOIN lectures ON transcripts.lecture_id = lectures.id
 		JOIN exams ON lectures.exam_id = exams.id
-		WHERE transcripts.lecture_id = ? AND exams.user_id = ?
+		WHERE transcripts.lecture_id = ? AND `+examAccess(models.ExamRoleViewer)+`
 	`, lectureID, userID).Scan(&transcriptID, &status, &estimatedCost)
 
 	if err == sql.ErrNoRows {
@@ -829,9 +1297,13 @@ func (server *Server) handleGetTranscript(responseWriter http.ResponseWriter, re
 		return
 	}
 
+	if !server.restoreLectureTranscripts(responseWriter, lectureID) {
+		return
+	}
+
 	// Get segments in order
 	transcriptRows, databaseError := server.database.Query(`
-		SELECT id, transcript_id, media_id, start_millisecond, end_millisecond, text, confidence, speaker
+		SELECT id, transcript_id, media_id, start_millisecond, end_millisecond, text, polished_text, confidence, speaker
 		FROM transcript_segments
 		WHERE transcript_id = ?
 		ORDER BY start_millisecond ASC
@@ -843,15 +1315,19 @@ func (server *Server) handleGetTranscript(responseWriter http.ResponseWriter, re
 	defer transcriptRows.Close()
 
 	var segments []map[string]any
+	var segmentIDs []int
+	var segmentStarts []int64
 	for transcriptRows.Next() {
 		var segmentInternalID int
-		var segmentID, mediaID, text, speaker sql.NullString
+		var segmentID, mediaID, text, polishedText, speaker sql.NullString
 		var startMs, endMs int64
 		var confidence sql.NullFloat64
 
-		if err := transcriptRows.Scan(&segmentInternalID, &segmentID, &mediaID, &startMs, &endMs, &text, &confidence, &speaker); err != nil {
+		if err := transcriptRows.Scan(&segmentInternalID, &segmentID, &mediaID, &startMs, &endMs, &text, &polishedText, &confidence, &speaker); err != nil {
 			continue
 		}
+		segmentIDs = append(segmentIDs, segmentInternalID)
+		segmentStarts = append(segmentStarts, startMs)
 
 		segment := map[string]any{
 			"id":                segmentInternalID,
@@ -859,6 +1335,9 @@ func (server *Server) handleGetTranscript(responseWriter http.ResponseWriter, re
 			"end_millisecond":   endMs,
 			"text":              text.String,
 		}
+		if polishedText.Valid {
+			segment["polished_text"] = polishedText.String
+		}
 		if confidence.Valid {
 			segment["confidence"] = confidence.Float64
 		}
@@ -868,11 +1347,20 @@ func (server *Server) handleGetTranscript(responseWriter http.ResponseWriter, re
 		segments = append(segments, segment)
 	}
 
+	// The caller's bookmarks come with the segment each one falls in, so they can be shown along the text
+	bookmarks, err := database.ListLectureBookmarks(server.database, lectureID, userID)
+	if err != nil {
+		server.writeError(responseWriter, http.StatusInternalServerError, "DATABASE_ERROR", "Failed to get bookmarks", nil)
+		return
+	}
+	anchorBookmarks(bookmarks, segmentIDs, segmentStarts)
+
 	server.writeJSON(responseWriter, http.StatusOK, map[string]any{
 		"transcript_id":  transcriptID,
 		"status":         status,
 		"estimated_cost": estimatedCost,
 		"segments":       segments,
+		"bookmarks":      bookmarks,
 	})
 }
 
@@ -899,6 +1387,11 @@ func (server *Server) handleUpdateTranscript(responseWriter http.ResponseWriter,
 
 	userID := server.getUserID(request)
 
+	examID, authorized := server.authorizeLecture(responseWriter, request, updateRequest.LectureID, models.ExamRoleManager)
+	if !authorized {
+		return
+	}
+
 	// Verify ownership
 	var exists bool
 	err := server.database.QueryRow(`
@@ -906,7 +1399,7 @@ func (server *Server) handleUpdateTranscript(responseWriter http.ResponseWriter,
 			SELECT 1 FROM transcripts 
 			JOIN lectures ON transcripts.lecture_id = lectures.id
 			JOIN exams ON lectures.exam_id = exams.id
-			WHERE transcripts.id = ? AND transcripts.lecture_id = ? AND exams.user_id = ?
+			WHERE transcripts.id = ? AND transcripts.lecture_id = ? AND `+examAccess(models.ExamRoleManager)+`
 		)
 	`, updateRequest.TranscriptID, updateRequest.LectureID, userID).Scan(&exists)
 
@@ -915,6 +1408,10 @@ func (server *Server) handleUpdateTranscript(responseWriter http.ResponseWriter,
 		return
 	}
 
+	if !server.restoreLectureTranscripts(responseWriter, updateRequest.LectureID) {
+		return
+	}
+
 	databaseTransaction, err := server.database.Begin()
 	if err != nil {
 		server.writeError(responseWriter, http.StatusInternalServerError, "DATABASE_ERROR", "Failed to start transaction", nil)
@@ -925,7 +1422,7 @@ func (server *Server) handleUpdateTranscript(responseWriter http.ResponseWriter,
 	for _, segment := range updateRequest.Segments {
 		_, err = databaseTransaction.Exec(`
 			UPDATE transcript_segments 
-			SET text = ? 
+			SET text = ?, polished_text = NULL 
 			WHERE id = ? AND transcript_id = ?
 		`, segment.Text, segment.ID, updateRequest.TranscriptID)
 		if err != nil {
@@ -946,9 +1443,81 @@ func (server *Server) handleUpdateTranscript(responseWriter http.ResponseWriter,
 		return
 	}
 
+	// Chat retrieval re-indexes the edited text on next use
+	if err := embeddings.DeleteLecture(server.database, updateRequest.LectureID); err != nil {
+		slog.Warn("Failed to invalidate lecture embeddings", "lectureID", updateRequest.LectureID, "error", err)
+	}
+
+	server.recordEvent(request, models.ResourceEvent{
+		ExamID:       examID,
+		LectureID:    updateRequest.LectureID,
+		ResourceType: models.ResourceTypeTranscript,
+		ResourceID:   updateRequest.TranscriptID,
+		Action:       models.ResourceActionEdited,
+		Summary:      fmt.Sprintf("Edited %d segments of the transcript", len(updateRequest.Segments)),
+	})
+
 	server.writeJSON(responseWriter, http.StatusOK, map[string]string{"message": "Transcript updated successfully"})
 }
 
+// handlePolishTranscript starts an LLM cleanup pass over a lecture transcript, keeping the raw text intact
+func (server *Server) handlePolishTranscript(responseWriter http.ResponseWriter, request *http.Request) {
+	var polishRequest struct {
+		LectureID string `json:"lecture_id"`
+		ExamID    string `json:"exam_id"`
+		Force     bool   `json:"force"`
+	}
+	if err := json.NewDecoder(request.Body).Decode(&polishRequest); err != nil {
+		server.writeError(responseWriter, http.StatusBadRequest, "VALIDATION_ERROR", "Invalid request body", nil)
+		return
+	}
+
+	if polishRequest.LectureID == "" || polishRequest.ExamID == "" {
+		server.writeError(responseWriter, http.StatusBadRequest, "VALIDATION_ERROR", "lecture_id and exam_id are required", nil)
+		return
+	}
+
+	userID := server.getUserID(request)
+
+	if !server.authorizeExam(responseWriter, request, polishRequest.ExamID, models.ExamRoleGenerator) {
+		return
+	}
+
+	var transcriptStatus string
+	err := server.database.QueryRow(`
+		SELECT transcripts.status FROM transcripts
+		JOIN lectures ON transcripts.lecture_id = lectures.id
+		JOIN exams ON lectures.exam_id = exams.id
+		WHERE transcripts.lecture_id = ? AND lectures.exam_id = ? AND `+examAccess(models.ExamRoleGenerator)+`
+	`, polishRequest.LectureID, polishRequest.ExamID, userID).Scan(&transcriptStatus)
+	if err == sql.ErrNoRows {
+		server.writeError(responseWriter, http.StatusNotFound, "NOT_FOUND", "Transcript not found", nil)
+		return
+	}
+	if err != nil {
+		server.writeError(responseWriter, http.StatusInternalServerError, "DATABASE_ERROR", "Failed to verify transcript", nil)
+		return
+	}
+	if transcriptStatus != "completed" {
+		server.writeError(responseWriter, http.StatusConflict, "TRANSCRIPT_NOT_READY", "The transcript must be completed before it can be polished", nil)
+		return
+	}
+
+	jobID, err := server.jobQueue.Enqueue(userID, models.JobTypePolishTranscript, map[string]any{
+		"lecture_id": polishRequest.LectureID,
+		"force":      polishRequest.Force,
+	}, polishRequest.ExamID, polishRequest.LectureID)
+	if err != nil {
+		server.writeEnqueueError(responseWriter, err, "Failed to create polish job")
+		return
+	}
+
+	server.writeJSON(responseWriter, http.StatusAccepted, map[string]string{
+		"job_id":  jobID,
+		"message": "Transcript polishing started",
+	})
+}
+
 // handleGetTranscriptHTML retrieves the unified transcript for a lecture converted to HTML with timestamps
 func (server *Server) handleGetTranscriptHTML(responseWriter http.ResponseWriter, request *http.Request) {
 	lectureID := request.URL.Query().Get("lecture_id")
@@ -967,7 +1536,7 @@ func (server *Server) handleGetTranscriptHTML(responseWriter http.ResponseWriter
 		FROM transcripts 
 		JOIN lectures ON transcripts.lecture_id = lectures.id
 		JOIN exams ON lectures.exam_id = exams.id
-		WHERE transcripts.lecture_id = ? AND exams.user_id = ?
+		WHERE transcripts.lecture_id = ? AND `+examAccess(models.ExamRoleViewer)+`
 	`, lectureID, userID).Scan(&transcriptID, &status, &estimatedCost)
 
 	if err == sql.ErrNoRows {
@@ -979,6 +1548,10 @@ func (server *Server) handleGetTranscriptHTML(responseWriter http.ResponseWriter
 		return
 	}
 
+	if !server.restoreLectureTranscripts(responseWriter, lectureID) {
+		return
+	}
+
 	// Get transcript segments
 	transcriptRows, databaseError := server.database.Query(`
 		SELECT 
@@ -0,0 +1,243 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"lectures/internal/database"
+	"lectures/internal/models"
+)
+
+func TestHandleUploadProgressPersistence(t *testing.T) {
+	server, _, sessionID, cleanup := setupUniqueExtraTestEnv(t, "uploadprogress")
+	defer cleanup()
+
+	sendRequest := func(method, path string, body []byte) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, bytes.NewBuffer(body))
+		req.Header.Set("Authorization", "Bearer "+sessionID)
+		req.Header.Set("X-Requested-With", "XMLHttpRequest")
+		rr := httptest.NewRecorder()
+		server.Handler().ServeHTTP(rr, req)
+		return rr
+	}
+
+	preparePayload, _ := json.Marshal(map[string]any{"filename": "lecture.mp3", "file_size_bytes": 10})
+	rr := sendRequest("POST", "/api/uploads/prepare", preparePayload)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", rr.Code, rr.Body.String())
+	}
+	var prepared struct {
+		Data struct {
+			UploadID string `json:"upload_id"`
+		} `json:"data"`
+	}
+	json.Unmarshal(rr.Body.Bytes(), &prepared)
+	uploadID := prepared.Data.UploadID
+	defer os.RemoveAll(filepath.Join(os.TempDir(), "lectures-uploads", uploadID))
+
+	if rr := sendRequest("POST", "/api/uploads/append?upload_id="+uploadID, []byte("hello")); rr.Code != http.StatusOK {
+		t.Fatalf("Expected status 200 on append, got %d", rr.Code)
+	}
+
+	readUpload := func() (int64, string, bool) {
+		rr := sendRequest("GET", "/api/uploads/details?upload_id="+uploadID, nil)
+		var upload struct {
+			Data struct {
+				BytesReceived int64      `json:"bytes_received"`
+				Status        string     `json:"status"`
+				LastChunkAt   *time.Time `json:"last_chunk_at"`
+			} `json:"data"`
+		}
+		json.Unmarshal(rr.Body.Bytes(), &upload)
+		return upload.Data.BytesReceived, upload.Data.Status, upload.Data.LastChunkAt != nil
+	}
+
+	if bytesReceived, status, hasLastChunk := readUpload(); bytesReceived != 5 || status != "uploading" || !hasLastChunk {
+		t.Errorf("Expected 5 bytes uploading with a chunk time, got %d %q %v", bytesReceived, status, hasLastChunk)
+	}
+
+	// Simulate a restart that lost the in-flight accounting
+	server.database.Exec("UPDATE uploads SET bytes_received = 0 WHERE id = ?", uploadID)
+	server.reconcileUploads()
+	if bytesReceived, _, _ := readUpload(); bytesReceived != 5 {
+		t.Errorf("Expected reconciliation to restore 5 bytes, got %d", bytesReceived)
+	}
+
+	sendRequest("POST", "/api/uploads/append?upload_id="+uploadID, []byte("world"))
+	stagePayload, _ := json.Marshal(map[string]string{"upload_id": uploadID})
+	if rr := sendRequest("POST", "/api/uploads/stage", stagePayload); rr.Code != http.StatusOK {
+		t.Fatalf("Expected status 200 on stage, got %d: %s", rr.Code, rr.Body.String())
+	}
+	if bytesReceived, status, _ := readUpload(); bytesReceived != 10 || status != "staged" {
+		t.Errorf("Expected 10 staged bytes, got %d %q", bytesReceived, status)
+	}
+
+	var listed struct {
+		Data []struct {
+			ID string `json:"id"`
+		} `json:"data"`
+	}
+	json.Unmarshal(sendRequest("GET", "/api/uploads", nil).Body.Bytes(), &listed)
+	if len(listed.Data) != 1 || listed.Data[0].ID != uploadID {
+		t.Errorf("Expected the upload to be listed, got %+v", listed.Data)
+	}
+
+	// A staging directory without an uploads row belongs to no one
+	orphanDirectory := filepath.Join(os.TempDir(), "lectures-uploads", "orphan-"+uploadID)
+	os.MkdirAll(orphanDirectory, 0755)
+	os.WriteFile(filepath.Join(orphanDirectory, "upload.data"), nil, 0644)
+	defer os.RemoveAll(orphanDirectory)
+	if rr := sendRequest("POST", "/api/uploads/append?upload_id=orphan-"+uploadID, []byte("hello")); rr.Code != http.StatusNotFound {
+		t.Errorf("Expected 404 appending to an upload without a row, got %d", rr.Code)
+	}
+	orphanPayload, _ := json.Marshal(map[string]string{"upload_id": "orphan-" + uploadID})
+	if rr := sendRequest("POST", "/api/uploads/stage", orphanPayload); rr.Code != http.StatusNotFound {
+		t.Errorf("Expected 404 staging an upload without a row, got %d", rr.Code)
+	}
+}
+
+func TestHandleLectureProcessingReport(t *testing.T) {
+	server, userID, sessionID, cleanup := setupUniqueExtraTestEnv(t, "report")
+	defer cleanup()
+
+	server.database.Exec("INSERT INTO exams (id, user_id, title) VALUES ('report-exam', ?, 'Biology')", userID)
+	server.database.Exec("INSERT INTO lectures (id, exam_id, title, status) VALUES ('report-lecture', 'report-exam', 'Cells', 'processing')")
+	server.database.Exec("INSERT INTO lecture_media (id, lecture_id, media_type, sequence_order, duration_milliseconds, file_path, original_filename) VALUES ('report-media', 'report-lecture', 'audio', 0, 3725000, '/tmp/a.mp3', 'a.mp3')")
+	server.database.Exec("INSERT INTO transcripts (id, lecture_id, status, estimated_cost) VALUES ('report-transcript', 'report-lecture', 'completed', 0.25)")
+	for index, confidence := range []any{0.95, 0.3, 0.8, nil} {
+		server.database.Exec("INSERT INTO transcript_segments (transcript_id, start_millisecond, end_millisecond, text, confidence) VALUES ('report-transcript', ?, ?, 'Text', ?)", index*1000, index*1000+1000, confidence)
+	}
+	server.database.Exec("INSERT INTO reference_documents (id, lecture_id, document_type, title, file_path, page_count, extraction_status, estimated_cost) VALUES ('report-document', 'report-lecture', 'pdf', 'slides.pdf', '/tmp/slides.pdf', 2, 'completed', 0.5)")
+	server.database.Exec("INSERT INTO reference_pages (document_id, page_number, image_path, extracted_text) VALUES ('report-document', 1, '/tmp/1.png', 'Cells'), ('report-document', 2, '/tmp/2.png', '  ')")
+	server.database.Exec("INSERT INTO jobs (id, user_id, lecture_id, type, status, payload, error, created_at, completed_at) VALUES ('report-job-1', ?, 'report-lecture', 'TRANSCRIBE_MEDIA', 'FAILED', '{}', 'provider timeout', ?, ?)", userID, time.Now().Add(-time.Minute), time.Now())
+	server.database.Exec("INSERT INTO jobs (id, user_id, lecture_id, type, status, payload, created_at) VALUES ('report-job-2', ?, 'report-lecture', 'TRANSCRIBE_MEDIA', 'COMPLETED', '{}', ?)", userID, time.Now())
+
+	sendRequest := func() *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", "/api/lectures/report?lecture_id=report-lecture&exam_id=report-exam", nil)
+		req.Header.Set("Authorization", "Bearer "+sessionID)
+		rr := httptest.NewRecorder()
+		server.Handler().ServeHTTP(rr, req)
+		return rr
+	}
+
+	if rr := sendRequest(); rr.Code != http.StatusNotFound {
+		t.Fatalf("Expected status 404 before the lecture is ready, got %d", rr.Code)
+	}
+
+	database.CheckLectureReadiness(server.database, "report-lecture")
+
+	rr := sendRequest()
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d. Body: %s", rr.Code, rr.Body.String())
+	}
+	var response struct {
+		Data models.ProcessingReport `json:"data"`
+	}
+	json.Unmarshal(rr.Body.Bytes(), &response)
+	report := response.Data
+
+	if report.TotalDurationMilliseconds != 3725000 || report.TotalCost != 0.75 {
+		t.Errorf("Unexpected totals: %+v", report)
+	}
+	if report.Transcript == nil || report.Transcript.SegmentCount != 4 || report.Transcript.LowConfidenceSegments != 1 {
+		t.Fatalf("Unexpected transcript section: %+v", report.Transcript)
+	}
+	expectedCounts := []int{1, 1, 0, 1, 1}
+	for bucketIndex, bucket := range report.Transcript.ConfidenceDistribution {
+		if bucket.Count != expectedCounts[bucketIndex] {
+			t.Errorf("Unexpected count for bucket %s: %d", bucket.Label, bucket.Count)
+		}
+	}
+	if len(report.Documents) != 1 || len(report.Documents[0].EmptyPages) != 1 || report.Documents[0].EmptyPages[0] != 2 {
+		t.Errorf("Expected page 2 to be reported as empty, got %+v", report.Documents)
+	}
+	if len(report.Stages) != 1 || report.Stages[0].Attempts != 2 || len(report.Stages[0].Failures) != 1 || report.Stages[0].Failures[0].Error != "provider timeout" {
+		t.Errorf("Unexpected stages: %+v", report.Stages)
+	}
+	if !strings.Contains(report.Markdown, "01:02:05") || !strings.Contains(report.Markdown, "provider timeout") {
+		t.Errorf("Expected the markdown to include durations and failures, got:\n%s", report.Markdown)
+	}
+}
+
+func TestHandleLectureRecap(t *testing.T) {
+	server, userID, sessionID, cleanup := setupUniqueExtraTestEnv(t, "recap")
+	defer cleanup()
+
+	server.database.Exec("INSERT INTO exams (id, user_id, title) VALUES ('recap-exam', ?, 'Biology')", userID)
+	server.database.Exec("INSERT INTO lectures (id, exam_id, title, status) VALUES ('recap-lecture', 'recap-exam', 'Cells', 'processing')")
+	server.database.Exec("INSERT INTO transcripts (id, lecture_id, status) VALUES ('recap-transcript', 'recap-lecture', 'completed')")
+	server.database.Exec("INSERT INTO transcript_segments (transcript_id, start_millisecond, end_millisecond, text) VALUES ('recap-transcript', 0, 1000, 'Cells are the unit of life')")
+
+	sendRequest := func() map[string]any {
+		req := httptest.NewRequest("GET", "/api/lectures/recap?lecture_id=recap-lecture&exam_id=recap-exam", nil)
+		req.Header.Set("Authorization", "Bearer "+sessionID)
+		rr := httptest.NewRecorder()
+		server.Handler().ServeHTTP(rr, req)
+		if rr.Code != http.StatusOK {
+			t.Fatalf("Expected status 200, got %d. Body: %s", rr.Code, rr.Body.String())
+		}
+		var response struct {
+			Data map[string]any `json:"data"`
+		}
+		json.Unmarshal(rr.Body.Bytes(), &response)
+		return response.Data
+	}
+
+	if recap := sendRequest(); recap["status"] != "unavailable" {
+		t.Errorf("Expected no recap before the lecture is ready, got %v", recap)
+	}
+
+	server.database.Exec("UPDATE lectures SET status = 'ready' WHERE id = 'recap-lecture'")
+	if recap := sendRequest(); recap["status"] != "pending" {
+		t.Errorf("Expected a recap to be scheduled for a ready lecture, got %v", recap)
+	}
+	var recapJobs int
+	server.database.QueryRow("SELECT COUNT(*) FROM jobs WHERE type = ? AND lecture_id = 'recap-lecture'", models.JobTypeGenerateRecap).Scan(&recapJobs)
+	if recapJobs != 1 {
+		t.Errorf("Expected one recap job, got %d", recapJobs)
+	}
+
+	// A failed recap is reported instead of being queued again on every read
+	server.database.Exec("UPDATE jobs SET status = 'FAILED', error = 'model unavailable' WHERE type = ? AND lecture_id = 'recap-lecture'", models.JobTypeGenerateRecap)
+	for range 2 {
+		if recap := sendRequest(); recap["status"] != "failed" || recap["error"] != "model unavailable" {
+			t.Errorf("Expected the failed recap to be reported, got %v", recap)
+		}
+	}
+	server.database.QueryRow("SELECT COUNT(*) FROM jobs WHERE type = ? AND lecture_id = 'recap-lecture'", models.JobTypeGenerateRecap).Scan(&recapJobs)
+	if recapJobs != 1 {
+		t.Errorf("Expected reads not to queue the failed recap again, got %d jobs", recapJobs)
+	}
+
+	retryBody, _ := json.Marshal(map[string]string{"lecture_id": "recap-lecture", "exam_id": "recap-exam", "job_type": models.JobTypeGenerateRecap})
+	retryRequest := httptest.NewRequest("POST", "/api/lectures/retry-job", bytes.NewBuffer(retryBody))
+	retryRequest.Header.Set("Authorization", "Bearer "+sessionID)
+	retryRequest.Header.Set("X-Requested-With", "XMLHttpRequest")
+	retryResponse := httptest.NewRecorder()
+	server.Handler().ServeHTTP(retryResponse, retryRequest)
+	if retryResponse.Code != http.StatusAccepted {
+		t.Fatalf("Expected status 202 retrying the recap, got %d. Body: %s", retryResponse.Code, retryResponse.Body.String())
+	}
+	var lectureStatus string
+	server.database.QueryRow("SELECT status FROM lectures WHERE id = 'recap-lecture'").Scan(&lectureStatus)
+	if lectureStatus != "ready" {
+		t.Errorf("Expected retrying the recap to keep the lecture ready, got %q", lectureStatus)
+	}
+	if recap := sendRequest(); recap["status"] != "pending" {
+		t.Errorf("Expected the retried recap to be pending, got %v", recap)
+	}
+
+	server.database.Exec("INSERT INTO lecture_recaps (lecture_id, bullets, language, model, content_signature) VALUES ('recap-lecture', '[\"Cells are the unit of life.\"]', 'en-US', 'cheap-model', '1:26:0:0')")
+	recap := sendRequest()
+	bullets, _ := recap["bullets"].([]any)
+	if recap["status"] != "ready" || len(bullets) != 1 || bullets[0] != "Cells are the unit of life." || recap["model"] != "cheap-model" {
+		t.Errorf("Unexpected cached recap: %v", recap)
+	}
+}
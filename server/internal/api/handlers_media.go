@@ -3,6 +3,7 @@ package api
 import (
 	"database/sql"
 	"encoding/json"
+	"fmt"
 	"net/http"
 	"os"
 	"path/filepath"
@@ -25,7 +26,7 @@ func (server *Server) handleListMedia(responseWriter http.ResponseWriter, reques
 		FROM lecture_media
 		JOIN lectures ON lecture_media.lecture_id = lectures.id
 		JOIN exams ON lectures.exam_id = exams.id
-		WHERE lecture_media.lecture_id = ? AND exams.user_id = ?
+		WHERE lecture_media.lecture_id = ? AND `+examAccess(models.ExamRoleViewer)+`
 		ORDER BY lecture_media.sequence_order ASC
 	`, lectureID, userID)
 	if databaseError != nil {
@@ -73,14 +74,20 @@ func (server *Server) handleDeleteMedia(responseWriter http.ResponseWriter, requ
 
 	userID := server.getUserID(request)
 
+	examID, authorized := server.authorizeLecture(responseWriter, request, deleteRequest.LectureID, models.ExamRoleManager)
+	if !authorized {
+		return
+	}
+
 	// Get file path and verify ownership
 	var filePath string
+	var originalFilename, objectKey sql.NullString
 	err := server.database.QueryRow(`
-		SELECT lecture_media.file_path FROM lecture_media 
+		SELECT lecture_media.file_path, lecture_media.original_filename, lecture_media.object_key FROM lecture_media 
 		JOIN lectures ON lecture_media.lecture_id = lectures.id
 		JOIN exams ON lectures.exam_id = exams.id
-		WHERE lecture_media.id = ? AND lecture_media.lecture_id = ? AND exams.user_id = ?
-	`, deleteRequest.MediaID, deleteRequest.LectureID, userID).Scan(&filePath)
+		WHERE lecture_media.id = ? AND lecture_media.lecture_id = ? AND `+examAccess(models.ExamRoleManager)+`
+	`, deleteRequest.MediaID, deleteRequest.LectureID, userID).Scan(&filePath, &originalFilename, &objectKey)
 
 	if err == sql.ErrNoRows {
 		server.writeError(responseWriter, http.StatusNotFound, "NOT_FOUND", "Media not found", nil)
@@ -97,6 +104,18 @@ func (server *Server) handleDeleteMedia(responseWriter http.ResponseWriter, requ
 		server.writeError(responseWriter, http.StatusInternalServerError, "DATABASE_ERROR", "Failed to delete media from database", nil)
 		return
 	}
+	if objectKey.Valid {
+		server.deleteStoredObjects([]string{objectKey.String})
+	}
+
+	server.recordEvent(request, models.ResourceEvent{
+		ExamID:       examID,
+		LectureID:    deleteRequest.LectureID,
+		ResourceType: models.ResourceTypeMedia,
+		ResourceID:   deleteRequest.MediaID,
+		Action:       models.ResourceActionRemoved,
+		Summary:      fmt.Sprintf("Removed the recording \"%s\"", originalFilename.String),
+	})
 
 	server.writeJSON(responseWriter, http.StatusOK, map[string]string{"message": "Media deleted successfully"})
 }
@@ -111,9 +130,9 @@ func (server *Server) handleGetMediaContent(responseWriter http.ResponseWriter,
 
 	// Try multiple token sources with validation (cookie -> header -> query param)
 	// This handles cases where old cookies exist but the current session uses a different token
-	sessionToken := server.getValidSessionToken(request)
-	if sessionToken == "" {
-		server.writeError(responseWriter, http.StatusUnauthorized, "AUTHENTICATION_ERROR", "Authentication required", nil)
+	sessionToken, passwordChangeRequired := server.getValidSessionToken(request)
+	if sessionToken == "" || passwordChangeRequired {
+		server.writeSessionError(responseWriter, passwordChangeRequired)
 		return
 	}
 
@@ -125,16 +144,16 @@ func (server *Server) handleGetMediaContent(responseWriter http.ResponseWriter,
 		return
 	}
 
-	// Get file path, type, and BLOB data; verify ownership
-	var filePath, mediaType string
+	// Get file path, type, and BLOB data or object key; verify ownership
+	var filePath, mediaType, objectKey string
 	var fileData []byte
 	err = server.database.QueryRow(`
-		SELECT lecture_media.file_path, lecture_media.media_type, lecture_media.file_data
+		SELECT lecture_media.file_path, lecture_media.media_type, lecture_media.file_data, COALESCE(lecture_media.object_key, '')
 		FROM lecture_media
 		JOIN lectures ON lecture_media.lecture_id = lectures.id
 		JOIN exams ON lectures.exam_id = exams.id
-		WHERE lecture_media.id = ? AND exams.user_id = ?
-	`, mediaID, userID).Scan(&filePath, &mediaType, &fileData)
+		WHERE lecture_media.id = ? AND `+examAccess(models.ExamRoleViewer)+`
+	`, mediaID, userID).Scan(&filePath, &mediaType, &fileData, &objectKey)
 
 	if err == sql.ErrNoRows {
 		server.writeError(responseWriter, http.StatusNotFound, "NOT_FOUND", "Media not found", nil)
@@ -145,6 +164,12 @@ func (server *Server) handleGetMediaContent(responseWriter http.ResponseWriter,
 		return
 	}
 
+	// Media in an object store that presigns URLs is downloaded from it directly
+	fileData, loaded := server.loadStoredContent(responseWriter, request, fileData, objectKey, filepath.Base(filePath), true)
+	if !loaded {
+		return
+	}
+
 	// Serve from BLOB: restore to temp file so http.ServeFile can handle range requests
 	if len(fileData) > 0 {
 		ext := filepath.Ext(filePath)
@@ -0,0 +1,93 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net/http"
+
+	"lectures/internal/storage"
+)
+
+// loadStoredContent returns file content kept in a BLOB column or under an object key, for the caller to serve.
+// When the object store hands out presigned URLs the client is redirected to one instead, and false is returned
+// as for errors, which are answered here
+func (server *Server) loadStoredContent(responseWriter http.ResponseWriter, request *http.Request, blob []byte, objectKey string, fileName string, inline bool) ([]byte, bool) {
+	if len(blob) > 0 || objectKey == "" {
+		return blob, true
+	}
+	if server.objectStore == nil {
+		slog.Error("File is in object storage, which is not configured", "object_key", objectKey)
+		server.writeError(responseWriter, http.StatusInternalServerError, "OBJECT_STORAGE_ERROR", "The file is in object storage, which is not configured", nil)
+		return nil, false
+	}
+
+	presignedURL, err := server.objectStore.PresignGet(objectKey, fileName, inline)
+	if err == nil && presignedURL != "" {
+		http.Redirect(responseWriter, request, presignedURL, http.StatusFound)
+		return nil, false
+	}
+
+	content, err := storage.LoadContent(request.Context(), server.objectStore, nil, objectKey)
+	if errors.Is(err, storage.ErrObjectNotFound) {
+		server.writeError(responseWriter, http.StatusNotFound, "FILE_NOT_FOUND", "File not found in object storage", nil)
+		return nil, false
+	}
+	if err != nil {
+		slog.Error("Failed to read from object storage", "object_key", objectKey, "error", err)
+		server.writeError(responseWriter, http.StatusBadGateway, "OBJECT_STORAGE_ERROR", "Failed to read the file from object storage", nil)
+		return nil, false
+	}
+	return content, true
+}
+
+// deleteStoredObjects removes objects whose rows were deleted. Failures are logged: a leftover object is only
+// wasted space
+func (server *Server) deleteStoredObjects(objectKeys []string) {
+	if server.objectStore == nil {
+		return
+	}
+	for _, objectKey := range objectKeys {
+		if err := server.objectStore.Delete(context.Background(), objectKey); err != nil {
+			slog.Warn("Failed to delete object", "object_key", objectKey, "error", err)
+		}
+	}
+}
+
+// lectureObjectKeys lists the objects of the media and page images of the lectures a condition on lectures
+// selects, to delete them with the lectures
+func (server *Server) lectureObjectKeys(condition string, argument string) []string {
+	return server.queryObjectKeys(`
+		SELECT lecture_media.object_key FROM lecture_media
+		JOIN lectures ON lecture_media.lecture_id = lectures.id
+		WHERE lecture_media.object_key IS NOT NULL AND `+condition+`
+		UNION ALL
+		SELECT reference_pages.object_key FROM reference_pages
+		JOIN reference_documents ON reference_pages.document_id = reference_documents.id
+		JOIN lectures ON reference_documents.lecture_id = lectures.id
+		WHERE reference_pages.object_key IS NOT NULL AND `+condition+`
+	`, argument, argument)
+}
+
+// queryObjectKeys lists the object keys a query returns, to delete them once their rows are gone. Nothing is
+// listed without an object store
+func (server *Server) queryObjectKeys(query string, arguments ...any) []string {
+	if server.objectStore == nil {
+		return nil
+	}
+	rows, err := server.database.Query(query, arguments...)
+	if err != nil {
+		slog.Warn("Failed to list object keys", "error", err)
+		return nil
+	}
+	defer rows.Close()
+
+	var objectKeys []string
+	for rows.Next() {
+		var objectKey string
+		if rows.Scan(&objectKey) == nil && objectKey != "" {
+			objectKeys = append(objectKeys, objectKey)
+		}
+	}
+	return objectKeys
+}
@@ -0,0 +1,74 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"lectures/internal/configuration"
+	"lectures/internal/storage"
+)
+
+func TestHandleObjectStorage(t *testing.T) {
+	server, userID, sessionID, cleanup := setupUniqueExtraTestEnv(t, "object_storage")
+	defer cleanup()
+
+	localStore := storage.NewLocalObjectStore(filepath.Join(server.configuration.Storage.DataDirectory, "objects"))
+	server.SetObjectStore(localStore)
+	ctx := context.Background()
+
+	server.database.Exec("INSERT INTO exams (id, user_id, title) VALUES ('object-exam', ?, 'Optics')", userID)
+	server.database.Exec("INSERT INTO lectures (id, exam_id, title) VALUES ('object-lecture', 'object-exam', 'Lenses')")
+	mediaKey := storage.MediaObjectKey("object-lecture", "object-media", ".mp3")
+	localStore.Put(ctx, mediaKey, []byte("audio bytes"), "audio/mpeg")
+	server.database.Exec("INSERT INTO lecture_media (id, lecture_id, media_type, sequence_order, file_path, object_key) VALUES ('object-media', 'object-lecture', 'audio', 0, 'object-media.mp3', ?)", mediaKey)
+	server.database.Exec("INSERT INTO reference_documents (id, lecture_id, document_type, title, file_path, page_count, extraction_status) VALUES ('object-document', 'object-lecture', 'pdf', 'Slides', 'slides.pdf', 1, 'completed')")
+	pageKey := storage.PageImageObjectKey("object-lecture", "object-document", 1)
+	localStore.Put(ctx, pageKey, []byte("png bytes"), "image/png")
+	server.database.Exec("INSERT INTO reference_pages (document_id, page_number, image_path, extracted_text, object_key) VALUES ('object-document', 1, 'page_1.png', 'Lenses', ?)", pageKey)
+
+	sendRequest := func(method string, target string, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, target, strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer "+sessionID)
+		req.Header.Set("X-Requested-With", "XMLHttpRequest")
+		req.Header.Set("Content-Type", "application/json")
+		rr := httptest.NewRecorder()
+		server.Handler().ServeHTTP(rr, req)
+		return rr
+	}
+
+	// A local store is served by the server
+	if response := sendRequest("GET", "/api/media/content?media_id=object-media", ""); response.Code != http.StatusOK || response.Body.String() != "audio bytes" {
+		t.Errorf("Expected the media from the object store, got %d: %s", response.Code, response.Body.String())
+	}
+	pageImageURL := "/api/documents/pages/image?document_id=object-document&lecture_id=object-lecture&page_number=1"
+	if response := sendRequest("GET", pageImageURL, ""); response.Code != http.StatusOK || response.Body.String() != "png bytes" {
+		t.Errorf("Expected the page image from the object store, got %d: %s", response.Code, response.Body.String())
+	}
+
+	// A store handing out presigned URLs is downloaded from directly
+	s3Store, err := storage.NewS3ObjectStore(configuration.S3Configuration{Endpoint: "http://minio.test:9000", Bucket: "lectures", AccessKeyID: "minio", SecretAccessKey: "secret", PathStyle: true})
+	if err != nil {
+		t.Fatalf("NewS3ObjectStore failed: %v", err)
+	}
+	server.SetObjectStore(s3Store)
+	response := sendRequest("GET", pageImageURL, "")
+	if location := response.Header().Get("Location"); response.Code != http.StatusFound || !strings.HasPrefix(location, "http://minio.test:9000/lectures/"+pageKey+"?") || !strings.Contains(location, "X-Amz-Signature=") {
+		t.Errorf("Expected a redirect to a presigned URL, got %d to %q", response.Code, location)
+	}
+
+	// Deleting the lecture removes its objects
+	server.SetObjectStore(localStore)
+	if response := sendRequest("DELETE", "/api/lectures", `{"lecture_id":"object-lecture","exam_id":"object-exam"}`); response.Code != http.StatusOK {
+		t.Fatalf("Expected the lecture deleted, got %d: %s", response.Code, response.Body.String())
+	}
+	for _, key := range []string{mediaKey, pageKey} {
+		if _, err := localStore.Get(ctx, key); !errors.Is(err, storage.ErrObjectNotFound) {
+			t.Errorf("Expected %s removed with its lecture, got %v", key, err)
+		}
+	}
+}
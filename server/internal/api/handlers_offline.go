@@ -0,0 +1,297 @@
+package api
+
+import (
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"time"
+
+	"lectures/internal/database"
+	"lectures/internal/markdown"
+	"lectures/internal/models"
+)
+
+// maximumOfflineItems bounds the tools and the transcripts of an offline bundle
+const maximumOfflineItems = 200
+
+// offlineEntry is a resource of an offline bundle for the service worker to cache
+type offlineEntry struct {
+	URL      string `json:"url"`
+	Revision string `json:"revision"`        // Changes whenever the content served at URL does
+	Kind     string `json:"kind"`            // "tool", "tool_html", "transcript", "transcript_html" or "page_image"
+	ID       string `json:"id,omitempty"`    // Tool, lecture or document the resource belongs to
+	Title    string `json:"title,omitempty"` // Title of the tool or lecture
+	Size     int64  `json:"size,omitempty"`  // Bytes of a page image
+}
+
+// offlineRevision returns a short content hash used as the revision of an offline entry
+func offlineRevision(parts ...string) string {
+	hash := sha256.New()
+	for _, part := range parts {
+		hash.Write([]byte(part))
+		hash.Write([]byte{0})
+	}
+	return hex.EncodeToString(hash.Sum(nil))[:16]
+}
+
+// handleCreateOfflineBundle lists what the frontend has to cache to study the selected tools and transcripts of
+// an exam offline: their JSON payloads and the slide images the guides cite, each with a revision, and a
+// version of the whole bundle that changes whenever one of them does
+func (server *Server) handleCreateOfflineBundle(responseWriter http.ResponseWriter, request *http.Request) {
+	var bundleRequest struct {
+		ExamID     string   `json:"exam_id"`
+		ToolIDs    []string `json:"tool_ids"`
+		LectureIDs []string `json:"lecture_ids"` // Lectures whose transcripts are included
+	}
+	if err := json.NewDecoder(request.Body).Decode(&bundleRequest); err != nil {
+		server.writeError(responseWriter, http.StatusBadRequest, "VALIDATION_ERROR", "Invalid body", nil)
+		return
+	}
+	if bundleRequest.ExamID == "" {
+		server.writeError(responseWriter, http.StatusBadRequest, "VALIDATION_ERROR", "exam_id is required", nil)
+		return
+	}
+	if len(bundleRequest.ToolIDs) == 0 && len(bundleRequest.LectureIDs) == 0 {
+		server.writeError(responseWriter, http.StatusBadRequest, "VALIDATION_ERROR", "tool_ids or lecture_ids is required", nil)
+		return
+	}
+	if len(bundleRequest.ToolIDs) > maximumOfflineItems || len(bundleRequest.LectureIDs) > maximumOfflineItems {
+		server.writeError(responseWriter, http.StatusBadRequest, "VALIDATION_ERROR", fmt.Sprintf("An offline bundle holds up to %d tools and %d transcripts", maximumOfflineItems, maximumOfflineItems), nil)
+		return
+	}
+
+	if !server.authorizeExam(responseWriter, request, bundleRequest.ExamID, models.ExamRoleViewer) {
+		return
+	}
+
+	entries := []offlineEntry{}
+	citedPages := make(map[string]map[int]bool) // Cited source, as named by the citations, to its pages
+	for _, toolID := range bundleRequest.ToolIDs {
+		var toolType, title, content string
+		err := server.database.QueryRow("SELECT type, title, content FROM tools WHERE id = ? AND exam_id = ? AND "+ownBookmarkDecks, toolID, bundleRequest.ExamID, server.getUserID(request)).Scan(&toolType, &title, &content)
+		if err == sql.ErrNoRows {
+			server.writeError(responseWriter, http.StatusNotFound, "NOT_FOUND", "Tool not found", map[string]string{"tool_id": toolID})
+			return
+		}
+		if err != nil {
+			server.writeError(responseWriter, http.StatusInternalServerError, "DATABASE_ERROR", "Failed to get tool", nil)
+			return
+		}
+
+		revision := offlineRevision(title, content)
+		query := fmt.Sprintf("?tool_id=%s&exam_id=%s", toolID, bundleRequest.ExamID)
+		entries = append(entries,
+			offlineEntry{URL: "/api/tools/details" + query, Revision: revision, Kind: "tool", ID: toolID, Title: title},
+			offlineEntry{URL: "/api/tools/html" + query, Revision: revision, Kind: "tool_html", ID: toolID, Title: title},
+		)
+		if models.ToolCitesSources(toolType) {
+			if err := server.collectCitedPages(toolID, citedPages); err != nil {
+				server.writeError(responseWriter, http.StatusInternalServerError, "DATABASE_ERROR", "Failed to get tool citations", nil)
+				return
+			}
+		}
+	}
+
+	for _, lectureID := range bundleRequest.LectureIDs {
+		var title, status string
+		err := server.database.QueryRow(`
+			SELECT lectures.title, transcripts.status FROM lectures
+			JOIN transcripts ON transcripts.lecture_id = lectures.id
+			WHERE lectures.id = ? AND lectures.exam_id = ?
+		`, lectureID, bundleRequest.ExamID).Scan(&title, &status)
+		if err == sql.ErrNoRows || (err == nil && status != "completed") {
+			server.writeError(responseWriter, http.StatusNotFound, "NOT_FOUND", "Transcript not found", map[string]string{"lecture_id": lectureID})
+			return
+		}
+		if err != nil {
+			server.writeError(responseWriter, http.StatusInternalServerError, "DATABASE_ERROR", "Failed to get transcript", nil)
+			return
+		}
+
+		revision, err := server.transcriptRevision(lectureID)
+		if err != nil {
+			server.writeError(responseWriter, http.StatusInternalServerError, "DATABASE_ERROR", "Failed to read transcript", nil)
+			return
+		}
+		query := "?lecture_id=" + lectureID
+		entries = append(entries,
+			offlineEntry{URL: "/api/transcripts" + query, Revision: revision, Kind: "transcript", ID: lectureID, Title: title},
+			offlineEntry{URL: "/api/transcripts/html" + query, Revision: revision, Kind: "transcript_html", ID: lectureID, Title: title},
+		)
+	}
+
+	pageEntries, err := server.offlinePageImages(bundleRequest.ExamID, citedPages)
+	if err != nil {
+		server.writeError(responseWriter, http.StatusInternalServerError, "DATABASE_ERROR", "Failed to get cited pages", nil)
+		return
+	}
+	entries = append(entries, pageEntries...)
+
+	var totalSize int64
+	revisions := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		totalSize += entry.Size
+		revisions = append(revisions, entry.URL, entry.Revision)
+	}
+	server.writeJSON(responseWriter, http.StatusOK, map[string]any{
+		"exam_id":      bundleRequest.ExamID,
+		"version":      offlineRevision(revisions...),
+		"generated_at": time.Now(),
+		"entries":      entries,
+		"total_size":   totalSize,
+	})
+}
+
+// collectCitedPages adds the document pages a study guide cites, as its citations name them
+func (server *Server) collectCitedPages(toolID string, citedPages map[string]map[int]bool) error {
+	rows, err := server.database.Query("SELECT source_id, metadata FROM tool_source_references WHERE tool_id = ? AND source_type = 'document'", toolID)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var sourceID string
+		var metadataJSON sql.NullString
+		if err := rows.Scan(&sourceID, &metadataJSON); err != nil {
+			return err
+		}
+		var metadata struct {
+			Pages []int `json:"pages"`
+		}
+		if json.Unmarshal([]byte(metadataJSON.String), &metadata) != nil {
+			continue
+		}
+		if citedPages[sourceID] == nil {
+			citedPages[sourceID] = make(map[int]bool)
+		}
+		for _, pageNumber := range metadata.Pages {
+			citedPages[sourceID][pageNumber] = true
+		}
+	}
+	return rows.Err()
+}
+
+// transcriptRevision hashes the segments of a lecture transcript, which are edited without touching the transcript
+func (server *Server) transcriptRevision(lectureID string) (string, error) {
+	if err := database.RestoreLectureTranscripts(server.database, lectureID); err != nil {
+		return "", err
+	}
+	rows, err := server.database.Query(`
+		SELECT transcript_segments.start_millisecond, transcript_segments.end_millisecond, transcript_segments.text, COALESCE(transcript_segments.polished_text, ''), COALESCE(transcript_segments.speaker, '')
+		FROM transcript_segments
+		JOIN transcripts ON transcript_segments.transcript_id = transcripts.id
+		WHERE transcripts.lecture_id = ?
+		ORDER BY transcript_segments.start_millisecond
+	`, lectureID)
+	if err != nil {
+		return "", err
+	}
+	defer rows.Close()
+
+	var parts []string
+	for rows.Next() {
+		var startMillisecond, endMillisecond int64
+		var text, polishedText, speaker string
+		if err := rows.Scan(&startMillisecond, &endMillisecond, &text, &polishedText, &speaker); err != nil {
+			return "", err
+		}
+		parts = append(parts, fmt.Sprintf("%d-%d", startMillisecond, endMillisecond), speaker, text, polishedText)
+	}
+	return offlineRevision(parts...), rows.Err()
+}
+
+// offlinePageImages lists the page images of the cited pages, resolving the documents the citations name the
+// way guide HTML does, with the same URLs apart from the session token
+func (server *Server) offlinePageImages(examID string, citedPages map[string]map[int]bool) ([]offlineEntry, error) {
+	if len(citedPages) == 0 {
+		return nil, nil
+	}
+
+	documentLectures := make(map[string]string)
+	documentNameToID := make(map[string]string)
+	rows, err := server.database.Query(`
+		SELECT reference_documents.id, reference_documents.lecture_id, reference_documents.title, COALESCE(reference_documents.original_filename, '')
+		FROM reference_documents
+		JOIN lectures ON reference_documents.lecture_id = lectures.id
+		WHERE lectures.exam_id = ?
+	`, examID)
+	if err != nil {
+		return nil, err
+	}
+	for rows.Next() {
+		var documentID, lectureID, title, originalFilename string
+		if err := rows.Scan(&documentID, &lectureID, &title, &originalFilename); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		documentLectures[documentID] = lectureID
+		documentNameToID[title] = documentID
+		if originalFilename != "" {
+			documentNameToID[originalFilename] = documentID
+		}
+	}
+	rows.Close()
+
+	documentNames := make([]string, 0, len(documentNameToID))
+	for name := range documentNameToID {
+		documentNames = append(documentNames, name)
+	}
+	sort.Strings(documentNames)
+
+	pagesByDocument := make(map[string]map[int]bool)
+	for sourceID, pages := range citedPages {
+		documentID := sourceID
+		if _, isDocumentID := documentLectures[sourceID]; !isDocumentID {
+			documentID = documentNameToID[markdown.ResolveCitationFilename(sourceID, documentNames)]
+		}
+		if documentID == "" {
+			continue
+		}
+		if pagesByDocument[documentID] == nil {
+			pagesByDocument[documentID] = make(map[int]bool)
+		}
+		for pageNumber := range pages {
+			pagesByDocument[documentID][pageNumber] = true
+		}
+	}
+
+	entries := []offlineEntry{}
+	for documentID, pages := range pagesByDocument {
+		for pageNumber := range pages {
+			// Page images are served from their BLOB or the object store, never from disk, so that is what
+			// tells whether they exist. Pages are stored anew on re-extraction, which gives them a new ID
+			var pageID, size int64
+			var imagePath, objectKey string
+			var hasImageData bool
+			err := server.database.QueryRow(`
+				SELECT id, image_path, COALESCE(object_key, ''), COALESCE(LENGTH(image_data), 0) > 0, COALESCE(size_bytes, LENGTH(image_data), 0)
+				FROM reference_pages WHERE document_id = ? AND page_number = ?
+			`, documentID, pageNumber).Scan(&pageID, &imagePath, &objectKey, &hasImageData, &size)
+			if err == sql.ErrNoRows {
+				continue
+			}
+			if err != nil {
+				return nil, err
+			}
+			if !hasImageData && objectKey == "" {
+				continue // Pages without a stored image are not served either
+			}
+			entries = append(entries, offlineEntry{
+				URL:      fmt.Sprintf("/api/documents/pages/image?document_id=%s&lecture_id=%s&page_number=%d", documentID, documentLectures[documentID], pageNumber),
+				Revision: offlineRevision(fmt.Sprint(pageID), imagePath, objectKey, fmt.Sprint(size)),
+				Kind:     "page_image",
+				ID:       documentID,
+				Size:     size,
+			})
+		}
+	}
+	sort.Slice(entries, func(first, second int) bool {
+		return entries[first].URL < entries[second].URL
+	})
+	return entries, nil
+}
@@ -0,0 +1,70 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestHandleCreateOfflineBundle(t *testing.T) {
+	server, userID, sessionID, cleanup := setupUniqueExtraTestEnv(t, "offline")
+	defer cleanup()
+
+	server.database.Exec("INSERT INTO exams (id, user_id, title) VALUES ('offline-exam', ?, 'Optics')", userID)
+	server.database.Exec("INSERT INTO lectures (id, exam_id, title, status) VALUES ('offline-lecture', 'offline-exam', 'Lenses', 'ready')")
+	server.database.Exec("INSERT INTO transcripts (id, lecture_id, status) VALUES ('offline-transcript', 'offline-lecture', 'completed')")
+	server.database.Exec("INSERT INTO transcript_segments (transcript_id, start_millisecond, end_millisecond, text) VALUES ('offline-transcript', 0, 1000, 'Lenses bend light.')")
+	server.database.Exec("INSERT INTO reference_documents (id, lecture_id, document_type, title, file_path, page_count) VALUES ('offline-document', 'offline-lecture', 'pdf', 'Lenses.pdf', '/tmp/lenses.pdf', 2)")
+	server.database.Exec("INSERT INTO reference_pages (document_id, page_number, image_path, image_data, size_bytes) VALUES ('offline-document', 1, 'page-1.png', ?, 3), ('offline-document', 2, 'page-2.png', NULL, NULL)", []byte("png"))
+	server.database.Exec("INSERT INTO tools (id, exam_id, lecture_id, type, title, content) VALUES ('offline-guide', 'offline-exam', 'offline-lecture', 'guide', 'Guide', '# Guide')")
+	server.database.Exec(`INSERT INTO tool_source_references (tool_id, source_type, source_id, metadata) VALUES ('offline-guide', 'document', 'lenses.pdf', '{"footnote_number": 1, "pages": [1, 2]}')`)
+
+	createBundle := func(body map[string]any) (int, map[string]any) {
+		encodedBody, _ := json.Marshal(body)
+		req := httptest.NewRequest("POST", "/api/offline/bundle", bytes.NewBuffer(encodedBody))
+		req.Header.Set("Authorization", "Bearer "+sessionID)
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("X-Requested-With", "XMLHttpRequest")
+		rr := httptest.NewRecorder()
+		server.Handler().ServeHTTP(rr, req)
+		var response struct {
+			Data map[string]any `json:"data"`
+		}
+		json.Unmarshal(rr.Body.Bytes(), &response)
+		return rr.Code, response.Data
+	}
+
+	selection := map[string]any{"exam_id": "offline-exam", "tool_ids": []string{"offline-guide"}, "lecture_ids": []string{"offline-lecture"}}
+	code, bundle := createBundle(selection)
+	if code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d", code)
+	}
+	entries := bundle["entries"].([]any)
+	kinds := []string{}
+	for _, entry := range entries {
+		kinds = append(kinds, entry.(map[string]any)["kind"].(string))
+	}
+	if strings.Join(kinds, ",") != "tool,tool_html,transcript,transcript_html,page_image" {
+		t.Fatalf("Expected the tool, transcript and the one existing cited page, got %v", kinds)
+	}
+	pageImage := entries[4].(map[string]any)
+	if pageImage["url"] != "/api/documents/pages/image?document_id=offline-document&lecture_id=offline-lecture&page_number=1" || bundle["total_size"] != 3.0 {
+		t.Errorf("Expected the cited page image of 3 bytes, got %v", pageImage)
+	}
+
+	server.database.Exec("UPDATE transcript_segments SET text = 'Lenses refract light.' WHERE transcript_id = 'offline-transcript'")
+	_, updatedBundle := createBundle(selection)
+	if updatedBundle["version"] == bundle["version"] {
+		t.Errorf("Expected an edited transcript to change the bundle version")
+	}
+
+	if code, _ := createBundle(map[string]any{"exam_id": "offline-exam", "tool_ids": []string{"other-tool"}}); code != http.StatusNotFound {
+		t.Errorf("Expected status 404 for a tool outside the exam, got %d", code)
+	}
+	if code, _ := createBundle(map[string]any{"exam_id": "offline-exam"}); code != http.StatusBadRequest {
+		t.Errorf("Expected status 400 without tools or transcripts, got %d", code)
+	}
+}
@@ -0,0 +1,370 @@
+package api
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"lectures/internal/models"
+
+	gonanoid "github.com/matoous/go-nanoid/v2"
+	"golang.org/x/oauth2"
+)
+
+// oidcLoginLifetime bounds how long the identity provider may take to send a user back to the callback
+const oidcLoginLifetime = 10 * time.Minute
+
+// oidcState holds the discovered identity provider and the logins waiting for its callback
+type oidcState struct {
+	mutex    sync.Mutex
+	provider *oidcProvider
+	logins   map[string]oidcLogin // By the state parameter of the authorization request
+}
+
+// oidcProvider is the part of the discovery document of an OpenID Connect provider the login flow uses
+type oidcProvider struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+}
+
+// oidcLogin is a login sent to the identity provider, with the PKCE verifier and nonce its callback must match
+type oidcLogin struct {
+	verifier  string
+	nonce     string
+	redirect  string
+	expiresAt time.Time
+}
+
+// oidcEnabled reports whether users can sign in through the configured OpenID Connect provider
+func (server *Server) oidcEnabled() bool {
+	return server.configuration.Security.Auth.Type == "oidc"
+}
+
+// discoverOIDCProvider fetches the discovery document of the identity provider once, retrying on later logins
+// when it could not be read
+func (server *Server) discoverOIDCProvider(requestContext context.Context) (*oidcProvider, error) {
+	server.oidc.mutex.Lock()
+	defer server.oidc.mutex.Unlock()
+	if server.oidc.provider != nil {
+		return server.oidc.provider, nil
+	}
+
+	issuer := strings.TrimSuffix(server.configuration.Security.Auth.OIDC.IssuerURL, "/")
+	if issuer == "" {
+		return nil, errors.New("no OIDC issuer is configured")
+	}
+	discoveryRequest, err := http.NewRequestWithContext(requestContext, http.MethodGet, issuer+"/.well-known/openid-configuration", nil)
+	if err != nil {
+		return nil, err
+	}
+	response, err := http.DefaultClient.Do(discoveryRequest)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch OIDC discovery document: %w", err)
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("OIDC discovery document returned status %d", response.StatusCode)
+	}
+
+	var provider oidcProvider
+	if err := json.NewDecoder(response.Body).Decode(&provider); err != nil {
+		return nil, fmt.Errorf("failed to parse OIDC discovery document: %w", err)
+	}
+	if strings.TrimSuffix(provider.Issuer, "/") != issuer {
+		return nil, fmt.Errorf("OIDC discovery document is for issuer %q instead of %q", provider.Issuer, issuer)
+	}
+	if provider.AuthorizationEndpoint == "" || provider.TokenEndpoint == "" {
+		return nil, errors.New("OIDC discovery document has no authorization or token endpoint")
+	}
+	server.oidc.provider = &provider
+	return &provider, nil
+}
+
+// oauthConfiguration returns the OAuth2 client of the authorization code flow with the identity provider
+func (server *Server) oauthConfiguration(provider *oidcProvider) *oauth2.Config {
+	oidcConfiguration := server.configuration.Security.Auth.OIDC
+	scopes := oidcConfiguration.Scopes
+	if len(scopes) == 0 {
+		scopes = []string{"openid", "profile", "email"}
+	}
+	return &oauth2.Config{
+		ClientID:     oidcConfiguration.ClientID,
+		ClientSecret: oidcConfiguration.ClientSecret,
+		Endpoint:     oauth2.Endpoint{AuthURL: provider.AuthorizationEndpoint, TokenURL: provider.TokenEndpoint},
+		RedirectURL:  oidcConfiguration.RedirectURL,
+		Scopes:       scopes,
+	}
+}
+
+// handleOIDCLogin sends the browser to the identity provider, with PKCE, to sign in. The optional "redirect"
+// is the path of this server the user returns to once signed in
+func (server *Server) handleOIDCLogin(responseWriter http.ResponseWriter, request *http.Request) {
+	if !server.oidcEnabled() {
+		server.writeError(responseWriter, http.StatusNotFound, "OIDC_DISABLED", "Single sign-on is not enabled", nil)
+		return
+	}
+	provider, err := server.discoverOIDCProvider(request.Context())
+	if err != nil {
+		slog.Error("Failed to discover OIDC provider", "error", err)
+		server.writeError(responseWriter, http.StatusBadGateway, "OIDC_UNAVAILABLE", "The identity provider cannot be reached", nil)
+		return
+	}
+
+	// Only paths of this server are followed, so the login cannot be used to send users elsewhere
+	redirect := request.URL.Query().Get("redirect")
+	if !strings.HasPrefix(redirect, "/") || strings.HasPrefix(redirect, "//") || strings.HasPrefix(redirect, "/\\") {
+		redirect = "/"
+	}
+
+	state, nonce := randomOIDCValue(), randomOIDCValue()
+	login := oidcLogin{verifier: oauth2.GenerateVerifier(), nonce: nonce, redirect: redirect, expiresAt: time.Now().Add(oidcLoginLifetime)}
+	server.oidc.mutex.Lock()
+	if server.oidc.logins == nil {
+		server.oidc.logins = make(map[string]oidcLogin)
+	}
+	for pendingState, pendingLogin := range server.oidc.logins {
+		if time.Now().After(pendingLogin.expiresAt) {
+			delete(server.oidc.logins, pendingState)
+		}
+	}
+	server.oidc.logins[state] = login
+	server.oidc.mutex.Unlock()
+
+	authorizationURL := server.oauthConfiguration(provider).AuthCodeURL(state, oauth2.S256ChallengeOption(login.verifier), oauth2.SetAuthURLParam("nonce", nonce))
+	http.Redirect(responseWriter, request, authorizationURL, http.StatusFound)
+}
+
+// handleOIDCCallback completes a login at the identity provider: it exchanges the code for an ID token, finds or
+// provisions the account of its subject, maps its role from the claims, and signs the user in before sending
+// them back to the path the login was started from
+func (server *Server) handleOIDCCallback(responseWriter http.ResponseWriter, request *http.Request) {
+	if !server.oidcEnabled() {
+		server.writeError(responseWriter, http.StatusNotFound, "OIDC_DISABLED", "Single sign-on is not enabled", nil)
+		return
+	}
+	query := request.URL.Query()
+
+	server.oidc.mutex.Lock()
+	login, found := server.oidc.logins[query.Get("state")]
+	delete(server.oidc.logins, query.Get("state"))
+	server.oidc.mutex.Unlock()
+	if !found || time.Now().After(login.expiresAt) {
+		server.writeError(responseWriter, http.StatusBadRequest, "VALIDATION_ERROR", "Unknown or expired login, sign in again", nil)
+		return
+	}
+	if providerError := query.Get("error"); providerError != "" {
+		server.writeError(responseWriter, http.StatusUnauthorized, "AUTHENTICATION_ERROR", "The identity provider refused the login", map[string]string{
+			"error":             providerError,
+			"error_description": query.Get("error_description"),
+		})
+		return
+	}
+
+	provider, err := server.discoverOIDCProvider(request.Context())
+	if err != nil {
+		slog.Error("Failed to discover OIDC provider", "error", err)
+		server.writeError(responseWriter, http.StatusBadGateway, "OIDC_UNAVAILABLE", "The identity provider cannot be reached", nil)
+		return
+	}
+	token, err := server.oauthConfiguration(provider).Exchange(request.Context(), query.Get("code"), oauth2.VerifierOption(login.verifier))
+	if err != nil {
+		slog.Warn("Failed to exchange OIDC authorization code", "error", err)
+		server.writeError(responseWriter, http.StatusUnauthorized, "AUTHENTICATION_ERROR", "The identity provider did not confirm the login", nil)
+		return
+	}
+	rawIDToken, _ := token.Extra("id_token").(string)
+	claims, err := parseIDToken(rawIDToken, provider.Issuer, server.configuration.Security.Auth.OIDC.ClientID, login.nonce)
+	if err != nil {
+		slog.Warn("Rejected OIDC ID token", "error", err)
+		server.writeError(responseWriter, http.StatusUnauthorized, "AUTHENTICATION_ERROR", "The identity provider did not confirm the login", nil)
+		return
+	}
+
+	user, err := server.provisionOIDCUser(claims)
+	if errors.Is(err, errOIDCRoleRequired) {
+		server.writeError(responseWriter, http.StatusForbidden, "FORBIDDEN", "Your account is not allowed to use this server", nil)
+		return
+	}
+	if err != nil {
+		slog.Error("Failed to provision OIDC user", "error", err)
+		server.writeError(responseWriter, http.StatusInternalServerError, "DATABASE_ERROR", "Failed to sign in", nil)
+		return
+	}
+	if _, _, err := server.startSession(responseWriter, user.ID); err != nil {
+		server.writeError(responseWriter, http.StatusInternalServerError, "DATABASE_ERROR", "Failed to create session", nil)
+		return
+	}
+	server.recordAuditEvent(request, models.AuditEvent{Action: models.AuditActionLoginSucceeded, ActorID: user.ID, ActorUsername: user.Username, Details: map[string]any{"method": "oidc"}})
+	slog.Info("User signed in with single sign-on", "userID", user.ID, "role", user.Role)
+
+	http.Redirect(responseWriter, request, login.redirect, http.StatusFound)
+}
+
+// errOIDCRoleRequired refuses the users whose claims no role mapping matches, when a mapped role is required
+var errOIDCRoleRequired = errors.New("no role mapping matches the claims of the user")
+
+// provisionOIDCUser returns the account of the subject of an ID token, creating it on their first login. When
+// a role claim is configured the role follows the claims on every login, except that the last administrator
+// keeps the role
+func (server *Server) provisionOIDCUser(claims map[string]any) (models.User, error) {
+	oidcConfiguration := server.configuration.Security.Auth.OIDC
+	subject, _ := claims["sub"].(string)
+	if subject == "" {
+		return models.User{}, errors.New("ID token has no subject")
+	}
+
+	mappedRole, mapped := server.oidcRole(claims)
+	if !mapped && oidcConfiguration.RequireRole {
+		return models.User{}, errOIDCRoleRequired
+	}
+
+	var user models.User
+	err := server.database.QueryRow("SELECT id, username, role FROM users WHERE oidc_subject = ?", subject).Scan(&user.ID, &user.Username, &user.Role)
+	if err == nil {
+		if oidcConfiguration.RoleClaim == "" || mappedRole == user.Role {
+			return user, nil
+		}
+		if user.Role == models.UserRoleAdmin {
+			var administratorCount int
+			server.database.QueryRow("SELECT COUNT(*) FROM users WHERE role = ?", models.UserRoleAdmin).Scan(&administratorCount)
+			if administratorCount <= 1 {
+				return user, nil
+			}
+		}
+		if _, err := server.database.Exec("UPDATE users SET role = ?, updated_at = ? WHERE id = ?", mappedRole, time.Now(), user.ID); err != nil {
+			return user, err
+		}
+		slog.Info("Role of a single sign-on user changed by their claims", "userID", user.ID, "from", user.Role, "to", mappedRole)
+		user.Role = mappedRole
+		return user, nil
+	}
+	if err != sql.ErrNoRows {
+		return user, err
+	}
+
+	// Local accounts are never taken over by a provider username, which gets a number instead
+	baseUsername := ""
+	for _, claim := range []string{oidcConfiguration.UsernameClaim, "preferred_username", "email", "sub"} {
+		if value, _ := claims[claim].(string); claim != "" && value != "" {
+			baseUsername = value
+			break
+		}
+	}
+	user = models.User{Username: baseUsername, Role: mappedRole}
+	for suffix := 2; ; suffix++ {
+		var taken bool
+		server.database.QueryRow("SELECT EXISTS(SELECT 1 FROM users WHERE username = ?)", user.Username).Scan(&taken)
+		if !taken {
+			break
+		}
+		user.Username = fmt.Sprintf("%s-%d", baseUsername, suffix)
+	}
+
+	user.ID, _ = gonanoid.New()
+	_, err = server.database.Exec(`
+		INSERT INTO users (id, username, password_hash, role, oidc_subject, created_at, updated_at)
+		VALUES (?, ?, '', ?, ?, ?, ?)
+	`, user.ID, user.Username, user.Role, subject, time.Now(), time.Now())
+	if err != nil {
+		return user, err
+	}
+	slog.Info("Provisioned single sign-on user", "userID", user.ID, "username", user.Username, "role", user.Role)
+	return user, nil
+}
+
+// oidcRole returns the most privileged role the values of the role claim map to, or the default role and false
+// when none does. Single sign-on never makes administrators without a mapping
+func (server *Server) oidcRole(claims map[string]any) (string, bool) {
+	oidcConfiguration := server.configuration.Security.Auth.OIDC
+	role := ""
+	var values []string
+	switch claimValue := claims[oidcConfiguration.RoleClaim].(type) {
+	case string:
+		values = strings.Fields(claimValue)
+	case []any:
+		for _, value := range claimValue {
+			if text, ok := value.(string); ok {
+				values = append(values, text)
+			}
+		}
+	}
+	for _, value := range values {
+		mappedRole := oidcConfiguration.RoleMapping[value]
+		if isUserRole(mappedRole) && (role == "" || len(rolePermissions[mappedRole]) > len(rolePermissions[role])) {
+			role = mappedRole
+		}
+	}
+	if role != "" {
+		return role, true
+	}
+
+	role = oidcConfiguration.DefaultRole
+	if role == "" {
+		role = server.configuration.Security.Auth.RegistrationRole
+	}
+	if role != models.UserRoleStudent {
+		role = models.UserRoleTeacher
+	}
+	return role, false
+}
+
+// parseIDToken returns the claims of an ID token after checking its issuer, audience, expiry and nonce. Its
+// signature is not checked: the token comes straight from the token endpoint of the provider, whose TLS
+// connection authenticates it (OpenID Connect Core 1.0, section 3.1.3.7)
+func parseIDToken(rawIDToken string, issuer string, clientID string, nonce string) (map[string]any, error) {
+	parts := strings.Split(rawIDToken, ".")
+	if len(parts) != 3 {
+		return nil, errors.New("missing or malformed ID token")
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return nil, fmt.Errorf("malformed ID token payload: %w", err)
+	}
+	var claims map[string]any
+	if err := json.Unmarshal(payload, &claims); err != nil {
+		return nil, fmt.Errorf("malformed ID token claims: %w", err)
+	}
+
+	if tokenIssuer, _ := claims["iss"].(string); strings.TrimSuffix(tokenIssuer, "/") != strings.TrimSuffix(issuer, "/") {
+		return nil, fmt.Errorf("ID token issued by %q", tokenIssuer)
+	}
+	var audiences []string
+	switch audience := claims["aud"].(type) {
+	case string:
+		audiences = []string{audience}
+	case []any:
+		for _, value := range audience {
+			if text, ok := value.(string); ok {
+				audiences = append(audiences, text)
+			}
+		}
+	}
+	if !slices.Contains(audiences, clientID) {
+		return nil, errors.New("ID token is not for this client")
+	}
+	if expiry, _ := claims["exp"].(float64); time.Now().After(time.Unix(int64(expiry), 0)) {
+		return nil, errors.New("ID token expired")
+	}
+	if tokenNonce, _ := claims["nonce"].(string); tokenNonce != nonce {
+		return nil, errors.New("ID token nonce does not match the login")
+	}
+	return claims, nil
+}
+
+// randomOIDCValue returns an unguessable state or nonce
+func randomOIDCValue() string {
+	value := make([]byte, 32)
+	rand.Read(value)
+	return base64.RawURLEncoding.EncodeToString(value)
+}
@@ -0,0 +1,126 @@
+package api
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"lectures/internal/configuration"
+	"lectures/internal/models"
+)
+
+func TestHandleOIDCLogin(t *testing.T) {
+	server, _, _, cleanup := setupUniqueExtraTestEnv(t, "oidc_login")
+	defer cleanup()
+
+	var nonce, verifier string
+	groups := []string{"staff", "lecturers"}
+	var identityProvider *httptest.Server
+	identityProvider = httptest.NewServer(http.HandlerFunc(func(responseWriter http.ResponseWriter, request *http.Request) {
+		switch request.URL.Path {
+		case "/.well-known/openid-configuration":
+			json.NewEncoder(responseWriter).Encode(map[string]string{
+				"issuer":                 identityProvider.URL,
+				"authorization_endpoint": identityProvider.URL + "/authorize",
+				"token_endpoint":         identityProvider.URL + "/token",
+			})
+		case "/token":
+			request.ParseForm()
+			verifier = request.PostForm.Get("code_verifier")
+			claims, _ := json.Marshal(map[string]any{
+				"iss": identityProvider.URL, "aud": "lectures", "sub": "subject-1", "exp": time.Now().Add(time.Hour).Unix(),
+				"nonce": nonce, "preferred_username": "useroidc_login", "groups": groups,
+			})
+			responseWriter.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(responseWriter).Encode(map[string]any{
+				"access_token": "access", "token_type": "Bearer",
+				"id_token": "eyJhbGciOiJSUzI1NiJ9." + base64.RawURLEncoding.EncodeToString(claims) + ".signature",
+			})
+		}
+	}))
+	defer identityProvider.Close()
+
+	server.configuration.Security.Auth.Type = "oidc"
+	server.configuration.Security.Auth.SessionTimeoutHours = 1
+	server.configuration.Security.Auth.OIDC = configuration.OIDCConfiguration{
+		IssuerURL:   identityProvider.URL,
+		ClientID:    "lectures",
+		RedirectURL: "http://lectures.example/api/auth/oidc/callback",
+		RoleClaim:   "groups",
+		RoleMapping: map[string]string{"staff": "teacher", "students": "student"},
+		DefaultRole: "student",
+	}
+
+	signIn := func(redirect string) *httptest.ResponseRecorder {
+		rr := httptest.NewRecorder()
+		server.Handler().ServeHTTP(rr, httptest.NewRequest("GET", "/api/auth/oidc/login?redirect="+url.QueryEscape(redirect), nil))
+		if rr.Code != http.StatusFound {
+			t.Fatalf("Expected status 302 to the identity provider, got %d: %s", rr.Code, rr.Body.String())
+		}
+		authorizationURL, _ := url.Parse(rr.Header().Get("Location"))
+		if authorizationURL.Query().Get("code_challenge_method") != "S256" || authorizationURL.Query().Get("client_id") != "lectures" {
+			t.Errorf("Expected a PKCE authorization request, got %s", authorizationURL)
+		}
+		nonce = authorizationURL.Query().Get("nonce")
+
+		rr = httptest.NewRecorder()
+		server.Handler().ServeHTTP(rr, httptest.NewRequest("GET", "/api/auth/oidc/callback?code=code&state="+url.QueryEscape(authorizationURL.Query().Get("state")), nil))
+		return rr
+	}
+
+	rr := signIn("/exams/1")
+	if rr.Code != http.StatusFound || rr.Header().Get("Location") != "/exams/1" {
+		t.Fatalf("Expected status 302 back to the exam, got %d: %s", rr.Code, rr.Body.String())
+	}
+	if verifier == "" {
+		t.Errorf("Expected the PKCE verifier sent to the token endpoint")
+	}
+	var username, role string
+	server.database.QueryRow("SELECT username, role FROM users WHERE oidc_subject = 'subject-1'").Scan(&username, &role)
+	if username != "useroidc_login-2" || role != models.UserRoleTeacher {
+		t.Errorf("Expected a teacher provisioned without taking over the local account, got %q with role %q", username, role)
+	}
+	sessionCookie := rr.Result().Cookies()[0]
+	statusRequest := httptest.NewRequest("GET", "/api/auth/status", nil)
+	statusRequest.AddCookie(sessionCookie)
+	statusRecorder := httptest.NewRecorder()
+	server.Handler().ServeHTTP(statusRecorder, statusRequest)
+	if !strings.Contains(statusRecorder.Body.String(), `"authenticated": true`) || !strings.Contains(statusRecorder.Body.String(), `"oidc": true`) {
+		t.Errorf("Expected the user signed in, got %s", statusRecorder.Body.String())
+	}
+
+	// The role follows the claims, and redirects leave this server for its root
+	groups = []string{"students"}
+	if rr := signIn("https://elsewhere.example"); rr.Header().Get("Location") != "/" {
+		t.Errorf("Expected a redirect to the root, got %q", rr.Header().Get("Location"))
+	}
+	server.database.QueryRow("SELECT role FROM users WHERE oidc_subject = 'subject-1'").Scan(&role)
+	if role != models.UserRoleStudent {
+		t.Errorf("Expected the role mapped from the new claims, got %q", role)
+	}
+
+	server.configuration.Security.Auth.OIDC.RequireRole = true
+	groups = []string{"guests"}
+	if rr := signIn("/"); rr.Code != http.StatusForbidden {
+		t.Errorf("Expected status 403 without a mapped role, got %d", rr.Code)
+	}
+
+	rr = httptest.NewRecorder()
+	server.Handler().ServeHTTP(rr, httptest.NewRequest("GET", "/api/auth/oidc/callback?code=code&state=forged", nil))
+	if rr.Code != http.StatusBadRequest {
+		t.Errorf("Expected status 400 for an unknown state, got %d", rr.Code)
+	}
+
+	body, _ := json.Marshal(map[string]string{"username": "local", "password": "password123"})
+	rr = httptest.NewRecorder()
+	server.Handler().ServeHTTP(rr, httptest.NewRequest("POST", "/api/auth/register", bytes.NewBuffer(body)))
+	if rr.Code != http.StatusForbidden {
+		t.Errorf("Expected status 403 registering behind single sign-on, got %d", rr.Code)
+	}
+}
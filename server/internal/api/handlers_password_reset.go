@@ -0,0 +1,241 @@
+package api
+
+import (
+	"crypto/rand"
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/mail"
+	"net/url"
+	"strings"
+	"time"
+
+	internalmail "lectures/internal/mail"
+	"lectures/internal/models"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// SetMailSender lets users reset their password through links sent by email
+func (server *Server) SetMailSender(sender internalmail.Sender) {
+	server.mailSender = sender
+}
+
+// normalizeEmail returns the bare address of an email, or an error when it is not one
+func normalizeEmail(email string) (string, error) {
+	address, err := mail.ParseAddress(strings.TrimSpace(email))
+	if err != nil {
+		return "", fmt.Errorf("invalid email address")
+	}
+	return address.Address, nil
+}
+
+// handlePasswordResetRequest emails a single-use reset link to the account with the given "username" or
+// "email". It answers the same whether or not such an account exists, so accounts cannot be discovered with it.
+// Accounts without an email address, and those of single sign-on, cannot be reset
+func (server *Server) handlePasswordResetRequest(responseWriter http.ResponseWriter, request *http.Request) {
+	if server.mailSender == nil || server.configuration.Security.Auth.PasswordResetURL == "" {
+		server.writeError(responseWriter, http.StatusServiceUnavailable, "PASSWORD_RESET_UNAVAILABLE", "Password resets are not available on this server", nil)
+		return
+	}
+	if !server.allowLoginAttempt("password-reset:" + clientAddress(request)) {
+		server.writeError(responseWriter, http.StatusTooManyRequests, "RATE_LIMIT", "Too many reset requests. Please try again later.", nil)
+		return
+	}
+
+	var resetRequest struct {
+		Username string `json:"username"`
+		Email    string `json:"email"`
+	}
+	if err := json.NewDecoder(request.Body).Decode(&resetRequest); err != nil || (resetRequest.Username == "" && resetRequest.Email == "") {
+		server.writeError(responseWriter, http.StatusBadRequest, "VALIDATION_ERROR", "username or email is required", nil)
+		return
+	}
+
+	var userID, email string
+	err := server.database.QueryRow(`
+		SELECT id, email FROM users
+		WHERE (username = ? OR email = ? COLLATE NOCASE) AND email IS NOT NULL AND oidc_subject IS NULL
+	`, resetRequest.Username, strings.TrimSpace(resetRequest.Email)).Scan(&userID, &email)
+	if err != nil && err != sql.ErrNoRows {
+		server.writeError(responseWriter, http.StatusInternalServerError, "DATABASE_ERROR", "Failed to look up account", nil)
+		return
+	}
+
+	if err == nil {
+		secret := make([]byte, 32)
+		rand.Read(secret)
+		token := base64.RawURLEncoding.EncodeToString(secret)
+		lifetime := time.Duration(server.configuration.Security.Auth.PasswordResetMinutes) * time.Minute
+		if lifetime <= 0 {
+			lifetime = time.Hour
+		}
+
+		// Only the latest link of an account works
+		server.database.Exec("DELETE FROM password_reset_tokens WHERE user_id = ?", userID)
+		_, err = server.database.Exec(`
+			INSERT INTO password_reset_tokens (token_hash, user_id, created_at, expires_at)
+			VALUES (?, ?, ?, ?)
+		`, hashToken(token), userID, time.Now(), time.Now().Add(lifetime))
+		if err != nil {
+			server.writeError(responseWriter, http.StatusInternalServerError, "DATABASE_ERROR", "Failed to create reset link", nil)
+			return
+		}
+
+		resetURL := server.configuration.Security.Auth.PasswordResetURL
+		separator := "?"
+		if strings.Contains(resetURL, "?") {
+			separator = "&"
+		}
+		resetURL += separator + "token=" + url.QueryEscape(token)
+		body := fmt.Sprintf("A password reset was requested for your account.\n\nOpen this link within %d minutes to choose a new password:\n%s\n\nIf you did not request it, ignore this email; your password stays the same.\n", int(lifetime.Minutes()), resetURL)
+
+		// Sending in the background keeps the response time the same for unknown accounts
+		go func() {
+			if err := server.mailSender.Send(email, "Reset your password", body); err != nil {
+				slog.Error("Failed to send password reset email", "userID", userID, "error", err)
+			}
+		}()
+		server.recordAuditEvent(request, models.AuditEvent{Action: models.AuditActionPasswordResetRequested, ResourceType: "user", ResourceID: userID})
+		slog.Info("Password reset requested", "userID", userID)
+	}
+
+	server.writeJSON(responseWriter, http.StatusAccepted, map[string]string{"message": "If the account exists and has an email address, a reset link was sent to it"})
+}
+
+// handlePasswordResetConfirm sets a new password with the token of a reset link, which then stops working. It
+// signs the account out everywhere and clears a required password change
+func (server *Server) handlePasswordResetConfirm(responseWriter http.ResponseWriter, request *http.Request) {
+	var confirmRequest struct {
+		Token       string `json:"token"`
+		NewPassword string `json:"new_password"`
+	}
+	if err := json.NewDecoder(request.Body).Decode(&confirmRequest); err != nil || confirmRequest.Token == "" {
+		server.writeError(responseWriter, http.StatusBadRequest, "VALIDATION_ERROR", "token is required", nil)
+		return
+	}
+	if len(confirmRequest.NewPassword) < 8 {
+		server.writeError(responseWriter, http.StatusBadRequest, "VALIDATION_ERROR", "New password must be at least 8 characters", nil)
+		return
+	}
+
+	var userID string
+	err := server.database.QueryRow(`
+		SELECT user_id FROM password_reset_tokens
+		WHERE token_hash = ? AND used_at IS NULL AND expires_at > ?
+	`, hashToken(confirmRequest.Token), time.Now()).Scan(&userID)
+	if err == sql.ErrNoRows {
+		server.writeError(responseWriter, http.StatusBadRequest, "INVALID_TOKEN", "The reset link is invalid, used or expired", nil)
+		return
+	}
+	if err != nil {
+		server.writeError(responseWriter, http.StatusInternalServerError, "DATABASE_ERROR", "Failed to verify reset link", nil)
+		return
+	}
+
+	passwordHash, err := bcrypt.GenerateFromPassword([]byte(confirmRequest.NewPassword), bcrypt.DefaultCost)
+	if err != nil {
+		server.writeError(responseWriter, http.StatusInternalServerError, "AUTHENTICATION_ERROR", "Failed to hash password", nil)
+		return
+	}
+
+	transaction, err := server.database.Begin()
+	if err != nil {
+		server.writeError(responseWriter, http.StatusInternalServerError, "DATABASE_ERROR", "Failed to start transaction", nil)
+		return
+	}
+	defer transaction.Rollback()
+
+	// Marking the token used first makes concurrent confirmations of the same link fail
+	result, err := transaction.Exec("UPDATE password_reset_tokens SET used_at = ? WHERE token_hash = ? AND used_at IS NULL", time.Now(), hashToken(confirmRequest.Token))
+	if err != nil {
+		server.writeError(responseWriter, http.StatusInternalServerError, "DATABASE_ERROR", "Failed to use reset link", nil)
+		return
+	}
+	if rowsAffected, _ := result.RowsAffected(); rowsAffected == 0 {
+		server.writeError(responseWriter, http.StatusBadRequest, "INVALID_TOKEN", "The reset link is invalid, used or expired", nil)
+		return
+	}
+	if _, err := transaction.Exec("UPDATE users SET password_hash = ?, password_change_required = 0, updated_at = ? WHERE id = ?", string(passwordHash), time.Now(), userID); err != nil {
+		server.writeError(responseWriter, http.StatusInternalServerError, "DATABASE_ERROR", "Failed to update password", nil)
+		return
+	}
+	if _, err := transaction.Exec("DELETE FROM auth_sessions WHERE user_id = ?", userID); err != nil {
+		server.writeError(responseWriter, http.StatusInternalServerError, "DATABASE_ERROR", "Failed to sign out sessions", nil)
+		return
+	}
+	// API tokens are credentials too: whoever may have used the old password could have created some
+	if _, err := transaction.Exec("DELETE FROM api_tokens WHERE user_id = ?", userID); err != nil {
+		server.writeError(responseWriter, http.StatusInternalServerError, "DATABASE_ERROR", "Failed to revoke API tokens", nil)
+		return
+	}
+	if err := transaction.Commit(); err != nil {
+		server.writeError(responseWriter, http.StatusInternalServerError, "DATABASE_ERROR", "Failed to commit transaction", nil)
+		return
+	}
+	server.recordAuditEvent(request, models.AuditEvent{Action: models.AuditActionPasswordReset, ActorID: userID, ResourceType: "user", ResourceID: userID})
+	slog.Info("Password reset", "userID", userID)
+
+	server.writeJSON(responseWriter, http.StatusOK, map[string]string{"message": "Password updated successfully. You can now log in."})
+}
+
+// handleAuthUpdateEmail sets or clears the email address reset links of the current user are sent to. The
+// current password is required, so a stolen session cannot redirect the resets of the account
+func (server *Server) handleAuthUpdateEmail(responseWriter http.ResponseWriter, request *http.Request) {
+	var emailRequest struct {
+		Email           string `json:"email"` // Empty clears it
+		CurrentPassword string `json:"current_password"`
+	}
+	if err := json.NewDecoder(request.Body).Decode(&emailRequest); err != nil {
+		server.writeError(responseWriter, http.StatusBadRequest, "VALIDATION_ERROR", "Invalid request body", nil)
+		return
+	}
+
+	var email any
+	if strings.TrimSpace(emailRequest.Email) != "" {
+		address, err := normalizeEmail(emailRequest.Email)
+		if err != nil {
+			server.writeError(responseWriter, http.StatusBadRequest, "VALIDATION_ERROR", err.Error(), nil)
+			return
+		}
+		email = address
+	}
+
+	userID := server.getUserID(request)
+	var passwordHash string
+	if err := server.database.QueryRow("SELECT password_hash FROM users WHERE id = ?", userID).Scan(&passwordHash); err != nil {
+		server.writeError(responseWriter, http.StatusInternalServerError, "DATABASE_ERROR", "Failed to get user details", nil)
+		return
+	}
+	if err := bcrypt.CompareHashAndPassword([]byte(passwordHash), []byte(emailRequest.CurrentPassword)); err != nil {
+		server.writeError(responseWriter, http.StatusUnauthorized, "AUTHENTICATION_ERROR", "Invalid current password", nil)
+		return
+	}
+
+	if !server.setUserEmail(responseWriter, userID, email) {
+		return
+	}
+	server.recordAuditEvent(request, models.AuditEvent{Action: models.AuditActionEmailChanged, ResourceType: "user", ResourceID: userID})
+	server.writeJSON(responseWriter, http.StatusOK, map[string]any{"email": email})
+}
+
+// setUserEmail stores the email address of an account, nil clearing it. It writes the error and returns false
+// when another account has the address
+func (server *Server) setUserEmail(responseWriter http.ResponseWriter, userID string, email any) bool {
+	if email != nil {
+		var taken bool
+		server.database.QueryRow("SELECT EXISTS(SELECT 1 FROM users WHERE email = ? COLLATE NOCASE AND id != ?)", email, userID).Scan(&taken)
+		if taken {
+			server.writeError(responseWriter, http.StatusConflict, "EMAIL_TAKEN", "Email address is already used by another account", nil)
+			return false
+		}
+	}
+	if _, err := server.database.Exec("UPDATE users SET email = ?, updated_at = ? WHERE id = ?", email, time.Now(), userID); err != nil {
+		server.writeError(responseWriter, http.StatusInternalServerError, "DATABASE_ERROR", "Failed to update email", nil)
+		return false
+	}
+	return true
+}
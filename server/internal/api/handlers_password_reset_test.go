@@ -0,0 +1,125 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+// capturingMailSender records the emails sent through it instead of delivering them
+type capturingMailSender struct {
+	sent chan string
+}
+
+func (sender *capturingMailSender) Send(to string, subject string, body string) error {
+	sender.sent <- to + "\n" + body
+	return nil
+}
+
+func TestHandlePasswordReset(t *testing.T) {
+	server, userID, sessionID, cleanup := setupUniqueExtraTestEnv(t, "password_reset")
+	defer cleanup()
+
+	sendRequest := func(method string, target string, body map[string]any) *httptest.ResponseRecorder {
+		encodedBody, _ := json.Marshal(body)
+		req := httptest.NewRequest(method, target, bytes.NewBuffer(encodedBody))
+		req.Header.Set("Authorization", "Bearer "+sessionID)
+		req.Header.Set("X-Requested-With", "XMLHttpRequest")
+		req.Header.Set("Content-Type", "application/json")
+		rr := httptest.NewRecorder()
+		server.Handler().ServeHTTP(rr, req)
+		return rr
+	}
+
+	if rr := sendRequest("POST", "/api/auth/password-reset/request", map[string]any{"username": "userpassword_reset"}); rr.Code != http.StatusServiceUnavailable {
+		t.Errorf("Expected status 503 without a mail server, got %d", rr.Code)
+	}
+
+	mailSender := &capturingMailSender{sent: make(chan string, 1)}
+	server.SetMailSender(mailSender)
+	server.configuration.Security.Auth.PasswordResetURL = "https://lectures.example.com/reset"
+
+	if rr := sendRequest("PATCH", "/api/auth/email", map[string]any{"email": "Reset <reset@example.com>", "current_password": "wrong-password"}); rr.Code != http.StatusUnauthorized {
+		t.Errorf("Expected status 401 setting the email with a wrong password, got %d", rr.Code)
+	}
+	if rr := sendRequest("PATCH", "/api/auth/email", map[string]any{"email": "Reset <reset@example.com>", "current_password": "password123"}); rr.Code != http.StatusOK {
+		t.Fatalf("Expected status 200 setting the email, got %d: %s", rr.Code, rr.Body.String())
+	}
+
+	// Unknown accounts get the same answer and no email
+	if rr := sendRequest("POST", "/api/auth/password-reset/request", map[string]any{"email": "nobody@example.com"}); rr.Code != http.StatusAccepted {
+		t.Errorf("Expected status 202 for an unknown account, got %d", rr.Code)
+	}
+	if rr := sendRequest("POST", "/api/auth/password-reset/request", map[string]any{"email": "RESET@example.com"}); rr.Code != http.StatusAccepted {
+		t.Fatalf("Expected status 202 requesting a reset, got %d: %s", rr.Code, rr.Body.String())
+	}
+
+	var sent string
+	select {
+	case sent = <-mailSender.sent:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Expected a reset email to be sent")
+	}
+	if !strings.HasPrefix(sent, "reset@example.com\n") {
+		t.Errorf("Expected the email sent to the account address, got %q", sent)
+	}
+	_, link, found := strings.Cut(sent, "https://lectures.example.com/reset?token=")
+	if !found {
+		t.Fatalf("Expected a reset link in the email, got %q", sent)
+	}
+	token, _, _ := strings.Cut(link, "\n")
+	token, _ = url.QueryUnescape(token)
+
+	server.database.Exec("INSERT INTO api_tokens (id, user_id, name, token_hash, prefix, scopes, created_at) VALUES ('reset-token', ?, 'CI', ?, 'lat_old', '[\"exams:read\"]', ?)", userID, hashToken("lat_old-token"), time.Now())
+
+	if rr := sendRequest("POST", "/api/auth/password-reset/confirm", map[string]any{"token": "not-a-token", "new_password": "new-password-1"}); rr.Code != http.StatusBadRequest {
+		t.Errorf("Expected status 400 for an unknown token, got %d", rr.Code)
+	}
+	if rr := sendRequest("POST", "/api/auth/password-reset/confirm", map[string]any{"token": token, "new_password": "new-password-1"}); rr.Code != http.StatusOK {
+		t.Fatalf("Expected status 200 resetting the password, got %d: %s", rr.Code, rr.Body.String())
+	}
+	if rr := sendRequest("POST", "/api/auth/password-reset/confirm", map[string]any{"token": token, "new_password": "new-password-2"}); rr.Code != http.StatusBadRequest {
+		t.Errorf("Expected status 400 reusing the token, got %d", rr.Code)
+	}
+	var sessions int
+	server.database.QueryRow("SELECT COUNT(*) FROM auth_sessions WHERE user_id = ?", userID).Scan(&sessions)
+	var apiTokens int
+	server.database.QueryRow("SELECT COUNT(*) FROM api_tokens WHERE user_id = ?", userID).Scan(&apiTokens)
+	if sessions != 0 || apiTokens != 0 {
+		t.Errorf("Expected the account signed out everywhere, got %d sessions and %d API tokens", sessions, apiTokens)
+	}
+
+	// A required password change blocks everything but changing it
+	server.database.Exec("INSERT INTO auth_sessions (id, user_id, created_at, last_activity, expires_at) VALUES (?, ?, ?, ?, ?)", sessionID, userID, time.Now(), time.Now(), time.Now().Add(time.Hour))
+	server.database.Exec("UPDATE users SET password_change_required = 1 WHERE id = ?", userID)
+	rr := sendRequest("GET", "/api/exams", nil)
+	if rr.Code != http.StatusForbidden || !strings.Contains(rr.Body.String(), "PASSWORD_CHANGE_REQUIRED") {
+		t.Errorf("Expected status 403 PASSWORD_CHANGE_REQUIRED, got %d: %s", rr.Code, rr.Body.String())
+	}
+	// Downloads, which authenticate outside the middleware so links work from the browser, are blocked too
+	downloadRequest := httptest.NewRequest("GET", "/api/media/content?media_id=any&session_token="+sessionID, nil)
+	downloadRecorder := httptest.NewRecorder()
+	server.Handler().ServeHTTP(downloadRecorder, downloadRequest)
+	if downloadRecorder.Code != http.StatusForbidden || !strings.Contains(downloadRecorder.Body.String(), "PASSWORD_CHANGE_REQUIRED") {
+		t.Errorf("Expected media downloads blocked until the password is changed, got %d: %s", downloadRecorder.Code, downloadRecorder.Body.String())
+	}
+	server.database.Exec("INSERT INTO api_tokens (id, user_id, name, token_hash, prefix, scopes, created_at) VALUES ('blocked-token', ?, 'CI', ?, 'lat_new', '[\"exams:read\"]', ?)", userID, hashToken("lat_new-token"), time.Now())
+	tokenRequest := httptest.NewRequest("GET", "/api/exams", nil)
+	tokenRequest.Header.Set("Authorization", "Bearer lat_new-token")
+	tokenRecorder := httptest.NewRecorder()
+	server.Handler().ServeHTTP(tokenRecorder, tokenRequest)
+	if tokenRecorder.Code != http.StatusForbidden || !strings.Contains(tokenRecorder.Body.String(), "PASSWORD_CHANGE_REQUIRED") {
+		t.Errorf("Expected API tokens blocked until the password is changed, got %d: %s", tokenRecorder.Code, tokenRecorder.Body.String())
+	}
+	if rr := sendRequest("PATCH", "/api/auth/password", map[string]any{"current_password": "new-password-1", "new_password": "new-password-3"}); rr.Code != http.StatusOK {
+		t.Fatalf("Expected status 200 changing the password, got %d: %s", rr.Code, rr.Body.String())
+	}
+	if rr := sendRequest("GET", "/api/exams", nil); rr.Code != http.StatusOK {
+		t.Errorf("Expected status 200 after changing the password, got %d: %s", rr.Code, rr.Body.String())
+	}
+}
@@ -0,0 +1,123 @@
+package api
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"strings"
+
+	"lectures/internal/database"
+	"lectures/internal/llm"
+	"lectures/internal/secrets"
+)
+
+// userKeyProviders lists the providers users can bring their own API key for
+var userKeyProviders = map[string]bool{
+	"openrouter": true,
+}
+
+// maximumProviderKeyLength bounds the API keys users store
+const maximumProviderKeyLength = 512
+
+// SetSecretCipher enables users to store their own provider API keys, sealed with the cipher, and makes the LLM
+// calls of their jobs and chats use them
+func (server *Server) SetSecretCipher(secretCipher *secrets.Cipher) {
+	server.secretCipher = secretCipher
+	if routingProvider, ok := server.llmProvider.(*llm.RoutingProvider); ok {
+		routingProvider.SetAPIKeyResolver(server.resolveUserAPIKey)
+	}
+}
+
+// resolveUserAPIKey returns the API key a user stored for a provider, or an empty string to use the operator's
+func (server *Server) resolveUserAPIKey(userID string, provider string) (string, error) {
+	if server.secretCipher == nil || !userKeyProviders[provider] {
+		return "", nil
+	}
+	sealedKey, err := database.GetUserProviderKey(server.database, userID, provider)
+	if err != nil || sealedKey == "" {
+		return "", err
+	}
+	return server.secretCipher.Open(sealedKey)
+}
+
+// handleListProviderKeys lists the providers the authenticated user stored an API key for, never the keys
+func (server *Server) handleListProviderKeys(responseWriter http.ResponseWriter, request *http.Request) {
+	keys, err := database.ListUserProviderKeys(server.database, server.getUserID(request))
+	if err != nil {
+		server.writeError(responseWriter, http.StatusInternalServerError, "DATABASE_ERROR", "Failed to list API keys", nil)
+		return
+	}
+	server.writeJSON(responseWriter, http.StatusOK, keys)
+}
+
+// handleSetProviderKey stores an API key of the authenticated user for a provider, encrypted, replacing the
+// previous one
+func (server *Server) handleSetProviderKey(responseWriter http.ResponseWriter, request *http.Request) {
+	if server.secretCipher == nil {
+		server.writeError(responseWriter, http.StatusServiceUnavailable, "ENCRYPTION_UNAVAILABLE", "API keys cannot be stored because no encryption key is available", nil)
+		return
+	}
+
+	var keyRequest struct {
+		Provider string `json:"provider"`
+		APIKey   string `json:"api_key"`
+	}
+	if err := json.NewDecoder(request.Body).Decode(&keyRequest); err != nil {
+		server.writeError(responseWriter, http.StatusBadRequest, "VALIDATION_ERROR", "Invalid body", nil)
+		return
+	}
+	if !userKeyProviders[keyRequest.Provider] {
+		server.writeError(responseWriter, http.StatusBadRequest, "VALIDATION_ERROR", "provider must be one of: openrouter", nil)
+		return
+	}
+	apiKey := strings.TrimSpace(keyRequest.APIKey)
+	if apiKey == "" || len(apiKey) > maximumProviderKeyLength {
+		server.writeError(responseWriter, http.StatusBadRequest, "VALIDATION_ERROR", "api_key is required and cannot be longer than 512 characters", nil)
+		return
+	}
+
+	sealedKey, err := server.secretCipher.Seal(apiKey)
+	if err != nil {
+		server.writeError(responseWriter, http.StatusInternalServerError, "ENCRYPTION_ERROR", "Failed to encrypt API key", nil)
+		return
+	}
+	keyHint := apiKey
+	if len(keyHint) > 4 {
+		keyHint = keyHint[len(keyHint)-4:]
+	}
+	userID := server.getUserID(request)
+	if err := database.SetUserProviderKey(server.database, userID, keyRequest.Provider, sealedKey, keyHint); err != nil {
+		server.writeError(responseWriter, http.StatusInternalServerError, "DATABASE_ERROR", "Failed to store API key", nil)
+		return
+	}
+	slog.Info("User stored a provider API key", "userID", userID, "provider", keyRequest.Provider)
+
+	keys, err := database.ListUserProviderKeys(server.database, userID)
+	if err != nil {
+		server.writeError(responseWriter, http.StatusInternalServerError, "DATABASE_ERROR", "Failed to list API keys", nil)
+		return
+	}
+	server.writeJSON(responseWriter, http.StatusOK, keys)
+}
+
+// handleDeleteProviderKey removes an API key of the authenticated user, so the operator's key is used again
+func (server *Server) handleDeleteProviderKey(responseWriter http.ResponseWriter, request *http.Request) {
+	var deleteRequest struct {
+		Provider string `json:"provider"`
+	}
+	if err := json.NewDecoder(request.Body).Decode(&deleteRequest); err != nil {
+		server.writeError(responseWriter, http.StatusBadRequest, "VALIDATION_ERROR", "Invalid body", nil)
+		return
+	}
+
+	deleted, err := database.DeleteUserProviderKey(server.database, server.getUserID(request), deleteRequest.Provider)
+	if err != nil {
+		server.writeError(responseWriter, http.StatusInternalServerError, "DATABASE_ERROR", "Failed to delete API key", nil)
+		return
+	}
+	if !deleted {
+		server.writeError(responseWriter, http.StatusNotFound, "NOT_FOUND", "No API key stored for this provider", nil)
+		return
+	}
+	server.writeJSON(responseWriter, http.StatusOK, map[string]string{"message": "API key deleted"})
+}
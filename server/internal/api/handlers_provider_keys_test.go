@@ -0,0 +1,66 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"lectures/internal/secrets"
+)
+
+func TestHandleProviderKeys(t *testing.T) {
+	server, userID, sessionID, cleanup := setupUniqueExtraTestEnv(t, "provider_keys")
+	defer cleanup()
+
+	sendRequest := func(method string, body map[string]any) *httptest.ResponseRecorder {
+		encodedBody, _ := json.Marshal(body)
+		req := httptest.NewRequest(method, "/api/settings/keys", bytes.NewBuffer(encodedBody))
+		req.Header.Set("Authorization", "Bearer "+sessionID)
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("X-Requested-With", "XMLHttpRequest")
+		rr := httptest.NewRecorder()
+		server.Handler().ServeHTTP(rr, req)
+		return rr
+	}
+
+	if rr := sendRequest("PUT", map[string]any{"provider": "openrouter", "api_key": "sk-or-user-1234"}); rr.Code != http.StatusServiceUnavailable {
+		t.Errorf("Expected status 503 without an encryption key, got %d", rr.Code)
+	}
+
+	secretCipher, err := secrets.LoadCipher("", filepath.Join(t.TempDir(), "encryption.key"))
+	if err != nil {
+		t.Fatalf("LoadCipher failed: %v", err)
+	}
+	server.SetSecretCipher(secretCipher)
+
+	if rr := sendRequest("PUT", map[string]any{"provider": "deepgram", "api_key": "key"}); rr.Code != http.StatusBadRequest {
+		t.Errorf("Expected status 400 for an unsupported provider, got %d", rr.Code)
+	}
+	rr := sendRequest("PUT", map[string]any{"provider": "openrouter", "api_key": "sk-or-user-1234"})
+	if rr.Code != http.StatusOK || strings.Contains(rr.Body.String(), "sk-or-user") || !strings.Contains(rr.Body.String(), `"1234"`) {
+		t.Fatalf("Expected the key to be stored and only its hint returned, got %d: %s", rr.Code, rr.Body.String())
+	}
+
+	var sealedKey string
+	server.database.QueryRow("SELECT sealed_key FROM user_provider_keys WHERE user_id = ?", userID).Scan(&sealedKey)
+	if sealedKey == "" || strings.Contains(sealedKey, "sk-or-user") {
+		t.Errorf("Expected the key to be encrypted at rest, got %q", sealedKey)
+	}
+	if apiKey, err := server.resolveUserAPIKey(userID, "openrouter"); err != nil || apiKey != "sk-or-user-1234" {
+		t.Errorf("Expected the provider to resolve the user's key, got %q and %v", apiKey, err)
+	}
+
+	if rr := sendRequest("DELETE", map[string]any{"provider": "openrouter"}); rr.Code != http.StatusOK {
+		t.Errorf("Expected status 200 deleting the key, got %d", rr.Code)
+	}
+	if apiKey, _ := server.resolveUserAPIKey(userID, "openrouter"); apiKey != "" {
+		t.Errorf("Expected the operator's key to be used again, got %q", apiKey)
+	}
+	if rr := sendRequest("DELETE", map[string]any{"provider": "openrouter"}); rr.Code != http.StatusNotFound {
+		t.Errorf("Expected status 404 deleting a missing key, got %d", rr.Code)
+	}
+}
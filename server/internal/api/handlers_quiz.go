@@ -0,0 +1,179 @@
+package api
+
+import (
+	"database/sql"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
+	gonanoid "github.com/matoous/go-nanoid/v2"
+
+	"lectures/internal/jobs"
+	"lectures/internal/models"
+)
+
+// handleCreateQuizAttempt grades answers to a quiz, one per question in order, and stores the graded attempt
+func (server *Server) handleCreateQuizAttempt(responseWriter http.ResponseWriter, request *http.Request) {
+	var attemptRequest struct {
+		ToolID  string              `json:"tool_id"`
+		ExamID  string              `json:"exam_id"`
+		Answers []models.QuizAnswer `json:"answers"`
+	}
+
+	if err := json.NewDecoder(request.Body).Decode(&attemptRequest); err != nil {
+		server.writeError(responseWriter, http.StatusBadRequest, "VALIDATION_ERROR", "Invalid request body", nil)
+		return
+	}
+
+	if attemptRequest.ToolID == "" || attemptRequest.ExamID == "" {
+		server.writeError(responseWriter, http.StatusBadRequest, "VALIDATION_ERROR", "tool_id and exam_id are required", nil)
+		return
+	}
+
+	userID := server.getUserID(request)
+
+	var toolType, content, languageCode string
+	err := server.database.QueryRow(`
+		SELECT tools.type, tools.content, COALESCE(tools.language_code, '')
+		FROM tools
+		JOIN exams ON tools.exam_id = exams.id
+		WHERE tools.id = ? AND tools.exam_id = ? AND `+examAccess(models.ExamRoleViewer)+`
+	`, attemptRequest.ToolID, attemptRequest.ExamID, userID).Scan(&toolType, &content, &languageCode)
+	if err == sql.ErrNoRows {
+		server.writeError(responseWriter, http.StatusNotFound, "NOT_FOUND", "Tool not found in this exam", nil)
+		return
+	}
+	if err != nil {
+		server.writeError(responseWriter, http.StatusInternalServerError, "DATABASE_ERROR", "Failed to get tool", nil)
+		return
+	}
+	if toolType != "quiz" {
+		server.writeError(responseWriter, http.StatusBadRequest, "VALIDATION_ERROR", "Only quizzes can be attempted", nil)
+		return
+	}
+
+	var questions []models.QuizQuestion
+	if err := json.Unmarshal([]byte(content), &questions); err != nil {
+		server.writeError(responseWriter, http.StatusInternalServerError, "JSON_ERROR", "Failed to parse quiz", nil)
+		return
+	}
+	if len(attemptRequest.Answers) > len(questions) {
+		server.writeError(responseWriter, http.StatusBadRequest, "VALIDATION_ERROR", "There are more answers than questions", nil)
+		return
+	}
+
+	// Free responses are graded by a paid model, so answering one needs budget left and is charged like a job
+	billedUserID := server.billedUserID(userID, attemptRequest.ExamID)
+	if answersFreeResponse(questions, attemptRequest.Answers) {
+		if err := server.checkCostBudget(billedUserID); err != nil {
+			server.writeEnqueueError(responseWriter, err, "Failed to check cost budget")
+			return
+		}
+	}
+
+	attemptID, _ := gonanoid.New()
+	results, metrics, err := server.toolGenerator.GradeQuizAttempt(request.Context(), questions, attemptRequest.Answers, languageCode)
+	if ledgerError := server.recordRequestCost(billedUserID, attemptID, jobs.CostTypeQuizGrading, metrics.EstimatedCost); ledgerError != nil {
+		slog.Warn("Failed to record quiz grading cost", "toolID", attemptRequest.ToolID, "error", ledgerError)
+	}
+	if err != nil {
+		slog.Error("Failed to grade quiz attempt", "toolID", attemptRequest.ToolID, "error", err)
+		server.writeError(responseWriter, http.StatusBadGateway, "GRADING_ERROR", "Failed to grade the free response answers", nil)
+		return
+	}
+
+	attempt := models.QuizAttempt{
+		ID:            attemptID,
+		ToolID:        attemptRequest.ToolID,
+		UserID:        userID,
+		Answers:       attemptRequest.Answers,
+		Results:       results,
+		MaximumScore:  float64(len(questions)),
+		EstimatedCost: metrics.EstimatedCost,
+		CreatedAt:     time.Now(),
+	}
+	if attempt.Answers == nil {
+		attempt.Answers = []models.QuizAnswer{}
+	}
+	for _, result := range results {
+		attempt.Score += result.Score
+	}
+
+	answersJSON, _ := json.Marshal(attempt.Answers)
+	resultsJSON, _ := json.Marshal(attempt.Results)
+	_, err = server.database.Exec(`
+		INSERT INTO quiz_attempts (id, tool_id, user_id, answers, results, score, maximum_score, estimated_cost, created_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)
+	`, attempt.ID, attempt.ToolID, attempt.UserID, string(answersJSON), string(resultsJSON), attempt.Score, attempt.MaximumScore, attempt.EstimatedCost, attempt.CreatedAt)
+	if err != nil {
+		server.writeError(responseWriter, http.StatusInternalServerError, "DATABASE_ERROR", "Failed to store quiz attempt", nil)
+		return
+	}
+	if metrics.EstimatedCost > 0 {
+		server.database.Exec("UPDATE tools SET estimated_cost = estimated_cost + ? WHERE id = ?", metrics.EstimatedCost, attempt.ToolID)
+	}
+
+	server.writeJSON(responseWriter, http.StatusCreated, attempt)
+}
+
+// answersFreeResponse reports whether an attempt answers a free response question, which only the model can grade
+func answersFreeResponse(questions []models.QuizQuestion, answers []models.QuizAnswer) bool {
+	for index, answer := range answers {
+		if index < len(questions) && questions[index].QuestionType() == models.QuizQuestionFreeResponse && strings.TrimSpace(answer.Text) != "" {
+			return true
+		}
+	}
+	return false
+}
+
+// handleListQuizAttempts lists the caller's graded attempts at a quiz, newest first
+func (server *Server) handleListQuizAttempts(responseWriter http.ResponseWriter, request *http.Request) {
+	toolID := request.URL.Query().Get("tool_id")
+	examID := request.URL.Query().Get("exam_id")
+
+	if toolID == "" || examID == "" {
+		server.writeError(responseWriter, http.StatusBadRequest, "VALIDATION_ERROR", "tool_id and exam_id are required", nil)
+		return
+	}
+
+	userID := server.getUserID(request)
+	page, err := parseListPage(request, "quiz_attempts.created_at", "quiz_attempts.id", true)
+	if err != nil {
+		server.writeError(responseWriter, http.StatusBadRequest, "VALIDATION_ERROR", err.Error(), nil)
+		return
+	}
+	condition, conditionArgs := page.condition()
+
+	rows, err := server.database.Query(`
+		SELECT quiz_attempts.id, quiz_attempts.answers, quiz_attempts.results, quiz_attempts.score, quiz_attempts.maximum_score,
+			quiz_attempts.estimated_cost, quiz_attempts.created_at, `+page.sortValue()+`
+		FROM quiz_attempts
+		JOIN tools ON quiz_attempts.tool_id = tools.id
+		JOIN exams ON tools.exam_id = exams.id
+		WHERE quiz_attempts.tool_id = ? AND tools.exam_id = ? AND quiz_attempts.user_id = ? AND `+examAccess(models.ExamRoleViewer)+condition+page.orderBy(), append([]any{toolID, examID, userID, userID}, conditionArgs...)...)
+	if err != nil {
+		server.writeError(responseWriter, http.StatusInternalServerError, "DATABASE_ERROR", "Failed to list quiz attempts", nil)
+		return
+	}
+	defer rows.Close()
+
+	attempts := []models.QuizAttempt{}
+	var cursors []pageCursor
+	for rows.Next() {
+		attempt := models.QuizAttempt{ToolID: toolID, UserID: userID}
+		var answersJSON, resultsJSON, sortValue string
+		if err := rows.Scan(&attempt.ID, &answersJSON, &resultsJSON, &attempt.Score, &attempt.MaximumScore, &attempt.EstimatedCost, &attempt.CreatedAt, &sortValue); err != nil {
+			server.writeError(responseWriter, http.StatusInternalServerError, "DATABASE_ERROR", "Failed to scan quiz attempt", nil)
+			return
+		}
+		json.Unmarshal([]byte(answersJSON), &attempt.Answers)
+		json.Unmarshal([]byte(resultsJSON), &attempt.Results)
+		attempts = append(attempts, attempt)
+		cursors = append(cursors, pageCursor{SortValue: sortValue, ID: attempt.ID})
+	}
+
+	attempts, nextCursor := paginate(page, attempts, cursors)
+	server.writePage(responseWriter, http.StatusOK, attempts, nextCursor)
+}
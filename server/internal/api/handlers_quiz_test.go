@@ -0,0 +1,99 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"lectures/internal/jobs"
+	"lectures/internal/models"
+)
+
+func TestHandleQuizAttempts(t *testing.T) {
+	server, _, sessionID, cleanup := setupHTMLTestEnv(t)
+	defer cleanup()
+	server.llmProvider.(*MockLLMProvider).ResponseText = `{"awarded_points": [1], "feedback": "Half of the answer is there."}`
+
+	examID := "exam-1"
+	toolID := "tool-1"
+	quiz := `[
+		{"question": "Q1", "options": ["A", "B", "C", "D"], "correct_answer": "B", "explanation": "E1"},
+		{"type": "ordering", "question": "Q2", "items": ["First", "Second", "Third"], "explanation": "E2"},
+		{"type": "free_response", "question": "Q3", "correct_answer": "Model answer", "rubric": [{"criterion": "C1", "points": 2}], "explanation": "E3"}
+	]`
+	_, _ = server.database.Exec("INSERT INTO exams (id, user_id, title) VALUES (?, ?, ?)", examID, "user-123", "Test Exam")
+	_, _ = server.database.Exec("INSERT INTO tools (id, exam_id, type, title, content) VALUES (?, ?, 'quiz', 'Test Quiz', ?)", toolID, examID, quiz)
+
+	sendRequest := func(method, url string, body any) *httptest.ResponseRecorder {
+		bodyJSON, _ := json.Marshal(body)
+		req := httptest.NewRequest(method, url, bytes.NewReader(bodyJSON))
+		req.Header.Set("Authorization", "Bearer "+sessionID)
+		req.Header.Set("X-Requested-With", "XMLHttpRequest")
+		rr := httptest.NewRecorder()
+		server.Handler().ServeHTTP(rr, req)
+		return rr
+	}
+
+	rr := sendRequest("POST", "/api/tools/quiz/attempts", map[string]any{
+		"tool_id": toolID,
+		"exam_id": examID,
+		"answers": []models.QuizAnswer{{Choice: "B"}, {Order: []string{"First", "Third", "Second"}}, {Text: "Part of the answer"}},
+	})
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("Expected status 201, got %d: %s", rr.Code, rr.Body.String())
+	}
+
+	var created struct {
+		Data models.QuizAttempt `json:"data"`
+	}
+	json.NewDecoder(rr.Body).Decode(&created)
+	// 1 for the choice, 2 of 3 ordered pairs, 1 of 2 rubric points
+	expectedScore := 1 + 2.0/3 + 0.5
+	if created.Data.MaximumScore != 3 || created.Data.Score != expectedScore {
+		t.Errorf("Expected a score of %v out of 3, got %v out of %v", expectedScore, created.Data.Score, created.Data.MaximumScore)
+	}
+	if len(created.Data.Results) != 3 || created.Data.Results[2].Feedback == "" {
+		t.Errorf("Unexpected results %+v", created.Data.Results)
+	}
+
+	rr = sendRequest("GET", fmt.Sprintf("/api/tools/quiz/attempts?tool_id=%s&exam_id=%s", toolID, examID), nil)
+	var listed struct {
+		Data []models.QuizAttempt `json:"data"`
+	}
+	json.NewDecoder(rr.Body).Decode(&listed)
+	if len(listed.Data) != 1 || listed.Data[0].ID != created.Data.ID || len(listed.Data[0].Answers) != 3 {
+		t.Errorf("Expected the stored attempt to be listed, got %+v", listed.Data)
+	}
+
+	rr = sendRequest("POST", "/api/tools/quiz/attempts", map[string]any{
+		"tool_id": toolID,
+		"exam_id": examID,
+		"answers": make([]models.QuizAnswer, 4),
+	})
+	if rr.Code != http.StatusBadRequest {
+		t.Errorf("Expected more answers than questions to be rejected, got %d", rr.Code)
+	}
+
+	// Only free responses are graded by the model, so only they are refused once the budget is spent
+	server.jobQueue.SetCostBudget(jobs.CostBudget{Daily: 0.5})
+	server.jobQueue.RecordRequestCost("user-123", created.Data.ID, jobs.CostTypeQuizGrading, 1)
+	rr = sendRequest("POST", "/api/tools/quiz/attempts", map[string]any{
+		"tool_id": toolID,
+		"exam_id": examID,
+		"answers": []models.QuizAnswer{{Choice: "B"}, {}, {Text: "Part of the answer"}},
+	})
+	if rr.Code != http.StatusPaymentRequired {
+		t.Errorf("Expected a free response over budget to be refused, got %d: %s", rr.Code, rr.Body.String())
+	}
+	rr = sendRequest("POST", "/api/tools/quiz/attempts", map[string]any{
+		"tool_id": toolID,
+		"exam_id": examID,
+		"answers": []models.QuizAnswer{{Choice: "B"}},
+	})
+	if rr.Code != http.StatusCreated {
+		t.Errorf("Expected answers graded without the model to be accepted over budget, got %d", rr.Code)
+	}
+}
@@ -0,0 +1,143 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+	"unicode/utf8"
+
+	"lectures/internal/database"
+	"lectures/internal/jobs"
+	"lectures/internal/models"
+)
+
+// maximumRedactedPhraseLength bounds a phrase redacted from a transcript
+const maximumRedactedPhraseLength = 200
+
+// handleListRedactions lists the redactions of a lecture transcript. They name what was redacted, so only
+// managers of the exam see them
+func (server *Server) handleListRedactions(responseWriter http.ResponseWriter, request *http.Request) {
+	lectureID := request.URL.Query().Get("lecture_id")
+	if lectureID == "" {
+		server.writeError(responseWriter, http.StatusBadRequest, "VALIDATION_ERROR", "lecture_id is required", nil)
+		return
+	}
+	if _, authorized := server.authorizeLecture(responseWriter, request, lectureID, models.ExamRoleManager); !authorized {
+		return
+	}
+
+	redactions, err := database.ListTranscriptRedactions(server.database, lectureID)
+	if err != nil {
+		server.writeError(responseWriter, http.StatusInternalServerError, "DATABASE_ERROR", "Failed to list redactions", nil)
+		return
+	}
+	server.writeJSON(responseWriter, http.StatusOK, redactions)
+}
+
+// handleCreateRedactions redacts phrases and time ranges from a lecture transcript and what was generated from
+// it, and optionally bleeps them in its media with a REDACT_MEDIA job. The text is replaced, so a redaction
+// cannot be undone
+func (server *Server) handleCreateRedactions(responseWriter http.ResponseWriter, request *http.Request) {
+	var redactRequest struct {
+		LectureID  string            `json:"lecture_id"`
+		Phrases    []string          `json:"phrases"`
+		TimeRanges []models.TimeSpan `json:"time_ranges"`
+		BleepAudio bool              `json:"bleep_audio"`
+	}
+	if err := json.NewDecoder(request.Body).Decode(&redactRequest); err != nil {
+		server.writeError(responseWriter, http.StatusBadRequest, "VALIDATION_ERROR", "Invalid request body", nil)
+		return
+	}
+	if redactRequest.LectureID == "" {
+		server.writeError(responseWriter, http.StatusBadRequest, "VALIDATION_ERROR", "lecture_id is required", nil)
+		return
+	}
+	if len(redactRequest.Phrases) == 0 && len(redactRequest.TimeRanges) == 0 {
+		server.writeError(responseWriter, http.StatusBadRequest, "VALIDATION_ERROR", "phrases or time_ranges are required", nil)
+		return
+	}
+
+	userID := server.getUserID(request)
+	var redactions []models.TranscriptRedaction
+	var phrases []string
+	for _, phrase := range redactRequest.Phrases {
+		phrase = strings.TrimSpace(phrase)
+		if phrase == "" || utf8.RuneCountInString(phrase) > maximumRedactedPhraseLength || strings.ContainsAny(phrase, "\n\r") {
+			server.writeError(responseWriter, http.StatusBadRequest, "VALIDATION_ERROR", fmt.Sprintf("Phrases must be a single non-empty line of up to %d characters", maximumRedactedPhraseLength), nil)
+			return
+		}
+		phrases = append(phrases, phrase)
+		redactions = append(redactions, models.TranscriptRedaction{LectureID: redactRequest.LectureID, Phrase: phrase, BleepAudio: redactRequest.BleepAudio, UserID: userID})
+	}
+	for _, timeRange := range redactRequest.TimeRanges {
+		if timeRange.StartMillisecond < 0 || timeRange.EndMillisecond <= timeRange.StartMillisecond {
+			server.writeError(responseWriter, http.StatusBadRequest, "VALIDATION_ERROR", "Time ranges must end after they start, at 0 or later", nil)
+			return
+		}
+		redactions = append(redactions, models.TranscriptRedaction{LectureID: redactRequest.LectureID, StartMillisecond: &timeRange.StartMillisecond, EndMillisecond: &timeRange.EndMillisecond, BleepAudio: redactRequest.BleepAudio, UserID: userID})
+	}
+
+	examID, authorized := server.authorizeLecture(responseWriter, request, redactRequest.LectureID, models.ExamRoleManager)
+	if !authorized {
+		return
+	}
+	if !server.restoreLectureTranscripts(responseWriter, redactRequest.LectureID) {
+		return
+	}
+
+	// Where phrases are said is only known before they are redacted
+	var bleepSpans []models.TimeSpan
+	if redactRequest.BleepAudio {
+		phraseSpans, err := database.FindPhraseSpans(server.database, redactRequest.LectureID, phrases)
+		if err != nil {
+			server.writeError(responseWriter, http.StatusInternalServerError, "DATABASE_ERROR", "Failed to locate the phrases", nil)
+			return
+		}
+		bleepSpans = append(phraseSpans, redactRequest.TimeRanges...)
+	}
+
+	createdRedactions, err := database.CreateTranscriptRedactions(server.database, redactions)
+	if err != nil {
+		server.writeError(responseWriter, http.StatusInternalServerError, "DATABASE_ERROR", "Failed to record redactions", nil)
+		return
+	}
+	redactionResult, err := database.ApplyTranscriptRedactions(server.database, redactRequest.LectureID)
+	if err != nil {
+		slog.Error("Failed to apply transcript redactions", "lectureID", redactRequest.LectureID, "error", err)
+		server.writeError(responseWriter, http.StatusInternalServerError, "DATABASE_ERROR", "Failed to apply redactions", nil)
+		return
+	}
+	server.deleteStoredObjects(redactionResult.PurgedExportKeys)
+	var transcriptID string
+	server.database.QueryRow("SELECT id FROM transcripts WHERE lecture_id = ?", redactRequest.LectureID).Scan(&transcriptID)
+	// The recap was dropped with the redacted text, so a new one is made from what remains
+	jobs.ScheduleLectureRecap(server.jobQueue, server.database, redactRequest.LectureID)
+
+	server.recordEvent(request, models.ResourceEvent{
+		ExamID:       examID,
+		LectureID:    redactRequest.LectureID,
+		ResourceType: models.ResourceTypeTranscript,
+		ResourceID:   transcriptID,
+		Action:       models.ResourceActionEdited,
+		Summary:      fmt.Sprintf("Redacted %d phrases and %d time ranges from the transcript", len(phrases), len(redactRequest.TimeRanges)),
+	})
+
+	response := map[string]any{
+		"redactions":        createdRedactions,
+		"redacted_segments": redactionResult.RedactedSegments,
+	}
+	if len(bleepSpans) > 0 {
+		jobID, err := server.jobQueue.Enqueue(userID, models.JobTypeRedactMedia, map[string]any{
+			"lecture_id": redactRequest.LectureID,
+			"spans":      bleepSpans,
+		}, examID, redactRequest.LectureID)
+		if err != nil {
+			server.writeEnqueueError(responseWriter, err, "Failed to queue bleeping the media")
+			return
+		}
+		response["job_id"] = jobID
+	}
+	server.writeJSON(responseWriter, http.StatusCreated, response)
+}
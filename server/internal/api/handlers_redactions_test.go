@@ -0,0 +1,133 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"lectures/internal/database"
+	"lectures/internal/models"
+)
+
+func TestHandleTranscriptRedactions(t *testing.T) {
+	server, userID, sessionID, cleanup := setupUniqueExtraTestEnv(t, "redactions")
+	defer cleanup()
+
+	server.database.Exec("INSERT INTO exams (id, user_id, title) VALUES ('redaction-exam', ?, 'Ethics')", userID)
+	server.database.Exec("INSERT INTO lectures (id, exam_id, title, status) VALUES ('redaction-lecture', 'redaction-exam', 'Consent', 'ready')")
+	server.database.Exec("INSERT INTO transcripts (id, lecture_id, status) VALUES ('redaction-transcript', 'redaction-lecture', 'completed')")
+	segments := []struct {
+		start, end int64
+		text       string
+	}{
+		{0, 5000, "Today we discuss informed consent."},
+		{5000, 9000, "José, your question about José's grandmother was personal."},
+		{9000, 12000, "Joséphine asked about the exam."},
+		{12000, 16000, "My phone number is 555 0199."},
+	}
+	for index, segment := range segments {
+		server.database.Exec("INSERT INTO transcript_segments (id, transcript_id, start_millisecond, end_millisecond, text, polished_text) VALUES (?, 'redaction-transcript', ?, ?, ?, ?)", index+1, segment.start, segment.end, segment.text, segment.text)
+	}
+	server.database.Exec("INSERT INTO tools (id, exam_id, lecture_id, type, title, content) VALUES ('redaction-tool', 'redaction-exam', 'redaction-lecture', 'guide', 'Guide', ?)", `"José raised a personal matter"`)
+	server.database.Exec("INSERT INTO lecture_recaps (lecture_id, bullets, content_signature) VALUES ('redaction-lecture', '[\"José\"]', 'signature')")
+	server.database.Exec("INSERT INTO tools (id, exam_id, type, title, content) VALUES ('redaction-overview', 'redaction-exam', 'course_overview', 'Overview', ?)", `"José opened the course"`)
+	server.database.Exec("INSERT INTO tool_sections (job_id, lecture_id, level, title, content) VALUES ('redaction-build', 'redaction-lecture', 2, 'Consent', 'José asked first')")
+	server.database.Exec("INSERT INTO chat_sessions (id, exam_id, title) VALUES ('redaction-chat', 'redaction-exam', 'Questions')")
+	server.database.Exec("INSERT INTO chat_messages (id, session_id, role, content) VALUES ('redaction-message', 'redaction-chat', 'assistant', 'José asked about his grandmother')")
+	server.database.Exec(`INSERT INTO chat_citations (message_id, source_type, source_id, location_type, location_data, snippet) VALUES ('redaction-message', 'transcript', 'redaction-transcript', 'segment_range', '{"lecture_id": "redaction-lecture", "start_millisecond": 12000, "end_millisecond": 16000}', 'My phone number is 555 0199.')`)
+	server.database.Exec("INSERT INTO jobs (id, type, status, payload, user_id, course_id, lecture_id, export_data, export_size_bytes) VALUES ('redaction-export', 'PUBLISH_MATERIAL', 'COMPLETED', '{}', ?, 'redaction-exam', 'redaction-lecture', 'José', 5)", userID)
+
+	sendRequest := func(method string, target string, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, target, strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer "+sessionID)
+		req.Header.Set("X-Requested-With", "XMLHttpRequest")
+		req.Header.Set("Content-Type", "application/json")
+		rr := httptest.NewRecorder()
+		server.Handler().ServeHTTP(rr, req)
+		return rr
+	}
+
+	if response := sendRequest("POST", "/api/transcripts/redactions", `{"lecture_id":"redaction-lecture","time_ranges":[{"start_millisecond":20,"end_millisecond":10}]}`); response.Code != http.StatusBadRequest {
+		t.Errorf("Expected an inverted time range refused, got %d", response.Code)
+	}
+
+	response := sendRequest("POST", "/api/transcripts/redactions", `{"lecture_id":"redaction-lecture","phrases":["josé"],"time_ranges":[{"start_millisecond":13000,"end_millisecond":14000}],"bleep_audio":true}`)
+	if response.Code != http.StatusCreated {
+		t.Fatalf("Expected the redactions created, got %d: %s", response.Code, response.Body.String())
+	}
+	var createResponse struct {
+		Data struct {
+			RedactedSegments int    `json:"redacted_segments"`
+			JobID            string `json:"job_id"`
+		} `json:"data"`
+	}
+	json.Unmarshal(response.Body.Bytes(), &createResponse)
+	if createResponse.Data.RedactedSegments != 2 || createResponse.Data.JobID == "" {
+		t.Errorf("Expected two segments redacted and a bleeping job, got %s", response.Body.String())
+	}
+
+	expectedTexts := map[int]string{
+		1: "Today we discuss informed consent.",
+		2: "[redacted], your question about [redacted]'s grandmother was personal.",
+		3: "Joséphine asked about the exam.",
+		4: "[redacted]",
+	}
+	for segmentID, expectedText := range expectedTexts {
+		var text, polishedText string
+		server.database.QueryRow("SELECT text, polished_text FROM transcript_segments WHERE id = ?", segmentID).Scan(&text, &polishedText)
+		if text != expectedText || polishedText != expectedText {
+			t.Errorf("Expected segment %d to read %q, got %q and %q", segmentID, expectedText, text, polishedText)
+		}
+	}
+	var toolContent string
+	server.database.QueryRow("SELECT content FROM tools WHERE id = 'redaction-tool'").Scan(&toolContent)
+	if toolContent != `"[redacted] raised a personal matter"` {
+		t.Errorf("Expected the phrase redacted from the lecture tools, got %s", toolContent)
+	}
+	var overviewContent, messageContent, citationSnippet string
+	var redactionPending bool
+	server.database.QueryRow("SELECT content, redaction_pending FROM tools WHERE id = 'redaction-overview'").Scan(&overviewContent, &redactionPending)
+	if overviewContent != `"[redacted] opened the course"` || !redactionPending {
+		t.Errorf("Expected the course overview redacted and flagged for the time range, got %s (%v)", overviewContent, redactionPending)
+	}
+	server.database.QueryRow("SELECT content FROM chat_messages WHERE id = 'redaction-message'").Scan(&messageContent)
+	server.database.QueryRow("SELECT snippet FROM chat_citations WHERE message_id = 'redaction-message'").Scan(&citationSnippet)
+	if messageContent != "[redacted] asked about his grandmother" || citationSnippet != "[redacted]" {
+		t.Errorf("Expected the chat redacted, got %q and %q", messageContent, citationSnippet)
+	}
+	var sectionCount, exportCount int
+	server.database.QueryRow("SELECT COUNT(*) FROM tool_sections WHERE lecture_id = 'redaction-lecture'").Scan(&sectionCount)
+	server.database.QueryRow("SELECT COUNT(*) FROM jobs WHERE id = 'redaction-export' AND export_data IS NULL").Scan(&exportCount)
+	if sectionCount != 0 || exportCount != 1 {
+		t.Errorf("Expected the kept sections dropped and the export purged, got %d sections and %d purged exports", sectionCount, exportCount)
+	}
+	var recapCount int
+	server.database.QueryRow("SELECT COUNT(*) FROM lecture_recaps WHERE lecture_id = 'redaction-lecture'").Scan(&recapCount)
+	if recapCount != 0 {
+		t.Error("Expected the recap dropped with the redacted text")
+	}
+
+	// The bleeping job covers the segment where the phrase is said and the time range
+	job, err := server.jobQueue.GetJob(createResponse.Data.JobID)
+	if err != nil || job.Type != models.JobTypeRedactMedia || !strings.Contains(job.Payload, `{"start_millisecond":5000,"end_millisecond":9000}`) || !strings.Contains(job.Payload, `{"start_millisecond":13000,"end_millisecond":14000}`) {
+		t.Errorf("Expected a REDACT_MEDIA job over the redacted spans, got %+v (%v)", job, err)
+	}
+
+	// A rewritten transcript is redacted again
+	server.database.Exec("UPDATE transcript_segments SET text = 'José is back', polished_text = NULL WHERE id = 2")
+	if _, err := database.ApplyTranscriptRedactions(server.database, "redaction-lecture"); err != nil {
+		t.Fatalf("ApplyTranscriptRedactions failed: %v", err)
+	}
+	var text string
+	server.database.QueryRow("SELECT text FROM transcript_segments WHERE id = 2").Scan(&text)
+	if text != "[redacted] is back" {
+		t.Errorf("Expected the redaction applied again, got %q", text)
+	}
+
+	response = sendRequest("GET", "/api/transcripts/redactions?lecture_id=redaction-lecture", "")
+	if response.Code != http.StatusOK || !strings.Contains(response.Body.String(), `"josé"`) || !strings.Contains(response.Body.String(), `"start_millisecond": 13000`) {
+		t.Errorf("Expected both redactions listed, got %d: %s", response.Code, response.Body.String())
+	}
+}
@@ -27,6 +27,7 @@ func (server *Server) handleGetSettings(responseWriter http.ResponseWriter, requ
 		"documents":       server.configuration.Documents,
 		"safety":          server.configuration.Safety,
 		"providers":       server.configuration.Providers,
+		"privacy":         server.configuration.Privacy,
 		"resolved_models": resolved,
 	})
 }
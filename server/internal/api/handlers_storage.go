@@ -0,0 +1,104 @@
+package api
+
+import (
+	"log/slog"
+	"net/http"
+
+	"lectures/internal/database"
+)
+
+const bytesPerMegabyte = 1024 * 1024
+
+// checkStorageQuota refuses to take in sizeBytes more for the caller when that would exceed their storage quota
+// or that of the server, and logs a warning when it comes close. Uploads in progress count against the quotas,
+// except exceptUploadID, the upload being checked
+func (server *Server) checkStorageQuota(responseWriter http.ResponseWriter, request *http.Request, exceptUploadID string, sizeBytes int64) bool {
+	quota := server.configuration.Storage.Quota
+	warningPercent := int64(quota.WarningPercent)
+	if warningPercent <= 0 {
+		warningPercent = 90
+	}
+	userID := server.getUserID(request)
+
+	limits := []struct {
+		scope     string
+		userID    string // Empty for the quota of the server
+		megabytes int
+		message   string
+	}{
+		{"user", userID, quota.PerUserMB, "Your storage quota is full"},
+		{"global", "", quota.GlobalMB, "The storage of this server is full"},
+	}
+	for _, limit := range limits {
+		if limit.megabytes <= 0 {
+			continue
+		}
+		usedBytes, err := server.storageUsedBytes(limit.userID, exceptUploadID)
+		if err != nil {
+			slog.Error("Failed to measure storage usage", "scope", limit.scope, "error", err)
+			server.writeError(responseWriter, http.StatusInternalServerError, "DATABASE_ERROR", "Failed to check the storage quota", nil)
+			return false
+		}
+
+		quotaBytes := int64(limit.megabytes) * bytesPerMegabyte
+		if usedBytes+sizeBytes > quotaBytes {
+			server.writeError(responseWriter, http.StatusRequestEntityTooLarge, "STORAGE_QUOTA_EXCEEDED", limit.message, map[string]any{
+				"scope":           limit.scope,
+				"quota_bytes":     quotaBytes,
+				"used_bytes":      usedBytes,
+				"requested_bytes": sizeBytes,
+			})
+			return false
+		}
+		if (usedBytes+sizeBytes)*100 >= quotaBytes*warningPercent {
+			slog.Warn("Storage quota nearly reached", "scope", limit.scope, "user_id", userID, "used_bytes", usedBytes+sizeBytes, "quota_bytes", quotaBytes)
+		}
+	}
+	return true
+}
+
+// storageUsedBytes returns the bytes stored and being uploaded for a user or, with an empty userID, for everyone
+func (server *Server) storageUsedBytes(userID string, exceptUploadID string) (int64, error) {
+	var storedUsage database.StorageUsage
+	var err error
+	if userID == "" {
+		storedUsage, err = database.GetTotalStorageUsage(server.database)
+	} else {
+		_, storedUsage, err = database.GetUserStorageUsage(server.database, userID)
+	}
+	if err != nil {
+		return 0, err
+	}
+	pendingBytes, err := database.GetPendingUploadBytes(server.database, userID, exceptUploadID)
+	if err != nil {
+		return 0, err
+	}
+	return storedUsage.TotalBytes + pendingBytes, nil
+}
+
+// handleGetStorageUsage reports the bytes of files the caller's exams hold, by kind and per exam, with their
+// uploads in progress and their quota
+func (server *Server) handleGetStorageUsage(responseWriter http.ResponseWriter, request *http.Request) {
+	userID := server.getUserID(request)
+	exams, totalUsage, err := database.GetUserStorageUsage(server.database, userID)
+	if err != nil {
+		slog.Error("Failed to compute storage usage", "userID", userID, "error", err)
+		server.writeError(responseWriter, http.StatusInternalServerError, "DATABASE_ERROR", "Failed to compute storage usage", nil)
+		return
+	}
+	pendingBytes, err := database.GetPendingUploadBytes(server.database, userID, "")
+	if err != nil {
+		server.writeError(responseWriter, http.StatusInternalServerError, "DATABASE_ERROR", "Failed to compute storage usage", nil)
+		return
+	}
+
+	server.writeJSON(responseWriter, http.StatusOK, storageUsageResponse{totalUsage, pendingBytes, int64(server.configuration.Storage.Quota.PerUserMB) * bytesPerMegabyte, exams})
+}
+
+// storageUsageResponse is the storage used by a user, by kind of file and by exam, with their quota
+type storageUsageResponse struct {
+	database.StorageUsage
+	PendingUploadBytes int64                       `json:"pending_upload_bytes"`
+	QuotaBytes         int64                       `json:"quota_bytes"` // 0 is unlimited
+	Exams              []database.ExamStorageUsage `json:"exams"`
+}
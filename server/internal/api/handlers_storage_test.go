@@ -0,0 +1,73 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestHandleStorageQuota(t *testing.T) {
+	server, userID, sessionID, cleanup := setupUniqueExtraTestEnv(t, "storage_quota")
+	defer cleanup()
+
+	server.database.Exec("INSERT INTO exams (id, user_id, title) VALUES ('quota-exam', ?, 'Acoustics')", userID)
+	server.database.Exec("INSERT INTO lectures (id, exam_id, title) VALUES ('quota-lecture', 'quota-exam', 'Waves')")
+	server.database.Exec("INSERT INTO lecture_media (id, lecture_id, media_type, sequence_order, file_path, object_key, size_bytes) VALUES ('quota-media', 'quota-lecture', 'audio', 0, 'quota-media.mp3', 'lectures/quota-lecture/media/quota-media.mp3', 600000)")
+	server.database.Exec("INSERT INTO reference_documents (id, lecture_id, document_type, title, file_path, page_count, extraction_status, file_data) VALUES ('quota-document', 'quota-lecture', 'pdf', 'Slides', 'slides.pdf', 1, 'completed', ?)", make([]byte, 100))
+	server.database.Exec("INSERT INTO reference_pages (document_id, page_number, image_path, extracted_text, image_data) VALUES ('quota-document', 1, 'page_1.png', 'Waves', ?)", make([]byte, 50))
+	server.database.Exec("INSERT INTO jobs (id, user_id, course_id, type, status, payload, export_size_bytes) VALUES ('quota-export', ?, 'quota-exam', 'PUBLISH_MATERIAL', 'COMPLETED', '{}', 250)", userID)
+
+	sendRequest := func(method string, target string, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, target, strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer "+sessionID)
+		req.Header.Set("X-Requested-With", "XMLHttpRequest")
+		req.Header.Set("Content-Type", "application/json")
+		rr := httptest.NewRecorder()
+		server.Handler().ServeHTTP(rr, req)
+		return rr
+	}
+
+	server.configuration.Storage.Quota.PerUserMB = 1
+	response := sendRequest("GET", "/api/storage/usage", "")
+	var usageResponse struct {
+		Data struct {
+			MediaBytes     int64 `json:"media_bytes"`
+			DocumentBytes  int64 `json:"document_bytes"`
+			PageImageBytes int64 `json:"page_image_bytes"`
+			ExportBytes    int64 `json:"export_bytes"`
+			TotalBytes     int64 `json:"total_bytes"`
+			QuotaBytes     int64 `json:"quota_bytes"`
+			Exams          []struct {
+				ExamID     string `json:"exam_id"`
+				TotalBytes int64  `json:"total_bytes"`
+			} `json:"exams"`
+		} `json:"data"`
+	}
+	json.Unmarshal(response.Body.Bytes(), &usageResponse)
+	usage := usageResponse.Data
+	if response.Code != http.StatusOK || usage.MediaBytes != 600000 || usage.DocumentBytes != 100 || usage.PageImageBytes != 50 || usage.ExportBytes != 250 || usage.TotalBytes != 600400 {
+		t.Fatalf("Expected the bytes of every kind of file, got %d: %s", response.Code, response.Body.String())
+	}
+	if usage.QuotaBytes != 1048576 || len(usage.Exams) != 1 || usage.Exams[0].ExamID != "quota-exam" || usage.Exams[0].TotalBytes != 600400 {
+		t.Errorf("Expected the quota and the usage of the exam, got %s", response.Body.String())
+	}
+
+	// Uploads that would not fit are refused when prepared, counting those in progress
+	if response := sendRequest("POST", "/api/uploads/prepare", `{"filename":"big.mp3","file_size_bytes":500000}`); response.Code != http.StatusRequestEntityTooLarge || !strings.Contains(response.Body.String(), `"user"`) {
+		t.Errorf("Expected the upload over the user quota refused, got %d: %s", response.Code, response.Body.String())
+	}
+	if response := sendRequest("POST", "/api/uploads/prepare", `{"filename":"small.mp3","file_size_bytes":300000}`); response.Code != http.StatusOK {
+		t.Fatalf("Expected the upload within the quota prepared, got %d: %s", response.Code, response.Body.String())
+	}
+	if response := sendRequest("POST", "/api/uploads/prepare", `{"filename":"more.mp3","file_size_bytes":200000}`); response.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("Expected the upload in progress counted against the quota, got %d: %s", response.Code, response.Body.String())
+	}
+
+	server.configuration.Storage.Quota.PerUserMB = 0
+	server.configuration.Storage.Quota.GlobalMB = 1
+	if response := sendRequest("POST", "/api/uploads/prepare", `{"filename":"more.mp3","file_size_bytes":200000}`); response.Code != http.StatusRequestEntityTooLarge || !strings.Contains(response.Body.String(), `"global"`) {
+		t.Errorf("Expected the upload over the global quota refused, got %d: %s", response.Code, response.Body.String())
+	}
+}
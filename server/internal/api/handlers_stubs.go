@@ -8,6 +8,6 @@ import (
 func (server *Server) handleHealth(responseWriter http.ResponseWriter, request *http.Request) {
 	server.writeJSON(responseWriter, http.StatusOK, map[string]string{
 		"status":  "healthy",
-		"version": "1.0.0",
+		"version": apiVersion,
 	})
 }
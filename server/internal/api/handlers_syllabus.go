@@ -0,0 +1,126 @@
+package api
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"lectures/internal/documents"
+	"lectures/internal/models"
+
+	gonanoid "github.com/matoous/go-nanoid/v2"
+)
+
+// maximumSyllabusBytes bounds the syllabus files read by handleCreateExamFromSyllabus
+const maximumSyllabusBytes = 50 << 20
+
+// SetDocumentProcessor lets exams be created from a syllabus, which is read by the document processor
+func (server *Server) SetDocumentProcessor(processor *documents.Processor) {
+	server.documentProcessor = processor
+}
+
+// handleCreateExamFromSyllabus creates an exam from a syllabus PDF (form field "syllabus") and queues an
+// IMPORT_SYLLABUS job that reads it and adds a planned lecture for each scheduled session, titled and dated, so
+// a semester is set up before any recording exists. The form may set the exam "language", in which the
+// syllabus is read, and its "title", which the job otherwise takes from the syllabus
+func (server *Server) handleCreateExamFromSyllabus(responseWriter http.ResponseWriter, request *http.Request) {
+	if server.documentProcessor == nil {
+		server.writeError(responseWriter, http.StatusServiceUnavailable, "SYLLABUS_UNAVAILABLE", "Syllabus import is not available on this server", nil)
+		return
+	}
+
+	request.Body = http.MaxBytesReader(responseWriter, request.Body, maximumSyllabusBytes+1<<20)
+	if err := request.ParseMultipartForm(32 << 20); err != nil {
+		server.writeError(responseWriter, http.StatusBadRequest, "VALIDATION_ERROR", "Invalid form or syllabus larger than 50 MB", nil)
+		return
+	}
+	syllabusFile, fileHeader, err := request.FormFile("syllabus")
+	if err != nil {
+		server.writeError(responseWriter, http.StatusBadRequest, "VALIDATION_ERROR", "syllabus file is required", nil)
+		return
+	}
+	syllabusFile.Close()
+	if !strings.EqualFold(filepath.Ext(fileHeader.Filename), ".pdf") {
+		server.writeError(responseWriter, http.StatusBadRequest, "VALIDATION_ERROR", "syllabus must be a PDF", nil)
+		return
+	}
+
+	userID := server.getUserID(request)
+	// Without a language the syllabus is read in the one detected from its text
+	language := strings.TrimSpace(request.FormValue("language"))
+	title := strings.TrimSpace(request.FormValue("title"))
+
+	// Checked before anything is created, so a refused import leaves no empty exam behind
+	if err := server.checkCostBudget(userID); err != nil {
+		server.writeEnqueueError(responseWriter, err, "Failed to check cost budget")
+		return
+	}
+
+	uploadID := server.stageMultipartFile(fileHeader)
+	if uploadID == "" {
+		server.writeError(responseWriter, http.StatusInternalServerError, "FILE_UPLOAD_ERROR", "Failed to stage syllabus", nil)
+		return
+	}
+	discardUpload := func() {
+		os.RemoveAll(filepath.Join(os.TempDir(), "lectures-uploads", uploadID))
+	}
+
+	examTitle := title
+	if examTitle == "" {
+		examTitle = strings.TrimSuffix(fileHeader.Filename, filepath.Ext(fileHeader.Filename))
+	}
+	exam, err := server.createSyllabusExam(userID, examTitle, language)
+	if err != nil {
+		discardUpload()
+		slog.Error("Failed to create exam from syllabus", "userID", userID, "error", err)
+		server.writeError(responseWriter, http.StatusInternalServerError, "DATABASE_ERROR", "Failed to create exam", nil)
+		return
+	}
+
+	jobID, err := server.jobQueue.Enqueue(userID, models.JobTypeImportSyllabus, map[string]any{
+		"exam_id":       exam.ID,
+		"upload_id":     uploadID,
+		"filename":      fileHeader.Filename,
+		"language_code": language,
+		"keep_title":    title != "",
+	}, exam.ID, "")
+	if err != nil {
+		discardUpload()
+		server.database.Exec("DELETE FROM exams WHERE id = ?", exam.ID)
+		server.writeEnqueueError(responseWriter, err, "Failed to queue syllabus import")
+		return
+	}
+
+	server.writeJSON(responseWriter, http.StatusAccepted, map[string]any{
+		"exam":   exam,
+		"job_id": jobID,
+	})
+}
+
+// createSyllabusExam stores the exam a syllabus import fills in
+func (server *Server) createSyllabusExam(userID string, title string, language string) (models.Exam, error) {
+	examID, _ := gonanoid.New()
+	exam := models.Exam{
+		ID:             examID,
+		UserID:         userID,
+		Title:          title,
+		Language:       language,
+		Collaboration:  models.DefaultExamCollaboration(),
+		MetadataFields: []models.MetadataField{},
+		Role:           models.ExamRoleOwner,
+		CreatedAt:      time.Now(),
+		UpdatedAt:      time.Now(),
+	}
+
+	generationDefaults, _ := json.Marshal(exam.GenerationDefaults)
+	collaboration, _ := json.Marshal(exam.Collaboration)
+	_, err := server.database.Exec(`
+		INSERT INTO exams (id, user_id, title, description, language, generation_defaults, collaboration, metadata_fields, estimated_cost, created_at, updated_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+	`, exam.ID, exam.UserID, exam.Title, exam.Description, exam.Language, string(generationDefaults), string(collaboration), "[]", exam.EstimatedCost, exam.CreatedAt, exam.UpdatedAt)
+	return exam, err
+}
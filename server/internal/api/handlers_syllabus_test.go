@@ -0,0 +1,149 @@
+package api
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"lectures/internal/documents"
+	"lectures/internal/jobs"
+	"lectures/internal/models"
+	"lectures/internal/tools"
+)
+
+func TestHandleCreateExamFromSyllabus(t *testing.T) {
+	server, _, sessionID, cleanup := setupUniqueExtraTestEnv(t, "syllabus")
+	defer cleanup()
+
+	sendSyllabus := func(filename string, fields map[string]string) *httptest.ResponseRecorder {
+		body := &bytes.Buffer{}
+		writer := multipart.NewWriter(body)
+		for name, value := range fields {
+			writer.WriteField(name, value)
+		}
+		if filename != "" {
+			part, _ := writer.CreateFormFile("syllabus", filename)
+			part.Write([]byte("%PDF-1.4 syllabus"))
+		}
+		writer.Close()
+		req := httptest.NewRequest("POST", "/api/exams/from-syllabus", body)
+		req.Header.Set("Authorization", "Bearer "+sessionID)
+		req.Header.Set("X-Requested-With", "XMLHttpRequest")
+		req.Header.Set("Content-Type", writer.FormDataContentType())
+		rr := httptest.NewRecorder()
+		server.Handler().ServeHTTP(rr, req)
+		return rr
+	}
+
+	if response := sendSyllabus("syllabus.pdf", nil); response.Code != http.StatusServiceUnavailable {
+		t.Errorf("Expected syllabus import unavailable without a document processor, got %d", response.Code)
+	}
+
+	mockLLM := &MockLLMProvider{ResponseText: `{"title": "Introduction to Optics", "description": "Lenses and waves.", "lectures": [
+		{"title": "Reflection and refraction", "date": "2026-09-14"},
+		{"title": "Thin lenses", "description": "Chapter 2", "date": ""}
+	]}`}
+	processor := documents.NewProcessor(mockLLM, "mock-model", nil, 72, "")
+	processor.SetConverter(&MockDocumentConverter{})
+	server.SetDocumentProcessor(processor)
+	server.toolGenerator = tools.NewToolGenerator(server.configuration, mockLLM, nil)
+	// No job runs yet, so the handlers can be registered again with the document processor
+	jobs.RegisterHandlers(server.jobQueue, server.database, server.configuration, nil, processor, server.toolGenerator, &MockMarkdownConverter{}, nil, nil)
+
+	if response := sendSyllabus("", nil); response.Code != http.StatusBadRequest {
+		t.Errorf("Expected a missing syllabus refused, got %d", response.Code)
+	}
+	if response := sendSyllabus("syllabus.docx", nil); response.Code != http.StatusBadRequest {
+		t.Errorf("Expected a syllabus other than a PDF refused, got %d", response.Code)
+	}
+
+	// The exam is created at once and filled in by a job, so the model calls run and are charged like any job
+	response := sendSyllabus("syllabus.pdf", map[string]string{"language": "en"})
+	if response.Code != http.StatusAccepted {
+		t.Fatalf("Expected the import queued, got %d: %s", response.Code, response.Body.String())
+	}
+	var importResponse struct {
+		Data struct {
+			Exam  models.Exam `json:"exam"`
+			JobID string      `json:"job_id"`
+		} `json:"data"`
+	}
+	json.Unmarshal(response.Body.Bytes(), &importResponse)
+	examID := importResponse.Data.Exam.ID
+	if importResponse.Data.Exam.Title != "syllabus" || importResponse.Data.Exam.Language != "en" || importResponse.Data.JobID == "" {
+		t.Fatalf("Expected the exam titled after the file with its import job, got %+v", importResponse.Data)
+	}
+
+	var jobStatus, jobCourseID string
+	for start := time.Now(); time.Since(start) < 5*time.Second; time.Sleep(20 * time.Millisecond) {
+		server.database.QueryRow("SELECT status, course_id FROM jobs WHERE id = ?", importResponse.Data.JobID).Scan(&jobStatus, &jobCourseID)
+		if jobStatus == models.JobStatusCompleted || jobStatus == models.JobStatusFailed {
+			break
+		}
+	}
+	if jobStatus != models.JobStatusCompleted || jobCourseID != examID {
+		t.Fatalf("Expected the import job of the exam completed, got %s on %q", jobStatus, jobCourseID)
+	}
+
+	var examTitle, examDescription string
+	server.database.QueryRow("SELECT title, description FROM exams WHERE id = ?", examID).Scan(&examTitle, &examDescription)
+	if examTitle != "Introduction to Optics" || examDescription != "Lenses and waves." {
+		t.Errorf("Expected the exam described by the syllabus, got %q: %q", examTitle, examDescription)
+	}
+	rows, _ := server.database.Query("SELECT id, title, specified_date FROM lectures WHERE exam_id = ? AND status = 'planned' ORDER BY created_at", examID)
+	var plannedIDs, plannedTitles []string
+	var datedLectures int
+	for rows.Next() {
+		var lectureID, title string
+		var specifiedDate sql.NullTime
+		rows.Scan(&lectureID, &title, &specifiedDate)
+		plannedIDs, plannedTitles = append(plannedIDs, lectureID), append(plannedTitles, title)
+		if specifiedDate.Valid && specifiedDate.Time.Format("2006-01-02") == "2026-09-14" {
+			datedLectures++
+		}
+	}
+	rows.Close()
+	if len(plannedIDs) != 2 || plannedTitles[0] != "Reflection and refraction" || datedLectures != 1 {
+		t.Fatalf("Expected two planned lectures in order with only the first dated, got %v (%d dated)", plannedTitles, datedLectures)
+	}
+
+	// A planned lecture is filled in where it was listed instead of adding another lecture
+	fillIn := func(lectureID string) *httptest.ResponseRecorder {
+		body := &bytes.Buffer{}
+		writer := multipart.NewWriter(body)
+		writer.WriteField("exam_id", examID)
+		writer.WriteField("lecture_id", lectureID)
+		writer.Close()
+		req := httptest.NewRequest("POST", "/api/lectures", body)
+		req.Header.Set("Authorization", "Bearer "+sessionID)
+		req.Header.Set("X-Requested-With", "XMLHttpRequest")
+		req.Header.Set("Content-Type", writer.FormDataContentType())
+		rr := httptest.NewRecorder()
+		server.Handler().ServeHTTP(rr, req)
+		return rr
+	}
+	rr := fillIn(plannedIDs[0])
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Expected the planned lecture filled in, got %d: %s", rr.Code, rr.Body.String())
+	}
+	var filled struct {
+		Data models.Lecture `json:"data"`
+	}
+	json.Unmarshal(rr.Body.Bytes(), &filled)
+	if filled.Data.ID != plannedIDs[0] || filled.Data.Status != "processing" || filled.Data.SpecifiedDate == nil {
+		t.Errorf("Expected the planned lecture processing with its date, got %+v", filled.Data)
+	}
+	var lectureCount int
+	server.database.QueryRow("SELECT COUNT(*) FROM lectures WHERE exam_id = ?", examID).Scan(&lectureCount)
+	if lectureCount != 2 {
+		t.Errorf("Expected no lecture added, got %d lectures", lectureCount)
+	}
+	if rr := fillIn(plannedIDs[0]); rr.Code != http.StatusNotFound {
+		t.Errorf("Expected a lecture no longer planned refused, got %d", rr.Code)
+	}
+}
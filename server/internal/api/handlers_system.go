@@ -2,21 +2,62 @@ package api
 
 import (
 	"database/sql"
+	"encoding/json"
 	"fmt"
 	"io"
 	"lectures/internal/jobs"
+	"lectures/internal/models"
+	"lectures/internal/storage"
 	"net/http"
 	"os"
 	"path/filepath"
 	"time"
 )
 
+// systemAnnouncementSettingKey is the settings row holding the operator announcement banner
+const systemAnnouncementSettingKey = "system_announcement"
+
+// loadSystemAnnouncement returns the stored announcement, or nil when none is set or it has expired
+func (server *Server) loadSystemAnnouncement() *models.SystemAnnouncement {
+	var valueJSON string
+	if err := server.database.QueryRow("SELECT value FROM settings WHERE key = ?", systemAnnouncementSettingKey).Scan(&valueJSON); err != nil {
+		return nil
+	}
+
+	var announcement models.SystemAnnouncement
+	if err := json.Unmarshal([]byte(valueJSON), &announcement); err != nil || announcement.Message == "" {
+		return nil
+	}
+	if announcement.EndsAt != nil && time.Now().After(*announcement.EndsAt) {
+		return nil
+	}
+	return &announcement
+}
+
+// systemStatus summarizes what users need to know about the instance: the announcement banner and whether jobs are paused
+func (server *Server) systemStatus() map[string]any {
+	return map[string]any{
+		"announcement": server.loadSystemAnnouncement(),
+		"queue_paused": server.jobQueue != nil && server.jobQueue.IsPaused(),
+	}
+}
+
+// broadcastSystemStatus pushes the current system status to every connected client
+func (server *Server) broadcastSystemStatus() {
+	server.Broadcast("system", "system:status", server.systemStatus())
+}
+
+// handleGetSystemStatus returns the current announcement banner and queue state
+func (server *Server) handleGetSystemStatus(responseWriter http.ResponseWriter, request *http.Request) {
+	server.writeJSON(responseWriter, http.StatusOK, server.systemStatus())
+}
+
 // handleBackupDatabase creates a consistent backup of the SQLite database and serves it for download
 func (server *Server) handleBackupDatabase(responseWriter http.ResponseWriter, request *http.Request) {
 	// 1. Authenticate — bypass authMiddleware (stale cookies must not block download links)
-	sessionToken := server.getValidSessionToken(request)
-	if sessionToken == "" {
-		server.writeError(responseWriter, http.StatusUnauthorized, "AUTHENTICATION_ERROR", "Authentication required", nil)
+	sessionToken, passwordChangeRequired := server.getValidSessionToken(request)
+	if sessionToken == "" || passwordChangeRequired {
+		server.writeSessionError(responseWriter, passwordChangeRequired)
 		return
 	}
 	var userID string
@@ -56,6 +97,7 @@ func (server *Server) handleBackupDatabase(responseWriter http.ResponseWriter, r
 	defer os.Remove(backupPath) // Clean up after serving
 
 	// 4. Serve the file
+	server.recordAuditEvent(request, models.AuditEvent{Action: models.AuditActionBackupDownloaded, ActorID: userID, ResourceType: "backup", ResourceID: backupFilename})
 	responseWriter.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"%s\"", backupFilename))
 	responseWriter.Header().Set("Content-Type", "application/x-sqlite3")
 	http.ServeFile(responseWriter, request, backupPath)
@@ -68,13 +110,13 @@ func (server *Server) handleRestoreDatabase(responseWriter http.ResponseWriter,
 	server.database.QueryRow("SELECT COUNT(*) FROM users").Scan(&userCount)
 	initialized := userCount > 0
 
+	var userID string
 	if initialized {
-		sessionToken := server.getValidSessionToken(request)
-		if sessionToken == "" {
-			server.writeError(responseWriter, http.StatusUnauthorized, "AUTHENTICATION_ERROR", "Authentication required", nil)
+		sessionToken, passwordChangeRequired := server.getValidSessionToken(request)
+		if sessionToken == "" || passwordChangeRequired {
+			server.writeSessionError(responseWriter, passwordChangeRequired)
 			return
 		}
-		var userID string
 		err := server.database.QueryRow("SELECT user_id FROM auth_sessions WHERE id = ?", sessionToken).Scan(&userID)
 		if err != nil {
 			server.writeError(responseWriter, http.StatusUnauthorized, "AUTHENTICATION_ERROR", "Invalid session", nil)
@@ -116,7 +158,7 @@ func (server *Server) handleRestoreDatabase(responseWriter http.ResponseWriter,
 	// WARNING: This is a disruptive operation.
 	server.database.Close()
 
-	realPath := filepath.Join(server.configuration.Storage.DataDirectory, "database.db")
+	realPath := storage.NewLayout(server.configuration.Storage).DatabasePath()
 	if err := os.Rename(tempPath, realPath); err != nil {
 		// Attempt fallback copy if rename fails (e.g. across filesystems)
 		if err := copyFile(tempPath, realPath); err != nil {
@@ -138,11 +180,13 @@ func (server *Server) handleRestoreDatabase(responseWriter http.ResponseWriter,
 	// 6. Restart job queue with new database connection
 	server.jobQueue.Stop()
 	server.jobQueue = jobs.NewQueue(newDB, 4)
+	server.jobQueue.SetConcurrency(server.configuration.Jobs.Concurrency, server.configuration.Jobs.Scaling)
 	jobs.RegisterHandlers(server.jobQueue, newDB, server.configuration, nil, nil, server.toolGenerator, server.markdownConverter, nil, nil)
 	server.jobQueue.Start()
 
 	// 7. Reload settings from restored database
 	server.loadSettingsFromDatabase()
+	server.recordAuditEvent(request, models.AuditEvent{Action: models.AuditActionBackupRestored, ActorID: userID, Details: map[string]any{"legacy_database": true}})
 
 	server.writeJSON(responseWriter, http.StatusOK, map[string]string{
 		"message": "Workspace restored successfully. You can now log in.",
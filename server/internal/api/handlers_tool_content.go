@@ -0,0 +1,297 @@
+package api
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"lectures/internal/database"
+	"lectures/internal/markdown"
+	"lectures/internal/models"
+)
+
+// toolSectionEdit replaces the body of a section of a study guide, found by its heading path, and may rename it
+type toolSectionEdit struct {
+	HeadingPath []string `json:"heading_path"`
+	Content     string   `json:"content"`
+	Title       string   `json:"title"`
+}
+
+// citationProblem is a citation of an edited study guide that does not point at the lecture's documents
+type citationProblem struct {
+	Number  int    `json:"number"`
+	File    string `json:"file"`
+	Pages   []int  `json:"pages,omitempty"`
+	Problem string `json:"problem"`
+}
+
+// toolSourceReference is a stored reference of a citation of a study guide
+type toolSourceReference struct {
+	sourceID string
+	metadata map[string]any
+}
+
+// handleUpdateToolContent edits the markdown of a study guide, replacing it whole or section by section. The
+// citations of the result must cite documents of the guide's lecture and pages they have; the stored source
+// references are rebuilt from them, keeping the improved descriptions and regions of the citations left as
+// they were. The replaced content is kept as a version, so the edit can be undone
+func (server *Server) handleUpdateToolContent(responseWriter http.ResponseWriter, request *http.Request) {
+	var updateRequest struct {
+		ToolID  string            `json:"tool_id"`
+		ExamID  string            `json:"exam_id"`
+		Content *string           `json:"content"`
+		Edits   []toolSectionEdit `json:"edits"`
+	}
+	if err := json.NewDecoder(request.Body).Decode(&updateRequest); err != nil {
+		server.writeError(responseWriter, http.StatusBadRequest, "VALIDATION_ERROR", "Invalid request body", nil)
+		return
+	}
+
+	if updateRequest.ToolID == "" || updateRequest.ExamID == "" {
+		server.writeError(responseWriter, http.StatusBadRequest, "VALIDATION_ERROR", "tool_id and exam_id are required", nil)
+		return
+	}
+	if (updateRequest.Content == nil) == (len(updateRequest.Edits) == 0) {
+		server.writeError(responseWriter, http.StatusBadRequest, "VALIDATION_ERROR", "Either content or edits is required", nil)
+		return
+	}
+
+	if !server.authorizeExam(responseWriter, request, updateRequest.ExamID, models.ExamRoleManager) {
+		return
+	}
+
+	var tool models.Tool
+	var lectureID, languageCode sql.NullString
+	err := server.database.QueryRow(`
+		SELECT type, title, content, lecture_id, language_code FROM tools WHERE id = ? AND exam_id = ?
+	`, updateRequest.ToolID, updateRequest.ExamID).Scan(&tool.Type, &tool.Title, &tool.Content, &lectureID, &languageCode)
+	if err == sql.ErrNoRows {
+		server.writeError(responseWriter, http.StatusNotFound, "NOT_FOUND", "Tool not found in this exam", nil)
+		return
+	}
+	if err != nil {
+		server.writeError(responseWriter, http.StatusInternalServerError, "DATABASE_ERROR", "Failed to get tool", nil)
+		return
+	}
+	if !models.ToolCitesSources(tool.Type) {
+		server.writeError(responseWriter, http.StatusBadRequest, "VALIDATION_ERROR", "Only study guides and course overviews can be edited as markdown; edit other tools with PATCH /api/tools/details", nil)
+		return
+	}
+
+	content := tool.Content
+	if updateRequest.Content != nil {
+		content = *updateRequest.Content
+	}
+	for editIndex, edit := range updateRequest.Edits {
+		if strings.ContainsAny(edit.Title, "\r\n") {
+			server.writeError(responseWriter, http.StatusBadRequest, "VALIDATION_ERROR", "title must be a single line", map[string]int{"edit": editIndex})
+			return
+		}
+		content, err = markdown.ReplaceSection(content, edit.HeadingPath, edit.Content, edit.Title)
+		if err != nil {
+			server.writeError(responseWriter, http.StatusBadRequest, "VALIDATION_ERROR", err.Error(), map[string]int{"edit": editIndex})
+			return
+		}
+	}
+	if strings.TrimSpace(content) == "" {
+		server.writeError(responseWriter, http.StatusBadRequest, "VALIDATION_ERROR", "A study guide cannot be empty", nil)
+		return
+	}
+
+	markdownReconstructor := markdown.NewReconstructor()
+	markdownReconstructor.Language = languageCode.String
+	_, citations := markdownReconstructor.ParseCitations(content)
+	citationKeys := make([]string, len(citations))
+	for index, citation := range citations {
+		citationKeys[index] = citationKey(citation)
+	}
+	_, previousCitations := markdownReconstructor.ParseCitations(tool.Content)
+	previousCitationKeys := make(map[string]bool)
+	for _, citation := range previousCitations {
+		previousCitationKeys[citationKey(citation)] = true
+	}
+
+	problems, err := server.validateGuideCitations(updateRequest.ExamID, lectureID.String, content, citations, previousCitationKeys)
+	if err != nil {
+		server.writeError(responseWriter, http.StatusInternalServerError, "DATABASE_ERROR", "Failed to check citations", nil)
+		return
+	}
+	if len(problems) > 0 {
+		server.writeError(responseWriter, http.StatusBadRequest, "INVALID_CITATIONS", "Some citations do not point at the lecture's documents", map[string]any{"citations": problems})
+		return
+	}
+
+	// The references of citations left as they were keep what the build worked out for them
+	previousReferences := make(map[string]toolSourceReference)
+	rows, err := server.database.Query("SELECT source_id, metadata FROM tool_source_references WHERE tool_id = ? AND source_type = 'document'", updateRequest.ToolID)
+	if err != nil {
+		server.writeError(responseWriter, http.StatusInternalServerError, "DATABASE_ERROR", "Failed to get tool source references", nil)
+		return
+	}
+	referencesByNumber := make(map[int]toolSourceReference)
+	for rows.Next() {
+		var sourceID string
+		var metadataJSON sql.NullString
+		if rows.Scan(&sourceID, &metadataJSON) != nil {
+			continue
+		}
+		var metadata map[string]any
+		if json.Unmarshal([]byte(metadataJSON.String), &metadata) != nil {
+			continue
+		}
+		if footnoteNumber, isNumber := metadata["footnote_number"].(float64); isNumber {
+			referencesByNumber[int(footnoteNumber)] = toolSourceReference{sourceID, metadata}
+		}
+	}
+	rows.Close()
+	for _, citation := range previousCitations {
+		if reference, found := referencesByNumber[citation.Number]; found {
+			previousReferences[citationKey(citation)] = reference
+		}
+	}
+
+	updatedAt := time.Now()
+	transaction, err := server.database.Begin()
+	if err != nil {
+		server.writeError(responseWriter, http.StatusInternalServerError, "DATABASE_ERROR", "Failed to update tool", nil)
+		return
+	}
+	defer transaction.Rollback()
+
+	// The version is kept with the edit, so a failed edit leaves no version or undo behind
+	versionID, err := database.SaveToolVersion(transaction, updateRequest.ToolID, models.ResourceActionEdited)
+	if err != nil {
+		server.writeError(responseWriter, http.StatusInternalServerError, "DATABASE_ERROR", "Failed to keep the previous version of the tool", nil)
+		return
+	}
+
+	if _, err := transaction.Exec("UPDATE tools SET content = ?, updated_at = ? WHERE id = ?", content, updatedAt, updateRequest.ToolID); err != nil {
+		server.writeError(responseWriter, http.StatusInternalServerError, "DATABASE_ERROR", "Failed to update tool", nil)
+		return
+	}
+	if _, err := transaction.Exec("DELETE FROM tool_source_references WHERE tool_id = ? AND source_type = 'document'", updateRequest.ToolID); err != nil {
+		server.writeError(responseWriter, http.StatusInternalServerError, "DATABASE_ERROR", "Failed to update tool source references", nil)
+		return
+	}
+	for index, citation := range citations {
+		sourceID := citation.File
+		metadata := map[string]any{"description": citation.Description, "pages": citation.Pages}
+		if reference, found := previousReferences[citationKeys[index]]; found {
+			sourceID, metadata = reference.sourceID, reference.metadata
+		}
+		metadata["footnote_number"] = citation.Number
+		metadataJSON, _ := json.Marshal(metadata)
+		if _, err := transaction.Exec(`
+			INSERT INTO tool_source_references (tool_id, source_type, source_id, metadata) VALUES (?, 'document', ?, ?)
+		`, updateRequest.ToolID, sourceID, string(metadataJSON)); err != nil {
+			server.writeError(responseWriter, http.StatusInternalServerError, "DATABASE_ERROR", "Failed to update tool source references", nil)
+			return
+		}
+	}
+	purgedExportKeys, err := database.InvalidateToolContent(transaction, updateRequest.ToolID)
+	if err != nil {
+		server.writeError(responseWriter, http.StatusInternalServerError, "DATABASE_ERROR", "Failed to update tool", nil)
+		return
+	}
+	if err := transaction.Commit(); err != nil {
+		server.writeError(responseWriter, http.StatusInternalServerError, "DATABASE_ERROR", "Failed to update tool", nil)
+		return
+	}
+	server.deleteStoredObjects(purgedExportKeys)
+
+	if versionID != "" {
+		server.recordEvent(request, models.ResourceEvent{
+			ExamID:       updateRequest.ExamID,
+			LectureID:    lectureID.String,
+			ResourceType: models.ResourceTypeTool,
+			ResourceID:   updateRequest.ToolID,
+			Action:       models.ResourceActionEdited,
+			Summary:      fmt.Sprintf("Edited the %s \"%s\"", tool.Type, tool.Title),
+			VersionID:    versionID,
+			Undo:         restoreToolVersionUndo(updateRequest.ExamID, versionID, "Put back the content before this edit"),
+		})
+	}
+
+	server.writeJSON(responseWriter, http.StatusOK, map[string]any{
+		"tool_id":    updateRequest.ToolID,
+		"content":    content,
+		"citations":  len(citations),
+		"version_id": versionID,
+		"updated_at": updatedAt,
+	})
+}
+
+// validateGuideCitations checks that the citations of a study guide are well formed and cite pages of documents
+// of its lecture, or of its exam for a guide of no lecture. Citations the guide already had, identified by
+// their citationKey, are not checked again. Cited filenames are corrected to the documents they resolve to, as
+// they are when the guide is built
+func (server *Server) validateGuideCitations(examID string, lectureID string, content string, citations []markdown.ParsedCitation, previousCitationKeys map[string]bool) ([]citationProblem, error) {
+	problems := []citationProblem{}
+	if strings.Count(content, "{{{") != strings.Count(content, "}}}") {
+		problems = append(problems, citationProblem{Problem: "a citation is not closed with }}}"})
+	}
+	if len(citations) == 0 {
+		return problems, nil
+	}
+
+	rows, err := server.database.Query(`
+		SELECT title, original_filename, page_count FROM reference_documents
+		WHERE lecture_id IN (SELECT id FROM lectures WHERE exam_id = ? AND (? = '' OR id = ?))
+	`, examID, lectureID, lectureID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	pageCounts := make(map[string]int)
+	var documentNames []string
+	for rows.Next() {
+		var title string
+		var originalFilename sql.NullString
+		var pageCount int
+		if err := rows.Scan(&title, &originalFilename, &pageCount); err != nil {
+			return nil, err
+		}
+		pageCounts[title] = pageCount
+		documentNames = append(documentNames, title)
+		if originalFilename.String != "" && originalFilename.String != title {
+			pageCounts[originalFilename.String] = pageCount
+			documentNames = append(documentNames, originalFilename.String)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	alreadyCited := make([]bool, len(citations))
+	for index, citation := range citations {
+		alreadyCited[index] = previousCitationKeys[citationKey(citation)]
+	}
+	markdown.CorrectCitationFilenames(citations, documentNames)
+	for index, citation := range citations {
+		if alreadyCited[index] {
+			continue
+		}
+		pageCount, found := pageCounts[citation.File]
+		if !found {
+			problems = append(problems, citationProblem{Number: citation.Number, File: citation.File, Pages: citation.Pages, Problem: "cites no document of the lecture"})
+			continue
+		}
+		for _, page := range citation.Pages {
+			if page < 1 || page > pageCount {
+				problems = append(problems, citationProblem{Number: citation.Number, File: citation.File, Pages: citation.Pages, Problem: fmt.Sprintf("cites page %d of a document of %d pages", page, pageCount)})
+				break
+			}
+		}
+	}
+	return problems, nil
+}
+
+// citationKey identifies a citation by what it cites as written, so a citation moved by an edit is still
+// recognized
+func citationKey(citation markdown.ParsedCitation) string {
+	return fmt.Sprintf("%s\x00%s\x00%v", citation.Description, citation.File, citation.Pages)
+}
@@ -0,0 +1,97 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestUpdateToolContent(t *testing.T) {
+	server, userID, sessionID, cleanup := setupUniqueExtraTestEnv(t, "tool_content")
+	defer cleanup()
+
+	guide := "# Optics\n\n## 1. Lenses\n\nLenses bend light {{{Refraction-slides.pdf-p2}}}.\n\n## 2. Mirrors\n\nMirrors reflect light.\n"
+	server.database.Exec("INSERT INTO exams (id, user_id, title) VALUES ('content-exam', ?, 'Physics')", userID)
+	server.database.Exec("INSERT INTO lectures (id, exam_id, title, status) VALUES ('content-lecture', 'content-exam', 'Optics', 'ready')")
+	server.database.Exec("INSERT INTO reference_documents (id, lecture_id, document_type, title, file_path, page_count) VALUES ('content-document', 'content-lecture', 'pdf', 'slides.pdf', '/tmp/slides.pdf', 3)")
+	server.database.Exec("INSERT INTO tools (id, exam_id, lecture_id, type, title, content) VALUES ('content-guide', 'content-exam', 'content-lecture', 'guide', 'Optics guide', ?)", guide)
+	server.database.Exec(`INSERT INTO tool_source_references (tool_id, source_type, source_id, metadata) VALUES ('content-guide', 'document', 'slides.pdf', '{"footnote_number": 1, "description": "Snell law of refraction", "pages": [2]}')`)
+	server.database.Exec("INSERT INTO tool_sections (job_id, tool_id, exam_id, lecture_id, level, title, content) VALUES ('content-build', 'content-guide', 'content-exam', 'content-lecture', 1, 'Optics', '# Optics')")
+	server.database.Exec(`INSERT INTO jobs (id, user_id, course_id, type, status, payload, export_data) VALUES ('guide-export', ?, 'content-exam', 'PUBLISH_MATERIAL', 'COMPLETED', '{"tool_id": "content-guide"}', 'pdf'), ('other-export', ?, 'content-exam', 'PUBLISH_MATERIAL', 'COMPLETED', '{"tool_id": "other-guide"}', 'pdf')`, userID, userID)
+
+	updateContent := func(body map[string]any) *httptest.ResponseRecorder {
+		body["exam_id"], body["tool_id"] = "content-exam", "content-guide"
+		bodyBytes, _ := json.Marshal(body)
+		req := httptest.NewRequest("PATCH", "/api/tools/content", bytes.NewReader(bodyBytes))
+		req.Header.Set("Authorization", "Bearer "+sessionID)
+		req.Header.Set("X-Requested-With", "XMLHttpRequest")
+		req.Header.Set("Content-Type", "application/json")
+		rr := httptest.NewRecorder()
+		server.Handler().ServeHTTP(rr, req)
+		return rr
+	}
+	storedContent := func() string {
+		var content string
+		server.database.QueryRow("SELECT content FROM tools WHERE id = 'content-guide'").Scan(&content)
+		return content
+	}
+
+	t.Run("Sections are edited by heading path", func(t *testing.T) {
+		rr := updateContent(map[string]any{"edits": []map[string]any{
+			{"heading_path": []string{"Mirrors"}, "content": "Mirrors reflect light {{{Reflection-slides.pdf-p3}}}.", "title": "2. Plane mirrors"},
+		}})
+		if rr.Code != http.StatusOK {
+			t.Fatalf("Expected status 200 editing a section, got %d: %s", rr.Code, rr.Body.String())
+		}
+		expected := "# Optics\n\n## 1. Lenses\n\nLenses bend light {{{Refraction-slides.pdf-p2}}}.\n\n## 2. Plane mirrors\n\nMirrors reflect light {{{Reflection-slides.pdf-p3}}}.\n"
+		if content := storedContent(); content != expected {
+			t.Errorf("Expected the section replaced and renamed, got %q", content)
+		}
+
+		var improvedDescription string
+		server.database.QueryRow("SELECT json_extract(metadata, '$.description') FROM tool_source_references WHERE tool_id = 'content-guide' AND json_extract(metadata, '$.footnote_number') = 1").Scan(&improvedDescription)
+		if improvedDescription != "Snell law of refraction" {
+			t.Errorf("Expected the untouched citation to keep its improved description, got %q", improvedDescription)
+		}
+		var references int
+		server.database.QueryRow("SELECT COUNT(*) FROM tool_source_references WHERE tool_id = 'content-guide'").Scan(&references)
+		if references != 2 {
+			t.Errorf("Expected a reference per citation, got %d", references)
+		}
+		var versions int
+		server.database.QueryRow("SELECT COUNT(*) FROM tool_versions WHERE tool_id = 'content-guide' AND reason = 'edited'").Scan(&versions)
+		if versions != 1 {
+			t.Errorf("Expected the replaced content kept as a version, got %d", versions)
+		}
+
+		// What was derived from the previous content is dropped, and only for this tool
+		var sections, guideExports, otherExports int
+		server.database.QueryRow("SELECT COUNT(*) FROM tool_sections WHERE tool_id = 'content-guide'").Scan(&sections)
+		server.database.QueryRow("SELECT COUNT(*) FROM jobs WHERE id = 'guide-export' AND export_data IS NOT NULL").Scan(&guideExports)
+		server.database.QueryRow("SELECT COUNT(*) FROM jobs WHERE id = 'other-export' AND export_data IS NOT NULL").Scan(&otherExports)
+		if sections != 0 || guideExports != 0 || otherExports != 1 {
+			t.Errorf("Expected the sections and exports of the edited guide dropped, got %d sections, %d and %d exports", sections, guideExports, otherExports)
+		}
+	})
+
+	t.Run("Invalid edits are refused", func(t *testing.T) {
+		before := storedContent()
+		if rr := updateContent(map[string]any{"edits": []map[string]any{{"heading_path": []string{"Prisms"}, "content": "Prisms split light."}}}); rr.Code != http.StatusBadRequest {
+			t.Errorf("Expected status 400 for an unknown heading, got %d", rr.Code)
+		}
+		rr := updateContent(map[string]any{"content": "# Optics\n\nLight bends {{{Refraction-slides.pdf-p9}}} and {{{Waves-notes.pdf-p1}}}.\n"})
+		if rr.Code != http.StatusBadRequest || !strings.Contains(rr.Body.String(), "INVALID_CITATIONS") ||
+			!strings.Contains(rr.Body.String(), "cites page 9 of a document of 3 pages") || !strings.Contains(rr.Body.String(), "cites no document of the lecture") {
+			t.Errorf("Expected both citations refused, got %d: %s", rr.Code, rr.Body.String())
+		}
+		if rr := updateContent(map[string]any{"content": "# Optics", "edits": []map[string]any{{"heading_path": []string{"Optics"}}}}); rr.Code != http.StatusBadRequest {
+			t.Errorf("Expected status 400 for content along with edits, got %d", rr.Code)
+		}
+		if content := storedContent(); content != before {
+			t.Errorf("Expected refused edits to leave the guide unchanged, got %q", content)
+		}
+	})
+}
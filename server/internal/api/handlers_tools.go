@@ -2,17 +2,25 @@ package api
 
 import (
 	"database/sql"
+	"encoding/base64"
 	"encoding/json"
 	"fmt"
+	"log/slog"
 	"net/http"
 	"net/url"
+	"os"
 	"path/filepath"
 	"regexp"
+	"sort"
 	"strings"
 	"time"
 
+	"lectures/internal/database"
+	"lectures/internal/jobs"
 	"lectures/internal/markdown"
 	"lectures/internal/models"
+	"lectures/internal/storage"
+	"lectures/internal/tools"
 )
 
 // BCP-47 Regex (basic validation)
@@ -23,55 +31,140 @@ func (server *Server) handleCreateTool(responseWriter http.ResponseWriter, reque
 	var createToolRequest struct {
 		ExamID                  string `json:"exam_id"`
 		LectureID               string `json:"lecture_id"`
-		Type                    string `json:"type"` // "guide", "flashcard", "quiz"
+		Type                    string `json:"type"` // "guide", "flashcard", "quiz", "mindmap", or "course_overview" or "mock_exam" of the whole exam
 		Length                  string `json:"length"`
 		LanguageCode            string `json:"language_code"`
 		EnableDocumentsMatching *bool  `json:"enable_documents_matching"`
-		AdherenceThreshold      int    `json:"adherence_threshold"`
-		MaximumRetries          int    `json:"maximum_retries"`
+		AdherenceThreshold      *int   `json:"adherence_threshold"`
+		MaximumRetries          *int   `json:"maximum_retries"`
+		GenerateImages          bool   `json:"generate_images"`       // Flashcards only: add mnemonic images to selected cards
+		ResumeJobID             string `json:"resume_job_id"`         // Guides only: failed build whose accepted sections are reused
+		AllowPartialSources     bool   `json:"allow_partial_sources"` // Generate from the finished sources of a lecture that is not ready
+		Source                  string `json:"source"`                // "lecture" (default), or flashcards only: "bookmarks" of the caller
+		PresetID                string `json:"preset_id"`             // Generation preset of the caller whose settings apply under those of the request
 		// Models
 		ModelDocumentsMatching string `json:"model_documents_matching"`
 		ModelStructure         string `json:"model_structure"`
 		ModelGeneration        string `json:"model_generation"`
 		ModelAdherence         string `json:"model_adherence"`
 		ModelPolishing         string `json:"model_polishing"`
+		// Sampling parameters of every model call of the generation, a seed making it reproducible
+		Sampling models.SamplingParameters `json:"sampling"`
+		// Mock exams only: the lectures, questions, duration and points of the exam
+		MockExam models.MockExamSettings `json:"mock_exam"`
 	}
 
 	if err := json.NewDecoder(request.Body).Decode(&createToolRequest); err != nil {
 		server.writeError(responseWriter, http.StatusBadRequest, "VALIDATION_ERROR", "Invalid request body", nil)
 		return
 	}
+	if err := createToolRequest.Sampling.Validate(); err != nil {
+		server.writeError(responseWriter, http.StatusBadRequest, "VALIDATION_ERROR", err.Error(), nil)
+		return
+	}
 
-	if createToolRequest.ExamID == "" || createToolRequest.LectureID == "" {
+	// Course overviews and mock exams are built from the ready lectures of the exam rather than from one lecture
+	examWide := models.ToolCoversExam(createToolRequest.Type)
+	if examWide {
+		if createToolRequest.ExamID == "" {
+			server.writeError(responseWriter, http.StatusBadRequest, "VALIDATION_ERROR", "exam_id is required", nil)
+			return
+		}
+		if createToolRequest.LectureID != "" {
+			server.writeError(responseWriter, http.StatusBadRequest, "VALIDATION_ERROR", "A "+strings.ReplaceAll(createToolRequest.Type, "_", " ")+" covers the whole exam; lecture_id must be omitted", nil)
+			return
+		}
+	} else if createToolRequest.ExamID == "" || createToolRequest.LectureID == "" {
 		server.writeError(responseWriter, http.StatusBadRequest, "VALIDATION_ERROR", "exam_id and lecture_id are required", nil)
 		return
 	}
+	if createToolRequest.Type == models.ToolTypeMockExam {
+		if err := createToolRequest.MockExam.Normalize(); err != nil {
+			server.writeError(responseWriter, http.StatusBadRequest, "VALIDATION_ERROR", err.Error(), nil)
+			return
+		}
+	}
 
 	// Verify exam and lecture exist
 	var lecture models.Lecture
-	err := server.database.QueryRow("SELECT id, status FROM lectures WHERE id = ? AND exam_id = ?", createToolRequest.LectureID, createToolRequest.ExamID).Scan(&lecture.ID, &lecture.Status)
-	if err != nil {
+	var err error
+	if examWide {
+		if !server.checkExamWideLectures(responseWriter, createToolRequest.ExamID, createToolRequest.MockExam.LectureIDs) {
+			return
+		}
+	} else if err = server.database.QueryRow("SELECT id, status FROM lectures WHERE id = ? AND exam_id = ?", createToolRequest.LectureID, createToolRequest.ExamID).Scan(&lecture.ID, &lecture.Status); err != nil {
 		server.writeError(responseWriter, http.StatusNotFound, "NOT_FOUND", "Lecture not found in this exam", nil)
 		return
 	}
 
-	if lecture.Status != "ready" {
-		server.writeError(responseWriter, http.StatusConflict, "LECTURE_NOT_READY", fmt.Sprintf("Lecture is currently in status: %s. Please wait for processing to complete.", lecture.Status), nil)
-		return
+	if !examWide && lecture.Status != "ready" {
+		if !createToolRequest.AllowPartialSources {
+			server.writeError(responseWriter, http.StatusConflict, "LECTURE_NOT_READY", fmt.Sprintf("Lecture is currently in status: %s. Please wait for processing to complete.", lecture.Status), nil)
+			return
+		}
+		// Slides may have arrived while the recording failed, or the other way around: what has finished is used
+		sources, err := database.GetLectureSources(server.database, createToolRequest.LectureID)
+		if err != nil {
+			server.writeError(responseWriter, http.StatusInternalServerError, "DATABASE_ERROR", "Failed to check lecture sources", nil)
+			return
+		}
+		if !sources.HasUsableSource() {
+			server.writeError(responseWriter, http.StatusConflict, "LECTURE_NOT_READY", "Neither the recording nor any document of this lecture has finished processing.", nil)
+			return
+		}
+	}
+
+	// Omitted values fall back to the preset, then to the exam's defaults, then to the global configuration
+	examDefaults, err := database.GetExamGenerationDefaults(server.database, createToolRequest.ExamID)
+	if err != nil {
+		slog.Warn("Failed to load exam generation defaults", "examID", createToolRequest.ExamID, "error", err)
+	}
+	if createToolRequest.PresetID != "" {
+		preset, err := database.GetGenerationPreset(server.database, server.getUserID(request), createToolRequest.PresetID)
+		if err == sql.ErrNoRows {
+			server.writeError(responseWriter, http.StatusNotFound, "NOT_FOUND", "Generation preset not found", nil)
+			return
+		}
+		if err != nil {
+			server.writeError(responseWriter, http.StatusInternalServerError, "DATABASE_ERROR", "Failed to get generation preset", nil)
+			return
+		}
+		examDefaults = preset.Settings.Over(examDefaults)
+		createToolRequest.Sampling = preset.Sampling.Merge(createToolRequest.Sampling)
 	}
 
 	// Default values
 	if createToolRequest.Type == "" {
 		createToolRequest.Type = "guide"
 	}
+	if createToolRequest.Length == "" {
+		createToolRequest.Length = examDefaults.Length
+	}
 	if createToolRequest.Length == "" {
 		createToolRequest.Length = "medium"
 	}
+	if createToolRequest.LanguageCode == "" {
+		createToolRequest.LanguageCode = examDefaults.LanguageCode
+	}
 	if createToolRequest.LanguageCode == "" {
 		createToolRequest.LanguageCode = server.configuration.LLM.Language
 	}
+	if createToolRequest.AdherenceThreshold == nil {
+		createToolRequest.AdherenceThreshold = examDefaults.AdherenceThreshold
+	}
+	if createToolRequest.MaximumRetries == nil {
+		createToolRequest.MaximumRetries = examDefaults.MaximumRetries
+	}
+	createToolRequest.ModelDocumentsMatching = firstNonEmpty(createToolRequest.ModelDocumentsMatching, examDefaults.ModelDocumentsMatching)
+	createToolRequest.ModelStructure = firstNonEmpty(createToolRequest.ModelStructure, examDefaults.ModelStructure)
+	createToolRequest.ModelGeneration = firstNonEmpty(createToolRequest.ModelGeneration, examDefaults.ModelGeneration)
+	createToolRequest.ModelAdherence = firstNonEmpty(createToolRequest.ModelAdherence, examDefaults.ModelAdherence)
+	createToolRequest.ModelPolishing = firstNonEmpty(createToolRequest.ModelPolishing, examDefaults.ModelPolishing)
 
 	enableMatching := server.configuration.LLM.EnableDocumentsMatching
+	if examDefaults.EnableDocumentsMatching != nil {
+		enableMatching = *examDefaults.EnableDocumentsMatching
+	}
 	if createToolRequest.EnableDocumentsMatching != nil {
 		enableMatching = *createToolRequest.EnableDocumentsMatching
 	}
@@ -82,35 +175,101 @@ func (server *Server) handleCreateTool(responseWriter http.ResponseWriter, reque
 		return
 	}
 
+	if createToolRequest.GenerateImages && createToolRequest.Type != "flashcard" {
+		server.writeError(responseWriter, http.StatusBadRequest, "VALIDATION_ERROR", "generate_images is only supported for flashcards", nil)
+		return
+	}
+	if createToolRequest.GenerateImages && (server.toolGenerator == nil || !server.toolGenerator.ImageGenerationAvailable()) {
+		server.writeError(responseWriter, http.StatusBadRequest, "VALIDATION_ERROR", "Image generation is not configured on this server", nil)
+		return
+	}
+
 	userID := server.getUserID(request)
 
+	if !server.authorizeExam(responseWriter, request, createToolRequest.ExamID, models.ExamRoleGenerator) {
+		return
+	}
+
+	if createToolRequest.ResumeJobID != "" && !server.validateResumeJob(responseWriter, createToolRequest.ResumeJobID, createToolRequest.Type, createToolRequest.LectureID) {
+		return
+	}
+
+	switch createToolRequest.Source {
+	case "", toolSourceLecture:
+		createToolRequest.Source = toolSourceLecture
+	case toolSourceBookmarks:
+		if !server.validateBookmarkSource(responseWriter, createToolRequest.Type, createToolRequest.LectureID, userID) {
+			return
+		}
+	default:
+		server.writeError(responseWriter, http.StatusBadRequest, "VALIDATION_ERROR", "source must be lecture or bookmarks", nil)
+		return
+	}
+
+	// A deck generated from bookmarks replaces only the previous deck of the same user's bookmarks
+	var bookmarkUserID string
+	if createToolRequest.Source == toolSourceBookmarks {
+		bookmarkUserID = userID
+	}
+
+	// Files of the replaced tool are removed along with its row
+	var replacedToolIDs []string
+	replacedRows, err := server.database.Query(`
+		SELECT id FROM tools
+		WHERE COALESCE(lecture_id, '') = ? AND type = ? AND exam_id = ? AND COALESCE(bookmark_user_id, '') = ?
+	`, createToolRequest.LectureID, createToolRequest.Type, createToolRequest.ExamID, bookmarkUserID)
+	if err == nil {
+		for replacedRows.Next() {
+			var replacedToolID string
+			if replacedRows.Scan(&replacedToolID) == nil {
+				replacedToolIDs = append(replacedToolIDs, replacedToolID)
+			}
+		}
+		replacedRows.Close()
+	}
+
+	// The replaced tool is kept as a version, which the job links from the history once the new one is stored
+	var replacedVersionID string
+	for _, replacedToolID := range replacedToolIDs {
+		replacedVersionID = server.saveToolVersion(replacedToolID, models.ResourceActionRegenerated)
+	}
+
 	// Enforce "one of each type" by deleting existing tool of the same type
 	_, _ = server.database.Exec(`
 		DELETE FROM tools 
-		WHERE lecture_id = ? AND type = ? AND EXISTS (
-			SELECT 1 FROM exams WHERE id = ? AND user_id = ?
-		)
-	`, createToolRequest.LectureID, createToolRequest.Type, createToolRequest.ExamID, userID)
+		WHERE COALESCE(lecture_id, '') = ? AND type = ? AND exam_id = ? AND COALESCE(bookmark_user_id, '') = ?
+	`, createToolRequest.LectureID, createToolRequest.Type, createToolRequest.ExamID, bookmarkUserID)
+	for _, replacedToolID := range replacedToolIDs {
+		server.removeToolFiles(replacedToolID)
+	}
 
 	// Enqueue job
-	jobIdentifier, err := server.jobQueue.Enqueue(userID, models.JobTypeBuildMaterial, map[string]string{
+	jobIdentifier, err := server.jobQueue.Enqueue(userID, models.JobTypeBuildMaterial, map[string]any{
 		"exam_id":                   createToolRequest.ExamID,
 		"lecture_id":                createToolRequest.LectureID,
 		"type":                      createToolRequest.Type,
 		"length":                    createToolRequest.Length,
 		"language_code":             createToolRequest.LanguageCode,
 		"enable_documents_matching": fmt.Sprintf("%v", enableMatching),
-		"adherence_threshold":       fmt.Sprintf("%d", createToolRequest.AdherenceThreshold),
-		"maximum_retries":           fmt.Sprintf("%d", createToolRequest.MaximumRetries),
+		"adherence_threshold":       formatOptionalInt(createToolRequest.AdherenceThreshold),
+		"maximum_retries":           formatOptionalInt(createToolRequest.MaximumRetries),
 		"model_documents_matching":  createToolRequest.ModelDocumentsMatching,
 		"model_structure":           createToolRequest.ModelStructure,
 		"model_generation":          createToolRequest.ModelGeneration,
 		"model_adherence":           createToolRequest.ModelAdherence,
 		"model_polishing":           createToolRequest.ModelPolishing,
+		"generate_images":           fmt.Sprintf("%v", createToolRequest.GenerateImages),
+		"resume_job_id":             createToolRequest.ResumeJobID,
+		"allow_partial_sources":     fmt.Sprintf("%v", createToolRequest.AllowPartialSources),
+		"source":                    createToolRequest.Source,
+		"replaced_version_id":       replacedVersionID,
+		"sampling":                  createToolRequest.Sampling,
+		"preset_id":                 createToolRequest.PresetID,
+		"mock_exam":                 createToolRequest.MockExam,
 	}, createToolRequest.ExamID, createToolRequest.LectureID)
 
 	if err != nil {
-		server.writeError(responseWriter, http.StatusInternalServerError, "BACKGROUND_JOB_ERROR", "Failed to create generation job", nil)
+		server.writeEnqueueError(responseWriter, err, "Failed to create generation job")
 		return
 	}
 
@@ -120,7 +279,66 @@ func (server *Server) handleCreateTool(responseWriter http.ResponseWriter, reque
 	})
 }
 
-// handleListTools lists all tools for an exam or lecture (must belong to the user)
+// checkExamWideLectures checks that a tool built from the lectures of an exam has at least one ready lecture to
+// draw from: one of lectureIDs when given, each of which must belong to the exam
+func (server *Server) checkExamWideLectures(responseWriter http.ResponseWriter, examID string, lectureIDs []string) bool {
+	readyLectures := 0
+	if len(lectureIDs) == 0 {
+		if err := server.database.QueryRow("SELECT COUNT(*) FROM lectures WHERE exam_id = ? AND status = 'ready'", examID).Scan(&readyLectures); err != nil {
+			server.writeError(responseWriter, http.StatusInternalServerError, "DATABASE_ERROR", "Failed to check the lectures of the exam", nil)
+			return false
+		}
+	}
+	for _, lectureID := range lectureIDs {
+		var status string
+		if err := server.database.QueryRow("SELECT status FROM lectures WHERE id = ? AND exam_id = ?", lectureID, examID).Scan(&status); err != nil {
+			server.writeError(responseWriter, http.StatusNotFound, "NOT_FOUND", fmt.Sprintf("Lecture %s not found in this exam", lectureID), nil)
+			return false
+		}
+		if status == "ready" {
+			readyLectures++
+		}
+	}
+	if readyLectures == 0 {
+		server.writeError(responseWriter, http.StatusConflict, "LECTURE_NOT_READY", "No lecture of this exam has finished processing.", nil)
+		return false
+	}
+	return true
+}
+
+// validateResumeJob checks that the accepted sections of resumeJobID can be reused by a new guide of the lecture:
+// it must be a guide build of that lecture that failed or was cancelled, so the sections of a running build are
+// never taken over
+func (server *Server) validateResumeJob(responseWriter http.ResponseWriter, resumeJobID string, toolType string, lectureID string) bool {
+	if toolType != "guide" {
+		server.writeError(responseWriter, http.StatusBadRequest, "VALIDATION_ERROR", "resume_job_id is only supported for guides", nil)
+		return false
+	}
+
+	var jobType, jobStatus string
+	var jobLectureID sql.NullString
+	err := server.database.QueryRow("SELECT type, status, lecture_id FROM jobs WHERE id = ?", resumeJobID).Scan(&jobType, &jobStatus, &jobLectureID)
+	if err != nil || jobType != models.JobTypeBuildMaterial || jobLectureID.String != lectureID {
+		server.writeError(responseWriter, http.StatusBadRequest, "VALIDATION_ERROR", "resume_job_id is not a build of this lecture", nil)
+		return false
+	}
+	if jobStatus != models.JobStatusFailed && jobStatus != models.JobStatusCancelled {
+		server.writeError(responseWriter, http.StatusConflict, "JOB_NOT_RESUMABLE", fmt.Sprintf("Only failed or cancelled builds can be resumed, this one is %s", jobStatus), nil)
+		return false
+	}
+	return true
+}
+
+// toolSortColumns are the fields tools may be listed by
+var toolSortColumns = map[string]string{
+	"created_at": "tools.created_at",
+	"updated_at": "tools.updated_at",
+	"title":      "LOWER(tools.title)",
+	"type":       "tools.type",
+}
+
+// handleListTools lists the tools of an exam or lecture (must belong to the user), newest first unless sorted
+// otherwise, filtered by types, language and creation time
 func (server *Server) handleListTools(responseWriter http.ResponseWriter, request *http.Request) {
 	examID := request.URL.Query().Get("exam_id")
 	lectureID := request.URL.Query().Get("lecture_id")
@@ -131,15 +349,34 @@ func (server *Server) handleListTools(responseWriter http.ResponseWriter, reques
 	}
 
 	userID := server.getUserID(request)
-	toolType := request.URL.Query().Get("type")
+	createdAfter, err := parseTimeParameter(request, "created_after")
+	if err != nil {
+		server.writeError(responseWriter, http.StatusBadRequest, "VALIDATION_ERROR", err.Error(), nil)
+		return
+	}
+	createdBefore, err := parseTimeParameter(request, "created_before")
+	if err != nil {
+		server.writeError(responseWriter, http.StatusBadRequest, "VALIDATION_ERROR", err.Error(), nil)
+		return
+	}
+	sortColumn, descending, err := parseListSort(request, toolSortColumns, "created_at:desc")
+	if err != nil {
+		server.writeError(responseWriter, http.StatusBadRequest, "VALIDATION_ERROR", err.Error(), nil)
+		return
+	}
+	page, err := parseListPage(request, sortColumn, "tools.id", descending)
+	if err != nil {
+		server.writeError(responseWriter, http.StatusBadRequest, "VALIDATION_ERROR", err.Error(), nil)
+		return
+	}
 
 	query := `
-		SELECT tools.id, tools.exam_id, tools.lecture_id, tools.type, tools.title, tools.language_code, tools.estimated_cost, tools.created_at, tools.updated_at
+		SELECT tools.id, tools.exam_id, tools.lecture_id, tools.type, tools.title, tools.language_code, tools.estimated_cost, COALESCE(tools.partial_sources, 0), COALESCE(tools.bookmark_user_id, ''), tools.redaction_pending, tools.created_at, tools.updated_at, ` + page.sortValue() + `
 		FROM tools
 		JOIN exams ON tools.exam_id = exams.id
-		WHERE exams.user_id = ?
+		WHERE ` + examAccess(models.ExamRoleViewer) + ` AND ` + ownBookmarkDecks + `
 	`
-	arguments := []any{userID}
+	arguments := []any{userID, userID}
 
 	if examID != "" {
 		query += " AND tools.exam_id = ?"
@@ -150,12 +387,27 @@ func (server *Server) handleListTools(responseWriter http.ResponseWriter, reques
 		arguments = append(arguments, lectureID)
 	}
 
-	if toolType != "" {
-		query += " AND tools.type = ?"
-		arguments = append(arguments, toolType)
+	if toolTypes := listParameter(request, "type"); len(toolTypes) > 0 {
+		condition, conditionArguments := anyOf("tools.type", toolTypes)
+		query += condition
+		arguments = append(arguments, conditionArguments...)
+	}
+	if languageCode := request.URL.Query().Get("language"); languageCode != "" {
+		query += " AND tools.language_code = ?"
+		arguments = append(arguments, languageCode)
+	}
+	if !createdAfter.IsZero() {
+		query += " AND tools.created_at >= ?"
+		arguments = append(arguments, createdAfter)
+	}
+	if !createdBefore.IsZero() {
+		query += " AND tools.created_at < ?"
+		arguments = append(arguments, createdBefore)
 	}
 
-	query += " ORDER BY tools.created_at DESC"
+	condition, conditionArgs := page.condition()
+	query += condition + page.orderBy()
+	arguments = append(arguments, conditionArgs...)
 
 	toolRows, databaseError := server.database.Query(query, arguments...)
 	if databaseError != nil {
@@ -165,19 +417,23 @@ func (server *Server) handleListTools(responseWriter http.ResponseWriter, reques
 	defer toolRows.Close()
 
 	var toolsList = []models.Tool{}
+	var cursors []pageCursor
 	for toolRows.Next() {
 		var tool models.Tool
 		var lID sql.NullString
-		if err := toolRows.Scan(&tool.ID, &tool.ExamID, &lID, &tool.Type, &tool.Title, &tool.LanguageCode, &tool.EstimatedCost, &tool.CreatedAt, &tool.UpdatedAt); err != nil {
+		var sortValue string
+		if err := toolRows.Scan(&tool.ID, &tool.ExamID, &lID, &tool.Type, &tool.Title, &tool.LanguageCode, &tool.EstimatedCost, &tool.PartialSources, &tool.BookmarkUserID, &tool.RedactionPending, &tool.CreatedAt, &tool.UpdatedAt, &sortValue); err != nil {
 			continue
 		}
 		if lID.Valid {
 			tool.LectureID = lID.String
 		}
 		toolsList = append(toolsList, tool)
+		cursors = append(cursors, pageCursor{SortValue: sortValue, ID: tool.ID})
 	}
 
-	server.writeJSON(responseWriter, http.StatusOK, toolsList)
+	toolsList, nextCursor := paginate(page, toolsList, cursors)
+	server.writePage(responseWriter, http.StatusOK, toolsList, nextCursor)
 }
 
 // handleGetTool retrieves a specific tool
@@ -195,11 +451,11 @@ func (server *Server) handleGetTool(responseWriter http.ResponseWriter, request
 	var tool models.Tool
 	var lectureID sql.NullString
 	err := server.database.QueryRow(`
-		SELECT tools.id, tools.exam_id, tools.lecture_id, tools.type, tools.title, tools.language_code, tools.content, tools.estimated_cost, tools.created_at, tools.updated_at
+		SELECT tools.id, tools.exam_id, tools.lecture_id, tools.type, tools.title, tools.language_code, tools.content, tools.estimated_cost, COALESCE(tools.partial_sources, 0), COALESCE(tools.bookmark_user_id, ''), tools.redaction_pending, tools.created_at, tools.updated_at
 		FROM tools
 		JOIN exams ON tools.exam_id = exams.id
-		WHERE tools.id = ? AND tools.exam_id = ? AND exams.user_id = ?
-	`, toolID, examID, userID).Scan(&tool.ID, &tool.ExamID, &lectureID, &tool.Type, &tool.Title, &tool.LanguageCode, &tool.Content, &tool.EstimatedCost, &tool.CreatedAt, &tool.UpdatedAt)
+		WHERE tools.id = ? AND tools.exam_id = ? AND `+examAccess(models.ExamRoleViewer)+` AND `+ownBookmarkDecks+`
+	`, toolID, examID, userID, userID).Scan(&tool.ID, &tool.ExamID, &lectureID, &tool.Type, &tool.Title, &tool.LanguageCode, &tool.Content, &tool.EstimatedCost, &tool.PartialSources, &tool.BookmarkUserID, &tool.RedactionPending, &tool.CreatedAt, &tool.UpdatedAt)
 
 	if lectureID.Valid {
 		tool.LectureID = lectureID.String
@@ -217,6 +473,169 @@ func (server *Server) handleGetTool(responseWriter http.ResponseWriter, request
 	server.writeJSON(responseWriter, http.StatusOK, tool)
 }
 
+// handleGetToolSections lists the outline and sections a study guide was generated from, with the model, attempts,
+// adherence score and cost of each
+func (server *Server) handleGetToolSections(responseWriter http.ResponseWriter, request *http.Request) {
+	toolID := request.URL.Query().Get("tool_id")
+	examID := request.URL.Query().Get("exam_id")
+
+	if toolID == "" || examID == "" {
+		server.writeError(responseWriter, http.StatusBadRequest, "VALIDATION_ERROR", "tool_id and exam_id are required", nil)
+		return
+	}
+
+	userID := server.getUserID(request)
+
+	var exists bool
+	server.database.QueryRow(`
+		SELECT EXISTS(SELECT 1 FROM tools JOIN exams ON tools.exam_id = exams.id WHERE tools.id = ? AND tools.exam_id = ? AND `+examAccess(models.ExamRoleViewer)+`)
+	`, toolID, examID, userID).Scan(&exists)
+	if !exists {
+		server.writeError(responseWriter, http.StatusNotFound, "NOT_FOUND", "Tool not found in this exam", nil)
+		return
+	}
+
+	sectionRows, err := server.database.Query(`
+		SELECT id, job_id, tool_id, COALESCE(exam_id, ''), COALESCE(lecture_id, ''), COALESCE(parent_id, 0), level, position, title, COALESCE(coverage, ''), content,
+			COALESCE(model, ''), attempts, adherence_score, input_tokens, output_tokens, estimated_cost, created_at
+		FROM tool_sections
+		WHERE tool_id = ?
+		ORDER BY level ASC, position ASC, id ASC
+	`, toolID)
+	if err != nil {
+		server.writeError(responseWriter, http.StatusInternalServerError, "DATABASE_ERROR", "Failed to list tool sections", nil)
+		return
+	}
+	defer sectionRows.Close()
+
+	sections := []models.ToolSection{}
+	for sectionRows.Next() {
+		var section models.ToolSection
+		var adherenceScore sql.NullInt64
+		if err := sectionRows.Scan(&section.ID, &section.JobID, &section.ToolID, &section.ExamID, &section.LectureID, &section.ParentID, &section.Level, &section.Position,
+			&section.Title, &section.Coverage, &section.Content, &section.Model, &section.Attempts, &adherenceScore,
+			&section.InputTokens, &section.OutputTokens, &section.EstimatedCost, &section.CreatedAt); err != nil {
+			continue
+		}
+		if adherenceScore.Valid {
+			score := int(adherenceScore.Int64)
+			section.AdherenceScore = &score
+		}
+		sections = append(sections, section)
+	}
+
+	server.writeJSON(responseWriter, http.StatusOK, sections)
+}
+
+// handleRegenerateToolSection rebuilds one section of a study guide or course overview: the tool is kept as a version
+// and replaced by a build with the settings of the original one, which reuses the outline and the other sections
+// and only generates the requested section again
+func (server *Server) handleRegenerateToolSection(responseWriter http.ResponseWriter, request *http.Request) {
+	var regenerateRequest struct {
+		ExamID    string `json:"exam_id"`
+		ToolID    string `json:"tool_id"`
+		SectionID int64  `json:"section_id"`
+	}
+	if err := json.NewDecoder(request.Body).Decode(&regenerateRequest); err != nil {
+		server.writeError(responseWriter, http.StatusBadRequest, "VALIDATION_ERROR", "Invalid request body", nil)
+		return
+	}
+	if regenerateRequest.ExamID == "" || regenerateRequest.ToolID == "" || regenerateRequest.SectionID == 0 {
+		server.writeError(responseWriter, http.StatusBadRequest, "VALIDATION_ERROR", "exam_id, tool_id and section_id are required", nil)
+		return
+	}
+
+	userID := server.getUserID(request)
+	if !server.authorizeExam(responseWriter, request, regenerateRequest.ExamID, models.ExamRoleGenerator) {
+		return
+	}
+
+	var toolType, languageCode string
+	var lectureID sql.NullString
+	var redactionPending bool
+	err := server.database.QueryRow(`
+		SELECT type, lecture_id, language_code, redaction_pending FROM tools WHERE id = ? AND exam_id = ?
+	`, regenerateRequest.ToolID, regenerateRequest.ExamID).Scan(&toolType, &lectureID, &languageCode, &redactionPending)
+	if err != nil {
+		server.writeError(responseWriter, http.StatusNotFound, "NOT_FOUND", "Tool not found in this exam", nil)
+		return
+	}
+	if toolType != "guide" && toolType != models.ToolTypeCourseOverview {
+		server.writeError(responseWriter, http.StatusBadRequest, "VALIDATION_ERROR", "Only study guides and course overviews are generated by section", nil)
+		return
+	}
+	// The other sections would be reused while they still cover redacted parts of a recording
+	if redactionPending {
+		server.writeError(responseWriter, http.StatusConflict, "INVALID_STATE", "This tool covers redacted material and must be regenerated as a whole", nil)
+		return
+	}
+
+	var sectionJobID string
+	err = server.database.QueryRow("SELECT job_id FROM tool_sections WHERE id = ? AND tool_id = ? AND level = 2", regenerateRequest.SectionID, regenerateRequest.ToolID).Scan(&sectionJobID)
+	if err != nil {
+		server.writeError(responseWriter, http.StatusNotFound, "NOT_FOUND", "Section not found in this tool", nil)
+		return
+	}
+	if err := server.checkCostBudget(server.billedUserID(userID, regenerateRequest.ExamID)); err != nil {
+		server.writeEnqueueError(responseWriter, err, "Failed to create generation job")
+		return
+	}
+
+	// The build keeps the settings of the one that generated the tool, falling back to those stored with the tool
+	payload := map[string]any{}
+	var originalPayload string
+	if server.database.QueryRow("SELECT payload FROM jobs WHERE id = ? AND type = ?", sectionJobID, models.JobTypeBuildMaterial).Scan(&originalPayload) == nil {
+		_ = json.Unmarshal([]byte(originalPayload), &payload)
+	}
+	if payload["language_code"] == nil || payload["language_code"] == "" {
+		payload["language_code"] = languageCode
+	}
+
+	// The remaining sections are handed to the build under a key of their own before the tool, which would
+	// delete them, is replaced
+	resumeJobID := "regenerate-" + regenerateRequest.ToolID
+	transaction, err := server.database.Begin()
+	if err != nil {
+		server.writeError(responseWriter, http.StatusInternalServerError, "DATABASE_ERROR", "Failed to replace the tool", nil)
+		return
+	}
+	defer transaction.Rollback()
+	replacedVersionID, err := database.SaveToolVersion(transaction, regenerateRequest.ToolID, models.ResourceActionRegenerated)
+	if err != nil {
+		server.writeError(responseWriter, http.StatusInternalServerError, "DATABASE_ERROR", "Failed to keep the previous version of the tool", nil)
+		return
+	}
+	if err := jobs.DetachToolSections(transaction, regenerateRequest.ToolID, regenerateRequest.SectionID, resumeJobID); err != nil {
+		server.writeError(responseWriter, http.StatusInternalServerError, "DATABASE_ERROR", "Failed to replace the tool", nil)
+		return
+	}
+	if _, err := transaction.Exec("DELETE FROM tools WHERE id = ?", regenerateRequest.ToolID); err != nil {
+		server.writeError(responseWriter, http.StatusInternalServerError, "DATABASE_ERROR", "Failed to replace the tool", nil)
+		return
+	}
+	if err := transaction.Commit(); err != nil {
+		server.writeError(responseWriter, http.StatusInternalServerError, "DATABASE_ERROR", "Failed to replace the tool", nil)
+		return
+	}
+	server.removeToolFiles(regenerateRequest.ToolID)
+
+	payload["exam_id"] = regenerateRequest.ExamID
+	payload["lecture_id"] = lectureID.String
+	payload["type"] = toolType
+	payload["resume_job_id"] = resumeJobID
+	payload["replaced_version_id"] = replacedVersionID
+	jobIdentifier, err := server.jobQueue.Enqueue(userID, models.JobTypeBuildMaterial, payload, regenerateRequest.ExamID, lectureID.String)
+	if err != nil {
+		server.writeEnqueueError(responseWriter, err, "Failed to create generation job")
+		return
+	}
+
+	server.writeJSON(responseWriter, http.StatusAccepted, map[string]string{
+		"job_id":  jobIdentifier,
+		"message": "Section regeneration job created",
+	})
+}
+
 // handleUpdateTool allows manual refinement of tool content or title
 func (server *Server) handleUpdateTool(responseWriter http.ResponseWriter, request *http.Request) {
 	var updateRequest struct {
@@ -238,13 +657,17 @@ func (server *Server) handleUpdateTool(responseWriter http.ResponseWriter, reque
 
 	userID := server.getUserID(request)
 
+	if !server.authorizeExam(responseWriter, request, updateRequest.ExamID, models.ExamRoleManager) {
+		return
+	}
+
 	// Verify ownership
 	var exists bool
 	err := server.database.QueryRow(`
 		SELECT EXISTS(
 			SELECT 1 FROM tools 
 			JOIN exams ON tools.exam_id = exams.id
-			WHERE tools.id = ? AND tools.exam_id = ? AND exams.user_id = ?
+			WHERE tools.id = ? AND tools.exam_id = ? AND `+examAccess(models.ExamRoleManager)+`
 		)
 	`, updateRequest.ToolID, updateRequest.ExamID, userID).Scan(&exists)
 
@@ -253,6 +676,27 @@ func (server *Server) handleUpdateTool(responseWriter http.ResponseWriter, reque
 		return
 	}
 
+	var previousTitle, toolType string
+	var lectureID sql.NullString
+	server.database.QueryRow("SELECT title, type, lecture_id FROM tools WHERE id = ?", updateRequest.ToolID).Scan(&previousTitle, &toolType, &lectureID)
+
+	transaction, err := server.database.Begin()
+	if err != nil {
+		server.writeError(responseWriter, http.StatusInternalServerError, "DATABASE_ERROR", "Failed to update tool", nil)
+		return
+	}
+	defer transaction.Rollback()
+
+	// The content being replaced is kept with the edit so it can be undone
+	var versionID string
+	if updateRequest.Content != nil || (updateRequest.Title != nil && *updateRequest.Title != previousTitle) {
+		versionID, err = database.SaveToolVersion(transaction, updateRequest.ToolID, models.ResourceActionEdited)
+		if err != nil {
+			server.writeError(responseWriter, http.StatusInternalServerError, "DATABASE_ERROR", "Failed to keep the previous version of the tool", nil)
+			return
+		}
+	}
+
 	query := "UPDATE tools SET updated_at = ?"
 	args := []any{time.Now()}
 
@@ -268,15 +712,97 @@ func (server *Server) handleUpdateTool(responseWriter http.ResponseWriter, reque
 	query += " WHERE id = ?"
 	args = append(args, updateRequest.ToolID)
 
-	_, err = server.database.Exec(query, args...)
-	if err != nil {
+	if _, err := transaction.Exec(query, args...); err != nil {
+		server.writeError(responseWriter, http.StatusInternalServerError, "DATABASE_ERROR", "Failed to update tool", nil)
+		return
+	}
+	var purgedExportKeys []string
+	if updateRequest.Content != nil {
+		if purgedExportKeys, err = database.InvalidateToolContent(transaction, updateRequest.ToolID); err != nil {
+			server.writeError(responseWriter, http.StatusInternalServerError, "DATABASE_ERROR", "Failed to update tool", nil)
+			return
+		}
+	}
+	if err := transaction.Commit(); err != nil {
 		server.writeError(responseWriter, http.StatusInternalServerError, "DATABASE_ERROR", "Failed to update tool", nil)
 		return
 	}
+	server.deleteStoredObjects(purgedExportKeys)
+
+	if versionID != "" {
+		event := models.ResourceEvent{
+			ExamID:       updateRequest.ExamID,
+			LectureID:    lectureID.String,
+			ResourceType: models.ResourceTypeTool,
+			ResourceID:   updateRequest.ToolID,
+			Action:       models.ResourceActionEdited,
+			Summary:      fmt.Sprintf("Edited the %s \"%s\"", toolType, previousTitle),
+			VersionID:    versionID,
+			Undo:         restoreToolVersionUndo(updateRequest.ExamID, versionID, "Put back the content before this edit"),
+		}
+		if updateRequest.Content == nil {
+			event.Action = models.ResourceActionRenamed
+			event.Summary = fmt.Sprintf("Renamed the %s \"%s\" to \"%s\"", toolType, previousTitle, *updateRequest.Title)
+		}
+		server.recordEvent(request, event)
+	}
 
 	server.writeJSON(responseWriter, http.StatusOK, map[string]string{"message": "Tool updated successfully"})
 }
 
+// handleRateTool records the user's rating of a generated tool, from 1 to 5, replacing any earlier rating.
+// Ratings feed the comparison of prompt variants in experimentation mode
+func (server *Server) handleRateTool(responseWriter http.ResponseWriter, request *http.Request) {
+	var ratingRequest struct {
+		ToolID  string `json:"tool_id"`
+		ExamID  string `json:"exam_id"`
+		Rating  int    `json:"rating"`
+		Comment string `json:"comment"`
+	}
+
+	if err := json.NewDecoder(request.Body).Decode(&ratingRequest); err != nil {
+		server.writeError(responseWriter, http.StatusBadRequest, "VALIDATION_ERROR", "Invalid request body", nil)
+		return
+	}
+
+	if ratingRequest.ToolID == "" || ratingRequest.ExamID == "" {
+		server.writeError(responseWriter, http.StatusBadRequest, "VALIDATION_ERROR", "tool_id and exam_id are required", nil)
+		return
+	}
+	if ratingRequest.Rating < 1 || ratingRequest.Rating > 5 {
+		server.writeError(responseWriter, http.StatusBadRequest, "VALIDATION_ERROR", "rating must be between 1 and 5", nil)
+		return
+	}
+
+	userID := server.getUserID(request)
+
+	// Verify ownership
+	var exists bool
+	err := server.database.QueryRow(`
+		SELECT EXISTS(
+			SELECT 1 FROM tools
+			JOIN exams ON tools.exam_id = exams.id
+			WHERE tools.id = ? AND tools.exam_id = ? AND `+examAccess(models.ExamRoleViewer)+`
+		)
+	`, ratingRequest.ToolID, ratingRequest.ExamID, userID).Scan(&exists)
+
+	if err != nil || !exists {
+		server.writeError(responseWriter, http.StatusNotFound, "NOT_FOUND", "Tool not found in this exam", nil)
+		return
+	}
+
+	_, err = server.database.Exec(`
+		INSERT INTO tool_feedback (tool_id, user_id, rating, comment, created_at, updated_at) VALUES (?, ?, ?, ?, ?, ?)
+		ON CONFLICT(tool_id, user_id) DO UPDATE SET rating = excluded.rating, comment = excluded.comment, updated_at = excluded.updated_at
+	`, ratingRequest.ToolID, userID, ratingRequest.Rating, strings.TrimSpace(ratingRequest.Comment), time.Now(), time.Now())
+	if err != nil {
+		server.writeError(responseWriter, http.StatusInternalServerError, "DATABASE_ERROR", "Failed to store rating", nil)
+		return
+	}
+
+	server.writeJSON(responseWriter, http.StatusOK, map[string]any{"tool_id": ratingRequest.ToolID, "rating": ratingRequest.Rating})
+}
+
 // handleGetToolHTML retrieves a specific tool and converts its content to HTML
 func (server *Server) handleGetToolHTML(responseWriter http.ResponseWriter, request *http.Request) {
 	toolID := request.URL.Query().Get("tool_id")
@@ -295,8 +821,8 @@ func (server *Server) handleGetToolHTML(responseWriter http.ResponseWriter, requ
 		SELECT tools.id, tools.lecture_id, tools.title, tools.type, tools.content
 		FROM tools
 		JOIN exams ON tools.exam_id = exams.id
-		WHERE tools.id = ? AND tools.exam_id = ? AND exams.user_id = ?
-	`, toolID, examID, userID).Scan(&tool.ID, &lectureID, &tool.Title, &tool.Type, &tool.Content)
+		WHERE tools.id = ? AND tools.exam_id = ? AND `+examAccess(models.ExamRoleViewer)+` AND `+ownBookmarkDecks+`
+	`, toolID, examID, userID, userID).Scan(&tool.ID, &lectureID, &tool.Title, &tool.Type, &tool.Content)
 
 	if lectureID.Valid {
 		tool.LectureID = lectureID.String
@@ -314,7 +840,7 @@ func (server *Server) handleGetToolHTML(responseWriter http.ResponseWriter, requ
 	// For flashcards and quizzes, we return structured data with HTML fields
 	if tool.Type == "flashcard" {
 		var flashcards []map[string]string
-		content := tool.Content
+		content := tools.ResolveFlashcardImages(tool.Content, storage.NewLayout(server.configuration.Storage).ToolExportDirectory(tool.ID))
 		// Attempt robust extraction if direct parse fails
 		if err := json.Unmarshal([]byte(content), &flashcards); err != nil {
 			// Try to extract from Markdown fences
@@ -340,6 +866,11 @@ func (server *Server) handleGetToolHTML(responseWriter http.ResponseWriter, requ
 		for _, fc := range flashcards {
 			frontHTML, _ := server.markdownConverter.MarkdownToHTML(fc["front"])
 			backHTML, _ := server.markdownConverter.MarkdownToHTML(fc["back"])
+			if fc["image"] != "" {
+				if imageData, readError := os.ReadFile(fc["image"]); readError == nil {
+					backHTML += fmt.Sprintf(`<img class="flashcard-mnemonic" src="data:image/png;base64,%s" alt="">`, base64.StdEncoding.EncodeToString(imageData))
+				}
+			}
 			result = append(result, flashcardHTML{
 				FrontHTML: frontHTML,
 				BackHTML:  backHTML,
@@ -356,7 +887,7 @@ func (server *Server) handleGetToolHTML(responseWriter http.ResponseWriter, requ
 	}
 
 	if tool.Type == "quiz" {
-		var quizItems []map[string]any
+		var quizItems []models.QuizQuestion
 		content := tool.Content
 		if err := json.Unmarshal([]byte(content), &quizItems); err != nil {
 			start := strings.Index(content, "[")
@@ -372,31 +903,61 @@ func (server *Server) handleGetToolHTML(responseWriter http.ResponseWriter, requ
 			}
 		}
 
+		type quizPairHTML struct {
+			LeftHTML  string `json:"left_html"`
+			RightHTML string `json:"right_html"`
+		}
 		type quizItemHTML struct {
-			QuestionHTML      string   `json:"question_html"`
-			OptionsHTML       []string `json:"options_html"`
-			CorrectAnswerHTML string   `json:"correct_answer_html"`
-			ExplanationHTML   string   `json:"explanation_html"`
+			Type              string                       `json:"type"`
+			QuestionHTML      string                       `json:"question_html"`
+			OptionsHTML       []string                     `json:"options_html,omitempty"`
+			CorrectAnswerHTML string                       `json:"correct_answer_html,omitempty"`
+			PairsHTML         []quizPairHTML               `json:"pairs_html,omitempty"` // In their correct matching
+			ItemsHTML         []string                     `json:"items_html,omitempty"` // In their correct order
+			Answer            *float64                     `json:"answer,omitempty"`
+			Tolerance         float64                      `json:"tolerance,omitempty"`
+			Unit              string                       `json:"unit,omitempty"`
+			Rubric            []models.QuizRubricCriterion `json:"rubric,omitempty"`
+			ExplanationHTML   string                       `json:"explanation_html"`
 		}
 		var result []quizItemHTML
 
 		for _, item := range quizItems {
-			questionHTML, _ := server.markdownConverter.MarkdownToHTML(fmt.Sprintf("%v", item["question"]))
-			explanationHTML, _ := server.markdownConverter.MarkdownToHTML(fmt.Sprintf("%v", item["explanation"]))
-			correctAnswerHTML, _ := server.markdownConverter.MarkdownToHTML(fmt.Sprintf("%v", item["correct_answer"]))
+			questionHTML, _ := server.markdownConverter.MarkdownToHTML(item.Question)
+			explanationHTML, _ := server.markdownConverter.MarkdownToHTML(item.Explanation)
+			var correctAnswerHTML string
+			if item.CorrectAnswer != "" {
+				correctAnswerHTML, _ = server.markdownConverter.MarkdownToHTML(item.CorrectAnswer)
+			}
 
 			var optionsHTML []string
-			if options, ok := item["options"].([]any); ok {
-				for _, opt := range options {
-					oHTML, _ := server.markdownConverter.MarkdownToHTML(fmt.Sprintf("%v", opt))
-					optionsHTML = append(optionsHTML, oHTML)
-				}
+			for _, option := range item.Options {
+				optionHTML, _ := server.markdownConverter.MarkdownToHTML(option)
+				optionsHTML = append(optionsHTML, optionHTML)
+			}
+			var pairsHTML []quizPairHTML
+			for _, pair := range item.Pairs {
+				leftHTML, _ := server.markdownConverter.MarkdownToHTML(pair.Left)
+				rightHTML, _ := server.markdownConverter.MarkdownToHTML(pair.Right)
+				pairsHTML = append(pairsHTML, quizPairHTML{LeftHTML: leftHTML, RightHTML: rightHTML})
+			}
+			var itemsHTML []string
+			for _, orderedItem := range item.Items {
+				orderedItemHTML, _ := server.markdownConverter.MarkdownToHTML(orderedItem)
+				itemsHTML = append(itemsHTML, orderedItemHTML)
 			}
 
 			result = append(result, quizItemHTML{
+				Type:              item.QuestionType(),
 				QuestionHTML:      questionHTML,
 				OptionsHTML:       optionsHTML,
 				CorrectAnswerHTML: correctAnswerHTML,
+				PairsHTML:         pairsHTML,
+				ItemsHTML:         itemsHTML,
+				Answer:            item.Answer,
+				Tolerance:         item.Tolerance,
+				Unit:              item.Unit,
+				Rubric:            item.Rubric,
 				ExplanationHTML:   explanationHTML,
 			})
 		}
@@ -410,12 +971,99 @@ func (server *Server) handleGetToolHTML(responseWriter http.ResponseWriter, requ
 		return
 	}
 
+	// For mock exams, the questions are returned with their answers, which clients hide until the time is up
+	if tool.Type == models.ToolTypeMockExam {
+		var mockExam models.MockExam
+		if err := json.Unmarshal([]byte(tool.Content), &mockExam); err != nil {
+			server.writeError(responseWriter, http.StatusInternalServerError, "JSON_ERROR", "Failed to parse mock exam", nil)
+			return
+		}
+
+		type mockExamQuestionHTML struct {
+			Type              string   `json:"type"`
+			Difficulty        string   `json:"difficulty"`
+			Points            int      `json:"points"`
+			Lecture           string   `json:"lecture"`
+			QuestionHTML      string   `json:"question_html"`
+			OptionsHTML       []string `json:"options_html,omitempty"`
+			CorrectAnswerHTML string   `json:"correct_answer_html"`
+			ExplanationHTML   string   `json:"explanation_html"`
+		}
+		questions := make([]mockExamQuestionHTML, 0, len(mockExam.Questions))
+		for _, question := range mockExam.Questions {
+			questionHTML, _ := server.markdownConverter.MarkdownToHTML(question.Question)
+			correctAnswerHTML, _ := server.markdownConverter.MarkdownToHTML(question.CorrectAnswer)
+			explanationHTML, _ := server.markdownConverter.MarkdownToHTML(question.Explanation)
+			var optionsHTML []string
+			for _, option := range question.Options {
+				optionHTML, _ := server.markdownConverter.MarkdownToHTML(option)
+				optionsHTML = append(optionsHTML, optionHTML)
+			}
+			questions = append(questions, mockExamQuestionHTML{
+				Type:              question.Type,
+				Difficulty:        question.Difficulty,
+				Points:            question.Points,
+				Lecture:           question.Lecture,
+				QuestionHTML:      questionHTML,
+				OptionsHTML:       optionsHTML,
+				CorrectAnswerHTML: correctAnswerHTML,
+				ExplanationHTML:   explanationHTML,
+			})
+		}
+
+		server.writeJSON(responseWriter, http.StatusOK, map[string]any{
+			"tool_id": tool.ID,
+			"title":   tool.Title,
+			"type":    tool.Type,
+			"content": map[string]any{
+				"duration_minutes": mockExam.DurationMinutes,
+				"total_points":     mockExam.TotalPoints,
+				"questions":        questions,
+			},
+		})
+		return
+	}
+
+	// For mindmaps, the Mermaid source of the diagram is returned for the client to draw, with the concepts
+	if tool.Type == models.ToolTypeMindmap {
+		var mindmap models.Mindmap
+		if err := json.Unmarshal([]byte(tool.Content), &mindmap); err != nil {
+			server.writeError(responseWriter, http.StatusInternalServerError, "JSON_ERROR", "Failed to parse mindmap", nil)
+			return
+		}
+		diagram, _ := markdown.MindmapToMermaid(tool.Content)
+
+		type mindmapNodeHTML struct {
+			ID              string `json:"id"`
+			Label           string `json:"label"`
+			DescriptionHTML string `json:"description_html"`
+		}
+		nodes := make([]mindmapNodeHTML, 0, len(mindmap.Nodes))
+		for _, node := range mindmap.Nodes {
+			descriptionHTML, _ := server.markdownConverter.MarkdownToHTML(node.Description)
+			nodes = append(nodes, mindmapNodeHTML{ID: node.ID, Label: node.Label, DescriptionHTML: descriptionHTML})
+		}
+
+		server.writeJSON(responseWriter, http.StatusOK, map[string]any{
+			"tool_id": tool.ID,
+			"title":   tool.Title,
+			"type":    tool.Type,
+			"content": map[string]any{
+				"mermaid": diagram,
+				"nodes":   nodes,
+				"edges":   mindmap.Edges,
+			},
+		})
+		return
+	}
+
 	// For guide (study guide), it's already Markdown, return structured data with HTML
 	markdownText := tool.Content
 
 	// Pre-fetch original filenames and associated lecture IDs for this exam
 	filenameMap := make(map[string]string)
 	docToLectureMap := make(map[string]string)
+	documentNameToID := make(map[string]string) // Titles and original filenames, as cited by the model
 	fRows, err := server.database.Query(`
 		SELECT rd.id, rd.lecture_id, rd.original_filename, rd.title 
 		FROM reference_documents rd
@@ -433,13 +1081,17 @@ func (server *Server) handleGetToolHTML(responseWriter http.ResponseWriter, requ
 				}
 				filenameMap[id] = name
 				docToLectureMap[id] = lectureID
+				documentNameToID[title] = id
+				if orig.Valid && orig.String != "" {
+					documentNameToID[orig.String] = id
+				}
 			}
 		}
 		fRows.Close()
 	}
 
-	// For study guides, transform raw citations to footnotes at runtime
-	if tool.Type == "guide" {
+	// For study guides and course overviews, transform raw citations to footnotes at runtime
+	if models.ToolCitesSources(tool.Type) {
 		markdownReconstructor := markdown.NewReconstructor()
 		markdownReconstructor.Language = tool.LanguageCode
 
@@ -521,7 +1173,19 @@ func (server *Server) handleGetToolHTML(responseWriter http.ResponseWriter, requ
 		}
 		enrichNodeMetadata(ast)
 
+		documentNames := make([]string, 0, len(documentNameToID))
+		for name := range documentNameToID {
+			documentNames = append(documentNames, name)
+		}
+		sort.Strings(documentNames)
+
 		imageResolver := func(sourceID string, pageNumber int) string {
+			// Citations name the file; map the (possibly mangled) name onto its document
+			if _, isDocumentID := docToLectureMap[sourceID]; !isDocumentID {
+				if resolvedName := markdown.ResolveCitationFilename(sourceID, documentNames); resolvedName != "" {
+					sourceID = documentNameToID[resolvedName]
+				}
+			}
 			associatedLectureID := docToLectureMap[sourceID]
 			if associatedLectureID == "" {
 				return "" // Cannot resolve image without a lecture link
@@ -638,14 +1302,22 @@ func (server *Server) handleDeleteTool(responseWriter http.ResponseWriter, reque
 		return
 	}
 
-	userID := server.getUserID(request)
+	if !server.authorizeExam(responseWriter, request, deleteRequest.ExamID, models.ExamRoleManager) {
+		return
+	}
+
+	// The deleted tool is kept as a version so it can be restored
+	var title, toolType string
+	var lectureID sql.NullString
+	var versionID string
+	if server.database.QueryRow("SELECT title, type, lecture_id FROM tools WHERE id = ? AND exam_id = ?", deleteRequest.ToolID, deleteRequest.ExamID).Scan(&title, &toolType, &lectureID) == nil {
+		versionID = server.saveToolVersion(deleteRequest.ToolID, models.ResourceActionDeleted)
+	}
 
 	result, err := server.database.Exec(`
 		DELETE FROM tools
-		WHERE id = ? AND exam_id = ? AND EXISTS (
-			SELECT 1 FROM exams WHERE id = ? AND user_id = ?
-		)
-	`, deleteRequest.ToolID, deleteRequest.ExamID, deleteRequest.ExamID, userID)
+		WHERE id = ? AND exam_id = ?
+	`, deleteRequest.ToolID, deleteRequest.ExamID)
 	if err != nil {
 		server.writeError(responseWriter, http.StatusInternalServerError, "DATABASE_ERROR", "Failed to delete tool", nil)
 		return
@@ -657,17 +1329,42 @@ func (server *Server) handleDeleteTool(responseWriter http.ResponseWriter, reque
 		return
 	}
 
+	server.removeToolFiles(deleteRequest.ToolID)
+
+	server.recordEvent(request, models.ResourceEvent{
+		ExamID:       deleteRequest.ExamID,
+		LectureID:    lectureID.String,
+		ResourceType: models.ResourceTypeTool,
+		ResourceID:   deleteRequest.ToolID,
+		Action:       models.ResourceActionDeleted,
+		Summary:      fmt.Sprintf("Deleted the %s \"%s\"", toolType, title),
+		VersionID:    versionID,
+		Undo:         restoreToolVersionUndo(deleteRequest.ExamID, versionID, "Restore the deleted tool"),
+	})
+
 	server.writeJSON(responseWriter, http.StatusOK, map[string]string{"message": "Tool deleted successfully"})
 }
 
+// removeToolFiles deletes the permanent assets (e.g. flashcard images) of a deleted tool
+func (server *Server) removeToolFiles(toolID string) {
+	if toolID == "" {
+		return
+	}
+	if err := os.RemoveAll(storage.NewLayout(server.configuration.Storage).ToolExportDirectory(toolID)); err != nil {
+		slog.Warn("Failed to remove tool files", "toolID", toolID, "error", err)
+	}
+}
+
 // handleExportTool triggers an export job for a specific tool (PDF, Docx, MD)
 func (server *Server) handleExportTool(responseWriter http.ResponseWriter, request *http.Request) {
 	var exportRequest struct {
 		ToolID        string `json:"tool_id"`
 		ExamID        string `json:"exam_id"`
-		Format        string `json:"format"` // "pdf", "docx", "md"
+		Format        string `json:"format"` // "pdf", "docx", "epub", "html", "md", "anki", "csv", "apkg"
+		Theme         string `json:"theme"`
 		IncludeImages *bool  `json:"include_images"`
 		IncludeQRCode *bool  `json:"include_qr_code"`
+		SelfContained bool   `json:"self_contained"`
 	}
 
 	if decodingError := json.NewDecoder(request.Body).Decode(&exportRequest); decodingError != nil {
@@ -680,6 +1377,11 @@ func (server *Server) handleExportTool(responseWriter http.ResponseWriter, reque
 		return
 	}
 
+	if !exportThemePattern.MatchString(exportRequest.Theme) {
+		server.writeError(responseWriter, http.StatusBadRequest, "VALIDATION_ERROR", "Theme may only contain lowercase letters, digits and dashes", nil)
+		return
+	}
+
 	if exportRequest.Format == "" {
 		exportRequest.Format = "pdf"
 	}
@@ -696,16 +1398,20 @@ func (server *Server) handleExportTool(responseWriter http.ResponseWriter, reque
 
 	userID := server.getUserID(request)
 
+	if !server.authorizeExam(responseWriter, request, exportRequest.ExamID, models.ExamRoleGenerator) {
+		return
+	}
+
 	// Verify tool exists and belongs to the user
-	var toolID string
+	var toolID, toolType string
 	var languageCode sql.NullString
 	var lectureID sql.NullString
 	queryError := server.database.QueryRow(`
-		SELECT tools.id, tools.language_code, tools.lecture_id
+		SELECT tools.id, tools.type, tools.language_code, tools.lecture_id
 		FROM tools
 		JOIN exams ON tools.exam_id = exams.id
-		WHERE tools.id = ? AND tools.exam_id = ? AND exams.user_id = ?
-	`, exportRequest.ToolID, exportRequest.ExamID, userID).Scan(&toolID, &languageCode, &lectureID)
+		WHERE tools.id = ? AND tools.exam_id = ? AND `+examAccess(models.ExamRoleGenerator)+` AND `+ownBookmarkDecks+`
+	`, exportRequest.ToolID, exportRequest.ExamID, userID, userID).Scan(&toolID, &toolType, &languageCode, &lectureID)
 
 	if queryError == sql.ErrNoRows {
 		server.writeError(responseWriter, http.StatusNotFound, "NOT_FOUND", "Tool not found in this exam", nil)
@@ -716,6 +1422,12 @@ func (server *Server) handleExportTool(responseWriter http.ResponseWriter, reque
 		return
 	}
 
+	// Anki packages hold flashcards only
+	if exportRequest.Format == "apkg" && toolType != "flashcard" {
+		server.writeError(responseWriter, http.StatusBadRequest, "VALIDATION_ERROR", "Only flashcard tools can be exported as Anki packages", nil)
+		return
+	}
+
 	lang := ""
 	if languageCode.Valid {
 		lang = languageCode.String
@@ -726,8 +1438,10 @@ func (server *Server) handleExportTool(responseWriter http.ResponseWriter, reque
 		"tool_id":         exportRequest.ToolID,
 		"language_code":   lang,
 		"format":          exportRequest.Format,
+		"theme":           exportRequest.Theme,
 		"include_images":  fmt.Sprintf("%v", includeImages),
 		"include_qr_code": fmt.Sprintf("%v", includeQRCode),
+		"self_contained":  fmt.Sprintf("%v", exportRequest.SelfContained),
 	}, exportRequest.ExamID, lectureID.String)
 
 	if enqueuingError != nil {
@@ -744,11 +1458,13 @@ func (server *Server) handleExportTool(responseWriter http.ResponseWriter, reque
 // handleExportTranscript triggers an export job for a lecture transcript
 func (server *Server) handleExportTranscript(responseWriter http.ResponseWriter, request *http.Request) {
 	var exportRequest struct {
-		LectureID     string `json:"lecture_id"`
-		ExamID        string `json:"exam_id"`
-		Format        string `json:"format"` // "pdf", "docx", "md"
-		IncludeImages *bool  `json:"include_images"`
-		IncludeQRCode *bool  `json:"include_qr_code"`
+		LectureID        string `json:"lecture_id"`
+		ExamID           string `json:"exam_id"`
+		Format           string `json:"format"` // "pdf", "docx", "epub", "html", "md"
+		IncludeImages    *bool  `json:"include_images"`
+		IncludeQRCode    *bool  `json:"include_qr_code"`
+		IncludeBookmarks *bool  `json:"include_bookmarks"`
+		SelfContained    bool   `json:"self_contained"`
 	}
 
 	if decodingError := json.NewDecoder(request.Body).Decode(&exportRequest); decodingError != nil {
@@ -775,8 +1491,17 @@ func (server *Server) handleExportTranscript(responseWriter http.ResponseWriter,
 		includeQRCode = *exportRequest.IncludeQRCode
 	}
 
+	includeBookmarks := true
+	if exportRequest.IncludeBookmarks != nil {
+		includeBookmarks = *exportRequest.IncludeBookmarks
+	}
+
 	userID := server.getUserID(request)
 
+	if !server.authorizeExam(responseWriter, request, exportRequest.ExamID, models.ExamRoleGenerator) {
+		return
+	}
+
 	// Verify lecture exists and belongs to the user
 	var lectureTitle string
 	var languageCode sql.NullString
@@ -784,7 +1509,7 @@ func (server *Server) handleExportTranscript(responseWriter http.ResponseWriter,
 		SELECT lectures.title, lectures.language
 		FROM lectures
 		JOIN exams ON lectures.exam_id = exams.id
-		WHERE lectures.id = ? AND lectures.exam_id = ? AND exams.user_id = ?
+		WHERE lectures.id = ? AND lectures.exam_id = ? AND `+examAccess(models.ExamRoleGenerator)+`
 	`, exportRequest.LectureID, exportRequest.ExamID, userID).Scan(&lectureTitle, &languageCode)
 
 	if queryError == sql.ErrNoRows {
@@ -807,11 +1532,13 @@ func (server *Server) handleExportTranscript(responseWriter http.ResponseWriter,
 
 	// Enqueue export job
 	jobIdentifier, enqueuingError := server.jobQueue.Enqueue(userID, models.JobTypePublishMaterial, map[string]string{
-		"lecture_id":      exportRequest.LectureID,
-		"language_code":   lang,
-		"format":          exportRequest.Format,
-		"include_images":  fmt.Sprintf("%v", includeImages),
-		"include_qr_code": fmt.Sprintf("%v", includeQRCode),
+		"lecture_id":        exportRequest.LectureID,
+		"language_code":     lang,
+		"format":            exportRequest.Format,
+		"include_images":    fmt.Sprintf("%v", includeImages),
+		"include_qr_code":   fmt.Sprintf("%v", includeQRCode),
+		"include_bookmarks": fmt.Sprintf("%v", includeBookmarks),
+		"self_contained":    fmt.Sprintf("%v", exportRequest.SelfContained),
 	}, exportRequest.ExamID, exportRequest.LectureID)
 
 	if enqueuingError != nil {
@@ -831,9 +1558,10 @@ func (server *Server) handleExportDocument(responseWriter http.ResponseWriter, r
 		DocumentID    string `json:"document_id"`
 		LectureID     string `json:"lecture_id"`
 		ExamID        string `json:"exam_id"`
-		Format        string `json:"format"` // "pdf", "docx", "md"
+		Format        string `json:"format"` // "pdf", "docx", "epub", "html", "md"
 		IncludeImages *bool  `json:"include_images"`
 		IncludeQRCode *bool  `json:"include_qr_code"`
+		SelfContained bool   `json:"self_contained"`
 	}
 
 	if decodingError := json.NewDecoder(request.Body).Decode(&exportRequest); decodingError != nil {
@@ -862,6 +1590,10 @@ func (server *Server) handleExportDocument(responseWriter http.ResponseWriter, r
 
 	userID := server.getUserID(request)
 
+	if !server.authorizeExam(responseWriter, request, exportRequest.ExamID, models.ExamRoleGenerator) {
+		return
+	}
+
 	// Verify document exists and belongs to the user
 	var docTitle string
 	var languageCode sql.NullString
@@ -870,7 +1602,7 @@ func (server *Server) handleExportDocument(responseWriter http.ResponseWriter, r
 		FROM reference_documents
 		JOIN lectures ON reference_documents.lecture_id = lectures.id
 		JOIN exams ON lectures.exam_id = exams.id
-		WHERE reference_documents.id = ? AND reference_documents.lecture_id = ? AND exams.user_id = ?
+		WHERE reference_documents.id = ? AND reference_documents.lecture_id = ? AND `+examAccess(models.ExamRoleGenerator)+`
 	`, exportRequest.DocumentID, exportRequest.LectureID, userID).Scan(&docTitle, &languageCode)
 
 	if queryError == sql.ErrNoRows {
@@ -899,6 +1631,7 @@ func (server *Server) handleExportDocument(responseWriter http.ResponseWriter, r
 		"format":          exportRequest.Format,
 		"include_images":  fmt.Sprintf("%v", includeImages),
 		"include_qr_code": fmt.Sprintf("%v", includeQRCode),
+		"self_contained":  fmt.Sprintf("%v", exportRequest.SelfContained),
 	}, exportRequest.ExamID, exportRequest.LectureID)
 
 	if enqueuingError != nil {
@@ -921,9 +1654,9 @@ func (server *Server) handleDownloadExport(responseWriter http.ResponseWriter, r
 	}
 
 	// Try multiple token sources with validation (cookie -> header -> query param)
-	sessionToken := server.getValidSessionToken(request)
-	if sessionToken == "" {
-		server.writeError(responseWriter, http.StatusUnauthorized, "AUTHENTICATION_ERROR", "Authentication required", nil)
+	sessionToken, passwordChangeRequired := server.getValidSessionToken(request)
+	if sessionToken == "" || passwordChangeRequired {
+		server.writeSessionError(responseWriter, passwordChangeRequired)
 		return
 	}
 
@@ -953,18 +1686,29 @@ func (server *Server) handleDownloadExport(responseWriter http.ResponseWriter, r
 		responseWriter.Header().Set("Content-Type", "application/vnd.openxmlformats-officedocument.wordprocessingml.document")
 	case ".md":
 		responseWriter.Header().Set("Content-Type", "text/markdown")
+	case ".zip":
+		responseWriter.Header().Set("Content-Type", "application/zip")
 	}
 
-	// Serve from DB BLOB
+	// Serve from DB BLOB or object store
 	var exportData []byte
+	var objectKey sql.NullString
 	err = server.database.QueryRow(`
-		SELECT export_data FROM jobs
-		WHERE user_id = ? AND type = 'PUBLISH_MATERIAL' AND status = 'COMPLETED'
-		AND result LIKE ? AND export_data IS NOT NULL
+		SELECT export_data, export_object_key FROM jobs
+		WHERE user_id = ? AND type IN ('PUBLISH_MATERIAL', 'PUBLISH_BUNDLE') AND status = 'COMPLETED'
+		AND result LIKE ? AND (export_data IS NOT NULL OR export_object_key IS NOT NULL)
 		ORDER BY completed_at DESC LIMIT 1
-	`, userID, "%"+fileName+"%").Scan(&exportData)
+	`, userID, "%"+fileName+"%").Scan(&exportData, &objectKey)
 
-	if err != nil || len(exportData) == 0 {
+	if err != nil {
+		server.writeError(responseWriter, http.StatusNotFound, "NOT_FOUND", "Export file not found", nil)
+		return
+	}
+	exportData, loaded := server.loadStoredContent(responseWriter, request, exportData, objectKey.String, fileName, disposition == "inline")
+	if !loaded {
+		return
+	}
+	if len(exportData) == 0 {
 		server.writeError(responseWriter, http.StatusNotFound, "NOT_FOUND", "Export file not found", nil)
 		return
 	}
@@ -0,0 +1,274 @@
+package api
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestCreateMockExam(t *testing.T) {
+	server, userID, sessionID, cleanup := setupUniqueExtraTestEnv(t, "mock_exam")
+	defer cleanup()
+
+	server.database.Exec("INSERT INTO exams (id, user_id, title) VALUES ('mock-exam', ?, 'Physics')", userID)
+	server.database.Exec("INSERT INTO exams (id, user_id, title) VALUES ('other-exam', ?, 'Chemistry')", userID)
+	server.database.Exec("INSERT INTO lectures (id, exam_id, title, status) VALUES ('optics', 'mock-exam', 'Optics', 'ready')")
+	server.database.Exec("INSERT INTO lectures (id, exam_id, title, status) VALUES ('acoustics', 'mock-exam', 'Acoustics', 'processing')")
+	server.database.Exec("INSERT INTO lectures (id, exam_id, title, status) VALUES ('bonds', 'other-exam', 'Bonds', 'ready')")
+
+	createTool := func(body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("POST", "/api/tools", strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer "+sessionID)
+		req.Header.Set("X-Requested-With", "XMLHttpRequest")
+		req.Header.Set("Content-Type", "application/json")
+		rr := httptest.NewRecorder()
+		server.Handler().ServeHTTP(rr, req)
+		return rr
+	}
+
+	for body, expectedStatus := range map[string]int{
+		`{"exam_id":"mock-exam","lecture_id":"optics","type":"mock_exam"}`:                                          http.StatusBadRequest,
+		`{"exam_id":"mock-exam","type":"mock_exam","mock_exam":{"difficulty":{"easy":50,"hard":40}}}`:               http.StatusBadRequest,
+		`{"exam_id":"mock-exam","type":"mock_exam","mock_exam":{"multiple_choice":61}}`:                             http.StatusBadRequest,
+		`{"exam_id":"mock-exam","type":"mock_exam","mock_exam":{"lecture_ids":["bonds"]}}`:                          http.StatusNotFound,
+		`{"exam_id":"mock-exam","type":"mock_exam","language_code":"en","mock_exam":{"lecture_ids":["acoustics"]}}`: http.StatusConflict,
+	} {
+		if rr := createTool(body); rr.Code != expectedStatus {
+			t.Errorf("Expected status %d for %s, got %d: %s", expectedStatus, body, rr.Code, rr.Body.String())
+		}
+	}
+
+	rr := createTool(`{"exam_id":"mock-exam","type":"mock_exam","language_code":"en","mock_exam":{"lecture_ids":["optics","acoustics"],"short_answer":4,"duration_minutes":45}}`)
+	if rr.Code != http.StatusAccepted {
+		t.Fatalf("Expected status 202, got %d: %s", rr.Code, rr.Body.String())
+	}
+	var response struct {
+		Data struct {
+			JobID string `json:"job_id"`
+		} `json:"data"`
+	}
+	json.Unmarshal(rr.Body.Bytes(), &response)
+
+	var payload string
+	server.database.QueryRow("SELECT payload FROM jobs WHERE id = ?", response.Data.JobID).Scan(&payload)
+	for _, expected := range []string{`"type":"mock_exam"`, `"short_answer":4`, `"multiple_choice":0`, `"duration_minutes":45`, `"total_points":100`, `"medium":50`} {
+		if !strings.Contains(payload, expected) {
+			t.Errorf("Expected %s in the normalized settings of the job, got %s", expected, payload)
+		}
+	}
+
+	if _, err := server.database.Exec("INSERT INTO tools (id, exam_id, lecture_id, type, title, content) VALUES ('stored-mock', 'mock-exam', NULL, 'mock_exam', 'Physics', '{}')"); err != nil {
+		t.Errorf("Expected the tools table to accept mock exams: %v", err)
+	}
+}
+
+func TestRegenerateToolSection(t *testing.T) {
+	server, userID, sessionID, cleanup := setupUniqueExtraTestEnv(t, "regenerate_section")
+	defer cleanup()
+
+	server.database.Exec("INSERT INTO exams (id, user_id, title) VALUES ('section-exam', ?, 'Physics')", userID)
+	server.database.Exec("INSERT INTO lectures (id, exam_id, title, status) VALUES ('section-lecture', 'section-exam', 'Optics', 'ready')")
+	server.database.Exec(`INSERT INTO jobs (id, user_id, course_id, type, status, payload) VALUES ('overview-build', ?, 'section-exam', 'BUILD_MATERIAL', 'COMPLETED', '{"type":"course_overview","length":"long","model_generation":"original-model"}')`, userID)
+	server.database.Exec("INSERT INTO tools (id, exam_id, lecture_id, type, title, language_code, content) VALUES ('overview-tool', 'section-exam', NULL, 'course_overview', 'Physics', 'en', '# Physics')")
+	server.database.Exec("INSERT INTO tools (id, exam_id, lecture_id, type, title, language_code, content) VALUES ('quiz-tool', 'section-exam', 'section-lecture', 'quiz', 'Optics', 'en', '[]')")
+	server.database.Exec("INSERT INTO tool_sections (id, job_id, tool_id, exam_id, level, position, title, content) VALUES (1, 'overview-build', 'overview-tool', 'section-exam', 1, 0, 'Physics', '# Physics')")
+	server.database.Exec("INSERT INTO tool_sections (id, job_id, tool_id, exam_id, parent_id, level, position, title, content) VALUES (2, 'overview-build', 'overview-tool', 'section-exam', 1, 2, 0, 'Optics', '## Optics')")
+	server.database.Exec("INSERT INTO tool_sections (id, job_id, tool_id, exam_id, parent_id, level, position, title, content) VALUES (3, 'overview-build', 'overview-tool', 'section-exam', 1, 2, 1, 'Waves', '## Waves')")
+
+	regenerate := func(body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("POST", "/api/tools/sections/regenerate", strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer "+sessionID)
+		req.Header.Set("X-Requested-With", "XMLHttpRequest")
+		req.Header.Set("Content-Type", "application/json")
+		rr := httptest.NewRecorder()
+		server.Handler().ServeHTTP(rr, req)
+		return rr
+	}
+
+	for body, expectedStatus := range map[string]int{
+		`{"exam_id":"section-exam","tool_id":"overview-tool"}`:                 http.StatusBadRequest,
+		`{"exam_id":"section-exam","tool_id":"quiz-tool","section_id":2}`:      http.StatusBadRequest,
+		`{"exam_id":"section-exam","tool_id":"missing-tool","section_id":2}`:   http.StatusNotFound,
+		`{"exam_id":"section-exam","tool_id":"overview-tool","section_id":1}`:  http.StatusNotFound,
+		`{"exam_id":"section-exam","tool_id":"overview-tool","section_id":99}`: http.StatusNotFound,
+	} {
+		if rr := regenerate(body); rr.Code != expectedStatus {
+			t.Errorf("Expected status %d for %s, got %d: %s", expectedStatus, body, rr.Code, rr.Body.String())
+		}
+	}
+
+	server.database.Exec("UPDATE tools SET redaction_pending = 1 WHERE id = 'overview-tool'")
+	if rr := regenerate(`{"exam_id":"section-exam","tool_id":"overview-tool","section_id":3}`); rr.Code != http.StatusConflict {
+		t.Errorf("Expected status 409 while the tool awaits its regeneration after a redaction, got %d: %s", rr.Code, rr.Body.String())
+	}
+	server.database.Exec("UPDATE tools SET redaction_pending = 0 WHERE id = 'overview-tool'")
+
+	rr := regenerate(`{"exam_id":"section-exam","tool_id":"overview-tool","section_id":3}`)
+	if rr.Code != http.StatusAccepted {
+		t.Fatalf("Expected status 202, got %d: %s", rr.Code, rr.Body.String())
+	}
+	var response struct {
+		Data struct {
+			JobID string `json:"job_id"`
+		} `json:"data"`
+	}
+	json.Unmarshal(rr.Body.Bytes(), &response)
+
+	// The build keeps the settings of the original one and resumes the sections left by the replaced tool
+	var payload string
+	server.database.QueryRow("SELECT payload FROM jobs WHERE id = ?", response.Data.JobID).Scan(&payload)
+	for _, expected := range []string{`"type":"course_overview"`, `"length":"long"`, `"model_generation":"original-model"`, `"language_code":"en"`, `"resume_job_id":"regenerate-overview-tool"`} {
+		if !strings.Contains(payload, expected) {
+			t.Errorf("Expected %s in the payload of the build, got %s", expected, payload)
+		}
+	}
+	if strings.Contains(payload, `"replaced_version_id":""`) {
+		t.Errorf("Expected the replaced tool to be kept as a version, got %s", payload)
+	}
+
+	var toolCount, regeneratedCount int
+	server.database.QueryRow("SELECT COUNT(*) FROM tools WHERE id = 'overview-tool'").Scan(&toolCount)
+	server.database.QueryRow("SELECT COUNT(*) FROM tool_sections WHERE id = 3").Scan(&regeneratedCount)
+	if toolCount != 0 || regeneratedCount != 0 {
+		t.Errorf("Expected the tool and the regenerated section to be replaced, got %d tools and %d sections", toolCount, regeneratedCount)
+	}
+}
+
+func TestHandleCreateToolFromPartialSources(t *testing.T) {
+	server, userID, sessionID, cleanup := setupUniqueExtraTestEnv(t, "partial")
+	defer cleanup()
+
+	server.database.Exec("INSERT INTO exams (id, user_id, title) VALUES ('partial-exam', ?, 'Optics')", userID)
+	server.database.Exec("INSERT INTO lectures (id, exam_id, title, status) VALUES ('partial-lecture', 'partial-exam', 'Lenses', 'processing')")
+	server.database.Exec("INSERT INTO transcripts (id, lecture_id, status) VALUES ('partial-transcript', 'partial-lecture', 'failed')")
+
+	sendRequest := func(body map[string]any) *httptest.ResponseRecorder {
+		encodedBody, _ := json.Marshal(body)
+		req := httptest.NewRequest("POST", "/api/tools", bytes.NewBuffer(encodedBody))
+		req.Header.Set("Authorization", "Bearer "+sessionID)
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("X-Requested-With", "XMLHttpRequest")
+		rr := httptest.NewRecorder()
+		server.Handler().ServeHTTP(rr, req)
+		return rr
+	}
+	toolRequest := map[string]any{"exam_id": "partial-exam", "lecture_id": "partial-lecture", "type": "guide", "language_code": "en-US"}
+
+	if rr := sendRequest(toolRequest); rr.Code != http.StatusConflict {
+		t.Errorf("Expected status 409 without allow_partial_sources, got %d", rr.Code)
+	}
+
+	// Nothing has finished: the recording failed and the slides are still being extracted
+	server.database.Exec("INSERT INTO reference_documents (id, lecture_id, document_type, title, file_path, page_count, extraction_status) VALUES ('slides', 'partial-lecture', 'pdf', 'Slides', 'slides.pdf', 1, 'processing')")
+	toolRequest["allow_partial_sources"] = true
+	if rr := sendRequest(toolRequest); rr.Code != http.StatusConflict {
+		t.Errorf("Expected status 409 when no source has finished, got %d", rr.Code)
+	}
+
+	server.database.Exec("UPDATE reference_documents SET extraction_status = 'completed' WHERE id = 'slides'")
+	rr := sendRequest(toolRequest)
+	if rr.Code != http.StatusAccepted {
+		t.Fatalf("Expected status 202 once the slides are extracted, got %d. Body: %s", rr.Code, rr.Body.String())
+	}
+	var payload string
+	server.database.QueryRow("SELECT payload FROM jobs WHERE lecture_id = 'partial-lecture'").Scan(&payload)
+	if !strings.Contains(payload, `"allow_partial_sources":"true"`) {
+		t.Errorf("Expected the job to allow partial sources, got payload %s", payload)
+	}
+}
+
+func TestHandleCreateTool_ResumeJob(t *testing.T) {
+	server, userID, sessionID, cleanup := setupUniqueExtraTestEnv(t, "resume")
+	defer cleanup()
+
+	server.database.Exec("INSERT INTO exams (id, user_id, title) VALUES ('resume-exam', ?, 'Optics')", userID)
+	server.database.Exec("INSERT INTO lectures (id, exam_id, title, status) VALUES ('resume-lecture', 'resume-exam', 'Lenses', 'ready')")
+	server.database.Exec("INSERT INTO lectures (id, exam_id, title, status) VALUES ('other-lecture', 'resume-exam', 'Mirrors', 'ready')")
+	server.database.Exec("INSERT INTO jobs (id, type, status, payload, user_id, lecture_id) VALUES ('failed-build', 'BUILD_MATERIAL', 'FAILED', '{}', ?, 'resume-lecture')", userID)
+	server.database.Exec("INSERT INTO jobs (id, type, status, payload, user_id, lecture_id) VALUES ('running-build', 'BUILD_MATERIAL', 'RUNNING', '{}', ?, 'resume-lecture')", userID)
+	server.database.Exec("INSERT INTO jobs (id, type, status, payload, user_id, lecture_id) VALUES ('other-build', 'BUILD_MATERIAL', 'FAILED', '{}', ?, 'other-lecture')", userID)
+
+	sendRequest := func(body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("POST", "/api/tools", strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer "+sessionID)
+		req.Header.Set("X-Requested-With", "XMLHttpRequest")
+		req.Header.Set("Content-Type", "application/json")
+		rr := httptest.NewRecorder()
+		server.Handler().ServeHTTP(rr, req)
+		return rr
+	}
+
+	cases := []struct {
+		name         string
+		body         string
+		expectedCode int
+	}{
+		{"running build", `{"exam_id":"resume-exam","lecture_id":"resume-lecture","language_code":"en","resume_job_id":"running-build"}`, http.StatusConflict},
+		{"build of another lecture", `{"exam_id":"resume-exam","lecture_id":"resume-lecture","language_code":"en","resume_job_id":"other-build"}`, http.StatusBadRequest},
+		{"flashcards", `{"exam_id":"resume-exam","lecture_id":"resume-lecture","language_code":"en","type":"flashcard","resume_job_id":"failed-build"}`, http.StatusBadRequest},
+		{"failed build", `{"exam_id":"resume-exam","lecture_id":"resume-lecture","language_code":"en","resume_job_id":"failed-build"}`, http.StatusAccepted},
+	}
+	for _, testCase := range cases {
+		if response := sendRequest(testCase.body); response.Code != testCase.expectedCode {
+			t.Errorf("%s: expected %d, got %d: %s", testCase.name, testCase.expectedCode, response.Code, response.Body.String())
+		}
+	}
+}
+
+func TestCreateCourseOverview(t *testing.T) {
+	server, userID, sessionID, cleanup := setupUniqueExtraTestEnv(t, "course_overview")
+	defer cleanup()
+
+	server.database.Exec("INSERT INTO exams (id, user_id, title) VALUES ('overview-exam', ?, 'Physics')", userID)
+	server.database.Exec("INSERT INTO lectures (id, exam_id, title, status) VALUES ('overview-lecture', 'overview-exam', 'Optics', 'processing')")
+
+	createTool := func(body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("POST", "/api/tools", strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer "+sessionID)
+		req.Header.Set("X-Requested-With", "XMLHttpRequest")
+		req.Header.Set("Content-Type", "application/json")
+		rr := httptest.NewRecorder()
+		server.Handler().ServeHTTP(rr, req)
+		return rr
+	}
+
+	if rr := createTool(`{"exam_id":"overview-exam","lecture_id":"overview-lecture","type":"course_overview"}`); rr.Code != http.StatusBadRequest {
+		t.Errorf("Expected status 400 for a course overview of a lecture, got %d: %s", rr.Code, rr.Body.String())
+	}
+	if rr := createTool(`{"exam_id":"overview-exam","type":"course_overview","language_code":"en"}`); rr.Code != http.StatusConflict {
+		t.Errorf("Expected status 409 while no lecture is ready, got %d: %s", rr.Code, rr.Body.String())
+	}
+
+	server.database.Exec("UPDATE lectures SET status = 'ready'")
+	server.database.Exec("INSERT INTO tools (id, exam_id, lecture_id, type, title, content) VALUES ('previous-overview', 'overview-exam', NULL, 'course_overview', 'Physics', '# Physics')")
+	server.database.Exec("INSERT INTO tools (id, exam_id, lecture_id, type, title, content) VALUES ('lecture-guide', 'overview-exam', 'overview-lecture', 'guide', 'Optics', '# Optics')")
+
+	rr := createTool(`{"exam_id":"overview-exam","type":"course_overview","language_code":"en"}`)
+	if rr.Code != http.StatusAccepted {
+		t.Fatalf("Expected status 202, got %d: %s", rr.Code, rr.Body.String())
+	}
+	var response struct {
+		Data struct {
+			JobID string `json:"job_id"`
+		} `json:"data"`
+	}
+	json.Unmarshal(rr.Body.Bytes(), &response)
+
+	var jobLectureID sql.NullString
+	var payload string
+	server.database.QueryRow("SELECT lecture_id, payload FROM jobs WHERE id = ?", response.Data.JobID).Scan(&jobLectureID, &payload)
+	if jobLectureID.Valid || !strings.Contains(payload, `"type":"course_overview"`) {
+		t.Errorf("Expected an exam-wide job building a course overview, got lecture %v and payload %s", jobLectureID, payload)
+	}
+
+	var overviews, guides int
+	server.database.QueryRow("SELECT COUNT(*) FROM tools WHERE type = 'course_overview'").Scan(&overviews)
+	server.database.QueryRow("SELECT COUNT(*) FROM tools WHERE type = 'guide'").Scan(&guides)
+	if overviews != 0 || guides != 1 {
+		t.Errorf("Expected the previous overview replaced and the lecture's guide kept, got %d overviews and %d guides", overviews, guides)
+	}
+}
@@ -0,0 +1,45 @@
+package api
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"lectures/internal/database"
+)
+
+// handleGetUsage aggregates the tokens and estimated cost of the jobs charged to the caller over the last days,
+// grouped by day, job type, model or exam
+func (server *Server) handleGetUsage(responseWriter http.ResponseWriter, request *http.Request) {
+	groupBy := request.URL.Query().Get("group_by")
+	if groupBy == "" {
+		groupBy = database.UsageGroupByDay
+	}
+	if !database.IsValidUsageGrouping(groupBy) {
+		server.writeError(responseWriter, http.StatusBadRequest, "VALIDATION_ERROR", "group_by must be one of day, job_type, model, exam", nil)
+		return
+	}
+
+	windowDays := 30
+	if daysValue := request.URL.Query().Get("days"); daysValue != "" {
+		parsedDays, err := strconv.Atoi(daysValue)
+		if err != nil || parsedDays < 1 || parsedDays > 365 {
+			server.writeError(responseWriter, http.StatusBadRequest, "VALIDATION_ERROR", "days must be between 1 and 365", nil)
+			return
+		}
+		windowDays = parsedDays
+	}
+
+	groups, total, err := database.GetUserUsage(server.database, server.getUserID(request), groupBy, time.Now().AddDate(0, 0, -windowDays))
+	if err != nil {
+		server.writeError(responseWriter, http.StatusInternalServerError, "DATABASE_ERROR", "Failed to read usage", nil)
+		return
+	}
+
+	server.writeJSON(responseWriter, http.StatusOK, map[string]any{
+		"group_by": groupBy,
+		"days":     windowDays,
+		"groups":   groups,
+		"total":    total,
+	})
+}
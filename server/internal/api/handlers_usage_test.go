@@ -0,0 +1,160 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"lectures/internal/models"
+)
+
+func TestHandleUsageStatisticsPrivacy(t *testing.T) {
+	server, userID, sessionID, cleanup := setupUniqueExtraTestEnv(t, "usagestats")
+	defer cleanup()
+
+	sendRequest := func(path string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", path, nil)
+		req.Header.Set("Authorization", "Bearer "+sessionID)
+		rr := httptest.NewRecorder()
+		server.Handler().ServeHTTP(rr, req)
+		return rr
+	}
+
+	if rr := sendRequest("/api/admin/stats"); rr.Code != http.StatusForbidden {
+		t.Fatalf("Expected status 403 for non-admin, got %d", rr.Code)
+	}
+	server.database.Exec("UPDATE users SET role = 'admin' WHERE id = ?", userID)
+
+	// Two users studied alone yesterday; neither bucket reaches the default group size of 5
+	server.database.Exec("INSERT INTO users (id, username, password_hash, role) VALUES ('other-user', 'other', 'x', 'teacher')")
+	for index, owner := range []string{userID, userID, "other-user"} {
+		server.database.Exec("INSERT INTO jobs (id, user_id, type, status, payload, estimated_cost, created_at) VALUES (?, ?, ?, 'COMPLETED', '{}', 0.5, ?)",
+			fmt.Sprintf("stats-job-%d", index), owner, models.JobTypeBuildMaterial, time.Now().AddDate(0, 0, -1))
+	}
+	// Today's jobs are outside every window, and an unreadable timestamp is skipped instead of failing the request
+	server.database.Exec("INSERT INTO jobs (id, user_id, type, status, payload, estimated_cost, created_at) VALUES ('stats-job-today', ?, ?, 'COMPLETED', '{}', 0.5, ?)",
+		userID, models.JobTypeBuildMaterial, time.Now())
+	server.database.Exec("INSERT INTO jobs (id, user_id, type, status, payload, estimated_cost, created_at) VALUES ('stats-job-garbled', ?, ?, 'COMPLETED', '{}', 0.5, 'not a date')",
+		userID, models.JobTypeBuildMaterial)
+
+	var response struct {
+		Data struct {
+			Policy     string `json:"policy"`
+			JobsByType []struct {
+				Key           string   `json:"key"`
+				Count         int      `json:"count"`
+				EstimatedCost *float64 `json:"estimated_cost"`
+			} `json:"jobs_by_type"`
+			EstimatedCostTotal *float64 `json:"estimated_cost_total"`
+			SuppressedBuckets  int      `json:"suppressed_buckets"`
+			EpsilonSpent       float64  `json:"epsilon_spent"`
+		} `json:"data"`
+	}
+
+	rr := sendRequest("/api/admin/stats")
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d. Body: %s", rr.Code, rr.Body.String())
+	}
+	json.Unmarshal(rr.Body.Bytes(), &response)
+	if response.Data.Policy != "private" || len(response.Data.JobsByType) != 0 || response.Data.EstimatedCostTotal != nil {
+		t.Errorf("Expected small groups to be withheld under the private policy, got %+v", response.Data)
+	}
+	if response.Data.SuppressedBuckets != 2 {
+		t.Errorf("Expected the job type and day buckets to be suppressed, got %d", response.Data.SuppressedBuckets)
+	}
+	if response.Data.EpsilonSpent != 6 {
+		t.Errorf("Expected the first release to spend 6 of the budget, got %v", response.Data.EpsilonSpent)
+	}
+
+	// Reading the same window again is free, while a new window beyond the daily budget is refused
+	var first, again struct {
+		Data json.RawMessage `json:"data"`
+	}
+	json.Unmarshal(rr.Body.Bytes(), &first)
+	rr = sendRequest("/api/admin/stats")
+	json.Unmarshal(rr.Body.Bytes(), &again)
+	if rr.Code != http.StatusOK || string(first.Data) != string(again.Data) {
+		t.Errorf("Expected the same window to return the same figures, got %d: %s", rr.Code, rr.Body.String())
+	}
+	server.configuration.Privacy.UsageStatistics.EpsilonBudget = 10
+	if rr := sendRequest("/api/admin/stats?days=3"); rr.Code != http.StatusTooManyRequests || !strings.Contains(rr.Body.String(), "PRIVACY_BUDGET_EXHAUSTED") {
+		t.Errorf("Expected status 429 once the budget is spent, got %d: %s", rr.Code, rr.Body.String())
+	}
+
+	server.configuration.Privacy.UsageStatistics.Policy = "exact"
+	rr = sendRequest("/api/admin/stats?days=7")
+	json.Unmarshal(rr.Body.Bytes(), &response)
+	if len(response.Data.JobsByType) != 1 || response.Data.JobsByType[0].Count != 3 || response.Data.JobsByType[0].EstimatedCost == nil || *response.Data.EstimatedCostTotal != 1.5 {
+		t.Errorf("Expected exact per-type figures, got %+v", response.Data.JobsByType)
+	}
+
+	if rr := sendRequest("/api/admin/stats?days=0"); rr.Code != http.StatusBadRequest {
+		t.Errorf("Expected status 400 for an invalid window, got %d", rr.Code)
+	}
+
+	server.configuration.Privacy.UsageStatistics.Policy = "disabled"
+	if rr := sendRequest("/api/admin/stats"); rr.Code != http.StatusForbidden {
+		t.Errorf("Expected status 403 when statistics are disabled, got %d", rr.Code)
+	}
+}
+
+func TestHandleGetUsage(t *testing.T) {
+	server, userID, sessionID, cleanup := setupUniqueExtraTestEnv(t, "usage")
+	defer cleanup()
+
+	server.database.Exec("INSERT INTO exams (id, user_id, title) VALUES ('usage-exam', ?, 'Optics')", userID)
+	insertJob := func(jobID string, jobType string, model string, examID any, cost float64, createdAt time.Time) {
+		server.database.Exec(`
+			INSERT INTO jobs (id, user_id, course_id, type, status, model, payload, input_tokens, output_tokens, estimated_cost, created_at)
+			VALUES (?, ?, ?, ?, 'COMPLETED', ?, '{}', 100, 10, ?, ?)
+		`, jobID, userID, examID, jobType, model, cost, createdAt)
+	}
+	insertJob("usage-build", "BUILD_MATERIAL", "generation-model", "usage-exam", 0.5, time.Now())
+	insertJob("usage-polish", "POLISH_TRANSCRIPT", "polishing-model", "usage-exam", 0.25, time.Now())
+	insertJob("usage-suggest", "SUGGEST", "polishing-model", nil, 0.25, time.Now())
+	insertJob("usage-old", "BUILD_MATERIAL", "generation-model", "usage-exam", 9.0, time.Now().AddDate(0, 0, -40))
+
+	getUsage := func(query string) (int, map[string]any) {
+		req := httptest.NewRequest("GET", "/api/usage"+query, nil)
+		req.Header.Set("Authorization", "Bearer "+sessionID)
+		rr := httptest.NewRecorder()
+		server.Handler().ServeHTTP(rr, req)
+		var response struct {
+			Data map[string]any `json:"data"`
+		}
+		json.Unmarshal(rr.Body.Bytes(), &response)
+		return rr.Code, response.Data
+	}
+
+	code, usage := getUsage("?group_by=model")
+	if code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d", code)
+	}
+	groups := usage["groups"].([]any)
+	if len(groups) != 2 {
+		t.Fatalf("Expected 2 models used in the last 30 days, got %v", groups)
+	}
+	firstGroup := groups[0].(map[string]any)
+	if firstGroup["key"] != "generation-model" || firstGroup["estimated_cost"] != 0.5 {
+		t.Errorf("Expected the generation model first with $0.50, got %v", firstGroup)
+	}
+	total := usage["total"].(map[string]any)
+	if total["jobs"] != 3.0 || total["input_tokens"] != 300.0 || total["estimated_cost"] != 1.0 {
+		t.Errorf("Expected 3 jobs, 300 input tokens and $1.00 in total, got %v", total)
+	}
+
+	_, usage = getUsage("?group_by=exam&days=60")
+	groups = usage["groups"].([]any)
+	examGroup := groups[0].(map[string]any)
+	if examGroup["key"] != "usage-exam" || examGroup["label"] != "Optics" || examGroup["jobs"] != 3.0 {
+		t.Errorf("Expected the exam with 3 jobs over 60 days first, got %v", examGroup)
+	}
+
+	if code, _ := getUsage("?group_by=week"); code != http.StatusBadRequest {
+		t.Errorf("Expected status 400 for an unknown grouping, got %d", code)
+	}
+}
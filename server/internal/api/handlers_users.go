@@ -0,0 +1,291 @@
+package api
+
+import (
+	"database/sql"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
+	"lectures/internal/database"
+	"lectures/internal/models"
+
+	gonanoid "github.com/matoous/go-nanoid/v2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// isUserRole reports whether role is one of the UserRole* roles
+func isUserRole(role string) bool {
+	_, known := rolePermissions[role]
+	return known
+}
+
+// handleListUsers lists every account with its role
+func (server *Server) handleListUsers(responseWriter http.ResponseWriter, request *http.Request) {
+	if !server.requireAdmin(responseWriter, request) {
+		return
+	}
+	users, err := database.ListUsers(server.database)
+	if err != nil {
+		server.writeError(responseWriter, http.StatusInternalServerError, "DATABASE_ERROR", "Failed to list users", nil)
+		return
+	}
+	server.writeJSON(responseWriter, http.StatusOK, users)
+}
+
+// handleCreateUser creates an account with a role, teacher unless another is given. With
+// "require_password_change" the user must choose a new password before using anything else
+func (server *Server) handleCreateUser(responseWriter http.ResponseWriter, request *http.Request) {
+	if !server.requireAdmin(responseWriter, request) {
+		return
+	}
+
+	var createRequest struct {
+		Username              string `json:"username"`
+		Password              string `json:"password"`
+		Role                  string `json:"role"`
+		Email                 string `json:"email"`
+		RequirePasswordChange bool   `json:"require_password_change"`
+	}
+	if err := json.NewDecoder(request.Body).Decode(&createRequest); err != nil {
+		server.writeError(responseWriter, http.StatusBadRequest, "VALIDATION_ERROR", "Invalid request body", nil)
+		return
+	}
+	createRequest.Username = strings.TrimSpace(createRequest.Username)
+	if createRequest.Username == "" {
+		server.writeError(responseWriter, http.StatusBadRequest, "VALIDATION_ERROR", "Username is required", nil)
+		return
+	}
+	if len(createRequest.Password) < 8 {
+		server.writeError(responseWriter, http.StatusBadRequest, "VALIDATION_ERROR", "Password must be at least 8 characters", nil)
+		return
+	}
+	if createRequest.Role == "" {
+		createRequest.Role = models.UserRoleTeacher
+	}
+	if !isUserRole(createRequest.Role) {
+		server.writeError(responseWriter, http.StatusBadRequest, "VALIDATION_ERROR", "role must be admin, teacher or student", nil)
+		return
+	}
+
+	var exists bool
+	server.database.QueryRow("SELECT EXISTS(SELECT 1 FROM users WHERE username = ?)", createRequest.Username).Scan(&exists)
+	if exists {
+		server.writeError(responseWriter, http.StatusConflict, "USERNAME_TAKEN", "Username is already registered", nil)
+		return
+	}
+
+	var email any
+	if strings.TrimSpace(createRequest.Email) != "" {
+		address, err := normalizeEmail(createRequest.Email)
+		if err != nil {
+			server.writeError(responseWriter, http.StatusBadRequest, "VALIDATION_ERROR", err.Error(), nil)
+			return
+		}
+		server.database.QueryRow("SELECT EXISTS(SELECT 1 FROM users WHERE email = ? COLLATE NOCASE)", address).Scan(&exists)
+		if exists {
+			server.writeError(responseWriter, http.StatusConflict, "EMAIL_TAKEN", "Email address is already used by another account", nil)
+			return
+		}
+		email = address
+	}
+
+	passwordHash, err := bcrypt.GenerateFromPassword([]byte(createRequest.Password), bcrypt.DefaultCost)
+	if err != nil {
+		server.writeError(responseWriter, http.StatusInternalServerError, "AUTHENTICATION_ERROR", "Failed to hash password", nil)
+		return
+	}
+	userID, _ := gonanoid.New()
+	_, err = server.database.Exec(`
+		INSERT INTO users (id, username, password_hash, role, email, password_change_required, created_at, updated_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+	`, userID, createRequest.Username, string(passwordHash), createRequest.Role, email, createRequest.RequirePasswordChange, time.Now(), time.Now())
+	if err != nil {
+		server.writeError(responseWriter, http.StatusInternalServerError, "DATABASE_ERROR", "Failed to create user", nil)
+		return
+	}
+	server.recordAuditEvent(request, models.AuditEvent{Action: models.AuditActionAccountCreated, ResourceType: "user", ResourceID: userID, Details: map[string]any{"username": createRequest.Username, "role": createRequest.Role}})
+	slog.Info("Administrator created a user", "adminID", server.getUserID(request), "userID", userID, "role", createRequest.Role)
+
+	user, err := database.GetUser(server.database, userID)
+	if err != nil {
+		server.writeError(responseWriter, http.StatusInternalServerError, "DATABASE_ERROR", "Failed to read the created user", nil)
+		return
+	}
+	server.writeJSON(responseWriter, http.StatusCreated, user)
+}
+
+// handleUpdateUser changes the role or email address of an account, resets its password, which signs it out
+// everywhere, or requires it to choose a new password at its next request. The last administrator cannot lose
+// the role
+func (server *Server) handleUpdateUser(responseWriter http.ResponseWriter, request *http.Request) {
+	if !server.requireAdmin(responseWriter, request) {
+		return
+	}
+
+	var updateRequest struct {
+		UserID                string  `json:"user_id"`
+		Role                  *string `json:"role"`
+		Password              *string `json:"password"`
+		Email                 *string `json:"email"` // Empty clears it
+		RequirePasswordChange *bool   `json:"require_password_change"`
+	}
+	if err := json.NewDecoder(request.Body).Decode(&updateRequest); err != nil {
+		server.writeError(responseWriter, http.StatusBadRequest, "VALIDATION_ERROR", "Invalid request body", nil)
+		return
+	}
+	if updateRequest.UserID == "" {
+		server.writeError(responseWriter, http.StatusBadRequest, "VALIDATION_ERROR", "user_id is required", nil)
+		return
+	}
+	if updateRequest.Role != nil && !isUserRole(*updateRequest.Role) {
+		server.writeError(responseWriter, http.StatusBadRequest, "VALIDATION_ERROR", "role must be admin, teacher or student", nil)
+		return
+	}
+	if updateRequest.Password != nil && len(*updateRequest.Password) < 8 {
+		server.writeError(responseWriter, http.StatusBadRequest, "VALIDATION_ERROR", "Password must be at least 8 characters", nil)
+		return
+	}
+	var email any
+	if updateRequest.Email != nil && strings.TrimSpace(*updateRequest.Email) != "" {
+		address, err := normalizeEmail(*updateRequest.Email)
+		if err != nil {
+			server.writeError(responseWriter, http.StatusBadRequest, "VALIDATION_ERROR", err.Error(), nil)
+			return
+		}
+		email = address
+	}
+
+	user, err := database.GetUser(server.database, updateRequest.UserID)
+	if err == sql.ErrNoRows {
+		server.writeError(responseWriter, http.StatusNotFound, "NOT_FOUND", "User not found", nil)
+		return
+	}
+	if err != nil {
+		server.writeError(responseWriter, http.StatusInternalServerError, "DATABASE_ERROR", "Failed to read user", nil)
+		return
+	}
+	if updateRequest.Role != nil && user.Role == models.UserRoleAdmin && *updateRequest.Role != models.UserRoleAdmin && !server.hasOtherAdministrators(responseWriter) {
+		return
+	}
+
+	if updateRequest.Role != nil {
+		if _, err := server.database.Exec("UPDATE users SET role = ?, updated_at = ? WHERE id = ?", *updateRequest.Role, time.Now(), user.ID); err != nil {
+			server.writeError(responseWriter, http.StatusInternalServerError, "DATABASE_ERROR", "Failed to update role", nil)
+			return
+		}
+		slog.Info("Administrator changed the role of a user", "adminID", server.getUserID(request), "userID", user.ID, "from", user.Role, "to", *updateRequest.Role)
+	}
+	if updateRequest.Password != nil {
+		passwordHash, err := bcrypt.GenerateFromPassword([]byte(*updateRequest.Password), bcrypt.DefaultCost)
+		if err != nil {
+			server.writeError(responseWriter, http.StatusInternalServerError, "AUTHENTICATION_ERROR", "Failed to hash password", nil)
+			return
+		}
+		// The sessions and API tokens of the account are revoked along with the old password
+		transaction, err := server.database.Begin()
+		if err != nil {
+			server.writeError(responseWriter, http.StatusInternalServerError, "DATABASE_ERROR", "Failed to start transaction", nil)
+			return
+		}
+		defer transaction.Rollback()
+		if _, err := transaction.Exec("UPDATE users SET password_hash = ?, updated_at = ? WHERE id = ?", string(passwordHash), time.Now(), user.ID); err != nil {
+			server.writeError(responseWriter, http.StatusInternalServerError, "DATABASE_ERROR", "Failed to update password", nil)
+			return
+		}
+		if _, err := transaction.Exec("DELETE FROM auth_sessions WHERE user_id = ?", user.ID); err != nil {
+			server.writeError(responseWriter, http.StatusInternalServerError, "DATABASE_ERROR", "Failed to sign out sessions", nil)
+			return
+		}
+		if _, err := transaction.Exec("DELETE FROM api_tokens WHERE user_id = ?", user.ID); err != nil {
+			server.writeError(responseWriter, http.StatusInternalServerError, "DATABASE_ERROR", "Failed to revoke API tokens", nil)
+			return
+		}
+		if err := transaction.Commit(); err != nil {
+			server.writeError(responseWriter, http.StatusInternalServerError, "DATABASE_ERROR", "Failed to commit transaction", nil)
+			return
+		}
+		server.recordAuditEvent(request, models.AuditEvent{Action: models.AuditActionPasswordChanged, ResourceType: "user", ResourceID: user.ID})
+		slog.Info("Administrator reset the password of a user", "adminID", server.getUserID(request), "userID", user.ID)
+	}
+	if updateRequest.Email != nil {
+		if !server.setUserEmail(responseWriter, user.ID, email) {
+			return
+		}
+		server.recordAuditEvent(request, models.AuditEvent{Action: models.AuditActionEmailChanged, ResourceType: "user", ResourceID: user.ID})
+	}
+	if updateRequest.RequirePasswordChange != nil {
+		if _, err := server.database.Exec("UPDATE users SET password_change_required = ?, updated_at = ? WHERE id = ?", *updateRequest.RequirePasswordChange, time.Now(), user.ID); err != nil {
+			server.writeError(responseWriter, http.StatusInternalServerError, "DATABASE_ERROR", "Failed to update user", nil)
+			return
+		}
+		slog.Info("Administrator changed whether a user must change password", "adminID", server.getUserID(request), "userID", user.ID, "required", *updateRequest.RequirePasswordChange)
+	}
+
+	user, err = database.GetUser(server.database, user.ID)
+	if err != nil {
+		server.writeError(responseWriter, http.StatusInternalServerError, "DATABASE_ERROR", "Failed to read the updated user", nil)
+		return
+	}
+	server.writeJSON(responseWriter, http.StatusOK, user)
+}
+
+// handleDeleteUser deletes an account along with the exams it owns and everything in them. Administrators cannot
+// delete themselves, and accounts with jobs still pending or running must wait for them or cancel them first
+func (server *Server) handleDeleteUser(responseWriter http.ResponseWriter, request *http.Request) {
+	if !server.requireAdmin(responseWriter, request) {
+		return
+	}
+
+	userID := request.URL.Query().Get("user_id")
+	if userID == "" {
+		server.writeError(responseWriter, http.StatusBadRequest, "VALIDATION_ERROR", "user_id is required", nil)
+		return
+	}
+	if userID == server.getUserID(request) {
+		server.writeError(responseWriter, http.StatusBadRequest, "VALIDATION_ERROR", "You cannot delete your own account", nil)
+		return
+	}
+	user, err := database.GetUser(server.database, userID)
+	if err == sql.ErrNoRows {
+		server.writeError(responseWriter, http.StatusNotFound, "NOT_FOUND", "User not found", nil)
+		return
+	}
+	if err != nil {
+		server.writeError(responseWriter, http.StatusInternalServerError, "DATABASE_ERROR", "Failed to read user", nil)
+		return
+	}
+	if user.Role == models.UserRoleAdmin && !server.hasOtherAdministrators(responseWriter) {
+		return
+	}
+
+	var activeJobs int
+	server.database.QueryRow("SELECT COUNT(*) FROM jobs WHERE user_id = ? AND status IN (?, ?)", userID, models.JobStatusPending, models.JobStatusRunning).Scan(&activeJobs)
+	if activeJobs > 0 {
+		server.writeError(responseWriter, http.StatusConflict, "USER_HAS_ACTIVE_JOBS", "The user has jobs pending or running", map[string]int{"active_jobs": activeJobs})
+		return
+	}
+
+	if _, err := server.database.Exec("DELETE FROM users WHERE id = ?", userID); err != nil {
+		server.writeError(responseWriter, http.StatusInternalServerError, "DATABASE_ERROR", "Failed to delete user", nil)
+		return
+	}
+	slog.Info("Administrator deleted a user", "adminID", server.getUserID(request), "userID", userID, "username", user.Username)
+	server.writeJSON(responseWriter, http.StatusOK, map[string]string{"message": "User deleted"})
+}
+
+// hasOtherAdministrators refuses to remove an administrator when they are the last one, since nobody could
+// manage the server anymore
+func (server *Server) hasOtherAdministrators(responseWriter http.ResponseWriter) bool {
+	administrators, err := database.CountAdministrators(server.database)
+	if err != nil {
+		server.writeError(responseWriter, http.StatusInternalServerError, "DATABASE_ERROR", "Failed to count administrators", nil)
+		return false
+	}
+	if administrators <= 1 {
+		server.writeError(responseWriter, http.StatusConflict, "LAST_ADMINISTRATOR", "The last administrator cannot be removed", nil)
+		return false
+	}
+	return true
+}
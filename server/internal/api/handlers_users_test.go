@@ -0,0 +1,118 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"lectures/internal/models"
+
+	gonanoid "github.com/matoous/go-nanoid/v2"
+)
+
+func TestHandleUserRoles(t *testing.T) {
+	server, userID, sessionID, cleanup := setupUniqueExtraTestEnv(t, "roles")
+	defer cleanup()
+
+	sendRequest := func(session string, method string, target string, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, target, strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer "+session)
+		req.Header.Set("X-Requested-With", "XMLHttpRequest")
+		req.Header.Set("Content-Type", "application/json")
+		rr := httptest.NewRecorder()
+		server.Handler().ServeHTTP(rr, req)
+		return rr
+	}
+
+	if response := sendRequest(sessionID, "GET", "/api/admin/users", ""); response.Code != http.StatusForbidden {
+		t.Errorf("Expected teachers kept out of user management, got %d", response.Code)
+	}
+	server.database.Exec("UPDATE users SET role = 'admin' WHERE id = ?", userID)
+
+	if response := sendRequest(sessionID, "POST", "/api/admin/users", `{"username":"pupil","password":"password123","role":"janitor"}`); response.Code != http.StatusBadRequest {
+		t.Errorf("Expected an unknown role refused, got %d", response.Code)
+	}
+	response := sendRequest(sessionID, "POST", "/api/admin/users", `{"username":"pupil","password":"password123","role":"student"}`)
+	if response.Code != http.StatusCreated {
+		t.Fatalf("Expected the student created, got %d: %s", response.Code, response.Body.String())
+	}
+	var createResponse struct {
+		Data models.User `json:"data"`
+	}
+	json.Unmarshal(response.Body.Bytes(), &createResponse)
+	studentID := createResponse.Data.ID
+	if createResponse.Data.Role != models.UserRoleStudent {
+		t.Errorf("Expected the student role, got %q", createResponse.Data.Role)
+	}
+	if response := sendRequest(sessionID, "POST", "/api/admin/users", `{"username":"pupil","password":"password123"}`); response.Code != http.StatusConflict {
+		t.Errorf("Expected a taken username refused, got %d", response.Code)
+	}
+
+	studentSession := gonanoid.Must()
+	server.database.Exec("INSERT INTO auth_sessions (id, user_id, created_at, last_activity, expires_at) VALUES (?, ?, ?, ?, ?)", studentSession, studentID, time.Now(), time.Now(), time.Now().Add(time.Hour))
+
+	// Students read but create nothing and change no settings
+	if response := sendRequest(studentSession, "GET", "/api/exams", ""); response.Code != http.StatusOK {
+		t.Errorf("Expected students to list exams, got %d", response.Code)
+	}
+	response = sendRequest(studentSession, "POST", "/api/exams", `{"title":"Physics"}`)
+	if response.Code != http.StatusForbidden || !strings.Contains(response.Body.String(), models.PermissionCreateExams) {
+		t.Errorf("Expected students refused to create exams, got %d: %s", response.Code, response.Body.String())
+	}
+	if response := sendRequest(studentSession, "POST", "/api/tools", `{"exam_id":"x","lecture_id":"y"}`); response.Code != http.StatusForbidden {
+		t.Errorf("Expected students refused to queue generation, got %d", response.Code)
+	}
+	if response := sendRequest(studentSession, "PATCH", "/api/settings", `{}`); response.Code != http.StatusForbidden {
+		t.Errorf("Expected students refused to change settings, got %d", response.Code)
+	}
+
+	// A new role applies to sessions already open
+	if response := sendRequest(sessionID, "PATCH", "/api/admin/users", `{"user_id":"`+studentID+`","role":"teacher"}`); response.Code != http.StatusOK {
+		t.Fatalf("Expected the role changed, got %d: %s", response.Code, response.Body.String())
+	}
+	if response := sendRequest(studentSession, "POST", "/api/exams", `{"title":"Physics"}`); response.Code != http.StatusCreated {
+		t.Errorf("Expected the promoted teacher to create an exam, got %d: %s", response.Code, response.Body.String())
+	}
+
+	// A reset password signs the user out
+	if response := sendRequest(sessionID, "PATCH", "/api/admin/users", `{"user_id":"`+studentID+`","password":"newpassword1"}`); response.Code != http.StatusOK {
+		t.Fatalf("Expected the password reset, got %d", response.Code)
+	}
+	if response := sendRequest(studentSession, "GET", "/api/exams", ""); response.Code != http.StatusUnauthorized {
+		t.Errorf("Expected the sessions of the user ended, got %d", response.Code)
+	}
+
+	if response := sendRequest(sessionID, "PATCH", "/api/admin/users", `{"user_id":"`+userID+`","role":"teacher"}`); response.Code != http.StatusConflict {
+		t.Errorf("Expected the last administrator kept, got %d", response.Code)
+	}
+	if response := sendRequest(sessionID, "DELETE", "/api/admin/users?user_id="+userID, ""); response.Code != http.StatusBadRequest {
+		t.Errorf("Expected administrators unable to delete themselves, got %d", response.Code)
+	}
+
+	server.database.Exec("INSERT INTO jobs (id, type, status, payload, user_id) VALUES ('roles-job', 'BUILD_MATERIAL', 'PENDING', '{}', ?)", studentID)
+	if response := sendRequest(sessionID, "DELETE", "/api/admin/users?user_id="+studentID, ""); response.Code != http.StatusConflict {
+		t.Errorf("Expected a user with pending jobs kept, got %d", response.Code)
+	}
+	server.database.Exec("UPDATE jobs SET status = 'CANCELLED' WHERE id = 'roles-job'")
+	if response := sendRequest(sessionID, "DELETE", "/api/admin/users?user_id="+studentID, ""); response.Code != http.StatusOK {
+		t.Fatalf("Expected the user deleted, got %d: %s", response.Code, response.Body.String())
+	}
+	var examCount int
+	server.database.QueryRow("SELECT COUNT(*) FROM exams WHERE user_id = ?", studentID).Scan(&examCount)
+	if examCount != 0 {
+		t.Errorf("Expected the exams of the deleted user removed, found %d", examCount)
+	}
+
+	server.configuration.Security.Auth.RegistrationRole = models.UserRoleStudent
+	registerRequest := httptest.NewRequest("POST", "/api/auth/register", strings.NewReader(`{"username":"newcomer","password":"password123"}`))
+	registerRecorder := httptest.NewRecorder()
+	server.Handler().ServeHTTP(registerRecorder, registerRequest)
+	var registeredRole string
+	server.database.QueryRow("SELECT role FROM users WHERE username = 'newcomer'").Scan(&registeredRole)
+	if registerRecorder.Code != http.StatusCreated || registeredRole != models.UserRoleStudent {
+		t.Errorf("Expected a self-registered student, got %d and role %q", registerRecorder.Code, registeredRole)
+	}
+}
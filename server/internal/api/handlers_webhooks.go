@@ -0,0 +1,379 @@
+package api
+
+import (
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"lectures/internal/database"
+	"lectures/internal/models"
+	"lectures/internal/webhooks"
+
+	gonanoid "github.com/matoous/go-nanoid/v2"
+)
+
+// Bounds of the webhooks users register
+const (
+	maximumWebhooksPerUser          = 20
+	maximumWebhookURLLength         = 2048
+	maximumWebhookDescriptionLength = 200
+	minimumWebhookSecretLength      = 16
+)
+
+// webhookSecretPrefix starts the secrets the server generates for webhooks
+const webhookSecretPrefix = "whsec_"
+
+// SetWebhookDispatcher enables users to register webhooks, whose events the dispatcher delivers
+func (server *Server) SetWebhookDispatcher(dispatcher *webhooks.Dispatcher) {
+	server.webhookDispatcher = dispatcher
+}
+
+// requireWebhooks writes the error of the webhook routes when no dispatcher is set, which is when no encryption
+// key is available to seal the webhook secrets
+func (server *Server) requireWebhooks(responseWriter http.ResponseWriter) bool {
+	if server.webhookDispatcher == nil || server.secretCipher == nil {
+		server.writeError(responseWriter, http.StatusServiceUnavailable, "WEBHOOKS_UNAVAILABLE", "Webhooks are disabled because no encryption key is available", nil)
+		return false
+	}
+	return true
+}
+
+// validateWebhookEvents checks that events names subscribable events, or "*" for all of them
+func validateWebhookEvents(events []string) error {
+	if len(events) == 0 {
+		return errors.New("events is required")
+	}
+	for _, event := range events {
+		if event != webhooks.AllEvents && !slices.Contains(webhooks.Events, event) {
+			return errors.New("events must be among " + strings.Join(webhooks.Events, ", ") + ", or * for all of them")
+		}
+	}
+	return nil
+}
+
+// handleListWebhooks lists the webhooks of the authenticated user, never their secrets
+func (server *Server) handleListWebhooks(responseWriter http.ResponseWriter, request *http.Request) {
+	registeredWebhooks, err := database.ListWebhooks(server.database, server.getUserID(request))
+	if err != nil {
+		server.writeError(responseWriter, http.StatusInternalServerError, "DATABASE_ERROR", "Failed to list webhooks", nil)
+		return
+	}
+	server.writeJSON(responseWriter, http.StatusOK, registeredWebhooks)
+}
+
+// handleCreateWebhook registers a webhook of the authenticated user. Its secret, generated unless given, is
+// only returned here
+func (server *Server) handleCreateWebhook(responseWriter http.ResponseWriter, request *http.Request) {
+	if !server.requireWebhooks(responseWriter) {
+		return
+	}
+
+	var webhookRequest struct {
+		URL         string   `json:"url"`
+		Events      []string `json:"events"`
+		Description string   `json:"description"`
+		Secret      string   `json:"secret"`
+	}
+	if err := json.NewDecoder(request.Body).Decode(&webhookRequest); err != nil {
+		server.writeError(responseWriter, http.StatusBadRequest, "VALIDATION_ERROR", "Invalid body", nil)
+		return
+	}
+	webhookURL := strings.TrimSpace(webhookRequest.URL)
+	if len(webhookURL) > maximumWebhookURLLength {
+		server.writeError(responseWriter, http.StatusBadRequest, "VALIDATION_ERROR", "url cannot be longer than 2048 characters", nil)
+		return
+	}
+	if err := server.webhookDispatcher.ValidateURL(webhookURL); err != nil {
+		server.writeError(responseWriter, http.StatusBadRequest, "VALIDATION_ERROR", err.Error(), nil)
+		return
+	}
+	if err := validateWebhookEvents(webhookRequest.Events); err != nil {
+		server.writeError(responseWriter, http.StatusBadRequest, "VALIDATION_ERROR", err.Error(), nil)
+		return
+	}
+	description := strings.TrimSpace(webhookRequest.Description)
+	if len(description) > maximumWebhookDescriptionLength {
+		server.writeError(responseWriter, http.StatusBadRequest, "VALIDATION_ERROR", "description cannot be longer than 200 characters", nil)
+		return
+	}
+	secret := webhookRequest.Secret
+	if secret == "" {
+		randomBytes := make([]byte, 24)
+		if _, err := rand.Read(randomBytes); err != nil {
+			server.writeError(responseWriter, http.StatusInternalServerError, "INTERNAL_ERROR", "Failed to generate webhook secret", nil)
+			return
+		}
+		secret = webhookSecretPrefix + hex.EncodeToString(randomBytes)
+	} else if len(secret) < minimumWebhookSecretLength {
+		server.writeError(responseWriter, http.StatusBadRequest, "VALIDATION_ERROR", "secret must be at least 16 characters long", nil)
+		return
+	}
+
+	userID := server.getUserID(request)
+	var webhookCount int
+	if err := server.database.QueryRow("SELECT COUNT(*) FROM webhooks WHERE user_id = ?", userID).Scan(&webhookCount); err != nil {
+		server.writeError(responseWriter, http.StatusInternalServerError, "DATABASE_ERROR", "Failed to count webhooks", nil)
+		return
+	}
+	if webhookCount >= maximumWebhooksPerUser {
+		server.writeError(responseWriter, http.StatusConflict, "LIMIT_REACHED", "You cannot register more than 20 webhooks", nil)
+		return
+	}
+
+	sealedSecret, err := server.secretCipher.Seal(secret)
+	if err != nil {
+		server.writeError(responseWriter, http.StatusInternalServerError, "ENCRYPTION_ERROR", "Failed to encrypt webhook secret", nil)
+		return
+	}
+	webhookID, err := gonanoid.New()
+	if err != nil {
+		server.writeError(responseWriter, http.StatusInternalServerError, "INTERNAL_ERROR", "Failed to generate webhook ID", nil)
+		return
+	}
+	now := time.Now()
+	webhook := models.Webhook{
+		ID:          webhookID,
+		URL:         webhookURL,
+		Events:      webhookRequest.Events,
+		Description: description,
+		Active:      true,
+		CreatedAt:   now,
+		UpdatedAt:   now,
+	}
+	if err := database.CreateWebhook(server.database, userID, webhook, sealedSecret); err != nil {
+		server.writeError(responseWriter, http.StatusInternalServerError, "DATABASE_ERROR", "Failed to create webhook", nil)
+		return
+	}
+	slog.Info("User registered a webhook", "userID", userID, "webhookID", webhookID, "events", webhook.Events)
+
+	server.writeJSON(responseWriter, http.StatusCreated, map[string]any{
+		"webhook": webhook,
+		"secret":  secret,
+	})
+}
+
+// handleUpdateWebhook changes the URL, events, description or state of a webhook of the authenticated user.
+// Deliveries of a paused webhook wait until it is active again
+func (server *Server) handleUpdateWebhook(responseWriter http.ResponseWriter, request *http.Request) {
+	if !server.requireWebhooks(responseWriter) {
+		return
+	}
+
+	var updateRequest struct {
+		WebhookID   string    `json:"webhook_id"`
+		URL         *string   `json:"url"`
+		Events      *[]string `json:"events"`
+		Description *string   `json:"description"`
+		Active      *bool     `json:"active"`
+	}
+	if err := json.NewDecoder(request.Body).Decode(&updateRequest); err != nil {
+		server.writeError(responseWriter, http.StatusBadRequest, "VALIDATION_ERROR", "Invalid body", nil)
+		return
+	}
+
+	userID := server.getUserID(request)
+	webhook, err := database.GetWebhook(server.database, userID, updateRequest.WebhookID)
+	if err == sql.ErrNoRows {
+		server.writeError(responseWriter, http.StatusNotFound, "NOT_FOUND", "Webhook not found", nil)
+		return
+	}
+	if err != nil {
+		server.writeError(responseWriter, http.StatusInternalServerError, "DATABASE_ERROR", "Failed to get webhook", nil)
+		return
+	}
+
+	if updateRequest.URL != nil {
+		webhookURL := strings.TrimSpace(*updateRequest.URL)
+		if len(webhookURL) > maximumWebhookURLLength {
+			server.writeError(responseWriter, http.StatusBadRequest, "VALIDATION_ERROR", "url cannot be longer than 2048 characters", nil)
+			return
+		}
+		if err := server.webhookDispatcher.ValidateURL(webhookURL); err != nil {
+			server.writeError(responseWriter, http.StatusBadRequest, "VALIDATION_ERROR", err.Error(), nil)
+			return
+		}
+		webhook.URL = webhookURL
+	}
+	if updateRequest.Events != nil {
+		if err := validateWebhookEvents(*updateRequest.Events); err != nil {
+			server.writeError(responseWriter, http.StatusBadRequest, "VALIDATION_ERROR", err.Error(), nil)
+			return
+		}
+		webhook.Events = *updateRequest.Events
+	}
+	if updateRequest.Description != nil {
+		description := strings.TrimSpace(*updateRequest.Description)
+		if len(description) > maximumWebhookDescriptionLength {
+			server.writeError(responseWriter, http.StatusBadRequest, "VALIDATION_ERROR", "description cannot be longer than 200 characters", nil)
+			return
+		}
+		webhook.Description = description
+	}
+	if updateRequest.Active != nil {
+		webhook.Active = *updateRequest.Active
+	}
+	webhook.UpdatedAt = time.Now()
+
+	if err := database.UpdateWebhook(server.database, userID, webhook); err != nil {
+		server.writeError(responseWriter, http.StatusInternalServerError, "DATABASE_ERROR", "Failed to update webhook", nil)
+		return
+	}
+	if webhook.Active {
+		server.webhookDispatcher.Wake()
+	}
+	server.writeJSON(responseWriter, http.StatusOK, webhook)
+}
+
+// handleDeleteWebhook removes a webhook of the authenticated user along with its deliveries
+func (server *Server) handleDeleteWebhook(responseWriter http.ResponseWriter, request *http.Request) {
+	var deleteRequest struct {
+		WebhookID string `json:"webhook_id"`
+	}
+	if err := json.NewDecoder(request.Body).Decode(&deleteRequest); err != nil {
+		server.writeError(responseWriter, http.StatusBadRequest, "VALIDATION_ERROR", "Invalid body", nil)
+		return
+	}
+
+	deleted, err := database.DeleteWebhook(server.database, server.getUserID(request), deleteRequest.WebhookID)
+	if err != nil {
+		server.writeError(responseWriter, http.StatusInternalServerError, "DATABASE_ERROR", "Failed to delete webhook", nil)
+		return
+	}
+	if !deleted {
+		server.writeError(responseWriter, http.StatusNotFound, "NOT_FOUND", "Webhook not found", nil)
+		return
+	}
+	server.writeJSON(responseWriter, http.StatusOK, map[string]string{"message": "Webhook deleted"})
+}
+
+// handleTestWebhook queues a ping event for a webhook of the authenticated user, whatever events it subscribed
+// to, so its receiver can be checked
+func (server *Server) handleTestWebhook(responseWriter http.ResponseWriter, request *http.Request) {
+	if !server.requireWebhooks(responseWriter) {
+		return
+	}
+
+	var testRequest struct {
+		WebhookID string `json:"webhook_id"`
+	}
+	if err := json.NewDecoder(request.Body).Decode(&testRequest); err != nil {
+		server.writeError(responseWriter, http.StatusBadRequest, "VALIDATION_ERROR", "Invalid body", nil)
+		return
+	}
+
+	delivery, err := server.webhookDispatcher.Ping(server.getUserID(request), testRequest.WebhookID)
+	if err == sql.ErrNoRows {
+		server.writeError(responseWriter, http.StatusNotFound, "NOT_FOUND", "Active webhook not found", nil)
+		return
+	}
+	if err != nil {
+		server.writeError(responseWriter, http.StatusInternalServerError, "DATABASE_ERROR", "Failed to queue webhook delivery", nil)
+		return
+	}
+	server.writeJSON(responseWriter, http.StatusAccepted, delivery)
+}
+
+// handleListWebhookDeliveries lists the deliveries of the webhooks of the authenticated user, newest first,
+// optionally of a single webhook or with a single status
+func (server *Server) handleListWebhookDeliveries(responseWriter http.ResponseWriter, request *http.Request) {
+	page, err := parseListPage(request, "webhook_deliveries.created_at", "webhook_deliveries.id", true)
+	if err != nil {
+		server.writeError(responseWriter, http.StatusBadRequest, "VALIDATION_ERROR", err.Error(), nil)
+		return
+	}
+
+	query := `
+		SELECT webhook_deliveries.id, webhook_deliveries.webhook_id, webhook_deliveries.event, webhook_deliveries.payload,
+			webhook_deliveries.status, webhook_deliveries.attempts, webhook_deliveries.response_status, webhook_deliveries.error,
+			webhook_deliveries.next_attempt_at, webhook_deliveries.delivered_at, webhook_deliveries.created_at, ` + page.sortValue() + `
+		FROM webhook_deliveries
+		JOIN webhooks ON webhook_deliveries.webhook_id = webhooks.id
+		WHERE webhooks.user_id = ?`
+	arguments := []any{server.getUserID(request)}
+	if webhookID := request.URL.Query().Get("webhook_id"); webhookID != "" {
+		query += " AND webhook_deliveries.webhook_id = ?"
+		arguments = append(arguments, webhookID)
+	}
+	if status := request.URL.Query().Get("status"); status != "" {
+		if status != models.WebhookDeliveryStatusPending && status != models.WebhookDeliveryStatusDelivered && status != models.WebhookDeliveryStatusFailed {
+			server.writeError(responseWriter, http.StatusBadRequest, "VALIDATION_ERROR", "status must be pending, delivered or failed", nil)
+			return
+		}
+		query += " AND webhook_deliveries.status = ?"
+		arguments = append(arguments, status)
+	}
+	condition, conditionArguments := page.condition()
+	query += condition + page.orderBy()
+	arguments = append(arguments, conditionArguments...)
+
+	rows, err := server.database.Query(query, arguments...)
+	if err != nil {
+		server.writeError(responseWriter, http.StatusInternalServerError, "DATABASE_ERROR", "Failed to list webhook deliveries", nil)
+		return
+	}
+	defer rows.Close()
+
+	deliveries := []models.WebhookDelivery{}
+	var cursors []pageCursor
+	for rows.Next() {
+		var delivery models.WebhookDelivery
+		var responseStatus sql.NullInt64
+		var deliveryError sql.NullString
+		var nextAttemptAt, deliveredAt sql.NullTime
+		var cursor pageCursor
+		if err := rows.Scan(&delivery.ID, &delivery.WebhookID, &delivery.Event, &delivery.Payload, &delivery.Status, &delivery.Attempts,
+			&responseStatus, &deliveryError, &nextAttemptAt, &deliveredAt, &delivery.CreatedAt, &cursor.SortValue); err != nil {
+			server.writeError(responseWriter, http.StatusInternalServerError, "DATABASE_ERROR", "Failed to read webhook deliveries", nil)
+			return
+		}
+		delivery.ResponseStatus = int(responseStatus.Int64)
+		delivery.Error = deliveryError.String
+		if nextAttemptAt.Valid && delivery.Status == models.WebhookDeliveryStatusPending {
+			delivery.NextAttemptAt = &nextAttemptAt.Time
+		}
+		if deliveredAt.Valid {
+			delivery.DeliveredAt = &deliveredAt.Time
+		}
+		cursor.ID = delivery.ID
+		deliveries = append(deliveries, delivery)
+		cursors = append(cursors, cursor)
+	}
+
+	deliveries, nextCursor := paginate(page, deliveries, cursors)
+	server.writePage(responseWriter, http.StatusOK, deliveries, nextCursor)
+}
+
+// handleRedeliverWebhookDelivery queues a delivery of the authenticated user's webhooks again, such as one that
+// was given up, with a fresh count of attempts
+func (server *Server) handleRedeliverWebhookDelivery(responseWriter http.ResponseWriter, request *http.Request) {
+	if !server.requireWebhooks(responseWriter) {
+		return
+	}
+
+	var redeliverRequest struct {
+		DeliveryID string `json:"delivery_id"`
+	}
+	if err := json.NewDecoder(request.Body).Decode(&redeliverRequest); err != nil {
+		server.writeError(responseWriter, http.StatusBadRequest, "VALIDATION_ERROR", "Invalid body", nil)
+		return
+	}
+
+	queued, err := database.RedeliverWebhookDelivery(server.database, server.getUserID(request), redeliverRequest.DeliveryID)
+	if err != nil {
+		server.writeError(responseWriter, http.StatusInternalServerError, "DATABASE_ERROR", "Failed to queue webhook delivery", nil)
+		return
+	}
+	if !queued {
+		server.writeError(responseWriter, http.StatusNotFound, "NOT_FOUND", "Webhook delivery not found", nil)
+		return
+	}
+	server.webhookDispatcher.Wake()
+	server.writeJSON(responseWriter, http.StatusAccepted, map[string]string{"message": "Webhook delivery queued"})
+}
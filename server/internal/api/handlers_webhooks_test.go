@@ -0,0 +1,170 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"lectures/internal/configuration"
+	"lectures/internal/models"
+	"lectures/internal/secrets"
+	"lectures/internal/webhooks"
+)
+
+func TestWebhooks(t *testing.T) {
+	server, userID, sessionID, cleanup := setupUniqueExtraTestEnv(t, "webhooks")
+	defer cleanup()
+
+	type receivedDelivery struct {
+		header http.Header
+		body   []byte
+	}
+	received := make(chan receivedDelivery, 10)
+	var failing atomic.Bool
+	failing.Store(true)
+	receiver := httptest.NewServer(http.HandlerFunc(func(responseWriter http.ResponseWriter, request *http.Request) {
+		body, _ := io.ReadAll(request.Body)
+		received <- receivedDelivery{header: request.Header.Clone(), body: body}
+		if failing.Load() {
+			responseWriter.WriteHeader(http.StatusBadGateway)
+		}
+	}))
+	defer receiver.Close()
+
+	sendRequest := func(method string, target string, body any) *httptest.ResponseRecorder {
+		var requestBody io.Reader
+		if body != nil {
+			bodyJSON, _ := json.Marshal(body)
+			requestBody = bytes.NewReader(bodyJSON)
+		}
+		req := httptest.NewRequest(method, target, requestBody)
+		req.Header.Set("Authorization", "Bearer "+sessionID)
+		req.Header.Set("X-Requested-With", "XMLHttpRequest")
+		rr := httptest.NewRecorder()
+		server.Handler().ServeHTTP(rr, req)
+		return rr
+	}
+
+	webhookBody := map[string]any{"url": receiver.URL, "events": []string{models.WebhookEventJobCompleted, models.WebhookEventToolCreated}}
+	if rr := sendRequest("POST", "/api/webhooks", webhookBody); rr.Code != http.StatusServiceUnavailable {
+		t.Errorf("Expected status 503 without an encryption key, got %d", rr.Code)
+	}
+
+	secretCipher, err := secrets.LoadCipher("", filepath.Join(t.TempDir(), "encryption.key"))
+	if err != nil {
+		t.Fatalf("LoadCipher failed: %v", err)
+	}
+	server.SetSecretCipher(secretCipher)
+	server.SetWebhookDispatcher(webhooks.NewDispatcher(server.database, configuration.WebhooksConfiguration{MaximumAttempts: 2}, secretCipher))
+	if rr := sendRequest("POST", "/api/webhooks", webhookBody); rr.Code != http.StatusBadRequest {
+		t.Errorf("Expected status 400 for a loopback URL, got %d", rr.Code)
+	}
+
+	dispatcher := webhooks.NewDispatcher(server.database, configuration.WebhooksConfiguration{MaximumAttempts: 2, AllowPrivateNetworks: true}, secretCipher)
+	server.SetWebhookDispatcher(dispatcher)
+	for _, invalidBody := range []map[string]any{
+		{"url": "ftp://example.com/hook", "events": []string{models.WebhookEventJobCompleted}},
+		{"url": receiver.URL, "events": []string{"job.exploded"}},
+		{"url": receiver.URL, "events": []string{models.WebhookEventJobCompleted}, "secret": "short"},
+	} {
+		if rr := sendRequest("POST", "/api/webhooks", invalidBody); rr.Code != http.StatusBadRequest {
+			t.Errorf("Expected status 400 for %v, got %d", invalidBody, rr.Code)
+		}
+	}
+	rr := sendRequest("POST", "/api/webhooks", webhookBody)
+	var created struct {
+		Data struct {
+			Webhook models.Webhook `json:"webhook"`
+			Secret  string         `json:"secret"`
+		} `json:"data"`
+	}
+	json.Unmarshal(rr.Body.Bytes(), &created)
+	if rr.Code != http.StatusCreated || created.Data.Secret == "" || created.Data.Webhook.ID == "" {
+		t.Fatalf("Expected the webhook and its secret, got %d: %s", rr.Code, rr.Body.String())
+	}
+	if rr := sendRequest("GET", "/api/webhooks", nil); strings.Contains(rr.Body.String(), created.Data.Secret) || !strings.Contains(rr.Body.String(), created.Data.Webhook.ID) {
+		t.Errorf("Expected the webhook to be listed without its secret, got %s", rr.Body.String())
+	}
+
+	server.database.Exec("INSERT INTO exams (id, user_id, title) VALUES ('webhook-exam', ?, 'Exam')", userID)
+	server.database.Exec("INSERT INTO tools (id, exam_id, type, title, language_code, content) VALUES ('webhook-tool', 'webhook-exam', 'guide', 'Guide', 'en', '{}')")
+	dispatcher.JobUpdated(&models.Job{ID: "webhook-job", UserID: userID, Type: models.JobTypeBuildMaterial, Result: `{"tool_id":"webhook-tool"}`}, models.JobStatusCompleted)
+	dispatcher.JobUpdated(&models.Job{ID: "webhook-failed-job", UserID: userID, Type: models.JobTypeBuildMaterial}, models.JobStatusFailed)
+
+	// The receiver first fails, so both deliveries are scheduled for a retry
+	dispatcher.DeliverDue(context.Background())
+	events := map[string]bool{}
+	for range 2 {
+		delivery := <-received
+		timestamp := delivery.header.Get("X-Webhook-Timestamp")
+		if delivery.header.Get("X-Webhook-Signature") != "sha256="+webhooks.Sign(created.Data.Secret, timestamp, delivery.body) {
+			t.Errorf("Expected a valid signature, got %q", delivery.header.Get("X-Webhook-Signature"))
+		}
+		var payload struct {
+			Event string         `json:"event"`
+			Data  map[string]any `json:"data"`
+		}
+		json.Unmarshal(delivery.body, &payload)
+		if payload.Event != delivery.header.Get("X-Webhook-Event") {
+			t.Errorf("Expected the payload event to match its header, got %s", payload.Event)
+		}
+		if payload.Event == models.WebhookEventToolCreated && payload.Data["tool_id"] != "webhook-tool" {
+			t.Errorf("Expected the created tool, got %v", payload.Data)
+		}
+		events[payload.Event] = true
+	}
+	if !events[models.WebhookEventJobCompleted] || !events[models.WebhookEventToolCreated] {
+		t.Errorf("Expected job.completed and tool.created only, got %v", events)
+	}
+
+	var deliveries struct {
+		Data []models.WebhookDelivery `json:"data"`
+	}
+	json.Unmarshal(sendRequest("GET", "/api/webhooks/deliveries?status=pending", nil).Body.Bytes(), &deliveries)
+	if len(deliveries.Data) != 2 || deliveries.Data[0].Attempts != 1 || deliveries.Data[0].ResponseStatus != http.StatusBadGateway || deliveries.Data[0].NextAttemptAt == nil {
+		t.Fatalf("Expected both deliveries to wait for a retry, got %+v", deliveries.Data)
+	}
+
+	// Retries are due later; bringing them forward, the receiver accepts them
+	failing.Store(false)
+	server.database.Exec("UPDATE webhook_deliveries SET next_attempt_at = ?", time.Now().Add(-time.Second))
+	dispatcher.DeliverDue(context.Background())
+	<-received
+	<-received
+	json.Unmarshal(sendRequest("GET", "/api/webhooks/deliveries?webhook_id="+created.Data.Webhook.ID+"&status=delivered", nil).Body.Bytes(), &deliveries)
+	if len(deliveries.Data) != 2 || deliveries.Data[0].Attempts != 2 || deliveries.Data[0].DeliveredAt == nil {
+		t.Errorf("Expected both deliveries to be delivered on their second attempt, got %+v", deliveries.Data)
+	}
+
+	// A ping ignores the events the webhook subscribed to
+	if rr := sendRequest("POST", "/api/webhooks/test", map[string]any{"webhook_id": created.Data.Webhook.ID}); rr.Code != http.StatusAccepted {
+		t.Fatalf("Expected status 202 for a ping, got %d: %s", rr.Code, rr.Body.String())
+	}
+	dispatcher.DeliverDue(context.Background())
+	if delivery := <-received; delivery.header.Get("X-Webhook-Event") != models.WebhookEventPing {
+		t.Errorf("Expected a ping, got %s", delivery.header.Get("X-Webhook-Event"))
+	}
+
+	if rr := sendRequest("PATCH", "/api/webhooks", map[string]any{"webhook_id": created.Data.Webhook.ID, "active": false}); rr.Code != http.StatusOK {
+		t.Errorf("Expected status 200 pausing the webhook, got %d", rr.Code)
+	}
+	if rr := sendRequest("POST", "/api/webhooks/test", map[string]any{"webhook_id": created.Data.Webhook.ID}); rr.Code != http.StatusNotFound {
+		t.Errorf("Expected status 404 pinging a paused webhook, got %d", rr.Code)
+	}
+	if rr := sendRequest("DELETE", "/api/webhooks", map[string]any{"webhook_id": created.Data.Webhook.ID}); rr.Code != http.StatusOK {
+		t.Errorf("Expected status 200 deleting the webhook, got %d", rr.Code)
+	}
+	var remainingDeliveries int
+	server.database.QueryRow("SELECT COUNT(*) FROM webhook_deliveries").Scan(&remainingDeliveries)
+	if remainingDeliveries != 0 {
+		t.Errorf("Expected the deliveries to be deleted with their webhook, got %d", remainingDeliveries)
+	}
+}
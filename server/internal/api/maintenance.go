@@ -0,0 +1,158 @@
+package api
+
+import (
+	"errors"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+
+	"lectures/internal/database"
+)
+
+// defaultMaintenanceInterval is how often the database is analyzed and vacuumed unless configured otherwise
+const defaultMaintenanceInterval = 24 * time.Hour
+
+// defaultJobCompactionAge is how long finished jobs keep their payload and result unless configured otherwise
+const defaultJobCompactionAge = 90 * 24 * time.Hour
+
+// databaseMaintenance remembers the last maintenance run, so the hourly worker only runs it once per interval
+type databaseMaintenance struct {
+	mutex     sync.Mutex
+	lastRunAt time.Time
+	lastError string
+}
+
+// maintainDatabaseIfDue runs the database maintenance once the configured interval elapsed since the last run
+func (server *Server) maintainDatabaseIfDue() {
+	interval := defaultMaintenanceInterval
+	if intervalHours := server.configuration.Database.MaintenanceIntervalHours; intervalHours < 0 {
+		return
+	} else if intervalHours > 0 {
+		interval = time.Duration(intervalHours) * time.Hour
+	}
+
+	server.maintenance.mutex.Lock()
+	lastRunAt := server.maintenance.lastRunAt
+	server.maintenance.mutex.Unlock()
+	if !lastRunAt.IsZero() && time.Since(lastRunAt) < interval {
+		return
+	}
+	server.maintainDatabase()
+}
+
+// maintainDatabase compacts old finished jobs, archives the transcripts of idle lectures, prunes old login
+// attempts, then refreshes the query planner statistics and vacuums the database file
+func (server *Server) maintainDatabase() error {
+	server.maintenance.mutex.Lock()
+	defer server.maintenance.mutex.Unlock()
+
+	startedAt := time.Now()
+	var maintenanceErrors []error
+
+	compactionAge := defaultJobCompactionAge
+	if compactionDays := server.configuration.Database.JobCompactionDays; compactionDays > 0 {
+		compactionAge = time.Duration(compactionDays) * 24 * time.Hour
+	}
+	compactedJobs := 0
+	if server.configuration.Database.JobCompactionDays >= 0 {
+		var err error
+		if compactedJobs, err = database.CompactFinishedJobs(server.database, startedAt.Add(-compactionAge)); err != nil {
+			slog.Error("Failed to compact finished jobs", "error", err)
+			maintenanceErrors = append(maintenanceErrors, err)
+		}
+	}
+
+	archivedTranscripts := 0
+	if archiveDays := server.configuration.Database.TranscriptArchiveDays; archiveDays > 0 {
+		var err error
+		if archivedTranscripts, err = database.ArchiveIdleTranscripts(server.database, startedAt.Add(-time.Duration(archiveDays)*24*time.Hour)); err != nil {
+			slog.Error("Failed to archive idle transcripts", "error", err)
+			maintenanceErrors = append(maintenanceErrors, err)
+		}
+	}
+
+	prunedLoginAttempts, err := database.PruneLoginAttempts(server.database, startedAt.Add(-loginAttemptRetention))
+	if err != nil {
+		slog.Error("Failed to prune login attempts", "error", err)
+		maintenanceErrors = append(maintenanceErrors, err)
+	}
+
+	if err := database.Optimize(server.database); err != nil {
+		slog.Error("Failed to analyze database", "error", err)
+		maintenanceErrors = append(maintenanceErrors, err)
+	}
+	if err := database.Vacuum(server.database); err != nil {
+		slog.Error("Failed to vacuum database", "error", err)
+		maintenanceErrors = append(maintenanceErrors, err)
+	}
+
+	err = errors.Join(maintenanceErrors...)
+	server.maintenance.lastRunAt = startedAt
+	server.maintenance.lastError = ""
+	if err != nil {
+		server.maintenance.lastError = err.Error()
+	}
+	slog.Info("Database maintenance completed", "compacted_jobs", compactedJobs, "archived_transcripts", archivedTranscripts, "pruned_login_attempts", prunedLoginAttempts, "duration", time.Since(startedAt))
+	return err
+}
+
+// restoreLectureTranscripts brings back the archived transcripts of lectures about to be read, answering 500
+// when one could not be restored
+func (server *Server) restoreLectureTranscripts(responseWriter http.ResponseWriter, lectureIDs ...string) bool {
+	if err := database.RestoreLectureTranscripts(server.database, lectureIDs...); err != nil {
+		slog.Error("Failed to restore archived transcripts", "error", err)
+		server.writeError(responseWriter, http.StatusInternalServerError, "DATABASE_ERROR", "Failed to restore archived transcript", nil)
+		return false
+	}
+	return true
+}
+
+// restoreExamTranscripts brings back the archived transcripts of every lecture of an exam about to be read
+func (server *Server) restoreExamTranscripts(responseWriter http.ResponseWriter, examID string) bool {
+	if err := database.RestoreExamTranscripts(server.database, examID); err != nil {
+		slog.Error("Failed to restore archived transcripts", "examID", examID, "error", err)
+		server.writeError(responseWriter, http.StatusInternalServerError, "DATABASE_ERROR", "Failed to restore archived transcripts", nil)
+		return false
+	}
+	return true
+}
+
+// handleGetDatabaseReport reports the size of the database file, of each table and index and of the archived
+// transcripts, with the outcome of the last maintenance run
+func (server *Server) handleGetDatabaseReport(responseWriter http.ResponseWriter, request *http.Request) {
+	if !server.requireAdmin(responseWriter, request) {
+		return
+	}
+
+	report, err := database.GetSizeReport(server.database)
+	if err != nil {
+		slog.Error("Failed to measure database", "error", err)
+		server.writeError(responseWriter, http.StatusInternalServerError, "DATABASE_ERROR", "Failed to measure database", nil)
+		return
+	}
+
+	server.maintenance.mutex.Lock()
+	if !server.maintenance.lastRunAt.IsZero() {
+		lastRunAt := server.maintenance.lastRunAt
+		report.LastMaintenanceAt = &lastRunAt
+	}
+	report.LastMaintenanceError = server.maintenance.lastError
+	server.maintenance.mutex.Unlock()
+
+	server.writeJSON(responseWriter, http.StatusOK, report)
+}
+
+// handleRunDatabaseMaintenance runs the database maintenance now instead of waiting for its interval, then
+// reports the database size
+func (server *Server) handleRunDatabaseMaintenance(responseWriter http.ResponseWriter, request *http.Request) {
+	if !server.requireAdmin(responseWriter, request) {
+		return
+	}
+
+	if err := server.maintainDatabase(); err != nil {
+		server.writeError(responseWriter, http.StatusInternalServerError, "MAINTENANCE_ERROR", "Database maintenance failed", err.Error())
+		return
+	}
+	server.handleGetDatabaseReport(responseWriter, request)
+}
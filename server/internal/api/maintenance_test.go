@@ -0,0 +1,101 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"lectures/internal/database"
+)
+
+func TestHandleDatabaseMaintenance(t *testing.T) {
+	server, userID, sessionID, cleanup := setupUniqueExtraTestEnv(t, "database_maintenance")
+	defer cleanup()
+	server.configuration.Database.TranscriptArchiveDays = 30
+
+	monthsAgo := time.Now().AddDate(0, -3, 0)
+	server.database.Exec("INSERT INTO exams (id, user_id, title) VALUES ('maintenance-exam', ?, 'Thermodynamics')", userID)
+	server.database.Exec("INSERT INTO lectures (id, exam_id, title, status, updated_at) VALUES ('idle-lecture', 'maintenance-exam', 'Entropy', 'ready', ?)", monthsAgo)
+	server.database.Exec("INSERT INTO lectures (id, exam_id, title, status) VALUES ('recent-lecture', 'maintenance-exam', 'Enthalpy', 'ready')")
+	server.database.Exec("INSERT INTO transcripts (id, lecture_id, status, updated_at) VALUES ('idle-transcript', 'idle-lecture', 'completed', ?)", monthsAgo)
+	server.database.Exec("INSERT INTO transcripts (id, lecture_id, status) VALUES ('recent-transcript', 'recent-lecture', 'completed')")
+	server.database.Exec("INSERT INTO transcript_segments (id, transcript_id, text, polished_text, speaker, start_millisecond, end_millisecond) VALUES (7, 'idle-transcript', 'Entropy grows', 'Entropy grows.', 'Lecturer', 0, 1000)")
+	server.database.Exec("INSERT INTO transcript_segments (id, transcript_id, text, start_millisecond, end_millisecond) VALUES (8, 'idle-transcript', 'in isolated systems', 1000, 2000)")
+	server.database.Exec("INSERT INTO transcript_segments (transcript_id, text, start_millisecond, end_millisecond) VALUES ('recent-transcript', 'Enthalpy', 0, 1000)")
+	insertJob := func(jobID string, jobType string, completedAt time.Time) {
+		server.database.Exec(`
+			INSERT INTO jobs (id, user_id, type, status, payload, result, estimated_cost, completed_at)
+			VALUES (?, ?, ?, 'COMPLETED', '{"exam_id":"maintenance-exam"}', '{"content":"..."}', 0.5, ?)
+		`, jobID, userID, jobType, completedAt)
+	}
+	insertJob("old-build", "BUILD_MATERIAL", time.Now().AddDate(-1, 0, 0))
+	insertJob("old-export", "PUBLISH_MATERIAL", time.Now().AddDate(-1, 0, 0))
+	insertJob("recent-build", "BUILD_MATERIAL", time.Now())
+
+	sendRequest := func(method string, target string) (int, database.SizeReport) {
+		req := httptest.NewRequest(method, target, nil)
+		req.Header.Set("Authorization", "Bearer "+sessionID)
+		req.Header.Set("X-Requested-With", "XMLHttpRequest")
+		rr := httptest.NewRecorder()
+		server.Handler().ServeHTTP(rr, req)
+		var response struct {
+			Data database.SizeReport `json:"data"`
+		}
+		json.Unmarshal(rr.Body.Bytes(), &response)
+		return rr.Code, response.Data
+	}
+
+	if code, _ := sendRequest("GET", "/api/admin/database"); code != http.StatusForbidden {
+		t.Errorf("Expected users to be refused the database report, got %d", code)
+	}
+	server.database.Exec("UPDATE users SET role = 'admin' WHERE id = ?", userID)
+
+	code, report := sendRequest("POST", "/api/admin/database/maintenance")
+	if code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d", code)
+	}
+	if report.ArchivedTranscripts != 1 || report.ArchivedSegments != 2 || report.LastMaintenanceAt == nil {
+		t.Errorf("Expected the idle transcript archived with its 2 segments, got %+v", report)
+	}
+	if report.FileBytes == 0 || len(report.Tables) == 0 {
+		t.Errorf("Expected the file and its tables measured, got %+v", report)
+	}
+
+	var compactedJobs []string
+	rows, _ := server.database.Query("SELECT id FROM jobs WHERE payload = '{}' AND result IS NULL AND estimated_cost = 0.5 ORDER BY id")
+	for rows.Next() {
+		var jobID string
+		rows.Scan(&jobID)
+		compactedJobs = append(compactedJobs, jobID)
+	}
+	rows.Close()
+	if len(compactedJobs) != 1 || compactedJobs[0] != "old-build" {
+		t.Errorf("Expected only the old build job compacted with its cost kept, got %v", compactedJobs)
+	}
+
+	var remainingSegments int
+	server.database.QueryRow("SELECT COUNT(*) FROM transcript_segments WHERE transcript_id = 'idle-transcript'").Scan(&remainingSegments)
+	if remainingSegments != 0 {
+		t.Errorf("Expected the archived segments removed, got %d", remainingSegments)
+	}
+
+	req := httptest.NewRequest("GET", "/api/transcripts?lecture_id=idle-lecture", nil)
+	req.Header.Set("Authorization", "Bearer "+sessionID)
+	rr := httptest.NewRecorder()
+	server.Handler().ServeHTTP(rr, req)
+	if rr.Code != http.StatusOK || !strings.Contains(rr.Body.String(), "Entropy grows.") || !strings.Contains(rr.Body.String(), "in isolated systems") {
+		t.Errorf("Expected the transcript restored on read, got %d: %s", rr.Code, rr.Body.String())
+	}
+
+	var restoredSpeaker string
+	server.database.QueryRow("SELECT speaker FROM transcript_segments WHERE id = 7 AND transcript_id = 'idle-transcript'").Scan(&restoredSpeaker)
+	if restoredSpeaker != "Lecturer" {
+		t.Errorf("Expected the segments restored with their IDs and speakers, got %q", restoredSpeaker)
+	}
+	if _, report := sendRequest("GET", "/api/admin/database"); report.ArchivedTranscripts != 0 {
+		t.Errorf("Expected no archive left after the restore, got %+v", report)
+	}
+}
@@ -0,0 +1,644 @@
+package api
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"net/http"
+	"reflect"
+	"slices"
+	"strings"
+	"time"
+
+	"lectures/internal/database"
+	"lectures/internal/jobs"
+	"lectures/internal/models"
+	"lectures/internal/storage"
+
+	"github.com/gorilla/mux"
+)
+
+// apiVersion is the version of the API reported by /api/health and the OpenAPI document
+const apiVersion = "1.0.0"
+
+// apiOperation describes a route for the OpenAPI document. Query parameters and body fields are written as
+// space-separated "name:type" pairs, a trailing "!" marking the required ones. Types are string, integer,
+// number, boolean, object, date-time, binary or any, and arrays of them with a "[]" prefix
+type apiOperation struct {
+	tag      string
+	summary  string
+	query    string
+	body     string // Fields of a JSON body
+	form     string // Fields of a multipart body, binary ones being files
+	response any    // A value of the type of the "data" of successful responses; a string lists its fields, nil is any object
+	status   int    // Status of successful responses, 200 when 0
+	raw      string // Media type of successful responses that are not JSON, such as downloads
+	signedIn bool   // Public route whose handler still needs a session
+}
+
+// Responses shared by many routes
+const (
+	messageResponse = "message:string"
+	jobResponse     = "job_id:string message:string"
+)
+
+// pageParameters are the query parameters of the lists paginated with parseListPage, whose next page's cursor is
+// the "next_cursor" of the meta
+const pageParameters = " limit:integer cursor:string"
+
+// apiOperations describes every route of the API, by method and path template. Handlers decode anonymous
+// structs, so their bodies are written here; TestOpenAPIDocument checks that no route is missing
+var apiOperations = map[string]apiOperation{
+	// Health and documentation
+	"GET /api/health":       {tag: "System", summary: "Report that the server is up", response: "status:string version:string"},
+	"GET /healthz":          {tag: "System", summary: "Check that the server can serve requests", response: healthReport{}},
+	"GET /readyz":           {tag: "System", summary: "Check the database, storage and external dependencies", response: healthReport{}},
+	"GET /api/openapi.json": {tag: "System", summary: "This OpenAPI document", raw: "application/json"},
+	"GET /api/docs":         {tag: "System", summary: "Browse this OpenAPI document with Swagger UI", raw: "text/html"},
+
+	// Authentication
+	"POST /api/auth/setup":                  {tag: "Authentication", summary: "Create the first administrator of a new server", body: "username:string! password:string! openrouter_api_key:string", response: "token:string expires_at:date-time user:object permissions:[]string"},
+	"POST /api/auth/register":               {tag: "Authentication", summary: "Create an account with the registration role", body: "username:string! password:string! email:string", response: messageResponse, status: http.StatusCreated},
+	"POST /api/auth/login":                  {tag: "Authentication", summary: "Sign in with a password and receive a session token", body: "username:string! password:string!", response: "token:string expires_at:date-time user:object permissions:[]string password_change_required:boolean"},
+	"GET /api/auth/status":                  {tag: "Authentication", summary: "Report whether the server is set up and the request signed in", response: "authenticated:boolean initialized:boolean oidc:boolean expires_at:date-time user:object permissions:[]string password_change_required:boolean"},
+	"GET /api/auth/oidc/login":              {tag: "Authentication", summary: "Redirect to the identity provider to sign in", query: "redirect:string", status: http.StatusFound, raw: "text/html"},
+	"GET /api/auth/oidc/callback":           {tag: "Authentication", summary: "Complete a sign-in at the identity provider", query: "code:string state:string! error:string error_description:string", status: http.StatusFound, raw: "text/html"},
+	"POST /api/auth/password-reset/request": {tag: "Authentication", summary: "Email a password reset link to an account", body: "username:string email:string", response: messageResponse, status: http.StatusAccepted},
+	"POST /api/auth/password-reset/confirm": {tag: "Authentication", summary: "Set a new password with a reset link", body: "token:string! new_password:string!", response: messageResponse},
+	"POST /api/auth/logout":                 {tag: "Authentication", summary: "End the current session", response: messageResponse},
+	"PATCH /api/auth/password":              {tag: "Authentication", summary: "Change the password of the current user", body: "current_password:string! new_password:string!", response: messageResponse},
+	"PATCH /api/auth/email":                 {tag: "Authentication", summary: "Set or clear the email address of the current user", body: "email:string current_password:string!", response: "email:string"},
+	"POST /api/auth/tokens":                 {tag: "Authentication", summary: "Create a scoped personal access token, returned only once", body: "name:string! scopes:[]string! expires_in_days:integer", response: "token:string api_token:object", status: http.StatusCreated},
+	"GET /api/auth/tokens":                  {tag: "Authentication", summary: "List the personal access tokens of the current user", response: []models.APIToken{}},
+	"DELETE /api/auth/tokens":               {tag: "Authentication", summary: "Revoke a personal access token", body: "token_id:string!", response: messageResponse},
+
+	// Uploads
+	"POST /api/uploads/prepare": {tag: "Uploads", summary: "Start a staged upload", body: "filename:string! file_size_bytes:integer!", response: "upload_id:string chunk_size_bytes:integer"},
+	"POST /api/uploads/append":  {tag: "Uploads", summary: "Append a chunk, sent as the raw body, to a staged upload", query: "upload_id:string!", response: "status:string bytes_received:integer"},
+	"POST /api/uploads/stage":   {tag: "Uploads", summary: "Complete a staged upload once all its chunks were appended", body: "upload_id:string! file_size_bytes:integer", response: "upload_id:string status:string"},
+	"POST /api/uploads/import":  {tag: "Uploads", summary: "Import a recording from a URL or a cloud drive", body: "source:string! filename:string data:object", response: jobResponse, status: http.StatusAccepted},
+	"GET /api/uploads":          {tag: "Uploads", summary: "List the staged uploads of the current user", response: []models.Upload{}},
+	"GET /api/uploads/details":  {tag: "Uploads", summary: "Get a staged upload", query: "upload_id:string!", response: models.Upload{}},
+
+	// Exams
+	"POST /api/exams":               {tag: "Exams", summary: "Create an exam", body: "title:string! description:string language:string generation_defaults:object collaboration:object metadata_fields:[]object", response: models.Exam{}, status: http.StatusCreated},
+	"GET /api/exams":                {tag: "Exams", summary: "List the exams the current user owns or was shared", query: pageParameters, response: []examResponse{}},
+	"GET /api/exams/details":        {tag: "Exams", summary: "Get an exam", query: "exam_id:string!", response: examResponse{}},
+	"PATCH /api/exams":              {tag: "Exams", summary: "Update an exam", body: "exam_id:string! title:string description:string generation_defaults:object collaboration:object metadata_fields:[]object", response: models.Exam{}},
+	"DELETE /api/exams":             {tag: "Exams", summary: "Delete an exam and everything in it", body: "exam_id:string!", response: messageResponse},
+	"GET /api/exams/search":         {tag: "Exams", summary: "Search the transcripts and documents of an exam", query: "exam_id:string! query:string!", response: "[]type:string lecture_id:string title:string snippet:string metadata:any"},
+	"POST /api/exams/suggest":       {tag: "Exams", summary: "Suggest a title and description for an exam from its lectures", body: "exam_id:string!", response: jobResponse, status: http.StatusAccepted},
+	"POST /api/exams/from-syllabus": {tag: "Exams", summary: "Create an exam and queue the import of its planned lectures from a syllabus", form: "syllabus:binary! title:string language:string", response: "exam:object job_id:string", status: http.StatusAccepted},
+	"GET /api/exams/concepts":       {tag: "Exams", summary: "List the concepts covered by the lectures of an exam", query: "exam_id:string!", response: []string{}},
+	"POST /api/exams/duplicates":    {tag: "Exams", summary: "Find content repeated across the lectures of an exam", body: "exam_id:string!", response: jobResponse, status: http.StatusAccepted},
+	"GET /api/exams/duplicates":     {tag: "Exams", summary: "List the content repeated across the lectures of an exam", query: "exam_id:string!", response: []models.ContentOverlap{}},
+	"GET /api/exams/members":        {tag: "Exams", summary: "List the members of an exam", query: "exam_id:string!", response: []models.ExamMember{}},
+	"PUT /api/exams/members":        {tag: "Exams", summary: "Share an exam with a user, or change their role", body: "exam_id:string! username:string! role:string!", response: models.ExamMember{}},
+	"DELETE /api/exams/members":     {tag: "Exams", summary: "Stop sharing an exam with a user", body: "exam_id:string! user_id:string!", response: messageResponse},
+	"GET /api/exams/history":        {tag: "Exams", summary: "List the changes made to an exam and its resources", query: "exam_id:string! lecture_id:string resource_type:string resource_id:string before_id:integer limit:integer", response: []models.ResourceEvent{}},
+
+	// Lectures
+	"POST /api/lectures":                    {tag: "Lectures", summary: "Create a lecture from recordings and documents, uploaded or staged, or fill in a planned one", form: "exam_id:string! lecture_id:string title:string description:string language:string diarize:boolean specified_date:string metadata_fields:string media:[]binary documents:[]binary media_upload_ids:[]string document_upload_ids:[]string", response: models.Lecture{}, status: http.StatusCreated},
+	"GET /api/lectures":                     {tag: "Lectures", summary: "List the lectures of an exam", query: "exam_id:string!" + pageParameters, response: []models.Lecture{}},
+	"GET /api/lectures/details":             {tag: "Lectures", summary: "Get a lecture", query: "exam_id:string! lecture_id:string!", response: models.Lecture{}},
+	"GET /api/lectures/report":              {tag: "Lectures", summary: "Get the processing report of a lecture", query: "exam_id:string! lecture_id:string!", response: models.ProcessingReport{}},
+	"GET /api/lectures/recap":               {tag: "Lectures", summary: "Get the short recap of a lecture", query: "exam_id:string! lecture_id:string!", response: "lecture_id:string status:string bullets:[]string error:string"},
+	"PATCH /api/lectures":                   {tag: "Lectures", summary: "Update a lecture", body: "lecture_id:string! exam_id:string! title:string description:string specified_date:string metadata_fields:[]object", response: models.Lecture{}},
+	"DELETE /api/lectures":                  {tag: "Lectures", summary: "Delete a lecture", body: "lecture_id:string! exam_id:string!", response: messageResponse},
+	"POST /api/lectures/retry-job":          {tag: "Lectures", summary: "Run a failed processing step of a lecture again", body: "lecture_id:string! exam_id:string! job_type:string! diarize:boolean", response: jobResponse, status: http.StatusAccepted},
+	"GET /api/lectures/bookmarks":           {tag: "Lectures", summary: "List the bookmarks of the current user in a lecture", query: "lecture_id:string!", response: []models.LectureBookmark{}},
+	"POST /api/lectures/bookmarks":          {tag: "Lectures", summary: "Bookmark a moment of a lecture", body: "lecture_id:string! name:string millisecond:integer!", response: models.LectureBookmark{}, status: http.StatusCreated},
+	"PATCH /api/lectures/bookmarks":         {tag: "Lectures", summary: "Rename or move a bookmark", body: "bookmark_id:string! name:string millisecond:integer", response: models.LectureBookmark{}},
+	"DELETE /api/lectures/bookmarks":        {tag: "Lectures", summary: "Delete a bookmark", body: "bookmark_id:string!", response: messageResponse},
+	"POST /api/lectures/documents/from-url": {tag: "Lectures", summary: "Add a webpage to a lecture as a document", body: "lecture_id:string! exam_id:string! url:string! title:string", response: jobResponse, status: http.StatusAccepted},
+
+	// Media and transcripts
+	"GET /api/media":                   {tag: "Media", summary: "List the recordings of a lecture", query: "lecture_id:string!", response: []models.LectureMedia{}},
+	"DELETE /api/media":                {tag: "Media", summary: "Delete a recording", body: "media_id:string! lecture_id:string!", response: messageResponse},
+	"GET /api/media/content":           {tag: "Media", summary: "Stream a recording, with range requests", query: "media_id:string! session_token:string", raw: "application/octet-stream", signedIn: true},
+	"GET /api/transcripts":             {tag: "Transcripts", summary: "Get the transcript of a lecture", query: "lecture_id:string!", response: "transcript_id:string status:string estimated_cost:number segments:[]object bookmarks:[]object"},
+	"PATCH /api/transcripts":           {tag: "Transcripts", summary: "Correct the text of transcript segments", body: "transcript_id:string lecture_id:string! segments:[]object!", response: messageResponse},
+	"GET /api/transcripts/html":        {tag: "Transcripts", summary: "Get the transcript of a lecture rendered as HTML", query: "lecture_id:string!", response: "transcript_id:string status:string estimated_cost:number segments:[]object"},
+	"POST /api/transcripts/polish":     {tag: "Transcripts", summary: "Polish the transcript of a lecture", body: "lecture_id:string! exam_id:string! force:boolean", response: jobResponse, status: http.StatusAccepted},
+	"GET /api/transcripts/redactions":  {tag: "Transcripts", summary: "List the redactions of a transcript", query: "lecture_id:string!", response: []models.TranscriptRedaction{}},
+	"POST /api/transcripts/redactions": {tag: "Transcripts", summary: "Redact phrases and time ranges of a transcript", body: "lecture_id:string! phrases:[]string time_ranges:[]object bleep_audio:boolean", response: "redactions:[]object redacted_segments:integer", status: http.StatusCreated},
+	"POST /api/transcripts/export":     {tag: "Exports", summary: "Export the transcript of a lecture", body: "lecture_id:string! exam_id:string! format:string! include_images:boolean include_qr_code:boolean include_bookmarks:boolean self_contained:boolean", response: jobResponse, status: http.StatusAccepted},
+
+	// Documents
+	"GET /api/documents":             {tag: "Documents", summary: "List the documents of a lecture", query: "lecture_id:string!" + pageParameters, response: []models.ReferenceDocument{}},
+	"GET /api/documents/details":     {tag: "Documents", summary: "Get a document", query: "document_id:string! lecture_id:string!", response: models.ReferenceDocument{}},
+	"PATCH /api/documents":           {tag: "Documents", summary: "Extract the text of a document again, with another method", body: "document_id:string! lecture_id:string! extraction_method:string! language:string", response: jobResponse, status: http.StatusAccepted},
+	"DELETE /api/documents":          {tag: "Documents", summary: "Delete a document", body: "document_id:string! lecture_id:string!", response: messageResponse},
+	"GET /api/documents/pages":       {tag: "Documents", summary: "List the pages of a document with their text", query: "document_id:string! lecture_id:string!", response: "[]id:string document_id:string page_number:integer image_path:string extracted_text:string extracted_html:string metadata:object extraction_source:string language:string"},
+	"GET /api/documents/pages/html":  {tag: "Documents", summary: "Get a page of a document rendered as HTML", query: "document_id:string! lecture_id:string! page_number:integer!", raw: "text/html"},
+	"GET /api/documents/pages/image": {tag: "Documents", summary: "Get the image of a page of a document", query: "document_id:string! lecture_id:string! page_number:integer! session_token:string", raw: "image/png", signedIn: true},
+	"GET /api/documents/chunks":      {tag: "Documents", summary: "List the chunks a document was split into for retrieval", query: "document_id:string! lecture_id:string!", response: []models.ReferenceChunk{}},
+	"POST /api/documents/export":     {tag: "Exports", summary: "Export a document", body: "document_id:string! lecture_id:string! exam_id:string! format:string! include_images:boolean include_qr_code:boolean self_contained:boolean", response: jobResponse, status: http.StatusAccepted},
+
+	// Tools
+	"POST /api/tools":                     {tag: "Tools", summary: "Generate a study guide, flashcards or a quiz", body: "exam_id:string! lecture_id:string type:string! length:string language_code:string enable_documents_matching:boolean adherence_threshold:integer maximum_retries:integer generate_images:boolean resume_job_id:string allow_partial_sources:boolean source:string model_documents_matching:string model_structure:string model_generation:string model_adherence:string model_polishing:string sampling:object preset_id:string", response: jobResponse, status: http.StatusAccepted},
+	"GET /api/tools":                      {tag: "Tools", summary: "List the tools of an exam", query: "exam_id:string lecture_id:string type:string language:string created_after:string created_before:string sort:string" + pageParameters, response: []models.Tool{}},
+	"GET /api/tools/details":              {tag: "Tools", summary: "Get a tool", query: "exam_id:string! tool_id:string!", response: models.Tool{}},
+	"PATCH /api/tools/details":            {tag: "Tools", summary: "Edit the title or content of a tool", body: "tool_id:string! exam_id:string! title:string content:string", response: messageResponse},
+	"PATCH /api/tools/content":            {tag: "Tools", summary: "Edit the markdown of a study guide whole or by section", body: "tool_id:string! exam_id:string! content:string edits:[]object", response: "tool_id:string content:string citations:integer version_id:string updated_at:string"},
+	"DELETE /api/tools":                   {tag: "Tools", summary: "Delete a tool", body: "tool_id:string! exam_id:string!", response: messageResponse},
+	"GET /api/tools/sections":             {tag: "Tools", summary: "List the sections of a study guide or course overview", query: "exam_id:string! tool_id:string!", response: []models.ToolSection{}},
+	"POST /api/tools/sections/regenerate": {tag: "Tools", summary: "Regenerate one section of a study guide or course overview", body: "exam_id:string! tool_id:string! section_id:integer!", response: jobResponse, status: http.StatusAccepted},
+	"GET /api/tools/versions":             {tag: "Tools", summary: "List the earlier versions of the tools of an exam", query: "exam_id:string! lecture_id:string type:string", response: []models.ToolVersion{}},
+	"POST /api/tools/versions/restore":    {tag: "Tools", summary: "Restore an earlier version of a tool", body: "exam_id:string! version_id:string!", response: "tool_id:string version_id:string replaced_version_id:string"},
+	"POST /api/tools/rollback":            {tag: "Tools", summary: "Roll a tool back to an earlier version, as POST /api/tools/versions/restore", body: "exam_id:string! version_id:string!", response: "tool_id:string version_id:string replaced_version_id:string"},
+	"GET /api/tools/versions/diff":        {tag: "Tools", summary: "Compare a version of a tool with another version or the current tool", query: "exam_id:string! version_id:string! against:string", response: "version_id:string against_version_id:string against_tool_id:string type:string blocks:[]object added:integer removed:integer unchanged:integer"},
+	"GET /api/tools/html":                 {tag: "Tools", summary: "Get a tool rendered as HTML", query: "exam_id:string! tool_id:string!", response: "tool_id:string title:string type:string content:any content_html:string citations:[]object"},
+	"POST /api/tools/export":              {tag: "Exports", summary: "Export a tool", body: "tool_id:string! exam_id:string! format:string! theme:string include_images:boolean include_qr_code:boolean self_contained:boolean", response: jobResponse, status: http.StatusAccepted},
+	"PUT /api/tools/feedback":             {tag: "Tools", summary: "Rate a tool", body: "tool_id:string! exam_id:string! rating:integer! comment:string", response: "tool_id:string rating:integer"},
+	"POST /api/tools/quiz/attempts":       {tag: "Tools", summary: "Submit and grade the answers to a quiz", body: "tool_id:string! exam_id:string! answers:[]object!", response: models.QuizAttempt{}, status: http.StatusCreated},
+	"GET /api/tools/quiz/attempts":        {tag: "Tools", summary: "List the attempts of the current user at a quiz", query: "exam_id:string! tool_id:string!" + pageParameters, response: []models.QuizAttempt{}},
+	"GET /api/tools/presets":              {tag: "Tools", summary: "List the generation presets of the current user", response: []models.GenerationPreset{}},
+	"POST /api/tools/presets":             {tag: "Tools", summary: "Save generation settings as a named preset", body: "name:string! description:string settings:object sampling:object", response: models.GenerationPreset{}, status: http.StatusCreated},
+	"PATCH /api/tools/presets":            {tag: "Tools", summary: "Rename a generation preset or replace its settings", body: "preset_id:string! name:string description:string settings:object sampling:object", response: models.GenerationPreset{}},
+	"DELETE /api/tools/presets":           {tag: "Tools", summary: "Delete a generation preset", body: "preset_id:string!", response: messageResponse},
+
+	// Exports
+	"POST /api/exports/presets":   {tag: "Exports", summary: "Save export settings as a preset", body: "exam_id:string name:string! formats:[]string! theme:string include_images:boolean include_qr_code:boolean", response: models.ExportPreset{}, status: http.StatusCreated},
+	"GET /api/exports/presets":    {tag: "Exports", summary: "List the export presets of the current user", query: "exam_id:string", response: []models.ExportPreset{}},
+	"DELETE /api/exports/presets": {tag: "Exports", summary: "Delete an export preset", body: "preset_id:string!", response: messageResponse},
+	"POST /api/exports/publish":   {tag: "Exports", summary: "Export the tools of an exam in several formats at once", body: "exam_id:string! tool_id:string preset_id:string formats:[]string theme:string include_images:boolean include_qr_code:boolean self_contained:boolean", response: "job_id:string tool_count:integer export_count:integer message:string", status: http.StatusAccepted},
+	"POST /api/offline/bundle":    {tag: "Exports", summary: "List what to download to study an exam offline", body: "exam_id:string! tool_ids:[]string lecture_ids:[]string", response: "exam_id:string version:string generated_at:date-time entries:[]object total_size:integer"},
+	"GET /api/exports/download":   {tag: "Exports", summary: "Download an exported file", query: "path:string! view:boolean session_token:string", raw: "application/octet-stream", signedIn: true},
+
+	// Chat
+	"POST /api/chat/sessions":          {tag: "Chat", summary: "Start a chat about an exam", body: "exam_id:string! title:string sampling:object", response: models.ChatSession{}, status: http.StatusCreated},
+	"GET /api/chat/sessions":           {tag: "Chat", summary: "List the chats about an exam", query: "exam_id:string!" + pageParameters, response: []models.ChatSession{}},
+	"GET /api/chat/sessions/details":   {tag: "Chat", summary: "Get a chat with its context and messages", query: "exam_id:string! session_id:string!" + pageParameters, response: "session:object context:object messages:[]object"},
+	"PATCH /api/chat/sessions/context": {tag: "Chat", summary: "Choose the lectures and tools a chat draws on", body: "session_id:string! included_lecture_ids:[]string included_tool_ids:[]string sampling:object", response: messageResponse},
+	"DELETE /api/chat/sessions":        {tag: "Chat", summary: "Delete a chat", body: "session_id:string! exam_id:string!", response: messageResponse},
+	"POST /api/chat/messages":          {tag: "Chat", summary: "Send a message; the answer streams over the WebSocket", body: "session_id:string! content:string! sampling:object", response: models.ChatMessage{}, status: http.StatusAccepted},
+
+	// Jobs and usage
+	"GET /api/jobs":          {tag: "Jobs", summary: "List the jobs of the current user", query: "course_id:string lecture_id:string label:string status:string type:string created_after:string created_before:string sort:string" + pageParameters, response: "[]id:string type:string status:string priority:string progress:integer progress_message_text:string payload:string result:string failure:object course_id:string lecture_id:string depends_on:[]string paused:boolean label:string note:string input_tokens:integer output_tokens:integer estimated_cost:number created_at:string"},
+	"GET /api/jobs/details":  {tag: "Jobs", summary: "Get a job", query: "job_id:string!", response: models.Job{}},
+	"GET /api/jobs/logs":     {tag: "Jobs", summary: "Get the lines logged while running a job, optionally waiting for new ones", query: "job_id:string! after:integer limit:integer follow:boolean wait:integer", response: "logs:[]object next_after:integer job_status:string"},
+	"DELETE /api/jobs":       {tag: "Jobs", summary: "Cancel a job, or delete the record of a finished one", body: "job_id:string! delete:boolean", response: messageResponse},
+	"PATCH /api/jobs":        {tag: "Jobs", summary: "Label a job or add a note to it", body: "job_id:string! label:string note:string", response: models.Job{}},
+	"GET /api/jobs/labels":   {tag: "Jobs", summary: "List the labels of the jobs of the current user", response: "[]label:string count:integer"},
+	"GET /api/jobs/types":    {tag: "Jobs", summary: "List the job types the queue runs", response: []string{}},
+	"POST /api/jobs/pause":   {tag: "Jobs", summary: "Pause a job at its next step", body: "job_id:string!", response: models.Job{}},
+	"POST /api/jobs/resume":  {tag: "Jobs", summary: "Resume a paused job", body: "job_id:string!", response: models.Job{}},
+	"GET /api/jobs/dead":     {tag: "Jobs", summary: "List the failed jobs that were not requeued", query: "code:string", response: "jobs:[]object failure_counts:object"},
+	"POST /api/jobs/requeue": {tag: "Jobs", summary: "Run a failed job again", body: "job_id:string!", response: models.Job{}, status: http.StatusCreated},
+	"GET /api/budget":        {tag: "Usage", summary: "Get the spending budget of the current user", response: jobs.CostBudgetStatus{}},
+	"GET /api/usage":         {tag: "Usage", summary: "Summarize the model usage and cost of the current user", query: "days:integer group_by:string", response: "group_by:string days:integer groups:[]object total:object"},
+	"GET /api/storage/usage": {tag: "Usage", summary: "Get the storage used by the current user and its quota", response: storageUsageResponse{}},
+
+	// Administration
+	"GET /api/admin/queue":                  {tag: "Administration", summary: "Get the state of the job queue", response: "paused:boolean types:[]object pools:[]object"},
+	"POST /api/admin/queue/pause":           {tag: "Administration", summary: "Stop starting queued jobs", response: "paused:boolean"},
+	"POST /api/admin/queue/resume":          {tag: "Administration", summary: "Start queued jobs again", response: "paused:boolean"},
+	"POST /api/admin/jobs/fail":             {tag: "Administration", summary: "Mark a stuck job as failed", body: "job_id:string! reason:string", response: messageResponse},
+	"POST /api/admin/jobs/reassign":         {tag: "Administration", summary: "Queue again the running jobs of workers that stopped", body: "minimum_age_seconds:integer", response: "reassigned:integer"},
+	"GET /api/admin/stats":                  {tag: "Administration", summary: "Get anonymized usage statistics", query: "days:integer"},
+	"GET /api/admin/users":                  {tag: "Administration", summary: "List the users", response: []models.User{}},
+	"POST /api/admin/users":                 {tag: "Administration", summary: "Create a user", body: "username:string! password:string! role:string! email:string require_password_change:boolean", response: models.User{}, status: http.StatusCreated},
+	"PATCH /api/admin/users":                {tag: "Administration", summary: "Change the role, password or email address of a user", body: "user_id:string! role:string password:string email:string require_password_change:boolean", response: models.User{}},
+	"DELETE /api/admin/users":               {tag: "Administration", summary: "Delete a user and their exams", query: "user_id:string!", response: messageResponse},
+	"PUT /api/admin/users/budget":           {tag: "Administration", summary: "Set the spending budgets of a user", body: "user_id:string! daily_budget:number monthly_budget:number", response: jobs.CostBudgetStatus{}},
+	"GET /api/admin/audit":                  {tag: "Administration", summary: "List the audit log", query: "actor_id:string actor_username:string ip_address:string action:string resource_type:string resource_id:string before_id:integer limit:integer", response: []models.AuditEvent{}},
+	"GET /api/admin/auth/events":            {tag: "Administration", summary: "List the audit log of authentication", query: "user_id:string username:string ip_address:string event:string before_id:integer limit:integer", response: []models.AuthEvent{}},
+	"GET /api/admin/auth/lockouts":          {tag: "Administration", summary: "List the usernames and addresses locked out of logins", response: []database.LoginLockout{}},
+	"POST /api/admin/auth/unlock":           {tag: "Administration", summary: "Lift the login lockout of a username or an address", body: "username:string ip_address:string", response: "cleared_attempts:integer"},
+	"PUT /api/admin/system/announcement":    {tag: "Administration", summary: "Announce maintenance or an outage to every user", body: "kind:string! level:string! message:string! starts_at:date-time ends_at:date-time", response: models.SystemAnnouncement{}},
+	"DELETE /api/admin/system/announcement": {tag: "Administration", summary: "Clear the announcement", response: messageResponse},
+	"GET /api/admin/prompt-variants":        {tag: "Administration", summary: "List the prompt variants with their results", query: "prompt_path:string", response: "experiments_enabled:boolean variants:[]object statistics:[]object"},
+	"POST /api/admin/prompt-variants":       {tag: "Administration", summary: "Add a variant of a prompt to experiment with", body: "prompt_path:string! name:string! content:string! weight:number active:boolean", response: models.PromptVariant{}, status: http.StatusCreated},
+	"PATCH /api/admin/prompt-variants":      {tag: "Administration", summary: "Activate, deactivate or reweigh a prompt variant", body: "id:string! active:boolean weight:number", response: messageResponse},
+	"GET /api/admin/database":               {tag: "Administration", summary: "Report the size of the database by table", response: database.SizeReport{}},
+	"POST /api/admin/database/maintenance":  {tag: "Administration", summary: "Prune, analyze and compact the database now", response: database.SizeReport{}},
+	"GET /api/admin/llm/providers":          {tag: "Administration", summary: "Report the calls and failovers of the model providers", response: "calls:[]object failovers:[]object"},
+	"GET /api/admin/backups":                {tag: "Administration", summary: "List the backup archives", response: []storage.BackupArchive{}},
+	"POST /api/admin/backups":               {tag: "Administration", summary: "Create a backup archive", response: jobResponse, status: http.StatusAccepted},
+	"GET /api/admin/backups/download":       {tag: "Administration", summary: "Download a backup archive", query: "name:string!", raw: "application/gzip"},
+	"POST /api/admin/restore":               {tag: "Administration", summary: "Restore a backup archive, stored or uploaded", body: "name:string", form: "archive:binary!", response: "name:string created_at:date-time schema_version:integer files:integer message:string"},
+
+	// System and settings
+	"GET /api/system/status":    {tag: "System", summary: "Get the announcement, queue and provider status shown to users"},
+	"GET /api/system/backup":    {tag: "System", summary: "Download a copy of the database", raw: "application/octet-stream", signedIn: true},
+	"POST /api/system/restore":  {tag: "System", summary: "Replace the database with an uploaded copy, before setup or as an administrator", form: "database:binary!", response: messageResponse},
+	"GET /api/settings":         {tag: "Settings", summary: "Get the settings", response: "llm:object transcription:object documents:object safety:object providers:object privacy:object resolved_models:object"},
+	"PATCH /api/settings":       {tag: "Settings", summary: "Update sections of the settings", body: "llm:object transcription:object documents:object safety:object providers:object uploads:object"},
+	"GET /api/settings/keys":    {tag: "Settings", summary: "List the provider API keys of the current user, masked", response: []database.UserProviderKey{}},
+	"PUT /api/settings/keys":    {tag: "Settings", summary: "Store a provider API key of the current user, sealed", body: "provider:string! api_key:string!", response: []database.UserProviderKey{}},
+	"DELETE /api/settings/keys": {tag: "Settings", summary: "Delete a provider API key of the current user", body: "provider:string!", response: messageResponse},
+
+	// Webhooks
+	"GET /api/webhooks":                       {tag: "Webhooks", summary: "List the webhooks of the current user", response: []models.Webhook{}},
+	"POST /api/webhooks":                      {tag: "Webhooks", summary: "Register a webhook, its signing secret returned only once", body: "url:string! events:[]string! description:string secret:string", response: "webhook:object secret:string", status: http.StatusCreated},
+	"PATCH /api/webhooks":                     {tag: "Webhooks", summary: "Update or pause a webhook", body: "webhook_id:string! url:string events:[]string description:string active:boolean", response: models.Webhook{}},
+	"DELETE /api/webhooks":                    {tag: "Webhooks", summary: "Delete a webhook and its deliveries", body: "webhook_id:string!", response: messageResponse},
+	"POST /api/webhooks/test":                 {tag: "Webhooks", summary: "Send a ping event to a webhook", body: "webhook_id:string!", response: models.WebhookDelivery{}, status: http.StatusAccepted},
+	"GET /api/webhooks/deliveries":            {tag: "Webhooks", summary: "List the deliveries of the webhooks of the current user, newest first", query: "webhook_id:string status:string" + pageParameters, response: []models.WebhookDelivery{}},
+	"POST /api/webhooks/deliveries/redeliver": {tag: "Webhooks", summary: "Queue a delivery again with a fresh count of attempts", body: "delivery_id:string!", response: messageResponse, status: http.StatusAccepted},
+
+	// Realtime
+	"GET /api/socket": {tag: "Realtime", summary: "Open the WebSocket of job progress and chat answers", query: "session_token:string subscribe_chat:string", status: http.StatusSwitchingProtocols, raw: "application/json", signedIn: true},
+}
+
+// apiErrorCodes are the codes of the "error" of failed responses
+var apiErrorCodes = []string{
+	"ACCOUNT_LOCKED", "ALREADY_INITIALIZED", "AUTHENTICATION_ERROR", "BACKGROUND_JOB_ERROR", "BACKUP_ERROR",
+	"BUDGET_EXCEEDED", "CONFIGURATION_ERROR", "CONVERSION_ERROR", "CSRF_ERROR", "DATABASE_ERROR", "DOCUMENT_NOT_READY",
+	"EMAIL_TAKEN", "ENCRYPTION_ERROR", "ENCRYPTION_UNAVAILABLE", "FILE_ERROR", "FILE_NOT_FOUND", "FILE_UPLOAD_ERROR",
+	"FORBIDDEN", "FORBIDDEN_SETTING", "GRADING_ERROR", "IMAGE_NOT_FOUND", "INTERNAL_ERROR", "INVALID_BACKUP", "INVALID_CITATIONS",
+	"INVALID_SIZE", "INVALID_STATE", "INVALID_TOKEN", "JOBS_RUNNING", "JOB_NOT_RESUMABLE", "JOB_TYPE_DISABLED",
+	"JSON_ERROR", "LAST_ADMINISTRATOR", "LECTURE_NOT_PLANNED", "LECTURE_NOT_READY", "LIMIT_REACHED", "MAINTENANCE_ERROR", "NOT_FOUND",
+	"NOT_INITIALIZED", "OBJECT_STORAGE_ERROR", "OIDC_DISABLED", "OIDC_UNAVAILABLE", "PASSWORD_CHANGE_REQUIRED",
+	"PASSWORD_RESET_UNAVAILABLE", "PAYLOAD_TOO_LARGE", "PRESET_NAME_TAKEN", "PRIVACY_BUDGET_EXHAUSTED", "RATE_LIMIT", "REGISTRATION_DISABLED",
+	"RESOURCE_VIOLATION", "RESTORE_ERROR", "RESTORE_IN_PROGRESS", "STATISTICS_DISABLED", "STORAGE_QUOTA_EXCEEDED",
+	"SYLLABUS_UNAVAILABLE", "TOO_MANY_CONNECTIONS", "TRANSCRIPT_NOT_READY", "USERNAME_TAKEN",
+	"USER_HAS_ACTIVE_JOBS", "VALIDATION_ERROR", "WEBHOOKS_UNAVAILABLE",
+}
+
+// openAPISchemas builds the schemas of Go types, named structs becoming shared components
+type openAPISchemas struct {
+	components map[string]any
+	types      map[string]reflect.Type
+}
+
+// schemaOf returns the schema of the JSON encoding of a type
+func (schemas *openAPISchemas) schemaOf(valueType reflect.Type) map[string]any {
+	switch valueType {
+	case reflect.TypeFor[time.Time]():
+		return map[string]any{"type": "string", "format": "date-time"}
+	case reflect.TypeFor[json.RawMessage]():
+		return map[string]any{}
+	}
+
+	switch valueType.Kind() {
+	case reflect.Pointer:
+		return schemas.schemaOf(valueType.Elem())
+	case reflect.Bool:
+		return map[string]any{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32:
+		return map[string]any{"type": "integer"}
+	case reflect.Int64, reflect.Uint64:
+		return map[string]any{"type": "integer", "format": "int64"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]any{"type": "number"}
+	case reflect.String:
+		return map[string]any{"type": "string"}
+	case reflect.Slice, reflect.Array:
+		if valueType.Elem().Kind() == reflect.Uint8 {
+			return map[string]any{"type": "string", "format": "byte"}
+		}
+		return map[string]any{"type": "array", "items": schemas.schemaOf(valueType.Elem())}
+	case reflect.Map:
+		return map[string]any{"type": "object", "additionalProperties": schemas.schemaOf(valueType.Elem())}
+	case reflect.Struct:
+		if valueType.Name() == "" {
+			return schemas.structSchema(valueType)
+		}
+		name := strings.ToUpper(valueType.Name()[:1]) + valueType.Name()[1:]
+		if knownType, found := schemas.types[name]; found && knownType != valueType {
+			// Types of other packages sharing a name are told apart by their package
+			packageName := valueType.PkgPath()[strings.LastIndex(valueType.PkgPath(), "/")+1:]
+			name = strings.ToUpper(packageName[:1]) + packageName[1:] + name
+		}
+		if _, found := schemas.types[name]; !found {
+			// Registered before its fields, for types that contain themselves
+			schemas.types[name] = valueType
+			schemas.components[name] = schemas.structSchema(valueType)
+		}
+		return map[string]any{"$ref": "#/components/schemas/" + name}
+	default:
+		return map[string]any{}
+	}
+}
+
+// structSchema returns the object schema of the exported fields of a struct, those of embedded structs
+// included, as encoding/json writes them
+func (schemas *openAPISchemas) structSchema(structType reflect.Type) map[string]any {
+	properties := map[string]any{}
+	var required []string
+	for index := range structType.NumField() {
+		field := structType.Field(index)
+		name, options, _ := strings.Cut(field.Tag.Get("json"), ",")
+		if name == "-" || (!field.IsExported() && !field.Anonymous) {
+			continue
+		}
+		fieldType := field.Type
+		if fieldType.Kind() == reflect.Pointer {
+			fieldType = fieldType.Elem()
+		}
+		if field.Anonymous && name == "" && fieldType.Kind() == reflect.Struct {
+			embedded := schemas.structSchema(fieldType)
+			for propertyName, property := range embedded["properties"].(map[string]any) {
+				properties[propertyName] = property
+			}
+			if embeddedRequired, found := embedded["required"].([]string); found {
+				required = append(required, embeddedRequired...)
+			}
+			continue
+		}
+		if name == "" {
+			name = field.Name
+		}
+		properties[name] = schemas.schemaOf(field.Type)
+		if !strings.Contains(options, "omitempty") && field.Type.Kind() != reflect.Pointer {
+			required = append(required, name)
+		}
+	}
+	schema := map[string]any{"type": "object", "properties": properties}
+	if len(required) > 0 {
+		slices.Sort(required)
+		schema["required"] = slices.Compact(required)
+	}
+	return schema
+}
+
+// fieldTypeSchema returns the schema of a type of the field notation of apiOperation
+func fieldTypeSchema(typeName string) map[string]any {
+	if elementType, isArray := strings.CutPrefix(typeName, "[]"); isArray {
+		return map[string]any{"type": "array", "items": fieldTypeSchema(elementType)}
+	}
+	switch typeName {
+	case "any":
+		return map[string]any{}
+	case "date-time":
+		return map[string]any{"type": "string", "format": "date-time"}
+	case "binary":
+		return map[string]any{"type": "string", "format": "binary"}
+	default:
+		return map[string]any{"type": typeName}
+	}
+}
+
+// parseFields splits the field notation of apiOperation into names, types and whether they are required
+func parseFields(fields string) []struct {
+	name     string
+	typeName string
+	required bool
+} {
+	var parsed []struct {
+		name     string
+		typeName string
+		required bool
+	}
+	for _, field := range strings.Fields(fields) {
+		name, typeName, _ := strings.Cut(field, ":")
+		typeName, required := strings.CutSuffix(typeName, "!")
+		parsed = append(parsed, struct {
+			name     string
+			typeName string
+			required bool
+		}{name, typeName, required})
+	}
+	return parsed
+}
+
+// fieldsSchema returns the object schema of fields in the notation of apiOperation. A leading "[]" makes it an
+// array of such objects
+func fieldsSchema(fields string) map[string]any {
+	if objectFields, isArray := strings.CutPrefix(fields, "[]"); isArray {
+		return map[string]any{"type": "array", "items": fieldsSchema(objectFields)}
+	}
+	properties := map[string]any{}
+	var required []string
+	for _, field := range parseFields(fields) {
+		properties[field.name] = fieldTypeSchema(field.typeName)
+		if field.required {
+			required = append(required, field.name)
+		}
+	}
+	schema := map[string]any{"type": "object", "properties": properties}
+	if len(required) > 0 {
+		schema["required"] = required
+	}
+	return schema
+}
+
+// operationID names an operation after its method and path, such as "postExamsFromSyllabus"
+func operationID(method string, pathTemplate string) string {
+	operationName := strings.ToLower(method)
+	for _, segment := range strings.FieldsFunc(strings.TrimPrefix(pathTemplate, "/api"), func(character rune) bool {
+		return character == '/' || character == '-' || character == '.'
+	}) {
+		operationName += strings.ToUpper(segment[:1]) + segment[1:]
+	}
+	return operationName
+}
+
+// openAPIDocument describes the routes of the server as an OpenAPI 3 document
+func (server *Server) openAPIDocument() map[string]any {
+	schemas := &openAPISchemas{components: map[string]any{}, types: map[string]reflect.Type{}}
+	schemas.components["Meta"] = schemas.structSchema(reflect.TypeFor[models.Meta]())
+	schemas.components["Error"] = map[string]any{
+		"type":     "object",
+		"required": []string{"error", "meta"},
+		"properties": map[string]any{
+			"error": map[string]any{
+				"type":     "object",
+				"required": []string{"code", "message"},
+				"properties": map[string]any{
+					"code":    map[string]any{"type": "string", "enum": apiErrorCodes},
+					"message": map[string]any{"type": "string"},
+					"details": map[string]any{"description": "Details depending on the code, such as the fields that failed validation"},
+				},
+			},
+			"meta": map[string]any{"$ref": "#/components/schemas/Meta"},
+		},
+	}
+
+	paths := map[string]map[string]any{}
+	server.router.Walk(func(route *mux.Route, router *mux.Router, ancestors []*mux.Route) error {
+		pathTemplate, err := route.GetPathTemplate()
+		if err != nil {
+			return nil
+		}
+		methods, err := route.GetMethods()
+		if err != nil {
+			return nil
+		}
+		for _, method := range methods {
+			if method == http.MethodOptions {
+				continue
+			}
+			operation := apiOperations[method+" "+pathTemplate]
+			if paths[pathTemplate] == nil {
+				paths[pathTemplate] = map[string]any{}
+			}
+			// Routes of the authenticated API are those of its subrouter
+			paths[pathTemplate][strings.ToLower(method)] = server.openAPIOperation(schemas, method, pathTemplate, operation, len(ancestors) > 0)
+		}
+		return nil
+	})
+
+	documentServer := map[string]any{"url": "/"}
+	if basePath := server.basePath(); basePath != "" {
+		documentServer["url"] = basePath
+	}
+	return map[string]any{
+		"openapi": "3.0.3",
+		"info": map[string]any{
+			"title":   "Lectures Assistant API",
+			"version": apiVersion,
+			"description": "Successful responses wrap their result in \"data\", next to \"meta\"; failed ones carry an \"error\" " +
+				"with a code. Requests authenticate with a session token from /api/auth/login or a personal access token, " +
+				"as a bearer token, or with the session cookie; requests with the cookie must also send \"X-Requested-With\".",
+		},
+		"servers": []any{documentServer},
+		"paths":   paths,
+		"components": map[string]any{
+			"schemas": schemas.components,
+			"securitySchemes": map[string]any{
+				"bearerToken":   map[string]any{"type": "http", "scheme": "bearer", "description": "A session token, or a personal access token starting with \"" + apiTokenPrefix + "\""},
+				"sessionCookie": map[string]any{"type": "apiKey", "in": "cookie", "name": "session_token"},
+			},
+			"responses": map[string]any{
+				"Error": map[string]any{
+					"description": "The request failed",
+					"content":     map[string]any{"application/json": map[string]any{"schema": map[string]any{"$ref": "#/components/schemas/Error"}}},
+				},
+			},
+		},
+		"security": []any{map[string]any{"bearerToken": []string{}}, map[string]any{"sessionCookie": []string{}}},
+	}
+}
+
+// openAPIOperation describes one method of a route
+func (server *Server) openAPIOperation(schemas *openAPISchemas, method string, pathTemplate string, operation apiOperation, authenticated bool) map[string]any {
+	described := map[string]any{
+		"operationId": operationID(method, pathTemplate),
+		"summary":     operation.summary,
+	}
+	if operation.tag != "" {
+		described["tags"] = []string{operation.tag}
+	}
+
+	var requirements []string
+	if !authenticated && !operation.signedIn {
+		described["security"] = []any{}
+	}
+	if authenticated {
+		if permission := routePermission(method, pathTemplate); permission != "" {
+			requirements = append(requirements, fmt.Sprintf("Requires the %q permission.", permission))
+		}
+		if scope := routeTokenScope(method, pathTemplate); scope != "" {
+			requirements = append(requirements, fmt.Sprintf("Personal access tokens need the %q scope.", scope))
+		} else {
+			requirements = append(requirements, "Personal access tokens cannot use it.")
+		}
+	}
+	if len(requirements) > 0 {
+		described["description"] = strings.Join(requirements, " ")
+	}
+
+	var parameters []any
+	for _, field := range parseFields(operation.query) {
+		parameters = append(parameters, map[string]any{
+			"name":     field.name,
+			"in":       "query",
+			"required": field.required,
+			"schema":   fieldTypeSchema(field.typeName),
+		})
+	}
+	if len(parameters) > 0 {
+		described["parameters"] = parameters
+	}
+
+	content := map[string]any{}
+	if operation.body != "" {
+		content["application/json"] = map[string]any{"schema": fieldsSchema(operation.body)}
+	}
+	if operation.form != "" {
+		content["multipart/form-data"] = map[string]any{"schema": fieldsSchema(operation.form)}
+	}
+	if len(content) > 0 {
+		described["requestBody"] = map[string]any{"required": true, "content": content}
+	}
+
+	status := operation.status
+	if status == 0 {
+		status = http.StatusOK
+	}
+	success := map[string]any{"description": http.StatusText(status)}
+	switch {
+	case operation.raw != "":
+		success["content"] = map[string]any{operation.raw: map[string]any{"schema": map[string]any{"type": "string", "format": "binary"}}}
+	case status != http.StatusFound:
+		var dataSchema map[string]any
+		switch response := operation.response.(type) {
+		case nil:
+			dataSchema = map[string]any{"type": "object"}
+		case string:
+			dataSchema = fieldsSchema(response)
+		default:
+			dataSchema = schemas.schemaOf(reflect.TypeOf(response))
+		}
+		success["content"] = map[string]any{"application/json": map[string]any{"schema": map[string]any{
+			"type":     "object",
+			"required": []string{"data", "meta"},
+			"properties": map[string]any{
+				"data": dataSchema,
+				"meta": map[string]any{"$ref": "#/components/schemas/Meta"},
+			},
+		}}}
+	}
+	described["responses"] = map[string]any{
+		fmt.Sprint(status): success,
+		"default":          map[string]any{"$ref": "#/components/responses/Error"},
+	}
+	return described
+}
+
+// handleOpenAPI serves the OpenAPI document of the API
+func (server *Server) handleOpenAPI(responseWriter http.ResponseWriter, request *http.Request) {
+	responseWriter.Header().Set("Content-Type", "application/json")
+	encoder := json.NewEncoder(responseWriter)
+	encoder.SetIndent("", "  ")
+	encoder.Encode(server.openAPIDocument())
+}
+
+// defaultSwaggerUIURL is the release of swagger-ui-dist /api/docs loads when no copy is configured, pinned to
+// an exact version so the page never runs a release nobody reviewed
+const defaultSwaggerUIURL = "https://unpkg.com/swagger-ui-dist@5.17.14"
+
+// swaggerUIScript starts Swagger UI on the OpenAPI document next to the page. The Content-Security-Policy of the
+// page allows it by its hash and no other inline script
+const swaggerUIScript = `window.ui = SwaggerUIBundle({ url: "openapi.json", dom_id: "#swagger-ui" });`
+
+// swaggerUIPage browses the OpenAPI document with the Swagger UI found at an address
+var swaggerUIPage = template.Must(template.New("docs").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+  <meta charset="utf-8">
+  <title>Lectures Assistant API</title>
+  <link rel="stylesheet" href="{{.URL}}/swagger-ui.css">
+</head>
+<body>
+  <div id="swagger-ui"></div>
+  <script src="{{.URL}}/swagger-ui-bundle.js"></script>
+  <script>` + swaggerUIScript + `</script>
+</body>
+</html>
+`))
+
+// handleAPIDocs serves Swagger UI for the OpenAPI document, from the configured copy of swagger-ui-dist or the
+// pinned release. Scripts and styles may only come from there
+func (server *Server) handleAPIDocs(responseWriter http.ResponseWriter, request *http.Request) {
+	swaggerUIURL := strings.TrimSuffix(server.configuration.Server.SwaggerUIURL, "/")
+	if swaggerUIURL == "" {
+		swaggerUIURL = defaultSwaggerUIURL
+	}
+	assetSource := swaggerUIURL + "/"
+	if strings.HasPrefix(swaggerUIURL, "/") && !strings.HasPrefix(swaggerUIURL, "//") {
+		assetSource = "'self'"
+	}
+	scriptHash := sha256.Sum256([]byte(swaggerUIScript))
+	responseWriter.Header().Set("Content-Security-Policy", fmt.Sprintf(
+		"default-src 'none'; script-src %s 'sha256-%s'; style-src %s; img-src 'self' data:; connect-src 'self'",
+		assetSource, base64.StdEncoding.EncodeToString(scriptHash[:]), assetSource,
+	))
+	responseWriter.Header().Set("Content-Type", "text/html; charset=utf-8")
+	swaggerUIPage.Execute(responseWriter, struct{ URL string }{swaggerUIURL})
+}
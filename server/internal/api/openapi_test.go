@@ -0,0 +1,348 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+)
+
+// schemaViolations lists where a decoded JSON value departs from an OpenAPI schema of document: a value of
+// another type, a missing required property, or a property of a described object the schema does not name
+func schemaViolations(document map[string]any, schema map[string]any, value any, location string) []string {
+	if reference, found := schema["$ref"].(string); found {
+		components := document["components"].(map[string]any)["schemas"].(map[string]any)
+		return schemaViolations(document, components[strings.TrimPrefix(reference, "#/components/schemas/")].(map[string]any), value, location)
+	}
+	if value == nil {
+		// Pointers and empty slices of Go values encode as null
+		return nil
+	}
+
+	var violations []string
+	switch schema["type"] {
+	case "string":
+		if _, isString := value.(string); !isString {
+			violations = append(violations, location+" is not a string")
+		}
+	case "boolean":
+		if _, isBoolean := value.(bool); !isBoolean {
+			violations = append(violations, location+" is not a boolean")
+		}
+	case "number":
+		if _, isNumber := value.(float64); !isNumber {
+			violations = append(violations, location+" is not a number")
+		}
+	case "integer":
+		if number, isNumber := value.(float64); !isNumber || number != math.Trunc(number) {
+			violations = append(violations, location+" is not an integer")
+		}
+	case "array":
+		items, isArray := value.([]any)
+		if !isArray {
+			return append(violations, location+" is not an array")
+		}
+		itemSchema, _ := schema["items"].(map[string]any)
+		for index, item := range items {
+			violations = append(violations, schemaViolations(document, itemSchema, item, location+"["+strconv.Itoa(index)+"]")...)
+		}
+	case "object":
+		object, isObject := value.(map[string]any)
+		if !isObject {
+			return append(violations, location+" is not an object")
+		}
+		required, _ := schema["required"].([]any)
+		for _, name := range required {
+			if _, found := object[name.(string)]; !found {
+				violations = append(violations, location+" is missing "+name.(string))
+			}
+		}
+		properties, described := schema["properties"].(map[string]any)
+		if additional, found := schema["additionalProperties"].(map[string]any); found {
+			for name, property := range object {
+				violations = append(violations, schemaViolations(document, additional, property, location+"."+name)...)
+			}
+		} else if described && len(properties) > 0 {
+			for name, property := range object {
+				propertySchema, found := properties[name].(map[string]any)
+				if !found {
+					violations = append(violations, location+"."+name+" is not documented")
+					continue
+				}
+				violations = append(violations, schemaViolations(document, propertySchema, property, location+"."+name)...)
+			}
+		}
+	}
+	return violations
+}
+
+func TestOpenAPIDocument(t *testing.T) {
+	server, _, _, cleanup := setupUniqueExtraTestEnv(t, "openapi")
+	defer cleanup()
+
+	// Every route is described
+	server.router.Walk(func(route *mux.Route, router *mux.Router, ancestors []*mux.Route) error {
+		pathTemplate, err := route.GetPathTemplate()
+		if err != nil {
+			return nil
+		}
+		methods, _ := route.GetMethods()
+		for _, method := range methods {
+			if method == http.MethodOptions {
+				continue
+			}
+			if operation, found := apiOperations[method+" "+pathTemplate]; !found || operation.summary == "" {
+				t.Errorf("Expected %s %s to be described in apiOperations", method, pathTemplate)
+			}
+		}
+		return nil
+	})
+	// And every description is of a route
+	for operationKey := range apiOperations {
+		method, pathTemplate, _ := strings.Cut(operationKey, " ")
+		request := httptest.NewRequest(method, pathTemplate, nil)
+		var match mux.RouteMatch
+		if !server.router.Match(request, &match) || match.Route == nil {
+			t.Errorf("Expected %s to be a route", operationKey)
+			continue
+		}
+		if matchedTemplate, _ := match.Route.GetPathTemplate(); matchedTemplate != pathTemplate {
+			t.Errorf("Expected %s to be a route, found %s", operationKey, matchedTemplate)
+		}
+	}
+
+	// The document is public
+	req := httptest.NewRequest("GET", "/api/openapi.json", nil)
+	rr := httptest.NewRecorder()
+	server.Handler().ServeHTTP(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", rr.Code, rr.Body.String())
+	}
+	var document struct {
+		OpenAPI    string                               `json:"openapi"`
+		Paths      map[string]map[string]map[string]any `json:"paths"`
+		Components struct {
+			Schemas map[string]any `json:"schemas"`
+		} `json:"components"`
+	}
+	if err := json.Unmarshal(rr.Body.Bytes(), &document); err != nil {
+		t.Fatalf("Expected a JSON document: %v", err)
+	}
+	if document.OpenAPI != "3.0.3" {
+		t.Errorf("Expected OpenAPI 3.0.3, got %q", document.OpenAPI)
+	}
+	for _, schema := range []string{"Exam", "Lecture", "Job", "Error"} {
+		if _, found := document.Components.Schemas[schema]; !found {
+			t.Errorf("Expected the %s schema", schema)
+		}
+	}
+	createExam := document.Paths["/api/exams"]["post"]
+	if createExam == nil || createExam["requestBody"] == nil {
+		t.Fatalf("Expected POST /api/exams with a request body, got %v", document.Paths["/api/exams"])
+	}
+	if description, _ := createExam["description"].(string); !strings.Contains(description, "exams:write") {
+		t.Errorf("Expected POST /api/exams to name its token scope, got %q", description)
+	}
+	if security, found := document.Paths["/api/auth/login"]["post"]["security"].([]any); !found || len(security) != 0 {
+		t.Errorf("Expected POST /api/auth/login not to require authentication")
+	}
+
+	req = httptest.NewRequest("GET", "/api/docs", nil)
+	rr = httptest.NewRecorder()
+	server.Handler().ServeHTTP(rr, req)
+	if rr.Code != http.StatusOK || !strings.Contains(rr.Body.String(), "openapi.json") {
+		t.Errorf("Expected Swagger UI pointing to the document, got %d", rr.Code)
+	}
+	if !strings.Contains(rr.Body.String(), defaultSwaggerUIURL+"/swagger-ui-bundle.js") {
+		t.Errorf("Expected Swagger UI of the pinned release, got:\n%s", rr.Body.String())
+	}
+	if policy := rr.Header().Get("Content-Security-Policy"); !strings.Contains(policy, "script-src "+defaultSwaggerUIURL+"/ 'sha256-") {
+		t.Errorf("Expected scripts limited to the pinned release and the page's own, got %q", policy)
+	}
+
+	// A self-hosted copy replaces the CDN
+	server.configuration.Server.SwaggerUIURL = "/swagger-ui/"
+	rr = httptest.NewRecorder()
+	server.Handler().ServeHTTP(rr, httptest.NewRequest("GET", "/api/docs", nil))
+	if !strings.Contains(rr.Body.String(), `src="/swagger-ui/swagger-ui-bundle.js"`) || strings.Contains(rr.Body.String(), "unpkg.com") {
+		t.Errorf("Expected Swagger UI from the configured copy, got:\n%s", rr.Body.String())
+	}
+	if policy := rr.Header().Get("Content-Security-Policy"); !strings.Contains(policy, "script-src 'self' 'sha256-") {
+		t.Errorf("Expected scripts limited to the server for a copy it serves, got %q", policy)
+	}
+}
+
+// TestOpenAPIErrorCodes checks that the error codes the handlers answer with are those the document lists
+func TestOpenAPIErrorCodes(t *testing.T) {
+	files, err := filepath.Glob("*.go")
+	if err != nil {
+		t.Fatalf("Failed to list the sources: %v", err)
+	}
+	usedCodes := make(map[string]bool)
+	fileSet := token.NewFileSet()
+	for _, file := range files {
+		if strings.HasSuffix(file, "_test.go") {
+			continue
+		}
+		parsedFile, err := parser.ParseFile(fileSet, file, nil, 0)
+		if err != nil {
+			t.Fatalf("Failed to parse %s: %v", file, err)
+		}
+		ast.Inspect(parsedFile, func(node ast.Node) bool {
+			call, isCall := node.(*ast.CallExpr)
+			if !isCall || len(call.Args) < 3 {
+				return true
+			}
+			selector, isSelector := call.Fun.(*ast.SelectorExpr)
+			if !isSelector || (selector.Sel.Name != "writeError" && selector.Sel.Name != "writeLoginLocked") {
+				return true
+			}
+			// writeError takes the code after the status, writeLoginLocked first
+			codeArgument := call.Args[2]
+			if selector.Sel.Name == "writeLoginLocked" {
+				codeArgument = call.Args[1]
+			}
+			if literal, isLiteral := codeArgument.(*ast.BasicLit); isLiteral && literal.Kind == token.STRING {
+				code, _ := strconv.Unquote(literal.Value)
+				usedCodes[code] = true
+			}
+			return true
+		})
+	}
+
+	for code := range usedCodes {
+		if !slices.Contains(apiErrorCodes, code) {
+			t.Errorf("Expected %s to be listed in apiErrorCodes", code)
+		}
+	}
+	for _, code := range apiErrorCodes {
+		if !usedCodes[code] {
+			t.Errorf("Expected %s of apiErrorCodes to be used by a handler", code)
+		}
+	}
+}
+
+// TestOpenAPIResponses checks the responses of the routes against the schemas the document gives them, so
+// hand-written field lists cannot drift from what the handlers write
+func TestOpenAPIResponses(t *testing.T) {
+	server, userID, sessionID, cleanup := setupUniqueExtraTestEnv(t, "openapi_responses")
+	defer cleanup()
+	server.database.Exec("UPDATE users SET role = 'admin' WHERE id = ?", userID)
+
+	var document map[string]any
+	json.Unmarshal(mustMarshal(t, server.openAPIDocument()), &document)
+	paths := document["paths"].(map[string]any)
+
+	call := func(method string, target string, body any) map[string]any {
+		t.Helper()
+		var requestBody bytes.Buffer
+		if body != nil {
+			json.NewEncoder(&requestBody).Encode(body)
+		}
+		req := httptest.NewRequest(method, target, &requestBody)
+		req.Header.Set("Authorization", "Bearer "+sessionID)
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("X-Requested-With", "XMLHttpRequest")
+		rr := httptest.NewRecorder()
+		server.Handler().ServeHTTP(rr, req)
+
+		var match mux.RouteMatch
+		server.router.Match(req, &match)
+		pathTemplate, _ := match.Route.GetPathTemplate()
+		operation := paths[pathTemplate].(map[string]any)[strings.ToLower(method)].(map[string]any)
+		responses := operation["responses"].(map[string]any)
+		success, found := responses[strconv.Itoa(rr.Code)].(map[string]any)
+		if !found {
+			t.Errorf("%s %s answered %d, which is not documented: %s", method, target, rr.Code, rr.Body.String())
+			return nil
+		}
+		schema := success["content"].(map[string]any)["application/json"].(map[string]any)["schema"].(map[string]any)
+		var response map[string]any
+		if err := json.Unmarshal(rr.Body.Bytes(), &response); err != nil {
+			t.Errorf("%s %s answered no JSON: %v", method, target, err)
+			return nil
+		}
+		for _, violation := range schemaViolations(document, schema, response, "response") {
+			t.Errorf("%s %s: %s", method, target, violation)
+		}
+		data, _ := response["data"].(map[string]any)
+		return data
+	}
+
+	exam := call("POST", "/api/exams", map[string]any{"title": "Optics", "description": "Light"})
+	examID, _ := exam["id"].(string)
+	if examID == "" {
+		t.Fatalf("Expected an exam to be created, got %v", exam)
+	}
+	server.database.Exec("INSERT INTO lectures (id, exam_id, title, status) VALUES ('openapi-lecture', ?, 'Lenses', 'ready')", examID)
+	server.database.Exec("INSERT INTO transcripts (id, lecture_id, status) VALUES ('openapi-transcript', 'openapi-lecture', 'completed')")
+	server.database.Exec("INSERT INTO transcript_segments (transcript_id, start_millisecond, end_millisecond, text) VALUES ('openapi-transcript', 0, 1000, 'Lenses bend light.')")
+	server.database.Exec("INSERT INTO reference_documents (id, lecture_id, document_type, title, file_path, page_count, extraction_status) VALUES ('openapi-document', 'openapi-lecture', 'pdf', 'Slides', 'slides.pdf', 1, 'completed')")
+	server.database.Exec("INSERT INTO reference_pages (document_id, page_number, image_path, extracted_text) VALUES ('openapi-document', 1, 'page-1.png', 'Thin lenses')")
+	server.database.Exec("INSERT INTO tools (id, exam_id, lecture_id, type, title, language_code, content) VALUES ('openapi-tool', ?, 'openapi-lecture', 'guide', 'Lenses', 'en', '# Lenses')", examID)
+	server.database.Exec("INSERT INTO jobs (id, user_id, type, status, payload, progress_message_text, result) VALUES ('openapi-job', ?, 'BUILD_MATERIAL', 'COMPLETED', '{}', '', '{}')", userID)
+
+	query := "?exam_id=" + examID + "&lecture_id=openapi-lecture"
+	for _, target := range []string{
+		"/api/health",
+		"/api/auth/status",
+		"/api/auth/tokens",
+		"/api/exams",
+		"/api/exams/details" + query,
+		"/api/exams/members" + query,
+		"/api/exams/history" + query,
+		"/api/lectures" + query,
+		"/api/lectures/details" + query,
+		"/api/lectures/report" + query,
+		"/api/lectures/bookmarks" + query,
+		"/api/transcripts" + query,
+		"/api/transcripts/redactions" + query,
+		"/api/documents" + query,
+		"/api/documents/details" + query + "&document_id=openapi-document",
+		"/api/documents/pages" + query + "&document_id=openapi-document",
+		"/api/tools" + query,
+		"/api/tools/details" + query + "&tool_id=openapi-tool",
+		"/api/tools/sections" + query + "&tool_id=openapi-tool",
+		"/api/tools/versions" + query,
+		"/api/tools/presets",
+		"/api/chat/sessions" + query,
+		"/api/jobs",
+		"/api/jobs/details?job_id=openapi-job",
+		"/api/jobs/logs?job_id=openapi-job",
+		"/api/jobs/labels",
+		"/api/jobs/types",
+		"/api/jobs/dead",
+		"/api/budget",
+		"/api/usage",
+		"/api/storage/usage",
+		"/api/admin/queue",
+		"/api/admin/users",
+		"/api/admin/database",
+		"/api/admin/llm/providers",
+		"/api/settings/keys",
+		"/api/webhooks",
+	} {
+		call("GET", target, nil)
+	}
+}
+
+// mustMarshal encodes a value as JSON, failing the test when it cannot be
+func mustMarshal(t *testing.T, value any) []byte {
+	t.Helper()
+	encoded, err := json.Marshal(value)
+	if err != nil {
+		t.Fatalf("Failed to encode: %v", err)
+	}
+	return encoded
+}
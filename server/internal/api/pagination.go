@@ -0,0 +1,166 @@
+package api
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Bounds of a page of the list endpoints. The default applies once a client pages with a cursor: lists asked for
+// without limit or cursor are returned whole, as they were before they were paginated
+const (
+	defaultPageSize = 100
+	maximumPageSize = 500
+)
+
+// pageCursor is where a page of a list ended: the sort value and ID of its last item. Clients get it encoded,
+// as an opaque string
+type pageCursor struct {
+	SortValue string `json:"s"`
+	ID        string `json:"i"`
+}
+
+// listPage is the page of a list a request asks for. Lists are sorted by a column then by ID, so that items
+// sharing a sort value keep their order, and a page starts right after the item its cursor names
+type listPage struct {
+	sortColumn string
+	idColumn   string
+	descending bool
+	limit      int // 0 returns the whole list
+	after      *pageCursor
+}
+
+// parseListPage reads the "limit" and "cursor" parameters of a request for a list sorted by a column then by ID
+func parseListPage(request *http.Request, sortColumn string, idColumn string, descending bool) (listPage, error) {
+	page := listPage{sortColumn: sortColumn, idColumn: idColumn, descending: descending}
+	query := request.URL.Query()
+	if query.Get("cursor") != "" {
+		page.limit = defaultPageSize
+	}
+	if limitValue := query.Get("limit"); limitValue != "" {
+		limit, err := strconv.Atoi(limitValue)
+		if err != nil || limit < 1 || limit > maximumPageSize {
+			return page, fmt.Errorf("limit must be between 1 and %d", maximumPageSize)
+		}
+		page.limit = limit
+	}
+	if cursorValue := query.Get("cursor"); cursorValue != "" {
+		cursorJSON, err := base64.RawURLEncoding.DecodeString(cursorValue)
+		var cursor pageCursor
+		if err != nil || json.Unmarshal(cursorJSON, &cursor) != nil || cursor.ID == "" {
+			return page, fmt.Errorf("cursor is not one returned by this list")
+		}
+		page.after = &cursor
+	}
+	return page, nil
+}
+
+// parseListSort reads the "sort" parameter of a list: one of the fields of sortColumns, optionally followed by
+// ":asc" or ":desc", ascending being the default. It returns the column of the field and whether it is sorted
+// descending, defaultSort being used when the parameter is missing. Sorted values are compared as text, so the
+// columns hold text or timestamps
+func parseListSort(request *http.Request, sortColumns map[string]string, defaultSort string) (string, bool, error) {
+	sort := request.URL.Query().Get("sort")
+	if sort == "" {
+		sort = defaultSort
+	}
+	field, direction, _ := strings.Cut(sort, ":")
+	column, found := sortColumns[field]
+	if !found || (direction != "" && direction != "asc" && direction != "desc") {
+		fields := make([]string, 0, len(sortColumns))
+		for field := range sortColumns {
+			fields = append(fields, field)
+		}
+		slices.Sort(fields)
+		return "", false, fmt.Errorf("sort must be one of %s, optionally followed by :asc or :desc", strings.Join(fields, ", "))
+	}
+	return column, direction == "desc", nil
+}
+
+// parseTimeParameter reads a query parameter holding a time, in RFC 3339 or as a date, returning the zero time
+// when it is missing. The time is returned in the local zone the database stores its times in, since stored
+// times are compared as text and only compare in order within one zone
+func parseTimeParameter(request *http.Request, name string) (time.Time, error) {
+	value := request.URL.Query().Get(name)
+	if value == "" {
+		return time.Time{}, nil
+	}
+	if parsed, err := time.Parse(time.RFC3339, value); err == nil {
+		return parsed.Local(), nil
+	}
+	if parsed, err := time.Parse(time.DateOnly, value); err == nil {
+		return parsed.Local(), nil
+	}
+	return time.Time{}, fmt.Errorf("%s must be an RFC 3339 time or a YYYY-MM-DD date", name)
+}
+
+// listParameter splits a query parameter listing comma-separated values
+func listParameter(request *http.Request, name string) []string {
+	var values []string
+	for _, value := range strings.Split(request.URL.Query().Get(name), ",") {
+		if value = strings.TrimSpace(value); value != "" {
+			values = append(values, value)
+		}
+	}
+	return values
+}
+
+// anyOf returns the condition, to add to a WHERE clause, matching a column against any of the values
+func anyOf(column string, values []string) (string, []any) {
+	placeholders := make([]string, len(values))
+	arguments := make([]any, len(values))
+	for index, value := range values {
+		placeholders[index] = "?"
+		arguments[index] = value
+	}
+	return fmt.Sprintf(" AND %s IN (%s)", column, strings.Join(placeholders, ", ")), arguments
+}
+
+// sortValue is the expression to select along with each item, read into the cursor of the page it ends. It is
+// the sort column as stored, which is what the cursor is compared to. Conditions and ordering use the column
+// itself, so its indexes apply: stored as text, its values compare with the cursor's as text all the same
+func (page listPage) sortValue() string {
+	return "CAST(" + page.sortColumn + " AS TEXT)"
+}
+
+// condition returns the condition, to add to the WHERE clause, selecting the items after the cursor
+func (page listPage) condition() (string, []any) {
+	if page.after == nil {
+		return "", nil
+	}
+	comparison := ">"
+	if page.descending {
+		comparison = "<"
+	}
+	return fmt.Sprintf(" AND (%[1]s %[3]s ? OR (%[1]s = ? AND %[2]s %[3]s ?))", page.sortColumn, page.idColumn, comparison),
+		[]any{page.after.SortValue, page.after.SortValue, page.after.ID}
+}
+
+// orderBy returns the ORDER BY and LIMIT clauses of the page, which fetch one item past it to tell whether
+// another page follows
+func (page listPage) orderBy() string {
+	direction := "ASC"
+	if page.descending {
+		direction = "DESC"
+	}
+	orderBy := fmt.Sprintf(" ORDER BY %s %s, %s %s", page.sortColumn, direction, page.idColumn, direction)
+	if page.limit == 0 {
+		return orderBy
+	}
+	return orderBy + fmt.Sprintf(" LIMIT %d", page.limit+1)
+}
+
+// paginate drops the item fetched past a page, given the cursors of the items, and returns the cursor of the
+// next page, or an empty string on the last one
+func paginate[Item any](page listPage, items []Item, cursors []pageCursor) ([]Item, string) {
+	if page.limit == 0 || len(items) <= page.limit {
+		return items, ""
+	}
+	cursorJSON, _ := json.Marshal(cursors[page.limit-1])
+	return items[:page.limit], base64.RawURLEncoding.EncodeToString(cursorJSON)
+}
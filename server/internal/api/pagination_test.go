@@ -0,0 +1,181 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"lectures/internal/models"
+)
+
+func TestListPagination(t *testing.T) {
+	server, userID, sessionID, cleanup := setupUniqueExtraTestEnv(t, "pagination")
+	defer cleanup()
+
+	// Exams created in the same second are still ordered, by ID
+	for _, examID := range []string{"page-exam-a", "page-exam-b", "page-exam-c", "page-exam-d", "page-exam-e"} {
+		server.database.Exec("INSERT INTO exams (id, user_id, title, created_at) VALUES (?, ?, ?, '2026-01-01 10:00:00')", examID, userID, examID)
+	}
+	server.database.Exec("INSERT INTO chat_sessions (id, exam_id, title) VALUES ('page-chat', 'page-exam-a', 'Questions')")
+	for index := range 3 {
+		server.database.Exec("INSERT INTO chat_messages (id, session_id, role, content, created_at) VALUES (?, 'page-chat', 'user', 'Question', ?)", fmt.Sprintf("page-message-%d", index), fmt.Sprintf("2026-01-01 10:00:0%d", index))
+	}
+
+	listPage := func(target string) (json.RawMessage, string, int) {
+		req := httptest.NewRequest("GET", target, nil)
+		req.Header.Set("Authorization", "Bearer "+sessionID)
+		rr := httptest.NewRecorder()
+		server.Handler().ServeHTTP(rr, req)
+		var response struct {
+			Data json.RawMessage `json:"data"`
+			Meta models.Meta     `json:"meta"`
+		}
+		json.Unmarshal(rr.Body.Bytes(), &response)
+		return response.Data, response.Meta.NextCursor, rr.Code
+	}
+
+	var listed []string
+	cursor := ""
+	for pages := 0; pages < 5; pages++ {
+		data, nextCursor, code := listPage("/api/exams?limit=2&cursor=" + url.QueryEscape(cursor))
+		if code != http.StatusOK {
+			t.Fatalf("Expected status 200, got %d", code)
+		}
+		var exams []models.Exam
+		json.Unmarshal(data, &exams)
+		if len(exams) > 2 {
+			t.Fatalf("Expected at most 2 exams per page, got %d", len(exams))
+		}
+		for _, exam := range exams {
+			listed = append(listed, exam.ID)
+		}
+		if nextCursor == "" {
+			break
+		}
+		cursor = nextCursor
+	}
+	if strings.Join(listed, ",") != "page-exam-e,page-exam-d,page-exam-c,page-exam-b,page-exam-a" {
+		t.Errorf("Expected every exam once, newest then highest ID first, got %v", listed)
+	}
+
+	// Chat messages are paged oldest first
+	data, nextCursor, _ := listPage("/api/chat/sessions/details?exam_id=page-exam-a&session_id=page-chat&limit=2")
+	var details struct {
+		Messages []models.ChatMessage `json:"messages"`
+	}
+	json.Unmarshal(data, &details)
+	if len(details.Messages) != 2 || details.Messages[0].ID != "page-message-0" || nextCursor == "" {
+		t.Fatalf("Expected the 2 oldest messages and a cursor, got %+v", details.Messages)
+	}
+	data, nextCursor, _ = listPage("/api/chat/sessions/details?exam_id=page-exam-a&session_id=page-chat&limit=2&cursor=" + url.QueryEscape(nextCursor))
+	json.Unmarshal(data, &details)
+	if len(details.Messages) != 1 || details.Messages[0].ID != "page-message-2" || nextCursor != "" {
+		t.Errorf("Expected the last message without a cursor, got %+v (cursor %q)", details.Messages, nextCursor)
+	}
+
+	for _, target := range []string{"/api/exams?limit=0", "/api/exams?limit=100000", "/api/exams?cursor=not-a-cursor"} {
+		if _, _, code := listPage(target); code != http.StatusBadRequest {
+			t.Errorf("Expected status 400 for %s, got %d", target, code)
+		}
+	}
+
+	// Without limit or cursor, lists are returned whole, however long
+	for index := 3; index < 150; index++ {
+		server.database.Exec("INSERT INTO chat_messages (id, session_id, role, content, created_at) VALUES (?, 'page-chat', 'user', 'Question', '2026-01-01 11:00:00')", fmt.Sprintf("page-message-%03d", index))
+	}
+	data, nextCursor, _ = listPage("/api/chat/sessions/details?exam_id=page-exam-a&session_id=page-chat")
+	json.Unmarshal(data, &details)
+	if len(details.Messages) != 150 || nextCursor != "" {
+		t.Errorf("Expected all 150 messages without a cursor, got %d (cursor %q)", len(details.Messages), nextCursor)
+	}
+}
+
+func TestListFiltersAndSorting(t *testing.T) {
+	server, userID, sessionID, cleanup := setupUniqueExtraTestEnv(t, "list_filters")
+	defer cleanup()
+
+	for _, job := range []struct{ id, jobType, status, createdAt string }{
+		{"filter-job-1", models.JobTypeTranscribeMedia, models.JobStatusCompleted, "2026-01-01 10:00:00"},
+		{"filter-job-2", models.JobTypeBuildMaterial, models.JobStatusFailed, "2026-02-01 10:00:00"},
+		{"filter-job-3", models.JobTypeBuildMaterial, models.JobStatusCompleted, "2026-03-01 10:00:00"},
+		{"filter-job-4", models.JobTypeIngestDocuments, models.JobStatusPending, "2026-04-01 10:00:00"},
+	} {
+		if _, err := server.database.Exec("INSERT INTO jobs (id, user_id, type, status, payload, progress_message_text, result, created_at) VALUES (?, ?, ?, ?, '{}', '', '', ?)", job.id, userID, job.jobType, job.status, job.createdAt); err != nil {
+			t.Fatalf("Failed to insert job: %v", err)
+		}
+	}
+	server.database.Exec("INSERT INTO exams (id, user_id, title) VALUES ('filter-exam', ?, 'Exam')", userID)
+	for _, tool := range []struct{ id, toolType, title string }{
+		{"filter-tool-1", "guide", "biology"},
+		{"filter-tool-2", "quiz", "Anatomy"},
+		{"filter-tool-3", "flashcard", "Cells"},
+	} {
+		server.database.Exec("INSERT INTO tools (id, exam_id, type, title, language_code, content) VALUES (?, 'filter-exam', ?, ?, 'en', '{}')", tool.id, tool.toolType, tool.title)
+	}
+
+	listIDs := func(target string) ([]string, int) {
+		req := httptest.NewRequest("GET", target, nil)
+		req.Header.Set("Authorization", "Bearer "+sessionID)
+		rr := httptest.NewRecorder()
+		server.Handler().ServeHTTP(rr, req)
+		var response struct {
+			Data []struct {
+				ID string `json:"id"`
+			} `json:"data"`
+		}
+		json.Unmarshal(rr.Body.Bytes(), &response)
+		var ids []string
+		for _, item := range response.Data {
+			ids = append(ids, item.ID)
+		}
+		return ids, rr.Code
+	}
+
+	for target, expected := range map[string]string{
+		"/api/jobs?status=completed,failed":                                  "filter-job-3,filter-job-2,filter-job-1",
+		"/api/jobs?type=" + models.JobTypeBuildMaterial:                      "filter-job-3,filter-job-2",
+		"/api/jobs?created_after=2026-02-01&created_before=2026-04-01":       "filter-job-3,filter-job-2",
+		"/api/jobs?sort=created_at:asc&status=COMPLETED":                     "filter-job-1,filter-job-3",
+		"/api/tools?exam_id=filter-exam&sort=title":                          "filter-tool-2,filter-tool-1,filter-tool-3",
+		"/api/tools?exam_id=filter-exam&type=quiz,flashcard&sort=title:desc": "filter-tool-3,filter-tool-2",
+	} {
+		ids, code := listIDs(target)
+		if code != http.StatusOK {
+			t.Errorf("Expected status 200 for %s, got %d", target, code)
+			continue
+		}
+		if strings.Join(ids, ",") != expected {
+			t.Errorf("Expected %s for %s, got %v", expected, target, ids)
+		}
+	}
+
+	// Jobs are stored in local time, so the bounds compare with them whatever the zone they were given in
+	localZone := time.Local
+	time.Local = time.FixedZone("UTC+9", 9*60*60)
+	now := time.Now()
+	server.database.Exec("INSERT INTO jobs (id, user_id, type, status, payload, progress_message_text, result, created_at) VALUES ('filter-job-local', ?, ?, ?, '{}', '', '', ?)", userID, models.JobTypeBuildMaterial, models.JobStatusCompleted, now)
+	window := "/api/jobs?created_after=" + url.QueryEscape(now.Add(-time.Hour).UTC().Format(time.RFC3339)) + "&created_before=" + url.QueryEscape(now.Add(time.Hour).UTC().Format(time.RFC3339))
+	if ids, _ := listIDs(window); strings.Join(ids, ",") != "filter-job-local" {
+		t.Errorf("Expected the job stored in local time within a window given in UTC, got %v", ids)
+	}
+	time.Local = localZone
+	server.database.Exec("DELETE FROM jobs WHERE id = 'filter-job-local'")
+
+	// Sorted lists page through the same order
+	ids, _ := listIDs("/api/jobs?sort=type&limit=10")
+	firstPageIDs, _ := listIDs("/api/jobs?sort=type&limit=2")
+	if len(ids) != 4 || len(firstPageIDs) != 2 || ids[0] != firstPageIDs[0] || ids[1] != firstPageIDs[1] {
+		t.Errorf("Expected the first page to start the sorted list, got %v and %v", firstPageIDs, ids)
+	}
+
+	for _, target := range []string{"/api/jobs?sort=payload", "/api/jobs?sort=created_at:sideways", "/api/jobs?status=DONE", "/api/tools?exam_id=filter-exam&created_after=yesterday"} {
+		if _, code := listIDs(target); code != http.StatusBadRequest {
+			t.Errorf("Expected status 400 for %s, got %d", target, code)
+		}
+	}
+}
@@ -0,0 +1,259 @@
+package api
+
+import (
+	"net/http"
+	"slices"
+	"strings"
+
+	"lectures/internal/models"
+
+	"github.com/gorilla/mux"
+)
+
+// rolePermissions lists what each user role may do beyond reading, chatting about and exporting the exams
+// shared with it
+var rolePermissions = map[string][]string{
+	models.UserRoleAdmin:   {models.PermissionCreateExams, models.PermissionRunJobs, models.PermissionManageSettings, models.PermissionManageUsers},
+	models.UserRoleTeacher: {models.PermissionCreateExams, models.PermissionRunJobs},
+	models.UserRoleStudent: {},
+}
+
+// permissionSession marks the routes of routePermissions any signed-in user may call
+const permissionSession = ""
+
+// routePermissions are the permissions routes of the authenticated API need, by method and path template.
+// Routes with permissionSession only need a session; their handlers still check the role of the user on the
+// exam they touch. Every route under /api/admin/ needs PermissionManageUsers, and so does any route missing
+// here, so a new route is closed until it is listed
+var routePermissions = map[string]string{
+	"POST /api/exams":               models.PermissionCreateExams,
+	"POST /api/exams/suggest":       models.PermissionCreateExams,
+	"POST /api/exams/from-syllabus": models.PermissionCreateExams,
+
+	// Uploads, imports and retries queue processing jobs, and generation calls paid models
+	"POST /api/lectures":                    models.PermissionRunJobs,
+	"POST /api/uploads/prepare":             models.PermissionRunJobs,
+	"POST /api/uploads/append":              models.PermissionRunJobs,
+	"POST /api/uploads/stage":               models.PermissionRunJobs,
+	"POST /api/uploads/import":              models.PermissionRunJobs,
+	"POST /api/lectures/documents/from-url": models.PermissionRunJobs,
+	"POST /api/lectures/retry-job":          models.PermissionRunJobs,
+	"PATCH /api/documents":                  models.PermissionRunJobs,
+	"POST /api/transcripts/polish":          models.PermissionRunJobs,
+	"POST /api/transcripts/redactions":      models.PermissionRunJobs,
+	"POST /api/tools":                       models.PermissionRunJobs,
+	"POST /api/tools/sections/regenerate":   models.PermissionRunJobs,
+	"POST /api/exams/duplicates":            models.PermissionRunJobs,
+	"POST /api/jobs/resume":                 models.PermissionRunJobs,
+	"POST /api/jobs/requeue":                models.PermissionRunJobs,
+
+	"PATCH /api/settings": models.PermissionManageSettings,
+
+	// Reading, chatting about, bookmarking, quizzing on and exporting the exams shared with the user, and
+	// managing their own account, keys, presets, jobs and webhooks
+	"POST /api/auth/logout":                   permissionSession,
+	"PATCH /api/auth/password":                permissionSession,
+	"PATCH /api/auth/email":                   permissionSession,
+	"POST /api/auth/tokens":                   permissionSession,
+	"GET /api/auth/tokens":                    permissionSession,
+	"DELETE /api/auth/tokens":                 permissionSession,
+	"GET /api/uploads":                        permissionSession,
+	"GET /api/uploads/details":                permissionSession,
+	"GET /api/exams":                          permissionSession,
+	"GET /api/exams/details":                  permissionSession,
+	"PATCH /api/exams":                        permissionSession,
+	"DELETE /api/exams":                       permissionSession,
+	"GET /api/exams/search":                   permissionSession,
+	"GET /api/exams/concepts":                 permissionSession,
+	"GET /api/exams/duplicates":               permissionSession,
+	"GET /api/exams/members":                  permissionSession,
+	"PUT /api/exams/members":                  permissionSession,
+	"DELETE /api/exams/members":               permissionSession,
+	"GET /api/exams/history":                  permissionSession,
+	"GET /api/lectures":                       permissionSession,
+	"GET /api/lectures/details":               permissionSession,
+	"GET /api/lectures/report":                permissionSession,
+	"GET /api/lectures/recap":                 permissionSession, // Only users who may run jobs queue a missing recap
+	"PATCH /api/lectures":                     permissionSession,
+	"DELETE /api/lectures":                    permissionSession,
+	"GET /api/lectures/bookmarks":             permissionSession,
+	"POST /api/lectures/bookmarks":            permissionSession,
+	"PATCH /api/lectures/bookmarks":           permissionSession,
+	"DELETE /api/lectures/bookmarks":          permissionSession,
+	"GET /api/media":                          permissionSession,
+	"DELETE /api/media":                       permissionSession,
+	"GET /api/transcripts":                    permissionSession,
+	"PATCH /api/transcripts":                  permissionSession,
+	"GET /api/transcripts/html":               permissionSession,
+	"GET /api/transcripts/redactions":         permissionSession,
+	"GET /api/documents":                      permissionSession,
+	"GET /api/documents/details":              permissionSession,
+	"DELETE /api/documents":                   permissionSession,
+	"GET /api/documents/pages":                permissionSession,
+	"GET /api/documents/pages/html":           permissionSession,
+	"GET /api/documents/chunks":               permissionSession,
+	"GET /api/tools":                          permissionSession,
+	"GET /api/tools/details":                  permissionSession,
+	"PATCH /api/tools/details":                permissionSession,
+	"PATCH /api/tools/content":                permissionSession,
+	"GET /api/tools/sections":                 permissionSession,
+	"GET /api/tools/versions":                 permissionSession,
+	"POST /api/tools/versions/restore":        permissionSession,
+	"POST /api/tools/rollback":                permissionSession,
+	"GET /api/tools/versions/diff":            permissionSession,
+	"GET /api/tools/html":                     permissionSession,
+	"DELETE /api/tools":                       permissionSession,
+	"POST /api/tools/export":                  permissionSession,
+	"PUT /api/tools/feedback":                 permissionSession,
+	"POST /api/tools/quiz/attempts":           permissionSession, // Free responses are graded by a model, as chat answers are written
+	"GET /api/tools/quiz/attempts":            permissionSession,
+	"GET /api/tools/presets":                  permissionSession,
+	"POST /api/tools/presets":                 permissionSession,
+	"PATCH /api/tools/presets":                permissionSession,
+	"DELETE /api/tools/presets":               permissionSession,
+	"POST /api/transcripts/export":            permissionSession,
+	"POST /api/documents/export":              permissionSession,
+	"POST /api/exports/presets":               permissionSession,
+	"GET /api/exports/presets":                permissionSession,
+	"DELETE /api/exports/presets":             permissionSession,
+	"POST /api/exports/publish":               permissionSession,
+	"POST /api/offline/bundle":                permissionSession,
+	"POST /api/chat/sessions":                 permissionSession,
+	"GET /api/chat/sessions":                  permissionSession,
+	"GET /api/chat/sessions/details":          permissionSession,
+	"PATCH /api/chat/sessions/context":        permissionSession,
+	"DELETE /api/chat/sessions":               permissionSession,
+	"POST /api/chat/messages":                 permissionSession,
+	"GET /api/jobs":                           permissionSession,
+	"GET /api/jobs/details":                   permissionSession,
+	"GET /api/jobs/logs":                      permissionSession,
+	"DELETE /api/jobs":                        permissionSession,
+	"PATCH /api/jobs":                         permissionSession,
+	"GET /api/jobs/labels":                    permissionSession,
+	"GET /api/jobs/types":                     permissionSession,
+	"POST /api/jobs/pause":                    permissionSession,
+	"GET /api/jobs/dead":                      permissionSession,
+	"GET /api/budget":                         permissionSession,
+	"GET /api/usage":                          permissionSession,
+	"GET /api/storage/usage":                  permissionSession,
+	"GET /api/system/status":                  permissionSession,
+	"GET /api/settings":                       permissionSession,
+	"GET /api/settings/keys":                  permissionSession,
+	"PUT /api/settings/keys":                  permissionSession,
+	"DELETE /api/settings/keys":               permissionSession,
+	"GET /api/webhooks":                       permissionSession,
+	"POST /api/webhooks":                      permissionSession,
+	"PATCH /api/webhooks":                     permissionSession,
+	"DELETE /api/webhooks":                    permissionSession,
+	"POST /api/webhooks/test":                 permissionSession,
+	"GET /api/webhooks/deliveries":            permissionSession,
+	"POST /api/webhooks/deliveries/redeliver": permissionSession,
+}
+
+// roleHasPermission reports whether a user role grants a permission
+func roleHasPermission(role string, permission string) bool {
+	return slices.Contains(rolePermissions[role], permission)
+}
+
+// requiredPermission returns the permission the route of a request needs, or an empty string when a session
+// is enough
+func requiredPermission(request *http.Request) string {
+	route := mux.CurrentRoute(request)
+	if route == nil {
+		return ""
+	}
+	pathTemplate, err := route.GetPathTemplate()
+	if err != nil {
+		return ""
+	}
+	return routePermission(request.Method, pathTemplate)
+}
+
+// routePermission returns the permission a route needs, by method and path template
+func routePermission(method string, pathTemplate string) string {
+	if strings.HasPrefix(pathTemplate, "/api/admin/") {
+		return models.PermissionManageUsers
+	}
+	permission, listed := routePermissions[method+" "+pathTemplate]
+	if !listed {
+		return models.PermissionManageUsers
+	}
+	return permission
+}
+
+// getUserRole returns the role of the authenticated user of a request
+func (server *Server) getUserRole(request *http.Request) string {
+	if role, ok := request.Context().Value(userRoleKey).(string); ok {
+		return role
+	}
+	return ""
+}
+
+// tokenScopeResources groups the first segment of API paths into the resources API token scopes name. Routes
+// outside them, such as the administration, settings and token routes, are not available to API tokens
+var tokenScopeResources = map[string]string{
+	"exams":       "exams",
+	"lectures":    "lectures",
+	"uploads":     "lectures",
+	"documents":   "lectures",
+	"transcripts": "lectures",
+	"media":       "lectures",
+	"tools":       "tools",
+	"exports":     "tools",
+	"offline":     "tools",
+	"chat":        "chat",
+	"jobs":        "jobs",
+	"usage":       "usage",
+	"budget":      "usage",
+	"storage":     "usage",
+	"webhooks":    "webhooks",
+}
+
+// isTokenScope reports whether scope is "<resource>:read" or "<resource>:write" for a resource of
+// tokenScopeResources. Usage is only read
+func isTokenScope(scope string) bool {
+	resource, access, found := strings.Cut(scope, ":")
+	if !found || (access != "read" && access != "write") || (resource == "usage" && access == "write") {
+		return false
+	}
+	for _, knownResource := range tokenScopeResources {
+		if knownResource == resource {
+			return true
+		}
+	}
+	return false
+}
+
+// requiredTokenScope returns the scope an API token needs for the route of a request: reading for GET
+// requests and writing otherwise. It is empty for the routes API tokens cannot use
+func requiredTokenScope(request *http.Request) string {
+	route := mux.CurrentRoute(request)
+	if route == nil {
+		return ""
+	}
+	pathTemplate, err := route.GetPathTemplate()
+	if err != nil {
+		return ""
+	}
+	return routeTokenScope(request.Method, pathTemplate)
+}
+
+// routeTokenScope returns the scope an API token needs for a route, by method and path template
+func routeTokenScope(method string, pathTemplate string) string {
+	segment, _, _ := strings.Cut(strings.TrimPrefix(pathTemplate, "/api/"), "/")
+	resource, found := tokenScopeResources[segment]
+	if !found {
+		return ""
+	}
+	if method == http.MethodGet || method == http.MethodHead {
+		return resource + ":read"
+	}
+	return resource + ":write"
+}
+
+// tokenHasScope reports whether the scopes of an API token grant a scope, writing a resource also granting
+// reading it
+func tokenHasScope(scopes []string, scope string) bool {
+	resource, _, _ := strings.Cut(scope, ":")
+	return slices.Contains(scopes, scope) || slices.Contains(scopes, resource+":write")
+}
@@ -0,0 +1,49 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"lectures/internal/models"
+
+	"github.com/gorilla/mux"
+)
+
+func TestRoutePermissions(t *testing.T) {
+	server, userID, sessionID, cleanup := setupUniqueExtraTestEnv(t, "route_permissions")
+	defer cleanup()
+
+	// Every route of the authenticated API is listed, so none is left open by omission
+	server.router.Walk(func(route *mux.Route, router *mux.Router, ancestors []*mux.Route) error {
+		pathTemplate, templateError := route.GetPathTemplate()
+		methods, methodsError := route.GetMethods()
+		if len(ancestors) == 0 || templateError != nil || methodsError != nil || strings.HasPrefix(pathTemplate, "/api/admin/") {
+			return nil
+		}
+		for _, method := range methods {
+			if _, listed := routePermissions[method+" "+pathTemplate]; !listed {
+				t.Errorf("Expected %s %s listed in routePermissions", method, pathTemplate)
+			}
+		}
+		return nil
+	})
+	if permission := routePermission("POST", "/api/unlisted"); permission != models.PermissionManageUsers {
+		t.Errorf("Expected an unlisted route kept to administrators, got %q", permission)
+	}
+
+	server.database.Exec("UPDATE users SET role = 'student' WHERE id = ?", userID)
+	server.database.Exec("INSERT INTO exams (id, user_id, title) VALUES ('permission-exam', ?, 'Optics')", userID)
+	server.database.Exec("INSERT INTO lectures (id, exam_id, title, status) VALUES ('permission-lecture', 'permission-exam', 'Lenses', 'ready')")
+	req := httptest.NewRequest("GET", "/api/lectures/recap?lecture_id=permission-lecture&exam_id=permission-exam", nil)
+	req.Header.Set("Authorization", "Bearer "+sessionID)
+	req.Header.Set("X-Requested-With", "XMLHttpRequest")
+	rr := httptest.NewRecorder()
+	server.Handler().ServeHTTP(rr, req)
+	var jobCount int
+	server.database.QueryRow("SELECT COUNT(*) FROM jobs WHERE type = ?", models.JobTypeGenerateRecap).Scan(&jobCount)
+	if rr.Code != http.StatusOK || !strings.Contains(rr.Body.String(), `"unavailable"`) || jobCount != 0 {
+		t.Errorf("Expected a student to read a missing recap without queueing it, got %d with %d jobs: %s", rr.Code, jobCount, rr.Body.String())
+	}
+}
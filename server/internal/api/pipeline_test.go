@@ -150,6 +150,10 @@ func (documentConverter *MockDocumentConverter) ExtractPagesAsImages(pdfPath, ou
 	return []string{imagePath}, nil
 }
 
+func (documentConverter *MockDocumentConverter) ConvertImageToPNG(inputPath, outputPath string) error {
+	return os.WriteFile(outputPath, []byte("fake image"), 0644)
+}
+
 func TestIntegration_EndToEndPipeline(tester *testing.T) {
 	temporaryDirectory, err := os.MkdirTemp("", "lectures-test-*")
 	if err != nil {
@@ -427,7 +431,7 @@ func TestUpload_StagedProtocol(tester *testing.T) {
 		},
 	}
 
-	_, _ = initializedDatabase.Exec("INSERT INTO users (id, username, password_hash, role) VALUES (?, ?, ?, ?)", "user-1", "testuser", "dummy_hash", "user")
+	_, _ = initializedDatabase.Exec("INSERT INTO users (id, username, password_hash, role) VALUES (?, ?, ?, ?)", "user-1", "testuser", "dummy_hash", "teacher")
 	sessionID := "staged-session"
 	_, _ = initializedDatabase.Exec("INSERT INTO auth_sessions (id, user_id, created_at, last_activity, expires_at) VALUES (?, ?, ?, ?, ?)", sessionID, "user-1", time.Now(), time.Now(), time.Now().Add(1*time.Hour))
 
@@ -561,7 +565,7 @@ func TestWebSocket_ProgressUpdates(tester *testing.T) {
 		},
 	}
 
-	_, _ = initializedDatabase.Exec("INSERT INTO users (id, username, password_hash, role) VALUES (?, ?, ?, ?)", "user-1", "testuser", "dummy_hash", "user")
+	_, _ = initializedDatabase.Exec("INSERT INTO users (id, username, password_hash, role) VALUES (?, ?, ?, ?)", "user-1", "testuser", "dummy_hash", "teacher")
 
 	sessionID := "test-session-id"
 	_, _ = initializedDatabase.Exec("INSERT INTO auth_sessions (id, user_id, created_at, last_activity, expires_at) VALUES (?, ?, ?, ?, ?)", sessionID, "user-1", time.Now(), time.Now(), time.Now().Add(1*time.Hour))
@@ -674,7 +678,7 @@ func TestAI_FailureScenarios(tester *testing.T) {
 	defer jobQueue.Stop()
 
 	lectureID, examID := "l1", "e1"
-	_, _ = initializedDatabase.Exec("INSERT INTO users (id, username, password_hash, role) VALUES (?, ?, ?, ?)", "test-user", "testuser", "dummy", "user")
+	_, _ = initializedDatabase.Exec("INSERT INTO users (id, username, password_hash, role) VALUES (?, ?, ?, ?)", "test-user", "testuser", "dummy", "teacher")
 	_, _ = initializedDatabase.Exec("INSERT INTO exams (id, user_id, title, description) VALUES (?, ?, ?, ?)", examID, "test-user", "Exam", "Desc")
 	_, _ = initializedDatabase.Exec("INSERT INTO lectures (id, exam_id, title, description, status) VALUES (?, ?, ?, ?, ?)", lectureID, examID, "Lecture", "Desc", "ready")
 	_, _ = initializedDatabase.Exec("INSERT INTO transcripts (id, lecture_id, status) VALUES (?, ?, ?)", "t1", lectureID, "completed")
@@ -801,7 +805,7 @@ func TestTools_GenerationLogic(tester *testing.T) {
 	defer jobQueue.Stop()
 
 	examID, lectureID := "exam-1", "lecture-1"
-	_, _ = initializedDatabase.Exec("INSERT INTO users (id, username, password_hash, role) VALUES (?, ?, ?, ?)", "test-user", "testuser", "dummy", "user")
+	_, _ = initializedDatabase.Exec("INSERT INTO users (id, username, password_hash, role) VALUES (?, ?, ?, ?)", "test-user", "testuser", "dummy", "teacher")
 	_, _ = initializedDatabase.Exec("INSERT INTO exams (id, user_id, title, description) VALUES (?, ?, ?, ?)", examID, "test-user", "Exam", "Desc")
 	_, _ = initializedDatabase.Exec("INSERT INTO lectures (id, exam_id, title, description, status) VALUES (?, ?, ?, ?, ?)", lectureID, examID, "Lecture", "Desc", "ready")
 	_, _ = initializedDatabase.Exec("INSERT INTO transcripts (id, lecture_id, status) VALUES (?, ?, ?)", "t1", lectureID, "completed")
@@ -876,6 +880,14 @@ func (markdownConverter *MockMarkdownConverter) HTMLToDocx(htmlContent, outputPa
 	return os.WriteFile(outputPath, []byte("fake docx"), 0644)
 }
 
+func (markdownConverter *MockMarkdownConverter) HTMLToEPUB(htmlContent, outputPath string, options markdown.ConversionOptions) error {
+	return os.WriteFile(outputPath, []byte("fake epub"), 0644)
+}
+
+func (markdownConverter *MockMarkdownConverter) SaveHTML(htmlContent, outputPath string, options markdown.ConversionOptions) error {
+	return os.WriteFile(outputPath, []byte(htmlContent), 0644)
+}
+
 func (markdownConverter *MockMarkdownConverter) HTMLToAnki(toolType string, toolContent string, outputPath string) error {
 	return os.WriteFile(outputPath, []byte("fake anki"), 0644)
 }
@@ -932,7 +944,7 @@ func TestExport_ToolGeneration(tester *testing.T) {
 	toolID := "tool-1"
 	userID := "test-user"
 	examID := "e1"
-	_, _ = initializedDatabase.Exec("INSERT INTO users (id, username, password_hash, role) VALUES (?, ?, ?, ?)", userID, "testuser", "dummy", "user")
+	_, _ = initializedDatabase.Exec("INSERT INTO users (id, username, password_hash, role) VALUES (?, ?, ?, ?)", userID, "testuser", "dummy", "teacher")
 	_, _ = initializedDatabase.Exec("INSERT INTO exams (id, user_id, title) VALUES (?, ?, ?)", examID, userID, "E")
 	_, _ = initializedDatabase.Exec("INSERT INTO tools (id, exam_id, type, title, language_code, content) VALUES (?, ?, 'guide', 'Title', 'en-US', 'Content')", toolID, examID)
 
@@ -1552,7 +1564,7 @@ func TestUpload_ProgressTracking(tester *testing.T) {
 		},
 	}
 
-	_, _ = initializedDatabase.Exec("INSERT INTO users (id, username, password_hash, role) VALUES (?, ?, ?, ?)", "user-1", "testuser", "dummy_hash", "user")
+	_, _ = initializedDatabase.Exec("INSERT INTO users (id, username, password_hash, role) VALUES (?, ?, ?, ?)", "user-1", "testuser", "dummy_hash", "teacher")
 	sessionID := "test-session-id"
 	_, _ = initializedDatabase.Exec("INSERT INTO auth_sessions (id, user_id, created_at, last_activity, expires_at) VALUES (?, ?, ?, ?, ?)", sessionID, "user-1", time.Now(), time.Now(), time.Now().Add(1*time.Hour))
 
@@ -0,0 +1,146 @@
+package api
+
+import (
+	"log/slog"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"slices"
+	"strings"
+)
+
+// basePath returns the configured path the server is reached under, without a trailing slash, or an empty
+// string when it is served from the root
+func (server *Server) basePath() string {
+	basePath := strings.Trim(strings.TrimSpace(server.configuration.Server.BasePath), "/")
+	if basePath == "" {
+		return ""
+	}
+	return "/" + basePath
+}
+
+// isTrustedProxy reports whether an address is one of the configured reverse proxies
+func (server *Server) isTrustedProxy(address netip.Addr) bool {
+	for _, trustedProxy := range server.configuration.Server.TrustedProxies {
+		trustedProxy = strings.TrimSpace(trustedProxy)
+		if prefix, err := netip.ParsePrefix(trustedProxy); err == nil {
+			if prefix.Contains(address) {
+				return true
+			}
+			continue
+		}
+		if trustedAddress, err := netip.ParseAddr(trustedProxy); err == nil {
+			if trustedAddress.Unmap() == address {
+				return true
+			}
+			continue
+		}
+		slog.Warn("Ignoring invalid trusted proxy", "proxy", trustedProxy)
+	}
+	return false
+}
+
+// isFromTrustedProxy reports whether a request was received from one of the configured reverse proxies
+func (server *Server) isFromTrustedProxy(request *http.Request) bool {
+	peer, err := netip.ParseAddr(clientAddress(request))
+	return err == nil && server.isTrustedProxy(peer.Unmap())
+}
+
+// forwardedClient returns the address of the client of a request relayed by trusted proxies: the last address
+// of X-Forwarded-For that is not one of them, proxies appending the address they received each request from. It
+// returns an empty string when the header names none
+func (server *Server) forwardedClient(request *http.Request) string {
+	var forwarded []string
+	for _, header := range request.Header.Values("X-Forwarded-For") {
+		forwarded = append(forwarded, strings.Split(header, ",")...)
+	}
+	client := ""
+	for _, hop := range slices.Backward(forwarded) {
+		address, err := netip.ParseAddr(strings.TrimSpace(hop))
+		if err != nil {
+			break
+		}
+		client = address.Unmap().String()
+		if !server.isTrustedProxy(address.Unmap()) {
+			break
+		}
+	}
+	return client
+}
+
+// proxyHandler adapts requests relayed by a reverse proxy before routing them: the address of the client and
+// the host are taken from the forwarding headers of trusted proxies, and the base path is stripped. Requests
+// outside the base path are routed as they are, so proxies may strip it themselves and probes may reach
+// /healthz directly
+func (server *Server) proxyHandler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(responseWriter http.ResponseWriter, request *http.Request) {
+		if server.isFromTrustedProxy(request) {
+			relayedRequest := *request
+			if client := server.forwardedClient(request); client != "" {
+				relayedRequest.RemoteAddr = net.JoinHostPort(client, "0")
+			}
+			if forwardedHost := strings.TrimSpace(strings.Split(request.Header.Get("X-Forwarded-Host"), ",")[0]); forwardedHost != "" {
+				relayedRequest.Host = forwardedHost
+			}
+			request = &relayedRequest
+		}
+
+		if basePath := server.basePath(); basePath != "" {
+			if path, found := strings.CutPrefix(request.URL.Path, basePath); found && (path == "" || strings.HasPrefix(path, "/")) {
+				strippedRequest := *request
+				strippedURL := *request.URL
+				strippedURL.Path = path
+				if path == "" {
+					strippedURL.Path = "/"
+				}
+				strippedURL.RawPath = ""
+				strippedRequest.URL = &strippedURL
+				request = &strippedRequest
+			}
+		}
+
+		next.ServeHTTP(responseWriter, request)
+	})
+}
+
+// isAllowedOrigin reports whether a browser may call the API from an origin. Without configured origins any
+// may; the server itself always may
+func (server *Server) isAllowedOrigin(request *http.Request, origin string) bool {
+	allowedOrigins := server.configuration.Server.AllowedOrigins
+	if len(allowedOrigins) == 0 || isSameOrigin(request, origin) {
+		return true
+	}
+	for _, allowedOrigin := range allowedOrigins {
+		if allowedOrigin == "*" || strings.EqualFold(strings.TrimRight(allowedOrigin, "/"), origin) {
+			return true
+		}
+	}
+	return false
+}
+
+// isSameOrigin reports whether an origin is the host a request was sent to
+func isSameOrigin(request *http.Request, origin string) bool {
+	originURL, err := url.Parse(origin)
+	return err == nil && originURL.Host != "" && strings.EqualFold(originURL.Host, request.Host)
+}
+
+// checkWebSocketOrigin accepts WebSockets from the allowed origins. Without configured origins only localhost
+// and the server itself are, as browsers send cookies along with cross-site WebSockets
+func (server *Server) checkWebSocketOrigin(request *http.Request) bool {
+	origin := request.Header.Get("Origin")
+	slog.Debug("WebSocket origin check", "origin", origin)
+	if origin == "" || isSameOrigin(request, origin) {
+		return true
+	}
+	if len(server.configuration.Server.AllowedOrigins) > 0 {
+		if server.isAllowedOrigin(request, origin) {
+			return true
+		}
+	} else if strings.Contains(origin, "localhost") || strings.Contains(origin, "127.0.0.1") {
+		// Allow localhost on any port
+		return true
+	}
+	slog.Warn("WebSocket origin rejected", "origin", origin)
+	return false
+}
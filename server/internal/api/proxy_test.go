@@ -0,0 +1,88 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestReverseProxyDeployment(t *testing.T) {
+	server, userID, sessionID, cleanup := setupUniqueExtraTestEnv(t, "reverse_proxy")
+	defer cleanup()
+	server.database.Exec("UPDATE users SET role = 'admin' WHERE id = ?", userID)
+	server.configuration.Server.BasePath = "/lectures/"
+	server.configuration.Server.AllowedOrigins = []string{"https://app.example.org"}
+	server.configuration.Server.TrustedProxies = []string{"10.0.0.0/8", "192.0.2.1"}
+
+	sendRequest := func(remoteAddress string, target string, headers map[string]string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", target, nil)
+		req.RemoteAddr = remoteAddress
+		req.Header.Set("Authorization", "Bearer "+sessionID)
+		for name, value := range headers {
+			req.Header.Set(name, value)
+		}
+		rr := httptest.NewRecorder()
+		server.Handler().ServeHTTP(rr, req)
+		return rr
+	}
+
+	// The base path is stripped, and paths outside it are still routed
+	if rr := sendRequest("192.0.2.50:1000", "/lectures/api/exams", nil); rr.Code != http.StatusOK {
+		t.Errorf("Expected status 200 under the base path, got %d: %s", rr.Code, rr.Body.String())
+	}
+	if rr := sendRequest("192.0.2.50:1000", "/healthz", nil); rr.Code != http.StatusOK {
+		t.Errorf("Expected status 200 for probes outside the base path, got %d: %s", rr.Code, rr.Body.String())
+	}
+	if rr := sendRequest("192.0.2.50:1000", "/lecturesx/api/exams", nil); rr.Code == http.StatusOK {
+		t.Errorf("Expected a path merely starting like the base path not to be stripped")
+	}
+
+	// Only configured origins, and the server itself, get CORS headers
+	if rr := sendRequest("192.0.2.50:1000", "/lectures/api/exams", map[string]string{"Origin": "https://app.example.org"}); rr.Header().Get("Access-Control-Allow-Origin") != "https://app.example.org" {
+		t.Errorf("Expected CORS headers for an allowed origin, got %v", rr.Header())
+	}
+	if rr := sendRequest("192.0.2.50:1000", "/lectures/api/exams", map[string]string{"Origin": "https://evil.example.com"}); rr.Header().Get("Access-Control-Allow-Origin") != "" {
+		t.Errorf("Expected no CORS headers for another origin, got %v", rr.Header())
+	}
+	if rr := sendRequest("10.1.2.3:1000", "/lectures/api/exams", map[string]string{"Origin": "https://lectures.example.org", "X-Forwarded-Host": "lectures.example.org"}); rr.Header().Get("Access-Control-Allow-Origin") == "" {
+		t.Errorf("Expected CORS headers for the forwarded host, got %v", rr.Header())
+	}
+
+	// Configured origins pass the CSRF check of state-changing requests, which still refuses other origins
+	createExam := func(origin string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("POST", "/lectures/api/exams", strings.NewReader(`{"title": "Optics"}`))
+		req.RemoteAddr = "192.0.2.50:1000"
+		req.Header.Set("Authorization", "Bearer "+sessionID)
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("X-Requested-With", "XMLHttpRequest")
+		req.Header.Set("Origin", origin)
+		rr := httptest.NewRecorder()
+		server.Handler().ServeHTTP(rr, req)
+		return rr
+	}
+	if rr := createExam("https://app.example.org"); rr.Code != http.StatusCreated {
+		t.Errorf("Expected a configured origin to create an exam, got %d: %s", rr.Code, rr.Body.String())
+	}
+	if rr := createExam("https://evil.example.com"); rr.Code != http.StatusForbidden {
+		t.Errorf("Expected another origin to be refused, got %d: %s", rr.Code, rr.Body.String())
+	}
+
+	// Forwarded addresses are believed from trusted proxies only, skipping the proxies they went through
+	sendRequest("10.1.2.3:1000", "/lectures/api/admin/users", map[string]string{"X-Forwarded-For": "198.51.100.7, 203.0.113.9, 10.4.4.4"})
+	sendRequest("192.0.2.99:1000", "/lectures/api/admin/users", map[string]string{"X-Forwarded-For": "198.51.100.8"})
+	server.database.Exec("UPDATE users SET role = 'teacher' WHERE id = ?", userID)
+	sendRequest("10.1.2.3:1000", "/lectures/api/admin/users", map[string]string{"X-Forwarded-For": "198.51.100.7, 203.0.113.9, 10.4.4.4"})
+	sendRequest("192.0.2.99:1000", "/lectures/api/admin/users", map[string]string{"X-Forwarded-For": "198.51.100.8"})
+	var addresses []string
+	rows, _ := server.database.Query("SELECT ip_address FROM audit_events WHERE action = 'access_denied' ORDER BY id")
+	for rows.Next() {
+		var address string
+		rows.Scan(&address)
+		addresses = append(addresses, address)
+	}
+	rows.Close()
+	if strings.Join(addresses, ",") != "203.0.113.9,192.0.2.99" {
+		t.Errorf("Expected the forwarded address from trusted proxies and the peer otherwise, got %v", addresses)
+	}
+}
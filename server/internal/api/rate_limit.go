@@ -0,0 +1,177 @@
+package api
+
+import (
+	"maps"
+	"math"
+	"net/http"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+
+	"lectures/internal/configuration"
+
+	"github.com/gorilla/mux"
+)
+
+// Classes of requests sharing a rate limit
+const (
+	rateLimitClassAuth   = "auth"
+	rateLimitClassRead   = "read"
+	rateLimitClassWrite  = "write"
+	rateLimitClassUpload = "upload"
+)
+
+// maximumRateLimitBuckets is how many clients are tracked at most. Past it, the buckets that refilled are
+// forgotten, and else the ones closest to refilling
+const maximumRateLimitBuckets = 10000
+
+// publicRateLimitClasses are the public routes limited per address, by method and path template. Health
+// probes are not limited
+var publicRateLimitClasses = map[string]string{
+	"POST /api/auth/setup":                  rateLimitClassAuth,
+	"POST /api/auth/register":               rateLimitClassAuth,
+	"POST /api/auth/login":                  rateLimitClassAuth,
+	"GET /api/auth/oidc/login":              rateLimitClassAuth,
+	"GET /api/auth/oidc/callback":           rateLimitClassAuth,
+	"POST /api/auth/password-reset/request": rateLimitClassAuth,
+	"POST /api/auth/password-reset/confirm": rateLimitClassAuth,
+	"GET /api/auth/status":                  rateLimitClassRead,
+	"POST /api/system/restore":              rateLimitClassWrite,
+}
+
+// tokenBucket holds the requests a client may still make at once
+type tokenBucket struct {
+	tokens    float64
+	updatedAt time.Time
+	fullAt    time.Time // When it is refilled, after which it can be forgotten
+}
+
+// rateLimiter keeps a token bucket per client and class of requests. The zero value is ready to use
+type rateLimiter struct {
+	mutex   sync.Mutex
+	buckets map[string]*tokenBucket
+}
+
+// allow takes a token from the bucket of a key, which refills at the rate of a rule up to its burst. When none is
+// left, it returns how long until one is
+func (limiter *rateLimiter) allow(key string, rule configuration.RateLimitRule, now time.Time) (bool, time.Duration) {
+	ratePerSecond := rule.RequestsPerMinute / 60
+	burst := float64(rule.Burst)
+	if burst < 1 {
+		burst = math.Max(1, rule.RequestsPerMinute)
+	}
+
+	limiter.mutex.Lock()
+	defer limiter.mutex.Unlock()
+	if limiter.buckets == nil {
+		limiter.buckets = make(map[string]*tokenBucket)
+	}
+	bucket, found := limiter.buckets[key]
+	if !found {
+		if len(limiter.buckets) >= maximumRateLimitBuckets {
+			limiter.forgetRefilled(now)
+		}
+		bucket = &tokenBucket{tokens: burst, updatedAt: now}
+		limiter.buckets[key] = bucket
+	}
+
+	bucket.tokens = math.Min(burst, bucket.tokens+now.Sub(bucket.updatedAt).Seconds()*ratePerSecond)
+	bucket.updatedAt = now
+	allowed := bucket.tokens >= 1
+	if allowed {
+		bucket.tokens--
+	}
+	bucket.fullAt = now.Add(time.Duration((burst - bucket.tokens) / ratePerSecond * float64(time.Second)))
+	if allowed {
+		return true, 0
+	}
+	return false, time.Duration((1 - bucket.tokens) / ratePerSecond * float64(time.Second))
+}
+
+// forgetRefilled drops the buckets that refilled, which are the same as new ones. When none has, as under a
+// flood of distinct clients, the tenth of the buckets closest to refilling is dropped, so the map stays bounded
+func (limiter *rateLimiter) forgetRefilled(now time.Time) {
+	for key, bucket := range limiter.buckets {
+		if !bucket.fullAt.After(now) {
+			delete(limiter.buckets, key)
+		}
+	}
+	if len(limiter.buckets) < maximumRateLimitBuckets {
+		return
+	}
+	keys := slices.Collect(maps.Keys(limiter.buckets))
+	slices.SortFunc(keys, func(first string, second string) int {
+		return limiter.buckets[first].fullAt.Compare(limiter.buckets[second].fullAt)
+	})
+	for _, key := range keys[:len(keys)-maximumRateLimitBuckets*9/10] {
+		delete(limiter.buckets, key)
+	}
+}
+
+// rateLimitRule returns the configured limit of a class of requests
+func (server *Server) rateLimitRule(class string) configuration.RateLimitRule {
+	rateLimits := server.configuration.Security.RateLimits
+	switch class {
+	case rateLimitClassAuth:
+		return rateLimits.Auth
+	case rateLimitClassRead:
+		return rateLimits.Read
+	case rateLimitClassUpload:
+		return rateLimits.Upload
+	default:
+		return rateLimits.Write
+	}
+}
+
+// allowRequest takes a request of a class from the bucket of a client. It writes the error and returns false
+// when the client must wait, telling it how long
+func (server *Server) allowRequest(responseWriter http.ResponseWriter, class string, client string) bool {
+	rule := server.rateLimitRule(class)
+	if rule.RequestsPerMinute <= 0 {
+		return true
+	}
+	allowed, wait := server.rateLimiter.allow(class+":"+client, rule, time.Now())
+	if allowed {
+		return true
+	}
+	retryAfterSeconds := int(math.Ceil(wait.Seconds()))
+	responseWriter.Header().Set("Retry-After", strconv.Itoa(retryAfterSeconds))
+	server.writeError(responseWriter, http.StatusTooManyRequests, "RATE_LIMIT", "Too many requests. Please try again later.", map[string]any{
+		"class":               class,
+		"retry_after_seconds": retryAfterSeconds,
+	})
+	return false
+}
+
+// addressRateLimitMiddleware limits the requests to the public routes of publicRateLimitClasses per address
+func (server *Server) addressRateLimitMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(responseWriter http.ResponseWriter, request *http.Request) {
+		if route := mux.CurrentRoute(request); route != nil {
+			pathTemplate, _ := route.GetPathTemplate()
+			if class, found := publicRateLimitClasses[request.Method+" "+pathTemplate]; found {
+				if !server.allowRequest(responseWriter, class, "address:"+clientAddress(request)) {
+					return
+				}
+			}
+		}
+		next.ServeHTTP(responseWriter, request)
+	})
+}
+
+// userRateLimitMiddleware limits the requests to the authenticated API per user, reads apart from writes and
+// upload chunks. It runs after authMiddleware, which refuses the requests of unknown clients first
+func (server *Server) userRateLimitMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(responseWriter http.ResponseWriter, request *http.Request) {
+		class := rateLimitClassWrite
+		if request.Method == http.MethodGet || request.Method == http.MethodHead {
+			class = rateLimitClassRead
+		} else if request.URL.Path == "/api/uploads/append" {
+			class = rateLimitClassUpload
+		}
+		if !server.allowRequest(responseWriter, class, "user:"+server.getUserID(request)) {
+			return
+		}
+		next.ServeHTTP(responseWriter, request)
+	})
+}
@@ -0,0 +1,97 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"lectures/internal/configuration"
+)
+
+func TestRateLimits(t *testing.T) {
+	server, _, sessionID, cleanup := setupUniqueExtraTestEnv(t, "rate_limits")
+	defer cleanup()
+	server.configuration.Security.RateLimits = configuration.RateLimitConfiguration{
+		Auth: configuration.RateLimitRule{RequestsPerMinute: 1, Burst: 2},
+		Read: configuration.RateLimitRule{RequestsPerMinute: 1, Burst: 2},
+	}
+
+	sendRequest := func(remoteAddress string, token string, method string, target string, body map[string]any) *httptest.ResponseRecorder {
+		encodedBody, _ := json.Marshal(body)
+		req := httptest.NewRequest(method, target, bytes.NewBuffer(encodedBody))
+		req.RemoteAddr = remoteAddress
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		req.Header.Set("X-Requested-With", "XMLHttpRequest")
+		rr := httptest.NewRecorder()
+		server.Handler().ServeHTTP(rr, req)
+		return rr
+	}
+
+	// Sign-in routes are limited per address, whatever the outcome
+	for attempt := range 2 {
+		if rr := sendRequest("198.51.100.20:1000", "", "POST", "/api/auth/login", map[string]any{"username": "nobody", "password": "wrong"}); rr.Code == http.StatusTooManyRequests {
+			t.Fatalf("Expected login attempt %d within the burst, got %d: %s", attempt, rr.Code, rr.Body.String())
+		}
+	}
+	rr := sendRequest("198.51.100.20:1001", "", "POST", "/api/auth/login", map[string]any{"username": "nobody", "password": "wrong"})
+	if rr.Code != http.StatusTooManyRequests || !strings.Contains(rr.Body.String(), "RATE_LIMIT") || rr.Header().Get("Retry-After") != "60" {
+		t.Fatalf("Expected status 429 with Retry-After 60 past the burst, got %d (Retry-After %q): %s", rr.Code, rr.Header().Get("Retry-After"), rr.Body.String())
+	}
+	if rr := sendRequest("198.51.100.21:1000", "", "POST", "/api/auth/login", map[string]any{"username": "nobody", "password": "wrong"}); rr.Code == http.StatusTooManyRequests {
+		t.Errorf("Expected another address not to be limited, got %d", rr.Code)
+	}
+	for range 3 {
+		if rr := sendRequest("198.51.100.20:1002", "", "GET", "/healthz", nil); rr.Code == http.StatusTooManyRequests {
+			t.Fatalf("Expected health probes not to be limited, got %d", rr.Code)
+		}
+	}
+
+	// The authenticated API is limited per user, reads apart from writes, which have no limit here
+	for attempt := range 2 {
+		if rr := sendRequest("198.51.100.20:1003", sessionID, "GET", "/api/exams", nil); rr.Code != http.StatusOK {
+			t.Fatalf("Expected read %d within the burst, got %d: %s", attempt, rr.Code, rr.Body.String())
+		}
+	}
+	if rr := sendRequest("198.51.100.22:1000", sessionID, "GET", "/api/exams", nil); rr.Code != http.StatusTooManyRequests {
+		t.Errorf("Expected status 429 for the user from any address, got %d: %s", rr.Code, rr.Body.String())
+	}
+	if rr := sendRequest("198.51.100.20:1004", sessionID, "POST", "/api/exams", map[string]any{"title": "Unlimited writes"}); rr.Code != http.StatusCreated {
+		t.Errorf("Expected writes not to be limited, got %d: %s", rr.Code, rr.Body.String())
+	}
+
+	// Buckets refill at the rate of their rule
+	var limiter rateLimiter
+	rule := configuration.RateLimitRule{RequestsPerMinute: 60, Burst: 1}
+	start := time.Now()
+	if allowed, _ := limiter.allow("client", rule, start); !allowed {
+		t.Fatal("Expected the first request to be allowed")
+	}
+	if allowed, wait := limiter.allow("client", rule, start.Add(500*time.Millisecond)); allowed || wait != 500*time.Millisecond {
+		t.Errorf("Expected to wait 500ms for a token, got allowed %v and %v", allowed, wait)
+	}
+	if allowed, _ := limiter.allow("client", rule, start.Add(1500*time.Millisecond)); !allowed {
+		t.Error("Expected a request to be allowed once refilled")
+	}
+
+	// A flood of distinct clients, none of which refilled, stays bounded
+	var floodedLimiter rateLimiter
+	for index := range maximumRateLimitBuckets + 10 {
+		floodedLimiter.allow(fmt.Sprintf("client-%d", index), rule, start.Add(time.Duration(index)*time.Microsecond))
+	}
+	if count := len(floodedLimiter.buckets); count > maximumRateLimitBuckets {
+		t.Errorf("Expected at most %d buckets, got %d", maximumRateLimitBuckets, count)
+	}
+	if _, found := floodedLimiter.buckets["client-0"]; found {
+		t.Error("Expected the bucket closest to refilling to be forgotten first")
+	}
+	if _, found := floodedLimiter.buckets[fmt.Sprintf("client-%d", maximumRateLimitBuckets+9)]; !found {
+		t.Error("Expected the newest bucket to be kept")
+	}
+}
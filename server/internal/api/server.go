@@ -14,12 +14,18 @@ import (
 	"time"
 
 	"lectures/internal/configuration"
+	"lectures/internal/documents"
+	"lectures/internal/embeddings"
 	"lectures/internal/jobs"
 	"lectures/internal/llm"
+	"lectures/internal/mail"
 	"lectures/internal/markdown"
 	"lectures/internal/models"
 	"lectures/internal/prompts"
+	"lectures/internal/secrets"
+	"lectures/internal/storage"
 	"lectures/internal/tools"
+	"lectures/internal/webhooks"
 
 	"github.com/gorilla/mux"
 	gonanoid "github.com/matoous/go-nanoid/v2"
@@ -36,9 +42,21 @@ type Server struct {
 	promptManager     *prompts.Manager
 	toolGenerator     *tools.ToolGenerator
 	markdownConverter markdown.MarkdownConverter
+	embeddingIndex    *embeddings.Index    // Nil sends whole lectures as chat context
+	documentProcessor *documents.Processor // Reads syllabi; nil disables creating exams from one
+	secretCipher      *secrets.Cipher      // Seals the API keys users store; nil disables them
+	objectStore       storage.ObjectStore  // Holds media, page images and exports; nil keeps them in the database
+	mailSender        mail.Sender          // Sends password reset links; nil disables resets
+	webhookDispatcher *webhooks.Dispatcher // Delivers the events of user webhooks; nil disables them
+	dependencyChecks  []healthCheck        // External dependencies reported by /readyz
+	providerHealth    providerHealth
+	maintenance       databaseMaintenance
+	backupRestore     backupRestore
+	oidc              oidcState // Identity provider and pending logins of single sign-on
 	// Security
 	loginAttempts      map[string][]time.Time
 	loginAttemptsMutex sync.Mutex
+	rateLimiter        rateLimiter
 }
 
 // NewServer creates a new API server
@@ -48,7 +66,7 @@ func NewServer(configuration *configuration.Configuration, database *sql.DB, job
 		database:          database,
 		jobQueue:          jobQueue,
 		router:            mux.NewRouter(),
-		wsHub:             NewHub(),
+		wsHub:             NewHub(configuration.Server.WebSocket),
 		llmProvider:       llmProvider,
 		promptManager:     promptManager,
 		toolGenerator:     toolGenerator,
@@ -133,25 +151,66 @@ func (server *Server) syncConfigurationToDatabase() {
 	}
 }
 
+// SetEmbeddingIndex enables retrieval of the most relevant lecture chunks as chat context
+func (server *Server) SetEmbeddingIndex(index *embeddings.Index) {
+	server.embeddingIndex = index
+}
+
+// SetObjectStore serves media, page images and exports from an object store, and keeps new uploads in it
+func (server *Server) SetObjectStore(objectStore storage.ObjectStore) {
+	server.objectStore = objectStore
+}
+
 // Handler returns the HTTP handler
 func (server *Server) Handler() http.Handler {
-	return server.router
+	return server.proxyHandler(server.router)
 }
 
 // Broadcast sends a message to a specific WebSocket channel
 func (server *Server) Broadcast(channel string, msgType string, payload any) {
+	server.BroadcastEach([]string{channel}, msgType, payload)
+}
+
+// BroadcastEach sends an event to several WebSocket channels, such as the lecture and course of a job. Clients
+// get a copy for each of them they subscribed to, while those following the events of the exam get one
+func (server *Server) BroadcastEach(channels []string, msgType string, payload any) {
+	if len(channels) == 0 {
+		return
+	}
+	examID := ""
+	for _, channel := range channels {
+		if examID = server.channelExam(channel); examID != "" {
+			break
+		}
+	}
 	server.wsHub.Broadcast(WSMessage{
-		Type:      msgType,
-		Channel:   channel,
-		Payload:   payload,
-		Timestamp: time.Now().Format(time.RFC3339),
+		Type:         msgType,
+		Channel:      channels[0],
+		Payload:      payload,
+		Timestamp:    time.Now().Format(time.RFC3339),
+		alsoChannels: channels[1:],
+		examID:       examID,
 	})
 }
 
+// channelExam returns the exam the events of a course or lecture channel belong to, or an empty string
+func (server *Server) channelExam(channel string) string {
+	if examID, found := strings.CutPrefix(channel, "course:"); found {
+		return examID
+	}
+	if lectureID, found := strings.CutPrefix(channel, "lecture:"); found {
+		var examID string
+		server.database.QueryRow("SELECT exam_id FROM lectures WHERE id = ?", lectureID).Scan(&examID)
+		return examID
+	}
+	return ""
+}
+
 // setupRoutes configures all API routes
 func (server *Server) setupRoutes() {
 	// Add global CORS middleware - must be first
 	server.router.Use(server.corsMiddleware)
+	server.router.Use(server.addressRateLimitMiddleware)
 
 	// Explicitly handle OPTIONS for all routes globally to prevent 405
 	server.router.PathPrefix("/").Methods("OPTIONS").HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -160,10 +219,18 @@ func (server *Server) setupRoutes() {
 
 	// Public routes
 	server.router.HandleFunc("/api/health", server.handleHealth).Methods("GET")
+	server.router.HandleFunc("/healthz", server.handleLiveness).Methods("GET")
+	server.router.HandleFunc("/readyz", server.handleReadiness).Methods("GET")
+	server.router.HandleFunc("/api/openapi.json", server.handleOpenAPI).Methods("GET")
+	server.router.HandleFunc("/api/docs", server.handleAPIDocs).Methods("GET")
 	server.router.HandleFunc("/api/auth/setup", server.handleAuthSetup).Methods("POST")
 	server.router.HandleFunc("/api/auth/register", server.handleAuthRegister).Methods("POST")
 	server.router.HandleFunc("/api/auth/login", server.handleAuthLogin).Methods("POST")
 	server.router.HandleFunc("/api/auth/status", server.handleAuthStatus).Methods("GET")
+	server.router.HandleFunc("/api/auth/oidc/login", server.handleOIDCLogin).Methods("GET")
+	server.router.HandleFunc("/api/auth/oidc/callback", server.handleOIDCCallback).Methods("GET")
+	server.router.HandleFunc("/api/auth/password-reset/request", server.handlePasswordResetRequest).Methods("POST")
+	server.router.HandleFunc("/api/auth/password-reset/confirm", server.handlePasswordResetConfirm).Methods("POST")
 	// System restore must be public to allow restoration during initial setup
 	// Authentication is handled internally by the handler based on initialization state
 	server.router.HandleFunc("/api/system/restore", server.handleRestoreDatabase).Methods("POST")
@@ -173,16 +240,24 @@ func (server *Server) setupRoutes() {
 	apiRouter.Use(server.requestIDMiddleware)
 	apiRouter.Use(server.loggingMiddleware)
 	apiRouter.Use(server.authMiddleware)
+	apiRouter.Use(server.userRateLimitMiddleware)
+	apiRouter.Use(server.auditMiddleware)
 
 	// Auth (requires auth)
 	apiRouter.HandleFunc("/auth/logout", server.handleAuthLogout).Methods("POST")
 	apiRouter.HandleFunc("/auth/password", server.handleAuthChangePassword).Methods("PATCH")
+	apiRouter.HandleFunc("/auth/email", server.handleAuthUpdateEmail).Methods("PATCH")
+	apiRouter.HandleFunc("/auth/tokens", server.handleCreateAPIToken).Methods("POST")
+	apiRouter.HandleFunc("/auth/tokens", server.handleListAPITokens).Methods("GET")
+	apiRouter.HandleFunc("/auth/tokens", server.handleRevokeAPIToken).Methods("DELETE")
 
 	// Staged Upload Protocol
 	apiRouter.HandleFunc("/uploads/prepare", server.handleUploadPrepare).Methods("POST")
 	apiRouter.HandleFunc("/uploads/append", server.handleUploadAppend).Methods("POST")
 	apiRouter.HandleFunc("/uploads/stage", server.handleUploadStage).Methods("POST")
 	apiRouter.HandleFunc("/uploads/import", server.handleImport).Methods("POST")
+	apiRouter.HandleFunc("/uploads", server.handleListUploads).Methods("GET")
+	apiRouter.HandleFunc("/uploads/details", server.handleGetUpload).Methods("GET")
 
 	// Exams
 	apiRouter.HandleFunc("/exams", server.handleCreateExam).Methods("POST")
@@ -192,15 +267,29 @@ func (server *Server) setupRoutes() {
 	apiRouter.HandleFunc("/exams", server.handleDeleteExam).Methods("DELETE")
 	apiRouter.HandleFunc("/exams/search", server.handleExamSearch).Methods("GET")
 	apiRouter.HandleFunc("/exams/suggest", server.handleExamSuggest).Methods("POST")
+	apiRouter.HandleFunc("/exams/from-syllabus", server.handleCreateExamFromSyllabus).Methods("POST")
 	apiRouter.HandleFunc("/exams/concepts", server.handleGetExamConcepts).Methods("GET")
+	apiRouter.HandleFunc("/exams/duplicates", server.handleAnalyzeExamDuplicates).Methods("POST")
+	apiRouter.HandleFunc("/exams/duplicates", server.handleGetExamDuplicates).Methods("GET")
+	apiRouter.HandleFunc("/exams/members", server.handleListExamMembers).Methods("GET")
+	apiRouter.HandleFunc("/exams/members", server.handleSetExamMember).Methods("PUT")
+	apiRouter.HandleFunc("/exams/members", server.handleDeleteExamMember).Methods("DELETE")
+	apiRouter.HandleFunc("/exams/history", server.handleGetExamHistory).Methods("GET")
 
 	// Lectures
 	apiRouter.HandleFunc("/lectures", server.handleCreateLecture).Methods("POST")
 	apiRouter.HandleFunc("/lectures", server.handleListLectures).Methods("GET")
 	apiRouter.HandleFunc("/lectures/details", server.handleGetLecture).Methods("GET")
+	apiRouter.HandleFunc("/lectures/report", server.handleGetLectureReport).Methods("GET")
+	apiRouter.HandleFunc("/lectures/recap", server.handleGetLectureRecap).Methods("GET")
 	apiRouter.HandleFunc("/lectures", server.handleUpdateLecture).Methods("PATCH")
 	apiRouter.HandleFunc("/lectures", server.handleDeleteLecture).Methods("DELETE")
 	apiRouter.HandleFunc("/lectures/retry-job", server.handleRetryLectureJob).Methods("POST")
+	apiRouter.HandleFunc("/lectures/bookmarks", server.handleListBookmarks).Methods("GET")
+	apiRouter.HandleFunc("/lectures/bookmarks", server.handleCreateBookmark).Methods("POST")
+	apiRouter.HandleFunc("/lectures/bookmarks", server.handleUpdateBookmark).Methods("PATCH")
+	apiRouter.HandleFunc("/lectures/bookmarks", server.handleDeleteBookmark).Methods("DELETE")
+	apiRouter.HandleFunc("/lectures/documents/from-url", server.handleCreateDocumentFromURL).Methods("POST")
 
 	// Media (Listing/Ordering)
 	apiRouter.HandleFunc("/media", server.handleListMedia).Methods("GET")
@@ -210,13 +299,18 @@ func (server *Server) setupRoutes() {
 	apiRouter.HandleFunc("/transcripts", server.handleGetTranscript).Methods("GET")
 	apiRouter.HandleFunc("/transcripts", server.handleUpdateTranscript).Methods("PATCH")
 	apiRouter.HandleFunc("/transcripts/html", server.handleGetTranscriptHTML).Methods("GET")
+	apiRouter.HandleFunc("/transcripts/polish", server.handlePolishTranscript).Methods("POST")
+	apiRouter.HandleFunc("/transcripts/redactions", server.handleListRedactions).Methods("GET")
+	apiRouter.HandleFunc("/transcripts/redactions", server.handleCreateRedactions).Methods("POST")
 
 	// Reference Documents (Listing/Meta)
 	apiRouter.HandleFunc("/documents", server.handleListDocuments).Methods("GET")
 	apiRouter.HandleFunc("/documents/details", server.handleGetDocument).Methods("GET")
+	apiRouter.HandleFunc("/documents", server.handleUpdateDocumentExtraction).Methods("PATCH")
 	apiRouter.HandleFunc("/documents", server.handleDeleteDocument).Methods("DELETE")
 	apiRouter.HandleFunc("/documents/pages", server.handleGetDocumentPages).Methods("GET")
 	apiRouter.HandleFunc("/documents/pages/html", server.handleGetPageHTML).Methods("GET")
+	apiRouter.HandleFunc("/documents/chunks", server.handleGetDocumentChunks).Methods("GET")
 
 	// WebSocket — registered on the public router (not apiRouter) because:
 	// Browsers always send cookies with image tag requests. If a stale HttpOnly cookie
@@ -235,12 +329,33 @@ func (server *Server) setupRoutes() {
 	apiRouter.HandleFunc("/tools", server.handleListTools).Methods("GET")
 	apiRouter.HandleFunc("/tools/details", server.handleGetTool).Methods("GET")
 	apiRouter.HandleFunc("/tools/details", server.handleUpdateTool).Methods("PATCH")
+	apiRouter.HandleFunc("/tools/content", server.handleUpdateToolContent).Methods("PATCH")
+	apiRouter.HandleFunc("/tools/sections", server.handleGetToolSections).Methods("GET")
+	apiRouter.HandleFunc("/tools/sections/regenerate", server.handleRegenerateToolSection).Methods("POST")
+	apiRouter.HandleFunc("/tools/versions", server.handleListToolVersions).Methods("GET")
+	apiRouter.HandleFunc("/tools/versions/restore", server.handleRestoreToolVersion).Methods("POST")
+	apiRouter.HandleFunc("/tools/rollback", server.handleRestoreToolVersion).Methods("POST")
+	apiRouter.HandleFunc("/tools/versions/diff", server.handleDiffToolVersion).Methods("GET")
 	apiRouter.HandleFunc("/tools/html", server.handleGetToolHTML).Methods("GET")
 	apiRouter.HandleFunc("/tools", server.handleDeleteTool).Methods("DELETE")
 	apiRouter.HandleFunc("/tools/export", server.handleExportTool).Methods("POST")
+	apiRouter.HandleFunc("/tools/feedback", server.handleRateTool).Methods("PUT")
+	apiRouter.HandleFunc("/tools/quiz/attempts", server.handleCreateQuizAttempt).Methods("POST")
+	apiRouter.HandleFunc("/tools/quiz/attempts", server.handleListQuizAttempts).Methods("GET")
+	apiRouter.HandleFunc("/tools/presets", server.handleListGenerationPresets).Methods("GET")
+	apiRouter.HandleFunc("/tools/presets", server.handleCreateGenerationPreset).Methods("POST")
+	apiRouter.HandleFunc("/tools/presets", server.handleUpdateGenerationPreset).Methods("PATCH")
+	apiRouter.HandleFunc("/tools/presets", server.handleDeleteGenerationPreset).Methods("DELETE")
 	apiRouter.HandleFunc("/transcripts/export", server.handleExportTranscript).Methods("POST")
 	apiRouter.HandleFunc("/documents/export", server.handleExportDocument).Methods("POST")
 
+	// Export presets and bundled publishing
+	apiRouter.HandleFunc("/exports/presets", server.handleCreateExportPreset).Methods("POST")
+	apiRouter.HandleFunc("/exports/presets", server.handleListExportPresets).Methods("GET")
+	apiRouter.HandleFunc("/exports/presets", server.handleDeleteExportPreset).Methods("DELETE")
+	apiRouter.HandleFunc("/exports/publish", server.handlePublishBundle).Methods("POST")
+	apiRouter.HandleFunc("/offline/bundle", server.handleCreateOfflineBundle).Methods("POST")
+
 	// Exports download serving — registered on the public router because:
 	// Anchor tag navigations or window.open calls used for downloads send cookies.
 	// A stale cookie would block the download via authMiddleware before the handler
@@ -258,7 +373,50 @@ func (server *Server) setupRoutes() {
 	// Jobs
 	apiRouter.HandleFunc("/jobs", server.handleListJobs).Methods("GET")
 	apiRouter.HandleFunc("/jobs/details", server.handleGetJob).Methods("GET")
+	apiRouter.HandleFunc("/jobs/logs", server.handleGetJobLogs).Methods("GET")
 	apiRouter.HandleFunc("/jobs", server.handleCancelJob).Methods("DELETE")
+	apiRouter.HandleFunc("/jobs", server.handleUpdateJob).Methods("PATCH")
+	apiRouter.HandleFunc("/jobs/labels", server.handleListJobLabels).Methods("GET")
+	apiRouter.HandleFunc("/jobs/types", server.handleListJobTypes).Methods("GET")
+	apiRouter.HandleFunc("/jobs/pause", server.handlePauseJob).Methods("POST")
+	apiRouter.HandleFunc("/jobs/resume", server.handleResumeJob).Methods("POST")
+	apiRouter.HandleFunc("/jobs/dead", server.handleListDeadJobs).Methods("GET")
+	apiRouter.HandleFunc("/jobs/requeue", server.handleRequeueJob).Methods("POST")
+	apiRouter.HandleFunc("/budget", server.handleGetCostBudget).Methods("GET")
+	apiRouter.HandleFunc("/usage", server.handleGetUsage).Methods("GET")
+	apiRouter.HandleFunc("/storage/usage", server.handleGetStorageUsage).Methods("GET")
+
+	// Queue administration (admin only)
+	apiRouter.HandleFunc("/admin/queue", server.handleGetQueueStatus).Methods("GET")
+	apiRouter.HandleFunc("/admin/queue/pause", server.handlePauseQueue).Methods("POST")
+	apiRouter.HandleFunc("/admin/queue/resume", server.handleResumeQueue).Methods("POST")
+	apiRouter.HandleFunc("/admin/jobs/fail", server.handleForceFailJob).Methods("POST")
+	apiRouter.HandleFunc("/admin/jobs/reassign", server.handleReassignOrphanedJobs).Methods("POST")
+	apiRouter.HandleFunc("/admin/stats", server.handleGetUsageStatistics).Methods("GET")
+	apiRouter.HandleFunc("/admin/users", server.handleListUsers).Methods("GET")
+	apiRouter.HandleFunc("/admin/users", server.handleCreateUser).Methods("POST")
+	apiRouter.HandleFunc("/admin/users", server.handleUpdateUser).Methods("PATCH")
+	apiRouter.HandleFunc("/admin/users", server.handleDeleteUser).Methods("DELETE")
+	apiRouter.HandleFunc("/admin/users/budget", server.handleSetUserCostBudget).Methods("PUT")
+	apiRouter.HandleFunc("/admin/audit", server.handleListAuditEvents).Methods("GET")
+	apiRouter.HandleFunc("/admin/auth/events", server.handleListAuthEvents).Methods("GET")
+	apiRouter.HandleFunc("/admin/auth/lockouts", server.handleListLoginLockouts).Methods("GET")
+	apiRouter.HandleFunc("/admin/auth/unlock", server.handleUnlockLogin).Methods("POST")
+	apiRouter.HandleFunc("/admin/system/announcement", server.handleSetSystemAnnouncement).Methods("PUT")
+	apiRouter.HandleFunc("/admin/system/announcement", server.handleClearSystemAnnouncement).Methods("DELETE")
+	apiRouter.HandleFunc("/admin/prompt-variants", server.handleListPromptVariants).Methods("GET")
+	apiRouter.HandleFunc("/admin/prompt-variants", server.handleCreatePromptVariant).Methods("POST")
+	apiRouter.HandleFunc("/admin/prompt-variants", server.handleUpdatePromptVariant).Methods("PATCH")
+	apiRouter.HandleFunc("/admin/database", server.handleGetDatabaseReport).Methods("GET")
+	apiRouter.HandleFunc("/admin/database/maintenance", server.handleRunDatabaseMaintenance).Methods("POST")
+	apiRouter.HandleFunc("/admin/llm/providers", server.handleGetLLMProviders).Methods("GET")
+	apiRouter.HandleFunc("/admin/backups", server.handleListBackups).Methods("GET")
+	apiRouter.HandleFunc("/admin/backups", server.handleCreateBackup).Methods("POST")
+	apiRouter.HandleFunc("/admin/backups/download", server.handleDownloadBackup).Methods("GET")
+	apiRouter.HandleFunc("/admin/restore", server.handleRestoreBackup).Methods("POST")
+
+	// System status banner (any authenticated user)
+	apiRouter.HandleFunc("/system/status", server.handleGetSystemStatus).Methods("GET")
 
 	// System backup — registered on the public router (not apiRouter) because:
 	// Browsers send cookies with download link navigations. If a stale HttpOnly cookie
@@ -269,6 +427,18 @@ func (server *Server) setupRoutes() {
 	// Settings
 	apiRouter.HandleFunc("/settings", server.handleGetSettings).Methods("GET")
 	apiRouter.HandleFunc("/settings", server.handleUpdateSettings).Methods("PATCH")
+	apiRouter.HandleFunc("/settings/keys", server.handleListProviderKeys).Methods("GET")
+	apiRouter.HandleFunc("/settings/keys", server.handleSetProviderKey).Methods("PUT")
+	apiRouter.HandleFunc("/settings/keys", server.handleDeleteProviderKey).Methods("DELETE")
+
+	// Webhooks
+	apiRouter.HandleFunc("/webhooks", server.handleListWebhooks).Methods("GET")
+	apiRouter.HandleFunc("/webhooks", server.handleCreateWebhook).Methods("POST")
+	apiRouter.HandleFunc("/webhooks", server.handleUpdateWebhook).Methods("PATCH")
+	apiRouter.HandleFunc("/webhooks", server.handleDeleteWebhook).Methods("DELETE")
+	apiRouter.HandleFunc("/webhooks/test", server.handleTestWebhook).Methods("POST")
+	apiRouter.HandleFunc("/webhooks/deliveries", server.handleListWebhookDeliveries).Methods("GET")
+	apiRouter.HandleFunc("/webhooks/deliveries/redeliver", server.handleRedeliverWebhookDelivery).Methods("POST")
 
 	// WebSocket — registered on the public router (not apiRouter) because:
 	// The apiRouter's authMiddleware checks cookies first, but browsers always send
@@ -320,7 +490,8 @@ func (server *Server) corsMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(responseWriter http.ResponseWriter, request *http.Request) {
 		origin := request.Header.Get("Origin")
 
-		if origin != "" {
+		if origin != "" && server.isAllowedOrigin(request, origin) {
+			responseWriter.Header().Add("Vary", "Origin")
 			responseWriter.Header().Set("Access-Control-Allow-Origin", origin)
 			responseWriter.Header().Set("Access-Control-Allow-Methods", "POST, GET, OPTIONS, PUT, DELETE, PATCH")
 			responseWriter.Header().Set("Access-Control-Allow-Headers", "Accept, Content-Type, Content-Length, Accept-Encoding, X-CSRF-Token, Authorization, X-Requested-With")
@@ -368,7 +539,16 @@ func (server *Server) loggingMiddleware(next http.Handler) http.Handler {
 
 type contextKey string
 
-const userIDKey contextKey = "user_id"
+const (
+	userIDKey   contextKey = "user_id"
+	userRoleKey contextKey = "user_role"
+)
+
+// passwordChangeRoutes are the routes of the authenticated API left to users who must change their password
+var passwordChangeRoutes = map[string]bool{
+	"PATCH /api/auth/password": true,
+	"POST /api/auth/logout":    true,
+}
 
 func (server *Server) authMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(responseWriter http.ResponseWriter, request *http.Request) {
@@ -378,8 +558,11 @@ func (server *Server) authMiddleware(next http.Handler) http.Handler {
 			return
 		}
 
+		// Browsers never send API tokens on their own, so requests authenticated by one need no CSRF protection
+		apiToken := bearerAPIToken(request)
+
 		// CSRF Protection: Require custom header AND Origin validation for state-changing methods
-		if request.Method == "POST" || request.Method == "PATCH" || request.Method == "DELETE" {
+		if apiToken == "" && (request.Method == "POST" || request.Method == "PATCH" || request.Method == "DELETE") {
 			// Check X-Requested-With header (set by XMLHttpRequest/fetch)
 			if request.Header.Get("X-Requested-With") == "" {
 				server.writeError(responseWriter, http.StatusForbidden, "CSRF_ERROR", "X-Requested-With header is required", nil)
@@ -397,7 +580,10 @@ func (server *Server) authMiddleware(next http.Handler) http.Handler {
 					isLocalhost := strings.Contains(originURL.Host, "localhost") || strings.Contains(originURL.Host, "127.0.0.1")
 					isSameHost := originURL.Host == host || originURL.Host+":80" == host || originURL.Host+":443" == host
 
-					if !isLocalhost && !isSameHost {
+					// Frontends served from another origin are trusted once they are listed in server.allowed_origins
+					isConfiguredOrigin := len(server.configuration.Server.AllowedOrigins) > 0 && server.isAllowedOrigin(request, origin)
+
+					if !isLocalhost && !isSameHost && !isConfiguredOrigin {
 						slog.Warn("CSRF: Origin header mismatch", "origin", origin, "host", host)
 						server.writeError(responseWriter, http.StatusForbidden, "CSRF_ERROR", "Origin header mismatch", nil)
 						return
@@ -406,30 +592,65 @@ func (server *Server) authMiddleware(next http.Handler) http.Handler {
 			}
 		}
 
-		sessionToken := server.getSessionToken(request)
-		if sessionToken == "" {
-			server.writeError(responseWriter, http.StatusUnauthorized, "AUTHENTICATION_ERROR", "Authentication required", nil)
-			return
+		var userID, userRole, sessionToken string
+		var passwordChangeRequired bool
+		if apiToken != "" {
+			var authenticated bool
+			if userID, userRole, authenticated = server.authenticateAPIToken(responseWriter, request, apiToken); !authenticated {
+				return
+			}
+		} else {
+			sessionToken = server.getSessionToken(request)
+			if sessionToken == "" {
+				server.writeError(responseWriter, http.StatusUnauthorized, "AUTHENTICATION_ERROR", "Authentication required", nil)
+				return
+			}
+
+			var expiresAt time.Time
+			databaseError := server.database.QueryRow(`
+				SELECT auth_sessions.user_id, auth_sessions.expires_at, users.role, users.password_change_required
+				FROM auth_sessions
+				JOIN users ON auth_sessions.user_id = users.id
+				WHERE auth_sessions.id = ?
+			`, sessionToken).Scan(&userID, &expiresAt, &userRole, &passwordChangeRequired)
+			if databaseError != nil {
+				server.writeError(responseWriter, http.StatusUnauthorized, "AUTHENTICATION_ERROR", "Invalid session", nil)
+				return
+			}
+
+			if time.Now().After(expiresAt) {
+				server.writeError(responseWriter, http.StatusUnauthorized, "AUTHENTICATION_ERROR", "Session expired", nil)
+				return
+			}
 		}
 
-		var userID string
-		var expiresAt time.Time
-		databaseError := server.database.QueryRow("SELECT user_id, expires_at FROM auth_sessions WHERE id = ?", sessionToken).Scan(&userID, &expiresAt)
-		if databaseError != nil {
-			server.writeError(responseWriter, http.StatusUnauthorized, "AUTHENTICATION_ERROR", "Invalid session", nil)
+		// Users an administrator asked to change their password may do nothing else until they do
+		if passwordChangeRequired && !passwordChangeRoutes[request.Method+" "+request.URL.Path] {
+			server.writeError(responseWriter, http.StatusForbidden, "PASSWORD_CHANGE_REQUIRED", "You must change your password first", nil)
 			return
 		}
 
-		if time.Now().After(expiresAt) {
-			server.writeError(responseWriter, http.StatusUnauthorized, "AUTHENTICATION_ERROR", "Session expired", nil)
+		// The role of the account decides which routes it may use at all
+		if permission := requiredPermission(request); permission != "" && !roleHasPermission(userRole, permission) {
+			server.recordAuditEvent(request, models.AuditEvent{Action: models.AuditActionAccessDenied, ActorID: userID, Status: http.StatusForbidden, Details: map[string]any{
+				"route":               request.Method + " " + request.URL.Path,
+				"required_permission": permission,
+			}})
+			server.writeError(responseWriter, http.StatusForbidden, "FORBIDDEN", "Your account role does not allow this", map[string]string{
+				"role":                userRole,
+				"required_permission": permission,
+			})
 			return
 		}
 
 		// Update last activity
-		_, _ = server.database.Exec("UPDATE auth_sessions SET last_activity = ? WHERE id = ?", time.Now(), sessionToken)
+		if sessionToken != "" {
+			_, _ = server.database.Exec("UPDATE auth_sessions SET last_activity = ? WHERE id = ?", time.Now(), sessionToken)
+		}
 
-		// Inject user_id into context
-		requestContext := context.WithValue(request.Context(), userIDKey, userID)
+		// Inject user_id and role into context, the user also for the LLM calls made while handling the request
+		requestContext := context.WithValue(context.WithValue(request.Context(), userIDKey, userID), userRoleKey, userRole)
+		requestContext = llm.WithUser(requestContext, userID)
 		next.ServeHTTP(responseWriter, request.WithContext(requestContext))
 	})
 }
@@ -437,11 +658,17 @@ func (server *Server) authMiddleware(next http.Handler) http.Handler {
 // Utility functions
 
 func (server *Server) writeJSON(responseWriter http.ResponseWriter, statusCode int, data interface{}) {
+	server.writePage(responseWriter, statusCode, data, "")
+}
+
+// writePage writes a page of a list, along with the cursor of the next page when there is one
+func (server *Server) writePage(responseWriter http.ResponseWriter, statusCode int, data interface{}, nextCursor string) {
 	response := models.APIResponse{
 		Data: data,
 		Meta: models.Meta{
-			Timestamp: time.Now().Format(time.RFC3339),
-			RequestID: responseWriter.Header().Get("X-Request-ID"),
+			Timestamp:  time.Now().Format(time.RFC3339),
+			RequestID:  responseWriter.Header().Get("X-Request-ID"),
+			NextCursor: nextCursor,
 		},
 	}
 	responseWriter.Header().Set("Content-Type", "application/json")
@@ -494,7 +721,9 @@ func (server *Server) getSessionToken(request *http.Request) string {
 // getValidSessionToken tries multiple token sources and validates each against the database
 // Returns the first valid token, or empty string if none are valid
 // Useful for image requests where old cookies may conflict with current session
-func (server *Server) getValidSessionToken(request *http.Request) string {
+// passwordChangeRequired is set when the only valid session is of an account that must change its password first;
+// callers refuse it with writeSessionError, as authMiddleware does
+func (server *Server) getValidSessionToken(request *http.Request) (sessionToken string, passwordChangeRequired bool) {
 	var tokensToTry []string
 
 	// 1. Try cookie first
@@ -517,13 +746,34 @@ func (server *Server) getValidSessionToken(request *http.Request) string {
 	// Validate each token until we find a valid one
 	for _, token := range tokensToTry {
 		var expiresAt time.Time
-		err := server.database.QueryRow("SELECT expires_at FROM auth_sessions WHERE id = ?", token).Scan(&expiresAt)
-		if err == nil && time.Now().Before(expiresAt) {
-			return token
+		var changeRequired bool
+		err := server.database.QueryRow(`
+			SELECT auth_sessions.expires_at, users.password_change_required
+			FROM auth_sessions
+			JOIN users ON auth_sessions.user_id = users.id
+			WHERE auth_sessions.id = ?
+		`, token).Scan(&expiresAt, &changeRequired)
+		if err != nil || !time.Now().Before(expiresAt) {
+			continue
+		}
+		if !changeRequired {
+			return token, false
+		}
+		if sessionToken == "" {
+			sessionToken, passwordChangeRequired = token, true
 		}
 	}
 
-	return ""
+	return sessionToken, passwordChangeRequired
+}
+
+// writeSessionError refuses a request that getValidSessionToken found no usable session for
+func (server *Server) writeSessionError(responseWriter http.ResponseWriter, passwordChangeRequired bool) {
+	if passwordChangeRequired {
+		server.writeError(responseWriter, http.StatusForbidden, "PASSWORD_CHANGE_REQUIRED", "You must change your password first", nil)
+		return
+	}
+	server.writeError(responseWriter, http.StatusUnauthorized, "AUTHENTICATION_ERROR", "Authentication required", nil)
 }
 
 func (server *Server) getUserID(request *http.Request) string {
@@ -4,6 +4,7 @@ import (
 	"encoding/json"
 	"io"
 	"net/http"
+	"strconv"
 	"time"
 )
 
@@ -14,6 +15,24 @@ func writeJSONResponse(responseWriter http.ResponseWriter, value interface{}) er
 	return encoder.Encode(value)
 }
 
+// firstNonEmpty returns the first non-empty string among the given values
+func firstNonEmpty(values ...string) string {
+	for _, value := range values {
+		if value != "" {
+			return value
+		}
+	}
+	return ""
+}
+
+// formatOptionalInt formats a value for a job payload, empty when it is unset
+func formatOptionalInt(value *int) string {
+	if value == nil {
+		return ""
+	}
+	return strconv.Itoa(*value)
+}
+
 // ProgressReader wraps an io.ReadCloser to track reading progress
 type ProgressReader struct {
 	Reader     io.ReadCloser
@@ -2,30 +2,33 @@ package api
 
 import (
 	"encoding/json"
+	"errors"
 	"log/slog"
 	"net/http"
+	"os"
 	"strings"
 	"sync"
 	"time"
 
 	"github.com/gorilla/websocket"
+
+	"lectures/internal/configuration"
+	"lectures/internal/models"
 )
 
-var upgrader = websocket.Upgrader{
-	CheckOrigin: func(httpRequest *http.Request) bool {
-		origin := httpRequest.Header.Get("Origin")
-		slog.Debug("WebSocket origin check", "origin", origin)
-		if origin == "" {
-			return true
-		}
-		// Allow localhost on any port
-		if strings.Contains(origin, "localhost") || strings.Contains(origin, "127.0.0.1") {
-			return true
-		}
-		slog.Warn("WebSocket origin rejected", "origin", origin)
-		return false
-	},
-}
+// WebSocket flow control: messages queued for a client beyond webSocketSendBuffer mean it is too slow to keep up
+const (
+	webSocketSendBuffer     = 512
+	webSocketWriteTimeout   = 10 * time.Second
+	webSocketMaximumMessage = 64 * 1024 // Bytes of a message from a client, which only subscribes and unsubscribes
+)
+
+// Close codes the server ends WebSocket connections with, besides normal closures. Clients dropped for being
+// slow may reconnect right away; those over the connection limit should close another connection first
+const (
+	closeCodeSlowConsumer       = websocket.CloseTryAgainLater
+	closeCodeTooManyConnections = websocket.ClosePolicyViolation
+)
 
 // Hub maintains the set of active clients and broadcasts messages
 type Hub struct {
@@ -34,6 +37,9 @@ type Hub struct {
 	register   chan *WSClient
 	unregister chan *WSClient
 	mutex      sync.RWMutex
+
+	idleTimeout               time.Duration // Silence after which a connection is closed; pings are sent twice as often
+	maximumConnectionsPerUser int
 }
 
 // WSMessage represents a message to be broadcast
@@ -42,16 +48,44 @@ type WSMessage struct {
 	Channel   string `json:"channel"`
 	Payload   any    `json:"payload"`
 	Timestamp string `json:"timestamp"`
+
+	alsoChannels []string // Further channels of the event, whose subscribers get a copy each, as for Channel
+	examID       string   // Exam of the event, whose examEventsChannel subscribers get it once
+}
+
+// examEventsChannel is the channel following every lecture, job and tool event of an exam, such as those sent
+// on its course channel and on the channels of its lectures
+func examEventsChannel(examID string) string {
+	return "exam:" + examID + ":*"
+}
+
+// examOfEventsChannel returns the exam of an examEventsChannel, or false for other channels
+func examOfEventsChannel(channel string) (string, bool) {
+	examID, found := strings.CutPrefix(channel, "exam:")
+	if !found {
+		return "", false
+	}
+	examID, found = strings.CutSuffix(examID, ":*")
+	return examID, found && examID != "" && !strings.Contains(examID, ":")
 }
 
 // NewHub creates a new WebSocket hub
-func NewHub() *Hub {
-	return &Hub{
-		clients:    make(map[*WSClient]bool),
-		broadcast:  make(chan WSMessage, 1024), // Buffered to prevent blocking
-		register:   make(chan *WSClient),
-		unregister: make(chan *WSClient),
+func NewHub(webSocketConfiguration configuration.WebSocketConfiguration) *Hub {
+	hub := &Hub{
+		clients:                   make(map[*WSClient]bool),
+		broadcast:                 make(chan WSMessage, 1024), // Buffered to prevent blocking
+		register:                  make(chan *WSClient),
+		unregister:                make(chan *WSClient),
+		idleTimeout:               time.Duration(webSocketConfiguration.IdleTimeoutSeconds) * time.Second,
+		maximumConnectionsPerUser: webSocketConfiguration.MaximumConnectionsPerUser,
+	}
+	if hub.idleTimeout <= 0 {
+		hub.idleTimeout = 60 * time.Second
 	}
+	if hub.maximumConnectionsPerUser <= 0 {
+		hub.maximumConnectionsPerUser = 10
+	}
+	return hub
 }
 
 // Broadcast sends a message to the hub for broadcasting
@@ -64,11 +98,32 @@ func (hub *Hub) Broadcast(message WSMessage) {
 	}
 }
 
-// Run starts the hub loop
+// connectionCount returns the number of registered connections of a user
+func (hub *Hub) connectionCount(userID string) int {
+	hub.mutex.RLock()
+	defer hub.mutex.RUnlock()
+	count := 0
+	for client := range hub.clients {
+		if client.userID == userID {
+			count++
+		}
+	}
+	return count
+}
+
+// Run starts the hub loop. It never waits on a client, so one that stopped reading cannot hold up the others
 func (hub *Hub) Run() {
 	for {
 		select {
 		case client := <-hub.register:
+			// Connections opened at once may all pass the check of handleWebSocket
+			if hub.connectionCount(client.userID) >= hub.maximumConnectionsPerUser {
+				client.mutex.Lock()
+				client.shutdown(closeCodeTooManyConnections, "too many connections")
+				client.mutex.Unlock()
+				slog.Warn("WS client rejected over the connection limit", "userID", client.userID)
+				continue
+			}
 			hub.mutex.Lock()
 			hub.clients[client] = true
 			hub.mutex.Unlock()
@@ -79,10 +134,7 @@ func (hub *Hub) Run() {
 			if _, ok := hub.clients[client]; ok {
 				delete(hub.clients, client)
 				client.mutex.Lock()
-				if !client.closed {
-					client.closed = true
-					close(client.send)
-				}
+				client.shutdown(websocket.CloseNormalClosure, "")
 				client.mutex.Unlock()
 			}
 			hub.mutex.Unlock()
@@ -94,14 +146,10 @@ func (hub *Hub) Run() {
 			sentCount := 0
 
 			for client := range hub.clients {
-				if client.isSubscribed(wsMessage.Channel) {
-					select {
-					case client.send <- wsMessage:
-						sentCount++
-					default:
-						// If send channel is full, mark client for removal
-						toRemove = append(toRemove, client)
-					}
+				sent, dropped := hub.deliver(client, wsMessage)
+				sentCount += sent
+				if dropped {
+					toRemove = append(toRemove, client)
 				}
 			}
 			hub.mutex.RUnlock()
@@ -110,16 +158,7 @@ func (hub *Hub) Run() {
 			if len(toRemove) > 0 {
 				hub.mutex.Lock()
 				for _, client := range toRemove {
-					if _, ok := hub.clients[client]; ok {
-						delete(hub.clients, client)
-						client.mutex.Lock()
-						if !client.closed {
-							client.closed = true
-							close(client.send)
-						}
-						client.mutex.Unlock()
-						slog.Warn("WS client disconnected due to full buffer", "userID", client.userID)
-					}
+					delete(hub.clients, client)
 				}
 				hub.mutex.Unlock()
 			}
@@ -131,6 +170,31 @@ func (hub *Hub) Run() {
 	}
 }
 
+// deliver queues a copy of a message for each of its channels the client subscribed to or, when it subscribed
+// to none of them, a single one if it follows the events of the exam of the message. It returns the number of
+// copies queued and whether the client was dropped for being too slow
+func (hub *Hub) deliver(client *WSClient, wsMessage WSMessage) (int, bool) {
+	sentCount := 0
+	for _, channel := range append([]string{wsMessage.Channel}, wsMessage.alsoChannels...) {
+		if !client.isSubscribed(channel) {
+			continue
+		}
+		channelMessage := wsMessage
+		channelMessage.Channel = channel
+		if !client.enqueue(channelMessage) {
+			return sentCount, true
+		}
+		sentCount++
+	}
+	if sentCount == 0 && wsMessage.examID != "" && client.isSubscribed(examEventsChannel(wsMessage.examID)) {
+		if !client.enqueue(wsMessage) {
+			return 0, true
+		}
+		sentCount++
+	}
+	return sentCount, false
+}
+
 // WSClient represents a connected WebSocket client
 type WSClient struct {
 	hub           *Hub
@@ -141,6 +205,8 @@ type WSClient struct {
 	userID        string
 	mutex         sync.Mutex
 	closed        bool
+	closeCode     int    // Code of the close message writePump sends once send is closed
+	closeText     string // Reason of the close message
 }
 
 func (client *WSClient) isSubscribed(channel string) bool {
@@ -150,6 +216,40 @@ func (client *WSClient) isSubscribed(channel string) bool {
 	return exists
 }
 
+// enqueue queues a message for the client without blocking, reporting whether it was queued. A client whose
+// buffer is full is too slow to keep up and is disconnected with closeCodeSlowConsumer
+func (client *WSClient) enqueue(message any) bool {
+	client.mutex.Lock()
+	defer client.mutex.Unlock()
+	if client.closed {
+		return false
+	}
+	select {
+	case client.send <- message:
+		return true
+	default:
+		client.shutdown(closeCodeSlowConsumer, "too slow to keep up")
+		slog.Warn("WS client disconnected due to full buffer", "userID", client.userID)
+		return false
+	}
+}
+
+// shutdown stops the subscriptions of the client and closes its send channel, so writePump sends a close
+// message with the code and reason and ends the connection. The caller holds the client mutex
+func (client *WSClient) shutdown(closeCode int, closeText string) {
+	if client.closed {
+		return
+	}
+	client.closed = true
+	client.closeCode = closeCode
+	client.closeText = closeText
+	for channel, stopChannel := range client.subscriptions {
+		close(stopChannel)
+		delete(client.subscriptions, channel)
+	}
+	close(client.send)
+}
+
 // handleWebSocket handles the WebSocket connection upgrade
 func (server *Server) handleWebSocket(responseWriter http.ResponseWriter, request *http.Request) {
 	slog.Info("WebSocket connection attempt", "origin", request.Header.Get("Origin"), "remote", request.RemoteAddr)
@@ -158,10 +258,10 @@ func (server *Server) handleWebSocket(responseWriter http.ResponseWriter, reques
 	// Browsers always send cookies with WebSocket connections, even cross-origin.
 	// A stale HttpOnly cookie would shadow the valid query-param token if we used
 	// simple cookie extraction. getValidSessionToken handles this by validating each source.
-	sessionToken := server.getValidSessionToken(request)
-	if sessionToken == "" {
+	sessionToken, passwordChangeRequired := server.getValidSessionToken(request)
+	if sessionToken == "" || passwordChangeRequired {
 		slog.Warn("WebSocket rejected: no valid session token found")
-		server.writeError(responseWriter, http.StatusUnauthorized, "AUTHENTICATION_ERROR", "Authentication required", nil)
+		server.writeSessionError(responseWriter, passwordChangeRequired)
 		return
 	}
 
@@ -174,7 +274,16 @@ func (server *Server) handleWebSocket(responseWriter http.ResponseWriter, reques
 		return
 	}
 
+	if server.wsHub.connectionCount(userID) >= server.wsHub.maximumConnectionsPerUser {
+		slog.Warn("WebSocket rejected: too many connections", "userID", userID)
+		server.writeError(responseWriter, http.StatusTooManyRequests, "TOO_MANY_CONNECTIONS", "Too many open connections; close another tab and try again", map[string]any{
+			"maximum_connections": server.wsHub.maximumConnectionsPerUser,
+		})
+		return
+	}
+
 	slog.Info("WebSocket upgrading", "userID", userID)
+	upgrader := websocket.Upgrader{CheckOrigin: server.checkWebSocketOrigin}
 	connection, upgradeError := upgrader.Upgrade(responseWriter, request, nil)
 	if upgradeError != nil {
 		slog.Error("WebSocket upgrade failed", "error", upgradeError, "origin", request.Header.Get("Origin"))
@@ -186,7 +295,7 @@ func (server *Server) handleWebSocket(responseWriter http.ResponseWriter, reques
 		hub:           server.wsHub,
 		server:        server,
 		connection:    connection,
-		send:          make(chan any, 512), // Larger buffer
+		send:          make(chan any, webSocketSendBuffer),
 		subscriptions: make(map[string]chan bool),
 		userID:        userID,
 	}
@@ -194,21 +303,25 @@ func (server *Server) handleWebSocket(responseWriter http.ResponseWriter, reques
 	// Auto-subscribe to chat session if provided in query
 	if autoChatID := request.URL.Query().Get("subscribe_chat"); autoChatID != "" {
 		var exists bool
-		server.database.QueryRow("SELECT EXISTS(SELECT 1 FROM chat_sessions JOIN exams ON chat_sessions.exam_id = exams.id WHERE chat_sessions.id = ? AND exams.user_id = ?)", autoChatID, userID).Scan(&exists)
+		server.database.QueryRow("SELECT EXISTS(SELECT 1 FROM chat_sessions JOIN exams ON chat_sessions.exam_id = exams.id WHERE chat_sessions.id = ? AND "+visibleChatSessionAccess(models.ExamRoleViewer)+")", autoChatID, userID, userID).Scan(&exists)
 		if exists {
 			slog.Info("Auto-subscribing to chat", "sessionID", autoChatID, "userID", userID)
 			client.subscriptions["chat:"+autoChatID] = make(chan bool)
 		}
 	}
 
+	// Every client follows the system channel so announcement banners and queue pauses show up live
+	client.subscriptions["system"] = make(chan bool)
+
 	client.hub.register <- client
 
-	// Send handshake
-	client.send <- map[string]any{
+	// Send handshake, including the current system status so the banner is shown right away
+	client.enqueue(map[string]any{
 		"type":           "connected",
 		"timestamp":      time.Now().Format(time.RFC3339),
-		"server_version": "1.0.0",
-	}
+		"server_version": apiVersion,
+		"system_status":  server.systemStatus(),
+	})
 
 	go client.writePump()
 	go client.readPump()
@@ -220,11 +333,23 @@ func (client *WSClient) readPump() {
 		client.close()
 	}()
 
+	// A connection is idle once neither a message nor a pong answering the pings of writePump arrived for the
+	// idle timeout, such as when the browser tab or the network went away without closing it
+	client.connection.SetReadLimit(webSocketMaximumMessage)
+	client.connection.SetReadDeadline(time.Now().Add(client.hub.idleTimeout))
+	client.connection.SetPongHandler(func(string) error {
+		return client.connection.SetReadDeadline(time.Now().Add(client.hub.idleTimeout))
+	})
+
 	for {
 		_, message, err := client.connection.ReadMessage()
 		if err != nil {
+			if errors.Is(err, os.ErrDeadlineExceeded) {
+				slog.Info("WS client idle, disconnecting", "userID", client.userID)
+			}
 			break
 		}
+		client.connection.SetReadDeadline(time.Now().Add(client.hub.idleTimeout))
 
 		var envelope struct {
 			Type    string `json:"type"`
@@ -246,7 +371,7 @@ func (client *WSClient) readPump() {
 }
 
 func (client *WSClient) writePump() {
-	ticker := time.NewTicker(30 * time.Second)
+	ticker := time.NewTicker(client.hub.idleTimeout / 2)
 	defer func() {
 		ticker.Stop()
 		client.connection.Close()
@@ -255,10 +380,13 @@ func (client *WSClient) writePump() {
 	for {
 		select {
 		case wsMessage, isAvailable := <-client.send:
-			// Set a write deadline for every message
-			client.connection.SetWriteDeadline(time.Now().Add(10 * time.Second))
+			// Set a write deadline for every message, so a peer that stopped reading is dropped
+			client.connection.SetWriteDeadline(time.Now().Add(webSocketWriteTimeout))
 			if !isAvailable {
-				client.connection.WriteMessage(websocket.CloseMessage, []byte{})
+				client.mutex.Lock()
+				closeCode, closeText := client.closeCode, client.closeText
+				client.mutex.Unlock()
+				client.connection.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(closeCode, closeText))
 				return
 			}
 
@@ -272,7 +400,7 @@ func (client *WSClient) writePump() {
 			}
 
 		case <-ticker.C:
-			if err := client.connection.SetWriteDeadline(time.Now().Add(10 * time.Second)); err != nil {
+			if err := client.connection.SetWriteDeadline(time.Now().Add(webSocketWriteTimeout)); err != nil {
 				return
 			}
 			if err := client.connection.WriteMessage(websocket.PingMessage, nil); err != nil {
@@ -283,14 +411,12 @@ func (client *WSClient) writePump() {
 }
 
 func (client *WSClient) handleSubscribe(channel string) {
-	client.mutex.Lock()
-	defer client.mutex.Unlock()
-
-	if _, exists := client.subscriptions[channel]; exists {
+	// The client mutex is not held while the access is checked, as the hub waits on it to broadcast
+	if client.isSubscribed(channel) {
 		return
 	}
 
-	// Security Check: Ensure user owns the resource they are subscribing to
+	// Security Check: Ensure user has access to the resource they are subscribing to
 	if strings.HasPrefix(channel, "lecture:") {
 		lectureID := strings.TrimPrefix(channel, "lecture:")
 		var exists bool
@@ -298,17 +424,24 @@ func (client *WSClient) handleSubscribe(channel string) {
 			SELECT EXISTS(
 				SELECT 1 FROM lectures 
 				JOIN exams ON lectures.exam_id = exams.id 
-				WHERE lectures.id = ? AND exams.user_id = ?
+				WHERE lectures.id = ? AND `+examAccess(models.ExamRoleViewer)+`
 			)
 		`, lectureID, client.userID).Scan(&exists)
 		if !exists {
 			slog.Warn("Unauthorized subscription attempt to lecture", "userID", client.userID, "lectureID", lectureID)
 			return
 		}
+	} else if examID, isExamEvents := examOfEventsChannel(channel); isExamEvents {
+		var exists bool
+		client.server.database.QueryRow("SELECT EXISTS(SELECT 1 FROM exams WHERE id = ? AND "+examAccess(models.ExamRoleViewer)+")", examID, client.userID).Scan(&exists)
+		if !exists {
+			slog.Warn("Unauthorized subscription attempt to exam events", "userID", client.userID, "examID", examID)
+			return
+		}
 	} else if strings.HasPrefix(channel, "course:") {
 		courseID := strings.TrimPrefix(channel, "course:")
 		var exists bool
-		client.server.database.QueryRow("SELECT EXISTS(SELECT 1 FROM exams WHERE id = ? AND user_id = ?)", courseID, client.userID).Scan(&exists)
+		client.server.database.QueryRow("SELECT EXISTS(SELECT 1 FROM exams WHERE id = ? AND "+examAccess(models.ExamRoleViewer)+")", courseID, client.userID).Scan(&exists)
 		if !exists {
 			slog.Warn("Unauthorized subscription attempt to course", "userID", client.userID, "courseID", courseID)
 			return
@@ -317,32 +450,38 @@ func (client *WSClient) handleSubscribe(channel string) {
 		jobID := strings.TrimPrefix(channel, "job:")
 		job, err := client.server.jobQueue.GetJob(jobID)
 		if err != nil || job.UserID != client.userID {
-			slog.Warn("Unauthorized subscription attempt to job", "userID", client.userID, "jobID", jobID)
+			slog.Warn("Unauthorized subscription attempt to job", "userID", client.userID, "channel", channel)
 			return
 		}
 	} else if strings.HasPrefix(channel, "chat:") {
 		chatID := strings.TrimPrefix(channel, "chat:")
 		var exists bool
-		client.server.database.QueryRow("SELECT EXISTS(SELECT 1 FROM chat_sessions JOIN exams ON chat_sessions.exam_id = exams.id WHERE chat_sessions.id = ? AND exams.user_id = ?)", chatID, client.userID).Scan(&exists)
+		client.server.database.QueryRow("SELECT EXISTS(SELECT 1 FROM chat_sessions JOIN exams ON chat_sessions.exam_id = exams.id WHERE chat_sessions.id = ? AND "+visibleChatSessionAccess(models.ExamRoleViewer)+")", chatID, client.userID, client.userID).Scan(&exists)
 		if !exists {
 			slog.Warn("Unauthorized subscription attempt to chat", "userID", client.userID, "chatID", chatID)
 			return
 		}
 	}
 
+	client.mutex.Lock()
+	if _, exists := client.subscriptions[channel]; exists || client.closed {
+		client.mutex.Unlock()
+		return
+	}
 	stopChannel := make(chan bool)
 	client.subscriptions[channel] = stopChannel
+	client.mutex.Unlock()
 
 	if len(channel) > 4 && channel[:4] == "job:" {
 		jobID := channel[4:]
 		go client.monitorJob(jobID, stopChannel)
 	}
 
-	client.send <- map[string]any{
+	client.enqueue(map[string]any{
 		"type":      "subscribed",
 		"channel":   channel,
 		"timestamp": time.Now().Format(time.RFC3339),
-	}
+	})
 }
 
 func (client *WSClient) handleUnsubscribe(channel string) {
@@ -372,17 +511,23 @@ func (client *WSClient) monitorJob(jobID string, stopChannel chan bool) {
 			if !ok {
 				return
 			}
-			// Use non-blocking send to prevent goroutine leak if client buffer is full
-			select {
-			case client.send <- WSMessage{
+			// A client too slow to take the update is disconnected rather than waited for
+			if !client.enqueue(WSMessage{
 				Type:      "job:progress",
 				Channel:   "job:" + jobID,
 				Payload:   update,
 				Timestamp: time.Now().Format(time.RFC3339),
-			}:
-			default:
-				// Client buffer full, skip this update to prevent blocking
-				slog.Debug("Skipping job update - client buffer full", "jobID", jobID, "userID", client.userID)
+			}) {
+				return
+			}
+			// Failures are also announced on their own so frontends can act on the structured reason
+			if update.Status == "FAILED" && update.Failure != nil {
+				client.enqueue(WSMessage{
+					Type:      "job:failed",
+					Channel:   "job:" + jobID,
+					Payload:   map[string]any{"id": jobID, "type": update.Type, "error": update.Error, "failure": update.Failure},
+					Timestamp: time.Now().Format(time.RFC3339),
+				})
 			}
 			if update.Status == "COMPLETED" || update.Status == "FAILED" || update.Status == "CANCELLED" {
 				return
@@ -398,12 +543,6 @@ func (client *WSClient) close() {
 	if client.closed {
 		return
 	}
-	client.closed = true
-
-	for channel, stopChannel := range client.subscriptions {
-		close(stopChannel)
-		delete(client.subscriptions, channel)
-	}
-	close(client.send)
+	client.shutdown(websocket.CloseNormalClosure, "")
 	client.connection.Close()
 }
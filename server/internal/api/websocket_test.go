@@ -0,0 +1,151 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"lectures/internal/configuration"
+
+	"github.com/gorilla/websocket"
+)
+
+func TestWebSocketKeepaliveAndLimits(t *testing.T) {
+	server, userID, sessionID, cleanup := setupUniqueExtraTestEnv(t, "websocket_limits")
+	defer cleanup()
+
+	server.wsHub = NewHub(configuration.WebSocketConfiguration{MaximumConnectionsPerUser: 1})
+	server.wsHub.idleTimeout = 300 * time.Millisecond
+	go server.wsHub.Run()
+	testServer := httptest.NewServer(server.Handler())
+	defer testServer.Close()
+
+	websocketURL := "ws" + strings.TrimPrefix(testServer.URL, "http") + "/api/socket"
+	headers := http.Header{}
+	headers.Add("Authorization", "Bearer "+sessionID)
+	connect := func() *websocket.Conn {
+		connection, _, err := websocket.DefaultDialer.Dial(websocketURL, headers)
+		if err != nil {
+			t.Fatalf("WebSocket dial failed: %v", err)
+		}
+		var handshake map[string]any
+		if err := connection.ReadJSON(&handshake); err != nil || handshake["type"] != "connected" {
+			t.Fatalf("Expected the handshake, got %v (%v)", handshake, err)
+		}
+		return connection
+	}
+	waitForConnections := func(expected int) bool {
+		for start := time.Now(); time.Since(start) < 2*time.Second; time.Sleep(10 * time.Millisecond) {
+			if server.wsHub.connectionCount(userID) == expected {
+				return true
+			}
+		}
+		return false
+	}
+
+	// Pongs, answered while the client reads, keep the connection open past the idle timeout
+	connection := connect()
+	readError := make(chan error, 1)
+	go func() {
+		for {
+			if _, _, err := connection.ReadMessage(); err != nil {
+				readError <- err
+				return
+			}
+		}
+	}()
+	time.Sleep(time.Second)
+	select {
+	case err := <-readError:
+		t.Fatalf("Expected the connection to stay open while answering pings, got %v", err)
+	default:
+	}
+	if !waitForConnections(1) {
+		t.Fatalf("Expected one registered connection, got %d", server.wsHub.connectionCount(userID))
+	}
+
+	if _, response, err := websocket.DefaultDialer.Dial(websocketURL, headers); err == nil || response == nil || response.StatusCode != http.StatusTooManyRequests {
+		t.Errorf("Expected status 429 over the connection limit, got %v (%v)", response, err)
+	}
+	connection.Close()
+	if !waitForConnections(0) {
+		t.Fatalf("Expected the closed connection to be unregistered, got %d", server.wsHub.connectionCount(userID))
+	}
+
+	// A client that stops reading answers no pings and is disconnected once idle
+	idleConnection := connect()
+	defer idleConnection.Close()
+	if !waitForConnections(1) || !waitForConnections(0) {
+		t.Errorf("Expected the idle connection to be disconnected, got %d", server.wsHub.connectionCount(userID))
+	}
+
+	// A client whose buffer is full is dropped with the slow consumer close code rather than waited for
+	client := &WSClient{hub: server.wsHub, send: make(chan any, 1), subscriptions: map[string]chan bool{"system": make(chan bool)}}
+	if !client.enqueue("first") || client.enqueue("second") {
+		t.Fatal("Expected the second message to overflow the buffer")
+	}
+	if !client.closed || client.closeCode != websocket.CloseTryAgainLater || len(client.subscriptions) != 0 {
+		t.Errorf("Expected the slow client to be shut down with code %d, got %d", websocket.CloseTryAgainLater, client.closeCode)
+	}
+}
+
+func TestWebSocketExamSubscriptions(t *testing.T) {
+	server, userID, sessionID, cleanup := setupUniqueExtraTestEnv(t, "websocket_exam")
+	defer cleanup()
+
+	server.database.Exec("INSERT INTO users (id, username, password_hash, role) VALUES ('other-user', 'other', 'hash', 'teacher')")
+	server.database.Exec("INSERT INTO exams (id, user_id, title) VALUES ('own-exam', ?, 'Own'), ('other-exam', 'other-user', 'Other')", userID)
+	server.database.Exec("INSERT INTO lectures (id, exam_id, title) VALUES ('own-lecture', 'own-exam', 'Own'), ('other-lecture', 'other-exam', 'Other')")
+
+	testServer := httptest.NewServer(server.Handler())
+	defer testServer.Close()
+	headers := http.Header{}
+	headers.Add("Authorization", "Bearer "+sessionID)
+	connection, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(testServer.URL, "http")+"/api/socket", headers)
+	if err != nil {
+		t.Fatalf("WebSocket dial failed: %v", err)
+	}
+	defer connection.Close()
+	connection.SetReadDeadline(time.Now().Add(5 * time.Second))
+	readMessage := func() WSMessage {
+		var message WSMessage
+		if err := connection.ReadJSON(&message); err != nil {
+			t.Fatalf("Failed to read message: %v", err)
+		}
+		return message
+	}
+	readMessage() // Handshake
+
+	// The subscription to an exam of another user is not confirmed
+	connection.WriteJSON(map[string]string{"type": "subscribe", "channel": "exam:other-exam:*"})
+	connection.WriteJSON(map[string]string{"type": "subscribe", "channel": "exam:own-exam:*"})
+	if message := readMessage(); message.Type != "subscribed" || message.Channel != "exam:own-exam:*" {
+		t.Fatalf("Expected the own exam subscription to be confirmed, got %+v", message)
+	}
+
+	// Events of other exams are not received, and an event sent to the lecture, course and user channels of a
+	// job is received once
+	server.BroadcastEach([]string{"lecture:other-lecture", "course:other-exam"}, "job:progress", map[string]string{"id": "other-job"})
+	server.BroadcastEach([]string{"lecture:own-lecture", "course:own-exam", "user:" + userID}, "job:progress", map[string]string{"id": "own-job"})
+	server.Broadcast("course:own-exam", "tool:created", map[string]string{"tool_id": "own-tool"})
+	if message := readMessage(); message.Type != "job:progress" || message.Channel != "lecture:own-lecture" {
+		t.Errorf("Expected the job progress of the own lecture, got %+v", message)
+	}
+	if message := readMessage(); message.Type != "tool:created" || message.Channel != "course:own-exam" {
+		t.Errorf("Expected the tool event after a single job progress, got %+v", message)
+	}
+
+	// Subscribers of the lecture channel itself still get their copy
+	connection.WriteJSON(map[string]string{"type": "subscribe", "channel": "lecture:own-lecture"})
+	readMessage()
+	server.Broadcast("lecture:own-lecture", "lecture:updated", map[string]string{"lecture_id": "own-lecture"})
+	server.Broadcast("course:own-exam", "tool:created", map[string]string{"tool_id": "second-tool"})
+	if message := readMessage(); message.Type != "lecture:updated" || message.Channel != "lecture:own-lecture" {
+		t.Errorf("Expected the lecture update once, got %+v", message)
+	}
+	if message := readMessage(); message.Type != "tool:created" {
+		t.Errorf("Expected the tool event after a single lecture update, got %+v", message)
+	}
+}
@@ -9,57 +9,201 @@ import (
 )
 
 type Configuration struct {
-	Server            ServerConfiguration        `yaml:"server" json:"server"`
-	Storage           StorageConfiguration       `yaml:"storage" json:"storage"`
-	Security          SecurityConfiguration      `yaml:"security" json:"security"`
-	LLM               LLMConfiguration           `yaml:"llm" json:"llm"`
-	Transcription     TranscriptionConfiguration `yaml:"transcription" json:"transcription"`
-	Providers         ProvidersConfiguration     `yaml:"providers" json:"providers"`
-	Documents         DocumentsConfiguration     `yaml:"documents" json:"documents"`
-	Uploads           UploadsConfiguration       `yaml:"uploads" json:"uploads"`
-	Safety            SafetyConfiguration        `yaml:"safety" json:"safety"`
-	ConfigurationPath string                     `yaml:"-" json:"-"`
+	Server            ServerConfiguration          `yaml:"server" json:"server"`
+	Storage           StorageConfiguration         `yaml:"storage" json:"storage"`
+	Security          SecurityConfiguration        `yaml:"security" json:"security"`
+	LLM               LLMConfiguration             `yaml:"llm" json:"llm"`
+	Transcription     TranscriptionConfiguration   `yaml:"transcription" json:"transcription"`
+	Providers         ProvidersConfiguration       `yaml:"providers" json:"providers"`
+	Documents         DocumentsConfiguration       `yaml:"documents" json:"documents"`
+	Uploads           UploadsConfiguration         `yaml:"uploads" json:"uploads"`
+	Safety            SafetyConfiguration          `yaml:"safety" json:"safety"`
+	ImageGeneration   ImageGenerationConfiguration `yaml:"image_generation" json:"image_generation"`
+	Embeddings        EmbeddingsConfiguration      `yaml:"embeddings" json:"embeddings"`
+	Privacy           PrivacyConfiguration         `yaml:"privacy" json:"privacy"`
+	Jobs              JobsConfiguration            `yaml:"jobs" json:"jobs"`
+	Database          DatabaseConfiguration        `yaml:"database" json:"database"`
+	Backup            BackupConfiguration          `yaml:"backup" json:"backup"`
+	SMTP              SMTPConfiguration            `yaml:"smtp" json:"smtp"`
+	Webhooks          WebhooksConfiguration        `yaml:"webhooks" json:"webhooks"`
+	ConfigurationPath string                       `yaml:"-" json:"-"`
 }
 
 type SafetyConfiguration struct {
-	MaximumCostPerJob    float64 `yaml:"maximum_cost_per_job" json:"maximum_cost_per_job"`
-	MaximumLoginAttempts int     `yaml:"maximum_login_attempts_per_hour" json:"maximum_login_attempts_per_hour"`
-	MaximumRetries       int     `yaml:"maximum_retries" json:"maximum_retries"`
+	MaximumCostPerJob              float64 `yaml:"maximum_cost_per_job" json:"maximum_cost_per_job"`
+	MaximumLoginAttempts           int     `yaml:"maximum_login_attempts_per_hour" json:"maximum_login_attempts_per_hour"`       // Failed logins per hour that lock out an address
+	MaximumFailedLoginsPerUsername int     `yaml:"maximum_failed_logins_per_username" json:"maximum_failed_logins_per_username"` // Failed logins within the lockout window that lock out a username, 5 when 0
+	LoginLockoutMinutes            int     `yaml:"login_lockout_minutes" json:"login_lockout_minutes"`                           // 15 when 0
+	MaximumRetries                 int     `yaml:"maximum_retries" json:"maximum_retries"`
+	DailyBudgetPerUser             float64 `yaml:"daily_budget_per_user" json:"daily_budget_per_user"`     // Dollars a user may spend per day, 0 is unlimited
+	MonthlyBudgetPerUser           float64 `yaml:"monthly_budget_per_user" json:"monthly_budget_per_user"` // Dollars a user may spend per month, 0 is unlimited
 }
 
 type ServerConfiguration struct {
-	Host string `yaml:"host" json:"host"`
-	Port int    `yaml:"port" json:"port"`
+	Host          string `yaml:"host" json:"host"`
+	Port          int    `yaml:"port" json:"port"`
+	PublicBaseURL string `yaml:"public_base_url,omitempty" json:"public_base_url,omitempty"` // Address of the web app, used for links in exported files
+	// Path the server is reached under behind a reverse proxy, such as "/lectures"; stripped from requests
+	BasePath string `yaml:"base_path,omitempty" json:"base_path,omitempty"`
+	// Origins of the web apps allowed to call the API from a browser, such as "https://lectures.example.org",
+	// or "*" for any. When empty any origin may, and WebSockets accept only localhost and the server itself
+	AllowedOrigins []string `yaml:"allowed_origins,omitempty" json:"allowed_origins,omitempty"`
+	// Addresses or CIDR ranges of the reverse proxies whose X-Forwarded-For and X-Forwarded-Host headers are
+	// believed, so rate limits, lockouts and the audit log see the address of the client
+	TrustedProxies []string `yaml:"trusted_proxies,omitempty" json:"trusted_proxies,omitempty"`
+	// Serves HTTPS itself rather than behind a reverse proxy
+	TLS TLSConfiguration `yaml:"tls,omitempty" json:"tls,omitempty"`
+	// Keepalive and limits of the WebSocket connections of /api/socket
+	WebSocket WebSocketConfiguration `yaml:"websocket,omitempty" json:"websocket,omitempty"`
+	// Address of a copy of swagger-ui-dist that /api/docs loads, such as "/swagger-ui" under web_directory for
+	// offline use; the pinned unpkg release when empty
+	SwaggerUIURL string `yaml:"swagger_ui_url,omitempty" json:"swagger_ui_url,omitempty"`
+}
+
+// WebSocketConfiguration bounds how long a silent WebSocket connection is kept and how many one user may open
+type WebSocketConfiguration struct {
+	IdleTimeoutSeconds        int `yaml:"idle_timeout_seconds,omitempty" json:"idle_timeout_seconds,omitempty"`                 // Time without a pong or message before a connection is closed; 0 uses the default of 60
+	MaximumConnectionsPerUser int `yaml:"maximum_connections_per_user,omitempty" json:"maximum_connections_per_user,omitempty"` // Connections of a user at once, such as browser tabs; 0 uses the default of 10
+}
+
+// TLSConfiguration serves HTTPS with the certificate of files or, without them, with certificates obtained
+// through ACME, from Let's Encrypt by default, for a list of domains
+type TLSConfiguration struct {
+	CertificateFile        string   `yaml:"certificate_file,omitempty" json:"certificate_file,omitempty"`                 // PEM certificate chain
+	KeyFile                string   `yaml:"key_file,omitempty" json:"key_file,omitempty"`                                 // PEM private key
+	AutocertDomains        []string `yaml:"autocert_domains,omitempty" json:"autocert_domains,omitempty"`                 // Domains to obtain certificates for
+	AutocertEmail          string   `yaml:"autocert_email,omitempty" json:"autocert_email,omitempty"`                     // Contact of the account at the certificate authority
+	AutocertCacheDirectory string   `yaml:"autocert_cache_directory,omitempty" json:"autocert_cache_directory,omitempty"` // "certificates" in the data directory when empty
+	AutocertDirectoryURL   string   `yaml:"autocert_directory_url,omitempty" json:"autocert_directory_url,omitempty"`     // ACME directory, Let's Encrypt when empty
+	RedirectPort           int      `yaml:"redirect_port,omitempty" json:"redirect_port,omitempty"`                       // Port redirecting plain HTTP to HTTPS and answering ACME challenges, such as 80; 0 disables it
+}
+
+// Enabled reports whether the server serves HTTPS
+func (tlsConfiguration TLSConfiguration) Enabled() bool {
+	return tlsConfiguration.CertificateFile != "" || tlsConfiguration.KeyFile != "" || len(tlsConfiguration.AutocertDomains) > 0
 }
 
 type StorageConfiguration struct {
 	DataDirectory string `yaml:"data_directory" json:"data_directory"`
 	BinDirectory  string `yaml:"bin_directory,omitempty" json:"bin_directory,omitempty"`
 	WebDirectory  string `yaml:"web_directory,omitempty" json:"web_directory,omitempty"`
+	// Where each part of the data directory is kept; see storage.Layout
+	Layout StorageLayoutConfiguration `yaml:"layout,omitempty" json:"layout,omitempty"`
+	// Where the bytes of lecture media, page images and exports are kept; see storage.ObjectStore
+	Objects ObjectStorageConfiguration `yaml:"objects,omitempty" json:"objects,omitempty"`
+	// How many bytes of files users may keep; see database.StorageUsage
+	Quota StorageQuotaConfiguration `yaml:"quota,omitempty" json:"quota,omitempty"`
+}
+
+// StorageQuotaConfiguration caps the bytes of lecture media, documents, page images and exports, counting
+// uploads in progress. Limits of 0 are unlimited
+type StorageQuotaConfiguration struct {
+	PerUserMB      int `yaml:"per_user_megabytes" json:"per_user_megabytes"` // Megabytes the exams of each user may hold
+	GlobalMB       int `yaml:"global_megabytes" json:"global_megabytes"`     // Megabytes all exams may hold together
+	WarningPercent int `yaml:"warning_percent" json:"warning_percent"`       // Share of a quota past which uploads log a warning, default 90
+}
+
+// StorageLayoutConfiguration places the parts of the data directory. Relative paths are resolved against
+// data_directory, absolute ones put a part on another volume, and empty ones keep the default location
+type StorageLayoutConfiguration struct {
+	Database string `yaml:"database,omitempty" json:"database,omitempty"` // SQLite database file, "database.db"
+	Log      string `yaml:"log,omitempty" json:"log,omitempty"`           // Server log file, "server.log"
+	Lectures string `yaml:"lectures,omitempty" json:"lectures,omitempty"` // Lecture files, "files/lectures"
+	Exports  string `yaml:"exports,omitempty" json:"exports,omitempty"`   // Generated assets of tools, "files/exports"
+	Backups  string `yaml:"backups,omitempty" json:"backups,omitempty"`   // Backup archives, "backups"
+	Objects  string `yaml:"objects,omitempty" json:"objects,omitempty"`   // Objects of the local object store, "files/objects"
+}
+
+// ObjectStorageConfiguration chooses where the bytes of lecture media, reference page images and exports live:
+// "database" (the default) keeps them in BLOB columns, "local" in the objects part of the data directory and
+// "s3" in a bucket of an S3-compatible service such as MinIO
+type ObjectStorageConfiguration struct {
+	Backend string          `yaml:"backend,omitempty" json:"backend,omitempty"`
+	S3      S3Configuration `yaml:"s3,omitempty" json:"s3,omitempty"`
+}
+
+// S3Configuration reaches a bucket of an S3-compatible service
+type S3Configuration struct {
+	Endpoint        string `yaml:"endpoint,omitempty" json:"endpoint,omitempty"` // Service URL, "https://s3.<region>.amazonaws.com" when empty
+	Region          string `yaml:"region,omitempty" json:"region,omitempty"`     // Signing region, "us-east-1" when empty
+	Bucket          string `yaml:"bucket" json:"bucket"`
+	Prefix          string `yaml:"prefix,omitempty" json:"prefix,omitempty"` // Prepended to every object key, to share a bucket
+	AccessKeyID     string `yaml:"access_key_id" json:"-"`
+	SecretAccessKey string `yaml:"secret_access_key" json:"-"`
+	PathStyle       bool   `yaml:"path_style,omitempty" json:"path_style,omitempty"`           // Address the bucket in the path rather than the host name, as MinIO needs
+	PresignSeconds  int    `yaml:"presign_seconds,omitempty" json:"presign_seconds,omitempty"` // Lifetime of download URLs, 900 when 0
 }
 
 type SecurityConfiguration struct {
 	Auth AuthConfiguration `yaml:"auth" json:"auth"`
+	// Base64 AES-256 key encrypting the API keys users store; when empty, encryption.key in the data directory is
+	// used, generated on first start
+	EncryptionKey string                 `yaml:"encryption_key,omitempty" json:"-"`
+	RateLimits    RateLimitConfiguration `yaml:"rate_limits" json:"rate_limits"`
+}
+
+// RateLimitConfiguration throttles the API with token buckets. Sign-in routes are limited per address, the
+// authenticated API per user. A class without a rate is not limited
+type RateLimitConfiguration struct {
+	Auth   RateLimitRule `yaml:"auth" json:"auth"`     // Setup, registration, logins, single sign-on and password resets
+	Read   RateLimitRule `yaml:"read" json:"read"`     // GET requests
+	Write  RateLimitRule `yaml:"write" json:"write"`   // Other requests
+	Upload RateLimitRule `yaml:"upload" json:"upload"` // Chunks of staged uploads, which large files send many of
+}
+
+// RateLimitRule lets requests through at a steady rate, allowing bursts up to a number of requests
+type RateLimitRule struct {
+	RequestsPerMinute float64 `yaml:"requests_per_minute" json:"requests_per_minute"`
+	Burst             int     `yaml:"burst" json:"burst"` // The requests per minute when 0
 }
 
 type AuthConfiguration struct {
-	Type                string `yaml:"type" json:"type"`
-	SessionTimeoutHours int    `yaml:"session_timeout_hours" json:"session_timeout_hours"`
-	PasswordHash        string `yaml:"password_hash" json:"-"`
-	RequireHTTPS        bool   `yaml:"require_https" json:"require_https"`
+	Type                 string            `yaml:"type" json:"type"` // "session", or "oidc" to also sign in through OIDC
+	SessionTimeoutHours  int               `yaml:"session_timeout_hours" json:"session_timeout_hours"`
+	PasswordHash         string            `yaml:"password_hash" json:"-"`
+	RequireHTTPS         bool              `yaml:"require_https" json:"require_https"`
+	RegistrationRole     string            `yaml:"registration_role" json:"registration_role"`           // Role of self-registered accounts: teacher or student
+	PasswordResetURL     string            `yaml:"password_reset_url" json:"password_reset_url"`         // Page of the web app resetting a password, linked with "?token=" in emails; empty disables resets
+	PasswordResetMinutes int               `yaml:"password_reset_minutes" json:"password_reset_minutes"` // Lifetime of reset links, 60 when 0
+	OIDC                 OIDCConfiguration `yaml:"oidc" json:"oidc"`
+}
+
+// OIDCConfiguration is the OpenID Connect provider users sign in with when the auth type is "oidc"
+type OIDCConfiguration struct {
+	IssuerURL     string            `yaml:"issuer_url" json:"issuer_url"` // Its configuration is discovered from /.well-known/openid-configuration
+	ClientID      string            `yaml:"client_id" json:"client_id"`
+	ClientSecret  string            `yaml:"client_secret" json:"-"`
+	RedirectURL   string            `yaml:"redirect_url" json:"redirect_url"`     // Public URL of /api/auth/oidc/callback, registered with the provider
+	Scopes        []string          `yaml:"scopes" json:"scopes"`                 // "openid", "profile" and "email" when empty
+	UsernameClaim string            `yaml:"username_claim" json:"username_claim"` // "preferred_username" when empty, then the email and the subject
+	RoleClaim     string            `yaml:"role_claim" json:"role_claim"`         // Claim holding the groups or roles of the user, such as "groups"
+	RoleMapping   map[string]string `yaml:"role_mapping" json:"role_mapping"`     // Values of the role claim to the user role they grant
+	DefaultRole   string            `yaml:"default_role" json:"default_role"`     // Role when no mapping matches: teacher or student, the registration role when empty
+	RequireRole   bool              `yaml:"require_role" json:"require_role"`     // Refuse the users no mapping matches
 }
 
 type LLMConfiguration struct {
-	Provider                string              `yaml:"provider" json:"provider"`
-	Language                string              `yaml:"language" json:"language"`
-	EnableDocumentsMatching bool                `yaml:"enable_documents_matching" json:"enable_documents_matching"`
-	Models                  ModelsConfiguration `yaml:"models" json:"models"`
+	Provider                string                   `yaml:"provider" json:"provider"`
+	Language                string                   `yaml:"language" json:"language"`
+	EnableDocumentsMatching bool                     `yaml:"enable_documents_matching" json:"enable_documents_matching"`
+	Models                  ModelsConfiguration      `yaml:"models" json:"models"`
+	MaximumConcurrentCalls  int                      `yaml:"maximum_concurrent_calls" json:"maximum_concurrent_calls"` // LLM calls in flight at once, shared by all generation jobs
+	PromptExperiments       bool                     `yaml:"prompt_experiments" json:"prompt_experiments"`             // Assign registered prompt variants to generation jobs at random
+	Failover                LLMFailoverConfiguration `yaml:"failover" json:"failover"`
 
 	// Backwards compatibility (deprecated)
 	Model        string `yaml:"model,omitempty" json:"model,omitempty"`
 	DefaultModel string `yaml:"default_model,omitempty" json:"default_model,omitempty"`
 }
 
+// LLMFailoverConfiguration names the provider serving the model calls while the configured provider is down
+type LLMFailoverConfiguration struct {
+	Provider         string `yaml:"provider" json:"provider"`                   // "openrouter" or "ollama"; empty disables failover
+	Model            string `yaml:"model" json:"model"`                         // Model of the failover provider used for every task
+	FailureThreshold int    `yaml:"failure_threshold" json:"failure_threshold"` // Consecutive connection failures or server errors before failing over; 0 uses the default of 3
+	ProbeSeconds     int    `yaml:"probe_seconds" json:"probe_seconds"`         // Seconds between the calls probing whether the provider is back; 0 uses the default of 60
+}
+
 type ModelConfiguration struct {
 	Model    string `yaml:"model" json:"model"`
 	Provider string `yaml:"provider,omitempty" json:"provider,omitempty"`
@@ -172,6 +316,8 @@ type TranscriptionConfiguration struct {
 	Model                   string `yaml:"model,omitempty" json:"model,omitempty"` // Optional: defaults to llm.models.recording_transcription
 	AudioChunkLengthSeconds int    `yaml:"audio_chunk_length_seconds" json:"audio_chunk_length_seconds"`
 	RefiningBatchSize       int    `yaml:"refining_batch_size" json:"refining_batch_size"`
+	Diarize                 bool   `yaml:"diarize" json:"diarize"`                   // Default for transcription jobs that do not set diarize themselves
+	Device                  string `yaml:"device,omitempty" json:"device,omitempty"` // whisper-local only: "cpu" or "cuda"
 }
 
 // GetModel returns the model to use for transcription
@@ -185,15 +331,33 @@ func (transcriptionConfig *TranscriptionConfiguration) GetModel(llmConfig *LLMCo
 }
 
 type ProvidersConfiguration struct {
-	OpenRouter OpenRouterConfiguration `yaml:"openrouter" json:"openrouter"`
-	Ollama     OllamaConfiguration     `yaml:"ollama" json:"ollama"`
-	Google     GoogleConfiguration     `yaml:"google" json:"google"`
+	OpenRouter  OpenRouterConfiguration  `yaml:"openrouter" json:"openrouter"`
+	Ollama      OllamaConfiguration      `yaml:"ollama" json:"ollama"`
+	Google      GoogleConfiguration      `yaml:"google" json:"google"`
+	Deepgram    DeepgramConfiguration    `yaml:"deepgram" json:"deepgram"`
+	OpenAI      OpenAIConfiguration      `yaml:"openai" json:"openai"`
+	HuggingFace HuggingFaceConfiguration `yaml:"huggingface" json:"huggingface"`
 }
 
 type OpenRouterConfiguration struct {
 	APIKey string `yaml:"api_key" json:"api_key"`
 }
 
+type DeepgramConfiguration struct {
+	APIKey string `yaml:"api_key" json:"api_key"`
+}
+
+// OpenAIConfiguration holds credentials for OpenAI or any service exposing an OpenAI-compatible API
+type OpenAIConfiguration struct {
+	APIKey  string `yaml:"api_key" json:"api_key"`
+	BaseURL string `yaml:"base_url,omitempty" json:"base_url,omitempty"`
+}
+
+// HuggingFaceConfiguration holds the access token needed to download the gated pyannote diarization models
+type HuggingFaceConfiguration struct {
+	Token string `yaml:"token" json:"token"`
+}
+
 type OllamaConfiguration struct {
 	BaseURL string `yaml:"base_url" json:"base_url"`
 }
@@ -203,10 +367,98 @@ type GoogleConfiguration struct {
 	ClientSecret string `yaml:"client_secret" json:"client_secret"`
 }
 
+// ImageGenerationConfiguration controls the optional mnemonic image stage for flashcards
+type ImageGenerationConfiguration struct {
+	Provider             string  `yaml:"provider,omitempty" json:"provider,omitempty"` // "openai" (OpenAI-compatible images API); empty disables the stage
+	Model                string  `yaml:"model,omitempty" json:"model,omitempty"`
+	Size                 string  `yaml:"size,omitempty" json:"size,omitempty"`
+	MaximumImagesPerTool int     `yaml:"maximum_images_per_tool,omitempty" json:"maximum_images_per_tool,omitempty"`
+	CostPerImage         float64 `yaml:"cost_per_image,omitempty" json:"cost_per_image,omitempty"` // Used for cost estimation, providers do not report it
+}
+
+// EmbeddingsConfiguration controls retrieval of relevant lecture chunks for the chat
+type EmbeddingsConfiguration struct {
+	Provider            string `yaml:"provider,omitempty" json:"provider,omitempty"` // "openai" or "ollama"; empty sends whole lectures to the chat instead
+	Model               string `yaml:"model,omitempty" json:"model,omitempty"`
+	TopK                int    `yaml:"top_k,omitempty" json:"top_k,omitempty"`                                 // Chunks retrieved per question
+	ChunkSizeCharacters int    `yaml:"chunk_size_characters,omitempty" json:"chunk_size_characters,omitempty"` // Target size of transcript and page chunks
+}
+
+// PrivacyConfiguration controls what administrators can learn about other users
+type PrivacyConfiguration struct {
+	UsageStatistics UsageStatisticsConfiguration `yaml:"usage_statistics" json:"usage_statistics"`
+}
+
+// UsageStatisticsConfiguration is the privacy policy applied to the admin usage statistics
+type UsageStatisticsConfiguration struct {
+	Policy             string  `yaml:"policy" json:"policy"`                               // "disabled", "exact" (single-user deployments) or "private" (thresholds and noise)
+	MinimumGroupSize   int     `yaml:"minimum_group_size" json:"minimum_group_size"`       // Buckets with fewer distinct users are suppressed
+	Epsilon            float64 `yaml:"epsilon" json:"epsilon"`                             // Laplace noise budget per figure; smaller is noisier
+	EpsilonBudget      float64 `yaml:"epsilon_budget" json:"epsilon_budget"`               // Spent per UTC day by releases of new windows
+	MaximumCostPerUser float64 `yaml:"maximum_cost_per_user" json:"maximum_cost_per_user"` // Cap on one user's share of the released cost total
+}
+
 type DocumentsConfiguration struct {
-	RenderDPI        int      `yaml:"render_dots_per_inch" json:"render_dots_per_inch"`
-	MaximumPages     int      `yaml:"maximum_pages" json:"maximum_pages"`
-	SupportedFormats []string `yaml:"supported_formats" json:"supported_formats"`
+	RenderDPI              int      `yaml:"render_dots_per_inch" json:"render_dots_per_inch"`
+	MaximumPages           int      `yaml:"maximum_pages" json:"maximum_pages"`
+	SupportedFormats       []string `yaml:"supported_formats" json:"supported_formats"`
+	ChunkSizeCharacters    int      `yaml:"chunk_size_characters" json:"chunk_size_characters"`       // Target size of the semantic chunks of extracted text
+	ChunkOverlapCharacters int      `yaml:"chunk_overlap_characters" json:"chunk_overlap_characters"` // Text repeated from the end of the previous chunk
+	ExtractionMethod       string   `yaml:"extraction_method" json:"extraction_method"`               // "vision", "ocr", "auto" or "math"; documents can override it
+	VisionMaximumPages     int      `yaml:"vision_maximum_pages" json:"vision_maximum_pages"`         // Above this page count, "auto" reads documents without the vision model
+	PageParallelism        int      `yaml:"page_parallelism" json:"page_parallelism"`                 // Pages of a document read at once, within llm.maximum_concurrent_calls for model reads
+	ForeignQuotes          string   `yaml:"foreign_quotes" json:"foreign_quotes"`                     // "translate" or "verbatim": how generation quotes pages in another language than the tool
+}
+
+type JobsConfiguration struct {
+	Concurrency             map[string]int                      `yaml:"concurrency" json:"concurrency"`                               // Workers of each job pool: transcribe, ingest, build and publish
+	Scaling                 map[string]PoolScalingConfiguration `yaml:"scaling" json:"scaling"`                                       // Bounds within which a pool grows and shrinks with its queue; pools not listed keep their concurrency
+	DeadLetterRetentionDays int                                 `yaml:"dead_letter_retention_days" json:"dead_letter_retention_days"` // Days failed jobs are kept unless requeued; 0 uses the default of 30, a negative value keeps them
+	Handlers                JobHandlersConfiguration            `yaml:"handlers" json:"handlers"`                                     // Job types this server runs
+}
+
+// JobHandlersConfiguration chooses the job types a server runs, so a deployment can leave out heavy or unused ones
+type JobHandlersConfiguration struct {
+	Set      string   `yaml:"set" json:"set"`                               // "default" runs every type, "minimal" the lecture pipeline only, "custom" the enabled types
+	Enabled  []string `yaml:"enabled,omitempty" json:"enabled,omitempty"`   // Job types of the custom set
+	Disabled []string `yaml:"disabled,omitempty" json:"disabled,omitempty"` // Job types left out of any set
+}
+
+// DatabaseConfiguration schedules the upkeep that keeps the database small and its queries fast
+type DatabaseConfiguration struct {
+	MaintenanceIntervalHours int `yaml:"maintenance_interval_hours" json:"maintenance_interval_hours"` // Hours between runs of ANALYZE and VACUUM; 0 uses the default of 24, a negative value disables maintenance
+	JobCompactionDays        int `yaml:"job_compaction_days" json:"job_compaction_days"`               // Days after which finished jobs lose their payload and result; 0 uses the default of 90, a negative value keeps them
+	TranscriptArchiveDays    int `yaml:"transcript_archive_days" json:"transcript_archive_days"`       // Days a lecture stays untouched before its transcript segments are compressed; 0 disables archiving
+}
+
+// BackupConfiguration schedules the backups of the database and files and how long their archives are kept
+type BackupConfiguration struct {
+	IntervalHours int `yaml:"interval_hours" json:"interval_hours"` // Hours between scheduled backups; 0 only takes them on request
+	KeepCount     int `yaml:"keep_count" json:"keep_count"`         // Archives kept, newest first; 0 uses the default of 7, a negative value keeps them all
+	KeepDays      int `yaml:"keep_days" json:"keep_days"`           // Days after which archives are removed, the newest excepted; 0 keeps them regardless of age
+}
+
+// SMTPConfiguration is the mail server sending password reset emails. Port 465 uses implicit TLS, and other
+// ports upgrade with STARTTLS when the server offers it
+type SMTPConfiguration struct {
+	Host     string `yaml:"host" json:"host"` // Empty disables sending emails
+	Port     int    `yaml:"port" json:"port"` // 587 when 0
+	Username string `yaml:"username" json:"username"`
+	Password string `yaml:"password" json:"-"`
+	From     string `yaml:"from" json:"from"` // Sender address of the emails
+}
+
+// WebhooksConfiguration tunes how events are posted to the webhooks users register
+type WebhooksConfiguration struct {
+	MaximumAttempts       int  `yaml:"maximum_attempts" json:"maximum_attempts"`               // Attempts of a delivery before it is given up; 0 uses the default of 8
+	TimeoutSeconds        int  `yaml:"timeout_seconds" json:"timeout_seconds"`                 // Time a receiver has to answer; 0 uses the default of 10
+	DeliveryRetentionDays int  `yaml:"delivery_retention_days" json:"delivery_retention_days"` // Days the deliveries are logged; 0 uses the default of 30, a negative value keeps them
+	AllowPrivateNetworks  bool `yaml:"allow_private_networks" json:"allow_private_networks"`   // Also post to loopback and private addresses, such as a bot on the same host
+}
+
+type PoolScalingConfiguration struct {
+	MinimumWorkers int `yaml:"minimum_workers" json:"minimum_workers"`
+	MaximumWorkers int `yaml:"maximum_workers" json:"maximum_workers"`
 }
 
 type UploadsConfiguration struct {
@@ -231,6 +483,7 @@ type DocumentUploadConfiguration struct {
 	MaximumFilesPerLecture  int      `yaml:"maximum_files_per_lecture" json:"maximum_files_per_lecture"`
 	MaximumPagesPerDocument int      `yaml:"maximum_pages_per_document" json:"maximum_pages_per_document"`
 	SupportedFormats        []string `yaml:"supported_formats" json:"supported_formats"`
+	AllowPrivateNetworks    bool     `yaml:"allow_private_networks" json:"allow_private_networks"` // Also fetch webpages from loopback and private addresses, such as an intranet
 }
 
 // Load reads the configuration from a file or creates a default one
@@ -343,15 +596,29 @@ func defaultConfiguration(isLocal bool) *Configuration {
 		Server: ServerConfiguration{
 			Host: "0.0.0.0",
 			Port: 3000,
+			WebSocket: WebSocketConfiguration{
+				IdleTimeoutSeconds:        60,
+				MaximumConnectionsPerUser: 10,
+			},
 		},
 		Storage: StorageConfiguration{
 			DataDirectory: dataDir,
+			Quota: StorageQuotaConfiguration{
+				WarningPercent: 90,
+			},
 		},
 		Security: SecurityConfiguration{
 			Auth: AuthConfiguration{
 				Type:                "session",
 				SessionTimeoutHours: 72,
 				RequireHTTPS:        false,
+				RegistrationRole:    "teacher",
+			},
+			RateLimits: RateLimitConfiguration{
+				Auth:   RateLimitRule{RequestsPerMinute: 10, Burst: 10},
+				Read:   RateLimitRule{RequestsPerMinute: 600, Burst: 200},
+				Write:  RateLimitRule{RequestsPerMinute: 120, Burst: 60},
+				Upload: RateLimitRule{RequestsPerMinute: 600, Burst: 100},
 			},
 		},
 		LLM: LLMConfiguration{
@@ -359,6 +626,7 @@ func defaultConfiguration(isLocal bool) *Configuration {
 			Model:                   "google/gemini-3-flash-preview",
 			Language:                "en-US",
 			EnableDocumentsMatching: false,
+			MaximumConcurrentCalls:  3,
 			Models: ModelsConfiguration{
 				RecordingTranscription: ModelConfiguration{Model: "google/gemini-2.5-flash-lite"},
 				DocumentsIngestion:     ModelConfiguration{Model: "google/gemini-2.5-flash-lite"},
@@ -388,9 +656,15 @@ func defaultConfiguration(isLocal bool) *Configuration {
 			},
 		},
 		Documents: DocumentsConfiguration{
-			RenderDPI:        200,
-			MaximumPages:     1000,
-			SupportedFormats: []string{"pdf", "pptx", "docx"},
+			RenderDPI:              200,
+			MaximumPages:           1000,
+			SupportedFormats:       []string{"pdf", "pptx", "key", "docx", "jpg", "jpeg", "png", "heic", "epub", "html", "htm"},
+			ChunkSizeCharacters:    2000,
+			ChunkOverlapCharacters: 200,
+			ExtractionMethod:       "vision",
+			VisionMaximumPages:     200,
+			PageParallelism:        4,
+			ForeignQuotes:          "translate",
 		},
 		Uploads: UploadsConfiguration{
 			Media: MediaUploadConfiguration{
@@ -406,13 +680,50 @@ func defaultConfiguration(isLocal bool) *Configuration {
 				MaximumFileSizeMB:       500,
 				MaximumFilesPerLecture:  50,
 				MaximumPagesPerDocument: 500,
-				SupportedFormats:        []string{"pdf", "pptx", "docx"},
+				SupportedFormats:        []string{"pdf", "pptx", "key", "docx", "jpg", "jpeg", "png", "heic", "epub", "html", "htm"},
 			},
 		},
 		Safety: SafetyConfiguration{
-			MaximumCostPerJob:    15.0,
-			MaximumLoginAttempts: 10,
-			MaximumRetries:       3,
+			MaximumCostPerJob:              15.0,
+			MaximumLoginAttempts:           10,
+			MaximumFailedLoginsPerUsername: 5,
+			LoginLockoutMinutes:            15,
+			MaximumRetries:                 3,
+		},
+		Privacy: PrivacyConfiguration{
+			UsageStatistics: UsageStatisticsConfiguration{
+				Policy:             "private",
+				MinimumGroupSize:   5,
+				Epsilon:            1.0,
+				EpsilonBudget:      30,
+				MaximumCostPerUser: 10,
+			},
+		},
+		Jobs: JobsConfiguration{
+			Concurrency: map[string]int{
+				"transcribe": 1,
+				"ingest":     2,
+				"build":      2,
+				"publish":    4,
+			},
+			Scaling: map[string]PoolScalingConfiguration{
+				"ingest":  {MinimumWorkers: 1, MaximumWorkers: 4},
+				"build":   {MinimumWorkers: 1, MaximumWorkers: 4},
+				"publish": {MinimumWorkers: 2, MaximumWorkers: 8},
+			},
+			DeadLetterRetentionDays: 30,
+		},
+		Database: DatabaseConfiguration{
+			MaintenanceIntervalHours: 24,
+			JobCompactionDays:        90,
+		},
+		Backup: BackupConfiguration{
+			KeepCount: 7,
+		},
+		Webhooks: WebhooksConfiguration{
+			MaximumAttempts:       8,
+			TimeoutSeconds:        10,
+			DeliveryRetentionDays: 30,
 		},
 	}
 }
@@ -0,0 +1,100 @@
+package database
+
+import (
+	"database/sql"
+	"encoding/json"
+	"time"
+
+	"lectures/internal/models"
+)
+
+// AuditEventFilter narrows the audit log. Empty fields do not filter
+type AuditEventFilter struct {
+	ActorID       string
+	ActorUsername string
+	IPAddress     string
+	Action        string
+	ResourceType  string
+	ResourceID    string
+	BeforeID      int64 // Only events older than this one, to page through the log
+	Limit         int
+}
+
+// RecordAuditEvent adds an event to the audit log
+func RecordAuditEvent(database *sql.DB, event models.AuditEvent) error {
+	var detailsJSON any
+	if len(event.Details) > 0 {
+		encodedDetails, _ := json.Marshal(event.Details)
+		detailsJSON = string(encodedDetails)
+	}
+	_, err := database.Exec(`
+		INSERT INTO audit_events (action, actor_id, actor_username, ip_address, resource_type, resource_id, status, details, payload_digest, created_at)
+		VALUES (?, NULLIF(?, ''), NULLIF(?, ''), NULLIF(?, ''), NULLIF(?, ''), NULLIF(?, ''), NULLIF(?, 0), ?, NULLIF(?, ''), ?)
+	`, event.Action, event.ActorID, event.ActorUsername, event.IPAddress, event.ResourceType, event.ResourceID, event.Status, detailsJSON, event.PayloadDigest, time.Now())
+	return err
+}
+
+// ListAuditEvents lists the audit log, newest first, with the current username of the actors of events that did
+// not record one
+func ListAuditEvents(database *sql.DB, filter AuditEventFilter) ([]models.AuditEvent, error) {
+	query := `
+		SELECT audit_events.id, audit_events.action, COALESCE(audit_events.actor_id, ''),
+		       COALESCE(audit_events.actor_username, users.username, ''), COALESCE(audit_events.ip_address, ''),
+		       COALESCE(audit_events.resource_type, ''), COALESCE(audit_events.resource_id, ''), COALESCE(audit_events.status, 0),
+		       audit_events.details, COALESCE(audit_events.payload_digest, ''), audit_events.created_at
+		FROM audit_events
+		LEFT JOIN users ON audit_events.actor_id = users.id
+		WHERE 1 = 1`
+	var arguments []any
+	if filter.ActorID != "" {
+		query += " AND audit_events.actor_id = ?"
+		arguments = append(arguments, filter.ActorID)
+	}
+	if filter.ActorUsername != "" {
+		query += " AND COALESCE(audit_events.actor_username, users.username) = ?"
+		arguments = append(arguments, filter.ActorUsername)
+	}
+	if filter.IPAddress != "" {
+		query += " AND audit_events.ip_address = ?"
+		arguments = append(arguments, filter.IPAddress)
+	}
+	if filter.Action != "" {
+		query += " AND audit_events.action = ?"
+		arguments = append(arguments, filter.Action)
+	}
+	if filter.ResourceType != "" {
+		query += " AND audit_events.resource_type = ?"
+		arguments = append(arguments, filter.ResourceType)
+	}
+	if filter.ResourceID != "" {
+		query += " AND audit_events.resource_id = ?"
+		arguments = append(arguments, filter.ResourceID)
+	}
+	if filter.BeforeID > 0 {
+		query += " AND audit_events.id < ?"
+		arguments = append(arguments, filter.BeforeID)
+	}
+	query += " ORDER BY audit_events.id DESC LIMIT ?"
+	arguments = append(arguments, filter.Limit)
+
+	rows, err := database.Query(query, arguments...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	events := []models.AuditEvent{}
+	for rows.Next() {
+		var event models.AuditEvent
+		var detailsJSON sql.NullString
+		if err := rows.Scan(&event.ID, &event.Action, &event.ActorID, &event.ActorUsername, &event.IPAddress, &event.ResourceType,
+			&event.ResourceID, &event.Status, &detailsJSON, &event.PayloadDigest, &event.CreatedAt); err != nil {
+			return nil, err
+		}
+		if detailsJSON.Valid && detailsJSON.String != "" {
+			json.Unmarshal([]byte(detailsJSON.String), &event.Details)
+		}
+		events = append(events, event)
+	}
+	return events, rows.Err()
+}
@@ -0,0 +1,164 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+)
+
+// Snapshot writes a consistent copy of the database to a new file while it is in use
+func Snapshot(database *sql.DB, path string) error {
+	_, err := database.Exec("VACUUM INTO ?", path)
+	return err
+}
+
+// SchemaVersion returns the latest migration applied to a database, 0 when none was
+func SchemaVersion(database *sql.DB) (int, error) {
+	if err := ensureMigrationsTable(database); err != nil {
+		return 0, err
+	}
+	var version int
+	err := database.QueryRow("SELECT COALESCE(MAX(version), 0) FROM schema_migrations").Scan(&version)
+	return version, err
+}
+
+// ValidateSnapshot checks that a database file is intact and was written by a server whose schema this one
+// knows, then migrates it to the current schema
+func ValidateSnapshot(path string) error {
+	snapshot, err := Open(path)
+	if err != nil {
+		return fmt.Errorf("backup database cannot be opened: %w", err)
+	}
+	defer snapshot.Close()
+
+	var integrity string
+	if err := snapshot.QueryRow("PRAGMA integrity_check").Scan(&integrity); err != nil {
+		return fmt.Errorf("backup database cannot be checked: %w", err)
+	}
+	if integrity != "ok" {
+		return fmt.Errorf("backup database is corrupted: %s", integrity)
+	}
+
+	var userTables int
+	snapshot.QueryRow("SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name IN ('users', 'exams', 'lectures')").Scan(&userTables)
+	if userTables != 3 {
+		return fmt.Errorf("backup database is not a database of this server")
+	}
+
+	if err := Migrate(snapshot, 0); err != nil {
+		return fmt.Errorf("backup database cannot be migrated: %w", err)
+	}
+	return nil
+}
+
+// RestoreSnapshot replaces the content of every table of the database with the one of a validated snapshot,
+// in a single transaction, so readers see either the old data or the restored one. Columns the snapshot lacks
+// keep their defaults. Jobs the snapshot has running are failed, no worker running them anymore
+func RestoreSnapshot(database *sql.DB, snapshotPath string) error {
+	restoreContext := context.Background()
+	// Foreign keys and attached databases are set per connection, and foreign keys cannot be switched off
+	// inside a transaction
+	connection, err := database.Conn(restoreContext)
+	if err != nil {
+		return err
+	}
+	defer connection.Close()
+
+	if _, err := connection.ExecContext(restoreContext, "PRAGMA foreign_keys = OFF"); err != nil {
+		return err
+	}
+	defer connection.ExecContext(restoreContext, "PRAGMA foreign_keys = ON")
+	if _, err := connection.ExecContext(restoreContext, "ATTACH DATABASE ? AS snapshot", snapshotPath); err != nil {
+		return fmt.Errorf("failed to attach backup database: %w", err)
+	}
+	defer connection.ExecContext(restoreContext, "DETACH DATABASE snapshot")
+
+	tables, err := restorableTables(restoreContext, connection)
+	if err != nil {
+		return err
+	}
+
+	transaction, err := connection.BeginTx(restoreContext, nil)
+	if err != nil {
+		return err
+	}
+	defer transaction.Rollback()
+
+	for _, table := range tables {
+		columns, err := sharedColumns(restoreContext, transaction, table)
+		if err != nil {
+			return err
+		}
+		if _, err := transaction.ExecContext(restoreContext, `DELETE FROM main."`+table+`"`); err != nil {
+			return fmt.Errorf("failed to clear %s: %w", table, err)
+		}
+		if len(columns) == 0 {
+			continue
+		}
+		columnList := `"` + strings.Join(columns, `", "`) + `"`
+		if _, err := transaction.ExecContext(restoreContext, `INSERT INTO main."`+table+`" (`+columnList+`) SELECT `+columnList+` FROM snapshot."`+table+`"`); err != nil {
+			return fmt.Errorf("failed to restore %s: %w", table, err)
+		}
+	}
+
+	if _, err := transaction.ExecContext(restoreContext, "UPDATE jobs SET status = 'FAILED', error = 'Interrupted by a restore from backup' WHERE status = 'RUNNING'"); err != nil {
+		return err
+	}
+
+	var violations int
+	if err := transaction.QueryRowContext(restoreContext, "SELECT COUNT(*) FROM pragma_foreign_key_check").Scan(&violations); err != nil {
+		return err
+	}
+	if violations > 0 {
+		return fmt.Errorf("backup database has %d rows referencing missing rows", violations)
+	}
+	return transaction.Commit()
+}
+
+// restorableTables lists the tables of the live database a restore replaces: every table but SQLite's own and
+// the record of applied migrations, which both databases share after validation
+func restorableTables(restoreContext context.Context, connection *sql.Conn) ([]string, error) {
+	rows, err := connection.QueryContext(restoreContext, `
+		SELECT name FROM main.sqlite_master
+		WHERE type = 'table' AND name NOT LIKE 'sqlite_%' AND name != 'schema_migrations'
+		ORDER BY name
+	`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var tables []string
+	for rows.Next() {
+		var table string
+		if err := rows.Scan(&table); err != nil {
+			return nil, err
+		}
+		tables = append(tables, table)
+	}
+	return tables, rows.Err()
+}
+
+// sharedColumns lists the columns a table has in both the live database and the snapshot
+func sharedColumns(restoreContext context.Context, transaction *sql.Tx, table string) ([]string, error) {
+	rows, err := transaction.QueryContext(restoreContext, `
+		SELECT live.name FROM pragma_table_info(?, 'main') AS live
+		JOIN pragma_table_info(?, 'snapshot') AS snapshot ON snapshot.name = live.name
+		ORDER BY live.cid
+	`, table, table)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var columns []string
+	for rows.Next() {
+		var column string
+		if err := rows.Scan(&column); err != nil {
+			return nil, err
+		}
+		columns = append(columns, column)
+	}
+	return columns, rows.Err()
+}
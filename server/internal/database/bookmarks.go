@@ -0,0 +1,31 @@
+package database
+
+import (
+	"database/sql"
+
+	"lectures/internal/models"
+)
+
+// ListLectureBookmarks returns the bookmarks a user placed on a lecture, in the order of the lecture
+func ListLectureBookmarks(database *sql.DB, lectureID string, userID string) ([]models.LectureBookmark, error) {
+	rows, err := database.Query(`
+		SELECT id, lecture_id, user_id, name, millisecond, created_at, updated_at
+		FROM lecture_bookmarks
+		WHERE lecture_id = ? AND user_id = ?
+		ORDER BY millisecond, created_at
+	`, lectureID, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	bookmarks := []models.LectureBookmark{}
+	for rows.Next() {
+		var bookmark models.LectureBookmark
+		if err := rows.Scan(&bookmark.ID, &bookmark.LectureID, &bookmark.UserID, &bookmark.Name, &bookmark.Millisecond, &bookmark.CreatedAt, &bookmark.UpdatedAt); err != nil {
+			return nil, err
+		}
+		bookmarks = append(bookmarks, bookmark)
+	}
+	return bookmarks, rows.Err()
+}
@@ -7,8 +7,23 @@ import (
 	_ "modernc.org/sqlite"
 )
 
-// Initialize creates and initializes the SQLite database
+// Initialize opens the SQLite database and brings its schema up to date
 func Initialize(path string) (*sql.DB, error) {
+	database, err := Open(path)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := Migrate(database, 0); err != nil {
+		database.Close()
+		return nil, fmt.Errorf("failed to migrate schema: %w", err)
+	}
+
+	return database, nil
+}
+
+// Open opens the SQLite database without touching its schema
+func Open(path string) (*sql.DB, error) {
 	database, err := sql.Open("sqlite", path+"?_pragma=foreign_keys(1)&_pragma=journal_mode(WAL)&_pragma=busy_timeout(10000)&_pragma=synchronous(NORMAL)&_pragma=cache_size(1000000000)&_pragma=locking_mode(NORMAL)&_pragma=temp_store(memory)&_pragma=datetime_format(rfc3339)")
 	if err != nil {
 		return nil, fmt.Errorf("failed to open database: %w", err)
@@ -25,14 +40,11 @@ func Initialize(path string) (*sql.DB, error) {
 		return nil, fmt.Errorf("failed to ping database: %w", err)
 	}
 
-	// Create schema
-	if err := createSchema(database); err != nil {
-		return nil, fmt.Errorf("failed to create schema: %w", err)
-	}
-
 	return database, nil
 }
 
+// createSchema creates the base schema, the state of databases created before migrations were versioned. New
+// schema changes are versioned migrations, see migrations.go
 func createSchema(database *sql.DB) error {
 	schema := `
 	-- Users
@@ -57,6 +69,23 @@ func createSchema(database *sql.DB) error {
 		updated_at DATETIME DEFAULT CURRENT_TIMESTAMP
 	);
 
+	-- Users an exam is shared with and their role: a viewer reads its materials, a generator also queues jobs
+	-- spending their own budget, and a manager also edits and deletes the exam and its contents
+	CREATE TABLE IF NOT EXISTS exam_members (
+		exam_id TEXT NOT NULL REFERENCES exams(id) ON DELETE CASCADE,
+		user_id TEXT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+		role TEXT CHECK(role IN ('viewer', 'generator', 'manager')) NOT NULL,
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		PRIMARY KEY (exam_id, user_id)
+	);
+
+	-- Everyone with access to an exam and the rank of their role, the owner ranking above every member: handlers
+	-- authorize a request by requiring a minimum rank
+	CREATE VIEW IF NOT EXISTS exam_access AS
+		SELECT id AS exam_id, user_id, 'owner' AS role, 4 AS rank FROM exams
+		UNION ALL
+		SELECT exam_id, user_id, role, CASE role WHEN 'manager' THEN 3 WHEN 'generator' THEN 2 ELSE 1 END AS rank FROM exam_members;
+
 	-- Lectures belong to Exams
 	CREATE TABLE IF NOT EXISTS lectures (
 		id TEXT PRIMARY KEY,
@@ -110,6 +139,101 @@ func createSchema(database *sql.DB) error {
 		speaker TEXT
 	);
 
+	-- Segments of transcripts left untouched for a long time, moved out of transcript_segments as gzip-compressed
+	-- JSON to keep the table small. They are restored before anything reads the transcript again
+	CREATE TABLE IF NOT EXISTS transcript_archives (
+		transcript_id TEXT PRIMARY KEY REFERENCES transcripts(id) ON DELETE CASCADE,
+		segments BLOB NOT NULL,
+		segment_count INTEGER NOT NULL,
+		archived_at DATETIME DEFAULT CURRENT_TIMESTAMP
+	);
+
+	-- Transcription checkpoints: the final segments of every completed audio chunk, so an interrupted
+	-- transcription resumes where it stopped. Times are relative to the start of the media file
+	CREATE TABLE IF NOT EXISTS transcript_chunks (
+		transcript_id TEXT NOT NULL REFERENCES transcripts(id) ON DELETE CASCADE,
+		media_id TEXT NOT NULL REFERENCES lecture_media(id) ON DELETE CASCADE,
+		layout TEXT NOT NULL,
+		chunk_index INTEGER NOT NULL,
+		segments JSON NOT NULL,
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		PRIMARY KEY (transcript_id, media_id, layout, chunk_index)
+	);
+
+	-- Staged upload sessions; the data itself stays on disk until it is bound to a lecture
+	CREATE TABLE IF NOT EXISTS uploads (
+		id TEXT PRIMARY KEY,
+		user_id TEXT REFERENCES users(id) ON DELETE CASCADE,
+		filename TEXT,
+		file_size_bytes INTEGER DEFAULT 0,
+		bytes_received INTEGER DEFAULT 0,
+		status TEXT CHECK(status IN ('uploading', 'staged')) DEFAULT 'uploading',
+		last_chunk_at DATETIME,
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		updated_at DATETIME DEFAULT CURRENT_TIMESTAMP
+	);
+
+	-- Named export presets; exam_id is NULL for presets available in every exam
+	CREATE TABLE IF NOT EXISTS export_presets (
+		id TEXT PRIMARY KEY,
+		user_id TEXT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+		exam_id TEXT REFERENCES exams(id) ON DELETE CASCADE,
+		name TEXT NOT NULL,
+		formats JSON NOT NULL,
+		theme TEXT,
+		include_images BOOLEAN DEFAULT 1,
+		include_qr_code BOOLEAN DEFAULT 0,
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		updated_at DATETIME DEFAULT CURRENT_TIMESTAMP
+	);
+
+	-- Processing report of a lecture (JSON-encoded models.ProcessingReport), regenerated whenever the lecture becomes ready
+	CREATE TABLE IF NOT EXISTS lecture_reports (
+		lecture_id TEXT PRIMARY KEY REFERENCES lectures(id) ON DELETE CASCADE,
+		report JSON NOT NULL,
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+	);
+
+	-- Short recap of a lecture (JSON array of bullets) for dashboards and widgets, regenerated when the
+	-- transcript or documents change; content_signature identifies the content it was generated from
+	CREATE TABLE IF NOT EXISTS lecture_recaps (
+		lecture_id TEXT PRIMARY KEY REFERENCES lectures(id) ON DELETE CASCADE,
+		bullets JSON NOT NULL,
+		language TEXT,
+		model TEXT,
+		content_signature TEXT NOT NULL,
+		estimated_cost REAL DEFAULT 0,
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+	);
+
+	-- Material covered in more than one lecture of an exam (re-used slides, repeated explanations), found by the
+	-- ANALYZE_DUPLICATES job; occurrences is a JSON array and canonical_index points at the clearest occurrence
+	CREATE TABLE IF NOT EXISTS content_overlaps (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		exam_id TEXT NOT NULL REFERENCES exams(id) ON DELETE CASCADE,
+		topic TEXT NOT NULL,
+		similarity REAL NOT NULL,
+		occurrences JSON NOT NULL,
+		canonical_index INTEGER NOT NULL DEFAULT 0,
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+	);
+
+	-- Embedded transcript and reference page chunks used to retrieve chat context; vectors are little-endian float32
+	CREATE TABLE IF NOT EXISTS embedding_chunks (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		lecture_id TEXT NOT NULL REFERENCES lectures(id) ON DELETE CASCADE,
+		source_type TEXT CHECK(source_type IN ('transcript', 'slide')) NOT NULL,
+		source_id TEXT NOT NULL,
+		label TEXT NOT NULL,
+		page_number INTEGER,
+		start_millisecond INTEGER,
+		end_millisecond INTEGER,
+		content TEXT NOT NULL,
+		model TEXT NOT NULL,
+		vector BLOB NOT NULL,
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+	);
+
 	-- Reference Documents: PDFs, PowerPoints, etc. (zero or more per lecture)
 	CREATE TABLE IF NOT EXISTS reference_documents (
 		id TEXT PRIMARY KEY,
@@ -135,6 +259,18 @@ func createSchema(database *sql.DB) error {
 		UNIQUE(document_id, page_number)
 	);
 
+	-- Semantic chunks of the extracted document text, used as context units by generation and chat
+	CREATE TABLE IF NOT EXISTS reference_chunks (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		document_id TEXT NOT NULL REFERENCES reference_documents(id) ON DELETE CASCADE,
+		chunk_index INTEGER NOT NULL,
+		start_page INTEGER NOT NULL,
+		end_page INTEGER NOT NULL,
+		heading TEXT,
+		content TEXT NOT NULL,
+		UNIQUE(document_id, chunk_index)
+	);
+
 	-- Generated tools (study guides, flashcards, etc., now associated with a specific Lecture)
 	CREATE TABLE IF NOT EXISTS tools (
 		id TEXT PRIMARY KEY,
@@ -149,6 +285,38 @@ func createSchema(database *sql.DB) error {
 		updated_at DATETIME DEFAULT CURRENT_TIMESTAMP
 	);
 
+	-- Content of tools before an edit, a regeneration or a deletion replaced it, so it can be restored. tool_id
+	-- is not a foreign key since regenerated and deleted tools lose their row
+	CREATE TABLE IF NOT EXISTS tool_versions (
+		id TEXT PRIMARY KEY,
+		tool_id TEXT NOT NULL,
+		exam_id TEXT NOT NULL REFERENCES exams(id) ON DELETE CASCADE,
+		lecture_id TEXT REFERENCES lectures(id) ON DELETE CASCADE,
+		type TEXT NOT NULL,
+		title TEXT NOT NULL,
+		language_code TEXT,
+		content JSON NOT NULL,
+		reason TEXT NOT NULL,
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+	);
+
+	-- History of an exam: what happened to it, its lectures and their documents, media, transcripts, tools and
+	-- exports. lecture_id and resource_id are not foreign keys so deletions stay in the history
+	CREATE TABLE IF NOT EXISTS resource_events (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		exam_id TEXT NOT NULL REFERENCES exams(id) ON DELETE CASCADE,
+		lecture_id TEXT,
+		resource_type TEXT NOT NULL,
+		resource_id TEXT NOT NULL,
+		action TEXT NOT NULL,
+		summary TEXT NOT NULL,
+		user_id TEXT REFERENCES users(id) ON DELETE SET NULL,
+		job_id TEXT,
+		version_id TEXT,
+		undo JSON,
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+	);
+
 	CREATE TABLE IF NOT EXISTS tool_source_references (
 		id INTEGER PRIMARY KEY AUTOINCREMENT,
 		tool_id TEXT NOT NULL REFERENCES tools(id) ON DELETE CASCADE,
@@ -157,6 +325,77 @@ func createSchema(database *sql.DB) error {
 		metadata JSON
 	);
 
+	-- Intermediate markdown of generated study guides, stored as it is accepted: the outline at level 1 and each
+	-- section at level 2 under it. Rows are kept by job_id until the guide is stored and tool_id is set, so an
+	-- interrupted generation can resume from them
+	CREATE TABLE IF NOT EXISTS tool_sections (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		job_id TEXT NOT NULL,
+		tool_id TEXT REFERENCES tools(id) ON DELETE CASCADE,
+		lecture_id TEXT NOT NULL REFERENCES lectures(id) ON DELETE CASCADE,
+		parent_id INTEGER REFERENCES tool_sections(id) ON DELETE CASCADE,
+		level INTEGER NOT NULL,
+		position INTEGER NOT NULL DEFAULT 0,
+		title TEXT NOT NULL,
+		coverage TEXT,
+		content TEXT NOT NULL,
+		model TEXT,
+		attempts INTEGER DEFAULT 0,
+		adherence_score INTEGER,
+		input_tokens INTEGER DEFAULT 0,
+		output_tokens INTEGER DEFAULT 0,
+		estimated_cost REAL DEFAULT 0,
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+	);
+
+	-- User ratings of generated tools (1 to 5), one per user and tool
+	CREATE TABLE IF NOT EXISTS tool_feedback (
+		tool_id TEXT NOT NULL REFERENCES tools(id) ON DELETE CASCADE,
+		user_id TEXT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+		rating INTEGER CHECK(rating BETWEEN 1 AND 5) NOT NULL,
+		comment TEXT,
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		updated_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		PRIMARY KEY (tool_id, user_id)
+	);
+
+	-- Graded attempts at a quiz: the answers given, one per question, and the result of each
+	CREATE TABLE IF NOT EXISTS quiz_attempts (
+		id TEXT PRIMARY KEY,
+		tool_id TEXT NOT NULL REFERENCES tools(id) ON DELETE CASCADE,
+		user_id TEXT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+		answers JSON NOT NULL,
+		results JSON NOT NULL,
+		score REAL NOT NULL,
+		maximum_score REAL NOT NULL,
+		estimated_cost REAL DEFAULT 0,
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+	);
+
+	-- Prompt experiments: alternative templates of a prompt file, tried at random on generation jobs
+	CREATE TABLE IF NOT EXISTS prompt_variants (
+		id TEXT PRIMARY KEY,
+		prompt_path TEXT NOT NULL,
+		name TEXT NOT NULL,
+		content TEXT NOT NULL,
+		weight REAL NOT NULL DEFAULT 1,
+		active BOOLEAN NOT NULL DEFAULT 1,
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+	);
+
+	-- The variant each job used for each experimental prompt (variant_id is NULL for the prompt file) and the
+	-- adherence scores its sections received. Rows outlive their jobs and tools so the statistics are kept
+	CREATE TABLE IF NOT EXISTS prompt_assignments (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		job_id TEXT NOT NULL,
+		prompt_path TEXT NOT NULL,
+		variant_id TEXT,
+		tool_id TEXT,
+		adherence_score_total INTEGER NOT NULL DEFAULT 0,
+		adherence_score_count INTEGER NOT NULL DEFAULT 0,
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+	);
+
 	-- Chat sessions (scoped to an Exam)
 	CREATE TABLE IF NOT EXISTS chat_sessions (
 		id TEXT PRIMARY KEY,
@@ -221,6 +460,44 @@ func createSchema(database *sql.DB) error {
 		completed_at DATETIME
 	);
 
+	-- Jobs that may only start once other jobs completed; dependencies are not foreign keys since retrying a
+	-- failed job deletes its row
+	CREATE TABLE IF NOT EXISTS job_dependencies (
+		job_id TEXT NOT NULL REFERENCES jobs(id) ON DELETE CASCADE,
+		depends_on_job_id TEXT NOT NULL,
+		PRIMARY KEY (job_id, depends_on_job_id)
+	);
+
+	-- State a paused job resumes from, such as the pages of a document already read, as key and JSON value
+	CREATE TABLE IF NOT EXISTS job_checkpoints (
+		job_id TEXT NOT NULL REFERENCES jobs(id) ON DELETE CASCADE,
+		key TEXT NOT NULL,
+		value JSON NOT NULL,
+		PRIMARY KEY (job_id, key)
+	);
+
+	-- What each job spent on the providers, recorded as it runs so daily and monthly budgets can be enforced;
+	-- rows outlive their job
+	CREATE TABLE IF NOT EXISTS cost_ledger (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		user_id TEXT NOT NULL,
+		job_id TEXT NOT NULL,
+		job_type TEXT NOT NULL,
+		amount REAL NOT NULL,
+		recorded_at DATETIME DEFAULT CURRENT_TIMESTAMP
+	);
+
+	-- API keys users bring for the providers, sealed with the deployment's encryption key, so their generations
+	-- are billed to them; key_hint keeps the last characters to tell keys apart
+	CREATE TABLE IF NOT EXISTS user_provider_keys (
+		user_id TEXT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+		provider TEXT NOT NULL,
+		sealed_key TEXT NOT NULL,
+		key_hint TEXT NOT NULL,
+		updated_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		PRIMARY KEY (user_id, provider)
+	);
+
 	-- User settings (can be global or user-specific if we added user_id, but keeping as is for global defaults)
 	CREATE TABLE IF NOT EXISTS settings (
 		key TEXT PRIMARY KEY,
@@ -242,7 +519,7 @@ func createSchema(database *sql.DB) error {
 		return err
 	}
 
-	// Run migrations for schema updates
+	// Schema updates from before migrations were versioned, frozen: new changes are versioned migrations
 	migrations := []string{
 		// Add user_id column to tables if they were created in older versions without it
 		`ALTER TABLE jobs ADD COLUMN user_id TEXT`,
@@ -291,19 +568,110 @@ func createSchema(database *sql.DB) error {
 		`CREATE INDEX index_reference_pages_document_id ON reference_pages(document_id)`,
 		`CREATE INDEX index_tools_exam_id ON tools(exam_id)`,
 		`CREATE INDEX index_tools_lecture_id ON tools(lecture_id)`,
+		`CREATE INDEX index_tool_sections_job_id ON tool_sections(job_id)`,
+		`CREATE INDEX index_tool_sections_tool_id ON tool_sections(tool_id)`,
 		`CREATE INDEX index_chat_sessions_exam_id ON chat_sessions(exam_id)`,
 		`CREATE INDEX index_chat_messages_session_id ON chat_messages(session_id)`,
 		`CREATE INDEX index_jobs_user_id ON jobs(user_id)`,
 		`CREATE INDEX index_jobs_course_id ON jobs(course_id)`,
 		`CREATE INDEX index_jobs_lecture_id ON jobs(lecture_id)`,
 		`CREATE INDEX index_jobs_status ON jobs(status)`,
+		`CREATE INDEX index_job_dependencies_depends_on_job_id ON job_dependencies(depends_on_job_id)`,
 		`CREATE INDEX index_auth_sessions_user_id ON auth_sessions(user_id)`,
+		`CREATE INDEX index_uploads_user_id ON uploads(user_id)`,
+		`CREATE INDEX index_export_presets_user_id ON export_presets(user_id)`,
+		`CREATE INDEX index_embedding_chunks_lecture_id ON embedding_chunks(lecture_id, model)`,
+		`CREATE INDEX index_chat_citations_message_id ON chat_citations(message_id)`,
+		`CREATE INDEX index_prompt_assignments_job_id ON prompt_assignments(job_id)`,
+		`CREATE INDEX index_prompt_assignments_prompt_path ON prompt_assignments(prompt_path, variant_id)`,
+		`CREATE INDEX index_quiz_attempts_tool_id ON quiz_attempts(tool_id, user_id, created_at)`,
 
 		// Store all file data as BLOBs so backups are fully self-contained
 		`ALTER TABLE reference_pages ADD COLUMN image_data BLOB`,
 		`ALTER TABLE lecture_media ADD COLUMN file_data BLOB`,
 		`ALTER TABLE reference_documents ADD COLUMN file_data BLOB`,
 		`ALTER TABLE jobs ADD COLUMN export_data BLOB`,
+
+		// Per-exam tool generation defaults (JSON-encoded models.ExamGenerationDefaults)
+		`ALTER TABLE exams ADD COLUMN generation_defaults TEXT`,
+
+		// LLM-polished segment text; the raw transcription in text is never overwritten
+		`ALTER TABLE transcript_segments ADD COLUMN polished_text TEXT`,
+
+		// Source-format details of a page (JSON-encoded models.ReferencePageMetadata), e.g. slide titles and speaker notes
+		`ALTER TABLE reference_pages ADD COLUMN metadata JSON`,
+
+		// Prompt tokens predicted before the calls of a job, compared with the provider-reported input_tokens
+		`ALTER TABLE jobs ADD COLUMN estimated_input_tokens INTEGER DEFAULT 0`,
+
+		// Provenance of reference documents fetched from a webpage
+		`ALTER TABLE reference_documents ADD COLUMN source_url TEXT`,
+
+		// Per-document choice between the vision model and OCR, and how the pages were read (JSON-encoded
+		// models.DocumentExtractionMetadata); each page keeps the source of its own text
+		`ALTER TABLE reference_documents ADD COLUMN extraction_method TEXT`,
+		`ALTER TABLE reference_documents ADD COLUMN extraction_metadata JSON`,
+		`ALTER TABLE reference_pages ADD COLUMN extraction_source TEXT`,
+
+		// Scheduling priority of a job (models.JobPriority*), pending jobs being started by priority then FIFO
+		`ALTER TABLE jobs ADD COLUMN priority TEXT DEFAULT 'normal'`,
+		`CREATE INDEX index_jobs_status_priority ON jobs(status, priority, created_at)`,
+
+		// Structured reason of a job failure (JSON-encoded models.JobFailure) next to the raw error text
+		`ALTER TABLE jobs ADD COLUMN failure JSON`,
+
+		// Resource a job locks while it runs, so conflicting jobs such as two builds of the same tool queue up
+		`ALTER TABLE jobs ADD COLUMN lock_key TEXT`,
+		`CREATE INDEX index_jobs_lock_key ON jobs(lock_key, status)`,
+
+		// Cooperative pause: running jobs asked to pause stop at their next stage boundary, paused ones wait
+		`ALTER TABLE jobs ADD COLUMN pause_requested BOOLEAN DEFAULT 0`,
+		`ALTER TABLE jobs ADD COLUMN paused_at DATETIME`,
+
+		// Dead-letter queue: a failed job requeued by its user points to the fresh job that replaced it
+		`ALTER TABLE jobs ADD COLUMN requeued_as TEXT`,
+		`CREATE INDEX index_jobs_status_completed_at ON jobs(status, completed_at)`,
+
+		// Mixed-language courses: the language a document is read in (detected when NULL), the one most of its
+		// pages turned out to be in, and the language of each page's text
+		`ALTER TABLE reference_documents ADD COLUMN language TEXT`,
+		`ALTER TABLE reference_documents ADD COLUMN detected_language TEXT`,
+		`ALTER TABLE reference_pages ADD COLUMN language TEXT`,
+
+		// Crash recovery: running jobs beat while their worker is alive, and a job is requeued after a limited
+		// number of interruptions before it is failed
+		`ALTER TABLE jobs ADD COLUMN heartbeat_at DATETIME`,
+		`ALTER TABLE jobs ADD COLUMN recovery_count INTEGER DEFAULT 0`,
+
+		// Incremental readiness: a tool generated before every source of its lecture finished processing
+		`ALTER TABLE tools ADD COLUMN partial_sources BOOLEAN DEFAULT 0`,
+
+		// Cost budgets: per-user overrides of safety.daily_budget_per_user and safety.monthly_budget_per_user
+		// (NULL keeps the default, 0 is unlimited), checked against the spend recorded in cost_ledger
+		`ALTER TABLE users ADD COLUMN daily_budget REAL`,
+		`ALTER TABLE users ADD COLUMN monthly_budget REAL`,
+		`CREATE INDEX index_cost_ledger_user_id ON cost_ledger(user_id, recorded_at)`,
+
+		// Usage analytics: the model a job's tokens and cost are attributed to, resolved when it is queued
+		`ALTER TABLE jobs ADD COLUMN model TEXT`,
+
+		// User-defined metadata fields (JSON-encoded []models.MetadataField) printed on exports and available to prompts
+		`ALTER TABLE exams ADD COLUMN metadata_fields JSON`,
+		`ALTER TABLE lectures ADD COLUMN metadata_fields JSON`,
+
+		// Shared exams: chat sessions stay private to the user who started them (NULL for sessions started by
+		// the owner before exams could be shared)
+		`ALTER TABLE chat_sessions ADD COLUMN user_id TEXT`,
+		`CREATE INDEX index_exam_members_user_id ON exam_members(user_id)`,
+
+		// When the segments of a transcript were last restored from its archive, so it is not archived again
+		// right away
+		`ALTER TABLE transcripts ADD COLUMN restored_at DATETIME`,
+
+		// Lookups of the history and tool versions of a lecture
+		`CREATE INDEX index_resource_events_exam_id ON resource_events(exam_id, id)`,
+		`CREATE INDEX index_resource_events_lecture_id ON resource_events(lecture_id)`,
+		`CREATE INDEX index_tool_versions_lecture_type ON tool_versions(lecture_id, type)`,
 	}
 
 	for _, migration := range migrations {
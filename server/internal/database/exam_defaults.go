@@ -0,0 +1,36 @@
+package database
+
+import (
+	"database/sql"
+	"encoding/json"
+
+	"lectures/internal/models"
+)
+
+// GetExamGenerationDefaults returns the tool-generation defaults stored on an exam (zero values when unset)
+func GetExamGenerationDefaults(database *sql.DB, examID string) (models.ExamGenerationDefaults, error) {
+	var rawDefaults sql.NullString
+	if err := database.QueryRow("SELECT generation_defaults FROM exams WHERE id = ?", examID).Scan(&rawDefaults); err != nil {
+		return models.ExamGenerationDefaults{}, err
+	}
+	return ParseExamGenerationDefaults(rawDefaults), nil
+}
+
+// ParseExamGenerationDefaults decodes the generation_defaults column, ignoring malformed values
+func ParseExamGenerationDefaults(rawDefaults sql.NullString) models.ExamGenerationDefaults {
+	var defaults models.ExamGenerationDefaults
+	if rawDefaults.Valid && rawDefaults.String != "" {
+		json.Unmarshal([]byte(rawDefaults.String), &defaults)
+	}
+	return defaults
+}
+
+// ParseExamCollaboration decodes the collaboration column, falling back to the defaults for missing or
+// malformed values
+func ParseExamCollaboration(rawCollaboration sql.NullString) models.ExamCollaboration {
+	collaboration := models.DefaultExamCollaboration()
+	if rawCollaboration.Valid && rawCollaboration.String != "" {
+		json.Unmarshal([]byte(rawCollaboration.String), &collaboration)
+	}
+	return collaboration
+}
@@ -0,0 +1,86 @@
+package database
+
+import (
+	"database/sql"
+	"encoding/json"
+
+	"lectures/internal/models"
+)
+
+// generationPresetColumns are the columns scanned by scanGenerationPreset
+const generationPresetColumns = "id, name, description, settings, sampling, created_at, updated_at"
+
+// scanGenerationPreset reads a row of generationPresetColumns
+func scanGenerationPreset(row interface{ Scan(...any) error }) (models.GenerationPreset, error) {
+	var preset models.GenerationPreset
+	var settings, sampling string
+	if err := row.Scan(&preset.ID, &preset.Name, &preset.Description, &settings, &sampling, &preset.CreatedAt, &preset.UpdatedAt); err != nil {
+		return preset, err
+	}
+	json.Unmarshal([]byte(settings), &preset.Settings)
+	json.Unmarshal([]byte(sampling), &preset.Sampling)
+	return preset, nil
+}
+
+// CreateGenerationPreset stores a generation preset of a user
+func CreateGenerationPreset(database *sql.DB, userID string, preset models.GenerationPreset) error {
+	settings, _ := json.Marshal(preset.Settings)
+	sampling, _ := json.Marshal(preset.Sampling)
+	_, err := database.Exec(`
+		INSERT INTO generation_presets (id, user_id, name, description, settings, sampling, created_at, updated_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+	`, preset.ID, userID, preset.Name, preset.Description, string(settings), string(sampling), preset.CreatedAt, preset.UpdatedAt)
+	return err
+}
+
+// GetGenerationPreset returns a generation preset of a user, or sql.ErrNoRows
+func GetGenerationPreset(database *sql.DB, userID string, presetID string) (models.GenerationPreset, error) {
+	return scanGenerationPreset(database.QueryRow("SELECT "+generationPresetColumns+" FROM generation_presets WHERE id = ? AND user_id = ?", presetID, userID))
+}
+
+// ListGenerationPresets returns the generation presets of a user by name
+func ListGenerationPresets(database *sql.DB, userID string) ([]models.GenerationPreset, error) {
+	rows, err := database.Query("SELECT "+generationPresetColumns+" FROM generation_presets WHERE user_id = ? ORDER BY name COLLATE NOCASE, id", userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	presets := []models.GenerationPreset{}
+	for rows.Next() {
+		preset, err := scanGenerationPreset(rows)
+		if err != nil {
+			return nil, err
+		}
+		presets = append(presets, preset)
+	}
+	return presets, rows.Err()
+}
+
+// GenerationPresetNameTaken reports whether another preset of a user than presetID has a name
+func GenerationPresetNameTaken(database *sql.DB, userID string, name string, presetID string) (bool, error) {
+	var taken bool
+	err := database.QueryRow("SELECT EXISTS(SELECT 1 FROM generation_presets WHERE user_id = ? AND name = ? AND id != ?)", userID, name, presetID).Scan(&taken)
+	return taken, err
+}
+
+// UpdateGenerationPreset saves the name, description, settings and sampling parameters of a preset of a user
+func UpdateGenerationPreset(database *sql.DB, userID string, preset models.GenerationPreset) error {
+	settings, _ := json.Marshal(preset.Settings)
+	sampling, _ := json.Marshal(preset.Sampling)
+	_, err := database.Exec(`
+		UPDATE generation_presets SET name = ?, description = ?, settings = ?, sampling = ?, updated_at = ?
+		WHERE id = ? AND user_id = ?
+	`, preset.Name, preset.Description, string(settings), string(sampling), preset.UpdatedAt, preset.ID, userID)
+	return err
+}
+
+// DeleteGenerationPreset removes a generation preset of a user, reporting whether there was one
+func DeleteGenerationPreset(database *sql.DB, userID string, presetID string) (bool, error) {
+	result, err := database.Exec("DELETE FROM generation_presets WHERE id = ? AND user_id = ?", presetID, userID)
+	if err != nil {
+		return false, err
+	}
+	affectedRows, _ := result.RowsAffected()
+	return affectedRows > 0, nil
+}
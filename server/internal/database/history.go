@@ -0,0 +1,186 @@
+package database
+
+import (
+	"database/sql"
+	"encoding/json"
+	"time"
+
+	"lectures/internal/models"
+
+	gonanoid "github.com/matoous/go-nanoid/v2"
+)
+
+// maximumToolVersions bounds the versions kept of the tool of each type of a lecture, the oldest dropped first
+const maximumToolVersions = 10
+
+// ResourceEventFilter narrows the history of an exam. Empty fields do not filter
+type ResourceEventFilter struct {
+	LectureID    string
+	ResourceType string
+	ResourceID   string
+	BeforeID     int64 // Only events older than this one, to page through the history
+	Limit        int
+}
+
+// RecordResourceEvent adds an event to the history of its exam
+func RecordResourceEvent(database *sql.DB, event models.ResourceEvent) error {
+	var undoJSON any
+	if event.Undo != nil {
+		encodedUndo, _ := json.Marshal(event.Undo)
+		undoJSON = string(encodedUndo)
+	}
+	_, err := database.Exec(`
+		INSERT INTO resource_events (exam_id, lecture_id, resource_type, resource_id, action, summary, user_id, job_id, version_id, undo, created_at)
+		VALUES (?, NULLIF(?, ''), ?, ?, ?, ?, NULLIF(?, ''), NULLIF(?, ''), NULLIF(?, ''), ?, ?)
+	`, event.ExamID, event.LectureID, event.ResourceType, event.ResourceID, event.Action, event.Summary, event.UserID, event.JobID, event.VersionID, undoJSON, time.Now())
+	return err
+}
+
+// ListResourceEvents lists the history of an exam, newest first, with the username of whoever made each change
+func ListResourceEvents(database *sql.DB, examID string, filter ResourceEventFilter) ([]models.ResourceEvent, error) {
+	query := `
+		SELECT resource_events.id, resource_events.exam_id, COALESCE(resource_events.lecture_id, ''), resource_events.resource_type,
+		       resource_events.resource_id, resource_events.action, resource_events.summary, COALESCE(resource_events.user_id, ''),
+		       COALESCE(users.username, ''), COALESCE(resource_events.job_id, ''), COALESCE(resource_events.version_id, ''),
+		       resource_events.undo, resource_events.created_at
+		FROM resource_events
+		LEFT JOIN users ON resource_events.user_id = users.id
+		WHERE resource_events.exam_id = ?`
+	arguments := []any{examID}
+	if filter.LectureID != "" {
+		query += " AND resource_events.lecture_id = ?"
+		arguments = append(arguments, filter.LectureID)
+	}
+	if filter.ResourceType != "" {
+		query += " AND resource_events.resource_type = ?"
+		arguments = append(arguments, filter.ResourceType)
+	}
+	if filter.ResourceID != "" {
+		query += " AND resource_events.resource_id = ?"
+		arguments = append(arguments, filter.ResourceID)
+	}
+	if filter.BeforeID > 0 {
+		query += " AND resource_events.id < ?"
+		arguments = append(arguments, filter.BeforeID)
+	}
+	query += " ORDER BY resource_events.id DESC LIMIT ?"
+	arguments = append(arguments, filter.Limit)
+
+	rows, err := database.Query(query, arguments...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	events := []models.ResourceEvent{}
+	for rows.Next() {
+		var event models.ResourceEvent
+		var undoJSON sql.NullString
+		if err := rows.Scan(&event.ID, &event.ExamID, &event.LectureID, &event.ResourceType, &event.ResourceID, &event.Action, &event.Summary,
+			&event.UserID, &event.Username, &event.JobID, &event.VersionID, &undoJSON, &event.CreatedAt); err != nil {
+			return nil, err
+		}
+		if undoJSON.Valid && undoJSON.String != "" {
+			var undo models.ResourceEventUndo
+			if json.Unmarshal([]byte(undoJSON.String), &undo) == nil {
+				event.Undo = &undo
+			}
+		}
+		events = append(events, event)
+	}
+	return events, rows.Err()
+}
+
+// Executor runs statements on the database or within a transaction
+type Executor interface {
+	Exec(query string, arguments ...any) (sql.Result, error)
+	QueryRow(query string, arguments ...any) *sql.Row
+}
+
+// SaveToolVersion keeps the current title and content of a tool before they are replaced, for the given
+// reason, and returns the ID of the version. Only the latest maximumToolVersions versions of the tool of each
+// type of a lecture are kept, counting the decks generated from the bookmarks of each user apart. Given the
+// transaction replacing the content, the version is only kept if the change is
+func SaveToolVersion(database Executor, toolID string, reason string) (string, error) {
+	versionID, err := gonanoid.New()
+	if err != nil {
+		return "", err
+	}
+
+	var examID, toolType, bookmarkUserID string
+	var lectureID sql.NullString
+	err = database.QueryRow("SELECT exam_id, lecture_id, type, COALESCE(bookmark_user_id, '') FROM tools WHERE id = ?", toolID).Scan(&examID, &lectureID, &toolType, &bookmarkUserID)
+	if err != nil {
+		return "", err
+	}
+
+	_, err = database.Exec(`
+		INSERT INTO tool_versions (id, tool_id, exam_id, lecture_id, type, title, language_code, content, reason, bookmark_user_id, created_at)
+		SELECT ?, id, exam_id, NULLIF(lecture_id, ''), type, title, language_code, content, ?, bookmark_user_id, ?
+		FROM tools WHERE id = ?
+	`, versionID, reason, time.Now(), toolID)
+	if err != nil {
+		return "", err
+	}
+
+	_, err = database.Exec(`
+		DELETE FROM tool_versions
+		WHERE exam_id = ? AND COALESCE(lecture_id, '') = ? AND type = ? AND COALESCE(bookmark_user_id, '') = ? AND id NOT IN (
+			SELECT id FROM tool_versions
+			WHERE exam_id = ? AND COALESCE(lecture_id, '') = ? AND type = ? AND COALESCE(bookmark_user_id, '') = ?
+			ORDER BY created_at DESC, rowid DESC
+			LIMIT ?
+		)
+	`, examID, lectureID.String, toolType, bookmarkUserID, examID, lectureID.String, toolType, bookmarkUserID, maximumToolVersions)
+	return versionID, err
+}
+
+// GetToolVersion returns a version of a tool with its content
+func GetToolVersion(database *sql.DB, versionID string) (models.ToolVersion, error) {
+	var version models.ToolVersion
+	var lectureID, languageCode sql.NullString
+	err := database.QueryRow(`
+		SELECT id, tool_id, exam_id, lecture_id, type, title, language_code, content, reason, COALESCE(bookmark_user_id, ''), created_at
+		FROM tool_versions WHERE id = ?
+	`, versionID).Scan(&version.ID, &version.ToolID, &version.ExamID, &lectureID, &version.Type, &version.Title, &languageCode,
+		&version.Content, &version.Reason, &version.BookmarkUserID, &version.CreatedAt)
+	version.LectureID = lectureID.String
+	version.LanguageCode = languageCode.String
+	return version, err
+}
+
+// ListToolVersions lists the versions kept of the tools of an exam that userID may see, newest first and without
+// their content, optionally only those of a lecture and a type. Versions of decks built from the bookmarks of
+// other users are left out
+func ListToolVersions(database *sql.DB, examID string, userID string, lectureID string, toolType string) ([]models.ToolVersion, error) {
+	query := `
+		SELECT id, tool_id, exam_id, COALESCE(lecture_id, ''), type, title, COALESCE(language_code, ''), reason, COALESCE(bookmark_user_id, ''), created_at
+		FROM tool_versions WHERE exam_id = ? AND COALESCE(bookmark_user_id, '') IN ('', ?)`
+	arguments := []any{examID, userID}
+	if lectureID != "" {
+		query += " AND lecture_id = ?"
+		arguments = append(arguments, lectureID)
+	}
+	if toolType != "" {
+		query += " AND type = ?"
+		arguments = append(arguments, toolType)
+	}
+	query += " ORDER BY created_at DESC, rowid DESC"
+
+	rows, err := database.Query(query, arguments...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	versions := []models.ToolVersion{}
+	for rows.Next() {
+		var version models.ToolVersion
+		if err := rows.Scan(&version.ID, &version.ToolID, &version.ExamID, &version.LectureID, &version.Type, &version.Title,
+			&version.LanguageCode, &version.Reason, &version.BookmarkUserID, &version.CreatedAt); err != nil {
+			return nil, err
+		}
+		versions = append(versions, version)
+	}
+	return versions, rows.Err()
+}
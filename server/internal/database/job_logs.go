@@ -0,0 +1,59 @@
+package database
+
+import (
+	"database/sql"
+	"encoding/json"
+
+	"lectures/internal/models"
+)
+
+// AppendJobLog records a line logged while running a job, unless the job already has maximumLines lines. It
+// returns the line with its sequence, and whether it was recorded
+func AppendJobLog(database *sql.DB, jobLog models.JobLog, maximumLines int) (models.JobLog, bool, error) {
+	var attributes any
+	if len(jobLog.Attributes) > 0 {
+		attributesJSON, _ := json.Marshal(jobLog.Attributes)
+		attributes = string(attributesJSON)
+	}
+	result, err := database.Exec(`
+		INSERT INTO job_logs (job_id, level, message, attributes, created_at)
+		SELECT ?, ?, ?, ?, ?
+		WHERE EXISTS(SELECT 1 FROM jobs WHERE id = ?) AND (SELECT COUNT(*) FROM job_logs WHERE job_id = ?) < ?
+	`, jobLog.JobID, jobLog.Level, jobLog.Message, attributes, jobLog.CreatedAt, jobLog.JobID, jobLog.JobID, maximumLines)
+	if err != nil {
+		return jobLog, false, err
+	}
+	if affectedRows, _ := result.RowsAffected(); affectedRows == 0 {
+		return jobLog, false, nil
+	}
+	jobLog.Sequence, err = result.LastInsertId()
+	return jobLog, err == nil, err
+}
+
+// ListJobLogs returns up to limit lines of a job logged after a sequence, oldest first
+func ListJobLogs(database *sql.DB, jobID string, afterSequence int64, limit int) ([]models.JobLog, error) {
+	rows, err := database.Query(`
+		SELECT sequence, level, message, attributes, created_at FROM job_logs
+		WHERE job_id = ? AND sequence > ?
+		ORDER BY sequence
+		LIMIT ?
+	`, jobID, afterSequence, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	jobLogs := []models.JobLog{}
+	for rows.Next() {
+		jobLog := models.JobLog{JobID: jobID}
+		var attributes sql.NullString
+		if err := rows.Scan(&jobLog.Sequence, &jobLog.Level, &jobLog.Message, &attributes, &jobLog.CreatedAt); err != nil {
+			return nil, err
+		}
+		if attributes.Valid {
+			json.Unmarshal([]byte(attributes.String), &jobLog.Attributes)
+		}
+		jobLogs = append(jobLogs, jobLog)
+	}
+	return jobLogs, rows.Err()
+}
@@ -0,0 +1,279 @@
+package database
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"lectures/internal/models"
+)
+
+// LoginLockoutPolicy is how many failed logins within a sliding window lock out a username or an address. A
+// successful login starts the count of its username over, while an address only recovers as its failures leave
+// the window: logging into one's own account must not unlock an address guessing the passwords of others. An
+// administrator unlocking either starts its count over
+type LoginLockoutPolicy struct {
+	MaximumFailures int
+	Window          time.Duration
+}
+
+// LoginLockout is a username or an address currently refused logins
+type LoginLockout struct {
+	Username       string    `json:"username,omitempty"`
+	IPAddress      string    `json:"ip_address,omitempty"`
+	FailedAttempts int       `json:"failed_attempts"`
+	LockedUntil    time.Time `json:"locked_until"`
+}
+
+// AuthEventFilter narrows the audit log of authentication. Empty fields do not filter
+type AuthEventFilter struct {
+	UserID    string
+	Username  string
+	IPAddress string
+	Event     string
+	BeforeID  int64 // Only events older than this one, to page through the log
+	Limit     int
+}
+
+// RecordLoginAttempt stores a login attempt with a username, known or not, from an address
+func RecordLoginAttempt(database *sql.DB, username string, ipAddress string, succeeded bool) error {
+	_, err := database.Exec(`
+		INSERT INTO login_attempts (username, ip_address, succeeded, attempted_at)
+		VALUES (?, ?, ?, ?)
+	`, username, ipAddress, succeeded, time.Now())
+	return err
+}
+
+// UsernameLockedUntil returns until when logins with a username are refused, or the zero time when they are not
+func UsernameLockedUntil(database *sql.DB, username string, policy LoginLockoutPolicy) (time.Time, error) {
+	return lockedUntil(database, "username", username, policy)
+}
+
+// AddressLockedUntil returns until when logins from an address are refused, or the zero time when they are not
+func AddressLockedUntil(database *sql.DB, ipAddress string, policy LoginLockoutPolicy) (time.Time, error) {
+	return lockedUntil(database, "ip_address", ipAddress, policy)
+}
+
+// sinceLastSuccess restricts the failed logins with a username, given by usernameExpression, to those after its
+// last successful login. Addresses are not restricted, their successes not resetting their count
+func sinceLastSuccess(column string, usernameExpression string) string {
+	if column != "username" {
+		return ""
+	}
+	return fmt.Sprintf("AND id > COALESCE((SELECT MAX(id) FROM login_attempts WHERE username = %s AND succeeded = 1), 0)", usernameExpression)
+}
+
+// lockedUntil applies a lockout policy to the failed logins sharing the value of a column of login_attempts that
+// were not cleared, counting only those since its last successful login for usernames
+func lockedUntil(database *sql.DB, column string, value string, policy LoginLockoutPolicy) (time.Time, error) {
+	if policy.MaximumFailures <= 0 {
+		return time.Time{}, nil
+	}
+	arguments := []any{value}
+	if column == "username" {
+		arguments = append(arguments, value)
+	}
+	rows, err := database.Query(fmt.Sprintf(`
+		SELECT attempted_at FROM login_attempts
+		WHERE %[1]s = ? AND succeeded = 0 AND cleared = 0 %[2]s
+		ORDER BY id DESC LIMIT ?
+	`, column, sinceLastSuccess(column, "?")), append(arguments, policy.MaximumFailures)...)
+	if err != nil {
+		return time.Time{}, err
+	}
+	defer rows.Close()
+
+	// Only the oldest of the latest failures matters: the lockout lasts until it leaves the window. Compared in
+	// Go, timestamps not being stored in a comparable format
+	failures := 0
+	var oldestAttemptAt time.Time
+	for rows.Next() {
+		if err := rows.Scan(&oldestAttemptAt); err != nil {
+			return time.Time{}, err
+		}
+		failures++
+	}
+	if err := rows.Err(); err != nil {
+		return time.Time{}, err
+	}
+	if failures < policy.MaximumFailures {
+		return time.Time{}, nil
+	}
+	if until := oldestAttemptAt.Add(policy.Window); until.After(time.Now()) {
+		return until, nil
+	}
+	return time.Time{}, nil
+}
+
+// ListLoginLockouts returns the usernames and the addresses currently refused logins
+func ListLoginLockouts(database *sql.DB, usernamePolicy LoginLockoutPolicy, addressPolicy LoginLockoutPolicy) ([]LoginLockout, error) {
+	lockouts := []LoginLockout{}
+	for _, lockoutKind := range []struct {
+		column string
+		policy LoginLockoutPolicy
+	}{{"username", usernamePolicy}, {"ip_address", addressPolicy}} {
+		if lockoutKind.policy.MaximumFailures <= 0 {
+			continue
+		}
+		// Candidates have enough counted failures, whether or not these are recent
+		rows, err := database.Query(fmt.Sprintf(`
+			SELECT attempts.%[1]s, COUNT(*) FROM login_attempts AS attempts
+			WHERE attempts.succeeded = 0 AND attempts.cleared = 0 %[2]s
+			GROUP BY attempts.%[1]s
+			HAVING COUNT(*) >= ?
+		`, lockoutKind.column, sinceLastSuccess(lockoutKind.column, "attempts.username")), lockoutKind.policy.MaximumFailures)
+		if err != nil {
+			return nil, err
+		}
+		var candidates []LoginLockout
+		for rows.Next() {
+			var value string
+			var lockout LoginLockout
+			if err := rows.Scan(&value, &lockout.FailedAttempts); err != nil {
+				rows.Close()
+				return nil, err
+			}
+			if lockoutKind.column == "username" {
+				lockout.Username = value
+			} else {
+				lockout.IPAddress = value
+			}
+			candidates = append(candidates, lockout)
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return nil, err
+		}
+
+		for _, lockout := range candidates {
+			value := lockout.Username + lockout.IPAddress
+			until, err := lockedUntil(database, lockoutKind.column, value, lockoutKind.policy)
+			if err != nil {
+				return nil, err
+			}
+			if !until.IsZero() {
+				lockout.LockedUntil = until
+				lockouts = append(lockouts, lockout)
+			}
+		}
+	}
+	return lockouts, nil
+}
+
+// ClearFailedLogins stops the failed logins with a username, from an address, or both, from counting towards
+// lockouts. They stay stored for the record. It returns how many were cleared
+func ClearFailedLogins(database *sql.DB, username string, ipAddress string) (int64, error) {
+	result, err := database.Exec(`
+		UPDATE login_attempts SET cleared = 1
+		WHERE succeeded = 0 AND cleared = 0 AND (username = NULLIF(?, '') OR ip_address = NULLIF(?, ''))
+	`, username, ipAddress)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+// PruneLoginAttempts deletes the login attempts made before the cutoff, returning how many were deleted. The
+// audit log keeps its events
+func PruneLoginAttempts(database *sql.DB, cutoff time.Time) (int64, error) {
+	rows, err := database.Query("SELECT id, attempted_at FROM login_attempts ORDER BY id")
+	if err != nil {
+		return 0, err
+	}
+	// Attempts are stored in the order they were made, so the old ones are those up to the first recent one
+	var lastOldID int64
+	for rows.Next() {
+		var attemptID int64
+		var attemptedAt time.Time
+		if err := rows.Scan(&attemptID, &attemptedAt); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		if !attemptedAt.Before(cutoff) {
+			break
+		}
+		lastOldID = attemptID
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+	if lastOldID == 0 {
+		return 0, nil
+	}
+
+	result, err := database.Exec("DELETE FROM login_attempts WHERE id <= ?", lastOldID)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+// RecordAuthEvent adds an event to the audit log of authentication
+func RecordAuthEvent(database *sql.DB, event models.AuthEvent) error {
+	var detailsJSON any
+	if len(event.Details) > 0 {
+		encodedDetails, _ := json.Marshal(event.Details)
+		detailsJSON = string(encodedDetails)
+	}
+	_, err := database.Exec(`
+		INSERT INTO auth_events (event, user_id, username, ip_address, details, created_at)
+		VALUES (?, NULLIF(?, ''), NULLIF(?, ''), NULLIF(?, ''), ?, ?)
+	`, event.Event, event.UserID, event.Username, event.IPAddress, detailsJSON, time.Now())
+	return err
+}
+
+// ListAuthEvents lists the audit log of authentication, newest first, with the current username of the
+// accounts of events that did not record one
+func ListAuthEvents(database *sql.DB, filter AuthEventFilter) ([]models.AuthEvent, error) {
+	query := `
+		SELECT auth_events.id, auth_events.event, COALESCE(auth_events.user_id, ''), COALESCE(auth_events.username, users.username, ''),
+		       COALESCE(auth_events.ip_address, ''), auth_events.details, auth_events.created_at
+		FROM auth_events
+		LEFT JOIN users ON auth_events.user_id = users.id
+		WHERE 1 = 1`
+	var arguments []any
+	if filter.UserID != "" {
+		query += " AND auth_events.user_id = ?"
+		arguments = append(arguments, filter.UserID)
+	}
+	if filter.Username != "" {
+		query += " AND COALESCE(auth_events.username, users.username) = ?"
+		arguments = append(arguments, filter.Username)
+	}
+	if filter.IPAddress != "" {
+		query += " AND auth_events.ip_address = ?"
+		arguments = append(arguments, filter.IPAddress)
+	}
+	if filter.Event != "" {
+		query += " AND auth_events.event = ?"
+		arguments = append(arguments, filter.Event)
+	}
+	if filter.BeforeID > 0 {
+		query += " AND auth_events.id < ?"
+		arguments = append(arguments, filter.BeforeID)
+	}
+	query += " ORDER BY auth_events.id DESC LIMIT ?"
+	arguments = append(arguments, filter.Limit)
+
+	rows, err := database.Query(query, arguments...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	events := []models.AuthEvent{}
+	for rows.Next() {
+		var event models.AuthEvent
+		var detailsJSON sql.NullString
+		if err := rows.Scan(&event.ID, &event.Event, &event.UserID, &event.Username, &event.IPAddress, &detailsJSON, &event.CreatedAt); err != nil {
+			return nil, err
+		}
+		if detailsJSON.Valid && detailsJSON.String != "" {
+			json.Unmarshal([]byte(detailsJSON.String), &event.Details)
+		}
+		events = append(events, event)
+	}
+	return events, rows.Err()
+}
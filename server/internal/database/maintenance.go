@@ -0,0 +1,163 @@
+package database
+
+import (
+	"database/sql"
+	"sort"
+	"time"
+)
+
+// compactedJobStatuses are the statuses of jobs whose payload, metadata and result are no longer needed: only
+// failed jobs can be requeued from their payload
+var compactedJobStatuses = []string{"COMPLETED", "CANCELLED"}
+
+// Optimize refreshes the statistics the query planner relies on
+func Optimize(database *sql.DB) error {
+	if _, err := database.Exec("ANALYZE"); err != nil {
+		return err
+	}
+	_, err := database.Exec("PRAGMA optimize")
+	return err
+}
+
+// Vacuum rebuilds the database file to return the space of deleted rows to the file system, then truncates the
+// write-ahead log. Writers wait while it runs
+func Vacuum(database *sql.DB) error {
+	if _, err := database.Exec("VACUUM"); err != nil {
+		return err
+	}
+	_, err := database.Exec("PRAGMA wal_checkpoint(TRUNCATE)")
+	return err
+}
+
+// CompactFinishedJobs clears the payload, metadata, result and logs of the jobs that completed or were cancelled
+// before the cutoff, keeping the columns usage reports read. Export jobs are kept whole since their result
+// lists the files users download. It returns how many jobs were compacted
+func CompactFinishedJobs(database *sql.DB, cutoff time.Time) (int, error) {
+	rows, err := database.Query(`
+		SELECT id, completed_at FROM jobs
+		WHERE status IN (?, ?) AND type NOT IN ('PUBLISH_MATERIAL', 'PUBLISH_BUNDLE') AND completed_at IS NOT NULL
+		  AND (payload != '{}' OR metadata IS NOT NULL OR result IS NOT NULL OR EXISTS(SELECT 1 FROM job_logs WHERE job_id = jobs.id))
+	`, compactedJobStatuses[0], compactedJobStatuses[1])
+	if err != nil {
+		return 0, err
+	}
+
+	var jobIDs []string
+	for rows.Next() {
+		var jobID string
+		var completedAt time.Time
+		if err := rows.Scan(&jobID, &completedAt); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		// Filtered in Go, timestamps not being stored in a comparable format
+		if completedAt.Before(cutoff) {
+			jobIDs = append(jobIDs, jobID)
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	compactedCount := 0
+	for _, jobID := range jobIDs {
+		if _, err := database.Exec("UPDATE jobs SET payload = '{}', metadata = NULL, result = NULL, progress_message_text = NULL WHERE id = ?", jobID); err != nil {
+			return compactedCount, err
+		}
+		if _, err := database.Exec("DELETE FROM job_logs WHERE job_id = ?", jobID); err != nil {
+			return compactedCount, err
+		}
+		compactedCount++
+	}
+	return compactedCount, nil
+}
+
+// TableSize is the space a table or index takes in the database file
+type TableSize struct {
+	Name  string `json:"name"`
+	Rows  int64  `json:"rows,omitempty"` // Only counted for tables
+	Bytes int64  `json:"bytes"`
+}
+
+// SizeReport describes how the database file is used
+type SizeReport struct {
+	FileBytes            int64       `json:"file_bytes"`
+	FreeBytes            int64       `json:"free_bytes"` // Space of deleted rows a vacuum would return
+	Tables               []TableSize `json:"tables"`     // Largest first
+	ArchivedTranscripts  int         `json:"archived_transcripts"`
+	ArchivedSegments     int64       `json:"archived_segments"`
+	ArchivedBytes        int64       `json:"archived_bytes"`
+	CompactedJobs        int64       `json:"compacted_jobs"`
+	LastMaintenanceAt    *time.Time  `json:"last_maintenance_at,omitempty"`
+	LastMaintenanceError string      `json:"last_maintenance_error,omitempty"`
+}
+
+// GetSizeReport measures the database file, its free pages and each table and index, with the row counts of
+// the tables
+func GetSizeReport(database *sql.DB) (SizeReport, error) {
+	var report SizeReport
+	var pageSize, pageCount, freePages int64
+	if err := database.QueryRow("PRAGMA page_size").Scan(&pageSize); err != nil {
+		return report, err
+	}
+	if err := database.QueryRow("PRAGMA page_count").Scan(&pageCount); err != nil {
+		return report, err
+	}
+	if err := database.QueryRow("PRAGMA freelist_count").Scan(&freePages); err != nil {
+		return report, err
+	}
+	report.FileBytes = pageSize * pageCount
+	report.FreeBytes = pageSize * freePages
+
+	rows, err := database.Query("SELECT name, SUM(pgsize) FROM dbstat GROUP BY name")
+	if err != nil {
+		return report, err
+	}
+	for rows.Next() {
+		var table TableSize
+		if err := rows.Scan(&table.Name, &table.Bytes); err != nil {
+			rows.Close()
+			return report, err
+		}
+		report.Tables = append(report.Tables, table)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return report, err
+	}
+
+	tableNames := make(map[string]bool)
+	tableRows, err := database.Query("SELECT name FROM sqlite_master WHERE type = 'table'")
+	if err != nil {
+		return report, err
+	}
+	for tableRows.Next() {
+		var name string
+		if err := tableRows.Scan(&name); err != nil {
+			tableRows.Close()
+			return report, err
+		}
+		tableNames[name] = true
+	}
+	tableRows.Close()
+	for index := range report.Tables {
+		if tableNames[report.Tables[index].Name] {
+			// Table names come from sqlite_master, so they are safe to quote into the query
+			database.QueryRow(`SELECT COUNT(*) FROM "` + report.Tables[index].Name + `"`).Scan(&report.Tables[index].Rows)
+		}
+	}
+	sort.Slice(report.Tables, func(first, second int) bool {
+		return report.Tables[first].Bytes > report.Tables[second].Bytes
+	})
+
+	if err := database.QueryRow("SELECT COUNT(*), COALESCE(SUM(segment_count), 0), COALESCE(SUM(LENGTH(segments)), 0) FROM transcript_archives").Scan(
+		&report.ArchivedTranscripts, &report.ArchivedSegments, &report.ArchivedBytes); err != nil {
+		return report, err
+	}
+	if err := database.QueryRow("SELECT COUNT(*) FROM jobs WHERE payload = '{}' AND metadata IS NULL AND result IS NULL AND status IN (?, ?)",
+		compactedJobStatuses[0], compactedJobStatuses[1]).Scan(&report.CompactedJobs); err != nil {
+		return report, err
+	}
+	return report, nil
+}
@@ -0,0 +1,33 @@
+package database
+
+import (
+	"database/sql"
+	"encoding/json"
+
+	"lectures/internal/models"
+)
+
+// ParseMetadataFields decodes a metadata_fields column, ignoring malformed values
+func ParseMetadataFields(rawFields sql.NullString) []models.MetadataField {
+	fields := []models.MetadataField{}
+	if rawFields.Valid && rawFields.String != "" {
+		json.Unmarshal([]byte(rawFields.String), &fields)
+	}
+	return fields
+}
+
+// GetMetadataFields returns the metadata fields of an exam, merged with those of one of its lectures when
+// lectureID is set
+func GetMetadataFields(database *sql.DB, examID string, lectureID string) ([]models.MetadataField, error) {
+	var rawExamFields sql.NullString
+	if err := database.QueryRow("SELECT metadata_fields FROM exams WHERE id = ?", examID).Scan(&rawExamFields); err != nil {
+		return nil, err
+	}
+	var rawLectureFields sql.NullString
+	if lectureID != "" {
+		if err := database.QueryRow("SELECT metadata_fields FROM lectures WHERE id = ? AND exam_id = ?", lectureID, examID).Scan(&rawLectureFields); err != nil {
+			return nil, err
+		}
+	}
+	return models.MergeMetadataFields(ParseMetadataFields(rawExamFields), ParseMetadataFields(rawLectureFields)), nil
+}
@@ -0,0 +1,729 @@
+package database
+
+import (
+	"database/sql"
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+)
+
+// Migration is a versioned change of the schema. Each migration runs in a transaction recording its version
+// in schema_migrations, so it is applied entirely or not at all
+type Migration struct {
+	Version int
+	Name    string
+	Up      string // Statements applying the change
+	Down    string // Statements reverting it, used by rollbacks during development; empty, like Revert, when it cannot be reverted
+	// Apply runs after Up in the same transaction, for changes that SQL statements alone cannot make
+	Apply func(transaction *sql.Tx) error
+	// Revert runs after Down in the same transaction, undoing what Apply did
+	Revert func(transaction *sql.Tx) error
+}
+
+// MigrationState is a migration with the time it was applied, nil while it is pending
+type MigrationState struct {
+	Migration
+	AppliedAt *time.Time
+}
+
+// migrations are the versioned changes applied after the base schema of createSchema, in order. Schema
+// changes go here from now on, with a new version each: the base schema and its unversioned ALTER TABLE list
+// are frozen as the state of databases created before migrations were versioned
+var migrations = []Migration{
+	{
+		Version: 1,
+		Name:    "index_history_foreign_keys",
+		Up: `
+			CREATE INDEX index_resource_events_user_id ON resource_events(user_id);
+			CREATE INDEX index_tool_versions_exam_id ON tool_versions(exam_id);
+		`,
+		Down: `
+			DROP INDEX index_resource_events_user_id;
+			DROP INDEX index_tool_versions_exam_id;
+		`,
+	},
+	{
+		Version: 2,
+		Name:    "job_labels",
+		Up: `
+			ALTER TABLE jobs ADD COLUMN label TEXT;
+			ALTER TABLE jobs ADD COLUMN note TEXT;
+			CREATE INDEX index_jobs_user_id_label ON jobs(user_id, label);
+		`,
+		Down: `
+			DROP INDEX index_jobs_user_id_label;
+			ALTER TABLE jobs DROP COLUMN note;
+			ALTER TABLE jobs DROP COLUMN label;
+		`,
+	},
+	{
+		Version: 3,
+		Name:    "lecture_bookmarks",
+		Up: `
+			CREATE TABLE lecture_bookmarks (
+				id TEXT PRIMARY KEY,
+				lecture_id TEXT NOT NULL REFERENCES lectures(id) ON DELETE CASCADE,
+				user_id TEXT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+				name TEXT NOT NULL,
+				millisecond INTEGER NOT NULL CHECK(millisecond >= 0),
+				created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+				updated_at DATETIME DEFAULT CURRENT_TIMESTAMP
+			);
+			CREATE INDEX index_lecture_bookmarks_lecture_id_user_id ON lecture_bookmarks(lecture_id, user_id);
+			CREATE INDEX index_lecture_bookmarks_user_id ON lecture_bookmarks(user_id);
+		`,
+		Down: `
+			DROP TABLE lecture_bookmarks;
+		`,
+	},
+	{
+		Version: 4,
+		Name:    "object_keys",
+		Up: `
+			ALTER TABLE lecture_media ADD COLUMN object_key TEXT;
+			ALTER TABLE reference_pages ADD COLUMN object_key TEXT;
+			ALTER TABLE jobs ADD COLUMN export_object_key TEXT;
+		`,
+		Down: `
+			ALTER TABLE jobs DROP COLUMN export_object_key;
+			ALTER TABLE reference_pages DROP COLUMN object_key;
+			ALTER TABLE lecture_media DROP COLUMN object_key;
+		`,
+	},
+	{
+		// Objects outside the database have no BLOB to measure, so storage quotas need their size recorded
+		Version: 5,
+		Name:    "stored_sizes",
+		Up: `
+			ALTER TABLE lecture_media ADD COLUMN size_bytes INTEGER;
+			ALTER TABLE reference_pages ADD COLUMN size_bytes INTEGER;
+			ALTER TABLE jobs ADD COLUMN export_size_bytes INTEGER;
+			UPDATE lecture_media SET size_bytes = LENGTH(file_data) WHERE file_data IS NOT NULL;
+			UPDATE reference_pages SET size_bytes = LENGTH(image_data) WHERE image_data IS NOT NULL;
+			UPDATE jobs SET export_size_bytes = LENGTH(export_data) WHERE export_data IS NOT NULL;
+		`,
+		Down: `
+			ALTER TABLE jobs DROP COLUMN export_size_bytes;
+			ALTER TABLE reference_pages DROP COLUMN size_bytes;
+			ALTER TABLE lecture_media DROP COLUMN size_bytes;
+		`,
+	},
+	{
+		Version: 6,
+		Name:    "transcript_redactions",
+		Up: `
+			CREATE TABLE transcript_redactions (
+				id INTEGER PRIMARY KEY AUTOINCREMENT,
+				lecture_id TEXT NOT NULL REFERENCES lectures(id) ON DELETE CASCADE,
+				user_id TEXT REFERENCES users(id) ON DELETE SET NULL,
+				phrase TEXT,
+				start_millisecond INTEGER,
+				end_millisecond INTEGER,
+				bleep_audio BOOLEAN DEFAULT 0,
+				created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+				CHECK ((phrase IS NULL) != (start_millisecond IS NULL))
+			);
+			CREATE INDEX index_transcript_redactions_lecture_id ON transcript_redactions(lecture_id);
+		`,
+		Down: `
+			DROP TABLE transcript_redactions;
+		`,
+	},
+	{
+		Version: 7,
+		Name:    "user_roles",
+		// Accounts of the former "user" role could do everything but administration, as teachers can
+		Apply: func(transaction *sql.Tx) error {
+			if err := rewriteTableDefinition(transaction, "users",
+				"CHECK(role IN ('admin', 'user')) DEFAULT 'user'",
+				"CHECK(role IN ('admin', 'teacher', 'student')) DEFAULT 'teacher'"); err != nil {
+				return err
+			}
+			_, err := transaction.Exec("UPDATE users SET role = 'teacher' WHERE role IS NULL OR role NOT IN ('admin', 'teacher', 'student')")
+			return err
+		},
+		// Teachers become users again; students had no equivalent, so they must be removed or promoted first
+		Revert: func(transaction *sql.Tx) error {
+			if err := refuseRollbackWhileUsed(transaction, "users", "role = 'student'"); err != nil {
+				return err
+			}
+			if err := rewriteTableDefinition(transaction, "users",
+				"CHECK(role IN ('admin', 'teacher', 'student')) DEFAULT 'teacher'",
+				"CHECK(role IN ('admin', 'user')) DEFAULT 'user'"); err != nil {
+				return err
+			}
+			_, err := transaction.Exec("UPDATE users SET role = 'user' WHERE role = 'teacher'")
+			return err
+		},
+	},
+	{
+		Version: 8,
+		Name:    "chat_sampling",
+		Up: `
+			ALTER TABLE chat_sessions ADD COLUMN sampling TEXT;
+		`,
+		Down: `
+			ALTER TABLE chat_sessions DROP COLUMN sampling;
+		`,
+	},
+	{
+		Version: 9,
+		Name:    "planned_lectures",
+		// Lectures scaffolded from a syllabus are planned until a recording or document is added to them
+		Apply: func(transaction *sql.Tx) error {
+			return rewriteTableDefinition(transaction, "lectures",
+				"CHECK(status IN ('processing', 'ready', 'failed'))",
+				"CHECK(status IN ('planned', 'processing', 'ready', 'failed'))")
+		},
+		Revert: func(transaction *sql.Tx) error {
+			if err := refuseRollbackWhileUsed(transaction, "lectures", "status = 'planned'"); err != nil {
+				return err
+			}
+			return rewriteTableDefinition(transaction, "lectures",
+				"CHECK(status IN ('planned', 'processing', 'ready', 'failed'))",
+				"CHECK(status IN ('processing', 'ready', 'failed'))")
+		},
+	},
+	{
+		Version: 10,
+		Name:    "exam_collaboration",
+		Up: `
+			ALTER TABLE exams ADD COLUMN collaboration TEXT;
+		`,
+		Down: `
+			ALTER TABLE exams DROP COLUMN collaboration;
+		`,
+	},
+	{
+		Version: 11,
+		Name:    "oidc_users",
+		// Accounts provisioned by single sign-on are found by the subject of the provider, and have no password
+		Up: `
+			ALTER TABLE users ADD COLUMN oidc_subject TEXT;
+			CREATE UNIQUE INDEX IF NOT EXISTS idx_users_oidc_subject ON users(oidc_subject) WHERE oidc_subject IS NOT NULL;
+		`,
+		Down: `
+			DROP INDEX IF EXISTS idx_users_oidc_subject;
+			ALTER TABLE users DROP COLUMN oidc_subject;
+		`,
+	},
+	{
+		Version: 12,
+		Name:    "api_tokens",
+		// Only the SHA-256 of a token is kept; its prefix tells the tokens of a user apart
+		Up: `
+			CREATE TABLE IF NOT EXISTS api_tokens (
+				id TEXT PRIMARY KEY,
+				user_id TEXT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+				name TEXT NOT NULL,
+				token_hash TEXT NOT NULL UNIQUE,
+				prefix TEXT NOT NULL,
+				scopes TEXT NOT NULL,
+				created_at DATETIME NOT NULL,
+				last_used_at DATETIME,
+				expires_at DATETIME
+			);
+			CREATE INDEX IF NOT EXISTS idx_api_tokens_user ON api_tokens(user_id);
+		`,
+		Down: `
+			DROP TABLE IF EXISTS api_tokens;
+		`,
+	},
+	{
+		Version: 13,
+		Name:    "password_reset",
+		Up: `
+			ALTER TABLE users ADD COLUMN email TEXT;
+			ALTER TABLE users ADD COLUMN password_change_required BOOLEAN NOT NULL DEFAULT 0;
+			CREATE UNIQUE INDEX IF NOT EXISTS idx_users_email ON users(email COLLATE NOCASE) WHERE email IS NOT NULL;
+			CREATE TABLE IF NOT EXISTS password_reset_tokens (
+				token_hash TEXT PRIMARY KEY,
+				user_id TEXT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+				created_at DATETIME NOT NULL,
+				expires_at DATETIME NOT NULL,
+				used_at DATETIME
+			);
+		`,
+		Down: `
+			DROP TABLE IF EXISTS password_reset_tokens;
+			DROP INDEX IF EXISTS idx_users_email;
+			ALTER TABLE users DROP COLUMN password_change_required;
+			ALTER TABLE users DROP COLUMN email;
+		`,
+	},
+	{
+		Version: 14,
+		Name:    "login_attempts",
+		Up: `
+			CREATE TABLE login_attempts (
+				id INTEGER PRIMARY KEY AUTOINCREMENT,
+				username TEXT NOT NULL,
+				ip_address TEXT NOT NULL,
+				succeeded BOOLEAN NOT NULL,
+				cleared BOOLEAN NOT NULL DEFAULT 0,
+				attempted_at DATETIME NOT NULL
+			);
+			CREATE INDEX index_login_attempts_username ON login_attempts(username, succeeded);
+			CREATE INDEX index_login_attempts_ip_address ON login_attempts(ip_address, succeeded);
+			CREATE TABLE auth_events (
+				id INTEGER PRIMARY KEY AUTOINCREMENT,
+				event TEXT NOT NULL,
+				user_id TEXT,
+				username TEXT,
+				ip_address TEXT,
+				details TEXT,
+				created_at DATETIME NOT NULL
+			);
+			CREATE INDEX index_auth_events_user_id ON auth_events(user_id);
+			CREATE INDEX index_auth_events_username ON auth_events(username);
+		`,
+		Down: `
+			DROP TABLE auth_events;
+			DROP TABLE login_attempts;
+		`,
+	},
+	{
+		Version: 15,
+		Name:    "audit_events",
+		// The audit log of every security-relevant action starts with the events of the audit log of
+		// authentication, which is kept. Administrators acting on an account were recorded as "by" in the details
+		Up: `
+			CREATE TABLE audit_events (
+				id INTEGER PRIMARY KEY AUTOINCREMENT,
+				action TEXT NOT NULL,
+				actor_id TEXT,
+				actor_username TEXT,
+				ip_address TEXT,
+				resource_type TEXT,
+				resource_id TEXT,
+				status INTEGER,
+				details TEXT,
+				payload_digest TEXT,
+				created_at DATETIME NOT NULL
+			);
+			INSERT INTO audit_events (id, action, actor_id, actor_username, ip_address, resource_type, resource_id, details, created_at)
+			SELECT id, event, COALESCE(json_extract(details, '$.by'), user_id),
+			       CASE WHEN json_extract(details, '$.by') IS NULL THEN username END, ip_address,
+			       CASE WHEN user_id IS NOT NULL THEN 'user' END, user_id,
+			       NULLIF(json_remove(details, '$.by'), '{}'), created_at
+			FROM auth_events;
+			CREATE INDEX index_audit_events_actor_id ON audit_events(actor_id);
+			CREATE INDEX index_audit_events_action ON audit_events(action);
+			CREATE INDEX index_audit_events_resource ON audit_events(resource_type, resource_id);
+		`,
+		Down: `
+			DROP TABLE audit_events;
+		`,
+	},
+	{
+		Version: 16,
+		Name:    "webhooks",
+		// Secrets are sealed like the API keys of users. Deliveries keep the exact payload that is signed, so
+		// retries post the same bytes
+		Up: `
+			CREATE TABLE webhooks (
+				id TEXT PRIMARY KEY,
+				user_id TEXT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+				url TEXT NOT NULL,
+				sealed_secret TEXT NOT NULL,
+				events TEXT NOT NULL,
+				description TEXT NOT NULL DEFAULT '',
+				active BOOLEAN NOT NULL DEFAULT 1,
+				created_at DATETIME NOT NULL,
+				updated_at DATETIME NOT NULL
+			);
+			CREATE INDEX index_webhooks_user_id ON webhooks(user_id);
+			CREATE TABLE webhook_deliveries (
+				id TEXT PRIMARY KEY,
+				webhook_id TEXT NOT NULL REFERENCES webhooks(id) ON DELETE CASCADE,
+				event TEXT NOT NULL,
+				payload TEXT NOT NULL,
+				status TEXT NOT NULL,
+				attempts INTEGER NOT NULL DEFAULT 0,
+				response_status INTEGER,
+				error TEXT,
+				next_attempt_at DATETIME,
+				delivered_at DATETIME,
+				created_at DATETIME NOT NULL
+			);
+			CREATE INDEX index_webhook_deliveries_webhook_id ON webhook_deliveries(webhook_id, created_at);
+			CREATE INDEX index_webhook_deliveries_due ON webhook_deliveries(status, next_attempt_at);
+		`,
+		Down: `
+			DROP TABLE webhook_deliveries;
+			DROP TABLE webhooks;
+		`,
+	},
+	{
+		Version: 17,
+		Name:    "job_logs",
+		// The rowid orders the lines of a job and is the sequence clients follow them by
+		Up: `
+			CREATE TABLE job_logs (
+				sequence INTEGER PRIMARY KEY AUTOINCREMENT,
+				job_id TEXT NOT NULL REFERENCES jobs(id) ON DELETE CASCADE,
+				level TEXT NOT NULL,
+				message TEXT NOT NULL,
+				attributes TEXT,
+				created_at DATETIME NOT NULL
+			);
+			CREATE INDEX index_job_logs_job_id ON job_logs(job_id, sequence);
+		`,
+		Down: `
+			DROP TABLE job_logs;
+		`,
+	},
+	{
+		Version: 18,
+		Name:    "generation_presets",
+		Up: `
+			CREATE TABLE generation_presets (
+				id TEXT PRIMARY KEY,
+				user_id TEXT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+				name TEXT NOT NULL,
+				description TEXT NOT NULL DEFAULT '',
+				settings TEXT NOT NULL,
+				sampling TEXT NOT NULL,
+				created_at DATETIME NOT NULL,
+				updated_at DATETIME NOT NULL,
+				UNIQUE (user_id, name)
+			);
+		`,
+		Down: `
+			DROP TABLE generation_presets;
+		`,
+	},
+	{
+		Version: 19,
+		Name:    "course_overview_tools",
+		// Course overviews synthesize every lecture of an exam and belong to none of them
+		Apply: func(transaction *sql.Tx) error {
+			return rewriteTableDefinition(transaction, "tools",
+				"CHECK(type IN ('guide', 'flashcard', 'quiz', 'custom'))",
+				"CHECK(type IN ('guide', 'flashcard', 'quiz', 'custom', 'course_overview'))")
+		},
+		Revert: func(transaction *sql.Tx) error {
+			if err := refuseRollbackWhileUsed(transaction, "tools", "type = 'course_overview'"); err != nil {
+				return err
+			}
+			return rewriteTableDefinition(transaction, "tools",
+				"CHECK(type IN ('guide', 'flashcard', 'quiz', 'custom', 'course_overview'))",
+				"CHECK(type IN ('guide', 'flashcard', 'quiz', 'custom'))")
+		},
+	},
+	{
+		Version: 20,
+		Name:    "mindmap_tools",
+		Apply: func(transaction *sql.Tx) error {
+			return rewriteTableDefinition(transaction, "tools",
+				"CHECK(type IN ('guide', 'flashcard', 'quiz', 'custom', 'course_overview'))",
+				"CHECK(type IN ('guide', 'flashcard', 'quiz', 'custom', 'course_overview', 'mindmap'))")
+		},
+		Revert: func(transaction *sql.Tx) error {
+			if err := refuseRollbackWhileUsed(transaction, "tools", "type = 'mindmap'"); err != nil {
+				return err
+			}
+			return rewriteTableDefinition(transaction, "tools",
+				"CHECK(type IN ('guide', 'flashcard', 'quiz', 'custom', 'course_overview', 'mindmap'))",
+				"CHECK(type IN ('guide', 'flashcard', 'quiz', 'custom', 'course_overview'))")
+		},
+	},
+	{
+		Version: 21,
+		Name:    "mock_exam_tools",
+		// Mock exams draw their questions from several lectures of an exam and belong to none of them
+		Apply: func(transaction *sql.Tx) error {
+			return rewriteTableDefinition(transaction, "tools",
+				"CHECK(type IN ('guide', 'flashcard', 'quiz', 'custom', 'course_overview', 'mindmap'))",
+				"CHECK(type IN ('guide', 'flashcard', 'quiz', 'custom', 'course_overview', 'mindmap', 'mock_exam'))")
+		},
+		Revert: func(transaction *sql.Tx) error {
+			if err := refuseRollbackWhileUsed(transaction, "tools", "type = 'mock_exam'"); err != nil {
+				return err
+			}
+			return rewriteTableDefinition(transaction, "tools",
+				"CHECK(type IN ('guide', 'flashcard', 'quiz', 'custom', 'course_overview', 'mindmap', 'mock_exam'))",
+				"CHECK(type IN ('guide', 'flashcard', 'quiz', 'custom', 'course_overview', 'mindmap'))")
+		},
+	},
+	{
+		Version: 22,
+		Name:    "bookmark_tools",
+		// Flashcards generated from the bookmarks of a user are a deck of their own, next to the lecture's
+		Up: `
+			ALTER TABLE tools ADD COLUMN bookmark_user_id TEXT REFERENCES users(id) ON DELETE CASCADE;
+			ALTER TABLE tool_versions ADD COLUMN bookmark_user_id TEXT;
+		`,
+		Down: `
+			ALTER TABLE tool_versions DROP COLUMN bookmark_user_id;
+			ALTER TABLE tools DROP COLUMN bookmark_user_id;
+		`,
+	},
+	{
+		Version: 23,
+		Name:    "tool_redaction_flags",
+		// Set on the tools that may quote a redacted time range of the transcript, until they are regenerated
+		Up: `
+			ALTER TABLE tools ADD COLUMN redaction_pending BOOLEAN NOT NULL DEFAULT 0;
+		`,
+		Down: `
+			ALTER TABLE tools DROP COLUMN redaction_pending;
+		`,
+	},
+	{
+		Version: 24,
+		Name:    "job_billed_users",
+		// The user a job is charged to, which is the owner of its exam when they pay for the jobs of its
+		// members. Jobs that already spent something take it from the cost ledger
+		Up: `
+			ALTER TABLE jobs ADD COLUMN billed_user_id TEXT;
+			UPDATE jobs SET billed_user_id = (SELECT cost_ledger.user_id FROM cost_ledger WHERE cost_ledger.job_id = jobs.id LIMIT 1);
+			CREATE INDEX index_jobs_billed_user_id ON jobs(billed_user_id);
+		`,
+		Down: `
+			DROP INDEX index_jobs_billed_user_id;
+			ALTER TABLE jobs DROP COLUMN billed_user_id;
+		`,
+	},
+	{
+		Version: 25,
+		Name:    "job_dead_letter_expiry",
+		// Set when a failed job leaves the dead-letter queue at the end of its retention; the job is compacted
+		// rather than deleted, so what it spent stays in the usage reports
+		Up: `
+			ALTER TABLE jobs ADD COLUMN dead_letter_expired_at DATETIME;
+		`,
+		Down: `
+			ALTER TABLE jobs DROP COLUMN dead_letter_expired_at;
+		`,
+	},
+	{
+		Version: 26,
+		Name:    "tool_section_exams",
+		// Course overviews record their sections too; they belong to the exam and to no lecture. Rolling back
+		// drops those sections
+		Up: `
+			ALTER TABLE tool_sections ADD COLUMN exam_id TEXT REFERENCES exams(id) ON DELETE CASCADE;
+			UPDATE tool_sections SET exam_id = (SELECT lectures.exam_id FROM lectures WHERE lectures.id = tool_sections.lecture_id);
+			CREATE INDEX index_tool_sections_exam_id ON tool_sections(exam_id);
+		`,
+		Apply: func(transaction *sql.Tx) error {
+			return rewriteTableDefinition(transaction, "tool_sections",
+				"lecture_id TEXT NOT NULL REFERENCES lectures(id) ON DELETE CASCADE",
+				"lecture_id TEXT REFERENCES lectures(id) ON DELETE CASCADE")
+		},
+		Down: `
+			DELETE FROM tool_sections WHERE lecture_id IS NULL;
+			DROP INDEX index_tool_sections_exam_id;
+			ALTER TABLE tool_sections DROP COLUMN exam_id;
+		`,
+		Revert: func(transaction *sql.Tx) error {
+			return rewriteTableDefinition(transaction, "tool_sections",
+				"lecture_id TEXT REFERENCES lectures(id) ON DELETE CASCADE",
+				"lecture_id TEXT NOT NULL REFERENCES lectures(id) ON DELETE CASCADE")
+		},
+	},
+}
+
+// LatestMigrationVersion is the schema version this server expects
+func LatestMigrationVersion() int {
+	if len(migrations) == 0 {
+		return 0
+	}
+	return migrations[len(migrations)-1].Version
+}
+
+// ensureMigrationsTable creates the table recording the applied migrations
+func ensureMigrationsTable(database *sql.DB) error {
+	_, err := database.Exec(`
+		CREATE TABLE IF NOT EXISTS schema_migrations (
+			version INTEGER PRIMARY KEY,
+			name TEXT NOT NULL,
+			applied_at DATETIME NOT NULL
+		)
+	`)
+	return err
+}
+
+// appliedMigrations returns when each applied migration was applied, by version
+func appliedMigrations(database *sql.DB) (map[int]time.Time, error) {
+	if err := ensureMigrationsTable(database); err != nil {
+		return nil, err
+	}
+	rows, err := database.Query("SELECT version, applied_at FROM schema_migrations")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	applied := make(map[int]time.Time)
+	for rows.Next() {
+		var version int
+		var appliedAt time.Time
+		if err := rows.Scan(&version, &appliedAt); err != nil {
+			return nil, err
+		}
+		applied[version] = appliedAt
+	}
+	return applied, rows.Err()
+}
+
+// MigrationStatus lists every migration this server knows, in order, with when it was applied
+func MigrationStatus(database *sql.DB) ([]MigrationState, error) {
+	applied, err := appliedMigrations(database)
+	if err != nil {
+		return nil, err
+	}
+	states := make([]MigrationState, 0, len(migrations))
+	for _, migration := range migrations {
+		state := MigrationState{Migration: migration}
+		if appliedAt, isApplied := applied[migration.Version]; isApplied {
+			state.AppliedAt = &appliedAt
+		}
+		states = append(states, state)
+	}
+	return states, nil
+}
+
+// Migrate brings the schema up to date: the base schema, then the pending migrations up to the target version,
+// 0 applying all of them. A database migrated by a newer server is refused rather than used with a schema this
+// server does not know
+func Migrate(database *sql.DB, targetVersion int) error {
+	if err := createSchema(database); err != nil {
+		return fmt.Errorf("failed to create base schema: %w", err)
+	}
+	applied, err := appliedMigrations(database)
+	if err != nil {
+		return fmt.Errorf("failed to read applied migrations: %w", err)
+	}
+	for version := range applied {
+		if version > LatestMigrationVersion() {
+			return fmt.Errorf("database schema version %d is newer than this server supports (%d)", version, LatestMigrationVersion())
+		}
+	}
+
+	for _, migration := range migrations {
+		if targetVersion > 0 && migration.Version > targetVersion {
+			break
+		}
+		if _, isApplied := applied[migration.Version]; isApplied {
+			continue
+		}
+		if err := runMigration(database, migration.Up, func(transaction *sql.Tx) error {
+			if migration.Apply != nil {
+				if err := migration.Apply(transaction); err != nil {
+					return err
+				}
+			}
+			_, err := transaction.Exec("INSERT INTO schema_migrations (version, name, applied_at) VALUES (?, ?, ?)", migration.Version, migration.Name, time.Now())
+			return err
+		}); err != nil {
+			return fmt.Errorf("failed to apply migration %d (%s): %w", migration.Version, migration.Name, err)
+		}
+		slog.Info("Applied schema migration", "version", migration.Version, "name", migration.Name)
+	}
+	return nil
+}
+
+// RollbackMigrations reverts the applied migrations newer than the target version, newest first, and returns
+// them. It is meant for development: a server started afterwards applies them again
+func RollbackMigrations(database *sql.DB, targetVersion int) ([]Migration, error) {
+	applied, err := appliedMigrations(database)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read applied migrations: %w", err)
+	}
+
+	var reverted []Migration
+	for index := len(migrations) - 1; index >= 0; index-- {
+		migration := migrations[index]
+		if migration.Version <= targetVersion {
+			break
+		}
+		if _, isApplied := applied[migration.Version]; !isApplied {
+			continue
+		}
+		if migration.Down == "" && migration.Revert == nil {
+			return reverted, fmt.Errorf("migration %d (%s) cannot be rolled back", migration.Version, migration.Name)
+		}
+		if err := runMigration(database, migration.Down, func(transaction *sql.Tx) error {
+			if migration.Revert != nil {
+				if err := migration.Revert(transaction); err != nil {
+					return err
+				}
+			}
+			_, err := transaction.Exec("DELETE FROM schema_migrations WHERE version = ?", migration.Version)
+			return err
+		}); err != nil {
+			return reverted, fmt.Errorf("failed to roll back migration %d (%s): %w", migration.Version, migration.Name, err)
+		}
+		slog.Info("Rolled back schema migration", "version", migration.Version, "name", migration.Name)
+		reverted = append(reverted, migration)
+	}
+	return reverted, nil
+}
+
+// runMigration runs the statements of a migration and records the change in one transaction
+func runMigration(database *sql.DB, statements string, record func(transaction *sql.Tx) error) error {
+	transaction, err := database.Begin()
+	if err != nil {
+		return err
+	}
+	defer transaction.Rollback()
+
+	if strings.TrimSpace(statements) != "" {
+		if _, err := transaction.Exec(statements); err != nil {
+			return err
+		}
+	}
+	if err := record(transaction); err != nil {
+		return err
+	}
+	return transaction.Commit()
+}
+
+// refuseRollbackWhileUsed fails a rollback while rows of table match condition, as they use a value the schema
+// rolled back to would not allow
+func refuseRollbackWhileUsed(transaction *sql.Tx, table string, condition string) error {
+	var count int
+	if err := transaction.QueryRow("SELECT COUNT(*) FROM " + table + " WHERE " + condition).Scan(&count); err != nil {
+		return err
+	}
+	if count > 0 {
+		return fmt.Errorf("%d rows of %s have %s; delete or change them before rolling back", count, table, condition)
+	}
+	return nil
+}
+
+// rewriteTableDefinition replaces part of the CREATE TABLE statement of a table, such as a CHECK constraint,
+// without rebuilding it: rebuilding a table others reference would cascade their deletion, since foreign keys
+// cannot be turned off within a transaction. Only changes that leave the stored rows valid may be made this
+// way. The schema version is bumped so every connection reloads the schema
+func rewriteTableDefinition(transaction *sql.Tx, table string, oldDefinition string, newDefinition string) error {
+	var definition string
+	if err := transaction.QueryRow("SELECT sql FROM sqlite_schema WHERE type = 'table' AND name = ?", table).Scan(&definition); err != nil {
+		return fmt.Errorf("failed to read the definition of %s: %w", table, err)
+	}
+	if !strings.Contains(definition, oldDefinition) {
+		return fmt.Errorf("the definition of %s does not contain %q", table, oldDefinition)
+	}
+
+	var schemaVersion int
+	if err := transaction.QueryRow("PRAGMA schema_version").Scan(&schemaVersion); err != nil {
+		return err
+	}
+	if _, err := transaction.Exec("PRAGMA writable_schema = ON"); err != nil {
+		return err
+	}
+	_, err := transaction.Exec("UPDATE sqlite_schema SET sql = ? WHERE type = 'table' AND name = ?", strings.Replace(definition, oldDefinition, newDefinition, 1), table)
+	if _, disableError := transaction.Exec("PRAGMA writable_schema = OFF"); err == nil {
+		err = disableError
+	}
+	if err != nil {
+		return fmt.Errorf("failed to rewrite the definition of %s: %w", table, err)
+	}
+	if _, err := transaction.Exec(fmt.Sprintf("PRAGMA schema_version = %d", schemaVersion+1)); err != nil {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,306 @@
+package database
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"lectures/internal/models"
+)
+
+// LowConfidenceThreshold is the segment confidence below which a transcript passage is worth checking by ear
+const LowConfidenceThreshold = 0.5
+
+// confidenceBucketBounds are the lower bounds of the confidence distribution buckets, after the unscored one
+var confidenceBucketBounds = []struct {
+	lowerBound float64
+	label      string
+}{
+	{0, "< 0.50"},
+	{0.5, "0.50 – 0.70"},
+	{0.7, "0.70 – 0.90"},
+	{0.9, "≥ 0.90"},
+}
+
+// reportedStages are the pipeline stages whose attempts the processing report lists
+var reportedStages = []string{"TRANSCRIBE_MEDIA", "INGEST_DOCUMENTS", "INGEST_URL", "IMPORT_YOUTUBE"}
+
+// BuildProcessingReport gathers the processing outcome of a lecture: media durations, transcript confidence,
+// extracted pages, failed attempts and total cost. The Markdown rendering is filled in as well
+func BuildProcessingReport(database *sql.DB, lectureID string) (*models.ProcessingReport, error) {
+	report := &models.ProcessingReport{
+		LectureID:   lectureID,
+		GeneratedAt: time.Now(),
+		Media:       []models.ProcessingReportMedia{},
+		Documents:   []models.ProcessingReportDocument{},
+		Stages:      []models.ProcessingReportStage{},
+	}
+	if err := database.QueryRow("SELECT title FROM lectures WHERE id = ?", lectureID).Scan(&report.LectureTitle); err != nil {
+		return nil, err
+	}
+
+	mediaRows, err := database.Query(`
+		SELECT COALESCE(original_filename, ''), COALESCE(duration_milliseconds, 0)
+		FROM lecture_media WHERE lecture_id = ?
+		ORDER BY sequence_order ASC
+	`, lectureID)
+	if err != nil {
+		return nil, err
+	}
+	for mediaRows.Next() {
+		var media models.ProcessingReportMedia
+		if err := mediaRows.Scan(&media.Filename, &media.DurationMilliseconds); err != nil {
+			mediaRows.Close()
+			return nil, err
+		}
+		report.Media = append(report.Media, media)
+		report.TotalDurationMilliseconds += media.DurationMilliseconds
+	}
+	mediaRows.Close()
+
+	transcript, err := buildTranscriptReport(database, lectureID)
+	if err != nil {
+		return nil, err
+	}
+	if transcript != nil {
+		report.Transcript = transcript
+		report.TotalCost += transcript.Cost
+	}
+
+	documentRows, err := database.Query(`
+		SELECT id, title, extraction_status, page_count, COALESCE(estimated_cost, 0)
+		FROM reference_documents WHERE lecture_id = ?
+		ORDER BY created_at ASC
+	`, lectureID)
+	if err != nil {
+		return nil, err
+	}
+	var documentIDs []string
+	for documentRows.Next() {
+		var documentID string
+		var document models.ProcessingReportDocument
+		if err := documentRows.Scan(&documentID, &document.Title, &document.Status, &document.PageCount, &document.Cost); err != nil {
+			documentRows.Close()
+			return nil, err
+		}
+		documentIDs = append(documentIDs, documentID)
+		report.Documents = append(report.Documents, document)
+		report.TotalCost += document.Cost
+	}
+	documentRows.Close()
+
+	for documentIndex, documentID := range documentIDs {
+		pageRows, err := database.Query(`
+			SELECT page_number FROM reference_pages
+			WHERE document_id = ? AND TRIM(COALESCE(extracted_text, '')) = ''
+			ORDER BY page_number ASC
+		`, documentID)
+		if err != nil {
+			return nil, err
+		}
+		for pageRows.Next() {
+			var pageNumber int
+			if pageRows.Scan(&pageNumber) == nil {
+				report.Documents[documentIndex].EmptyPages = append(report.Documents[documentIndex].EmptyPages, pageNumber)
+			}
+		}
+		pageRows.Close()
+	}
+
+	for _, jobType := range reportedStages {
+		stage, err := buildStageReport(database, lectureID, jobType)
+		if err != nil {
+			return nil, err
+		}
+		if stage.Attempts > 0 {
+			report.Stages = append(report.Stages, stage)
+		}
+	}
+
+	report.Markdown = RenderProcessingReport(report)
+	return report, nil
+}
+
+func buildTranscriptReport(database *sql.DB, lectureID string) (*models.ProcessingReportTranscript, error) {
+	var transcriptID string
+	transcript := &models.ProcessingReportTranscript{}
+	err := database.QueryRow("SELECT id, status, COALESCE(estimated_cost, 0) FROM transcripts WHERE lecture_id = ?", lectureID).Scan(&transcriptID, &transcript.Status, &transcript.Cost)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := database.Query("SELECT confidence FROM transcript_segments WHERE transcript_id = ?", transcriptID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	unscoredCount := 0
+	bucketCounts := make([]int, len(confidenceBucketBounds))
+	var confidenceSum float64
+	for rows.Next() {
+		var confidence sql.NullFloat64
+		if err := rows.Scan(&confidence); err != nil {
+			return nil, err
+		}
+		transcript.SegmentCount++
+		if !confidence.Valid {
+			unscoredCount++
+			continue
+		}
+		confidenceSum += confidence.Float64
+		if confidence.Float64 < LowConfidenceThreshold {
+			transcript.LowConfidenceSegments++
+		}
+		for bucketIndex := len(confidenceBucketBounds) - 1; bucketIndex >= 0; bucketIndex-- {
+			if confidence.Float64 >= confidenceBucketBounds[bucketIndex].lowerBound || bucketIndex == 0 {
+				bucketCounts[bucketIndex]++
+				break
+			}
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	if scoredCount := transcript.SegmentCount - unscoredCount; scoredCount > 0 {
+		transcript.AverageConfidence = confidenceSum / float64(scoredCount)
+	}
+	transcript.ConfidenceDistribution = append(transcript.ConfidenceDistribution, models.ConfidenceBucket{Label: "unscored", Count: unscoredCount})
+	for bucketIndex, bucket := range confidenceBucketBounds {
+		transcript.ConfidenceDistribution = append(transcript.ConfidenceDistribution, models.ConfidenceBucket{Label: bucket.label, Count: bucketCounts[bucketIndex]})
+	}
+	return transcript, nil
+}
+
+func buildStageReport(database *sql.DB, lectureID string, jobType string) (models.ProcessingReportStage, error) {
+	stage := models.ProcessingReportStage{JobType: jobType}
+	rows, err := database.Query(`
+		SELECT id, status, COALESCE(error, ''), completed_at
+		FROM jobs WHERE lecture_id = ? AND type = ?
+		ORDER BY created_at ASC
+	`, lectureID, jobType)
+	if err != nil {
+		return stage, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var failure models.ProcessingReportFailure
+		var completedAt sql.NullTime
+		if err := rows.Scan(&failure.JobID, &failure.Status, &failure.Error, &completedAt); err != nil {
+			return stage, err
+		}
+		stage.Attempts++
+		if failure.Status != "FAILED" && failure.Status != "CANCELLED" {
+			continue
+		}
+		if completedAt.Valid {
+			failure.FailedAt = &completedAt.Time
+		}
+		stage.Failures = append(stage.Failures, failure)
+	}
+	return stage, rows.Err()
+}
+
+// RenderProcessingReport formats a processing report as Markdown for reading or exporting
+func RenderProcessingReport(report *models.ProcessingReport) string {
+	var builder strings.Builder
+	fmt.Fprintf(&builder, "# Processing report: %s\n\n", report.LectureTitle)
+	fmt.Fprintf(&builder, "Generated on %s. Total estimated cost: $%.4f.\n\n", report.GeneratedAt.Format("2006-01-02 15:04"), report.TotalCost)
+
+	if len(report.Media) > 0 {
+		builder.WriteString("## Media\n\n")
+		for _, media := range report.Media {
+			fmt.Fprintf(&builder, "- %s: %s\n", media.Filename, formatReportDuration(media.DurationMilliseconds))
+		}
+		fmt.Fprintf(&builder, "\nTotal duration: %s.\n\n", formatReportDuration(report.TotalDurationMilliseconds))
+	}
+
+	if transcript := report.Transcript; transcript != nil {
+		builder.WriteString("## Transcript\n\n")
+		fmt.Fprintf(&builder, "Status %s, %d segments, average confidence %.2f, estimated cost $%.4f.\n\n", transcript.Status, transcript.SegmentCount, transcript.AverageConfidence, transcript.Cost)
+		builder.WriteString("| Confidence | Segments |\n|---|---|\n")
+		for _, bucket := range transcript.ConfidenceDistribution {
+			fmt.Fprintf(&builder, "| %s | %d |\n", bucket.Label, bucket.Count)
+		}
+		builder.WriteString("\n")
+		if transcript.LowConfidenceSegments > 0 {
+			fmt.Fprintf(&builder, "%d segment(s) scored below %.2f and may be worth checking against the recording.\n\n", transcript.LowConfidenceSegments, LowConfidenceThreshold)
+		}
+	}
+
+	if len(report.Documents) > 0 {
+		builder.WriteString("## Documents\n\n")
+		for _, document := range report.Documents {
+			fmt.Fprintf(&builder, "- %s: %s, %d page(s), estimated cost $%.4f", document.Title, document.Status, document.PageCount, document.Cost)
+			if len(document.EmptyPages) > 0 {
+				pageNumbers := make([]string, len(document.EmptyPages))
+				for pageIndex, pageNumber := range document.EmptyPages {
+					pageNumbers[pageIndex] = fmt.Sprint(pageNumber)
+				}
+				fmt.Fprintf(&builder, "; no text extracted from page(s) %s", strings.Join(pageNumbers, ", "))
+			}
+			builder.WriteString("\n")
+		}
+		builder.WriteString("\n")
+	}
+
+	if len(report.Stages) > 0 {
+		builder.WriteString("## Attempts\n\n")
+		for _, stage := range report.Stages {
+			fmt.Fprintf(&builder, "- %s: %d attempt(s), %d failed\n", stage.JobType, stage.Attempts, len(stage.Failures))
+			for _, failure := range stage.Failures {
+				errorMessage := failure.Error
+				if errorMessage == "" {
+					errorMessage = strings.ToLower(failure.Status)
+				}
+				fmt.Fprintf(&builder, "  - %s: %s\n", failure.JobID, errorMessage)
+			}
+		}
+	}
+	return strings.TrimRight(builder.String(), "\n") + "\n"
+}
+
+// SaveProcessingReport builds the report of a lecture and stores it, replacing the previous one
+func SaveProcessingReport(database *sql.DB, lectureID string) (*models.ProcessingReport, error) {
+	report, err := BuildProcessingReport(database, lectureID)
+	if err != nil {
+		return nil, err
+	}
+	encodedReport, err := json.Marshal(report)
+	if err != nil {
+		return nil, err
+	}
+	_, err = database.Exec(`
+		INSERT INTO lecture_reports (lecture_id, report, created_at) VALUES (?, ?, ?)
+		ON CONFLICT(lecture_id) DO UPDATE SET report = excluded.report, created_at = excluded.created_at
+	`, lectureID, string(encodedReport), report.GeneratedAt)
+	if err != nil {
+		return nil, err
+	}
+	return report, nil
+}
+
+// GetProcessingReport returns the stored report of a lecture, or sql.ErrNoRows when none was generated yet
+func GetProcessingReport(database *sql.DB, lectureID string) (*models.ProcessingReport, error) {
+	var encodedReport string
+	if err := database.QueryRow("SELECT report FROM lecture_reports WHERE lecture_id = ?", lectureID).Scan(&encodedReport); err != nil {
+		return nil, err
+	}
+	var report models.ProcessingReport
+	if err := json.Unmarshal([]byte(encodedReport), &report); err != nil {
+		return nil, err
+	}
+	return &report, nil
+}
+
+func formatReportDuration(milliseconds int64) string {
+	totalSeconds := milliseconds / 1000
+	return fmt.Sprintf("%02d:%02d:%02d", totalSeconds/3600, totalSeconds%3600/60, totalSeconds%60)
+}
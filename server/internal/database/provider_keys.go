@@ -0,0 +1,63 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+)
+
+// UserProviderKey describes an API key a user stored for a provider, without the key itself
+type UserProviderKey struct {
+	Provider  string    `json:"provider"`
+	KeyHint   string    `json:"key_hint"` // Last characters of the key
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// SetUserProviderKey stores the sealed API key of a user for a provider, replacing the previous one
+func SetUserProviderKey(database *sql.DB, userID string, provider string, sealedKey string, keyHint string) error {
+	_, err := database.Exec(`
+		INSERT INTO user_provider_keys (user_id, provider, sealed_key, key_hint, updated_at)
+		VALUES (?, ?, ?, ?, ?)
+		ON CONFLICT(user_id, provider) DO UPDATE SET sealed_key = excluded.sealed_key, key_hint = excluded.key_hint, updated_at = excluded.updated_at
+	`, userID, provider, sealedKey, keyHint, time.Now())
+	return err
+}
+
+// GetUserProviderKey returns the sealed API key of a user for a provider, or an empty string when they have none
+func GetUserProviderKey(database *sql.DB, userID string, provider string) (string, error) {
+	var sealedKey string
+	err := database.QueryRow("SELECT sealed_key FROM user_provider_keys WHERE user_id = ? AND provider = ?", userID, provider).Scan(&sealedKey)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", nil
+	}
+	return sealedKey, err
+}
+
+// ListUserProviderKeys returns the providers a user stored an API key for
+func ListUserProviderKeys(database *sql.DB, userID string) ([]UserProviderKey, error) {
+	rows, err := database.Query("SELECT provider, key_hint, updated_at FROM user_provider_keys WHERE user_id = ? ORDER BY provider", userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	keys := []UserProviderKey{}
+	for rows.Next() {
+		var key UserProviderKey
+		if err := rows.Scan(&key.Provider, &key.KeyHint, &key.UpdatedAt); err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+	return keys, rows.Err()
+}
+
+// DeleteUserProviderKey removes the API key of a user for a provider, reporting whether there was one
+func DeleteUserProviderKey(database *sql.DB, userID string, provider string) (bool, error) {
+	result, err := database.Exec("DELETE FROM user_provider_keys WHERE user_id = ? AND provider = ?", userID, provider)
+	if err != nil {
+		return false, err
+	}
+	affectedRows, _ := result.RowsAffected()
+	return affectedRows > 0, nil
+}
@@ -6,26 +6,84 @@ import (
 	"time"
 )
 
-// CheckLectureReadiness checks if all processing for a lecture is complete and updates its status
-func CheckLectureReadiness(database *sql.DB, lectureID string) {
-	// 1. Check transcript status
-	var transcriptStatus string
-	err := database.QueryRow("SELECT status FROM transcripts WHERE lecture_id = ?", lectureID).Scan(&transcriptStatus)
+// LectureSources describes how far the processing of a lecture's recording and reference documents has come
+type LectureSources struct {
+	TranscriptStatus    string // Empty when the lecture has no recording
+	CompletedDocuments  int
+	IncompleteDocuments int // Documents still being extracted or whose extraction failed
+}
+
+// TranscriptReady reports whether the transcript is usable, or not needed because there is no recording
+func (sources LectureSources) TranscriptReady() bool {
+	return sources.TranscriptStatus == "completed" || sources.TranscriptStatus == ""
+}
+
+// DocumentsReady reports whether every reference document has been extracted
+func (sources LectureSources) DocumentsReady() bool {
+	return sources.IncompleteDocuments == 0
+}
+
+// Complete reports whether both pipelines have finished, which is when a lecture becomes ready
+func (sources LectureSources) Complete() bool {
+	return sources.TranscriptReady() && sources.DocumentsReady()
+}
+
+// HasUsableSource reports whether at least one source has finished processing, so materials can be generated
+// from part of the lecture
+func (sources LectureSources) HasUsableSource() bool {
+	return sources.TranscriptStatus == "completed" || sources.CompletedDocuments > 0
+}
+
+// GetLectureSources loads the processing state of a lecture's transcript and reference documents
+func GetLectureSources(database *sql.DB, lectureID string) (LectureSources, error) {
+	var sources LectureSources
+	err := database.QueryRow("SELECT status FROM transcripts WHERE lecture_id = ?", lectureID).Scan(&sources.TranscriptStatus)
 	if err != nil && err != sql.ErrNoRows {
-		return
+		return sources, err
 	}
 
-	// 2. Check all reference documents extraction status
-	var pendingDocuments int
-	database.QueryRow("SELECT COUNT(*) FROM reference_documents WHERE lecture_id = ? AND extraction_status != 'completed'", lectureID).Scan(&pendingDocuments)
+	err = database.QueryRow(`
+		SELECT COALESCE(SUM(CASE WHEN extraction_status = 'completed' THEN 1 ELSE 0 END), 0),
+			COALESCE(SUM(CASE WHEN extraction_status != 'completed' THEN 1 ELSE 0 END), 0)
+		FROM reference_documents WHERE lecture_id = ?
+	`, lectureID).Scan(&sources.CompletedDocuments, &sources.IncompleteDocuments)
+	return sources, err
+}
+
+// CheckLectureReadiness checks if all processing for a lecture is complete and updates its status
+func CheckLectureReadiness(database *sql.DB, lectureID string) {
+	UpdateLectureReadiness(database, lectureID)
+}
+
+// UpdateLectureReadiness checks if all processing for a lecture is complete and updates its status, reporting
+// whether the lecture just became ready rather than being ready already
+func UpdateLectureReadiness(database *sql.DB, lectureID string) bool {
+	sources, err := GetLectureSources(database, lectureID)
+	if err != nil {
+		return false
+	}
 
 	// A lecture is ready if the transcript is completed (if it exists)
 	// AND all reference documents are completed
-	isTranscriptReady := transcriptStatus == "completed" || transcriptStatus == ""
-	isDocumentsReady := pendingDocuments == 0
-
-	if isTranscriptReady && isDocumentsReady {
-		_, _ = database.Exec("UPDATE lectures SET status = 'ready', updated_at = ? WHERE id = ?", time.Now(), lectureID)
+	if sources.Complete() {
+		// The status is changed by a single conditional update, so of two jobs finishing together only one
+		// sees the lecture become ready
+		becameReady := false
+		result, err := database.Exec("UPDATE lectures SET status = 'ready', updated_at = ? WHERE id = ? AND COALESCE(status, '') != 'ready'", time.Now(), lectureID)
+		if err == nil {
+			updatedRows, _ := result.RowsAffected()
+			becameReady = updatedRows > 0
+		}
+		if !becameReady {
+			_, _ = database.Exec("UPDATE lectures SET updated_at = ? WHERE id = ?", time.Now(), lectureID)
+		}
+		// The content may have changed since the lecture was last indexed for chat retrieval
+		_, _ = database.Exec("DELETE FROM embedding_chunks WHERE lecture_id = ?", lectureID)
 		slog.Info("Lecture is now READY", "lectureID", lectureID)
+		if _, err := SaveProcessingReport(database, lectureID); err != nil {
+			slog.Warn("Failed to generate processing report", "lectureID", lectureID, "error", err)
+		}
+		return becameReady
 	}
+	return false
 }
@@ -0,0 +1,417 @@
+package database
+
+import (
+	"database/sql"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"lectures/internal/models"
+)
+
+// CreateTranscriptRedactions records redactions of a lecture transcript and returns them with their IDs. They
+// take effect once ApplyTranscriptRedactions runs
+func CreateTranscriptRedactions(database *sql.DB, redactions []models.TranscriptRedaction) ([]models.TranscriptRedaction, error) {
+	transaction, err := database.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer transaction.Rollback()
+
+	created := make([]models.TranscriptRedaction, 0, len(redactions))
+	for _, redaction := range redactions {
+		redaction.CreatedAt = time.Now()
+		result, err := transaction.Exec(`
+			INSERT INTO transcript_redactions (lecture_id, user_id, phrase, start_millisecond, end_millisecond, bleep_audio, created_at)
+			VALUES (?, NULLIF(?, ''), NULLIF(?, ''), ?, ?, ?, ?)
+		`, redaction.LectureID, redaction.UserID, redaction.Phrase, redaction.StartMillisecond, redaction.EndMillisecond, redaction.BleepAudio, redaction.CreatedAt)
+		if err != nil {
+			return nil, fmt.Errorf("failed to record redaction: %w", err)
+		}
+		redaction.ID, _ = result.LastInsertId()
+		created = append(created, redaction)
+	}
+	return created, transaction.Commit()
+}
+
+// ListTranscriptRedactions returns the redactions of a lecture transcript, oldest first
+func ListTranscriptRedactions(database *sql.DB, lectureID string) ([]models.TranscriptRedaction, error) {
+	rows, err := database.Query(`
+		SELECT id, lecture_id, COALESCE(user_id, ''), COALESCE(phrase, ''), start_millisecond, end_millisecond, bleep_audio, created_at
+		FROM transcript_redactions
+		WHERE lecture_id = ?
+		ORDER BY id
+	`, lectureID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	redactions := []models.TranscriptRedaction{}
+	for rows.Next() {
+		var redaction models.TranscriptRedaction
+		var startMillisecond, endMillisecond sql.NullInt64
+		if err := rows.Scan(&redaction.ID, &redaction.LectureID, &redaction.UserID, &redaction.Phrase, &startMillisecond, &endMillisecond, &redaction.BleepAudio, &redaction.CreatedAt); err != nil {
+			return nil, err
+		}
+		if startMillisecond.Valid && endMillisecond.Valid {
+			redaction.StartMillisecond = &startMillisecond.Int64
+			redaction.EndMillisecond = &endMillisecond.Int64
+		}
+		redactions = append(redactions, redaction)
+	}
+	return redactions, rows.Err()
+}
+
+// TranscriptRedactionResult is what applying the redactions of a lecture changed
+type TranscriptRedactionResult struct {
+	RedactedSegments int      // Segments of the transcript whose text changed
+	PurgedExportKeys []string // Object keys of the purged exports, to delete from the object store
+}
+
+// ApplyTranscriptRedactions hides the redactions of a lecture from its transcript, raw and polished, and its
+// phrases from what was made from it: the content and saved versions of the tools of the lecture and of its
+// exam, the generated sections kept for resumed builds, and the chat messages and citations of the exam. Time
+// ranges cannot be told apart in generated content, so the tools that may quote them are flagged with
+// redaction_pending until regenerated, the sections kept for resumed builds are dropped, and chat citations of
+// the ranges lose their excerpt. The recap and retrieval chunks are dropped to be rebuilt from the redacted
+// text, and the stored exports of the exam are purged. It is called again whenever the transcript is rewritten
+func ApplyTranscriptRedactions(database *sql.DB, lectureID string) (TranscriptRedactionResult, error) {
+	var result TranscriptRedactionResult
+	redactions, err := ListTranscriptRedactions(database, lectureID)
+	if err != nil || len(redactions) == 0 {
+		return result, err
+	}
+	if err := RestoreLectureTranscripts(database, lectureID); err != nil {
+		return result, err
+	}
+
+	var phrasePatterns []*regexp.Regexp
+	var timeRanges []models.TimeSpan
+	for _, redaction := range redactions {
+		if redaction.Phrase != "" {
+			phrasePatterns = append(phrasePatterns, phrasePattern(redaction.Phrase))
+		} else if redaction.StartMillisecond != nil && redaction.EndMillisecond != nil {
+			timeRanges = append(timeRanges, models.TimeSpan{StartMillisecond: *redaction.StartMillisecond, EndMillisecond: *redaction.EndMillisecond})
+		}
+	}
+
+	transaction, err := database.Begin()
+	if err != nil {
+		return result, err
+	}
+	defer transaction.Rollback()
+
+	var examID string
+	if err := transaction.QueryRow("SELECT exam_id FROM lectures WHERE id = ?", lectureID).Scan(&examID); err != nil {
+		return result, fmt.Errorf("failed to get lecture: %w", err)
+	}
+
+	type segmentUpdate struct {
+		id           int64
+		text         string
+		polishedText sql.NullString
+	}
+	rows, err := transaction.Query(`
+		SELECT transcript_segments.id, transcript_segments.start_millisecond, transcript_segments.end_millisecond,
+		       transcript_segments.text, transcript_segments.polished_text
+		FROM transcript_segments
+		JOIN transcripts ON transcript_segments.transcript_id = transcripts.id
+		WHERE transcripts.lecture_id = ?
+	`, lectureID)
+	if err != nil {
+		return result, fmt.Errorf("failed to read transcript: %w", err)
+	}
+	var updates []segmentUpdate
+	timeRangesRedacted := false
+	for rows.Next() {
+		var update segmentUpdate
+		var startMillisecond, endMillisecond int64
+		if err := rows.Scan(&update.id, &startMillisecond, &endMillisecond, &update.text, &update.polishedText); err != nil {
+			rows.Close()
+			return result, err
+		}
+		originalText, originalPolishedText := update.text, update.polishedText.String
+		inTimeRange := overlapsAny(startMillisecond, endMillisecond, timeRanges)
+		if inTimeRange {
+			update.text = models.RedactedText
+			if update.polishedText.Valid {
+				update.polishedText.String = models.RedactedText
+			}
+		} else {
+			update.text = redactPhrases(update.text, phrasePatterns)
+			update.polishedText.String = redactPhrases(update.polishedText.String, phrasePatterns)
+		}
+		if update.text != originalText || update.polishedText.String != originalPolishedText {
+			updates = append(updates, update)
+			timeRangesRedacted = timeRangesRedacted || inTimeRange
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return result, err
+	}
+	for _, update := range updates {
+		if _, err := transaction.Exec("UPDATE transcript_segments SET text = ?, polished_text = ? WHERE id = ?", update.text, update.polishedText, update.id); err != nil {
+			return result, fmt.Errorf("failed to redact segment: %w", err)
+		}
+	}
+
+	derivedChanged := 0
+	if len(phrasePatterns) > 0 {
+		// Exam-level tools, such as course overviews, belong to no lecture but are made from all of them
+		toolCondition := "(lecture_id = ? OR (lecture_id IS NULL AND exam_id = ?))"
+		chatCondition := "session_id IN (SELECT id FROM chat_sessions WHERE exam_id = ?)"
+		for _, target := range []struct {
+			table, column, condition string
+			arguments                []any
+		}{
+			{"tools", "content", toolCondition, []any{lectureID, examID}},
+			{"tools", "title", toolCondition, []any{lectureID, examID}},
+			{"tool_versions", "content", toolCondition, []any{lectureID, examID}},
+			{"tool_versions", "title", toolCondition, []any{lectureID, examID}},
+			{"tool_sections", "content", toolCondition, []any{lectureID, examID}},
+			{"tool_sections", "title", toolCondition, []any{lectureID, examID}},
+			{"chat_messages", "content", chatCondition, []any{examID}},
+			{"chat_citations", "snippet", "message_id IN (SELECT id FROM chat_messages WHERE " + chatCondition + ")", []any{examID}},
+		} {
+			changed, err := redactColumn(transaction, target.table, target.column, target.condition, target.arguments, phrasePatterns)
+			if err != nil {
+				return result, err
+			}
+			derivedChanged += changed
+		}
+	}
+	if timeRangesRedacted {
+		if _, err := transaction.Exec("UPDATE tools SET redaction_pending = 1 WHERE lecture_id = ? OR (lecture_id IS NULL AND exam_id = ?)", lectureID, examID); err != nil {
+			return result, fmt.Errorf("failed to flag tools: %w", err)
+		}
+		if _, err := transaction.Exec("DELETE FROM tool_sections WHERE (lecture_id = ? OR (lecture_id IS NULL AND exam_id = ?)) AND tool_id IS NULL", lectureID, examID); err != nil {
+			return result, fmt.Errorf("failed to drop generated sections: %w", err)
+		}
+		if err := redactCitationExcerpts(transaction, lectureID, examID, timeRanges); err != nil {
+			return result, err
+		}
+	}
+
+	if len(updates) > 0 || derivedChanged > 0 {
+		if _, err := transaction.Exec("UPDATE transcripts SET updated_at = ? WHERE lecture_id = ?", time.Now(), lectureID); err != nil {
+			return result, err
+		}
+		if _, err := transaction.Exec("DELETE FROM lecture_recaps WHERE lecture_id = ?", lectureID); err != nil {
+			return result, err
+		}
+		if _, err := transaction.Exec("DELETE FROM embedding_chunks WHERE lecture_id = ?", lectureID); err != nil {
+			return result, err
+		}
+		if result.PurgedExportKeys, err = purgeExamExports(transaction, examID); err != nil {
+			return result, err
+		}
+	}
+	result.RedactedSegments = len(updates)
+	return result, transaction.Commit()
+}
+
+// redactColumn redacts phrases from a text column of the rows of a table a condition selects, returning the
+// number of rows changed
+func redactColumn(transaction *sql.Tx, table string, column string, condition string, arguments []any, phrasePatterns []*regexp.Regexp) (int, error) {
+	rows, err := transaction.Query("SELECT id, "+column+" FROM "+table+" WHERE "+condition, arguments...)
+	if err != nil {
+		return 0, fmt.Errorf("failed to read %s: %w", table, err)
+	}
+	type redactedRow struct {
+		id   any
+		text string
+	}
+	var redactedRows []redactedRow
+	for rows.Next() {
+		var row redactedRow
+		var text sql.NullString
+		if err := rows.Scan(&row.id, &text); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		if row.text = redactPhrases(text.String, phrasePatterns); row.text != text.String {
+			redactedRows = append(redactedRows, row)
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+	for _, row := range redactedRows {
+		if _, err := transaction.Exec("UPDATE "+table+" SET "+column+" = ? WHERE id = ?", row.text, row.id); err != nil {
+			return 0, fmt.Errorf("failed to redact %s: %w", table, err)
+		}
+	}
+	return len(redactedRows), nil
+}
+
+// redactCitationExcerpts replaces the excerpts of the chat citations of the exam that quote the transcript of
+// the lecture within the time ranges
+func redactCitationExcerpts(transaction *sql.Tx, lectureID string, examID string, timeRanges []models.TimeSpan) error {
+	for _, timeRange := range timeRanges {
+		_, err := transaction.Exec(`
+			UPDATE chat_citations SET snippet = ?
+			WHERE source_type = 'transcript'
+			AND json_extract(location_data, '$.lecture_id') = ?
+			AND json_extract(location_data, '$.start_millisecond') < ?
+			AND json_extract(location_data, '$.end_millisecond') > ?
+			AND message_id IN (SELECT chat_messages.id FROM chat_messages JOIN chat_sessions ON chat_messages.session_id = chat_sessions.id WHERE chat_sessions.exam_id = ?)
+		`, models.RedactedText, lectureID, timeRange.EndMillisecond, timeRange.StartMillisecond, examID)
+		if err != nil {
+			return fmt.Errorf("failed to redact chat citations: %w", err)
+		}
+	}
+	return nil
+}
+
+// purgeExamExports drops the stored files of the exports and bundles of an exam, made before a redaction, and
+// returns the object keys of those kept in the object store
+func purgeExamExports(transaction *sql.Tx, examID string) ([]string, error) {
+	return purgeExports(transaction, "course_id = ? AND type IN (?, ?)", examID, models.JobTypePublishMaterial, models.JobTypePublishBundle)
+}
+
+// PurgeToolExports drops the stored files of the exports of a tool and of the bundles including it, made from
+// content it no longer has, and returns the object keys of those kept in the object store
+func PurgeToolExports(transaction *sql.Tx, toolID string) ([]string, error) {
+	return purgeExports(transaction, `(
+		(type = ? AND json_extract(payload, '$.tool_id') = ?) OR
+		(type = ? AND EXISTS (SELECT 1 FROM json_each(payload, '$.tool_ids') WHERE json_each.value = ?))
+	)`, models.JobTypePublishMaterial, toolID, models.JobTypePublishBundle, toolID)
+}
+
+// InvalidateToolContent drops what was derived from the previous content of an edited tool: its generated
+// sections, which a resumed build or a section regeneration would otherwise reuse, and its stored exports. It
+// returns the object keys of the purged exports kept in the object store
+func InvalidateToolContent(transaction *sql.Tx, toolID string) ([]string, error) {
+	if _, err := transaction.Exec("DELETE FROM tool_sections WHERE tool_id = ?", toolID); err != nil {
+		return nil, fmt.Errorf("failed to drop tool sections: %w", err)
+	}
+	return PurgeToolExports(transaction, toolID)
+}
+
+// purgeExports clears the stored files of the export jobs matching a condition, returning their object keys
+func purgeExports(transaction *sql.Tx, condition string, arguments ...any) ([]string, error) {
+	exportCondition := condition + " AND (export_data IS NOT NULL OR export_object_key IS NOT NULL)"
+	rows, err := transaction.Query("SELECT export_object_key FROM jobs WHERE "+exportCondition+" AND export_object_key IS NOT NULL", arguments...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list exports: %w", err)
+	}
+	var objectKeys []string
+	for rows.Next() {
+		var objectKey string
+		if err := rows.Scan(&objectKey); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		objectKeys = append(objectKeys, objectKey)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	_, err = transaction.Exec("UPDATE jobs SET export_data = NULL, export_object_key = NULL, export_size_bytes = NULL WHERE "+exportCondition, arguments...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to purge exports: %w", err)
+	}
+	return objectKeys, nil
+}
+
+// FindPhraseSpans returns the spans of the segments of a lecture transcript where any of the phrases is said,
+// in order. It must run before the phrases are redacted, to know where to bleep the audio
+func FindPhraseSpans(database *sql.DB, lectureID string, phrases []string) ([]models.TimeSpan, error) {
+	spans := []models.TimeSpan{}
+	if len(phrases) == 0 {
+		return spans, nil
+	}
+	var phrasePatterns []*regexp.Regexp
+	for _, phrase := range phrases {
+		phrasePatterns = append(phrasePatterns, phrasePattern(phrase))
+	}
+
+	rows, err := database.Query(`
+		SELECT transcript_segments.start_millisecond, transcript_segments.end_millisecond,
+		       transcript_segments.text, COALESCE(transcript_segments.polished_text, '')
+		FROM transcript_segments
+		JOIN transcripts ON transcript_segments.transcript_id = transcripts.id
+		WHERE transcripts.lecture_id = ?
+		ORDER BY transcript_segments.start_millisecond
+	`, lectureID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var span models.TimeSpan
+		var text, polishedText string
+		if err := rows.Scan(&span.StartMillisecond, &span.EndMillisecond, &text, &polishedText); err != nil {
+			return nil, err
+		}
+		if redactPhrases(text, phrasePatterns) != text || redactPhrases(polishedText, phrasePatterns) != polishedText {
+			spans = append(spans, span)
+		}
+	}
+	return spans, rows.Err()
+}
+
+// phrasePattern matches a phrase in any case; redactPhrases keeps the matches that are whole words
+func phrasePattern(phrase string) *regexp.Regexp {
+	return regexp.MustCompile("(?i)" + regexp.QuoteMeta(phrase))
+}
+
+// redactPhrases replaces every occurrence of the phrases that is not part of a longer word
+func redactPhrases(text string, phrasePatterns []*regexp.Regexp) string {
+	for _, pattern := range phrasePatterns {
+		var builder strings.Builder
+		lastEnd := 0
+		for _, match := range pattern.FindAllStringIndex(text, -1) {
+			if !isWordEdge(text, match[0], match[1]) {
+				continue
+			}
+			builder.WriteString(text[lastEnd:match[0]])
+			builder.WriteString(models.RedactedText)
+			lastEnd = match[1]
+		}
+		if lastEnd > 0 {
+			builder.WriteString(text[lastEnd:])
+			text = builder.String()
+		}
+	}
+	return text
+}
+
+// isWordEdge reports whether text[start:end] does not continue a word on either side. Go's \b only knows ASCII
+// letters, which would fail on names such as "José"
+func isWordEdge(text string, start int, end int) bool {
+	firstRune, _ := utf8.DecodeRuneInString(text[start:end])
+	lastRune, _ := utf8.DecodeLastRuneInString(text[start:end])
+	if start > 0 && isWordRune(firstRune) {
+		if previousRune, _ := utf8.DecodeLastRuneInString(text[:start]); isWordRune(previousRune) {
+			return false
+		}
+	}
+	if end < len(text) && isWordRune(lastRune) {
+		if nextRune, _ := utf8.DecodeRuneInString(text[end:]); isWordRune(nextRune) {
+			return false
+		}
+	}
+	return true
+}
+
+func isWordRune(character rune) bool {
+	return unicode.IsLetter(character) || unicode.IsDigit(character) || character == '_'
+}
+
+// overlapsAny reports whether a segment overlaps any of the time ranges
+func overlapsAny(startMillisecond int64, endMillisecond int64, timeRanges []models.TimeSpan) bool {
+	for _, timeRange := range timeRanges {
+		if startMillisecond < timeRange.EndMillisecond && endMillisecond > timeRange.StartMillisecond {
+			return true
+		}
+	}
+	return false
+}
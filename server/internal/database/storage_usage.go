@@ -0,0 +1,105 @@
+package database
+
+import (
+	"cmp"
+	"database/sql"
+	"fmt"
+	"slices"
+)
+
+// StorageUsage adds up the bytes of the files kept for exams: lecture media, reference documents, their page
+// images and exports. Files are charged to the exam they belong to, exports included whoever queued them
+type StorageUsage struct {
+	MediaBytes     int64 `json:"media_bytes"`
+	DocumentBytes  int64 `json:"document_bytes"`
+	PageImageBytes int64 `json:"page_image_bytes"`
+	ExportBytes    int64 `json:"export_bytes"`
+	TotalBytes     int64 `json:"total_bytes"`
+}
+
+func (usage *StorageUsage) add(other StorageUsage) {
+	usage.MediaBytes += other.MediaBytes
+	usage.DocumentBytes += other.DocumentBytes
+	usage.PageImageBytes += other.PageImageBytes
+	usage.ExportBytes += other.ExportBytes
+	usage.TotalBytes += other.TotalBytes
+}
+
+// ExamStorageUsage is the storage usage of one exam
+type ExamStorageUsage struct {
+	ExamID string `json:"exam_id"`
+	Title  string `json:"title"`
+	StorageUsage
+}
+
+// examStorageQuery measures the files of each exam: by the size recorded with them, or by their BLOB when they
+// were written before sizes were recorded
+const examStorageQuery = `
+	SELECT exams.id, exams.title,
+		(SELECT COALESCE(SUM(COALESCE(lecture_media.size_bytes, LENGTH(lecture_media.file_data), 0)), 0)
+			FROM lecture_media JOIN lectures ON lecture_media.lecture_id = lectures.id
+			WHERE lectures.exam_id = exams.id),
+		(SELECT COALESCE(SUM(COALESCE(LENGTH(reference_documents.file_data), 0)), 0)
+			FROM reference_documents JOIN lectures ON reference_documents.lecture_id = lectures.id
+			WHERE lectures.exam_id = exams.id),
+		(SELECT COALESCE(SUM(COALESCE(reference_pages.size_bytes, LENGTH(reference_pages.image_data), 0)), 0)
+			FROM reference_pages
+			JOIN reference_documents ON reference_pages.document_id = reference_documents.id
+			JOIN lectures ON reference_documents.lecture_id = lectures.id
+			WHERE lectures.exam_id = exams.id),
+		(SELECT COALESCE(SUM(COALESCE(jobs.export_size_bytes, LENGTH(jobs.export_data), 0)), 0)
+			FROM jobs WHERE jobs.course_id = exams.id)
+	FROM exams
+`
+
+// GetUserStorageUsage returns the storage usage of the exams a user owns, largest first, with their total.
+// Shared exams are charged to their owner
+func GetUserStorageUsage(database *sql.DB, userID string) ([]ExamStorageUsage, StorageUsage, error) {
+	return queryExamStorageUsage(database, examStorageQuery+" WHERE exams.user_id = ?", userID)
+}
+
+// GetTotalStorageUsage returns the storage usage of every exam together
+func GetTotalStorageUsage(database *sql.DB) (StorageUsage, error) {
+	_, total, err := queryExamStorageUsage(database, examStorageQuery)
+	return total, err
+}
+
+func queryExamStorageUsage(database *sql.DB, query string, arguments ...any) ([]ExamStorageUsage, StorageUsage, error) {
+	var total StorageUsage
+	rows, err := database.Query(query+" ORDER BY exams.created_at", arguments...)
+	if err != nil {
+		return nil, total, fmt.Errorf("failed to query storage usage: %w", err)
+	}
+	defer rows.Close()
+
+	exams := []ExamStorageUsage{}
+	for rows.Next() {
+		var exam ExamStorageUsage
+		if err := rows.Scan(&exam.ExamID, &exam.Title, &exam.MediaBytes, &exam.DocumentBytes, &exam.PageImageBytes, &exam.ExportBytes); err != nil {
+			return nil, total, fmt.Errorf("failed to scan storage usage: %w", err)
+		}
+		exam.TotalBytes = exam.MediaBytes + exam.DocumentBytes + exam.PageImageBytes + exam.ExportBytes
+		total.add(exam.StorageUsage)
+		exams = append(exams, exam)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, total, err
+	}
+
+	// Stable, so exams of the same size keep their creation order
+	slices.SortStableFunc(exams, func(first, second ExamStorageUsage) int {
+		return cmp.Compare(second.TotalBytes, first.TotalBytes)
+	})
+	return exams, total, nil
+}
+
+// GetPendingUploadBytes returns the bytes uploads in progress will occupy, those of a user or, with an empty
+// userID, of everyone. The upload exceptUploadID is left out, so it can be checked against a quota itself
+func GetPendingUploadBytes(database *sql.DB, userID string, exceptUploadID string) (int64, error) {
+	var pendingBytes int64
+	err := database.QueryRow(`
+		SELECT COALESCE(SUM(MAX(file_size_bytes, bytes_received)), 0) FROM uploads
+		WHERE (? = '' OR user_id = ?) AND id != ?
+	`, userID, userID, exceptUploadID).Scan(&pendingBytes)
+	return pendingBytes, err
+}
@@ -0,0 +1,234 @@
+package database
+
+import (
+	"bytes"
+	"compress/gzip"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"time"
+)
+
+// archivedSegment is a row of transcript_segments as stored in its transcript's archive. Segments keep their ID
+// so they are restored exactly as they were
+type archivedSegment struct {
+	ID                        int64    `json:"id"`
+	MediaID                   *string  `json:"media_id,omitempty"`
+	StartMillisecond          int64    `json:"start_millisecond"`
+	EndMillisecond            int64    `json:"end_millisecond"`
+	OriginalStartMilliseconds *int64   `json:"original_start_milliseconds,omitempty"`
+	OriginalEndMilliseconds   *int64   `json:"original_end_milliseconds,omitempty"`
+	Text                      string   `json:"text"`
+	PolishedText              *string  `json:"polished_text,omitempty"`
+	Confidence                *float64 `json:"confidence,omitempty"`
+	Speaker                   *string  `json:"speaker,omitempty"`
+}
+
+// ArchiveIdleTranscripts moves the segments of the completed transcripts whose lecture was not updated or
+// restored since the cutoff into compressed archives, skipping lectures with queued or running jobs. It returns
+// how many transcripts were archived
+func ArchiveIdleTranscripts(database *sql.DB, cutoff time.Time) (int, error) {
+	rows, err := database.Query(`
+		SELECT transcripts.id, transcripts.updated_at, transcripts.restored_at, lectures.updated_at
+		FROM transcripts
+		JOIN lectures ON transcripts.lecture_id = lectures.id
+		WHERE transcripts.status = 'completed'
+		  AND transcripts.id NOT IN (SELECT transcript_id FROM transcript_archives)
+		  AND lectures.id NOT IN (SELECT lecture_id FROM jobs WHERE lecture_id IS NOT NULL AND status IN ('PENDING', 'RUNNING'))
+	`)
+	if err != nil {
+		return 0, err
+	}
+
+	var transcriptIDs []string
+	for rows.Next() {
+		var transcriptID string
+		var transcriptUpdatedAt, lectureUpdatedAt time.Time
+		var restoredAt sql.NullTime
+		if err := rows.Scan(&transcriptID, &transcriptUpdatedAt, &restoredAt, &lectureUpdatedAt); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		// Filtered in Go, timestamps not being stored in a comparable format
+		if transcriptUpdatedAt.Before(cutoff) && lectureUpdatedAt.Before(cutoff) && (!restoredAt.Valid || restoredAt.Time.Before(cutoff)) {
+			transcriptIDs = append(transcriptIDs, transcriptID)
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	archivedCount := 0
+	for _, transcriptID := range transcriptIDs {
+		if err := archiveTranscript(database, transcriptID); err != nil {
+			return archivedCount, fmt.Errorf("failed to archive transcript %s: %w", transcriptID, err)
+		}
+		archivedCount++
+	}
+	return archivedCount, nil
+}
+
+// archiveTranscript moves the segments of a transcript into its archive
+func archiveTranscript(database *sql.DB, transcriptID string) error {
+	transaction, err := database.Begin()
+	if err != nil {
+		return err
+	}
+	defer transaction.Rollback()
+
+	rows, err := transaction.Query(`
+		SELECT id, media_id, start_millisecond, end_millisecond, original_start_milliseconds, original_end_milliseconds,
+		       text, polished_text, confidence, speaker
+		FROM transcript_segments
+		WHERE transcript_id = ?
+		ORDER BY id
+	`, transcriptID)
+	if err != nil {
+		return err
+	}
+	segments := []archivedSegment{}
+	for rows.Next() {
+		var segment archivedSegment
+		if err := rows.Scan(&segment.ID, &segment.MediaID, &segment.StartMillisecond, &segment.EndMillisecond, &segment.OriginalStartMilliseconds,
+			&segment.OriginalEndMilliseconds, &segment.Text, &segment.PolishedText, &segment.Confidence, &segment.Speaker); err != nil {
+			rows.Close()
+			return err
+		}
+		segments = append(segments, segment)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	var compressed bytes.Buffer
+	writer := gzip.NewWriter(&compressed)
+	if err := json.NewEncoder(writer).Encode(segments); err != nil {
+		return err
+	}
+	if err := writer.Close(); err != nil {
+		return err
+	}
+
+	if _, err := transaction.Exec("INSERT INTO transcript_archives (transcript_id, segments, segment_count, archived_at) VALUES (?, ?, ?, ?)",
+		transcriptID, compressed.Bytes(), len(segments), time.Now()); err != nil {
+		return err
+	}
+	if _, err := transaction.Exec("DELETE FROM transcript_segments WHERE transcript_id = ?", transcriptID); err != nil {
+		return err
+	}
+	return transaction.Commit()
+}
+
+// RestoreTranscript moves the segments of an archived transcript back into transcript_segments. Transcripts
+// that are not archived are left as they are
+func RestoreTranscript(database *sql.DB, transcriptID string) error {
+	transaction, err := database.Begin()
+	if err != nil {
+		return err
+	}
+	defer transaction.Rollback()
+
+	var compressed []byte
+	err = transaction.QueryRow("SELECT segments FROM transcript_archives WHERE transcript_id = ?", transcriptID).Scan(&compressed)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	reader, err := gzip.NewReader(bytes.NewReader(compressed))
+	if err != nil {
+		return err
+	}
+	decompressed, err := io.ReadAll(reader)
+	if err != nil {
+		return err
+	}
+	var segments []archivedSegment
+	if err := json.Unmarshal(decompressed, &segments); err != nil {
+		return err
+	}
+
+	// A concurrent restore may have won the race, in which case the segments are already back
+	result, err := transaction.Exec("DELETE FROM transcript_archives WHERE transcript_id = ?", transcriptID)
+	if err != nil {
+		return err
+	}
+	if deletedRows, _ := result.RowsAffected(); deletedRows == 0 {
+		return nil
+	}
+
+	for _, segment := range segments {
+		if _, err := transaction.Exec(`
+			INSERT INTO transcript_segments (id, transcript_id, media_id, start_millisecond, end_millisecond, original_start_milliseconds,
+				original_end_milliseconds, text, polished_text, confidence, speaker)
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+		`, segment.ID, transcriptID, segment.MediaID, segment.StartMillisecond, segment.EndMillisecond, segment.OriginalStartMilliseconds,
+			segment.OriginalEndMilliseconds, segment.Text, segment.PolishedText, segment.Confidence, segment.Speaker); err != nil {
+			return err
+		}
+	}
+	if _, err := transaction.Exec("UPDATE transcripts SET restored_at = ? WHERE id = ?", time.Now(), transcriptID); err != nil {
+		return err
+	}
+
+	if err := transaction.Commit(); err != nil {
+		return err
+	}
+	slog.Info("Restored archived transcript", "transcriptID", transcriptID, "segments", len(segments))
+	return nil
+}
+
+// RestoreLectureTranscripts restores the archived transcripts of the given lectures
+func RestoreLectureTranscripts(database *sql.DB, lectureIDs ...string) error {
+	for _, lectureID := range lectureIDs {
+		if err := restoreArchivedTranscripts(database, "SELECT transcripts.id FROM transcripts JOIN transcript_archives ON transcript_archives.transcript_id = transcripts.id WHERE transcripts.lecture_id = ?", lectureID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// RestoreExamTranscripts restores the archived transcripts of every lecture of an exam
+func RestoreExamTranscripts(database *sql.DB, examID string) error {
+	return restoreArchivedTranscripts(database, `
+		SELECT transcripts.id FROM transcripts
+		JOIN transcript_archives ON transcript_archives.transcript_id = transcripts.id
+		JOIN lectures ON transcripts.lecture_id = lectures.id
+		WHERE lectures.exam_id = ?
+	`, examID)
+}
+
+// restoreArchivedTranscripts restores the transcripts the query selects by ID
+func restoreArchivedTranscripts(database *sql.DB, query string, argument string) error {
+	rows, err := database.Query(query, argument)
+	if err != nil {
+		return err
+	}
+	var transcriptIDs []string
+	for rows.Next() {
+		var transcriptID string
+		if err := rows.Scan(&transcriptID); err != nil {
+			rows.Close()
+			return err
+		}
+		transcriptIDs = append(transcriptIDs, transcriptID)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for _, transcriptID := range transcriptIDs {
+		if err := RestoreTranscript(database, transcriptID); err != nil {
+			return fmt.Errorf("failed to restore transcript %s: %w", transcriptID, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,98 @@
+package database
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+
+	"lectures/internal/models"
+)
+
+const uploadColumns = "id, user_id, filename, file_size_bytes, bytes_received, status, last_chunk_at, created_at, updated_at"
+
+// CreateUpload records a new staged upload session for a user
+func CreateUpload(database *sql.DB, uploadID string, userID string, filename string, fileSizeBytes int64) error {
+	_, err := database.Exec(`
+		INSERT INTO uploads (id, user_id, filename, file_size_bytes, bytes_received, status, created_at, updated_at)
+		VALUES (?, ?, ?, ?, 0, ?, ?, ?)
+	`, uploadID, userID, filename, fileSizeBytes, models.UploadStatusUploading, time.Now(), time.Now())
+	return err
+}
+
+// RecordUploadProgress stores the number of bytes received so far and stamps the last chunk time
+func RecordUploadProgress(database *sql.DB, uploadID string, bytesReceived int64) error {
+	_, err := database.Exec(`
+		UPDATE uploads SET bytes_received = ?, last_chunk_at = ?, updated_at = ? WHERE id = ?
+	`, bytesReceived, time.Now(), time.Now(), uploadID)
+	return err
+}
+
+// MarkUploadStaged flags an upload as complete and waiting to be bound to a lecture
+func MarkUploadStaged(database *sql.DB, uploadID string, bytesReceived int64) error {
+	_, err := database.Exec(`
+		UPDATE uploads SET status = ?, bytes_received = ?, updated_at = ? WHERE id = ?
+	`, models.UploadStatusStaged, bytesReceived, time.Now(), uploadID)
+	return err
+}
+
+// GetUpload loads a single upload session
+func GetUpload(database *sql.DB, uploadID string) (models.Upload, error) {
+	return scanUpload(database.QueryRow("SELECT "+uploadColumns+" FROM uploads WHERE id = ?", uploadID))
+}
+
+// ListUploads returns the upload sessions of a user, most recent first
+func ListUploads(database *sql.DB, userID string) ([]models.Upload, error) {
+	rows, err := database.Query("SELECT "+uploadColumns+" FROM uploads WHERE user_id = ? ORDER BY created_at DESC", userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query uploads: %w", err)
+	}
+	defer rows.Close()
+
+	uploads := []models.Upload{}
+	for rows.Next() {
+		upload, scanErr := scanUpload(rows)
+		if scanErr != nil {
+			return nil, fmt.Errorf("failed to scan upload: %w", scanErr)
+		}
+		uploads = append(uploads, upload)
+	}
+	return uploads, rows.Err()
+}
+
+// ListAllUploads returns every upload session, used to reconcile accounting with the staging area
+func ListAllUploads(database *sql.DB) ([]models.Upload, error) {
+	rows, err := database.Query("SELECT " + uploadColumns + " FROM uploads")
+	if err != nil {
+		return nil, fmt.Errorf("failed to query uploads: %w", err)
+	}
+	defer rows.Close()
+
+	uploads := []models.Upload{}
+	for rows.Next() {
+		upload, scanErr := scanUpload(rows)
+		if scanErr != nil {
+			return nil, fmt.Errorf("failed to scan upload: %w", scanErr)
+		}
+		uploads = append(uploads, upload)
+	}
+	return uploads, rows.Err()
+}
+
+type uploadScanner interface {
+	Scan(destination ...any) error
+}
+
+func scanUpload(scanner uploadScanner) (models.Upload, error) {
+	var upload models.Upload
+	var userID, filename sql.NullString
+	var lastChunkAt sql.NullTime
+	if err := scanner.Scan(&upload.ID, &userID, &filename, &upload.FileSizeBytes, &upload.BytesReceived, &upload.Status, &lastChunkAt, &upload.CreatedAt, &upload.UpdatedAt); err != nil {
+		return models.Upload{}, err
+	}
+	upload.UserID = userID.String
+	upload.Filename = filename.String
+	if lastChunkAt.Valid {
+		upload.LastChunkAt = &lastChunkAt.Time
+	}
+	return upload, nil
+}
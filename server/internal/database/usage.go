@@ -0,0 +1,112 @@
+package database
+
+import (
+	"database/sql"
+	"fmt"
+	"sort"
+	"time"
+)
+
+// Usage groupings of GetUserUsage
+const (
+	UsageGroupByDay     = "day"
+	UsageGroupByJobType = "job_type"
+	UsageGroupByModel   = "model"
+	UsageGroupByExam    = "exam"
+)
+
+// IsValidUsageGrouping reports whether groupBy is one of the UsageGroupBy* groupings
+func IsValidUsageGrouping(groupBy string) bool {
+	switch groupBy {
+	case UsageGroupByDay, UsageGroupByJobType, UsageGroupByModel, UsageGroupByExam:
+		return true
+	}
+	return false
+}
+
+// UsageGroup adds up the tokens and cost of the jobs sharing a day, job type, model or exam
+type UsageGroup struct {
+	Key           string  `json:"key"`             // Day as YYYY-MM-DD, job type, model or exam ID; empty for jobs without one
+	Label         string  `json:"label,omitempty"` // Title of the exam when grouped by exam
+	Jobs          int     `json:"jobs"`
+	InputTokens   int     `json:"input_tokens"`
+	OutputTokens  int     `json:"output_tokens"`
+	EstimatedCost float64 `json:"estimated_cost"`
+}
+
+func (group *UsageGroup) add(inputTokens int, outputTokens int, estimatedCost float64) {
+	group.Jobs++
+	group.InputTokens += inputTokens
+	group.OutputTokens += outputTokens
+	group.EstimatedCost += estimatedCost
+}
+
+// GetUserUsage aggregates the token counts and estimated cost of the jobs charged to a user since the given
+// time, with their total. Jobs members queue on an exam whose owner pays for them count for the owner. Days come in order, other groups most expensive first
+func GetUserUsage(database *sql.DB, userID string, groupBy string, since time.Time) ([]UsageGroup, UsageGroup, error) {
+	var total UsageGroup
+	if !IsValidUsageGrouping(groupBy) {
+		return nil, total, fmt.Errorf("invalid usage grouping: %q", groupBy)
+	}
+
+	rows, err := database.Query(`
+		SELECT jobs.type, COALESCE(jobs.model, ''), COALESCE(jobs.course_id, ''), COALESCE(exams.title, ''),
+		       COALESCE(jobs.input_tokens, 0), COALESCE(jobs.output_tokens, 0), COALESCE(jobs.estimated_cost, 0), jobs.created_at
+		FROM jobs
+		LEFT JOIN exams ON exams.id = jobs.course_id
+		WHERE COALESCE(jobs.billed_user_id, jobs.user_id) = ?
+	`, userID)
+	if err != nil {
+		return nil, total, err
+	}
+	defer rows.Close()
+
+	groupsByKey := make(map[string]*UsageGroup)
+	for rows.Next() {
+		var jobType, model, examID, examTitle string
+		var inputTokens, outputTokens int
+		var estimatedCost float64
+		var createdAt time.Time
+		if err := rows.Scan(&jobType, &model, &examID, &examTitle, &inputTokens, &outputTokens, &estimatedCost, &createdAt); err != nil {
+			return nil, total, err
+		}
+		// Filtered in Go like the admin usage statistics, timestamps not being stored in a comparable format
+		if createdAt.Before(since) {
+			continue
+		}
+
+		group := UsageGroup{}
+		switch groupBy {
+		case UsageGroupByDay:
+			group.Key = createdAt.Local().Format("2006-01-02")
+		case UsageGroupByJobType:
+			group.Key = jobType
+		case UsageGroupByModel:
+			group.Key = model
+		case UsageGroupByExam:
+			group.Key, group.Label = examID, examTitle
+		}
+		if existingGroup, ok := groupsByKey[group.Key]; ok {
+			existingGroup.add(inputTokens, outputTokens, estimatedCost)
+		} else {
+			group.add(inputTokens, outputTokens, estimatedCost)
+			groupsByKey[group.Key] = &group
+		}
+		total.add(inputTokens, outputTokens, estimatedCost)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, total, err
+	}
+
+	groups := make([]UsageGroup, 0, len(groupsByKey))
+	for _, group := range groupsByKey {
+		groups = append(groups, *group)
+	}
+	sort.Slice(groups, func(first, second int) bool {
+		if groupBy == UsageGroupByDay || groups[first].EstimatedCost == groups[second].EstimatedCost {
+			return groups[first].Key < groups[second].Key
+		}
+		return groups[first].EstimatedCost > groups[second].EstimatedCost
+	})
+	return groups, total, nil
+}
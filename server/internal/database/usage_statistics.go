@@ -0,0 +1,168 @@
+package database
+
+import (
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"log/slog"
+	"slices"
+	"time"
+)
+
+// UsageEvent is one job or generated tool counted by the admin usage statistics
+type UsageEvent struct {
+	Type          string
+	UserID        string
+	EstimatedCost float64
+	CreatedAt     time.Time
+}
+
+// ListJobUsage returns the jobs created from since until before until, attributed to the user they are billed to
+func ListJobUsage(database *sql.DB, since time.Time, until time.Time) ([]UsageEvent, error) {
+	return listUsageEvents(database, "SELECT type, COALESCE(billed_user_id, user_id), estimated_cost, created_at FROM jobs", since, until)
+}
+
+// ListToolUsage returns the study tools generated from since until before until, attributed to the exam owner
+func ListToolUsage(database *sql.DB, since time.Time, until time.Time) ([]UsageEvent, error) {
+	return listUsageEvents(database, `
+		SELECT tools.type, exams.user_id, tools.estimated_cost, tools.created_at
+		FROM tools
+		JOIN exams ON tools.exam_id = exams.id
+	`, since, until)
+}
+
+// usageTimestampLayouts are the text forms of timestamps the driver leaves unparsed
+var usageTimestampLayouts = []string{time.RFC3339Nano, "2006-01-02 15:04:05.999999999-07:00", "2006-01-02 15:04:05.999999999", "2006-01-02T15:04:05", "2006-01-02"}
+
+// listUsageEvents filters by time in Go because timestamps are not stored in a uniformly comparable format.
+// Rows whose timestamp cannot be read are left out rather than failing the statistics
+func listUsageEvents(database *sql.DB, query string, since time.Time, until time.Time) ([]UsageEvent, error) {
+	rows, err := database.Query(query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var events []UsageEvent
+	skipped := 0
+	for rows.Next() {
+		var event UsageEvent
+		var estimatedCost sql.NullFloat64
+		var createdAt any
+		if err := rows.Scan(&event.Type, &event.UserID, &estimatedCost, &createdAt); err != nil {
+			return nil, err
+		}
+		var parsed bool
+		if event.CreatedAt, parsed = usageTimestamp(createdAt); !parsed {
+			skipped++
+			continue
+		}
+		if event.CreatedAt.Before(since) || !event.CreatedAt.Before(until) {
+			continue
+		}
+		event.EstimatedCost = estimatedCost.Float64
+		events = append(events, event)
+	}
+	if skipped > 0 {
+		slog.Warn("Left usage events with unreadable timestamps out of the statistics", "count", skipped)
+	}
+	return events, rows.Err()
+}
+
+// usageTimestamp reads a timestamp the driver returned as a time or as text
+func usageTimestamp(value any) (time.Time, bool) {
+	var text string
+	switch typed := value.(type) {
+	case time.Time:
+		return typed, true
+	case string:
+		text = typed
+	case []byte:
+		text = string(typed)
+	default:
+		return time.Time{}, false
+	}
+	for _, layout := range usageTimestampLayouts {
+		if parsed, err := time.Parse(layout, text); err == nil {
+			return parsed, true
+		}
+	}
+	return time.Time{}, false
+}
+
+// privacyNoiseKeySettingKey is the settings row holding the secret the noise of the usage statistics derives from
+const privacyNoiseKeySettingKey = "privacy_noise_key"
+
+// PrivacyNoiseKey returns the secret the noise of the usage statistics derives from, created on first use
+func PrivacyNoiseKey(database *sql.DB) ([]byte, error) {
+	randomKey := make([]byte, 32)
+	if _, err := rand.Read(randomKey); err != nil {
+		return nil, err
+	}
+	valueJSON, _ := json.Marshal(hex.EncodeToString(randomKey))
+	if _, err := database.Exec("INSERT OR IGNORE INTO settings (key, value, updated_at) VALUES (?, ?, ?)", privacyNoiseKeySettingKey, string(valueJSON), time.Now()); err != nil {
+		return nil, err
+	}
+
+	var storedJSON, encodedKey string
+	if err := database.QueryRow("SELECT value FROM settings WHERE key = ?", privacyNoiseKeySettingKey).Scan(&storedJSON); err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal([]byte(storedJSON), &encodedKey); err != nil {
+		return nil, err
+	}
+	return hex.DecodeString(encodedKey)
+}
+
+// privacyBudgetSettingKey is the settings row recording the privacy budget the usage statistics spent today
+const privacyBudgetSettingKey = "privacy_budget"
+
+// PrivacyBudget is the privacy budget the usage statistics spent on a UTC day, and the windows released
+type PrivacyBudget struct {
+	Day     string   `json:"day"`
+	Spent   float64  `json:"spent"`
+	Windows []string `json:"windows"`
+}
+
+// SpendPrivacyBudget records the release of a window of the usage statistics on the given UTC day. A window
+// already released that day is free, since it is released again with the same noise; a new one spends cost,
+// and is refused (false) when that would exceed the budget of the day
+func SpendPrivacyBudget(database *sql.DB, day string, window string, cost float64, budget float64) (PrivacyBudget, bool, error) {
+	transaction, err := database.Begin()
+	if err != nil {
+		return PrivacyBudget{}, false, err
+	}
+	defer transaction.Rollback()
+
+	var spending PrivacyBudget
+	var valueJSON string
+	err = transaction.QueryRow("SELECT value FROM settings WHERE key = ?", privacyBudgetSettingKey).Scan(&valueJSON)
+	if err != nil && err != sql.ErrNoRows {
+		return spending, false, err
+	}
+	if err == nil {
+		json.Unmarshal([]byte(valueJSON), &spending)
+	}
+	if spending.Day != day {
+		spending = PrivacyBudget{Day: day}
+	}
+	if slices.Contains(spending.Windows, window) {
+		return spending, true, nil
+	}
+	if spending.Spent+cost > budget {
+		return spending, false, nil
+	}
+
+	spending.Spent += cost
+	spending.Windows = append(spending.Windows, window)
+	updatedJSON, _ := json.Marshal(spending)
+	_, err = transaction.Exec(`
+		INSERT INTO settings (key, value, updated_at) VALUES (?, ?, ?)
+		ON CONFLICT(key) DO UPDATE SET value = excluded.value, updated_at = excluded.updated_at
+	`, privacyBudgetSettingKey, string(updatedJSON), time.Now())
+	if err != nil {
+		return spending, false, err
+	}
+	return spending, true, transaction.Commit()
+}
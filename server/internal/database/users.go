@@ -0,0 +1,40 @@
+package database
+
+import (
+	"database/sql"
+
+	"lectures/internal/models"
+)
+
+// ListUsers returns every user account, oldest first
+func ListUsers(database *sql.DB) ([]models.User, error) {
+	rows, err := database.Query("SELECT id, username, role, COALESCE(email, ''), password_change_required, created_at, updated_at FROM users ORDER BY created_at, username")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	users := []models.User{}
+	for rows.Next() {
+		var user models.User
+		if err := rows.Scan(&user.ID, &user.Username, &user.Role, &user.Email, &user.PasswordChangeRequired, &user.CreatedAt, &user.UpdatedAt); err != nil {
+			return nil, err
+		}
+		users = append(users, user)
+	}
+	return users, rows.Err()
+}
+
+// GetUser returns a user account, or sql.ErrNoRows when it does not exist
+func GetUser(database *sql.DB, userID string) (models.User, error) {
+	var user models.User
+	err := database.QueryRow("SELECT id, username, role, COALESCE(email, ''), password_change_required, created_at, updated_at FROM users WHERE id = ?", userID).Scan(&user.ID, &user.Username, &user.Role, &user.Email, &user.PasswordChangeRequired, &user.CreatedAt, &user.UpdatedAt)
+	return user, err
+}
+
+// CountAdministrators returns the number of accounts with the admin role
+func CountAdministrators(database *sql.DB) (int, error) {
+	var count int
+	err := database.QueryRow("SELECT COUNT(*) FROM users WHERE role = ?", models.UserRoleAdmin).Scan(&count)
+	return count, err
+}
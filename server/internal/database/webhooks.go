@@ -0,0 +1,224 @@
+package database
+
+import (
+	"database/sql"
+	"encoding/json"
+	"time"
+
+	"lectures/internal/models"
+)
+
+// WebhookTarget is an active webhook events are posted to, with its sealed secret
+type WebhookTarget struct {
+	ID           string
+	URL          string
+	SealedSecret string
+	Events       []string
+}
+
+// DueWebhookDelivery is a delivery whose next attempt is due, with the webhook it is posted to
+type DueWebhookDelivery struct {
+	models.WebhookDelivery
+	URL          string
+	SealedSecret string
+}
+
+// webhookColumns are the columns scanned by scanWebhook
+const webhookColumns = "id, url, events, description, active, created_at, updated_at"
+
+// scanWebhook reads a row of webhookColumns
+func scanWebhook(row interface{ Scan(...any) error }) (models.Webhook, error) {
+	var webhook models.Webhook
+	var events string
+	if err := row.Scan(&webhook.ID, &webhook.URL, &events, &webhook.Description, &webhook.Active, &webhook.CreatedAt, &webhook.UpdatedAt); err != nil {
+		return webhook, err
+	}
+	json.Unmarshal([]byte(events), &webhook.Events)
+	return webhook, nil
+}
+
+// CreateWebhook registers a webhook of a user, its secret sealed
+func CreateWebhook(database *sql.DB, userID string, webhook models.Webhook, sealedSecret string) error {
+	events, _ := json.Marshal(webhook.Events)
+	_, err := database.Exec(`
+		INSERT INTO webhooks (id, user_id, url, sealed_secret, events, description, active, created_at, updated_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)
+	`, webhook.ID, userID, webhook.URL, sealedSecret, string(events), webhook.Description, webhook.Active, webhook.CreatedAt, webhook.UpdatedAt)
+	return err
+}
+
+// GetWebhook returns a webhook of a user, or sql.ErrNoRows
+func GetWebhook(database *sql.DB, userID string, webhookID string) (models.Webhook, error) {
+	return scanWebhook(database.QueryRow("SELECT "+webhookColumns+" FROM webhooks WHERE id = ? AND user_id = ?", webhookID, userID))
+}
+
+// ListWebhooks returns the webhooks of a user, oldest first
+func ListWebhooks(database *sql.DB, userID string) ([]models.Webhook, error) {
+	rows, err := database.Query("SELECT "+webhookColumns+" FROM webhooks WHERE user_id = ? ORDER BY created_at, id", userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	webhooks := []models.Webhook{}
+	for rows.Next() {
+		webhook, err := scanWebhook(rows)
+		if err != nil {
+			return nil, err
+		}
+		webhooks = append(webhooks, webhook)
+	}
+	return webhooks, rows.Err()
+}
+
+// UpdateWebhook saves the address, events, description and state of a webhook of a user
+func UpdateWebhook(database *sql.DB, userID string, webhook models.Webhook) error {
+	events, _ := json.Marshal(webhook.Events)
+	_, err := database.Exec(`
+		UPDATE webhooks SET url = ?, events = ?, description = ?, active = ?, updated_at = ?
+		WHERE id = ? AND user_id = ?
+	`, webhook.URL, string(events), webhook.Description, webhook.Active, webhook.UpdatedAt, webhook.ID, userID)
+	return err
+}
+
+// DeleteWebhook removes a webhook of a user and its deliveries, reporting whether there was one
+func DeleteWebhook(database *sql.DB, userID string, webhookID string) (bool, error) {
+	result, err := database.Exec("DELETE FROM webhooks WHERE id = ? AND user_id = ?", webhookID, userID)
+	if err != nil {
+		return false, err
+	}
+	affectedRows, _ := result.RowsAffected()
+	return affectedRows > 0, nil
+}
+
+// ListWebhookTargets returns the active webhooks of a user, or a single one of them when webhookID is set
+func ListWebhookTargets(database *sql.DB, userID string, webhookID string) ([]WebhookTarget, error) {
+	query := "SELECT id, url, sealed_secret, events FROM webhooks WHERE user_id = ? AND active = 1"
+	arguments := []any{userID}
+	if webhookID != "" {
+		query += " AND id = ?"
+		arguments = append(arguments, webhookID)
+	}
+	rows, err := database.Query(query, arguments...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var targets []WebhookTarget
+	for rows.Next() {
+		var target WebhookTarget
+		var events string
+		if err := rows.Scan(&target.ID, &target.URL, &target.SealedSecret, &events); err != nil {
+			return nil, err
+		}
+		json.Unmarshal([]byte(events), &target.Events)
+		targets = append(targets, target)
+	}
+	return targets, rows.Err()
+}
+
+// QueueWebhookDelivery records a delivery to attempt as soon as possible
+func QueueWebhookDelivery(database *sql.DB, delivery models.WebhookDelivery) error {
+	_, err := database.Exec(`
+		INSERT INTO webhook_deliveries (id, webhook_id, event, payload, status, attempts, next_attempt_at, created_at)
+		VALUES (?, ?, ?, ?, ?, 0, ?, ?)
+	`, delivery.ID, delivery.WebhookID, delivery.Event, delivery.Payload, models.WebhookDeliveryStatusPending, delivery.CreatedAt, delivery.CreatedAt)
+	return err
+}
+
+// ClaimDueWebhookDeliveries returns up to limit pending deliveries whose next attempt is due, oldest first, and
+// postpones them by lease so that no other worker or process attempts them meanwhile
+func ClaimDueWebhookDeliveries(database *sql.DB, now time.Time, lease time.Duration, limit int) ([]DueWebhookDelivery, error) {
+	rows, err := database.Query(`
+		SELECT webhook_deliveries.id, webhook_deliveries.webhook_id, webhook_deliveries.event, webhook_deliveries.payload,
+			webhook_deliveries.attempts, webhook_deliveries.created_at, webhooks.url, webhooks.sealed_secret
+		FROM webhook_deliveries
+		JOIN webhooks ON webhook_deliveries.webhook_id = webhooks.id
+		WHERE webhook_deliveries.status = ? AND webhook_deliveries.next_attempt_at <= ? AND webhooks.active = 1
+		ORDER BY webhook_deliveries.next_attempt_at
+		LIMIT ?
+	`, models.WebhookDeliveryStatusPending, now, limit)
+	if err != nil {
+		return nil, err
+	}
+	var candidates []DueWebhookDelivery
+	for rows.Next() {
+		var delivery DueWebhookDelivery
+		if err := rows.Scan(&delivery.ID, &delivery.WebhookID, &delivery.Event, &delivery.Payload, &delivery.Attempts, &delivery.CreatedAt, &delivery.URL, &delivery.SealedSecret); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		delivery.Status = models.WebhookDeliveryStatusPending
+		candidates = append(candidates, delivery)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	var claimed []DueWebhookDelivery
+	for _, delivery := range candidates {
+		result, err := database.Exec(
+			"UPDATE webhook_deliveries SET next_attempt_at = ? WHERE id = ? AND status = ? AND attempts = ? AND next_attempt_at <= ?",
+			now.Add(lease), delivery.ID, models.WebhookDeliveryStatusPending, delivery.Attempts, now,
+		)
+		if err != nil {
+			return claimed, err
+		}
+		if affectedRows, _ := result.RowsAffected(); affectedRows == 1 {
+			claimed = append(claimed, delivery)
+		}
+	}
+	return claimed, nil
+}
+
+// RecordWebhookAttempt saves the outcome of an attempt of a delivery: delivered, retried at nextAttemptAt, or
+// given up when it is nil
+func RecordWebhookAttempt(database *sql.DB, deliveryID string, attempts int, responseStatus int, attemptError string, delivered bool, nextAttemptAt *time.Time) error {
+	status := models.WebhookDeliveryStatusPending
+	var deliveredAt *time.Time
+	switch {
+	case delivered:
+		status = models.WebhookDeliveryStatusDelivered
+		now := time.Now()
+		deliveredAt = &now
+	case nextAttemptAt == nil:
+		status = models.WebhookDeliveryStatusFailed
+	}
+	var responseStatusValue, attemptErrorValue any
+	if responseStatus != 0 {
+		responseStatusValue = responseStatus
+	}
+	if attemptError != "" {
+		attemptErrorValue = attemptError
+	}
+	_, err := database.Exec(`
+		UPDATE webhook_deliveries
+		SET status = ?, attempts = ?, response_status = ?, error = ?, next_attempt_at = ?, delivered_at = ?
+		WHERE id = ?
+	`, status, attempts, responseStatusValue, attemptErrorValue, nextAttemptAt, deliveredAt, deliveryID)
+	return err
+}
+
+// RedeliverWebhookDelivery queues a delivery of a user's webhook again, reporting whether there was one
+func RedeliverWebhookDelivery(database *sql.DB, userID string, deliveryID string) (bool, error) {
+	result, err := database.Exec(`
+		UPDATE webhook_deliveries SET status = ?, attempts = 0, next_attempt_at = ?
+		WHERE id = ? AND webhook_id IN (SELECT id FROM webhooks WHERE user_id = ?)
+	`, models.WebhookDeliveryStatusPending, time.Now(), deliveryID, userID)
+	if err != nil {
+		return false, err
+	}
+	affectedRows, _ := result.RowsAffected()
+	return affectedRows > 0, nil
+}
+
+// PruneWebhookDeliveries removes the deliveries created before a time that are no longer pending
+func PruneWebhookDeliveries(database *sql.DB, before time.Time) (int64, error) {
+	result, err := database.Exec("DELETE FROM webhook_deliveries WHERE created_at < ? AND status != ?", before, models.WebhookDeliveryStatusPending)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
@@ -0,0 +1,265 @@
+package documents
+
+import (
+	"fmt"
+	"strings"
+	"unicode/utf8"
+
+	"lectures/internal/models"
+)
+
+// Defaults used when documents.chunk_size_characters and documents.chunk_overlap_characters are not set
+const (
+	DefaultChunkSizeCharacters    = 2000
+	DefaultChunkOverlapCharacters = 200
+)
+
+// pageMarkerFormat opens the text of each page inside a chunk that spans several pages, so what is drawn from the
+// chunk can still be cited by its exact page
+const pageMarkerFormat = "[Page %d]"
+
+// PageMarker returns the marker that opens the text of a page inside chunk content
+func PageMarker(pageNumber int) string {
+	return fmt.Sprintf(pageMarkerFormat, pageNumber)
+}
+
+// PageMarkerNumber returns the page that a paragraph of chunk content marks the start of, or 0 when it is not a
+// page marker
+func PageMarkerNumber(paragraph string) int {
+	paragraph = strings.TrimSpace(paragraph)
+	var pageNumber int
+	if _, err := fmt.Sscanf(paragraph, pageMarkerFormat, &pageNumber); err != nil || PageMarker(pageNumber) != paragraph {
+		return 0
+	}
+	return pageNumber
+}
+
+// textBlock is a paragraph, heading, list, equation or code block of a single page
+type textBlock struct {
+	pageNumber int
+	text       string
+	isHeading  bool
+}
+
+// SetChunking configures the target size and overlap of the chunks produced by ChunkPages; values that are not
+// positive keep the defaults
+func (processor *Processor) SetChunking(chunkSizeCharacters int, overlapCharacters int) {
+	if chunkSizeCharacters > 0 {
+		processor.chunkSize = chunkSizeCharacters
+	}
+	if overlapCharacters > 0 {
+		processor.chunkOverlap = overlapCharacters
+	}
+}
+
+// ChunkPages splits the extracted pages of a document into chunks using the processor's chunking configuration
+func (processor *Processor) ChunkPages(documentID string, pages []models.ReferencePage) []models.ReferenceChunk {
+	return ChunkPages(documentID, pages, processor.chunkSize, processor.chunkOverlap)
+}
+
+// ChunkPages splits the extracted text of a document into semantically coherent chunks of roughly chunkSize
+// characters. Chunks end at headings and block boundaries and may span pages, in which case the text of each page
+// opens with a page marker (see PageMarkerNumber); a block is only cut inside, at sentence boundaries, when it is
+// larger than a chunk on its own. A chunk that was closed because it grew too large hands its last sentences (up
+// to overlap characters) to the next one, while a heading starts afresh
+func ChunkPages(documentID string, pages []models.ReferencePage, chunkSize int, overlap int) []models.ReferenceChunk {
+	if chunkSize <= 0 {
+		chunkSize = DefaultChunkSizeCharacters
+	}
+	overlap = max(0, min(overlap, chunkSize/2))
+	// A heading does not close a chunk that is still too small to be useful on its own
+	minimumChunkSize := chunkSize / 4
+
+	var chunks []models.ReferenceChunk
+	var current []textBlock
+	currentLength := 0
+	sectionHeading := ""
+	chunkHeading := ""
+
+	flush := func() {
+		if len(current) == 0 {
+			return
+		}
+		spansPages := current[0].pageNumber != current[len(current)-1].pageNumber
+		texts := make([]string, 0, len(current))
+		previousPage := 0
+		for _, block := range current {
+			if spansPages && block.pageNumber != previousPage {
+				texts = append(texts, PageMarker(block.pageNumber))
+				previousPage = block.pageNumber
+			}
+			texts = append(texts, block.text)
+		}
+		chunks = append(chunks, models.ReferenceChunk{
+			DocumentID: documentID,
+			ChunkIndex: len(chunks),
+			StartPage:  current[0].pageNumber,
+			EndPage:    current[len(current)-1].pageNumber,
+			Heading:    chunkHeading,
+			Content:    strings.Join(texts, "\n\n"),
+		})
+		current = nil
+		currentLength = 0
+	}
+
+	for _, block := range splitIntoBlocks(pages, chunkSize) {
+		if block.isHeading {
+			if currentLength >= minimumChunkSize {
+				flush()
+			}
+			sectionHeading = strings.TrimSpace(strings.TrimLeft(block.text, "#"))
+		} else if len(current) > 0 && currentLength+len(block.text) > chunkSize {
+			lastBlock := current[len(current)-1]
+			flush()
+			if tail := overlapTail(lastBlock.text, overlap); tail != "" {
+				current = append(current, textBlock{pageNumber: lastBlock.pageNumber, text: tail})
+				currentLength = len(tail)
+			}
+		}
+
+		// Headings only join chunks that are still small, so they describe most of the chunk
+		if len(current) == 0 || block.isHeading {
+			chunkHeading = sectionHeading
+		}
+		current = append(current, block)
+		currentLength += len(block.text)
+	}
+	flush()
+
+	return chunks
+}
+
+// splitIntoBlocks breaks every page into blocks separated by blank lines and headings, followed by the speaker
+// notes of the page if any. Code fences and display equations are kept whole, and blocks longer than
+// maximumLength are split at sentence boundaries
+func splitIntoBlocks(pages []models.ReferencePage, maximumLength int) []textBlock {
+	var blocks []textBlock
+	for _, page := range pages {
+		var lines []string
+		insideFence := false
+		insideEquation := false
+
+		closeBlock := func() {
+			text := strings.TrimSpace(strings.Join(lines, "\n"))
+			lines = nil
+			if text == "" {
+				return
+			}
+			if len(text) <= maximumLength {
+				blocks = append(blocks, textBlock{pageNumber: page.PageNumber, text: text})
+				return
+			}
+			for _, piece := range splitLongText(text, maximumLength) {
+				blocks = append(blocks, textBlock{pageNumber: page.PageNumber, text: piece})
+			}
+		}
+
+		for _, line := range strings.Split(page.ExtractedText, "\n") {
+			trimmedLine := strings.TrimSpace(line)
+			insideSpecialBlock := insideFence || insideEquation
+
+			if strings.HasPrefix(trimmedLine, "```") {
+				insideFence = !insideFence
+			} else if strings.Count(trimmedLine, "$$")%2 == 1 {
+				insideEquation = !insideEquation
+			}
+
+			switch {
+			case insideSpecialBlock:
+				lines = append(lines, line)
+			case trimmedLine == "":
+				closeBlock()
+			case strings.HasPrefix(trimmedLine, "#"):
+				closeBlock()
+				blocks = append(blocks, textBlock{pageNumber: page.PageNumber, text: trimmedLine, isHeading: true})
+			default:
+				lines = append(lines, line)
+			}
+		}
+		closeBlock()
+
+		// Speaker notes often explain what the slide only hints at, so they travel with the slide's text
+		if page.Metadata != nil && page.Metadata.SpeakerNotes != "" {
+			lines = []string{"Speaker notes: " + page.Metadata.SpeakerNotes}
+			closeBlock()
+		}
+	}
+	return blocks
+}
+
+// splitLongText cuts text into pieces of at most maximumLength characters, preferably between sentences
+func splitLongText(text string, maximumLength int) []string {
+	var pieces []string
+	var builder strings.Builder
+	for _, sentence := range splitSentences(text) {
+		for len(sentence) > maximumLength {
+			// A single sentence larger than a chunk is cut at the last space that fits
+			cut := strings.LastIndex(sentence[:maximumLength], " ")
+			if cut <= 0 {
+				cut = maximumLength
+				for cut > 0 && !utf8.RuneStart(sentence[cut]) {
+					cut--
+				}
+			}
+			if builder.Len() > 0 {
+				pieces = append(pieces, strings.TrimSpace(builder.String()))
+				builder.Reset()
+			}
+			pieces = append(pieces, strings.TrimSpace(sentence[:cut]))
+			sentence = strings.TrimSpace(sentence[cut:])
+		}
+		if builder.Len() > 0 && builder.Len()+len(sentence)+1 > maximumLength {
+			pieces = append(pieces, strings.TrimSpace(builder.String()))
+			builder.Reset()
+		}
+		if builder.Len() > 0 {
+			builder.WriteString(" ")
+		}
+		builder.WriteString(sentence)
+	}
+	if builder.Len() > 0 {
+		pieces = append(pieces, strings.TrimSpace(builder.String()))
+	}
+	return pieces
+}
+
+// splitSentences splits text after sentence-ending punctuation that is followed by whitespace
+func splitSentences(text string) []string {
+	var sentences []string
+	start := 0
+	for index := 0; index < len(text)-1; index++ {
+		switch text[index] {
+		case '.', '!', '?':
+			if next := text[index+1]; next == ' ' || next == '\n' || next == '\t' {
+				if sentence := strings.TrimSpace(text[start : index+1]); sentence != "" {
+					sentences = append(sentences, sentence)
+				}
+				start = index + 1
+			}
+		}
+	}
+	if sentence := strings.TrimSpace(text[start:]); sentence != "" {
+		sentences = append(sentences, sentence)
+	}
+	return sentences
+}
+
+// overlapTail returns the last whole sentences of text that fit in overlap characters
+func overlapTail(text string, overlap int) string {
+	if overlap <= 0 {
+		return ""
+	}
+	sentences := splitSentences(text)
+	tail := ""
+	for index := len(sentences) - 1; index >= 0; index-- {
+		candidate := sentences[index]
+		if tail != "" {
+			candidate += " " + tail
+		}
+		if len(candidate) > overlap {
+			break
+		}
+		tail = candidate
+	}
+	return tail
+}
@@ -0,0 +1,94 @@
+package documents
+
+import (
+	"strings"
+	"testing"
+
+	"lectures/internal/models"
+)
+
+func TestChunkPages_BreaksAtHeadingsAndSpansPages(t *testing.T) {
+	pages := []models.ReferencePage{
+		{PageNumber: 1, ExtractedText: "# Osmosis\n\nWater moves across a membrane towards the higher solute concentration."},
+		{PageNumber: 2, ExtractedText: "The pressure needed to stop this flow is the osmotic pressure.\n\n# Diffusion\n\nParticles spread from high to low concentration."},
+	}
+
+	chunks := ChunkPages("document-1", pages, 200, 0)
+	if len(chunks) != 2 {
+		t.Fatalf("Expected 2 chunks, got %d: %+v", len(chunks), chunks)
+	}
+	if chunks[0].Heading != "Osmosis" || chunks[0].StartPage != 1 || chunks[0].EndPage != 2 {
+		t.Errorf("Unexpected first chunk: %+v", chunks[0])
+	}
+	if chunks[1].Heading != "Diffusion" || chunks[1].StartPage != 2 || chunks[1].ChunkIndex != 1 {
+		t.Errorf("Unexpected second chunk: %+v", chunks[1])
+	}
+	if !strings.HasPrefix(chunks[1].Content, "# Diffusion") {
+		t.Errorf("Expected the second chunk to start with its heading, got %q", chunks[1].Content)
+	}
+}
+
+func TestChunkPages_MarksPagesInsideChunks(t *testing.T) {
+	pages := []models.ReferencePage{
+		{PageNumber: 4, ExtractedText: "Glycolysis splits glucose."},
+		{PageNumber: 5, ExtractedText: "The Krebs cycle follows."},
+	}
+
+	chunks := ChunkPages("document-1", pages, 2000, 0)
+	if len(chunks) != 1 {
+		t.Fatalf("Expected 1 chunk, got %d", len(chunks))
+	}
+	if expected := "[Page 4]\n\nGlycolysis splits glucose.\n\n[Page 5]\n\nThe Krebs cycle follows."; chunks[0].Content != expected {
+		t.Errorf("Expected each page to open with its marker, got %q", chunks[0].Content)
+	}
+	if PageMarkerNumber("[Page 5]") != 5 || PageMarkerNumber("[Page 5] and more") != 0 || PageMarkerNumber("Page 5") != 0 {
+		t.Error("Expected only whole page markers to be recognized")
+	}
+
+	single := ChunkPages("document-1", pages[:1], 2000, 0)
+	if single[0].Content != "Glycolysis splits glucose." {
+		t.Errorf("Expected a single-page chunk to carry no marker, got %q", single[0].Content)
+	}
+}
+
+func TestChunkPages_OverlapsOnSizeBreaks(t *testing.T) {
+	pages := []models.ReferencePage{
+		{PageNumber: 1, ExtractedText: "Enzymes lower activation energy. They are not consumed.\n\nTemperature affects enzyme activity strongly."},
+	}
+
+	chunks := ChunkPages("document-1", pages, 60, 30)
+	if len(chunks) != 2 {
+		t.Fatalf("Expected 2 chunks, got %d: %+v", len(chunks), chunks)
+	}
+	if !strings.HasPrefix(chunks[1].Content, "They are not consumed.") {
+		t.Errorf("Expected the last sentence to be carried over, got %q", chunks[1].Content)
+	}
+}
+
+func TestChunkPages_KeepsCodeFencesWhole(t *testing.T) {
+	pages := []models.ReferencePage{
+		{PageNumber: 1, ExtractedText: "Example:\n\n```\nfor i := range items {\n\n\tprint(i)\n}\n```"},
+	}
+
+	chunks := ChunkPages("document-1", pages, 2000, 200)
+	if len(chunks) != 1 {
+		t.Fatalf("Expected 1 chunk, got %d", len(chunks))
+	}
+	if !strings.Contains(chunks[0].Content, "{\n\n\tprint(i)\n}") {
+		t.Errorf("Expected the code block to stay intact, got %q", chunks[0].Content)
+	}
+}
+
+func TestSplitLongText_CutsAtSentences(t *testing.T) {
+	pieces := splitLongText("One sentence here. Another sentence there. A final one.", 40)
+	if len(pieces) != 2 || pieces[0] != "One sentence here." || pieces[1] != "Another sentence there. A final one." {
+		t.Fatalf("Unexpected pieces: %q", pieces)
+	}
+
+	pieces = splitLongText(strings.Repeat("è", 30), 15)
+	for _, piece := range pieces {
+		if !strings.HasPrefix(piece, "è") {
+			t.Errorf("Expected cuts on rune boundaries, got %q", piece)
+		}
+	}
+}
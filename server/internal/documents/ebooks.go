@@ -0,0 +1,165 @@
+package documents
+
+import (
+	"archive/zip"
+	"fmt"
+	"html"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// maximumEbookEntrySize bounds every file unpacked from an EPUB archive
+const maximumEbookEntrySize = 256 << 20
+
+var (
+	bodyPattern           = regexp.MustCompile(`(?is)<body\b[^>]*>(.*)</body\s*>`)
+	resourceSourcePattern = regexp.MustCompile(`(?i)(\s(?:src|xlink:href)\s*=\s*")([^"]*)(")`)
+)
+
+// BuildEPUBHTML unpacks an EPUB into outputDirectory and writes htmlPath, a single HTML document joining the
+// chapters of its spine in reading order, each starting on a new page. Images keep working because their
+// references are rewritten to the unpacked files. Chapters marked as non-linear (covers, notes pop-ups) are left out
+func BuildEPUBHTML(epubPath string, outputDirectory string, htmlPath string) error {
+	archive, err := zip.OpenReader(epubPath)
+	if err != nil {
+		return fmt.Errorf("failed to open ebook: %w", err)
+	}
+	defer archive.Close()
+
+	files := make(map[string]*zip.File, len(archive.File))
+	for _, file := range archive.File {
+		files[file.Name] = file
+	}
+
+	var container struct {
+		Rootfiles []struct {
+			FullPath string `xml:"full-path,attr"`
+		} `xml:"rootfiles>rootfile"`
+	}
+	if err := decodeZipXML(files, "META-INF/container.xml", &container); err != nil {
+		return err
+	}
+	if len(container.Rootfiles) == 0 {
+		return fmt.Errorf("ebook has no package document")
+	}
+	packagePath := container.Rootfiles[0].FullPath
+
+	var packageDocument struct {
+		Title    string `xml:"metadata>title"`
+		Manifest []struct {
+			ID        string `xml:"id,attr"`
+			Href      string `xml:"href,attr"`
+			MediaType string `xml:"media-type,attr"`
+		} `xml:"manifest>item"`
+		Spine []struct {
+			IDReference string `xml:"idref,attr"`
+			Linear      string `xml:"linear,attr"`
+		} `xml:"spine>itemref"`
+	}
+	if err := decodeZipXML(files, packagePath, &packageDocument); err != nil {
+		return err
+	}
+
+	if err := unpackArchive(archive, outputDirectory); err != nil {
+		return err
+	}
+
+	chapterPaths := make(map[string]string, len(packageDocument.Manifest))
+	for _, item := range packageDocument.Manifest {
+		if item.MediaType == "application/xhtml+xml" || item.MediaType == "text/html" {
+			chapterPaths[item.ID] = resolvePartPath(packagePath, item.Href)
+		}
+	}
+
+	var builder strings.Builder
+	fmt.Fprintf(&builder, "<!DOCTYPE html>\n<html>\n<head>\n<meta charset=\"utf-8\">\n<title>%s</title>\n</head>\n<body>\n", html.EscapeString(strings.TrimSpace(packageDocument.Title)))
+	chapterCount := 0
+	for _, itemReference := range packageDocument.Spine {
+		chapterPath, found := chapterPaths[itemReference.IDReference]
+		if !found || itemReference.Linear == "no" {
+			continue
+		}
+		content, err := os.ReadFile(filepath.Join(outputDirectory, filepath.FromSlash(chapterPath)))
+		if err != nil {
+			return fmt.Errorf("failed to read chapter %s: %w", chapterPath, err)
+		}
+
+		pageBreak := ""
+		if chapterCount > 0 {
+			pageBreak = ` style="page-break-before: always"`
+		}
+		fmt.Fprintf(&builder, "<div%s>\n%s\n</div>\n", pageBreak, rewriteChapterResources(chapterBody(string(content)), outputDirectory, chapterPath))
+		chapterCount++
+	}
+	builder.WriteString("</body>\n</html>\n")
+
+	if chapterCount == 0 {
+		return fmt.Errorf("ebook has no readable chapters")
+	}
+	return os.WriteFile(htmlPath, []byte(builder.String()), 0644)
+}
+
+// chapterBody returns the inner HTML of the body of a chapter, or the whole chapter when it has no body element
+func chapterBody(content string) string {
+	if match := bodyPattern.FindStringSubmatch(content); match != nil {
+		return match[1]
+	}
+	return content
+}
+
+// rewriteChapterResources points the relative image sources of a chapter at the unpacked files
+func rewriteChapterResources(body string, outputDirectory string, chapterPath string) string {
+	return resourceSourcePattern.ReplaceAllStringFunc(body, func(match string) string {
+		groups := resourceSourcePattern.FindStringSubmatch(match)
+		source := html.UnescapeString(groups[2])
+		if source == "" || strings.HasPrefix(source, "#") || strings.HasPrefix(source, "data:") || strings.Contains(source, "://") {
+			return match
+		}
+		source, _, _ = strings.Cut(source, "#")
+		resourcePath := filepath.Join(outputDirectory, filepath.FromSlash(resolvePartPath(chapterPath, source)))
+		return groups[1] + html.EscapeString(resourcePath) + groups[3]
+	})
+}
+
+// unpackArchive extracts every file of an archive into outputDirectory, refusing entries that would escape it
+func unpackArchive(archive *zip.ReadCloser, outputDirectory string) error {
+	for _, file := range archive.File {
+		if file.FileInfo().IsDir() {
+			continue
+		}
+		cleanName := path.Clean(file.Name)
+		if path.IsAbs(cleanName) || cleanName == ".." || strings.HasPrefix(cleanName, "../") {
+			return fmt.Errorf("ebook entry %s points outside the archive", file.Name)
+		}
+
+		destinationPath := filepath.Join(outputDirectory, filepath.FromSlash(cleanName))
+		if err := os.MkdirAll(filepath.Dir(destinationPath), 0755); err != nil {
+			return err
+		}
+		if err := extractZipEntry(file, destinationPath); err != nil {
+			return fmt.Errorf("failed to unpack ebook entry %s: %w", file.Name, err)
+		}
+	}
+	return nil
+}
+
+func extractZipEntry(file *zip.File, destinationPath string) error {
+	reader, err := file.Open()
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+
+	destination, err := os.Create(destinationPath)
+	if err != nil {
+		return err
+	}
+	defer destination.Close()
+
+	_, err = io.Copy(destination, io.LimitReader(reader, maximumEbookEntrySize))
+	return err
+}
@@ -0,0 +1,118 @@
+package documents
+
+import (
+	"archive/zip"
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"lectures/internal/models"
+)
+
+func writeTestEbook(t *testing.T, parts map[string]string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "book.epub")
+	file, err := os.Create(path)
+	if err != nil {
+		t.Fatalf("Failed to create ebook: %v", err)
+	}
+	defer file.Close()
+
+	archive := zip.NewWriter(file)
+	for name, content := range parts {
+		writer, _ := archive.Create(name)
+		writer.Write([]byte(content))
+	}
+	if err := archive.Close(); err != nil {
+		t.Fatalf("Failed to write ebook: %v", err)
+	}
+	return path
+}
+
+func testEbookParts() map[string]string {
+	return map[string]string{
+		"META-INF/container.xml": `<container xmlns="urn:oasis:names:tc:opendocument:xmlns:container"><rootfiles><rootfile full-path="OEBPS/content.opf" media-type="application/oebps-package+xml"/></rootfiles></container>`,
+		"OEBPS/content.opf": `<package xmlns="http://www.idpf.org/2007/opf" xmlns:dc="http://purl.org/dc/elements/1.1/">
+			<metadata><dc:title>Thermodynamics</dc:title></metadata>
+			<manifest>
+				<item id="cover" href="Text/cover.xhtml" media-type="application/xhtml+xml"/>
+				<item id="first" href="Text/first.xhtml" media-type="application/xhtml+xml"/>
+				<item id="second" href="Text/second.xhtml" media-type="application/xhtml+xml"/>
+				<item id="figure" href="Images/engine.png" media-type="image/png"/>
+			</manifest>
+			<spine>
+				<itemref idref="cover" linear="no"/>
+				<itemref idref="first"/>
+				<itemref idref="second"/>
+			</spine>
+		</package>`,
+		"OEBPS/Text/cover.xhtml":  `<html><body><p>Cover page</p></body></html>`,
+		"OEBPS/Text/first.xhtml":  `<html><head><title>One</title></head><body class="chapter"><h1>Heat</h1><img src="../Images/engine.png" alt="Engine"/></body></html>`,
+		"OEBPS/Text/second.xhtml": `<html><body><h1>Entropy</h1><p>Entropy never decreases.</p></body></html>`,
+		"OEBPS/Images/engine.png": "png",
+	}
+}
+
+func TestBuildEPUBHTML_JoinsSpineChapters(t *testing.T) {
+	ebookPath := writeTestEbook(t, testEbookParts())
+	outputDirectory := t.TempDir()
+	htmlPath := filepath.Join(outputDirectory, "book.html")
+
+	if err := BuildEPUBHTML(ebookPath, filepath.Join(outputDirectory, "content"), htmlPath); err != nil {
+		t.Fatalf("BuildEPUBHTML failed: %v", err)
+	}
+	content, _ := os.ReadFile(htmlPath)
+	html := string(content)
+
+	if strings.Contains(html, "Cover page") {
+		t.Error("Expected the non-linear cover to be left out")
+	}
+	if !strings.Contains(html, "<title>Thermodynamics</title>") || strings.Contains(html, "<title>One</title>") {
+		t.Error("Expected the ebook title and no chapter heads")
+	}
+	heatIndex, entropyIndex := strings.Index(html, "<h1>Heat</h1>"), strings.Index(html, "<h1>Entropy</h1>")
+	if heatIndex < 0 || entropyIndex < heatIndex {
+		t.Errorf("Expected the chapters in spine order, got:\n%s", html)
+	}
+	if strings.Count(html, "page-break-before: always") != 1 {
+		t.Error("Expected every chapter after the first to start on a new page")
+	}
+
+	imagePath := filepath.Join(outputDirectory, "content", "OEBPS", "Images", "engine.png")
+	if !strings.Contains(html, `src="`+imagePath+`"`) {
+		t.Errorf("Expected the image to point at the unpacked file, got:\n%s", html)
+	}
+	if _, err := os.Stat(imagePath); err != nil {
+		t.Errorf("Expected the image to be unpacked: %v", err)
+	}
+}
+
+func TestBuildEPUBHTML_RejectsEntriesOutsideTheArchive(t *testing.T) {
+	parts := testEbookParts()
+	parts["../escape.txt"] = "outside"
+	ebookPath := writeTestEbook(t, parts)
+
+	outputDirectory := t.TempDir()
+	err := BuildEPUBHTML(ebookPath, filepath.Join(outputDirectory, "content"), filepath.Join(outputDirectory, "book.html"))
+	if err == nil || !strings.Contains(err.Error(), "outside the archive") {
+		t.Fatalf("Expected the escaping entry to be refused, got %v", err)
+	}
+}
+
+func TestProcessDocument_EbookIsConvertedThroughHTML(t *testing.T) {
+	ebookPath := writeTestEbook(t, testEbookParts())
+
+	converter := &recordingConverter{}
+	processor := NewProcessor(&visionProvider{}, "vision-model", nil, 150, "")
+	processor.SetConverter(converter)
+
+	document := models.ReferenceDocument{ID: "document-1", FilePath: ebookPath}
+	if _, _, err := processor.ProcessDocument(context.Background(), document, t.TempDir(), "en-US", func(int, string) {}); err != nil {
+		t.Fatalf("ProcessDocument failed: %v", err)
+	}
+	if len(converter.convertedDocuments) != 1 || filepath.Base(converter.convertedDocuments[0]) != "document-1.html" {
+		t.Errorf("Expected the joined chapters to be converted to PDF, got %v", converter.convertedDocuments)
+	}
+}
@@ -5,6 +5,8 @@ import (
 	"os"
 	"os/exec"
 	"path/filepath"
+	"slices"
+	"strconv"
 	"strings"
 
 	"lectures/internal/media"
@@ -15,6 +17,15 @@ type DocumentConverter interface {
 	CheckDependencies() error
 	ConvertToPDF(inputPath string, outputPath string) error
 	ExtractPagesAsImages(pdfPath string, outputDirectory string, dpi int) ([]string, error)
+	ConvertImageToPNG(inputPath string, outputPath string) error
+}
+
+// TextExtractor reads page text without the vision model, for documents extracted with OCR
+type TextExtractor interface {
+	// ExtractPageTexts returns the text embedded in each page of a PDF, empty for pages that only hold images
+	ExtractPageTexts(pdfPath string) ([]string, error)
+	// RecognizeText runs OCR on a page image; language is a Tesseract language such as "eng"
+	RecognizeText(imagePath string, language string) (string, error)
 }
 
 // ExternalDocumentConverter implementation that uses Ghostscript and LibreOffice
@@ -99,10 +110,46 @@ func (c *ExternalDocumentConverter) ExtractPagesAsImages(pdfPath string, outputD
 		return nil, fmt.Errorf("ghostscript page extraction failed: %v, stderr: %s", executionError, stderr.String())
 	}
 
-	imageFiles, globError := filepath.Glob(filepath.Join(outputDirectory, "[0-9][0-9][0-9].png"))
+	return numberedFiles(outputDirectory, ".png")
+}
+
+// numberedFiles lists the pages Ghostscript wrote as %03d files with an extension in a directory, in page order.
+// Pages past 999 have more digits, so files are ordered by their number rather than their name
+func numberedFiles(directory string, extension string) ([]string, error) {
+	candidates, globError := filepath.Glob(filepath.Join(directory, "*"+extension))
 	if globError != nil {
 		return nil, globError
 	}
+	pageNumbers := make(map[string]int, len(candidates))
+	var files []string
+	for _, candidate := range candidates {
+		pageNumber, parseError := strconv.Atoi(strings.TrimSuffix(filepath.Base(candidate), extension))
+		if parseError != nil || pageNumber < 0 {
+			continue
+		}
+		pageNumbers[candidate] = pageNumber
+		files = append(files, candidate)
+	}
+	slices.SortFunc(files, func(first string, second string) int {
+		return pageNumbers[first] - pageNumbers[second]
+	})
+	return files, nil
+}
 
-	return imageFiles, nil
+// maximumImageDimension bounds the longest side of uploaded photos, which are often far larger than a vision model needs
+const maximumImageDimension = 2400
+
+// ConvertImageToPNG converts a JPG, PNG or HEIC image to PNG with ffmpeg, downscaling it so that neither side
+// exceeds maximumImageDimension
+func (c *ExternalDocumentConverter) ConvertImageToPNG(inputPath string, outputPath string) error {
+	ffmpeg := media.ResolveBinaryPath("ffmpeg", c.binDir)
+	scaleFilter := fmt.Sprintf("scale='if(gte(iw,ih),min(%[1]d,iw),-2)':'if(gte(iw,ih),-2,min(%[1]d,ih))'", maximumImageDimension)
+	command := exec.Command(ffmpeg, "-y", "-loglevel", "error", "-i", inputPath, "-frames:v", "1", "-vf", scaleFilter, outputPath)
+
+	var stderr strings.Builder
+	command.Stderr = &stderr
+	if executionError := command.Run(); executionError != nil {
+		return fmt.Errorf("ffmpeg image conversion failed: %v, stderr: %s", executionError, stderr.String())
+	}
+	return nil
 }
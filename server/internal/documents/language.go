@@ -0,0 +1,184 @@
+package documents
+
+import (
+	"log/slog"
+	"strings"
+	"unicode"
+
+	"lectures/internal/models"
+)
+
+// minimumDetectionLetters is how many letters a text needs before its language is guessed; title pages and
+// figure-only slides carry too few words to tell
+const minimumDetectionLetters = 60
+
+// minimumStopwordMatches is how many common words a Latin-script text needs in its most likely language
+const minimumStopwordMatches = 3
+
+// languageStopwords lists very common short words of the Latin-script languages told apart by DetectLanguage
+var languageStopwords = map[string][]string{
+	"en": {"the", "and", "of", "to", "is", "in", "that", "for", "are", "with", "this", "as", "be", "by", "which"},
+	"de": {"der", "die", "und", "das", "ist", "nicht", "mit", "den", "von", "zu", "ein", "eine", "sich", "auf", "wird"},
+	"fr": {"le", "la", "les", "et", "des", "est", "une", "du", "dans", "pour", "que", "qui", "sur", "pas", "sont"},
+	"es": {"el", "la", "los", "las", "y", "es", "del", "una", "para", "que", "por", "con", "se", "como", "están"},
+	"it": {"il", "di", "che", "è", "e", "della", "per", "una", "sono", "del", "con", "gli", "non", "nel", "alla"},
+	"pt": {"o", "os", "da", "do", "e", "é", "uma", "para", "que", "com", "não", "dos", "das", "em", "são"},
+	"nl": {"de", "het", "een", "en", "van", "is", "dat", "niet", "op", "te", "voor", "met", "zijn", "wordt", "ook"},
+	"pl": {"i", "w", "nie", "na", "się", "jest", "że", "do", "to", "z", "jak", "oraz", "przez", "dla", "są"},
+	"sv": {"och", "att", "är", "som", "det", "en", "på", "för", "med", "av", "inte", "till", "har", "den", "om"},
+}
+
+// DetectLanguage guesses the ISO 639-1 code of the language a page is written in, from its script and, for Latin
+// script, from its most common words. It returns an empty string when the text is too short or too ambiguous
+func DetectLanguage(text string) string {
+	scriptCounts := make(map[string]int)
+	letterCount := 0
+	for _, character := range text {
+		if !unicode.IsLetter(character) {
+			continue
+		}
+		letterCount++
+		switch {
+		case unicode.In(character, unicode.Hiragana, unicode.Katakana):
+			scriptCounts["ja"]++
+		case unicode.Is(unicode.Han, character):
+			scriptCounts["zh"]++
+		case unicode.Is(unicode.Hangul, character):
+			scriptCounts["ko"]++
+		case unicode.Is(unicode.Cyrillic, character):
+			scriptCounts["cyrillic"]++
+		case unicode.Is(unicode.Greek, character):
+			scriptCounts["el"]++
+		case unicode.Is(unicode.Arabic, character):
+			scriptCounts["ar"]++
+		case unicode.Is(unicode.Hebrew, character):
+			scriptCounts["he"]++
+		case unicode.Is(unicode.Devanagari, character):
+			scriptCounts["hi"]++
+		case unicode.Is(unicode.Latin, character):
+			scriptCounts["latin"]++
+		}
+	}
+	if letterCount < minimumDetectionLetters {
+		return ""
+	}
+
+	dominantScript, dominantCount := "", 0
+	for script, count := range scriptCounts {
+		if count > dominantCount {
+			dominantScript, dominantCount = script, count
+		}
+	}
+	// Math-heavy pages mix Greek symbols into Latin text, so a script only counts once it holds most letters
+	if dominantCount*2 < letterCount {
+		return ""
+	}
+
+	switch dominantScript {
+	case "latin":
+		return detectLatinLanguage(text)
+	case "cyrillic":
+		// Ukrainian has letters Russian lacks
+		if strings.ContainsAny(text, "іїєґІЇЄҐ") {
+			return "uk"
+		}
+		return "ru"
+	case "zh":
+		// Japanese text mixes kanji with kana, which a few kana already give away
+		if scriptCounts["ja"] > 0 {
+			return "ja"
+		}
+		return "zh"
+	}
+	return dominantScript
+}
+
+// detectLatinLanguage scores a Latin-script text against the stopwords of each language; a tie means the text
+// cannot be told apart and yields an empty string
+func detectLatinLanguage(text string) string {
+	wordCounts := make(map[string]int)
+	for _, word := range strings.FieldsFunc(strings.ToLower(text), func(character rune) bool {
+		return !unicode.IsLetter(character)
+	}) {
+		wordCounts[word]++
+	}
+
+	bestLanguage, bestScore, tied := "", 0, false
+	for language, stopwords := range languageStopwords {
+		score := 0
+		for _, stopword := range stopwords {
+			score += wordCounts[stopword]
+		}
+		switch {
+		case score > bestScore:
+			bestLanguage, bestScore, tied = language, score, false
+		case score == bestScore:
+			tied = true
+		}
+	}
+	if tied || bestScore < minimumStopwordMatches {
+		return ""
+	}
+	return bestLanguage
+}
+
+// DominantLanguage returns the language most pages of a document are written in, or an empty string when no
+// page language is known
+func DominantLanguage(pages []models.ReferencePage) string {
+	languageCounts := make(map[string]int)
+	dominantLanguage := ""
+	for _, page := range pages {
+		if page.Language == "" {
+			continue
+		}
+		languageCounts[page.Language]++
+		if languageCounts[page.Language] > languageCounts[dominantLanguage] {
+			dominantLanguage = page.Language
+		}
+	}
+	return dominantLanguage
+}
+
+// SameLanguage reports whether two language codes name the same language, ignoring their region, e.g. "en" and
+// "en-US"
+func SameLanguage(firstCode string, secondCode string) bool {
+	return strings.EqualFold(baseLanguage(firstCode), baseLanguage(secondCode))
+}
+
+func baseLanguage(languageCode string) string {
+	return strings.ToLower(strings.SplitN(strings.ReplaceAll(languageCode, "_", "-"), "-", 2)[0])
+}
+
+// extractionLanguage resolves the language the pages of a document are read in: the one set on the document,
+// otherwise the one detected from the text embedded in its PDF, otherwise fallbackLanguage. pdfPath is empty for
+// photos, which carry no text to detect from
+func (processor *Processor) extractionLanguage(document models.ReferenceDocument, pdfPath string, fallbackLanguage string) string {
+	if document.Language != "" {
+		return document.Language
+	}
+	if pdfPath == "" || processor.textExtractor == nil {
+		return fallbackLanguage
+	}
+	embeddedTexts, extractionError := processor.textExtractor.ExtractPageTexts(pdfPath)
+	if extractionError != nil {
+		return fallbackLanguage
+	}
+	if detectedLanguage := DetectLanguage(strings.Join(embeddedTexts, "\n")); detectedLanguage != "" {
+		if !SameLanguage(detectedLanguage, fallbackLanguage) {
+			slog.Info("Reading document in its detected language", "documentID", document.ID, "language", detectedLanguage, "lectureLanguage", fallbackLanguage)
+		}
+		return detectedLanguage
+	}
+	return fallbackLanguage
+}
+
+// tagPageLanguages sets the language of each page from its extracted text, pages too short to tell being given
+// the language the document was read in
+func tagPageLanguages(pages []models.ReferencePage, documentLanguage string) {
+	for pageIndex := range pages {
+		pages[pageIndex].Language = DetectLanguage(pages[pageIndex].ExtractedText)
+		if pages[pageIndex].Language == "" {
+			pages[pageIndex].Language = documentLanguage
+		}
+	}
+}
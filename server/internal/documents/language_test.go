@@ -0,0 +1,85 @@
+package documents
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"lectures/internal/models"
+)
+
+func TestDetectLanguage(t *testing.T) {
+	testCases := []struct {
+		name     string
+		text     string
+		expected string
+	}{
+		{"English", "The second law of thermodynamics states that the entropy of an isolated system is never decreasing over time.", "en"},
+		{"German", "Der zweite Hauptsatz der Thermodynamik besagt, dass die Entropie eines abgeschlossenen Systems nicht abnimmt und mit der Zeit wächst.", "de"},
+		{"Italian", "Il secondo principio della termodinamica afferma che l'entropia di un sistema isolato non diminuisce mai e che il calore passa per natura dal corpo più caldo.", "it"},
+		{"Russian", "Второе начало термодинамики утверждает, что энтропия изолированной системы не может уменьшаться со временем.", "ru"},
+		{"Japanese", "熱力学第二法則によれば、孤立系のエントロピーは時間とともに減少することはなく、熱は高温の物体から低温の物体へと自然に流れるとされている。これは不可逆過程の本質を示す法則である。", "ja"},
+		{"Too short", "Chapter 1: Entropy", ""},
+		{"Only formulas", "\\[ \\Delta S = \\int \\frac{\\delta Q}{T} \\geq 0 \\] \\[ S = k_B \\ln \\Omega \\] \\[ dU = T dS - p dV \\] \\[ F = U - TS \\]", ""},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			if detected := DetectLanguage(testCase.text); detected != testCase.expected {
+				t.Errorf("Expected %q, got %q", testCase.expected, detected)
+			}
+		})
+	}
+}
+
+// mixedLanguageTextExtractor has German embedded text on the first page and recognizes English on the others
+type mixedLanguageTextExtractor struct {
+	languages []string
+}
+
+func (extractor *mixedLanguageTextExtractor) ExtractPageTexts(pdfPath string) ([]string, error) {
+	return []string{"Der zweite Hauptsatz der Thermodynamik besagt, dass die Entropie eines abgeschlossenen Systems nicht abnimmt.", ""}, nil
+}
+
+func (extractor *mixedLanguageTextExtractor) RecognizeText(imagePath string, language string) (string, error) {
+	extractor.languages = append(extractor.languages, language)
+	return "The entropy of an isolated system never decreases, which is the second law of thermodynamics as it is stated in the textbook.", nil
+}
+
+func TestProcessDocument_DocumentLanguage(t *testing.T) {
+	pdfPath := filepath.Join(t.TempDir(), "skript.pdf")
+	os.WriteFile(pdfPath, []byte("pdf"), 0644)
+
+	processor := NewProcessor(nil, "", nil, 150, "")
+	processor.SetConverter(&pagedConverter{pageCount: 2})
+	processor.SetExtraction(ExtractionMethodOCR, 0)
+	textExtractor := &mixedLanguageTextExtractor{}
+	processor.SetTextExtractor(textExtractor)
+
+	// Without a language of its own, the document is read in the language detected from its embedded text
+	document := models.ReferenceDocument{ID: "skript", FilePath: pdfPath}
+	pages, _, err := processor.ProcessDocument(context.Background(), document, t.TempDir(), "en-US", func(int, string) {})
+	if err != nil {
+		t.Fatalf("ProcessDocument failed: %v", err)
+	}
+	if len(textExtractor.languages) != 1 || textExtractor.languages[0] != "deu" {
+		t.Errorf("Expected the scanned page to be recognized in German, got %v", textExtractor.languages)
+	}
+	if len(pages) != 2 || pages[0].Language != "de" || pages[1].Language != "en" {
+		t.Fatalf("Expected each page to keep its own language, got %+v", pages)
+	}
+	if dominantLanguage := DominantLanguage(pages); dominantLanguage != "de" {
+		t.Errorf("Expected the earliest language to win a tie, got %q", dominantLanguage)
+	}
+
+	// A language set on the document replaces the detection
+	textExtractor.languages = nil
+	document.Language = "fr-FR"
+	if _, _, err := processor.ProcessDocument(context.Background(), document, t.TempDir(), "en-US", func(int, string) {}); err != nil {
+		t.Fatalf("ProcessDocument failed: %v", err)
+	}
+	if len(textExtractor.languages) != 1 || textExtractor.languages[0] != "fra" {
+		t.Errorf("Expected the document language to be used for OCR, got %v", textExtractor.languages)
+	}
+}
@@ -0,0 +1,74 @@
+package documents
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"strings"
+
+	"lectures/internal/markdown"
+	"lectures/internal/models"
+	"lectures/internal/prompts"
+)
+
+// mathRepairAttempts is how many times a page whose equations do not parse is sent back to the vision model
+const mathRepairAttempts = 1
+
+// interpretMathPage transcribes an equation-heavy page with LaTeX-faithful math and checks its equations with the
+// markdown parser. A page whose equations do not parse is repaired against the image, and the transcription with
+// the fewest problems is kept
+func (processor *Processor) interpretMathPage(jobContext context.Context, imagePath string, languageCode string) (string, models.JobMetrics, error) {
+	var ingestPrompt string
+	if processor.promptManager != nil {
+		latexInstructions, _ := processor.promptManager.GetPrompt(prompts.PromptLatexInstructions, nil)
+		languageRequirement, _ := processor.promptManager.GetPrompt(prompts.PromptLanguageRequirement, map[string]string{
+			"language":      languageCode,
+			"language_code": languageCode,
+		})
+
+		var promptError error
+		ingestPrompt, promptError = processor.promptManager.GetPrompt(prompts.PromptIngestDocumentPageMath, map[string]string{
+			"language_requirement": languageRequirement,
+			"latex_instructions":   latexInstructions,
+		})
+		if promptError != nil {
+			return "", models.JobMetrics{}, promptError
+		}
+	} else {
+		// Fallback prompt when promptManager is nil (e.g., in tests)
+		ingestPrompt = fmt.Sprintf("Transcribe this document page, writing every equation in LaTeX exactly as shown. The response must be written in %s.", languageCode)
+	}
+
+	extractedText, metrics, err := processor.askAboutPage(jobContext, imagePath, ingestPrompt)
+	if err != nil {
+		return "", metrics, err
+	}
+
+	issues := markdown.ValidateEquations(extractedText)
+	for attempt := 0; attempt < mathRepairAttempts && len(issues) > 0 && processor.promptManager != nil; attempt++ {
+		repairPrompt, promptError := processor.promptManager.GetPrompt(prompts.PromptRepairPageEquations, map[string]string{
+			"issues":        "- " + strings.Join(issues, "\n- "),
+			"transcription": extractedText,
+		})
+		if promptError != nil {
+			break
+		}
+
+		repairedText, repairMetrics, repairError := processor.askAboutPage(jobContext, imagePath, repairPrompt)
+		metrics.InputTokens += repairMetrics.InputTokens
+		metrics.OutputTokens += repairMetrics.OutputTokens
+		metrics.EstimatedCost += repairMetrics.EstimatedCost
+		if repairError != nil {
+			slog.WarnContext(jobContext, "Failed to repair page equations", "imagePath", imagePath, "error", repairError)
+			break
+		}
+		if repairedIssues := markdown.ValidateEquations(repairedText); len(repairedIssues) < len(issues) {
+			extractedText, issues = repairedText, repairedIssues
+		}
+	}
+	if len(issues) > 0 {
+		slog.WarnContext(jobContext, "Page equations do not parse", "imagePath", imagePath, "issues", issues)
+	}
+
+	return extractedText, metrics, nil
+}
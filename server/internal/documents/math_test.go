@@ -0,0 +1,74 @@
+package documents
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"lectures/internal/llm"
+	"lectures/internal/models"
+	"lectures/internal/prompts"
+)
+
+// equationProvider transcribes a page with a broken equation, then fixes it when asked to repair it
+type equationProvider struct {
+	mutex   sync.Mutex
+	prompts []string
+}
+
+func (provider *equationProvider) Name() string { return "equations" }
+
+func (provider *equationProvider) Chat(_ context.Context, request *llm.ChatRequest) (<-chan llm.ChatResponseChunk, error) {
+	provider.mutex.Lock()
+	defer provider.mutex.Unlock()
+
+	prompt := request.Messages[0].Content[0].Text
+	provider.prompts = append(provider.prompts, prompt)
+	text := "# Gauss's law\n\n\\[\\oint_S \\mathbf{E} \\cdot d\\mathbf{A} = \\frac{Q}{\\varepsilon_0\\]"
+	if strings.Contains(prompt, "Equation Repair Task") {
+		text = "# Gauss's law\n\n\\[\\oint_S \\mathbf{E} \\cdot d\\mathbf{A} = \\frac{Q}{\\varepsilon_0}\\]"
+	}
+
+	responseChannel := make(chan llm.ChatResponseChunk, 1)
+	responseChannel <- llm.ChatResponseChunk{Text: text, InputTokens: 10, OutputTokens: 5}
+	close(responseChannel)
+	return responseChannel, nil
+}
+
+func TestProcessDocument_MathExtraction(t *testing.T) {
+	pdfPath := filepath.Join(t.TempDir(), "electrostatics.pdf")
+	os.WriteFile(pdfPath, []byte("pdf"), 0644)
+
+	provider := &equationProvider{}
+	processor := NewProcessor(provider, "vision-model", prompts.NewManager("../../prompts"), 150, "")
+	processor.SetConverter(&pagedConverter{pageCount: 1})
+
+	document := models.ReferenceDocument{ID: "electrostatics", FilePath: pdfPath, ExtractionMethod: ExtractionMethodMath}
+	pages, metrics, err := processor.ProcessDocument(context.Background(), document, t.TempDir(), "en-US", func(int, string) {})
+	if err != nil {
+		t.Fatalf("ProcessDocument failed: %v", err)
+	}
+
+	if len(provider.prompts) != 2 || !strings.Contains(provider.prompts[0], "exactly as it appears on the page") || !strings.Contains(provider.prompts[1], "unbalanced braces") {
+		t.Fatalf("Expected a math transcription followed by a repair naming the problem, got %d prompts", len(provider.prompts))
+	}
+	if len(pages) != 1 || pages[0].ExtractionSource != ExtractionSourceMath || !strings.Contains(pages[0].ExtractedText, `\varepsilon_0}`) {
+		t.Fatalf("Expected the repaired transcription, got %+v", pages)
+	}
+	if metrics.InputTokens != 20 {
+		t.Errorf("Expected the repair to be counted, got %+v", metrics)
+	}
+
+	summary := processor.SummarizeExtraction(document, pages)
+	if summary.Method != ExtractionMethodMath || summary.MathPages != 1 || summary.VisionPages != 0 || len(summary.EquationIssuePages) != 0 || summary.Reason != "" {
+		t.Errorf("Unexpected extraction summary: %+v", summary)
+	}
+
+	pages[0].ExtractedText = `\(\frac{1}{2\)`
+	if summary := processor.SummarizeExtraction(document, pages); len(summary.EquationIssuePages) != 1 || summary.EquationIssuePages[0] != 1 {
+		t.Errorf("Expected page 1 to be listed with equation issues, got %+v", summary)
+	}
+}
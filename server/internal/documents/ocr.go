@@ -0,0 +1,236 @@
+package documents
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"lectures/internal/markdown"
+	"lectures/internal/media"
+	"lectures/internal/models"
+)
+
+// Extraction methods, set by documents.extraction_method and overridable per document
+const (
+	// ExtractionMethodVision interprets every page with the vision model
+	ExtractionMethodVision = "vision"
+	// ExtractionMethodOCR never calls the vision model: embedded PDF text is used where there is some, and
+	// pages that only hold images are recognized with Tesseract
+	ExtractionMethodOCR = "ocr"
+	// ExtractionMethodAuto uses the vision model unless the document has more pages than
+	// documents.vision_maximum_pages, in which case it is extracted like ExtractionMethodOCR
+	ExtractionMethodAuto = "auto"
+	// ExtractionMethodMath transcribes every page with the vision model, asking for LaTeX-faithful equations that
+	// are checked with the markdown parser, for equation-heavy slides that interpretation would paraphrase
+	ExtractionMethodMath = "math"
+)
+
+// Sources of the text of a page, recorded in models.ReferencePage.ExtractionSource
+const (
+	ExtractionSourceVision       = "vision"
+	ExtractionSourceEmbeddedText = "embedded_text"
+	ExtractionSourceOCR          = "ocr"
+	ExtractionSourceMath         = "math"
+)
+
+// minimumEmbeddedTextCharacters is how much embedded text a page needs to be read without OCR; scanned pages
+// often carry a few stray characters such as a page number or a scanner watermark
+const minimumEmbeddedTextCharacters = 40
+
+// tesseractLanguages maps ISO 639-1 codes to the names of Tesseract's trained data
+var tesseractLanguages = map[string]string{
+	"ar": "ara", "cs": "ces", "da": "dan", "de": "deu", "el": "ell", "en": "eng", "es": "spa", "fi": "fin",
+	"fr": "fra", "he": "heb", "hi": "hin", "hu": "hun", "it": "ita", "ja": "jpn", "ko": "kor", "nl": "nld",
+	"no": "nor", "pl": "pol", "pt": "por", "ro": "ron", "ru": "rus", "sv": "swe", "tr": "tur", "uk": "ukr",
+	"zh": "chi_sim",
+}
+
+// IsExtractionMethod reports whether a method can be requested for a document
+func IsExtractionMethod(method string) bool {
+	return method == ExtractionMethodVision || method == ExtractionMethodOCR || method == ExtractionMethodAuto || method == ExtractionMethodMath
+}
+
+// SetExtraction configures how documents without their own extraction method are read, and the page count above
+// which ExtractionMethodAuto skips the vision model; an unknown method keeps the vision model and a limit that is
+// not positive means no limit
+func (processor *Processor) SetExtraction(method string, visionMaximumPages int) {
+	if IsExtractionMethod(method) {
+		processor.extractionMethod = method
+	}
+	processor.visionMaximumPages = visionMaximumPages
+}
+
+// SetTextExtractor allows overriding the embedded text and OCR extraction (useful for testing)
+func (processor *Processor) SetTextExtractor(textExtractor TextExtractor) {
+	processor.textExtractor = textExtractor
+}
+
+// documentExtractionMethod resolves the method that applies to a document
+func (processor *Processor) documentExtractionMethod(document models.ReferenceDocument) string {
+	if IsExtractionMethod(document.ExtractionMethod) {
+		return document.ExtractionMethod
+	}
+	return processor.extractionMethod
+}
+
+// visionSkipReason explains why a document of pageCount pages is not sent to the vision model, or returns an
+// empty string when it is
+func (processor *Processor) visionSkipReason(method string, pageCount int) string {
+	switch {
+	case method == ExtractionMethodOCR:
+		return "OCR was requested"
+	case processor.llmProvider == nil || processor.llmModel == "":
+		return "no vision model is configured"
+	case method == ExtractionMethodAuto && processor.visionMaximumPages > 0 && pageCount > processor.visionMaximumPages:
+		return fmt.Sprintf("the document has %d pages, more than documents.vision_maximum_pages (%d)", pageCount, processor.visionMaximumPages)
+	}
+	return ""
+}
+
+// SummarizeExtraction counts how the pages of a document were read, for the extraction metadata of the document
+func (processor *Processor) SummarizeExtraction(document models.ReferenceDocument, pages []models.ReferencePage) *models.DocumentExtractionMetadata {
+	method := processor.documentExtractionMethod(document)
+	summary := &models.DocumentExtractionMetadata{Method: method}
+	for _, page := range pages {
+		switch page.ExtractionSource {
+		case ExtractionSourceEmbeddedText:
+			summary.EmbeddedTextPages++
+		case ExtractionSourceOCR:
+			summary.OCRPages++
+		case ExtractionSourceMath:
+			summary.MathPages++
+			if len(markdown.ValidateEquations(page.ExtractedText)) > 0 {
+				summary.EquationIssuePages = append(summary.EquationIssuePages, page.PageNumber)
+			}
+		default:
+			summary.VisionPages++
+		}
+	}
+	if summary.VisionPages == 0 && summary.MathPages == 0 && len(pages) > 0 {
+		summary.Reason = processor.visionSkipReason(method, len(pages))
+	}
+	return summary
+}
+
+// readPagesLocally extracts the pages without the vision model. The embedded text of the PDF is used for the
+// pages that have enough of it, and the others are recognized from their images with OCR; pdfPath is empty
+// for photos, which are always recognized
+func (processor *Processor) readPagesLocally(jobContext context.Context, pdfPath string, imageFiles []string, documentID string, languageCode string, updateProgress func(int, string)) ([]models.ReferencePage, models.JobMetrics, error) {
+	var embeddedTexts []string
+	if pdfPath != "" {
+		updateProgress(15, "Reading embedded text...")
+		var extractionError error
+		if embeddedTexts, extractionError = processor.textExtractor.ExtractPageTexts(pdfPath); extractionError != nil {
+			slog.WarnContext(jobContext, "Failed to read embedded PDF text, recognizing every page", "documentID", documentID, "error", extractionError)
+			embeddedTexts = nil
+		}
+	}
+
+	language := tesseractLanguage(languageCode)
+	readPage := func(workerContext context.Context, pageIndex int) (models.ReferencePage, models.JobMetrics, error) {
+		if workerContext.Err() != nil {
+			return models.ReferencePage{}, models.JobMetrics{}, workerContext.Err()
+		}
+
+		page := models.ReferencePage{DocumentID: documentID, PageNumber: pageIndex + 1, ImagePath: imageFiles[pageIndex]}
+		if pageIndex < len(embeddedTexts) && len(strings.TrimSpace(embeddedTexts[pageIndex])) >= minimumEmbeddedTextCharacters {
+			page.ExtractedText = embeddedTexts[pageIndex]
+			page.ExtractionSource = ExtractionSourceEmbeddedText
+			return page, models.JobMetrics{}, nil
+		}
+		recognizedText, recognitionError := processor.textExtractor.RecognizeText(imageFiles[pageIndex], language)
+		if recognitionError != nil {
+			return models.ReferencePage{}, models.JobMetrics{}, fmt.Errorf("failed to recognize page %d: %w", page.PageNumber, recognitionError)
+		}
+		page.ExtractedText = normalizeExtractedText(recognizedText)
+		page.ExtractionSource = ExtractionSourceOCR
+		return page, models.JobMetrics{}, nil
+	}
+
+	// OCR runs locally, so it is not bound by the model call limit
+	return readPagesInParallel(jobContext, len(imageFiles), processor.pageWorkers(false), readPage, func(completedCount int) {
+		progress := 15 + int(float64(completedCount)/float64(len(imageFiles))*85.0)
+		updateProgress(progress, fmt.Sprintf("Reading page text... (%d/%d)", completedCount, len(imageFiles)))
+	})
+}
+
+// tesseractLanguage picks the Tesseract language for a document language, English when it has no trained data
+func tesseractLanguage(languageCode string) string {
+	if language, found := tesseractLanguages[baseLanguage(languageCode)]; found {
+		return language
+	}
+	return "eng"
+}
+
+// normalizeExtractedText collapses the layout spacing of text read from a PDF or OCR: runs of spaces become one,
+// and consecutive blank lines become a single paragraph break
+func normalizeExtractedText(text string) string {
+	var lines []string
+	blankLine := false
+	for _, line := range strings.Split(strings.ReplaceAll(text, "\f", "\n"), "\n") {
+		line = strings.Join(strings.Fields(line), " ")
+		if line == "" {
+			blankLine = len(lines) > 0
+			continue
+		}
+		if blankLine {
+			lines = append(lines, "")
+			blankLine = false
+		}
+		lines = append(lines, line)
+	}
+	return strings.Join(lines, "\n")
+}
+
+// ExtractPageTexts reads the text embedded in each page of a PDF with Ghostscript's text device
+func (c *ExternalDocumentConverter) ExtractPageTexts(pdfPath string) ([]string, error) {
+	outputDirectory, directoryError := os.MkdirTemp("", "pdf-text-*")
+	if directoryError != nil {
+		return nil, directoryError
+	}
+	defer os.RemoveAll(outputDirectory)
+
+	gs := media.ResolveBinaryPath("gs", c.binDir)
+	command := exec.Command(gs, "-dSAFER", "-dBATCH", "-dNOPAUSE", "-sDEVICE=txtwrite", fmt.Sprintf("-sOutputFile=%s", filepath.Join(outputDirectory, "%03d.txt")), pdfPath)
+
+	var stderr strings.Builder
+	command.Stderr = &stderr
+	if executionError := command.Run(); executionError != nil {
+		return nil, fmt.Errorf("ghostscript text extraction failed: %v, stderr: %s", executionError, stderr.String())
+	}
+
+	textFiles, listingError := numberedFiles(outputDirectory, ".txt")
+	if listingError != nil {
+		return nil, listingError
+	}
+	pageTexts := make([]string, 0, len(textFiles))
+	for _, textFile := range textFiles {
+		content, readError := os.ReadFile(textFile)
+		if readError != nil {
+			return nil, readError
+		}
+		pageTexts = append(pageTexts, normalizeExtractedText(string(content)))
+	}
+	return pageTexts, nil
+}
+
+// RecognizeText runs Tesseract on a page image and returns the recognized text
+func (c *ExternalDocumentConverter) RecognizeText(imagePath string, language string) (string, error) {
+	tesseract := media.ResolveBinaryPath("tesseract", c.binDir)
+	if _, lookError := exec.LookPath(tesseract); lookError != nil {
+		return "", fmt.Errorf("tesseract not found (install tesseract-ocr or place it in the bin folder)")
+	}
+	command := exec.Command(tesseract, imagePath, "stdout", "-l", language)
+
+	var stdout, stderr strings.Builder
+	command.Stdout = &stdout
+	command.Stderr = &stderr
+	if executionError := command.Run(); executionError != nil {
+		return "", fmt.Errorf("tesseract failed: %v, stderr: %s", executionError, stderr.String())
+	}
+	return stdout.String(), nil
+}
@@ -0,0 +1,135 @@
+package documents
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"lectures/internal/models"
+)
+
+// pagedConverter renders a fixed number of placeholder page images
+type pagedConverter struct {
+	recordingConverter
+	pageCount int
+}
+
+func (converter *pagedConverter) ExtractPagesAsImages(pdfPath string, outputDirectory string, dpi int) ([]string, error) {
+	var imageFiles []string
+	for pageNumber := 1; pageNumber <= converter.pageCount; pageNumber++ {
+		imagePath := filepath.Join(outputDirectory, fmt.Sprintf("%03d.png", pageNumber))
+		os.WriteFile(imagePath, []byte("png"), 0644)
+		imageFiles = append(imageFiles, imagePath)
+	}
+	return imageFiles, nil
+}
+
+// scannedTextExtractor has embedded text only on the first page, and records the language of every recognition
+type scannedTextExtractor struct {
+	languages []string
+}
+
+func (extractor *scannedTextExtractor) ExtractPageTexts(pdfPath string) ([]string, error) {
+	return []string{"Chapter 1: the embedded text layer of a born-digital page", "3"}, nil
+}
+
+func (extractor *scannedTextExtractor) RecognizeText(imagePath string, language string) (string, error) {
+	extractor.languages = append(extractor.languages, language)
+	return "Scanned   page\n\n\n\nsecond  paragraph\f", nil
+}
+
+func TestProcessDocument_ReadsPagesWithoutVisionModel(t *testing.T) {
+	pdfPath := filepath.Join(t.TempDir(), "scan.pdf")
+	os.WriteFile(pdfPath, []byte("pdf"), 0644)
+
+	processor := NewProcessor(&visionProvider{}, "vision-model", nil, 150, "")
+	processor.SetConverter(&pagedConverter{pageCount: 3})
+	processor.SetExtraction(ExtractionMethodAuto, 2)
+	textExtractor := &scannedTextExtractor{}
+	processor.SetTextExtractor(textExtractor)
+
+	document := models.ReferenceDocument{ID: "scan", FilePath: pdfPath}
+	pages, metrics, err := processor.ProcessDocument(context.Background(), document, t.TempDir(), "it-IT", func(int, string) {})
+	if err != nil {
+		t.Fatalf("ProcessDocument failed: %v", err)
+	}
+
+	if metrics.InputTokens != 0 {
+		t.Errorf("Expected the vision model to be skipped above vision_maximum_pages, got %+v", metrics)
+	}
+	if len(pages) != 3 || pages[0].ExtractionSource != ExtractionSourceEmbeddedText || pages[1].ExtractionSource != ExtractionSourceOCR || pages[2].ExtractionSource != ExtractionSourceOCR {
+		t.Fatalf("Expected embedded text for the first page and OCR for the others, got %+v", pages)
+	}
+	if pages[1].ExtractedText != "Scanned page\n\nsecond paragraph" {
+		t.Errorf("Expected the OCR text to be normalized, got %q", pages[1].ExtractedText)
+	}
+	if len(textExtractor.languages) != 2 || textExtractor.languages[0] != "ita" {
+		t.Errorf("Expected two pages recognized in Italian, got %v", textExtractor.languages)
+	}
+
+	summary := processor.SummarizeExtraction(document, pages)
+	if summary.Method != ExtractionMethodAuto || summary.EmbeddedTextPages != 1 || summary.OCRPages != 2 || summary.VisionPages != 0 || summary.Reason == "" {
+		t.Errorf("Unexpected extraction summary: %+v", summary)
+	}
+
+	// A document asking for the vision model is interpreted even though the processor defaults to auto
+	document.ExtractionMethod = ExtractionMethodVision
+	pages, _, err = processor.ProcessDocument(context.Background(), document, t.TempDir(), "it-IT", func(int, string) {})
+	if err != nil {
+		t.Fatalf("ProcessDocument failed: %v", err)
+	}
+	if summary := processor.SummarizeExtraction(document, pages); summary.VisionPages != 3 || summary.Reason != "" {
+		t.Errorf("Expected every page to be interpreted, got %+v", summary)
+	}
+}
+
+// unavailableVisionProvider fails its preflight check, as a vision model that is down does
+type unavailableVisionProvider struct {
+	visionProvider
+}
+
+func (provider *unavailableVisionProvider) Preflight(_ context.Context, model string) error {
+	return fmt.Errorf("model %s is unavailable", model)
+}
+
+func TestPrepareModel_SkippedForDocumentsReadWithOCR(t *testing.T) {
+	processor := NewProcessor(&unavailableVisionProvider{}, "vision-model", nil, 150, "")
+	processor.SetExtraction(ExtractionMethodVision, 0)
+
+	ocrDocument := models.ReferenceDocument{ID: "scan", ExtractionMethod: ExtractionMethodOCR}
+	if err := processor.PrepareModel(context.Background(), nil, ocrDocument); err != nil {
+		t.Errorf("Expected no preflight for a document read with OCR, got %v", err)
+	}
+	if err := processor.PrepareModel(context.Background(), nil, ocrDocument, models.ReferenceDocument{ID: "slides"}); err == nil {
+		t.Error("Expected the preflight to run when a document uses the vision model")
+	}
+
+	processor.SetExtraction(ExtractionMethodOCR, 0)
+	if err := processor.PrepareModel(context.Background(), nil); err != nil {
+		t.Errorf("Expected no preflight when documents are read with OCR by default, got %v", err)
+	}
+	if err := processor.PrepareModel(context.Background(), nil, models.ReferenceDocument{ID: "slides", ExtractionMethod: ExtractionMethodVision}); err == nil {
+		t.Error("Expected the preflight to run for a document asking for the vision model")
+	}
+}
+
+func TestNumberedFiles_OrdersPagesPast999(t *testing.T) {
+	directory := t.TempDir()
+	for _, name := range []string{"1000.txt", "999.txt", "001.txt", "1001.txt", "notes.txt", "002.png"} {
+		os.WriteFile(filepath.Join(directory, name), nil, 0644)
+	}
+
+	files, err := numberedFiles(directory, ".txt")
+	if err != nil {
+		t.Fatalf("numberedFiles failed: %v", err)
+	}
+	var names []string
+	for _, file := range files {
+		names = append(names, filepath.Base(file))
+	}
+	if fmt.Sprint(names) != "[001.txt 999.txt 1000.txt 1001.txt]" {
+		t.Errorf("Expected the pages in page order, got %v", names)
+	}
+}
@@ -0,0 +1,123 @@
+package documents
+
+import (
+	"context"
+	"sync"
+
+	"lectures/internal/models"
+)
+
+// DefaultPageParallelism is the number of pages of a document read at once unless configured otherwise
+const DefaultPageParallelism = 4
+
+// SetParallelism configures how many pages are read at once. Pages read by the vision model are further limited
+// to maximumModelCalls, the LLM calls allowed in flight; values that are not positive keep the default and no
+// model limit respectively
+func (processor *Processor) SetParallelism(pageParallelism int, maximumModelCalls int) {
+	if pageParallelism > 0 {
+		processor.pageParallelism = pageParallelism
+	}
+	processor.maximumModelCalls = maximumModelCalls
+}
+
+// pageWorkers returns how many pages are read at once, usesModel telling whether each read calls the LLM
+func (processor *Processor) pageWorkers(usesModel bool) int {
+	workers := max(processor.pageParallelism, 1)
+	if usesModel && processor.maximumModelCalls > 0 {
+		workers = min(workers, processor.maximumModelCalls)
+	}
+	return workers
+}
+
+// PageCheckpoint keeps the pages a job read with the model, so pausing an ingestion does not pay for them twice
+type PageCheckpoint interface {
+	// LoadPage returns the page read before the pause, if any; its image path is that of the earlier rendering
+	LoadPage(documentID string, pageNumber int) (models.ReferencePage, bool)
+	SavePage(page models.ReferencePage)
+}
+
+type pageCheckpointKey struct{}
+
+// WithPageCheckpoint attaches a page checkpoint to a job context, read back when pages are interpreted
+func WithPageCheckpoint(parent context.Context, checkpoint PageCheckpoint) context.Context {
+	return context.WithValue(parent, pageCheckpointKey{}, checkpoint)
+}
+
+func pageCheckpointFromContext(jobContext context.Context) PageCheckpoint {
+	checkpoint, _ := jobContext.Value(pageCheckpointKey{}).(PageCheckpoint)
+	return checkpoint
+}
+
+// pageReader reads the page at pageIndex, counting from zero
+type pageReader func(jobContext context.Context, pageIndex int) (models.ReferencePage, models.JobMetrics, error)
+
+// readPagesInParallel reads pageCount pages with a pool of workers and returns them in page order, whatever order
+// they finish in. onPageRead is called with the number of pages read so far. The first failure cancels the pages
+// still waiting and is returned; a pause of the job is checked before each page and returned as a failure
+func readPagesInParallel(jobContext context.Context, pageCount int, workers int, readPage pageReader, onPageRead func(completedCount int)) ([]models.ReferencePage, models.JobMetrics, error) {
+	var metrics models.JobMetrics
+	if pageCount == 0 {
+		return nil, metrics, jobContext.Err()
+	}
+
+	workerContext, cancel := context.WithCancel(jobContext)
+	defer cancel()
+
+	pages := make([]models.ReferencePage, pageCount)
+	pageIndexes := make(chan int)
+	var waitGroup sync.WaitGroup
+	var mutex sync.Mutex
+	var firstError error
+	completedCount := 0
+
+	for range min(max(workers, 1), pageCount) {
+		waitGroup.Add(1)
+		go func() {
+			defer waitGroup.Done()
+			for pageIndex := range pageIndexes {
+				var page models.ReferencePage
+				var pageMetrics models.JobMetrics
+				readError := models.CheckPause(workerContext)
+				if readError == nil {
+					page, pageMetrics, readError = readPage(workerContext, pageIndex)
+				}
+
+				mutex.Lock()
+				if readError != nil {
+					if firstError == nil {
+						firstError = readError
+						cancel()
+					}
+					mutex.Unlock()
+					continue
+				}
+				pages[pageIndex] = page
+				metrics.InputTokens += pageMetrics.InputTokens
+				metrics.OutputTokens += pageMetrics.OutputTokens
+				metrics.EstimatedCost += pageMetrics.EstimatedCost
+				completedCount++
+				onPageRead(completedCount)
+				mutex.Unlock()
+			}
+		}()
+	}
+
+feedPages:
+	for pageIndex := range pageCount {
+		select {
+		case pageIndexes <- pageIndex:
+		case <-workerContext.Done():
+			break feedPages
+		}
+	}
+	close(pageIndexes)
+	waitGroup.Wait()
+
+	if firstError != nil {
+		return nil, metrics, firstError
+	}
+	if jobContext.Err() != nil {
+		return nil, metrics, jobContext.Err()
+	}
+	return pages, metrics, nil
+}
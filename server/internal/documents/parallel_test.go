@@ -0,0 +1,145 @@
+package documents
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"lectures/internal/models"
+)
+
+func TestReadPagesInParallel_KeepsPageOrderWithinTheWorkerLimit(t *testing.T) {
+	var running, peak atomic.Int32
+	readPage := func(_ context.Context, pageIndex int) (models.ReferencePage, models.JobMetrics, error) {
+		current := running.Add(1)
+		defer running.Add(-1)
+		for {
+			observed := peak.Load()
+			if current <= observed || peak.CompareAndSwap(observed, current) {
+				break
+			}
+		}
+		// Earlier pages take longer, so they finish out of order
+		time.Sleep(time.Duration(12-pageIndex) * time.Millisecond)
+		return models.ReferencePage{PageNumber: pageIndex + 1}, models.JobMetrics{InputTokens: 10}, nil
+	}
+
+	var lastCount int
+	pages, metrics, err := readPagesInParallel(context.Background(), 12, 3, readPage, func(completedCount int) { lastCount = completedCount })
+	if err != nil {
+		t.Fatalf("Reading failed: %v", err)
+	}
+	for pageIndex, page := range pages {
+		if page.PageNumber != pageIndex+1 {
+			t.Fatalf("Expected pages in order, got page %d at index %d", page.PageNumber, pageIndex)
+		}
+	}
+	if peak.Load() > 3 || peak.Load() < 2 {
+		t.Errorf("Expected up to 3 pages read at once, got %d", peak.Load())
+	}
+	if metrics.InputTokens != 120 || lastCount != 12 {
+		t.Errorf("Expected metrics and progress for every page, got %d tokens and %d pages", metrics.InputTokens, lastCount)
+	}
+}
+
+func TestReadPagesInParallel_StopsAtTheFirstFailure(t *testing.T) {
+	var readCount atomic.Int32
+	readPage := func(workerContext context.Context, pageIndex int) (models.ReferencePage, models.JobMetrics, error) {
+		readCount.Add(1)
+		if pageIndex == 1 {
+			return models.ReferencePage{}, models.JobMetrics{}, errors.New("page unreadable")
+		}
+		select {
+		case <-time.After(5 * time.Millisecond):
+		case <-workerContext.Done():
+		}
+		return models.ReferencePage{PageNumber: pageIndex + 1}, models.JobMetrics{}, nil
+	}
+
+	if _, _, err := readPagesInParallel(context.Background(), 200, 2, readPage, func(int) {}); err == nil || err.Error() != "page unreadable" {
+		t.Fatalf("Expected the page failure, got %v", err)
+	}
+	if readCount.Load() > 10 {
+		t.Errorf("Expected the remaining pages to be skipped, %d were read", readCount.Load())
+	}
+}
+
+// memoryCheckpoint keeps checkpointed pages in memory
+type memoryCheckpoint struct {
+	mutex sync.Mutex
+	pages map[string]models.ReferencePage
+}
+
+func (checkpoint *memoryCheckpoint) LoadPage(documentID string, pageNumber int) (models.ReferencePage, bool) {
+	checkpoint.mutex.Lock()
+	defer checkpoint.mutex.Unlock()
+	page, found := checkpoint.pages[fmt.Sprintf("%s:%d", documentID, pageNumber)]
+	return page, found
+}
+
+func (checkpoint *memoryCheckpoint) SavePage(page models.ReferencePage) {
+	checkpoint.mutex.Lock()
+	defer checkpoint.mutex.Unlock()
+	checkpoint.pages[fmt.Sprintf("%s:%d", page.DocumentID, page.PageNumber)] = page
+}
+
+func (checkpoint *memoryCheckpoint) count() int {
+	checkpoint.mutex.Lock()
+	defer checkpoint.mutex.Unlock()
+	return len(checkpoint.pages)
+}
+
+func TestInterpretPages_ResumesFromTheCheckpointAfterAPause(t *testing.T) {
+	processor := NewProcessor(&visionProvider{}, "vision-model", nil, 150, "")
+	processor.SetParallelism(1, 0)
+
+	var imageFiles []string
+	for pageNumber := 1; pageNumber <= 3; pageNumber++ {
+		imagePath := filepath.Join(t.TempDir(), fmt.Sprintf("%03d.png", pageNumber))
+		os.WriteFile(imagePath, []byte("png"), 0644)
+		imageFiles = append(imageFiles, imagePath)
+	}
+
+	// The job is paused once the first page was read
+	checkpoint := &memoryCheckpoint{pages: map[string]models.ReferencePage{}}
+	pausedContext := models.WithPauseCheck(WithPageCheckpoint(context.Background(), checkpoint), func() bool { return checkpoint.count() >= 1 })
+	if _, _, err := processor.interpretPages(pausedContext, imageFiles, "document-1", "en", "", func(int, string) {}); !errors.Is(err, models.ErrJobPaused) {
+		t.Fatalf("Expected the reading to stop at the pause, got %v", err)
+	}
+	if checkpoint.count() != 1 {
+		t.Fatalf("Expected the first page in the checkpoint, got %d pages", checkpoint.count())
+	}
+
+	// Resuming only asks the model about the pages still missing
+	pages, metrics, err := processor.interpretPages(WithPageCheckpoint(context.Background(), checkpoint), imageFiles, "document-1", "en", "", func(int, string) {})
+	if err != nil {
+		t.Fatalf("Resumed reading failed: %v", err)
+	}
+	if len(pages) != 3 || pages[0].ImagePath != imageFiles[0] || pages[0].ExtractedText == "" {
+		t.Fatalf("Unexpected resumed pages %+v", pages)
+	}
+	if metrics.InputTokens != 20 {
+		t.Errorf("Expected two pages to be read after the pause, got %d input tokens", metrics.InputTokens)
+	}
+}
+
+func TestPageWorkers_RespectsTheModelCallLimit(t *testing.T) {
+	processor := NewProcessor(&visionProvider{}, "vision-model", nil, 150, "")
+	processor.SetParallelism(8, 3)
+	if workers := processor.pageWorkers(true); workers != 3 {
+		t.Errorf("Expected model reads to be limited to 3 calls, got %d", workers)
+	}
+	if workers := processor.pageWorkers(false); workers != 8 {
+		t.Errorf("Expected local reads to use 8 workers, got %d", workers)
+	}
+	processor.SetParallelism(0, 0)
+	if workers := processor.pageWorkers(true); workers != 8 {
+		t.Errorf("Expected non-positive values to keep the parallelism, got %d", workers)
+	}
+}
@@ -4,11 +4,10 @@ import (
 	"context"
 	"encoding/base64"
 	"fmt"
+	"log/slog"
 	"os"
 	"path/filepath"
-	"sort"
 	"strings"
-	"sync"
 
 	"lectures/internal/llm"
 	"lectures/internal/models"
@@ -20,18 +19,32 @@ type Processor struct {
 	llmModel      string
 	promptManager *prompts.Manager
 	converter     DocumentConverter
+	textExtractor TextExtractor
 	dpi           int
 	binDir        string
+	chunkSize     int
+	chunkOverlap  int
+
+	extractionMethod   string
+	visionMaximumPages int
+
+	pageParallelism   int
+	maximumModelCalls int
 }
 
 func NewProcessor(llmProvider llm.Provider, llmModel string, promptManager *prompts.Manager, dpi int, binDir string) *Processor {
 	return &Processor{
-		llmProvider:   llmProvider,
-		llmModel:      llmModel,
-		promptManager: promptManager,
-		converter:     &ExternalDocumentConverter{binDir: binDir},
-		dpi:           dpi,
-		binDir:        binDir,
+		llmProvider:      llmProvider,
+		llmModel:         llmModel,
+		promptManager:    promptManager,
+		converter:        &ExternalDocumentConverter{binDir: binDir},
+		textExtractor:    &ExternalDocumentConverter{binDir: binDir},
+		dpi:              dpi,
+		binDir:           binDir,
+		chunkSize:        DefaultChunkSizeCharacters,
+		chunkOverlap:     DefaultChunkOverlapCharacters,
+		extractionMethod: ExtractionMethodVision,
+		pageParallelism:  DefaultPageParallelism,
 	}
 }
 
@@ -45,7 +58,26 @@ func (processor *Processor) CheckDependencies() error {
 	return processor.converter.CheckDependencies()
 }
 
-// ProcessDocument extracts pages as images and performs interpretation using a Vision LLM
+// PrepareModel runs the preflight check and warmup for the page interpretation model before reading documents,
+// which have the default extraction method when none are given. It is skipped when all of them are read with
+// OCR, so ingestion keeps working while the model is unavailable
+func (processor *Processor) PrepareModel(jobContext context.Context, onLoading func(model string), documents ...models.ReferenceDocument) error {
+	needsModel := len(documents) == 0 && processor.extractionMethod != ExtractionMethodOCR
+	for _, document := range documents {
+		if processor.documentExtractionMethod(document) != ExtractionMethodOCR {
+			needsModel = true
+		}
+	}
+	if !needsModel {
+		return nil
+	}
+	return llm.PrepareModel(jobContext, processor.llmProvider, processor.llmModel, onLoading)
+}
+
+// ProcessDocument extracts pages as images and reads their text, with the vision LLM or, depending on the
+// document's extraction method, from the embedded PDF text and OCR. Pages are read in the document's language,
+// languageCode (the lecture's) being the fallback when it has none and none can be detected. Each page records
+// its ExtractionSource and Language
 func (processor *Processor) ProcessDocument(jobContext context.Context, document models.ReferenceDocument, outputDirectory string, languageCode string, updateProgress func(int, string)) ([]models.ReferencePage, models.JobMetrics, error) {
 	var metrics models.JobMetrics
 	if directoryError := os.MkdirAll(outputDirectory, 0755); directoryError != nil {
@@ -53,12 +85,13 @@ func (processor *Processor) ProcessDocument(jobContext context.Context, document
 	}
 
 	extension := strings.ToLower(filepath.Ext(document.FilePath))
+	extractionMethod := processor.documentExtractionMethod(document)
 	var pdfPath string
 
 	switch extension {
 	case ".pdf":
 		pdfPath = document.FilePath
-	case ".pptx", ".docx":
+	case ".pptx", ".key", ".docx", ".html", ".htm":
 		updateProgress(5, "Converting document to PDF...")
 		temporaryPdfPath := filepath.Join(os.TempDir(), fmt.Sprintf("%s.pdf", document.ID))
 		if conversionError := processor.converter.ConvertToPDF(document.FilePath, temporaryPdfPath); conversionError != nil {
@@ -66,14 +99,70 @@ func (processor *Processor) ProcessDocument(jobContext context.Context, document
 		}
 		pdfPath = temporaryPdfPath
 		defer os.Remove(temporaryPdfPath)
+	case ".epub":
+		// The chapters are joined into one HTML document, which LibreOffice paginates like any other page
+		updateProgress(5, "Converting ebook to PDF...")
+		unpackDirectory, directoryError := os.MkdirTemp("", "ebook-"+document.ID+"-*")
+		if directoryError != nil {
+			return nil, metrics, fmt.Errorf("failed to create ebook directory: %w", directoryError)
+		}
+		defer os.RemoveAll(unpackDirectory)
+
+		htmlPath := filepath.Join(unpackDirectory, document.ID+".html")
+		if unpackError := BuildEPUBHTML(document.FilePath, filepath.Join(unpackDirectory, "content"), htmlPath); unpackError != nil {
+			return nil, metrics, fmt.Errorf("failed to read ebook: %w", unpackError)
+		}
+		pdfPath = filepath.Join(unpackDirectory, document.ID+".pdf")
+		if conversionError := processor.converter.ConvertToPDF(htmlPath, pdfPath); conversionError != nil {
+			return nil, metrics, fmt.Errorf("failed to convert ebook to PDF: %w", conversionError)
+		}
+	case ".jpg", ".jpeg", ".png", ".heic", ".heif":
+		// A photo (e.g. of a whiteboard) is a single-page document
+		updateProgress(10, "Preparing image...")
+		imagePath := filepath.Join(outputDirectory, "001.png")
+		if conversionError := processor.converter.ConvertImageToPNG(document.FilePath, imagePath); conversionError != nil {
+			return nil, metrics, fmt.Errorf("failed to convert image: %w", conversionError)
+		}
+		languageCode = processor.extractionLanguage(document, "", languageCode)
+		var pages []models.ReferencePage
+		var err error
+		if reason := processor.visionSkipReason(extractionMethod, 1); reason != "" {
+			pages, metrics, err = processor.readPagesLocally(jobContext, "", []string{imagePath}, document.ID, languageCode, updateProgress)
+		} else {
+			pages, metrics, err = processor.interpretPages(jobContext, []string{imagePath}, document.ID, languageCode, extractionMethod, updateProgress)
+		}
+		tagPageLanguages(pages, languageCode)
+		return pages, metrics, err
 	default:
 		return nil, metrics, fmt.Errorf("unsupported document type: %s", extension)
 	}
 
-	return processor.processPDF(jobContext, pdfPath, document.ID, outputDirectory, languageCode, updateProgress)
+	languageCode = processor.extractionLanguage(document, pdfPath, languageCode)
+	pages, metrics, err := processor.processPDF(jobContext, pdfPath, document.ID, outputDirectory, languageCode, extractionMethod, updateProgress)
+	tagPageLanguages(pages, languageCode)
+	if err != nil || extension != ".pptx" {
+		return pages, metrics, err
+	}
+
+	// Slide titles and speaker notes are not part of the rendered pages, so they are read from the deck itself
+	slidesMetadata, metadataError := ExtractSlideMetadata(document.FilePath)
+	if metadataError != nil {
+		slog.WarnContext(jobContext, "Failed to read slide titles and speaker notes", "documentID", document.ID, "error", metadataError)
+		return pages, metrics, nil
+	}
+	if len(slidesMetadata) != len(pages) {
+		slog.WarnContext(jobContext, "Slide count does not match the rendered pages, skipping slide metadata", "documentID", document.ID, "slides", len(slidesMetadata), "pages", len(pages))
+		return pages, metrics, nil
+	}
+	for pageIndex := range pages {
+		if slidesMetadata[pageIndex] != (models.ReferencePageMetadata{}) {
+			pages[pageIndex].Metadata = &slidesMetadata[pageIndex]
+		}
+	}
+	return pages, metrics, nil
 }
 
-func (processor *Processor) processPDF(jobContext context.Context, pdfPath string, documentID string, outputDirectory string, languageCode string, updateProgress func(int, string)) ([]models.ReferencePage, models.JobMetrics, error) {
+func (processor *Processor) processPDF(jobContext context.Context, pdfPath string, documentID string, outputDirectory string, languageCode string, extractionMethod string, updateProgress func(int, string)) ([]models.ReferencePage, models.JobMetrics, error) {
 	var metrics models.JobMetrics
 	updateProgress(10, "Extracting pages as images...")
 	imageFiles, extractionError := processor.converter.ExtractPagesAsImages(pdfPath, outputDirectory, processor.dpi)
@@ -81,87 +170,59 @@ func (processor *Processor) processPDF(jobContext context.Context, pdfPath strin
 		return nil, metrics, extractionError
 	}
 
-	var extractedPages []models.ReferencePage
-	totalImages := len(imageFiles)
-
-	var wg sync.WaitGroup
-	var mutex sync.Mutex
-	var firstError error
-
-	// Semaphore to limit concurrency (e.g., 5 concurrent pages)
-	semaphore := make(chan struct{}, 5)
-
-	completedCount := 0
-
-	for imageIndex, imagePath := range imageFiles {
-		if firstError != nil {
-			break
-		}
-
-		pageNumber := imageIndex + 1
-		wg.Add(1)
-
-		go func(pNum int, pPath string) {
-			defer wg.Done()
-
-			select {
-			case semaphore <- struct{}{}:
-				defer func() { <-semaphore }()
-			case <-jobContext.Done():
-				return
-			}
-
-			extractedText, pageMetrics, interpretationError := processor.interpretPageContent(jobContext, pPath, languageCode)
-
-			mutex.Lock()
-			defer mutex.Unlock()
-
-			if interpretationError != nil && firstError == nil {
-				firstError = fmt.Errorf("failed to interpret page %d: %w", pNum, interpretationError)
-				return
-			}
-
-			metrics.InputTokens += pageMetrics.InputTokens
-			metrics.OutputTokens += pageMetrics.OutputTokens
-			metrics.EstimatedCost += pageMetrics.EstimatedCost
+	// Page images are still rendered without the vision model, so pages can be shown and cited the same way
+	if reason := processor.visionSkipReason(extractionMethod, len(imageFiles)); reason != "" {
+		slog.InfoContext(jobContext, "Reading document without the vision model", "documentID", documentID, "reason", reason)
+		return processor.readPagesLocally(jobContext, pdfPath, imageFiles, documentID, languageCode, updateProgress)
+	}
 
-			extractedPages = append(extractedPages, models.ReferencePage{
-				DocumentID:    documentID,
-				PageNumber:    pNum,
-				ImagePath:     pPath,
-				ExtractedText: extractedText,
-			})
+	return processor.interpretPages(jobContext, imageFiles, documentID, languageCode, extractionMethod, updateProgress)
+}
 
-			completedCount++
-			progress := 10 + int(float64(completedCount)/float64(totalImages)*90.0)
-			updateProgress(progress, fmt.Sprintf("Interpreting page contents... (%d/%d)", completedCount, totalImages))
-		}(pageNumber, imagePath)
+// interpretPages runs the vision LLM over the page images with a pool of workers and returns the pages in order;
+// the math extraction method transcribes them with LaTeX-faithful equations instead of interpreting them
+func (processor *Processor) interpretPages(jobContext context.Context, imageFiles []string, documentID string, languageCode string, extractionMethod string, updateProgress func(int, string)) ([]models.ReferencePage, models.JobMetrics, error) {
+	extractionSource := ExtractionSourceVision
+	interpretPage := processor.interpretPageContent
+	if extractionMethod == ExtractionMethodMath {
+		extractionSource = ExtractionSourceMath
+		interpretPage = processor.interpretMathPage
 	}
 
-	wg.Wait()
+	totalImages := len(imageFiles)
+	checkpoint := pageCheckpointFromContext(jobContext)
+	readPage := func(workerContext context.Context, pageIndex int) (models.ReferencePage, models.JobMetrics, error) {
+		if checkpoint != nil {
+			if page, found := checkpoint.LoadPage(documentID, pageIndex+1); found {
+				page.ImagePath = imageFiles[pageIndex]
+				return page, models.JobMetrics{}, nil
+			}
+		}
 
-	if firstError != nil {
-		return nil, metrics, firstError
+		extractedText, pageMetrics, interpretationError := interpretPage(workerContext, imageFiles[pageIndex], languageCode)
+		if interpretationError != nil {
+			return models.ReferencePage{}, pageMetrics, fmt.Errorf("failed to interpret page %d: %w", pageIndex+1, interpretationError)
+		}
+		page := models.ReferencePage{
+			DocumentID:       documentID,
+			PageNumber:       pageIndex + 1,
+			ImagePath:        imageFiles[pageIndex],
+			ExtractedText:    extractedText,
+			ExtractionSource: extractionSource,
+		}
+		if checkpoint != nil {
+			checkpoint.SavePage(page)
+		}
+		return page, pageMetrics, nil
 	}
 
-	// Sort pages by page number since they were processed in parallel
-	sort.Slice(extractedPages, func(i, j int) bool {
-		return extractedPages[i].PageNumber < extractedPages[j].PageNumber
+	return readPagesInParallel(jobContext, totalImages, processor.pageWorkers(true), readPage, func(completedCount int) {
+		progress := 10 + int(float64(completedCount)/float64(totalImages)*90.0)
+		updateProgress(progress, fmt.Sprintf("Interpreting page contents... (%d/%d)", completedCount, totalImages))
 	})
-
-	return extractedPages, metrics, nil
 }
 
 func (processor *Processor) interpretPageContent(jobContext context.Context, imagePath string, languageCode string) (string, models.JobMetrics, error) {
-	var metrics models.JobMetrics
-	imageData, readError := os.ReadFile(imagePath)
-	if readError != nil {
-		return "", metrics, readError
-	}
-
-	base64Image := base64.StdEncoding.EncodeToString(imageData)
-	dataURL := fmt.Sprintf("data:image/png;base64,%s", base64Image)
-
 	var ingestPrompt string
 	if processor.promptManager != nil {
 		latexInstructions, _ := processor.promptManager.GetPrompt(prompts.PromptLatexInstructions, nil)
@@ -176,20 +237,34 @@ func (processor *Processor) interpretPageContent(jobContext context.Context, ima
 			"latex_instructions":   latexInstructions,
 		})
 		if promptError != nil {
-			return "", metrics, promptError
+			return "", models.JobMetrics{}, promptError
 		}
 	} else {
 		// Fallback prompt when promptManager is nil (e.g., in tests)
 		ingestPrompt = fmt.Sprintf("Extract and transcribe all text content from this document page. The response must be written in %s.", languageCode)
 	}
 
+	return processor.askAboutPage(jobContext, imagePath, ingestPrompt)
+}
+
+// askAboutPage sends a page image with a prompt to the vision LLM and returns its answer
+func (processor *Processor) askAboutPage(jobContext context.Context, imagePath string, prompt string) (string, models.JobMetrics, error) {
+	var metrics models.JobMetrics
+	imageData, readError := os.ReadFile(imagePath)
+	if readError != nil {
+		return "", metrics, readError
+	}
+
+	base64Image := base64.StdEncoding.EncodeToString(imageData)
+	dataURL := fmt.Sprintf("data:image/png;base64,%s", base64Image)
+
 	request := llm.ChatRequest{
 		Model: processor.llmModel,
 		Messages: []llm.Message{
 			{
 				Role: "user",
 				Content: []llm.ContentPart{
-					{Type: "text", Text: ingestPrompt},
+					{Type: "text", Text: prompt},
 					{Type: "image", ImageURL: dataURL},
 				},
 			},
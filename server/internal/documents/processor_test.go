@@ -0,0 +1,80 @@
+package documents
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"lectures/internal/llm"
+	"lectures/internal/models"
+)
+
+// recordingConverter writes placeholder images and records which conversion ran
+type recordingConverter struct {
+	convertedDocuments []string
+	convertedImages    []string
+}
+
+func (converter *recordingConverter) CheckDependencies() error { return nil }
+
+func (converter *recordingConverter) ConvertToPDF(inputPath string, outputPath string) error {
+	converter.convertedDocuments = append(converter.convertedDocuments, inputPath)
+	return os.WriteFile(outputPath, []byte("pdf"), 0644)
+}
+
+func (converter *recordingConverter) ExtractPagesAsImages(pdfPath string, outputDirectory string, dpi int) ([]string, error) {
+	return nil, nil
+}
+
+func (converter *recordingConverter) ConvertImageToPNG(inputPath string, outputPath string) error {
+	converter.convertedImages = append(converter.convertedImages, inputPath)
+	return os.WriteFile(outputPath, []byte("png"), 0644)
+}
+
+// visionProvider answers every page with the same transcription and checks that an image was attached
+type visionProvider struct{}
+
+func (provider *visionProvider) Name() string { return "vision" }
+
+func (provider *visionProvider) Chat(_ context.Context, request *llm.ChatRequest) (<-chan llm.ChatResponseChunk, error) {
+	responseChannel := make(chan llm.ChatResponseChunk, 1)
+	text := "no image"
+	for _, part := range request.Messages[0].Content {
+		if part.Type == "image" && strings.HasPrefix(part.ImageURL, "data:image/png;base64,") {
+			text = "# Whiteboard\n\nEntropy never decreases."
+		}
+	}
+	responseChannel <- llm.ChatResponseChunk{Text: text, InputTokens: 10, OutputTokens: 5}
+	close(responseChannel)
+	return responseChannel, nil
+}
+
+func TestProcessDocument_ImageIsSinglePage(t *testing.T) {
+	photoPath := filepath.Join(t.TempDir(), "whiteboard.HEIC")
+	os.WriteFile(photoPath, []byte("heic"), 0644)
+
+	converter := &recordingConverter{}
+	processor := NewProcessor(&visionProvider{}, "vision-model", nil, 150, "")
+	processor.SetConverter(converter)
+
+	document := models.ReferenceDocument{ID: "document-1", FilePath: photoPath}
+	pages, metrics, err := processor.ProcessDocument(context.Background(), document, t.TempDir(), "en-US", func(int, string) {})
+	if err != nil {
+		t.Fatalf("ProcessDocument failed: %v", err)
+	}
+
+	if len(converter.convertedImages) != 1 || converter.convertedImages[0] != photoPath {
+		t.Errorf("Expected the photo to be converted once, got %v", converter.convertedImages)
+	}
+	if len(pages) != 1 || pages[0].PageNumber != 1 || pages[0].DocumentID != "document-1" {
+		t.Fatalf("Expected a single page, got %+v", pages)
+	}
+	if pages[0].ExtractedText != "# Whiteboard\n\nEntropy never decreases." {
+		t.Errorf("Unexpected extracted text: %q", pages[0].ExtractedText)
+	}
+	if metrics.InputTokens != 10 || metrics.OutputTokens != 5 {
+		t.Errorf("Expected the interpretation metrics to be reported, got %+v", metrics)
+	}
+}
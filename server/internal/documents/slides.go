@@ -0,0 +1,228 @@
+package documents
+
+import (
+	"archive/zip"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"path"
+	"strings"
+
+	"lectures/internal/models"
+)
+
+// relationship is an entry of an Office Open XML .rels part
+type relationship struct {
+	ID     string `xml:"Id,attr"`
+	Type   string `xml:"Type,attr"`
+	Target string `xml:"Target,attr"`
+}
+
+// xmlElement is a generic XML node, enough to walk the shape trees of slides and notes
+type xmlElement struct {
+	XMLName    xml.Name
+	Attributes []xml.Attr   `xml:",any,attr"`
+	Children   []xmlElement `xml:",any"`
+	Text       string       `xml:",chardata"`
+}
+
+// ExtractSlideMetadata reads the title and speaker notes of every slide of a .pptx file, in presentation order.
+// Hidden slides are skipped because LibreOffice leaves them out of the PDF, so entry i describes page i+1
+func ExtractSlideMetadata(pptxPath string) ([]models.ReferencePageMetadata, error) {
+	archive, err := zip.OpenReader(pptxPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open presentation: %w", err)
+	}
+	defer archive.Close()
+
+	files := make(map[string]*zip.File, len(archive.File))
+	for _, file := range archive.File {
+		files[file.Name] = file
+	}
+
+	var presentation struct {
+		Slides []struct {
+			RelationshipID string `xml:"http://schemas.openxmlformats.org/officeDocument/2006/relationships id,attr"`
+		} `xml:"sldIdLst>sldId"`
+	}
+	if err := decodeZipXML(files, "ppt/presentation.xml", &presentation); err != nil {
+		return nil, err
+	}
+	presentationRelationships, err := readRelationships(files, "ppt/presentation.xml")
+	if err != nil {
+		return nil, err
+	}
+
+	var slidesMetadata []models.ReferencePageMetadata
+	for _, slide := range presentation.Slides {
+		target, found := presentationRelationships[slide.RelationshipID]
+		if !found {
+			continue
+		}
+		slidePath := resolvePartPath("ppt/presentation.xml", target.Target)
+
+		var slideRoot xmlElement
+		if err := decodeZipXML(files, slidePath, &slideRoot); err != nil {
+			return nil, err
+		}
+		if attributeValue(slideRoot, "show") == "0" {
+			continue
+		}
+
+		metadata := models.ReferencePageMetadata{SlideTitle: placeholderText(slideRoot, "title", "ctrTitle")}
+
+		slideRelationships, err := readRelationships(files, slidePath)
+		if err != nil {
+			return nil, err
+		}
+		for _, slideRelationship := range slideRelationships {
+			if !strings.HasSuffix(slideRelationship.Type, "/notesSlide") {
+				continue
+			}
+			var notesRoot xmlElement
+			if err := decodeZipXML(files, resolvePartPath(slidePath, slideRelationship.Target), &notesRoot); err == nil {
+				metadata.SpeakerNotes = placeholderText(notesRoot, "body")
+			}
+		}
+
+		slidesMetadata = append(slidesMetadata, metadata)
+	}
+	return slidesMetadata, nil
+}
+
+// readRelationships parses the .rels part of a package part, keyed by relationship ID. A part without one has none
+func readRelationships(files map[string]*zip.File, partPath string) (map[string]relationship, error) {
+	relationshipsPath := path.Join(path.Dir(partPath), "_rels", path.Base(partPath)+".rels")
+	relationships := make(map[string]relationship)
+	if _, found := files[relationshipsPath]; !found {
+		return relationships, nil
+	}
+
+	var parsed struct {
+		Relationships []relationship `xml:"Relationship"`
+	}
+	if err := decodeZipXML(files, relationshipsPath, &parsed); err != nil {
+		return nil, err
+	}
+	for _, entry := range parsed.Relationships {
+		relationships[entry.ID] = entry
+	}
+	return relationships, nil
+}
+
+// resolvePartPath resolves a relationship target relative to the part that references it
+func resolvePartPath(sourcePartPath string, target string) string {
+	if strings.HasPrefix(target, "/") {
+		return strings.TrimPrefix(target, "/")
+	}
+	return path.Clean(path.Join(path.Dir(sourcePartPath), target))
+}
+
+func decodeZipXML(files map[string]*zip.File, name string, destination any) error {
+	file, found := files[name]
+	if !found {
+		return fmt.Errorf("archive part %s is missing", name)
+	}
+	reader, err := file.Open()
+	if err != nil {
+		return fmt.Errorf("failed to open archive part %s: %w", name, err)
+	}
+	defer reader.Close()
+
+	if err := xml.NewDecoder(io.LimitReader(reader, 64<<20)).Decode(destination); err != nil {
+		return fmt.Errorf("failed to parse archive part %s: %w", name, err)
+	}
+	return nil
+}
+
+// placeholderText returns the text of the first shape whose placeholder has one of the given types,
+// one line per paragraph
+func placeholderText(root xmlElement, placeholderTypes ...string) string {
+	var text string
+	var walk func(element xmlElement) bool
+	walk = func(element xmlElement) bool {
+		if element.XMLName.Local == "sp" && hasPlaceholderType(element, placeholderTypes) {
+			text = shapeText(element)
+			return true
+		}
+		for _, child := range element.Children {
+			if walk(child) {
+				return true
+			}
+		}
+		return false
+	}
+	walk(root)
+	return text
+}
+
+func hasPlaceholderType(shape xmlElement, placeholderTypes []string) bool {
+	var found bool
+	var walk func(element xmlElement)
+	walk = func(element xmlElement) {
+		if found {
+			return
+		}
+		if element.XMLName.Local == "ph" {
+			placeholderType := attributeValue(element, "type")
+			for _, wanted := range placeholderTypes {
+				if placeholderType == wanted {
+					found = true
+					return
+				}
+			}
+			return
+		}
+		// The placeholder of a shape sits in its non-visual properties, never in its text body
+		if element.XMLName.Local == "txBody" {
+			return
+		}
+		for _, child := range element.Children {
+			walk(child)
+		}
+	}
+	walk(shape)
+	return found
+}
+
+// shapeText joins the text runs of a shape, one line per paragraph, dropping empty paragraphs
+func shapeText(shape xmlElement) string {
+	var paragraphs []string
+	var builder strings.Builder
+	var walk func(element xmlElement)
+	walk = func(element xmlElement) {
+		switch element.XMLName.Local {
+		case "t":
+			builder.WriteString(element.Text)
+			return
+		case "br":
+			builder.WriteString(" ")
+			return
+		case "fld":
+			// Slide numbers and dates are fields, not notes
+			if attributeValue(element, "type") == "slidenum" {
+				return
+			}
+		}
+		for _, child := range element.Children {
+			walk(child)
+		}
+		if element.XMLName.Local == "p" {
+			if paragraph := strings.TrimSpace(builder.String()); paragraph != "" {
+				paragraphs = append(paragraphs, paragraph)
+			}
+			builder.Reset()
+		}
+	}
+	walk(shape)
+	return strings.Join(paragraphs, "\n")
+}
+
+func attributeValue(element xmlElement, name string) string {
+	for _, attribute := range element.Attributes {
+		if attribute.Name.Local == name {
+			return attribute.Value
+		}
+	}
+	return ""
+}
@@ -0,0 +1,100 @@
+package documents
+
+import (
+	"archive/zip"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"lectures/internal/models"
+)
+
+const (
+	presentationNamespaces = `xmlns:a="http://schemas.openxmlformats.org/drawingml/2006/main" xmlns:r="http://schemas.openxmlformats.org/officeDocument/2006/relationships" xmlns:p="http://schemas.openxmlformats.org/presentationml/2006/main"`
+	relationshipsHeader    = `<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">`
+	slideType              = "http://schemas.openxmlformats.org/officeDocument/2006/relationships/slide"
+	notesSlideType         = "http://schemas.openxmlformats.org/officeDocument/2006/relationships/notesSlide"
+)
+
+func writeTestPresentation(t *testing.T, parts map[string]string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "deck.pptx")
+	file, err := os.Create(path)
+	if err != nil {
+		t.Fatalf("Failed to create presentation: %v", err)
+	}
+	defer file.Close()
+
+	archive := zip.NewWriter(file)
+	for name, content := range parts {
+		writer, _ := archive.Create(name)
+		writer.Write([]byte(content))
+	}
+	if err := archive.Close(); err != nil {
+		t.Fatalf("Failed to write presentation: %v", err)
+	}
+	return path
+}
+
+func slideXML(attributes string, shapes string) string {
+	return `<p:sld ` + presentationNamespaces + attributes + `><p:cSld><p:spTree>` + shapes + `</p:spTree></p:cSld></p:sld>`
+}
+
+func shapeXML(placeholderType string, paragraphs ...string) string {
+	shape := `<p:sp><p:nvSpPr><p:cNvPr id="1" name="Shape"/><p:cNvSpPr/><p:nvPr><p:ph type="` + placeholderType + `"/></p:nvPr></p:nvSpPr><p:txBody>`
+	for _, paragraph := range paragraphs {
+		shape += `<a:p><a:r><a:t>` + paragraph + `</a:t></a:r></a:p>`
+	}
+	return shape + `</p:txBody></p:sp>`
+}
+
+func TestExtractSlideMetadata(t *testing.T) {
+	path := writeTestPresentation(t, map[string]string{
+		"ppt/presentation.xml": `<p:presentation ` + presentationNamespaces + `><p:sldIdLst>` +
+			`<p:sldId id="256" r:id="rId3"/><p:sldId id="257" r:id="rId2"/><p:sldId id="258" r:id="rId4"/>` +
+			`</p:sldIdLst></p:presentation>`,
+		"ppt/_rels/presentation.xml.rels": relationshipsHeader +
+			`<Relationship Id="rId2" Type="` + slideType + `" Target="slides/slide2.xml"/>` +
+			`<Relationship Id="rId3" Type="` + slideType + `" Target="slides/slide1.xml"/>` +
+			`<Relationship Id="rId4" Type="` + slideType + `" Target="slides/slide3.xml"/>` +
+			`</Relationships>`,
+		"ppt/slides/slide1.xml": slideXML("", shapeXML("ctrTitle", "Thermodynamics")+shapeXML("subTitle", "Lecture 1")),
+		"ppt/slides/_rels/slide1.xml.rels": relationshipsHeader +
+			`<Relationship Id="rId1" Type="` + notesSlideType + `" Target="../notesSlides/notesSlide1.xml"/>` +
+			`</Relationships>`,
+		"ppt/notesSlides/notesSlide1.xml": `<p:notes ` + presentationNamespaces + `><p:cSld><p:spTree>` +
+			shapeXML("sldImg") + shapeXML("body", "Start with the history.", "Mention Carnot.") +
+			`</p:spTree></p:cSld></p:notes>`,
+		"ppt/slides/slide2.xml": slideXML(` show="0"`, shapeXML("title", "Hidden backup slide")),
+		"ppt/slides/slide3.xml": slideXML("", shapeXML("title", "First law")),
+	})
+
+	slidesMetadata, err := ExtractSlideMetadata(path)
+	if err != nil {
+		t.Fatalf("ExtractSlideMetadata failed: %v", err)
+	}
+
+	expected := []models.ReferencePageMetadata{
+		{SlideTitle: "Thermodynamics", SpeakerNotes: "Start with the history.\nMention Carnot."},
+		{SlideTitle: "First law"},
+	}
+	if len(slidesMetadata) != len(expected) {
+		t.Fatalf("Expected %d slides, got %d: %+v", len(expected), len(slidesMetadata), slidesMetadata)
+	}
+	for slideIndex := range expected {
+		if slidesMetadata[slideIndex] != expected[slideIndex] {
+			t.Errorf("Slide %d: expected %+v, got %+v", slideIndex+1, expected[slideIndex], slidesMetadata[slideIndex])
+		}
+	}
+}
+
+func TestChunkPages_IncludesSpeakerNotes(t *testing.T) {
+	pages := []models.ReferencePage{
+		{PageNumber: 1, ExtractedText: "# Carnot cycle", Metadata: &models.ReferencePageMetadata{SpeakerNotes: "Draw the PV diagram."}},
+	}
+
+	chunks := ChunkPages("document-1", pages, 2000, 200)
+	if len(chunks) != 1 || chunks[0].Content != "# Carnot cycle\n\nSpeaker notes: Draw the PV diagram." {
+		t.Errorf("Expected the speaker notes to follow the slide text, got %+v", chunks)
+	}
+}
@@ -0,0 +1,153 @@
+package documents
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+
+	"lectures/internal/models"
+)
+
+// DocumentChunk is a reference chunk together with the title of its document and the language of its pages
+type DocumentChunk struct {
+	models.ReferenceChunk
+	DocumentTitle string
+	Language      string // Language most of the chunk's pages are in, empty for pages extracted before it was tracked
+}
+
+// PageLabel describes the pages a chunk covers, e.g. "Page 4" or "Pages 4–6"
+func (chunk DocumentChunk) PageLabel() string {
+	if chunk.EndPage > chunk.StartPage {
+		return fmt.Sprintf("Pages %d–%d", chunk.StartPage, chunk.EndPage)
+	}
+	return fmt.Sprintf("Page %d", chunk.StartPage)
+}
+
+// ListLectureReferenceChunks returns the chunks of every reference document of a lecture, in document and
+// reading order. Documents ingested before chunking was introduced have no stored chunks; their pages are
+// returned as one chunk each instead
+func ListLectureReferenceChunks(database *sql.DB, lectureID string) ([]DocumentChunk, error) {
+	documentRows, err := database.Query("SELECT id, title FROM reference_documents WHERE lecture_id = ? ORDER BY id", lectureID)
+	if err != nil {
+		return nil, err
+	}
+	type documentInfo struct{ id, title string }
+	var lectureDocuments []documentInfo
+	for documentRows.Next() {
+		var document documentInfo
+		if documentRows.Scan(&document.id, &document.title) == nil {
+			lectureDocuments = append(lectureDocuments, document)
+		}
+	}
+	documentRows.Close()
+
+	var chunks []DocumentChunk
+	for _, document := range lectureDocuments {
+		documentChunks, err := listDocumentChunks(database, document.id, document.title)
+		if err != nil {
+			return nil, err
+		}
+		if len(documentChunks) == 0 {
+			documentChunks, err = listPagesAsChunks(database, document.id, document.title)
+			if err != nil {
+				return nil, err
+			}
+		}
+		pageLanguages, err := listPageLanguages(database, document.id)
+		if err != nil {
+			return nil, err
+		}
+		for chunkIndex := range documentChunks {
+			documentChunks[chunkIndex].Language = chunkLanguage(documentChunks[chunkIndex], pageLanguages)
+		}
+		chunks = append(chunks, documentChunks...)
+	}
+	return chunks, nil
+}
+
+func listDocumentChunks(database *sql.DB, documentID string, documentTitle string) ([]DocumentChunk, error) {
+	rows, err := database.Query(`
+		SELECT id, chunk_index, start_page, end_page, heading, content
+		FROM reference_chunks
+		WHERE document_id = ?
+		ORDER BY chunk_index ASC
+	`, documentID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var chunks []DocumentChunk
+	for rows.Next() {
+		chunk := DocumentChunk{DocumentTitle: documentTitle}
+		chunk.DocumentID = documentID
+		var heading sql.NullString
+		if err := rows.Scan(&chunk.ID, &chunk.ChunkIndex, &chunk.StartPage, &chunk.EndPage, &heading, &chunk.Content); err != nil {
+			return nil, err
+		}
+		chunk.Heading = heading.String
+		chunks = append(chunks, chunk)
+	}
+	return chunks, rows.Err()
+}
+
+func listPagesAsChunks(database *sql.DB, documentID string, documentTitle string) ([]DocumentChunk, error) {
+	rows, err := database.Query(`
+		SELECT page_number, extracted_text FROM reference_pages
+		WHERE document_id = ?
+		ORDER BY page_number ASC
+	`, documentID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var chunks []DocumentChunk
+	for rows.Next() {
+		var pageNumber int
+		var text sql.NullString
+		if err := rows.Scan(&pageNumber, &text); err != nil {
+			return nil, err
+		}
+		if strings.TrimSpace(text.String) == "" {
+			continue
+		}
+		chunk := DocumentChunk{DocumentTitle: documentTitle}
+		chunk.DocumentID = documentID
+		chunk.ChunkIndex = len(chunks)
+		chunk.StartPage = pageNumber
+		chunk.EndPage = pageNumber
+		chunk.Content = strings.TrimSpace(text.String)
+		chunks = append(chunks, chunk)
+	}
+	return chunks, rows.Err()
+}
+
+// listPageLanguages returns the language of each page of a document that has one, keyed by page number
+func listPageLanguages(database *sql.DB, documentID string) (map[int]string, error) {
+	rows, err := database.Query("SELECT page_number, language FROM reference_pages WHERE document_id = ? AND language IS NOT NULL", documentID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	pageLanguages := make(map[int]string)
+	for rows.Next() {
+		var pageNumber int
+		var language string
+		if err := rows.Scan(&pageNumber, &language); err != nil {
+			return nil, err
+		}
+		pageLanguages[pageNumber] = language
+	}
+	return pageLanguages, rows.Err()
+}
+
+// chunkLanguage picks the language most pages of a chunk are in, the earliest page winning a tie
+func chunkLanguage(chunk DocumentChunk, pageLanguages map[int]string) string {
+	pages := make([]models.ReferencePage, 0, chunk.EndPage-chunk.StartPage+1)
+	for pageNumber := chunk.StartPage; pageNumber <= chunk.EndPage; pageNumber++ {
+		pages = append(pages, models.ReferencePage{PageNumber: pageNumber, Language: pageLanguages[pageNumber]})
+	}
+	return DominantLanguage(pages)
+}
@@ -0,0 +1,310 @@
+package documents
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"html"
+	"io"
+	"mime"
+	"net"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+	"time"
+
+	"lectures/internal/netguard"
+
+	htmlparser "golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+	"golang.org/x/net/html/charset"
+)
+
+// webpageClient fetches reference webpages. Redirects are followed up to the standard library limit, and unless
+// private networks are allowed every address connected to, including those redirected to, must be public
+func webpageClient(allowPrivateNetworks bool) *http.Client {
+	return &http.Client{
+		Transport:     netguard.Transport(&net.Dialer{Timeout: 30 * time.Second}, allowPrivateNetworks),
+		Timeout:       2 * time.Minute,
+		CheckRedirect: netguard.CheckRedirect(allowPrivateNetworks),
+	}
+}
+
+// boilerplateElements are dropped from fetched pages because they never carry the content worth studying
+var boilerplateElements = map[atom.Atom]bool{
+	atom.Script: true, atom.Style: true, atom.Noscript: true, atom.Template: true, atom.Iframe: true,
+	atom.Nav: true, atom.Header: true, atom.Footer: true, atom.Aside: true, atom.Form: true,
+	atom.Button: true, atom.Input: true, atom.Select: true, atom.Textarea: true, atom.Dialog: true,
+	atom.Svg: true, atom.Canvas: true, atom.Object: true, atom.Embed: true, atom.Link: true, atom.Meta: true,
+}
+
+// boilerplateRoles are the ARIA landmarks of site chrome rather than content
+var boilerplateRoles = map[string]bool{
+	"navigation": true, "banner": true, "contentinfo": true, "complementary": true, "search": true, "dialog": true,
+}
+
+// Webpage is a fetched page ready to be ingested as a reference document
+type Webpage struct {
+	// Title is the title of the page, or the last segment of its URL when it has none
+	Title string
+	// Extension is ".html" for cleaned pages and ".pdf" for PDFs served directly
+	Extension string
+	Content   []byte
+	// URL is the address the page was served from after redirects
+	URL string
+}
+
+// FetchWebpage downloads a webpage for ingestion. HTML pages are reduced to their readable content with
+// ExtractReadableHTML, plain text is wrapped in a minimal page and PDFs are kept as they are; pages larger than
+// maximumBytes and other content types are refused, as are private network addresses unless allowPrivateNetworks
+func FetchWebpage(jobContext context.Context, pageURL string, maximumBytes int64, allowPrivateNetworks bool) (*Webpage, error) {
+	request, err := http.NewRequestWithContext(jobContext, http.MethodGet, pageURL, nil)
+	if err != nil {
+		return nil, fmt.Errorf("invalid URL: %w", err)
+	}
+	request.Header.Set("User-Agent", "LecturesAssistant/1.0 (reference document ingestion)")
+	request.Header.Set("Accept", "text/html,application/xhtml+xml,application/pdf;q=0.9,text/plain;q=0.8")
+
+	response, err := webpageClient(allowPrivateNetworks).Do(request)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch page: %w", err)
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to fetch page: server responded with %s", response.Status)
+	}
+
+	content, err := io.ReadAll(io.LimitReader(response.Body, maximumBytes+1))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read page: %w", err)
+	}
+	if int64(len(content)) > maximumBytes {
+		return nil, fmt.Errorf("page is larger than %d MB", maximumBytes>>20)
+	}
+
+	contentType := response.Header.Get("Content-Type")
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil || mediaType == "application/octet-stream" {
+		contentType = http.DetectContentType(content)
+		mediaType, _, _ = mime.ParseMediaType(contentType)
+	}
+
+	finalURL := response.Request.URL
+	webpage := &Webpage{Title: urlTitle(finalURL), URL: finalURL.String()}
+	switch mediaType {
+	case "application/pdf":
+		webpage.Extension = ".pdf"
+		webpage.Content = content
+	case "text/html", "application/xhtml+xml":
+		decodedReader, err := charset.NewReader(bytes.NewReader(content), contentType)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode page: %w", err)
+		}
+		title, readableHTML, err := ExtractReadableHTML(decodedReader, finalURL)
+		if err != nil {
+			return nil, err
+		}
+		if title != "" {
+			webpage.Title = title
+		}
+		webpage.Extension = ".html"
+		webpage.Content = []byte(readableHTML)
+	case "text/plain":
+		decodedReader, err := charset.NewReader(bytes.NewReader(content), contentType)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode page: %w", err)
+		}
+		text, err := io.ReadAll(decodedReader)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode page: %w", err)
+		}
+		webpage.Extension = ".html"
+		webpage.Content = []byte(readablePage(webpage.Title, finalURL, "<pre>"+html.EscapeString(string(text))+"</pre>"))
+	default:
+		return nil, fmt.Errorf("unsupported content type %q; only HTML pages, plain text and PDFs can be ingested", mediaType)
+	}
+	return webpage, nil
+}
+
+// ExtractReadableHTML strips the boilerplate of a webpage and returns its title and a standalone HTML document
+// holding only its content. The content is the page's <article> or <main> element when it has one, and its body
+// otherwise; navigation, headers, footers, sidebars, forms, scripts and hidden elements are removed, and links and
+// images are made absolute against pageURL so they survive conversion. The document opens with the source address
+func ExtractReadableHTML(page io.Reader, pageURL *url.URL) (string, string, error) {
+	root, err := htmlparser.Parse(page)
+	if err != nil {
+		return "", "", fmt.Errorf("failed to parse page: %w", err)
+	}
+
+	title := ""
+	if titleNode := findElement(root, func(node *htmlparser.Node) bool { return node.DataAtom == atom.Title }); titleNode != nil {
+		title = strings.Join(strings.Fields(nodeText(titleNode)), " ")
+	}
+	baseURL := pageURL
+	if baseNode := findElement(root, func(node *htmlparser.Node) bool { return node.DataAtom == atom.Base }); baseNode != nil {
+		if baseReference, err := pageURL.Parse(attribute(baseNode, "href")); err == nil {
+			baseURL = baseReference
+		}
+	}
+
+	content := findElement(root, func(node *htmlparser.Node) bool { return node.DataAtom == atom.Article })
+	if content == nil {
+		content = findElement(root, func(node *htmlparser.Node) bool {
+			return node.DataAtom == atom.Main || attribute(node, "role") == "main"
+		})
+	}
+	if content == nil {
+		content = findElement(root, func(node *htmlparser.Node) bool { return node.DataAtom == atom.Body })
+	}
+	if content == nil {
+		return "", "", fmt.Errorf("page has no content")
+	}
+
+	removeBoilerplate(content)
+	absolutizeReferences(content, baseURL)
+	if strings.TrimSpace(nodeText(content)) == "" {
+		return "", "", fmt.Errorf("page has no readable text")
+	}
+
+	var body strings.Builder
+	for child := content.FirstChild; child != nil; child = child.NextSibling {
+		if err := htmlparser.Render(&body, child); err != nil {
+			return "", "", fmt.Errorf("failed to render page: %w", err)
+		}
+	}
+	return title, readablePage(title, pageURL, body.String()), nil
+}
+
+// readablePage wraps extracted content in a standalone document that records where it was fetched from
+func readablePage(title string, pageURL *url.URL, body string) string {
+	var builder strings.Builder
+	fmt.Fprintf(&builder, "<!DOCTYPE html>\n<html>\n<head>\n<meta charset=\"utf-8\">\n<title>%s</title>\n</head>\n<body>\n", html.EscapeString(title))
+	if title != "" {
+		fmt.Fprintf(&builder, "<h1>%s</h1>\n", html.EscapeString(title))
+	}
+	source := html.EscapeString(pageURL.String())
+	fmt.Fprintf(&builder, "<p><small>Source: <a href=\"%s\">%s</a></small></p>\n", source, source)
+	builder.WriteString(body)
+	builder.WriteString("\n</body>\n</html>\n")
+	return builder.String()
+}
+
+// removeBoilerplate detaches the site chrome, hidden elements and comments below node
+func removeBoilerplate(node *htmlparser.Node) {
+	for child := node.FirstChild; child != nil; {
+		next := child.NextSibling
+		if isBoilerplate(child) {
+			node.RemoveChild(child)
+		} else {
+			removeBoilerplate(child)
+		}
+		child = next
+	}
+}
+
+func isBoilerplate(node *htmlparser.Node) bool {
+	switch node.Type {
+	case htmlparser.CommentNode:
+		return true
+	case htmlparser.ElementNode:
+		if boilerplateElements[node.DataAtom] || boilerplateRoles[attribute(node, "role")] {
+			return true
+		}
+		if hasAttribute(node, "hidden") || attribute(node, "aria-hidden") == "true" {
+			return true
+		}
+		style := strings.ReplaceAll(strings.ToLower(attribute(node, "style")), " ", "")
+		return strings.Contains(style, "display:none") || strings.Contains(style, "visibility:hidden")
+	}
+	return false
+}
+
+// absolutizeReferences resolves the links and image sources below node against baseURL. Lazy-loaded images
+// keep their real source in data-src, which replaces the placeholder
+func absolutizeReferences(node *htmlparser.Node, baseURL *url.URL) {
+	if node.Type == htmlparser.ElementNode {
+		if node.DataAtom == atom.Img {
+			if lazySource := attribute(node, "data-src"); lazySource != "" {
+				setAttribute(node, "src", lazySource)
+			}
+		}
+		for attributeIndex, nodeAttribute := range node.Attr {
+			if nodeAttribute.Key != "href" && nodeAttribute.Key != "src" {
+				continue
+			}
+			if strings.HasPrefix(nodeAttribute.Val, "#") || strings.HasPrefix(nodeAttribute.Val, "data:") {
+				continue
+			}
+			if resolved, err := baseURL.Parse(strings.TrimSpace(nodeAttribute.Val)); err == nil {
+				node.Attr[attributeIndex].Val = resolved.String()
+			}
+		}
+	}
+	for child := node.FirstChild; child != nil; child = child.NextSibling {
+		absolutizeReferences(child, baseURL)
+	}
+}
+
+// findElement returns the first element below node, in document order, that matches
+func findElement(node *htmlparser.Node, matches func(*htmlparser.Node) bool) *htmlparser.Node {
+	if node.Type == htmlparser.ElementNode && matches(node) {
+		return node
+	}
+	for child := node.FirstChild; child != nil; child = child.NextSibling {
+		if found := findElement(child, matches); found != nil {
+			return found
+		}
+	}
+	return nil
+}
+
+func nodeText(node *htmlparser.Node) string {
+	if node.Type == htmlparser.TextNode {
+		return node.Data
+	}
+	var builder strings.Builder
+	for child := node.FirstChild; child != nil; child = child.NextSibling {
+		builder.WriteString(nodeText(child))
+	}
+	return builder.String()
+}
+
+func attribute(node *htmlparser.Node, key string) string {
+	for _, nodeAttribute := range node.Attr {
+		if nodeAttribute.Key == key {
+			return nodeAttribute.Val
+		}
+	}
+	return ""
+}
+
+func hasAttribute(node *htmlparser.Node, key string) bool {
+	for _, nodeAttribute := range node.Attr {
+		if nodeAttribute.Key == key {
+			return true
+		}
+	}
+	return false
+}
+
+func setAttribute(node *htmlparser.Node, key string, value string) {
+	for attributeIndex, nodeAttribute := range node.Attr {
+		if nodeAttribute.Key == key {
+			node.Attr[attributeIndex].Val = value
+			return
+		}
+	}
+	node.Attr = append(node.Attr, htmlparser.Attribute{Key: key, Val: value})
+}
+
+// urlTitle names a page after the last segment of its path, or its host for the root page
+func urlTitle(pageURL *url.URL) string {
+	if segment := path.Base(strings.TrimSuffix(pageURL.Path, "/")); segment != "." && segment != "/" && segment != "" {
+		if unescaped, err := url.PathUnescape(segment); err == nil {
+			return unescaped
+		}
+		return segment
+	}
+	return pageURL.Host
+}
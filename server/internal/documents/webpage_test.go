@@ -0,0 +1,111 @@
+package documents
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"lectures/internal/netguard"
+)
+
+func TestExtractReadableHTML_KeepsArticleAndDropsBoilerplate(t *testing.T) {
+	page := `<!DOCTYPE html>
+<html><head><title> Cell  Biology </title><base href="https://example.edu/notes/"><script>track()</script></head>
+<body>
+<header><a href="/">Home</a></header>
+<nav><ul><li>Menu entry</li></ul></nav>
+<article>
+<h2>Mitochondria</h2>
+<p>The powerhouse of the cell.<!-- editor note --></p>
+<img data-src="figures/mito.png" src="placeholder.gif">
+<div class="share" aria-hidden="true">Share this</div>
+<aside>Related posts</aside>
+<p style="display: none">Hidden text</p>
+<a href="#references">References</a> <a href="glossary.html">Glossary</a>
+</article>
+<footer>Copyright</footer>
+</body></html>`
+	pageURL, _ := url.Parse("https://example.edu/notes/cells")
+
+	title, document, err := ExtractReadableHTML(strings.NewReader(page), pageURL)
+	if err != nil {
+		t.Fatalf("ExtractReadableHTML failed: %v", err)
+	}
+	if title != "Cell Biology" {
+		t.Errorf("Expected the page title, got %q", title)
+	}
+	for _, expected := range []string{"Mitochondria", "The powerhouse of the cell.", `src="https://example.edu/notes/figures/mito.png"`, `href="https://example.edu/notes/glossary.html"`, `href="#references"`, "Source: <a href=\"https://example.edu/notes/cells\""} {
+		if !strings.Contains(document, expected) {
+			t.Errorf("Expected the document to contain %q, got:\n%s", expected, document)
+		}
+	}
+	for _, unexpected := range []string{"Menu entry", "Home", "track()", "editor note", "Share this", "Related posts", "Hidden text", "Copyright"} {
+		if strings.Contains(document, unexpected) {
+			t.Errorf("Expected %q to be stripped, got:\n%s", unexpected, document)
+		}
+	}
+}
+
+func TestExtractReadableHTML_RejectsPagesWithoutText(t *testing.T) {
+	pageURL, _ := url.Parse("https://example.edu/")
+	if _, _, err := ExtractReadableHTML(strings.NewReader("<html><body><nav>Menu</nav><script>app()</script></body></html>"), pageURL); err == nil {
+		t.Error("Expected a page without readable text to be rejected")
+	}
+}
+
+func TestFetchWebpage_HandlesContentTypes(t *testing.T) {
+	testServer := httptest.NewServer(http.HandlerFunc(func(responseWriter http.ResponseWriter, request *http.Request) {
+		switch request.URL.Path {
+		case "/moved":
+			http.Redirect(responseWriter, request, "/lesson", http.StatusFound)
+		case "/lesson":
+			responseWriter.Header().Set("Content-Type", "text/html; charset=iso-8859-1")
+			responseWriter.Write([]byte("<html><head><title>Le\xe7on</title></head><body><main><p>Caf\xe9 content</p></main></body></html>"))
+		case "/handout.pdf":
+			responseWriter.Header().Set("Content-Type", "application/pdf")
+			responseWriter.Write([]byte("%PDF-1.4 handout"))
+		case "/archive.zip":
+			responseWriter.Header().Set("Content-Type", "application/zip")
+			responseWriter.Write([]byte("PK"))
+		case "/large":
+			responseWriter.Header().Set("Content-Type", "text/plain")
+			responseWriter.Write([]byte(strings.Repeat("a", 2048)))
+		default:
+			http.NotFound(responseWriter, request)
+		}
+	}))
+	defer testServer.Close()
+
+	webpage, err := FetchWebpage(context.Background(), testServer.URL+"/moved", 1<<20, true)
+	if err != nil {
+		t.Fatalf("FetchWebpage failed: %v", err)
+	}
+	if webpage.Title != "Leçon" || webpage.Extension != ".html" || webpage.URL != testServer.URL+"/lesson" {
+		t.Errorf("Unexpected webpage: title %q, extension %q, URL %q", webpage.Title, webpage.Extension, webpage.URL)
+	}
+	if !strings.Contains(string(webpage.Content), "Café content") {
+		t.Errorf("Expected the page to be decoded to UTF-8, got:\n%s", webpage.Content)
+	}
+
+	webpage, err = FetchWebpage(context.Background(), testServer.URL+"/handout.pdf", 1<<20, true)
+	if err != nil {
+		t.Fatalf("FetchWebpage failed for a PDF: %v", err)
+	}
+	if webpage.Extension != ".pdf" || webpage.Title != "handout.pdf" || string(webpage.Content) != "%PDF-1.4 handout" {
+		t.Errorf("Expected the PDF to be kept as it is, got %+v", webpage)
+	}
+
+	for _, failingPath := range []string{"/archive.zip", "/missing", "/large"} {
+		if _, err := FetchWebpage(context.Background(), testServer.URL+failingPath, 1024, true); err == nil {
+			t.Errorf("Expected fetching %s to fail", failingPath)
+		}
+	}
+
+	if _, err := FetchWebpage(context.Background(), testServer.URL+"/lesson", 1<<20, false); !errors.Is(err, netguard.ErrPrivateAddress) {
+		t.Errorf("Expected a loopback page to be refused unless private networks are allowed, got %v", err)
+	}
+}
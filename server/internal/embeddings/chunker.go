@@ -0,0 +1,162 @@
+package embeddings
+
+import (
+	"fmt"
+	"strings"
+
+	"lectures/internal/documents"
+)
+
+// DefaultChunkSizeCharacters is used when embeddings.chunk_size_characters is not set
+const DefaultChunkSizeCharacters = 1200
+
+// Source types of a chunk, matching the chat_citations source types
+const (
+	SourceTranscript = "transcript"
+	SourceSlide      = "slide"
+)
+
+// Chunk is a piece of a lecture that is embedded and retrieved on its own
+type Chunk struct {
+	LectureID        string
+	SourceType       string
+	SourceID         string // Transcript ID or reference document ID
+	Label            string // Human-readable location, e.g. "Transcript 00:12:30" or "notes.pdf, page 4"
+	PageNumber       int
+	StartMillisecond int64
+	EndMillisecond   int64
+	Content          string
+}
+
+// TranscriptSegment is the part of a transcript segment the chunker needs
+type TranscriptSegment struct {
+	StartMillisecond int64
+	EndMillisecond   int64
+	Text             string
+}
+
+// ChunkTranscript groups consecutive segments into chunks of roughly chunkSize characters
+func ChunkTranscript(lectureID string, transcriptID string, segments []TranscriptSegment, chunkSize int) []Chunk {
+	if chunkSize <= 0 {
+		chunkSize = DefaultChunkSizeCharacters
+	}
+
+	var chunks []Chunk
+	var builder strings.Builder
+	var current Chunk
+	flush := func() {
+		if builder.Len() == 0 {
+			return
+		}
+		current.Content = strings.TrimSpace(builder.String())
+		current.Label = "Transcript " + formatTimestamp(current.StartMillisecond)
+		chunks = append(chunks, current)
+		builder.Reset()
+	}
+
+	for _, segment := range segments {
+		text := strings.TrimSpace(segment.Text)
+		if text == "" {
+			continue
+		}
+		if builder.Len() > 0 && builder.Len()+len(text) > chunkSize {
+			flush()
+		}
+		if builder.Len() == 0 {
+			current = Chunk{
+				LectureID:        lectureID,
+				SourceType:       SourceTranscript,
+				SourceID:         transcriptID,
+				StartMillisecond: segment.StartMillisecond,
+			}
+		} else {
+			builder.WriteString(" ")
+		}
+		builder.WriteString(text)
+		current.EndMillisecond = segment.EndMillisecond
+	}
+	flush()
+	return chunks
+}
+
+// ChunkReference splits the text of a reference chunk covering pages startPage to endPage into chunks of roughly
+// chunkSize characters, on paragraph boundaries where possible. When the text carries page markers, each chunk is
+// labeled with the pages it actually covers
+func ChunkReference(lectureID string, documentID string, documentTitle string, startPage int, endPage int, text string, chunkSize int) []Chunk {
+	if chunkSize <= 0 {
+		chunkSize = DefaultChunkSizeCharacters
+	}
+
+	type pageParagraph struct {
+		pageNumber int
+		text       string
+	}
+	var paragraphs []pageParagraph
+	pageNumber := startPage
+	hasPageMarkers := false
+	for _, paragraph := range strings.Split(text, "\n\n") {
+		paragraph = strings.TrimSpace(paragraph)
+		if markedPage := documents.PageMarkerNumber(paragraph); markedPage > 0 {
+			pageNumber = markedPage
+			hasPageMarkers = true
+			continue
+		}
+		if paragraph != "" {
+			paragraphs = append(paragraphs, pageParagraph{pageNumber: pageNumber, text: paragraph})
+		}
+	}
+
+	newChunk := func(pieceParagraphs []pageParagraph) Chunk {
+		firstPage, lastPage := startPage, endPage
+		if hasPageMarkers {
+			firstPage, lastPage = pieceParagraphs[0].pageNumber, pieceParagraphs[len(pieceParagraphs)-1].pageNumber
+		}
+		label := fmt.Sprintf("%s, page %d", documentTitle, firstPage)
+		if lastPage > firstPage {
+			label = fmt.Sprintf("%s, pages %d–%d", documentTitle, firstPage, lastPage)
+		}
+		// A chunk still spanning pages keeps the markers, so the answer can cite the page of each passage
+		texts := make([]string, 0, len(pieceParagraphs))
+		previousPage := 0
+		for _, paragraph := range pieceParagraphs {
+			if hasPageMarkers && lastPage > firstPage && paragraph.pageNumber != previousPage {
+				texts = append(texts, documents.PageMarker(paragraph.pageNumber))
+				previousPage = paragraph.pageNumber
+			}
+			texts = append(texts, paragraph.text)
+		}
+		return Chunk{
+			LectureID:  lectureID,
+			SourceType: SourceSlide,
+			SourceID:   documentID,
+			Label:      label,
+			PageNumber: firstPage,
+			Content:    strings.Join(texts, "\n\n"),
+		}
+	}
+
+	var chunks []Chunk
+	var piece []pageParagraph
+	pieceLength := 0
+	for _, paragraph := range paragraphs {
+		if len(piece) > 0 && pieceLength+len(paragraph.text) > chunkSize {
+			chunks = append(chunks, newChunk(piece))
+			piece = nil
+			pieceLength = 0
+		}
+		if len(piece) > 0 {
+			pieceLength += 2
+		}
+		piece = append(piece, paragraph)
+		pieceLength += len(paragraph.text)
+	}
+	if len(piece) > 0 {
+		chunks = append(chunks, newChunk(piece))
+	}
+	return chunks
+}
+
+func formatTimestamp(milliseconds int64) string {
+	totalSeconds := milliseconds / 1000
+	return fmt.Sprintf("%02d:%02d:%02d", totalSeconds/3600, (totalSeconds/60)%60, totalSeconds%60)
+}
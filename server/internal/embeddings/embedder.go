@@ -0,0 +1,178 @@
+// Package embeddings chunks lecture transcripts and reference pages, embeds them and retrieves the chunks
+// most relevant to a chat question, so the chat prompt carries only what it needs instead of whole lectures.
+package embeddings
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"lectures/internal/configuration"
+)
+
+const (
+	openAIBaseURL      = "https://api.openai.com/v1"
+	defaultOpenAIModel = "text-embedding-3-small"
+	defaultOllamaModel = "nomic-embed-text"
+)
+
+// Embedder turns texts into vectors, one per input and in the same order
+type Embedder interface {
+	Embed(context context.Context, texts []string) ([][]float32, error)
+
+	// Model identifies the embedding model; vectors from different models are never compared
+	Model() string
+}
+
+// NewEmbedder creates the embedder selected by the configuration, or nil when retrieval is disabled
+func NewEmbedder(config *configuration.Configuration) (Embedder, error) {
+	switch config.Embeddings.Provider {
+	case "":
+		return nil, nil
+	case "openai":
+		return NewOpenAIEmbedder(config.Providers.OpenAI.APIKey, config.Providers.OpenAI.BaseURL, config.Embeddings.Model), nil
+	case "ollama":
+		return NewOllamaEmbedder(config.Providers.Ollama.BaseURL, config.Embeddings.Model), nil
+	default:
+		return nil, fmt.Errorf("unknown embeddings provider: %s", config.Embeddings.Provider)
+	}
+}
+
+// OpenAIEmbedder calls the OpenAI embeddings API or any compatible endpoint
+type OpenAIEmbedder struct {
+	apiKey     string
+	baseURL    string
+	model      string
+	httpClient *http.Client
+}
+
+// NewOpenAIEmbedder creates an OpenAI embedder; an empty baseURL targets api.openai.com
+func NewOpenAIEmbedder(apiKey string, baseURL string, model string) *OpenAIEmbedder {
+	if baseURL == "" {
+		baseURL = openAIBaseURL
+	}
+	if model == "" {
+		model = defaultOpenAIModel
+	}
+	return &OpenAIEmbedder{
+		apiKey:     apiKey,
+		baseURL:    strings.TrimSuffix(baseURL, "/"),
+		model:      model,
+		httpClient: &http.Client{Timeout: 2 * time.Minute},
+	}
+}
+
+func (embedder *OpenAIEmbedder) Model() string {
+	return embedder.model
+}
+
+func (embedder *OpenAIEmbedder) Embed(requestContext context.Context, texts []string) ([][]float32, error) {
+	var parsedResponse struct {
+		Data []struct {
+			Index     int       `json:"index"`
+			Embedding []float32 `json:"embedding"`
+		} `json:"data"`
+	}
+	err := postJSON(requestContext, embedder.httpClient, embedder.baseURL+"/embeddings", embedder.apiKey, map[string]any{
+		"model": embedder.model,
+		"input": texts,
+	}, &parsedResponse)
+	if err != nil {
+		return nil, err
+	}
+
+	vectors := make([][]float32, len(texts))
+	for _, item := range parsedResponse.Data {
+		if item.Index >= 0 && item.Index < len(vectors) {
+			vectors[item.Index] = item.Embedding
+		}
+	}
+	for index, vector := range vectors {
+		if len(vector) == 0 {
+			return nil, fmt.Errorf("no embedding received for input %d", index)
+		}
+	}
+	return vectors, nil
+}
+
+// OllamaEmbedder calls the embed endpoint of a local Ollama server
+type OllamaEmbedder struct {
+	baseURL    string
+	model      string
+	httpClient *http.Client
+}
+
+// NewOllamaEmbedder creates an Ollama embedder
+func NewOllamaEmbedder(baseURL string, model string) *OllamaEmbedder {
+	if baseURL == "" {
+		baseURL = "http://localhost:11434"
+	}
+	if model == "" {
+		model = defaultOllamaModel
+	}
+	return &OllamaEmbedder{
+		baseURL:    strings.TrimSuffix(baseURL, "/"),
+		model:      model,
+		httpClient: &http.Client{Timeout: 5 * time.Minute},
+	}
+}
+
+func (embedder *OllamaEmbedder) Model() string {
+	return embedder.model
+}
+
+func (embedder *OllamaEmbedder) Embed(requestContext context.Context, texts []string) ([][]float32, error) {
+	var parsedResponse struct {
+		Embeddings [][]float32 `json:"embeddings"`
+	}
+	err := postJSON(requestContext, embedder.httpClient, embedder.baseURL+"/api/embed", "", map[string]any{
+		"model": embedder.model,
+		"input": texts,
+	}, &parsedResponse)
+	if err != nil {
+		return nil, err
+	}
+	if len(parsedResponse.Embeddings) != len(texts) {
+		return nil, fmt.Errorf("expected %d embeddings, received %d", len(texts), len(parsedResponse.Embeddings))
+	}
+	return parsedResponse.Embeddings, nil
+}
+
+func postJSON(requestContext context.Context, httpClient *http.Client, url string, apiKey string, body any, result any) error {
+	encodedBody, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+
+	httpRequest, err := http.NewRequestWithContext(requestContext, http.MethodPost, url, bytes.NewReader(encodedBody))
+	if err != nil {
+		return fmt.Errorf("failed to create embeddings request: %w", err)
+	}
+	httpRequest.Header.Set("Content-Type", "application/json")
+	if apiKey != "" {
+		httpRequest.Header.Set("Authorization", "Bearer "+apiKey)
+	}
+
+	httpResponse, err := httpClient.Do(httpRequest)
+	if err != nil {
+		return fmt.Errorf("embeddings request failed: %w", err)
+	}
+	defer httpResponse.Body.Close()
+
+	responseBody, err := io.ReadAll(httpResponse.Body)
+	if err != nil {
+		return fmt.Errorf("failed to read embeddings response: %w", err)
+	}
+	if httpResponse.StatusCode != http.StatusOK {
+		return fmt.Errorf("embeddings provider returned status %d: %s", httpResponse.StatusCode, strings.TrimSpace(string(responseBody)))
+	}
+	if err := json.Unmarshal(responseBody, result); err != nil {
+		return fmt.Errorf("failed to parse embeddings response: %w", err)
+	}
+	return nil
+}
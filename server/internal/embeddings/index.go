@@ -0,0 +1,244 @@
+package embeddings
+
+import (
+	"context"
+	"database/sql"
+	"encoding/binary"
+	"fmt"
+	"log/slog"
+	"math"
+	"sort"
+	"strings"
+	"time"
+
+	"lectures/internal/documents"
+)
+
+// DefaultTopK is used when embeddings.top_k is not set
+const DefaultTopK = 8
+
+// embeddingBatchSize is the number of chunks sent to the embedder per request
+const embeddingBatchSize = 64
+
+// Result is a retrieved chunk and its cosine similarity to the question
+type Result struct {
+	Chunk
+	Score float64
+}
+
+// Index stores chunk embeddings in SQLite and answers similarity queries over them
+type Index struct {
+	database  *sql.DB
+	embedder  Embedder
+	chunkSize int
+}
+
+// NewIndex creates an index; chunkSize falls back to DefaultChunkSizeCharacters when not positive
+func NewIndex(database *sql.DB, embedder Embedder, chunkSize int) *Index {
+	if chunkSize <= 0 {
+		chunkSize = DefaultChunkSizeCharacters
+	}
+	return &Index{database: database, embedder: embedder, chunkSize: chunkSize}
+}
+
+// EnsureLecture indexes a ready lecture unless it already has chunks for the current model.
+// Lectures still processing are skipped, so a partial transcript is never cached
+func (index *Index) EnsureLecture(requestContext context.Context, lectureID string) error {
+	var status string
+	if err := index.database.QueryRow("SELECT status FROM lectures WHERE id = ?", lectureID).Scan(&status); err != nil {
+		return err
+	}
+	if status != "ready" {
+		return nil
+	}
+
+	var exists bool
+	index.database.QueryRow("SELECT EXISTS(SELECT 1 FROM embedding_chunks WHERE lecture_id = ? AND model = ?)", lectureID, index.embedder.Model()).Scan(&exists)
+	if exists {
+		return nil
+	}
+
+	chunkCount, err := index.IndexLecture(requestContext, lectureID)
+	if err != nil {
+		return err
+	}
+	slog.Info("Lecture indexed for retrieval", "lectureID", lectureID, "chunks", chunkCount, "model", index.embedder.Model())
+	return nil
+}
+
+// IndexLecture (re)builds the chunks of a lecture from its transcript and reference document chunks and returns their count
+func (index *Index) IndexLecture(requestContext context.Context, lectureID string) (int, error) {
+	chunks, err := index.lectureChunks(lectureID)
+	if err != nil {
+		return 0, err
+	}
+
+	vectors := make([][]float32, 0, len(chunks))
+	for start := 0; start < len(chunks); start += embeddingBatchSize {
+		end := min(start+embeddingBatchSize, len(chunks))
+		texts := make([]string, 0, end-start)
+		for _, chunk := range chunks[start:end] {
+			texts = append(texts, chunk.Label+"\n"+chunk.Content)
+		}
+		batchVectors, err := index.embedder.Embed(requestContext, texts)
+		if err != nil {
+			return 0, fmt.Errorf("failed to embed lecture chunks: %w", err)
+		}
+		vectors = append(vectors, batchVectors...)
+	}
+
+	transaction, err := index.database.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer transaction.Rollback()
+
+	if _, err := transaction.Exec("DELETE FROM embedding_chunks WHERE lecture_id = ?", lectureID); err != nil {
+		return 0, err
+	}
+	for chunkIndex, chunk := range chunks {
+		_, err := transaction.Exec(`
+			INSERT INTO embedding_chunks (lecture_id, source_type, source_id, label, page_number, start_millisecond, end_millisecond, content, model, vector, created_at)
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+		`, chunk.LectureID, chunk.SourceType, chunk.SourceID, chunk.Label, chunk.PageNumber, chunk.StartMillisecond, chunk.EndMillisecond, chunk.Content, index.embedder.Model(), encodeVector(vectors[chunkIndex]), time.Now())
+		if err != nil {
+			return 0, fmt.Errorf("failed to store chunk: %w", err)
+		}
+	}
+	return len(chunks), transaction.Commit()
+}
+
+// Search returns the topK chunks of the given lectures most similar to the question, best first
+func (index *Index) Search(requestContext context.Context, lectureIDs []string, question string, topK int) ([]Result, error) {
+	if len(lectureIDs) == 0 || strings.TrimSpace(question) == "" {
+		return nil, nil
+	}
+	if topK <= 0 {
+		topK = DefaultTopK
+	}
+
+	questionVectors, err := index.embedder.Embed(requestContext, []string{question})
+	if err != nil {
+		return nil, fmt.Errorf("failed to embed question: %w", err)
+	}
+	questionVector := questionVectors[0]
+
+	placeholders := make([]string, len(lectureIDs))
+	arguments := []any{index.embedder.Model()}
+	for lectureIndex, lectureID := range lectureIDs {
+		placeholders[lectureIndex] = "?"
+		arguments = append(arguments, lectureID)
+	}
+	rows, err := index.database.Query(fmt.Sprintf(`
+		SELECT lecture_id, source_type, source_id, label, page_number, start_millisecond, end_millisecond, content, vector
+		FROM embedding_chunks
+		WHERE model = ? AND lecture_id IN (%s)
+	`, strings.Join(placeholders, ",")), arguments...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var results []Result
+	for rows.Next() {
+		var result Result
+		var pageNumber sql.NullInt64
+		var startMillisecond, endMillisecond sql.NullInt64
+		var vectorData []byte
+		if err := rows.Scan(&result.LectureID, &result.SourceType, &result.SourceID, &result.Label, &pageNumber, &startMillisecond, &endMillisecond, &result.Content, &vectorData); err != nil {
+			return nil, err
+		}
+		result.PageNumber = int(pageNumber.Int64)
+		result.StartMillisecond = startMillisecond.Int64
+		result.EndMillisecond = endMillisecond.Int64
+		result.Score = cosineSimilarity(questionVector, decodeVector(vectorData))
+		results = append(results, result)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	sort.SliceStable(results, func(first, second int) bool {
+		return results[first].Score > results[second].Score
+	})
+	if len(results) > topK {
+		results = results[:topK]
+	}
+	return results, nil
+}
+
+// DeleteLecture drops the chunks of a lecture so they are rebuilt from the current content on next use
+func DeleteLecture(database *sql.DB, lectureID string) error {
+	_, err := database.Exec("DELETE FROM embedding_chunks WHERE lecture_id = ?", lectureID)
+	return err
+}
+
+func (index *Index) lectureChunks(lectureID string) ([]Chunk, error) {
+	var chunks []Chunk
+
+	var transcriptID string
+	err := index.database.QueryRow("SELECT id FROM transcripts WHERE lecture_id = ?", lectureID).Scan(&transcriptID)
+	if err != nil && err != sql.ErrNoRows {
+		return nil, err
+	}
+	if transcriptID != "" {
+		rows, err := index.database.Query(`
+			SELECT start_millisecond, end_millisecond, text FROM transcript_segments
+			WHERE transcript_id = ?
+			ORDER BY start_millisecond ASC
+		`, transcriptID)
+		if err != nil {
+			return nil, err
+		}
+		var segments []TranscriptSegment
+		for rows.Next() {
+			var segment TranscriptSegment
+			if rows.Scan(&segment.StartMillisecond, &segment.EndMillisecond, &segment.Text) == nil {
+				segments = append(segments, segment)
+			}
+		}
+		rows.Close()
+		chunks = append(chunks, ChunkTranscript(lectureID, transcriptID, segments, index.chunkSize)...)
+	}
+
+	referenceChunks, err := documents.ListLectureReferenceChunks(index.database, lectureID)
+	if err != nil {
+		return nil, err
+	}
+	for _, referenceChunk := range referenceChunks {
+		chunks = append(chunks, ChunkReference(lectureID, referenceChunk.DocumentID, referenceChunk.DocumentTitle, referenceChunk.StartPage, referenceChunk.EndPage, referenceChunk.Content, index.chunkSize)...)
+	}
+	return chunks, nil
+}
+
+func encodeVector(vector []float32) []byte {
+	data := make([]byte, 4*len(vector))
+	for valueIndex, value := range vector {
+		binary.LittleEndian.PutUint32(data[4*valueIndex:], math.Float32bits(value))
+	}
+	return data
+}
+
+func decodeVector(data []byte) []float32 {
+	vector := make([]float32, len(data)/4)
+	for valueIndex := range vector {
+		vector[valueIndex] = math.Float32frombits(binary.LittleEndian.Uint32(data[4*valueIndex:]))
+	}
+	return vector
+}
+
+func cosineSimilarity(first []float32, second []float32) float64 {
+	if len(first) != len(second) || len(first) == 0 {
+		return 0
+	}
+	var dotProduct, firstNorm, secondNorm float64
+	for valueIndex := range first {
+		dotProduct += float64(first[valueIndex]) * float64(second[valueIndex])
+		firstNorm += float64(first[valueIndex]) * float64(first[valueIndex])
+		secondNorm += float64(second[valueIndex]) * float64(second[valueIndex])
+	}
+	if firstNorm == 0 || secondNorm == 0 {
+		return 0
+	}
+	return dotProduct / (math.Sqrt(firstNorm) * math.Sqrt(secondNorm))
+}
@@ -0,0 +1,173 @@
+package embeddings
+
+import (
+	"context"
+	"database/sql"
+	"hash/fnv"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"lectures/internal/database"
+)
+
+// bagOfWordsEmbedder hashes words into a small vector so texts sharing words are similar
+type bagOfWordsEmbedder struct {
+	calls int
+}
+
+func (embedder *bagOfWordsEmbedder) Model() string {
+	return "bag-of-words"
+}
+
+func (embedder *bagOfWordsEmbedder) Embed(_ context.Context, texts []string) ([][]float32, error) {
+	embedder.calls++
+	vectors := make([][]float32, len(texts))
+	for textIndex, text := range texts {
+		vector := make([]float32, 64)
+		for _, word := range strings.Fields(strings.ToLower(text)) {
+			hash := fnv.New32a()
+			hash.Write([]byte(strings.Trim(word, ".,?!")))
+			vector[hash.Sum32()%64]++
+		}
+		vectors[textIndex] = vector
+	}
+	return vectors, nil
+}
+
+func setupIndexTestDatabase(t *testing.T) *sql.DB {
+	t.Helper()
+	db, err := database.Initialize(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatalf("Failed to init DB: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	_, _ = db.Exec("INSERT INTO users (id, username, password_hash) VALUES ('user-1', 'tester', 'hash')")
+	_, _ = db.Exec("INSERT INTO exams (id, user_id, title) VALUES ('exam-1', 'user-1', 'Biology')")
+	return db
+}
+
+func TestChunkTranscript_GroupsSegments(t *testing.T) {
+	segments := []TranscriptSegment{
+		{StartMillisecond: 0, EndMillisecond: 4000, Text: "Cells are the unit of life."},
+		{StartMillisecond: 4000, EndMillisecond: 8000, Text: "   "},
+		{StartMillisecond: 8000, EndMillisecond: 12000, Text: "Mitochondria produce energy."},
+		{StartMillisecond: 3725000, EndMillisecond: 3730000, Text: "Ribosomes build proteins."},
+	}
+
+	chunks := ChunkTranscript("lecture-1", "transcript-1", segments, 60)
+	if len(chunks) != 2 {
+		t.Fatalf("Expected 2 chunks, got %d: %+v", len(chunks), chunks)
+	}
+	if chunks[0].Content != "Cells are the unit of life. Mitochondria produce energy." || chunks[0].EndMillisecond != 12000 {
+		t.Errorf("Unexpected first chunk: %+v", chunks[0])
+	}
+	if chunks[1].Label != "Transcript 01:02:05" || chunks[1].SourceType != SourceTranscript || chunks[1].SourceID != "transcript-1" {
+		t.Errorf("Unexpected second chunk: %+v", chunks[1])
+	}
+}
+
+func TestChunkReference_SplitsOnParagraphs(t *testing.T) {
+	text := "First paragraph about osmosis.\n\nSecond paragraph about diffusion.\n\n\n\nThird."
+
+	chunks := ChunkReference("lecture-1", "document-1", "slides.pdf", 3, 3, text, 40)
+	if len(chunks) != 2 {
+		t.Fatalf("Expected 2 chunks, got %d: %+v", len(chunks), chunks)
+	}
+	if chunks[0].Content != "First paragraph about osmosis." {
+		t.Errorf("Unexpected first chunk content: %q", chunks[0].Content)
+	}
+	if chunks[1].Content != "Second paragraph about diffusion.\n\nThird." {
+		t.Errorf("Unexpected second chunk content: %q", chunks[1].Content)
+	}
+	if chunks[1].Label != "slides.pdf, page 3" || chunks[1].PageNumber != 3 || chunks[1].SourceType != SourceSlide {
+		t.Errorf("Unexpected chunk location: %+v", chunks[1])
+	}
+}
+
+func TestChunkReference_LabelsMarkedPages(t *testing.T) {
+	text := "[Page 4]\n\nGlycolysis splits glucose into pyruvate.\n\n[Page 5]\n\nThe Krebs cycle follows.\n\n[Page 6]\n\nThen oxidative phosphorylation."
+
+	chunks := ChunkReference("lecture-1", "document-1", "slides.pdf", 4, 6, text, 60)
+	if len(chunks) != 2 {
+		t.Fatalf("Expected 2 chunks, got %d: %+v", len(chunks), chunks)
+	}
+	if chunks[0].Label != "slides.pdf, page 4" || chunks[0].PageNumber != 4 || chunks[0].Content != "Glycolysis splits glucose into pyruvate." {
+		t.Errorf("Unexpected first chunk: %+v", chunks[0])
+	}
+	if chunks[1].Label != "slides.pdf, pages 5–6" || chunks[1].PageNumber != 5 {
+		t.Errorf("Unexpected second chunk location: %+v", chunks[1])
+	}
+	if chunks[1].Content != "[Page 5]\n\nThe Krebs cycle follows.\n\n[Page 6]\n\nThen oxidative phosphorylation." {
+		t.Errorf("Expected the markers to stay in a chunk spanning pages, got %q", chunks[1].Content)
+	}
+}
+
+func TestIndex_SearchRanksRelevantChunksFirst(t *testing.T) {
+	db := setupIndexTestDatabase(t)
+	_, _ = db.Exec("INSERT INTO lectures (id, exam_id, title, status) VALUES ('lecture-1', 'exam-1', 'Cells', 'ready')")
+	_, _ = db.Exec("INSERT INTO transcripts (id, lecture_id, status) VALUES ('transcript-1', 'lecture-1', 'completed')")
+	_, _ = db.Exec("INSERT INTO transcript_segments (transcript_id, start_millisecond, end_millisecond, text) VALUES ('transcript-1', 0, 5000, 'Photosynthesis turns sunlight into chemical energy in plants.')")
+	_, _ = db.Exec("INSERT INTO transcript_segments (transcript_id, start_millisecond, end_millisecond, text) VALUES ('transcript-1', 5000, 9000, 'The exam will take place on Friday morning.')")
+	_, _ = db.Exec("INSERT INTO reference_documents (id, lecture_id, document_type, title, file_path, page_count) VALUES ('document-1', 'lecture-1', 'pdf', 'slides.pdf', '/tmp/slides.pdf', 1)")
+	_, _ = db.Exec("INSERT INTO reference_pages (document_id, page_number, image_path, extracted_text) VALUES ('document-1', 1, '/tmp/page.png', 'Mitochondria are the powerhouse of the cell.')")
+
+	embedder := &bagOfWordsEmbedder{}
+	index := NewIndex(db, embedder, 70)
+
+	if err := index.EnsureLecture(context.Background(), "lecture-1"); err != nil {
+		t.Fatalf("EnsureLecture failed: %v", err)
+	}
+	var chunkCount int
+	db.QueryRow("SELECT COUNT(*) FROM embedding_chunks WHERE lecture_id = 'lecture-1'").Scan(&chunkCount)
+	if chunkCount != 3 {
+		t.Fatalf("Expected 3 stored chunks, got %d", chunkCount)
+	}
+
+	// A second call reuses the stored chunks
+	callsBefore := embedder.calls
+	if err := index.EnsureLecture(context.Background(), "lecture-1"); err != nil {
+		t.Fatalf("EnsureLecture failed: %v", err)
+	}
+	if embedder.calls != callsBefore {
+		t.Error("Expected an indexed lecture not to be embedded again")
+	}
+
+	results, err := index.Search(context.Background(), []string{"lecture-1"}, "What are mitochondria in the cell?", 2)
+	if err != nil {
+		t.Fatalf("Search failed: %v", err)
+	}
+	if len(results) != 2 {
+		t.Fatalf("Expected 2 results, got %d", len(results))
+	}
+	if results[0].SourceType != SourceSlide || results[0].SourceID != "document-1" || results[0].PageNumber != 1 {
+		t.Errorf("Expected the slide to rank first, got %+v", results[0])
+	}
+	if results[0].Score < results[1].Score {
+		t.Error("Expected results ordered by score")
+	}
+
+	if err := DeleteLecture(db, "lecture-1"); err != nil {
+		t.Fatalf("DeleteLecture failed: %v", err)
+	}
+	db.QueryRow("SELECT COUNT(*) FROM embedding_chunks WHERE lecture_id = 'lecture-1'").Scan(&chunkCount)
+	if chunkCount != 0 {
+		t.Errorf("Expected chunks to be deleted, got %d", chunkCount)
+	}
+}
+
+func TestIndex_EnsureLectureSkipsUnreadyLectures(t *testing.T) {
+	db := setupIndexTestDatabase(t)
+	_, _ = db.Exec("INSERT INTO lectures (id, exam_id, title, status) VALUES ('lecture-1', 'exam-1', 'Cells', 'processing')")
+	_, _ = db.Exec("INSERT INTO transcripts (id, lecture_id, status) VALUES ('transcript-1', 'lecture-1', 'processing')")
+	_, _ = db.Exec("INSERT INTO transcript_segments (transcript_id, start_millisecond, end_millisecond, text) VALUES ('transcript-1', 0, 5000, 'A partial transcript.')")
+
+	embedder := &bagOfWordsEmbedder{}
+	if err := NewIndex(db, embedder, 0).EnsureLecture(context.Background(), "lecture-1"); err != nil {
+		t.Fatalf("EnsureLecture failed: %v", err)
+	}
+	if embedder.calls != 0 {
+		t.Errorf("Expected a processing lecture not to be indexed, got %d embed calls", embedder.calls)
+	}
+}
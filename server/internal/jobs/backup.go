@@ -0,0 +1,119 @@
+package jobs
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"time"
+
+	"lectures/internal/configuration"
+	"lectures/internal/database"
+	"lectures/internal/models"
+	"lectures/internal/storage"
+)
+
+// defaultBackupKeepCount is how many backup archives are kept unless configured otherwise
+const defaultBackupKeepCount = 7
+
+// backupHandler takes a consistent snapshot of the database with VACUUM INTO and packs it with the lecture
+// files and tool assets into an archive of the backups directory, then removes the archives the retention
+// policy no longer keeps
+func backupHandler(db *sql.DB, config *configuration.Configuration) JobHandler {
+	return func(jobContext context.Context, job *models.Job, updateProgress func(int, string, any, models.JobMetrics)) error {
+		layout := storage.NewLayout(config.Storage)
+		if err := os.MkdirAll(layout.BackupsDirectory(), 0755); err != nil {
+			return fmt.Errorf("failed to create backups directory: %w", err)
+		}
+
+		snapshotDirectory, err := os.MkdirTemp(layout.BackupsDirectory(), ".snapshot-")
+		if err != nil {
+			return err
+		}
+		defer os.RemoveAll(snapshotDirectory)
+
+		updateProgress(5, "Taking a snapshot of the database...", nil, models.JobMetrics{})
+		createdAt := time.Now()
+		snapshotPath := filepath.Join(snapshotDirectory, "database.db")
+		if err := database.Snapshot(db, snapshotPath); err != nil {
+			return fmt.Errorf("failed to snapshot database: %w", err)
+		}
+		schemaVersion, err := database.SchemaVersion(db)
+		if err != nil {
+			return err
+		}
+		if jobContext.Err() != nil {
+			return jobContext.Err()
+		}
+
+		updateProgress(20, "Archiving files...", nil, models.JobMetrics{})
+		archiveName := storage.BackupArchiveName(createdAt)
+		lastReportedAt := time.Now()
+		manifest, err := storage.CreateBackupArchive(filepath.Join(layout.BackupsDirectory(), archiveName), layout, snapshotPath,
+			storage.BackupManifest{CreatedAt: createdAt, SchemaVersion: schemaVersion}, func(archivedFiles int, totalFiles int) {
+				if time.Since(lastReportedAt) > time.Second {
+					lastReportedAt = time.Now()
+					updateProgress(20+75*archivedFiles/max(totalFiles, 1), fmt.Sprintf("Archived %d/%d files...", archivedFiles, totalFiles), nil, models.JobMetrics{})
+				}
+			})
+		if err != nil {
+			return fmt.Errorf("failed to write backup archive: %w", err)
+		}
+
+		keepCount := config.Backup.KeepCount
+		if keepCount == 0 {
+			keepCount = defaultBackupKeepCount
+		}
+		removed, err := storage.PruneBackups(layout, max(keepCount, 0), time.Duration(config.Backup.KeepDays)*24*time.Hour)
+		if err != nil {
+			slog.WarnContext(jobContext, "Failed to remove old backups", "error", err)
+		}
+
+		archiveInfo, _ := os.Stat(filepath.Join(layout.BackupsDirectory(), archiveName))
+		var archiveBytes int64
+		if archiveInfo != nil {
+			archiveBytes = archiveInfo.Size()
+		}
+		removedNames := []string{}
+		for _, archive := range removed {
+			removedNames = append(removedNames, archive.Name)
+		}
+		result, _ := json.Marshal(map[string]any{
+			"name":           archiveName,
+			"bytes":          archiveBytes,
+			"files":          manifest.Files,
+			"schema_version": manifest.SchemaVersion,
+			"removed":        removedNames,
+		})
+		job.Result = string(result)
+		slog.InfoContext(jobContext, "Backup completed", "archive", archiveName, "files", manifest.Files, "bytes", archiveBytes, "removed", len(removed))
+		updateProgress(100, "Backup completed", nil, models.JobMetrics{})
+		return nil
+	}
+}
+
+// IsBackupDue reports whether a scheduled backup should be queued: backups are scheduled, none is queued or
+// running, and the latest archive is older than the interval
+func IsBackupDue(db *sql.DB, config *configuration.Configuration) (bool, error) {
+	if config.Backup.IntervalHours <= 0 {
+		return false, nil
+	}
+
+	var activeBackups int
+	if err := db.QueryRow("SELECT COUNT(*) FROM jobs WHERE type = ? AND status IN (?, ?)",
+		models.JobTypeBackup, models.JobStatusPending, models.JobStatusRunning).Scan(&activeBackups); err != nil {
+		return false, err
+	}
+	if activeBackups > 0 {
+		return false, nil
+	}
+
+	archives, err := storage.ListBackups(storage.NewLayout(config.Storage))
+	if err != nil {
+		return false, err
+	}
+	return len(archives) == 0 || time.Since(archives[0].CreatedAt) >= time.Duration(config.Backup.IntervalHours)*time.Hour, nil
+}
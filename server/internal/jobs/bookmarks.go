@@ -0,0 +1,121 @@
+package jobs
+
+import (
+	"database/sql"
+	"fmt"
+	"html"
+	"log/slog"
+	"math"
+	"strings"
+
+	"lectures/internal/database"
+	"lectures/internal/models"
+)
+
+// bookmarkContextMilliseconds is how much of the transcript around a bookmark stands for it when generating
+// from bookmarks, on each side
+const bookmarkContextMilliseconds = 60_000
+
+// bookmarkedTranscript returns the parts of a lecture transcript around the bookmarks a user placed on it, each
+// under the name of its bookmark, to generate from those moments only. Overlapping windows repeat no segment
+func bookmarkedTranscript(db *sql.DB, lectureID string, userID string) (string, error) {
+	bookmarks, err := database.ListLectureBookmarks(db, lectureID, userID)
+	if err != nil {
+		return "", fmt.Errorf("failed to list bookmarks: %w", err)
+	}
+	if len(bookmarks) == 0 {
+		return "", fmt.Errorf("no bookmarks on lecture %s", lectureID)
+	}
+
+	rows, err := db.Query(`
+		SELECT id, COALESCE(polished_text, text), start_millisecond, end_millisecond FROM transcript_segments
+		WHERE transcript_id = (SELECT id FROM transcripts WHERE lecture_id = ?)
+		ORDER BY start_millisecond ASC
+	`, lectureID)
+	if err != nil {
+		return "", fmt.Errorf("failed to query transcript: %w", err)
+	}
+	type transcriptSegment struct {
+		id         int
+		text       string
+		start, end int64
+	}
+	var segments []transcriptSegment
+	for rows.Next() {
+		var segment transcriptSegment
+		if rows.Scan(&segment.id, &segment.text, &segment.start, &segment.end) == nil {
+			segments = append(segments, segment)
+		}
+	}
+	rows.Close()
+
+	var transcriptBuilder strings.Builder
+	included := make(map[int]bool)
+	for _, bookmark := range bookmarks {
+		var excerpt []string
+		for _, segment := range segments {
+			if segment.end < bookmark.Millisecond-bookmarkContextMilliseconds || segment.start > bookmark.Millisecond+bookmarkContextMilliseconds || included[segment.id] {
+				continue
+			}
+			included[segment.id] = true
+			excerpt = append(excerpt, segment.text)
+		}
+		if len(excerpt) == 0 {
+			continue
+		}
+		transcriptBuilder.WriteString(fmt.Sprintf("Bookmark \"%s\" at %s:\n%s\n\n", bookmark.Name, formatTimestamp(bookmark.Millisecond), strings.Join(excerpt, " ")))
+	}
+	if transcriptBuilder.Len() == 0 {
+		return "", fmt.Errorf("no transcript around the bookmarks of lecture %s", lectureID)
+	}
+	return transcriptBuilder.String(), nil
+}
+
+// transcriptBookmarks places bookmarks in a transcript export: an index linking to each one, and the anchor of
+// each before the first segment ending after it
+type transcriptBookmarks struct {
+	bookmarks []models.LectureBookmark
+	written   int
+}
+
+// loadTranscriptBookmarks reads the bookmarks a user placed on a lecture for its transcript export, which goes on
+// without them when they cannot be read
+func loadTranscriptBookmarks(db *sql.DB, lectureID string, userID string) transcriptBookmarks {
+	bookmarks, err := database.ListLectureBookmarks(db, lectureID, userID)
+	if err != nil {
+		slog.Warn("Failed to read bookmarks for the transcript export", "lectureID", lectureID, "error", err)
+	}
+	return transcriptBookmarks{bookmarks: bookmarks}
+}
+
+// writeIndex writes the list of bookmarks linking to their anchors, nothing when there are none
+func (placement *transcriptBookmarks) writeIndex(builder *strings.Builder) {
+	if len(placement.bookmarks) == 0 {
+		return
+	}
+	builder.WriteString("## Bookmarks\n\n")
+	for index, bookmark := range placement.bookmarks {
+		builder.WriteString(fmt.Sprintf("- [%s – %s](#bookmark-%d)\n", formatTimestamp(bookmark.Millisecond), escapeMarkdownLinkText(bookmark.Name), index+1))
+	}
+	builder.WriteString("\n")
+}
+
+// writeAnchorsBefore writes the anchors of the bookmarks placed before the given millisecond that are not written
+// yet. Bookmarks must be in the order of the lecture
+func (placement *transcriptBookmarks) writeAnchorsBefore(builder *strings.Builder, millisecond int64) {
+	for placement.written < len(placement.bookmarks) && placement.bookmarks[placement.written].Millisecond < millisecond {
+		bookmark := placement.bookmarks[placement.written]
+		placement.written++
+		builder.WriteString(fmt.Sprintf("<a id=\"bookmark-%d\"></a>\n\n> **Bookmark %s:** %s\n\n", placement.written, formatTimestamp(bookmark.Millisecond), html.EscapeString(bookmark.Name)))
+	}
+}
+
+// escapeMarkdownLinkText keeps brackets in a name from ending the text of a link
+func escapeMarkdownLinkText(text string) string {
+	return strings.NewReplacer(`\`, `\\`, "[", `\[`, "]", `\]`).Replace(text)
+}
+
+// writeRemainingAnchors writes the anchors of the bookmarks after the end of the transcript
+func (placement *transcriptBookmarks) writeRemainingAnchors(builder *strings.Builder) {
+	placement.writeAnchorsBefore(builder, math.MaxInt64)
+}
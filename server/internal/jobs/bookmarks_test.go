@@ -0,0 +1,91 @@
+package jobs
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestBookmarkedTranscript(t *testing.T) {
+	queue, cleanup := setupQueueTestDatabase(t)
+	defer cleanup()
+	db := queue.database
+
+	db.Exec("INSERT INTO exams (id, user_id, title) VALUES ('bookmark-exam', 'user-1', 'Optics')")
+	db.Exec("INSERT INTO lectures (id, exam_id, title, status) VALUES ('bookmark-lecture', 'bookmark-exam', 'Lenses', 'ready')")
+	db.Exec("INSERT INTO transcripts (id, lecture_id, status) VALUES ('bookmark-transcript', 'bookmark-lecture', 'completed')")
+	segments := []struct {
+		text       string
+		start, end int64
+	}{
+		{"Light bends at interfaces.", 0, 30_000},
+		{"Snell's law relates the angles.", 30_000, 60_000},
+		{"A break for questions.", 200_000, 230_000},
+		{"Focal length of a thin lens.", 400_000, 430_000},
+	}
+	for _, segment := range segments {
+		db.Exec("INSERT INTO transcript_segments (transcript_id, text, start_millisecond, end_millisecond) VALUES ('bookmark-transcript', ?, ?, ?)", segment.text, segment.start, segment.end)
+	}
+	db.Exec("UPDATE transcript_segments SET polished_text = 'The focal length of a thin lens.' WHERE text = 'Focal length of a thin lens.'")
+
+	if _, err := bookmarkedTranscript(db, "bookmark-lecture", "user-1"); err == nil {
+		t.Error("Expected generating without bookmarks to fail")
+	}
+
+	db.Exec("INSERT INTO lecture_bookmarks (id, lecture_id, user_id, name, millisecond) VALUES ('lens', 'bookmark-lecture', 'user-1', 'Thin lens', 410000)")
+	db.Exec("INSERT INTO lecture_bookmarks (id, lecture_id, user_id, name, millisecond) VALUES ('snell', 'bookmark-lecture', 'user-1', 'Snell', 35000)")
+	db.Exec("INSERT INTO lecture_bookmarks (id, lecture_id, user_id, name, millisecond) VALUES ('again', 'bookmark-lecture', 'user-1', 'Snell again', 40000)")
+
+	transcript, err := bookmarkedTranscript(db, "bookmark-lecture", "user-1")
+	if err != nil {
+		t.Fatalf("bookmarkedTranscript failed: %v", err)
+	}
+	if strings.Contains(transcript, "A break for questions.") {
+		t.Errorf("Expected the transcript far from the bookmarks to be left out, got %q", transcript)
+	}
+	if !strings.Contains(transcript, "Bookmark \"Snell\" at 00:35:\nLight bends at interfaces. Snell's law relates the angles.") {
+		t.Errorf("Expected the transcript around the first bookmark under its name, got %q", transcript)
+	}
+	if strings.Contains(transcript, "Snell again") || strings.Count(transcript, "Snell's law") != 1 {
+		t.Errorf("Expected overlapping bookmarks not to repeat segments, got %q", transcript)
+	}
+	if !strings.HasSuffix(strings.TrimSpace(transcript), "The focal length of a thin lens.") {
+		t.Errorf("Expected the polished bookmarks in the order of the lecture, got %q", transcript)
+	}
+}
+
+func TestTranscriptBookmarks(t *testing.T) {
+	queue, cleanup := setupQueueTestDatabase(t)
+	defer cleanup()
+	db := queue.database
+
+	db.Exec("INSERT INTO exams (id, user_id, title) VALUES ('anchor-exam', 'user-1', 'Optics')")
+	db.Exec("INSERT INTO lectures (id, exam_id, title) VALUES ('anchor-lecture', 'anchor-exam', 'Lenses')")
+	db.Exec("INSERT INTO lecture_bookmarks (id, lecture_id, user_id, name, millisecond) VALUES ('first', 'anchor-lecture', 'user-1', 'Snell [law]', 45000)")
+	db.Exec("INSERT INTO lecture_bookmarks (id, lecture_id, user_id, name, millisecond) VALUES ('late', 'anchor-lecture', 'user-1', 'After the end', 900000)")
+
+	placement := loadTranscriptBookmarks(db, "anchor-lecture", "user-1")
+	var builder strings.Builder
+	placement.writeIndex(&builder)
+	if !strings.Contains(builder.String(), `- [00:45 – Snell \[law\]](#bookmark-1)`) || !strings.Contains(builder.String(), "(#bookmark-2)") {
+		t.Errorf("Expected an index linking to each bookmark, got %q", builder.String())
+	}
+
+	builder.Reset()
+	placement.writeAnchorsBefore(&builder, 30_000)
+	if builder.Len() != 0 {
+		t.Errorf("Expected no anchor before a segment ending ahead of the bookmarks, got %q", builder.String())
+	}
+	placement.writeAnchorsBefore(&builder, 60_000)
+	if !strings.HasPrefix(builder.String(), `<a id="bookmark-1"></a>`) || strings.Contains(builder.String(), "bookmark-2") {
+		t.Errorf("Expected only the anchor of the first bookmark, got %q", builder.String())
+	}
+	placement.writeRemainingAnchors(&builder)
+	if !strings.Contains(builder.String(), `<a id="bookmark-2"></a>`) {
+		t.Errorf("Expected bookmarks past the transcript anchored at its end, got %q", builder.String())
+	}
+
+	// Other users' bookmarks are not exported
+	if placement := loadTranscriptBookmarks(db, "anchor-lecture", "user-2"); len(placement.bookmarks) != 0 {
+		t.Errorf("Expected no bookmarks for another user, got %+v", placement.bookmarks)
+	}
+}
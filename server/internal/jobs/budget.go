@@ -0,0 +1,204 @@
+package jobs
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"lectures/internal/database"
+	"lectures/internal/models"
+)
+
+// ErrBudgetExceeded is wrapped by the errors of jobs refused or stopped because their user spent their budget
+var ErrBudgetExceeded = errors.New("cost budget exceeded")
+
+// CostBudget caps what a user may spend on the AI and transcription providers per calendar day and month, in
+// dollars. A zero limit is unlimited
+type CostBudget struct {
+	Daily   float64 `json:"daily"`
+	Monthly float64 `json:"monthly"`
+}
+
+// CostBudgetStatus is the budget of a user with what they spent of it so far
+type CostBudgetStatus struct {
+	Budget       CostBudget `json:"budget"`
+	DailySpent   float64    `json:"daily_spent"`
+	MonthlySpent float64    `json:"monthly_spent"`
+}
+
+// BudgetExceededError tells which budget of a user is spent, or would be by a job expected to cost Expected
+type BudgetExceededError struct {
+	Period   string // "daily" or "monthly"
+	Limit    float64
+	Spent    float64
+	Expected float64 // 0 when the budget is already spent
+}
+
+func (budgetError *BudgetExceededError) Error() string {
+	if budgetError.Expected > 0 {
+		return fmt.Sprintf("%s cost budget would be exceeded: spent $%.4f of $%.4f, and the job is expected to cost $%.4f", budgetError.Period, budgetError.Spent, budgetError.Limit, budgetError.Expected)
+	}
+	return fmt.Sprintf("%s cost budget exceeded: spent $%.4f of $%.4f", budgetError.Period, budgetError.Spent, budgetError.Limit)
+}
+
+func (budgetError *BudgetExceededError) Unwrap() error {
+	return ErrBudgetExceeded
+}
+
+// unbilledJobTypes never call a paid provider, so users can still download and export what they already
+// generated once their budget is spent
+var unbilledJobTypes = map[string]bool{
+	models.JobTypePublishMaterial:     true,
+	models.JobTypePublishBundle:       true,
+	models.JobTypeDownloadGoogleDrive: true,
+	models.JobTypeBackup:              true,
+	models.JobTypeRedactMedia:         true,
+}
+
+// expectedCostSampleSize is how many of the latest completed jobs of a type the expected cost of the next one is
+// averaged over
+const expectedCostSampleSize = 20
+
+// budgetFailure is recorded on the jobs stopped because their user spent their budget
+var budgetFailure = models.JobFailure{Code: models.JobFailureBudgetExceeded, Retryable: true, Action: models.JobActionRetryLater,
+	Message: "Your spending limit was reached. Try again once it resets, or ask an administrator to raise it."}
+
+// SetCostBudget sets the default budget of every user; users.daily_budget and users.monthly_budget override it
+func (queue *Queue) SetCostBudget(budget CostBudget) {
+	queue.costBudget = budget
+}
+
+// UserCostBudget returns the budget of a user and what they spent of it today and this month
+func (queue *Queue) UserCostBudget(userID string) (CostBudgetStatus, error) {
+	budget, err := userCostBudget(queue.database, userID, queue.costBudget)
+	if err != nil {
+		return CostBudgetStatus{}, err
+	}
+	status := CostBudgetStatus{Budget: budget}
+	now := time.Now()
+	if status.DailySpent, err = ledgerSpend(queue.database, userID, startOfDay(now)); err != nil {
+		return status, err
+	}
+	if status.MonthlySpent, err = ledgerSpend(queue.database, userID, startOfMonth(now)); err != nil {
+		return status, err
+	}
+	return status, nil
+}
+
+// CheckCostBudget returns a BudgetExceededError when the user spent their daily or monthly budget
+func (queue *Queue) CheckCostBudget(userID string) error {
+	return queue.checkCostBudget(userID, 0)
+}
+
+// checkCostBudget returns a BudgetExceededError when the user spent their daily or monthly budget, or would by
+// spending expectedCost more
+func (queue *Queue) checkCostBudget(userID string, expectedCost float64) error {
+	status, err := queue.UserCostBudget(userID)
+	if err != nil {
+		return fmt.Errorf("failed to check cost budget: %w", err)
+	}
+	periods := []struct {
+		name  string
+		limit float64
+		spent float64
+	}{
+		{"daily", status.Budget.Daily, status.DailySpent},
+		{"monthly", status.Budget.Monthly, status.MonthlySpent},
+	}
+	for _, period := range periods {
+		switch {
+		case period.limit <= 0:
+		case period.spent >= period.limit:
+			return &BudgetExceededError{Period: period.name, Limit: period.limit, Spent: period.spent}
+		case period.spent+expectedCost > period.limit:
+			return &BudgetExceededError{Period: period.name, Limit: period.limit, Spent: period.spent, Expected: expectedCost}
+		}
+	}
+	return nil
+}
+
+// expectedJobCost returns what a job of a type is expected to spend: the average of the latest completed jobs of
+// the type, and 0 until one completed
+func (queue *Queue) expectedJobCost(jobType string) (float64, error) {
+	var expectedCost float64
+	err := queue.database.QueryRow(`
+		SELECT COALESCE(AVG(estimated_cost), 0) FROM (
+			SELECT estimated_cost FROM jobs WHERE type = ? AND status = ? ORDER BY completed_at DESC LIMIT ?
+		)
+	`, jobType, models.JobStatusCompleted, expectedCostSampleSize).Scan(&expectedCost)
+	return expectedCost, err
+}
+
+// recordCost adds what a job spent since its last progress update to the cost ledger of the user it is billed to
+func (queue *Queue) recordCost(job *models.Job, billedUserID string, amount float64) error {
+	_, err := queue.database.Exec(
+		"INSERT INTO cost_ledger (user_id, job_id, job_type, amount, recorded_at) VALUES (?, ?, ?, ?, ?)",
+		billedUserID, job.ID, job.Type, amount, time.Now(),
+	)
+	return err
+}
+
+// Ledger types of the spend of requests served outside the queue
+const (
+	CostTypeQuizGrading = "GRADE_QUIZ_ATTEMPT" // Free responses graded when a quiz attempt is submitted
+	CostTypeTitlePolish = "POLISH_TITLE"       // Title and description of an exam or lecture cleaned when it is saved
+)
+
+// RecordRequestCost adds what a request served outside the queue spent to the cost ledger of a user, under the ID
+// of what it produced (such as a quiz attempt) and a costType in place of a job type, so it counts against the
+// budgets like the spend of jobs
+func (queue *Queue) RecordRequestCost(billedUserID string, referenceID string, costType string, amount float64) error {
+	if amount <= 0 {
+		return nil
+	}
+	return queue.recordCost(&models.Job{ID: referenceID, Type: costType}, billedUserID, amount)
+}
+
+// BilledUserID returns the user the jobs a user queues on an exam are charged to: the owner of the exam when
+// they pay for the jobs of its members, and the user themselves otherwise
+func (queue *Queue) BilledUserID(userID string, examID string) string {
+	if examID == "" {
+		return userID
+	}
+	var ownerID string
+	var rawCollaboration sql.NullString
+	err := queue.database.QueryRow("SELECT user_id, collaboration FROM exams WHERE id = ?", examID).Scan(&ownerID, &rawCollaboration)
+	if err != nil || !database.ParseExamCollaboration(rawCollaboration).OwnerPaysJobs {
+		return userID
+	}
+	return ownerID
+}
+
+// userCostBudget applies the overrides of a user to the default budget. A NULL override keeps the default
+func userCostBudget(database *sql.DB, userID string, defaultBudget CostBudget) (CostBudget, error) {
+	var dailyBudget, monthlyBudget sql.NullFloat64
+	err := database.QueryRow("SELECT daily_budget, monthly_budget FROM users WHERE id = ?", userID).Scan(&dailyBudget, &monthlyBudget)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return defaultBudget, err
+	}
+	budget := defaultBudget
+	if dailyBudget.Valid {
+		budget.Daily = dailyBudget.Float64
+	}
+	if monthlyBudget.Valid {
+		budget.Monthly = monthlyBudget.Float64
+	}
+	return budget, nil
+}
+
+func ledgerSpend(database *sql.DB, userID string, since time.Time) (float64, error) {
+	var spent float64
+	err := database.QueryRow(
+		"SELECT COALESCE(SUM(amount), 0) FROM cost_ledger WHERE user_id = ? AND recorded_at >= ?", userID, since,
+	).Scan(&spent)
+	return spent, err
+}
+
+func startOfDay(moment time.Time) time.Time {
+	return time.Date(moment.Year(), moment.Month(), moment.Day(), 0, 0, 0, 0, moment.Location())
+}
+
+func startOfMonth(moment time.Time) time.Time {
+	return time.Date(moment.Year(), moment.Month(), 1, 0, 0, 0, 0, moment.Location())
+}
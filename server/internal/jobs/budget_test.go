@@ -0,0 +1,114 @@
+package jobs
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"lectures/internal/models"
+)
+
+func TestQueue_CostBudget(t *testing.T) {
+	queue, cleanup := setupQueueTestDatabase(t)
+	defer cleanup()
+	pool := queue.pools[0]
+	queue.SetCostBudget(CostBudget{Daily: 1.0, Monthly: 10.0})
+
+	t.Run("Spend is recorded and stops the job over budget", func(t *testing.T) {
+		var stoppedAt int
+		queue.RegisterHandler("SPENDING", func(jobContext context.Context, job *models.Job, updateProgress func(int, string, any, models.JobMetrics)) error {
+			var metrics models.JobMetrics
+			for step := 1; step <= 5; step++ {
+				if err := jobContext.Err(); err != nil {
+					stoppedAt = step
+					return err
+				}
+				metrics.EstimatedCost += 0.4
+				updateProgress(step*20, "Spending", nil, metrics)
+			}
+			return nil
+		})
+
+		jobID, err := queue.Enqueue("user-1", "SPENDING", map[string]string{}, "", "")
+		if err != nil {
+			t.Fatalf("Enqueue failed: %v", err)
+		}
+		queue.executeJob(queue.claimNextJob(pool, 0, false))
+
+		if stoppedAt != 4 {
+			t.Errorf("Expected the job to stop once $1.20 was spent, stopped at step %d", stoppedAt)
+		}
+		job, _ := queue.GetJob(jobID)
+		if job.Status != models.JobStatusFailed || job.Failure == nil || job.Failure.Code != models.JobFailureBudgetExceeded {
+			t.Fatalf("Expected the job to fail over budget, got %s with %+v", job.Status, job.Failure)
+		}
+
+		status, err := queue.UserCostBudget("user-1")
+		if err != nil {
+			t.Fatalf("UserCostBudget failed: %v", err)
+		}
+		if status.DailySpent < 1.19 || status.DailySpent > 1.21 || status.MonthlySpent != status.DailySpent {
+			t.Errorf("Expected $1.20 recorded in the ledger, got %+v", status)
+		}
+	})
+
+	t.Run("Billed jobs are refused once the budget is spent", func(t *testing.T) {
+		_, err := queue.Enqueue("user-1", models.JobTypeBuildMaterial, map[string]string{}, "", "")
+		var budgetError *BudgetExceededError
+		if !errors.As(err, &budgetError) || budgetError.Period != "daily" {
+			t.Fatalf("Expected the daily budget to refuse the job, got %v", err)
+		}
+		if _, err := queue.Enqueue("user-1", models.JobTypePublishMaterial, map[string]string{}, "", ""); err != nil {
+			t.Errorf("Expected exports to be queued over budget, got %v", err)
+		}
+	})
+
+	t.Run("Spend outside jobs counts against the budget", func(t *testing.T) {
+		if err := queue.RecordRequestCost("user-2", "attempt-1", CostTypeQuizGrading, 0.6); err != nil {
+			t.Fatalf("RecordRequestCost failed: %v", err)
+		}
+		queue.RecordRequestCost("user-2", "attempt-2", CostTypeQuizGrading, 0)
+		if err := queue.CheckCostBudget("user-2"); err != nil {
+			t.Errorf("Expected $0.60 to stay within the budget, got %v", err)
+		}
+		queue.RecordRequestCost("user-2", "attempt-3", CostTypeQuizGrading, 0.6)
+		if err := queue.CheckCostBudget("user-2"); !errors.Is(err, ErrBudgetExceeded) {
+			t.Errorf("Expected $1.20 to spend the daily budget, got %v", err)
+		}
+		var entries int
+		queue.database.QueryRow("SELECT COUNT(*) FROM cost_ledger WHERE user_id = 'user-2' AND job_type = ?", CostTypeQuizGrading).Scan(&entries)
+		if entries != 2 {
+			t.Errorf("Expected two ledger entries, free requests being left out, got %d", entries)
+		}
+	})
+
+	t.Run("Jobs that would exceed the budget are refused", func(t *testing.T) {
+		queue.database.Exec("INSERT INTO users (id, username, password_hash) VALUES ('user-3', 'student', 'hash')")
+		queue.database.Exec(`INSERT INTO jobs (id, user_id, type, status, payload, estimated_cost, completed_at)
+			VALUES ('past-import', 'user-1', 'IMPORT_SLIDES', ?, '{}', 0.7, CURRENT_TIMESTAMP)`, models.JobStatusCompleted)
+		queue.RecordRequestCost("user-3", "attempt-4", CostTypeQuizGrading, 0.5)
+
+		_, err := queue.Enqueue("user-3", "IMPORT_SLIDES", map[string]string{}, "", "")
+		var budgetError *BudgetExceededError
+		if !errors.As(err, &budgetError) || budgetError.Period != "daily" || budgetError.Expected < 0.69 || budgetError.Expected > 0.71 {
+			t.Fatalf("Expected $0.50 spent and $0.70 expected to exceed the daily budget, got %v", err)
+		}
+		if _, err := queue.Enqueue("user-3", "UNPRICED", map[string]string{}, "", ""); err != nil {
+			t.Errorf("Expected a job of a type without completed jobs to be queued within the budget, got %v", err)
+		}
+	})
+
+	t.Run("User overrides replace the default", func(t *testing.T) {
+		queue.database.Exec("UPDATE users SET daily_budget = 0, monthly_budget = 1.1 WHERE id = ?", "user-1")
+		_, err := queue.Enqueue("user-1", models.JobTypeBuildMaterial, map[string]string{}, "", "")
+		var budgetError *BudgetExceededError
+		if !errors.As(err, &budgetError) || budgetError.Period != "monthly" {
+			t.Fatalf("Expected the monthly override to refuse the job, got %v", err)
+		}
+
+		queue.database.Exec("UPDATE users SET monthly_budget = NULL WHERE id = ?", "user-1")
+		if _, err := queue.Enqueue("user-1", models.JobTypeBuildMaterial, map[string]string{}, "", ""); err != nil {
+			t.Errorf("Expected an unlimited daily budget to accept the job, got %v", err)
+		}
+	})
+}
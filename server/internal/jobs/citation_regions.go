@@ -0,0 +1,140 @@
+package jobs
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"lectures/internal/markdown"
+	"lectures/internal/models"
+	"lectures/internal/storage"
+	"lectures/internal/tools"
+)
+
+// citationRegionMaximumCitations bounds the vision calls made to crop the citations of a single guide
+const citationRegionMaximumCitations = 60
+
+// locateCitationRegions finds, for each citation of a guide, the region of its first cited page that supports
+// the claim. Citations without a page image, or whose claim covers most of the page, are left out and keep the
+// whole page. Failures are logged and never fail the build
+func locateCitationRegions(jobContext context.Context, database *sql.DB, layout storage.Layout, objectStore storage.ObjectStore, toolGenerator *tools.ToolGenerator, lectureID string, citations []markdown.ParsedCitation, jobID string) (map[int]markdown.PageRegion, models.JobMetrics) {
+	var totalMetrics models.JobMetrics
+	regions := make(map[int]markdown.PageRegion)
+
+	imageDirectory := filepath.Join(os.TempDir(), "lectures-regions", jobID)
+	if err := os.MkdirAll(imageDirectory, 0755); err != nil {
+		slog.WarnContext(jobContext, "Failed to create citation region directory", "error", err)
+		return regions, totalMetrics
+	}
+	defer os.RemoveAll(imageDirectory)
+
+	var waitGroup sync.WaitGroup
+	var resultMutex sync.Mutex
+	located := 0
+	for _, citation := range citations {
+		if len(citation.Pages) == 0 || citation.File == "" || located == citationRegionMaximumCitations {
+			continue
+		}
+		pageNumber := citation.Pages[0]
+		imagePath := lecturePageImage(jobContext, database, layout, objectStore, lectureID, citation.File, pageNumber, imageDirectory)
+		if imagePath == "" {
+			continue
+		}
+		located++
+
+		waitGroup.Add(1)
+		go func(citation markdown.ParsedCitation) {
+			defer waitGroup.Done()
+			region, metrics, err := toolGenerator.LocateCitationRegion(jobContext, imagePath, pageNumber, citation.Description)
+			resultMutex.Lock()
+			defer resultMutex.Unlock()
+			totalMetrics.InputTokens += metrics.InputTokens
+			totalMetrics.OutputTokens += metrics.OutputTokens
+			totalMetrics.EstimatedCost += metrics.EstimatedCost
+			totalMetrics.EstimatedInputTokens += metrics.EstimatedInputTokens
+			if err != nil {
+				slog.WarnContext(jobContext, "Failed to locate citation region, keeping the whole page", "footnote", citation.Number, "error", err)
+				return
+			}
+			if region != nil {
+				regions[citation.Number] = *region
+			}
+		}(citation)
+	}
+	waitGroup.Wait()
+
+	return regions, totalMetrics
+}
+
+// lecturePageImage returns a readable image of a page of one of the lecture's documents, named by title or
+// original filename, restoring it from its BLOB or the object store into directory when the stored file is gone
+func lecturePageImage(jobContext context.Context, database *sql.DB, layout storage.Layout, objectStore storage.ObjectStore, lectureID string, documentName string, pageNumber int, directory string) string {
+	var imagePath, objectKey string
+	var imageData []byte
+	err := database.QueryRow(`
+		SELECT reference_pages.image_path, reference_pages.image_data, COALESCE(reference_pages.object_key, '')
+		FROM reference_pages
+		JOIN reference_documents ON reference_pages.document_id = reference_documents.id
+		WHERE reference_documents.lecture_id = ? AND (reference_documents.original_filename = ? OR reference_documents.title = ?)
+			AND reference_pages.page_number = ?
+		LIMIT 1
+	`, lectureID, documentName, documentName, pageNumber).Scan(&imagePath, &imageData, &objectKey)
+	if err != nil {
+		return ""
+	}
+	if _, statError := os.Stat(layout.ResolvePath(imagePath)); statError == nil {
+		return layout.ResolvePath(imagePath)
+	}
+	restoredPath := filepath.Join(directory, fmt.Sprintf("%s_page_%d.png", filepath.Base(imagePath), pageNumber))
+	if !restorePageImage(jobContext, objectStore, imageData, objectKey, restoredPath) {
+		return ""
+	}
+	return restoredPath
+}
+
+// citedPageRegions gathers the regions cited on each page of a guide, keyed by "file:page". A page is cropped to
+// the union of its regions, unless some citation of it has no region and needs the whole page
+type citedPageRegions struct {
+	regions    map[string]markdown.PageRegion
+	wholePages map[string]bool
+}
+
+func newCitedPageRegions() *citedPageRegions {
+	return &citedPageRegions{regions: make(map[string]markdown.PageRegion), wholePages: make(map[string]bool)}
+}
+
+// add records the pages a citation cites, with the region it was located in if any
+func (pageRegions *citedPageRegions) add(file string, pages []int, region *markdown.PageRegion) {
+	for _, pageNumber := range pages {
+		key := fmt.Sprintf("%s:%d", file, pageNumber)
+		if region == nil || region.Page != pageNumber || !region.Valid() {
+			pageRegions.wholePages[key] = true
+			continue
+		}
+		if existing, found := pageRegions.regions[key]; found {
+			pageRegions.regions[key] = existing.Union(*region)
+		} else {
+			pageRegions.regions[key] = *region
+		}
+	}
+}
+
+// crop returns the image to embed for a cited page: a crop of imagePath written into directory when the page
+// has a region, and imagePath itself otherwise or when cropping fails
+func (pageRegions *citedPageRegions) crop(imagePath string, file string, pageNumber int, directory string) string {
+	key := fmt.Sprintf("%s:%d", file, pageNumber)
+	region, found := pageRegions.regions[key]
+	if imagePath == "" || !found || pageRegions.wholePages[key] {
+		return imagePath
+	}
+	croppedPath := filepath.Join(directory, fmt.Sprintf("%s_page_%d_cropped.png", filepath.Base(imagePath), pageNumber))
+	if err := markdown.CropPageImage(imagePath, region, croppedPath); err != nil {
+		slog.Warn("Failed to crop cited page, embedding the whole page", "file", file, "page", pageNumber, "error", err)
+		return imagePath
+	}
+	return croppedPath
+}
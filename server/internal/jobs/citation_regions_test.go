@@ -0,0 +1,69 @@
+package jobs
+
+import (
+	"bytes"
+	"context"
+	"image"
+	"image/png"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"lectures/internal/configuration"
+	"lectures/internal/database"
+	"lectures/internal/markdown"
+	"lectures/internal/prompts"
+	"lectures/internal/storage"
+	"lectures/internal/tools"
+)
+
+func TestJob_CitationRegions(t *testing.T) {
+	tempDir := t.TempDir()
+	db, err := database.Initialize(filepath.Join(tempDir, "test.db"))
+	if err != nil {
+		t.Fatalf("Failed to init DB: %v", err)
+	}
+	defer db.Close()
+
+	var pageImage bytes.Buffer
+	png.Encode(&pageImage, image.NewRGBA(image.Rect(0, 0, 100, 100)))
+
+	_, _ = db.Exec("INSERT INTO users (id, username, password_hash) VALUES ('region-user', 'tester', 'hash')")
+	_, _ = db.Exec("INSERT INTO exams (id, user_id, title) VALUES ('region-exam', 'region-user', 'Biology')")
+	_, _ = db.Exec("INSERT INTO lectures (id, exam_id, title) VALUES ('region-lecture', 'region-exam', 'Cells')")
+	_, _ = db.Exec("INSERT INTO reference_documents (id, lecture_id, document_type, title, file_path, original_filename, page_count, extraction_status) VALUES ('region-doc', 'region-lecture', 'pdf', 'Slides', '/tmp/slides.pdf', 'slides.pdf', 9, 'completed')")
+	// The stored file is gone, so the page is restored from its BLOB
+	_, _ = db.Exec("INSERT INTO reference_pages (document_id, page_number, image_path, image_data) VALUES ('region-doc', 2, '/missing/page_2.png', ?)", pageImage.Bytes())
+
+	config := &configuration.Configuration{LLM: configuration.LLMConfiguration{Model: "test-model"}}
+	toolGenerator := tools.NewToolGenerator(config, &mockLLMProvider{ResponseText: `{"found": true, "left": 0.2, "top": 0.2, "width": 0.3, "height": 0.3}`}, prompts.NewManager("../../prompts"))
+
+	citations := []markdown.ParsedCitation{
+		{Number: 1, Description: "Cells divide", File: "slides.pdf", Pages: []int{2}},
+		{Number: 2, Description: "Not rendered", File: "slides.pdf", Pages: []int{9}},
+	}
+	regions, _ := locateCitationRegions(context.Background(), db, storage.NewLayout(configuration.StorageConfiguration{DataDirectory: tempDir}), nil, toolGenerator, "region-lecture", citations, "region-job")
+	if len(regions) != 1 || regions[1].Page != 2 || regions[1].Width != 0.3 {
+		t.Fatalf("Expected a region for the rendered page only, got %+v", regions)
+	}
+
+	pagePath := filepath.Join(tempDir, "page.png")
+	os.WriteFile(pagePath, pageImage.Bytes(), 0644)
+
+	pageRegions := newCitedPageRegions()
+	region := regions[1]
+	pageRegions.add("slides.pdf", []int{2}, &region)
+	pageRegions.add("slides.pdf", []int{2, 3}, nil)
+	pageRegions.add("slides.pdf", []int{4}, &markdown.PageRegion{Page: 4, Left: 0.5, Top: 0.5, Width: 0.25, Height: 0.25})
+
+	if croppedPath := pageRegions.crop(pagePath, "slides.pdf", 4, tempDir); croppedPath == pagePath {
+		t.Errorf("Expected page 4 to be cropped")
+	}
+	// Page 2 is also cited by a claim that needs the whole page
+	if croppedPath := pageRegions.crop(pagePath, "slides.pdf", 2, tempDir); croppedPath != pagePath {
+		t.Errorf("Expected page 2 to be kept whole, got %s", croppedPath)
+	}
+	if croppedPath := pageRegions.crop(pagePath, "slides.pdf", 3, tempDir); croppedPath != pagePath {
+		t.Errorf("Expected page 3 to be kept whole, got %s", croppedPath)
+	}
+}
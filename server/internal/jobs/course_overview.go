@@ -0,0 +1,343 @@
+package jobs
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"slices"
+	"time"
+
+	"lectures/internal/documents"
+	"lectures/internal/markdown"
+	"lectures/internal/models"
+	"lectures/internal/tools"
+
+	gonanoid "github.com/matoous/go-nanoid/v2"
+)
+
+// courseOverviewChunksPerLecture bounds the reference chunks each lecture contributes to a course overview
+const courseOverviewChunksPerLecture = 20
+
+// courseLecture is a lecture a course overview was built from, recorded with the citations of its documents
+type courseLecture struct {
+	id    string
+	title string
+}
+
+// courseDocument is a reference file of a course overview, with the lecture it belongs to and its own name
+type courseDocument struct {
+	lecture courseLecture
+	name    string
+}
+
+// courseDocumentName qualifies the name of a reference file with the number of its lecture in the overview, so
+// files of the same name in different lectures are cited apart. The separator is not a dash, which would split
+// the citation marker
+func courseDocumentName(lectureNumber int, documentName string) string {
+	return fmt.Sprintf("L%d_%s", lectureNumber, documentName)
+}
+
+// buildCourseOverview generates the course overview of an exam from its ready lectures and stores it as a tool
+// of no lecture. Each stored citation records the lecture of the cited document, so the overview can point
+// back to where a topic was taught, and the sections recorded by jobID are attached to it. It returns the ID and
+// title of the tool and the metrics of the build
+func buildCourseOverview(
+	jobContext context.Context,
+	db *sql.DB,
+	toolGenerator *tools.ToolGenerator,
+	jobID string,
+	examID string,
+	length string,
+	languageCode string,
+	options models.GenerationOptions,
+	reportBuildProgress func(int, string, any, models.JobMetrics),
+) (string, string, models.JobMetrics, error) {
+	var totalMetrics models.JobMetrics
+	reportBuildProgress(5, "Gathering the lectures of the course...", models.BuildProgress{Phase: models.BuildPhaseGatheringLectures}, totalMetrics)
+
+	var courseTitle string
+	if err := db.QueryRow("SELECT title FROM exams WHERE id = ?", examID).Scan(&courseTitle); err != nil {
+		return "", "", totalMetrics, fmt.Errorf("failed to get exam: %w", err)
+	}
+	lectures, lectureOfDocument, err := gatherCourseLectures(db, examID, nil, languageCode, &options)
+	if err != nil {
+		return "", "", totalMetrics, err
+	}
+	overlaps, err := courseOverlaps(db, examID, lectures)
+	if err != nil {
+		return "", "", totalMetrics, err
+	}
+
+	toolContent, toolTitle, totalMetrics, err := toolGenerator.GenerateCourseOverview(jobContext, courseTitle, lectures, overlaps, length, languageCode, options, reportBuildProgress)
+	if err != nil {
+		return "", "", totalMetrics, fmt.Errorf("tool generation failed: %w", err)
+	}
+
+	markdownReconstructor := markdown.NewReconstructor()
+	markdownReconstructor.Language = languageCode
+	_, citations := markdownReconstructor.ParseCitations(toolContent)
+	if len(citations) > 0 {
+		reportBuildProgress(90, "Processing footnotes...", models.BuildProgress{Phase: models.BuildPhaseProcessingFootnotes}, totalMetrics)
+		updatedCitations, footnoteMetrics, footnoteError := toolGenerator.ProcessFootnotesAI(jobContext, citations, languageCode, options)
+		totalMetrics.InputTokens += footnoteMetrics.InputTokens
+		totalMetrics.OutputTokens += footnoteMetrics.OutputTokens
+		totalMetrics.EstimatedCost += footnoteMetrics.EstimatedCost
+		totalMetrics.EstimatedInputTokens += footnoteMetrics.EstimatedInputTokens
+		if footnoteError == nil {
+			citations = updatedCitations
+		}
+
+		documentNames := make([]string, 0, len(lectureOfDocument))
+		for documentName := range lectureOfDocument {
+			documentNames = append(documentNames, documentName)
+		}
+		markdown.CorrectCitationFilenames(citations, documentNames)
+	}
+
+	reportBuildProgress(95, "Finalizing tool...", models.BuildProgress{Phase: models.BuildPhaseFinalizing}, totalMetrics)
+
+	toolID, _ := gonanoid.New()
+	transaction, err := db.Begin()
+	if err != nil {
+		return "", "", totalMetrics, fmt.Errorf("failed to begin transaction for tool storage: %w", err)
+	}
+	defer transaction.Rollback()
+
+	_, err = transaction.Exec(`
+		INSERT INTO tools (id, exam_id, lecture_id, type, title, language_code, content, estimated_cost, partial_sources, created_at, updated_at)
+		VALUES (?, ?, NULL, ?, ?, ?, ?, ?, 0, ?, ?)
+	`, toolID, examID, models.ToolTypeCourseOverview, toolTitle, languageCode, toolContent, totalMetrics.EstimatedCost, time.Now(), time.Now())
+	if err != nil {
+		return "", "", totalMetrics, fmt.Errorf("failed to store tool: %w", err)
+	}
+
+	if _, err := transaction.Exec("UPDATE exams SET estimated_cost = estimated_cost + ?, updated_at = ? WHERE id = ?", totalMetrics.EstimatedCost, time.Now(), examID); err != nil {
+		return "", "", totalMetrics, fmt.Errorf("failed to update exam estimated cost: %w", err)
+	}
+	if err := attachToolSections(transaction, jobID, toolID, examID, ""); err != nil {
+		slog.WarnContext(jobContext, "Failed to attach generated sections to the course overview", "toolID", toolID, "error", err)
+	}
+
+	for _, citation := range citations {
+		metadata := map[string]any{
+			"footnote_number": citation.Number,
+			"description":     citation.Description,
+			"pages":           citation.Pages,
+		}
+		sourceID := citation.File
+		if document, found := lectureOfDocument[citation.File]; found {
+			sourceID = document.name
+			metadata["lecture_id"] = document.lecture.id
+			metadata["lecture_title"] = document.lecture.title
+		}
+		metadataJSON, _ := json.Marshal(metadata)
+		if _, err := transaction.Exec(`
+			INSERT INTO tool_source_references (tool_id, source_type, source_id, metadata)
+			VALUES (?, 'document', ?, ?)
+		`, toolID, sourceID, string(metadataJSON)); err != nil {
+			return "", "", totalMetrics, fmt.Errorf("failed to store tool source reference: %w", err)
+		}
+	}
+
+	if err := transaction.Commit(); err != nil {
+		return "", "", totalMetrics, fmt.Errorf("failed to commit tool storage: %w", err)
+	}
+	return toolID, toolTitle, totalMetrics, nil
+}
+
+// gatherCourseLectures reads the ready lectures of an exam in the order they were taught, each with its
+// transcript and the key pages of its reference files, only those of lectureIDs when it is not empty. The
+// reference files are named after the number of their lecture, and each qualified name is mapped onto its
+// lecture and its own name, so the citations of the overview can be traced back to their lecture
+func gatherCourseLectures(db *sql.DB, examID string, lectureIDs []string, languageCode string, options *models.GenerationOptions) ([]tools.CourseLecture, map[string]courseDocument, error) {
+	rows, err := db.Query(`
+		SELECT id, title FROM lectures WHERE exam_id = ? AND status = 'ready'
+		ORDER BY COALESCE(specified_date, created_at), created_at, id
+	`, examID)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to list lectures: %w", err)
+	}
+	var readyLectures []courseLecture
+	for rows.Next() {
+		var lecture courseLecture
+		if err := rows.Scan(&lecture.id, &lecture.title); err != nil {
+			rows.Close()
+			return nil, nil, fmt.Errorf("failed to list lectures: %w", err)
+		}
+		if len(lectureIDs) > 0 && !slices.Contains(lectureIDs, lecture.id) {
+			continue
+		}
+		readyLectures = append(readyLectures, lecture)
+	}
+	rows.Close()
+	if len(readyLectures) == 0 {
+		return nil, nil, fmt.Errorf("the exam has no ready lecture")
+	}
+
+	var courseLectures []tools.CourseLecture
+	lectureOfDocument := make(map[string]courseDocument)
+	for index, lecture := range readyLectures {
+		transcript, err := lectureTranscript(db, lecture.id)
+		if err != nil {
+			return nil, nil, err
+		}
+		keyChunks, err := courseKeyChunks(db, lecture.id)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to get reference chunks: %w", err)
+		}
+
+		documentNames := lectureDocumentNames(db, lecture.id)
+		for nameIndex, documentName := range documentNames {
+			documentNames[nameIndex] = courseDocumentName(index+1, documentName)
+			lectureOfDocument[documentNames[nameIndex]] = courseDocument{lecture: lecture, name: documentName}
+		}
+		for chunkIndex := range keyChunks {
+			keyChunks[chunkIndex].DocumentTitle = courseDocumentName(index+1, keyChunks[chunkIndex].DocumentTitle)
+		}
+		courseLectures = append(courseLectures, tools.CourseLecture{
+			ID:                 lecture.id,
+			Title:              lecture.title,
+			Transcript:         transcript,
+			DocumentNames:      documentNames,
+			ReferenceMaterials: referenceMaterials(keyChunks, languageCode, options),
+		})
+	}
+	return courseLectures, lectureOfDocument, nil
+}
+
+// courseOverlaps reads the material the last duplicate analysis of an exam found repeated across the lectures of
+// a course overview, numbering the lectures as the overview does. Occurrences in lectures left out of the
+// overview are dropped, and the clearest remaining one is cited when the analysis chose one of them
+func courseOverlaps(db *sql.DB, examID string, lectures []tools.CourseLecture) ([]tools.CourseOverlap, error) {
+	lectureNumbers := make(map[string]int, len(lectures))
+	for index, lecture := range lectures {
+		lectureNumbers[lecture.ID] = index + 1
+	}
+
+	rows, err := db.Query("SELECT topic, occurrences, canonical_index FROM content_overlaps WHERE exam_id = ? ORDER BY id ASC", examID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get content overlaps: %w", err)
+	}
+	defer rows.Close()
+
+	var overlaps []tools.CourseOverlap
+	for rows.Next() {
+		var topic, occurrencesJSON string
+		var canonicalIndex int
+		if rows.Scan(&topic, &occurrencesJSON, &canonicalIndex) != nil {
+			continue
+		}
+		var occurrences []models.ContentOccurrence
+		if json.Unmarshal([]byte(occurrencesJSON), &occurrences) != nil {
+			continue
+		}
+		if canonicalIndex >= 0 && canonicalIndex < len(occurrences) {
+			// The clearest occurrence is tried first, so it is cited whenever its lecture is in the overview
+			canonical := occurrences[canonicalIndex]
+			occurrences = append([]models.ContentOccurrence{canonical}, slices.Delete(occurrences, canonicalIndex, canonicalIndex+1)...)
+		}
+
+		overlap := tools.CourseOverlap{Topic: topic}
+		for _, occurrence := range occurrences {
+			lectureNumber, found := lectureNumbers[occurrence.LectureID]
+			if !found {
+				continue
+			}
+			if !slices.Contains(overlap.Lectures, lectureNumber) {
+				overlap.Lectures = append(overlap.Lectures, lectureNumber)
+			}
+			if overlap.CitedLecture == 0 {
+				overlap.CitedLecture = lectureNumber
+				if occurrence.SourceType == "document" {
+					overlap.CitedDocument = courseDocumentName(lectureNumber, occurrence.DocumentTitle)
+					overlap.CitedPage = occurrence.PageNumber
+				} else {
+					overlap.CitedTimestamp = formatTimestamp(occurrence.StartMillisecond)
+				}
+			}
+		}
+		if len(overlap.Lectures) < 2 {
+			continue
+		}
+		slices.Sort(overlap.Lectures)
+		overlaps = append(overlaps, overlap)
+	}
+	return overlaps, rows.Err()
+}
+
+// courseKeyChunks selects the reference chunks of a lecture worth carrying into a course overview: those
+// covering the pages its study guides cite, or its first chunks when no guide cites any
+func courseKeyChunks(db *sql.DB, lectureID string) ([]documents.DocumentChunk, error) {
+	chunks, err := documents.ListLectureReferenceChunks(db, lectureID)
+	if err != nil {
+		return nil, err
+	}
+
+	documentIDs := make(map[string]string)
+	documentRows, err := db.Query("SELECT id, title, original_filename FROM reference_documents WHERE lecture_id = ?", lectureID)
+	if err != nil {
+		return nil, err
+	}
+	for documentRows.Next() {
+		var documentID, title string
+		var originalFilename sql.NullString
+		if documentRows.Scan(&documentID, &title, &originalFilename) != nil {
+			continue
+		}
+		documentIDs[title] = documentID
+		if originalFilename.String != "" {
+			documentIDs[originalFilename.String] = documentID
+		}
+	}
+	documentRows.Close()
+
+	referenceRows, err := db.Query(`
+		SELECT tool_source_references.source_id, tool_source_references.metadata FROM tool_source_references
+		JOIN tools ON tool_source_references.tool_id = tools.id
+		WHERE tools.lecture_id = ? AND tools.type = 'guide' AND tool_source_references.source_type = 'document'
+	`, lectureID)
+	if err != nil {
+		return nil, err
+	}
+	citedPages := make(map[string]map[int]bool)
+	for referenceRows.Next() {
+		var sourceID string
+		var metadataJSON sql.NullString
+		if referenceRows.Scan(&sourceID, &metadataJSON) != nil {
+			continue
+		}
+		var metadata struct {
+			Pages []int `json:"pages"`
+		}
+		documentID, found := documentIDs[sourceID]
+		if !found || json.Unmarshal([]byte(metadataJSON.String), &metadata) != nil {
+			continue
+		}
+		if citedPages[documentID] == nil {
+			citedPages[documentID] = make(map[int]bool)
+		}
+		for _, page := range metadata.Pages {
+			citedPages[documentID][page] = true
+		}
+	}
+	referenceRows.Close()
+
+	var keyChunks []documents.DocumentChunk
+	for _, chunk := range chunks {
+		for page := chunk.StartPage; page <= max(chunk.EndPage, chunk.StartPage); page++ {
+			if citedPages[chunk.DocumentID][page] {
+				keyChunks = append(keyChunks, chunk)
+				break
+			}
+		}
+	}
+	if len(keyChunks) == 0 {
+		keyChunks = chunks
+	}
+	if len(keyChunks) > courseOverviewChunksPerLecture {
+		keyChunks = keyChunks[:courseOverviewChunksPerLecture]
+	}
+	return keyChunks, nil
+}
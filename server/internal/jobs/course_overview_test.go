@@ -0,0 +1,122 @@
+package jobs
+
+import (
+	"slices"
+	"strings"
+	"testing"
+
+	"lectures/internal/models"
+	"lectures/internal/tools"
+)
+
+func TestGatherCourseLectures(t *testing.T) {
+	queue, cleanup := setupQueueTestDatabase(t)
+	defer cleanup()
+	db := queue.database
+
+	db.Exec("INSERT INTO exams (id, user_id, title) VALUES ('course-exam', 'user-1', 'Optics')")
+	db.Exec("INSERT INTO lectures (id, exam_id, title, status, specified_date, created_at) VALUES ('lenses', 'course-exam', 'Lenses', 'ready', '2026-03-10', '2026-01-01')")
+	db.Exec("INSERT INTO lectures (id, exam_id, title, status, specified_date, created_at) VALUES ('mirrors', 'course-exam', 'Mirrors', 'ready', '2026-03-03', '2026-01-02')")
+	db.Exec("INSERT INTO lectures (id, exam_id, title, status) VALUES ('prisms', 'course-exam', 'Prisms', 'processing')")
+	db.Exec("INSERT INTO transcripts (id, lecture_id, status) VALUES ('mirrors-transcript', 'mirrors', 'completed')")
+	db.Exec("INSERT INTO transcript_segments (transcript_id, start_millisecond, end_millisecond, text) VALUES ('mirrors-transcript', 2000, 3000, 'reflect light.')")
+	db.Exec("INSERT INTO transcript_segments (transcript_id, start_millisecond, end_millisecond, text) VALUES ('mirrors-transcript', 0, 1000, 'Mirrors')")
+	db.Exec("INSERT INTO reference_documents (id, lecture_id, document_type, title, original_filename, file_path, page_count, extraction_status) VALUES ('lens-slides', 'lenses', 'pdf', 'Lens slides', 'lenses.pdf', 'lenses.pdf', 3, 'completed')")
+	for _, page := range []string{"1, 'page-1.png', 'Thin lenses'", "2, 'page-2.png', 'Snell law'", "3, 'page-3.png', 'Aberrations'"} {
+		db.Exec("INSERT INTO reference_pages (document_id, page_number, image_path, extracted_text) VALUES ('lens-slides', " + page + ")")
+	}
+	// The guide of the lecture cites its second page, which makes it the key page of the lecture
+	db.Exec("INSERT INTO tools (id, exam_id, lecture_id, type, title, content) VALUES ('lens-guide', 'course-exam', 'lenses', 'guide', 'Lenses', 'Guide')")
+	db.Exec(`INSERT INTO tool_source_references (tool_id, source_type, source_id, metadata) VALUES ('lens-guide', 'document', 'lenses.pdf', '{"footnote_number": 1, "pages": [2]}')`)
+
+	options := models.GenerationOptions{}
+	lectures, lectureOfDocument, err := gatherCourseLectures(db, "course-exam", nil, "en", &options)
+	if err != nil {
+		t.Fatalf("gatherCourseLectures failed: %v", err)
+	}
+
+	if len(lectures) != 2 || lectures[0].Title != "Mirrors" || lectures[1].Title != "Lenses" {
+		t.Fatalf("Expected the ready lectures in the order they were taught, got %+v", lectures)
+	}
+	if strings.TrimSpace(lectures[0].Transcript) != "Mirrors reflect light." {
+		t.Errorf("Expected the segments of the transcript in order, got %q", lectures[0].Transcript)
+	}
+	if !strings.Contains(lectures[1].ReferenceMaterials, "Snell law") || strings.Contains(lectures[1].ReferenceMaterials, "Thin lenses") {
+		t.Errorf("Expected only the cited page as reference material, got:\n%s", lectures[1].ReferenceMaterials)
+	}
+	if !strings.Contains(lectures[1].ReferenceMaterials, "L2_Lens slides") || !slices.Contains(lectures[1].DocumentNames, "L2_lenses.pdf") {
+		t.Errorf("Expected the reference files named after their lecture, got %v:\n%s", lectures[1].DocumentNames, lectures[1].ReferenceMaterials)
+	}
+	if document := lectureOfDocument["L2_lenses.pdf"]; document.lecture.id != "lenses" || document.lecture.title != "Lenses" || document.name != "lenses.pdf" {
+		t.Errorf("Expected the original filename to map onto its lecture, got %+v", document)
+	}
+	if document := lectureOfDocument["L2_Lens slides"]; document.lecture.id != "lenses" || document.name != "Lens slides" {
+		t.Errorf("Expected the title to map onto its lecture, got %+v", document)
+	}
+
+	// Files of the same name in two lectures keep their own lecture
+	db.Exec("INSERT INTO reference_documents (id, lecture_id, document_type, title, original_filename, file_path, page_count, extraction_status) VALUES ('mirror-slides', 'mirrors', 'pdf', 'Slides', 'lenses.pdf', 'mirrors.pdf', 1, 'completed')")
+	_, lectureOfDocument, _ = gatherCourseLectures(db, "course-exam", nil, "en", &options)
+	if document := lectureOfDocument["L1_lenses.pdf"]; document.lecture.id != "mirrors" || document.name != "lenses.pdf" {
+		t.Errorf("Expected the file of the first lecture to map onto it, got %+v", document)
+	}
+	if document := lectureOfDocument["L2_lenses.pdf"]; document.lecture.id != "lenses" {
+		t.Errorf("Expected the file of the second lecture to keep its lecture, got %+v", document)
+	}
+	db.Exec("DELETE FROM reference_documents WHERE id = 'mirror-slides'")
+
+	lectures, _, _ = gatherCourseLectures(db, "course-exam", []string{"lenses", "prisms"}, "en", &options)
+	if len(lectures) != 1 || lectures[0].Title != "Lenses" {
+		t.Errorf("Expected only the chosen lectures that are ready, got %+v", lectures)
+	}
+
+	// Without a citing guide the first pages are used
+	db.Exec("DELETE FROM tools")
+	lectures, _, _ = gatherCourseLectures(db, "course-exam", nil, "en", &options)
+	if !strings.Contains(lectures[1].ReferenceMaterials, "Thin lenses") {
+		t.Errorf("Expected the first pages without a citing guide, got:\n%s", lectures[1].ReferenceMaterials)
+	}
+
+	db.Exec("UPDATE lectures SET status = 'processing'")
+	if _, _, err := gatherCourseLectures(db, "course-exam", nil, "en", &options); err == nil {
+		t.Error("Expected an error when no lecture is ready")
+	}
+}
+
+func TestCourseOverlaps(t *testing.T) {
+	queue, cleanup := setupQueueTestDatabase(t)
+	defer cleanup()
+	db := queue.database
+
+	db.Exec("INSERT INTO exams (id, user_id, title) VALUES ('overlap-exam', 'user-1', 'Optics')")
+	db.Exec(`INSERT INTO content_overlaps (exam_id, topic, similarity, occurrences, canonical_index) VALUES ('overlap-exam', 'Snell law', 0.9, '[
+		{"lecture_id": "mirrors", "source_type": "transcript", "start_millisecond": 65000},
+		{"lecture_id": "lenses", "source_type": "document", "document_title": "Lens slides", "page_number": 2},
+		{"lecture_id": "prisms", "source_type": "document", "document_title": "Prism slides", "page_number": 1}
+	]', 1)`)
+	// The clearest occurrence of this one is in a lecture left out of the overview
+	db.Exec(`INSERT INTO content_overlaps (exam_id, topic, similarity, occurrences, canonical_index) VALUES ('overlap-exam', 'Dispersion', 0.8, '[
+		{"lecture_id": "lenses", "source_type": "transcript", "start_millisecond": 1000},
+		{"lecture_id": "prisms", "source_type": "document", "document_title": "Prism slides", "page_number": 3},
+		{"lecture_id": "mirrors", "source_type": "transcript", "start_millisecond": 2000}
+	]', 1)`)
+	db.Exec(`INSERT INTO content_overlaps (exam_id, topic, similarity, occurrences, canonical_index) VALUES ('overlap-exam', 'Prisms only', 0.8, '[
+		{"lecture_id": "lenses", "source_type": "transcript", "start_millisecond": 1000},
+		{"lecture_id": "prisms", "source_type": "transcript", "start_millisecond": 3000}
+	]', 0)`)
+
+	lectures := []tools.CourseLecture{{ID: "mirrors", Title: "Mirrors"}, {ID: "lenses", Title: "Lenses"}}
+	overlaps, err := courseOverlaps(db, "overlap-exam", lectures)
+	if err != nil {
+		t.Fatalf("courseOverlaps failed: %v", err)
+	}
+	if len(overlaps) != 2 {
+		t.Fatalf("Expected the material repeated within the overview only, got %+v", overlaps)
+	}
+	if overlap := overlaps[0]; !slices.Equal(overlap.Lectures, []int{1, 2}) || overlap.CitedLecture != 2 || overlap.CitedDocument != "L2_Lens slides" || overlap.CitedPage != 2 {
+		t.Errorf("Expected the clearest occurrence to be cited by its qualified name, got %+v", overlap)
+	}
+	if overlap := overlaps[1]; overlap.Topic != "Dispersion" || overlap.CitedLecture != 2 || overlap.CitedDocument != "" || overlap.CitedTimestamp != "00:01" {
+		t.Errorf("Expected the first occurrence within the overview to be cited, got %+v", overlap)
+	}
+}
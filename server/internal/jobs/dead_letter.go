@@ -0,0 +1,197 @@
+package jobs
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"lectures/internal/models"
+)
+
+// DefaultDeadJobRetention is how long failed jobs stay in the dead-letter queue when the configuration sets no
+// retention
+const DefaultDeadJobRetention = 30 * 24 * time.Hour
+
+// ListDeadJobs returns the failed jobs of a user that were not requeued, most recent failure first. Jobs that
+// failed before failures were recorded are classified from their error text
+func (queue *Queue) ListDeadJobs(userID string) ([]*models.Job, error) {
+	rows, err := queue.database.Query(`
+		SELECT `+jobColumns+` FROM jobs
+		WHERE user_id = ? AND status = ? AND requeued_as IS NULL AND dead_letter_expired_at IS NULL
+		ORDER BY completed_at DESC
+	`, userID, models.JobStatusFailed)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query dead jobs: %w", err)
+	}
+	deadJobs := []*models.Job{}
+	deadJobByID := make(map[string]*models.Job)
+	for rows.Next() {
+		// Failed jobs hold no lock, so only their dependencies are left to read
+		job, _, err := scanJob(rows)
+		if err != nil {
+			continue
+		}
+		if job.Failure == nil {
+			failure := classifyJobFailure(errors.New(job.Error))
+			job.Failure = &failure
+		}
+		deadJobs = append(deadJobs, job)
+		deadJobByID[job.ID] = job
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to query dead jobs: %w", err)
+	}
+
+	dependencyRows, err := queue.database.Query(`
+		SELECT job_dependencies.job_id, job_dependencies.depends_on_job_id FROM job_dependencies
+		JOIN jobs ON jobs.id = job_dependencies.job_id
+		WHERE jobs.user_id = ? AND jobs.status = ? AND jobs.requeued_as IS NULL AND jobs.dead_letter_expired_at IS NULL
+		ORDER BY job_dependencies.rowid
+	`, userID, models.JobStatusFailed)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query dead job dependencies: %w", err)
+	}
+	defer dependencyRows.Close()
+	for dependencyRows.Next() {
+		var jobID, dependencyID string
+		if dependencyRows.Scan(&jobID, &dependencyID) != nil {
+			continue
+		}
+		if job, found := deadJobByID[jobID]; found {
+			job.DependsOn = append(job.DependsOn, dependencyID)
+		}
+	}
+	return deadJobs, dependencyRows.Err()
+}
+
+// RequeueJob clones a failed job into a fresh pending one with the same type, payload, priority and scope, and
+// returns its ID. The failed job leaves the dead-letter queue and points to its replacement. Dependencies that
+// were requeued in turn are replaced by their copies and completed ones dropped, while a dependency still
+// failed must be requeued first
+func (queue *Queue) RequeueJob(jobID string) (string, error) {
+	job, err := queue.GetJob(jobID)
+	if err != nil {
+		return "", err
+	}
+	var expired bool
+	if err := queue.database.QueryRow("SELECT dead_letter_expired_at IS NOT NULL FROM jobs WHERE id = ?", jobID).Scan(&expired); err != nil {
+		return "", err
+	}
+	if job.Status != models.JobStatusFailed || job.RequeuedAs != "" || expired {
+		return "", fmt.Errorf("job %s is not a failed job waiting to be requeued", jobID)
+	}
+
+	var dependsOn []string
+	for _, dependencyID := range job.DependsOn {
+		dependency, err := queue.currentJob(dependencyID)
+		if err == sql.ErrNoRows {
+			continue
+		}
+		if err != nil {
+			return "", err
+		}
+		switch dependency.Status {
+		case models.JobStatusCompleted:
+			continue
+		case models.JobStatusFailed, models.JobStatusCancelled:
+			return "", fmt.Errorf("dependency %s ended with status %s; requeue it first", dependency.ID, dependency.Status)
+		}
+		dependsOn = append(dependsOn, dependency.ID)
+	}
+
+	requeuedJobID, err := queue.enqueue(job.UserID, job.Type, json.RawMessage(job.Payload), job.CourseID, job.LectureID, job.Priority, dependsOn, job.ID)
+	if err != nil {
+		return "", err
+	}
+
+	slog.Info("Requeued failed job", "jobID", jobID, "requeuedAs", requeuedJobID, "type", job.Type)
+	return requeuedJobID, nil
+}
+
+// currentJob follows the requeues of a job to the job that stands for it now
+func (queue *Queue) currentJob(jobID string) (*models.Job, error) {
+	job, err := queue.GetJob(jobID)
+	for err == nil && job.RequeuedAs != "" {
+		job, err = queue.GetJob(job.RequeuedAs)
+	}
+	return job, err
+}
+
+// ExpireDeadJobs takes the failed jobs that were not requeued and failed more than retention ago out of the
+// dead-letter queue, returning how many expired. They are compacted rather than deleted: their payload, metadata,
+// result, logs and checkpoints are cleared, while the columns usage reports read are kept
+func (queue *Queue) ExpireDeadJobs(retention time.Duration) (int, error) {
+	rows, err := queue.database.Query(
+		"SELECT id, created_at, completed_at FROM jobs WHERE status = ? AND requeued_as IS NULL AND dead_letter_expired_at IS NULL", models.JobStatusFailed,
+	)
+	if err != nil {
+		return 0, fmt.Errorf("failed to query dead jobs: %w", err)
+	}
+
+	cutoff := time.Now().Add(-retention)
+	var expiredJobIDs []string
+	for rows.Next() {
+		var jobID string
+		var createdAt time.Time
+		var completedAt sql.NullTime
+		if rows.Scan(&jobID, &createdAt, &completedAt) != nil {
+			continue
+		}
+		failedAt := createdAt
+		if completedAt.Valid {
+			failedAt = completedAt.Time
+		}
+		if failedAt.Before(cutoff) {
+			expiredJobIDs = append(expiredJobIDs, jobID)
+		}
+	}
+	rows.Close()
+
+	expiredCount := 0
+	for _, jobID := range expiredJobIDs {
+		expired, err := queue.compactDeadJob(jobID)
+		if err != nil {
+			return expiredCount, fmt.Errorf("failed to expire dead job %s: %w", jobID, err)
+		}
+		if expired {
+			expiredCount++
+		}
+	}
+
+	if expiredCount > 0 {
+		slog.Info("Expired dead jobs", "count", expiredCount, "retention", retention)
+	}
+	return expiredCount, nil
+}
+
+// compactDeadJob marks a failed job as expired and clears what only requeuing it needed, in one transaction. It
+// reports false when the job was requeued or expired in the meantime
+func (queue *Queue) compactDeadJob(jobID string) (bool, error) {
+	transaction, err := queue.database.Begin()
+	if err != nil {
+		return false, err
+	}
+	defer transaction.Rollback()
+
+	result, err := transaction.Exec(`
+		UPDATE jobs SET payload = '{}', metadata = NULL, result = NULL, progress_message_text = NULL, dead_letter_expired_at = ?
+		WHERE id = ? AND status = ? AND requeued_as IS NULL AND dead_letter_expired_at IS NULL
+	`, time.Now(), jobID, models.JobStatusFailed)
+	if err != nil {
+		return false, err
+	}
+	if affectedRows, _ := result.RowsAffected(); affectedRows == 0 {
+		return false, nil
+	}
+	if _, err := transaction.Exec("DELETE FROM job_logs WHERE job_id = ?", jobID); err != nil {
+		return false, err
+	}
+	if _, err := transaction.Exec("DELETE FROM job_checkpoints WHERE job_id = ?", jobID); err != nil {
+		return false, err
+	}
+	return true, transaction.Commit()
+}
@@ -0,0 +1,104 @@
+package jobs
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"lectures/internal/models"
+)
+
+func TestQueue_DeadLetters(t *testing.T) {
+	queue, cleanup := setupQueueTestDatabase(t)
+	defer cleanup()
+
+	transcriptionJobID, _ := queue.Enqueue("user-1", models.JobTypeTranscribeMedia, map[string]string{"lecture_id": "lecture-1"}, "", "")
+	buildJobID, _ := queue.EnqueueAfter("user-1", models.JobTypeBuildMaterial, map[string]string{"lecture_id": "lecture-1", "type": "quiz"}, "", "", []string{transcriptionJobID})
+	queue.failJob(transcriptionJobID, "whisper returned status 503", classifyJobFailure(errors.New("whisper returned status 503")))
+
+	// Failures recorded before they were classified are classified from their error text
+	legacyJobID, _ := queue.Enqueue("user-1", models.JobTypeSuggest, map[string]string{}, "", "")
+	queue.database.Exec("UPDATE jobs SET status = ?, error = ?, completed_at = ? WHERE id = ?", models.JobStatusFailed, "status 429: rate limit", time.Now(), legacyJobID)
+
+	deadJobs, err := queue.ListDeadJobs("user-1")
+	if err != nil {
+		t.Fatalf("ListDeadJobs failed: %v", err)
+	}
+	failureCodes := make(map[string]string)
+	for _, job := range deadJobs {
+		failureCodes[job.ID] = job.Failure.Code
+	}
+	if len(deadJobs) != 3 || failureCodes[transcriptionJobID] != models.JobFailureProviderUnavailable ||
+		failureCodes[buildJobID] != models.JobFailureDependencyFailed || failureCodes[legacyJobID] != models.JobFailureRateLimited {
+		t.Fatalf("Unexpected dead jobs %v", failureCodes)
+	}
+
+	t.Run("Requeue", func(t *testing.T) {
+		if _, err := queue.RequeueJob(buildJobID); err == nil {
+			t.Fatal("Expected the build to wait for its failed dependency to be requeued first")
+		}
+
+		requeuedTranscriptionJobID, err := queue.RequeueJob(transcriptionJobID)
+		if err != nil {
+			t.Fatalf("RequeueJob failed: %v", err)
+		}
+		if _, err := queue.RequeueJob(transcriptionJobID); err == nil {
+			t.Error("Expected a requeued job to be rejected the second time")
+		}
+
+		requeuedBuildJobID, err := queue.RequeueJob(buildJobID)
+		if err != nil {
+			t.Fatalf("RequeueJob failed once the dependency was requeued: %v", err)
+		}
+		requeuedBuild, _ := queue.GetJob(requeuedBuildJobID)
+		if requeuedBuild.Status != models.JobStatusPending || requeuedBuild.Payload != `{"lecture_id":"lecture-1","type":"quiz"}` {
+			t.Errorf("Unexpected requeued build %+v", requeuedBuild)
+		}
+		if len(requeuedBuild.DependsOn) != 1 || requeuedBuild.DependsOn[0] != requeuedTranscriptionJobID {
+			t.Errorf("Expected the requeued build to wait for the requeued transcription, got %v", requeuedBuild.DependsOn)
+		}
+
+		failedBuild, _ := queue.GetJob(buildJobID)
+		if failedBuild.RequeuedAs != requeuedBuildJobID {
+			t.Errorf("Expected the failed build to point to its replacement, got %q", failedBuild.RequeuedAs)
+		}
+		deadJobs, _ := queue.ListDeadJobs("user-1")
+		if len(deadJobs) != 1 || deadJobs[0].ID != legacyJobID {
+			t.Errorf("Expected requeued jobs to leave the dead-letter queue, got %d jobs", len(deadJobs))
+		}
+	})
+
+	t.Run("Expiry", func(t *testing.T) {
+		recentJobID, _ := queue.Enqueue("user-1", models.JobTypeSuggest, map[string]string{}, "", "")
+		queue.failJob(recentJobID, "boom", classifyJobFailure(errors.New("boom")))
+		queue.database.Exec("UPDATE jobs SET completed_at = ? WHERE id = ?", time.Now().Add(-31*24*time.Hour), legacyJobID)
+
+		expiredCount, err := queue.ExpireDeadJobs(DefaultDeadJobRetention)
+		if err != nil || expiredCount != 1 {
+			t.Fatalf("Expected one expired job, got %d (%v)", expiredCount, err)
+		}
+		// The expired job is compacted, keeping what it spent, and leaves the dead-letter queue for good
+		queue.database.Exec("UPDATE jobs SET estimated_cost = 0.5 WHERE id = ?", legacyJobID)
+		expiredJob, err := queue.GetJob(legacyJobID)
+		if err != nil || expiredJob.Payload != "{}" || expiredJob.Status != models.JobStatusFailed {
+			t.Errorf("Expected the expired job to be compacted, got %+v (%v)", expiredJob, err)
+		}
+		deadJobs, _ := queue.ListDeadJobs("user-1")
+		if len(deadJobs) != 1 || deadJobs[0].ID != recentJobID {
+			t.Errorf("Expected only the recent failure in the dead-letter queue, got %d jobs", len(deadJobs))
+		}
+		if _, err := queue.RequeueJob(legacyJobID); err == nil {
+			t.Error("Expected an expired job to be refused for requeuing")
+		}
+		if expiredCount, _ := queue.ExpireDeadJobs(DefaultDeadJobRetention); expiredCount != 0 {
+			t.Errorf("Expected an expired job to expire once, got %d", expiredCount)
+		}
+		if _, err := queue.GetJob(recentJobID); err != nil {
+			t.Error("Expected the recent failure to be kept")
+		}
+		// Requeued failures are history rather than dead letters, so they are kept
+		if _, err := queue.GetJob(transcriptionJobID); err != nil {
+			t.Error("Expected the requeued job to be kept")
+		}
+	})
+}
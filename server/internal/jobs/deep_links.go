@@ -0,0 +1,97 @@
+package jobs
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"log/slog"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"lectures/internal/configuration"
+
+	qrcode "github.com/skip2/go-qrcode"
+)
+
+// exportQRCodeSize is the side, in pixels, of the QR code images embedded in exports
+const exportQRCodeSize = 256
+
+// exportLinks builds the web app addresses embedded in exported files, so a printed handout leads back to the
+// lecture or guide section it came from. Links carry ref=export-<format>, so the app can tell them apart from
+// in-app navigation. Nothing is linked until server.public_base_url is configured
+type exportLinks struct {
+	baseURL     *url.URL
+	referrer    string
+	qrDirectory string // QR codes are rendered here when not empty
+}
+
+// newExportLinks prepares the links of an export job; QR codes are only rendered when includeQRCode is set
+func newExportLinks(config *configuration.Configuration, jobID string, format string, includeQRCode bool) exportLinks {
+	links := exportLinks{referrer: "export-" + format}
+	publicBaseURL := strings.TrimSpace(config.Server.PublicBaseURL)
+	if publicBaseURL == "" {
+		return links
+	}
+	baseURL, parsingError := url.Parse(publicBaseURL)
+	if parsingError != nil || (baseURL.Scheme != "http" && baseURL.Scheme != "https") || baseURL.Host == "" {
+		slog.Warn("Ignoring invalid server.public_base_url for export links", "public_base_url", publicBaseURL)
+		return links
+	}
+	links.baseURL = baseURL
+	if includeQRCode {
+		links.qrDirectory = filepath.Join(os.TempDir(), "lectures-exports", jobID, "qr")
+	}
+	return links
+}
+
+// enabled reports whether exports get links at all
+func (links exportLinks) enabled() bool {
+	return links.baseURL != nil
+}
+
+// address joins path segments to the base URL, with the referrer and an optional fragment
+func (links exportLinks) address(query url.Values, fragment string, segments ...string) string {
+	address := links.baseURL.JoinPath(segments...)
+	if query == nil {
+		query = url.Values{}
+	}
+	query.Set("ref", links.referrer)
+	address.RawQuery = query.Encode()
+	address.Fragment = fragment
+	return address.String()
+}
+
+// lecture links to a lecture page
+func (links exportLinks) lecture(examID string, lectureID string) string {
+	return links.address(nil, "", "exams", examID, "lectures", lectureID)
+}
+
+// lectureTimestamp links to a lecture page opened on the transcript segment playing at millisecond
+func (links exportLinks) lectureTimestamp(examID string, lectureID string, millisecond int64) string {
+	return links.address(url.Values{"t": {strconv.FormatInt(millisecond, 10)}}, "", "exams", examID, "lectures", lectureID)
+}
+
+// tool links to a tool page, scrolled to the section with the given anchor when it is not empty
+func (links exportLinks) tool(examID string, toolID string, anchor string) string {
+	return links.address(nil, anchor, "exams", examID, "tools", toolID)
+}
+
+// qrCode renders the QR code of a link and returns its path, or "" when QR codes were not requested or the
+// image could not be written
+func (links exportLinks) qrCode(link string) string {
+	if links.qrDirectory == "" {
+		return ""
+	}
+	if directoryError := os.MkdirAll(links.qrDirectory, 0755); directoryError != nil {
+		slog.Warn("Failed to create QR code directory", "error", directoryError)
+		return ""
+	}
+	imagePath := filepath.Join(links.qrDirectory, fmt.Sprintf("%x.png", sha256.Sum256([]byte(link))))
+	if writeError := qrcode.WriteFile(link, qrcode.Medium, exportQRCodeSize, imagePath); writeError != nil {
+		slog.Warn("Failed to render QR code", "link", link, "error", writeError)
+		return ""
+	}
+	return imagePath
+}
@@ -0,0 +1,43 @@
+package jobs
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"lectures/internal/configuration"
+)
+
+func TestExportLinks(t *testing.T) {
+	config := &configuration.Configuration{}
+	if links := newExportLinks(config, "job-links", "pdf", true); links.enabled() {
+		t.Fatalf("Expected no links without server.public_base_url")
+	}
+	config.Server.PublicBaseURL = "not a url"
+	if links := newExportLinks(config, "job-links", "pdf", true); links.enabled() {
+		t.Fatalf("Expected an invalid base URL to be ignored")
+	}
+
+	config.Server.PublicBaseURL = "https://lectures.example/app/"
+	links := newExportLinks(config, "job-links", "pdf", true)
+	defer os.RemoveAll(filepath.Dir(links.qrDirectory))
+
+	if link := links.lectureTimestamp("exam-1", "lecture-1", 90500); link != "https://lectures.example/app/exams/exam-1/lectures/lecture-1?ref=export-pdf&t=90500" {
+		t.Errorf("Unexpected timestamp link %q", link)
+	}
+	if link := links.tool("exam-1", "tool-1", "cell-division"); link != "https://lectures.example/app/exams/exam-1/tools/tool-1?ref=export-pdf#cell-division" {
+		t.Errorf("Unexpected section link %q", link)
+	}
+
+	imagePath := links.qrCode(links.lecture("exam-1", "lecture-1"))
+	if imagePath == "" {
+		t.Fatalf("Expected a QR code to be rendered")
+	}
+	if information, err := os.Stat(imagePath); err != nil || information.Size() == 0 {
+		t.Errorf("Expected the QR code image at %s: %v", imagePath, err)
+	}
+
+	if withoutQRCodes := newExportLinks(config, "job-links", "pdf", false); withoutQRCodes.qrCode("https://lectures.example") != "" {
+		t.Errorf("Expected no QR code when they were not requested")
+	}
+}
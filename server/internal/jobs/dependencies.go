@@ -0,0 +1,101 @@
+package jobs
+
+import (
+	"database/sql"
+	"fmt"
+	"log/slog"
+
+	"lectures/internal/models"
+)
+
+// dependenciesMetCondition selects the pending jobs whose dependencies all completed. A dependency whose row is
+// gone, such as a failed job that was retried, no longer holds the job back
+const dependenciesMetCondition = `
+	AND NOT EXISTS (
+		SELECT 1 FROM job_dependencies
+		JOIN jobs AS dependency ON dependency.id = job_dependencies.depends_on_job_id
+		WHERE job_dependencies.job_id = jobs.id AND dependency.status != 'COMPLETED'
+	)`
+
+// EnqueueAfter creates a new job with the default priority of its type that only starts once every job of
+// dependsOn completed. It fails without starting when one of them fails or is cancelled
+func (queue *Queue) EnqueueAfter(userID string, jobType string, payload interface{}, courseID, lectureID string, dependsOn []string) (string, error) {
+	return queue.enqueue(userID, jobType, payload, courseID, lectureID, DefaultJobPriority(jobType), dependsOn, "")
+}
+
+// insertJobDependencies records the jobs jobID waits for, which must exist and still be able to complete
+func insertJobDependencies(transaction *sql.Tx, jobID string, dependsOn []string) error {
+	for _, dependencyID := range dependsOn {
+		var status string
+		if err := transaction.QueryRow("SELECT status FROM jobs WHERE id = ?", dependencyID).Scan(&status); err != nil {
+			if err == sql.ErrNoRows {
+				return fmt.Errorf("dependency job %s not found", dependencyID)
+			}
+			return fmt.Errorf("failed to query dependency job %s: %w", dependencyID, err)
+		}
+		if status == models.JobStatusFailed || status == models.JobStatusCancelled {
+			return fmt.Errorf("dependency job %s already ended with status %s", dependencyID, status)
+		}
+		if _, err := transaction.Exec(
+			"INSERT OR IGNORE INTO job_dependencies (job_id, depends_on_job_id) VALUES (?, ?)", jobID, dependencyID,
+		); err != nil {
+			return fmt.Errorf("failed to insert job dependency: %w", err)
+		}
+	}
+	return nil
+}
+
+// jobDependencies lists the jobs jobID waits for
+func (queue *Queue) jobDependencies(jobID string) []string {
+	rows, err := queue.database.Query("SELECT depends_on_job_id FROM job_dependencies WHERE job_id = ? ORDER BY rowid", jobID)
+	if err != nil {
+		return nil
+	}
+	defer rows.Close()
+
+	var dependsOn []string
+	for rows.Next() {
+		var dependencyID string
+		if rows.Scan(&dependencyID) == nil {
+			dependsOn = append(dependsOn, dependencyID)
+		}
+	}
+	return dependsOn
+}
+
+// failBlockedJobs fails the pending jobs waiting for a job that failed or was cancelled, since they can never
+// start. Failing them fails the jobs waiting for them in turn
+func (queue *Queue) failBlockedJobs() {
+	rows, err := queue.database.Query(`
+		SELECT job_dependencies.job_id, dependency.id, dependency.type, dependency.status
+		FROM job_dependencies
+		JOIN jobs AS dependent ON dependent.id = job_dependencies.job_id
+		JOIN jobs AS dependency ON dependency.id = job_dependencies.depends_on_job_id
+		WHERE dependent.status = ? AND dependency.status IN (?, ?)
+	`, models.JobStatusPending, models.JobStatusFailed, models.JobStatusCancelled)
+	if err != nil {
+		slog.Error("Failed to query jobs waiting for failed jobs", "error", err)
+		return
+	}
+
+	blockedJobs := make(map[string]string)
+	var blockedJobIDs []string
+	for rows.Next() {
+		var jobID, dependencyID, dependencyType, dependencyStatus string
+		if rows.Scan(&jobID, &dependencyID, &dependencyType, &dependencyStatus) != nil {
+			continue
+		}
+		if _, found := blockedJobs[jobID]; !found {
+			blockedJobIDs = append(blockedJobIDs, jobID)
+			blockedJobs[jobID] = fmt.Sprintf("dependency %s (%s) ended with status %s", dependencyID, dependencyType, dependencyStatus)
+		}
+	}
+	rows.Close()
+
+	for _, jobID := range blockedJobIDs {
+		queue.failJob(jobID, blockedJobs[jobID], models.JobFailure{
+			Code: models.JobFailureDependencyFailed, Action: models.JobActionCheckInput,
+			Message: "A task this one was waiting for did not finish, so it was not started.",
+		})
+	}
+}
@@ -0,0 +1,75 @@
+package jobs
+
+import (
+	"errors"
+	"testing"
+
+	"lectures/internal/models"
+)
+
+func TestQueue_Dependencies(t *testing.T) {
+	queue, cleanup := setupQueueTestDatabase(t)
+	defer cleanup()
+	queue.SetConcurrency(nil, nil)
+	buildPool := queue.poolFor(models.JobTypeBuildMaterial)
+
+	transcriptionJobID, _ := queue.Enqueue("user-1", models.JobTypeTranscribeMedia, map[string]string{}, "", "")
+	ingestionJobID, _ := queue.Enqueue("user-1", models.JobTypeIngestDocuments, map[string]string{}, "", "")
+	buildJobID, err := queue.EnqueueAfter("user-1", models.JobTypeBuildMaterial, map[string]string{}, "", "", []string{transcriptionJobID, ingestionJobID})
+	if err != nil {
+		t.Fatalf("EnqueueAfter failed: %v", err)
+	}
+
+	job, _ := queue.GetJob(buildJobID)
+	if len(job.DependsOn) != 2 || job.DependsOn[0] != transcriptionJobID || job.DependsOn[1] != ingestionJobID {
+		t.Errorf("Unexpected dependencies %v", job.DependsOn)
+	}
+
+	// The build waits until both dependencies completed
+	if claimed := queue.claimNextJob(buildPool, 0, false); claimed != nil {
+		t.Fatalf("Claimed %s before its dependencies completed", claimed.ID)
+	}
+	queue.completeJob(transcriptionJobID, "")
+	if claimed := queue.claimNextJob(buildPool, 0, false); claimed != nil {
+		t.Fatalf("Claimed %s before ingestion completed", claimed.ID)
+	}
+	queue.completeJob(ingestionJobID, "")
+	if claimed := queue.claimNextJob(buildPool, 0, false); claimed == nil || claimed.ID != buildJobID {
+		t.Fatalf("Expected the build to be claimed once its dependencies completed, got %+v", claimed)
+	}
+
+	t.Run("Failure propagates", func(t *testing.T) {
+		failingJobID, _ := queue.Enqueue("user-1", models.JobTypeTranscribeMedia, map[string]string{}, "", "")
+		waitingJobID, _ := queue.EnqueueAfter("user-1", models.JobTypeBuildMaterial, map[string]string{}, "", "", []string{failingJobID})
+		chainedJobID, _ := queue.EnqueueAfter("user-1", models.JobTypePublishMaterial, map[string]string{}, "", "", []string{waitingJobID})
+
+		queue.failJob(failingJobID, "whisper returned status 503", classifyJobFailure(errors.New("whisper returned status 503")))
+
+		for _, jobID := range []string{waitingJobID, chainedJobID} {
+			job, _ := queue.GetJob(jobID)
+			if job.Status != models.JobStatusFailed || job.Failure == nil || job.Failure.Code != models.JobFailureDependencyFailed {
+				t.Errorf("Expected job %s to fail with its dependency, got %s %+v", jobID, job.Status, job.Failure)
+			}
+		}
+
+		if _, err := queue.EnqueueAfter("user-1", models.JobTypeBuildMaterial, map[string]string{}, "", "", []string{failingJobID}); err == nil {
+			t.Error("Expected enqueuing after a failed job to be rejected")
+		}
+		if _, err := queue.EnqueueAfter("user-1", models.JobTypeBuildMaterial, map[string]string{}, "", "", []string{"missing-job"}); err == nil {
+			t.Error("Expected enqueuing after an unknown job to be rejected")
+		}
+	})
+
+	t.Run("Cancellation propagates", func(t *testing.T) {
+		cancelledJobID, _ := queue.Enqueue("user-1", models.JobTypeIngestDocuments, map[string]string{}, "", "")
+		waitingJobID, _ := queue.EnqueueAfter("user-1", models.JobTypeBuildMaterial, map[string]string{}, "", "", []string{cancelledJobID})
+
+		if err := queue.CancelJob(cancelledJobID); err != nil {
+			t.Fatalf("CancelJob failed: %v", err)
+		}
+		job, _ := queue.GetJob(waitingJobID)
+		if job.Status != models.JobStatusFailed {
+			t.Errorf("Expected the waiting job to fail, got %s", job.Status)
+		}
+	})
+}
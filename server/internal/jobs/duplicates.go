@@ -0,0 +1,317 @@
+package jobs
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+	"unicode"
+
+	"lectures/internal/models"
+)
+
+const (
+	// overlapWindowCharacters is the length of the transcript windows compared with each other and with pages
+	overlapWindowCharacters = 1200
+	// overlapMinimumShingles skips title slides and short remarks, which match too easily
+	overlapMinimumShingles = 15
+	// overlapMinimumShared is the number of three-word phrases two passages must share to be compared at all
+	overlapMinimumShared = 8
+	// overlapThreshold is the share of the shorter passage's phrases found in the longer one above which both
+	// cover the same material
+	overlapThreshold = 0.5
+	// overlapCommonPhraseUnits drops phrases found in more passages than this, such as footers and slide templates
+	overlapCommonPhraseUnits = 40
+)
+
+// overlapUnit is a passage compared for overlaps: a document page or a window of transcript
+type overlapUnit struct {
+	occurrence models.ContentOccurrence
+	text       string
+	shingles   map[uint64]struct{}
+}
+
+// analyzeDuplicatesHandler finds the material covered in more than one lecture of an exam and replaces the exam's
+// content_overlaps with it. Passages are compared by the three-word phrases they share, so re-used slides and
+// explanations repeated close to verbatim are found without calling a model
+func analyzeDuplicatesHandler(database *sql.DB) JobHandler {
+	return func(jobContext context.Context, job *models.Job, updateProgress func(int, string, any, models.JobMetrics)) error {
+		var payload struct {
+			ExamID string `json:"exam_id"`
+		}
+		if unmarshalingError := json.Unmarshal([]byte(job.Payload), &payload); unmarshalingError != nil {
+			return fmt.Errorf("failed to unmarshal job payload: %w", unmarshalingError)
+		}
+
+		updateProgress(10, "Reading lecture material...", nil, models.JobMetrics{})
+		units, err := loadOverlapUnits(database, payload.ExamID)
+		if err != nil {
+			return err
+		}
+		if jobContext.Err() != nil {
+			return jobContext.Err()
+		}
+
+		updateProgress(50, "Comparing lectures...", nil, models.JobMetrics{})
+		overlaps := findContentOverlaps(units)
+
+		transaction, err := database.Begin()
+		if err != nil {
+			return fmt.Errorf("failed to begin transaction: %w", err)
+		}
+		defer transaction.Rollback()
+
+		if _, err := transaction.Exec("DELETE FROM content_overlaps WHERE exam_id = ?", payload.ExamID); err != nil {
+			return fmt.Errorf("failed to clear content overlaps: %w", err)
+		}
+		for _, overlap := range overlaps {
+			occurrencesJSON, _ := json.Marshal(overlap.Occurrences)
+			_, err := transaction.Exec(`
+				INSERT INTO content_overlaps (exam_id, topic, similarity, occurrences, canonical_index, created_at)
+				VALUES (?, ?, ?, ?, ?, ?)
+			`, payload.ExamID, overlap.Topic, overlap.Similarity, string(occurrencesJSON), overlap.CanonicalIndex, time.Now())
+			if err != nil {
+				return fmt.Errorf("failed to store content overlap: %w", err)
+			}
+		}
+		if err := transaction.Commit(); err != nil {
+			return fmt.Errorf("failed to commit content overlaps: %w", err)
+		}
+
+		updateProgress(100, fmt.Sprintf("Found %d topics covered in more than one lecture", len(overlaps)), nil, models.JobMetrics{})
+		job.Result = fmt.Sprintf(`{"overlaps": %d}`, len(overlaps))
+		return nil
+	}
+}
+
+// loadOverlapUnits reads the extracted pages and the transcript windows of every lecture of an exam
+func loadOverlapUnits(database *sql.DB, examID string) ([]overlapUnit, error) {
+	var units []overlapUnit
+
+	pageRows, err := database.Query(`
+		SELECT lectures.id, lectures.title, reference_documents.id, reference_documents.title, reference_pages.page_number, reference_pages.extracted_text
+		FROM reference_pages
+		JOIN reference_documents ON reference_pages.document_id = reference_documents.id
+		JOIN lectures ON reference_documents.lecture_id = lectures.id
+		WHERE lectures.exam_id = ? AND reference_documents.extraction_status = 'completed'
+			AND reference_pages.extracted_text IS NOT NULL
+		ORDER BY lectures.created_at ASC, reference_documents.created_at ASC, reference_pages.page_number ASC
+	`, examID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query reference pages: %w", err)
+	}
+	for pageRows.Next() {
+		var occurrence models.ContentOccurrence
+		var text string
+		if pageRows.Scan(&occurrence.LectureID, &occurrence.LectureTitle, &occurrence.DocumentID, &occurrence.DocumentTitle, &occurrence.PageNumber, &text) != nil {
+			continue
+		}
+		occurrence.SourceType = "document"
+		units = append(units, overlapUnit{occurrence: occurrence, text: text})
+	}
+	pageRows.Close()
+
+	segmentRows, err := database.Query(`
+		SELECT lectures.id, lectures.title, transcript_segments.start_millisecond, transcript_segments.end_millisecond,
+			COALESCE(transcript_segments.polished_text, transcript_segments.text)
+		FROM transcript_segments
+		JOIN transcripts ON transcript_segments.transcript_id = transcripts.id
+		JOIN lectures ON transcripts.lecture_id = lectures.id
+		WHERE lectures.exam_id = ? AND transcripts.status = 'completed'
+		ORDER BY lectures.created_at ASC, lectures.id ASC, transcript_segments.start_millisecond ASC
+	`, examID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query transcripts: %w", err)
+	}
+	defer segmentRows.Close()
+
+	// Consecutive segments are joined into windows of about overlapWindowCharacters
+	var window *overlapUnit
+	var windowText strings.Builder
+	flushWindow := func() {
+		if window != nil {
+			window.text = windowText.String()
+			units = append(units, *window)
+		}
+		window = nil
+		windowText.Reset()
+	}
+	for segmentRows.Next() {
+		var lectureID, lectureTitle, text string
+		var startMillisecond, endMillisecond int64
+		if segmentRows.Scan(&lectureID, &lectureTitle, &startMillisecond, &endMillisecond, &text) != nil {
+			continue
+		}
+		if window != nil && (window.occurrence.LectureID != lectureID || windowText.Len() >= overlapWindowCharacters) {
+			flushWindow()
+		}
+		if window == nil {
+			window = &overlapUnit{occurrence: models.ContentOccurrence{
+				LectureID:        lectureID,
+				LectureTitle:     lectureTitle,
+				SourceType:       "transcript",
+				StartMillisecond: startMillisecond,
+			}}
+		}
+		window.occurrence.EndMillisecond = endMillisecond
+		windowText.WriteString(strings.TrimSpace(text))
+		windowText.WriteString(" ")
+	}
+	flushWindow()
+
+	return units, nil
+}
+
+// findContentOverlaps groups the passages of different lectures that share most of their phrases. Each group
+// spanning at least two lectures becomes an overlap, the clearest occurrence being the page with the most text
+// or, without pages, the longest transcript window
+func findContentOverlaps(units []overlapUnit) []models.ContentOverlap {
+	phraseUnits := make(map[uint64][]int)
+	for unitIndex := range units {
+		units[unitIndex].shingles = textShingles(units[unitIndex].text)
+		if len(units[unitIndex].shingles) < overlapMinimumShingles {
+			continue
+		}
+		for shingle := range units[unitIndex].shingles {
+			phraseUnits[shingle] = append(phraseUnits[shingle], unitIndex)
+		}
+	}
+
+	// Count the phrases shared by every pair of passages from different lectures
+	type unitPair struct{ first, second int }
+	sharedPhrases := make(map[unitPair]int)
+	for _, unitIndexes := range phraseUnits {
+		if len(unitIndexes) < 2 || len(unitIndexes) > overlapCommonPhraseUnits {
+			continue
+		}
+		for firstPosition, first := range unitIndexes {
+			for _, second := range unitIndexes[firstPosition+1:] {
+				if units[first].occurrence.LectureID != units[second].occurrence.LectureID {
+					sharedPhrases[unitPair{first, second}]++
+				}
+			}
+		}
+	}
+
+	parents := make([]int, len(units))
+	for unitIndex := range parents {
+		parents[unitIndex] = unitIndex
+	}
+	var root func(int) int
+	root = func(unitIndex int) int {
+		for parents[unitIndex] != unitIndex {
+			parents[unitIndex] = parents[parents[unitIndex]]
+			unitIndex = parents[unitIndex]
+		}
+		return unitIndex
+	}
+
+	bestSimilarity := make(map[int]float64)
+	var matchedPairs []unitPair
+	for pair, shared := range sharedPhrases {
+		if shared < overlapMinimumShared {
+			continue
+		}
+		similarity := float64(shared) / float64(min(len(units[pair.first].shingles), len(units[pair.second].shingles)))
+		if similarity < overlapThreshold {
+			continue
+		}
+		matchedPairs = append(matchedPairs, pair)
+		parents[root(pair.second)] = root(pair.first)
+		bestSimilarity[pair.first] = max(bestSimilarity[pair.first], similarity)
+		bestSimilarity[pair.second] = max(bestSimilarity[pair.second], similarity)
+	}
+
+	groups := make(map[int][]int)
+	for _, pair := range matchedPairs {
+		for _, unitIndex := range []int{pair.first, pair.second} {
+			groupRoot := root(unitIndex)
+			if !slices.Contains(groups[groupRoot], unitIndex) {
+				groups[groupRoot] = append(groups[groupRoot], unitIndex)
+			}
+		}
+	}
+
+	var overlaps []models.ContentOverlap
+	for _, members := range groups {
+		sort.Ints(members)
+		lectures := make(map[string]bool)
+		overlap := models.ContentOverlap{}
+		canonical := -1
+		for _, unitIndex := range members {
+			unit := units[unitIndex]
+			lectures[unit.occurrence.LectureID] = true
+			occurrence := unit.occurrence
+			occurrence.Excerpt = overlapExcerpt(unit.text, 200)
+			overlap.Occurrences = append(overlap.Occurrences, occurrence)
+			overlap.Similarity = max(overlap.Similarity, bestSimilarity[unitIndex])
+			if canonical == -1 || clearerOccurrence(unit, units[members[canonical]]) {
+				canonical = len(overlap.Occurrences) - 1
+			}
+		}
+		if len(lectures) < 2 {
+			continue
+		}
+		overlap.CanonicalIndex = canonical
+		overlap.Topic = overlapTopic(units[members[canonical]].text)
+		overlaps = append(overlaps, overlap)
+	}
+
+	sort.Slice(overlaps, func(first, second int) bool {
+		if len(overlaps[first].Occurrences) != len(overlaps[second].Occurrences) {
+			return len(overlaps[first].Occurrences) > len(overlaps[second].Occurrences)
+		}
+		return overlaps[first].Topic < overlaps[second].Topic
+	})
+	return overlaps
+}
+
+// clearerOccurrence reports whether candidate explains the material better than current: written pages beat
+// spoken explanations, then the passage with more text wins
+func clearerOccurrence(candidate overlapUnit, current overlapUnit) bool {
+	candidateIsPage := candidate.occurrence.SourceType == "document"
+	currentIsPage := current.occurrence.SourceType == "document"
+	if candidateIsPage != currentIsPage {
+		return candidateIsPage
+	}
+	return len(candidate.shingles) > len(current.shingles)
+}
+
+// textShingles returns the hashes of the three-word phrases of a text, ignoring case, punctuation and markup
+func textShingles(text string) map[uint64]struct{} {
+	words := strings.FieldsFunc(strings.ToLower(text), func(character rune) bool {
+		return !unicode.IsLetter(character) && !unicode.IsDigit(character)
+	})
+	shingles := make(map[uint64]struct{})
+	for wordIndex := 0; wordIndex+3 <= len(words); wordIndex++ {
+		hasher := fnv.New64a()
+		hasher.Write([]byte(strings.Join(words[wordIndex:wordIndex+3], " ")))
+		shingles[hasher.Sum64()] = struct{}{}
+	}
+	return shingles
+}
+
+// overlapTopic names an overlap after the first heading or line of its clearest occurrence
+func overlapTopic(text string) string {
+	for _, line := range strings.Split(text, "\n") {
+		line = strings.TrimSpace(strings.TrimLeft(strings.TrimSpace(line), "#*-• "))
+		if line != "" {
+			return overlapExcerpt(line, 80)
+		}
+	}
+	return ""
+}
+
+// overlapExcerpt collapses the whitespace of text and cuts it to at most limit characters
+func overlapExcerpt(text string, limit int) string {
+	text = strings.Join(strings.Fields(text), " ")
+	if runes := []rune(text); len(runes) > limit {
+		return strings.TrimSpace(string(runes[:limit])) + "…"
+	}
+	return text
+}
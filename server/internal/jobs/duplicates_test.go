@@ -0,0 +1,70 @@
+package jobs
+
+import (
+	"context"
+	"encoding/json"
+	"path/filepath"
+	"testing"
+
+	"lectures/internal/database"
+	"lectures/internal/models"
+)
+
+func TestJob_AnalyzeDuplicates(t *testing.T) {
+	tempDir := t.TempDir()
+	db, err := database.Initialize(filepath.Join(tempDir, "test.db"))
+	if err != nil {
+		t.Fatalf("Failed to init DB: %v", err)
+	}
+	defer db.Close()
+
+	slide := "# Krebs Cycle\n\nThe citric acid cycle oxidizes acetyl CoA to carbon dioxide in the mitochondrial matrix, producing NADH, FADH2 and one GTP per turn, which feed the electron transport chain."
+	_, _ = db.Exec("INSERT INTO users (id, username, password_hash) VALUES ('dup-user', 'tester', 'hash')")
+	_, _ = db.Exec("INSERT INTO exams (id, user_id, title) VALUES ('dup-exam', 'dup-user', 'Biochemistry')")
+	_, _ = db.Exec("INSERT INTO lectures (id, exam_id, title, created_at) VALUES ('dup-first', 'dup-exam', 'Metabolism', '2026-01-01'), ('dup-second', 'dup-exam', 'Respiration', '2026-01-08')")
+	_, _ = db.Exec(`INSERT INTO reference_documents (id, lecture_id, document_type, title, file_path, page_count, extraction_status) VALUES
+		('dup-slides-1', 'dup-first', 'pdf', 'Metabolism slides', '/tmp/a.pdf', 2, 'completed'),
+		('dup-slides-2', 'dup-second', 'pdf', 'Respiration slides', '/tmp/b.pdf', 1, 'completed')`)
+	_, _ = db.Exec("INSERT INTO reference_pages (document_id, page_number, image_path, extracted_text) VALUES ('dup-slides-1', 1, 'a1.png', ?)", slide)
+	_, _ = db.Exec("INSERT INTO reference_pages (document_id, page_number, image_path, extracted_text) VALUES ('dup-slides-1', 2, 'a2.png', 'Glycolysis splits glucose into two pyruvate molecules in the cytosol and yields a net gain of two ATP and two NADH for every glucose molecule.')")
+	_, _ = db.Exec("INSERT INTO reference_pages (document_id, page_number, image_path, extracted_text) VALUES ('dup-slides-2', 4, 'b4.png', ?)", slide+" Recap from last week.")
+	_, _ = db.Exec("INSERT INTO transcripts (id, lecture_id, status) VALUES ('dup-transcript', 'dup-second', 'completed')")
+	_, _ = db.Exec("INSERT INTO transcript_segments (transcript_id, start_millisecond, end_millisecond, text) VALUES ('dup-transcript', 60000, 90000, 'So remember, the citric acid cycle oxidizes acetyl CoA to carbon dioxide in the mitochondrial matrix, producing NADH, FADH2 and one GTP per turn.')")
+
+	jobQueue := NewQueue(db, 1)
+	RegisterHandlers(jobQueue, db, nil, nil, nil, nil, &MockMarkdownConverter{}, nil, nil)
+	job := &models.Job{ID: "dup-job", Type: models.JobTypeAnalyzeDuplicates, Payload: `{"exam_id": "dup-exam"}`}
+	if err := jobQueue.handlers[models.JobTypeAnalyzeDuplicates](context.Background(), job, func(int, string, any, models.JobMetrics) {}); err != nil {
+		t.Fatalf("Job failed: %v", err)
+	}
+
+	var topic, occurrencesJSON string
+	var canonicalIndex, overlapCount int
+	db.QueryRow("SELECT COUNT(*) FROM content_overlaps WHERE exam_id = 'dup-exam'").Scan(&overlapCount)
+	db.QueryRow("SELECT topic, occurrences, canonical_index FROM content_overlaps WHERE exam_id = 'dup-exam'").Scan(&topic, &occurrencesJSON, &canonicalIndex)
+	if overlapCount != 1 {
+		t.Fatalf("Expected a single overlap, got %d", overlapCount)
+	}
+
+	var occurrences []models.ContentOccurrence
+	json.Unmarshal([]byte(occurrencesJSON), &occurrences)
+	if len(occurrences) != 3 {
+		t.Fatalf("Expected both slides and the transcript, got %s", occurrencesJSON)
+	}
+	canonical := occurrences[canonicalIndex]
+	if canonical.DocumentID != "dup-slides-2" || canonical.PageNumber != 4 || topic != "Krebs Cycle" {
+		t.Errorf("Expected the fuller slide to be cited as %q, got %+v as %q", "Krebs Cycle", canonical, topic)
+	}
+	if occurrences[2].SourceType != "transcript" || occurrences[2].StartMillisecond != 60000 || occurrences[2].LectureID != "dup-second" {
+		t.Errorf("Unexpected transcript occurrence %+v", occurrences[2])
+	}
+
+	// Running the analysis again replaces the previous results
+	if err := jobQueue.handlers[models.JobTypeAnalyzeDuplicates](context.Background(), job, func(int, string, any, models.JobMetrics) {}); err != nil {
+		t.Fatalf("Second run failed: %v", err)
+	}
+	db.QueryRow("SELECT COUNT(*) FROM content_overlaps WHERE exam_id = 'dup-exam'").Scan(&overlapCount)
+	if overlapCount != 1 {
+		t.Errorf("Expected the overlaps to be replaced, got %d", overlapCount)
+	}
+}
@@ -0,0 +1,19 @@
+package jobs
+
+import (
+	"database/sql"
+	"log/slog"
+
+	"lectures/internal/database"
+	"lectures/internal/models"
+)
+
+// examGenerationDefaults returns the tool-generation defaults of an exam. Generation goes on with the global
+// configuration when they cannot be read
+func examGenerationDefaults(db *sql.DB, examID string) models.ExamGenerationDefaults {
+	defaults, err := database.GetExamGenerationDefaults(db, examID)
+	if err != nil {
+		slog.Warn("Failed to read exam generation defaults", "examID", examID, "error", err)
+	}
+	return defaults
+}
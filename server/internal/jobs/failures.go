@@ -0,0 +1,118 @@
+package jobs
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"net"
+	"strings"
+
+	"lectures/internal/llm"
+	"lectures/internal/models"
+)
+
+// JobError is returned by handlers that know why they failed, and overrides the classification of the error
+// it wraps
+type JobError struct {
+	Failure models.JobFailure
+	Err     error
+}
+
+func (jobError *JobError) Error() string {
+	return jobError.Err.Error()
+}
+
+func (jobError *JobError) Unwrap() error {
+	return jobError.Err
+}
+
+// jobFailureRule classifies the errors whose text contains one of its fragments
+type jobFailureRule struct {
+	fragments []string
+	failure   models.JobFailure
+}
+
+// jobFailureRules are tried in order on the lowercase error text, so dependency checks come before the generic
+// "not found"
+var jobFailureRules = []jobFailureRule{
+	{
+		fragments: []string{"failed to unmarshal job payload", "invalid payload", "is required"},
+		failure: models.JobFailure{Code: models.JobFailureInvalidRequest, Action: models.JobActionCheckInput,
+			Message: "The task was started with incomplete or invalid options."},
+	},
+	{
+		fragments: []string{"ffmpeg not found", "ffprobe not found", "ffmpeg dependency check failed", "yt-dlp not found"},
+		failure: models.JobFailure{Code: models.JobFailureMissingDependency, Action: models.JobActionContactAdmin,
+			Message: "A tool the server needs for this task is not installed."},
+	},
+	{
+		fragments: []string{"api key", "insufficient credits", "not configured", "is not installed", "no llm provider found", "status 401", "status 402", "status 403"},
+		failure: models.JobFailure{Code: models.JobFailureProviderRejected, Action: models.JobActionContactAdmin,
+			Message: "The AI provider refused the request. The server's provider settings need attention."},
+	},
+	{
+		fragments: []string{"status 429", "rate limit", "too many requests"},
+		failure: models.JobFailure{Code: models.JobFailureRateLimited, Retryable: true, Action: models.JobActionRetryLater,
+			Message: "The AI provider is receiving too many requests. Try again in a few minutes."},
+	},
+	{
+		fragments: []string{"not reachable", "connection refused", "no such host", "unavailable (status", "status 500", "status 502", "status 503", "status 504"},
+		failure: models.JobFailure{Code: models.JobFailureProviderUnavailable, Retryable: true, Action: models.JobActionRetryLater,
+			Message: "The AI or transcription provider could not be reached. Try again in a few minutes."},
+	},
+	{
+		fragments: []string{"ffmpeg", "ffprobe", "failed to decode", "unsupported format", "unsupported file"},
+		failure: models.JobFailure{Code: models.JobFailureMediaUnreadable, Action: models.JobActionCheckInput,
+			Message: "The uploaded file could not be read. Check that it is not damaged and is in a supported format."},
+	},
+	{
+		fragments: []string{"not found", "no such file"},
+		failure: models.JobFailure{Code: models.JobFailureNotFound, Action: models.JobActionCheckInput,
+			Message: "Something the task needs was deleted or never finished processing."},
+	},
+}
+
+// classifyJobFailure describes the error a handler returned. Errors nothing is known about are reported as
+// internal and retryable, since most of them come from transient conditions
+func classifyJobFailure(err error) models.JobFailure {
+	var jobError *JobError
+	if errors.As(err, &jobError) {
+		return jobError.Failure
+	}
+
+	if errors.Is(err, ErrBudgetExceeded) {
+		return budgetFailure
+	}
+
+	var overflowError *llm.ContextOverflowError
+	if errors.As(err, &overflowError) {
+		return models.JobFailure{Code: models.JobFailurePromptTooLarge, Action: models.JobActionReduceInput,
+			Message: "The material is too long for the selected model. Select fewer lectures or documents, or a model with a larger context window."}
+	}
+	if errors.Is(err, context.DeadlineExceeded) {
+		return models.JobFailure{Code: models.JobFailureTimeout, Retryable: true, Action: models.JobActionRetry,
+			Message: "The task took too long and was stopped."}
+	}
+	if errors.Is(err, sql.ErrNoRows) {
+		return models.JobFailure{Code: models.JobFailureNotFound, Action: models.JobActionCheckInput,
+			Message: "Something the task needs was deleted or never finished processing."}
+	}
+
+	errorText := strings.ToLower(err.Error())
+	for _, rule := range jobFailureRules {
+		for _, fragment := range rule.fragments {
+			if strings.Contains(errorText, fragment) {
+				return rule.failure
+			}
+		}
+	}
+
+	var networkError net.Error
+	if errors.As(err, &networkError) {
+		return models.JobFailure{Code: models.JobFailureProviderUnavailable, Retryable: true, Action: models.JobActionRetryLater,
+			Message: "The AI or transcription provider could not be reached. Try again in a few minutes."}
+	}
+
+	return models.JobFailure{Code: models.JobFailureInternal, Retryable: true, Action: models.JobActionRetry,
+		Message: "The task failed unexpectedly."}
+}
@@ -0,0 +1,89 @@
+package jobs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+
+	"lectures/internal/llm"
+	"lectures/internal/models"
+)
+
+func TestClassifyJobFailure(t *testing.T) {
+	testCases := []struct {
+		name              string
+		err               error
+		expectedCode      string
+		expectedRetryable bool
+		expectedAction    string
+	}{
+		{"Invalid payload", fmt.Errorf("failed to unmarshal job payload: %w", errors.New("unexpected end of JSON input")), models.JobFailureInvalidRequest, false, models.JobActionCheckInput},
+		{"Missing ffmpeg", fmt.Errorf("ffmpeg dependency check failed: %w", errors.New("ffmpeg not found")), models.JobFailureMissingDependency, false, models.JobActionContactAdmin},
+		{"Rejected key", errors.New("OpenRouter rejected the API key (check providers.openrouter.api_key)"), models.JobFailureProviderRejected, false, models.JobActionContactAdmin},
+		{"Rate limited", errors.New("deepgram returned status 429: Too Many Requests"), models.JobFailureRateLimited, true, models.JobActionRetryLater},
+		{"Provider down", fmt.Errorf("transcription failed: %w", errors.New("whisper returned status 503: upstream")), models.JobFailureProviderUnavailable, true, models.JobActionRetryLater},
+		{"Damaged media", errors.New("ffmpeg extract failed: exit status 1, stderr: Invalid data found"), models.JobFailureMediaUnreadable, false, models.JobActionCheckInput},
+		{"Prompt too large", fmt.Errorf("failed to generate section: %w", &llm.ContextOverflowError{Model: "small", EstimatedTokens: 9000, ContextWindow: 4096}), models.JobFailurePromptTooLarge, false, models.JobActionReduceInput},
+		{"Timeout", fmt.Errorf("request failed: %w", context.DeadlineExceeded), models.JobFailureTimeout, true, models.JobActionRetry},
+		{"Deleted lecture", errors.New("lecture not found"), models.JobFailureNotFound, false, models.JobActionCheckInput},
+		{"Unknown", errors.New("something odd happened"), models.JobFailureInternal, true, models.JobActionRetry},
+		{"Explicit", fmt.Errorf("wrapped: %w", &JobError{Failure: models.JobFailure{Code: models.JobFailureInvalidRequest, Action: models.JobActionCheckInput}, Err: errors.New("status 503")}), models.JobFailureInvalidRequest, false, models.JobActionCheckInput},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			failure := classifyJobFailure(testCase.err)
+			if failure.Code != testCase.expectedCode || failure.Retryable != testCase.expectedRetryable || failure.Action != testCase.expectedAction {
+				t.Errorf("Expected %s (retryable %v, %s), got %+v", testCase.expectedCode, testCase.expectedRetryable, testCase.expectedAction, failure)
+			}
+		})
+	}
+}
+
+func TestQueue_FailureIsRecorded(t *testing.T) {
+	queue, cleanup := setupQueueTestDatabase(t)
+	defer cleanup()
+
+	jobID, _ := queue.Enqueue("user-1", models.JobTypeTranscribeMedia, map[string]string{}, "", "")
+	_, _ = queue.database.Exec("UPDATE jobs SET status = ?, progress_message_text = ?, pause_requested = 1 WHERE id = ?", models.JobStatusRunning, "Transcribing audio...", jobID)
+
+	updates := queue.Subscribe(jobID)
+	defer queue.Unsubscribe(jobID, updates)
+
+	queue.failJob(jobID, "whisper returned status 503: upstream", classifyJobFailure(errors.New("whisper returned status 503: upstream")))
+
+	job, err := queue.GetJob(jobID)
+	if err != nil {
+		t.Fatalf("GetJob failed: %v", err)
+	}
+	if job.Failure == nil {
+		t.Fatal("Expected the failure to be stored with the job")
+	}
+	if job.Failure.Code != models.JobFailureProviderUnavailable || job.Failure.Phase != "Transcribing audio..." {
+		t.Errorf("Unexpected failure %+v", job.Failure)
+	}
+	if job.Error != "whisper returned status 503: upstream" {
+		t.Errorf("Expected the raw error to be kept, got %q", job.Error)
+	}
+	if job.PauseRequested {
+		t.Error("Expected the pause request to be withdrawn from the failed job")
+	}
+
+	update := <-updates
+	if update.Failure == nil || update.Failure.Code != models.JobFailureProviderUnavailable {
+		t.Errorf("Expected the failure in the job update, got %+v", update.Failure)
+	}
+
+	t.Run("Interrupted by restart", func(t *testing.T) {
+		runningJobID, _ := queue.Enqueue("user-1", models.JobTypeSuggest, map[string]string{}, "", "")
+		_, _ = queue.database.Exec("UPDATE jobs SET status = ?, progress_message_text = ? WHERE id = ?", models.JobStatusRunning, "Writing suggestions...", runningJobID)
+
+		queue.recoverJobs()
+
+		job, _ := queue.GetJob(runningJobID)
+		if job.Failure == nil || job.Failure.Code != models.JobFailureInterrupted || !job.Failure.Retryable || job.Failure.Phase != "Writing suggestions..." {
+			t.Errorf("Unexpected failure for an interrupted job: %+v", job.Failure)
+		}
+	})
+}
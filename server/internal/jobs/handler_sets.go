@@ -0,0 +1,109 @@
+package jobs
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"slices"
+	"sort"
+
+	"lectures/internal/configuration"
+	"lectures/internal/models"
+)
+
+// Handler sets of jobs.handlers.set
+const (
+	HandlerSetDefault = "default"
+	HandlerSetMinimal = "minimal"
+	HandlerSetCustom  = "custom"
+)
+
+// ErrJobTypeDisabled is wrapped by the errors of jobs refused because the handler set of the server leaves their
+// type out
+var ErrJobTypeDisabled = errors.New("job type is disabled")
+
+// builtinJobTypes are the job types RegisterHandlers provides
+var builtinJobTypes = []string{
+	models.JobTypeTranscribeMedia,
+	models.JobTypeIngestDocuments,
+	models.JobTypeIngestURL,
+	models.JobTypeImportYouTube,
+	models.JobTypeBuildMaterial,
+	models.JobTypeDownloadGoogleDrive,
+	models.JobTypeSuggest,
+	models.JobTypePolishTranscript,
+	models.JobTypePublishMaterial,
+	models.JobTypePublishBundle,
+	models.JobTypeGenerateRecap,
+	models.JobTypeAnalyzeDuplicates,
+	models.JobTypeBackup,
+	models.JobTypeRedactMedia,
+	models.JobTypeImportSyllabus,
+}
+
+// minimalJobTypes turn uploaded lectures into materials and exports, leaving out imports from other services,
+// recaps and duplicate analyses
+var minimalJobTypes = []string{
+	models.JobTypeTranscribeMedia,
+	models.JobTypeIngestDocuments,
+	models.JobTypeBuildMaterial,
+	models.JobTypeSuggest,
+	models.JobTypePolishTranscript,
+	models.JobTypePublishMaterial,
+	models.JobTypePublishBundle,
+	models.JobTypeBackup,
+	models.JobTypeRedactMedia,
+}
+
+// SetHandlerSet restricts the job types the queue runs to those of a handler set. Handlers of other types are
+// not registered and their jobs are refused when enqueued. It must be called before RegisterHandlers; without
+// it every type runs, including those registered by third parties
+func (queue *Queue) SetHandlerSet(handlersConfiguration configuration.JobHandlersConfiguration) error {
+	var enabledJobTypes []string
+	switch handlersConfiguration.Set {
+	case "", HandlerSetDefault:
+	case HandlerSetMinimal:
+		enabledJobTypes = minimalJobTypes
+	case HandlerSetCustom:
+		if len(handlersConfiguration.Enabled) == 0 {
+			return fmt.Errorf("jobs.handlers.enabled must list the job types of the custom set")
+		}
+		enabledJobTypes = handlersConfiguration.Enabled
+	default:
+		return fmt.Errorf("unknown job handler set %q", handlersConfiguration.Set)
+	}
+
+	for _, jobType := range slices.Concat(handlersConfiguration.Enabled, handlersConfiguration.Disabled) {
+		if !slices.Contains(builtinJobTypes, jobType) {
+			slog.Warn("Job handler configuration names a job type without a built-in handler", "type", jobType)
+		}
+	}
+
+	queue.enabledJobTypes = nil
+	if enabledJobTypes != nil {
+		queue.enabledJobTypes = make(map[string]bool)
+		for _, jobType := range enabledJobTypes {
+			queue.enabledJobTypes[jobType] = true
+		}
+	}
+	queue.disabledJobTypes = make(map[string]bool)
+	for _, jobType := range handlersConfiguration.Disabled {
+		queue.disabledJobTypes[jobType] = true
+	}
+	return nil
+}
+
+// IsJobTypeEnabled reports whether the handler set of the queue runs a job type
+func (queue *Queue) IsJobTypeEnabled(jobType string) bool {
+	return !queue.disabledJobTypes[jobType] && (queue.enabledJobTypes == nil || queue.enabledJobTypes[jobType])
+}
+
+// JobTypes lists the job types the queue has handlers for, sorted
+func (queue *Queue) JobTypes() []string {
+	jobTypes := make([]string, 0, len(queue.handlers))
+	for jobType := range queue.handlers {
+		jobTypes = append(jobTypes, jobType)
+	}
+	sort.Strings(jobTypes)
+	return jobTypes
+}
@@ -0,0 +1,74 @@
+package jobs
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"testing"
+
+	"lectures/internal/configuration"
+	"lectures/internal/models"
+)
+
+func TestQueue_HandlerSet(t *testing.T) {
+	queue, cleanup := setupQueueTestDatabase(t)
+	defer cleanup()
+	noopHandler := func(jobContext context.Context, job *models.Job, updateProgress func(int, string, any, models.JobMetrics)) error {
+		return nil
+	}
+	registerAll := func() {
+		queue.handlers = make(map[string]JobHandler)
+		for _, jobType := range append(slices.Clone(builtinJobTypes), "PLUGIN") {
+			queue.RegisterHandler(jobType, noopHandler)
+		}
+	}
+
+	t.Run("The default set runs every type", func(t *testing.T) {
+		if err := queue.SetHandlerSet(configuration.JobHandlersConfiguration{}); err != nil {
+			t.Fatalf("SetHandlerSet failed: %v", err)
+		}
+		registerAll()
+		if len(queue.JobTypes()) != len(builtinJobTypes)+1 {
+			t.Errorf("Expected every handler registered, got %v", queue.JobTypes())
+		}
+	})
+
+	t.Run("The minimal set leaves out imports, recaps and third-party types", func(t *testing.T) {
+		if err := queue.SetHandlerSet(configuration.JobHandlersConfiguration{Set: HandlerSetMinimal, Disabled: []string{models.JobTypeBackup}}); err != nil {
+			t.Fatalf("SetHandlerSet failed: %v", err)
+		}
+		registerAll()
+		jobTypes := queue.JobTypes()
+		if !slices.Contains(jobTypes, models.JobTypeTranscribeMedia) || slices.Contains(jobTypes, models.JobTypeImportYouTube) ||
+			slices.Contains(jobTypes, models.JobTypeGenerateRecap) || slices.Contains(jobTypes, models.JobTypeBackup) || slices.Contains(jobTypes, "PLUGIN") {
+			t.Errorf("Expected the minimal set without backups, got %v", jobTypes)
+		}
+
+		_, err := queue.Enqueue("user-1", models.JobTypeImportYouTube, map[string]string{}, "", "")
+		if !errors.Is(err, ErrJobTypeDisabled) {
+			t.Errorf("Expected a disabled type to be refused, got %v", err)
+		}
+		if _, err := queue.Enqueue("user-1", models.JobTypeTranscribeMedia, map[string]string{}, "", ""); err != nil {
+			t.Errorf("Expected an enabled type to be queued, got %v", err)
+		}
+	})
+
+	t.Run("A custom set runs the types it lists", func(t *testing.T) {
+		if err := queue.SetHandlerSet(configuration.JobHandlersConfiguration{Set: HandlerSetCustom, Enabled: []string{models.JobTypeSuggest, "PLUGIN"}}); err != nil {
+			t.Fatalf("SetHandlerSet failed: %v", err)
+		}
+		registerAll()
+		if jobTypes := queue.JobTypes(); !slices.Equal(jobTypes, []string{"PLUGIN", models.JobTypeSuggest}) {
+			t.Errorf("Expected the listed types only, got %v", jobTypes)
+		}
+	})
+
+	t.Run("Invalid sets are refused", func(t *testing.T) {
+		if err := queue.SetHandlerSet(configuration.JobHandlersConfiguration{Set: HandlerSetCustom}); err == nil {
+			t.Error("Expected a custom set without types to be refused")
+		}
+		if err := queue.SetHandlerSet(configuration.JobHandlersConfiguration{Set: "everything"}); err == nil {
+			t.Error("Expected an unknown set to be refused")
+		}
+	})
+}
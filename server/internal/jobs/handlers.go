@@ -5,13 +5,16 @@ import (
 	"context"
 	"database/sql"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"log/slog"
+	"mime"
 	"mime/multipart"
 	"net/http"
 	"os"
 	"path/filepath"
+	"sort"
 	"strconv"
 	"strings"
 	"sync"
@@ -20,7 +23,10 @@ import (
 	"lectures/internal/configuration"
 	"lectures/internal/documents"
 	"lectures/internal/markdown"
+	"lectures/internal/media"
 	"lectures/internal/models"
+	"lectures/internal/prompts"
+	"lectures/internal/storage"
 	"lectures/internal/tools"
 	"lectures/internal/transcription"
 
@@ -51,6 +57,31 @@ func sanitizeFilename(name string) string {
 	return result
 }
 
+// lectureDocumentNames returns the titles and original filenames of a lecture's reference documents, the names
+// its citations are expected to use
+func lectureDocumentNames(database *sql.DB, lectureID string) []string {
+	rows, err := database.Query("SELECT title, original_filename FROM reference_documents WHERE lecture_id = ? ORDER BY created_at ASC", lectureID)
+	if err != nil {
+		slog.Warn("Failed to list lecture documents for citation correction", "lectureID", lectureID, "error", err)
+		return nil
+	}
+	defer rows.Close()
+
+	var names []string
+	for rows.Next() {
+		var title string
+		var originalFilename sql.NullString
+		if rows.Scan(&title, &originalFilename) != nil {
+			continue
+		}
+		names = append(names, title)
+		if originalFilename.Valid && originalFilename.String != "" && originalFilename.String != title {
+			names = append(names, originalFilename.String)
+		}
+	}
+	return names
+}
+
 func formatTimestamp(ms int64) string {
 	totalSeconds := ms / 1000
 	minutes := totalSeconds / 60
@@ -58,6 +89,128 @@ func formatTimestamp(ms int64) string {
 	return fmt.Sprintf("%02d:%02d", minutes, seconds)
 }
 
+// writeHTMLExport writes converted HTML content in one of the HTML-based export formats, PDF being the default
+func writeHTMLExport(markdownConverter markdown.MarkdownConverter, htmlContent string, format string, outputPath string, options markdown.ConversionOptions) error {
+	switch format {
+	case "docx":
+		return markdownConverter.HTMLToDocx(htmlContent, outputPath, options)
+	case "epub":
+		return markdownConverter.HTMLToEPUB(htmlContent, outputPath, options)
+	case "html":
+		return markdownConverter.SaveHTML(htmlContent, outputPath, options)
+	default:
+		return markdownConverter.HTMLToPDF(htmlContent, outputPath, options)
+	}
+}
+
+// parseOptionalInt reads an integer of a job payload, nil when the payload leaves it empty
+func parseOptionalInt(value string) *int {
+	parsed, err := strconv.Atoi(value)
+	if err != nil {
+		return nil
+	}
+	return &parsed
+}
+
+// reportModelLoading returns a callback that shows the "loading model" state while a cold model is warmed up,
+// so a slow first job does not look hung
+func reportModelLoading(updateProgress func(int, string, any, models.JobMetrics)) func(string) {
+	return func(model string) {
+		updateProgress(0, "Loading model "+model+"...", map[string]any{"phase": "loading_model", "model": model}, models.JobMetrics{})
+	}
+}
+
+// ingestReferenceDocument extracts the pages of a reference document and stores them, with their image data and
+// semantic chunks, marking the document completed. A document the processor fails on is marked failed
+func ingestReferenceDocument(jobContext context.Context, database *sql.DB, objectStore storage.ObjectStore, documentProcessor *documents.Processor, jobID string, document models.ReferenceDocument, languageCode string) (models.JobMetrics, error) {
+	if _, err := database.Exec("UPDATE reference_documents SET extraction_status = ?, updated_at = ? WHERE id = ?", "processing", time.Now(), document.ID); err != nil {
+		return models.JobMetrics{}, fmt.Errorf("failed to update document status: %w", err)
+	}
+
+	// Page images are rendered to a temp directory and moved into the database
+	outputDir := filepath.Join(os.TempDir(), "lectures-documents", jobID, document.ID, "pages")
+
+	pages, documentMetrics, processingError := documentProcessor.ProcessDocument(jobContext, document, outputDir, languageCode, func(progress int, message string) {
+		// Sub-progress of individual documents is not reported to avoid flooding
+	})
+	if processingError != nil {
+		os.RemoveAll(outputDir)
+		extractionStatus := "failed"
+		if errors.Is(processingError, models.ErrJobPaused) {
+			extractionStatus = "pending"
+		}
+		database.Exec("UPDATE reference_documents SET extraction_status = ?, updated_at = ? WHERE id = ?", extractionStatus, time.Now(), document.ID)
+		return documentMetrics, fmt.Errorf("document processor failed for %s: %w", document.Title, processingError)
+	}
+
+	if err := storeReferencePages(jobContext, database, objectStore, documentProcessor, document, pages, documentMetrics); err != nil {
+		os.RemoveAll(outputDir)
+		return documentMetrics, err
+	}
+	return documentMetrics, nil
+}
+
+// storeReferencePages replaces the pages and chunks of a document in one transaction; image data goes into BLOBs,
+// or the object store when one is configured
+func storeReferencePages(jobContext context.Context, database *sql.DB, objectStore storage.ObjectStore, documentProcessor *documents.Processor, document models.ReferenceDocument, pages []models.ReferencePage, documentMetrics models.JobMetrics) error {
+	documentID := document.ID
+	tx, err := database.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	tx.Exec("DELETE FROM reference_pages WHERE document_id = ?", documentID)
+	for _, currentPage := range pages {
+		imageData, readErr := os.ReadFile(currentPage.ImagePath)
+		if readErr != nil {
+			return fmt.Errorf("failed to read page image for DB storage: %w (path: %s)", readErr, currentPage.ImagePath)
+		}
+		imageSize := len(imageData)
+		imageData, objectKey, storeErr := storage.StoreContent(jobContext, objectStore, storage.PageImageObjectKey(document.LectureID, documentID, currentPage.PageNumber), imageData, "image/png")
+		if storeErr != nil {
+			return storeErr
+		}
+		// Store a logical path (just the filename) — not a disk path
+		logicalImagePath := filepath.Base(currentPage.ImagePath)
+		var pageMetadata any
+		if currentPage.Metadata != nil {
+			metadataJSON, _ := json.Marshal(currentPage.Metadata)
+			pageMetadata = string(metadataJSON)
+		}
+		_, err = tx.Exec(`
+			INSERT INTO reference_pages (document_id, page_number, image_path, extracted_text, image_data, object_key, size_bytes, metadata, extraction_source, language)
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, NULLIF(?, ''))
+		`, documentID, currentPage.PageNumber, logicalImagePath, currentPage.ExtractedText, imageData, objectKey, imageSize, pageMetadata, currentPage.ExtractionSource, currentPage.Language)
+		if err != nil {
+			return fmt.Errorf("failed to insert page: %w", err)
+		}
+	}
+
+	// Split the extracted text into overlapping semantic chunks for generation and chat
+	tx.Exec("DELETE FROM reference_chunks WHERE document_id = ?", documentID)
+	for _, chunk := range documentProcessor.ChunkPages(documentID, pages) {
+		_, err = tx.Exec(`
+			INSERT INTO reference_chunks (document_id, chunk_index, start_page, end_page, heading, content)
+			VALUES (?, ?, ?, ?, ?, ?)
+		`, documentID, chunk.ChunkIndex, chunk.StartPage, chunk.EndPage, chunk.Heading, chunk.Content)
+		if err != nil {
+			return fmt.Errorf("failed to insert chunk: %w", err)
+		}
+	}
+
+	extractionMetadata, _ := json.Marshal(documentProcessor.SummarizeExtraction(document, pages))
+	_, err = tx.Exec("UPDATE reference_documents SET extraction_status = ?, extraction_metadata = ?, detected_language = NULLIF(?, ''), page_count = ?, estimated_cost = ?, updated_at = ? WHERE id = ?", "completed", string(extractionMetadata), documents.DominantLanguage(pages), len(pages), documentMetrics.EstimatedCost, time.Now(), documentID)
+	if err != nil {
+		return fmt.Errorf("failed to finalize document status: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return nil
+}
+
 // RegisterHandlers registers all standard job handlers
 func RegisterHandlers(
 	queue *Queue,
@@ -70,9 +223,12 @@ func RegisterHandlers(
 	checkReadiness func(*sql.DB, string),
 	broadcast func(string, string, any),
 ) {
+	queue.configuration = config
+
 	queue.RegisterHandler(models.JobTypeTranscribeMedia, func(jobContext context.Context, job *models.Job, updateProgress func(int, string, any, models.JobMetrics)) error {
 		var payload struct {
 			LectureID string `json:"lecture_id"`
+			Diarize   *bool  `json:"diarize"` // Defaults to transcription.diarize
 		}
 		if unmarshalingError := json.Unmarshal([]byte(job.Payload), &payload); unmarshalingError != nil {
 			return fmt.Errorf("failed to unmarshal job payload: %w", unmarshalingError)
@@ -84,7 +240,7 @@ func RegisterHandlers(
 
 		// 1. Get lecture media files in order, including BLOB data
 		mediaRows, databaseError := database.Query(`
-			SELECT id, lecture_id, media_type, sequence_order, file_path, created_at, file_data
+			SELECT id, lecture_id, media_type, sequence_order, file_path, created_at, file_data, COALESCE(object_key, '')
 			FROM lecture_media
 			WHERE lecture_id = ?
 			ORDER BY sequence_order ASC
@@ -102,16 +258,23 @@ func RegisterHandlers(
 		for mediaRows.Next() {
 			var media models.LectureMedia
 			var fileData []byte
-			if scanningError := mediaRows.Scan(&media.ID, &media.LectureID, &media.MediaType, &media.SequenceOrder, &media.FilePath, &media.CreatedAt, &fileData); scanningError != nil {
+			var objectKey string
+			if scanningError := mediaRows.Scan(&media.ID, &media.LectureID, &media.MediaType, &media.SequenceOrder, &media.FilePath, &media.CreatedAt, &fileData, &objectKey); scanningError != nil {
 				return fmt.Errorf("failed to scan media file: %w", scanningError)
 			}
-			// Restore media file from DB BLOB to temp dir for processing
+			fileData, loadingError := storage.LoadContent(jobContext, queue.objectStore, fileData, objectKey)
+			if loadingError != nil {
+				return fmt.Errorf("failed to load media file: %w", loadingError)
+			}
+			// Restore media file from DB BLOB or object store to temp dir for processing
 			if len(fileData) > 0 {
 				tempPath := filepath.Join(mediaTempDir, filepath.Base(media.FilePath))
 				if writeErr := os.WriteFile(tempPath, fileData, 0644); writeErr != nil {
 					return fmt.Errorf("failed to restore media file from DB: %w", writeErr)
 				}
 				media.FilePath = tempPath
+			} else {
+				media.FilePath = storage.NewLayout(config.Storage).ResolvePath(media.FilePath)
 			}
 			mediaFiles = append(mediaFiles, media)
 		}
@@ -149,8 +312,27 @@ func RegisterHandlers(
 		}
 		defer os.RemoveAll(temporaryDirectory)
 
-		// 4. Run transcription
-		segments, totalMetrics, transcriptionError := transcriptionService.TranscribeLecture(jobContext, mediaFiles, temporaryDirectory, func(progress int, message string, metadata any) {
+		// 4. Check the provider and load cold models first, so a misconfiguration fails the job right away
+		if preparationError := transcriptionService.Prepare(jobContext, reportModelLoading(updateProgress)); preparationError != nil {
+			database.Exec("UPDATE transcripts SET status = ?, updated_at = ? WHERE id = ?", "failed", time.Now(), transcriptID)
+			database.Exec("UPDATE lectures SET status = ?, updated_at = ? WHERE id = ?", "failed", time.Now(), payload.LectureID)
+			return fmt.Errorf("transcription preflight failed: %w", preparationError)
+		}
+
+		// 5. Run transcription, hinting the lecture language to providers that support it
+		var lectureLanguage sql.NullString
+		database.QueryRow("SELECT language FROM lectures WHERE id = ?", payload.LectureID).Scan(&lectureLanguage)
+		transcriptionContext := transcription.WithLanguageHint(jobContext, lectureLanguage.String)
+
+		diarize := config.Transcription.Diarize
+		if payload.Diarize != nil {
+			diarize = *payload.Diarize
+		}
+		transcriptionContext = transcription.WithDiarization(transcriptionContext, diarize)
+
+		// Completed chunks are checkpointed, so a failed or cancelled run picks up where it stopped when re-enqueued
+		chunkStore := &transcriptChunkStore{database: database, transcriptID: transcriptID}
+		segments, detectedLanguage, totalMetrics, transcriptionError := transcriptionService.TranscribeLecture(transcriptionContext, mediaFiles, temporaryDirectory, chunkStore, func(progress int, message string, metadata any) {
 			updateProgress(progress, "Transcribing media files...", metadata, models.JobMetrics{})
 		})
 		if transcriptionError != nil {
@@ -159,7 +341,14 @@ func RegisterHandlers(
 			return fmt.Errorf("transcription service failed: %w", transcriptionError)
 		}
 
-		// 5. Store segments in database
+		// A lecture without an explicit language adopts the detected one, so later jobs stop falling back to llm.language
+		if detectedLanguage != "" && lectureLanguage.String == "" {
+			if _, updateError := database.Exec("UPDATE lectures SET language = ?, updated_at = ? WHERE id = ?", detectedLanguage, time.Now(), payload.LectureID); updateError != nil {
+				slog.WarnContext(jobContext, "Failed to store detected lecture language", "lectureID", payload.LectureID, "error", updateError)
+			}
+		}
+
+		// 6. Store segments in database
 		databaseTransaction, transactionError := database.Begin()
 		if transactionError != nil {
 			return fmt.Errorf("failed to begin transaction: %w", transactionError)
@@ -182,7 +371,7 @@ func RegisterHandlers(
 			}
 		}
 
-		// 6. Update media file durations based on segment end times
+		// 7. Update media file durations based on segment end times
 		for _, media := range mediaFiles {
 			// Find the last segment for this media file
 			var lastEndTime int64
@@ -193,11 +382,11 @@ func RegisterHandlers(
 			`, media.ID).Scan(&lastEndTime)
 
 			if queryError != nil {
-				slog.Warn("Failed to query max segment end time", "media_id", media.ID, "error", queryError)
+				slog.WarnContext(jobContext, "Failed to query max segment end time", "media_id", media.ID, "error", queryError)
 				continue
 			}
 
-			slog.Info("Found media segment end time", "media_id", media.ID, "last_end_milliseconds", lastEndTime, "last_end_seconds", lastEndTime/1000)
+			slog.InfoContext(jobContext, "Found media segment end time", "media_id", media.ID, "last_end_milliseconds", lastEndTime, "last_end_seconds", lastEndTime/1000)
 
 			if lastEndTime > 0 {
 				_, updateError := databaseTransaction.Exec(`
@@ -207,25 +396,31 @@ func RegisterHandlers(
 				`, lastEndTime, media.ID)
 
 				if updateError != nil {
-					slog.Warn("Failed to update media duration", "media_id", media.ID, "error", updateError)
+					slog.WarnContext(jobContext, "Failed to update media duration", "media_id", media.ID, "error", updateError)
 				} else {
-					slog.Info("Updated media duration", "media_id", media.ID, "duration_milliseconds", lastEndTime, "duration_seconds", lastEndTime/1000)
+					slog.InfoContext(jobContext, "Updated media duration", "media_id", media.ID, "duration_milliseconds", lastEndTime, "duration_seconds", lastEndTime/1000)
 				}
 			} else {
-				slog.Warn("Media has no segments or zero duration", "media_id", media.ID)
+				slog.WarnContext(jobContext, "Media has no segments or zero duration", "media_id", media.ID)
 			}
 		}
 
-		// 7. Finalize transcript
+		// 8. Finalize transcript
 		_, executionError = databaseTransaction.Exec("UPDATE transcripts SET status = ?, estimated_cost = ?, updated_at = ? WHERE id = ?", "completed", totalMetrics.EstimatedCost, time.Now(), transcriptID)
 		if executionError != nil {
 			return fmt.Errorf("failed to finalize transcript status: %w", executionError)
 		}
 
+		// Checkpoints are only needed until the transcript is complete; a later re-transcription starts fresh
+		_, executionError = databaseTransaction.Exec("DELETE FROM transcript_chunks WHERE transcript_id = ?", transcriptID)
+		if executionError != nil {
+			return fmt.Errorf("failed to clear transcript chunks: %w", executionError)
+		}
+
 		// Update lecture cost (aggregate)
 		_, executionError = databaseTransaction.Exec("UPDATE lectures SET estimated_cost = estimated_cost + ?, updated_at = ? WHERE id = ?", totalMetrics.EstimatedCost, time.Now(), payload.LectureID)
 		if executionError != nil {
-			slog.Warn("Failed to update lecture estimated cost", "lectureID", payload.LectureID, "error", executionError)
+			slog.WarnContext(jobContext, "Failed to update lecture estimated cost", "lectureID", payload.LectureID, "error", executionError)
 		}
 
 		// Update exam cost (aggregate)
@@ -234,13 +429,16 @@ func RegisterHandlers(
 		if examID != "" {
 			_, executionError = databaseTransaction.Exec("UPDATE exams SET estimated_cost = estimated_cost + ?, updated_at = ? WHERE id = ?", totalMetrics.EstimatedCost, time.Now(), examID)
 			if executionError != nil {
-				slog.Warn("Failed to update exam estimated cost", "examID", examID, "error", executionError)
+				slog.WarnContext(jobContext, "Failed to update exam estimated cost", "examID", examID, "error", executionError)
 			}
 		}
 
 		if commitError := databaseTransaction.Commit(); commitError != nil {
 			return fmt.Errorf("failed to commit transaction: %w", commitError)
 		}
+		if redactionError := applyTranscriptRedactions(jobContext, database, queue.objectStore, payload.LectureID); redactionError != nil {
+			return redactionError
+		}
 
 		if checkReadiness != nil {
 			checkReadiness(database, payload.LectureID)
@@ -259,6 +457,7 @@ func RegisterHandlers(
 		var payload struct {
 			LectureID    string `json:"lecture_id"`
 			LanguageCode string `json:"language_code"`
+			DocumentID   string `json:"document_id"` // Re-ingests a single document; every document of the lecture when empty
 		}
 		if unmarshalingError := json.Unmarshal([]byte(job.Payload), &payload); unmarshalingError != nil {
 			return fmt.Errorf("failed to unmarshal job payload: %w", unmarshalingError)
@@ -268,12 +467,16 @@ func RegisterHandlers(
 			payload.LanguageCode = config.LLM.Language
 		}
 
+		// Pages read before a pause and the documents already stored are not read again when the job resumes
+		checkpoint := &jobCheckpoint{database: database, jobID: job.ID}
+		jobContext = documents.WithPageCheckpoint(jobContext, checkpoint)
+
 		// 1. Get reference documents for the lecture, including BLOB data
 		documentRows, databaseError := database.Query(`
-			SELECT id, lecture_id, document_type, title, file_path, page_count, extraction_status, created_at, updated_at, file_data
+			SELECT id, lecture_id, document_type, title, file_path, page_count, extraction_status, COALESCE(extraction_method, ''), COALESCE(language, ''), created_at, updated_at, file_data
 			FROM reference_documents
-			WHERE lecture_id = ?
-		`, payload.LectureID)
+			WHERE lecture_id = ? AND (? = '' OR id = ?)
+		`, payload.LectureID, payload.DocumentID, payload.DocumentID)
 		if databaseError != nil {
 			return fmt.Errorf("failed to query documents: %w", databaseError)
 		}
@@ -288,7 +491,7 @@ func RegisterHandlers(
 		for documentRows.Next() {
 			var document models.ReferenceDocument
 			var fileData []byte
-			if scanningError := documentRows.Scan(&document.ID, &document.LectureID, &document.DocumentType, &document.Title, &document.FilePath, &document.PageCount, &document.ExtractionStatus, &document.CreatedAt, &document.UpdatedAt, &fileData); scanningError != nil {
+			if scanningError := documentRows.Scan(&document.ID, &document.LectureID, &document.DocumentType, &document.Title, &document.FilePath, &document.PageCount, &document.ExtractionStatus, &document.ExtractionMethod, &document.Language, &document.CreatedAt, &document.UpdatedAt, &fileData); scanningError != nil {
 				return fmt.Errorf("failed to scan document: %w", scanningError)
 			}
 			// Restore document file from DB BLOB to temp dir for processing
@@ -298,10 +501,20 @@ func RegisterHandlers(
 					return fmt.Errorf("failed to restore document from DB: %w", writeErr)
 				}
 				document.FilePath = tempPath
+			} else {
+				document.FilePath = storage.NewLayout(config.Storage).ResolvePath(document.FilePath)
 			}
 			documentsList = append(documentsList, document)
 		}
 
+		// Documents read with OCR, by default or on their own, do not need the vision model
+		if documentProcessor != nil && len(documentsList) > 0 {
+			if preparationError := documentProcessor.PrepareModel(jobContext, reportModelLoading(updateProgress), documentsList...); preparationError != nil {
+				database.Exec("UPDATE lectures SET status = ?, updated_at = ? WHERE id = ?", "failed", time.Now(), payload.LectureID)
+				return preparationError
+			}
+		}
+
 		totalDocuments := len(documentsList)
 		var wg sync.WaitGroup
 		var mutex sync.Mutex
@@ -315,6 +528,11 @@ func RegisterHandlers(
 			if firstError != nil {
 				break
 			}
+			var documentStored bool
+			if checkpoint.load("document:"+document.ID, &documentStored) && documentStored {
+				completedCount++
+				continue
+			}
 
 			wg.Add(1)
 			go func(idx int, doc models.ReferenceDocument) {
@@ -327,105 +545,22 @@ func RegisterHandlers(
 					return
 				}
 
-				// 2. Update status to processing
-				_, executionError := database.Exec("UPDATE reference_documents SET extraction_status = ?, updated_at = ? WHERE id = ?", "processing", time.Now(), doc.ID)
-				if executionError != nil {
-					mutex.Lock()
-					if firstError == nil {
-						firstError = fmt.Errorf("failed to update document status: %w", executionError)
-					}
-					mutex.Unlock()
-					return
-				}
-
-				// 3. Create temp output directory for page images
-				outputDir := filepath.Join(os.TempDir(), "lectures-documents", job.ID, doc.ID, "pages")
-
-				// 4. Run document processing
-				pages, docMetrics, processingError := documentProcessor.ProcessDocument(jobContext, doc, outputDir, payload.LanguageCode, func(progress int, message string) {
-					// We don't report sub-progress for individual docs here to avoid flooding, or we could prefix it
-				})
-
-				if processingError != nil {
-					mutex.Lock()
-					if firstError == nil {
-						firstError = fmt.Errorf("document processor failed for %s: %w", doc.Title, processingError)
-					}
-					mutex.Unlock()
-					os.RemoveAll(outputDir)
-					database.Exec("UPDATE reference_documents SET extraction_status = ?, updated_at = ? WHERE id = ?", "failed", time.Now(), doc.ID)
-					return
-				}
-
-				// 5. Store pages in database — image data goes into BLOBs
-				tx, err := database.Begin()
-				if err != nil {
-					mutex.Lock()
-					if firstError == nil {
-						firstError = fmt.Errorf("failed to begin transaction: %w", err)
-					}
-					mutex.Unlock()
-					os.RemoveAll(outputDir)
-					return
-				}
-				defer tx.Rollback()
-
-				tx.Exec("DELETE FROM reference_pages WHERE document_id = ?", doc.ID)
-				for _, currentPage := range pages {
-					// Read image bytes to store in DB
-					imageData, readErr := os.ReadFile(currentPage.ImagePath)
-					if readErr != nil {
-						mutex.Lock()
-						if firstError == nil {
-							firstError = fmt.Errorf("failed to read page image for DB storage: %w (path: %s)", readErr, currentPage.ImagePath)
-						}
-						mutex.Unlock()
-						os.RemoveAll(outputDir)
-						return
-					}
-					// Store a logical path (just the filename) — not a disk path
-					logicalImagePath := filepath.Base(currentPage.ImagePath)
-					_, err = tx.Exec(`
-						INSERT INTO reference_pages (document_id, page_number, image_path, extracted_text, image_data)
-						VALUES (?, ?, ?, ?, ?)
-					`, doc.ID, currentPage.PageNumber, logicalImagePath, currentPage.ExtractedText, imageData)
-					if err != nil {
-						mutex.Lock()
-						if firstError == nil {
-							firstError = fmt.Errorf("failed to insert page: %w", err)
-						}
-						mutex.Unlock()
-						os.RemoveAll(outputDir)
-						return
-					}
-				}
-
-				// 6. Update document as completed
-				_, err = tx.Exec("UPDATE reference_documents SET extraction_status = ?, page_count = ?, estimated_cost = ?, updated_at = ? WHERE id = ?", "completed", len(pages), docMetrics.EstimatedCost, time.Now(), doc.ID)
-				if err != nil {
-					mutex.Lock()
-					if firstError == nil {
-						firstError = fmt.Errorf("failed to finalize document status: %w", err)
-					}
-					mutex.Unlock()
-					os.RemoveAll(outputDir)
-					return
-				}
-
-				if err := tx.Commit(); err != nil {
+				docMetrics, ingestionError := ingestReferenceDocument(jobContext, database, queue.objectStore, documentProcessor, job.ID, doc, payload.LanguageCode)
+				if ingestionError != nil {
 					mutex.Lock()
 					if firstError == nil {
-						firstError = fmt.Errorf("failed to commit transaction: %w", err)
+						firstError = ingestionError
 					}
 					mutex.Unlock()
-					os.RemoveAll(outputDir)
 					return
 				}
+				checkpoint.save("document:"+doc.ID, true)
 
 				mutex.Lock()
 				totalMetrics.InputTokens += docMetrics.InputTokens
 				totalMetrics.OutputTokens += docMetrics.OutputTokens
 				totalMetrics.EstimatedCost += docMetrics.EstimatedCost
+				totalMetrics.EstimatedInputTokens += docMetrics.EstimatedInputTokens
 				completedCount++
 				progress := int(float64(completedCount) / float64(totalDocuments) * 100)
 				updateProgress(progress, fmt.Sprintf("Ingested %d/%d reference documents...", completedCount, totalDocuments), nil, totalMetrics)
@@ -435,6 +570,9 @@ func RegisterHandlers(
 
 		wg.Wait()
 
+		if errors.Is(firstError, models.ErrJobPaused) {
+			return firstError
+		}
 		if firstError != nil {
 			database.Exec("UPDATE lectures SET status = ?, updated_at = ? WHERE id = ?", "failed", time.Now(), payload.LectureID)
 			return firstError
@@ -460,16 +598,320 @@ func RegisterHandlers(
 		return nil
 	})
 
+	queue.RegisterHandler(models.JobTypeIngestURL, func(jobContext context.Context, job *models.Job, updateProgress func(int, string, any, models.JobMetrics)) error {
+		var payload struct {
+			LectureID    string `json:"lecture_id"`
+			URL          string `json:"url"`
+			Title        string `json:"title"`
+			LanguageCode string `json:"language_code"`
+		}
+		if unmarshalingError := json.Unmarshal([]byte(job.Payload), &payload); unmarshalingError != nil {
+			return fmt.Errorf("failed to unmarshal job payload: %w", unmarshalingError)
+		}
+
+		if payload.LanguageCode == "" {
+			payload.LanguageCode = config.LLM.Language
+		}
+
+		// The lecture was put back into processing when the job was created; a page that cannot be fetched adds
+		// no document, so the lecture returns to the state its other documents and media put it in
+		refreshLectureStatus := func() {
+			if checkReadiness != nil {
+				checkReadiness(database, payload.LectureID)
+			}
+		}
+
+		// 1. Fetch the page and strip it down to its content
+		updateProgress(5, "Fetching "+payload.URL+"...", nil, models.JobMetrics{})
+		maximumBytes := int64(config.Uploads.Documents.MaximumFileSizeMB) << 20
+		webpage, fetchingError := documents.FetchWebpage(jobContext, payload.URL, maximumBytes, config.Uploads.Documents.AllowPrivateNetworks)
+		if fetchingError != nil {
+			refreshLectureStatus()
+			return fetchingError
+		}
+
+		if documentProcessor != nil {
+			if preparationError := documentProcessor.PrepareModel(jobContext, reportModelLoading(updateProgress)); preparationError != nil {
+				refreshLectureStatus()
+				return preparationError
+			}
+		}
+
+		// 2. Store the page as a reference document, with the address it was fetched from as provenance
+		title := payload.Title
+		if title == "" {
+			title = webpage.Title
+		}
+		// Dashes would break the citation parser, which splits on them
+		title = strings.ReplaceAll(sanitizeFilename(title), "-", "_")
+
+		documentType := "other"
+		if webpage.Extension == ".pdf" {
+			documentType = "pdf"
+		}
+		documentID, _ := gonanoid.New()
+		document := models.ReferenceDocument{
+			ID:               documentID,
+			LectureID:        payload.LectureID,
+			DocumentType:     documentType,
+			Title:            title,
+			FilePath:         documentID + webpage.Extension,
+			ExtractionStatus: "pending",
+			SourceURL:        webpage.URL,
+			CreatedAt:        time.Now(),
+			UpdatedAt:        time.Now(),
+		}
+		_, databaseError := database.Exec(`
+			INSERT INTO reference_documents (id, lecture_id, document_type, title, file_path, original_filename, page_count, extraction_status, source_url, created_at, updated_at, file_data)
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+		`, document.ID, document.LectureID, document.DocumentType, document.Title, document.FilePath, document.Title, 0, document.ExtractionStatus, document.SourceURL, document.CreatedAt, document.UpdatedAt, webpage.Content)
+		if databaseError != nil {
+			refreshLectureStatus()
+			return fmt.Errorf("failed to store document: %w", databaseError)
+		}
+
+		recordSourceAdded(database, job, payload.LectureID, models.ResourceTypeDocument, document.ID, document.Title)
+
+		if broadcast != nil {
+			broadcast("lecture:"+payload.LectureID, "lecture:updated", map[string]string{"lecture_id": payload.LectureID, "reason": "document_added"})
+		}
+
+		// 3. Run the same page extraction as uploaded documents
+		updateProgress(20, "Extracting pages of "+title+"...", nil, models.JobMetrics{})
+		documentDirectory := filepath.Join(os.TempDir(), "lectures-documents", job.ID)
+		if err := os.MkdirAll(documentDirectory, 0755); err != nil {
+			return fmt.Errorf("failed to create document directory: %w", err)
+		}
+		defer os.RemoveAll(documentDirectory)
+
+		document.FilePath = filepath.Join(documentDirectory, document.FilePath)
+		if err := os.WriteFile(document.FilePath, webpage.Content, 0644); err != nil {
+			return fmt.Errorf("failed to write page: %w", err)
+		}
+
+		totalMetrics, ingestionError := ingestReferenceDocument(jobContext, database, queue.objectStore, documentProcessor, job.ID, document, payload.LanguageCode)
+		if ingestionError != nil {
+			// Only this page failed: it is removed again, with the job's error telling why, and the lecture keeps
+			// the state its other sources put it in. A paused job fetches the page again when resumed
+			database.Exec("DELETE FROM reference_documents WHERE id = ?", document.ID)
+			if broadcast != nil {
+				broadcast("lecture:"+payload.LectureID, "lecture:updated", map[string]string{"lecture_id": payload.LectureID, "reason": "document_removed"})
+			}
+			if !errors.Is(ingestionError, models.ErrJobPaused) {
+				refreshLectureStatus()
+			}
+			return ingestionError
+		}
+
+		_, _ = database.Exec("UPDATE lectures SET estimated_cost = estimated_cost + ?, updated_at = ? WHERE id = ?", totalMetrics.EstimatedCost, time.Now(), payload.LectureID)
+		var examID string
+		database.QueryRow("SELECT exam_id FROM lectures WHERE id = ?", payload.LectureID).Scan(&examID)
+		if examID != "" {
+			_, _ = database.Exec("UPDATE exams SET estimated_cost = estimated_cost + ?, updated_at = ? WHERE id = ?", totalMetrics.EstimatedCost, time.Now(), examID)
+		}
+
+		refreshLectureStatus()
+
+		if broadcast != nil {
+			broadcast("lecture:"+payload.LectureID, "lecture:updated", map[string]string{"lecture_id": payload.LectureID, "reason": "documents_complete"})
+		}
+
+		job.Result = fmt.Sprintf(`{"document_id": "%s"}`, documentID)
+		updateProgress(100, "Webpage ingestion completed", nil, totalMetrics)
+		return nil
+	})
+
+	queue.RegisterHandler(models.JobTypeImportYouTube, func(jobContext context.Context, job *models.Job, updateProgress func(int, string, any, models.JobMetrics)) error {
+		var payload struct {
+			LectureID    string `json:"lecture_id"`
+			URL          string `json:"url"`
+			UseCaptions  bool   `json:"use_captions"`
+			LanguageCode string `json:"language_code"` // Language of the captions to look for; any when empty
+		}
+		if unmarshalingError := json.Unmarshal([]byte(job.Payload), &payload); unmarshalingError != nil {
+			return fmt.Errorf("failed to unmarshal job payload: %w", unmarshalingError)
+		}
+
+		failLecture := func() {
+			database.Exec("UPDATE lectures SET status = ?, updated_at = ? WHERE id = ?", "failed", time.Now(), payload.LectureID)
+		}
+
+		// 1. Download the audio, and the uploaded captions when they may replace transcription
+		downloadDirectory := filepath.Join(os.TempDir(), "lectures-jobs", job.ID, "youtube")
+		if mkdirError := os.MkdirAll(downloadDirectory, 0755); mkdirError != nil {
+			return fmt.Errorf("failed to create download directory: %w", mkdirError)
+		}
+		defer os.RemoveAll(filepath.Dir(downloadDirectory))
+
+		captionsLanguage := ""
+		if payload.UseCaptions {
+			captionsLanguage = payload.LanguageCode
+			if captionsLanguage == "" {
+				captionsLanguage = "all"
+			}
+		}
+		updateProgress(0, "Downloading from YouTube...", nil, models.JobMetrics{})
+		download, downloadError := media.DownloadYouTubeAudio(jobContext, payload.URL, downloadDirectory, config.Storage.BinDirectory, captionsLanguage, func(percent int) {
+			updateProgress(percent*80/100, "Downloading from YouTube...", nil, models.JobMetrics{})
+		})
+		if downloadError != nil {
+			failLecture()
+			return downloadError
+		}
+
+		// 2. Register the audio as the last media file of the lecture
+		updateProgress(85, "Storing downloaded media...", nil, models.JobMetrics{})
+		fileData, readError := os.ReadFile(download.AudioPath)
+		if readError != nil {
+			failLecture()
+			return fmt.Errorf("failed to read downloaded media: %w", readError)
+		}
+		durationMilliseconds, durationError := media.GetDurationMilliseconds(download.AudioPath, config.Storage.BinDirectory)
+		if durationError != nil {
+			durationMilliseconds = download.DurationMilliseconds
+		}
+		mediaType := "audio"
+		if download.HasVideo {
+			mediaType = "video"
+		}
+		extension := strings.ToLower(filepath.Ext(download.AudioPath))
+		title := download.Title
+		if title == "" {
+			title = "YouTube video"
+		}
+
+		mediaID, _ := gonanoid.New()
+		fileSize := len(fileData)
+		fileData, objectKey, storeError := storage.StoreContent(jobContext, queue.objectStore, storage.MediaObjectKey(payload.LectureID, mediaID, extension), fileData, mime.TypeByExtension(extension))
+		if storeError != nil {
+			failLecture()
+			return storeError
+		}
+		var mediaCount int
+		_, databaseError := database.Exec(`
+			INSERT INTO lecture_media (id, lecture_id, media_type, sequence_order, duration_milliseconds, file_path, original_filename, created_at, file_data, object_key, size_bytes)
+			VALUES (?, ?, ?, (SELECT COALESCE(MAX(sequence_order) + 1, 0) FROM lecture_media WHERE lecture_id = ?), ?, ?, ?, ?, ?, ?, ?)
+		`, mediaID, payload.LectureID, mediaType, payload.LectureID, durationMilliseconds, mediaID+extension, sanitizeFilename(title)+extension, time.Now(), fileData, objectKey, fileSize)
+		if databaseError != nil {
+			failLecture()
+			return fmt.Errorf("failed to store media: %w", databaseError)
+		}
+		database.QueryRow("SELECT COUNT(*) FROM lecture_media WHERE lecture_id = ?", payload.LectureID).Scan(&mediaCount)
+
+		recordSourceAdded(database, job, payload.LectureID, models.ResourceTypeMedia, mediaID, sanitizeFilename(title)+extension)
+
+		if broadcast != nil {
+			broadcast("lecture:"+payload.LectureID, "lecture:updated", map[string]string{"lecture_id": payload.LectureID, "reason": "media_added"})
+		}
+
+		// 3. Uploaded captions stand in for the transcript only when the video is the whole lecture; otherwise the
+		// transcript has to cover every media file, so the lecture goes through transcription as usual
+		var captionSegments []models.TranscriptSegment
+		if download.CaptionsPath != "" && mediaCount == 1 {
+			if captions, captionsError := os.ReadFile(download.CaptionsPath); captionsError == nil {
+				captionSegments = transcription.ParseWebVTT(string(captions))
+			} else {
+				slog.WarnContext(jobContext, "Failed to read downloaded captions", "jobID", job.ID, "error", captionsError)
+			}
+		}
+
+		if len(captionSegments) == 0 {
+			transcriptionJobID, enqueuingError := queue.Enqueue(job.UserID, models.JobTypeTranscribeMedia, map[string]any{"lecture_id": payload.LectureID}, job.CourseID, payload.LectureID)
+			if enqueuingError != nil {
+				failLecture()
+				return fmt.Errorf("failed to enqueue transcription: %w", enqueuingError)
+			}
+			job.Result = fmt.Sprintf(`{"media_id": "%s", "transcription_job_id": "%s"}`, mediaID, transcriptionJobID)
+			updateProgress(100, "YouTube import completed, transcription queued", nil, models.JobMetrics{})
+			return nil
+		}
+
+		updateProgress(90, "Storing captions as the transcript...", nil, models.JobMetrics{})
+		databaseTransaction, transactionError := database.Begin()
+		if transactionError != nil {
+			failLecture()
+			return fmt.Errorf("failed to begin transaction: %w", transactionError)
+		}
+		defer databaseTransaction.Rollback()
+
+		transcriptID, _ := gonanoid.New()
+		_, transactionError = databaseTransaction.Exec(`
+			INSERT OR IGNORE INTO transcripts (id, lecture_id, status, created_at, updated_at)
+			VALUES (?, ?, ?, ?, ?)
+		`, transcriptID, payload.LectureID, "completed", time.Now(), time.Now())
+		if transactionError != nil {
+			failLecture()
+			return fmt.Errorf("failed to create transcript: %w", transactionError)
+		}
+		if transactionError = databaseTransaction.QueryRow("SELECT id FROM transcripts WHERE lecture_id = ?", payload.LectureID).Scan(&transcriptID); transactionError != nil {
+			failLecture()
+			return fmt.Errorf("failed to get transcript ID: %w", transactionError)
+		}
+		if _, transactionError = databaseTransaction.Exec("DELETE FROM transcript_segments WHERE transcript_id = ?", transcriptID); transactionError != nil {
+			failLecture()
+			return fmt.Errorf("failed to delete old segments: %w", transactionError)
+		}
+		for _, segment := range captionSegments {
+			_, transactionError = databaseTransaction.Exec(`
+				INSERT INTO transcript_segments (transcript_id, media_id, start_millisecond, end_millisecond, original_start_milliseconds, original_end_milliseconds, text)
+				VALUES (?, ?, ?, ?, ?, ?, ?)
+			`, transcriptID, mediaID, segment.StartMillisecond, segment.EndMillisecond, segment.OriginalStartMilliseconds, segment.OriginalEndMilliseconds, segment.Text)
+			if transactionError != nil {
+				failLecture()
+				return fmt.Errorf("failed to insert segment: %w", transactionError)
+			}
+		}
+		if _, transactionError = databaseTransaction.Exec("UPDATE transcripts SET status = ?, updated_at = ? WHERE id = ?", "completed", time.Now(), transcriptID); transactionError != nil {
+			failLecture()
+			return fmt.Errorf("failed to finalize transcript status: %w", transactionError)
+		}
+
+		// A lecture without an explicit language adopts the language of the captions
+		captionsBaseLanguage := strings.SplitN(download.CaptionsLanguage, "-", 2)[0]
+		if _, transactionError = databaseTransaction.Exec("UPDATE lectures SET language = ?, updated_at = ? WHERE id = ? AND COALESCE(language, '') = ''", captionsBaseLanguage, time.Now(), payload.LectureID); transactionError != nil {
+			slog.WarnContext(jobContext, "Failed to store captions language", "lectureID", payload.LectureID, "error", transactionError)
+		}
+
+		if commitError := databaseTransaction.Commit(); commitError != nil {
+			failLecture()
+			return fmt.Errorf("failed to commit transaction: %w", commitError)
+		}
+		if redactionError := applyTranscriptRedactions(jobContext, database, queue.objectStore, payload.LectureID); redactionError != nil {
+			failLecture()
+			return redactionError
+		}
+
+		if checkReadiness != nil {
+			checkReadiness(database, payload.LectureID)
+		}
+
+		if broadcast != nil {
+			broadcast("lecture:"+payload.LectureID, "lecture:updated", map[string]string{"lecture_id": payload.LectureID, "reason": "transcription_complete"})
+		}
+
+		job.Result = fmt.Sprintf(`{"media_id": "%s", "transcript_id": "%s", "captions_language": "%s"}`, mediaID, transcriptID, download.CaptionsLanguage)
+		updateProgress(100, "YouTube import completed with uploaded captions", nil, models.JobMetrics{})
+		return nil
+	})
+
 	queue.RegisterHandler(models.JobTypeBuildMaterial, func(jobContext context.Context, job *models.Job, updateProgress func(int, string, any, models.JobMetrics)) error {
 		var payload struct {
-			LectureID               string `json:"lecture_id"`
-			ExamID                  string `json:"exam_id"`
-			Type                    string `json:"type"`
-			Length                  string `json:"length"`
-			LanguageCode            string `json:"language_code"`
-			EnableDocumentsMatching string `json:"enable_documents_matching"`
-			AdherenceThreshold      string `json:"adherence_threshold"`
-			MaximumRetries          string `json:"maximum_retries"`
+			LectureID               string                    `json:"lecture_id"`
+			ExamID                  string                    `json:"exam_id"`
+			Type                    string                    `json:"type"`
+			Length                  string                    `json:"length"`
+			LanguageCode            string                    `json:"language_code"`
+			EnableDocumentsMatching string                    `json:"enable_documents_matching"`
+			AdherenceThreshold      string                    `json:"adherence_threshold"`
+			MaximumRetries          string                    `json:"maximum_retries"`
+			GenerateImages          string                    `json:"generate_images"`
+			ResumeJobID             string                    `json:"resume_job_id"` // Guides only: failed build whose accepted sections are reused
+			AllowPartialSources     string                    `json:"allow_partial_sources"`
+			Source                  string                    `json:"source"`              // "bookmarks" generates from the moments the user bookmarked
+			ReplacedVersionID       string                    `json:"replaced_version_id"` // Version keeping the tool this build replaces
+			PresetID                string                    `json:"preset_id"`           // Generation preset the request applied, already merged into the payload
+			Sampling                models.SamplingParameters `json:"sampling"`
+			MockExam                models.MockExamSettings   `json:"mock_exam"` // Mock exams only: their questions, duration and points
 			// Models
 			ModelDocumentsMatching string `json:"model_documents_matching"`
 			ModelStructure         string `json:"model_structure"`
@@ -481,8 +923,51 @@ func RegisterHandlers(
 			return fmt.Errorf("failed to unmarshal job payload: %w", unmarshalingError)
 		}
 
-		threshold, _ := strconv.Atoi(payload.AdherenceThreshold)
-		maximumRetries, _ := strconv.Atoi(payload.MaximumRetries)
+		// Values omitted from the payload fall back to the exam's defaults, then to the global configuration. The
+		// request already layered its preset into the payload, so the preset is not read again here
+		examDefaults := examGenerationDefaults(database, payload.ExamID)
+		if payload.LanguageCode == "" {
+			payload.LanguageCode = examDefaults.LanguageCode
+		}
+		if payload.LanguageCode == "" {
+			payload.LanguageCode = config.LLM.Language
+		}
+		if payload.Length == "" {
+			payload.Length = examDefaults.Length
+		}
+		if payload.EnableDocumentsMatching == "" {
+			enableMatching := config.LLM.EnableDocumentsMatching
+			if examDefaults.EnableDocumentsMatching != nil {
+				enableMatching = *examDefaults.EnableDocumentsMatching
+			}
+			payload.EnableDocumentsMatching = strconv.FormatBool(enableMatching)
+		}
+
+		// An explicit 0 is kept: it accepts every section, or makes a single attempt
+		threshold := parseOptionalInt(payload.AdherenceThreshold)
+		if threshold == nil {
+			threshold = examDefaults.AdherenceThreshold
+		}
+		maximumRetries := parseOptionalInt(payload.MaximumRetries)
+		if maximumRetries == nil {
+			maximumRetries = examDefaults.MaximumRetries
+		}
+		if payload.ModelDocumentsMatching == "" {
+			payload.ModelDocumentsMatching = examDefaults.ModelDocumentsMatching
+		}
+		if payload.ModelStructure == "" {
+			payload.ModelStructure = examDefaults.ModelStructure
+		}
+		if payload.ModelGeneration == "" {
+			payload.ModelGeneration = examDefaults.ModelGeneration
+		}
+		if payload.ModelAdherence == "" {
+			payload.ModelAdherence = examDefaults.ModelAdherence
+		}
+		if payload.ModelPolishing == "" {
+			payload.ModelPolishing = examDefaults.ModelPolishing
+		}
+
 		options := models.GenerationOptions{
 			EnableDocumentsMatching: payload.EnableDocumentsMatching == "true",
 			AdherenceThreshold:      threshold,
@@ -492,78 +977,139 @@ func RegisterHandlers(
 			ModelGeneration:         payload.ModelGeneration,
 			ModelAdherence:          payload.ModelAdherence,
 			ModelPolishing:          payload.ModelPolishing,
+			TemplateVariables:       models.MetadataTemplateVariables(lectureMetadataFields(database, payload.ExamID, payload.LectureID)),
+			Sampling:                payload.Sampling,
 		}
+		jobContext = models.WithSampling(jobContext, options.Sampling)
 
 		if payload.Type == "" {
 			payload.Type = "guide"
 		}
 
+		// In experimentation mode the job tries registered prompt variants; the outcome is recorded once the
+		// tool is stored, so the variants can be compared on adherence and on user ratings
+		var adherenceScores []int
+		if config.LLM.PromptExperiments {
+			assignedVariants, assignmentError := prompts.AssignPromptVariants(database, job.ID, payload.Type)
+			if assignmentError != nil {
+				slog.WarnContext(jobContext, "Failed to assign prompt variants, using the prompt files", "jobID", job.ID, "error", assignmentError)
+			}
+			options.PromptVariants = assignedVariants
+			var scoresMutex sync.Mutex
+			options.OnAdherenceScore = func(score int) {
+				scoresMutex.Lock()
+				adherenceScores = append(adherenceScores, score)
+				scoresMutex.Unlock()
+			}
+		}
+
+		// Sections of guides and course overviews are stored as they are accepted, so a build interrupted by a
+		// crash or a failure resumes from its outline and only generates the sections still missing
+		if (payload.Type == "guide" && payload.LectureID != "") || payload.Type == models.ToolTypeCourseOverview {
+			sectionRecorder := &toolSectionRecorder{database: database, jobID: job.ID, examID: payload.ExamID, lectureID: payload.LectureID}
+			resumeJobID := payload.ResumeJobID
+			if resumeJobID == "" {
+				resumeJobID = requeuedFromJobID(database, job.ID)
+			}
+			resumeOutline, resumeSections, resumeError := sectionRecorder.resumeToolSections(resumeJobID)
+			if resumeError != nil {
+				slog.WarnContext(jobContext, "Failed to load the sections of an interrupted generation, starting over", "jobID", job.ID, "error", resumeError)
+			} else if resumeOutline != "" {
+				slog.InfoContext(jobContext, "Resuming study guide generation", "jobID", job.ID, "acceptedSections", len(resumeSections))
+				options.ResumeOutline, options.ResumeSections = resumeOutline, resumeSections
+			}
+			options.OnOutline = sectionRecorder.recordOutline
+			options.OnSectionAccepted = sectionRecorder.recordSection
+		}
+
+		if toolGenerator != nil {
+			if preparationError := toolGenerator.PrepareToolModels(jobContext, payload.Type, options, reportModelLoading(updateProgress)); preparationError != nil {
+				return preparationError
+			}
+		}
+
+		// Each update carries the phase of the build, placed among the phases of the tool type
+		generateImages := payload.Type == "flashcard" && payload.GenerateImages == "true" && toolGenerator.ImageGenerationAvailable()
+		buildPhases := models.BuildPhases(payload.Type, generateImages)
+		reportBuildProgress := func(progress int, message string, metadata any, metrics models.JobMetrics) {
+			if buildProgress, ok := metadata.(models.BuildProgress); ok {
+				metadata = buildProgress.Within(buildPhases, metrics)
+			}
+			updateProgress(progress, message, metadata, metrics)
+		}
+
+		// Course overviews and mock exams are built from the ready lectures of the exam and belong to none of them
+		if models.ToolCoversExam(payload.Type) {
+			var toolID, toolTitle string
+			var totalMetrics models.JobMetrics
+			var buildError error
+			if payload.Type == models.ToolTypeMockExam {
+				toolID, toolTitle, totalMetrics, buildError = buildMockExam(jobContext, database, toolGenerator, payload.ExamID, payload.MockExam, payload.LanguageCode, options, reportBuildProgress)
+			} else {
+				toolID, toolTitle, totalMetrics, buildError = buildCourseOverview(jobContext, database, toolGenerator, job.ID, payload.ExamID, payload.Length, payload.LanguageCode, options, reportBuildProgress)
+			}
+			if buildError != nil {
+				return buildError
+			}
+
+			if config.LLM.PromptExperiments {
+				if recordingError := prompts.RecordPromptOutcome(database, job.ID, toolID, adherenceScores); recordingError != nil {
+					slog.WarnContext(jobContext, "Failed to record prompt experiment outcome", "jobID", job.ID, "error", recordingError)
+				}
+			}
+
+			recordToolGenerated(database, job, payload.ExamID, "", toolID, payload.Type, toolTitle, payload.ReplacedVersionID)
+
+			if broadcast != nil {
+				broadcast("course:"+payload.ExamID, "tool:created", map[string]string{"course_id": payload.ExamID, "tool_id": toolID})
+			}
+
+			job.Result = fmt.Sprintf(`{"tool_id": "%s"}`, toolID)
+
+			reportBuildProgress(100, "Tool usage completed", models.BuildProgress{Phase: models.BuildPhaseFinalizing}, totalMetrics)
+			return nil
+		}
+
 		var lecture models.Lecture
 		queryError := database.QueryRow("SELECT id, exam_id, title, description FROM lectures WHERE id = ?", payload.LectureID).Scan(&lecture.ID, &lecture.ExamID, &lecture.Title, &lecture.Description)
 		if queryError != nil {
 			return fmt.Errorf("failed to get lecture: %w", queryError)
 		}
 
-		transcriptRows, databaseError := database.Query(`
-			SELECT text FROM transcript_segments 
-			WHERE transcript_id = (SELECT id FROM transcripts WHERE lecture_id = ?)
-			ORDER BY start_millisecond ASC
-		`, payload.LectureID)
-		if databaseError != nil {
-			return fmt.Errorf("failed to query transcript: %w", databaseError)
+		sources, selectionError := selectGenerationSources(database, payload.LectureID, payload.AllowPartialSources == "true")
+		if selectionError != nil {
+			return selectionError
+		}
+		if sources.partial {
+			slog.InfoContext(jobContext, "Generating from partial sources", "jobID", job.ID, "lectureID", payload.LectureID, "transcript", sources.useTranscript, "documents", len(sources.completedDocumentIDs))
 		}
 
 		var transcriptBuilder strings.Builder
-		for transcriptRows.Next() {
-			var text string
-			if scanningError := transcriptRows.Scan(&text); scanningError == nil {
-				transcriptBuilder.WriteString(text + " ")
+		if payload.Source == "bookmarks" {
+			// Only the transcript around the bookmarks is used, so the cards cover those moments and nothing else
+			bookmarkedText, bookmarkError := bookmarkedTranscript(database, payload.LectureID, job.UserID)
+			if bookmarkError != nil {
+				return bookmarkError
 			}
+			transcriptBuilder.WriteString(bookmarkedText)
+		} else if sources.useTranscript {
+			transcript, transcriptError := lectureTranscript(database, payload.LectureID)
+			if transcriptError != nil {
+				return transcriptError
+			}
+			transcriptBuilder.WriteString(transcript)
 		}
-		transcriptRows.Close()
 
-		documentRows, databaseError := database.Query(`
-			SELECT reference_documents.title, reference_pages.page_number, reference_pages.extracted_text
-			FROM reference_documents
-			JOIN reference_pages ON reference_documents.id = reference_pages.document_id
-			WHERE reference_documents.lecture_id = ?
-			ORDER BY reference_documents.id, reference_pages.page_number ASC
-		`, payload.LectureID)
+		referenceChunks, databaseError := documents.ListLectureReferenceChunks(database, payload.LectureID)
 		if databaseError != nil {
-			return fmt.Errorf("failed to query reference pages: %w", databaseError)
+			return fmt.Errorf("failed to query reference chunks: %w", databaseError)
 		}
-
-		markdownReconstructor := markdown.NewReconstructor()
-		markdownReconstructor.Language = payload.LanguageCode
-		rootNode := &markdown.Node{Type: markdown.NodeDocument}
-		currentDocumentTitle := ""
-
-		for documentRows.Next() {
-			var title, text string
-			var pageNumber int
-			if scanningError := documentRows.Scan(&title, &pageNumber, &text); scanningError == nil {
-				if title != currentDocumentTitle {
-					rootNode.Children = append(rootNode.Children, &markdown.Node{
-						Type:    markdown.NodeHeading,
-						Level:   1,
-						Content: "Reference File: `" + title + "`",
-					})
-					currentDocumentTitle = title
-				}
-				rootNode.Children = append(rootNode.Children, &markdown.Node{
-					Type:    markdown.NodeHeading,
-					Level:   2,
-					Content: fmt.Sprintf("Page %d", pageNumber),
-				})
-				rootNode.Children = append(rootNode.Children, &markdown.Node{
-					Type:    markdown.NodeParagraph,
-					Content: strings.TrimSpace(text),
-				})
-			}
+		referenceChunks = sources.filterChunks(referenceChunks)
+		if payload.Source == "bookmarks" {
+			referenceChunks = nil
 		}
-		documentRows.Close()
 
-		referenceFilesContent := markdownReconstructor.Reconstruct(rootNode)
+		referenceFilesContent := referenceMaterials(referenceChunks, payload.LanguageCode, &options)
 
 		var toolContent, toolTitle string
 		var totalMetrics models.JobMetrics
@@ -571,18 +1117,16 @@ func RegisterHandlers(
 
 		switch payload.Type {
 		case "flashcard":
-			toolContent, toolTitle, totalMetrics, generationError = toolGenerator.GenerateFlashcards(jobContext, lecture, transcriptBuilder.String(), referenceFilesContent, payload.LanguageCode, options, func(progress int, message string, metadata any, metrics models.JobMetrics) {
-				updateProgress(progress, message, metadata, metrics)
-			})
+			toolContent, toolTitle, totalMetrics, generationError = toolGenerator.GenerateFlashcards(jobContext, lecture, transcriptBuilder.String(), referenceFilesContent, payload.LanguageCode, options, reportBuildProgress)
 		case "quiz":
-			toolContent, toolTitle, totalMetrics, generationError = toolGenerator.GenerateQuiz(jobContext, lecture, transcriptBuilder.String(), referenceFilesContent, payload.LanguageCode, options, func(progress int, message string, metadata any, metrics models.JobMetrics) {
-				updateProgress(progress, message, metadata, metrics)
-			})
+			toolContent, toolTitle, totalMetrics, generationError = toolGenerator.GenerateQuiz(jobContext, lecture, transcriptBuilder.String(), referenceFilesContent, payload.LanguageCode, options, reportBuildProgress)
+		case models.ToolTypeMindmap:
+			toolContent, toolTitle, totalMetrics, generationError = toolGenerator.GenerateMindmap(jobContext, lecture, transcriptBuilder.String(), referenceFilesContent, payload.LanguageCode, options, reportBuildProgress)
 		default:
 			toolContent, toolTitle, generationError = toolGenerator.GenerateStudyGuide(jobContext, lecture, transcriptBuilder.String(), referenceFilesContent, payload.Length, payload.LanguageCode, options, func(progress int, message string, metadata any, metrics models.JobMetrics) {
 				// Metrics are already aggregated inside GenerateStudyGuide and passed back via this callback
 				totalMetrics = metrics
-				updateProgress(progress, message, metadata, metrics)
+				reportBuildProgress(progress, message, metadata, metrics)
 			})
 		}
 
@@ -591,42 +1135,90 @@ func RegisterHandlers(
 		}
 
 		// Identify citations to populate tool_source_references, but we will store the RAW toolContent
+		markdownReconstructor := markdown.NewReconstructor()
+		markdownReconstructor.Language = payload.LanguageCode
 		_, citations := markdownReconstructor.ParseCitations(toolContent)
 
 		// Improve footnotes using AI if it's a guide and we have citations
 		if payload.Type == "guide" && len(citations) > 0 {
+			reportBuildProgress(90, "Processing footnotes...", models.BuildProgress{Phase: models.BuildPhaseProcessingFootnotes}, totalMetrics)
 			updatedCitations, footnoteMetrics, err := toolGenerator.ProcessFootnotesAI(jobContext, citations, payload.LanguageCode, options)
 			totalMetrics.InputTokens += footnoteMetrics.InputTokens
 			totalMetrics.OutputTokens += footnoteMetrics.OutputTokens
 			totalMetrics.EstimatedCost += footnoteMetrics.EstimatedCost
+			totalMetrics.EstimatedInputTokens += footnoteMetrics.EstimatedInputTokens
 			if err == nil {
 				citations = updatedCitations
 			}
 		}
 
-		updateProgress(95, "Finalizing tool...", nil, totalMetrics)
+		// The model sometimes mangles the cited filenames; map them back onto the lecture's documents so the
+		// cited pages can still be found when the tool is rendered or exported
+		if payload.LectureID != "" && len(citations) > 0 {
+			markdown.CorrectCitationFilenames(citations, lectureDocumentNames(database, payload.LectureID))
+		}
+
+		// Cited pages are cropped to the region supporting each claim when the guide is exported
+		var citationRegions map[int]markdown.PageRegion
+		if payload.Type == "guide" && payload.LectureID != "" && len(citations) > 0 {
+			reportBuildProgress(92, "Locating cited regions...", models.BuildProgress{Phase: models.BuildPhaseLocatingCitations}, totalMetrics)
+			var regionMetrics models.JobMetrics
+			citationRegions, regionMetrics = locateCitationRegions(jobContext, database, storage.NewLayout(config.Storage), queue.objectStore, toolGenerator, payload.LectureID, citations, job.ID)
+			totalMetrics.InputTokens += regionMetrics.InputTokens
+			totalMetrics.OutputTokens += regionMetrics.OutputTokens
+			totalMetrics.EstimatedCost += regionMetrics.EstimatedCost
+			totalMetrics.EstimatedInputTokens += regionMetrics.EstimatedInputTokens
+		}
 
 		toolID, _ := gonanoid.New()
 
+		// Optional mnemonic images never fail the build: the cards are kept as generated
+		if generateImages {
+			reportBuildProgress(90, "Generating mnemonic images...", models.BuildProgress{Phase: models.BuildPhaseGeneratingImages}, totalMetrics)
+			toolDirectory := storage.NewLayout(config.Storage).ToolExportDirectory(toolID)
+			contentWithImages, imageMetrics, imageError := toolGenerator.GenerateFlashcardImages(jobContext, toolContent, toolDirectory, options)
+			totalMetrics.InputTokens += imageMetrics.InputTokens
+			totalMetrics.OutputTokens += imageMetrics.OutputTokens
+			totalMetrics.EstimatedCost += imageMetrics.EstimatedCost
+			totalMetrics.EstimatedInputTokens += imageMetrics.EstimatedInputTokens
+			if imageError != nil {
+				slog.WarnContext(jobContext, "Flashcard image generation failed, keeping cards without images", "lectureID", payload.LectureID, "error", imageError)
+			}
+			toolContent = contentWithImages
+		}
+
+		reportBuildProgress(95, "Finalizing tool...", models.BuildProgress{Phase: models.BuildPhaseFinalizing}, totalMetrics)
+
 		transaction, err := database.Begin()
 		if err != nil {
 			return fmt.Errorf("failed to begin transaction for tool storage: %w", err)
 		}
 		defer transaction.Rollback()
 
+		var bookmarkUserID string
+		if payload.Source == "bookmarks" {
+			bookmarkUserID = job.UserID
+		}
 		_, executionError := transaction.Exec(`
-			INSERT INTO tools (id, exam_id, lecture_id, type, title, language_code, content, estimated_cost, created_at, updated_at)
-			VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
-		`, toolID, payload.ExamID, payload.LectureID, payload.Type, toolTitle, payload.LanguageCode, toolContent, totalMetrics.EstimatedCost, time.Now(), time.Now())
+			INSERT INTO tools (id, exam_id, lecture_id, type, title, language_code, content, estimated_cost, partial_sources, bookmark_user_id, created_at, updated_at)
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, NULLIF(?, ''), ?, ?)
+		`, toolID, payload.ExamID, payload.LectureID, payload.Type, toolTitle, payload.LanguageCode, toolContent, totalMetrics.EstimatedCost, sources.partial, bookmarkUserID, time.Now(), time.Now())
 		if executionError != nil {
+			os.RemoveAll(storage.NewLayout(config.Storage).ToolExportDirectory(toolID))
 			return fmt.Errorf("failed to store tool: %w", executionError)
 		}
 
+		if payload.Type == "guide" && payload.LectureID != "" {
+			if attachError := attachToolSections(transaction, job.ID, toolID, payload.ExamID, payload.LectureID); attachError != nil {
+				slog.WarnContext(jobContext, "Failed to attach generated sections to the guide", "toolID", toolID, "error", attachError)
+			}
+		}
+
 		// Update lecture cost (aggregate)
 		if payload.LectureID != "" {
 			_, executionError = transaction.Exec("UPDATE lectures SET estimated_cost = estimated_cost + ?, updated_at = ? WHERE id = ?", totalMetrics.EstimatedCost, time.Now(), payload.LectureID)
 			if executionError != nil {
-				slog.Warn("Failed to update lecture estimated cost during tool build", "lectureID", payload.LectureID, "error", executionError)
+				slog.WarnContext(jobContext, "Failed to update lecture estimated cost during tool build", "lectureID", payload.LectureID, "error", executionError)
 			}
 
 			// Update exam cost (aggregate)
@@ -635,24 +1227,28 @@ func RegisterHandlers(
 			if examID != "" {
 				_, executionError = transaction.Exec("UPDATE exams SET estimated_cost = estimated_cost + ?, updated_at = ? WHERE id = ?", totalMetrics.EstimatedCost, time.Now(), examID)
 				if executionError != nil {
-					slog.Warn("Failed to update exam estimated cost during tool build", "examID", examID, "error", executionError)
+					slog.WarnContext(jobContext, "Failed to update exam estimated cost during tool build", "examID", examID, "error", executionError)
 				}
 			}
 		}
 
 		// Store citation metadata in structured table
 		for _, citation := range citations {
-			metadataJSON, _ := json.Marshal(map[string]any{
+			metadata := map[string]any{
 				"footnote_number": citation.Number,
 				"description":     citation.Description,
 				"pages":           citation.Pages,
-			})
+			}
+			if region, found := citationRegions[citation.Number]; found {
+				metadata["region"] = region
+			}
+			metadataJSON, _ := json.Marshal(metadata)
 			_, executionError = transaction.Exec(`
 				INSERT INTO tool_source_references (tool_id, source_type, source_id, metadata)
 				VALUES (?, ?, ?, ?)
 			`, toolID, "document", citation.File, string(metadataJSON))
 			if executionError != nil {
-				slog.Error("Failed to store tool source reference", "toolID", toolID, "error", executionError)
+				slog.ErrorContext(jobContext, "Failed to store tool source reference", "toolID", toolID, "error", executionError)
 			}
 		}
 
@@ -660,13 +1256,21 @@ func RegisterHandlers(
 			return fmt.Errorf("failed to commit tool storage: %w", commitError)
 		}
 
+		if config.LLM.PromptExperiments {
+			if recordingError := prompts.RecordPromptOutcome(database, job.ID, toolID, adherenceScores); recordingError != nil {
+				slog.WarnContext(jobContext, "Failed to record prompt experiment outcome", "jobID", job.ID, "error", recordingError)
+			}
+		}
+
+		recordToolGenerated(database, job, payload.ExamID, payload.LectureID, toolID, payload.Type, toolTitle, payload.ReplacedVersionID)
+
 		if broadcast != nil {
 			broadcast("course:"+payload.ExamID, "tool:created", map[string]string{"course_id": payload.ExamID, "tool_id": toolID})
 		}
 
 		job.Result = fmt.Sprintf(`{"tool_id": "%s"}`, toolID)
 
-		updateProgress(100, "Tool usage completed", nil, totalMetrics)
+		reportBuildProgress(100, "Tool usage completed", models.BuildProgress{Phase: models.BuildPhaseFinalizing}, totalMetrics)
 		return nil
 	})
 
@@ -812,6 +1416,7 @@ func RegisterHandlers(
 		totalMetrics.InputTokens += polishMetrics.InputTokens
 		totalMetrics.OutputTokens += polishMetrics.OutputTokens
 		totalMetrics.EstimatedCost += polishMetrics.EstimatedCost
+		totalMetrics.EstimatedInputTokens += polishMetrics.EstimatedInputTokens
 
 		if err != nil {
 			return err
@@ -823,12 +1428,110 @@ func RegisterHandlers(
 			return err
 		}
 
+		recordExamSuggestion(database, job, payload.ExamID, currentTitle, newTitle, currentDescription, newDescription)
+
 		updateProgress(100, "Metadata updated successfully", nil, totalMetrics)
 		job.Result = fmt.Sprintf(`{"title": "%s", "description": "%s"}`, newTitle, newDescription)
 		return nil
 	})
 
-	queue.RegisterHandler(models.JobTypePublishMaterial, func(jobContext context.Context, job *models.Job, updateProgress func(int, string, any, models.JobMetrics)) error {
+	queue.RegisterHandler(models.JobTypePolishTranscript, func(jobContext context.Context, job *models.Job, updateProgress func(int, string, any, models.JobMetrics)) error {
+		var totalMetrics models.JobMetrics
+		var payload struct {
+			LectureID      string `json:"lecture_id"`
+			Force          bool   `json:"force"` // Re-polish segments that already have polished text
+			ModelPolishing string `json:"model_polishing"`
+		}
+		if err := json.Unmarshal([]byte(job.Payload), &payload); err != nil {
+			return fmt.Errorf("failed to unmarshal job payload: %w", err)
+		}
+
+		var transcriptID, examID string
+		err := database.QueryRow(`
+			SELECT transcripts.id, lectures.exam_id FROM transcripts
+			JOIN lectures ON transcripts.lecture_id = lectures.id
+			WHERE transcripts.lecture_id = ? AND transcripts.status = 'completed'
+		`, payload.LectureID).Scan(&transcriptID, &examID)
+		if err != nil {
+			return fmt.Errorf("no completed transcript found for lecture: %w", err)
+		}
+
+		// Segments polished by an earlier run are kept, so a failed job resumes where it stopped
+		segmentQuery := "SELECT id, text FROM transcript_segments WHERE transcript_id = ?"
+		if !payload.Force {
+			segmentQuery += " AND polished_text IS NULL"
+		}
+		rows, err := database.Query(segmentQuery+" ORDER BY start_millisecond ASC", transcriptID)
+		if err != nil {
+			return fmt.Errorf("failed to query transcript segments: %w", err)
+		}
+		var segments []models.TranscriptSegment
+		for rows.Next() {
+			var segment models.TranscriptSegment
+			if err := rows.Scan(&segment.ID, &segment.Text); err == nil {
+				segments = append(segments, segment)
+			}
+		}
+		rows.Close()
+
+		if len(segments) == 0 {
+			updateProgress(100, "Transcript is already polished", nil, totalMetrics)
+			job.Result = `{"polished_segments": 0}`
+			return nil
+		}
+
+		// The spend is added to the transcript, lecture and exam however the job ends, failures included
+		defer func() {
+			database.Exec("UPDATE transcripts SET estimated_cost = estimated_cost + ?, updated_at = ? WHERE id = ?", totalMetrics.EstimatedCost, time.Now(), transcriptID)
+			database.Exec("UPDATE lectures SET estimated_cost = estimated_cost + ?, updated_at = ? WHERE id = ?", totalMetrics.EstimatedCost, time.Now(), payload.LectureID)
+			database.Exec("UPDATE exams SET estimated_cost = estimated_cost + ?, updated_at = ? WHERE id = ?", totalMetrics.EstimatedCost, time.Now(), examID)
+		}()
+
+		options := models.GenerationOptions{ModelPolishing: payload.ModelPolishing}
+		polishedCount := 0
+		for batchStart := 0; batchStart < len(segments); batchStart += tools.TranscriptPolishBatchSize {
+			batchEnd := min(batchStart+tools.TranscriptPolishBatchSize, len(segments))
+			updateProgress(batchStart*100/len(segments), fmt.Sprintf("Polishing segments %d-%d of %d...", batchStart+1, batchEnd, len(segments)), nil, totalMetrics)
+
+			polishedTexts, batchMetrics, polishError := toolGenerator.PolishTranscriptSegments(jobContext, segments[batchStart:batchEnd], options)
+			totalMetrics.InputTokens += batchMetrics.InputTokens
+			totalMetrics.OutputTokens += batchMetrics.OutputTokens
+			totalMetrics.EstimatedCost += batchMetrics.EstimatedCost
+			totalMetrics.EstimatedInputTokens += batchMetrics.EstimatedInputTokens
+			// Reported before storing the batch, so its spend reaches the cost ledger even if storing fails
+			updateProgress(batchEnd*100/len(segments), fmt.Sprintf("Polished segments %d-%d of %d", batchStart+1, batchEnd, len(segments)), nil, totalMetrics)
+			if polishError != nil {
+				return fmt.Errorf("failed to polish segments %d-%d: %w", batchStart+1, batchEnd, polishError)
+			}
+
+			for segmentID, polishedText := range polishedTexts {
+				if _, err := database.Exec("UPDATE transcript_segments SET polished_text = ? WHERE id = ? AND transcript_id = ?", polishedText, segmentID, transcriptID); err != nil {
+					return fmt.Errorf("failed to store polished segment: %w", err)
+				}
+				polishedCount++
+			}
+		}
+
+		if polishedCount > 0 {
+			if err := applyTranscriptRedactions(jobContext, database, queue.objectStore, payload.LectureID); err != nil {
+				return err
+			}
+			recordJobEvent(database, job, models.ResourceEvent{
+				ExamID:       examID,
+				LectureID:    payload.LectureID,
+				ResourceType: models.ResourceTypeTranscript,
+				ResourceID:   transcriptID,
+				Action:       models.ResourceActionEdited,
+				Summary:      fmt.Sprintf("Polished %d segments of the transcript", polishedCount),
+			})
+		}
+
+		updateProgress(100, fmt.Sprintf("Polished %d of %d segments", polishedCount, len(segments)), nil, totalMetrics)
+		job.Result = fmt.Sprintf(`{"polished_segments": %d, "total_segments": %d}`, polishedCount, len(segments))
+		return nil
+	})
+
+	publishMaterial := func(jobContext context.Context, job *models.Job, updateProgress func(int, string, any, models.JobMetrics)) error {
 		var totalMetrics models.JobMetrics
 
 		var payload struct {
@@ -836,8 +1539,13 @@ func RegisterHandlers(
 			DocumentID    string          `json:"document_id"`
 			LectureID     string          `json:"lecture_id"`
 			LanguageCode  string          `json:"language_code"`
-			Format        string          `json:"format"` // "pdf", "docx", "md"
+			Format        string          `json:"format"` // "pdf", "docx", "epub", "html", "md"
+			Theme         string          `json:"theme"`
 			IncludeImages json.RawMessage `json:"include_images"`
+			SelfContained json.RawMessage `json:"self_contained"`
+			IncludeQRCode json.RawMessage `json:"include_qr_code"`
+			// Transcripts only: the bookmarks of the user who exports, unless false
+			IncludeBookmarks json.RawMessage `json:"include_bookmarks"`
 		}
 		if unmarshalingError := json.Unmarshal([]byte(job.Payload), &payload); unmarshalingError != nil {
 			return fmt.Errorf("failed to unmarshal job payload: %w", unmarshalingError)
@@ -861,6 +1569,32 @@ func RegisterHandlers(
 			}
 		}
 
+		// A self-contained export verifies every image it references before conversion and embeds them:
+		// as data URIs in HTML and Markdown, and inside the package for DOCX, EPUB and PDF
+		selfContained := false
+		if len(payload.SelfContained) > 0 {
+			rawStr := string(payload.SelfContained)
+			selfContained = rawStr == "true" || rawStr == `"true"`
+		}
+		embedAssets := func(content string) (string, error) {
+			if !selfContained {
+				return content, nil
+			}
+			return markdown.EmbedLocalAssets(content, config.Storage.DataDirectory, payload.Format == "html" || payload.Format == "md")
+		}
+
+		// Links back to the web app, with QR codes when requested, once server.public_base_url is configured
+		includeQRCode := false
+		if len(payload.IncludeQRCode) > 0 {
+			rawStr := string(payload.IncludeQRCode)
+			includeQRCode = rawStr == "true" || rawStr == `"true"`
+		}
+		// HTML and Markdown files only keep the QR images when they are inlined
+		if (payload.Format == "html" || payload.Format == "md") && !selfContained {
+			includeQRCode = false
+		}
+		links := newExportLinks(config, job.ID, payload.Format, includeQRCode)
+
 		// 1. Handle Transcript Export
 		// Transcripts contain only text - no images to include/exclude
 		if payload.ToolID == "" && payload.DocumentID == "" && payload.LectureID != "" {
@@ -894,9 +1628,11 @@ func RegisterHandlers(
 
 			// 1. First pass: Organize segments by media file
 			type segment struct {
-				text  string
-				start string
-				end   string
+				text    string
+				start   string
+				end     string
+				link    string
+				endTime int64
 			}
 			mediaGroups := make(map[string][]segment)
 			var mediaOrder []string
@@ -914,11 +1650,16 @@ func RegisterHandlers(
 					if _, exists := mediaGroups[name]; !exists {
 						mediaOrder = append(mediaOrder, name)
 					}
-					mediaGroups[name] = append(mediaGroups[name], segment{
-						text:  text,
-						start: formatTimestamp(startMs),
-						end:   formatTimestamp(endMs),
-					})
+					seg := segment{
+						text:    text,
+						start:   formatTimestamp(startMs),
+						end:     formatTimestamp(endMs),
+						endTime: endMs,
+					}
+					if links.enabled() {
+						seg.link = links.lectureTimestamp(examID, lecture.ID, startMs)
+					}
+					mediaGroups[name] = append(mediaGroups[name], seg)
 				}
 			}
 
@@ -927,16 +1668,30 @@ func RegisterHandlers(
 			for _, name := range mediaOrder {
 				transcriptBuilder.WriteString(fmt.Sprintf("- [`%s`](#%s)\n", name, strings.ToLower(strings.ReplaceAll(name, " ", "-"))))
 			}
-			transcriptBuilder.WriteString("\n---\n\n")
+			transcriptBuilder.WriteString("\n")
+
+			// The bookmarks of whoever exports are listed after the contents and anchored in the text
+			var bookmarkPlacement transcriptBookmarks
+			if rawStr := string(payload.IncludeBookmarks); rawStr != "false" && rawStr != `"false"` {
+				bookmarkPlacement = loadTranscriptBookmarks(database, lecture.ID, job.UserID)
+			}
+			bookmarkPlacement.writeIndex(&transcriptBuilder)
+			transcriptBuilder.WriteString("---\n\n")
 
 			// 3. Build Content
 			for _, name := range mediaOrder {
 				transcriptBuilder.WriteString(fmt.Sprintf("## `%s`\n\n", name))
 				for _, seg := range mediaGroups[name] {
-					transcriptBuilder.WriteString(fmt.Sprintf("### %s – %s\n\n", seg.start, seg.end))
+					bookmarkPlacement.writeAnchorsBefore(&transcriptBuilder, seg.endTime)
+					if seg.link != "" {
+						transcriptBuilder.WriteString(fmt.Sprintf("### [%s – %s](%s)\n\n", seg.start, seg.end, seg.link))
+					} else {
+						transcriptBuilder.WriteString(fmt.Sprintf("### %s – %s\n\n", seg.start, seg.end))
+					}
 					transcriptBuilder.WriteString(seg.text + "\n\n")
 				}
 			}
+			bookmarkPlacement.writeRemainingAnchors(&transcriptBuilder)
 
 			// Setup export in temp directory (DB BLOB is the source of truth)
 			exportDirectory := filepath.Join(os.TempDir(), "lectures-exports", job.ID)
@@ -948,18 +1703,21 @@ func RegisterHandlers(
 			// Convert
 			updateProgress(50, "Generating transcript PDF...", nil, models.JobMetrics{})
 			options := markdown.ConversionOptions{
-				Language:    payload.LanguageCode,
-				CourseTitle: examTitle,
+				Title:          "Transcript of " + lecture.Title,
+				Language:       payload.LanguageCode,
+				CourseTitle:    examTitle,
+				MetadataFields: lectureMetadataFields(database, examID, lecture.ID),
+				Theme:          payload.Theme,
+			}
+			if links.enabled() {
+				options.QRCodePath = links.qrCode(links.lecture(examID, lecture.ID))
 			}
 
 			generateFunc := func(content string, opts markdown.ConversionOptions) error {
 				switch payload.Format {
-				case "pdf":
-					html, _ := markdownConverter.MarkdownToHTML(content)
-					return markdownConverter.HTMLToPDF(html, outputPath, opts)
-				case "docx":
+				case "pdf", "docx", "epub", "html":
 					html, _ := markdownConverter.MarkdownToHTML(content)
-					return markdownConverter.HTMLToDocx(html, outputPath, opts)
+					return writeHTMLExport(markdownConverter, html, payload.Format, outputPath, opts)
 				default:
 					return markdownConverter.SaveMarkdown(content, outputPath)
 				}
@@ -970,12 +1728,7 @@ func RegisterHandlers(
 				return err
 			}
 
-			// Store export bytes in DB for self-contained backups
-			if exportBytes, readErr := os.ReadFile(outputPath); readErr == nil {
-				database.Exec("UPDATE jobs SET export_data = ? WHERE id = ?", exportBytes, job.ID)
-			}
-			job.Result = fmt.Sprintf(`{"file_path": "%s", "format": "%s"}`, outputPath, payload.Format)
-			return nil
+			return completeExport(jobContext, database, queue.objectStore, job, outputPath, payload.Format)
 		}
 
 		// 2. Handle Document Export
@@ -984,10 +1737,10 @@ func RegisterHandlers(
 			var doc models.ReferenceDocument
 			var examID string
 			err := database.QueryRow(`
-				SELECT rd.id, rd.title, l.exam_id FROM reference_documents rd
+				SELECT rd.id, rd.lecture_id, rd.title, l.exam_id FROM reference_documents rd
 				JOIN lectures l ON rd.lecture_id = l.id
 				WHERE rd.id = ?
-			`, payload.DocumentID).Scan(&doc.ID, &doc.Title, &examID)
+			`, payload.DocumentID).Scan(&doc.ID, &doc.LectureID, &doc.Title, &examID)
 			if err != nil {
 				return err
 			}
@@ -996,7 +1749,7 @@ func RegisterHandlers(
 			database.QueryRow("SELECT title FROM exams WHERE id = ?", examID).Scan(&examTitle)
 
 			rows, err := database.Query(`
-				SELECT page_number, image_path, extracted_text, image_data FROM reference_pages
+				SELECT page_number, image_path, extracted_text, image_data, COALESCE(object_key, '') FROM reference_pages
 				WHERE document_id = ? ORDER BY page_number ASC
 			`, payload.DocumentID)
 			if err != nil {
@@ -1016,14 +1769,15 @@ func RegisterHandlers(
 
 			for rows.Next() {
 				var pageNum int
-				var imagePath, text string
+				var imagePath, text, objectKey string
 				var imageData []byte
-				if err := rows.Scan(&pageNum, &imagePath, &text, &imageData); err == nil {
-					// Restore image from BLOB to temp dir
-					if len(imageData) > 0 {
-						tempImagePath := filepath.Join(docExportTempDir, fmt.Sprintf("page_%d.png", pageNum))
-						os.WriteFile(tempImagePath, imageData, 0644)
+				if err := rows.Scan(&pageNum, &imagePath, &text, &imageData, &objectKey); err == nil {
+					// Restore image from BLOB or object store to temp dir
+					tempImagePath := filepath.Join(docExportTempDir, fmt.Sprintf("page_%d.png", pageNum))
+					if restorePageImage(jobContext, queue.objectStore, imageData, objectKey, tempImagePath) {
 						imagePath = tempImagePath
+					} else {
+						imagePath = storage.NewLayout(config.Storage).ResolvePath(imagePath)
 					}
 
 					if pageNum%10 == 0 || pageNum == 1 {
@@ -1064,18 +1818,22 @@ func RegisterHandlers(
 			// Convert
 			updateProgress(50, "Generating document analysis PDF...", nil, models.JobMetrics{})
 			options := markdown.ConversionOptions{
-				Language:    payload.LanguageCode,
-				CourseTitle: examTitle,
+				Title:          doc.Title,
+				Language:       payload.LanguageCode,
+				CourseTitle:    examTitle,
+				MetadataFields: lectureMetadataFields(database, examID, doc.LectureID),
+				Theme:          payload.Theme,
 			}
 
 			generateFunc := func(content string, opts markdown.ConversionOptions) error {
+				content, err := embedAssets(content)
+				if err != nil {
+					return err
+				}
 				switch payload.Format {
-				case "pdf":
+				case "pdf", "docx", "epub", "html":
 					html, _ := markdownConverter.MarkdownToHTML(content)
-					return markdownConverter.HTMLToPDF(html, outputPath, opts)
-				case "docx":
-					html, _ := markdownConverter.MarkdownToHTML(content)
-					return markdownConverter.HTMLToDocx(html, outputPath, opts)
+					return writeHTMLExport(markdownConverter, html, payload.Format, outputPath, opts)
 				default:
 					return markdownConverter.SaveMarkdown(content, outputPath)
 				}
@@ -1086,12 +1844,7 @@ func RegisterHandlers(
 				return err
 			}
 
-			// Store export bytes in DB for self-contained backups
-			if exportBytes, readErr := os.ReadFile(outputPath); readErr == nil {
-				database.Exec("UPDATE jobs SET export_data = ? WHERE id = ?", exportBytes, job.ID)
-			}
-			job.Result = fmt.Sprintf(`{"file_path": "%s", "format": "%s"}`, outputPath, payload.Format)
-			return nil
+			return completeExport(jobContext, database, queue.objectStore, job, outputPath, payload.Format)
 		}
 
 		// 3. Handle Tool Export (Study Guide, etc.)
@@ -1106,7 +1859,7 @@ func RegisterHandlers(
 			// Fetch Exam Title
 			var examTitle string
 			if err := database.QueryRow("SELECT title FROM exams WHERE id = ?", examID).Scan(&examTitle); err != nil {
-				slog.Warn("Failed to fetch exam title for metadata", "examID", examID, "error", err)
+				slog.WarnContext(jobContext, "Failed to fetch exam title for metadata", "examID", examID, "error", err)
 			}
 
 			if payload.LanguageCode == "" {
@@ -1127,13 +1880,38 @@ func RegisterHandlers(
 			outputExtension := "." + payload.Format
 			safeFilename := sanitizeFilename(tool.Title) + outputExtension
 			outputPath := filepath.Join(exportDirectory, safeFilename)
-			slog.Info("Exporting tool", "tool_title", tool.Title, "format", payload.Format, "filename", safeFilename, "path", outputPath)
+			slog.InfoContext(jobContext, "Exporting tool", "tool_title", tool.Title, "format", payload.Format, "filename", safeFilename, "path", outputPath)
 
 			// Prepare content for PDF/Docx/MD (convert JSON to Markdown if needed)
 			contentToConvert := tool.Content
 
-			// If it's a guide, transform raw citations to footnotes at runtime
-			if tool.Type == "guide" {
+			// Flashcards are rendered card by card, with mnemonic images resolved from the tool's directory
+			if tool.Type == "flashcard" {
+				toolDirectory := ""
+				if includeImages {
+					toolDirectory = storage.NewLayout(config.Storage).ToolExportDirectory(tool.ID)
+				}
+				tool.Content = tools.ResolveFlashcardImages(tool.Content, toolDirectory)
+				contentToConvert = markdown.FlashcardsToMarkdown(tool.Title, tool.Content)
+			}
+
+			// Quizzes are rendered as printable questions followed by an answer key
+			if tool.Type == "quiz" {
+				contentToConvert = markdown.QuizToMarkdown(tool.Title, tool.Content, payload.LanguageCode)
+			}
+
+			// Mindmaps are rendered as their diagram followed by the concepts and relations it shows
+			if tool.Type == models.ToolTypeMindmap {
+				contentToConvert = markdown.MindmapToMarkdown(tool.Title, tool.Content, payload.LanguageCode)
+			}
+
+			// Mock exams are rendered as a printable exam followed by its answer key
+			if tool.Type == models.ToolTypeMockExam {
+				contentToConvert = markdown.MockExamToMarkdown(tool.Title, tool.Content, payload.LanguageCode)
+			}
+
+			// If it's a guide or a course overview, transform raw citations to footnotes at runtime
+			if models.ToolCitesSources(tool.Type) {
 				markdownReconstructor := markdown.NewReconstructor()
 				markdownReconstructor.Language = payload.LanguageCode
 				markdownReconstructor.IncludeImages = includeImages
@@ -1170,7 +1948,7 @@ func RegisterHandlers(
 				// 4. Finalize markdown with footnote definitions
 				contentToConvert = markdownReconstructor.AppendCitations(processedContent, textCitations)
 
-				slog.Info("Starting tool content parsing and enrichment", "toolID", tool.ID)
+				slog.InfoContext(jobContext, "Starting tool content parsing and enrichment", "toolID", tool.ID)
 				markdownParser := markdown.NewParser()
 				ast := markdownParser.Parse(contentToConvert)
 
@@ -1180,20 +1958,23 @@ func RegisterHandlers(
 						File  string
 						Pages []int
 					})
+					pageRegions := newCitedPageRegions()
 					refRows, err := database.Query("SELECT source_id, metadata FROM tool_source_references WHERE tool_id = ?", tool.ID)
 					if err == nil {
 						for refRows.Next() {
 							var sourceID, metadataStr string
 							if err := refRows.Scan(&sourceID, &metadataStr); err == nil {
 								var meta struct {
-									FootnoteNumber int   `json:"footnote_number"`
-									Pages          []int `json:"pages"`
+									FootnoteNumber int                  `json:"footnote_number"`
+									Pages          []int                `json:"pages"`
+									Region         *markdown.PageRegion `json:"region"`
 								}
 								if json.Unmarshal([]byte(metadataStr), &meta) == nil {
 									citationMetadata[meta.FootnoteNumber] = struct {
 										File  string
 										Pages []int
 									}{File: sourceID, Pages: meta.Pages}
+									pageRegions.add(sourceID, meta.Pages, meta.Region)
 								}
 							}
 						}
@@ -1219,9 +2000,10 @@ func RegisterHandlers(
 					toolImageTempDir := filepath.Join(os.TempDir(), "lectures-exports", job.ID, "images")
 					os.MkdirAll(toolImageTempDir, 0755)
 					pageMap := make(map[string]string) // Key: "filename:page"
-					slog.Info("Pre-fetching page image paths from database", "examID", examID)
+					documentNames := make(map[string]bool)
+					slog.InfoContext(jobContext, "Pre-fetching page image paths from database", "examID", examID)
 					rows, err := database.Query(`
-						SELECT reference_documents.original_filename, reference_documents.title, reference_pages.page_number, reference_pages.image_path, reference_pages.image_data
+						SELECT reference_documents.original_filename, reference_documents.title, reference_pages.page_number, reference_pages.image_path, reference_pages.image_data, COALESCE(reference_pages.object_key, '')
 						FROM reference_pages
 						JOIN reference_documents ON reference_pages.document_id = reference_documents.id
 						JOIN lectures ON reference_documents.lecture_id = lectures.id
@@ -1230,43 +2012,64 @@ func RegisterHandlers(
 					if err == nil {
 						for rows.Next() {
 							var originalFilename sql.NullString
-							var title, imagePath string
+							var title, imagePath, objectKey string
 							var pageNumber int
 							var imageData []byte
-							if err := rows.Scan(&originalFilename, &title, &pageNumber, &imagePath, &imageData); err == nil {
-								// Restore image from BLOB to temp dir
-								resolvedPath := imagePath
-								if len(imageData) > 0 {
-									tempImagePath := filepath.Join(toolImageTempDir, fmt.Sprintf("%s_page_%d.png", filepath.Base(imagePath), pageNumber))
-									if writeErr := os.WriteFile(tempImagePath, imageData, 0644); writeErr == nil {
-										resolvedPath = tempImagePath
-									}
+							if err := rows.Scan(&originalFilename, &title, &pageNumber, &imagePath, &imageData, &objectKey); err == nil {
+								// Restore image from BLOB or object store to temp dir
+								resolvedPath := storage.NewLayout(config.Storage).ResolvePath(imagePath)
+								tempImagePath := filepath.Join(toolImageTempDir, fmt.Sprintf("%s_page_%d.png", filepath.Base(imagePath), pageNumber))
+								if restorePageImage(jobContext, queue.objectStore, imageData, objectKey, tempImagePath) {
+									resolvedPath = tempImagePath
 								}
 
 								if originalFilename.Valid && originalFilename.String != "" {
 									pageMap[fmt.Sprintf("%s:%d", originalFilename.String, pageNumber)] = resolvedPath
+									documentNames[originalFilename.String] = true
 								}
 								if title != "" {
 									pageMap[fmt.Sprintf("%s:%d", title, pageNumber)] = resolvedPath
+									documentNames[title] = true
 								}
 							}
 						}
 						rows.Close()
 					}
-					slog.Info("Pre-fetched pages for enrichment", "count", len(pageMap))
+					slog.InfoContext(jobContext, "Pre-fetched pages for enrichment", "count", len(pageMap))
 
+					knownDocumentNames := make([]string, 0, len(documentNames))
+					for name := range documentNames {
+						knownDocumentNames = append(knownDocumentNames, name)
+					}
+					sort.Strings(knownDocumentNames)
+
+					// Pages are cropped to the regions their citations were located in
 					imageResolver := func(filename string, pageNumber int) string {
 						key := fmt.Sprintf("%s:%d", filename, pageNumber)
-						return pageMap[key]
+						if resolvedPath, found := pageMap[key]; found {
+							return pageRegions.crop(resolvedPath, filename, pageNumber, toolImageTempDir)
+						}
+						// Tools built before citation filenames were corrected may still cite a mangled name
+						if resolvedFilename := markdown.ResolveCitationFilename(filename, knownDocumentNames); resolvedFilename != "" {
+							return pageMap[fmt.Sprintf("%s:%d", resolvedFilename, pageNumber)]
+						}
+						return ""
 					}
 
-					slog.Info("Starting AST enrichment with cited images")
+					slog.InfoContext(jobContext, "Starting AST enrichment with cited images")
 					markdown.EnrichWithCitedImages(ast, imageResolver)
-					slog.Info("Finished AST enrichment with cited images")
+					slog.InfoContext(jobContext, "Finished AST enrichment with cited images")
+				}
+
+				// Every section leads to the same section of the guide in the web app
+				if links.enabled() {
+					markdown.AddSectionLinks(ast, 2, payload.LanguageCode, func(anchor string) string {
+						return links.tool(examID, tool.ID, anchor)
+					}, links.qrCode)
 				}
 
 				contentToConvert = markdownReconstructor.Reconstruct(ast)
-				slog.Info("Finished tool content reconstruction", "contentLength", len(contentToConvert))
+				slog.InfoContext(jobContext, "Finished tool content reconstruction", "contentLength", len(contentToConvert))
 			}
 
 			// 3.1 Identify which lectures to include in metadata
@@ -1385,20 +2188,49 @@ func RegisterHandlers(
 					totalMetrics.InputTokens += abstractMetrics.InputTokens
 					totalMetrics.OutputTokens += abstractMetrics.OutputTokens
 					totalMetrics.EstimatedCost += abstractMetrics.EstimatedCost
+					totalMetrics.EstimatedInputTokens += abstractMetrics.EstimatedInputTokens
 				}
 			}
 
 			updateProgress(50, fmt.Sprintf("Generating %s document...", payload.Format), nil, models.JobMetrics{})
 			options := markdown.ConversionOptions{
+				Title:          tool.Title,
 				Language:       payload.LanguageCode,
 				Description:    abstract,
 				CourseTitle:    examTitle,
+				MetadataFields: lectureMetadataFields(database, examID, lectureIDs[0]),
 				CreationDate:   finalDate,
 				ReferenceFiles: referenceFiles,
 				AudioFiles:     audioFiles,
+				Theme:          payload.Theme,
+			}
+			// Card and table formats carry the tool's own content, without QR codes or embedded page images
+			cardFormat := payload.Format == "anki" || payload.Format == "csv" || payload.Format == "apkg"
+			if links.enabled() && !cardFormat {
+				options.QRCodePath = links.qrCode(links.tool(examID, tool.ID, ""))
 			}
 
 			generateFunc := func(currentContent string, currentOptions markdown.ConversionOptions) error {
+				if payload.Format == "apkg" {
+					if tool.Type != "flashcard" {
+						return fmt.Errorf("only flashcard tools can be exported as Anki packages")
+					}
+					var lectureTitle string
+					database.QueryRow("SELECT title FROM lectures WHERE id = ?", lectureIDs[0]).Scan(&lectureTitle)
+					return markdown.FlashcardsToAnkiPackage(tool.Content, markdown.AnkiPackageOptions{
+						ExamTitle:    examTitle,
+						LectureTitle: lectureTitle,
+						DeckID:       tool.ID,
+					}, outputPath)
+				}
+				if !cardFormat {
+					embeddedContent, err := embedAssets(currentContent)
+					if err != nil {
+						return err
+					}
+					currentContent = embeddedContent
+				}
+
 				// Normalize math for all non-HTML outputs if needed
 				normalizedContent := markdownConverter.NormalizeMath(currentContent)
 
@@ -1418,16 +2250,13 @@ func RegisterHandlers(
 					return fmt.Errorf("failed to convert to HTML: %w", err)
 				}
 
-				if payload.Format == "docx" {
-					return markdownConverter.HTMLToDocx(htmlContent, outputPath, currentOptions)
-				}
 				if payload.Format == "anki" {
 					return markdownConverter.HTMLToAnki(tool.Type, tool.Content, outputPath)
 				}
 				if payload.Format == "csv" {
 					return markdownConverter.HTMLToCSV(tool.Type, tool.Content, outputPath)
 				}
-				return markdownConverter.HTMLToPDF(htmlContent, outputPath, currentOptions)
+				return writeHTMLExport(markdownConverter, htmlContent, payload.Format, outputPath, currentOptions)
 			}
 
 			originalContent := contentToConvert
@@ -1448,22 +2277,24 @@ func RegisterHandlers(
 			}
 			database.Exec("UPDATE exams SET estimated_cost = estimated_cost + ?, updated_at = ? WHERE id = ?", totalMetrics.EstimatedCost, time.Now(), examID)
 
-			slog.Info("Export completed with costs",
+			slog.InfoContext(jobContext, "Export completed with costs",
 				"file_path", outputPath,
 				"format", payload.Format,
 				"input_tokens", totalMetrics.InputTokens,
 				"output_tokens", totalMetrics.OutputTokens,
 				"estimated_cost_usd", totalMetrics.EstimatedCost)
-			// Store export bytes in DB for self-contained backups
-			if exportBytes, readErr := os.ReadFile(outputPath); readErr == nil {
-				database.Exec("UPDATE jobs SET export_data = ? WHERE id = ?", exportBytes, job.ID)
-			}
-			job.Result = fmt.Sprintf(`{"file_path": "%s", "format": "%s"}`, outputPath, payload.Format)
-			return nil
+			return completeExport(jobContext, database, queue.objectStore, job, outputPath, payload.Format)
 		}
 
 		return fmt.Errorf("invalid publish material payload: no ID provided")
-	})
+	}
+	queue.RegisterHandler(models.JobTypePublishMaterial, recordExports(database, publishMaterial))
+	queue.RegisterHandler(models.JobTypePublishBundle, publishBundleHandler(database, queue.objectStore, publishMaterial))
+	queue.RegisterHandler(models.JobTypeGenerateRecap, generateRecapHandler(database, config, toolGenerator))
+	queue.RegisterHandler(models.JobTypeAnalyzeDuplicates, analyzeDuplicatesHandler(database))
+	queue.RegisterHandler(models.JobTypeBackup, backupHandler(database, config))
+	queue.RegisterHandler(models.JobTypeRedactMedia, redactMediaHandler(database, config, queue.objectStore))
+	queue.RegisterHandler(models.JobTypeImportSyllabus, importSyllabusHandler(database, config, documentProcessor, toolGenerator))
 }
 
 func uploadToTmpFiles(filePath string) (string, error) {
@@ -0,0 +1,167 @@
+package jobs
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+
+	"lectures/internal/database"
+	"lectures/internal/models"
+)
+
+// recordJobEvent adds an event made by a job to the history of its exam, on behalf of the user who queued it.
+// The history is a convenience, so failing to record it never fails the job
+func recordJobEvent(db *sql.DB, job *models.Job, event models.ResourceEvent) {
+	event.UserID = job.UserID
+	event.JobID = job.ID
+	if event.ExamID == "" {
+		event.ExamID = job.CourseID
+	}
+	if event.LectureID == "" {
+		event.LectureID = job.LectureID
+	}
+	if event.ExamID == "" && event.LectureID != "" {
+		db.QueryRow("SELECT exam_id FROM lectures WHERE id = ?", event.LectureID).Scan(&event.ExamID)
+	}
+	if event.ExamID == "" {
+		return
+	}
+	if err := database.RecordResourceEvent(db, event); err != nil {
+		slog.Warn("Failed to record history event", "jobID", job.ID, "resourceType", event.ResourceType, "action", event.Action, "error", err)
+	}
+}
+
+// recordToolGenerated records a stored tool in the history, as regenerated when it replaced an earlier one
+// kept as the given version
+func recordToolGenerated(db *sql.DB, job *models.Job, examID string, lectureID string, toolID string, toolType string, title string, replacedVersionID string) {
+	event := models.ResourceEvent{
+		ExamID:       examID,
+		LectureID:    lectureID,
+		ResourceType: models.ResourceTypeTool,
+		ResourceID:   toolID,
+		Action:       models.ResourceActionGenerated,
+		Summary:      fmt.Sprintf("Generated the %s \"%s\"", toolType, title),
+		Undo: &models.ResourceEventUndo{
+			Description: "Delete the generated tool",
+			Method:      http.MethodDelete,
+			Path:        "/api/tools",
+			Body:        map[string]any{"exam_id": examID, "tool_id": toolID},
+		},
+	}
+	if replacedVersionID != "" {
+		event.Action = models.ResourceActionRegenerated
+		event.Summary = fmt.Sprintf("Regenerated the %s \"%s\"", toolType, title)
+		event.VersionID = replacedVersionID
+		event.Undo = &models.ResourceEventUndo{
+			Description: "Put back the tool as it was before it was regenerated",
+			Method:      http.MethodPost,
+			Path:        "/api/tools/versions/restore",
+			Body:        map[string]any{"exam_id": examID, "version_id": replacedVersionID},
+		}
+	}
+	recordJobEvent(db, job, event)
+}
+
+// recordExports wraps the export handler to record each export it completes in the history of its exam
+func recordExports(db *sql.DB, handler JobHandler) JobHandler {
+	return func(jobContext context.Context, job *models.Job, updateProgress func(int, string, any, models.JobMetrics)) error {
+		if err := handler(jobContext, job, updateProgress); err != nil {
+			return err
+		}
+
+		var payload struct {
+			ToolID     string `json:"tool_id"`
+			DocumentID string `json:"document_id"`
+			LectureID  string `json:"lecture_id"`
+			Format     string `json:"format"`
+		}
+		json.Unmarshal([]byte(job.Payload), &payload)
+		if payload.Format == "" {
+			payload.Format = "pdf"
+		}
+
+		var exportedName string
+		var examID string
+		switch {
+		case payload.ToolID != "":
+			var toolType, title string
+			db.QueryRow("SELECT exam_id, type, title FROM tools WHERE id = ?", payload.ToolID).Scan(&examID, &toolType, &title)
+			exportedName = fmt.Sprintf("the %s \"%s\"", toolType, title)
+		case payload.DocumentID != "":
+			var title string
+			db.QueryRow("SELECT lectures.exam_id, reference_documents.title FROM reference_documents JOIN lectures ON reference_documents.lecture_id = lectures.id WHERE reference_documents.id = ?", payload.DocumentID).Scan(&examID, &title)
+			exportedName = fmt.Sprintf("the document \"%s\"", title)
+		default:
+			exportedName = "the transcript"
+		}
+
+		recordJobEvent(db, job, models.ResourceEvent{
+			ExamID:       examID,
+			LectureID:    payload.LectureID,
+			ResourceType: models.ResourceTypeExport,
+			ResourceID:   job.ID,
+			Action:       models.ResourceActionExported,
+			Summary:      fmt.Sprintf("Exported %s as %s", exportedName, strings.ToUpper(payload.Format)),
+		})
+		return nil
+	}
+}
+
+// recordSourceAdded records a document or recording a job added to a lecture, with the request removing it as
+// undo
+func recordSourceAdded(db *sql.DB, job *models.Job, lectureID string, resourceType string, resourceID string, name string) {
+	event := models.ResourceEvent{
+		LectureID:    lectureID,
+		ResourceType: resourceType,
+		ResourceID:   resourceID,
+		Action:       models.ResourceActionAdded,
+	}
+	if resourceType == models.ResourceTypeMedia {
+		event.Summary = fmt.Sprintf("Added the recording \"%s\"", name)
+		event.Undo = &models.ResourceEventUndo{
+			Description: "Remove the recording",
+			Method:      http.MethodDelete,
+			Path:        "/api/media",
+			Body:        map[string]any{"lecture_id": lectureID, "media_id": resourceID},
+		}
+	} else {
+		event.Summary = fmt.Sprintf("Added the document \"%s\"", name)
+		event.Undo = &models.ResourceEventUndo{
+			Description: "Remove the document",
+			Method:      http.MethodDelete,
+			Path:        "/api/documents",
+			Body:        map[string]any{"lecture_id": lectureID, "document_id": resourceID},
+		}
+	}
+	recordJobEvent(db, job, event)
+}
+
+// recordExamSuggestion records the title and description a suggestion job gave an exam, with the previous
+// title as undo
+func recordExamSuggestion(db *sql.DB, job *models.Job, examID string, previousTitle string, newTitle string, previousDescription string, newDescription string) {
+	if newTitle == previousTitle && newDescription == previousDescription {
+		return
+	}
+	event := models.ResourceEvent{
+		ExamID:       examID,
+		ResourceType: models.ResourceTypeExam,
+		ResourceID:   examID,
+		Action:       models.ResourceActionUpdated,
+		Summary:      fmt.Sprintf("Applied a suggested description to the exam \"%s\"", previousTitle),
+	}
+	if newTitle != previousTitle {
+		event.Action = models.ResourceActionRenamed
+		event.Summary = fmt.Sprintf("Renamed the exam \"%s\" to the suggested \"%s\"", previousTitle, newTitle)
+	}
+	event.Undo = &models.ResourceEventUndo{
+		Description: "Put back the previous title and description",
+		Method:      http.MethodPatch,
+		Path:        "/api/exams",
+		Body:        map[string]any{"exam_id": examID, "title": previousTitle, "description": previousDescription},
+	}
+	recordJobEvent(db, job, event)
+}
@@ -0,0 +1,44 @@
+package jobs
+
+import (
+	"encoding/json"
+
+	"lectures/internal/configuration"
+	"lectures/internal/models"
+)
+
+// jobModelTasks is the LLM task doing most of the work of each job type. Job types missing from it call no model
+var jobModelTasks = map[string]string{
+	models.JobTypeIngestDocuments:  "documents_ingestion",
+	models.JobTypeIngestURL:        "documents_ingestion",
+	models.JobTypeBuildMaterial:    "content_generation",
+	models.JobTypeSuggest:          "content_polishing",
+	models.JobTypePolishTranscript: "content_polishing",
+	models.JobTypeGenerateRecap:    "content_polishing",
+	models.JobTypePublishMaterial:  "content_polishing",
+	models.JobTypePublishBundle:    "content_polishing",
+}
+
+// primaryJobModel returns the model the usage of a job is attributed to: the generation model its payload
+// selects, or else the model configured for the task doing most of its work. It is resolved when the job is
+// queued so later configuration changes do not rewrite past usage
+func primaryJobModel(config *configuration.Configuration, jobType string, payloadJSON []byte) string {
+	if config == nil {
+		return ""
+	}
+	switch jobType {
+	case models.JobTypeTranscribeMedia, models.JobTypeImportYouTube:
+		return config.Transcription.GetModel(&config.LLM)
+	case models.JobTypeBuildMaterial:
+		var payload struct {
+			ModelGeneration string `json:"model_generation"`
+		}
+		if json.Unmarshal(payloadJSON, &payload) == nil && payload.ModelGeneration != "" {
+			return payload.ModelGeneration
+		}
+	}
+	if task, ok := jobModelTasks[jobType]; ok {
+		return config.LLM.GetModelForTask(task)
+	}
+	return ""
+}
@@ -0,0 +1,45 @@
+package jobs
+
+import (
+	"testing"
+
+	"lectures/internal/configuration"
+	"lectures/internal/models"
+)
+
+func TestQueue_RecordsJobModel(t *testing.T) {
+	queue, cleanup := setupQueueTestDatabase(t)
+	defer cleanup()
+	queue.configuration = &configuration.Configuration{
+		LLM: configuration.LLMConfiguration{
+			Model: "default-model",
+			Models: configuration.ModelsConfiguration{
+				ContentGeneration: configuration.ModelConfiguration{Model: "generation-model"},
+			},
+		},
+		Transcription: configuration.TranscriptionConfiguration{Model: "whisper-1"},
+	}
+
+	testCases := []struct {
+		jobType       string
+		payload       map[string]string
+		expectedModel string
+	}{
+		{models.JobTypeBuildMaterial, map[string]string{}, "generation-model"},
+		{models.JobTypeBuildMaterial, map[string]string{"model_generation": "chosen-model"}, "chosen-model"},
+		{models.JobTypeTranscribeMedia, map[string]string{}, "whisper-1"},
+		{models.JobTypePolishTranscript, map[string]string{}, "default-model"},
+		{models.JobTypeAnalyzeDuplicates, map[string]string{}, ""},
+	}
+	for _, testCase := range testCases {
+		jobID, err := queue.Enqueue("user-1", testCase.jobType, testCase.payload, "", "")
+		if err != nil {
+			t.Fatalf("Enqueue failed: %v", err)
+		}
+		var model string
+		queue.database.QueryRow("SELECT COALESCE(model, '') FROM jobs WHERE id = ?", jobID).Scan(&model)
+		if model != testCase.expectedModel {
+			t.Errorf("Expected %s with %v to be attributed to %q, got %q", testCase.jobType, testCase.payload, testCase.expectedModel, model)
+		}
+	}
+}
@@ -0,0 +1,83 @@
+package jobs
+
+import (
+	"database/sql"
+	"encoding/json"
+
+	"lectures/internal/models"
+)
+
+// lockHolderCondition matches the jobs holding their lock: running ones, and ones paused after they started, which
+// keep the lock until they resume from their checkpoint
+const lockHolderCondition = `(holder.status = 'RUNNING' OR (holder.status = 'PENDING' AND holder.paused_at IS NOT NULL AND holder.started_at IS NOT NULL))`
+
+// lockFreeCondition selects the pending jobs whose resource no other job holds. Claiming happens in a single
+// transaction under the dispatch mutex, so two jobs locking the same resource never start together
+const lockFreeCondition = `
+	AND (lock_key IS NULL OR NOT EXISTS (
+		SELECT 1 FROM jobs AS holder WHERE holder.lock_key = jobs.lock_key AND holder.id != jobs.id AND ` + lockHolderCondition + `
+	))`
+
+// jobLockKey returns the resource a job of jobType locks while it runs, or "" for jobs that can run alongside
+// any other. Builds lock the tool they replace, so two guides of a lecture are never paid for at once, while a
+// guide and a quiz of the same lecture still run side by side. Builds of a whole exam, such as course overviews
+// and mock exams, lock the exam and their type
+func jobLockKey(jobType string, lectureID string, payloadJSON []byte) string {
+	var payload struct {
+		ExamID    string `json:"exam_id"`
+		LectureID string `json:"lecture_id"`
+		ToolID    string `json:"tool_id"`
+		Type      string `json:"type"`
+	}
+	_ = json.Unmarshal(payloadJSON, &payload)
+	if payload.LectureID == "" {
+		payload.LectureID = lectureID
+	}
+
+	switch jobType {
+	case models.JobTypeBuildMaterial:
+		if payload.Type == "" {
+			payload.Type = "guide"
+		}
+		if payload.LectureID != "" {
+			return "lecture:" + payload.LectureID + ":" + payload.Type
+		}
+		if payload.ExamID != "" {
+			return "exam:" + payload.ExamID + ":" + payload.Type
+		}
+		return ""
+	case models.JobTypeTranscribeMedia, models.JobTypePolishTranscript:
+		if payload.LectureID == "" {
+			return ""
+		}
+		return "lecture:" + payload.LectureID + ":transcript"
+	case models.JobTypePublishMaterial:
+		if payload.ToolID == "" {
+			return ""
+		}
+		return "tool:" + payload.ToolID
+	}
+	return ""
+}
+
+// jobLock describes the lock of an active job, or returns nil when the job locks nothing or has ended. A job paused
+// after it started still holds its lock
+func (queue *Queue) jobLock(job *models.Job, lockKey sql.NullString) *models.JobLock {
+	if !lockKey.Valid || lockKey.String == "" || (job.Status != models.JobStatusPending && job.Status != models.JobStatusRunning) {
+		return nil
+	}
+
+	lock := &models.JobLock{Resource: lockKey.String, State: models.JobLockStateFree}
+	if job.Status == models.JobStatusRunning || (job.PausedAt != nil && job.StartedAt != nil) {
+		lock.State, lock.HolderJobID = models.JobLockStateHeld, job.ID
+		return lock
+	}
+	err := queue.database.QueryRow(
+		"SELECT id FROM jobs AS holder WHERE lock_key = ? AND id != ? AND "+lockHolderCondition+" ORDER BY started_at ASC LIMIT 1",
+		lockKey.String, job.ID,
+	).Scan(&lock.HolderJobID)
+	if err == nil {
+		lock.State = models.JobLockStateWaiting
+	}
+	return lock
+}
@@ -0,0 +1,114 @@
+package jobs
+
+import (
+	"testing"
+
+	"lectures/internal/models"
+)
+
+func TestJobLockKey(t *testing.T) {
+	testCases := []struct {
+		name        string
+		jobType     string
+		lectureID   string
+		payload     string
+		expectedKey string
+	}{
+		{"Guide build", models.JobTypeBuildMaterial, "lecture-1", `{"lecture_id": "lecture-1", "type": "guide"}`, "lecture:lecture-1:guide"},
+		{"Build without type", models.JobTypeBuildMaterial, "lecture-1", `{"lecture_id": "lecture-1"}`, "lecture:lecture-1:guide"},
+		{"Quiz build", models.JobTypeBuildMaterial, "", `{"lecture_id": "lecture-1", "type": "quiz"}`, "lecture:lecture-1:quiz"},
+		{"Course overview", models.JobTypeBuildMaterial, "", `{"exam_id": "exam-1", "type": "course_overview"}`, "exam:exam-1:course_overview"},
+		{"Mock exam", models.JobTypeBuildMaterial, "", `{"exam_id": "exam-1", "type": "mock_exam"}`, "exam:exam-1:mock_exam"},
+		{"Lecture build of an exam", models.JobTypeBuildMaterial, "", `{"exam_id": "exam-1", "lecture_id": "lecture-1", "type": "quiz"}`, "lecture:lecture-1:quiz"},
+		{"Polishing", models.JobTypePolishTranscript, "lecture-1", `{}`, "lecture:lecture-1:transcript"},
+		{"Export", models.JobTypePublishMaterial, "lecture-1", `{"tool_id": "tool-1"}`, "tool:tool-1"},
+		{"Suggestions", models.JobTypeSuggest, "", `{"exam_id": "exam-1"}`, ""},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			if key := jobLockKey(testCase.jobType, testCase.lectureID, []byte(testCase.payload)); key != testCase.expectedKey {
+				t.Errorf("Expected lock %q, got %q", testCase.expectedKey, key)
+			}
+		})
+	}
+}
+
+func TestQueue_ResourceLocks(t *testing.T) {
+	queue, cleanup := setupQueueTestDatabase(t)
+	defer cleanup()
+	queue.SetConcurrency(nil, nil)
+	buildPool := queue.poolFor(models.JobTypeBuildMaterial)
+
+	firstGuideJobID, _ := queue.Enqueue("user-1", models.JobTypeBuildMaterial, map[string]string{"lecture_id": "lecture-1", "type": "guide"}, "", "")
+	secondGuideJobID, _ := queue.Enqueue("user-1", models.JobTypeBuildMaterial, map[string]string{"lecture_id": "lecture-1", "type": "guide"}, "", "")
+	quizJobID, _ := queue.Enqueue("user-1", models.JobTypeBuildMaterial, map[string]string{"lecture_id": "lecture-1", "type": "quiz"}, "", "")
+
+	if claimed := queue.claimNextJob(buildPool, 0, false); claimed == nil || claimed.ID != firstGuideJobID {
+		t.Fatalf("Expected the first guide to be claimed, got %+v", claimed)
+	}
+
+	// The second guide waits for the first, while the quiz of the same lecture does not
+	if claimed := queue.claimNextJob(buildPool, 1, false); claimed == nil || claimed.ID != quizJobID {
+		t.Fatalf("Expected the quiz to be claimed past the locked guide, got %+v", claimed)
+	}
+	if claimed := queue.claimNextJob(buildPool, 2, false); claimed != nil {
+		t.Fatalf("Claimed %s while its lock was held", claimed.ID)
+	}
+
+	runningJob, _ := queue.GetJob(firstGuideJobID)
+	if runningJob.Lock == nil || runningJob.Lock.State != models.JobLockStateHeld || runningJob.Lock.Resource != "lecture:lecture-1:guide" {
+		t.Errorf("Unexpected lock of the running job %+v", runningJob.Lock)
+	}
+	waitingJob, _ := queue.GetJob(secondGuideJobID)
+	if waitingJob.Lock == nil || waitingJob.Lock.State != models.JobLockStateWaiting || waitingJob.Lock.HolderJobID != firstGuideJobID {
+		t.Errorf("Unexpected lock of the waiting job %+v", waitingJob.Lock)
+	}
+
+	queue.completeJob(firstGuideJobID, "")
+	if claimed := queue.claimNextJob(buildPool, 2, false); claimed == nil || claimed.ID != secondGuideJobID {
+		t.Fatalf("Expected the second guide to be claimed once the lock was released, got %+v", claimed)
+	}
+	completedJob, _ := queue.GetJob(firstGuideJobID)
+	if completedJob.Lock != nil {
+		t.Errorf("Expected no lock on a completed job, got %+v", completedJob.Lock)
+	}
+}
+
+func TestQueue_PausedJobKeepsItsLock(t *testing.T) {
+	queue, cleanup := setupQueueTestDatabase(t)
+	defer cleanup()
+	queue.SetConcurrency(nil, nil)
+	buildPool := queue.poolFor(models.JobTypeBuildMaterial)
+
+	firstOverviewJobID, _ := queue.Enqueue("user-1", models.JobTypeBuildMaterial, map[string]string{"exam_id": "exam-1", "type": "course_overview"}, "", "")
+	secondOverviewJobID, _ := queue.Enqueue("user-1", models.JobTypeBuildMaterial, map[string]string{"exam_id": "exam-1", "type": "course_overview"}, "", "")
+
+	if claimed := queue.claimNextJob(buildPool, 0, false); claimed == nil || claimed.ID != firstOverviewJobID {
+		t.Fatalf("Expected the first overview to be claimed, got %+v", claimed)
+	}
+	if err := queue.PauseJob(firstOverviewJobID); err != nil {
+		t.Fatalf("PauseJob failed: %v", err)
+	}
+	queue.parkPausedJob(firstOverviewJobID)
+
+	// The paused overview resumes from its checkpoint, so the second one keeps waiting for it
+	if claimed := queue.claimNextJob(buildPool, 1, false); claimed != nil {
+		t.Fatalf("Claimed %s while a paused job held its lock", claimed.ID)
+	}
+	pausedJob, _ := queue.GetJob(firstOverviewJobID)
+	if pausedJob.Lock == nil || pausedJob.Lock.State != models.JobLockStateHeld || pausedJob.Lock.Resource != "exam:exam-1:course_overview" {
+		t.Errorf("Unexpected lock of the paused job %+v", pausedJob.Lock)
+	}
+	waitingJob, _ := queue.GetJob(secondOverviewJobID)
+	if waitingJob.Lock == nil || waitingJob.Lock.State != models.JobLockStateWaiting || waitingJob.Lock.HolderJobID != firstOverviewJobID {
+		t.Errorf("Unexpected lock of the waiting job %+v", waitingJob.Lock)
+	}
+
+	if err := queue.ResumeJob(firstOverviewJobID); err != nil {
+		t.Fatalf("ResumeJob failed: %v", err)
+	}
+	if claimed := queue.claimNextJob(buildPool, 1, false); claimed == nil || claimed.ID != firstOverviewJobID {
+		t.Fatalf("Expected the resumed overview to be claimed before the waiting one, got %+v", claimed)
+	}
+}
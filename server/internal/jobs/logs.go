@@ -0,0 +1,165 @@
+package jobs
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"lectures/internal/database"
+	"lectures/internal/models"
+)
+
+// Bounds of job logs: the lines kept for a job, so a job stuck retrying cannot fill the database, and the lines
+// waiting to be written, beyond which new ones are dropped rather than slowing down the code logging them
+const (
+	maximumJobLogLines  = 2000
+	jobLogWriterBacklog = 1024
+)
+
+type jobIDKey struct{}
+
+// WithJobID attaches the job running with a context, so the lines logged with it are recorded in the job's log
+func WithJobID(parent context.Context, jobID string) context.Context {
+	return context.WithValue(parent, jobIDKey{}, jobID)
+}
+
+// JobIDFromContext returns the job running with a context, or an empty string
+func JobIDFromContext(jobContext context.Context) string {
+	jobID, _ := jobContext.Value(jobIDKey{}).(string)
+	return jobID
+}
+
+// LogHandler passes every record on to another handler and records those about a job in the job's log: records
+// logged with its job context, or with a "jobID" attribute. Lines are written in the background, since they may
+// be logged within a transaction that would hold the database until they are
+type LogHandler struct {
+	next       slog.Handler
+	writer     *jobLogWriter
+	attributes []slog.Attr
+	group      string
+}
+
+// jobLogWriter writes the lines of job logs one at a time, calling onLog, when set, with every recorded line
+type jobLogWriter struct {
+	database *sql.DB
+	onLog    func(models.JobLog)
+	lines    chan models.JobLog
+}
+
+// NewLogHandler creates a handler recording job logs in the database and passing records on to next
+func NewLogHandler(next slog.Handler, database *sql.DB, onLog func(models.JobLog)) *LogHandler {
+	writer := &jobLogWriter{database: database, onLog: onLog, lines: make(chan models.JobLog, jobLogWriterBacklog)}
+	go writer.run()
+	return &LogHandler{next: next, writer: writer}
+}
+
+func (writer *jobLogWriter) run() {
+	for line := range writer.lines {
+		jobLog, recorded, err := database.AppendJobLog(writer.database, line, maximumJobLogLines)
+		if err != nil {
+			// Logged without the job, which would record it again
+			slog.Error("Failed to record job log", "error", err)
+		} else if recorded && writer.onLog != nil {
+			writer.onLog(jobLog)
+		}
+	}
+}
+
+func (handler *LogHandler) Enabled(logContext context.Context, level slog.Level) bool {
+	return handler.next.Enabled(logContext, level)
+}
+
+func (handler *LogHandler) Handle(logContext context.Context, record slog.Record) error {
+	handlingError := handler.next.Handle(logContext, record)
+
+	attributes := make(map[string]any)
+	for _, attribute := range handler.attributes {
+		addLogAttribute(attributes, "", attribute)
+	}
+	record.Attrs(func(attribute slog.Attr) bool {
+		addLogAttribute(attributes, handler.group, attribute)
+		return true
+	})
+
+	jobID := JobIDFromContext(logContext)
+	if jobID == "" {
+		jobID, _ = attributes["jobID"].(string)
+	}
+	if jobID == "" {
+		return handlingError
+	}
+	delete(attributes, "jobID")
+
+	select {
+	case handler.writer.lines <- models.JobLog{
+		JobID:      jobID,
+		Level:      record.Level.String(),
+		Message:    record.Message,
+		Attributes: attributes,
+		CreatedAt:  record.Time.UTC().Truncate(time.Millisecond),
+	}:
+	default:
+	}
+	return handlingError
+}
+
+func (handler *LogHandler) WithAttrs(attributes []slog.Attr) slog.Handler {
+	derived := *handler
+	derived.next = handler.next.WithAttrs(attributes)
+	derived.attributes = append([]slog.Attr{}, handler.attributes...)
+	for _, attribute := range attributes {
+		attribute.Key = handler.group + attribute.Key
+		derived.attributes = append(derived.attributes, attribute)
+	}
+	return &derived
+}
+
+func (handler *LogHandler) WithGroup(name string) slog.Handler {
+	if name == "" {
+		return handler
+	}
+	derived := *handler
+	derived.next = handler.next.WithGroup(name)
+	derived.group = handler.group + name + "."
+	return &derived
+}
+
+// addLogAttribute adds an attribute to the attributes of a job log line, groups being flattened into dotted keys
+// and values that do not encode to JSON, errors included, stored as text
+func addLogAttribute(attributes map[string]any, prefix string, attribute slog.Attr) {
+	value := attribute.Value.Resolve()
+	if value.Kind() == slog.KindGroup {
+		groupPrefix := prefix
+		if attribute.Key != "" {
+			groupPrefix += attribute.Key + "."
+		}
+		for _, member := range value.Group() {
+			addLogAttribute(attributes, groupPrefix, member)
+		}
+		return
+	}
+	if attribute.Key == "" {
+		return
+	}
+
+	switch value.Kind() {
+	case slog.KindDuration:
+		attributes[prefix+attribute.Key] = value.Duration().String()
+	case slog.KindTime:
+		attributes[prefix+attribute.Key] = value.Time().Format(time.RFC3339)
+	case slog.KindAny:
+		anyValue := value.Any()
+		if err, isError := anyValue.(error); isError {
+			attributes[prefix+attribute.Key] = err.Error()
+		} else if _, err := json.Marshal(anyValue); err != nil {
+			attributes[prefix+attribute.Key] = fmt.Sprint(anyValue)
+		} else {
+			attributes[prefix+attribute.Key] = anyValue
+		}
+	default:
+		attributes[prefix+attribute.Key] = value.Any()
+	}
+}
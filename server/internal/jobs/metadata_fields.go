@@ -0,0 +1,20 @@
+package jobs
+
+import (
+	"database/sql"
+	"log/slog"
+
+	"lectures/internal/database"
+	"lectures/internal/models"
+)
+
+// lectureMetadataFields returns the metadata fields printed on the exports of a lecture and given to the prompts
+// of its materials. Exports and generation go on without them when they cannot be read
+func lectureMetadataFields(db *sql.DB, examID string, lectureID string) []models.MetadataField {
+	fields, err := database.GetMetadataFields(db, examID, lectureID)
+	if err != nil {
+		slog.Warn("Failed to read metadata fields", "examID", examID, "lectureID", lectureID, "error", err)
+		return nil
+	}
+	return fields
+}
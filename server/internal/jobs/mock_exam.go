@@ -0,0 +1,70 @@
+package jobs
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"lectures/internal/models"
+	"lectures/internal/tools"
+
+	gonanoid "github.com/matoous/go-nanoid/v2"
+)
+
+// buildMockExam generates a mock exam from the ready lectures of an exam chosen by the settings and stores it as a
+// tool of no lecture. It returns the ID and title of the tool and the metrics of the build
+func buildMockExam(
+	jobContext context.Context,
+	db *sql.DB,
+	toolGenerator *tools.ToolGenerator,
+	examID string,
+	settings models.MockExamSettings,
+	languageCode string,
+	options models.GenerationOptions,
+	reportBuildProgress func(int, string, any, models.JobMetrics),
+) (string, string, models.JobMetrics, error) {
+	var totalMetrics models.JobMetrics
+	if err := settings.Normalize(); err != nil {
+		return "", "", totalMetrics, fmt.Errorf("invalid mock exam settings: %w", err)
+	}
+	reportBuildProgress(5, "Gathering the lectures of the exam...", models.BuildProgress{Phase: models.BuildPhaseGatheringLectures}, totalMetrics)
+
+	var courseTitle string
+	if err := db.QueryRow("SELECT title FROM exams WHERE id = ?", examID).Scan(&courseTitle); err != nil {
+		return "", "", totalMetrics, fmt.Errorf("failed to get exam: %w", err)
+	}
+	lectures, _, err := gatherCourseLectures(db, examID, settings.LectureIDs, languageCode, &options)
+	if err != nil {
+		return "", "", totalMetrics, err
+	}
+
+	toolContent, toolTitle, totalMetrics, err := toolGenerator.GenerateMockExam(jobContext, courseTitle, lectures, settings, languageCode, options, reportBuildProgress)
+	if err != nil {
+		return "", "", totalMetrics, fmt.Errorf("tool generation failed: %w", err)
+	}
+
+	reportBuildProgress(95, "Finalizing tool...", models.BuildProgress{Phase: models.BuildPhaseFinalizing}, totalMetrics)
+
+	toolID, _ := gonanoid.New()
+	transaction, err := db.Begin()
+	if err != nil {
+		return "", "", totalMetrics, fmt.Errorf("failed to begin transaction for tool storage: %w", err)
+	}
+	defer transaction.Rollback()
+
+	_, err = transaction.Exec(`
+		INSERT INTO tools (id, exam_id, lecture_id, type, title, language_code, content, estimated_cost, partial_sources, created_at, updated_at)
+		VALUES (?, ?, NULL, ?, ?, ?, ?, ?, 0, ?, ?)
+	`, toolID, examID, models.ToolTypeMockExam, toolTitle, languageCode, toolContent, totalMetrics.EstimatedCost, time.Now(), time.Now())
+	if err != nil {
+		return "", "", totalMetrics, fmt.Errorf("failed to store tool: %w", err)
+	}
+	if _, err := transaction.Exec("UPDATE exams SET estimated_cost = estimated_cost + ?, updated_at = ? WHERE id = ?", totalMetrics.EstimatedCost, time.Now(), examID); err != nil {
+		return "", "", totalMetrics, fmt.Errorf("failed to update exam estimated cost: %w", err)
+	}
+	if err := transaction.Commit(); err != nil {
+		return "", "", totalMetrics, fmt.Errorf("failed to commit tool storage: %w", err)
+	}
+	return toolID, toolTitle, totalMetrics, nil
+}
@@ -0,0 +1,67 @@
+package jobs
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"mime"
+	"os"
+	"path/filepath"
+
+	"lectures/internal/models"
+	"lectures/internal/storage"
+)
+
+// SetObjectStore keeps the media, page images and exports jobs produce in an object store rather than the
+// database. It must be called before RegisterHandlers
+func (queue *Queue) SetObjectStore(objectStore storage.ObjectStore) {
+	queue.objectStore = objectStore
+}
+
+// completeExport stores the file a job exported, so backups are self-contained, and sets it as the job's result
+func completeExport(jobContext context.Context, db *sql.DB, objectStore storage.ObjectStore, job *models.Job, outputPath string, format string) error {
+	if err := storeExport(jobContext, db, objectStore, job.ID, outputPath); err != nil {
+		return err
+	}
+	job.Result = fmt.Sprintf(`{"file_path": "%s", "format": "%s"}`, outputPath, format)
+	return nil
+}
+
+// storeExport records the file a job exported as its export: in the object store when one is configured, in
+// export_data otherwise
+func storeExport(jobContext context.Context, db *sql.DB, objectStore storage.ObjectStore, jobID string, outputPath string) error {
+	exportBytes, err := os.ReadFile(outputPath)
+	if err != nil {
+		return fmt.Errorf("failed to read export: %w", err)
+	}
+	fileName := filepath.Base(outputPath)
+	exportData, objectKey, err := storage.StoreContent(jobContext, objectStore, storage.ExportObjectKey(jobID, fileName), exportBytes, mime.TypeByExtension(filepath.Ext(fileName)))
+	if err != nil {
+		return err
+	}
+	if _, err := db.Exec("UPDATE jobs SET export_data = ?, export_object_key = ?, export_size_bytes = ? WHERE id = ?", exportData, objectKey, len(exportBytes), jobID); err != nil {
+		return fmt.Errorf("failed to record export: %w", err)
+	}
+	return nil
+}
+
+// loadExport returns the file a job last exported and its object key, empty when it is kept in the database
+func loadExport(jobContext context.Context, db *sql.DB, objectStore storage.ObjectStore, jobID string) ([]byte, string, error) {
+	var exportData []byte
+	var objectKey sql.NullString
+	if err := db.QueryRow("SELECT export_data, export_object_key FROM jobs WHERE id = ?", jobID).Scan(&exportData, &objectKey); err != nil {
+		return nil, "", err
+	}
+	content, err := storage.LoadContent(jobContext, objectStore, exportData, objectKey.String)
+	return content, objectKey.String, err
+}
+
+// restorePageImage writes the image of a reference page, kept in its BLOB or the object store, to a file for
+// processing. It returns false when the page has no stored image
+func restorePageImage(jobContext context.Context, objectStore storage.ObjectStore, imageData []byte, objectKey string, restoredPath string) bool {
+	content, err := storage.LoadContent(jobContext, objectStore, imageData, objectKey)
+	if err != nil || len(content) == 0 {
+		return false
+	}
+	return os.WriteFile(restoredPath, content, 0644) == nil
+}
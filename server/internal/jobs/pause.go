@@ -0,0 +1,139 @@
+package jobs
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"lectures/internal/models"
+)
+
+// PauseJob pauses a job. A pending job is kept from starting at once; a running one is asked to stop at its next
+// stage boundary, such as a document page or a guide section, and waits with its checkpoint until resumed. Jobs
+// without stage boundaries finish as usual
+func (queue *Queue) PauseJob(jobID string) error {
+	result, err := queue.database.Exec(`
+		UPDATE jobs SET paused_at = CASE status WHEN ? THEN ? ELSE paused_at END, pause_requested = (status = ?)
+		WHERE id = ? AND status IN (?, ?)
+	`, models.JobStatusPending, time.Now(), models.JobStatusRunning, jobID, models.JobStatusPending, models.JobStatusRunning)
+	if err != nil {
+		return fmt.Errorf("failed to pause job: %w", err)
+	}
+	if affectedRows, _ := result.RowsAffected(); affectedRows == 0 {
+		return fmt.Errorf("job %s is not active", jobID)
+	}
+
+	slog.Info("Job pause requested", "jobID", jobID)
+	queue.publishStateUpdate(jobID)
+	return nil
+}
+
+// ResumeJob lets a paused job start again, from its checkpoint, or withdraws a pause the job has not reached yet
+func (queue *Queue) ResumeJob(jobID string) error {
+	result, err := queue.database.Exec(`
+		UPDATE jobs SET paused_at = NULL, pause_requested = 0
+		WHERE id = ? AND status IN (?, ?) AND (paused_at IS NOT NULL OR pause_requested = 1)
+	`, jobID, models.JobStatusPending, models.JobStatusRunning)
+	if err != nil {
+		return fmt.Errorf("failed to resume job: %w", err)
+	}
+	if affectedRows, _ := result.RowsAffected(); affectedRows == 0 {
+		return fmt.Errorf("job %s is not paused", jobID)
+	}
+
+	slog.Info("Job resumed", "jobID", jobID)
+	queue.publishStateUpdate(jobID)
+	return nil
+}
+
+// pauseRequested reports whether the running job was asked to pause; it backs the pause check of job contexts
+func (queue *Queue) pauseRequested(jobID string) bool {
+	var requested bool
+	_ = queue.database.QueryRow("SELECT COALESCE(pause_requested, 0) FROM jobs WHERE id = ?", jobID).Scan(&requested)
+	return requested
+}
+
+// parkPausedJob puts a job whose handler stopped at a pause back in the queue, paused, keeping its progress
+func (queue *Queue) parkPausedJob(jobID string) {
+	_, err := queue.database.Exec(`
+		UPDATE jobs SET status = ?, pause_requested = 0, paused_at = ?
+		WHERE id = ? AND status = ?
+	`, models.JobStatusPending, time.Now(), jobID, models.JobStatusRunning)
+	if err != nil {
+		slog.Error("Failed to park paused job", "jobID", jobID, "error", err)
+		return
+	}
+
+	slog.Info("Job paused", "jobID", jobID)
+	queue.publishStateUpdate(jobID)
+}
+
+// publishStateUpdate sends the current status of a job to its subscribers, with whether it is paused
+func (queue *Queue) publishStateUpdate(jobID string) {
+	job, err := queue.GetJob(jobID)
+	if err != nil {
+		return
+	}
+
+	var parsedPayload any
+	_ = json.Unmarshal([]byte(job.Payload), &parsedPayload)
+	update := JobUpdate{
+		JobID:               job.ID,
+		Type:                job.Type,
+		Status:              job.Status,
+		Paused:              job.PausedAt != nil,
+		Progress:            job.Progress,
+		ProgressMessageText: job.ProgressMessageText,
+		Payload:             parsedPayload,
+		CourseID:            job.CourseID,
+		LectureID:           job.LectureID,
+	}
+	queue.publishUpdate(update)
+	if queue.OnUpdate != nil {
+		queue.OnUpdate(job, update)
+	}
+}
+
+// jobCheckpoint stores the state a job resumes from after a pause, as JSON values under keys of its choosing.
+// The values are removed once the job completes
+type jobCheckpoint struct {
+	database *sql.DB
+	jobID    string
+}
+
+// load reads the value stored under key into value, reporting whether there was one
+func (checkpoint *jobCheckpoint) load(key string, value any) bool {
+	var valueJSON string
+	if err := checkpoint.database.QueryRow("SELECT value FROM job_checkpoints WHERE job_id = ? AND key = ?", checkpoint.jobID, key).Scan(&valueJSON); err != nil {
+		return false
+	}
+	return json.Unmarshal([]byte(valueJSON), value) == nil
+}
+
+// save stores value under key, replacing the previous one
+func (checkpoint *jobCheckpoint) save(key string, value any) {
+	valueJSON, err := json.Marshal(value)
+	if err == nil {
+		_, err = checkpoint.database.Exec(
+			"INSERT INTO job_checkpoints (job_id, key, value) VALUES (?, ?, ?) ON CONFLICT(job_id, key) DO UPDATE SET value = excluded.value",
+			checkpoint.jobID, key, string(valueJSON),
+		)
+	}
+	if err != nil {
+		slog.Warn("Failed to store job checkpoint", "jobID", checkpoint.jobID, "key", key, "error", err)
+	}
+}
+
+// LoadPage returns the page of a document read before the job was paused; it implements documents.PageCheckpoint
+func (checkpoint *jobCheckpoint) LoadPage(documentID string, pageNumber int) (models.ReferencePage, bool) {
+	var page models.ReferencePage
+	found := checkpoint.load(fmt.Sprintf("page:%s:%d", documentID, pageNumber), &page)
+	return page, found
+}
+
+// SavePage records a page of a document as read
+func (checkpoint *jobCheckpoint) SavePage(page models.ReferencePage) {
+	checkpoint.save(fmt.Sprintf("page:%s:%d", page.DocumentID, page.PageNumber), page)
+}
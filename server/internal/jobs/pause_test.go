@@ -0,0 +1,83 @@
+package jobs
+
+import (
+	"context"
+	"testing"
+
+	"lectures/internal/models"
+)
+
+func TestQueue_PauseAndResume(t *testing.T) {
+	queue, cleanup := setupQueueTestDatabase(t)
+	defer cleanup()
+	pool := queue.pools[0]
+
+	t.Run("Pending job", func(t *testing.T) {
+		jobID, _ := queue.Enqueue("user-1", models.JobTypeSuggest, map[string]string{}, "", "")
+		if err := queue.PauseJob(jobID); err != nil {
+			t.Fatalf("PauseJob failed: %v", err)
+		}
+		if claimed := queue.claimNextJob(pool, 0, false); claimed != nil {
+			t.Fatalf("Claimed paused job %s", claimed.ID)
+		}
+		if err := queue.ResumeJob(jobID); err != nil {
+			t.Fatalf("ResumeJob failed: %v", err)
+		}
+		if claimed := queue.claimNextJob(pool, 0, false); claimed == nil || claimed.ID != jobID {
+			t.Fatalf("Expected the resumed job to be claimed, got %+v", claimed)
+		}
+		queue.completeJob(jobID, "")
+
+		if err := queue.PauseJob(jobID); err == nil {
+			t.Error("Expected pausing a completed job to be rejected")
+		}
+	})
+
+	t.Run("Running job stops at a stage boundary", func(t *testing.T) {
+		const stages = 3
+		var runs int
+		queue.RegisterHandler("STAGED", func(jobContext context.Context, job *models.Job, updateProgress func(int, string, any, models.JobMetrics)) error {
+			runs++
+			checkpoint := &jobCheckpoint{database: queue.database, jobID: job.ID}
+			var completedStages int
+			checkpoint.load("stages", &completedStages)
+			for ; completedStages < stages; completedStages++ {
+				if err := models.CheckPause(jobContext); err != nil {
+					return err
+				}
+				// The user pauses the job while its first stage runs
+				if runs == 1 && completedStages == 0 {
+					queue.PauseJob(job.ID)
+				}
+				checkpoint.save("stages", completedStages+1)
+			}
+			return nil
+		})
+
+		jobID, _ := queue.Enqueue("user-1", "STAGED", map[string]string{}, "", "")
+		queue.executeJob(queue.claimNextJob(pool, 0, false))
+
+		job, _ := queue.GetJob(jobID)
+		if job.Status != models.JobStatusPending || job.PausedAt == nil || job.PauseRequested {
+			t.Fatalf("Expected the job to wait paused, got %s (paused at %v, requested %v)", job.Status, job.PausedAt, job.PauseRequested)
+		}
+		var completedStages int
+		(&jobCheckpoint{database: queue.database, jobID: jobID}).load("stages", &completedStages)
+		if completedStages != 1 {
+			t.Fatalf("Expected the first stage in the checkpoint, got %d", completedStages)
+		}
+
+		queue.ResumeJob(jobID)
+		queue.executeJob(queue.claimNextJob(pool, 0, false))
+
+		job, _ = queue.GetJob(jobID)
+		if job.Status != models.JobStatusCompleted || runs != 2 {
+			t.Fatalf("Expected the job to complete on its second run, got %s after %d runs", job.Status, runs)
+		}
+		var leftoverCheckpoints int
+		queue.database.QueryRow("SELECT COUNT(*) FROM job_checkpoints WHERE job_id = ?", jobID).Scan(&leftoverCheckpoints)
+		if leftoverCheckpoints != 0 {
+			t.Errorf("Expected the checkpoint to be removed once the job completed, %d left", leftoverCheckpoints)
+		}
+	})
+}
@@ -0,0 +1,218 @@
+package jobs
+
+import (
+	"log/slog"
+	"slices"
+	"strings"
+	"time"
+
+	"lectures/internal/configuration"
+	"lectures/internal/models"
+)
+
+// Worker pools, named as in jobs.concurrency of the configuration
+const (
+	PoolTranscribe = "transcribe"
+	PoolIngest     = "ingest"
+	PoolBuild      = "build"
+	PoolPublish    = "publish"
+)
+
+// DefaultPoolConcurrency is the number of workers of each pool unless configured otherwise. A single
+// transcription keeps whisper from taking every core, while exports are quick and mostly wait on XeLaTeX
+var DefaultPoolConcurrency = map[string]int{
+	PoolTranscribe: 1,
+	PoolIngest:     2,
+	PoolBuild:      2,
+	PoolPublish:    4,
+}
+
+// poolNames orders the pools for startup and logs
+var poolNames = []string{PoolTranscribe, PoolIngest, PoolBuild, PoolPublish}
+
+// poolJobTypes assigns job types to pools; types not listed, such as generation, run in the build pool
+var poolJobTypes = map[string][]string{
+	PoolTranscribe: {models.JobTypeTranscribeMedia, models.JobTypeImportYouTube, models.JobTypeRedactMedia},
+	PoolIngest:     {models.JobTypeIngestDocuments, models.JobTypeIngestURL, models.JobTypeDownloadGoogleDrive, models.JobTypeImportSyllabus},
+	PoolPublish:    {models.JobTypePublishMaterial, models.JobTypePublishBundle},
+}
+
+// poolScalingInterval is how often scalable pools are resized to their queue
+const poolScalingInterval = 5 * time.Second
+
+// workerPool runs the jobs of some types with workers of its own, so they never wait for workers busy with
+// other types. workers and busyWorkers are guarded by the dispatch mutex of the queue
+type workerPool struct {
+	name           string
+	workers        int
+	minimumWorkers int
+	maximumWorkers int
+	jobTypes       []string // Types run by the pool, or every type not in excludedTypes when empty
+	excludedTypes  []string
+	busyWorkers    int
+	nextWorkerID   int
+	retire         chan struct{} // Received by an idle worker of the pool when it is scaled down
+}
+
+// scalable reports whether the pool grows and shrinks with its queue
+func (pool *workerPool) scalable() bool {
+	return pool.maximumWorkers > pool.minimumWorkers
+}
+
+// runs reports whether jobs of jobType are run by the pool
+func (pool *workerPool) runs(jobType string) bool {
+	if len(pool.jobTypes) > 0 {
+		return slices.Contains(pool.jobTypes, jobType)
+	}
+	return !slices.Contains(pool.excludedTypes, jobType)
+}
+
+// typeCondition returns the SQL condition on the job type selecting the jobs of the pool, with its arguments
+func (pool *workerPool) typeCondition() (string, []any) {
+	jobTypes, operator := pool.jobTypes, "IN"
+	if len(jobTypes) == 0 {
+		jobTypes, operator = pool.excludedTypes, "NOT IN"
+	}
+	if len(jobTypes) == 0 {
+		return "", nil
+	}
+	condition := " AND type " + operator + " (?" + strings.Repeat(", ?", len(jobTypes)-1) + ")"
+	arguments := make([]any, len(jobTypes))
+	for index, jobType := range jobTypes {
+		arguments[index] = jobType
+	}
+	return condition, arguments
+}
+
+// SetConcurrency splits the workers of the queue into the transcribe, ingest, build and publish pools, each
+// starting with the number of workers configured for it or its DefaultPoolConcurrency. Pools with scaling bounds
+// are then resized between them as their queue grows and drains. It must be called before Start
+func (queue *Queue) SetConcurrency(concurrency map[string]int, scaling map[string]configuration.PoolScalingConfiguration) {
+	for name := range concurrency {
+		if !slices.Contains(poolNames, name) {
+			slog.Warn("Ignoring concurrency of unknown job pool", "pool", name, "pools", poolNames)
+		}
+	}
+	for name, bounds := range scaling {
+		if !slices.Contains(poolNames, name) {
+			slog.Warn("Ignoring scaling of unknown job pool", "pool", name, "pools", poolNames)
+		} else if bounds.MinimumWorkers < 1 || bounds.MaximumWorkers < bounds.MinimumWorkers {
+			slog.Warn("Ignoring invalid scaling bounds of job pool", "pool", name, "minimum", bounds.MinimumWorkers, "maximum", bounds.MaximumWorkers)
+		}
+	}
+
+	var assignedTypes []string
+	for _, jobTypes := range poolJobTypes {
+		assignedTypes = append(assignedTypes, jobTypes...)
+	}
+
+	queue.pools = nil
+	for _, name := range poolNames {
+		workers := DefaultPoolConcurrency[name]
+		if configured := concurrency[name]; configured > 0 {
+			workers = configured
+		}
+		pool := &workerPool{name: name, workers: workers, minimumWorkers: workers, maximumWorkers: workers, jobTypes: poolJobTypes[name]}
+		if bounds, found := scaling[name]; found && bounds.MinimumWorkers >= 1 && bounds.MaximumWorkers >= bounds.MinimumWorkers {
+			pool.minimumWorkers, pool.maximumWorkers = bounds.MinimumWorkers, bounds.MaximumWorkers
+			pool.workers = min(max(workers, bounds.MinimumWorkers), bounds.MaximumWorkers)
+		}
+		if len(pool.jobTypes) == 0 {
+			pool.excludedTypes = assignedTypes
+		}
+		queue.pools = append(queue.pools, pool)
+	}
+}
+
+// poolFor returns the pool running jobs of jobType
+func (queue *Queue) poolFor(jobType string) *workerPool {
+	for _, pool := range queue.pools {
+		if pool.runs(jobType) {
+			return pool
+		}
+	}
+	return nil
+}
+
+// PoolStatistics lists the worker pools of the queue with their current, busy and allowed workers
+func (queue *Queue) PoolStatistics() []QueuePoolStatistics {
+	queue.dispatchMutex.Lock()
+	defer queue.dispatchMutex.Unlock()
+
+	statistics := make([]QueuePoolStatistics, 0, len(queue.pools))
+	for _, pool := range queue.pools {
+		statistics = append(statistics, QueuePoolStatistics{
+			Name:           pool.name,
+			Workers:        pool.workers,
+			Busy:           pool.busyWorkers,
+			MinimumWorkers: pool.minimumWorkers,
+			MaximumWorkers: pool.maximumWorkers,
+		})
+	}
+	return statistics
+}
+
+// startWorkers adds count workers to the pool. The caller accounts for them in pool.workers
+func (queue *Queue) startWorkers(pool *workerPool, count int) {
+	for range count {
+		queue.waitGroup.Add(1)
+		go queue.worker(pool, pool.nextWorkerID)
+		pool.nextWorkerID++
+	}
+}
+
+// scaler resizes the scalable pools to their queue until the queue stops
+func (queue *Queue) scaler() {
+	defer queue.waitGroup.Done()
+
+	ticker := time.NewTicker(poolScalingInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-queue.context.Done():
+			return
+		case <-ticker.C:
+			queue.scalePools()
+		}
+	}
+}
+
+// scalePools gives each scalable pool a worker for every running and startable pending job of its types, plus the idle
+// worker kept for high priority jobs, within its bounds. Pools grow at once but shrink by one idle worker per
+// interval, so a burst of short exports does not make them flap. Paused intake never grows a pool
+func (queue *Queue) scalePools() {
+	paused := queue.IsPaused()
+	for _, pool := range queue.pools {
+		if !pool.scalable() {
+			continue
+		}
+
+		query := "SELECT COUNT(*) FROM jobs WHERE status = ? AND paused_at IS NULL"
+		arguments := []any{models.JobStatusPending}
+		typeCondition, typeArguments := pool.typeCondition()
+		var pendingJobs int
+		if err := queue.database.QueryRow(query+typeCondition+dependenciesMetCondition+lockFreeCondition, append(arguments, typeArguments...)...).Scan(&pendingJobs); err != nil {
+			slog.Warn("Failed to count pending jobs of pool", "pool", pool.name, "error", err)
+			continue
+		}
+
+		queue.dispatchMutex.Lock()
+		desiredWorkers := min(max(pool.busyWorkers+pendingJobs+1, pool.minimumWorkers), pool.maximumWorkers)
+		switch {
+		case desiredWorkers > pool.workers && !paused:
+			slog.Info("Scaling job pool up", "pool", pool.name, "workers", desiredWorkers, "pending", pendingJobs, "busy", pool.busyWorkers)
+			queue.startWorkers(pool, desiredWorkers-pool.workers)
+			pool.workers = desiredWorkers
+		case desiredWorkers < pool.workers && pool.busyWorkers < pool.workers:
+			// Only a worker waiting for its next tick receives, so a busy one is never retired
+			select {
+			case pool.retire <- struct{}{}:
+				pool.workers--
+				slog.Info("Scaling job pool down", "pool", pool.name, "workers", pool.workers)
+			default:
+			}
+		}
+		queue.dispatchMutex.Unlock()
+	}
+}
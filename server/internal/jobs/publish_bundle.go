@@ -0,0 +1,190 @@
+package jobs
+
+import (
+	"archive/zip"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"lectures/internal/models"
+	"lectures/internal/storage"
+)
+
+// publishBundleHandler exports one or more tools in several formats and packs the results into a single zip
+func publishBundleHandler(database *sql.DB, objectStore storage.ObjectStore, publishMaterial JobHandler) JobHandler {
+	return func(jobContext context.Context, job *models.Job, updateProgress func(int, string, any, models.JobMetrics)) error {
+		var payload struct {
+			ExamID        string   `json:"exam_id"`
+			ToolIDs       []string `json:"tool_ids"`
+			Formats       []string `json:"formats"`
+			Theme         string   `json:"theme"`
+			IncludeImages bool     `json:"include_images"`
+			IncludeQRCode bool     `json:"include_qr_code"`
+			SelfContained bool     `json:"self_contained"`
+		}
+		if unmarshalingError := json.Unmarshal([]byte(job.Payload), &payload); unmarshalingError != nil {
+			return fmt.Errorf("failed to unmarshal job payload: %w", unmarshalingError)
+		}
+		if len(payload.ToolIDs) == 0 || len(payload.Formats) == 0 {
+			return fmt.Errorf("publish bundle requires at least one tool and one format")
+		}
+
+		var examTitle string
+		database.QueryRow("SELECT title FROM exams WHERE id = ?", payload.ExamID).Scan(&examTitle)
+
+		exportDirectory := filepath.Join(os.TempDir(), "lectures-exports", job.ID)
+		bundleFilename := sanitizeFilename(examTitle) + " exports.zip"
+		if len(payload.ToolIDs) == 1 {
+			var toolTitle string
+			database.QueryRow("SELECT title FROM tools WHERE id = ?", payload.ToolIDs[0]).Scan(&toolTitle)
+			bundleFilename = sanitizeFilename(toolTitle) + " exports.zip"
+		}
+
+		type bundleEntry struct {
+			name string
+			data []byte
+		}
+		var entries []bundleEntry
+		var failures []map[string]string
+		usedNames := make(map[string]int)
+		var totalMetrics models.JobMetrics
+
+		totalSteps := len(payload.ToolIDs) * len(payload.Formats)
+		step := 0
+		for _, toolID := range payload.ToolIDs {
+			for _, format := range payload.Formats {
+				if jobContext.Err() != nil {
+					return jobContext.Err()
+				}
+
+				exportPayload, _ := json.Marshal(map[string]any{
+					"tool_id":         toolID,
+					"format":          format,
+					"theme":           payload.Theme,
+					"include_images":  payload.IncludeImages,
+					"include_qr_code": payload.IncludeQRCode,
+					"self_contained":  payload.SelfContained,
+				})
+				// Each export shares the bundle's job ID, so its bytes land in this job's export_data row
+				exportJob := &models.Job{
+					ID:        job.ID,
+					UserID:    job.UserID,
+					CourseID:  job.CourseID,
+					Type:      models.JobTypePublishMaterial,
+					Payload:   string(exportPayload),
+					CreatedAt: job.CreatedAt,
+				}
+
+				database.Exec("UPDATE jobs SET export_data = NULL, export_object_key = NULL WHERE id = ?", job.ID)
+
+				currentStep := step
+				var exportMetrics models.JobMetrics
+				exportProgress := func(progress int, message string, metadata any, metrics models.JobMetrics) {
+					if metrics.InputTokens > 0 || metrics.OutputTokens > 0 || metrics.EstimatedCost > 0 {
+						exportMetrics = metrics
+					}
+					runningMetrics := totalMetrics
+					runningMetrics.InputTokens += exportMetrics.InputTokens
+					runningMetrics.OutputTokens += exportMetrics.OutputTokens
+					runningMetrics.EstimatedCost += exportMetrics.EstimatedCost
+					runningMetrics.EstimatedInputTokens += exportMetrics.EstimatedInputTokens
+					updateProgress((currentStep*100+progress)/totalSteps*9/10, fmt.Sprintf("[%d/%d] %s", currentStep+1, totalSteps, message), metadata, runningMetrics)
+				}
+
+				exportError := publishMaterial(jobContext, exportJob, exportProgress)
+				totalMetrics.InputTokens += exportMetrics.InputTokens
+				totalMetrics.OutputTokens += exportMetrics.OutputTokens
+				totalMetrics.EstimatedCost += exportMetrics.EstimatedCost
+				totalMetrics.EstimatedInputTokens += exportMetrics.EstimatedInputTokens
+				step++
+
+				if exportError != nil {
+					slog.WarnContext(jobContext, "Bundle export failed", "jobID", job.ID, "toolID", toolID, "format", format, "error", exportError)
+					failures = append(failures, map[string]string{"tool_id": toolID, "format": format, "error": exportError.Error()})
+					continue
+				}
+
+				var exportResult struct {
+					FilePath string `json:"file_path"`
+				}
+				json.Unmarshal([]byte(exportJob.Result), &exportResult)
+
+				exportData, objectKey, _ := loadExport(jobContext, database, objectStore, job.ID)
+				// Exports of the bundle only live inside it
+				if objectKey != "" {
+					objectStore.Delete(jobContext, objectKey)
+				}
+				if len(exportData) == 0 {
+					failures = append(failures, map[string]string{"tool_id": toolID, "format": format, "error": "export produced no data"})
+					continue
+				}
+
+				entryName := filepath.Base(exportResult.FilePath)
+				if count := usedNames[entryName]; count > 0 {
+					extension := filepath.Ext(entryName)
+					entryName = fmt.Sprintf("%s (%d)%s", strings.TrimSuffix(entryName, extension), count+1, extension)
+				}
+				usedNames[filepath.Base(exportResult.FilePath)]++
+				entries = append(entries, bundleEntry{name: entryName, data: exportData})
+			}
+		}
+
+		if len(entries) == 0 {
+			return fmt.Errorf("all %d exports failed", totalSteps)
+		}
+
+		updateProgress(95, "Packing export bundle...", nil, totalMetrics)
+		if mkdirError := os.MkdirAll(exportDirectory, 0755); mkdirError != nil {
+			return fmt.Errorf("failed to create export directory: %w", mkdirError)
+		}
+		defer os.RemoveAll(exportDirectory)
+
+		outputPath := filepath.Join(exportDirectory, bundleFilename)
+		bundleFile, createError := os.Create(outputPath)
+		if createError != nil {
+			return fmt.Errorf("failed to create bundle: %w", createError)
+		}
+		zipWriter := zip.NewWriter(bundleFile)
+		for _, entry := range entries {
+			entryWriter, entryError := zipWriter.Create(entry.name)
+			if entryError == nil {
+				_, entryError = entryWriter.Write(entry.data)
+			}
+			if entryError != nil {
+				bundleFile.Close()
+				return fmt.Errorf("failed to write %s to bundle: %w", entry.name, entryError)
+			}
+		}
+		if closeError := zipWriter.Close(); closeError != nil {
+			bundleFile.Close()
+			return fmt.Errorf("failed to finalize bundle: %w", closeError)
+		}
+		bundleFile.Close()
+
+		if storeError := storeExport(jobContext, database, objectStore, job.ID, outputPath); storeError != nil {
+			return fmt.Errorf("failed to store bundle: %w", storeError)
+		}
+
+		resultJSON, _ := json.Marshal(map[string]any{
+			"file_path": outputPath,
+			"format":    "zip",
+			"exports":   len(entries),
+			"failures":  failures,
+		})
+		job.Result = string(resultJSON)
+		recordJobEvent(database, job, models.ResourceEvent{
+			ExamID:       payload.ExamID,
+			ResourceType: models.ResourceTypeExport,
+			ResourceID:   job.ID,
+			Action:       models.ResourceActionExported,
+			Summary:      fmt.Sprintf("Published %d exports of %d tools as %s", len(entries), len(payload.ToolIDs), strings.ToUpper(strings.Join(payload.Formats, ", "))),
+		})
+		updateProgress(100, fmt.Sprintf("Published %d of %d exports", len(entries), totalSteps), nil, totalMetrics)
+		return nil
+	}
+}
@@ -0,0 +1,106 @@
+package jobs
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+	"time"
+
+	"lectures/internal/configuration"
+	"lectures/internal/database"
+	"lectures/internal/models"
+
+	gonanoid "github.com/matoous/go-nanoid/v2"
+)
+
+func TestJob_PublishBundle(t *testing.T) {
+	tempDir := t.TempDir()
+	db, err := database.Initialize(filepath.Join(tempDir, "test.db"))
+	if err != nil {
+		t.Fatalf("Failed to init DB: %v", err)
+	}
+	defer db.Close()
+
+	config := &configuration.Configuration{
+		Storage: configuration.StorageConfiguration{DataDirectory: tempDir},
+		LLM:     configuration.LLMConfiguration{Language: "en-US"},
+	}
+
+	userID := gonanoid.Must()
+	examID := gonanoid.Must()
+	lectureID := gonanoid.Must()
+	jobID := gonanoid.Must()
+	_, _ = db.Exec("INSERT INTO users (id, username, password_hash) VALUES (?, ?, ?)", userID, "tester", "hash")
+	_, _ = db.Exec("INSERT INTO exams (id, user_id, title) VALUES (?, ?, ?)", examID, userID, "Bundle Course")
+	_, _ = db.Exec("INSERT INTO lectures (id, exam_id, title, status) VALUES (?, ?, ?, 'ready')", lectureID, examID, "Bundle Lecture")
+
+	var toolIDs []string
+	for _, title := range []string{"First Guide", "Second Guide"} {
+		toolID := gonanoid.Must()
+		toolIDs = append(toolIDs, toolID)
+		_, _ = db.Exec(`INSERT INTO tools (id, exam_id, lecture_id, type, title, language_code, content, created_at)
+			VALUES (?, ?, ?, 'guide', ?, 'en-US', ?, ?)`, toolID, examID, lectureID, title, "# "+title+"\n\nBody", time.Now())
+	}
+
+	payload, _ := json.Marshal(map[string]any{
+		"exam_id":        examID,
+		"tool_ids":       toolIDs,
+		"formats":        []string{"md", "docx"},
+		"include_images": false,
+	})
+	_, _ = db.Exec("INSERT INTO jobs (id, user_id, course_id, type, payload) VALUES (?, ?, ?, ?, ?)", jobID, userID, examID, models.JobTypePublishBundle, string(payload))
+	job := &models.Job{ID: jobID, UserID: userID, Type: models.JobTypePublishBundle, Payload: string(payload)}
+
+	jobQueue := NewQueue(db, 1)
+	RegisterHandlers(jobQueue, db, config, nil, nil, nil, &MockMarkdownConverter{}, nil, nil)
+
+	lastProgress := 0
+	err = jobQueue.handlers[models.JobTypePublishBundle](context.Background(), job, func(progress int, message string, metadata any, metrics models.JobMetrics) {
+		if progress < lastProgress {
+			t.Errorf("Progress went backwards: %d after %d", progress, lastProgress)
+		}
+		lastProgress = progress
+	})
+	if err != nil {
+		t.Fatalf("Job failed: %v", err)
+	}
+
+	var result struct {
+		FilePath string `json:"file_path"`
+		Exports  int    `json:"exports"`
+	}
+	json.Unmarshal([]byte(job.Result), &result)
+	if result.Exports != 4 || filepath.Base(result.FilePath) != "Bundle Course exports.zip" {
+		t.Errorf("Unexpected result: %s", job.Result)
+	}
+	if _, statErr := os.Stat(result.FilePath); !os.IsNotExist(statErr) {
+		t.Errorf("Expected temporary bundle to be removed, stat error: %v", statErr)
+	}
+
+	var bundleData []byte
+	db.QueryRow("SELECT export_data FROM jobs WHERE id = ?", jobID).Scan(&bundleData)
+	archive, err := zip.NewReader(bytes.NewReader(bundleData), int64(len(bundleData)))
+	if err != nil {
+		t.Fatalf("Stored bundle is not a zip: %v", err)
+	}
+
+	var names []string
+	for _, file := range archive.File {
+		names = append(names, file.Name)
+	}
+	sort.Strings(names)
+	expected := []string{"First Guide.docx", "First Guide.md", "Second Guide.docx", "Second Guide.md"}
+	if len(names) != len(expected) {
+		t.Fatalf("Expected entries %v, got %v", expected, names)
+	}
+	for i := range expected {
+		if names[i] != expected[i] {
+			t.Errorf("Expected entry %q, got %q", expected[i], names[i])
+		}
+	}
+}
@@ -41,6 +41,20 @@ func (m *MockMarkdownConverter) HTMLToDocx(htmlContent, outputPath string, optio
 	m.LastOptions = options
 	return os.WriteFile(outputPath, []byte("fake-docx-content"), 0644)
 }
+func (m *MockMarkdownConverter) HTMLToEPUB(htmlContent, outputPath string, options markdown.ConversionOptions) error {
+	m.LastOptions = options
+	return os.WriteFile(outputPath, []byte("fake-epub-content"), 0644)
+}
+func (m *MockMarkdownConverter) SaveHTML(htmlContent, outputPath string, options markdown.ConversionOptions) error {
+	m.LastOptions = options
+	return os.WriteFile(outputPath, []byte(htmlContent), 0644)
+}
+func (m *MockMarkdownConverter) HTMLToAnki(toolType, toolContent, outputPath string) error {
+	return os.WriteFile(outputPath, []byte("fake-anki-content"), 0644)
+}
+func (m *MockMarkdownConverter) HTMLToCSV(toolType, toolContent, outputPath string) error {
+	return os.WriteFile(outputPath, []byte("fake-csv-content"), 0644)
+}
 func (m *MockMarkdownConverter) SaveMarkdown(markdownText, outputPath string) error {
 	m.LastMarkdown = markdownText
 	return os.WriteFile(outputPath, []byte(markdownText), 0644)
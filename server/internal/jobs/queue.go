@@ -4,29 +4,86 @@ import (
 	"context"
 	"database/sql"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log/slog"
 	"strings"
 	"sync"
 	"time"
 
+	"lectures/internal/configuration"
+	"lectures/internal/llm"
 	"lectures/internal/models"
+	"lectures/internal/storage"
 
 	gonanoid "github.com/matoous/go-nanoid/v2"
 )
 
 // Queue manages background job processing
 type Queue struct {
-	database           *sql.DB
-	workers            int
-	context            context.Context
-	cancel             context.CancelFunc
-	waitGroup          sync.WaitGroup
-	handlers           map[string]JobHandler
-	subscribers        map[string][]chan JobUpdate
-	subscribersMutex   sync.RWMutex
-	heavyTaskSemaphore chan struct{}
-	OnUpdate           func(job *models.Job, update JobUpdate)
+	database         *sql.DB
+	pools            []*workerPool
+	context          context.Context
+	cancel           context.CancelFunc
+	waitGroup        sync.WaitGroup
+	handlers         map[string]JobHandler
+	subscribers      map[string][]chan JobUpdate
+	subscribersMutex sync.RWMutex
+	runningJobs      map[string]context.CancelFunc
+	runningJobsMutex sync.Mutex
+	dispatchMutex    sync.Mutex
+	costBudget       CostBudget
+	configuration    *configuration.Configuration // Set by RegisterHandlers, resolves the model a job is attributed to
+	objectStore      storage.ObjectStore          // Set by SetObjectStore; nil keeps file bytes in the database
+	enabledJobTypes  map[string]bool              // Set by SetHandlerSet; nil enables every type
+	disabledJobTypes map[string]bool              // Set by SetHandlerSet, left out whatever the set
+	OnUpdate         func(job *models.Job, update JobUpdate)
+}
+
+// QueueTypeStatistics counts active jobs of a single type
+type QueueTypeStatistics struct {
+	Type    string `json:"type"`
+	Pool    string `json:"pool"` // Worker pool running jobs of the type
+	Pending int    `json:"pending"`
+	Running int    `json:"running"`
+}
+
+// QueuePoolStatistics describes a worker pool, how many of its workers this process keeps busy and the bounds
+// it is scaled within
+type QueuePoolStatistics struct {
+	Name           string `json:"name"`
+	Workers        int    `json:"workers"`
+	Busy           int    `json:"busy"`
+	MinimumWorkers int    `json:"minimum_workers"`
+	MaximumWorkers int    `json:"maximum_workers"`
+}
+
+// queuePausedSettingKey is the settings row that stores the intake pause flag.
+// It lives in the database so the operator CLI can pause a running server.
+const queuePausedSettingKey = "queue_paused"
+
+// defaultJobPriorities is the priority of each job type enqueued without one. Exports and suggestions are quick
+// and awaited by the user, so they are not left behind hours of transcription; analyses nobody waits on come last
+var defaultJobPriorities = map[string]string{
+	models.JobTypePublishMaterial:   models.JobPriorityHigh,
+	models.JobTypePublishBundle:     models.JobPriorityHigh,
+	models.JobTypeSuggest:           models.JobPriorityHigh,
+	models.JobTypeGenerateRecap:     models.JobPriorityLow,
+	models.JobTypeAnalyzeDuplicates: models.JobPriorityLow,
+	models.JobTypeBackup:            models.JobPriorityLow,
+}
+
+// DefaultJobPriority returns the priority a job of the given type is enqueued with unless one is requested
+func DefaultJobPriority(jobType string) string {
+	if priority, found := defaultJobPriorities[jobType]; found {
+		return priority
+	}
+	return models.JobPriorityNormal
+}
+
+// IsValidJobPriority reports whether priority is one of the models.JobPriority constants
+func IsValidJobPriority(priority string) bool {
+	return priority == models.JobPriorityHigh || priority == models.JobPriorityNormal || priority == models.JobPriorityLow
 }
 
 // JobHandler is a function that processes a specific job type
@@ -34,49 +91,65 @@ type JobHandler func(context context.Context, job *models.Job, updateProgress fu
 
 // JobUpdate represents a job progress update
 type JobUpdate struct {
-	JobID               string  `json:"id"`
-	Type                string  `json:"type"`
-	Status              string  `json:"status"`
-	Progress            int     `json:"progress"`
-	ProgressMessageText string  `json:"progress_message_text"`
-	Metadata            any     `json:"metadata"`
-	Payload             any     `json:"payload"`
-	CourseID            string  `json:"course_id"`
-	LectureID           string  `json:"lecture_id"`
-	Error               string  `json:"error"`
-	Result              string  `json:"result"`
-	InputTokens         int     `json:"input_tokens"`
-	OutputTokens        int     `json:"output_tokens"`
-	EstimatedCost       float64 `json:"estimated_cost"`
-}
-
-// NewQueue creates a new job queue
+	JobID               string             `json:"id"`
+	Type                string             `json:"type"`
+	Status              string             `json:"status"`
+	Paused              bool               `json:"paused,omitempty"` // The pending job waits to be resumed
+	Progress            int                `json:"progress"`
+	ProgressMessageText string             `json:"progress_message_text"`
+	Metadata            any                `json:"metadata"`
+	Payload             any                `json:"payload"`
+	CourseID            string             `json:"course_id"`
+	LectureID           string             `json:"lecture_id"`
+	Error               string             `json:"error"`
+	Failure             *models.JobFailure `json:"failure,omitempty"`
+	Result              string             `json:"result"`
+	InputTokens         int                `json:"input_tokens"`
+	OutputTokens        int                `json:"output_tokens"`
+	EstimatedCost       float64            `json:"estimated_cost"`
+}
+
+// NewQueue creates a new job queue whose workers run jobs of every type, until SetConcurrency splits them
+// into pools
 func NewQueue(database *sql.DB, workers int) *Queue {
 	jobContext, cancel := context.WithCancel(context.Background())
 	return &Queue{
-		database:           database,
-		workers:            workers,
-		context:            jobContext,
-		cancel:             cancel,
-		handlers:           make(map[string]JobHandler),
-		subscribers:        make(map[string][]chan JobUpdate),
-		heavyTaskSemaphore: make(chan struct{}, 4), // Allow 4 heavy tasks at a time
+		database:    database,
+		pools:       []*workerPool{{name: "all", workers: workers, minimumWorkers: workers, maximumWorkers: workers}},
+		context:     jobContext,
+		cancel:      cancel,
+		handlers:    make(map[string]JobHandler),
+		subscribers: make(map[string][]chan JobUpdate),
+		runningJobs: make(map[string]context.CancelFunc),
 	}
 }
 
-// RegisterHandler registers a handler for a specific job type
+// RegisterHandler registers a handler for a specific job type, built-in or not, unless the handler set of the
+// queue leaves the type out
 func (queue *Queue) RegisterHandler(jobType string, handler JobHandler) {
+	if !queue.IsJobTypeEnabled(jobType) {
+		slog.Info("Job type disabled by the handler set", "type", jobType)
+		return
+	}
 	queue.handlers[jobType] = handler
 }
 
 // Start begins processing jobs
 func (queue *Queue) Start() {
 	queue.recoverJobs()
-	for index := 0; index < queue.workers; index++ {
+	scalable := false
+	queue.dispatchMutex.Lock()
+	for _, pool := range queue.pools {
+		pool.retire = make(chan struct{})
+		queue.startWorkers(pool, pool.workers)
+		scalable = scalable || pool.scalable()
+		slog.Info("Job pool started", "pool", pool.name, "workers", pool.workers, "minimum", pool.minimumWorkers, "maximum", pool.maximumWorkers)
+	}
+	queue.dispatchMutex.Unlock()
+	if scalable {
 		queue.waitGroup.Add(1)
-		go queue.worker(index)
+		go queue.scaler()
 	}
-	slog.Info("Job queue started", "workers", queue.workers)
 }
 
 // Stop gracefully shuts down the job queue
@@ -87,24 +160,38 @@ func (queue *Queue) Stop() {
 	slog.Info("Job queue stopped")
 }
 
-// recoverJobs marks jobs that were left in RUNNING state (e.g. server crash) as FAILED
-func (queue *Queue) recoverJobs() {
-	result, err := queue.database.Exec(`
-		UPDATE jobs 
-		SET status = ?, error = 'Server restarted while task was running', completed_at = ?
-		WHERE status = ?
-	`, models.JobStatusFailed, time.Now(), models.JobStatusRunning)
+// Enqueue creates a new job with the default priority of its type and adds it to the queue
+func (queue *Queue) Enqueue(userID string, jobType string, payload interface{}, courseID, lectureID string) (string, error) {
+	return queue.EnqueueWithPriority(userID, jobType, payload, courseID, lectureID, DefaultJobPriority(jobType))
+}
 
-	if err == nil {
-		rows, _ := result.RowsAffected()
-		if rows > 0 {
-			slog.Info("Recovered stuck jobs", "count", rows)
-		}
-	}
+// EnqueueWithPriority creates a new job and adds it to the queue, to be started before the pending jobs of lower
+// priority and after the earlier ones of the same priority
+func (queue *Queue) EnqueueWithPriority(userID string, jobType string, payload interface{}, courseID, lectureID string, priority string) (string, error) {
+	return queue.enqueue(userID, jobType, payload, courseID, lectureID, priority, nil, "")
 }
 
-// Enqueue creates a new job and adds it to the queue
-func (queue *Queue) Enqueue(userID string, jobType string, payload interface{}, courseID, lectureID string) (string, error) {
+// enqueue inserts a pending job waiting for dependsOn. When requeuedFrom is set the job replaces that failed job,
+// which is marked in the same transaction so it cannot be requeued twice
+func (queue *Queue) enqueue(userID string, jobType string, payload interface{}, courseID, lectureID string, priority string, dependsOn []string, requeuedFrom string) (string, error) {
+	if !IsValidJobPriority(priority) {
+		return "", fmt.Errorf("invalid job priority: %q", priority)
+	}
+	if !queue.IsJobTypeEnabled(jobType) {
+		return "", fmt.Errorf("%w: %s", ErrJobTypeDisabled, jobType)
+	}
+	billedUserID := queue.BilledUserID(userID, courseID)
+	// Jobs are refused when their user spent their budget, and when what jobs of their type usually cost would
+	// take them over it
+	if !unbilledJobTypes[jobType] {
+		expectedCost, costError := queue.expectedJobCost(jobType)
+		if costError != nil {
+			return "", fmt.Errorf("failed to estimate job cost: %w", costError)
+		}
+		if budgetError := queue.checkCostBudget(billedUserID, expectedCost); budgetError != nil {
+			return "", budgetError
+		}
+	}
 	jobID, _ := gonanoid.New()
 
 	payloadJSON, marshalingError := json.Marshal(payload)
@@ -120,17 +207,54 @@ func (queue *Queue) Enqueue(userID string, jobType string, payload interface{},
 	if lectureID == "" {
 		lectureIDValue = nil
 	}
+	var lockKeyValue interface{}
+	if lockKey := jobLockKey(jobType, lectureID, payloadJSON); lockKey != "" {
+		lockKeyValue = lockKey
+	}
+	var modelValue interface{}
+	if model := primaryJobModel(queue.configuration, jobType, payloadJSON); model != "" {
+		modelValue = model
+	}
 
-	_, executionError := queue.database.Exec(`
-		INSERT INTO jobs (id, user_id, course_id, lecture_id, type, status, progress, payload, created_at)
-		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)
-	`, jobID, userID, courseIDValue, lectureIDValue, jobType, models.JobStatusPending, 0, string(payloadJSON), time.Now())
+	transaction, transactionError := queue.database.Begin()
+	if transactionError != nil {
+		return "", fmt.Errorf("failed to begin transaction: %w", transactionError)
+	}
+	defer transaction.Rollback()
+
+	_, executionError := transaction.Exec(`
+		INSERT INTO jobs (id, user_id, billed_user_id, course_id, lecture_id, type, status, priority, lock_key, model, progress, payload, created_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+	`, jobID, userID, billedUserID, courseIDValue, lectureIDValue, jobType, models.JobStatusPending, priority, lockKeyValue, modelValue, 0, string(payloadJSON), time.Now())
 
 	if executionError != nil {
 		return "", fmt.Errorf("failed to insert job: %w", executionError)
 	}
+	if dependencyError := insertJobDependencies(transaction, jobID, dependsOn); dependencyError != nil {
+		return "", dependencyError
+	}
+	if requeuedFrom != "" {
+		result, requeueError := transaction.Exec(
+			"UPDATE jobs SET requeued_as = ? WHERE id = ? AND status = ? AND requeued_as IS NULL", jobID, requeuedFrom, models.JobStatusFailed,
+		)
+		if requeueError != nil {
+			return "", fmt.Errorf("failed to mark job %s as requeued: %w", requeuedFrom, requeueError)
+		}
+		if affectedRows, _ := result.RowsAffected(); affectedRows == 0 {
+			return "", fmt.Errorf("job %s is not a failed job waiting to be requeued", requeuedFrom)
+		}
+		// The replacement keeps the label and note the user gave the failed job
+		if _, labelError := transaction.Exec(
+			"UPDATE jobs SET (label, note) = (SELECT label, note FROM jobs WHERE id = ?) WHERE id = ?", requeuedFrom, jobID,
+		); labelError != nil {
+			return "", fmt.Errorf("failed to copy the label of job %s: %w", requeuedFrom, labelError)
+		}
+	}
+	if commitError := transaction.Commit(); commitError != nil {
+		return "", fmt.Errorf("failed to commit job: %w", commitError)
+	}
 
-	slog.Info("Enqueued job", "jobID", jobID, "type", jobType, "userID", userID, "courseID", courseID, "lectureID", lectureID)
+	slog.Info("Enqueued job", "jobID", jobID, "type", jobType, "priority", priority, "dependsOn", dependsOn, "userID", userID, "courseID", courseID, "lectureID", lectureID)
 	return jobID, nil
 }
 
@@ -179,10 +303,10 @@ func (queue *Queue) publishUpdate(update JobUpdate) {
 	}
 }
 
-// worker processes jobs from the queue
-func (queue *Queue) worker(workerID int) {
+// worker processes the jobs of its pool
+func (queue *Queue) worker(pool *workerPool, workerID int) {
 	defer queue.waitGroup.Done()
-	slog.Debug("Worker started", "workerID", workerID)
+	slog.Debug("Worker started", "pool", pool.name, "workerID", workerID)
 
 	ticker := time.NewTicker(1 * time.Second)
 	defer ticker.Stop()
@@ -190,51 +314,109 @@ func (queue *Queue) worker(workerID int) {
 	for {
 		select {
 		case <-queue.context.Done():
-			slog.Debug("Worker stopping", "workerID", workerID)
+			slog.Debug("Worker stopping", "pool", pool.name, "workerID", workerID)
+			return
+		case <-pool.retire:
+			slog.Debug("Worker retired", "pool", pool.name, "workerID", workerID)
 			return
 		case <-ticker.C:
-			queue.processNextJob(workerID)
+			queue.processNextJob(pool, workerID)
 		}
 	}
 }
 
-// processNextJob picks up and processes the next pending job
-func (queue *Queue) processNextJob(workerID int) {
+// processNextJob picks up and processes the next pending job of the pool
+func (queue *Queue) processNextJob(pool *workerPool, workerID int) {
+	// Intake is paused by the operator: running jobs finish, nothing new starts
+	if queue.IsPaused() {
+		return
+	}
+
+	// The last idle worker of a pool is kept for high priority jobs, so a quick export never waits for a worker
+	// to finish transcribing hours of audio
+	queue.dispatchMutex.Lock()
+	highPriorityOnly := pool.workers > 1 && pool.busyWorkers >= pool.workers-1
+	job := queue.claimNextJob(pool, workerID, highPriorityOnly)
+	if job != nil {
+		pool.busyWorkers++
+	}
+	queue.dispatchMutex.Unlock()
+	if job == nil {
+		return
+	}
+	defer func() {
+		queue.dispatchMutex.Lock()
+		pool.busyWorkers--
+		queue.dispatchMutex.Unlock()
+	}()
+
+	slog.Info("Worker processing job", "pool", pool.name, "workerID", workerID, "jobID", job.ID, "type", job.Type, "priority", job.Priority)
+
+	// Publish initial update
+	update := JobUpdate{
+		JobID:    job.ID,
+		Type:     job.Type,
+		Status:   models.JobStatusRunning,
+		Progress: 0,
+	}
+	queue.publishUpdate(update)
+	if queue.OnUpdate != nil {
+		queue.OnUpdate(job, update)
+	}
+
+	// Execute job
+	queue.executeJob(job)
+}
+
+// claimNextJob marks the pending job of the pool with the highest priority, the oldest among equals, as running
+// and returns it, or nil when there is none. With highPriorityOnly, only high priority jobs are considered
+func (queue *Queue) claimNextJob(pool *workerPool, workerID int, highPriorityOnly bool) *models.Job {
 	transaction, transactionError := queue.database.Begin()
 	if transactionError != nil {
 		// Transient lock errors are normal when multiple workers compete
 		if strings.Contains(transactionError.Error(), "database is locked") {
-			return // Silently retry next tick
+			return nil // Silently retry next tick
 		}
 		slog.Error("Worker failed to begin transaction", "workerID", workerID, "error", transactionError)
-		return
+		return nil
 	}
 	defer transaction.Rollback()
 
 	// Find and lock a pending job
 	var job models.Job
 	var metadataJSON, progressMessageText, courseID, lectureID sql.NullString
-	queryError := transaction.QueryRow(`
-		SELECT id, user_id, course_id, lecture_id, type, status, progress, progress_message_text, payload, metadata, created_at
+	query := `
+		SELECT id, user_id, course_id, lecture_id, type, status, COALESCE(priority, 'normal'), progress, progress_message_text, payload, metadata, created_at
 		FROM jobs
-		WHERE status = ?
-		ORDER BY created_at ASC
+		WHERE status = ? AND paused_at IS NULL
+	`
+	arguments := []any{models.JobStatusPending}
+	typeCondition, typeArguments := pool.typeCondition()
+	query += typeCondition + dependenciesMetCondition + lockFreeCondition
+	arguments = append(arguments, typeArguments...)
+	if highPriorityOnly {
+		query += " AND priority = ?"
+		arguments = append(arguments, models.JobPriorityHigh)
+	}
+	query += `
+		ORDER BY CASE priority WHEN 'high' THEN 0 WHEN 'low' THEN 2 ELSE 1 END ASC, created_at ASC
 		LIMIT 1
-	`, models.JobStatusPending).Scan(
-		&job.ID, &job.UserID, &courseID, &lectureID, &job.Type, &job.Status, &job.Progress,
+	`
+	queryError := transaction.QueryRow(query, arguments...).Scan(
+		&job.ID, &job.UserID, &courseID, &lectureID, &job.Type, &job.Status, &job.Priority, &job.Progress,
 		&progressMessageText, &job.Payload, &metadataJSON, &job.CreatedAt,
 	)
 
 	if queryError == sql.ErrNoRows {
-		return // No pending jobs
+		return nil // No pending jobs
 	}
 	if queryError != nil {
 		// Transient lock errors are normal when multiple workers compete
 		if strings.Contains(queryError.Error(), "database is locked") {
-			return // Silently retry next tick
+			return nil // Silently retry next tick
 		}
 		slog.Error("Worker failed to query job", "workerID", workerID, "error", queryError)
-		return
+		return nil
 	}
 
 	if courseID.Valid {
@@ -255,70 +437,70 @@ func (queue *Queue) processNextJob(workerID int) {
 	now := time.Now()
 	_, executionError := transaction.Exec(`
 		UPDATE jobs
-		SET status = ?, started_at = ?
+		SET status = ?, started_at = ?, heartbeat_at = ?
 		WHERE id = ?
-	`, models.JobStatusRunning, now, job.ID)
+	`, models.JobStatusRunning, now, now, job.ID)
 
 	if executionError != nil {
 		// Transient lock errors are normal when multiple workers compete
 		if strings.Contains(executionError.Error(), "database is locked") {
-			return // Silently retry next tick
+			return nil // Silently retry next tick
 		}
 		slog.Error("Worker failed to update job status", "workerID", workerID, "error", executionError)
-		return
+		return nil
 	}
 
 	if commitError := transaction.Commit(); commitError != nil {
 		// Transient lock errors are normal when multiple workers compete
 		if strings.Contains(commitError.Error(), "database is locked") {
-			return // Silently retry next tick
+			return nil // Silently retry next tick
 		}
 		slog.Error("Worker failed to commit transaction", "workerID", workerID, "error", commitError)
-		return
+		return nil
 	}
 
 	job.Status = models.JobStatusRunning
 	job.StartedAt = &now
-
-	slog.Info("Worker processing job", "workerID", workerID, "jobID", job.ID, "type", job.Type)
-
-	// Publish initial update
-	update := JobUpdate{
-		JobID:    job.ID,
-		Type:     job.Type,
-		Status:   models.JobStatusRunning,
-		Progress: 0,
-	}
-	queue.publishUpdate(update)
-	if queue.OnUpdate != nil {
-		queue.OnUpdate(&job, update)
-	}
-
-	// Execute job
-	queue.executeJob(&job)
+	return &job
 }
 
 // executeJob runs the job handler and updates the database
 func (queue *Queue) executeJob(job *models.Job) {
 	handler, ok := queue.handlers[job.Type]
 	if !ok {
-		queue.failJob(job.ID, fmt.Sprintf("no handler registered for job type: %s", job.Type))
+		queue.failJob(job.ID, fmt.Sprintf("no handler registered for job type: %s", job.Type), models.JobFailure{
+			Code: models.JobFailureInternal, Action: models.JobActionContactAdmin, Message: "This server cannot run this kind of task.",
+		})
 		return
 	}
 
-	// Handle resource-intensive tasks sequentially
-	isHeavyTask := job.Type == models.JobTypeTranscribeMedia || job.Type == models.JobTypeIngestDocuments
-	if isHeavyTask {
-		select {
-		case queue.heavyTaskSemaphore <- struct{}{}:
-			defer func() { <-queue.heavyTaskSemaphore }()
-		case <-queue.context.Done():
-			return
-		}
+	// The job context is cancelled with ErrBudgetExceeded as its cause when the user it is billed to spends their
+	// budget. Its LLM calls are made for that user, with their own API key when they stored one, and what is
+	// logged with it goes to the job's log
+	billedUserID := queue.BilledUserID(job.UserID, job.CourseID)
+	if _, err := queue.database.Exec("UPDATE jobs SET billed_user_id = ? WHERE id = ?", billedUserID, job.ID); err != nil {
+		slog.Error("Failed to record the user a job is billed to", "error", err, "jobID", job.ID)
 	}
+	jobContext, cancelWithCause := context.WithCancelCause(WithJobID(llm.WithUser(queue.context, billedUserID), job.ID))
+	cancelFunc := func() { cancelWithCause(nil) }
+	defer cancelFunc()
 
-	// Create update function
+	// Metrics are cumulative over this execution, so only what was spent since the last update is recorded
+	var recordedCost float64
 	updateProgress := func(progress int, message string, metadata any, metrics models.JobMetrics) {
+		if spent := metrics.EstimatedCost - recordedCost; spent > 0 {
+			if ledgerError := queue.recordCost(job, billedUserID, spent); ledgerError != nil {
+				slog.Error("Failed to record job cost", "error", ledgerError, "jobID", job.ID)
+			} else {
+				recordedCost = metrics.EstimatedCost
+				// The job stops at its next cancellation check once the user it is billed to spent their budget
+				if budgetError := queue.CheckCostBudget(billedUserID); errors.Is(budgetError, ErrBudgetExceeded) {
+					slog.Warn("Stopping job over cost budget", "jobID", job.ID, "userID", billedUserID, "error", budgetError)
+					cancelWithCause(budgetError)
+				}
+			}
+		}
+
 		var metadataJSON []byte
 		if metadata != nil {
 			metadataJSON, _ = json.Marshal(metadata)
@@ -326,9 +508,9 @@ func (queue *Queue) executeJob(job *models.Job) {
 
 		_, executionError := queue.database.Exec(`
 			UPDATE jobs
-			SET progress = ?, progress_message_text = ?, metadata = ?, input_tokens = ?, output_tokens = ?, estimated_cost = ?
+			SET progress = ?, progress_message_text = ?, metadata = ?, input_tokens = ?, output_tokens = ?, estimated_cost = ?, estimated_input_tokens = ?, heartbeat_at = ?
 			WHERE id = ?
-		`, progress, message, string(metadataJSON), metrics.InputTokens, metrics.OutputTokens, metrics.EstimatedCost, job.ID)
+		`, progress, message, string(metadataJSON), metrics.InputTokens, metrics.OutputTokens, metrics.EstimatedCost, metrics.EstimatedInputTokens, time.Now(), job.ID)
 
 		if executionError != nil {
 			slog.Error("Failed to update job progress in DB", "error", executionError, "jobID", job.ID)
@@ -363,14 +545,44 @@ func (queue *Queue) executeJob(job *models.Job) {
 		}
 	}
 
-	// Execute handler
-	jobContext, cancelFunc := context.WithCancel(queue.context)
-	defer cancelFunc()
+	if err := restoreJobTranscripts(queue.database, job); err != nil {
+		queue.failJob(job.ID, fmt.Sprintf("failed to restore archived transcripts: %v", err), models.JobFailure{
+			Code: models.JobFailureInternal, Action: models.JobActionRetry, Message: "The transcripts of this lecture could not be restored from the archive.",
+		})
+		return
+	}
+
+	// Execute handler; long stages check between pages or sections whether the job was asked to pause
+	jobContext = models.WithPauseCheck(jobContext, func() bool { return queue.pauseRequested(job.ID) })
+	go queue.beatWhileRunning(jobContext, job.ID)
+
+	queue.runningJobsMutex.Lock()
+	queue.runningJobs[job.ID] = cancelFunc
+	queue.runningJobsMutex.Unlock()
+	defer func() {
+		queue.runningJobsMutex.Lock()
+		delete(queue.runningJobs, job.ID)
+		queue.runningJobsMutex.Unlock()
+	}()
 
 	executionError := handler(jobContext, job, updateProgress)
 
+	// The job was cancelled or force-failed while the handler was running
+	if !queue.isJobRunning(job.ID) {
+		slog.Info("Discarding handler outcome for job that is no longer running", "jobID", job.ID)
+		return
+	}
+
+	if errors.Is(executionError, models.ErrJobPaused) {
+		queue.parkPausedJob(job.ID)
+		return
+	}
+	if budgetError := context.Cause(jobContext); executionError != nil && errors.Is(budgetError, ErrBudgetExceeded) {
+		queue.failJob(job.ID, budgetError.Error(), budgetFailure)
+		return
+	}
 	if executionError != nil {
-		queue.failJob(job.ID, executionError.Error())
+		queue.failJob(job.ID, executionError.Error(), classifyJobFailure(executionError))
 		return
 	}
 
@@ -382,7 +594,7 @@ func (queue *Queue) completeJob(jobID, result string) {
 	now := time.Now()
 	_, executionError := queue.database.Exec(`
 		UPDATE jobs
-		SET status = ?, progress = 100, completed_at = ?, result = ?
+		SET status = ?, progress = 100, completed_at = ?, result = ?, pause_requested = 0
 		WHERE id = ?
 	`, models.JobStatusCompleted, now, result, jobID)
 
@@ -390,6 +602,7 @@ func (queue *Queue) completeJob(jobID, result string) {
 		slog.Error("Failed to mark job as completed", "error", executionError)
 		return
 	}
+	_, _ = queue.database.Exec("DELETE FROM job_checkpoints WHERE job_id = ?", jobID)
 
 	job, err := queue.GetJob(jobID)
 	if err != nil {
@@ -400,6 +613,7 @@ func (queue *Queue) completeJob(jobID, result string) {
 	slog.Info("Job completed successfully",
 		"jobID", jobID,
 		"input_tokens", job.InputTokens,
+		"estimated_input_tokens", job.EstimatedInputTokens,
 		"output_tokens", job.OutputTokens,
 		"estimated_cost_usd", job.EstimatedCost,
 		"total_tokens", job.InputTokens+job.OutputTokens)
@@ -426,14 +640,19 @@ func (queue *Queue) completeJob(jobID, result string) {
 	}
 }
 
-// failJob marks a job as failed
-func (queue *Queue) failJob(jobID, errorMsg string) {
+// failJob marks a job as failed, recording the progress message it stopped at as the phase of failure
+func (queue *Queue) failJob(jobID, errorMsg string, failure models.JobFailure) {
+	var phase sql.NullString
+	_ = queue.database.QueryRow("SELECT progress_message_text FROM jobs WHERE id = ?", jobID).Scan(&phase)
+	failure.Phase = phase.String
+	failureJSON, _ := json.Marshal(failure)
+
 	now := time.Now()
 	_, executionError := queue.database.Exec(`
 		UPDATE jobs
-		SET status = ?, completed_at = ?, error = ?
+		SET status = ?, completed_at = ?, error = ?, failure = ?, pause_requested = 0
 		WHERE id = ?
-	`, models.JobStatusFailed, now, errorMsg, jobID)
+	`, models.JobStatusFailed, now, errorMsg, string(failureJSON), jobID)
 
 	if executionError != nil {
 		slog.Error("Failed to mark job as failed", "error", executionError)
@@ -446,7 +665,7 @@ func (queue *Queue) failJob(jobID, errorMsg string) {
 		return
 	}
 
-	slog.Error("Job failed", "jobID", jobID, "error", errorMsg)
+	slog.Error("Job failed", "jobID", jobID, "code", failure.Code, "phase", failure.Phase, "error", errorMsg)
 
 	var parsedPayload interface{}
 	_ = json.Unmarshal([]byte(job.Payload), &parsedPayload)
@@ -459,32 +678,48 @@ func (queue *Queue) failJob(jobID, errorMsg string) {
 		CourseID:  job.CourseID,
 		LectureID: job.LectureID,
 		Error:     errorMsg,
+		Failure:   job.Failure,
 	}
 	queue.publishUpdate(update)
 	if queue.OnUpdate != nil {
 		queue.OnUpdate(job, update)
 	}
+
+	queue.failBlockedJobs()
 }
 
 // GetJob retrieves a job by ID
 func (queue *Queue) GetJob(jobID string) (*models.Job, error) {
+	job, lockKey, queryError := scanJob(queue.database.QueryRow("SELECT "+jobColumns+" FROM jobs WHERE id = ?", jobID))
+	if queryError != nil {
+		return nil, queryError
+	}
+	job.DependsOn = queue.jobDependencies(jobID)
+	job.Lock = queue.jobLock(job, lockKey)
+
+	return job, nil
+}
+
+// jobColumns are the columns of a job read by scanJob
+const jobColumns = `id, user_id, course_id, lecture_id, type, status, COALESCE(priority, 'normal'), lock_key, progress, progress_message_text, payload, result, error, failure, metadata,
+	input_tokens, output_tokens, estimated_cost, COALESCE(estimated_input_tokens, 0), created_at, started_at, completed_at,
+	COALESCE(pause_requested, 0), paused_at, requeued_as, COALESCE(label, ''), COALESCE(note, '')`
+
+// scanJob reads a job selected with jobColumns, without its dependencies and lock, returning the key of the
+// lock it takes
+func scanJob(row interface{ Scan(...any) error }) (*models.Job, sql.NullString, error) {
 	var job models.Job
 	var startedAtTime, completedAtTime sql.NullTime
-	var metadataJSON, progressMessageText, result, errorMsg, courseID, lectureID sql.NullString
-
-	queryError := queue.database.QueryRow(`
-		SELECT id, user_id, course_id, lecture_id, type, status, progress, progress_message_text, payload, result, error, metadata,
-		       input_tokens, output_tokens, estimated_cost, created_at, started_at, completed_at
-		FROM jobs
-		WHERE id = ?
-	`, jobID).Scan(
-		&job.ID, &job.UserID, &courseID, &lectureID, &job.Type, &job.Status, &job.Progress, &progressMessageText,
-		&job.Payload, &result, &errorMsg, &metadataJSON, &job.InputTokens, &job.OutputTokens, &job.EstimatedCost,
-		&job.CreatedAt, &startedAtTime, &completedAtTime,
-	)
+	var metadataJSON, progressMessageText, result, errorMsg, failureJSON, courseID, lectureID, lockKey, requeuedAs sql.NullString
+	var pausedAtTime sql.NullTime
 
-	if queryError != nil {
-		return nil, queryError
+	if err := row.Scan(
+		&job.ID, &job.UserID, &courseID, &lectureID, &job.Type, &job.Status, &job.Priority, &lockKey, &job.Progress, &progressMessageText,
+		&job.Payload, &result, &errorMsg, &failureJSON, &metadataJSON, &job.InputTokens, &job.OutputTokens, &job.EstimatedCost, &job.EstimatedInputTokens,
+		&job.CreatedAt, &startedAtTime, &completedAtTime, &job.PauseRequested, &pausedAtTime, &requeuedAs,
+		&job.Label, &job.Note,
+	); err != nil {
+		return nil, lockKey, err
 	}
 
 	if courseID.Valid {
@@ -506,6 +741,12 @@ func (queue *Queue) GetJob(jobID string) (*models.Job, error) {
 	if errorMsg.Valid {
 		job.Error = errorMsg.String
 	}
+	if failureJSON.Valid {
+		var failure models.JobFailure
+		if json.Unmarshal([]byte(failureJSON.String), &failure) == nil {
+			job.Failure = &failure
+		}
+	}
 
 	if startedAtTime.Valid {
 		job.StartedAt = &startedAtTime.Time
@@ -513,8 +754,13 @@ func (queue *Queue) GetJob(jobID string) (*models.Job, error) {
 	if completedAtTime.Valid {
 		job.CompletedAt = &completedAtTime.Time
 	}
-
-	return &job, nil
+	if pausedAtTime.Valid {
+		job.PausedAt = &pausedAtTime.Time
+	}
+	if requeuedAs.Valid {
+		job.RequeuedAs = requeuedAs.String
+	}
+	return &job, lockKey, nil
 }
 
 // CancelJob cancels a running or pending job
@@ -550,5 +796,195 @@ func (queue *Queue) CancelJob(jobID string) error {
 		queue.OnUpdate(job, update)
 	}
 
+	queue.failBlockedJobs()
 	return nil
 }
+
+// isJobRunning reports whether the job is still marked RUNNING in the database
+func (queue *Queue) isJobRunning(jobID string) bool {
+	var status string
+	if err := queue.database.QueryRow("SELECT status FROM jobs WHERE id = ?", jobID).Scan(&status); err != nil {
+		return false
+	}
+	return status == models.JobStatusRunning
+}
+
+// Pause stops workers from picking up new jobs; jobs already running are not interrupted
+func (queue *Queue) Pause() error {
+	return queue.setPaused(true)
+}
+
+// Resume lets workers pick up pending jobs again
+func (queue *Queue) Resume() error {
+	return queue.setPaused(false)
+}
+
+// setPaused persists the intake pause flag in the settings table
+func (queue *Queue) setPaused(paused bool) error {
+	valueJSON, _ := json.Marshal(paused)
+	_, executionError := queue.database.Exec(`
+		INSERT INTO settings (key, value, updated_at)
+		VALUES (?, ?, ?)
+		ON CONFLICT(key) DO UPDATE SET value = excluded.value, updated_at = excluded.updated_at
+	`, queuePausedSettingKey, string(valueJSON), time.Now())
+	if executionError != nil {
+		return fmt.Errorf("failed to persist queue pause state: %w", executionError)
+	}
+
+	slog.Info("Job intake state changed", "paused", paused)
+	return nil
+}
+
+// IsPaused reports whether job intake is currently paused
+func (queue *Queue) IsPaused() bool {
+	var valueJSON string
+	if err := queue.database.QueryRow("SELECT value FROM settings WHERE key = ?", queuePausedSettingKey).Scan(&valueJSON); err != nil {
+		return false
+	}
+
+	var paused bool
+	_ = json.Unmarshal([]byte(valueJSON), &paused)
+	return paused
+}
+
+// Statistics returns the number of pending and running jobs grouped by type
+func (queue *Queue) Statistics() ([]QueueTypeStatistics, error) {
+	statisticsRows, queryError := queue.database.Query(`
+		SELECT type,
+		       SUM(CASE WHEN status = ? THEN 1 ELSE 0 END),
+		       SUM(CASE WHEN status = ? THEN 1 ELSE 0 END)
+		FROM jobs
+		WHERE status IN (?, ?)
+		GROUP BY type
+		ORDER BY type ASC
+	`, models.JobStatusPending, models.JobStatusRunning, models.JobStatusPending, models.JobStatusRunning)
+	if queryError != nil {
+		return nil, fmt.Errorf("failed to query queue statistics: %w", queryError)
+	}
+	defer statisticsRows.Close()
+
+	statistics := []QueueTypeStatistics{}
+	for statisticsRows.Next() {
+		var typeStatistics QueueTypeStatistics
+		if scanError := statisticsRows.Scan(&typeStatistics.Type, &typeStatistics.Pending, &typeStatistics.Running); scanError != nil {
+			return nil, fmt.Errorf("failed to scan queue statistics: %w", scanError)
+		}
+		if pool := queue.poolFor(typeStatistics.Type); pool != nil {
+			typeStatistics.Pool = pool.name
+		}
+		statistics = append(statistics, typeStatistics)
+	}
+
+	return statistics, statisticsRows.Err()
+}
+
+// ForceFailJob marks a pending or running job as failed and stops its handler if it runs in this process
+func (queue *Queue) ForceFailJob(jobID, reason string) error {
+	job, err := queue.GetJob(jobID)
+	if err != nil {
+		return err
+	}
+
+	if job.Status != models.JobStatusPending && job.Status != models.JobStatusRunning {
+		return fmt.Errorf("job %s is not active (status: %s)", jobID, job.Status)
+	}
+
+	if reason == "" {
+		reason = "Manually failed by operator"
+	}
+
+	queue.failJob(jobID, reason, models.JobFailure{
+		Code: models.JobFailureFailedByOperator, Retryable: true, Action: models.JobActionRetry, Message: "An administrator stopped the task.",
+	})
+
+	queue.runningJobsMutex.Lock()
+	cancelHandler, isRunningHere := queue.runningJobs[jobID]
+	queue.runningJobsMutex.Unlock()
+	if isRunningHere {
+		cancelHandler()
+	}
+
+	return nil
+}
+
+// ReassignOrphanedJobs moves RUNNING jobs that no worker of this queue is executing back to PENDING.
+// Jobs that beat, or started, less than minimumAge ago are left untouched, which lets a separate process
+// (such as the operator CLI) avoid stealing jobs from a live server.
+func (queue *Queue) ReassignOrphanedJobs(minimumAge time.Duration) (int, error) {
+	runningRows, queryError := queue.database.Query(`
+		SELECT id, started_at, heartbeat_at FROM jobs WHERE status = ?
+	`, models.JobStatusRunning)
+	if queryError != nil {
+		return 0, fmt.Errorf("failed to query running jobs: %w", queryError)
+	}
+
+	var orphanedJobIDs []string
+	for runningRows.Next() {
+		var jobID string
+		var startedAt, heartbeatAt sql.NullTime
+		if scanError := runningRows.Scan(&jobID, &startedAt, &heartbeatAt); scanError != nil {
+			continue
+		}
+
+		queue.runningJobsMutex.Lock()
+		_, isRunningHere := queue.runningJobs[jobID]
+		queue.runningJobsMutex.Unlock()
+		if isRunningHere {
+			continue
+		}
+
+		if heartbeatAt.Valid {
+			startedAt = heartbeatAt
+		}
+		if startedAt.Valid && time.Since(startedAt.Time) < minimumAge {
+			continue
+		}
+		orphanedJobIDs = append(orphanedJobIDs, jobID)
+	}
+	runningRows.Close()
+
+	reassignedCount := 0
+	for _, jobID := range orphanedJobIDs {
+		result, executionError := queue.database.Exec(`
+			UPDATE jobs
+			SET status = ?, progress = 0, progress_message_text = 'Requeued by operator', started_at = NULL, heartbeat_at = NULL
+			WHERE id = ? AND status = ?
+		`, models.JobStatusPending, jobID, models.JobStatusRunning)
+		if executionError != nil {
+			return reassignedCount, fmt.Errorf("failed to requeue job %s: %w", jobID, executionError)
+		}
+
+		if rowsAffected, _ := result.RowsAffected(); rowsAffected == 0 {
+			continue
+		}
+		reassignedCount++
+
+		job, err := queue.GetJob(jobID)
+		if err != nil {
+			continue
+		}
+
+		var parsedPayload interface{}
+		_ = json.Unmarshal([]byte(job.Payload), &parsedPayload)
+
+		update := JobUpdate{
+			JobID:               jobID,
+			Type:                job.Type,
+			Status:              models.JobStatusPending,
+			ProgressMessageText: job.ProgressMessageText,
+			Payload:             parsedPayload,
+			CourseID:            job.CourseID,
+			LectureID:           job.LectureID,
+		}
+		queue.publishUpdate(update)
+		if queue.OnUpdate != nil {
+			queue.OnUpdate(job, update)
+		}
+	}
+
+	if reassignedCount > 0 {
+		slog.Info("Reassigned orphaned jobs", "count", reassignedCount)
+	}
+
+	return reassignedCount, nil
+}
@@ -0,0 +1,259 @@
+package jobs
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"lectures/internal/configuration"
+	"lectures/internal/database"
+	"lectures/internal/models"
+)
+
+func setupQueueTestDatabase(t *testing.T) (*Queue, func()) {
+	tempDir, err := os.MkdirTemp("", "queue-test-*")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+
+	db, err := database.Initialize(filepath.Join(tempDir, "test.db"))
+	if err != nil {
+		t.Fatalf("Failed to init DB: %v", err)
+	}
+
+	_, _ = db.Exec("INSERT INTO users (id, username, password_hash, role) VALUES (?, ?, ?, ?)", "user-1", "operator", "hash", "admin")
+
+	cleanup := func() {
+		db.Close()
+		os.RemoveAll(tempDir)
+	}
+	return NewQueue(db, 0), cleanup
+}
+
+func TestQueue_OperatorControls(t *testing.T) {
+	queue, cleanup := setupQueueTestDatabase(t)
+	defer cleanup()
+
+	pendingJobID, _ := queue.Enqueue("user-1", models.JobTypeBuildMaterial, map[string]string{}, "", "")
+	runningJobID, _ := queue.Enqueue("user-1", models.JobTypeTranscribeMedia, map[string]string{}, "", "")
+	recentJobID, _ := queue.Enqueue("user-1", models.JobTypeTranscribeMedia, map[string]string{}, "", "")
+	_, _ = queue.database.Exec("UPDATE jobs SET status = ?, started_at = ? WHERE id = ?", models.JobStatusRunning, time.Now().Add(-1*time.Hour), runningJobID)
+	_, _ = queue.database.Exec("UPDATE jobs SET status = ?, started_at = ? WHERE id = ?", models.JobStatusRunning, time.Now(), recentJobID)
+
+	t.Run("Statistics", func(t *testing.T) {
+		statistics, err := queue.Statistics()
+		if err != nil {
+			t.Fatalf("Statistics failed: %v", err)
+		}
+		counts := map[string]QueueTypeStatistics{}
+		for _, typeStatistics := range statistics {
+			counts[typeStatistics.Type] = typeStatistics
+		}
+		if counts[models.JobTypeBuildMaterial].Pending != 1 {
+			t.Errorf("Expected 1 pending BUILD_MATERIAL job, got %+v", counts[models.JobTypeBuildMaterial])
+		}
+		if counts[models.JobTypeTranscribeMedia].Running != 2 {
+			t.Errorf("Expected 2 running TRANSCRIBE_MEDIA jobs, got %+v", counts[models.JobTypeTranscribeMedia])
+		}
+	})
+
+	t.Run("Pause and Resume", func(t *testing.T) {
+		if queue.IsPaused() {
+			t.Fatal("Queue should not start paused")
+		}
+		if err := queue.Pause(); err != nil {
+			t.Fatalf("Pause failed: %v", err)
+		}
+		if !queue.IsPaused() {
+			t.Error("Expected queue to be paused")
+		}
+
+		// A paused queue must not claim pending jobs
+		queue.processNextJob(queue.pools[0], 0)
+		job, _ := queue.GetJob(pendingJobID)
+		if job.Status != models.JobStatusPending {
+			t.Errorf("Paused queue picked up job, status %s", job.Status)
+		}
+
+		if err := queue.Resume(); err != nil {
+			t.Fatalf("Resume failed: %v", err)
+		}
+		if queue.IsPaused() {
+			t.Error("Expected queue to be resumed")
+		}
+	})
+
+	t.Run("Reassign Orphaned", func(t *testing.T) {
+		reassignedCount, err := queue.ReassignOrphanedJobs(10 * time.Minute)
+		if err != nil {
+			t.Fatalf("ReassignOrphanedJobs failed: %v", err)
+		}
+		if reassignedCount != 1 {
+			t.Errorf("Expected 1 reassigned job, got %d", reassignedCount)
+		}
+
+		job, _ := queue.GetJob(runningJobID)
+		if job.Status != models.JobStatusPending {
+			t.Errorf("Expected stale job to be PENDING, got %s", job.Status)
+		}
+		job, _ = queue.GetJob(recentJobID)
+		if job.Status != models.JobStatusRunning {
+			t.Errorf("Expected recent job to stay RUNNING, got %s", job.Status)
+		}
+	})
+
+	t.Run("Force Fail", func(t *testing.T) {
+		if err := queue.ForceFailJob(recentJobID, "stuck"); err != nil {
+			t.Fatalf("ForceFailJob failed: %v", err)
+		}
+		job, _ := queue.GetJob(recentJobID)
+		if job.Status != models.JobStatusFailed || job.Error != "stuck" {
+			t.Errorf("Expected FAILED with reason, got %s (%s)", job.Status, job.Error)
+		}
+
+		if err := queue.ForceFailJob(recentJobID, ""); err == nil {
+			t.Error("Expected error when failing an inactive job")
+		}
+	})
+}
+
+func TestQueue_Priority(t *testing.T) {
+	queue, cleanup := setupQueueTestDatabase(t)
+	defer cleanup()
+
+	lowJobID, _ := queue.EnqueueWithPriority("user-1", models.JobTypeTranscribeMedia, map[string]string{}, "", "", models.JobPriorityLow)
+	firstNormalJobID, _ := queue.Enqueue("user-1", models.JobTypeBuildMaterial, map[string]string{}, "", "")
+	secondNormalJobID, _ := queue.Enqueue("user-1", models.JobTypeTranscribeMedia, map[string]string{}, "", "")
+	highJobID, _ := queue.Enqueue("user-1", models.JobTypePublishMaterial, map[string]string{}, "", "")
+
+	if _, err := queue.EnqueueWithPriority("user-1", models.JobTypeBuildMaterial, map[string]string{}, "", "", "urgent"); err == nil {
+		t.Error("Expected an unknown priority to be rejected")
+	}
+
+	job, _ := queue.GetJob(highJobID)
+	if job.Priority != models.JobPriorityHigh {
+		t.Errorf("Expected exports to default to high priority, got %q", job.Priority)
+	}
+
+	t.Run("Reserved worker", func(t *testing.T) {
+		// The last idle worker only takes high priority jobs
+		if claimed := queue.claimNextJob(queue.pools[0], 0, true); claimed == nil || claimed.ID != highJobID {
+			t.Fatalf("Expected the high priority job to be claimed, got %+v", claimed)
+		}
+		if claimed := queue.claimNextJob(queue.pools[0], 0, true); claimed != nil {
+			t.Errorf("Expected no job for the reserved worker, got %s", claimed.ID)
+		}
+	})
+
+	t.Run("Priority then FIFO", func(t *testing.T) {
+		expectedOrder := []string{firstNormalJobID, secondNormalJobID, lowJobID}
+		for _, expectedJobID := range expectedOrder {
+			claimed := queue.claimNextJob(queue.pools[0], 0, false)
+			if claimed == nil || claimed.ID != expectedJobID {
+				t.Fatalf("Expected job %s to be claimed next, got %+v", expectedJobID, claimed)
+			}
+			if claimed.Status != models.JobStatusRunning {
+				t.Errorf("Expected the claimed job to be running, got %s", claimed.Status)
+			}
+		}
+		if claimed := queue.claimNextJob(queue.pools[0], 0, false); claimed != nil {
+			t.Errorf("Expected the queue to be empty, got %s", claimed.ID)
+		}
+	})
+}
+
+func TestQueue_WorkerPools(t *testing.T) {
+	queue, cleanup := setupQueueTestDatabase(t)
+	defer cleanup()
+
+	queue.SetConcurrency(map[string]int{PoolTranscribe: 3, "unknown": 7}, nil)
+
+	pools := map[string]*workerPool{}
+	for _, pool := range queue.pools {
+		pools[pool.name] = pool
+	}
+	if len(pools) != 4 || pools[PoolTranscribe].workers != 3 || pools[PoolPublish].workers != DefaultPoolConcurrency[PoolPublish] {
+		t.Fatalf("Unexpected pools %+v", queue.PoolStatistics())
+	}
+
+	transcribeJobID, _ := queue.Enqueue("user-1", models.JobTypeTranscribeMedia, map[string]string{}, "", "")
+	buildJobID, _ := queue.Enqueue("user-1", models.JobTypeBuildMaterial, map[string]string{}, "", "")
+	recapJobID, _ := queue.Enqueue("user-1", models.JobTypeGenerateRecap, map[string]string{}, "", "")
+
+	// Pools only claim their own job types; types without a pool run in the build pool
+	if claimed := queue.claimNextJob(pools[PoolPublish], 0, false); claimed != nil {
+		t.Errorf("Publish pool claimed %s job", claimed.Type)
+	}
+	if claimed := queue.claimNextJob(pools[PoolTranscribe], 0, false); claimed == nil || claimed.ID != transcribeJobID {
+		t.Errorf("Expected the transcribe pool to claim the transcription, got %+v", claimed)
+	}
+	if claimed := queue.claimNextJob(pools[PoolTranscribe], 0, false); claimed != nil {
+		t.Errorf("Transcribe pool claimed %s job", claimed.Type)
+	}
+	if claimed := queue.claimNextJob(pools[PoolBuild], 0, false); claimed == nil || claimed.ID != buildJobID {
+		t.Errorf("Expected the build pool to claim the build, got %+v", claimed)
+	}
+	if claimed := queue.claimNextJob(pools[PoolBuild], 0, false); claimed == nil || claimed.ID != recapJobID {
+		t.Errorf("Expected the build pool to claim the recap, got %+v", claimed)
+	}
+
+	statistics, _ := queue.Statistics()
+	for _, typeStatistics := range statistics {
+		if typeStatistics.Type == models.JobTypeGenerateRecap && typeStatistics.Pool != PoolBuild {
+			t.Errorf("Expected recaps to be reported in the build pool, got %q", typeStatistics.Pool)
+		}
+	}
+}
+
+func TestQueue_PoolScaling(t *testing.T) {
+	queue, cleanup := setupQueueTestDatabase(t)
+	defer cleanup()
+
+	queue.SetConcurrency(map[string]int{PoolPublish: 9}, map[string]configuration.PoolScalingConfiguration{
+		PoolPublish:    {MinimumWorkers: 1, MaximumWorkers: 3},
+		PoolTranscribe: {MinimumWorkers: 2, MaximumWorkers: 1},
+	})
+	publishPool, transcribePool := queue.poolFor(models.JobTypePublishMaterial), queue.poolFor(models.JobTypeTranscribeMedia)
+	if publishPool.workers != 3 || !publishPool.scalable() {
+		t.Fatalf("Expected the configured concurrency to be clamped to the bounds, got %+v", publishPool)
+	}
+	if transcribePool.scalable() || transcribePool.workers != DefaultPoolConcurrency[PoolTranscribe] {
+		t.Fatalf("Expected invalid bounds to be ignored, got %+v", transcribePool)
+	}
+
+	// Workers are stopped before the queue is, so none of them claims the jobs below
+	defer func() {
+		queue.cancel()
+		queue.waitGroup.Wait()
+	}()
+	publishPool.workers = 1
+	publishPool.retire = make(chan struct{})
+
+	for range 4 {
+		queue.Enqueue("user-1", models.JobTypePublishMaterial, map[string]string{}, "", "")
+	}
+	queue.Enqueue("user-1", models.JobTypeTranscribeMedia, map[string]string{}, "", "")
+	queue.scalePools()
+
+	statistics := queue.PoolStatistics()
+	for _, poolStatistics := range statistics {
+		if poolStatistics.Name == PoolPublish && (poolStatistics.Workers != 3 || poolStatistics.MinimumWorkers != 1 || poolStatistics.MaximumWorkers != 3) {
+			t.Errorf("Expected the publish pool to grow to its maximum, got %+v", poolStatistics)
+		}
+		if poolStatistics.Name == PoolTranscribe && poolStatistics.Workers != 1 {
+			t.Errorf("Expected the transcribe pool to keep its workers, got %+v", poolStatistics)
+		}
+	}
+
+	// Once the queue drains, idle workers are retired one per interval
+	_, _ = queue.database.Exec("UPDATE jobs SET status = ? WHERE type = ?", models.JobStatusCompleted, models.JobTypePublishMaterial)
+	deadline := time.Now().Add(2 * time.Second)
+	for publishPool.workers == 3 && time.Now().Before(deadline) {
+		queue.scalePools()
+		time.Sleep(10 * time.Millisecond)
+	}
+	if publishPool.workers != 2 {
+		t.Errorf("Expected a single worker to be retired, got %d workers", publishPool.workers)
+	}
+}
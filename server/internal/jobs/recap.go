@@ -0,0 +1,239 @@
+package jobs
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+
+	"lectures/internal/configuration"
+	"lectures/internal/models"
+	"lectures/internal/tools"
+)
+
+// recapContentCharacters bounds the lecture text sent to the recap model; longer lectures are sampled evenly
+const recapContentCharacters = 48000
+
+// ScheduleLectureRecap enqueues the generation of the recap of a ready lecture, unless the stored recap was
+// generated from the current content or a generation is already pending. It is called whenever a lecture is
+// checked for readiness, so a recap is made once per version of the transcript and documents. A recap that
+// failed on the current content is not tried again until RetryLectureRecap is called
+func ScheduleLectureRecap(queue *Queue, database *sql.DB, lectureID string) {
+	if queue == nil || !queue.IsJobTypeEnabled(models.JobTypeGenerateRecap) {
+		return
+	}
+	if _, err := scheduleLectureRecap(queue, database, lectureID, false); err != nil {
+		slog.Warn("Failed to schedule lecture recap", "lectureID", lectureID, "error", err)
+	}
+}
+
+// RetryLectureRecap enqueues the recap of a ready lecture even when it failed on the current content, and returns
+// the ID of the job. The ID is empty when the lecture is not ready, has no text, or its recap is current or pending
+func RetryLectureRecap(queue *Queue, database *sql.DB, lectureID string) (string, error) {
+	return scheduleLectureRecap(queue, database, lectureID, true)
+}
+
+// LectureRecapFailure returns the error of the last recap of a lecture when it failed on the current content of the
+// lecture, and an empty string otherwise
+func LectureRecapFailure(database *sql.DB, lectureID string) string {
+	var status, signature, failure string
+	err := database.QueryRow(`
+		SELECT status, COALESCE(json_extract(payload, '$.content_signature'), ''), COALESCE(error, '') FROM jobs
+		WHERE type = ? AND lecture_id = ?
+		ORDER BY created_at DESC LIMIT 1
+	`, models.JobTypeGenerateRecap, lectureID).Scan(&status, &signature, &failure)
+	if err != nil || status != models.JobStatusFailed {
+		return ""
+	}
+	if currentSignature, _ := lectureContentSignature(database, lectureID); signature != currentSignature {
+		return ""
+	}
+	if failure == "" {
+		failure = "The recap could not be written"
+	}
+	return failure
+}
+
+func scheduleLectureRecap(queue *Queue, database *sql.DB, lectureID string, retryFailed bool) (string, error) {
+	var status, examID, userID string
+	err := database.QueryRow(`
+		SELECT lectures.status, lectures.exam_id, exams.user_id FROM lectures
+		JOIN exams ON lectures.exam_id = exams.id
+		WHERE lectures.id = ?
+	`, lectureID).Scan(&status, &examID, &userID)
+	if err != nil || status != "ready" {
+		return "", nil
+	}
+
+	signature, characterCount := lectureContentSignature(database, lectureID)
+	if characterCount == 0 {
+		return "", nil
+	}
+	var storedSignature string
+	database.QueryRow("SELECT content_signature FROM lecture_recaps WHERE lecture_id = ?", lectureID).Scan(&storedSignature)
+	if storedSignature == signature {
+		return "", nil
+	}
+	// Each attempt costs a model call, so a failed recap waits for new content or an explicit retry
+	if !retryFailed && LectureRecapFailure(database, lectureID) != "" {
+		return "", nil
+	}
+
+	var activeJobs int
+	database.QueryRow("SELECT COUNT(*) FROM jobs WHERE type = ? AND lecture_id = ? AND status IN ('PENDING', 'RUNNING')", models.JobTypeGenerateRecap, lectureID).Scan(&activeJobs)
+	if activeJobs > 0 {
+		return "", nil
+	}
+
+	return queue.Enqueue(userID, models.JobTypeGenerateRecap, map[string]string{"lecture_id": lectureID, "content_signature": signature}, examID, lectureID)
+}
+
+// lectureContentSignature identifies the current transcript and document text of a lecture, and returns how
+// many characters of text it has
+func lectureContentSignature(database *sql.DB, lectureID string) (string, int) {
+	var segmentCount, segmentCharacters int
+	database.QueryRow(`
+		SELECT COUNT(*), COALESCE(SUM(LENGTH(COALESCE(transcript_segments.polished_text, transcript_segments.text))), 0)
+		FROM transcript_segments
+		JOIN transcripts ON transcript_segments.transcript_id = transcripts.id
+		WHERE transcripts.lecture_id = ? AND transcripts.status = 'completed'
+	`, lectureID).Scan(&segmentCount, &segmentCharacters)
+
+	var pageCount, pageCharacters int
+	database.QueryRow(`
+		SELECT COUNT(*), COALESCE(SUM(LENGTH(COALESCE(reference_pages.extracted_text, ''))), 0)
+		FROM reference_pages
+		JOIN reference_documents ON reference_pages.document_id = reference_documents.id
+		WHERE reference_documents.lecture_id = ? AND reference_documents.extraction_status = 'completed'
+	`, lectureID).Scan(&pageCount, &pageCharacters)
+
+	return fmt.Sprintf("%d:%d:%d:%d", segmentCount, segmentCharacters, pageCount, pageCharacters), segmentCharacters + pageCharacters
+}
+
+// loadRecapContent gathers the transcript, preferring the polished text, followed by the text of the reference
+// pages. Lectures longer than recapContentCharacters are sampled in evenly spaced paragraphs
+func loadRecapContent(database *sql.DB, lectureID string) (string, error) {
+	var paragraphs []string
+
+	segmentRows, err := database.Query(`
+		SELECT COALESCE(transcript_segments.polished_text, transcript_segments.text)
+		FROM transcript_segments
+		JOIN transcripts ON transcript_segments.transcript_id = transcripts.id
+		WHERE transcripts.lecture_id = ? AND transcripts.status = 'completed'
+		ORDER BY transcript_segments.start_millisecond ASC
+	`, lectureID)
+	if err != nil {
+		return "", fmt.Errorf("failed to query transcript: %w", err)
+	}
+	for segmentRows.Next() {
+		var text string
+		if segmentRows.Scan(&text) == nil && strings.TrimSpace(text) != "" {
+			paragraphs = append(paragraphs, strings.TrimSpace(text))
+		}
+	}
+	segmentRows.Close()
+
+	pageRows, err := database.Query(`
+		SELECT reference_pages.extracted_text
+		FROM reference_pages
+		JOIN reference_documents ON reference_pages.document_id = reference_documents.id
+		WHERE reference_documents.lecture_id = ? AND reference_documents.extraction_status = 'completed'
+			AND reference_pages.extracted_text IS NOT NULL
+		ORDER BY reference_documents.created_at ASC, reference_pages.page_number ASC
+	`, lectureID)
+	if err != nil {
+		return "", fmt.Errorf("failed to query reference pages: %w", err)
+	}
+	for pageRows.Next() {
+		var text string
+		if pageRows.Scan(&text) == nil && strings.TrimSpace(text) != "" {
+			paragraphs = append(paragraphs, strings.TrimSpace(text))
+		}
+	}
+	pageRows.Close()
+
+	totalCharacters := 0
+	for _, paragraph := range paragraphs {
+		totalCharacters += len(paragraph) + 1
+	}
+	if totalCharacters <= recapContentCharacters {
+		return strings.Join(paragraphs, "\n"), nil
+	}
+
+	// Keep every n-th paragraph, so the sample spans the whole lecture instead of its opening minutes
+	stride := float64(totalCharacters) / float64(recapContentCharacters)
+	var sampled strings.Builder
+	for position := 0.0; int(position) < len(paragraphs); position += stride {
+		paragraph := paragraphs[int(position)]
+		if sampled.Len()+len(paragraph) > recapContentCharacters {
+			break
+		}
+		sampled.WriteString(paragraph)
+		sampled.WriteString("\n")
+	}
+	return sampled.String(), nil
+}
+
+// generateRecapHandler writes the recap of a lecture with the cheap polishing model and caches it in lecture_recaps
+func generateRecapHandler(database *sql.DB, config *configuration.Configuration, toolGenerator *tools.ToolGenerator) JobHandler {
+	return func(jobContext context.Context, job *models.Job, updateProgress func(int, string, any, models.JobMetrics)) error {
+		var payload struct {
+			LectureID string `json:"lecture_id"`
+		}
+		if unmarshalingError := json.Unmarshal([]byte(job.Payload), &payload); unmarshalingError != nil {
+			return fmt.Errorf("failed to unmarshal job payload: %w", unmarshalingError)
+		}
+
+		var title, examID, languageCode string
+		err := database.QueryRow(`
+			SELECT lectures.title, lectures.exam_id, COALESCE(NULLIF(lectures.language, ''), exams.language, '')
+			FROM lectures
+			JOIN exams ON lectures.exam_id = exams.id
+			WHERE lectures.id = ?
+		`, payload.LectureID).Scan(&title, &examID, &languageCode)
+		if err != nil {
+			return fmt.Errorf("failed to get lecture: %w", err)
+		}
+		if languageCode == "" {
+			languageCode = config.LLM.Language
+		}
+
+		// The signature is taken before reading, so content added meanwhile schedules another recap
+		signature, _ := lectureContentSignature(database, payload.LectureID)
+		content, err := loadRecapContent(database, payload.LectureID)
+		if err != nil {
+			return err
+		}
+		if strings.TrimSpace(content) == "" {
+			return fmt.Errorf("the lecture has no transcript or document text to recap")
+		}
+
+		updateProgress(20, "Writing lecture recap...", nil, models.JobMetrics{})
+		model := config.LLM.GetModelForTask("content_polishing")
+		bullets, totalMetrics, err := toolGenerator.GenerateLectureRecap(jobContext, title, content, languageCode, models.GenerationOptions{ModelPolishing: model})
+		if err != nil {
+			return fmt.Errorf("failed to generate lecture recap: %w", err)
+		}
+
+		bulletsJSON, _ := json.Marshal(bullets)
+		_, err = database.Exec(`
+			INSERT INTO lecture_recaps (lecture_id, bullets, language, model, content_signature, estimated_cost, created_at)
+			VALUES (?, ?, ?, ?, ?, ?, ?)
+			ON CONFLICT(lecture_id) DO UPDATE SET bullets = excluded.bullets, language = excluded.language, model = excluded.model,
+				content_signature = excluded.content_signature, estimated_cost = excluded.estimated_cost, created_at = excluded.created_at
+		`, payload.LectureID, string(bulletsJSON), languageCode, model, signature, totalMetrics.EstimatedCost, time.Now())
+		if err != nil {
+			return fmt.Errorf("failed to store lecture recap: %w", err)
+		}
+
+		database.Exec("UPDATE lectures SET estimated_cost = estimated_cost + ?, updated_at = ? WHERE id = ?", totalMetrics.EstimatedCost, time.Now(), payload.LectureID)
+		database.Exec("UPDATE exams SET estimated_cost = estimated_cost + ?, updated_at = ? WHERE id = ?", totalMetrics.EstimatedCost, time.Now(), examID)
+
+		updateProgress(100, "Lecture recap ready", nil, totalMetrics)
+		job.Result = fmt.Sprintf(`{"bullets": %d}`, len(bullets))
+		return nil
+	}
+}
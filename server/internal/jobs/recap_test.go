@@ -0,0 +1,85 @@
+package jobs
+
+import (
+	"context"
+	"encoding/json"
+	"path/filepath"
+	"testing"
+
+	"lectures/internal/configuration"
+	"lectures/internal/database"
+	"lectures/internal/models"
+	"lectures/internal/prompts"
+	"lectures/internal/tools"
+)
+
+func TestJob_GenerateRecap(t *testing.T) {
+	tempDir := t.TempDir()
+	db, err := database.Initialize(filepath.Join(tempDir, "test.db"))
+	if err != nil {
+		t.Fatalf("Failed to init DB: %v", err)
+	}
+	defer db.Close()
+
+	config := &configuration.Configuration{
+		Storage: configuration.StorageConfiguration{DataDirectory: tempDir},
+		LLM:     configuration.LLMConfiguration{Language: "en-US", Model: "test-model"},
+	}
+
+	_, _ = db.Exec("INSERT INTO users (id, username, password_hash) VALUES ('recap-user', 'tester', 'hash')")
+	_, _ = db.Exec("INSERT INTO exams (id, user_id, title, language) VALUES ('recap-exam', 'recap-user', 'Biology', 'it-IT')")
+	_, _ = db.Exec("INSERT INTO lectures (id, exam_id, title, status) VALUES ('recap-lecture', 'recap-exam', 'Cells', 'processing')")
+	_, _ = db.Exec("INSERT INTO transcripts (id, lecture_id, status) VALUES ('recap-transcript', 'recap-lecture', 'completed')")
+	_, _ = db.Exec("INSERT INTO transcript_segments (transcript_id, start_millisecond, end_millisecond, text) VALUES ('recap-transcript', 0, 1000, 'um cells are the unit of life')")
+	_, _ = db.Exec("UPDATE transcript_segments SET polished_text = 'Cells are the unit of life.'")
+
+	// The queue is not started, so scheduled jobs stay pending
+	jobQueue := NewQueue(db, 1)
+	toolGenerator := tools.NewToolGenerator(config, &mockLLMProvider{ResponseText: `{"bullets": ["Cells are the unit of life."]}`}, prompts.NewManager("../../prompts"))
+	RegisterHandlers(jobQueue, db, config, nil, nil, toolGenerator, &MockMarkdownConverter{}, nil, nil)
+
+	countRecapJobs := func() int {
+		var count int
+		db.QueryRow("SELECT COUNT(*) FROM jobs WHERE type = ? AND lecture_id = 'recap-lecture'", models.JobTypeGenerateRecap).Scan(&count)
+		return count
+	}
+
+	ScheduleLectureRecap(jobQueue, db, "recap-lecture")
+	if countRecapJobs() != 0 {
+		t.Fatalf("Expected no recap for a lecture that is still processing")
+	}
+
+	database.CheckLectureReadiness(db, "recap-lecture")
+	ScheduleLectureRecap(jobQueue, db, "recap-lecture")
+	ScheduleLectureRecap(jobQueue, db, "recap-lecture")
+	if countRecapJobs() != 1 {
+		t.Fatalf("Expected exactly one pending recap job, got %d", countRecapJobs())
+	}
+
+	var jobID, payload string
+	db.QueryRow("SELECT id, payload FROM jobs WHERE type = ?", models.JobTypeGenerateRecap).Scan(&jobID, &payload)
+	job := &models.Job{ID: jobID, Type: models.JobTypeGenerateRecap, Payload: payload}
+	if err := jobQueue.handlers[models.JobTypeGenerateRecap](context.Background(), job, func(int, string, any, models.JobMetrics) {}); err != nil {
+		t.Fatalf("Job failed: %v", err)
+	}
+	db.Exec("UPDATE jobs SET status = 'COMPLETED' WHERE id = ?", jobID)
+
+	var bulletsJSON, language string
+	db.QueryRow("SELECT bullets, language FROM lecture_recaps WHERE lecture_id = 'recap-lecture'").Scan(&bulletsJSON, &language)
+	var bullets []string
+	json.Unmarshal([]byte(bulletsJSON), &bullets)
+	if len(bullets) != 1 || bullets[0] != "Cells are the unit of life." || language != "it-IT" {
+		t.Errorf("Unexpected recap %s in %q", bulletsJSON, language)
+	}
+
+	// The cached recap matches the content, until the content changes
+	ScheduleLectureRecap(jobQueue, db, "recap-lecture")
+	if countRecapJobs() != 1 {
+		t.Errorf("Expected the cached recap to be kept")
+	}
+	_, _ = db.Exec("INSERT INTO transcript_segments (transcript_id, start_millisecond, end_millisecond, text) VALUES ('recap-transcript', 1000, 2000, 'Membranes control transport.')")
+	ScheduleLectureRecap(jobQueue, db, "recap-lecture")
+	if countRecapJobs() != 2 {
+		t.Errorf("Expected new content to schedule another recap, got %d jobs", countRecapJobs())
+	}
+}
@@ -0,0 +1,168 @@
+package jobs
+
+import (
+	"context"
+	"database/sql"
+	"log/slog"
+	"time"
+
+	"lectures/internal/models"
+)
+
+// jobHeartbeatInterval is how often a running job records that its worker is alive
+const jobHeartbeatInterval = 15 * time.Second
+
+// staleJobAge is how long a running job may go without a heartbeat before its worker is considered gone
+const staleJobAge = 4 * jobHeartbeatInterval
+
+// maximumJobRecoveries is how many times a job is requeued after interruptions before it is failed, so a job that
+// brings the server down does not do so on every restart
+const maximumJobRecoveries = 2
+
+// requeuedOnRecoveryJobTypes are the job types that are safe to run again after an interruption: they replace
+// what they produce or resume from their checkpoints. Other types, such as downloads that add media or
+// suggestions a user was waiting for, are failed instead
+var requeuedOnRecoveryJobTypes = map[string]bool{
+	models.JobTypeTranscribeMedia:   true,
+	models.JobTypeIngestDocuments:   true,
+	models.JobTypeBuildMaterial:     true,
+	models.JobTypePublishMaterial:   true,
+	models.JobTypePublishBundle:     true,
+	models.JobTypePolishTranscript:  true,
+	models.JobTypeGenerateRecap:     true,
+	models.JobTypeAnalyzeDuplicates: true,
+	models.JobTypeBackup:            true,
+	models.JobTypeRedactMedia:       true,
+	models.JobTypeImportSyllabus:    true,
+}
+
+// JobRecoveryCounts counts what happened to the running jobs found without a live worker
+type JobRecoveryCounts struct {
+	Requeued int // Put back in the queue, resuming from their checkpoints
+	Failed   int // Failed as interrupted, because they cannot safely run again or were interrupted too often
+	Alive    int // Still heartbeating, possibly in another process, and checked again once they could be stale
+}
+
+// recoverJobs handles the jobs left RUNNING by a server that stopped without finishing them. Jobs whose heartbeat
+// is stale are requeued or failed depending on their type; jobs whose heartbeat is recent are checked again once
+// it could have gone stale, since the previous process may have been killed moments ago
+func (queue *Queue) recoverJobs() {
+	counts := queue.recoverStaleJobs()
+	if counts.Requeued > 0 || counts.Failed > 0 || counts.Alive > 0 {
+		slog.Info("Recovered interrupted jobs", "requeued", counts.Requeued, "failed", counts.Failed, "alive", counts.Alive)
+	}
+	queue.failBlockedJobs()
+
+	if counts.Alive > 0 {
+		queue.waitGroup.Add(1)
+		go func() {
+			defer queue.waitGroup.Done()
+			select {
+			case <-queue.context.Done():
+			case <-time.After(staleJobAge):
+				if counts := queue.recoverStaleJobs(); counts.Requeued > 0 || counts.Failed > 0 {
+					slog.Info("Recovered interrupted jobs", "requeued", counts.Requeued, "failed", counts.Failed, "alive", counts.Alive)
+					queue.failBlockedJobs()
+				}
+			}
+		}()
+	}
+}
+
+// recoverStaleJobs requeues or fails the running jobs that no worker of this queue runs and whose heartbeat, or
+// start when they never beat, is older than staleJobAge
+func (queue *Queue) recoverStaleJobs() JobRecoveryCounts {
+	var counts JobRecoveryCounts
+	rows, err := queue.database.Query(`
+		SELECT id, type, COALESCE(recovery_count, 0), started_at, heartbeat_at FROM jobs WHERE status = ?
+	`, models.JobStatusRunning)
+	if err != nil {
+		slog.Error("Failed to query running jobs for recovery", "error", err)
+		return counts
+	}
+
+	type staleJob struct {
+		id, jobType   string
+		recoveryCount int
+	}
+	var staleJobs []staleJob
+	for rows.Next() {
+		var job staleJob
+		var startedAt, heartbeatAt sql.NullTime
+		if rows.Scan(&job.id, &job.jobType, &job.recoveryCount, &startedAt, &heartbeatAt) != nil {
+			continue
+		}
+		lastBeat := startedAt
+		if heartbeatAt.Valid {
+			lastBeat = heartbeatAt
+		}
+		queue.runningJobsMutex.Lock()
+		_, isRunningHere := queue.runningJobs[job.id]
+		queue.runningJobsMutex.Unlock()
+		if isRunningHere {
+			continue
+		}
+		if lastBeat.Valid && time.Since(lastBeat.Time) < staleJobAge {
+			counts.Alive++
+			continue
+		}
+		staleJobs = append(staleJobs, job)
+	}
+	rows.Close()
+
+	for _, job := range staleJobs {
+		if requeuedOnRecoveryJobTypes[job.jobType] && job.recoveryCount < maximumJobRecoveries {
+			// A pause asked for before the interruption is honored by requeueing the job paused
+			result, err := queue.database.Exec(`
+				UPDATE jobs SET status = ?, started_at = NULL, heartbeat_at = NULL, recovery_count = COALESCE(recovery_count, 0) + 1,
+					paused_at = CASE WHEN pause_requested = 1 THEN ? END, pause_requested = 0
+				WHERE id = ? AND status = ?
+			`, models.JobStatusPending, time.Now(), job.id, models.JobStatusRunning)
+			if err != nil {
+				slog.Error("Failed to requeue interrupted job", "jobID", job.id, "error", err)
+				continue
+			}
+			if affectedRows, _ := result.RowsAffected(); affectedRows > 0 {
+				slog.Info("Requeued interrupted job", "jobID", job.id, "type", job.jobType, "recoveries", job.recoveryCount+1)
+				counts.Requeued++
+			}
+			continue
+		}
+
+		message := "The server restarted while the task was running."
+		if requeuedOnRecoveryJobTypes[job.jobType] {
+			message = "The server stopped repeatedly while running this task."
+		}
+		queue.failJob(job.id, "Server restarted while task was running", models.JobFailure{
+			Code: models.JobFailureInterrupted, Retryable: true, Action: models.JobActionRetry, Message: message,
+		})
+		counts.Failed++
+	}
+	return counts
+}
+
+// beatWhileRunning records the heartbeat of a running job every jobHeartbeatInterval until jobContext ends
+func (queue *Queue) beatWhileRunning(jobContext context.Context, jobID string) {
+	ticker := time.NewTicker(jobHeartbeatInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-jobContext.Done():
+			return
+		case <-ticker.C:
+			if _, err := queue.database.Exec("UPDATE jobs SET heartbeat_at = ? WHERE id = ? AND status = ?", time.Now(), jobID, models.JobStatusRunning); err != nil {
+				slog.Warn("Failed to record job heartbeat", "jobID", jobID, "error", err)
+			}
+		}
+	}
+}
+
+// CountLiveJobs counts the running jobs whose worker beat within staleJobAge, which means a server is still
+// running them
+func (queue *Queue) CountLiveJobs() (int, error) {
+	var liveJobCount int
+	err := queue.database.QueryRow(
+		"SELECT COUNT(*) FROM jobs WHERE status = ? AND heartbeat_at > ?", models.JobStatusRunning, time.Now().Add(-staleJobAge),
+	).Scan(&liveJobCount)
+	return liveJobCount, err
+}
@@ -0,0 +1,57 @@
+package jobs
+
+import (
+	"testing"
+	"time"
+
+	"lectures/internal/models"
+)
+
+func TestQueue_RecoverStaleJobs(t *testing.T) {
+	queue, cleanup := setupQueueTestDatabase(t)
+	defer cleanup()
+
+	startRunning := func(jobType string, lastBeat time.Time) string {
+		jobID, _ := queue.Enqueue("user-1", jobType, map[string]string{}, "", "")
+		queue.database.Exec("UPDATE jobs SET status = ?, started_at = ?, heartbeat_at = ? WHERE id = ?", models.JobStatusRunning, time.Now().Add(-time.Hour), lastBeat, jobID)
+		return jobID
+	}
+	staleBeat := time.Now().Add(-2 * staleJobAge)
+
+	buildJobID := startRunning(models.JobTypeBuildMaterial, staleBeat)
+	suggestionJobID := startRunning(models.JobTypeSuggest, staleBeat)
+	crashingJobID := startRunning(models.JobTypeTranscribeMedia, staleBeat)
+	queue.database.Exec("UPDATE jobs SET recovery_count = ? WHERE id = ?", maximumJobRecoveries, crashingJobID)
+	pausingJobID := startRunning(models.JobTypeIngestDocuments, staleBeat)
+	queue.database.Exec("UPDATE jobs SET pause_requested = 1 WHERE id = ?", pausingJobID)
+	// Started long ago, but its worker beat moments ago, possibly in another process
+	aliveJobID := startRunning(models.JobTypeIngestDocuments, time.Now())
+
+	counts := queue.recoverStaleJobs()
+	if counts.Requeued != 2 || counts.Failed != 2 || counts.Alive != 1 {
+		t.Fatalf("Unexpected recovery counts %+v", counts)
+	}
+
+	buildJob, _ := queue.GetJob(buildJobID)
+	var recoveryCount int
+	queue.database.QueryRow("SELECT recovery_count FROM jobs WHERE id = ?", buildJobID).Scan(&recoveryCount)
+	if buildJob.Status != models.JobStatusPending || buildJob.StartedAt != nil || recoveryCount != 1 {
+		t.Errorf("Expected the build to be requeued once, got status %s and %d recoveries", buildJob.Status, recoveryCount)
+	}
+
+	for _, jobID := range []string{suggestionJobID, crashingJobID} {
+		job, _ := queue.GetJob(jobID)
+		if job.Status != models.JobStatusFailed || job.Failure == nil || job.Failure.Code != models.JobFailureInterrupted {
+			t.Errorf("Expected job %s (%s) to fail as interrupted, got status %s", jobID, job.Type, job.Status)
+		}
+	}
+
+	pausingJob, _ := queue.GetJob(pausingJobID)
+	if pausingJob.Status != models.JobStatusPending || pausingJob.PausedAt == nil || pausingJob.PauseRequested {
+		t.Errorf("Expected the job asked to pause to be requeued paused, got %+v", pausingJob)
+	}
+
+	if aliveJob, _ := queue.GetJob(aliveJobID); aliveJob.Status != models.JobStatusRunning {
+		t.Errorf("Expected the job with a live heartbeat to keep running, got %s", aliveJob.Status)
+	}
+}
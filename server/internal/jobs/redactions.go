@@ -0,0 +1,160 @@
+package jobs
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"mime"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"lectures/internal/configuration"
+	"lectures/internal/database"
+	"lectures/internal/media"
+	"lectures/internal/models"
+	"lectures/internal/storage"
+)
+
+// applyTranscriptRedactions applies the redactions of a lecture after its transcript was rewritten, so a new
+// transcription or polishing never brings redacted speech back
+func applyTranscriptRedactions(jobContext context.Context, db *sql.DB, objectStore storage.ObjectStore, lectureID string) error {
+	result, err := database.ApplyTranscriptRedactions(db, lectureID)
+	if err != nil {
+		return fmt.Errorf("failed to apply transcript redactions: %w", err)
+	}
+	if objectStore != nil {
+		for _, objectKey := range result.PurgedExportKeys {
+			if err := objectStore.Delete(jobContext, objectKey); err != nil {
+				slog.WarnContext(jobContext, "Failed to delete purged export", "object_key", objectKey, "error", err)
+			}
+		}
+	}
+	return nil
+}
+
+// redactMediaHandler bleeps spans of the timeline of a lecture transcript in its media files. Each span is
+// placed in the media files it falls in through the offsets of their transcript segments; media without
+// segments cannot be placed and are left as they are
+func redactMediaHandler(db *sql.DB, config *configuration.Configuration, objectStore storage.ObjectStore) JobHandler {
+	return func(jobContext context.Context, job *models.Job, updateProgress func(int, string, any, models.JobMetrics)) error {
+		var payload struct {
+			LectureID string            `json:"lecture_id"`
+			Spans     []models.TimeSpan `json:"spans"`
+		}
+		if err := json.Unmarshal([]byte(job.Payload), &payload); err != nil {
+			return fmt.Errorf("invalid payload: %w", err)
+		}
+
+		type mediaFile struct {
+			id                  string
+			filePath            string
+			offsetMillisecond   int64
+			durationMillisecond int64
+		}
+		rows, err := db.Query(`
+			SELECT lecture_media.id, lecture_media.file_path,
+			       MIN(transcript_segments.start_millisecond - COALESCE(transcript_segments.original_start_milliseconds, 0)),
+			       MAX(transcript_segments.end_millisecond)
+			FROM lecture_media
+			JOIN transcript_segments ON transcript_segments.media_id = lecture_media.id
+			WHERE lecture_media.lecture_id = ?
+			GROUP BY lecture_media.id
+			ORDER BY lecture_media.sequence_order
+		`, payload.LectureID)
+		if err != nil {
+			return fmt.Errorf("failed to list media: %w", err)
+		}
+		var mediaFiles []mediaFile
+		for rows.Next() {
+			var file mediaFile
+			var endMillisecond int64
+			if err := rows.Scan(&file.id, &file.filePath, &file.offsetMillisecond, &endMillisecond); err != nil {
+				rows.Close()
+				return err
+			}
+			file.durationMillisecond = endMillisecond - file.offsetMillisecond
+			mediaFiles = append(mediaFiles, file)
+		}
+		rows.Close()
+
+		temporaryDirectory := filepath.Join(os.TempDir(), "lectures-jobs", job.ID)
+		if err := os.MkdirAll(temporaryDirectory, 0755); err != nil {
+			return fmt.Errorf("failed to create temporary directory: %w", err)
+		}
+		defer os.RemoveAll(temporaryDirectory)
+
+		bleepedMedia := 0
+		for index, file := range mediaFiles {
+			if err := jobContext.Err(); err != nil {
+				return err
+			}
+			// Spans are moved onto the timeline of the media file, and those outside it dropped
+			var mediaSpans []models.TimeSpan
+			for _, span := range payload.Spans {
+				start := max(span.StartMillisecond-file.offsetMillisecond, 0)
+				end := min(span.EndMillisecond-file.offsetMillisecond, file.durationMillisecond)
+				if end > start {
+					mediaSpans = append(mediaSpans, models.TimeSpan{StartMillisecond: start, EndMillisecond: end})
+				}
+			}
+			if len(mediaSpans) == 0 {
+				continue
+			}
+
+			updateProgress(100*index/len(mediaFiles), fmt.Sprintf("Bleeping media %d/%d...", index+1, len(mediaFiles)), nil, models.JobMetrics{})
+			if err := bleepMediaFile(jobContext, db, config, objectStore, payload.LectureID, file.id, file.filePath, mediaSpans, temporaryDirectory); err != nil {
+				return err
+			}
+			bleepedMedia++
+		}
+
+		job.Result = fmt.Sprintf(`{"bleeped_media": %d}`, bleepedMedia)
+		updateProgress(100, fmt.Sprintf("Bleeped %d media files", bleepedMedia), nil, models.JobMetrics{})
+		return nil
+	}
+}
+
+// bleepMediaFile replaces a media file of a lecture with a copy bleeped within the spans
+func bleepMediaFile(jobContext context.Context, db *sql.DB, config *configuration.Configuration, objectStore storage.ObjectStore, lectureID string, mediaID string, filePath string, spans []models.TimeSpan, temporaryDirectory string) error {
+	var fileData []byte
+	var objectKey sql.NullString
+	if err := db.QueryRow("SELECT file_data, object_key FROM lecture_media WHERE id = ?", mediaID).Scan(&fileData, &objectKey); err != nil {
+		return fmt.Errorf("failed to read media: %w", err)
+	}
+	content, err := storage.LoadContent(jobContext, objectStore, fileData, objectKey.String)
+	if err != nil {
+		return fmt.Errorf("failed to load media: %w", err)
+	}
+	// Media of older versions may still be a file of the data directory
+	if len(content) == 0 {
+		if content, err = os.ReadFile(storage.NewLayout(config.Storage).ResolvePath(filePath)); err != nil {
+			return fmt.Errorf("failed to read media: %w", err)
+		}
+	}
+
+	extension := strings.ToLower(filepath.Ext(filePath))
+	inputPath := filepath.Join(temporaryDirectory, mediaID+extension)
+	outputPath := filepath.Join(temporaryDirectory, mediaID+".bleeped"+extension)
+	if err := os.WriteFile(inputPath, content, 0644); err != nil {
+		return err
+	}
+	if err := media.BleepAudio(inputPath, outputPath, spans, config.Storage.BinDirectory); err != nil {
+		return err
+	}
+	bleepedContent, err := os.ReadFile(outputPath)
+	if err != nil {
+		return err
+	}
+
+	bleepedData, bleepedObjectKey, err := storage.StoreContent(jobContext, objectStore, storage.MediaObjectKey(lectureID, mediaID, extension), bleepedContent, mime.TypeByExtension(extension))
+	if err != nil {
+		return err
+	}
+	if _, err := db.Exec("UPDATE lecture_media SET file_data = ?, object_key = ?, size_bytes = ? WHERE id = ?", bleepedData, bleepedObjectKey, len(bleepedContent), mediaID); err != nil {
+		return fmt.Errorf("failed to store bleeped media: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,130 @@
+package jobs
+
+import (
+	"database/sql"
+	"fmt"
+	"slices"
+	"strings"
+
+	"lectures/internal/database"
+	"lectures/internal/documents"
+	"lectures/internal/markdown"
+	"lectures/internal/models"
+)
+
+// generationSources selects what a material is generated from. A generation allowed from partial sources only
+// reads the recording and the documents that finished processing by the time it runs
+type generationSources struct {
+	useTranscript        bool
+	completedDocumentIDs map[string]bool // Nil when every document is used
+	partial              bool            // Some source was left out because it failed or is still processing
+}
+
+func selectGenerationSources(db *sql.DB, lectureID string, allowPartialSources bool) (generationSources, error) {
+	selection := generationSources{useTranscript: true}
+	if !allowPartialSources {
+		return selection, nil
+	}
+
+	sources, err := database.GetLectureSources(db, lectureID)
+	if err != nil {
+		return selection, fmt.Errorf("failed to check lecture sources: %w", err)
+	}
+	if sources.Complete() {
+		return selection, nil
+	}
+	if !sources.HasUsableSource() {
+		return selection, fmt.Errorf("no source of the lecture has finished processing")
+	}
+
+	selection.partial = true
+	selection.useTranscript = sources.TranscriptStatus == "completed"
+	selection.completedDocumentIDs = make(map[string]bool)
+	rows, err := db.Query("SELECT id FROM reference_documents WHERE lecture_id = ? AND extraction_status = 'completed'", lectureID)
+	if err != nil {
+		return selection, fmt.Errorf("failed to query completed documents: %w", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var documentID string
+		if rows.Scan(&documentID) == nil {
+			selection.completedDocumentIDs[documentID] = true
+		}
+	}
+	return selection, rows.Err()
+}
+
+// filterChunks drops the chunks of documents left out of the generation
+func (selection generationSources) filterChunks(chunks []documents.DocumentChunk) []documents.DocumentChunk {
+	if selection.completedDocumentIDs == nil {
+		return chunks
+	}
+	var selectedChunks []documents.DocumentChunk
+	for _, chunk := range chunks {
+		if selection.completedDocumentIDs[chunk.DocumentID] {
+			selectedChunks = append(selectedChunks, chunk)
+		}
+	}
+	return selectedChunks
+}
+
+// lectureTranscript returns the text of the transcript of a lecture, its segments joined in order
+func lectureTranscript(db *sql.DB, lectureID string) (string, error) {
+	rows, err := db.Query(`
+		SELECT text FROM transcript_segments 
+		WHERE transcript_id = (SELECT id FROM transcripts WHERE lecture_id = ?)
+		ORDER BY start_millisecond ASC
+	`, lectureID)
+	if err != nil {
+		return "", fmt.Errorf("failed to query transcript: %w", err)
+	}
+	defer rows.Close()
+
+	var transcriptBuilder strings.Builder
+	for rows.Next() {
+		var text string
+		if rows.Scan(&text) == nil {
+			transcriptBuilder.WriteString(text + " ")
+		}
+	}
+	return transcriptBuilder.String(), rows.Err()
+}
+
+// referenceMaterials lays out reference chunks as the reference files of a generation prompt: a heading per
+// document, then a heading per chunk naming its pages. Pages in another language than the tool are labeled with
+// it and the language is added to the source languages of the options, so generation can translate or quote them
+func referenceMaterials(chunks []documents.DocumentChunk, languageCode string, options *models.GenerationOptions) string {
+	markdownReconstructor := markdown.NewReconstructor()
+	markdownReconstructor.Language = languageCode
+	rootNode := &markdown.Node{Type: markdown.NodeDocument}
+	currentDocumentID := ""
+
+	for _, chunk := range chunks {
+		if chunk.DocumentID != currentDocumentID {
+			rootNode.Children = append(rootNode.Children, &markdown.Node{
+				Type:    markdown.NodeHeading,
+				Level:   1,
+				Content: "Reference File: `" + chunk.DocumentTitle + "`",
+			})
+			currentDocumentID = chunk.DocumentID
+		}
+		pageLabel := chunk.PageLabel()
+		if chunk.Language != "" && !documents.SameLanguage(chunk.Language, languageCode) {
+			pageLabel += " (" + chunk.Language + ")"
+			if !slices.Contains(options.SourceLanguages, chunk.Language) {
+				options.SourceLanguages = append(options.SourceLanguages, chunk.Language)
+			}
+		}
+		rootNode.Children = append(rootNode.Children, &markdown.Node{
+			Type:    markdown.NodeHeading,
+			Level:   2,
+			Content: pageLabel,
+		})
+		rootNode.Children = append(rootNode.Children, &markdown.Node{
+			Type:    markdown.NodeParagraph,
+			Content: chunk.Content,
+		})
+	}
+
+	return markdownReconstructor.Reconstruct(rootNode)
+}
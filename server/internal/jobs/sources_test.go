@@ -0,0 +1,54 @@
+package jobs
+
+import (
+	"testing"
+
+	"lectures/internal/documents"
+	"lectures/internal/models"
+)
+
+func TestSelectGenerationSources(t *testing.T) {
+	queue, cleanup := setupQueueTestDatabase(t)
+	defer cleanup()
+	db := queue.database
+
+	db.Exec("INSERT INTO exams (id, user_id, title) VALUES ('sources-exam', 'user-1', 'Optics')")
+	db.Exec("INSERT INTO lectures (id, exam_id, title, status) VALUES ('sources-lecture', 'sources-exam', 'Lenses', 'processing')")
+	db.Exec("INSERT INTO transcripts (id, lecture_id, status) VALUES ('sources-transcript', 'sources-lecture', 'failed')")
+	db.Exec("INSERT INTO reference_documents (id, lecture_id, document_type, title, file_path, page_count, extraction_status) VALUES ('slides', 'sources-lecture', 'pdf', 'Slides', 'slides.pdf', 1, 'completed')")
+	db.Exec("INSERT INTO reference_documents (id, lecture_id, document_type, title, file_path, page_count, extraction_status) VALUES ('notes', 'sources-lecture', 'pdf', 'Notes', 'notes.pdf', 1, 'processing')")
+
+	// Without the option every source is used, as for a ready lecture
+	selection, err := selectGenerationSources(db, "sources-lecture", false)
+	if err != nil || selection.partial || !selection.useTranscript || selection.completedDocumentIDs != nil {
+		t.Fatalf("Expected every source to be used without the option, got %+v (%v)", selection, err)
+	}
+
+	selection, err = selectGenerationSources(db, "sources-lecture", true)
+	if err != nil {
+		t.Fatalf("selectGenerationSources failed: %v", err)
+	}
+	if !selection.partial || selection.useTranscript {
+		t.Errorf("Expected the failed transcript to be left out, got %+v", selection)
+	}
+	chunks := []documents.DocumentChunk{
+		{ReferenceChunk: models.ReferenceChunk{DocumentID: "slides"}},
+		{ReferenceChunk: models.ReferenceChunk{DocumentID: "notes"}},
+	}
+	if selectedChunks := selection.filterChunks(chunks); len(selectedChunks) != 1 || selectedChunks[0].DocumentID != "slides" {
+		t.Errorf("Expected only the chunks of the extracted slides, got %+v", selectedChunks)
+	}
+
+	// Once everything has finished the tool is no longer partial
+	db.Exec("UPDATE transcripts SET status = 'completed'")
+	db.Exec("UPDATE reference_documents SET extraction_status = 'completed'")
+	if selection, _ := selectGenerationSources(db, "sources-lecture", true); selection.partial {
+		t.Errorf("Expected a complete lecture not to be partial, got %+v", selection)
+	}
+
+	db.Exec("UPDATE transcripts SET status = 'failed'")
+	db.Exec("UPDATE reference_documents SET extraction_status = 'failed'")
+	if _, err := selectGenerationSources(db, "sources-lecture", true); err == nil {
+		t.Error("Expected an error when no source has finished")
+	}
+}
@@ -0,0 +1,148 @@
+package jobs
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"lectures/internal/configuration"
+	"lectures/internal/documents"
+	"lectures/internal/models"
+	"lectures/internal/tools"
+
+	gonanoid "github.com/matoous/go-nanoid/v2"
+)
+
+// importSyllabusHandler reads a staged syllabus PDF and scaffolds its exam: the document processor reads the
+// pages, the outline model extracts the course and its scheduled sessions, and a planned lecture is added for
+// each session. The exam keeps the title it was created with when the user chose it
+func importSyllabusHandler(database *sql.DB, config *configuration.Configuration, documentProcessor *documents.Processor, toolGenerator *tools.ToolGenerator) JobHandler {
+	return func(jobContext context.Context, job *models.Job, updateProgress func(int, string, any, models.JobMetrics)) error {
+		var payload struct {
+			ExamID       string `json:"exam_id"`
+			UploadID     string `json:"upload_id"`
+			Filename     string `json:"filename"`
+			LanguageCode string `json:"language_code"` // Empty to read the syllabus in the language detected from its text
+			KeepTitle    bool   `json:"keep_title"`
+		}
+		if unmarshalingError := json.Unmarshal([]byte(job.Payload), &payload); unmarshalingError != nil {
+			return fmt.Errorf("failed to unmarshal job payload: %w", unmarshalingError)
+		}
+		if documentProcessor == nil || toolGenerator == nil {
+			return fmt.Errorf("syllabus import is not available on this server")
+		}
+		languageCode := payload.LanguageCode
+		if languageCode == "" {
+			languageCode = config.LLM.Language
+		}
+
+		// The staged upload is kept until the import succeeds, so a requeued job reads it again
+		uploadDirectory := filepath.Join(os.TempDir(), "lectures-uploads", payload.UploadID)
+		syllabusContent, err := os.ReadFile(filepath.Join(uploadDirectory, "upload.data"))
+		if err != nil {
+			return fmt.Errorf("failed to read staged syllabus: %w", err)
+		}
+		workDirectory := filepath.Join(os.TempDir(), "lectures-jobs", job.ID)
+		if err := os.MkdirAll(workDirectory, 0755); err != nil {
+			return fmt.Errorf("failed to create work directory: %w", err)
+		}
+		defer os.RemoveAll(workDirectory)
+
+		documentID, _ := gonanoid.New()
+		syllabusPath := filepath.Join(workDirectory, documentID+".pdf")
+		if err := os.WriteFile(syllabusPath, syllabusContent, 0644); err != nil {
+			return fmt.Errorf("failed to stage syllabus: %w", err)
+		}
+
+		if err := documentProcessor.PrepareModel(jobContext, reportModelLoading(updateProgress)); err != nil {
+			return err
+		}
+		updateProgress(5, "Reading syllabus...", nil, models.JobMetrics{})
+		pages, totalMetrics, err := documentProcessor.ProcessDocument(jobContext, models.ReferenceDocument{
+			ID:       documentID,
+			Title:    payload.Filename,
+			FilePath: syllabusPath,
+			Language: payload.LanguageCode,
+		}, filepath.Join(workDirectory, "pages"), languageCode, func(progress int, message string) {
+			updateProgress(5+progress*6/10, message, nil, models.JobMetrics{})
+		})
+		if err != nil {
+			return fmt.Errorf("failed to read the syllabus: %w", err)
+		}
+		// Reported before extracting, so the spend of reading the pages is recorded even if extraction fails
+		updateProgress(65, "Extracting the course schedule...", nil, totalMetrics)
+
+		var syllabusText strings.Builder
+		for _, page := range pages {
+			fmt.Fprintf(&syllabusText, "--- Page %d ---\n%s\n\n", page.PageNumber, page.ExtractedText)
+		}
+		syllabus, extractionMetrics, err := toolGenerator.ExtractSyllabus(jobContext, syllabusText.String(), languageCode, models.GenerationOptions{})
+		totalMetrics.InputTokens += extractionMetrics.InputTokens
+		totalMetrics.OutputTokens += extractionMetrics.OutputTokens
+		totalMetrics.EstimatedCost += extractionMetrics.EstimatedCost
+		updateProgress(90, "Planning lectures...", nil, totalMetrics)
+		if err != nil {
+			return fmt.Errorf("failed to read the course from the syllabus: %w", err)
+		}
+		if syllabus.Title == "" && len(syllabus.Lectures) == 0 {
+			return fmt.Errorf("no course or schedule was found in the syllabus")
+		}
+
+		lectureCount, err := planSyllabusLectures(database, payload.ExamID, payload.LanguageCode, syllabus, payload.KeepTitle, totalMetrics.EstimatedCost)
+		if err != nil {
+			return err
+		}
+		os.RemoveAll(uploadDirectory)
+
+		job.Result = fmt.Sprintf(`{"exam_id": "%s", "lecture_count": %d}`, payload.ExamID, lectureCount)
+		updateProgress(100, "Syllabus imported", nil, totalMetrics)
+		return nil
+	}
+}
+
+// planSyllabusLectures adds a planned lecture for each session of a syllabus to its exam, after the lectures it
+// already has, and describes the exam with the course of the syllabus, in one transaction
+func planSyllabusLectures(database *sql.DB, examID string, languageCode string, syllabus tools.Syllabus, keepTitle bool, estimatedCost float64) (int, error) {
+	transaction, err := database.Begin()
+	if err != nil {
+		return 0, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer transaction.Rollback()
+
+	now := time.Now()
+	query := "UPDATE exams SET description = CASE WHEN COALESCE(description, '') = '' THEN ? ELSE description END, estimated_cost = estimated_cost + ?, updated_at = ?"
+	arguments := []any{syllabus.Description, estimatedCost, now}
+	if !keepTitle && syllabus.Title != "" {
+		query += ", title = ?"
+		arguments = append(arguments, syllabus.Title)
+	}
+	result, err := transaction.Exec(query+" WHERE id = ?", append(arguments, examID)...)
+	if err != nil {
+		return 0, fmt.Errorf("failed to update exam: %w", err)
+	}
+	if affectedRows, _ := result.RowsAffected(); affectedRows == 0 {
+		return 0, fmt.Errorf("exam %s no longer exists", examID)
+	}
+
+	for index, syllabusLecture := range syllabus.Lectures {
+		lectureID, _ := gonanoid.New()
+		// Lectures are listed by creation time, so each session is created after the one before it
+		_, err = transaction.Exec(`
+			INSERT INTO lectures (id, exam_id, title, description, specified_date, language, status, metadata_fields, created_at, updated_at)
+			VALUES (?, ?, ?, ?, ?, ?, 'planned', '[]', ?, ?)
+		`, lectureID, examID, syllabusLecture.Title, syllabusLecture.Description, syllabusLecture.Date, languageCode, now.Add(time.Duration(index)*time.Millisecond), now)
+		if err != nil {
+			return 0, fmt.Errorf("failed to plan lecture: %w", err)
+		}
+	}
+
+	if err := transaction.Commit(); err != nil {
+		return 0, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return len(syllabus.Lectures), nil
+}
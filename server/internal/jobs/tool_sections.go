@@ -0,0 +1,162 @@
+package jobs
+
+import (
+	"database/sql"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	"lectures/internal/models"
+)
+
+// toolSectionRecorder stores the outline and the accepted sections of a study guide or course overview in
+// tool_sections as they are generated, keyed by the build job until the tool is stored. Course overviews belong to
+// their exam and have no lectureID
+type toolSectionRecorder struct {
+	database  *sql.DB
+	jobID     string
+	examID    string
+	lectureID string
+	outlineID int64
+	mutex     sync.Mutex
+}
+
+// recordOutline stores the outline every section is stored under
+func (recorder *toolSectionRecorder) recordOutline(outline models.ToolSection) {
+	recorder.mutex.Lock()
+	defer recorder.mutex.Unlock()
+
+	outline.JobID, outline.ExamID, outline.LectureID = recorder.jobID, recorder.examID, recorder.lectureID
+	outlineID, err := insertToolSection(recorder.database, outline)
+	if err != nil {
+		slog.Warn("Failed to store study guide outline", "jobID", recorder.jobID, "error", err)
+		return
+	}
+	recorder.outlineID = outlineID
+}
+
+// recordSection stores an accepted section under the outline. Sections are only useful with their outline, so
+// none is stored when the outline could not be
+func (recorder *toolSectionRecorder) recordSection(section models.ToolSection) {
+	recorder.mutex.Lock()
+	defer recorder.mutex.Unlock()
+
+	if recorder.outlineID == 0 {
+		return
+	}
+	section.JobID, section.ExamID, section.LectureID, section.ParentID = recorder.jobID, recorder.examID, recorder.lectureID, recorder.outlineID
+	if _, err := insertToolSection(recorder.database, section); err != nil {
+		slog.Warn("Failed to store study guide section", "jobID", recorder.jobID, "position", section.Position, "error", err)
+	}
+}
+
+func insertToolSection(database *sql.DB, section models.ToolSection) (int64, error) {
+	var parentID any
+	if section.ParentID != 0 {
+		parentID = section.ParentID
+	}
+	var adherenceScore any
+	if section.AdherenceScore != nil {
+		adherenceScore = *section.AdherenceScore
+	}
+	result, err := database.Exec(`
+		INSERT INTO tool_sections (job_id, exam_id, lecture_id, parent_id, level, position, title, coverage, content, model, attempts,
+			adherence_score, input_tokens, output_tokens, estimated_cost, created_at)
+		VALUES (?, ?, NULLIF(?, ''), ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+	`, section.JobID, section.ExamID, section.LectureID, parentID, section.Level, section.Position, section.Title, section.Coverage, section.Content,
+		section.Model, section.Attempts, adherenceScore, section.InputTokens, section.OutputTokens, section.EstimatedCost, time.Now())
+	if err != nil {
+		return 0, err
+	}
+	return result.LastInsertId()
+}
+
+// requeuedFromJobID returns the failed job that jobID was requeued from, or an empty string when it was enqueued
+// directly. A build requeued from the dead-letter queue runs under a new ID and resumes the failed job's sections
+func requeuedFromJobID(database *sql.DB, jobID string) string {
+	var failedJobID string
+	database.QueryRow("SELECT id FROM jobs WHERE requeued_as = ?", jobID).Scan(&failedJobID)
+	return failedJobID
+}
+
+// resumeToolSections prepares the recorder of a build job and returns the outline and accepted sections left by
+// an interrupted generation of the same tool: the job's own, when it was requeued, or those of resumeJobID, which
+// are taken over by the job. The outline is empty when there is nothing to resume
+func (recorder *toolSectionRecorder) resumeToolSections(resumeJobID string) (string, map[int]string, error) {
+	if resumeJobID != "" && resumeJobID != recorder.jobID {
+		_, err := recorder.database.Exec(`
+			UPDATE tool_sections SET job_id = ?
+			WHERE job_id = ? AND exam_id = ? AND COALESCE(lecture_id, '') = ? AND tool_id IS NULL
+		`, recorder.jobID, resumeJobID, recorder.examID, recorder.lectureID)
+		if err != nil {
+			return "", nil, fmt.Errorf("failed to take over the sections of job %s: %w", resumeJobID, err)
+		}
+	}
+
+	var outline string
+	err := recorder.database.QueryRow(`
+		SELECT id, content FROM tool_sections
+		WHERE job_id = ? AND exam_id = ? AND COALESCE(lecture_id, '') = ? AND tool_id IS NULL AND level = 1
+		ORDER BY id DESC LIMIT 1
+	`, recorder.jobID, recorder.examID, recorder.lectureID).Scan(&recorder.outlineID, &outline)
+	if err == sql.ErrNoRows {
+		return "", nil, nil
+	}
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to query the stored outline: %w", err)
+	}
+
+	sectionRows, err := recorder.database.Query(`
+		SELECT position, content FROM tool_sections
+		WHERE parent_id = ? AND level = 2
+		ORDER BY id ASC
+	`, recorder.outlineID)
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to query the stored sections: %w", err)
+	}
+	defer sectionRows.Close()
+
+	sections := make(map[int]string)
+	for sectionRows.Next() {
+		var position int
+		var content string
+		if sectionRows.Scan(&position, &content) == nil {
+			sections[position] = content
+		}
+	}
+	return outline, sections, sectionRows.Err()
+}
+
+// attachToolSections links the sections generated by a job to the stored tool, and drops the leftovers of
+// generations of the same lecture, or of the exam for course overviews, that never finished
+func attachToolSections(transaction *sql.Tx, jobID string, toolID string, examID string, lectureID string) error {
+	if _, err := transaction.Exec("UPDATE tool_sections SET tool_id = ? WHERE job_id = ? AND tool_id IS NULL", toolID, jobID); err != nil {
+		return fmt.Errorf("failed to attach sections to the tool: %w", err)
+	}
+	_, err := transaction.Exec(`
+		DELETE FROM tool_sections
+		WHERE exam_id = ? AND COALESCE(lecture_id, '') = ? AND tool_id IS NULL AND job_id != ?
+	`, examID, lectureID, jobID)
+	if err != nil {
+		return fmt.Errorf("failed to remove abandoned sections: %w", err)
+	}
+	return nil
+}
+
+// DetachToolSections prepares the regeneration of one section of a stored tool: the outline and the other
+// sections are detached from the tool under resumeJobID, for the build given that resume_job_id to take over,
+// and the section is dropped so that build generates it again. It fails when sectionID is not a section of the tool
+func DetachToolSections(transaction *sql.Tx, toolID string, sectionID int64, resumeJobID string) error {
+	result, err := transaction.Exec("DELETE FROM tool_sections WHERE id = ? AND tool_id = ? AND level = 2", sectionID, toolID)
+	if err != nil {
+		return fmt.Errorf("failed to drop the section: %w", err)
+	}
+	if deletedRows, _ := result.RowsAffected(); deletedRows == 0 {
+		return sql.ErrNoRows
+	}
+	if _, err := transaction.Exec("UPDATE tool_sections SET tool_id = NULL, job_id = ? WHERE tool_id = ?", resumeJobID, toolID); err != nil {
+		return fmt.Errorf("failed to detach the sections: %w", err)
+	}
+	return nil
+}
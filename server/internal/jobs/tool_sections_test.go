@@ -0,0 +1,172 @@
+package jobs
+
+import (
+	"database/sql"
+	"path/filepath"
+	"testing"
+
+	"lectures/internal/database"
+	"lectures/internal/models"
+)
+
+func TestToolSections_RecordAndResume(t *testing.T) {
+	db, err := database.Initialize(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatalf("Failed to init DB: %v", err)
+	}
+	defer db.Close()
+
+	_, _ = db.Exec("INSERT INTO users (id, username, password_hash) VALUES ('sections-user', 'tester', 'hash')")
+	_, _ = db.Exec("INSERT INTO exams (id, user_id, title) VALUES ('sections-exam', 'sections-user', 'Physics')")
+	_, _ = db.Exec("INSERT INTO lectures (id, exam_id, title) VALUES ('sections-lecture', 'sections-exam', 'Optics')")
+
+	// The failed job stored its outline and its first section before stopping
+	failedRecorder := &toolSectionRecorder{database: db, jobID: "failed-job", examID: "sections-exam", lectureID: "sections-lecture"}
+	failedRecorder.recordOutline(models.ToolSection{Level: 1, Title: "Optics", Content: "# Optics\n## Lenses\n## Mirrors", Model: "structure-model"})
+	score := 88
+	failedRecorder.recordSection(models.ToolSection{Level: 2, Position: 0, Title: "Lenses", Content: "## Lenses\nThin lens equation", Model: "generation-model", Attempts: 2, AdherenceScore: &score})
+
+	retryRecorder := &toolSectionRecorder{database: db, jobID: "retry-job", examID: "sections-exam", lectureID: "sections-lecture"}
+	outline, sections, err := retryRecorder.resumeToolSections("failed-job")
+	if err != nil {
+		t.Fatalf("Resume failed: %v", err)
+	}
+	if outline != "# Optics\n## Lenses\n## Mirrors" || len(sections) != 1 || sections[0] != "## Lenses\nThin lens equation" {
+		t.Fatalf("Unexpected resumed outline %q and sections %v", outline, sections)
+	}
+
+	// The retry adds the missing section under the same outline, then the guide is stored
+	retryRecorder.recordSection(models.ToolSection{Level: 2, Position: 1, Title: "Mirrors", Content: "## Mirrors\nReflection", Model: "generation-model", Attempts: 1})
+	_, _ = db.Exec("INSERT INTO tools (id, exam_id, lecture_id, type, title, content) VALUES ('sections-tool', 'sections-exam', 'sections-lecture', 'guide', 'Optics', '')")
+
+	// An abandoned generation of the same lecture is dropped once the guide is stored
+	abandonedRecorder := &toolSectionRecorder{database: db, jobID: "abandoned-job", examID: "sections-exam", lectureID: "sections-lecture"}
+	abandonedRecorder.recordOutline(models.ToolSection{Level: 1, Title: "Old", Content: "# Old"})
+
+	transaction, _ := db.Begin()
+	if err := attachToolSections(transaction, "retry-job", "sections-tool", "sections-exam", "sections-lecture"); err != nil {
+		t.Fatalf("Attach failed: %v", err)
+	}
+	transaction.Commit()
+
+	var attachedCount, childCount, leftoverCount int
+	db.QueryRow("SELECT COUNT(*) FROM tool_sections WHERE tool_id = 'sections-tool'").Scan(&attachedCount)
+	db.QueryRow("SELECT COUNT(*) FROM tool_sections WHERE tool_id = 'sections-tool' AND parent_id = ?", retryRecorder.outlineID).Scan(&childCount)
+	db.QueryRow("SELECT COUNT(*) FROM tool_sections WHERE tool_id IS NULL").Scan(&leftoverCount)
+	if attachedCount != 3 || childCount != 2 {
+		t.Errorf("Expected the outline and both sections on the guide, got %d rows with %d sections", attachedCount, childCount)
+	}
+	if leftoverCount != 0 {
+		t.Errorf("Expected abandoned sections to be removed, %d left", leftoverCount)
+	}
+
+	var adherenceScore, attempts int
+	db.QueryRow("SELECT adherence_score, attempts FROM tool_sections WHERE title = 'Lenses'").Scan(&adherenceScore, &attempts)
+	if adherenceScore != 88 || attempts != 2 {
+		t.Errorf("Expected the generation metadata to be kept, got score %d after %d attempts", adherenceScore, attempts)
+	}
+
+	// Deleting the guide removes its sections
+	_, _ = db.Exec("DELETE FROM tools WHERE id = 'sections-tool'")
+	db.QueryRow("SELECT COUNT(*) FROM tool_sections").Scan(&attachedCount)
+	if attachedCount != 0 {
+		t.Errorf("Expected the sections to be deleted with the guide, %d left", attachedCount)
+	}
+}
+
+func TestToolSections_ResumeRequeuedBuild(t *testing.T) {
+	queue, cleanup := setupQueueTestDatabase(t)
+	defer cleanup()
+	db := queue.database
+
+	_, _ = db.Exec("INSERT INTO exams (id, user_id, title) VALUES ('requeue-exam', 'user-1', 'Physics')")
+	_, _ = db.Exec("INSERT INTO lectures (id, exam_id, title) VALUES ('requeue-lecture', 'requeue-exam', 'Optics')")
+
+	// The build failed at its last section, after the outline and the first sections were accepted
+	failedJobID, _ := queue.Enqueue("user-1", models.JobTypeBuildMaterial, map[string]string{"type": "guide"}, "", "")
+	failedRecorder := &toolSectionRecorder{database: db, jobID: failedJobID, examID: "requeue-exam", lectureID: "requeue-lecture"}
+	failedRecorder.recordOutline(models.ToolSection{Level: 1, Title: "Optics", Content: "# Optics\n## Lenses\n## Mirrors\n## Prisms"})
+	failedRecorder.recordSection(models.ToolSection{Level: 2, Position: 0, Title: "Lenses", Content: "## Lenses"})
+	failedRecorder.recordSection(models.ToolSection{Level: 2, Position: 1, Title: "Mirrors", Content: "## Mirrors"})
+	queue.failJob(failedJobID, "generation failed", models.JobFailure{Code: models.JobFailureInterrupted})
+
+	if requeuedFromJobID(db, failedJobID) != "" {
+		t.Error("Expected a job enqueued directly not to resume another one")
+	}
+
+	// Requeued from the dead-letter queue, the build runs under a new ID without resume_job_id
+	requeuedJobID, err := queue.RequeueJob(failedJobID)
+	if err != nil {
+		t.Fatalf("RequeueJob failed: %v", err)
+	}
+	resumeJobID := requeuedFromJobID(db, requeuedJobID)
+	if resumeJobID != failedJobID {
+		t.Fatalf("Expected the requeued build to resume %s, got %q", failedJobID, resumeJobID)
+	}
+
+	retryRecorder := &toolSectionRecorder{database: db, jobID: requeuedJobID, examID: "requeue-exam", lectureID: "requeue-lecture"}
+	outline, sections, err := retryRecorder.resumeToolSections(resumeJobID)
+	if err != nil {
+		t.Fatalf("Resume failed: %v", err)
+	}
+	if outline == "" || len(sections) != 2 || sections[1] != "## Mirrors" {
+		t.Errorf("Expected the accepted sections to be reused, got outline %q and sections %v", outline, sections)
+	}
+}
+
+func TestToolSections_RegenerateCourseOverviewSection(t *testing.T) {
+	db, err := database.Initialize(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatalf("Failed to init DB: %v", err)
+	}
+	defer db.Close()
+
+	_, _ = db.Exec("INSERT INTO users (id, username, password_hash) VALUES ('overview-user', 'tester', 'hash')")
+	_, _ = db.Exec("INSERT INTO exams (id, user_id, title) VALUES ('overview-exam', 'overview-user', 'Physics')")
+	_, _ = db.Exec("INSERT INTO lectures (id, exam_id, title) VALUES ('overview-lecture', 'overview-exam', 'Optics')")
+
+	// A guide of one lecture is still being generated while the overview of the exam is stored
+	guideRecorder := &toolSectionRecorder{database: db, jobID: "guide-job", examID: "overview-exam", lectureID: "overview-lecture"}
+	guideRecorder.recordOutline(models.ToolSection{Level: 1, Title: "Optics", Content: "# Optics"})
+
+	overviewRecorder := &toolSectionRecorder{database: db, jobID: "overview-job", examID: "overview-exam"}
+	overviewRecorder.recordOutline(models.ToolSection{Level: 1, Title: "Physics", Content: "# Physics\n## Optics\n## Waves"})
+	overviewRecorder.recordSection(models.ToolSection{Level: 2, Position: 0, Title: "Optics", Content: "## Optics"})
+	overviewRecorder.recordSection(models.ToolSection{Level: 2, Position: 1, Title: "Waves", Content: "## Waves"})
+	_, _ = db.Exec("INSERT INTO tools (id, exam_id, type, title, content) VALUES ('overview-tool', 'overview-exam', 'course_overview', 'Physics', '')")
+
+	transaction, _ := db.Begin()
+	if err := attachToolSections(transaction, "overview-job", "overview-tool", "overview-exam", ""); err != nil {
+		t.Fatalf("Attach failed: %v", err)
+	}
+	transaction.Commit()
+
+	var attachedCount, guideCount int
+	db.QueryRow("SELECT COUNT(*) FROM tool_sections WHERE tool_id = 'overview-tool' AND lecture_id IS NULL").Scan(&attachedCount)
+	db.QueryRow("SELECT COUNT(*) FROM tool_sections WHERE job_id = 'guide-job'").Scan(&guideCount)
+	if attachedCount != 3 || guideCount != 1 {
+		t.Fatalf("Expected 3 overview rows and the pending guide outline, got %d and %d", attachedCount, guideCount)
+	}
+
+	// Regenerating the second section hands the outline and the first section to the resuming build
+	var wavesID int64
+	db.QueryRow("SELECT id FROM tool_sections WHERE title = 'Waves'").Scan(&wavesID)
+	transaction, _ = db.Begin()
+	if err := DetachToolSections(transaction, "overview-tool", overviewRecorder.outlineID, "regenerate-overview-tool"); err != sql.ErrNoRows {
+		t.Errorf("Expected the outline not to be regenerated as a section, got %v", err)
+	}
+	if err := DetachToolSections(transaction, "overview-tool", wavesID, "regenerate-overview-tool"); err != nil {
+		t.Fatalf("Detach failed: %v", err)
+	}
+	transaction.Commit()
+	_, _ = db.Exec("DELETE FROM tools WHERE id = 'overview-tool'")
+
+	rebuildRecorder := &toolSectionRecorder{database: db, jobID: "rebuild-job", examID: "overview-exam"}
+	outline, sections, err := rebuildRecorder.resumeToolSections("regenerate-overview-tool")
+	if err != nil {
+		t.Fatalf("Resume failed: %v", err)
+	}
+	if outline != "# Physics\n## Optics\n## Waves" || len(sections) != 1 || sections[0] != "## Optics" {
+		t.Errorf("Expected the outline and the kept section, got outline %q and sections %v", outline, sections)
+	}
+}
@@ -0,0 +1,20 @@
+package jobs
+
+import (
+	"database/sql"
+
+	"lectures/internal/database"
+	"lectures/internal/models"
+)
+
+// restoreJobTranscripts brings back the archived transcripts a job may read: those of its lecture, or of every
+// lecture of its exam when the job works on the whole exam
+func restoreJobTranscripts(db *sql.DB, job *models.Job) error {
+	if job.LectureID != "" {
+		return database.RestoreLectureTranscripts(db, job.LectureID)
+	}
+	if job.CourseID != "" {
+		return database.RestoreExamTranscripts(db, job.CourseID)
+	}
+	return nil
+}
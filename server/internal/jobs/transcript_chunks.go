@@ -0,0 +1,59 @@
+package jobs
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"lectures/internal/models"
+)
+
+// transcriptChunkStore checkpoints the chunks of a transcript in the transcript_chunks table
+type transcriptChunkStore struct {
+	database     *sql.DB
+	transcriptID string
+}
+
+// LoadChunks returns the chunks of a media file completed by earlier runs with the same layout
+func (store *transcriptChunkStore) LoadChunks(mediaID string, layout string) (map[int][]models.TranscriptSegment, error) {
+	rows, queryError := store.database.Query(`
+		SELECT chunk_index, segments FROM transcript_chunks
+		WHERE transcript_id = ? AND media_id = ? AND layout = ?
+	`, store.transcriptID, mediaID, layout)
+	if queryError != nil {
+		return nil, fmt.Errorf("failed to query transcript chunks: %w", queryError)
+	}
+	defer rows.Close()
+
+	chunks := make(map[int][]models.TranscriptSegment)
+	for rows.Next() {
+		var chunkIndex int
+		var segmentsJSON string
+		if scanningError := rows.Scan(&chunkIndex, &segmentsJSON); scanningError != nil {
+			return nil, fmt.Errorf("failed to scan transcript chunk: %w", scanningError)
+		}
+		var segments []models.TranscriptSegment
+		if unmarshalingError := json.Unmarshal([]byte(segmentsJSON), &segments); unmarshalingError != nil {
+			return nil, fmt.Errorf("failed to decode transcript chunk %d: %w", chunkIndex, unmarshalingError)
+		}
+		chunks[chunkIndex] = segments
+	}
+	return chunks, rows.Err()
+}
+
+// SaveChunk records the segments of a completed chunk, replacing any earlier result
+func (store *transcriptChunkStore) SaveChunk(mediaID string, layout string, chunkIndex int, segments []models.TranscriptSegment) error {
+	if segments == nil {
+		segments = []models.TranscriptSegment{}
+	}
+	segmentsJSON, marshalingError := json.Marshal(segments)
+	if marshalingError != nil {
+		return fmt.Errorf("failed to encode transcript chunk: %w", marshalingError)
+	}
+	_, executionError := store.database.Exec(`
+		INSERT OR REPLACE INTO transcript_chunks (transcript_id, media_id, layout, chunk_index, segments, created_at)
+		VALUES (?, ?, ?, ?, ?, ?)
+	`, store.transcriptID, mediaID, layout, chunkIndex, string(segmentsJSON), time.Now())
+	return executionError
+}
@@ -0,0 +1,372 @@
+package llm
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net"
+	"net/url"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/ollama/ollama/api"
+	openrouter "github.com/revrost/go-openrouter"
+)
+
+// Defaults of the circuit breaker guarding a provider with a failover
+const (
+	defaultFailureThreshold = 3
+	defaultOpenDuration     = time.Minute
+)
+
+// States of the circuit breaker guarding a provider
+const (
+	CircuitClosed   = "closed"    // Calls go to the provider
+	CircuitOpen     = "open"      // The provider is down: calls go to the failover provider
+	CircuitHalfOpen = "half_open" // A single call probes whether the provider is back
+)
+
+// ErrProviderUnavailable marks the errors of a provider answering with a server error
+var ErrProviderUnavailable = errors.New("provider unavailable")
+
+// Failover names the provider and model serving the calls routed to a provider while it is down
+type Failover struct {
+	Provider         string
+	Model            string
+	FailureThreshold int           // Consecutive outage errors opening the circuit; 0 uses the default of 3
+	OpenDuration     time.Duration // How long calls skip the provider before one probes it again; 0 uses the default of a minute
+}
+
+// circuitBreaker tracks the outages of a provider with a failover
+type circuitBreaker struct {
+	failover            Failover
+	mutex               sync.Mutex
+	state               string
+	consecutiveFailures int
+	openedAt            time.Time
+	probing             bool // A half-open probe is in flight, the other calls keep failing over
+}
+
+// FailoverStatus describes the circuit breaker of a provider, for the admin report
+type FailoverStatus struct {
+	Provider            string     `json:"provider"`
+	FailoverProvider    string     `json:"failover_provider"`
+	FailoverModel       string     `json:"failover_model"`
+	State               string     `json:"state"`
+	ConsecutiveFailures int        `json:"consecutive_failures"`
+	OpenedAt            *time.Time `json:"opened_at,omitempty"`
+}
+
+// ProviderCalls counts the model calls a provider served since the server started
+type ProviderCalls struct {
+	Provider      string `json:"provider"`
+	Calls         int64  `json:"calls"`
+	FailoverCalls int64  `json:"failover_calls"` // Calls served in place of a provider that was down
+	Failures      int64  `json:"failures"`       // Calls that failed with an outage error
+}
+
+// SetFailover sends the calls routed to a provider to another provider and model while the first one is down:
+// after FailureThreshold consecutive connection failures or server errors the circuit opens, and every
+// OpenDuration a single call probes whether the provider is back
+func (routingProvider *RoutingProvider) SetFailover(providerName string, failover Failover) {
+	if failover.FailureThreshold <= 0 {
+		failover.FailureThreshold = defaultFailureThreshold
+	}
+	if failover.OpenDuration <= 0 {
+		failover.OpenDuration = defaultOpenDuration
+	}
+
+	routingProvider.providersMutex.Lock()
+	defer routingProvider.providersMutex.Unlock()
+	routingProvider.circuitBreakers[providerName] = &circuitBreaker{failover: failover, state: CircuitClosed}
+}
+
+// FailoverStatuses reports the circuit breaker of every provider with a failover
+func (routingProvider *RoutingProvider) FailoverStatuses() []FailoverStatus {
+	routingProvider.providersMutex.RLock()
+	defer routingProvider.providersMutex.RUnlock()
+
+	statuses := make([]FailoverStatus, 0, len(routingProvider.circuitBreakers))
+	for providerName, breaker := range routingProvider.circuitBreakers {
+		breaker.mutex.Lock()
+		status := FailoverStatus{
+			Provider:            providerName,
+			FailoverProvider:    breaker.failover.Provider,
+			FailoverModel:       breaker.failover.Model,
+			State:               breaker.state,
+			ConsecutiveFailures: breaker.consecutiveFailures,
+		}
+		if breaker.state != CircuitClosed {
+			openedAt := breaker.openedAt
+			status.OpenedAt = &openedAt
+		}
+		breaker.mutex.Unlock()
+		statuses = append(statuses, status)
+	}
+	sort.Slice(statuses, func(first, second int) bool { return statuses[first].Provider < statuses[second].Provider })
+	return statuses
+}
+
+// ProviderCalls reports the calls served by each provider since the server started
+func (routingProvider *RoutingProvider) ProviderCalls() []ProviderCalls {
+	routingProvider.callsMutex.Lock()
+	defer routingProvider.callsMutex.Unlock()
+
+	calls := make([]ProviderCalls, 0, len(routingProvider.calls))
+	for _, providerCalls := range routingProvider.calls {
+		calls = append(calls, *providerCalls)
+	}
+	sort.Slice(calls, func(first, second int) bool { return calls[first].Provider < calls[second].Provider })
+	return calls
+}
+
+// countCall records the outcome of a call served by a provider
+func (routingProvider *RoutingProvider) countCall(providerName string, failedOver bool, err error) {
+	routingProvider.callsMutex.Lock()
+	defer routingProvider.callsMutex.Unlock()
+
+	providerCalls, exists := routingProvider.calls[providerName]
+	if !exists {
+		providerCalls = &ProviderCalls{Provider: providerName}
+		routingProvider.calls[providerName] = providerCalls
+	}
+	if IsOutageError(err) {
+		providerCalls.Failures++
+		return
+	}
+	providerCalls.Calls++
+	if failedOver {
+		providerCalls.FailoverCalls++
+	}
+}
+
+// circuitBreaker returns the circuit breaker of a provider and its failover provider, or nil when the provider
+// has no failover
+func (routingProvider *RoutingProvider) circuitBreaker(provider Provider) (*circuitBreaker, Provider) {
+	routingProvider.providersMutex.RLock()
+	defer routingProvider.providersMutex.RUnlock()
+
+	breaker, exists := routingProvider.circuitBreakers[provider.Name()]
+	if !exists {
+		return nil, nil
+	}
+	failoverProvider, exists := routingProvider.providers[breaker.failover.Provider]
+	if !exists {
+		return nil, nil
+	}
+	return breaker, failoverProvider
+}
+
+// chatWithFailover sends a request to a provider, or to its failover provider while it is down. A call is
+// only failed over when the provider fails before answering: an outage in the middle of a streamed answer
+// reaches the caller, the partial answer not being replayable, and counts towards opening the circuit
+func (routingProvider *RoutingProvider) chatWithFailover(jobContext context.Context, provider Provider, request *ChatRequest) (<-chan ChatResponseChunk, error) {
+	breaker, failoverProvider := routingProvider.circuitBreaker(provider)
+	if breaker == nil {
+		responseChannel, err := routingProvider.chatForUser(jobContext, provider, request)
+		return routingProvider.countChunks(provider.Name(), false, responseChannel, err)
+	}
+
+	if breaker.allow() {
+		responseChannel, err := routingProvider.chatForUser(jobContext, provider, request)
+		firstChunk, answered := ChatResponseChunk{}, false
+		if err == nil {
+			firstChunk, answered = <-responseChannel
+			if answered {
+				err = firstChunk.Error
+			}
+		}
+
+		if !IsOutageError(err) || jobContext.Err() != nil {
+			if err != nil && !answered {
+				breaker.recordOutcome(jobContext, provider.Name(), err)
+				return routingProvider.countChunks(provider.Name(), false, nil, err)
+			}
+			// An answer failing from its first chunk is reported now, and one streaming once it ends
+			answerChannel := prependChunk(firstChunk, answered, responseChannel)
+			if err != nil || !answered {
+				breaker.recordOutcome(jobContext, provider.Name(), err)
+			} else {
+				answerChannel = breaker.observeStream(jobContext, provider.Name(), answerChannel)
+			}
+			return routingProvider.countChunks(provider.Name(), false, answerChannel, nil)
+		}
+		breaker.recordFailure(provider.Name(), err)
+		routingProvider.countCall(provider.Name(), false, err)
+	}
+
+	failoverRequest := *request
+	failoverRequest.Model = breaker.failover.Model
+	slog.WarnContext(jobContext, "Failing over LLM call", "provider", provider.Name(), "failover_provider", failoverProvider.Name(), "model", failoverRequest.Model)
+	responseChannel, err := routingProvider.chatForUser(jobContext, failoverProvider, &failoverRequest)
+	return routingProvider.countChunks(failoverProvider.Name(), true, responseChannel, err)
+}
+
+// countChunks counts a call towards the provider that served it once its answer ends
+func (routingProvider *RoutingProvider) countChunks(providerName string, failedOver bool, responseChannel <-chan ChatResponseChunk, err error) (<-chan ChatResponseChunk, error) {
+	if err != nil {
+		routingProvider.countCall(providerName, failedOver, err)
+		return nil, err
+	}
+
+	countedChannel := make(chan ChatResponseChunk)
+	go func() {
+		defer close(countedChannel)
+		var chunkError error
+		for chunk := range responseChannel {
+			if chunk.Error != nil {
+				chunkError = chunk.Error
+			}
+			countedChannel <- chunk
+		}
+		routingProvider.countCall(providerName, failedOver, chunkError)
+	}()
+	return countedChannel, nil
+}
+
+// prependChunk returns a channel yielding a chunk already read from a channel, then the rest of it
+func prependChunk(firstChunk ChatResponseChunk, answered bool, responseChannel <-chan ChatResponseChunk) <-chan ChatResponseChunk {
+	if !answered {
+		closedChannel := make(chan ChatResponseChunk)
+		close(closedChannel)
+		return closedChannel
+	}
+	prependedChannel := make(chan ChatResponseChunk)
+	go func() {
+		defer close(prependedChannel)
+		prependedChannel <- firstChunk
+		for chunk := range responseChannel {
+			prependedChannel <- chunk
+		}
+	}()
+	return prependedChannel
+}
+
+// observeStream forwards the answer of a provider and reports how it ended to the circuit breaker, so an outage
+// in the middle of the stream counts like one before it
+func (breaker *circuitBreaker) observeStream(jobContext context.Context, providerName string, responseChannel <-chan ChatResponseChunk) <-chan ChatResponseChunk {
+	observedChannel := make(chan ChatResponseChunk)
+	go func() {
+		defer close(observedChannel)
+		var chunkError error
+		for chunk := range responseChannel {
+			if chunk.Error != nil {
+				chunkError = chunk.Error
+			}
+			observedChannel <- chunk
+		}
+		breaker.recordOutcome(jobContext, providerName, chunkError)
+	}()
+	return observedChannel
+}
+
+// recordOutcome reports a call that reached the provider: an outage error counts as a failure, any other end
+// as a success, and a call cancelled by its caller says nothing about the provider
+func (breaker *circuitBreaker) recordOutcome(jobContext context.Context, providerName string, err error) {
+	switch {
+	case jobContext.Err() != nil:
+		breaker.release()
+	case IsOutageError(err):
+		breaker.recordFailure(providerName, err)
+	default:
+		breaker.recordSuccess(providerName)
+	}
+}
+
+// allow reports whether a call may go to the provider: always while the circuit is closed, and once per open
+// duration as a half-open probe while it is open
+func (breaker *circuitBreaker) allow() bool {
+	breaker.mutex.Lock()
+	defer breaker.mutex.Unlock()
+
+	switch breaker.state {
+	case CircuitClosed:
+		return true
+	case CircuitOpen:
+		if time.Since(breaker.openedAt) < breaker.failover.OpenDuration {
+			return false
+		}
+		breaker.state = CircuitHalfOpen
+		breaker.probing = true
+		return true
+	default:
+		if breaker.probing {
+			return false
+		}
+		breaker.probing = true
+		return true
+	}
+}
+
+// recordSuccess closes the circuit after the provider answered
+func (breaker *circuitBreaker) recordSuccess(providerName string) {
+	breaker.mutex.Lock()
+	defer breaker.mutex.Unlock()
+
+	if breaker.state != CircuitClosed {
+		slog.Info("LLM provider is back, closing its circuit", "provider", providerName, "outage", time.Since(breaker.openedAt))
+	}
+	breaker.state = CircuitClosed
+	breaker.consecutiveFailures = 0
+	breaker.probing = false
+}
+
+// recordFailure opens the circuit once the provider failed FailureThreshold times in a row, or again when a
+// half-open probe failed
+func (breaker *circuitBreaker) recordFailure(providerName string, err error) {
+	breaker.mutex.Lock()
+	defer breaker.mutex.Unlock()
+
+	breaker.consecutiveFailures++
+	breaker.probing = false
+	if breaker.state == CircuitHalfOpen || (breaker.state == CircuitClosed && breaker.consecutiveFailures >= breaker.failover.FailureThreshold) {
+		if breaker.state == CircuitClosed {
+			slog.Error("LLM provider is down, opening its circuit", "provider", providerName, "failover_provider", breaker.failover.Provider, "failures", breaker.consecutiveFailures, "error", err)
+		}
+		breaker.state = CircuitOpen
+		breaker.openedAt = time.Now()
+	}
+}
+
+// release ends a half-open probe that said nothing about the provider, so the next call probes again
+func (breaker *circuitBreaker) release() {
+	breaker.mutex.Lock()
+	defer breaker.mutex.Unlock()
+	breaker.probing = false
+}
+
+// isOpen reports whether calls to the provider currently fail over
+func (breaker *circuitBreaker) isOpen() bool {
+	breaker.mutex.Lock()
+	defer breaker.mutex.Unlock()
+	return breaker.state != CircuitClosed
+}
+
+// IsOutageError reports whether an error means the provider is down rather than the request being wrong:
+// the provider could not be reached, or answered with a server error
+func IsOutageError(err error) bool {
+	if err == nil || errors.Is(err, context.Canceled) {
+		return false
+	}
+	if errors.Is(err, ErrProviderUnavailable) {
+		return true
+	}
+
+	var apiError *openrouter.APIError
+	if errors.As(err, &apiError) {
+		return apiError.HTTPStatusCode >= 500
+	}
+	var requestError *openrouter.RequestError
+	if errors.As(err, &requestError) {
+		return requestError.HTTPStatusCode >= 500
+	}
+	var statusError api.StatusError
+	if errors.As(err, &statusError) {
+		return statusError.StatusCode >= 500
+	}
+
+	var networkError *net.OpError
+	var urlError *url.Error
+	return errors.As(err, &networkError) || errors.As(err, &urlError)
+}
@@ -0,0 +1,175 @@
+package llm
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+)
+
+// scriptedProvider is a fake provider answering with its name, or failing with its error
+type scriptedProvider struct {
+	name   string
+	err    error
+	models []string
+}
+
+func (provider *scriptedProvider) Chat(jobContext context.Context, request *ChatRequest) (<-chan ChatResponseChunk, error) {
+	provider.models = append(provider.models, request.Model)
+	responseChannel := make(chan ChatResponseChunk, 1)
+	if provider.err != nil {
+		responseChannel <- ChatResponseChunk{Error: provider.err}
+	} else {
+		responseChannel <- ChatResponseChunk{Text: provider.name}
+	}
+	close(responseChannel)
+	return responseChannel, nil
+}
+
+func (provider *scriptedProvider) Name() string { return provider.name }
+
+func TestRoutingProvider_FailsOverWhileTheProviderIsDown(tester *testing.T) {
+	primaryProvider := &scriptedProvider{name: "openrouter"}
+	failoverProvider := &scriptedProvider{name: "ollama"}
+	routingProvider := NewRoutingProvider(primaryProvider)
+	routingProvider.Register("ollama", failoverProvider)
+	routingProvider.SetFailover("openrouter", Failover{Provider: "ollama", Model: "llama3", FailureThreshold: 2, OpenDuration: 50 * time.Millisecond})
+
+	chat := func() (string, error) {
+		responseChannel, err := routingProvider.Chat(context.Background(), &ChatRequest{Model: "google/gemini"})
+		if err != nil {
+			return "", err
+		}
+		var text string
+		for chunk := range responseChannel {
+			if chunk.Error != nil {
+				return "", chunk.Error
+			}
+			text += chunk.Text
+		}
+		return text, nil
+	}
+	state := func() string { return routingProvider.FailoverStatuses()[0].State }
+
+	if text, err := chat(); err != nil || text != "openrouter" {
+		tester.Fatalf("Expected the healthy provider to answer, got %q, %v", text, err)
+	}
+
+	primaryProvider.err = errors.New("invalid model")
+	for attempt := 0; attempt < 3; attempt++ {
+		if _, err := chat(); err == nil {
+			tester.Fatalf("Expected a request error to reach the caller")
+		}
+	}
+	if state() != CircuitClosed || len(failoverProvider.models) != 0 {
+		tester.Fatalf("Expected request errors to leave the circuit closed, got %s", state())
+	}
+
+	primaryProvider.err = fmt.Errorf("status 503: %w", ErrProviderUnavailable)
+	if text, err := chat(); err != nil || text != "ollama" || state() != CircuitClosed {
+		tester.Fatalf("Expected the first outage to be served by the failover provider with the circuit closed, got %q, %v, %s", text, err, state())
+	}
+	if text, _ := chat(); text != "ollama" || state() != CircuitOpen {
+		tester.Fatalf("Expected the circuit open after two outages, got %q and %s", text, state())
+	}
+
+	primaryCalls := len(primaryProvider.models)
+	if text, _ := chat(); text != "ollama" || len(primaryProvider.models) != primaryCalls {
+		tester.Fatalf("Expected the open circuit to skip the provider, got %q after %d calls", text, len(primaryProvider.models)-primaryCalls)
+	}
+	if failoverProvider.models[0] != "llama3" {
+		tester.Errorf("Expected the failover model, got %s", failoverProvider.models[0])
+	}
+
+	time.Sleep(60 * time.Millisecond)
+	if text, _ := chat(); text != "ollama" || len(primaryProvider.models) != primaryCalls+1 || state() != CircuitOpen {
+		tester.Fatalf("Expected a failed half-open probe to reopen the circuit, got %q and %s", text, state())
+	}
+
+	time.Sleep(60 * time.Millisecond)
+	primaryProvider.err = nil
+	if text, _ := chat(); text != "openrouter" || state() != CircuitClosed {
+		tester.Fatalf("Expected a successful probe to close the circuit, got %q and %s", text, state())
+	}
+
+	callsByProvider := make(map[string]ProviderCalls)
+	for _, providerCalls := range routingProvider.ProviderCalls() {
+		callsByProvider[providerCalls.Provider] = providerCalls
+	}
+	if calls := callsByProvider["openrouter"]; calls.Calls != 5 || calls.Failures != 3 {
+		tester.Errorf("Expected 5 calls and 3 outages of openrouter, got %+v", calls)
+	}
+	if calls := callsByProvider["ollama"]; calls.Calls != 4 || calls.FailoverCalls != 4 {
+		tester.Errorf("Expected 4 failover calls served by ollama, got %+v", calls)
+	}
+}
+
+func TestRoutingProvider_PreflightsTheFailoverProvider(tester *testing.T) {
+	primaryProvider := &preparableProvider{name: "openrouter", loadedModels: map[string]bool{}, preflightErr: fmt.Errorf("OpenRouter is unavailable (status 502): %w", ErrProviderUnavailable)}
+	failoverProvider := &preparableProvider{name: "ollama", loadedModels: map[string]bool{}}
+	routingProvider := NewRoutingProvider(primaryProvider)
+	routingProvider.Register("ollama", failoverProvider)
+
+	if err := routingProvider.Preflight(context.Background(), "google/gemini"); err == nil {
+		tester.Fatalf("Expected the outage to fail the preflight without a failover")
+	}
+
+	routingProvider.SetFailover("openrouter", Failover{Provider: "ollama", Model: "llama3"})
+	if err := routingProvider.Preflight(context.Background(), "google/gemini"); err != nil {
+		tester.Fatalf("Expected the failover provider to pass the preflight, got %v", err)
+	}
+	if len(failoverProvider.preflighted) != 1 || failoverProvider.preflighted[0] != "llama3" {
+		tester.Errorf("Expected the failover model checked, got %v", failoverProvider.preflighted)
+	}
+
+	primaryProvider.preflightErr = errors.New("OpenRouter rejected the API key")
+	if err := routingProvider.Preflight(context.Background(), "google/gemini"); err == nil {
+		tester.Errorf("Expected a rejected key to fail the preflight rather than fail over")
+	}
+}
+
+// streamingProvider is a fake provider that answers a first chunk, then fails with its error
+type streamingProvider struct {
+	name string
+	err  error
+}
+
+func (provider *streamingProvider) Chat(jobContext context.Context, request *ChatRequest) (<-chan ChatResponseChunk, error) {
+	responseChannel := make(chan ChatResponseChunk, 2)
+	responseChannel <- ChatResponseChunk{Text: provider.name}
+	if provider.err != nil {
+		responseChannel <- ChatResponseChunk{Error: provider.err}
+	}
+	close(responseChannel)
+	return responseChannel, nil
+}
+
+func (provider *streamingProvider) Name() string { return provider.name }
+
+func TestRoutingProvider_MidStreamOutagesOpenTheCircuit(tester *testing.T) {
+	primaryProvider := &streamingProvider{name: "openrouter", err: fmt.Errorf("stream reset: %w", ErrProviderUnavailable)}
+	routingProvider := NewRoutingProvider(primaryProvider)
+	routingProvider.Register("ollama", &scriptedProvider{name: "ollama"})
+	routingProvider.SetFailover("openrouter", Failover{Provider: "ollama", Model: "llama3", FailureThreshold: 2, OpenDuration: time.Minute})
+
+	for attempt := 0; attempt < 2; attempt++ {
+		responseChannel, err := routingProvider.Chat(context.Background(), &ChatRequest{Model: "google/gemini"})
+		if err != nil {
+			tester.Fatalf("Expected the stream to start, got %v", err)
+		}
+		var streamError error
+		for chunk := range responseChannel {
+			if chunk.Error != nil {
+				streamError = chunk.Error
+			}
+		}
+		if !errors.Is(streamError, ErrProviderUnavailable) {
+			tester.Fatalf("Expected the outage in the middle of the stream to reach the caller, got %v", streamError)
+		}
+	}
+
+	if status := routingProvider.FailoverStatuses()[0]; status.State != CircuitOpen || status.ConsecutiveFailures != 2 {
+		tester.Errorf("Expected two outages in the middle of the stream to open the circuit, got %+v", status)
+	}
+}
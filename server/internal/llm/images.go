@@ -0,0 +1,134 @@
+package llm
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const openAIBaseURL = "https://api.openai.com/v1"
+
+// ImageRequest describes a single image to generate
+type ImageRequest struct {
+	Model  string
+	Prompt string
+	Size   string // e.g. "1024x1024"; empty lets the provider choose
+}
+
+// ImageProvider defines the common interface for image-generation services
+type ImageProvider interface {
+	// GenerateImage returns the encoded image bytes (PNG for the supported providers)
+	GenerateImage(context context.Context, request *ImageRequest) ([]byte, error)
+
+	// Name returns the identifier of the provider
+	Name() string
+}
+
+// OpenAIImageProvider generates images through the OpenAI images API or any compatible endpoint
+type OpenAIImageProvider struct {
+	apiKey     string
+	baseURL    string
+	httpClient *http.Client
+}
+
+// NewOpenAIImageProvider creates an image provider; an empty baseURL targets api.openai.com
+func NewOpenAIImageProvider(apiKey string, baseURL string) *OpenAIImageProvider {
+	if baseURL == "" {
+		baseURL = openAIBaseURL
+	}
+	return &OpenAIImageProvider{
+		apiKey:     apiKey,
+		baseURL:    strings.TrimSuffix(baseURL, "/"),
+		httpClient: &http.Client{Timeout: 3 * time.Minute},
+	}
+}
+
+func (provider *OpenAIImageProvider) Name() string {
+	return "openai"
+}
+
+func (provider *OpenAIImageProvider) GenerateImage(jobContext context.Context, request *ImageRequest) ([]byte, error) {
+	requestBody := map[string]any{
+		"model":  request.Model,
+		"prompt": request.Prompt,
+		"n":      1,
+	}
+	if request.Size != "" {
+		requestBody["size"] = request.Size
+	}
+	// gpt-image models always answer with base64 and reject response_format
+	if !strings.HasPrefix(request.Model, "gpt-image") {
+		requestBody["response_format"] = "b64_json"
+	}
+
+	encodedBody, err := json.Marshal(requestBody)
+	if err != nil {
+		return nil, err
+	}
+
+	httpRequest, err := http.NewRequestWithContext(jobContext, http.MethodPost, provider.baseURL+"/images/generations", bytes.NewReader(encodedBody))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create image request: %w", err)
+	}
+	httpRequest.Header.Set("Authorization", "Bearer "+provider.apiKey)
+	httpRequest.Header.Set("Content-Type", "application/json")
+
+	httpResponse, err := provider.httpClient.Do(httpRequest)
+	if err != nil {
+		return nil, fmt.Errorf("image request failed: %w", err)
+	}
+	defer httpResponse.Body.Close()
+
+	responseBody, err := io.ReadAll(httpResponse.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read image response: %w", err)
+	}
+	if httpResponse.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("image provider returned status %d: %s", httpResponse.StatusCode, strings.TrimSpace(string(responseBody)))
+	}
+
+	var parsedResponse struct {
+		Data []struct {
+			Base64JSON string `json:"b64_json"`
+			URL        string `json:"url"`
+		} `json:"data"`
+	}
+	if err := json.Unmarshal(responseBody, &parsedResponse); err != nil {
+		return nil, fmt.Errorf("failed to parse image response: %w", err)
+	}
+	if len(parsedResponse.Data) == 0 {
+		return nil, fmt.Errorf("no image received from provider")
+	}
+
+	image := parsedResponse.Data[0]
+	if image.Base64JSON != "" {
+		return base64.StdEncoding.DecodeString(image.Base64JSON)
+	}
+	if image.URL != "" {
+		return provider.download(jobContext, image.URL)
+	}
+	return nil, fmt.Errorf("image response contains neither data nor URL")
+}
+
+func (provider *OpenAIImageProvider) download(jobContext context.Context, imageURL string) ([]byte, error) {
+	httpRequest, err := http.NewRequestWithContext(jobContext, http.MethodGet, imageURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	httpResponse, err := provider.httpClient.Do(httpRequest)
+	if err != nil {
+		return nil, fmt.Errorf("failed to download generated image: %w", err)
+	}
+	defer httpResponse.Body.Close()
+
+	if httpResponse.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("generated image download returned status %d", httpResponse.StatusCode)
+	}
+	return io.ReadAll(httpResponse.Body)
+}
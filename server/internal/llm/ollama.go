@@ -3,6 +3,8 @@ package llm
 import (
 	"context"
 	"encoding/base64"
+	"errors"
+	"fmt"
 	"log/slog"
 	"net/http"
 	"net/url"
@@ -12,7 +14,8 @@ import (
 )
 
 type OllamaProvider struct {
-	client *api.Client
+	client  *api.Client
+	baseURL string
 }
 
 func NewOllamaProvider(baseURL string) *OllamaProvider {
@@ -27,7 +30,8 @@ func NewOllamaProvider(baseURL string) *OllamaProvider {
 	}
 
 	return &OllamaProvider{
-		client: api.NewClient(parsedURL, http.DefaultClient),
+		client:  api.NewClient(parsedURL, http.DefaultClient),
+		baseURL: parsedURL.String(),
 	}
 }
 
@@ -35,6 +39,62 @@ func (provider *OllamaProvider) Name() string {
 	return "ollama"
 }
 
+// Preflight verifies that the Ollama server is reachable and the model has been pulled
+func (provider *OllamaProvider) Preflight(jobContext context.Context, model string) error {
+	model = strings.TrimPrefix(model, "ollama:")
+	if heartbeatError := provider.client.Heartbeat(jobContext); heartbeatError != nil {
+		return fmt.Errorf("ollama is not reachable at %s (is `ollama serve` running?): %w", provider.baseURL, heartbeatError)
+	}
+
+	if _, showError := provider.client.Show(jobContext, &api.ShowRequest{Model: model}); showError != nil {
+		var statusError api.StatusError
+		if errors.As(showError, &statusError) && statusError.StatusCode == http.StatusNotFound {
+			return fmt.Errorf("ollama model %s is not installed (run `ollama pull %s`)", model, model)
+		}
+		return fmt.Errorf("failed to inspect ollama model %s: %w", model, showError)
+	}
+	return nil
+}
+
+// ContextWindow returns the context length stored in the model's metadata (e.g. "llama.context_length")
+func (provider *OllamaProvider) ContextWindow(jobContext context.Context, model string) (int, error) {
+	model = strings.TrimPrefix(model, "ollama:")
+	showResponse, showError := provider.client.Show(jobContext, &api.ShowRequest{Model: model})
+	if showError != nil {
+		return 0, fmt.Errorf("failed to inspect ollama model %s: %w", model, showError)
+	}
+	for key, value := range showResponse.ModelInfo {
+		if !strings.HasSuffix(key, ".context_length") {
+			continue
+		}
+		if contextLength, isNumber := value.(float64); isNumber {
+			return int(contextLength), nil
+		}
+	}
+	return 0, nil
+}
+
+// NeedsWarmup reports whether the model is not currently loaded in Ollama's memory
+func (provider *OllamaProvider) NeedsWarmup(jobContext context.Context, model string) bool {
+	model = strings.TrimPrefix(model, "ollama:")
+	runningModels, listError := provider.client.ListRunning(jobContext)
+	if listError != nil {
+		return true
+	}
+	for _, runningModel := range runningModels.Models {
+		if runningModel.Name == model || runningModel.Model == model {
+			return false
+		}
+	}
+	return true
+}
+
+// Warmup loads the model into memory; a generate request without a prompt only loads the model
+func (provider *OllamaProvider) Warmup(jobContext context.Context, model string) error {
+	model = strings.TrimPrefix(model, "ollama:")
+	return provider.client.Generate(jobContext, &api.GenerateRequest{Model: model}, func(api.GenerateResponse) error { return nil })
+}
+
 func (provider *OllamaProvider) Chat(jobContext context.Context, request *ChatRequest) (<-chan ChatResponseChunk, error) {
 	// Safety check: ensure "ollama:" prefix is stripped
 	modelName := strings.TrimPrefix(request.Model, "ollama:")
@@ -84,6 +144,15 @@ func (provider *OllamaProvider) Chat(jobContext context.Context, request *ChatRe
 	if request.MaxTokens > 0 {
 		ollamaRequest.Options["num_predict"] = request.MaxTokens
 	}
+	if request.Temperature != nil {
+		ollamaRequest.Options["temperature"] = *request.Temperature
+	}
+	if request.TopP != nil {
+		ollamaRequest.Options["top_p"] = *request.TopP
+	}
+	if request.Seed != nil {
+		ollamaRequest.Options["seed"] = *request.Seed
+	}
 
 	go func() {
 		defer close(responseChannel)
@@ -3,21 +3,39 @@ package llm
 import (
 	"context"
 	"errors"
+	"fmt"
 	"io"
+	"net/http"
 	"strings"
 	"sync"
+	"time"
 
 	openrouter "github.com/revrost/go-openrouter"
 )
 
+// openRouterKeyURL reports the status of an API key, used as a cheap authenticated connectivity check
+const openRouterKeyURL = "https://openrouter.ai/api/v1/key"
+
 type OpenRouterProvider struct {
-	client      *openrouter.Client
-	clientMutex sync.RWMutex
+	client                *openrouter.Client
+	apiKey                string
+	keyURL                string
+	clientMutex           sync.RWMutex
+	contextWindows        map[string]int // Context length of every listed model, loaded on first use
+	contextWindowsError   error          // Failure of the last listing, returned until contextWindowsRetryAt
+	contextWindowsRetryAt time.Time
+	contextWindowsMutex   sync.Mutex
 }
 
+// contextWindowsRetryInterval is how long a failed model listing is remembered before it is tried again, so a
+// listing endpoint that is down does not add a request to every call
+const contextWindowsRetryInterval = 5 * time.Minute
+
 func NewOpenRouterProvider(apiKey string) *OpenRouterProvider {
 	return &OpenRouterProvider{
 		client: openrouter.NewClient(apiKey),
+		apiKey: apiKey,
+		keyURL: openRouterKeyURL,
 	}
 }
 
@@ -25,6 +43,77 @@ func (provider *OpenRouterProvider) SetAPIKey(apiKey string) {
 	provider.clientMutex.Lock()
 	defer provider.clientMutex.Unlock()
 	provider.client = openrouter.NewClient(apiKey)
+	provider.apiKey = apiKey
+
+	// A listing refused with the previous key may succeed with this one
+	provider.contextWindowsMutex.Lock()
+	provider.contextWindowsError = nil
+	provider.contextWindowsMutex.Unlock()
+}
+
+// Preflight verifies that an API key is configured and accepted by OpenRouter
+func (provider *OpenRouterProvider) Preflight(jobContext context.Context, model string) error {
+	provider.clientMutex.RLock()
+	apiKey := provider.apiKey
+	provider.clientMutex.RUnlock()
+
+	if apiKey == "" {
+		return fmt.Errorf("OpenRouter API key is not configured (providers.openrouter.api_key)")
+	}
+
+	httpRequest, requestError := http.NewRequestWithContext(jobContext, http.MethodGet, provider.keyURL, nil)
+	if requestError != nil {
+		return requestError
+	}
+	httpRequest.Header.Set("Authorization", "Bearer "+apiKey)
+
+	httpResponse, responseError := http.DefaultClient.Do(httpRequest)
+	if responseError != nil {
+		return fmt.Errorf("OpenRouter is not reachable: %w", responseError)
+	}
+	defer httpResponse.Body.Close()
+
+	switch {
+	case httpResponse.StatusCode == http.StatusUnauthorized || httpResponse.StatusCode == http.StatusForbidden:
+		return fmt.Errorf("OpenRouter rejected the API key (check providers.openrouter.api_key)")
+	case httpResponse.StatusCode == http.StatusPaymentRequired:
+		return fmt.Errorf("OpenRouter account has insufficient credits")
+	case httpResponse.StatusCode >= 500:
+		return fmt.Errorf("OpenRouter is unavailable (status %d): %w", httpResponse.StatusCode, ErrProviderUnavailable)
+	}
+	return nil
+}
+
+// ContextWindow returns the context length OpenRouter lists for the model. The model list is fetched once; a
+// failed listing is returned as is for contextWindowsRetryInterval before it is tried again
+func (provider *OpenRouterProvider) ContextWindow(jobContext context.Context, model string) (int, error) {
+	provider.contextWindowsMutex.Lock()
+	defer provider.contextWindowsMutex.Unlock()
+
+	if provider.contextWindows == nil {
+		if provider.contextWindowsError != nil && time.Now().Before(provider.contextWindowsRetryAt) {
+			return 0, provider.contextWindowsError
+		}
+
+		provider.clientMutex.RLock()
+		client := provider.client
+		provider.clientMutex.RUnlock()
+
+		listedModels, err := client.ListModels(jobContext)
+		if err != nil {
+			provider.contextWindowsError = fmt.Errorf("failed to list OpenRouter models: %w", err)
+			provider.contextWindowsRetryAt = time.Now().Add(contextWindowsRetryInterval)
+			return 0, provider.contextWindowsError
+		}
+		provider.contextWindowsError = nil
+		provider.contextWindows = make(map[string]int, len(listedModels))
+		for _, listedModel := range listedModels {
+			if listedModel.ContextLength != nil {
+				provider.contextWindows[listedModel.ID] = int(*listedModel.ContextLength)
+			}
+		}
+	}
+	return provider.contextWindows[strings.TrimPrefix(model, "openrouter:")], nil
 }
 
 func (provider *OpenRouterProvider) Name() string {
@@ -91,13 +180,7 @@ func (provider *OpenRouterProvider) Chat(jobContext context.Context, request *Ch
 		defer close(responseChannel)
 
 		if request.Stream {
-			completionStream, streamError := client.CreateChatCompletionStream(jobContext, openrouter.ChatCompletionRequest{
-				Model:     request.Model,
-				Messages:  chatMessages,
-				Stream:    true,
-				SessionId: request.SessionID,
-				MaxTokens: request.MaxTokens,
-			})
+			completionStream, streamError := client.CreateChatCompletionStream(jobContext, openRouterRequest(request, chatMessages))
 			if streamError != nil {
 				responseChannel <- ChatResponseChunk{Error: streamError}
 				return
@@ -127,12 +210,7 @@ func (provider *OpenRouterProvider) Chat(jobContext context.Context, request *Ch
 				}
 			}
 		} else {
-			chatResponse, chatError := client.CreateChatCompletion(jobContext, openrouter.ChatCompletionRequest{
-				Model:     request.Model,
-				Messages:  chatMessages,
-				SessionId: request.SessionID,
-				MaxTokens: request.MaxTokens,
-			})
+			chatResponse, chatError := client.CreateChatCompletion(jobContext, openRouterRequest(request, chatMessages))
 			if chatError != nil {
 				responseChannel <- ChatResponseChunk{Error: chatError}
 				return
@@ -151,3 +229,26 @@ func (provider *OpenRouterProvider) Chat(jobContext context.Context, request *Ch
 
 	return responseChannel, nil
 }
+
+// minimumOpenRouterTemperature stands for a temperature of 0, which the client would leave out of the request
+// and so let the model use its default
+const minimumOpenRouterTemperature = 0.0001
+
+// openRouterRequest converts a chat request for the OpenRouter client
+func openRouterRequest(request *ChatRequest, chatMessages []openrouter.ChatCompletionMessage) openrouter.ChatCompletionRequest {
+	completionRequest := openrouter.ChatCompletionRequest{
+		Model:     request.Model,
+		Messages:  chatMessages,
+		Stream:    request.Stream,
+		SessionId: request.SessionID,
+		MaxTokens: request.MaxTokens,
+		Seed:      request.Seed,
+	}
+	if request.Temperature != nil {
+		completionRequest.Temperature = float32(max(*request.Temperature, minimumOpenRouterTemperature))
+	}
+	if request.TopP != nil {
+		completionRequest.TopP = float32(*request.TopP)
+	}
+	return completionRequest
+}
@@ -0,0 +1,144 @@
+package llm
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+)
+
+// preflightTimeout bounds connectivity checks so an unreachable provider fails fast instead of hanging the job
+const preflightTimeout = 15 * time.Second
+
+// Preflighter is implemented by providers that can verify connectivity and model availability before work starts
+type Preflighter interface {
+	// Preflight returns an actionable error when the model cannot be used right now
+	Preflight(context context.Context, model string) error
+}
+
+// Warmer is implemented by providers whose models must be loaded before they answer quickly (e.g. local runtimes)
+type Warmer interface {
+	// NeedsWarmup reports whether the model still has to be loaded
+	NeedsWarmup(context context.Context, model string) bool
+
+	// Warmup loads the model and returns once it is ready to answer
+	Warmup(context context.Context, model string) error
+}
+
+// PrepareModel runs the provider's preflight check for a model and loads it if needed, calling onLoading
+// right before a (potentially slow) warmup starts. Providers without these hooks are left untouched
+func PrepareModel(jobContext context.Context, provider Provider, model string, onLoading func(model string)) error {
+	if provider == nil || model == "" {
+		return nil
+	}
+
+	if preflighter, supportsPreflight := provider.(Preflighter); supportsPreflight {
+		preflightContext, cancel := context.WithTimeout(jobContext, preflightTimeout)
+		preflightError := preflighter.Preflight(preflightContext, model)
+		cancel()
+		if preflightError != nil {
+			if jobContext.Err() != nil {
+				return jobContext.Err()
+			}
+			return fmt.Errorf("preflight check failed for model %s: %w", model, preflightError)
+		}
+	}
+
+	if warmer, supportsWarmup := provider.(Warmer); supportsWarmup && warmer.NeedsWarmup(jobContext, model) {
+		if onLoading != nil {
+			onLoading(model)
+		}
+		if warmupError := warmer.Warmup(jobContext, model); warmupError != nil {
+			if jobContext.Err() != nil {
+				return jobContext.Err()
+			}
+			return fmt.Errorf("failed to load model %s: %w", model, warmupError)
+		}
+	}
+
+	return nil
+}
+
+// Preflight checks the provider the model is routed to, with the API key of the user of the call when they
+// stored one. While the provider is down, its failover provider is checked instead
+func (routingProvider *RoutingProvider) Preflight(jobContext context.Context, model string) error {
+	provider, modelName := routingProvider.resolve(model)
+	if provider == nil {
+		return fmt.Errorf("no LLM provider found for: %s", model)
+	}
+
+	breaker, failoverProvider := routingProvider.circuitBreaker(provider)
+	if breaker != nil && breaker.isOpen() {
+		return routingProvider.preflightForUser(jobContext, failoverProvider, breaker.failover.Model)
+	}
+	err := routingProvider.preflightForUser(jobContext, provider, modelName)
+	if breaker != nil && IsOutageError(err) {
+		if failoverError := routingProvider.preflightForUser(jobContext, failoverProvider, breaker.failover.Model); failoverError == nil {
+			slog.Warn("LLM provider failed its preflight check, its failover provider is ready", "provider", provider.Name(), "failover_provider", failoverProvider.Name(), "error", err)
+			return nil
+		}
+	}
+	return err
+}
+
+// preflightForUser runs the preflight check of a provider with the API key of the user of the call
+func (routingProvider *RoutingProvider) preflightForUser(jobContext context.Context, provider Provider, model string) error {
+	provider, err := routingProvider.forUser(jobContext, provider)
+	if err != nil {
+		return err
+	}
+	if preflighter, supportsPreflight := provider.(Preflighter); supportsPreflight {
+		return preflighter.Preflight(jobContext, model)
+	}
+	return nil
+}
+
+// NeedsWarmup reports whether the provider the model is routed to still has to load it
+func (routingProvider *RoutingProvider) NeedsWarmup(jobContext context.Context, model string) bool {
+	provider, modelName := routingProvider.resolveServing(model)
+	warmer, supportsWarmup := provider.(Warmer)
+	return supportsWarmup && warmer.NeedsWarmup(jobContext, modelName)
+}
+
+// Warmup loads the model on the provider it is routed to
+func (routingProvider *RoutingProvider) Warmup(jobContext context.Context, model string) error {
+	provider, modelName := routingProvider.resolveServing(model)
+	if warmer, supportsWarmup := provider.(Warmer); supportsWarmup {
+		return warmer.Warmup(jobContext, modelName)
+	}
+	return nil
+}
+
+// resolve returns the provider a model name is routed to and the model name without its provider prefix,
+// mirroring the routing rules of Chat
+func (routingProvider *RoutingProvider) resolve(model string) (Provider, string) {
+	if strings.Contains(model, ":") {
+		parts := strings.SplitN(model, ":", 2)
+
+		routingProvider.providersMutex.RLock()
+		provider, exists := routingProvider.providers[parts[0]]
+		routingProvider.providersMutex.RUnlock()
+
+		if exists {
+			return provider, parts[1]
+		}
+		if routingProvider.defaultProvider != nil && routingProvider.defaultProvider.Name() == parts[0] {
+			return routingProvider.defaultProvider, parts[1]
+		}
+	}
+	return routingProvider.defaultProvider, model
+}
+
+// resolveServing returns the provider and model that serve the calls for a model: the ones it is routed to, or
+// the failover provider and model while that provider is down
+func (routingProvider *RoutingProvider) resolveServing(model string) (Provider, string) {
+	provider, modelName := routingProvider.resolve(model)
+	if provider == nil {
+		return nil, modelName
+	}
+	if breaker, failoverProvider := routingProvider.circuitBreaker(provider); breaker != nil && breaker.isOpen() {
+		return failoverProvider, breaker.failover.Model
+	}
+	return provider, modelName
+}
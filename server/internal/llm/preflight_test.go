@@ -0,0 +1,162 @@
+package llm
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// preparableProvider is a fake local runtime that records preflight checks and model loads
+type preparableProvider struct {
+	name          string
+	preflightErr  error
+	loadedModels  map[string]bool
+	preflighted   []string
+	warmedUpCount int
+}
+
+func (provider *preparableProvider) Chat(jobContext context.Context, request *ChatRequest) (<-chan ChatResponseChunk, error) {
+	return nil, errors.New("not implemented")
+}
+
+func (provider *preparableProvider) Name() string { return provider.name }
+
+func (provider *preparableProvider) Preflight(jobContext context.Context, model string) error {
+	provider.preflighted = append(provider.preflighted, model)
+	return provider.preflightErr
+}
+
+func (provider *preparableProvider) NeedsWarmup(jobContext context.Context, model string) bool {
+	return !provider.loadedModels[model]
+}
+
+func (provider *preparableProvider) Warmup(jobContext context.Context, model string) error {
+	provider.warmedUpCount++
+	provider.loadedModels[model] = true
+	return nil
+}
+
+func TestPrepareModel_WarmsUpColdModelsOnce(tester *testing.T) {
+	provider := &preparableProvider{name: "local", loadedModels: map[string]bool{}}
+
+	var loadingReports []string
+	onLoading := func(model string) { loadingReports = append(loadingReports, model) }
+
+	for attempt := 0; attempt < 2; attempt++ {
+		if err := PrepareModel(context.Background(), provider, "gemma3:1b", onLoading); err != nil {
+			tester.Fatalf("PrepareModel failed: %v", err)
+		}
+	}
+
+	if provider.warmedUpCount != 1 || len(loadingReports) != 1 {
+		tester.Errorf("Expected a single warmup and loading report, got %d warmups and %v", provider.warmedUpCount, loadingReports)
+	}
+	if len(provider.preflighted) != 2 {
+		tester.Errorf("Expected a preflight check on every preparation, got %d", len(provider.preflighted))
+	}
+}
+
+func TestPrepareModel_PreflightFailureStopsWarmup(tester *testing.T) {
+	provider := &preparableProvider{name: "local", loadedModels: map[string]bool{}, preflightErr: errors.New("model is not installed")}
+
+	err := PrepareModel(context.Background(), provider, "missing-model", nil)
+	if err == nil || !strings.Contains(err.Error(), "missing-model") || !strings.Contains(err.Error(), "not installed") {
+		tester.Fatalf("Expected an actionable preflight error, got %v", err)
+	}
+	if provider.warmedUpCount != 0 {
+		tester.Error("Expected no warmup after a failed preflight")
+	}
+}
+
+func TestRoutingProvider_PreparesRoutedProvider(tester *testing.T) {
+	defaultProvider := &preparableProvider{name: "openrouter", loadedModels: map[string]bool{}}
+	localProvider := &preparableProvider{name: "ollama", loadedModels: map[string]bool{}}
+
+	routingProvider := NewRoutingProvider(defaultProvider)
+	routingProvider.Register("ollama", localProvider)
+
+	if err := PrepareModel(context.Background(), routingProvider, "ollama:llama3", nil); err != nil {
+		tester.Fatalf("PrepareModel failed: %v", err)
+	}
+	if len(localProvider.preflighted) != 1 || localProvider.preflighted[0] != "llama3" || localProvider.warmedUpCount != 1 {
+		tester.Errorf("Expected the prefixed model to be prepared on the ollama provider without prefix, got %v", localProvider.preflighted)
+	}
+	if len(defaultProvider.preflighted) != 0 {
+		tester.Errorf("Expected the default provider to be left alone, got %v", defaultProvider.preflighted)
+	}
+}
+
+func TestOpenRouterProvider_Preflight(tester *testing.T) {
+	mockServer := httptest.NewServer(http.HandlerFunc(func(responseWriter http.ResponseWriter, request *http.Request) {
+		if request.Header.Get("Authorization") != "Bearer valid-key" {
+			responseWriter.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		responseWriter.Write([]byte(`{"data": {}}`))
+	}))
+	defer mockServer.Close()
+
+	provider := NewOpenRouterProvider("")
+	provider.keyURL = mockServer.URL
+	if err := provider.Preflight(context.Background(), "any"); err == nil || !strings.Contains(err.Error(), "not configured") {
+		tester.Errorf("Expected a missing key error, got %v", err)
+	}
+
+	provider.SetAPIKey("wrong-key")
+	if err := provider.Preflight(context.Background(), "any"); err == nil || !strings.Contains(err.Error(), "rejected") {
+		tester.Errorf("Expected a rejected key error, got %v", err)
+	}
+
+	provider.SetAPIKey("valid-key")
+	if err := provider.Preflight(context.Background(), "any"); err != nil {
+		tester.Errorf("Expected preflight to pass, got %v", err)
+	}
+}
+
+func TestOllamaProvider_PreflightAndWarmup(tester *testing.T) {
+	var generateCalls int
+	mockServer := httptest.NewServer(http.HandlerFunc(func(responseWriter http.ResponseWriter, request *http.Request) {
+		switch request.URL.Path {
+		case "/":
+			responseWriter.Write([]byte("Ollama is running"))
+		case "/api/show":
+			var body strings.Builder
+			buffer := make([]byte, 512)
+			count, _ := request.Body.Read(buffer)
+			body.Write(buffer[:count])
+			if !strings.Contains(body.String(), `"installed-model"`) {
+				responseWriter.WriteHeader(http.StatusNotFound)
+				responseWriter.Write([]byte(`{"error": "model not found"}`))
+				return
+			}
+			responseWriter.Write([]byte(`{"modelfile": ""}`))
+		case "/api/ps":
+			responseWriter.Write([]byte(`{"models": []}`))
+		case "/api/generate":
+			generateCalls++
+			responseWriter.Write([]byte(`{"model": "installed-model", "done": true}` + "\n"))
+		default:
+			responseWriter.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer mockServer.Close()
+
+	provider := NewOllamaProvider(mockServer.URL)
+
+	err := provider.Preflight(context.Background(), "missing-model")
+	if err == nil || !strings.Contains(err.Error(), "ollama pull missing-model") {
+		tester.Errorf("Expected a pull hint for a missing model, got %v", err)
+	}
+
+	var loadingReports []string
+	err = PrepareModel(context.Background(), provider, "installed-model", func(model string) { loadingReports = append(loadingReports, model) })
+	if err != nil {
+		tester.Fatalf("PrepareModel failed: %v", err)
+	}
+	if generateCalls != 1 || len(loadingReports) != 1 {
+		tester.Errorf("Expected the cold model to be loaded once, got %d generate calls and %v", generateCalls, loadingReports)
+	}
+}
@@ -6,6 +6,8 @@ import (
 	"log/slog"
 	"strings"
 	"sync"
+
+	"lectures/internal/models"
 )
 
 // ContentPart represents a part of a message (text, image, or audio)
@@ -36,6 +38,27 @@ type ChatRequest struct {
 	Stream    bool      `json:"stream"`
 	SessionID string    `json:"session_id,omitempty"`
 	MaxTokens int       `json:"max_tokens,omitempty"`
+
+	// Sampling parameters, the model's defaults when unset. Providers ignore those their models do not support
+	Temperature *float64 `json:"temperature,omitempty"`
+	TopP        *float64 `json:"top_p,omitempty"`
+	Seed        *int     `json:"seed,omitempty"`
+}
+
+// ApplySampling sets the sampling parameters of the request, keeping those the parameters leave unset
+func (request *ChatRequest) ApplySampling(sampling models.SamplingParameters) {
+	if sampling.Temperature != nil {
+		request.Temperature = sampling.Temperature
+	}
+	if sampling.TopP != nil {
+		request.TopP = sampling.TopP
+	}
+	if sampling.Seed != nil {
+		request.Seed = sampling.Seed
+	}
+	if sampling.MaxTokens > 0 {
+		request.MaxTokens = sampling.MaxTokens
+	}
 }
 
 // ChatResponseChunk represents a chunk of the streamed response
@@ -61,12 +84,20 @@ type RoutingProvider struct {
 	providers       map[string]Provider
 	defaultProvider Provider
 	providersMutex  sync.RWMutex
+	apiKeyResolver  APIKeyResolver             // Looks up the API keys users stored, see SetAPIKeyResolver
+	userProviders   map[string]Provider        // Providers using the API key of a user, keyed by provider name and key
+	circuitBreakers map[string]*circuitBreaker // Outages of the providers with a failover, see SetFailover
+	calls           map[string]*ProviderCalls  // Calls served by each provider
+	callsMutex      sync.Mutex
 }
 
 func NewRoutingProvider(defaultProvider Provider) *RoutingProvider {
 	return &RoutingProvider{
 		providers:       make(map[string]Provider),
 		defaultProvider: defaultProvider,
+		userProviders:   make(map[string]Provider),
+		circuitBreakers: make(map[string]*circuitBreaker),
+		calls:           make(map[string]*ProviderCalls),
 	}
 }
 
@@ -113,24 +144,34 @@ func (routingProvider *RoutingProvider) Chat(jobContext context.Context, request
 		routingProvider.providersMutex.RUnlock()
 
 		if exists {
-			return provider.Chat(jobContext, request)
+			return routingProvider.chatWithFailover(jobContext, provider, request)
 		}
 
 		// If prefix matched "openrouter" or "ollama" but wasn't in the map,
 		// fall back to default if it matches the name
 		if routingProvider.defaultProvider != nil && routingProvider.defaultProvider.Name() == providerName {
-			return routingProvider.defaultProvider.Chat(jobContext, request)
+			return routingProvider.chatWithFailover(jobContext, routingProvider.defaultProvider, request)
 		}
 	}
 
 	// Fallback to default provider
 	if routingProvider.defaultProvider != nil {
 		slog.Debug("Routing LLM request to default provider", "provider", routingProvider.defaultProvider.Name(), "model", request.Model)
-		return routingProvider.defaultProvider.Chat(jobContext, request)
+		return routingProvider.chatWithFailover(jobContext, routingProvider.defaultProvider, request)
 	}
 
 	return nil, fmt.Errorf("no LLM provider found for: %s", originalModelName)
 }
+
+// chatForUser sends a request to a provider with the API key of the user of the call when they stored one
+func (routingProvider *RoutingProvider) chatForUser(jobContext context.Context, provider Provider, request *ChatRequest) (<-chan ChatResponseChunk, error) {
+	userProvider, err := routingProvider.forUser(jobContext, provider)
+	if err != nil {
+		return nil, err
+	}
+	return userProvider.Chat(jobContext, request)
+}
+
 func (routingProvider *RoutingProvider) Name() string {
 	return "routing-provider"
 }
@@ -0,0 +1,79 @@
+package llm
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"lectures/internal/models"
+)
+
+func TestChatRequest_ApplySampling(tester *testing.T) {
+	temperature, topP, seed := 0.0, 0.9, 42
+	request := &ChatRequest{Model: "model", MaxTokens: 16384}
+	request.ApplySampling(models.SamplingParameters{Temperature: &temperature, Seed: &seed})
+	if request.Temperature == nil || *request.Temperature != 0 || request.Seed == nil || *request.Seed != 42 {
+		tester.Errorf("Expected temperature 0 and seed 42, got %v and %v", request.Temperature, request.Seed)
+	}
+	if request.TopP != nil || request.MaxTokens != 16384 {
+		tester.Errorf("Expected unset parameters to keep the request's, got top_p %v and max_tokens %d", request.TopP, request.MaxTokens)
+	}
+
+	request.ApplySampling(models.SamplingParameters{TopP: &topP, MaxTokens: 512})
+	if request.TopP == nil || *request.TopP != 0.9 || request.MaxTokens != 512 || *request.Seed != 42 {
+		tester.Errorf("Expected top_p and max_tokens to be overridden and the seed kept, got %+v", request)
+	}
+}
+
+func TestOpenRouterRequest_KeepsATemperatureOfZero(tester *testing.T) {
+	temperature, seed := 0.0, 7
+	completionRequest := openRouterRequest(&ChatRequest{Model: "model", Temperature: &temperature, Seed: &seed}, nil)
+	if completionRequest.Temperature <= 0 {
+		tester.Errorf("Expected a temperature of 0 to be sent, got %v which the client leaves out", completionRequest.Temperature)
+	}
+	if completionRequest.Seed == nil || *completionRequest.Seed != 7 {
+		tester.Errorf("Expected seed 7, got %v", completionRequest.Seed)
+	}
+
+	completionRequest = openRouterRequest(&ChatRequest{Model: "model"}, nil)
+	if completionRequest.Temperature != 0 || completionRequest.TopP != 0 || completionRequest.Seed != nil {
+		tester.Errorf("Expected unset parameters to be left out, got %+v", completionRequest)
+	}
+}
+
+func TestOllamaProvider_SendsSamplingOptions(tester *testing.T) {
+	var options map[string]any
+	mockServer := httptest.NewServer(http.HandlerFunc(func(responseWriter http.ResponseWriter, request *http.Request) {
+		var body struct {
+			Options map[string]any `json:"options"`
+		}
+		json.NewDecoder(request.Body).Decode(&body)
+		options = body.Options
+		responseWriter.Write([]byte(`{"model": "model", "message": {"role": "assistant", "content": "Hi"}, "done": true}` + "\n"))
+	}))
+	defer mockServer.Close()
+
+	temperature, topP, seed := 0.0, 0.5, 3
+	responseChannel, err := NewOllamaProvider(mockServer.URL).Chat(context.Background(), &ChatRequest{
+		Model:       "model",
+		Messages:    []Message{{Role: "user", Content: []ContentPart{{Type: "text", Text: "Hello"}}}},
+		MaxTokens:   100,
+		Temperature: &temperature,
+		TopP:        &topP,
+		Seed:        &seed,
+	})
+	if err != nil {
+		tester.Fatalf("Chat failed: %v", err)
+	}
+	for chunk := range responseChannel {
+		if chunk.Error != nil {
+			tester.Fatalf("Chat failed: %v", chunk.Error)
+		}
+	}
+
+	if options["temperature"] != 0.0 || options["top_p"] != 0.5 || options["seed"] != 3.0 || options["num_predict"] != 100.0 {
+		tester.Errorf("Expected the sampling options to be sent, got %v", options)
+	}
+}
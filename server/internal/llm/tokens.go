@@ -0,0 +1,139 @@
+package llm
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Token counts are estimated, not computed: there is no tokenizer here. Text is split with the pre-tokenization
+// rules of tiktoken's cl100k_base encoding (words, numbers of up to three digits, punctuation runs and whitespace)
+// and every piece is priced like its typical BPE merge. Without the merge table, and for models using other
+// vocabularies, the count is a heuristic that errs high on rare words and non-Latin scripts, which is the safe
+// side for a context-window guard
+var pretokenizationPattern = regexp.MustCompile(`(?i:'s|'t|'re|'ve|'m|'ll|'d)|[^\r\n\p{L}\p{N}]?\p{L}+|\p{N}{1,3}| ?[^\s\p{L}\p{N}]+[\r\n]*|\s*[\r\n]+|\s+`)
+
+const (
+	// messageOverheadTokens covers the role and separators the chat format adds to every message
+	messageOverheadTokens = 4
+	// replyPrimingTokens covers the assistant header every request ends with
+	replyPrimingTokens = 3
+	// imageTokens is the typical price of an attached page image at high detail
+	imageTokens = 765
+	// contextSafetyMargin keeps this share of the context window free to absorb estimation error
+	contextSafetyMargin = 0.05
+)
+
+// EstimateTokens is a heuristic estimate of the number of tokens text encodes to, not an exact count
+func EstimateTokens(text string) int {
+	tokens := 0
+	for _, piece := range pretokenizationPattern.FindAllString(text, -1) {
+		tokens += estimatePieceTokens(piece)
+	}
+	return tokens
+}
+
+func estimatePieceTokens(piece string) int {
+	runeCount := utf8.RuneCountInString(piece)
+	trimmed := strings.TrimLeftFunc(piece, func(character rune) bool {
+		return !unicode.IsLetter(character) && !unicode.IsNumber(character)
+	})
+	if trimmed == "" {
+		// Whitespace and punctuation runs usually merge into one token per couple of characters
+		if strings.TrimSpace(piece) == "" {
+			return 1
+		}
+		return (runeCount + 1) / 2
+	}
+
+	firstRune, _ := utf8.DecodeRuneInString(trimmed)
+	if unicode.IsNumber(firstRune) {
+		return 1
+	}
+	if firstRune > unicode.MaxLatin1 && !unicode.Is(unicode.Latin, firstRune) {
+		// Ideographs, Cyrillic, Greek and other scripts have few multi-character merges
+		return utf8.RuneCountInString(trimmed)
+	}
+	letterCount := utf8.RuneCountInString(trimmed)
+	if letterCount <= 6 {
+		return 1
+	}
+	return (letterCount + 3) / 4
+}
+
+// EstimateRequestTokens predicts the prompt size of a request. Audio parts are not counted because providers
+// price them by duration rather than by content
+func EstimateRequestTokens(request *ChatRequest) int {
+	tokens := replyPrimingTokens
+	for _, message := range request.Messages {
+		tokens += messageOverheadTokens
+		for _, part := range message.Content {
+			switch part.Type {
+			case "text":
+				tokens += EstimateTokens(part.Text)
+			case "image":
+				tokens += imageTokens
+			}
+		}
+	}
+	return tokens
+}
+
+// ContextWindower is implemented by providers that can report the context window of a model
+type ContextWindower interface {
+	// ContextWindow returns the number of tokens the model accepts, or 0 when it is unknown
+	ContextWindow(context context.Context, model string) (int, error)
+}
+
+// ContextWindow returns the context window reported by the provider the model is routed to, or by its failover
+// provider while it is down
+func (routingProvider *RoutingProvider) ContextWindow(jobContext context.Context, model string) (int, error) {
+	provider, modelName := routingProvider.resolveServing(model)
+	if windower, supportsContextWindow := provider.(ContextWindower); supportsContextWindow {
+		return windower.ContextWindow(jobContext, modelName)
+	}
+	return 0, nil
+}
+
+// ContextOverflowError reports a request that does not fit the context window of its model
+type ContextOverflowError struct {
+	Model           string
+	EstimatedTokens int
+	ContextWindow   int
+}
+
+func (overflowError *ContextOverflowError) Error() string {
+	return fmt.Sprintf("prompt of about %d tokens does not fit the %d-token context window of %s", overflowError.EstimatedTokens, overflowError.ContextWindow, overflowError.Model)
+}
+
+// GuardRequest estimates the prompt size of a request and, when the provider reports the context window of the
+// model, checks that it fits, leaving room for the reply. Prompts are never shortened behind the caller's back:
+// a *ContextOverflowError is returned when the request does not fit. It returns the estimated input tokens
+func GuardRequest(jobContext context.Context, provider Provider, request *ChatRequest) (int, error) {
+	estimatedTokens := EstimateRequestTokens(request)
+
+	windower, supportsContextWindow := provider.(ContextWindower)
+	if !supportsContextWindow {
+		return estimatedTokens, nil
+	}
+	contextWindow, err := windower.ContextWindow(jobContext, request.Model)
+	if err != nil || contextWindow <= 0 {
+		// An unknown window is no reason to fail the call; the provider remains the final judge
+		return estimatedTokens, nil
+	}
+
+	if estimatedTokens > promptBudget(contextWindow, request.MaxTokens) {
+		return estimatedTokens, &ContextOverflowError{Model: request.Model, EstimatedTokens: estimatedTokens, ContextWindow: contextWindow}
+	}
+	return estimatedTokens, nil
+}
+
+// promptBudget is the share of the context window available to the prompt once the reply is reserved.
+// The reply reservation is capped at a quarter of the window so small local models stay usable
+func promptBudget(contextWindow int, maximumReplyTokens int) int {
+	reservedTokens := min(maximumReplyTokens, contextWindow/4)
+	return int(float64(contextWindow-reservedTokens) * (1 - contextSafetyMargin))
+}
@@ -0,0 +1,125 @@
+package llm
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	openrouter "github.com/revrost/go-openrouter"
+)
+
+// windowedProvider reports a fixed context window for every model
+type windowedProvider struct {
+	contextWindow int
+}
+
+func (provider *windowedProvider) Chat(jobContext context.Context, request *ChatRequest) (<-chan ChatResponseChunk, error) {
+	return nil, errors.New("not implemented")
+}
+
+func (provider *windowedProvider) Name() string { return "windowed" }
+
+func (provider *windowedProvider) ContextWindow(jobContext context.Context, model string) (int, error) {
+	return provider.contextWindow, nil
+}
+
+func TestEstimateTokens(t *testing.T) {
+	// Reference counts from tiktoken's cl100k_base encoding
+	testCases := []struct {
+		text     string
+		expected int
+	}{
+		{"Hello, world!", 4},
+		{"The quick brown fox jumps over the lazy dog.", 10},
+		{"", 0},
+	}
+	for _, testCase := range testCases {
+		if estimate := EstimateTokens(testCase.text); estimate != testCase.expected {
+			t.Errorf("Expected %d tokens for %q, got %d", testCase.expected, testCase.text, estimate)
+		}
+	}
+
+	// Scripts without Latin merges are priced per character
+	if estimate := EstimateTokens("熱力学"); estimate != 3 {
+		t.Errorf("Expected 3 tokens for three ideographs, got %d", estimate)
+	}
+}
+
+func TestGuardRequest_RefusesOversizedPromptsWithoutTrimming(t *testing.T) {
+	largeText := "INSTRUCTIONS " + strings.Repeat("The transcript goes on about entropy. ", 500) + " QUESTION"
+	request := &ChatRequest{Model: "small-model", Messages: []Message{{Role: "user", Content: []ContentPart{{Type: "text", Text: largeText}}}}, MaxTokens: 16384}
+
+	estimatedTokens, err := GuardRequest(context.Background(), &windowedProvider{contextWindow: 1000}, request)
+	var overflowError *ContextOverflowError
+	if !errors.As(err, &overflowError) || overflowError.Model != "small-model" || overflowError.ContextWindow != 1000 || overflowError.EstimatedTokens != estimatedTokens {
+		t.Fatalf("Expected a context overflow error, got %v", err)
+	}
+	if request.Messages[0].Content[0].Text != largeText {
+		t.Error("Expected the prompt to be left untouched")
+	}
+
+	estimatedTokens, err = GuardRequest(context.Background(), &windowedProvider{contextWindow: 100000}, request)
+	if err != nil || estimatedTokens != EstimateRequestTokens(request) {
+		t.Errorf("Expected a prompt within the window to pass with its estimate, got %d tokens and %v", estimatedTokens, err)
+	}
+}
+
+func TestGuardRequest_SkipsUnknownWindows(t *testing.T) {
+	images := []ContentPart{{Type: "image", ImageURL: "data:image/png;base64,"}, {Type: "image", ImageURL: "data:image/png;base64,"}}
+	request := &ChatRequest{Model: "small-model", Messages: []Message{{Role: "user", Content: images}}}
+
+	estimatedTokens, err := GuardRequest(context.Background(), &windowedProvider{contextWindow: 0}, request)
+	if err != nil || estimatedTokens != 2*imageTokens+messageOverheadTokens+replyPrimingTokens {
+		t.Errorf("Expected an unknown window to only estimate, got %d tokens, error %v", estimatedTokens, err)
+	}
+}
+
+func TestOpenRouterProvider_ContextWindowCachesListingFailures(t *testing.T) {
+	listings := 0
+	mockServer := httptest.NewServer(http.HandlerFunc(func(responseWriter http.ResponseWriter, request *http.Request) {
+		listings++
+		if listings == 1 {
+			http.Error(responseWriter, "unavailable", http.StatusServiceUnavailable)
+			return
+		}
+		responseWriter.Header().Set("Content-Type", "application/json")
+		responseWriter.Write([]byte(`{"data": [{"id": "google/gemini", "context_length": 1048576}]}`))
+	}))
+	defer mockServer.Close()
+
+	provider := NewOpenRouterProvider("key")
+	config := openrouter.DefaultConfig("key")
+	config.BaseURL = mockServer.URL
+	provider.client = openrouter.NewClientWithConfig(*config)
+
+	for range 3 {
+		if _, err := provider.ContextWindow(context.Background(), "google/gemini"); err == nil {
+			t.Fatal("Expected the failed listing to be reported")
+		}
+	}
+	if listings != 1 {
+		t.Errorf("Expected the failed listing to be cached, the models were listed %d times", listings)
+	}
+
+	// Once the retry interval has passed the listing is tried again
+	provider.contextWindowsRetryAt = time.Now()
+	if contextWindow, err := provider.ContextWindow(context.Background(), "openrouter:google/gemini"); err != nil || contextWindow != 1048576 {
+		t.Errorf("Expected the listed context window after the retry, got %d and %v", contextWindow, err)
+	}
+}
+
+func TestRoutingProvider_ContextWindowFollowsPrefix(t *testing.T) {
+	routingProvider := NewRoutingProvider(&preparableProvider{name: "openrouter"})
+	routingProvider.Register("local", &windowedProvider{contextWindow: 8192})
+
+	if contextWindow, _ := routingProvider.ContextWindow(context.Background(), "local:llama3"); contextWindow != 8192 {
+		t.Errorf("Expected the registered provider's window, got %d", contextWindow)
+	}
+	if contextWindow, _ := routingProvider.ContextWindow(context.Background(), "google/gemini"); contextWindow != 0 {
+		t.Errorf("Expected an unknown window for providers that do not report one, got %d", contextWindow)
+	}
+}
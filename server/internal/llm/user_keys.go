@@ -0,0 +1,74 @@
+package llm
+
+import (
+	"context"
+	"fmt"
+)
+
+type userContextKey struct{}
+
+// WithUser marks the calls made with the returned context as made for a user, so the routing provider can use
+// the API key that user stored for the provider instead of the operator's
+func WithUser(parent context.Context, userID string) context.Context {
+	return context.WithValue(parent, userContextKey{}, userID)
+}
+
+// UserFromContext returns the user the calls of a context are made for, or an empty string
+func UserFromContext(callContext context.Context) string {
+	userID, _ := callContext.Value(userContextKey{}).(string)
+	return userID
+}
+
+// APIKeyResolver returns the API key a user stored for a provider, or an empty string when they have none
+type APIKeyResolver func(userID string, providerName string) (string, error)
+
+// KeyedProvider is a provider that can call its service with another API key
+type KeyedProvider interface {
+	Provider
+	WithAPIKey(apiKey string) Provider
+}
+
+// WithAPIKey returns an OpenRouter provider calling with the given API key
+func (provider *OpenRouterProvider) WithAPIKey(apiKey string) Provider {
+	return NewOpenRouterProvider(apiKey)
+}
+
+// SetAPIKeyResolver makes the routing provider call providers with the API key of the user of each call, when
+// they stored one, so the operator is not billed for their generations
+func (routingProvider *RoutingProvider) SetAPIKeyResolver(resolver APIKeyResolver) {
+	routingProvider.providersMutex.Lock()
+	defer routingProvider.providersMutex.Unlock()
+	routingProvider.apiKeyResolver = resolver
+}
+
+// forUser returns the provider to call for the user of a context: a copy of the provider using the API key the
+// user stored for it, or the provider itself
+func (routingProvider *RoutingProvider) forUser(callContext context.Context, provider Provider) (Provider, error) {
+	keyedProvider, isKeyed := provider.(KeyedProvider)
+	userID := UserFromContext(callContext)
+	routingProvider.providersMutex.RLock()
+	resolver := routingProvider.apiKeyResolver
+	routingProvider.providersMutex.RUnlock()
+	if !isKeyed || userID == "" || resolver == nil {
+		return provider, nil
+	}
+
+	apiKey, err := resolver(userID, provider.Name())
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the %s API key of the user: %w", provider.Name(), err)
+	}
+	if apiKey == "" {
+		return provider, nil
+	}
+
+	// Providers are kept per key so each user's client and model list are reused across calls
+	cacheKey := provider.Name() + "\x00" + apiKey
+	routingProvider.providersMutex.Lock()
+	defer routingProvider.providersMutex.Unlock()
+	if userProvider, exists := routingProvider.userProviders[cacheKey]; exists {
+		return userProvider, nil
+	}
+	userProvider := keyedProvider.WithAPIKey(apiKey)
+	routingProvider.userProviders[cacheKey] = userProvider
+	return userProvider, nil
+}
@@ -0,0 +1,68 @@
+package llm
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+// keyedProvider is a fake provider recording the API key each call was made with
+type keyedProvider struct {
+	apiKey string
+	calls  *[]string
+}
+
+func (provider *keyedProvider) Chat(jobContext context.Context, request *ChatRequest) (<-chan ChatResponseChunk, error) {
+	*provider.calls = append(*provider.calls, provider.apiKey)
+	responseChannel := make(chan ChatResponseChunk)
+	close(responseChannel)
+	return responseChannel, nil
+}
+
+func (provider *keyedProvider) Name() string { return "openrouter" }
+
+func (provider *keyedProvider) WithAPIKey(apiKey string) Provider {
+	return &keyedProvider{apiKey: apiKey, calls: provider.calls}
+}
+
+func TestRoutingProvider_UsesTheAPIKeyOfTheUser(tester *testing.T) {
+	var calls []string
+	routingProvider := NewRoutingProvider(&keyedProvider{apiKey: "operator-key", calls: &calls})
+	resolutions := 0
+	routingProvider.SetAPIKeyResolver(func(userID string, providerName string) (string, error) {
+		resolutions++
+		switch userID {
+		case "user-with-key":
+			return "user-key", nil
+		case "user-with-broken-key":
+			return "", errors.New("cannot be decrypted")
+		}
+		return "", nil
+	})
+
+	request := func(callContext context.Context) error {
+		_, err := routingProvider.Chat(callContext, &ChatRequest{Model: "openrouter:some/model"})
+		return err
+	}
+	request(context.Background())
+	request(WithUser(context.Background(), "user-without-key"))
+	request(WithUser(context.Background(), "user-with-key"))
+	request(WithUser(context.Background(), "user-with-key"))
+
+	expectedCalls := []string{"operator-key", "operator-key", "user-key", "user-key"}
+	if len(calls) != len(expectedCalls) {
+		tester.Fatalf("Expected %d calls, got %v", len(expectedCalls), calls)
+	}
+	for index, apiKey := range expectedCalls {
+		if calls[index] != apiKey {
+			tester.Errorf("Expected call %d with %s, got %s", index, apiKey, calls[index])
+		}
+	}
+	if len(routingProvider.userProviders) != 1 || resolutions != 3 {
+		tester.Errorf("Expected one cached user provider after 3 resolutions, got %d after %d", len(routingProvider.userProviders), resolutions)
+	}
+
+	if err := request(WithUser(context.Background(), "user-with-broken-key")); err == nil {
+		tester.Errorf("Expected an unreadable user key to fail the call rather than bill the operator")
+	}
+}
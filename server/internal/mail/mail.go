@@ -0,0 +1,100 @@
+// Package mail sends the emails of the server, such as password reset links, through an SMTP server.
+package mail
+
+import (
+	"crypto/tls"
+	"fmt"
+	"mime"
+	"net"
+	"net/smtp"
+	"strconv"
+	"strings"
+	"time"
+
+	"lectures/internal/configuration"
+)
+
+// Sender delivers plain text emails
+type Sender interface {
+	Send(to string, subject string, body string) error
+}
+
+// SMTPSender delivers emails through the configured SMTP server
+type SMTPSender struct {
+	configuration configuration.SMTPConfiguration
+}
+
+// NewSMTPSender creates a sender for an SMTP server
+func NewSMTPSender(smtpConfiguration configuration.SMTPConfiguration) *SMTPSender {
+	return &SMTPSender{configuration: smtpConfiguration}
+}
+
+// Send delivers an email, authenticating when a username is configured. Port 465 connects with TLS, and other
+// ports upgrade with STARTTLS when the server offers it
+func (sender *SMTPSender) Send(to string, subject string, body string) error {
+	port := sender.configuration.Port
+	if port == 0 {
+		port = 587
+	}
+	address := net.JoinHostPort(sender.configuration.Host, strconv.Itoa(port))
+
+	var auth smtp.Auth
+	if sender.configuration.Username != "" {
+		auth = smtp.PlainAuth("", sender.configuration.Username, sender.configuration.Password, sender.configuration.Host)
+	}
+	message := composeMessage(sender.configuration.From, to, subject, body, time.Now())
+
+	if port != 465 {
+		if err := smtp.SendMail(address, auth, sender.configuration.From, []string{to}, message); err != nil {
+			return fmt.Errorf("failed to send email: %w", err)
+		}
+		return nil
+	}
+
+	connection, err := tls.Dial("tcp", address, &tls.Config{ServerName: sender.configuration.Host})
+	if err != nil {
+		return fmt.Errorf("failed to connect to the mail server: %w", err)
+	}
+	client, err := smtp.NewClient(connection, sender.configuration.Host)
+	if err != nil {
+		connection.Close()
+		return fmt.Errorf("failed to greet the mail server: %w", err)
+	}
+	defer client.Close()
+	if auth != nil {
+		if err := client.Auth(auth); err != nil {
+			return fmt.Errorf("failed to authenticate with the mail server: %w", err)
+		}
+	}
+	if err := client.Mail(sender.configuration.From); err != nil {
+		return fmt.Errorf("failed to send email: %w", err)
+	}
+	if err := client.Rcpt(to); err != nil {
+		return fmt.Errorf("failed to send email: %w", err)
+	}
+	writer, err := client.Data()
+	if err != nil {
+		return fmt.Errorf("failed to send email: %w", err)
+	}
+	if _, err := writer.Write(message); err != nil {
+		return fmt.Errorf("failed to send email: %w", err)
+	}
+	if err := writer.Close(); err != nil {
+		return fmt.Errorf("failed to send email: %w", err)
+	}
+	return client.Quit()
+}
+
+// composeMessage returns a UTF-8 plain text email with its headers, the subject encoded for non-ASCII text
+func composeMessage(from string, to string, subject string, body string, date time.Time) []byte {
+	var message strings.Builder
+	message.WriteString("From: " + from + "\r\n")
+	message.WriteString("To: " + to + "\r\n")
+	message.WriteString("Subject: " + mime.QEncoding.Encode("utf-8", subject) + "\r\n")
+	message.WriteString("Date: " + date.Format(time.RFC1123Z) + "\r\n")
+	message.WriteString("MIME-Version: 1.0\r\n")
+	message.WriteString("Content-Type: text/plain; charset=utf-8\r\n")
+	message.WriteString("Content-Transfer-Encoding: 8bit\r\n\r\n")
+	message.WriteString(strings.ReplaceAll(strings.ReplaceAll(body, "\r\n", "\n"), "\n", "\r\n"))
+	return []byte(message.String())
+}
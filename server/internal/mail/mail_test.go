@@ -0,0 +1,93 @@
+package mail
+
+import (
+	"bufio"
+	"net"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"lectures/internal/configuration"
+)
+
+func TestComposeMessage(t *testing.T) {
+	message := string(composeMessage("lectures@example.com", "student@example.com", "Réinitialiser", "Line one\nLine two", time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)))
+
+	for _, expected := range []string{
+		"From: lectures@example.com\r\n",
+		"To: student@example.com\r\n",
+		"Subject: =?utf-8?q?R=C3=A9initialiser?=\r\n",
+		"Date: Fri, 02 Jan 2026 03:04:05 +0000\r\n",
+		"Content-Type: text/plain; charset=utf-8\r\n",
+		"\r\n\r\nLine one\r\nLine two",
+	} {
+		if !strings.Contains(message, expected) {
+			t.Errorf("Expected %q in the message, got %q", expected, message)
+		}
+	}
+}
+
+func TestSMTPSender_Send(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	defer listener.Close()
+
+	received := make(chan []string, 1)
+	go func() {
+		connection, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer connection.Close()
+		reader := bufio.NewReader(connection)
+		reply := func(line string) { connection.Write([]byte(line + "\r\n")) }
+
+		var commands []string
+		reply("220 localhost ready")
+		for {
+			line, err := reader.ReadString('\n')
+			if err != nil {
+				received <- commands
+				return
+			}
+			line = strings.TrimRight(line, "\r\n")
+			commands = append(commands, line)
+			switch {
+			case strings.HasPrefix(line, "EHLO"):
+				reply("250 localhost")
+			case line == "DATA":
+				reply("354 go ahead")
+				for {
+					dataLine, _ := reader.ReadString('\n')
+					if dataLine == ".\r\n" {
+						break
+					}
+					commands = append(commands, strings.TrimRight(dataLine, "\r\n"))
+				}
+				reply("250 queued")
+			case line == "QUIT":
+				reply("221 bye")
+				received <- commands
+				return
+			default:
+				reply("250 ok")
+			}
+		}
+	}()
+
+	port, _ := strconv.Atoi(strings.TrimPrefix(listener.Addr().String(), "127.0.0.1:"))
+	sender := NewSMTPSender(configuration.SMTPConfiguration{Host: "127.0.0.1", Port: port, From: "lectures@example.com"})
+	if err := sender.Send("student@example.com", "Reset", "Open the link"); err != nil {
+		t.Fatalf("Send failed: %v", err)
+	}
+
+	conversation := strings.Join(<-received, "\n")
+	for _, expected := range []string{"MAIL FROM:<lectures@example.com>", "RCPT TO:<student@example.com>", "Subject: Reset", "Open the link"} {
+		if !strings.Contains(conversation, expected) {
+			t.Errorf("Expected %q sent to the mail server, got %q", expected, conversation)
+		}
+	}
+}
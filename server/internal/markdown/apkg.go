@@ -0,0 +1,337 @@
+package markdown
+
+import (
+	"archive/zip"
+	"crypto/sha1"
+	"database/sql"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"html"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	_ "modernc.org/sqlite"
+)
+
+// ankiModelID identifies the note type of exported cards. It is fixed so that every package shares one
+// "Lectures Flashcard" note type in the user's collection instead of adding a copy per import
+const ankiModelID int64 = 1702000000000
+
+var (
+	ankiDisplayMathPattern = regexp.MustCompile(`\$\$([\s\S]+?)\$\$`)
+	ankiInlineMathPattern  = regexp.MustCompile(`\$([^$\n]+?)\$`)
+	ankiTagUnsafePattern   = regexp.MustCompile(`[\s"]+`)
+	ankiHTMLTagPattern     = regexp.MustCompile(`<[^>]*>`)
+)
+
+// AnkiPackageOptions describes where exported flashcards land in Anki
+type AnkiPackageOptions struct {
+	ExamTitle    string
+	LectureTitle string
+	// DeckID keeps note identifiers stable across exports of the same tool, so re-importing updates cards
+	// instead of duplicating them
+	DeckID string
+}
+
+// DeckName nests the lecture's deck under its exam, so every lecture gets its own deck
+func (options AnkiPackageOptions) DeckName() string {
+	var parts []string
+	for _, part := range []string{options.ExamTitle, options.LectureTitle} {
+		part = strings.TrimSpace(strings.ReplaceAll(part, "::", ":"))
+		if part != "" {
+			parts = append(parts, part)
+		}
+	}
+	if len(parts) == 0 {
+		return "Lectures"
+	}
+	return strings.Join(parts, "::")
+}
+
+// Tags names the exam and the lecture in Anki's tag syntax, which does not allow spaces
+func (options AnkiPackageOptions) Tags() []string {
+	var tags []string
+	for _, title := range []string{options.ExamTitle, options.LectureTitle} {
+		if tag := strings.Trim(ankiTagUnsafePattern.ReplaceAllString(strings.TrimSpace(title), "_"), "_"); tag != "" {
+			tags = append(tags, tag)
+		}
+	}
+	return tags
+}
+
+// FlashcardsToAnkiPackage writes flashcard JSON as an Anki package (.apkg) that imports directly into Anki.
+// The package is a zip holding a collection.anki2 SQLite database, with one note per card, and the mnemonic
+// images as numbered media files. Math is kept as LaTeX in the delimiters Anki's MathJax renders.
+// Image fields are expected to be absolute paths (see tools.ResolveFlashcardImages)
+func FlashcardsToAnkiPackage(toolContent string, options AnkiPackageOptions, outputPath string) error {
+	var flashcards []map[string]string
+	if err := json.Unmarshal([]byte(toolContent), &flashcards); err != nil {
+		return fmt.Errorf("failed to parse flashcards: %w", err)
+	}
+
+	workDirectory, err := os.MkdirTemp("", "apkg-")
+	if err != nil {
+		return fmt.Errorf("failed to create package directory: %w", err)
+	}
+	defer os.RemoveAll(workDirectory)
+
+	collectionPath := filepath.Join(workDirectory, "collection.anki2")
+	media, err := writeAnkiCollection(collectionPath, flashcards, options)
+	if err != nil {
+		return err
+	}
+
+	return writeAnkiArchive(outputPath, collectionPath, media)
+}
+
+// ankiMedia is an image file shipped in a package, under the name the notes reference it by
+type ankiMedia struct {
+	name string
+	path string
+}
+
+// writeAnkiCollection creates the collection database and returns the media its notes reference
+func writeAnkiCollection(collectionPath string, flashcards []map[string]string, options AnkiPackageOptions) ([]ankiMedia, error) {
+	collection, err := sql.Open("sqlite", collectionPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create collection: %w", err)
+	}
+	defer collection.Close()
+
+	for _, statement := range ankiSchema {
+		if _, err := collection.Exec(statement); err != nil {
+			return nil, fmt.Errorf("failed to create collection schema: %w", err)
+		}
+	}
+
+	now := time.Now()
+	deckName := options.DeckName()
+	deckID := ankiIdentifier("deck:" + deckName)
+
+	decks := map[string]any{"1": ankiDeck(1, "Default", now)}
+	// Parent decks are listed too, since older Anki versions do not create them on import
+	segments := strings.Split(deckName, "::")
+	for depth := 1; depth < len(segments); depth++ {
+		parentName := strings.Join(segments[:depth], "::")
+		parentID := ankiIdentifier("deck:" + parentName)
+		decks[strconv.FormatInt(parentID, 10)] = ankiDeck(parentID, parentName, now)
+	}
+	decks[strconv.FormatInt(deckID, 10)] = ankiDeck(deckID, deckName, now)
+
+	modelsJSON, _ := json.Marshal(map[string]any{strconv.FormatInt(ankiModelID, 10): ankiModel(deckID, now)})
+	decksJSON, _ := json.Marshal(decks)
+	configurationJSON, _ := json.Marshal(map[string]any{
+		"activeDecks": []int64{1}, "curDeck": 1, "newSpread": 0, "collapseTime": 1200, "timeLim": 0,
+		"estTimes": true, "dueCounts": true, "curModel": ankiModelID, "nextPos": len(flashcards) + 1,
+		"sortType": "noteFld", "sortBackwards": false, "addToCur": true,
+	})
+	deckConfigurationJSON, _ := json.Marshal(map[string]any{"1": ankiDeckConfiguration(now)})
+
+	if _, err := collection.Exec(`INSERT INTO col (id, crt, mod, scm, ver, dty, usn, ls, conf, models, decks, dconf, tags)
+		VALUES (1, ?, ?, ?, 11, 0, 0, 0, ?, ?, ?, ?, '{}')`,
+		now.Unix(), now.UnixMilli(), now.UnixMilli(), string(configurationJSON), string(modelsJSON), string(decksJSON), string(deckConfigurationJSON)); err != nil {
+		return nil, fmt.Errorf("failed to write collection: %w", err)
+	}
+
+	tags := ""
+	if noteTags := options.Tags(); len(noteTags) > 0 {
+		// Anki stores tags space separated, with a leading and trailing space
+		tags = " " + strings.Join(noteTags, " ") + " "
+	}
+
+	var media []ankiMedia
+	mediaNames := make(map[string]string)
+	for index, card := range flashcards {
+		front := ankiFieldHTML(card["front"])
+		back := ankiFieldHTML(card["back"])
+		if imagePath := card["image"]; imagePath != "" {
+			if _, statError := os.Stat(imagePath); statError == nil {
+				mediaName, found := mediaNames[imagePath]
+				if !found {
+					// Media share one folder across the whole collection, so names carry the tool they belong to
+					mediaName = fmt.Sprintf("lectures_%s_%d%s", ankiGUID(options.DeckID, -1), len(media)+1, filepath.Ext(imagePath))
+					mediaNames[imagePath] = mediaName
+					media = append(media, ankiMedia{name: mediaName, path: imagePath})
+				}
+				back += fmt.Sprintf(`<br><img src="%s">`, html.EscapeString(mediaName))
+			}
+		}
+
+		noteID := now.UnixMilli() + int64(index)
+		sortField := strings.TrimSpace(html.UnescapeString(ankiHTMLTagPattern.ReplaceAllString(front, "")))
+		if _, err := collection.Exec(`INSERT INTO notes (id, guid, mid, mod, usn, tags, flds, sfld, csum, flags, data)
+			VALUES (?, ?, ?, ?, -1, ?, ?, ?, ?, 0, '')`,
+			noteID, ankiGUID(options.DeckID, index), ankiModelID, now.Unix(), tags, front+"\x1f"+back, sortField, ankiChecksum(sortField)); err != nil {
+			return nil, fmt.Errorf("failed to write note: %w", err)
+		}
+		if _, err := collection.Exec(`INSERT INTO cards (id, nid, did, ord, mod, usn, type, queue, due, ivl, factor, reps, lapses, left, odue, odid, flags, data)
+			VALUES (?, ?, ?, 0, ?, -1, 0, 0, ?, 0, 0, 0, 0, 0, 0, 0, 0, '')`,
+			noteID, noteID, deckID, now.Unix(), index+1); err != nil {
+			return nil, fmt.Errorf("failed to write card: %w", err)
+		}
+	}
+
+	return media, nil
+}
+
+// writeAnkiArchive zips the collection with its media: each file is stored under its index, and the media
+// manifest maps the indexes back to the names the notes reference
+func writeAnkiArchive(outputPath string, collectionPath string, media []ankiMedia) error {
+	output, err := os.Create(outputPath)
+	if err != nil {
+		return fmt.Errorf("failed to create package: %w", err)
+	}
+	defer output.Close()
+
+	archive := zip.NewWriter(output)
+	if err := addFileToAnkiArchive(archive, "collection.anki2", collectionPath); err != nil {
+		return err
+	}
+
+	manifest := make(map[string]string, len(media))
+	for index, file := range media {
+		entryName := strconv.Itoa(index)
+		if err := addFileToAnkiArchive(archive, entryName, file.path); err != nil {
+			return err
+		}
+		manifest[entryName] = file.name
+	}
+
+	manifestJSON, _ := json.Marshal(manifest)
+	manifestWriter, err := archive.Create("media")
+	if err != nil {
+		return fmt.Errorf("failed to write media manifest: %w", err)
+	}
+	if _, err := manifestWriter.Write(manifestJSON); err != nil {
+		return fmt.Errorf("failed to write media manifest: %w", err)
+	}
+
+	if err := archive.Close(); err != nil {
+		return fmt.Errorf("failed to finish package: %w", err)
+	}
+	return nil
+}
+
+func addFileToAnkiArchive(archive *zip.Writer, entryName string, path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("failed to read %s: %w", filepath.Base(path), err)
+	}
+	defer file.Close()
+
+	entry, err := archive.Create(entryName)
+	if err != nil {
+		return fmt.Errorf("failed to add %s to package: %w", entryName, err)
+	}
+	if _, err := io.Copy(entry, file); err != nil {
+		return fmt.Errorf("failed to add %s to package: %w", entryName, err)
+	}
+	return nil
+}
+
+// ankiFieldHTML turns a card side into the HTML Anki shows: text is escaped, line breaks kept, and math moved
+// from $...$ and $$...$$ to the \(...\) and \[...\] delimiters Anki renders with MathJax
+func ankiFieldHTML(text string) string {
+	escaped := html.EscapeString(text)
+	escaped = ankiDisplayMathPattern.ReplaceAllStringFunc(escaped, func(match string) string {
+		return `\[` + strings.TrimSpace(match[2:len(match)-2]) + `\]`
+	})
+	escaped = ankiInlineMathPattern.ReplaceAllStringFunc(escaped, func(match string) string {
+		return `\(` + match[1:len(match)-1] + `\)`
+	})
+	return strings.ReplaceAll(escaped, "\n", "<br>")
+}
+
+// ankiIdentifier derives a stable positive identifier, so the same deck keeps its ID across exports
+func ankiIdentifier(key string) int64 {
+	digest := sha1.Sum([]byte(key))
+	return int64(binary.BigEndian.Uint64(digest[:8])>>12) + 1
+}
+
+// ankiGUID identifies a note by its tool and position; index -1 identifies the tool itself
+func ankiGUID(deckID string, index int) string {
+	digest := sha1.Sum([]byte(deckID + ":" + strconv.Itoa(index)))
+	return hex.EncodeToString(digest[:8])
+}
+
+// ankiChecksum is the duplicate-detection checksum Anki keeps for the sort field: the first 8 hex digits of
+// its SHA-1
+func ankiChecksum(sortField string) int64 {
+	digest := sha1.Sum([]byte(sortField))
+	return int64(binary.BigEndian.Uint32(digest[:4]))
+}
+
+func ankiDeck(id int64, name string, now time.Time) map[string]any {
+	return map[string]any{
+		"id": id, "name": name, "desc": "", "mod": now.Unix(), "usn": -1, "conf": 1, "dyn": 0,
+		"collapsed": false, "browserCollapsed": false, "extendNew": 10, "extendRev": 50,
+		"newToday": []int{0, 0}, "revToday": []int{0, 0}, "lrnToday": []int{0, 0}, "timeToday": []int{0, 0},
+	}
+}
+
+func ankiModel(deckID int64, now time.Time) map[string]any {
+	field := func(name string, order int) map[string]any {
+		return map[string]any{"name": name, "ord": order, "sticky": false, "rtl": false, "font": "Arial", "size": 20, "media": []string{}}
+	}
+	return map[string]any{
+		"id": ankiModelID, "name": "Lectures Flashcard", "type": 0, "mod": now.Unix(), "usn": -1, "sortf": 0,
+		"did": deckID, "tags": []string{}, "vers": []int{},
+		"flds": []map[string]any{field("Front", 0), field("Back", 1)},
+		"tmpls": []map[string]any{{
+			"name": "Card 1", "ord": 0, "did": nil, "bqfmt": "", "bafmt": "",
+			"qfmt": "{{Front}}", "afmt": "{{FrontSide}}<hr id=answer>{{Back}}",
+		}},
+		"css":       ".card { font-family: arial; font-size: 20px; text-align: center; color: black; background-color: white; }\nimg { max-width: 100%; }",
+		"latexPre":  "\\documentclass[12pt]{article}\n\\special{papersize=3in,5in}\n\\usepackage[utf8]{inputenc}\n\\usepackage{amssymb,amsmath}\n\\pagestyle{empty}\n\\setlength{\\parindent}{0in}\n\\begin{document}\n",
+		"latexPost": "\\end{document}",
+		"req":       []any{[]any{0, "any", []int{0}}},
+	}
+}
+
+func ankiDeckConfiguration(now time.Time) map[string]any {
+	return map[string]any{
+		"id": 1, "name": "Default", "mod": now.Unix(), "usn": 0, "maxTaken": 60, "autoplay": true, "timer": 0,
+		"replayq": true, "dyn": false,
+		"new": map[string]any{
+			"delays": []float64{1, 10}, "ints": []int{1, 4, 7}, "initialFactor": 2500, "order": 1, "perDay": 20,
+			"bury": true, "separate": true,
+		},
+		"rev": map[string]any{
+			"perDay": 200, "ease4": 1.3, "fuzz": 0.05, "minSpace": 1, "ivlFct": 1, "maxIvl": 36500, "bury": true,
+		},
+		"lapse": map[string]any{
+			"delays": []float64{10}, "mult": 0, "minInt": 1, "leechFails": 8, "leechAction": 0,
+		},
+	}
+}
+
+// ankiSchema is the schema of an Anki 2.1 collection (version 11), which every Anki release still imports
+var ankiSchema = []string{
+	`CREATE TABLE col (id integer primary key, crt integer not null, mod integer not null, scm integer not null,
+		ver integer not null, dty integer not null, usn integer not null, ls integer not null, conf text not null,
+		models text not null, decks text not null, dconf text not null, tags text not null)`,
+	`CREATE TABLE notes (id integer primary key, guid text not null, mid integer not null, mod integer not null,
+		usn integer not null, tags text not null, flds text not null, sfld integer not null, csum integer not null,
+		flags integer not null, data text not null)`,
+	`CREATE TABLE cards (id integer primary key, nid integer not null, did integer not null, ord integer not null,
+		mod integer not null, usn integer not null, type integer not null, queue integer not null, due integer not null,
+		ivl integer not null, factor integer not null, reps integer not null, lapses integer not null,
+		left integer not null, odue integer not null, odid integer not null, flags integer not null, data text not null)`,
+	`CREATE TABLE revlog (id integer primary key, cid integer not null, usn integer not null, ease integer not null,
+		ivl integer not null, lastIvl integer not null, factor integer not null, time integer not null, type integer not null)`,
+	`CREATE TABLE graves (usn integer not null, oid integer not null, type integer not null)`,
+	`CREATE INDEX ix_notes_usn on notes (usn)`,
+	`CREATE INDEX ix_cards_usn on cards (usn)`,
+	`CREATE INDEX ix_revlog_usn on revlog (usn)`,
+	`CREATE INDEX ix_cards_nid on cards (nid)`,
+	`CREATE INDEX ix_cards_sched on cards (did, queue, due)`,
+	`CREATE INDEX ix_revlog_cid on revlog (cid)`,
+	`CREATE INDEX ix_notes_csum on notes (csum)`,
+}
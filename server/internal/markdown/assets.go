@@ -0,0 +1,115 @@
+package markdown
+
+import (
+	"encoding/base64"
+	"fmt"
+	"html"
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// assetReferencePattern matches the source of HTML images (<img src="...">) and of Markdown images (![](...))
+var assetReferencePattern = regexp.MustCompile(`(<img\b[^>]*?\bsrc=")([^"]*)(")|(!\[[^\]]*\]\()([^)\s]+)(\))`)
+
+// UnresolvedAsset is an image referenced by an export that cannot be embedded
+type UnresolvedAsset struct {
+	Source string `json:"source"`
+	Reason string `json:"reason"`
+}
+
+// UnresolvedAssetsError lists every asset of an export that failed verification
+type UnresolvedAssetsError struct {
+	Assets []UnresolvedAsset
+}
+
+func (assetsError *UnresolvedAssetsError) Error() string {
+	descriptions := make([]string, 0, len(assetsError.Assets))
+	for _, asset := range assetsError.Assets {
+		descriptions = append(descriptions, fmt.Sprintf("%s (%s)", asset.Source, asset.Reason))
+	}
+	return fmt.Sprintf("%d referenced asset(s) could not be resolved: %s", len(assetsError.Assets), strings.Join(descriptions, "; "))
+}
+
+// EmbedLocalAssets checks that every image referenced by content, in HTML or Markdown syntax, resolves to a
+// readable local file; relative paths are looked up in baseDirectory. References are rewritten to absolute paths,
+// so pandoc can package them, or to data URIs when inline is set. Data URIs are kept as they are, while remote
+// URLs are reported because they cannot be embedded. Every failure is returned at once in an *UnresolvedAssetsError
+func EmbedLocalAssets(content string, baseDirectory string, inline bool) (string, error) {
+	var unresolvedAssets []UnresolvedAsset
+	reportedSources := make(map[string]bool)
+
+	rewrittenContent := assetReferencePattern.ReplaceAllStringFunc(content, func(match string) string {
+		groups := assetReferencePattern.FindStringSubmatch(match)
+		prefix, source, suffix := groups[1], groups[2], groups[3]
+		isHTML := prefix != ""
+		if !isHTML {
+			prefix, source, suffix = groups[4], groups[5], groups[6]
+		} else {
+			source = html.UnescapeString(source)
+		}
+
+		resolvedSource, reason := resolveAsset(source, baseDirectory, inline)
+		if reason != "" {
+			if !reportedSources[source] {
+				reportedSources[source] = true
+				unresolvedAssets = append(unresolvedAssets, UnresolvedAsset{Source: source, Reason: reason})
+			}
+			return match
+		}
+		if isHTML {
+			resolvedSource = html.EscapeString(resolvedSource)
+		}
+		return prefix + resolvedSource + suffix
+	})
+
+	if len(unresolvedAssets) > 0 {
+		return "", &UnresolvedAssetsError{Assets: unresolvedAssets}
+	}
+	return rewrittenContent, nil
+}
+
+// resolveAsset returns the embeddable form of an image source, or the reason it cannot be embedded
+func resolveAsset(source string, baseDirectory string, inline bool) (string, string) {
+	switch {
+	case strings.TrimSpace(source) == "":
+		return "", "empty image source"
+	case strings.HasPrefix(source, "data:"):
+		return source, ""
+	case strings.HasPrefix(source, "file://"):
+		source = strings.TrimPrefix(source, "file://")
+	case strings.Contains(source, "://"):
+		return "", "remote assets cannot be embedded"
+	}
+
+	path := source
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(baseDirectory, path)
+	}
+	information, err := os.Stat(path)
+	if os.IsNotExist(err) {
+		return "", "file not found"
+	}
+	if err != nil {
+		return "", "file cannot be read: " + err.Error()
+	}
+	if information.IsDir() {
+		return "", "path is a directory"
+	}
+	if !inline {
+		return path, ""
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", "file cannot be read: " + err.Error()
+	}
+	mimeType := mime.TypeByExtension(strings.ToLower(filepath.Ext(path)))
+	if !strings.HasPrefix(mimeType, "image/") {
+		mimeType = http.DetectContentType(data)
+	}
+	return "data:" + mimeType + ";base64," + base64.StdEncoding.EncodeToString(data), ""
+}
@@ -7,6 +7,7 @@ import (
 	"sort"
 	"strconv"
 	"strings"
+	"unicode"
 )
 
 // ParsedCitation represents metadata extracted from a {{{...}}} marker
@@ -179,3 +180,85 @@ func FormatPageNumbers(pages []int) string {
 
 	return strings.Join(ranges, ", ")
 }
+
+// ResolveCitationFilename maps a filename cited by the model onto the closest known document name, so a
+// slightly mangled citation ("Lecture-1 Slides.PDF" for "Lecture_1_Slides.pdf") still finds its pages.
+// Names are compared after normalization, then by Levenshtein distance; an empty string is returned when
+// no candidate is close enough
+func ResolveCitationFilename(citedFilename string, candidates []string) string {
+	normalizedCitation := normalizeCitationFilename(citedFilename)
+	if normalizedCitation == "" {
+		return ""
+	}
+
+	bestCandidate := ""
+	bestDistance := -1
+	for _, candidate := range candidates {
+		if candidate == citedFilename {
+			return candidate
+		}
+		normalizedCandidate := normalizeCitationFilename(candidate)
+		if normalizedCandidate == "" {
+			continue
+		}
+		distance := levenshteinDistance(normalizedCitation, normalizedCandidate)
+		if bestDistance == -1 || distance < bestDistance {
+			bestCandidate = candidate
+			bestDistance = distance
+		}
+	}
+
+	// Allow roughly one edit every five characters, and at least two, so unrelated documents never match
+	allowedDistance := max(2, len([]rune(normalizedCitation))/5)
+	if bestDistance == -1 || bestDistance > allowedDistance {
+		return ""
+	}
+	return bestCandidate
+}
+
+// CorrectCitationFilenames replaces the file of every citation with the document name it resolves to, and
+// leaves citations that match no document unchanged
+func CorrectCitationFilenames(citations []ParsedCitation, documentNames []string) {
+	for citationIndex, citation := range citations {
+		resolvedFilename := ResolveCitationFilename(citation.File, documentNames)
+		if resolvedFilename != "" && resolvedFilename != citation.File {
+			slog.Debug("Corrected citation filename", "cited", citation.File, "resolved", resolvedFilename)
+			citations[citationIndex].File = resolvedFilename
+		}
+	}
+}
+
+// normalizeCitationFilename lowercases a filename and drops everything but letters and digits, so
+// differences in case, separators and spacing do not count as edits
+func normalizeCitationFilename(filename string) string {
+	var builder strings.Builder
+	for _, character := range strings.ToLower(strings.TrimSpace(filename)) {
+		if unicode.IsLetter(character) || unicode.IsDigit(character) {
+			builder.WriteRune(character)
+		}
+	}
+	return builder.String()
+}
+
+func levenshteinDistance(first string, second string) int {
+	firstRunes := []rune(first)
+	secondRunes := []rune(second)
+
+	previousRow := make([]int, len(secondRunes)+1)
+	currentRow := make([]int, len(secondRunes)+1)
+	for secondIndex := range previousRow {
+		previousRow[secondIndex] = secondIndex
+	}
+	for firstIndex := 1; firstIndex <= len(firstRunes); firstIndex++ {
+		currentRow[0] = firstIndex
+		for secondIndex := 1; secondIndex <= len(secondRunes); secondIndex++ {
+			substitutionCost := 1
+			if firstRunes[firstIndex-1] == secondRunes[secondIndex-1] {
+				substitutionCost = 0
+			}
+			currentRow[secondIndex] = min(previousRow[secondIndex]+1, currentRow[secondIndex-1]+1, previousRow[secondIndex-1]+substitutionCost)
+		}
+		previousRow, currentRow = currentRow, previousRow
+	}
+	return previousRow[len(secondRunes)]
+}
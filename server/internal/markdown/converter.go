@@ -6,15 +6,20 @@ import (
 	"encoding/csv"
 	"encoding/json"
 	"fmt"
+	"html"
 	"log/slog"
 	"os"
 	"os/exec"
 	"path/filepath"
 	"regexp"
+	"strconv"
 	"strings"
 	"time"
 
 	"lectures/internal/media"
+	"lectures/internal/models"
+
+	"gopkg.in/yaml.v3"
 )
 
 // MarkdownConverter defines the interface for document format conversions
@@ -24,6 +29,8 @@ type MarkdownConverter interface {
 	NormalizeMath(markdownText string) string
 	HTMLToPDF(htmlContent string, outputPath string, options ConversionOptions) error
 	HTMLToDocx(htmlContent string, outputPath string, options ConversionOptions) error
+	HTMLToEPUB(htmlContent string, outputPath string, options ConversionOptions) error
+	SaveHTML(htmlContent string, outputPath string, options ConversionOptions) error
 	HTMLToAnki(toolType string, toolContent string, outputPath string) error
 	HTMLToCSV(toolType string, toolContent string, outputPath string) error
 	SaveMarkdown(markdownText string, outputPath string) error
@@ -72,17 +79,23 @@ type AudioFileMetadata struct {
 
 // ConversionOptions contains settings for PDF generation
 type ConversionOptions struct {
+	Title          string // Document title, used by EPUB and HTML exports
 	Language       string
 	Description    string
 	CourseTitle    string
+	MetadataFields []models.MetadataField // Exam and lecture details printed below the course
 	CreationDate   time.Time
 	ReferenceFiles []ReferenceFileMetadata
 	AudioFiles     []AudioFileMetadata
 	QRCodePath     string
+	Theme          string // Selects xelatex-template-<theme>.tex for PDFs when that template exists
 }
 
 // MarkdownToHTML converts markdown text to HTML string
 func (converter *ExternalConverter) MarkdownToHTML(markdownText string) (string, error) {
+	// Diagrams are rendered first, so their source is not taken for math
+	markdownText = converter.renderMermaidDiagrams(markdownText)
+
 	// Normalize LaTeX delimiters before passing to pandoc
 	markdownText = converter.normalizeMathDelimiters(markdownText)
 
@@ -171,6 +184,12 @@ func (converter *ExternalConverter) HTMLToPDF(htmlContent string, outputPath str
 	// Locate the custom XeLaTeX template in server root directory
 	// Server must be run from the server root directory
 	templatePath := "xelatex-template.tex"
+	if options.Theme != "" {
+		themedTemplatePath := "xelatex-template-" + filepath.Base(options.Theme) + ".tex"
+		if fileExists(themedTemplatePath) {
+			templatePath = themedTemplatePath
+		}
+	}
 
 	slog.Debug("Using XeLaTeX template", "path", templatePath, "exists", fileExists(templatePath))
 
@@ -248,6 +267,49 @@ func (converter *ExternalConverter) HTMLToDocx(htmlContent string, outputPath st
 	return nil
 }
 
+// HTMLToEPUB converts HTML content to an EPUB 3 file, packaging the images it references
+func (converter *ExternalConverter) HTMLToEPUB(htmlContent string, outputPath string, options ConversionOptions) error {
+	bin := media.ResolveBinaryPath("pandoc", converter.binDir)
+	arguments := []string{
+		"-f", "html",
+		"-t", "epub3",
+		"--resource-path", converter.dataDirectory,
+		"--toc",
+		"-o", outputPath,
+	}
+	if options.Title != "" {
+		arguments = append(arguments, "--metadata", "title="+options.Title)
+	}
+	if options.Language != "" {
+		arguments = append(arguments, "--metadata", "lang="+options.Language)
+	}
+	if options.CourseTitle != "" {
+		arguments = append(arguments, "--metadata", "subtitle="+options.CourseTitle)
+	}
+
+	command := exec.Command(bin, arguments...)
+	command.Stdin = strings.NewReader(htmlContent)
+	var stderr bytes.Buffer
+	command.Stderr = &stderr
+
+	if executionError := command.Run(); executionError != nil {
+		return fmt.Errorf("pandoc epub conversion failed: %v, stderr: %s", executionError, stderr.String())
+	}
+
+	return nil
+}
+
+// SaveHTML wraps HTML content in a standalone HTML document and saves it to a file
+func (converter *ExternalConverter) SaveHTML(htmlContent string, outputPath string, options ConversionOptions) error {
+	var builder strings.Builder
+	builder.WriteString("<!DOCTYPE html>\n")
+	fmt.Fprintf(&builder, "<html lang=\"%s\">\n<head>\n<meta charset=\"utf-8\">\n", html.EscapeString(options.Language))
+	fmt.Fprintf(&builder, "<title>%s</title>\n</head>\n<body>\n", html.EscapeString(options.Title))
+	builder.WriteString(htmlContent)
+	builder.WriteString("\n</body>\n</html>\n")
+	return os.WriteFile(outputPath, []byte(builder.String()), 0644)
+}
+
 // HTMLToAnki converts tool content to an Anki-compatible tab-separated file
 func (converter *ExternalConverter) HTMLToAnki(toolType string, toolContent string, outputPath string) error {
 	var builder strings.Builder
@@ -261,26 +323,46 @@ func (converter *ExternalConverter) HTMLToAnki(toolType string, toolContent stri
 			// Anki format: Front \t Back
 			front := strings.ReplaceAll(fc["front"], "\n", "<br>")
 			back := strings.ReplaceAll(fc["back"], "\n", "<br>")
+			// Images are inlined so the deck imports without a separate media folder
+			if fc["image"] != "" {
+				if dataURI := imageToBase64(fc["image"]); dataURI != "" {
+					back += fmt.Sprintf(`<br><img src="%s">`, dataURI)
+				}
+			}
 			fmt.Fprintf(&builder, "%s\t%s\n", front, back)
 		}
 	} else if toolType == "quiz" {
-		var quiz []map[string]any
+		var quiz []models.QuizQuestion
 		if err := json.Unmarshal([]byte(toolContent), &quiz); err != nil {
 			return err
 		}
 		for _, item := range quiz {
-			question := fmt.Sprintf("%v", item["question"])
-			options, _ := json.Marshal(item["options"])
-			correct := fmt.Sprintf("%v", item["correct_answer"])
-			explanation := fmt.Sprintf("%v", item["explanation"])
-
-			fmt.Fprintf(&builder, "%s\t%s\t%s\t%s\n", question, options, correct, explanation)
+			fmt.Fprintf(&builder, "%s\t%s\t%s\t%s\n", item.Question, quizOptionsJSON(item), QuizAnswerText(item), item.Explanation)
 		}
 	}
 
 	return os.WriteFile(outputPath, []byte(builder.String()), 0644)
 }
 
+// FlashcardsToMarkdown renders flashcard JSON as a Markdown document with one section per card.
+// Image fields are expected to be absolute paths (see tools.ResolveFlashcardImages)
+func FlashcardsToMarkdown(title string, toolContent string) string {
+	var flashcards []map[string]string
+	if err := json.Unmarshal([]byte(toolContent), &flashcards); err != nil {
+		return toolContent
+	}
+
+	var builder strings.Builder
+	fmt.Fprintf(&builder, "# %s\n\n", title)
+	for index, fc := range flashcards {
+		fmt.Fprintf(&builder, "## %d. %s\n\n%s\n\n", index+1, strings.ReplaceAll(fc["front"], "\n", " "), fc["back"])
+		if fc["image"] != "" {
+			fmt.Fprintf(&builder, "![](%s)\n\n", fc["image"])
+		}
+	}
+	return builder.String()
+}
+
 // HTMLToCSV converts tool content to a standard CSV file
 func (converter *ExternalConverter) HTMLToCSV(toolType string, toolContent string, outputPath string) error {
 	file, err := os.Create(outputPath)
@@ -303,19 +385,40 @@ func (converter *ExternalConverter) HTMLToCSV(toolType string, toolContent strin
 			writer.Write([]string{fc["front"], fc["back"]})
 		}
 	case "quiz":
-		var quiz []map[string]any
+		var quiz []models.QuizQuestion
 		if err := json.Unmarshal([]byte(toolContent), &quiz); err != nil {
 			return err
 		}
 		writer.Write([]string{"Question", "Options", "Correct Answer", "Explanation"})
 		for _, item := range quiz {
-			optionsBytes, _ := json.Marshal(item["options"])
-			writer.Write([]string{
-				fmt.Sprintf("%v", item["question"]),
-				string(optionsBytes),
-				fmt.Sprintf("%v", item["correct_answer"]),
-				fmt.Sprintf("%v", item["explanation"]),
-			})
+			writer.Write([]string{item.Question, quizOptionsJSON(item), QuizAnswerText(item), item.Explanation})
+		}
+	case models.ToolTypeMindmap:
+		var mindmap models.Mindmap
+		if err := json.Unmarshal([]byte(toolContent), &mindmap); err != nil {
+			return err
+		}
+		labels := make(map[string]string, len(mindmap.Nodes))
+		for _, node := range mindmap.Nodes {
+			labels[node.ID] = node.Label
+		}
+		writer.Write([]string{"From", "Relation", "To"})
+		for _, edge := range mindmap.Edges {
+			writer.Write([]string{labels[edge.From], edge.Label, labels[edge.To]})
+		}
+	case models.ToolTypeMockExam:
+		var mockExam models.MockExam
+		if err := json.Unmarshal([]byte(toolContent), &mockExam); err != nil {
+			return err
+		}
+		writer.Write([]string{"Type", "Difficulty", "Points", "Lecture", "Question", "Options", "Correct Answer", "Explanation"})
+		for _, question := range mockExam.Questions {
+			options := ""
+			if len(question.Options) > 0 {
+				optionsJSON, _ := json.Marshal(question.Options)
+				options = string(optionsJSON)
+			}
+			writer.Write([]string{question.Type, question.Difficulty, strconv.Itoa(question.Points), question.Lecture, question.Question, options, question.CorrectAnswer, question.Explanation})
 		}
 	}
 
@@ -378,6 +481,9 @@ func (converter *ExternalConverter) GenerateMetadataHeader(options ConversionOpt
 		courseLabel := getI18nLabel(options.Language, "course_label")
 		fmt.Fprintf(&builder, "**%s**: %s\n\n", courseLabel, options.CourseTitle)
 	}
+	for _, field := range options.MetadataFields {
+		fmt.Fprintf(&builder, "**%s**: %s\n\n", field.Name, field.Value)
+	}
 
 	// 1. Date
 	if !options.CreationDate.IsZero() {
@@ -460,6 +566,34 @@ func findAvailableFont(candidates []string) string {
 	return ""
 }
 
+// pandocMetadata is the metadata file read by the XeLaTeX template. It is written with a YAML encoder, so titles,
+// descriptions and metadata fields holding quotes, backslashes or newlines stay valid YAML
+type pandocMetadata struct {
+	Language            string                `yaml:"lang"`
+	CourseTitleLabel    string                `yaml:"course-title-label"`
+	AbstractTitle       string                `yaml:"abstract-title"`
+	AudioFilesTitle     string                `yaml:"audio-files-title"`
+	ReferenceFilesTitle string                `yaml:"reference-files-title"`
+	HeaderIncludes      []string              `yaml:"header-includes,omitempty"`
+	CourseTitle         string                `yaml:"course-title,omitempty"`
+	MetadataFields      []pandocMetadataField `yaml:"metadata-fields,omitempty"`
+	QRCodePath          string                `yaml:"qrcode-path,omitempty"`
+	Abstract            string                `yaml:"abstract,omitempty"`
+	Date                string                `yaml:"date,omitempty"`
+	ReferenceFiles      []pandocMetadataFile  `yaml:"referencefile,omitempty"`
+	AudioFiles          []pandocMetadataFile  `yaml:"audiofile,omitempty"`
+}
+
+type pandocMetadataField struct {
+	Name  string `yaml:"name"`
+	Value string `yaml:"value"`
+}
+
+type pandocMetadataFile struct {
+	Filename string `yaml:"filename"`
+	Metadata string `yaml:"metadata"`
+}
+
 func (converter *ExternalConverter) writeMetadataFile(path string, options ConversionOptions) error {
 	slog.Info("Preparing PDF metadata",
 		"language", options.Language,
@@ -468,104 +602,81 @@ func (converter *ExternalConverter) writeMetadataFile(path string, options Conve
 		"reference_files", options.ReferenceFiles,
 		"audio_files", options.AudioFiles)
 
-	var builder strings.Builder
-
 	// Normalize language code (e.g., "ja-JP" -> "ja")
 	normalizedLanguage := strings.ToLower(options.Language)
 	if idx := strings.Index(normalizedLanguage, "-"); idx != -1 {
 		normalizedLanguage = normalizedLanguage[:idx]
 	}
 
-	// CJK languages need special handling for XeLaTeX
-	cjkLanguages := map[string]bool{"ja": true, "ko": true, "zh": true}
-	isCJK := cjkLanguages[normalizedLanguage]
-
-	// Add the language for pandoc
-	// For CJK languages, use the full language tag for proper rendering
-	if isCJK {
-		// Use BCP 47 tag for CJK (e.g., ja-JP, ko-KR, zh-CN)
-		fmt.Fprintf(&builder, "lang: \"%s\"\n", options.Language)
-	} else {
-		fmt.Fprintf(&builder, "lang: \"%s\"\n", options.Language)
-	}
-
-	// Add translated labels for the template
-	fmt.Fprintf(&builder, "course-title-label: \"%s\"\n", getI18nLabel(options.Language, "course_label"))
-	fmt.Fprintf(&builder, "abstract-title: \"%s\"\n", getI18nLabel(options.Language, "abstract"))
-	fmt.Fprintf(&builder, "audio-files-title: \"%s\"\n", getI18nLabel(options.Language, "audio_files"))
-	fmt.Fprintf(&builder, "reference-files-title: \"%s\"\n", getI18nLabel(options.Language, "reference_files"))
-
-	// Add font settings for CJK languages
-	if isCJK {
-		// Ordered list of font candidates per language (first available wins)
-		languageFontCandidates := map[string][]string{
-			"ja": {"Noto Serif JP", "Noto Serif CJK JP", "Hiragino Mincho ProN", "Noto Sans CJK JP"},
-			"ko": {"Noto Serif KR", "Noto Serif CJK KR", "Apple SD Gothic Neo", "Noto Sans CJK KR"},
-			"zh": {"Noto Serif SC", "Noto Serif CJK SC", "STSong", "Noto Sans CJK SC"},
-		}
-		if candidates, ok := languageFontCandidates[normalizedLanguage]; ok {
-			if font := findAvailableFont(candidates); font != "" {
-				fmt.Fprintf(&builder, "header-includes:\n")
-				fmt.Fprintf(&builder, "  - |\n")
-				fmt.Fprintf(&builder, "    ```{=latex}\n")
-				fmt.Fprintf(&builder, "    \\newfontfamily\\cjkfont{%s}\n", font)
-				fmt.Fprintf(&builder, "    \\XeTeXlinebreaklocale \"%s\"\n", normalizedLanguage)
-				fmt.Fprintf(&builder, "    \\XeTeXlinebreakskip = 0pt plus 1pt\n")
-				fmt.Fprintf(&builder, "    \\usepackage{ucharclasses}\n")
-				fmt.Fprintf(&builder, "    \\setTransitionsForCJK{\\cjkfont}{\\rmfamily}\n")
-				fmt.Fprintf(&builder, "    ```\n")
-			}
+	// The language for pandoc, the full BCP 47 tag (e.g., ja-JP, ko-KR, zh-CN) for proper CJK rendering, and the
+	// translated labels for the template
+	metadata := pandocMetadata{
+		Language:            options.Language,
+		CourseTitleLabel:    getI18nLabel(options.Language, "course_label"),
+		AbstractTitle:       getI18nLabel(options.Language, "abstract"),
+		AudioFilesTitle:     getI18nLabel(options.Language, "audio_files"),
+		ReferenceFilesTitle: getI18nLabel(options.Language, "reference_files"),
+		CourseTitle:         options.CourseTitle,
+		QRCodePath:          strings.ReplaceAll(options.QRCodePath, "\\", "/"),
+		Abstract:            options.Description,
+	}
+
+	// Add font settings for CJK languages, which need special handling for XeLaTeX. Ordered list of font
+	// candidates per language (first available wins)
+	languageFontCandidates := map[string][]string{
+		"ja": {"Noto Serif JP", "Noto Serif CJK JP", "Hiragino Mincho ProN", "Noto Sans CJK JP"},
+		"ko": {"Noto Serif KR", "Noto Serif CJK KR", "Apple SD Gothic Neo", "Noto Sans CJK KR"},
+		"zh": {"Noto Serif SC", "Noto Serif CJK SC", "STSong", "Noto Sans CJK SC"},
+	}
+	if candidates, ok := languageFontCandidates[normalizedLanguage]; ok {
+		if font := findAvailableFont(candidates); font != "" {
+			metadata.HeaderIncludes = append(metadata.HeaderIncludes, strings.Join([]string{
+				"```{=latex}",
+				fmt.Sprintf("\\newfontfamily\\cjkfont{%s}", font),
+				fmt.Sprintf("\\XeTeXlinebreaklocale \"%s\"", normalizedLanguage),
+				"\\XeTeXlinebreakskip = 0pt plus 1pt",
+				"\\usepackage{ucharclasses}",
+				"\\setTransitionsForCJK{\\cjkfont}{\\rmfamily}",
+				"```",
+			}, "\n")+"\n")
 		}
 	}
 
-	if options.CourseTitle != "" {
-		fmt.Fprintf(&builder, "course-title: \"%s\"\n", strings.ReplaceAll(options.CourseTitle, "\"", "\\\""))
-	}
-
-	if options.QRCodePath != "" {
-		fmt.Fprintf(&builder, "qrcode-path: \"%s\"\n", strings.ReplaceAll(options.QRCodePath, "\\", "/"))
-	}
-
-	if options.Description != "" {
-		fmt.Fprintf(&builder, "abstract: \"%s\"\n", strings.ReplaceAll(options.Description, "\"", "\\\""))
+	for _, field := range options.MetadataFields {
+		metadata.MetadataFields = append(metadata.MetadataFields, pandocMetadataField{Name: field.Name, Value: field.Value})
 	}
 
 	if !options.CreationDate.IsZero() {
-		dateString := formatLocalizedDate(options.CreationDate, options.Language)
-		fmt.Fprintf(&builder, "date: \"%s\"\n", dateString)
-	}
-
-	if len(options.ReferenceFiles) > 0 {
-		builder.WriteString("referencefile:\n")
-		pageLabel := getI18nLabel(options.Language, "page_label")
-		pagesLabel := getI18nLabel(options.Language, "pages_label")
-
-		for _, file := range options.ReferenceFiles {
-			metadataStr := ""
-			if file.PageRange != "" {
-				metadataStr = pagesLabel + " " + file.PageRange
-			} else if file.PageCount > 0 {
-				label := pagesLabel
-				if file.PageCount == 1 {
-					label = pageLabel
-				}
-				metadataStr = fmt.Sprintf("%s 1--%d", label, file.PageCount)
+		metadata.Date = formatLocalizedDate(options.CreationDate, options.Language)
+	}
+
+	pageLabel := getI18nLabel(options.Language, "page_label")
+	pagesLabel := getI18nLabel(options.Language, "pages_label")
+	for _, file := range options.ReferenceFiles {
+		metadataStr := ""
+		if file.PageRange != "" {
+			metadataStr = pagesLabel + " " + file.PageRange
+		} else if file.PageCount > 0 {
+			label := pagesLabel
+			if file.PageCount == 1 {
+				label = pageLabel
 			}
-			fmt.Fprintf(&builder, "  - filename: \"%s\"\n    metadata: \"%s\"\n", file.Filename, metadataStr)
+			metadataStr = fmt.Sprintf("%s 1--%d", label, file.PageCount)
 		}
+		metadata.ReferenceFiles = append(metadata.ReferenceFiles, pandocMetadataFile{Filename: file.Filename, Metadata: metadataStr})
 	}
 
-	if len(options.AudioFiles) > 0 {
-		builder.WriteString("audiofile:\n")
-		for _, file := range options.AudioFiles {
-			durationStr := converter.FormatDuration(file.Duration, options.Language)
-			slog.Debug("Adding audio file to PDF metadata", "filename", file.Filename, "duration_seconds", file.Duration, "duration_formatted", durationStr)
-			fmt.Fprintf(&builder, "  - filename: \"%s\"\n    metadata: \"%s\"\n", file.Filename, durationStr)
-		}
+	for _, file := range options.AudioFiles {
+		durationStr := converter.FormatDuration(file.Duration, options.Language)
+		slog.Debug("Adding audio file to PDF metadata", "filename", file.Filename, "duration_seconds", file.Duration, "duration_formatted", durationStr)
+		metadata.AudioFiles = append(metadata.AudioFiles, pandocMetadataFile{Filename: file.Filename, Metadata: durationStr})
 	}
 
-	yamlContent := builder.String()
+	yamlContent, err := yaml.Marshal(metadata)
+	if err != nil {
+		return fmt.Errorf("failed to encode PDF metadata: %w", err)
+	}
 	slog.Info("Writing PDF metadata YAML", "path", path, "yaml_length", len(yamlContent))
 
-	return os.WriteFile(path, []byte(yamlContent), 0644)
+	return os.WriteFile(path, yamlContent, 0644)
 }
@@ -0,0 +1,67 @@
+package markdown
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+)
+
+// SectionLinker returns the address of a section in the web app from its heading anchor
+type SectionLinker func(anchor string) string
+
+// QRCodeWriter renders the QR code of an address and returns the image path, or "" when there is none
+type QRCodeWriter func(link string) string
+
+// HeadingAnchor returns the identifier Pandoc's GitHub-flavored reader gives a heading, which is also the anchor
+// of the section in the guide rendered by the web app: lowercase letters, digits, hyphens and underscores, with
+// spaces turned into hyphens and everything else dropped
+func HeadingAnchor(title string) string {
+	var builder strings.Builder
+	for _, character := range strings.ToLower(strings.TrimSpace(title)) {
+		switch {
+		case unicode.IsLetter(character) || unicode.IsDigit(character) || character == '-' || character == '_':
+			builder.WriteRune(character)
+		case character == ' ':
+			builder.WriteRune('-')
+		}
+	}
+	return builder.String()
+}
+
+// AddSectionLinks inserts a link to the web app under the heading of every section of the given level, preceded
+// by its QR code when qrCode returns an image. Anchors are made unique across all headings the way Pandoc does,
+// by appending -1, -2 and so on to repeated ones
+func AddSectionLinks(root *Node, level int, language string, sectionLink SectionLinker, qrCode QRCodeWriter) {
+	if root == nil || sectionLink == nil {
+		return
+	}
+
+	usedAnchors := make(map[string]int)
+	var visit func(*Node)
+	visit = func(node *Node) {
+		if node.Type == NodeSection && node.Title != "" {
+			anchor := HeadingAnchor(node.Title)
+			if count, used := usedAnchors[anchor]; used {
+				usedAnchors[anchor] = count + 1
+				anchor = fmt.Sprintf("%s-%d", anchor, count)
+			} else {
+				usedAnchors[anchor] = 1
+			}
+
+			if node.Level == level {
+				link := sectionLink(anchor)
+				content := fmt.Sprintf("[%s](%s)", getI18nLabel(language, "open_in_app"), link)
+				if qrCode != nil {
+					if imagePath := qrCode(link); imagePath != "" {
+						content = fmt.Sprintf(`<img src="%s" alt="QR code" width="72" height="72" /> %s`, imagePath, content)
+					}
+				}
+				node.Children = append([]*Node{{Type: NodeParagraph, Content: content}}, node.Children...)
+			}
+		}
+		for _, child := range node.Children {
+			visit(child)
+		}
+	}
+	visit(root)
+}
@@ -0,0 +1,210 @@
+package markdown
+
+import (
+	"regexp"
+	"strings"
+)
+
+// Operations of the blocks of a diff
+const (
+	DiffEqual   = "equal"
+	DiffAdded   = "added"
+	DiffRemoved = "removed"
+)
+
+// Kinds of the blocks a diff compares
+const (
+	DiffBlockHeading         = "heading"
+	DiffBlockParagraph       = "paragraph"
+	DiffBlockListItem        = "list_item"
+	DiffBlockTable           = "table"
+	DiffBlockQuote           = "quote"
+	DiffBlockCode            = "code"
+	DiffBlockDisplayEquation = "display_equation"
+)
+
+// maximumDiffCells bounds the table compared blocks take once the common start and end are left out; larger
+// changes are reported as the old blocks removed and the new ones added
+const maximumDiffCells = 4_000_000
+
+// DiffBlock is a block of a Markdown document kept, added or removed by a change, with the heading of the
+// section it is in
+type DiffBlock struct {
+	Operation string `json:"operation"`
+	Kind      string `json:"kind"`
+	Section   string `json:"section,omitempty"`
+	Content   string `json:"content"`
+}
+
+var (
+	diffHeadingPattern  = regexp.MustCompile(`^#{1,6}\s+`)
+	diffListItemPattern = regexp.MustCompile(`^\s*([-*+]|\d+[.)])\s+`)
+)
+
+// Diff compares two Markdown documents block by block rather than line by line, so rewrapping a paragraph
+// changes one block: headings, paragraphs, list items (nested ones apart from their parent), tables, quotes,
+// code blocks and display equations. Removed blocks come before the blocks added in their place
+func Diff(previous string, current string) []DiffBlock {
+	previousBlocks := splitDiffBlocks(previous)
+	currentBlocks := splitDiffBlocks(current)
+
+	prefixLength := 0
+	for prefixLength < len(previousBlocks) && prefixLength < len(currentBlocks) &&
+		previousBlocks[prefixLength].Content == currentBlocks[prefixLength].Content {
+		prefixLength++
+	}
+	suffixLength := 0
+	for suffixLength < len(previousBlocks)-prefixLength && suffixLength < len(currentBlocks)-prefixLength &&
+		previousBlocks[len(previousBlocks)-1-suffixLength].Content == currentBlocks[len(currentBlocks)-1-suffixLength].Content {
+		suffixLength++
+	}
+
+	diff := make([]DiffBlock, 0, len(previousBlocks)+len(currentBlocks))
+	for _, block := range currentBlocks[:prefixLength] {
+		block.Operation = DiffEqual
+		diff = append(diff, block)
+	}
+	diff = append(diff, diffChangedBlocks(
+		previousBlocks[prefixLength:len(previousBlocks)-suffixLength],
+		currentBlocks[prefixLength:len(currentBlocks)-suffixLength],
+	)...)
+	for _, block := range currentBlocks[len(currentBlocks)-suffixLength:] {
+		block.Operation = DiffEqual
+		diff = append(diff, block)
+	}
+	return diff
+}
+
+// diffChangedBlocks aligns the blocks between the common start and end of two documents on their longest
+// common subsequence
+func diffChangedBlocks(previousBlocks []DiffBlock, currentBlocks []DiffBlock) []DiffBlock {
+	var diff []DiffBlock
+	appendBlocks := func(blocks []DiffBlock, operation string) {
+		for _, block := range blocks {
+			block.Operation = operation
+			diff = append(diff, block)
+		}
+	}
+	if len(previousBlocks) == 0 || len(currentBlocks) == 0 || len(previousBlocks)*len(currentBlocks) > maximumDiffCells {
+		appendBlocks(previousBlocks, DiffRemoved)
+		appendBlocks(currentBlocks, DiffAdded)
+		return diff
+	}
+
+	// common[i][j] is the length of the longest common subsequence of previousBlocks[i:] and currentBlocks[j:]
+	width := len(currentBlocks) + 1
+	common := make([]int32, (len(previousBlocks)+1)*width)
+	for i := len(previousBlocks) - 1; i >= 0; i-- {
+		for j := len(currentBlocks) - 1; j >= 0; j-- {
+			if previousBlocks[i].Content == currentBlocks[j].Content {
+				common[i*width+j] = common[(i+1)*width+j+1] + 1
+			} else {
+				common[i*width+j] = max(common[(i+1)*width+j], common[i*width+j+1])
+			}
+		}
+	}
+
+	i, j := 0, 0
+	for i < len(previousBlocks) && j < len(currentBlocks) {
+		switch {
+		case previousBlocks[i].Content == currentBlocks[j].Content:
+			appendBlocks(currentBlocks[j:j+1], DiffEqual)
+			i++
+			j++
+		case common[(i+1)*width+j] >= common[i*width+j+1]:
+			appendBlocks(previousBlocks[i:i+1], DiffRemoved)
+			i++
+		default:
+			appendBlocks(currentBlocks[j:j+1], DiffAdded)
+			j++
+		}
+	}
+	appendBlocks(previousBlocks[i:], DiffRemoved)
+	appendBlocks(currentBlocks[j:], DiffAdded)
+	return diff
+}
+
+// splitDiffBlocks cuts a Markdown document into the blocks Diff compares, with trailing spaces trimmed
+func splitDiffBlocks(document string) []DiffBlock {
+	var blocks []DiffBlock
+	var section string
+	var kind string
+	var lines []string
+	flush := func() {
+		if len(lines) > 0 {
+			blocks = append(blocks, DiffBlock{Kind: kind, Section: section, Content: strings.Join(lines, "\n")})
+		}
+		kind, lines = "", nil
+	}
+
+	documentLines := strings.Split(strings.ReplaceAll(document, "\r\n", "\n"), "\n")
+	for index := 0; index < len(documentLines); index++ {
+		line := strings.TrimRight(documentLines[index], " \t")
+		trimmed := strings.TrimSpace(line)
+
+		switch {
+		case trimmed == "":
+			flush()
+
+		case strings.HasPrefix(trimmed, "```") || strings.HasPrefix(trimmed, "~~~"):
+			// Code blocks are kept whole, blank lines included, up to their closing fence
+			flush()
+			fence := trimmed[:3]
+			kind, lines = DiffBlockCode, []string{line}
+			for index+1 < len(documentLines) {
+				index++
+				lines = append(lines, strings.TrimRight(documentLines[index], " \t"))
+				if strings.HasPrefix(strings.TrimSpace(documentLines[index]), fence) {
+					break
+				}
+			}
+			flush()
+
+		case strings.HasPrefix(trimmed, "$$") && kind != DiffBlockParagraph:
+			// Display equations are kept whole up to the line closing them
+			flush()
+			kind, lines = DiffBlockDisplayEquation, []string{line}
+			closed := len(trimmed) > 2 && strings.HasSuffix(trimmed, "$$")
+			for !closed && index+1 < len(documentLines) {
+				index++
+				lines = append(lines, strings.TrimRight(documentLines[index], " \t"))
+				closed = strings.HasSuffix(strings.TrimSpace(documentLines[index]), "$$")
+			}
+			flush()
+
+		case diffHeadingPattern.MatchString(trimmed):
+			flush()
+			section = strings.TrimSpace(strings.TrimLeft(trimmed, "#"))
+			kind, lines = DiffBlockHeading, []string{line}
+			flush()
+
+		case diffListItemPattern.MatchString(line):
+			flush()
+			kind, lines = DiffBlockListItem, []string{line}
+
+		case strings.HasPrefix(trimmed, "|"):
+			if kind != DiffBlockTable {
+				flush()
+				kind = DiffBlockTable
+			}
+			lines = append(lines, line)
+
+		case strings.HasPrefix(trimmed, ">"):
+			if kind != DiffBlockQuote {
+				flush()
+				kind = DiffBlockQuote
+			}
+			lines = append(lines, line)
+
+		default:
+			// Lines following a list item continue it, as they do in a paragraph
+			if kind != DiffBlockParagraph && kind != DiffBlockListItem {
+				flush()
+				kind = DiffBlockParagraph
+			}
+			lines = append(lines, line)
+		}
+	}
+	flush()
+	return blocks
+}
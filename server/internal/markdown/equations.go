@@ -0,0 +1,133 @@
+package markdown
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+var (
+	inlineEquationRegex = regexp.MustCompile(`\$\$([^$]+)\$\$|\$([^$]+)\$`)
+	environmentRegex    = regexp.MustCompile(`\\(begin|end)\{([^}]*)\}`)
+	leftDelimiterRegex  = regexp.MustCompile(`\\left\b`)
+	rightDelimiterRegex = regexp.MustCompile(`\\right\b`)
+	textCommandRegex    = regexp.MustCompile(`\\[a-zA-Z]+`)
+)
+
+// ValidateEquations parses markdown the way documents are rendered and reports the equations that would not
+// render: unbalanced braces, \begin without a matching \end, \left without \right, chemistry macros, math
+// delimiters left open and LaTeX commands written outside math. An empty result means every equation parsed
+func ValidateEquations(markdownText string) []string {
+	var issues []string
+	seen := make(map[string]bool)
+	report := func(issue string) {
+		if !seen[issue] {
+			seen[issue] = true
+			issues = append(issues, issue)
+		}
+	}
+
+	var visit func(node *Node)
+	visit = func(node *Node) {
+		switch node.Type {
+		case NodeDisplayEquation, NodeInlineMath:
+			validateEquation(node.Content, report)
+		case NodeCodeBlock:
+			// Code is shown verbatim
+		default:
+			validateTextMath(node.Content, report)
+			validateTextMath(node.Title, report)
+			for _, row := range node.Rows {
+				for _, cell := range row.Cells {
+					validateTextMath(cell, report)
+				}
+			}
+		}
+		for _, child := range node.Children {
+			visit(child)
+		}
+	}
+	visit(NewParser().Parse(markdownText))
+
+	return issues
+}
+
+// validateTextMath checks the equations embedded in text, such as list items and table cells, and what is
+// left of the text once they are removed
+func validateTextMath(text string, report func(string)) {
+	if text == "" {
+		return
+	}
+	for _, match := range inlineEquationRegex.FindAllStringSubmatch(text, -1) {
+		validateEquation(match[1]+match[2], report)
+	}
+
+	remainder := inlineEquationRegex.ReplaceAllString(text, "")
+	if strings.Contains(remainder, "$$") {
+		report(fmt.Sprintf("display equation is not closed: %s", excerpt(text)))
+	}
+	for _, delimiter := range []string{`\(`, `\)`, `\[`, `\]`} {
+		if strings.Contains(remainder, delimiter) {
+			report(fmt.Sprintf("math delimiter %s has no counterpart: %s", delimiter, excerpt(text)))
+		}
+	}
+	if command := textCommandRegex.FindString(remainder); command != "" {
+		report(fmt.Sprintf("LaTeX command %s is outside math delimiters: %s", command, excerpt(text)))
+	}
+}
+
+// validateEquation checks that a LaTeX expression is well formed enough for KaTeX and Pandoc to render it
+func validateEquation(equation string, report func(string)) {
+	equation = strings.TrimSpace(equation)
+	if equation == "" {
+		report("empty equation")
+		return
+	}
+
+	depth := 0
+	for index := 0; index < len(equation) && depth >= 0; index++ {
+		switch equation[index] {
+		case '\\':
+			index++ // Skip the escaped character, e.g. \{ or \}
+		case '{':
+			depth++
+		case '}':
+			depth--
+		}
+	}
+	if depth != 0 {
+		report(fmt.Sprintf("unbalanced braces in %s", excerpt(equation)))
+	}
+
+	var environments []string
+	for _, match := range environmentRegex.FindAllStringSubmatch(equation, -1) {
+		if match[1] == "begin" {
+			environments = append(environments, match[2])
+			continue
+		}
+		if len(environments) == 0 || environments[len(environments)-1] != match[2] {
+			report(fmt.Sprintf("\\end{%s} does not close an open environment in %s", match[2], excerpt(equation)))
+			return
+		}
+		environments = environments[:len(environments)-1]
+	}
+	if len(environments) > 0 {
+		report(fmt.Sprintf("\\begin{%s} is never closed in %s", environments[len(environments)-1], excerpt(equation)))
+	}
+
+	if len(leftDelimiterRegex.FindAllString(equation, -1)) != len(rightDelimiterRegex.FindAllString(equation, -1)) {
+		report(fmt.Sprintf("\\left and \\right do not pair up in %s", excerpt(equation)))
+	}
+	if strings.Contains(equation, `\ce{`) {
+		report(fmt.Sprintf("\\ce cannot be rendered in %s", excerpt(equation)))
+	}
+}
+
+// excerpt shortens text quoted in an issue
+func excerpt(text string) string {
+	text = strings.Join(strings.Fields(text), " ")
+	if runes := []rune(text); len(runes) > 60 {
+		return string(runes[:60]) + "…"
+	}
+	return text
+}
@@ -53,6 +53,14 @@ var i18nMap = map[string]map[string]string{
 		"second_label":    "s",
 		"date_label":      "Date",
 		"course_label":    "Course",
+		"open_in_app":     "Open in the app",
+		"answer_key":      "Answers",
+		"points_label":    "points",
+		"concepts_label":  "Concepts",
+		"duration_label":  "Time allowed",
+		"minutes_label":   "minutes",
+		"total_label":     "Total",
+		"lecture_label":   "Lecture",
 	},
 	"tr": {
 		"abstract":        "özet",
@@ -65,6 +73,14 @@ var i18nMap = map[string]map[string]string{
 		"second_label":    "sn",
 		"date_label":      "Tarih",
 		"course_label":    "Ders",
+		"open_in_app":     "Uygulamada aç",
+		"answer_key":      "Cevaplar",
+		"points_label":    "puan",
+		"concepts_label":  "Kavramlar",
+		"duration_label":  "Süre",
+		"minutes_label":   "dakika",
+		"total_label":     "Toplam",
+		"lecture_label":   "Ders",
 	},
 	"it": {
 		"abstract":        "sommario",
@@ -77,6 +93,14 @@ var i18nMap = map[string]map[string]string{
 		"second_label":    "s",
 		"date_label":      "Data",
 		"course_label":    "Corso",
+		"open_in_app":     "Apri nell'app",
+		"answer_key":      "Soluzioni",
+		"points_label":    "punti",
+		"concepts_label":  "Concetti",
+		"duration_label":  "Tempo a disposizione",
+		"minutes_label":   "minuti",
+		"total_label":     "Totale",
+		"lecture_label":   "Lezione",
 	},
 	"es": {
 		"abstract":        "resumen",
@@ -89,6 +113,14 @@ var i18nMap = map[string]map[string]string{
 		"second_label":    "s",
 		"date_label":      "Fecha",
 		"course_label":    "Curso",
+		"open_in_app":     "Abrir en la app",
+		"answer_key":      "Respuestas",
+		"points_label":    "puntos",
+		"concepts_label":  "Conceptos",
+		"duration_label":  "Tiempo disponible",
+		"minutes_label":   "minutos",
+		"total_label":     "Total",
+		"lecture_label":   "Clase",
 	},
 	"fr": {
 		"abstract":        "résumé",
@@ -101,6 +133,14 @@ var i18nMap = map[string]map[string]string{
 		"second_label":    "s",
 		"date_label":      "Date",
 		"course_label":    "Cours",
+		"open_in_app":     "Ouvrir dans l'application",
+		"answer_key":      "Réponses",
+		"points_label":    "points",
+		"concepts_label":  "Concepts",
+		"duration_label":  "Durée",
+		"minutes_label":   "minutes",
+		"total_label":     "Total",
+		"lecture_label":   "Cours",
 	},
 	"de": {
 		"abstract":        "Zusammenfassung",
@@ -113,6 +153,14 @@ var i18nMap = map[string]map[string]string{
 		"second_label":    "Sek.",
 		"date_label":      "Datum",
 		"course_label":    "Kurs",
+		"open_in_app":     "In der App öffnen",
+		"answer_key":      "Lösungen",
+		"points_label":    "Punkte",
+		"concepts_label":  "Begriffe",
+		"duration_label":  "Bearbeitungszeit",
+		"minutes_label":   "Minuten",
+		"total_label":     "Gesamt",
+		"lecture_label":   "Vorlesung",
 	},
 	"pt": {
 		"abstract":        "resumo",
@@ -125,6 +173,14 @@ var i18nMap = map[string]map[string]string{
 		"second_label":    "s",
 		"date_label":      "Data",
 		"course_label":    "Curso",
+		"open_in_app":     "Abrir no aplicativo",
+		"answer_key":      "Respostas",
+		"points_label":    "pontos",
+		"concepts_label":  "Conceitos",
+		"duration_label":  "Tempo disponível",
+		"minutes_label":   "minutos",
+		"total_label":     "Total",
+		"lecture_label":   "Aula",
 	},
 }
 
@@ -1,8 +1,21 @@
 package markdown
 
 import (
+	"archive/zip"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"image"
+	"image/png"
+	"io"
+	"os"
+	"path/filepath"
 	"strings"
 	"testing"
+
+	"lectures/internal/models"
+
+	"gopkg.in/yaml.v3"
 )
 
 func TestMarkdownRoundTrip(tester *testing.T) {
@@ -1951,3 +1964,400 @@ func TestPunctuationSpacing(t *testing.T) {
 		})
 	}
 }
+
+func TestResolveCitationFilename(tester *testing.T) {
+	documentNames := []string{"Lecture_1_Slides.pdf", "Lecture_2_Slides.pdf", "Exercise_Sheet.pdf", "notes.docx"}
+
+	testCases := []struct {
+		name         string
+		cited        string
+		expectedName string
+	}{
+		{name: "Exact name", cited: "Exercise_Sheet.pdf", expectedName: "Exercise_Sheet.pdf"},
+		{name: "Case and separators", cited: "lecture-1 slides.PDF", expectedName: "Lecture_1_Slides.pdf"},
+		{name: "Typo", cited: "Excercise_Shet.pdf", expectedName: "Exercise_Sheet.pdf"},
+		{name: "Closest numbered document", cited: "Lecture_2_Slide.pdf", expectedName: "Lecture_2_Slides.pdf"},
+		{name: "Unrelated file", cited: "Syllabus.pdf", expectedName: ""},
+		{name: "Empty citation", cited: "unknown", expectedName: ""},
+	}
+
+	for _, testCase := range testCases {
+		tester.Run(testCase.name, func(tester *testing.T) {
+			if resolved := ResolveCitationFilename(testCase.cited, documentNames); resolved != testCase.expectedName {
+				tester.Errorf("Expected %q to resolve to %q, got %q", testCase.cited, testCase.expectedName, resolved)
+			}
+		})
+	}
+}
+
+func TestCorrectCitationFilenames(tester *testing.T) {
+	reconstructor := NewReconstructor()
+	_, citations := reconstructor.ParseCitations("Entropy grows {{{Second law-thermodynamics slides.PDF-p4}}} and so on {{{Other source-syllabus.pdf-p1}}}")
+
+	CorrectCitationFilenames(citations, []string{"Thermodynamics_Slides.pdf"})
+	if citations[0].File != "Thermodynamics_Slides.pdf" {
+		tester.Errorf("Expected the mangled filename to be corrected, got %q", citations[0].File)
+	}
+	if citations[1].File != "syllabus.pdf" {
+		tester.Errorf("Expected an unmatched filename to be kept, got %q", citations[1].File)
+	}
+}
+
+func TestEmbedLocalAssets(tester *testing.T) {
+	baseDirectory := tester.TempDir()
+	pngData := []byte("\x89PNG\r\n\x1a\nfake")
+	if err := os.WriteFile(filepath.Join(baseDirectory, "page_1.png"), pngData, 0644); err != nil {
+		tester.Fatalf("Failed to write image: %v", err)
+	}
+	content := "<figure>\n  <img src=\"page_1.png\" alt=\"\" />\n</figure>\n\n![](page_1.png)\n\n![](data:image/png;base64,AAAA)"
+
+	inlined, err := EmbedLocalAssets(content, baseDirectory, true)
+	if err != nil {
+		tester.Fatalf("EmbedLocalAssets failed: %v", err)
+	}
+	if strings.Count(inlined, "data:image/png;base64,") != 3 || strings.Contains(inlined, "page_1.png") {
+		tester.Errorf("Expected every image to be inlined, got %q", inlined)
+	}
+
+	packaged, err := EmbedLocalAssets(content, baseDirectory, false)
+	if err != nil {
+		tester.Fatalf("EmbedLocalAssets failed: %v", err)
+	}
+	if strings.Count(packaged, filepath.Join(baseDirectory, "page_1.png")) != 2 {
+		tester.Errorf("Expected local images to be rewritten to absolute paths, got %q", packaged)
+	}
+
+	_, err = EmbedLocalAssets(content+"\n\n![](missing.png)\n\n<img src=\"https://example.com/a.png\">\n\n![](missing.png)", baseDirectory, true)
+	var assetsError *UnresolvedAssetsError
+	if !errors.As(err, &assetsError) {
+		tester.Fatalf("Expected an UnresolvedAssetsError, got %v", err)
+	}
+	if len(assetsError.Assets) != 2 || assetsError.Assets[0].Source != "missing.png" || assetsError.Assets[1].Source != "https://example.com/a.png" {
+		tester.Errorf("Expected each unresolved asset to be listed once, got %+v", assetsError.Assets)
+	}
+	if !strings.Contains(err.Error(), "missing.png (file not found)") {
+		tester.Errorf("Expected the error to name the asset and the reason, got %q", err.Error())
+	}
+}
+
+func TestValidateEquations(tester *testing.T) {
+	wellFormed := "The energy is \\(E = mc^2\\), and\n\n\\[\n\\int_0^1 \\frac{1}{x} \\, dx\n\\]\n\n- Ordered pairs \\(\\left( a, b \\right)\\) and arrows \\(x \\leftarrow y\\)\n\n| Quantity | Value |\n|---|---|\n| \\(v^2\\) | $50 |"
+	if issues := ValidateEquations(wellFormed); len(issues) != 0 {
+		tester.Errorf("Expected no issues, got %q", issues)
+	}
+
+	testCases := []struct {
+		name     string
+		markdown string
+		expected string
+	}{
+		{"unbalanced braces", "Ratio \\(\\frac{a}{b\\) here", "unbalanced braces"},
+		{"unclosed display", "Display\n\n$$\nx = 1\n", "display equation is not closed"},
+		{"command outside math", "The angle \\alpha is small", "LaTeX command \\alpha is outside math delimiters"},
+		{"mismatched environment", "\\[\\begin{pmatrix} a \\end{bmatrix}\\]", "\\end{bmatrix} does not close an open environment"},
+		{"unpaired left", "- Item \\(\\left( x\\)", "\\left and \\right do not pair up"},
+		{"chemistry macro", "Water is \\(\\ce{H2O}\\)", "\\ce cannot be rendered"},
+	}
+	for _, testCase := range testCases {
+		issues := ValidateEquations(testCase.markdown)
+		if len(issues) != 1 || !strings.Contains(issues[0], testCase.expected) {
+			tester.Errorf("%s: expected an issue containing %q, got %q", testCase.name, testCase.expected, issues)
+		}
+	}
+}
+
+func TestAddSectionLinks(tester *testing.T) {
+	if anchor := HeadingAnchor("  Cell Division: Mitosis & Meiosis "); anchor != "cell-division-mitosis--meiosis" {
+		tester.Errorf("Unexpected anchor %q", anchor)
+	}
+
+	ast := NewParser().Parse("# Guide\n\n## Overview\n\nIntro.\n\n### Details\n\nMore.\n\n## Overview\n\nAgain.")
+	AddSectionLinks(ast, 2, "it", func(anchor string) string {
+		return "https://app.example/tools/t1#" + anchor
+	}, func(link string) string {
+		if strings.HasSuffix(link, "-1") {
+			return "/tmp/qr.png"
+		}
+		return ""
+	})
+	result := NewReconstructor().Reconstruct(ast)
+
+	if !strings.Contains(result, "[Apri nell'app](https://app.example/tools/t1#overview)") {
+		tester.Errorf("Expected a link to the first section, got:\n%s", result)
+	}
+	if !strings.Contains(result, `<img src="/tmp/qr.png" alt="QR code" width="72" height="72" /> [Apri nell'app](https://app.example/tools/t1#overview-1)`) {
+		tester.Errorf("Expected the repeated heading to get a unique anchor and its QR code, got:\n%s", result)
+	}
+	if strings.Contains(result, "#details") {
+		tester.Errorf("Expected only level 2 sections to be linked, got:\n%s", result)
+	}
+}
+
+func TestCropPageImage(tester *testing.T) {
+	directory := tester.TempDir()
+	pagePath := filepath.Join(directory, "page.png")
+	pageFile, _ := os.Create(pagePath)
+	png.Encode(pageFile, image.NewRGBA(image.Rect(0, 0, 200, 400)))
+	pageFile.Close()
+
+	croppedPath := filepath.Join(directory, "cropped.png")
+	if err := CropPageImage(pagePath, PageRegion{Page: 1, Left: 0.25, Top: 0.5, Width: 0.5, Height: 0.25}, croppedPath); err != nil {
+		tester.Fatalf("Crop failed: %v", err)
+	}
+	croppedFile, _ := os.Open(croppedPath)
+	defer croppedFile.Close()
+	configuration, err := png.DecodeConfig(croppedFile)
+	if err != nil {
+		tester.Fatalf("Cropped image is not a PNG: %v", err)
+	}
+	// The region is widened by the margin on every side
+	if configuration.Width != 108 || configuration.Height != 116 {
+		tester.Errorf("Expected a 108x116 crop, got %dx%d", configuration.Width, configuration.Height)
+	}
+
+	if err := CropPageImage(pagePath, PageRegion{Page: 1, Left: 0.5, Top: 0, Width: 0.8, Height: 0.5}, croppedPath); err == nil {
+		tester.Errorf("Expected a region outside the page to be rejected")
+	}
+
+	union := PageRegion{Page: 2, Left: 0.25, Top: 0.25, Width: 0.25, Height: 0.25}.Union(PageRegion{Page: 2, Left: 0.5, Top: 0.125, Width: 0.25, Height: 0.125})
+	if union.Left != 0.25 || union.Top != 0.125 || union.Width != 0.5 || union.Height != 0.375 {
+		tester.Errorf("Unexpected union %+v", union)
+	}
+}
+
+func TestQuizToMarkdown(tester *testing.T) {
+	quiz := `[
+		{"question": "Which organelle makes ATP?", "options": ["Nucleus", "Ribosome", "Mitochondria", "Golgi"], "correct_answer": "Mitochondria", "explanation": "The powerhouse of the cell."},
+		{"type": "ordering", "question": "Order the stages.", "items": ["Prophase", "Metaphase", "Anaphase"], "explanation": "P, M, A."},
+		{"type": "numeric", "question": "What is g?", "answer": 9.81, "tolerance": 0.05, "unit": "m/s^2", "explanation": "Standard gravity."},
+		{"type": "free_response", "question": "Why?", "correct_answer": "Because.", "rubric": [{"criterion": "Names the cause", "points": 2}], "explanation": "E."}
+	]`
+
+	rendered := QuizToMarkdown("Cells", quiz, "it")
+	for _, expected := range []string{
+		"# Cells", "## 1. Which organelle makes ATP?", "C. Mitochondria\nD. Golgi",
+		"- Anaphase\n- Metaphase\n- Prophase",
+		"# Soluzioni", "1. C. Mitochondria", "2. Prophase → Metaphase → Anaphase", "3. 9.81 ± 0.05 m/s^2", "- Names the cause (2 punti)",
+	} {
+		if !strings.Contains(rendered, expected) {
+			tester.Errorf("Expected %q in the rendered quiz:\n%s", expected, rendered)
+		}
+	}
+}
+
+func TestMindmapToMarkdown(tester *testing.T) {
+	mindmap := `{
+		"nodes": [{"id": "optics", "label": "Optics", "description": "The study of light."}, {"id": "snell", "label": "Snell \"law\"", "description": ""}],
+		"edges": [{"from": "optics", "to": "snell", "label": "explains refraction with"}, {"from": "snell", "to": "missing", "label": "dangling"}]
+	}`
+
+	diagram, err := MindmapToMermaid(mindmap)
+	if err != nil {
+		tester.Fatalf("MindmapToMermaid failed: %v", err)
+	}
+	for _, expected := range []string{"flowchart TD\n", `n1["Optics"]`, `n2["Snell #quot;law#quot;"]`, `n1 -->|"explains refraction with"| n2`} {
+		if !strings.Contains(diagram, expected) {
+			tester.Errorf("Expected %q in the diagram:\n%s", expected, diagram)
+		}
+	}
+	if strings.Contains(diagram, "dangling") {
+		tester.Errorf("Expected relations to unknown concepts left out:\n%s", diagram)
+	}
+
+	rendered := MindmapToMarkdown("Optics", mindmap, "it")
+	for _, expected := range []string{"# Optics\n\n```mermaid\nflowchart TD", "## Concetti", "- **Optics**: The study of light.\n  - explains refraction with → Snell \"law\""} {
+		if !strings.Contains(rendered, expected) {
+			tester.Errorf("Expected %q in the rendered mindmap:\n%s", expected, rendered)
+		}
+	}
+}
+
+func TestMockExamToMarkdown(tester *testing.T) {
+	mockExam := `{"duration_minutes": 60, "total_points": 10, "questions": [
+		{"type": "multiple_choice", "difficulty": "easy", "points": 4, "lecture": "Optics", "question": "What refracts?", "options": ["Light", "Sound", "Heat", "Mass"], "correct_answer": "Light", "explanation": "Snell's law."},
+		{"type": "problem", "difficulty": "hard", "points": 6, "lecture": "Acoustics", "question": "Compute the echo delay.", "correct_answer": "$t = 2d/v$"}
+	]}`
+
+	rendered := MockExamToMarkdown("Physics", mockExam, "en")
+	for _, expected := range []string{
+		"# Physics", "**Time allowed:** 60 minutes · **Total:** 10 points", "## 1. (4 points)\n\nWhat refracts?\n\nA. Light\nB. Sound",
+		"## 2. (6 points)\n\nCompute the echo delay.", "# Answers", "A. Light\n\nSnell's law.\n\n*Lecture: Optics*", "$t = 2d/v$\n\n*Lecture: Acoustics*",
+	} {
+		if !strings.Contains(rendered, expected) {
+			tester.Errorf("Expected %q in the rendered mock exam:\n%s", expected, rendered)
+		}
+	}
+	if strings.Index(rendered, "$t = 2d/v$") < strings.Index(rendered, "# Answers") {
+		tester.Errorf("Expected the answers after the questions:\n%s", rendered)
+	}
+}
+
+func TestDiffComparesBlocks(tester *testing.T) {
+	previous := "# Optics\n\n## Lenses\n\nA lens bends\nlight.\n\n- Convex\n- Concave\n\n$$\nf = 1/d\n$$\n\n## Mirrors\n\nMirrors reflect light.\n"
+	current := "# Optics\n\n## Lenses\n\nA lens bends\nlight.\n\n- Convex\n- Concave\n  - Diverging\n\n$$\nf = 1/d\n$$\n\n## Mirrors\n\nMirrors reflect most of the light.\n"
+
+	var changes []DiffBlock
+	for _, block := range Diff(previous, current) {
+		if block.Operation != DiffEqual {
+			changes = append(changes, block)
+		}
+	}
+	expected := []DiffBlock{
+		{Operation: DiffAdded, Kind: DiffBlockListItem, Section: "Lenses", Content: "  - Diverging"},
+		{Operation: DiffRemoved, Kind: DiffBlockParagraph, Section: "Mirrors", Content: "Mirrors reflect light."},
+		{Operation: DiffAdded, Kind: DiffBlockParagraph, Section: "Mirrors", Content: "Mirrors reflect most of the light."},
+	}
+	if len(changes) != len(expected) {
+		tester.Fatalf("Expected %d changed blocks, got %+v", len(expected), changes)
+	}
+	for index := range expected {
+		if changes[index] != expected[index] {
+			tester.Errorf("Change %d: expected %+v, got %+v", index, expected[index], changes[index])
+		}
+	}
+
+	if blocks := Diff(previous, previous); len(blocks) != 8 || blocks[5].Kind != DiffBlockDisplayEquation {
+		tester.Errorf("Expected 8 unchanged blocks with the equation kept whole, got %+v", blocks)
+	}
+}
+
+func TestReplaceSection(tester *testing.T) {
+	document := "# Optics\n\n## 1. Lenses\n\nOld lens text.\n\n### Thin lenses\n\nOld thin lens text.\n\n```\n## Not a heading\n```\n\n## 2. Mirrors\n\nMirror text.\n\n### Thin lenses\n\nUnrelated.\n"
+
+	replaced, err := ReplaceSection(document, []string{"lenses", "Thin lenses"}, "New thin lens text.", "")
+	if err != nil {
+		tester.Fatalf("ReplaceSection failed: %v", err)
+	}
+	expected := "# Optics\n\n## 1. Lenses\n\nOld lens text.\n\n### Thin lenses\n\nNew thin lens text.\n\n## 2. Mirrors\n\nMirror text.\n\n### Thin lenses\n\nUnrelated.\n"
+	if replaced != expected {
+		tester.Errorf("Expected the nested section replaced up to the next section:\n%q\ngot:\n%q", expected, replaced)
+	}
+
+	if _, err := ReplaceSection(document, []string{"Thin lenses"}, "Text.", ""); err == nil || !strings.Contains(err.Error(), "2 sections") {
+		tester.Errorf("Expected an ambiguous heading path refused, got %v", err)
+	}
+	if _, err := ReplaceSection(document, []string{"Not a heading"}, "Text.", ""); err == nil {
+		tester.Error("Expected headings inside code blocks to be ignored")
+	}
+
+	renamed, err := ReplaceSection(document, []string{"Optics", "Mirrors"}, "", "Plane mirrors")
+	if err != nil || !strings.HasSuffix(renamed, "\n\n## Plane mirrors\n") {
+		tester.Errorf("Expected the last section emptied and renamed, got %q (%v)", renamed, err)
+	}
+}
+
+func TestFlashcardsToAnkiPackage(tester *testing.T) {
+	directory := tester.TempDir()
+	imagePath := filepath.Join(directory, "card.png")
+	imageFile, _ := os.Create(imagePath)
+	png.Encode(imageFile, image.NewRGBA(image.Rect(0, 0, 4, 4)))
+	imageFile.Close()
+
+	flashcards := `[{"front": "What is $E$?", "back": "Energy:\n$$E = mc^2$$", "image": "` + imagePath + `"}, {"front": "Is 1 < 2?", "back": "Yes"}]`
+	outputPath := filepath.Join(directory, "deck.apkg")
+	options := AnkiPackageOptions{ExamTitle: "Modern Physics", LectureTitle: "Relativity", DeckID: "tool-1"}
+	if err := FlashcardsToAnkiPackage(flashcards, options, outputPath); err != nil {
+		tester.Fatalf("Failed to build the package: %v", err)
+	}
+
+	archive, err := zip.OpenReader(outputPath)
+	if err != nil {
+		tester.Fatalf("Failed to open the package: %v", err)
+	}
+	defer archive.Close()
+	entries := make(map[string]*zip.File)
+	for _, entry := range archive.File {
+		entries[entry.Name] = entry
+	}
+	for _, name := range []string{"collection.anki2", "media", "0"} {
+		if entries[name] == nil {
+			tester.Fatalf("Expected %q in the package", name)
+		}
+	}
+
+	mediaReader, _ := entries["media"].Open()
+	var manifest map[string]string
+	json.NewDecoder(mediaReader).Decode(&manifest)
+	mediaReader.Close()
+	if !strings.HasSuffix(manifest["0"], ".png") {
+		tester.Errorf("Expected the image in the media manifest, got %v", manifest)
+	}
+
+	collectionReader, _ := entries["collection.anki2"].Open()
+	collectionPath := filepath.Join(directory, "collection.anki2")
+	collectionFile, _ := os.Create(collectionPath)
+	io.Copy(collectionFile, collectionReader)
+	collectionFile.Close()
+	collectionReader.Close()
+
+	collection, err := sql.Open("sqlite", collectionPath)
+	if err != nil {
+		tester.Fatalf("Failed to open the collection: %v", err)
+	}
+	defer collection.Close()
+
+	var decksJSON string
+	collection.QueryRow("SELECT decks FROM col").Scan(&decksJSON)
+	if !strings.Contains(decksJSON, `"name":"Modern Physics::Relativity"`) {
+		tester.Errorf("Expected a deck for the lecture under its exam, got %s", decksJSON)
+	}
+
+	rows, err := collection.Query("SELECT notes.flds, notes.tags, cards.did FROM notes JOIN cards ON cards.nid = notes.id ORDER BY cards.due")
+	if err != nil {
+		tester.Fatalf("Failed to query the notes: %v", err)
+	}
+	defer rows.Close()
+	var fields []string
+	for rows.Next() {
+		var noteFields, tags string
+		var deckID int64
+		rows.Scan(&noteFields, &tags, &deckID)
+		if tags != " Modern_Physics Relativity " {
+			tester.Errorf("Expected the exam and lecture tags, got %q", tags)
+		}
+		if deckID != ankiIdentifier("deck:Modern Physics::Relativity") {
+			tester.Errorf("Expected the card in the lecture deck, got %d", deckID)
+		}
+		fields = append(fields, noteFields)
+	}
+	if len(fields) != 2 {
+		tester.Fatalf("Expected 2 notes, got %d", len(fields))
+	}
+	expectedFirst := `What is \(E\)?` + "\x1f" + `Energy:<br>\[E = mc^2\]<br><img src="` + manifest["0"] + `">`
+	if fields[0] != expectedFirst {
+		tester.Errorf("Expected %q, got %q", expectedFirst, fields[0])
+	}
+	if fields[1] != "Is 1 &lt; 2?\x1fYes" {
+		tester.Errorf("Expected escaped HTML, got %q", fields[1])
+	}
+}
+
+func TestWriteMetadataFileEscapesValues(tester *testing.T) {
+	converter := NewConverter("test_data", "").(*ExternalConverter)
+	metadataPath := filepath.Join(tester.TempDir(), "metadata.yaml")
+	err := converter.writeMetadataFile(metadataPath, ConversionOptions{
+		Language:       "en",
+		CourseTitle:    `Signals "and" Systems`,
+		Description:    "Sampling\nwith \\alpha and C:\\Users",
+		MetadataFields: []models.MetadataField{{Name: "Room", Value: `B\12: "north"`}},
+		ReferenceFiles: []ReferenceFileMetadata{{Filename: `notes\draft.pdf`, PageCount: 2}},
+	})
+	if err != nil {
+		tester.Fatalf("writeMetadataFile failed: %v", err)
+	}
+
+	content, _ := os.ReadFile(metadataPath)
+	var metadata pandocMetadata
+	if err := yaml.Unmarshal(content, &metadata); err != nil {
+		tester.Fatalf("Expected valid YAML, got %v:\n%s", err, content)
+	}
+	if metadata.CourseTitle != `Signals "and" Systems` || metadata.Abstract != "Sampling\nwith \\alpha and C:\\Users" {
+		tester.Errorf("Expected the title and description kept, got %+v", metadata)
+	}
+	if len(metadata.MetadataFields) != 1 || metadata.MetadataFields[0].Value != `B\12: "north"` {
+		tester.Errorf("Expected the metadata field kept, got %+v", metadata.MetadataFields)
+	}
+	if len(metadata.ReferenceFiles) != 1 || metadata.ReferenceFiles[0].Filename != `notes\draft.pdf` {
+		tester.Errorf("Expected the reference file kept, got %+v", metadata.ReferenceFiles)
+	}
+}
@@ -0,0 +1,136 @@
+package markdown
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"lectures/internal/media"
+	"lectures/internal/models"
+)
+
+var mermaidBlockPattern = regexp.MustCompile("(?ms)^```mermaid[ \t]*\n(.*?)\n```[ \t]*$")
+
+// mermaidLabelEscaper keeps labels from closing the quoted strings they are written in, using the entity codes
+// Mermaid decodes
+var mermaidLabelEscaper = strings.NewReplacer(`"`, "#quot;", "\n", " ", "\r", "")
+
+// MindmapToMermaid renders mindmap JSON as a Mermaid flowchart, a box per concept and an arrow per relation.
+// Nodes are renamed n1, n2... in their order, since the identifiers chosen by the model need not be valid
+// Mermaid names
+func MindmapToMermaid(toolContent string) (string, error) {
+	var mindmap models.Mindmap
+	if err := json.Unmarshal([]byte(toolContent), &mindmap); err != nil {
+		return "", fmt.Errorf("invalid mindmap: %w", err)
+	}
+
+	var builder strings.Builder
+	builder.WriteString("flowchart TD\n")
+	mermaidNames := make(map[string]string, len(mindmap.Nodes))
+	for index, node := range mindmap.Nodes {
+		mermaidNames[node.ID] = fmt.Sprintf("n%d", index+1)
+		fmt.Fprintf(&builder, "    %s[\"%s\"]\n", mermaidNames[node.ID], mermaidLabelEscaper.Replace(node.Label))
+	}
+	for _, edge := range mindmap.Edges {
+		from, to := mermaidNames[edge.From], mermaidNames[edge.To]
+		if from == "" || to == "" {
+			continue
+		}
+		if edge.Label == "" {
+			fmt.Fprintf(&builder, "    %s --> %s\n", from, to)
+			continue
+		}
+		fmt.Fprintf(&builder, "    %s -->|\"%s\"| %s\n", from, mermaidLabelEscaper.Replace(edge.Label), to)
+	}
+	return builder.String(), nil
+}
+
+// MindmapToMarkdown renders mindmap JSON as a Markdown document: the diagram as a Mermaid code block, which the
+// converter renders as an image, then each concept with its description and the relations it starts, so the
+// map still reads where the diagram is not shown
+func MindmapToMarkdown(title string, toolContent string, language string) string {
+	var mindmap models.Mindmap
+	if err := json.Unmarshal([]byte(toolContent), &mindmap); err != nil {
+		return toolContent
+	}
+	diagram, err := MindmapToMermaid(toolContent)
+	if err != nil {
+		return toolContent
+	}
+
+	labels := make(map[string]string, len(mindmap.Nodes))
+	for _, node := range mindmap.Nodes {
+		labels[node.ID] = node.Label
+	}
+
+	var builder strings.Builder
+	fmt.Fprintf(&builder, "# %s\n\n```mermaid\n%s```\n\n", title, diagram)
+	fmt.Fprintf(&builder, "## %s\n\n", getI18nLabel(language, "concepts_label"))
+	for _, node := range mindmap.Nodes {
+		fmt.Fprintf(&builder, "- **%s**", node.Label)
+		if node.Description != "" {
+			fmt.Fprintf(&builder, ": %s", strings.ReplaceAll(node.Description, "\n", " "))
+		}
+		builder.WriteString("\n")
+		for _, edge := range mindmap.Edges {
+			if edge.From == node.ID {
+				fmt.Fprintf(&builder, "  - %s → %s\n", strings.TrimSpace(edge.Label), labels[edge.To])
+			}
+		}
+	}
+	return builder.String()
+}
+
+// renderMermaidDiagrams replaces the Mermaid code blocks of a document with PNG images rendered by mermaid-cli
+// (mmdc), embedded as data URIs so the HTML and every format converted from it carry them. Blocks are left as
+// code when mmdc is not installed or fails to render them, so the source of the diagram is still shown
+func (converter *ExternalConverter) renderMermaidDiagrams(markdownText string) string {
+	if !strings.Contains(markdownText, "```mermaid") {
+		return markdownText
+	}
+	bin := media.ResolveBinaryPath("mmdc", converter.binDir)
+	if _, err := exec.LookPath(bin); err != nil {
+		slog.Warn("mmdc not found, Mermaid diagrams are kept as code blocks")
+		return markdownText
+	}
+
+	return mermaidBlockPattern.ReplaceAllStringFunc(markdownText, func(block string) string {
+		source := mermaidBlockPattern.FindStringSubmatch(block)[1]
+		image, err := converter.renderMermaid(bin, source)
+		if err != nil {
+			slog.Warn("Failed to render Mermaid diagram, keeping its source", "error", err)
+			return block
+		}
+		return "![](data:image/png;base64," + base64.StdEncoding.EncodeToString(image) + ")"
+	})
+}
+
+// renderMermaid renders the source of a Mermaid diagram as a PNG image
+func (converter *ExternalConverter) renderMermaid(bin string, source string) ([]byte, error) {
+	temporaryDirectory, err := os.MkdirTemp("", "mermaid-*")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(temporaryDirectory)
+
+	inputPath := filepath.Join(temporaryDirectory, "diagram.mmd")
+	outputPath := filepath.Join(temporaryDirectory, "diagram.png")
+	if err := os.WriteFile(inputPath, []byte(source), 0644); err != nil {
+		return nil, err
+	}
+
+	command := exec.Command(bin, "-i", inputPath, "-o", outputPath, "-b", "white", "-s", "2")
+	var stderr bytes.Buffer
+	command.Stderr = &stderr
+	if err := command.Run(); err != nil {
+		return nil, fmt.Errorf("mmdc failed: %v, stderr: %s", err, stderr.String())
+	}
+	return os.ReadFile(outputPath)
+}
@@ -0,0 +1,51 @@
+package markdown
+
+import (
+	"encoding/json"
+	"fmt"
+	"slices"
+	"strings"
+
+	"lectures/internal/models"
+)
+
+// MockExamToMarkdown renders mock exam JSON as a printable exam: its duration and points, the questions with what
+// each is worth, then the answer key, giving each answer with its explanation and the lecture the question
+// comes from
+func MockExamToMarkdown(title string, toolContent string, language string) string {
+	var mockExam models.MockExam
+	if err := json.Unmarshal([]byte(toolContent), &mockExam); err != nil {
+		return toolContent
+	}
+	pointsLabel := getI18nLabel(language, "points_label")
+
+	var builder strings.Builder
+	fmt.Fprintf(&builder, "# %s\n\n", title)
+	fmt.Fprintf(&builder, "**%s:** %d %s · **%s:** %d %s\n\n", getI18nLabel(language, "duration_label"), mockExam.DurationMinutes, getI18nLabel(language, "minutes_label"),
+		getI18nLabel(language, "total_label"), mockExam.TotalPoints, pointsLabel)
+	for index, question := range mockExam.Questions {
+		fmt.Fprintf(&builder, "## %d. (%d %s)\n\n%s\n\n", index+1, question.Points, pointsLabel, question.Question)
+		for optionIndex, option := range question.Options {
+			fmt.Fprintf(&builder, "%c. %s\n", 'A'+optionIndex, option)
+		}
+		if len(question.Options) > 0 {
+			builder.WriteString("\n")
+		}
+	}
+
+	fmt.Fprintf(&builder, "# %s\n\n", getI18nLabel(language, "answer_key"))
+	for index, question := range mockExam.Questions {
+		answerText := question.CorrectAnswer
+		if optionIndex := slices.Index(question.Options, question.CorrectAnswer); optionIndex >= 0 {
+			answerText = fmt.Sprintf("%c. %s", 'A'+optionIndex, answerText)
+		}
+		fmt.Fprintf(&builder, "## %d. (%d %s)\n\n%s\n\n", index+1, question.Points, pointsLabel, answerText)
+		if question.Explanation != "" {
+			fmt.Fprintf(&builder, "%s\n\n", question.Explanation)
+		}
+		if question.Lecture != "" {
+			fmt.Fprintf(&builder, "*%s: %s*\n\n", getI18nLabel(language, "lecture_label"), question.Lecture)
+		}
+	}
+	return builder.String()
+}
@@ -0,0 +1,110 @@
+package markdown
+
+import (
+	"encoding/json"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+
+	"lectures/internal/models"
+)
+
+// QuizToMarkdown renders quiz JSON as a printable Markdown document: the questions first, with the right items of
+// matching questions and the items of ordering questions sorted alphabetically so their order gives nothing away,
+// then an answer key with the explanations and the rubrics of free response questions
+func QuizToMarkdown(title string, toolContent string, language string) string {
+	var questions []models.QuizQuestion
+	if err := json.Unmarshal([]byte(toolContent), &questions); err != nil {
+		return toolContent
+	}
+
+	var builder strings.Builder
+	fmt.Fprintf(&builder, "# %s\n\n", title)
+	for index, question := range questions {
+		fmt.Fprintf(&builder, "## %d. %s\n\n", index+1, strings.ReplaceAll(question.Question, "\n", " "))
+
+		switch question.QuestionType() {
+		case models.QuizQuestionMultipleChoice:
+			for optionIndex, option := range question.Options {
+				fmt.Fprintf(&builder, "%c. %s\n", 'A'+optionIndex, option)
+			}
+		case models.QuizQuestionMatching:
+			rightItems := make([]string, 0, len(question.Pairs))
+			for _, pair := range question.Pairs {
+				rightItems = append(rightItems, pair.Right)
+			}
+			slices.Sort(rightItems)
+			builder.WriteString("| | |\n|---|---|\n")
+			for pairIndex, pair := range question.Pairs {
+				fmt.Fprintf(&builder, "| %d. %s | %c. %s |\n", pairIndex+1, pair.Left, 'A'+pairIndex, rightItems[pairIndex])
+			}
+		case models.QuizQuestionOrdering:
+			shuffledItems := slices.Sorted(slices.Values(question.Items))
+			for _, item := range shuffledItems {
+				fmt.Fprintf(&builder, "- %s\n", item)
+			}
+		case models.QuizQuestionNumeric:
+			fmt.Fprintf(&builder, "\\_\\_\\_\\_\\_\\_\\_\\_ %s\n", question.Unit)
+		}
+		builder.WriteString("\n")
+	}
+
+	fmt.Fprintf(&builder, "# %s\n\n", getI18nLabel(language, "answer_key"))
+	for index, question := range questions {
+		answerText := QuizAnswerText(question)
+		if optionIndex := slices.Index(question.Options, question.CorrectAnswer); optionIndex >= 0 {
+			answerText = fmt.Sprintf("%c. %s", 'A'+optionIndex, answerText)
+		}
+		fmt.Fprintf(&builder, "%d. %s\n\n", index+1, answerText)
+		for _, criterion := range question.Rubric {
+			fmt.Fprintf(&builder, "    - %s (%d %s)\n", criterion.Criterion, criterion.Points, getI18nLabel(language, "points_label"))
+		}
+		if len(question.Rubric) > 0 {
+			builder.WriteString("\n")
+		}
+		if question.Explanation != "" {
+			fmt.Fprintf(&builder, "    %s\n\n", strings.ReplaceAll(question.Explanation, "\n", " "))
+		}
+	}
+	return builder.String()
+}
+
+// QuizAnswerText returns the correct answer of a question on a single line: the option of a multiple choice
+// question, the pairs or the order of the items, the number with its tolerance and unit, or the model answer
+func QuizAnswerText(question models.QuizQuestion) string {
+	switch question.QuestionType() {
+	case models.QuizQuestionMatching:
+		matches := make([]string, 0, len(question.Pairs))
+		for _, pair := range question.Pairs {
+			matches = append(matches, pair.Left+" → "+pair.Right)
+		}
+		return strings.Join(matches, "; ")
+	case models.QuizQuestionOrdering:
+		return strings.Join(question.Items, " → ")
+	case models.QuizQuestionNumeric:
+		if question.Answer == nil {
+			return ""
+		}
+		answerText := strconv.FormatFloat(*question.Answer, 'g', -1, 64)
+		if question.Tolerance > 0 {
+			answerText += " ± " + strconv.FormatFloat(question.Tolerance, 'g', -1, 64)
+		}
+		return strings.TrimSpace(answerText + " " + question.Unit)
+	}
+	return strings.ReplaceAll(question.CorrectAnswer, "\n", " ")
+}
+
+// quizOptionsJSON lists what a question offers to choose from, as a JSON array for the Anki and CSV exports: the
+// options of a multiple choice question or the right items of a matching one, empty for the other types
+func quizOptionsJSON(question models.QuizQuestion) string {
+	choices := question.Options
+	for _, pair := range question.Pairs {
+		choices = append(choices, pair.Right)
+	}
+	if len(choices) == 0 {
+		return ""
+	}
+	choicesJSON, _ := json.Marshal(choices)
+	return string(choicesJSON)
+}
@@ -0,0 +1,85 @@
+package markdown
+
+import (
+	"fmt"
+	"image"
+	"image/draw"
+	"image/png"
+	"os"
+
+	_ "image/jpeg"
+)
+
+// regionMargin is added around a cropped region, as a fraction of the page, so text at its edges is not cut
+const regionMargin = 0.02
+
+// PageRegion is the part of a cited page that supports a claim, in fractions of the page size measured from the
+// top-left corner
+type PageRegion struct {
+	Page   int     `json:"page"`
+	Left   float64 `json:"left"`
+	Top    float64 `json:"top"`
+	Width  float64 `json:"width"`
+	Height float64 `json:"height"`
+}
+
+// Valid reports whether the region lies on the page and has an area
+func (region PageRegion) Valid() bool {
+	return region.Page > 0 && region.Left >= 0 && region.Top >= 0 && region.Width > 0 && region.Height > 0 &&
+		region.Left+region.Width <= 1.0001 && region.Top+region.Height <= 1.0001
+}
+
+// Union returns the smallest region covering both regions, which must be on the same page
+func (region PageRegion) Union(other PageRegion) PageRegion {
+	left := min(region.Left, other.Left)
+	top := min(region.Top, other.Top)
+	return PageRegion{
+		Page:   region.Page,
+		Left:   left,
+		Top:    top,
+		Width:  max(region.Left+region.Width, other.Left+other.Width) - left,
+		Height: max(region.Top+region.Height, other.Top+other.Height) - top,
+	}
+}
+
+// CropPageImage writes the region of a page image, widened by a small margin, to destinationPath as a PNG
+func CropPageImage(sourcePath string, region PageRegion, destinationPath string) error {
+	if !region.Valid() {
+		return fmt.Errorf("invalid page region %+v", region)
+	}
+
+	sourceFile, err := os.Open(sourcePath)
+	if err != nil {
+		return err
+	}
+	defer sourceFile.Close()
+	pageImage, _, err := image.Decode(sourceFile)
+	if err != nil {
+		return fmt.Errorf("failed to decode page image: %w", err)
+	}
+
+	bounds := pageImage.Bounds()
+	width, height := float64(bounds.Dx()), float64(bounds.Dy())
+	cropBounds := image.Rect(
+		bounds.Min.X+int(max(region.Left-regionMargin, 0)*width),
+		bounds.Min.Y+int(max(region.Top-regionMargin, 0)*height),
+		bounds.Min.X+int(min(region.Left+region.Width+regionMargin, 1)*width),
+		bounds.Min.Y+int(min(region.Top+region.Height+regionMargin, 1)*height),
+	).Intersect(bounds)
+	if cropBounds.Empty() {
+		return fmt.Errorf("page region %+v is empty at %dx%d", region, bounds.Dx(), bounds.Dy())
+	}
+
+	croppedImage := image.NewRGBA(image.Rect(0, 0, cropBounds.Dx(), cropBounds.Dy()))
+	draw.Draw(croppedImage, croppedImage.Bounds(), pageImage, cropBounds.Min, draw.Src)
+
+	destinationFile, err := os.Create(destinationPath)
+	if err != nil {
+		return err
+	}
+	if err := png.Encode(destinationFile, croppedImage); err != nil {
+		destinationFile.Close()
+		return fmt.Errorf("failed to encode cropped image: %w", err)
+	}
+	return destinationFile.Close()
+}
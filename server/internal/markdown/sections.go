@@ -0,0 +1,126 @@
+package markdown
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+var sectionHeadingPattern = regexp.MustCompile(`^(#{1,6})\s+(.+?)(?:\s+#+)?\s*$`)
+
+// documentHeading is a heading of a document, with the titles of the headings it is under
+type documentHeading struct {
+	line  int
+	level int
+	path  []string
+}
+
+// ReplaceSection replaces the body of a section of a Markdown document: everything under its heading up to the
+// next heading of the same or a higher level, subsections included. The heading path lists the titles leading
+// to the section and may leave out the outermost ones, such as the title of a guide; titles are compared
+// ignoring case and numbering such as "2." or "Chapter 3:". A title, when given, renames the heading
+func ReplaceSection(document string, headingPath []string, body string, title string) (string, error) {
+	if len(headingPath) == 0 {
+		return "", fmt.Errorf("heading_path is required")
+	}
+
+	lines := strings.Split(strings.ReplaceAll(document, "\r\n", "\n"), "\n")
+	headings := documentHeadings(lines)
+
+	var matches []int
+	for index, heading := range headings {
+		if headingPathMatches(heading.path, headingPath) {
+			matches = append(matches, index)
+		}
+	}
+	displayedPath := strings.Join(headingPath, " > ")
+	if len(matches) == 0 {
+		return "", fmt.Errorf("no section has the heading path %q", displayedPath)
+	}
+	if len(matches) > 1 {
+		return "", fmt.Errorf("%d sections have the heading path %q; give more of the headings above it", len(matches), displayedPath)
+	}
+
+	section := headings[matches[0]]
+	end := len(lines)
+	for _, heading := range headings[matches[0]+1:] {
+		if heading.level <= section.level {
+			end = heading.line
+			break
+		}
+	}
+
+	headingLine := lines[section.line]
+	if title = strings.TrimSpace(title); title != "" {
+		headingLine = strings.Repeat("#", section.level) + " " + title
+	}
+
+	replaced := append([]string{}, lines[:section.line]...)
+	replaced = append(replaced, headingLine, "")
+	if body = strings.Trim(strings.ReplaceAll(body, "\r\n", "\n"), "\n"); strings.TrimSpace(body) != "" {
+		replaced = append(replaced, strings.Split(body, "\n")...)
+		replaced = append(replaced, "")
+	}
+	rest := lines[end:]
+	for len(rest) > 0 && strings.TrimSpace(rest[0]) == "" {
+		rest = rest[1:]
+	}
+	if len(rest) == 0 {
+		return strings.Join(replaced, "\n"), nil
+	}
+	return strings.Join(append(replaced, rest...), "\n"), nil
+}
+
+// documentHeadings lists the headings of a document outside its code blocks
+func documentHeadings(lines []string) []documentHeading {
+	var headings []documentHeading
+	var titles [7]string
+	fence := ""
+	for index, line := range lines {
+		trimmed := strings.TrimSpace(line)
+		if fence != "" {
+			if strings.HasPrefix(trimmed, fence) {
+				fence = ""
+			}
+			continue
+		}
+		if strings.HasPrefix(trimmed, "```") || strings.HasPrefix(trimmed, "~~~") {
+			fence = trimmed[:3]
+			continue
+		}
+
+		match := sectionHeadingPattern.FindStringSubmatch(trimmed)
+		if match == nil {
+			continue
+		}
+		level := len(match[1])
+		titles[level] = match[2]
+		var path []string
+		for parentLevel := 1; parentLevel < level; parentLevel++ {
+			if titles[parentLevel] != "" {
+				path = append(path, titles[parentLevel])
+			}
+		}
+		for deeperLevel := level + 1; deeperLevel < len(titles); deeperLevel++ {
+			titles[deeperLevel] = ""
+		}
+		headings = append(headings, documentHeading{line: index, level: level, path: append(path, match[2])})
+	}
+	return headings
+}
+
+// headingPathMatches reports whether the last titles of a heading's path are the given ones
+func headingPathMatches(path []string, headingPath []string) bool {
+	if len(headingPath) > len(path) {
+		return false
+	}
+	parser := &Parser{}
+	offset := len(path) - len(headingPath)
+	for index, title := range headingPath {
+		title = strings.TrimSpace(title)
+		if !strings.EqualFold(path[offset+index], title) && !strings.EqualFold(parser.cleanTitle(path[offset+index]), parser.cleanTitle(title)) {
+			return false
+		}
+	}
+	return true
+}
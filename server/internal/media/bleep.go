@@ -0,0 +1,44 @@
+package media
+
+import (
+	"bytes"
+	"fmt"
+	"os/exec"
+	"strings"
+
+	"lectures/internal/models"
+)
+
+// bleepFrequencyHertz is the pitch of the tone covering redacted speech
+const bleepFrequencyHertz = 1000
+
+// BleepAudio copies a media file, replacing its audio within the spans, in milliseconds from its start, with a
+// tone. Video streams are copied as they are. Mixing without normalization needs ffmpeg 5 or later
+func BleepAudio(inputPath string, outputPath string, spans []models.TimeSpan, binDir string) error {
+	if len(spans) == 0 {
+		return fmt.Errorf("no spans to bleep")
+	}
+	var conditions []string
+	for _, span := range spans {
+		conditions = append(conditions, fmt.Sprintf("between(t,%.3f,%.3f)", float64(span.StartMillisecond)/1000, float64(span.EndMillisecond)/1000))
+	}
+	inSpans := strings.Join(conditions, "+")
+
+	// The speech is muted within the spans and the tone outside them, then both are mixed
+	filter := fmt.Sprintf("[0:a]volume=0:enable='%s'[muted];"+
+		"sine=frequency=%d:sample_rate=48000,volume=0.2,volume=0:enable='not(%s)'[tone];"+
+		"[muted][tone]amix=inputs=2:duration=first:normalize=0[bleeped]", inSpans, bleepFrequencyHertz, inSpans)
+
+	command := exec.Command(ResolveBinaryPath("ffmpeg", binDir),
+		"-y", "-i", inputPath,
+		"-filter_complex", filter,
+		"-map", "0:v?", "-map", "[bleeped]",
+		"-c:v", "copy",
+		outputPath)
+	var stderr bytes.Buffer
+	command.Stderr = &stderr
+	if err := command.Run(); err != nil {
+		return fmt.Errorf("ffmpeg bleep failed: %v, stderr: %s", err, stderr.String())
+	}
+	return nil
+}
@@ -0,0 +1,142 @@
+package media
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// downloadProgressPattern matches the progress lines yt-dlp prints with --newline
+var downloadProgressPattern = regexp.MustCompile(`^\[download\]\s+([\d.]+)%`)
+
+// YouTubeDownload is the audio of a video downloaded with yt-dlp, with its uploaded captions when requested
+type YouTubeDownload struct {
+	AudioPath            string
+	Title                string
+	DurationMilliseconds int64
+	// HasVideo is set when the video has no separate audio stream and the whole video was downloaded
+	HasVideo bool
+	// CaptionsPath is the WebVTT file of the captions uploaded by the author, empty when there are none.
+	// Automatic captions are never used, since the transcription providers are more accurate
+	CaptionsPath     string
+	CaptionsLanguage string
+}
+
+// IsYouTubeURL reports whether a URL points to a YouTube video
+func IsYouTubeURL(rawURL string) bool {
+	parsedURL, err := url.Parse(rawURL)
+	if err != nil || (parsedURL.Scheme != "http" && parsedURL.Scheme != "https") {
+		return false
+	}
+	host := strings.TrimPrefix(strings.ToLower(parsedURL.Hostname()), "www.")
+	return host == "youtube.com" || host == "m.youtube.com" || host == "music.youtube.com" || host == "youtu.be"
+}
+
+// DownloadYouTubeAudio downloads the best audio stream of a video into outputDirectory with yt-dlp. When
+// captionsLanguage is set, the captions the author uploaded in that language (any regional variant) are fetched
+// too; "all" accepts any language. onProgress receives the download percentage
+func DownloadYouTubeAudio(jobContext context.Context, videoURL string, outputDirectory string, binDir string, captionsLanguage string, onProgress func(percent int)) (*YouTubeDownload, error) {
+	binaryPath := ResolveBinaryPath("yt-dlp", binDir)
+	if _, err := exec.LookPath(binaryPath); err != nil {
+		return nil, fmt.Errorf("yt-dlp not found (install yt-dlp or place it in the bin folder)")
+	}
+
+	arguments := []string{
+		"--no-playlist",
+		"--newline",
+		"--format", "bestaudio/best",
+		"--write-info-json",
+		"--output", filepath.Join(outputDirectory, "audio.%(ext)s"),
+	}
+	if captionsLanguage != "" {
+		subtitleLanguages := "all"
+		if captionsLanguage != "all" {
+			baseLanguage := strings.ToLower(strings.SplitN(captionsLanguage, "-", 2)[0])
+			subtitleLanguages = baseLanguage + "," + baseLanguage + "-.*"
+		}
+		arguments = append(arguments, "--write-subs", "--sub-format", "vtt", "--sub-langs", subtitleLanguages)
+	}
+	arguments = append(arguments, "--", videoURL)
+
+	command := exec.CommandContext(jobContext, binaryPath, arguments...)
+	var stderr bytes.Buffer
+	command.Stderr = &stderr
+	stdout, err := command.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := command.Start(); err != nil {
+		return nil, fmt.Errorf("failed to start yt-dlp: %w", err)
+	}
+	scanner := bufio.NewScanner(stdout)
+	for scanner.Scan() {
+		if match := downloadProgressPattern.FindStringSubmatch(scanner.Text()); match != nil && onProgress != nil {
+			if percent, err := strconv.ParseFloat(match[1], 64); err == nil {
+				onProgress(int(percent))
+			}
+		}
+	}
+	if err := command.Wait(); err != nil {
+		if jobContext.Err() != nil {
+			return nil, jobContext.Err()
+		}
+		return nil, fmt.Errorf("yt-dlp failed: %v, stderr: %s", err, strings.TrimSpace(stderr.String()))
+	}
+
+	var information struct {
+		Title      string  `json:"title"`
+		Duration   float64 `json:"duration"`
+		VideoCodec string  `json:"vcodec"`
+		Language   string  `json:"language"`
+	}
+	informationData, err := os.ReadFile(filepath.Join(outputDirectory, "audio.info.json"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read video information: %w", err)
+	}
+	if err := json.Unmarshal(informationData, &information); err != nil {
+		return nil, fmt.Errorf("failed to parse video information: %w", err)
+	}
+
+	download := &YouTubeDownload{
+		Title:                strings.TrimSpace(information.Title),
+		DurationMilliseconds: int64(information.Duration * 1000),
+		HasVideo:             information.VideoCodec != "" && information.VideoCodec != "none",
+	}
+
+	entries, err := os.ReadDir(outputDirectory)
+	if err != nil {
+		return nil, err
+	}
+	var captionPaths []string
+	for _, entry := range entries {
+		name := entry.Name()
+		switch {
+		case entry.IsDir() || name == "audio.info.json" || strings.HasSuffix(name, ".part"):
+		case strings.HasSuffix(name, ".vtt"):
+			captionPaths = append(captionPaths, filepath.Join(outputDirectory, name))
+		case strings.HasPrefix(name, "audio."):
+			download.AudioPath = filepath.Join(outputDirectory, name)
+		}
+	}
+	if download.AudioPath == "" {
+		return nil, fmt.Errorf("yt-dlp did not produce an audio file")
+	}
+
+	// Captions are named audio.<language>.vtt; the spoken language of the video is preferred when several exist
+	for _, captionPath := range captionPaths {
+		language := strings.TrimSuffix(strings.TrimPrefix(filepath.Base(captionPath), "audio."), ".vtt")
+		if download.CaptionsPath == "" || (information.Language != "" && strings.HasPrefix(language, information.Language)) {
+			download.CaptionsPath, download.CaptionsLanguage = captionPath, language
+		}
+	}
+	return download, nil
+}
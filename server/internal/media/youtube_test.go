@@ -0,0 +1,80 @@
+package media
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+// writeFakeYtDlp installs a yt-dlp stand-in that records its arguments and writes a canned download
+func writeFakeYtDlp(tester *testing.T, binDirectory string, argumentsPath string) {
+	script := `#!/bin/sh
+echo "$@" > "` + argumentsPath + `"
+while [ "$#" -gt 0 ]; do
+	if [ "$1" = "--output" ]; then output=$(dirname "$2"); fi
+	shift
+done
+echo "[download]  42.5% of 10.00MiB at 1.00MiB/s ETA 00:05"
+echo "[download] 100% of 10.00MiB"
+printf 'audio' > "$output/audio.webm"
+printf 'WEBVTT\n\n00:00.000 --> 00:01.000\nHello\n' > "$output/audio.en.vtt"
+printf 'WEBVTT\n' > "$output/audio.de.vtt"
+printf '{"title": " Thermodynamics 101 ", "duration": 61.5, "vcodec": "none", "language": "en"}' > "$output/audio.info.json"
+`
+	if err := os.WriteFile(filepath.Join(binDirectory, "yt-dlp"), []byte(script), 0755); err != nil {
+		tester.Fatalf("Failed to write fake yt-dlp: %v", err)
+	}
+}
+
+func TestDownloadYouTubeAudio(tester *testing.T) {
+	if runtime.GOOS == "windows" {
+		tester.Skip("fake yt-dlp is a shell script")
+	}
+
+	binDirectory := tester.TempDir()
+	outputDirectory := tester.TempDir()
+	argumentsPath := filepath.Join(binDirectory, "arguments.txt")
+	writeFakeYtDlp(tester, binDirectory, argumentsPath)
+
+	var reportedProgress []int
+	download, err := DownloadYouTubeAudio(context.Background(), "https://youtu.be/abc", outputDirectory, binDirectory, "en-US", func(percent int) {
+		reportedProgress = append(reportedProgress, percent)
+	})
+	if err != nil {
+		tester.Fatalf("DownloadYouTubeAudio failed: %v", err)
+	}
+
+	arguments, _ := os.ReadFile(argumentsPath)
+	for _, expected := range []string{"--no-playlist", "--write-subs", "--sub-langs en,en-.*", "-- https://youtu.be/abc"} {
+		if !strings.Contains(string(arguments), expected) {
+			tester.Errorf("Expected arguments to contain %q, got %s", expected, arguments)
+		}
+	}
+	if len(reportedProgress) != 2 || reportedProgress[0] != 42 || reportedProgress[1] != 100 {
+		tester.Errorf("Expected progress 42 then 100, got %v", reportedProgress)
+	}
+	if filepath.Base(download.AudioPath) != "audio.webm" || download.Title != "Thermodynamics 101" || download.DurationMilliseconds != 61500 || download.HasVideo {
+		tester.Errorf("Unexpected download: %+v", download)
+	}
+	if filepath.Base(download.CaptionsPath) != "audio.en.vtt" || download.CaptionsLanguage != "en" {
+		tester.Errorf("Expected the captions in the spoken language, got %q (%s)", download.CaptionsPath, download.CaptionsLanguage)
+	}
+}
+
+func TestIsYouTubeURL(tester *testing.T) {
+	for rawURL, expected := range map[string]bool{
+		"https://www.youtube.com/watch?v=abc": true,
+		"https://youtu.be/abc":                true,
+		"http://m.youtube.com/watch?v=abc":    true,
+		"https://example.com/watch?v=abc":     false,
+		"ftp://youtube.com/abc":               false,
+		"youtube.com/watch?v=abc":             false,
+	} {
+		if IsYouTubeURL(rawURL) != expected {
+			tester.Errorf("IsYouTubeURL(%q): expected %v", rawURL, expected)
+		}
+	}
+}
@@ -0,0 +1,107 @@
+package models
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+)
+
+// Limits of the metadata fields of an exam or lecture
+const (
+	MaximumMetadataFields          = 20
+	MaximumMetadataFieldNameLength = 64
+	MaximumMetadataFieldValue      = 500
+)
+
+// MetadataField is a user-defined detail of an exam or lecture, such as the professor, course code, semester or
+// institution, printed on the title page of exports and available to prompts as {{metadata_<name>}}
+type MetadataField struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+// MetadataVariableName returns the prompt variable of a field: "Course code" is {{metadata_course_code}}
+func MetadataVariableName(name string) string {
+	var builder strings.Builder
+	separate := false
+	for _, character := range strings.ToLower(strings.TrimSpace(name)) {
+		if unicode.IsLetter(character) || unicode.IsDigit(character) {
+			if separate && builder.Len() > 0 {
+				builder.WriteByte('_')
+			}
+			builder.WriteRune(character)
+			separate = false
+		} else {
+			separate = true
+		}
+	}
+	return "metadata_" + builder.String()
+}
+
+// ValidateMetadataFields returns why a list of fields cannot be stored, or an empty string
+func ValidateMetadataFields(fields []MetadataField) string {
+	if len(fields) > MaximumMetadataFields {
+		return fmt.Sprintf("metadata_fields cannot have more than %d fields", MaximumMetadataFields)
+	}
+	seenVariables := make(map[string]bool)
+	for _, field := range fields {
+		name := strings.TrimSpace(field.Name)
+		if name == "" || MetadataVariableName(name) == "metadata_" {
+			return "Every metadata field needs a name with a letter or digit"
+		}
+		if len([]rune(name)) > MaximumMetadataFieldNameLength {
+			return fmt.Sprintf("Metadata field names cannot be longer than %d characters", MaximumMetadataFieldNameLength)
+		}
+		if len([]rune(field.Value)) > MaximumMetadataFieldValue {
+			return fmt.Sprintf("Metadata field values cannot be longer than %d characters", MaximumMetadataFieldValue)
+		}
+		variable := MetadataVariableName(name)
+		if variable == "metadata_fields" {
+			return "A metadata field cannot be named \"fields\""
+		}
+		if seenVariables[variable] {
+			return fmt.Sprintf("Metadata field %q is defined twice", name)
+		}
+		seenVariables[variable] = true
+	}
+	return ""
+}
+
+// MergeMetadataFields returns the fields of a lecture on top of those of its exam: a lecture field replaces the
+// exam field of the same name in place, and the others follow the exam fields. Fields without a value are dropped
+func MergeMetadataFields(examFields []MetadataField, lectureFields []MetadataField) []MetadataField {
+	merged := []MetadataField{}
+	positions := make(map[string]int)
+	for _, fields := range [][]MetadataField{examFields, lectureFields} {
+		for _, field := range fields {
+			variable := MetadataVariableName(field.Name)
+			if position, ok := positions[variable]; ok {
+				merged[position] = field
+				continue
+			}
+			positions[variable] = len(merged)
+			merged = append(merged, field)
+		}
+	}
+
+	filled := merged[:0]
+	for _, field := range merged {
+		if strings.TrimSpace(field.Value) != "" {
+			filled = append(filled, field)
+		}
+	}
+	return filled
+}
+
+// MetadataTemplateVariables returns the prompt variables of a list of fields, with metadata_fields listing them
+// all as "Name: Value" lines
+func MetadataTemplateVariables(fields []MetadataField) map[string]string {
+	variables := make(map[string]string, len(fields)+1)
+	lines := make([]string, 0, len(fields))
+	for _, field := range fields {
+		variables[MetadataVariableName(field.Name)] = field.Value
+		lines = append(lines, field.Name+": "+field.Value)
+	}
+	variables["metadata_fields"] = strings.Join(lines, "\n")
+	return variables
+}
@@ -0,0 +1,134 @@
+package models
+
+import "errors"
+
+// Mock exam question types
+const (
+	MockExamMultipleChoice = "multiple_choice"
+	MockExamShortAnswer    = "short_answer"
+	MockExamProblem        = "problem"
+)
+
+// Mock exam difficulties, easiest first
+var MockExamDifficulties = []string{"easy", "medium", "hard"}
+
+// Bounds of mock exam settings
+const (
+	MaximumMockExamQuestions = 60
+	MaximumMockExamMinutes   = 480
+)
+
+// MockExamSettings shape a mock exam: how many questions of each type it asks, how hard they are, how long it
+// lasts and what it is worth. Unset values take the defaults of Normalize
+type MockExamSettings struct {
+	LectureIDs      []string           `json:"lecture_ids,omitempty"` // Lectures the questions are drawn from, every ready lecture when empty
+	MultipleChoice  int                `json:"multiple_choice"`
+	ShortAnswer     int                `json:"short_answer"`
+	Problem         int                `json:"problem"`
+	Difficulty      MockExamDifficulty `json:"difficulty"`
+	DurationMinutes int                `json:"duration_minutes"`
+	TotalPoints     int                `json:"total_points"`
+}
+
+// MockExamDifficulty is the share of the questions of each difficulty, in percent
+type MockExamDifficulty struct {
+	Easy   int `json:"easy"`
+	Medium int `json:"medium"`
+	Hard   int `json:"hard"`
+}
+
+// Normalize fills the unset values of the settings with defaults, a 90 minute exam worth 100 points asking 10
+// multiple choice questions, 5 short answers and 3 problems, a fifth of them hard, then validates them
+func (settings *MockExamSettings) Normalize() error {
+	if settings.MultipleChoice == 0 && settings.ShortAnswer == 0 && settings.Problem == 0 {
+		settings.MultipleChoice, settings.ShortAnswer, settings.Problem = 10, 5, 3
+	}
+	if settings.Difficulty == (MockExamDifficulty{}) {
+		settings.Difficulty = MockExamDifficulty{Easy: 30, Medium: 50, Hard: 20}
+	}
+	if settings.DurationMinutes == 0 {
+		settings.DurationMinutes = 90
+	}
+	if settings.TotalPoints == 0 {
+		settings.TotalPoints = 100
+	}
+
+	if settings.MultipleChoice < 0 || settings.ShortAnswer < 0 || settings.Problem < 0 {
+		return errors.New("question counts cannot be negative")
+	}
+	if settings.QuestionCount() > MaximumMockExamQuestions {
+		return errors.New("a mock exam asks at most 60 questions")
+	}
+	difficulty := settings.Difficulty
+	if difficulty.Easy < 0 || difficulty.Medium < 0 || difficulty.Hard < 0 || difficulty.Easy+difficulty.Medium+difficulty.Hard != 100 {
+		return errors.New("difficulty shares must be non-negative and add up to 100")
+	}
+	if settings.DurationMinutes < 0 || settings.DurationMinutes > MaximumMockExamMinutes {
+		return errors.New("duration_minutes must be between 1 and 480")
+	}
+	if settings.TotalPoints < settings.QuestionCount() {
+		return errors.New("total_points must give every question at least one point")
+	}
+	return nil
+}
+
+// QuestionCount returns how many questions the mock exam asks
+func (settings MockExamSettings) QuestionCount() int {
+	return settings.MultipleChoice + settings.ShortAnswer + settings.Problem
+}
+
+// TypeCounts returns how many questions of each type the mock exam asks
+func (settings MockExamSettings) TypeCounts() map[string]int {
+	return map[string]int{
+		MockExamMultipleChoice: settings.MultipleChoice,
+		MockExamShortAnswer:    settings.ShortAnswer,
+		MockExamProblem:        settings.Problem,
+	}
+}
+
+// DifficultyCounts splits the questions between the difficulties by their shares, the questions left by rounding
+// down going to the largest remainders, so the counts add up to the number of questions
+func (settings MockExamSettings) DifficultyCounts() map[string]int {
+	total := settings.QuestionCount()
+	percents := []int{settings.Difficulty.Easy, settings.Difficulty.Medium, settings.Difficulty.Hard}
+
+	counts := make(map[string]int, len(MockExamDifficulties))
+	remainders := make([]int, len(percents))
+	assigned := 0
+	for index, percent := range percents {
+		counts[MockExamDifficulties[index]] = total * percent / 100
+		remainders[index] = total * percent % 100
+		assigned += counts[MockExamDifficulties[index]]
+	}
+	for ; assigned < total; assigned++ {
+		largest := 0
+		for index := range remainders {
+			if remainders[index] > remainders[largest] {
+				largest = index
+			}
+		}
+		counts[MockExamDifficulties[largest]]++
+		remainders[largest] = -1
+	}
+	return counts
+}
+
+// MockExam is the validated content of a mock exam tool: its questions, each with the answer printed in the
+// answer key after them
+type MockExam struct {
+	DurationMinutes int                `json:"duration_minutes"`
+	TotalPoints     int                `json:"total_points"`
+	Questions       []MockExamQuestion `json:"questions"`
+}
+
+// MockExamQuestion is a question of a mock exam
+type MockExamQuestion struct {
+	Type          string   `json:"type"`
+	Difficulty    string   `json:"difficulty"`
+	Points        int      `json:"points"`
+	Lecture       string   `json:"lecture"` // Title of the lecture the question is drawn from
+	Question      string   `json:"question"`
+	Options       []string `json:"options,omitempty"` // Multiple choice
+	CorrectAnswer string   `json:"correct_answer"`    // One of the options, a model answer, or the worked solution of a problem
+	Explanation   string   `json:"explanation,omitempty"`
+}
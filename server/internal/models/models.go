@@ -1,41 +1,410 @@
 package models
 
-import "time"
+import (
+	"encoding/json"
+	"slices"
+	"time"
+)
 
 // User represents a system user
 type User struct {
-	ID           string    `json:"id"`
-	Username     string    `json:"username"`
-	PasswordHash string    `json:"-"`
-	Role         string    `json:"role"` // "admin", "user"
-	CreatedAt    time.Time `json:"created_at"`
-	UpdatedAt    time.Time `json:"updated_at"`
+	ID                     string    `json:"id"`
+	Username               string    `json:"username"`
+	PasswordHash           string    `json:"-"`
+	Role                   string    `json:"role"`                     // A UserRole*
+	Email                  string    `json:"email,omitempty"`          // Where password reset links are sent
+	PasswordChangeRequired bool      `json:"password_change_required"` // The user must change their password before anything else
+	CreatedAt              time.Time `json:"created_at"`
+	UpdatedAt              time.Time `json:"updated_at"`
+}
+
+// APIToken is a personal access token, with which scripts call the API as its user within its scopes
+type APIToken struct {
+	ID         string     `json:"id"`
+	Name       string     `json:"name"`
+	Prefix     string     `json:"prefix"` // Start of the token, to recognize it
+	Scopes     []string   `json:"scopes"`
+	CreatedAt  time.Time  `json:"created_at"`
+	LastUsedAt *time.Time `json:"last_used_at,omitempty"`
+	ExpiresAt  *time.Time `json:"expires_at,omitempty"` // Nil for tokens valid until revoked
+}
+
+// Webhook is an address a user has events posted to, signed with its secret, so other systems can react to them
+type Webhook struct {
+	ID          string    `json:"id"`
+	URL         string    `json:"url"`
+	Events      []string  `json:"events"` // WebhookEvent* names, or "*" for every event
+	Description string    `json:"description,omitempty"`
+	Active      bool      `json:"active"`
+	CreatedAt   time.Time `json:"created_at"`
+	UpdatedAt   time.Time `json:"updated_at"`
+}
+
+// WebhookDelivery is an event posted, or to be posted, to a webhook, with the outcome of its last attempt
+type WebhookDelivery struct {
+	ID             string     `json:"id"`
+	WebhookID      string     `json:"webhook_id"`
+	Event          string     `json:"event"`
+	Payload        string     `json:"payload"`
+	Status         string     `json:"status"` // A WebhookDeliveryStatus*
+	Attempts       int        `json:"attempts"`
+	ResponseStatus int        `json:"response_status,omitempty"` // HTTP status the receiver answered the last attempt with
+	Error          string     `json:"error,omitempty"`           // Why the last attempt failed
+	NextAttemptAt  *time.Time `json:"next_attempt_at,omitempty"`
+	DeliveredAt    *time.Time `json:"delivered_at,omitempty"`
+	CreatedAt      time.Time  `json:"created_at"`
 }
 
+// Events posted to webhooks
+const (
+	WebhookEventJobCompleted = "job.completed"
+	WebhookEventJobFailed    = "job.failed"
+	WebhookEventJobCancelled = "job.cancelled"
+	WebhookEventLectureReady = "lecture.ready"
+	WebhookEventToolCreated  = "tool.created"
+	WebhookEventPing         = "ping" // Sent on request, to try a webhook
+)
+
+// Statuses of webhook deliveries
+const (
+	WebhookDeliveryStatusPending   = "pending"
+	WebhookDeliveryStatusDelivered = "delivered"
+	WebhookDeliveryStatusFailed    = "failed" // Given up after the last attempt
+)
+
+// AuthEvent is an entry of the audit log of sign-ins, lockouts and changes to the security of accounts. Each is
+// also an AuditEvent, under the same action
+type AuthEvent struct {
+	ID        int64          `json:"id"`
+	Event     string         `json:"event"`              // One of AuthEventActions
+	UserID    string         `json:"user_id,omitempty"`  // Empty for attempts on unknown usernames
+	Username  string         `json:"username,omitempty"` // As given, for failed logins
+	IPAddress string         `json:"ip_address,omitempty"`
+	Details   map[string]any `json:"details,omitempty"`
+	CreatedAt time.Time      `json:"created_at"`
+}
+
+// AuditEvent is an entry of the audit log of security-relevant and destructive actions: sign-ins, lockouts,
+// changes to accounts and settings, deletions, job cancellations and exports
+type AuditEvent struct {
+	ID            int64          `json:"id"`
+	Action        string         `json:"action"`                   // An AuditAction*
+	ActorID       string         `json:"actor_id,omitempty"`       // Account that acted, empty when unknown, as for logins with unknown usernames
+	ActorUsername string         `json:"actor_username,omitempty"` // As given for logins, otherwise the current username of the actor
+	IPAddress     string         `json:"ip_address,omitempty"`
+	ResourceType  string         `json:"resource_type,omitempty"` // What the action was on, such as "exam" or "user"
+	ResourceID    string         `json:"resource_id,omitempty"`
+	Status        int            `json:"status,omitempty"` // HTTP status answered, for actions recorded from their route
+	Details       map[string]any `json:"details,omitempty"`
+	PayloadDigest string         `json:"payload_digest,omitempty"` // SHA-256 of the request body, which is not kept
+	CreatedAt     time.Time      `json:"created_at"`
+}
+
+// Actions of the audit log
+const (
+	AuditActionLoginSucceeded         = "login_succeeded"
+	AuditActionLoginFailed            = "login_failed"
+	AuditActionLoginBlocked           = "login_blocked" // Refused without checking the password, the username or address being locked out
+	AuditActionLogout                 = "logout"
+	AuditActionAccountCreated         = "account_created"
+	AuditActionAccountUpdated         = "account_updated"
+	AuditActionAccountDeleted         = "account_deleted"
+	AuditActionAccountUnlocked        = "account_unlocked"
+	AuditActionPasswordChanged        = "password_changed"
+	AuditActionPasswordResetRequested = "password_reset_requested"
+	AuditActionPasswordReset          = "password_reset"
+	AuditActionEmailChanged           = "email_changed"
+	AuditActionAPITokenCreated        = "api_token_created"
+	AuditActionAPITokenRevoked        = "api_token_revoked"
+	AuditActionAccessDenied           = "access_denied" // A route the role of the account does not allow
+	AuditActionBudgetChanged          = "budget_changed"
+	AuditActionSettingsChanged        = "settings_changed"
+	AuditActionProviderKeyChanged     = "provider_key_changed"
+	AuditActionExamDeleted            = "exam_deleted"
+	AuditActionExamMemberChanged      = "exam_member_changed"
+	AuditActionExamMemberRemoved      = "exam_member_removed"
+	AuditActionLectureDeleted         = "lecture_deleted"
+	AuditActionMediaDeleted           = "media_deleted"
+	AuditActionDocumentDeleted        = "document_deleted"
+	AuditActionToolDeleted            = "tool_deleted"
+	AuditActionChatSessionDeleted     = "chat_session_deleted"
+	AuditActionExportPresetDeleted    = "export_preset_deleted"
+	AuditActionExportRequested        = "export_requested"
+	AuditActionJobCancelled           = "job_cancelled"
+	AuditActionJobFailed              = "job_failed" // Failed by an administrator
+	AuditActionJobsReassigned         = "jobs_reassigned"
+	AuditActionQueuePaused            = "queue_paused"
+	AuditActionQueueResumed           = "queue_resumed"
+	AuditActionAnnouncementChanged    = "announcement_changed"
+	AuditActionPromptVariantChanged   = "prompt_variant_changed"
+	AuditActionDatabaseMaintained     = "database_maintained"
+	AuditActionBackupCreated          = "backup_created"
+	AuditActionBackupDownloaded       = "backup_downloaded"
+	AuditActionBackupRestored         = "backup_restored"
+)
+
+// AuthEventActions are the actions of the audit log also kept in the audit log of authentication
+var AuthEventActions = map[string]bool{
+	AuditActionLoginSucceeded:         true,
+	AuditActionLoginFailed:            true,
+	AuditActionLoginBlocked:           true,
+	AuditActionLogout:                 true,
+	AuditActionAccountCreated:         true,
+	AuditActionAccountUnlocked:        true,
+	AuditActionPasswordChanged:        true,
+	AuditActionPasswordResetRequested: true,
+	AuditActionPasswordReset:          true,
+	AuditActionEmailChanged:           true,
+	AuditActionAPITokenCreated:        true,
+	AuditActionAPITokenRevoked:        true,
+}
+
+// Roles of user accounts, deciding what a user may do across the server. What they may do with a given exam
+// is further decided by their ExamRole* on it
+const (
+	UserRoleAdmin   = "admin"   // Everything, including managing users, the queue and the server settings
+	UserRoleTeacher = "teacher" // Creates exams and queues the jobs processing and generating their materials
+	UserRoleStudent = "student" // Reads, chats about and exports the exams shared with them
+)
+
+// Permissions granted by user roles, checked by the API before a request reaches its handler
+const (
+	PermissionCreateExams    = "exams:create"
+	PermissionRunJobs        = "jobs:run"
+	PermissionManageSettings = "settings:manage"
+	PermissionManageUsers    = "users:manage" // Also the rest of the administration API
+)
+
 // Exam represents a course or exam grouping
 type Exam struct {
-	ID            string    `json:"id"`
-	UserID        string    `json:"user_id"`
-	Title         string    `json:"title"`
-	Description   string    `json:"description,omitempty"`
+	ID                 string                 `json:"id"`
+	UserID             string                 `json:"user_id"`
+	Title              string                 `json:"title"`
+	Description        string                 `json:"description,omitempty"`
+	Language           string                 `json:"language,omitempty"`
+	GenerationDefaults ExamGenerationDefaults `json:"generation_defaults"`
+	Collaboration      ExamCollaboration      `json:"collaboration"`
+	MetadataFields     []MetadataField        `json:"metadata_fields"`
+	Role               string                 `json:"role,omitempty"` // Role of the requesting user: "owner" or an ExamRole*
+	EstimatedCost      float64                `json:"estimated_cost"`
+	CreatedAt          time.Time              `json:"created_at"`
+	UpdatedAt          time.Time              `json:"updated_at"`
+}
+
+// Roles of the users an exam is shared with, each allowing what the previous ones do. The owner of an exam
+// can do everything a manager can
+const (
+	ExamRoleViewer    = "viewer"    // Reads the exam and its materials
+	ExamRoleGenerator = "generator" // Also queues jobs, spending their own budget unless the owner pays, and chats
+	ExamRoleManager   = "manager"   // Also edits and deletes the exam and its contents, and shares it
+	ExamRoleOwner     = "owner"
+)
+
+// ExamCollaboration is what the owner of an exam shares with its members beyond its materials, which every
+// member reads. Only the owner changes it
+type ExamCollaboration struct {
+	SharedChatSessions bool `json:"shared_chat_sessions"` // Members read every chat session of the exam, not only theirs
+	OwnerPaysJobs      bool `json:"owner_pays_jobs"`      // Jobs of members are charged to the owner's budget and API keys
+}
+
+// UnmarshalJSON turns on the settings left out, so exams share their chats and charge their jobs to the owner
+// unless the owner opts out
+func (collaboration *ExamCollaboration) UnmarshalJSON(data []byte) error {
+	type plainCollaboration ExamCollaboration
+	decoded := plainCollaboration(DefaultExamCollaboration())
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		return err
+	}
+	*collaboration = ExamCollaboration(decoded)
+	return nil
+}
+
+// DefaultExamCollaboration is the collaboration of exams whose owner did not change it
+func DefaultExamCollaboration() ExamCollaboration {
+	return ExamCollaboration{SharedChatSessions: true, OwnerPaysJobs: true}
+}
+
+// ExamMember is a user an exam is shared with
+type ExamMember struct {
+	UserID    string    `json:"user_id"`
+	Username  string    `json:"username"`
+	Role      string    `json:"role"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// ExamGenerationDefaults holds the per-exam values used when a tool generation request omits them.
+// Empty fields fall through to the global configuration
+type ExamGenerationDefaults struct {
+	LanguageCode            string `json:"language_code,omitempty"`
+	Length                  string `json:"length,omitempty"` // "short", "medium", "long", "comprehensive"
+	EnableDocumentsMatching *bool  `json:"enable_documents_matching,omitempty"`
+	AdherenceThreshold      *int   `json:"adherence_threshold,omitempty"` // 0 accepts every section
+	MaximumRetries          *int   `json:"maximum_retries,omitempty"`     // 0 makes a single attempt
+	ModelDocumentsMatching  string `json:"model_documents_matching,omitempty"`
+	ModelStructure          string `json:"model_structure,omitempty"`
+	ModelGeneration         string `json:"model_generation,omitempty"`
+	ModelAdherence          string `json:"model_adherence,omitempty"`
+	ModelPolishing          string `json:"model_polishing,omitempty"`
+}
+
+// Over returns the defaults with the values they leave unset taken from fallback
+func (defaults ExamGenerationDefaults) Over(fallback ExamGenerationDefaults) ExamGenerationDefaults {
+	if defaults.LanguageCode == "" {
+		defaults.LanguageCode = fallback.LanguageCode
+	}
+	if defaults.Length == "" {
+		defaults.Length = fallback.Length
+	}
+	if defaults.EnableDocumentsMatching == nil {
+		defaults.EnableDocumentsMatching = fallback.EnableDocumentsMatching
+	}
+	if defaults.AdherenceThreshold == nil {
+		defaults.AdherenceThreshold = fallback.AdherenceThreshold
+	}
+	if defaults.MaximumRetries == nil {
+		defaults.MaximumRetries = fallback.MaximumRetries
+	}
+	if defaults.ModelDocumentsMatching == "" {
+		defaults.ModelDocumentsMatching = fallback.ModelDocumentsMatching
+	}
+	if defaults.ModelStructure == "" {
+		defaults.ModelStructure = fallback.ModelStructure
+	}
+	if defaults.ModelGeneration == "" {
+		defaults.ModelGeneration = fallback.ModelGeneration
+	}
+	if defaults.ModelAdherence == "" {
+		defaults.ModelAdherence = fallback.ModelAdherence
+	}
+	if defaults.ModelPolishing == "" {
+		defaults.ModelPolishing = fallback.ModelPolishing
+	}
+	return defaults
+}
+
+// GenerationPreset is a named set of generation settings of a user, such as a cheap draft or a thorough build,
+// that tool generations apply with preset_id. Values a request sets win over the preset, and values the preset
+// leaves unset fall back to the exam's defaults
+type GenerationPreset struct {
+	ID          string                 `json:"id"`
+	Name        string                 `json:"name"`
+	Description string                 `json:"description,omitempty"`
+	Settings    ExamGenerationDefaults `json:"settings"`
+	Sampling    SamplingParameters     `json:"sampling"` // Under the sampling parameters of the request
+	CreatedAt   time.Time              `json:"created_at"`
+	UpdatedAt   time.Time              `json:"updated_at"`
+}
+
+// Lecture represents a single lesson or session
+type Lecture struct {
+	ID             string          `json:"id"`
+	ExamID         string          `json:"exam_id"`
+	Title          string          `json:"title"`
+	Description    string          `json:"description,omitempty"`
+	SpecifiedDate  *time.Time      `json:"specified_date,omitempty"`
+	Language       string          `json:"language,omitempty"`
+	Status         string          `json:"status"`          // "planned", "processing", "ready", "failed"
+	MetadataFields []MetadataField `json:"metadata_fields"` // On top of the fields of the exam, see MergeMetadataFields
+	EstimatedCost  float64         `json:"estimated_cost"`
+	CreatedAt      time.Time       `json:"created_at"`
+	UpdatedAt      time.Time       `json:"updated_at"`
+}
+
+// ProcessingReport summarizes how the media and documents of a lecture were processed, so users can judge
+// whether the automated results are trustworthy or worth redoing. It is generated when the lecture becomes ready
+type ProcessingReport struct {
+	LectureID                 string                      `json:"lecture_id"`
+	LectureTitle              string                      `json:"lecture_title"`
+	GeneratedAt               time.Time                   `json:"generated_at"`
+	Media                     []ProcessingReportMedia     `json:"media"`
+	TotalDurationMilliseconds int64                       `json:"total_duration_milliseconds"`
+	Transcript                *ProcessingReportTranscript `json:"transcript,omitempty"`
+	Documents                 []ProcessingReportDocument  `json:"documents"`
+	Stages                    []ProcessingReportStage     `json:"stages"`
+	TotalCost                 float64                     `json:"total_cost"`
+	Markdown                  string                      `json:"markdown"` // Human-readable rendering of the report
+}
+
+// ProcessingReportMedia is a recording of the lecture
+type ProcessingReportMedia struct {
+	Filename             string `json:"filename"`
+	DurationMilliseconds int64  `json:"duration_milliseconds"`
+}
+
+// ProcessingReportTranscript describes the transcript and how confident the transcription was
+type ProcessingReportTranscript struct {
+	Status                 string             `json:"status"`
+	SegmentCount           int                `json:"segment_count"`
+	AverageConfidence      float64            `json:"average_confidence"` // Over scored segments only
+	LowConfidenceSegments  int                `json:"low_confidence_segments"`
+	ConfidenceDistribution []ConfidenceBucket `json:"confidence_distribution"`
+	Cost                   float64            `json:"cost"`
+}
+
+// ConfidenceBucket counts the transcript segments whose confidence falls in a range
+type ConfidenceBucket struct {
+	Label string `json:"label"`
+	Count int    `json:"count"`
+}
+
+// ProcessingReportDocument describes the extraction of a reference document
+type ProcessingReportDocument struct {
+	Title      string  `json:"title"`
+	Status     string  `json:"status"`
+	PageCount  int     `json:"page_count"`
+	EmptyPages []int   `json:"empty_pages,omitempty"` // Pages from which no text was extracted
+	Cost       float64 `json:"cost"`
+}
+
+// ProcessingReportStage lists the attempts of a pipeline stage (transcription or document ingestion)
+type ProcessingReportStage struct {
+	JobType  string                    `json:"job_type"`
+	Attempts int                       `json:"attempts"`
+	Failures []ProcessingReportFailure `json:"failures,omitempty"`
+}
+
+// ProcessingReportFailure is a failed or cancelled attempt of a stage
+type ProcessingReportFailure struct {
+	JobID    string     `json:"job_id"`
+	Status   string     `json:"status"`
+	Error    string     `json:"error,omitempty"`
+	FailedAt *time.Time `json:"failed_at,omitempty"`
+}
+
+// LectureRecap is a short summary of what a lecture covered, generated by a cheap model once the lecture is ready
+type LectureRecap struct {
+	LectureID     string    `json:"lecture_id"`
+	Bullets       []string  `json:"bullets"`
 	Language      string    `json:"language,omitempty"`
+	Model         string    `json:"model,omitempty"`
 	EstimatedCost float64   `json:"estimated_cost"`
 	CreatedAt     time.Time `json:"created_at"`
-	UpdatedAt     time.Time `json:"updated_at"`
 }
 
-// Lecture represents a single lesson or session
-type Lecture struct {
-	ID            string     `json:"id"`
-	ExamID        string     `json:"exam_id"`
-	Title         string     `json:"title"`
-	Description   string     `json:"description,omitempty"`
-	SpecifiedDate *time.Time `json:"specified_date,omitempty"`
-	Language      string     `json:"language,omitempty"`
-	Status        string     `json:"status"` // "processing", "ready", "failed"
-	EstimatedCost float64    `json:"estimated_cost"`
-	CreatedAt     time.Time  `json:"created_at"`
-	UpdatedAt     time.Time  `json:"updated_at"`
+// ContentOverlap is material covered in more than one lecture of an exam, such as a re-used slide or an
+// explanation given twice; guides covering the exam should keep a single section on it and cite the occurrence at
+// CanonicalIndex, the clearest one
+type ContentOverlap struct {
+	ID             int64               `json:"id"`
+	ExamID         string              `json:"exam_id"`
+	Topic          string              `json:"topic"`
+	Similarity     float64             `json:"similarity"`
+	Occurrences    []ContentOccurrence `json:"occurrences"`
+	CanonicalIndex int                 `json:"canonical_index"`
+	CreatedAt      time.Time           `json:"created_at"`
+}
+
+// ContentOccurrence locates one occurrence of overlapping material: a document page or a transcript range
+type ContentOccurrence struct {
+	LectureID        string `json:"lecture_id"`
+	LectureTitle     string `json:"lecture_title"`
+	SourceType       string `json:"source_type"` // "document" or "transcript"
+	DocumentID       string `json:"document_id,omitempty"`
+	DocumentTitle    string `json:"document_title,omitempty"`
+	PageNumber       int    `json:"page_number,omitempty"`
+	StartMillisecond int64  `json:"start_millisecond,omitempty"`
+	EndMillisecond   int64  `json:"end_millisecond,omitempty"`
+	Excerpt          string `json:"excerpt"`
 }
 
 // LectureMedia represents audio or video files
@@ -72,109 +441,442 @@ type TranscriptSegment struct {
 	OriginalStartMilliseconds int64   `json:"original_start_milliseconds,omitempty"`
 	OriginalEndMilliseconds   int64   `json:"original_end_milliseconds,omitempty"`
 	Text                      string  `json:"text"`
+	PolishedText              string  `json:"polished_text,omitempty"`
 	Confidence                float64 `json:"confidence,omitempty"`
 	Speaker                   string  `json:"speaker,omitempty"`
 }
 
 // ReferenceDocument represents a PDF, PowerPoint, or other document
 type ReferenceDocument struct {
+	ID                 string                      `json:"id"`
+	LectureID          string                      `json:"lecture_id"`
+	DocumentType       string                      `json:"document_type"`
+	Title              string                      `json:"title"`
+	FilePath           string                      `json:"file_path"`
+	PageCount          int                         `json:"page_count"`
+	ExtractionStatus   string                      `json:"extraction_status"`
+	ExtractionMethod   string                      `json:"extraction_method,omitempty"`   // Overrides documents.extraction_method: "vision", "ocr" or "auto"
+	ExtractionMetadata *DocumentExtractionMetadata `json:"extraction_metadata,omitempty"` // How the pages were read, once extracted
+	EstimatedCost      float64                     `json:"estimated_cost"`
+	SourceURL          string                      `json:"source_url,omitempty"`        // Address of a document ingested from a webpage
+	Language           string                      `json:"language,omitempty"`          // Language the pages are read in; detected when empty
+	DetectedLanguage   string                      `json:"detected_language,omitempty"` // Language most pages turned out to be in, once extracted
+	CreatedAt          time.Time                   `json:"created_at"`
+	UpdatedAt          time.Time                   `json:"updated_at"`
+}
+
+// DocumentExtractionMetadata records how the text of a document's pages was obtained: interpreted by the vision
+// model, read from the text embedded in the PDF, or recognized locally with OCR
+type DocumentExtractionMetadata struct {
+	Method             string `json:"method"` // The method that applied: "vision", "ocr", "auto" or "math"
+	VisionPages        int    `json:"vision_pages"`
+	EmbeddedTextPages  int    `json:"embedded_text_pages"`
+	OCRPages           int    `json:"ocr_pages"`
+	MathPages          int    `json:"math_pages"`
+	EquationIssuePages []int  `json:"equation_issue_pages,omitempty"` // Math pages whose equations still do not parse
+	Reason             string `json:"reason,omitempty"`               // Why the vision model was not used, when it was not
+}
+
+// ReferencePage represents a page extracted from a document
+type ReferencePage struct {
+	ID            int                    `json:"id"`
+	DocumentID    string                 `json:"document_id"`
+	PageNumber    int                    `json:"page_number"`
+	ImagePath     string                 `json:"image_path"`
+	ExtractedText string                 `json:"extracted_text,omitempty"`
+	Metadata      *ReferencePageMetadata `json:"metadata,omitempty"`
+	// ExtractionSource is how the text was obtained: "vision", "embedded_text" or "ocr"
+	ExtractionSource string `json:"extraction_source,omitempty"`
+	// Language is the language code of the extracted text, so generation can tell quotes in another language
+	Language string `json:"language,omitempty"`
+}
+
+// ReferencePageMetadata keeps what a page carries over from its source format, such as the title and
+// speaker notes of a presentation slide
+type ReferencePageMetadata struct {
+	SlideTitle   string `json:"slide_title,omitempty"`
+	SpeakerNotes string `json:"speaker_notes,omitempty"`
+}
+
+// ReferenceChunk is a semantically coherent piece of a reference document, possibly spanning several pages.
+// Consecutive chunks overlap slightly so an idea cut at a boundary is still whole in one of them
+type ReferenceChunk struct {
+	ID         int    `json:"id"`
+	DocumentID string `json:"document_id"`
+	ChunkIndex int    `json:"chunk_index"`
+	StartPage  int    `json:"start_page"`
+	EndPage    int    `json:"end_page"`
+	Heading    string `json:"heading,omitempty"` // Closest heading above the chunk, if the page text has one
+	Content    string `json:"content"`
+}
+
+// Tool represents AI-generated study materials
+type Tool struct {
 	ID               string    `json:"id"`
-	LectureID        string    `json:"lecture_id"`
-	DocumentType     string    `json:"document_type"`
+	ExamID           string    `json:"exam_id"`
+	LectureID        string    `json:"lecture_id,omitempty"`
+	Type             string    `json:"type"`
 	Title            string    `json:"title"`
-	FilePath         string    `json:"file_path"`
-	PageCount        int       `json:"page_count"`
-	ExtractionStatus string    `json:"extraction_status"`
+	LanguageCode     string    `json:"language_code"`
+	Content          string    `json:"content"` // JSON string
 	EstimatedCost    float64   `json:"estimated_cost"`
+	PartialSources   bool      `json:"partial_sources"`            // Generated while the recording or a document had not finished processing
+	BookmarkUserID   string    `json:"bookmark_user_id,omitempty"` // Whose bookmarks a flashcard deck was generated from, kept apart from the lecture's deck
+	RedactionPending bool      `json:"redaction_pending"`          // A time range of the transcript was redacted since it was generated; regenerate it to be sure none is quoted
 	CreatedAt        time.Time `json:"created_at"`
 	UpdatedAt        time.Time `json:"updated_at"`
 }
 
-// ReferencePage represents a page extracted from a document
-type ReferencePage struct {
-	ID            int    `json:"id"`
-	DocumentID    string `json:"document_id"`
-	PageNumber    int    `json:"page_number"`
-	ImagePath     string `json:"image_path"`
-	ExtractedText string `json:"extracted_text,omitempty"`
+// ToolTypeCourseOverview is the type of the tools synthesizing every ready lecture of an exam, which belong to
+// the exam rather than to a lecture
+const ToolTypeCourseOverview = "course_overview"
+
+// ToolTypeMindmap is the type of the concept maps of a lecture, stored as a graph of concepts and the relations
+// between them and rendered as a Mermaid flowchart
+const ToolTypeMindmap = "mindmap"
+
+// ToolTypeMockExam is the type of the timed practice exams drawn from several lectures of an exam, which belong
+// to the exam rather than to a lecture
+const ToolTypeMockExam = "mock_exam"
+
+// ToolCitesSources reports whether tools of a type are Markdown documents citing reference pages with
+// {{{...}}} markers, rendered and exported as study guides are
+func ToolCitesSources(toolType string) bool {
+	return toolType == "guide" || toolType == ToolTypeCourseOverview
 }
 
-// Tool represents AI-generated study materials
-type Tool struct {
-	ID            string    `json:"id"`
-	ExamID        string    `json:"exam_id"`
-	LectureID     string    `json:"lecture_id,omitempty"`
-	Type          string    `json:"type"`
-	Title         string    `json:"title"`
-	LanguageCode  string    `json:"language_code"`
-	Content       string    `json:"content"` // JSON string
-	EstimatedCost float64   `json:"estimated_cost"`
-	CreatedAt     time.Time `json:"created_at"`
-	UpdatedAt     time.Time `json:"updated_at"`
+// ToolCoversExam reports whether tools of a type are built from the lectures of an exam and belong to none of them
+func ToolCoversExam(toolType string) bool {
+	return toolType == ToolTypeCourseOverview || toolType == ToolTypeMockExam
+}
+
+// ToolVersion is the content of a tool as it was before an edit, a regeneration or a deletion replaced it
+type ToolVersion struct {
+	ID             string    `json:"id"`
+	ToolID         string    `json:"tool_id"`
+	ExamID         string    `json:"exam_id"`
+	LectureID      string    `json:"lecture_id,omitempty"`
+	Type           string    `json:"type"`
+	Title          string    `json:"title"`
+	LanguageCode   string    `json:"language_code"`
+	Content        string    `json:"content,omitempty"`          // Omitted from listings
+	Reason         string    `json:"reason"`                     // What replaced it: "edited", "regenerated", "deleted" or "restored"
+	BookmarkUserID string    `json:"bookmark_user_id,omitempty"` // Set on versions of a deck generated from the bookmarks of a user
+	CreatedAt      time.Time `json:"created_at"`
+}
+
+// Flashcard is a single validated card stored in a flashcard tool's content
+type Flashcard struct {
+	Front string `json:"front"`
+	Back  string `json:"back"`
+	Image string `json:"image,omitempty"` // Mnemonic image path, relative to the tool's export directory
+}
+
+// Mindmap is the validated content of a mindmap tool: the concepts of a lecture and how they relate
+type Mindmap struct {
+	Nodes []MindmapNode `json:"nodes"`
+	Edges []MindmapEdge `json:"edges"`
+}
+
+// MindmapNode is a concept of a mindmap, identified within it by ID
+type MindmapNode struct {
+	ID          string `json:"id"`
+	Label       string `json:"label"`
+	Description string `json:"description,omitempty"`
+}
+
+// MindmapEdge is a relation from one concept of a mindmap to another, such as "is a kind of" or "causes"
+type MindmapEdge struct {
+	From  string `json:"from"`
+	To    string `json:"to"`
+	Label string `json:"label,omitempty"`
+}
+
+// Quiz question types
+const (
+	QuizQuestionMultipleChoice = "multiple_choice"
+	QuizQuestionMatching       = "matching"
+	QuizQuestionOrdering       = "ordering"
+	QuizQuestionNumeric        = "numeric"
+	QuizQuestionFreeResponse   = "free_response"
+)
+
+// QuizQuestion is a single validated question stored in a quiz tool's content. Only the fields of its type are set;
+// quizzes generated before the other types were added have no type and are multiple choice
+type QuizQuestion struct {
+	Type          string                `json:"type,omitempty"`
+	Question      string                `json:"question"`
+	Options       []string              `json:"options,omitempty"`        // Multiple choice
+	CorrectAnswer string                `json:"correct_answer,omitempty"` // The exact text of one of the options, or a model answer to a free response question
+	Pairs         []QuizPair            `json:"pairs,omitempty"`          // Matching, each left item with its right one
+	Items         []string              `json:"items,omitempty"`          // Ordering, in the correct order
+	Answer        *float64              `json:"answer,omitempty"`         // Numeric
+	Tolerance     float64               `json:"tolerance,omitempty"`      // Numeric, the largest accepted absolute difference from the answer
+	Unit          string                `json:"unit,omitempty"`           // Numeric
+	Rubric        []QuizRubricCriterion `json:"rubric,omitempty"`         // Free response
+	Explanation   string                `json:"explanation"`
+}
+
+// QuestionType returns the type of the question, multiple choice when it has none
+func (question QuizQuestion) QuestionType() string {
+	if question.Type == "" {
+		return QuizQuestionMultipleChoice
+	}
+	return question.Type
+}
+
+// QuizPair is a left item of a matching question with the right item it matches
+type QuizPair struct {
+	Left  string `json:"left"`
+	Right string `json:"right"`
+}
+
+// QuizRubricCriterion is a point a free response answer is graded on, worth Points when fully met
+type QuizRubricCriterion struct {
+	Criterion string `json:"criterion"`
+	Points    int    `json:"points"`
+}
+
+// QuizAnswer is the answer given to one quiz question; only the field of the question's type is read
+type QuizAnswer struct {
+	Choice  string            `json:"choice,omitempty"`  // Multiple choice, the selected option
+	Matches map[string]string `json:"matches,omitempty"` // Matching, the right item chosen for each left item
+	Order   []string          `json:"order,omitempty"`   // Ordering, the items in the order given
+	Number  *float64          `json:"number,omitempty"`  // Numeric
+	Text    string            `json:"text,omitempty"`    // Free response
+}
+
+// QuizQuestionResult is the grade of one answer. Every question is worth 1; matching, ordering and free response
+// questions earn partial credit
+type QuizQuestionResult struct {
+	Type     string  `json:"type"`
+	Score    float64 `json:"score"`
+	Correct  bool    `json:"correct"`
+	Feedback string  `json:"feedback,omitempty"`
+}
+
+// QuizAttempt is a graded submission of answers to a quiz, one per question in order
+type QuizAttempt struct {
+	ID            string               `json:"id"`
+	ToolID        string               `json:"tool_id"`
+	UserID        string               `json:"user_id"`
+	Answers       []QuizAnswer         `json:"answers"`
+	Results       []QuizQuestionResult `json:"results"`
+	Score         float64              `json:"score"`
+	MaximumScore  float64              `json:"maximum_score"`
+	EstimatedCost float64              `json:"estimated_cost"`
+	CreatedAt     time.Time            `json:"created_at"`
+}
+
+// LectureBookmark is a named moment of a lecture a user marked for themselves, on the timeline of its transcript
+type LectureBookmark struct {
+	ID          string    `json:"id"`
+	LectureID   string    `json:"lecture_id"`
+	UserID      string    `json:"user_id"`
+	Name        string    `json:"name"`
+	Millisecond int64     `json:"millisecond"`
+	SegmentID   int       `json:"segment_id,omitempty"` // Transcript segment spoken at the bookmark, when listed with the transcript
+	CreatedAt   time.Time `json:"created_at"`
+	UpdatedAt   time.Time `json:"updated_at"`
+}
+
+// TranscriptRedaction hides a phrase or a time range of a lecture transcript, from its text and from everything
+// generated from it. Redactions are kept so they are applied again whenever the transcript is rewritten
+type TranscriptRedaction struct {
+	ID               int64     `json:"id"`
+	LectureID        string    `json:"lecture_id"`
+	Phrase           string    `json:"phrase,omitempty"`            // Hidden wherever it is said, as whole words and in any case
+	StartMillisecond *int64    `json:"start_millisecond,omitempty"` // Segments overlapping the range are hidden entirely
+	EndMillisecond   *int64    `json:"end_millisecond,omitempty"`
+	BleepAudio       bool      `json:"bleep_audio"`
+	UserID           string    `json:"user_id,omitempty"`
+	CreatedAt        time.Time `json:"created_at"`
+}
+
+// RedactedText replaces redacted phrases and segments
+const RedactedText = "[redacted]"
+
+// TimeSpan is a span of the timeline of a lecture transcript
+type TimeSpan struct {
+	StartMillisecond int64 `json:"start_millisecond"`
+	EndMillisecond   int64 `json:"end_millisecond"`
 }
 
 // ChatSession represents a conversation scoped to an exam
 type ChatSession struct {
-	ID            string    `json:"id"`
-	ExamID        string    `json:"exam_id"`
-	Title         string    `json:"title,omitempty"`
-	EstimatedCost float64   `json:"estimated_cost"`
-	CreatedAt     time.Time `json:"created_at"`
-	UpdatedAt     time.Time `json:"updated_at"`
+	ID            string             `json:"id"`
+	ExamID        string             `json:"exam_id"`
+	UserID        string             `json:"user_id"` // The user who started it, the only one continuing it
+	Title         string             `json:"title,omitempty"`
+	EstimatedCost float64            `json:"estimated_cost"`
+	Sampling      SamplingParameters `json:"sampling"` // Tunes the replies of the assistant; messages can override it
+	CreatedAt     time.Time          `json:"created_at"`
+	UpdatedAt     time.Time          `json:"updated_at"`
 }
 
 // ChatMessage represents a single message in a chat session
 type ChatMessage struct {
-	ID            string    `json:"id"`
-	SessionID     string    `json:"session_id"`
-	Role          string    `json:"role"` // "user", "assistant", "system"
-	Content       string    `json:"content"`
-	ContentHTML   string    `json:"content_html,omitempty"`
-	ModelUsed     string    `json:"model_used,omitempty"`
-	Metadata      string    `json:"metadata,omitempty"`
-	InputTokens   int       `json:"input_tokens,omitempty"`
-	OutputTokens  int       `json:"output_tokens,omitempty"`
-	EstimatedCost float64   `json:"estimated_cost,omitempty"`
-	CreatedAt     time.Time `json:"created_at"`
+	ID            string         `json:"id"`
+	SessionID     string         `json:"session_id"`
+	Role          string         `json:"role"` // "user", "assistant", "system"
+	Content       string         `json:"content"`
+	ContentHTML   string         `json:"content_html,omitempty"`
+	ModelUsed     string         `json:"model_used,omitempty"`
+	Metadata      string         `json:"metadata,omitempty"`
+	InputTokens   int            `json:"input_tokens,omitempty"`
+	OutputTokens  int            `json:"output_tokens,omitempty"`
+	EstimatedCost float64        `json:"estimated_cost,omitempty"`
+	Citations     []ChatCitation `json:"citations,omitempty"` // Retrieved chunks the answer was grounded in
+	CreatedAt     time.Time      `json:"created_at"`
+}
+
+// ChatCitation points at a transcript range or reference page that was retrieved as context for a chat answer
+type ChatCitation struct {
+	SourceType       string  `json:"source_type"` // "transcript" or "slide"
+	SourceID         string  `json:"source_id"`   // Transcript ID or reference document ID
+	LectureID        string  `json:"lecture_id"`
+	Label            string  `json:"label"`
+	PageNumber       int     `json:"page_number,omitempty"`
+	StartMillisecond int64   `json:"start_millisecond,omitempty"`
+	EndMillisecond   int64   `json:"end_millisecond,omitempty"`
+	Snippet          string  `json:"snippet"`
+	Score            float64 `json:"score"`
 }
 
 // JobMetrics contains token usage and cost information
 type JobMetrics struct {
-	InputTokens   int
-	OutputTokens  int
-	EstimatedCost float64
+	InputTokens          int
+	OutputTokens         int
+	EstimatedCost        float64
+	EstimatedInputTokens int // Prompt size predicted before the calls, to compare with the InputTokens reported by providers
+}
+
+// Phases of BUILD_MATERIAL jobs, reported in the phase of their BuildProgress
+const (
+	BuildPhaseGatheringLectures   = "gathering_lectures" // Course overviews and mock exams, reading the lectures of the exam
+	BuildPhaseMatchingDocuments   = "matching_documents"
+	BuildPhaseAnalyzingStructure  = "analyzing_structure"
+	BuildPhaseGeneratingSections  = "generating_sections"
+	BuildPhaseGenerating          = "generating" // Flashcards, quizzes, mindmaps and mock exams, generated in a single call
+	BuildPhaseProcessingFootnotes = "processing_footnotes"
+	BuildPhaseLocatingCitations   = "locating_citations"
+	BuildPhaseGeneratingImages    = "generating_images"
+	BuildPhaseFinalizing          = "finalizing"
+)
+
+// BuildProgress is the metadata of the progress updates of BUILD_MATERIAL jobs, so clients can show each phase
+// of a build rather than a single percentage. Phases with nothing to do, such as the footnotes of a guide
+// without citations, are skipped
+type BuildProgress struct {
+	Phase         string                `json:"phase"`
+	PhaseIndex    int                   `json:"phase_index"` // Position of the phase among those of the tool type, from 1
+	TotalPhases   int                   `json:"total_phases"`
+	Section       *BuildSectionProgress `json:"section,omitempty"`
+	Attempt       int                   `json:"attempt,omitempty"` // Attempt of the current generation, above 1 when it is retried
+	InputTokens   int                   `json:"input_tokens"`      // Spent so far by the job
+	OutputTokens  int                   `json:"output_tokens"`
+	EstimatedCost float64               `json:"estimated_cost"`
+}
+
+// BuildSectionProgress is how far the sections of a study guide have come, and the section an update is about
+type BuildSectionProgress struct {
+	Index     int    `json:"index"` // Position of the section in the outline, from 1
+	Title     string `json:"title"`
+	Completed int    `json:"completed"`
+	Total     int    `json:"total"`
+}
+
+// BuildPhases returns the phases a BUILD_MATERIAL job goes through for a tool type, in order
+func BuildPhases(toolType string, generateImages bool) []string {
+	switch toolType {
+	case "flashcard":
+		if generateImages {
+			return []string{BuildPhaseGenerating, BuildPhaseGeneratingImages, BuildPhaseFinalizing}
+		}
+		return []string{BuildPhaseGenerating, BuildPhaseFinalizing}
+	case "quiz", ToolTypeMindmap:
+		return []string{BuildPhaseGenerating, BuildPhaseFinalizing}
+	case ToolTypeMockExam:
+		return []string{BuildPhaseGatheringLectures, BuildPhaseGenerating, BuildPhaseFinalizing}
+	case ToolTypeCourseOverview:
+		return []string{
+			BuildPhaseGatheringLectures, BuildPhaseAnalyzingStructure, BuildPhaseGeneratingSections,
+			BuildPhaseProcessingFootnotes, BuildPhaseFinalizing,
+		}
+	}
+	return []string{
+		BuildPhaseMatchingDocuments, BuildPhaseAnalyzingStructure, BuildPhaseGeneratingSections,
+		BuildPhaseProcessingFootnotes, BuildPhaseLocatingCitations, BuildPhaseFinalizing,
+	}
+}
+
+// Within completes the progress with the position of its phase among the phases of a build and what the job
+// spent so far
+func (progress BuildProgress) Within(phases []string, metrics JobMetrics) BuildProgress {
+	progress.PhaseIndex = slices.Index(phases, progress.Phase) + 1
+	progress.TotalPhases = len(phases)
+	progress.InputTokens = metrics.InputTokens
+	progress.OutputTokens = metrics.OutputTokens
+	progress.EstimatedCost = metrics.EstimatedCost
+	return progress
 }
 
 // Job represents a background task
 type Job struct {
-	ID                  string     `json:"id"`
-	UserID              string     `json:"user_id"`
-	CourseID            string     `json:"course_id,omitempty"`
-	LectureID           string     `json:"lecture_id,omitempty"`
-	Type                string     `json:"type"`
-	Status              string     `json:"status"`
-	Progress            int        `json:"progress"`
-	ProgressMessageText string     `json:"progress_message_text,omitempty"`
-	Payload             string     `json:"payload"`          // JSON string
-	Result              string     `json:"result,omitempty"` // JSON string
-	Error               string     `json:"error,omitempty"`
-	Metadata            any        `json:"metadata,omitempty"` // Additional context for progress
-	InputTokens         int        `json:"input_tokens,omitempty"`
-	OutputTokens        int        `json:"output_tokens,omitempty"`
-	EstimatedCost       float64    `json:"estimated_cost,omitempty"`
-	CreatedAt           time.Time  `json:"created_at"`
-	StartedAt           *time.Time `json:"started_at,omitempty"`
-	CompletedAt         *time.Time `json:"completed_at,omitempty"`
+	ID                   string      `json:"id"`
+	UserID               string      `json:"user_id"`
+	CourseID             string      `json:"course_id,omitempty"`
+	LectureID            string      `json:"lecture_id,omitempty"`
+	Type                 string      `json:"type"`
+	Status               string      `json:"status"`
+	Priority             string      `json:"priority,omitempty"`
+	DependsOn            []string    `json:"depends_on,omitempty"` // Jobs that must complete before this one starts
+	Progress             int         `json:"progress"`
+	ProgressMessageText  string      `json:"progress_message_text,omitempty"`
+	Payload              string      `json:"payload"`          // JSON string
+	Result               string      `json:"result,omitempty"` // JSON string
+	Error                string      `json:"error,omitempty"`
+	Failure              *JobFailure `json:"failure,omitempty"`
+	Lock                 *JobLock    `json:"lock,omitempty"`            // Resource an active job locks, if any
+	PauseRequested       bool        `json:"pause_requested,omitempty"` // The running job stops at its next page or section
+	PausedAt             *time.Time  `json:"paused_at,omitempty"`       // Set while a pending job is paused and not started
+	RequeuedAs           string      `json:"requeued_as,omitempty"`     // Job that replaced this failed one when it was requeued
+	Label                string      `json:"label,omitempty"`           // Short tag the user gave the job to find it again
+	Note                 string      `json:"note,omitempty"`            // Free text the user attached, such as why it was queued
+	Metadata             any         `json:"metadata,omitempty"`        // Additional context for progress
+	InputTokens          int         `json:"input_tokens,omitempty"`
+	EstimatedInputTokens int         `json:"estimated_input_tokens,omitempty"`
+	OutputTokens         int         `json:"output_tokens,omitempty"`
+	EstimatedCost        float64     `json:"estimated_cost,omitempty"`
+	CreatedAt            time.Time   `json:"created_at"`
+	StartedAt            *time.Time  `json:"started_at,omitempty"`
+	CompletedAt          *time.Time  `json:"completed_at,omitempty"`
 }
 
+// JobPriority constants, pending jobs being started by priority and then in the order they were enqueued
+const (
+	JobPriorityHigh   = "high"
+	JobPriorityNormal = "normal"
+	JobPriorityLow    = "low"
+)
+
 // JobType constants
 const (
 	JobTypeTranscribeMedia     = "TRANSCRIBE_MEDIA"
 	JobTypeIngestDocuments     = "INGEST_DOCUMENTS"
 	JobTypeBuildMaterial       = "BUILD_MATERIAL"
 	JobTypePublishMaterial     = "PUBLISH_MATERIAL"
+	JobTypePublishBundle       = "PUBLISH_BUNDLE"
 	JobTypeSuggest             = "SUGGEST"
+	JobTypePolishTranscript    = "POLISH_TRANSCRIPT"
 	JobTypeDownloadGoogleDrive = "DOWNLOAD_GOOGLE_DRIVE"
+	JobTypeIngestURL           = "INGEST_URL"
+	JobTypeImportYouTube       = "IMPORT_YOUTUBE"
+	JobTypeGenerateRecap       = "GENERATE_RECAP"
+	JobTypeAnalyzeDuplicates   = "ANALYZE_DUPLICATES"
+	JobTypeBackup              = "BACKUP"
+	JobTypeRedactMedia         = "REDACT_MEDIA"
+	JobTypeImportSyllabus      = "IMPORT_SYLLABUS"
 )
 
 // JobStatus constants
@@ -186,6 +888,174 @@ const (
 	JobStatusCancelled = "CANCELLED"
 )
 
+// JobLock describes the resource a pending or running job locks, jobs locking the same resource running one at a time
+type JobLock struct {
+	Resource    string `json:"resource"`                // e.g. "lecture:<id>:guide" or "tool:<id>"
+	State       string `json:"state"`                   // One of the JobLockState* constants
+	HolderJobID string `json:"holder_job_id,omitempty"` // Job running with the lock; set unless the state is free
+}
+
+// JobLockState constants
+const (
+	JobLockStateHeld    = "held"    // The job is running with the lock
+	JobLockStateWaiting = "waiting" // Another job holds the lock, so the job waits for it to finish
+	JobLockStateFree    = "free"    // Nobody holds the lock; the job takes it when it starts
+)
+
+// JobLog is a line logged by the server while running a job, kept so users can see why a step was retried or
+// rejected
+type JobLog struct {
+	Sequence   int64          `json:"sequence"` // Increasing across all jobs, so clients ask for the lines after the last one they got
+	JobID      string         `json:"job_id"`
+	Level      string         `json:"level"` // DEBUG, INFO, WARN or ERROR
+	Message    string         `json:"message"`
+	Attributes map[string]any `json:"attributes,omitempty"`
+	CreatedAt  time.Time      `json:"created_at"`
+}
+
+// JobFailure explains why a job failed in terms a frontend can act on; the raw error stays in Job.Error
+type JobFailure struct {
+	Code      string `json:"code"`            // One of the JobFailure* codes
+	Phase     string `json:"phase,omitempty"` // Progress message of the job when it failed
+	Retryable bool   `json:"retryable"`       // Whether running the job again may succeed as is
+	Action    string `json:"action"`          // One of the JobAction* constants
+	Message   string `json:"message"`         // Explanation meant for users
+}
+
+// JobFailure codes
+const (
+	JobFailureInvalidRequest      = "INVALID_REQUEST"
+	JobFailureNotFound            = "NOT_FOUND"
+	JobFailureProviderUnavailable = "PROVIDER_UNAVAILABLE"
+	JobFailureProviderRejected    = "PROVIDER_REJECTED"
+	JobFailureRateLimited         = "RATE_LIMITED"
+	JobFailurePromptTooLarge      = "PROMPT_TOO_LARGE"
+	JobFailureMediaUnreadable     = "MEDIA_UNREADABLE"
+	JobFailureMissingDependency   = "MISSING_DEPENDENCY"
+	JobFailureTimeout             = "TIMEOUT"
+	JobFailureInterrupted         = "INTERRUPTED"
+	JobFailureFailedByOperator    = "FAILED_BY_OPERATOR"
+	JobFailureDependencyFailed    = "DEPENDENCY_FAILED"
+	JobFailureBudgetExceeded      = "BUDGET_EXCEEDED"
+	JobFailureInternal            = "INTERNAL_ERROR"
+)
+
+// JobAction constants suggest what the user can do about a failed job
+const (
+	JobActionRetry        = "retry"
+	JobActionRetryLater   = "retry_later"
+	JobActionCheckInput   = "check_input"
+	JobActionReduceInput  = "reduce_input"
+	JobActionContactAdmin = "contact_admin"
+)
+
+// SystemAnnouncement is an operator-managed banner shown to every user
+type SystemAnnouncement struct {
+	Kind      string     `json:"kind"`  // maintenance, outage, budget, or info
+	Level     string     `json:"level"` // info, warning, or critical
+	Message   string     `json:"message"`
+	StartsAt  *time.Time `json:"starts_at,omitempty"`
+	EndsAt    *time.Time `json:"ends_at,omitempty"` // The announcement disappears on its own once passed
+	UpdatedAt time.Time  `json:"updated_at"`
+}
+
+// Announcement kinds and levels
+const (
+	AnnouncementKindMaintenance = "maintenance"
+	AnnouncementKindOutage      = "outage"
+	AnnouncementKindBudget      = "budget"
+	AnnouncementKindInfo        = "info"
+
+	AnnouncementLevelInfo     = "info"
+	AnnouncementLevelWarning  = "warning"
+	AnnouncementLevelCritical = "critical"
+)
+
+// Upload is a staged upload session, persisted so its progress survives restarts and is visible from any device
+type Upload struct {
+	ID            string     `json:"id"`
+	UserID        string     `json:"user_id"`
+	Filename      string     `json:"filename"`
+	FileSizeBytes int64      `json:"file_size_bytes"`
+	BytesReceived int64      `json:"bytes_received"`
+	Status        string     `json:"status"` // uploading or staged
+	LastChunkAt   *time.Time `json:"last_chunk_at,omitempty"`
+	CreatedAt     time.Time  `json:"created_at"`
+	UpdatedAt     time.Time  `json:"updated_at"`
+}
+
+// Upload statuses
+const (
+	UploadStatusUploading = "uploading"
+	UploadStatusStaged    = "staged"
+)
+
+// Resource types of the history of an exam
+const (
+	ResourceTypeExam       = "exam"
+	ResourceTypeLecture    = "lecture"
+	ResourceTypeDocument   = "document"
+	ResourceTypeMedia      = "media"
+	ResourceTypeTranscript = "transcript"
+	ResourceTypeTool       = "tool"
+	ResourceTypeExport     = "export"
+)
+
+// Actions recorded in the history of an exam
+const (
+	ResourceActionCreated     = "created"
+	ResourceActionRenamed     = "renamed"
+	ResourceActionUpdated     = "updated"
+	ResourceActionDeleted     = "deleted"
+	ResourceActionAdded       = "added"
+	ResourceActionRemoved     = "removed"
+	ResourceActionEdited      = "edited"
+	ResourceActionGenerated   = "generated"
+	ResourceActionRegenerated = "regenerated"
+	ResourceActionRestored    = "restored"
+	ResourceActionExported    = "exported"
+)
+
+// ResourceEvent is an entry of the history of an exam: something that happened to the exam, one of its
+// lectures or their documents, media, transcripts, tools and exports
+type ResourceEvent struct {
+	ID           int64              `json:"id"`
+	ExamID       string             `json:"exam_id"`
+	LectureID    string             `json:"lecture_id,omitempty"`
+	ResourceType string             `json:"resource_type"`
+	ResourceID   string             `json:"resource_id"`
+	Action       string             `json:"action"`
+	Summary      string             `json:"summary"`
+	UserID       string             `json:"user_id,omitempty"`
+	Username     string             `json:"username,omitempty"`
+	JobID        string             `json:"job_id,omitempty"`     // Job that made the change, or produced the export
+	VersionID    string             `json:"version_id,omitempty"` // Tool version holding the content the change replaced
+	Undo         *ResourceEventUndo `json:"undo,omitempty"`
+	CreatedAt    time.Time          `json:"created_at"`
+}
+
+// ResourceEventUndo is the request that reverts an event, for clients to offer as an undo action
+type ResourceEventUndo struct {
+	Description string         `json:"description"`
+	Method      string         `json:"method"`
+	Path        string         `json:"path"`
+	Body        map[string]any `json:"body"`
+}
+
+// ExportPreset is a named set of export formats and options, scoped to an exam or to all of a user's exams
+type ExportPreset struct {
+	ID            string    `json:"id"`
+	UserID        string    `json:"user_id"`
+	ExamID        string    `json:"exam_id,omitempty"`
+	Name          string    `json:"name"`
+	Formats       []string  `json:"formats"`
+	Theme         string    `json:"theme,omitempty"`
+	IncludeImages bool      `json:"include_images"`
+	IncludeQRCode bool      `json:"include_qr_code"`
+	CreatedAt     time.Time `json:"created_at"`
+	UpdatedAt     time.Time `json:"updated_at"`
+}
+
 // APIResponse represents a standard API response
 type APIResponse struct {
 	Data interface{} `json:"data,omitempty"`
@@ -202,6 +1072,8 @@ type APIError struct {
 type Meta struct {
 	Timestamp string `json:"timestamp"`
 	RequestID string `json:"request_id"`
+	// NextCursor is the cursor of the next page of a list, absent on its last page
+	NextCursor string `json:"next_cursor,omitempty"`
 }
 
 // ErrorDetails contains error information
@@ -218,7 +1090,78 @@ type GenerationOptions struct {
 	ModelGeneration         string `json:"model_generation"`
 	ModelAdherence          string `json:"model_adherence"`
 	ModelPolishing          string `json:"model_polishing"`
-	AdherenceThreshold      int    `json:"adherence_threshold"`
-	MaximumRetries          int    `json:"maximum_retries"`
+	AdherenceThreshold      *int   `json:"adherence_threshold"` // nil for the default of 70
+	MaximumRetries          *int   `json:"maximum_retries"`     // nil for the configured safety.maximum_retries
 	EnableDocumentsMatching bool   `json:"enable_documents_matching"`
+	// Sampling tunes every model call of the generation. Jobs attach it to their context with WithSampling, which
+	// is where the calls read it from
+	Sampling SamplingParameters `json:"sampling"`
+
+	// SourceLanguages lists the languages of reference pages other than the tool's, which generation translates
+	// or quotes verbatim as documents.foreign_quotes says
+	SourceLanguages []string `json:"-"`
+	// PromptVariants replaces prompt templates, keyed by prompt path, with the variants assigned to the job
+	PromptVariants map[string]PromptVariant `json:"prompt_variants,omitempty"`
+	// TemplateVariables fills the {{metadata_...}} placeholders of the prompts with the metadata fields of the
+	// exam and lecture, see MetadataTemplateVariables
+	TemplateVariables map[string]string `json:"-"`
+	// OnAdherenceScore receives the adherence score of each accepted section of a study guide, for experiment tracking
+	OnAdherenceScore func(score int) `json:"-"`
+	// OnOutline receives the outline of a study guide once analyzed and OnSectionAccepted each section once
+	// accepted, so they can be stored while the guide is generated
+	OnOutline         func(outline ToolSection) `json:"-"`
+	OnSectionAccepted func(section ToolSection) `json:"-"`
+	// ResumeOutline and ResumeSections, keyed by position, come from an interrupted study guide generation: the
+	// outline is reused and only the sections missing from ResumeSections are generated
+	ResumeOutline  string         `json:"-"`
+	ResumeSections map[int]string `json:"-"`
+}
+
+// ToolSection is a stored piece of a generated study guide: its outline at level 1, then each accepted section at
+// level 2 under it, with how it was generated
+type ToolSection struct {
+	ID             int64     `json:"id"`
+	JobID          string    `json:"job_id"`
+	ToolID         string    `json:"tool_id,omitempty"`
+	ExamID         string    `json:"exam_id"`
+	LectureID      string    `json:"lecture_id,omitempty"` // Empty for the sections of a course overview
+	ParentID       int64     `json:"parent_id,omitempty"`
+	Level          int       `json:"level"`
+	Position       int       `json:"position"`
+	Title          string    `json:"title"`
+	Coverage       string    `json:"coverage,omitempty"` // What the outline asked the section to cover
+	Content        string    `json:"content"`
+	Model          string    `json:"model"`
+	Attempts       int       `json:"attempts"`
+	AdherenceScore *int      `json:"adherence_score,omitempty"` // Missing when the section was not verified
+	InputTokens    int       `json:"input_tokens"`
+	OutputTokens   int       `json:"output_tokens"`
+	EstimatedCost  float64   `json:"estimated_cost"`
+	CreatedAt      time.Time `json:"created_at"`
+}
+
+// PromptVariant is an alternative template for a prompt, tried against the prompt file in experiments
+type PromptVariant struct {
+	ID         string    `json:"id"`
+	PromptPath string    `json:"prompt_path"`
+	Name       string    `json:"name"`
+	Content    string    `json:"content"`
+	Weight     float64   `json:"weight"` // Relative chance of assignment; the prompt file weighs 1
+	Active     bool      `json:"active"`
+	CreatedAt  time.Time `json:"created_at"`
+}
+
+// PromptVariantStatistics summarizes the jobs a variant was assigned to. The prompt file itself is reported as
+// the "baseline" variant with an empty ID
+type PromptVariantStatistics struct {
+	VariantID        string  `json:"variant_id"`
+	Name             string  `json:"name"`
+	PromptPath       string  `json:"prompt_path"`
+	Active           bool    `json:"active"`
+	Weight           float64 `json:"weight"`
+	Assignments      int     `json:"assignments"`
+	AdherenceScores  int     `json:"adherence_scores"`
+	AverageAdherence float64 `json:"average_adherence"`
+	Ratings          int     `json:"ratings"`
+	AverageRating    float64 `json:"average_rating"`
 }
@@ -0,0 +1,28 @@
+package models
+
+import (
+	"context"
+	"errors"
+)
+
+// ErrJobPaused is returned by work stopped at a stage boundary because its job was paused. Handlers return it
+// wrapped or as is once they stored what they need to continue, and the job waits to be resumed
+var ErrJobPaused = errors.New("job paused")
+
+type pauseCheckKey struct{}
+
+// WithPauseCheck attaches to a job context the function reporting whether the job was asked to pause.
+// Long stages read it back with CheckPause between pages or sections
+func WithPauseCheck(parent context.Context, pauseRequested func() bool) context.Context {
+	return context.WithValue(parent, pauseCheckKey{}, pauseRequested)
+}
+
+// CheckPause returns ErrJobPaused when the job running with jobContext was asked to pause, and nil otherwise,
+// including for contexts without a pause check
+func CheckPause(jobContext context.Context) error {
+	pauseRequested, _ := jobContext.Value(pauseCheckKey{}).(func() bool)
+	if pauseRequested != nil && pauseRequested() {
+		return ErrJobPaused
+	}
+	return nil
+}
@@ -0,0 +1,64 @@
+package models
+
+import (
+	"context"
+	"errors"
+)
+
+// SamplingParameters tune how a model samples its answer. Unset parameters keep the defaults of the model, and
+// a seed makes generations reproducible on the providers and models supporting it
+type SamplingParameters struct {
+	Temperature *float64 `json:"temperature,omitempty"` // From 0, the most deterministic, to 2
+	TopP        *float64 `json:"top_p,omitempty"`       // Above 0 and at most 1
+	Seed        *int     `json:"seed,omitempty"`
+	MaxTokens   int      `json:"max_tokens,omitempty"` // Limit of the answer, the caller's own limit when 0
+}
+
+// Validate returns an error naming the first parameter out of its range
+func (sampling SamplingParameters) Validate() error {
+	if sampling.Temperature != nil && (*sampling.Temperature < 0 || *sampling.Temperature > 2) {
+		return errors.New("temperature must be between 0 and 2")
+	}
+	if sampling.TopP != nil && (*sampling.TopP <= 0 || *sampling.TopP > 1) {
+		return errors.New("top_p must be above 0 and at most 1")
+	}
+	if sampling.MaxTokens < 0 {
+		return errors.New("max_tokens cannot be negative")
+	}
+	return nil
+}
+
+// IsZero reports whether no parameter is set
+func (sampling SamplingParameters) IsZero() bool {
+	return sampling.Temperature == nil && sampling.TopP == nil && sampling.Seed == nil && sampling.MaxTokens == 0
+}
+
+// Merge returns the parameters with those set in override replacing them
+func (sampling SamplingParameters) Merge(override SamplingParameters) SamplingParameters {
+	if override.Temperature != nil {
+		sampling.Temperature = override.Temperature
+	}
+	if override.TopP != nil {
+		sampling.TopP = override.TopP
+	}
+	if override.Seed != nil {
+		sampling.Seed = override.Seed
+	}
+	if override.MaxTokens > 0 {
+		sampling.MaxTokens = override.MaxTokens
+	}
+	return sampling
+}
+
+type samplingKey struct{}
+
+// WithSampling attaches to a job context the sampling parameters its model calls use
+func WithSampling(parent context.Context, sampling SamplingParameters) context.Context {
+	return context.WithValue(parent, samplingKey{}, sampling)
+}
+
+// SamplingFromContext returns the sampling parameters attached to a context, none being set otherwise
+func SamplingFromContext(jobContext context.Context) SamplingParameters {
+	sampling, _ := jobContext.Value(samplingKey{}).(SamplingParameters)
+	return sampling
+}
@@ -0,0 +1,100 @@
+// Package netguard keeps the requests the server makes to addresses chosen by users, such as webhooks and
+// reference webpages, from reaching the server itself or the private network it runs in.
+package netguard
+
+import (
+	"errors"
+	"net"
+	"net/http"
+	"net/url"
+	"syscall"
+)
+
+// ErrPrivateAddress rejects connections to loopback, private and link-local addresses
+var ErrPrivateAddress = errors.New("private network addresses cannot be reached")
+
+// maximumRedirects matches the limit of the standard library client
+const maximumRedirects = 10
+
+// Ranges that are not reachable from the internet but that the net package does not classify: "this network",
+// the shared address space of carrier-grade NAT and local-use NAT64 prefixes
+var privateNetworks = []*net.IPNet{
+	mustParseNetwork("0.0.0.0/8"),
+	mustParseNetwork("100.64.0.0/10"),
+	mustParseNetwork("64:ff9b:1::/48"),
+}
+
+// nat64Network is the well-known NAT64 prefix, whose addresses reach the IPv4 address in their last 32 bits
+var nat64Network = mustParseNetwork("64:ff9b::/96")
+
+func mustParseNetwork(cidr string) *net.IPNet {
+	_, network, err := net.ParseCIDR(cidr)
+	if err != nil {
+		panic(err)
+	}
+	return network
+}
+
+// IsPrivateAddress reports whether an address is not reachable from the internet. Addresses translated by NAT64
+// are judged by the IPv4 address they reach
+func IsPrivateAddress(ip net.IP) bool {
+	if nat64Network.Contains(ip) {
+		return IsPrivateAddress(net.IP(ip.To16()[12:]))
+	}
+	for _, network := range privateNetworks {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return ip.IsLoopback() || ip.IsPrivate() || ip.IsUnspecified() || ip.IsLinkLocalUnicast() ||
+		ip.IsLinkLocalMulticast() || ip.IsInterfaceLocalMulticast()
+}
+
+// Control refuses connections to private addresses. Set as the Control of a net.Dialer, it checks the address
+// once resolved, so host names pointing to private addresses are caught too
+func Control(network string, address string, _ syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	if ip := net.ParseIP(host); ip == nil || IsPrivateAddress(ip) {
+		return ErrPrivateAddress
+	}
+	return nil
+}
+
+// CheckURL rejects the URLs whose host is localhost or a literal private address, before anything is resolved
+func CheckURL(target *url.URL) error {
+	if ip := net.ParseIP(target.Hostname()); (ip != nil && IsPrivateAddress(ip)) || target.Hostname() == "localhost" {
+		return ErrPrivateAddress
+	}
+	return nil
+}
+
+// Transport returns a transport dialing through the dialer, without proxy so the checked address is the one
+// connected to. Unless private networks are allowed, connections to private addresses are refused
+func Transport(dialer *net.Dialer, allowPrivateNetworks bool) *http.Transport {
+	if !allowPrivateNetworks {
+		dialer.Control = Control
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = nil
+	transport.DialContext = dialer.DialContext
+	return transport
+}
+
+// CheckRedirect follows redirects to HTTP and HTTPS addresses only, checking each of them like the first one
+func CheckRedirect(allowPrivateNetworks bool) func(*http.Request, []*http.Request) error {
+	return func(request *http.Request, via []*http.Request) error {
+		if len(via) >= maximumRedirects {
+			return errors.New("stopped after 10 redirects")
+		}
+		if request.URL.Scheme != "http" && request.URL.Scheme != "https" {
+			return errors.New("redirected to a URL that is not http or https")
+		}
+		if allowPrivateNetworks {
+			return nil
+		}
+		return CheckURL(request.URL)
+	}
+}
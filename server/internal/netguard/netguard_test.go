@@ -0,0 +1,66 @@
+package netguard
+
+import (
+	"net"
+	"net/http"
+	"net/url"
+	"testing"
+)
+
+func TestCheckURL(t *testing.T) {
+	for rawURL, private := range map[string]bool{
+		"https://example.edu/notes":    false,
+		"http://93.184.216.34/page":    false,
+		"http://localhost:8080/":       true,
+		"http://127.0.0.1/":            true,
+		"http://10.1.2.3/":             true,
+		"http://169.254.169.254/meta":  true,
+		"http://[::1]/":                true,
+		"http://[fe80::1]/":            true,
+		"http://0.0.0.0/":              true,
+		"http://0.1.2.3/":              true,
+		"http://100.64.0.1/":           true,
+		"http://100.127.255.254/":      true,
+		"http://100.128.0.1/":          false,
+		"http://[64:ff9b::a00:1]/":     true,
+		"http://[64:ff9b::5db8:d822]/": false,
+		"http://[64:ff9b:1::1]/":       true,
+		"https://intranet.example.com": false,
+	} {
+		target, _ := url.Parse(rawURL)
+		if err := CheckURL(target); (err != nil) != private {
+			t.Errorf("CheckURL(%s) = %v, expected private %v", rawURL, err, private)
+		}
+	}
+}
+
+func TestControlRefusesPrivateAddresses(t *testing.T) {
+	if err := Control("tcp", "127.0.0.1:80", nil); err != ErrPrivateAddress {
+		t.Errorf("Expected a loopback address to be refused, got %v", err)
+	}
+	if err := Control("tcp", net.JoinHostPort("93.184.216.34", "443"), nil); err != nil {
+		t.Errorf("Expected a public address to be allowed, got %v", err)
+	}
+}
+
+func TestCheckRedirect(t *testing.T) {
+	redirect := func(rawURL string) *http.Request {
+		request, _ := http.NewRequest(http.MethodGet, rawURL, nil)
+		return request
+	}
+	checkRedirect := CheckRedirect(false)
+	if err := checkRedirect(redirect("https://example.edu/moved"), nil); err != nil {
+		t.Errorf("Expected a redirect to a public URL to be followed, got %v", err)
+	}
+	for _, rawURL := range []string{"http://127.0.0.1/admin", "http://localhost/", "file:///etc/passwd"} {
+		if err := checkRedirect(redirect(rawURL), nil); err == nil {
+			t.Errorf("Expected the redirect to %s to be refused", rawURL)
+		}
+	}
+	if err := CheckRedirect(true)(redirect("http://127.0.0.1/admin"), nil); err != nil {
+		t.Errorf("Expected private redirects to be followed when allowed, got %v", err)
+	}
+	if err := checkRedirect(redirect("https://example.edu/"), make([]*http.Request, maximumRedirects)); err == nil {
+		t.Error("Expected redirects to stop after the limit")
+	}
+}
@@ -0,0 +1,203 @@
+// Package privacy applies the usage statistics privacy policy, so administrators of shared deployments
+// only see coarse totals instead of what individual users studied and when.
+package privacy
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/binary"
+	"math"
+	"sort"
+
+	"lectures/internal/configuration"
+)
+
+// Policy names accepted in privacy.usage_statistics.policy
+const (
+	PolicyDisabled = "disabled"
+	PolicyExact    = "exact"
+	PolicyPrivate  = "private"
+)
+
+const (
+	defaultMinimumGroupSize   = 5
+	defaultEpsilon            = 1.0
+	defaultEpsilonBudget      = 30.0
+	defaultMaximumCostPerUser = 10.0
+	// maximumContributionsPerUser caps how much a single user can move one count, which bounds the noise needed
+	maximumContributionsPerUser = 10
+)
+
+// Policy decides which aggregated figures are released and how much noise they carry
+type Policy struct {
+	Name               string
+	MinimumGroupSize   int
+	Epsilon            float64
+	EpsilonBudget      float64 // Spent per UTC day by releases of new windows
+	MaximumCostPerUser float64 // Cap on the cost one user adds to a released total, which bounds its noise
+	seed               []byte
+}
+
+// NewPolicy resolves the configured policy, falling back to the private defaults for missing values
+func NewPolicy(usageStatistics configuration.UsageStatisticsConfiguration) Policy {
+	policy := Policy{
+		Name:               usageStatistics.Policy,
+		MinimumGroupSize:   usageStatistics.MinimumGroupSize,
+		Epsilon:            usageStatistics.Epsilon,
+		EpsilonBudget:      usageStatistics.EpsilonBudget,
+		MaximumCostPerUser: usageStatistics.MaximumCostPerUser,
+	}
+	if policy.Name != PolicyDisabled && policy.Name != PolicyExact {
+		policy.Name = PolicyPrivate
+	}
+	if policy.MinimumGroupSize <= 0 {
+		policy.MinimumGroupSize = defaultMinimumGroupSize
+	}
+	if policy.Epsilon <= 0 {
+		policy.Epsilon = defaultEpsilon
+	}
+	if policy.EpsilonBudget <= 0 {
+		policy.EpsilonBudget = defaultEpsilonBudget
+	}
+	if policy.MaximumCostPerUser <= 0 {
+		policy.MaximumCostPerUser = defaultMaximumCostPerUser
+	}
+	return policy
+}
+
+// ForWindow derives the noise of the figures of one window from a secret key, so releasing the same window
+// again gives the same figures and averaging repeated requests learns nothing more
+func (policy Policy) ForWindow(key []byte, window string) Policy {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(window))
+	policy.seed = mac.Sum(nil)
+	return policy
+}
+
+// ReleaseCost is the budget a release of figureGroups noised groups of figures spends
+func (policy Policy) ReleaseCost(figureGroups int) float64 {
+	return policy.Epsilon * float64(figureGroups)
+}
+
+// Bucket accumulates one aggregated figure together with the users that contributed to it
+type Bucket struct {
+	Key           string
+	count         int
+	estimatedCost float64
+	contributions map[string]int
+}
+
+// Figure is a bucket as released to administrators
+type Figure struct {
+	Key           string   `json:"key"`
+	Count         int      `json:"count"`
+	EstimatedCost *float64 `json:"estimated_cost,omitempty"` // Only released under the exact policy
+}
+
+// Tally groups usage events into buckets by key
+type Tally struct {
+	buckets map[string]*Bucket
+}
+
+// NewTally creates an empty tally
+func NewTally() *Tally {
+	return &Tally{buckets: make(map[string]*Bucket)}
+}
+
+// Add records one event by userID in the bucket for key
+func (tally *Tally) Add(key string, userID string, estimatedCost float64) {
+	bucket, exists := tally.buckets[key]
+	if !exists {
+		bucket = &Bucket{Key: key, contributions: make(map[string]int)}
+		tally.buckets[key] = bucket
+	}
+	bucket.count++
+	bucket.estimatedCost += estimatedCost
+	bucket.contributions[userID]++
+}
+
+// Release applies the policy to every bucket of the named metric, returning the released figures sorted by key
+// and the number of buckets withheld because too few distinct users contributed to them
+func (policy Policy) Release(metric string, tally *Tally) ([]Figure, int) {
+	keys := make([]string, 0, len(tally.buckets))
+	for key := range tally.buckets {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	figures := []Figure{}
+	suppressed := 0
+	for _, key := range keys {
+		bucket := tally.buckets[key]
+		if policy.Name == PolicyExact {
+			estimatedCost := bucket.estimatedCost
+			figures = append(figures, Figure{Key: key, Count: bucket.count, EstimatedCost: &estimatedCost})
+			continue
+		}
+
+		if len(bucket.contributions) < policy.MinimumGroupSize {
+			suppressed++
+			continue
+		}
+
+		// Clamp each user's share so one heavy user cannot dominate a bucket, then add noise scaled to that cap
+		clampedCount := 0
+		for _, contributions := range bucket.contributions {
+			clampedCount += min(contributions, maximumContributionsPerUser)
+		}
+		figures = append(figures, Figure{Key: key, Count: policy.noisyCount(metric+"/"+key, clampedCount, maximumContributionsPerUser)})
+	}
+	return figures, suppressed
+}
+
+// Count releases the named count to which every user contributes at most once, such as the number of active
+// users
+func (policy Policy) Count(metric string, count int) int {
+	if policy.Name == PolicyExact {
+		return count
+	}
+	return policy.noisyCount(metric, count, 1)
+}
+
+// Total releases the named sum of the costs of each user. Under the private policy it is withheld (nil) below
+// the minimum group size, and otherwise each user's cost is capped at MaximumCostPerUser, noise scaled to
+// that cap is added, and it is rounded to whole units
+func (policy Policy) Total(metric string, costsByUser map[string]float64) *float64 {
+	total := 0.0
+	if policy.Name == PolicyExact {
+		for _, cost := range costsByUser {
+			total += cost
+		}
+		return &total
+	}
+	if len(costsByUser) < policy.MinimumGroupSize {
+		return nil
+	}
+	for _, cost := range costsByUser {
+		total += min(cost, policy.MaximumCostPerUser)
+	}
+	total = max(math.Round(total+policy.laplace(metric, policy.MaximumCostPerUser/policy.Epsilon)), 0)
+	return &total
+}
+
+// noisyCount adds Laplace noise for the given sensitivity and never releases a negative count
+func (policy Policy) noisyCount(metric string, count int, sensitivity int) int {
+	noisy := int(math.Round(float64(count) + policy.laplace(metric, float64(sensitivity)/policy.Epsilon)))
+	return max(noisy, 0)
+}
+
+// laplace draws the noise of a figure from a zero-centered Laplace distribution with the given scale. The draw
+// only depends on the seed of the window and the name of the figure
+func (policy Policy) laplace(metric string, scale float64) float64 {
+	mac := hmac.New(sha256.New, policy.seed)
+	mac.Write([]byte(metric))
+	uniform := float64(binary.BigEndian.Uint64(mac.Sum(nil))>>11)/(1<<53) - 0.5
+	if uniform == -0.5 {
+		return 0
+	}
+	sign := 1.0
+	if uniform < 0 {
+		sign = -1.0
+	}
+	return -scale * sign * math.Log(1-2*math.Abs(uniform))
+}
@@ -0,0 +1,136 @@
+package privacy
+
+import (
+	"fmt"
+	"testing"
+
+	"lectures/internal/configuration"
+)
+
+func TestNewPolicy_Defaults(t *testing.T) {
+	policy := NewPolicy(configuration.UsageStatisticsConfiguration{})
+	if policy.Name != PolicyPrivate || policy.MinimumGroupSize != defaultMinimumGroupSize || policy.Epsilon != defaultEpsilon {
+		t.Errorf("Expected the private defaults for an empty policy, got %+v", policy)
+	}
+
+	if policy := NewPolicy(configuration.UsageStatisticsConfiguration{Policy: "everything"}); policy.Name != PolicyPrivate {
+		t.Errorf("Expected an unknown policy to fall back to private, got %s", policy.Name)
+	}
+}
+
+func TestPolicy_ReleaseSuppressesSmallGroups(t *testing.T) {
+	// A huge budget makes the noise negligible so the clamped counts can be checked exactly
+	policy := NewPolicy(configuration.UsageStatisticsConfiguration{Policy: PolicyPrivate, MinimumGroupSize: 3, Epsilon: 1e9})
+
+	tally := NewTally()
+	for userIndex := range 3 {
+		tally.Add("guide", fmt.Sprintf("user-%d", userIndex), 1)
+	}
+	// One heavy user alone in a bucket must not be revealed
+	for range 50 {
+		tally.Add("quiz", "user-0", 1)
+	}
+	// A heavy user in a large enough group only counts up to the per-user cap
+	for range 50 {
+		tally.Add("flashcard", "user-0", 1)
+	}
+	tally.Add("flashcard", "user-1", 1)
+	tally.Add("flashcard", "user-2", 1)
+
+	figures, suppressed := policy.Release("jobs_by_type", tally)
+	if suppressed != 1 {
+		t.Errorf("Expected 1 suppressed bucket, got %d", suppressed)
+	}
+	if len(figures) != 2 || figures[0].Key != "flashcard" || figures[1].Key != "guide" {
+		t.Fatalf("Unexpected figures: %+v", figures)
+	}
+	if figures[0].Count != maximumContributionsPerUser+2 {
+		t.Errorf("Expected the flashcard count to be clamped to %d, got %d", maximumContributionsPerUser+2, figures[0].Count)
+	}
+	if figures[1].Count != 3 || figures[1].EstimatedCost != nil {
+		t.Errorf("Expected 3 guides without a cost, got %+v", figures[1])
+	}
+
+	if total := policy.Total("estimated_cost_total", map[string]float64{"user-0": 6.2, "user-1": 6.5}); total != nil {
+		t.Errorf("Expected a total over too few users to be withheld, got %v", *total)
+	}
+	// A heavy user only adds up to the per-user cost cap to the total
+	costsByUser := map[string]float64{"user-0": 6.2, "user-1": 6.5, "user-2": 500}
+	if total := policy.Total("estimated_cost_total", costsByUser); total == nil || *total != 23 {
+		t.Errorf("Expected a capped, rounded total of 23, got %v", total)
+	}
+}
+
+func TestPolicy_ExactReleasesEverything(t *testing.T) {
+	policy := NewPolicy(configuration.UsageStatisticsConfiguration{Policy: PolicyExact})
+
+	tally := NewTally()
+	tally.Add("quiz", "user-0", 0.25)
+	tally.Add("quiz", "user-0", 0.5)
+
+	figures, suppressed := policy.Release("jobs_by_type", tally)
+	if suppressed != 0 || len(figures) != 1 || figures[0].Count != 2 {
+		t.Fatalf("Expected the exact count, got %+v (%d suppressed)", figures, suppressed)
+	}
+	if figures[0].EstimatedCost == nil || *figures[0].EstimatedCost != 0.75 {
+		t.Errorf("Expected the exact cost, got %v", figures[0].EstimatedCost)
+	}
+	if policy.Count("users_total", 7) != 7 {
+		t.Error("Expected counts to be exact")
+	}
+	if total := policy.Total("estimated_cost_total", map[string]float64{"user-0": 0.75}); total == nil || *total != 0.75 {
+		t.Errorf("Expected the exact total, got %v", total)
+	}
+}
+
+func TestPolicy_NoiseIsNeverNegative(t *testing.T) {
+	policy := NewPolicy(configuration.UsageStatisticsConfiguration{Policy: PolicyPrivate, Epsilon: 0.01})
+	for index := range 1000 {
+		if count := policy.Count(fmt.Sprintf("metric-%d", index), 0); count < 0 {
+			t.Fatalf("Expected non-negative counts, got %d", count)
+		}
+	}
+}
+
+func TestPolicy_NoiseIsFixedPerWindow(t *testing.T) {
+	base := NewPolicy(configuration.UsageStatisticsConfiguration{Policy: PolicyPrivate, Epsilon: 0.1})
+	key := []byte("secret")
+	costsByUser := map[string]float64{}
+	for userIndex := range 10 {
+		costsByUser[fmt.Sprintf("user-%d", userIndex)] = 1
+	}
+
+	// Repeating a request for the same window must return the same figures, so averaging them learns nothing
+	first := base.ForWindow(key, "2026-10-16/30")
+	again := base.ForWindow(key, "2026-10-16/30")
+	for index := range 20 {
+		metric := fmt.Sprintf("metric-%d", index)
+		if first.Count(metric, 100) != again.Count(metric, 100) {
+			t.Fatalf("Expected the same noisy count for %s in the same window", metric)
+		}
+	}
+	if *first.Total("estimated_cost_total", costsByUser) != *again.Total("estimated_cost_total", costsByUser) {
+		t.Error("Expected the same noisy total in the same window")
+	}
+
+	// Another window or another key draws other noise
+	differs := func(other Policy) bool {
+		for index := range 20 {
+			metric := fmt.Sprintf("metric-%d", index)
+			if first.Count(metric, 100) != other.Count(metric, 100) {
+				return true
+			}
+		}
+		return false
+	}
+	if !differs(base.ForWindow(key, "2026-10-16/7")) {
+		t.Error("Expected another window to draw other noise")
+	}
+	if !differs(base.ForWindow([]byte("other"), "2026-10-16/30")) {
+		t.Error("Expected another key to draw other noise")
+	}
+
+	if cost := first.ReleaseCost(5); cost != 0.5 {
+		t.Errorf("Expected a release of 5 groups to cost 0.5, got %v", cost)
+	}
+}
@@ -0,0 +1,240 @@
+package prompts
+
+import (
+	"database/sql"
+	"fmt"
+	"math/rand/v2"
+	"slices"
+	"time"
+
+	"lectures/internal/models"
+
+	gonanoid "github.com/matoous/go-nanoid/v2"
+)
+
+// BaselineVariantWeight is the relative chance of a job keeping the prompt file during an experiment
+const BaselineVariantWeight = 1.0
+
+// BaselineVariantName names the prompt file in experiment statistics
+const BaselineVariantName = "baseline"
+
+// experimentalPrompts lists, per tool type, the prompts whose variants can be tried on generation jobs
+var experimentalPrompts = map[string][]string{
+	"guide":           {PromptAnalyzeLectureStructure, PromptStudyGuideSectionGeneration},
+	"course_overview": {PromptAnalyzeCourseStructure, PromptStudyGuideSectionGeneration},
+	"flashcard":       {PromptGenerateFlashcards},
+	"quiz":            {PromptGenerateQuiz},
+	"mindmap":         {PromptGenerateMindmap},
+	"mock_exam":       {PromptGenerateMockExam},
+}
+
+// IsExperimentalPrompt reports whether variants of a prompt can be registered
+func IsExperimentalPrompt(promptPath string) bool {
+	for _, promptPaths := range experimentalPrompts {
+		if slices.Contains(promptPaths, promptPath) {
+			return true
+		}
+	}
+	return false
+}
+
+// CreatePromptVariant registers an active variant of an experimental prompt, filling in its ID and creation time
+func CreatePromptVariant(database *sql.DB, variant *models.PromptVariant) error {
+	if !IsExperimentalPrompt(variant.PromptPath) {
+		return fmt.Errorf("prompt %s does not support experiments", variant.PromptPath)
+	}
+	variant.ID, _ = gonanoid.New()
+	variant.Active = true
+	variant.CreatedAt = time.Now()
+	_, err := database.Exec(`
+		INSERT INTO prompt_variants (id, prompt_path, name, content, weight, active, created_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?)
+	`, variant.ID, variant.PromptPath, variant.Name, variant.Content, variant.Weight, variant.Active, variant.CreatedAt)
+	return err
+}
+
+// ListPromptVariants returns the registered variants of a prompt, or of every prompt when promptPath is empty
+func ListPromptVariants(database *sql.DB, promptPath string, activeOnly bool) ([]models.PromptVariant, error) {
+	query := "SELECT id, prompt_path, name, content, weight, active, created_at FROM prompt_variants WHERE (? = '' OR prompt_path = ?)"
+	if activeOnly {
+		query += " AND active = 1"
+	}
+	rows, err := database.Query(query+" ORDER BY prompt_path, created_at", promptPath, promptPath)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	variants := []models.PromptVariant{}
+	for rows.Next() {
+		var variant models.PromptVariant
+		if err := rows.Scan(&variant.ID, &variant.PromptPath, &variant.Name, &variant.Content, &variant.Weight, &variant.Active, &variant.CreatedAt); err != nil {
+			return nil, err
+		}
+		variants = append(variants, variant)
+	}
+	return variants, rows.Err()
+}
+
+// UpdatePromptVariant changes whether a variant is assigned and how often. Variants are retired rather than
+// deleted so the statistics of past jobs remain readable; sql.ErrNoRows is returned for an unknown variant
+func UpdatePromptVariant(database *sql.DB, variantID string, active *bool, weight *float64) error {
+	result, err := database.Exec(`
+		UPDATE prompt_variants SET active = COALESCE(?, active), weight = COALESCE(?, weight) WHERE id = ?
+	`, active, weight, variantID)
+	if err != nil {
+		return err
+	}
+	if updatedRows, _ := result.RowsAffected(); updatedRows == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// AssignPromptVariants draws, for every experimental prompt of a tool type, either the prompt file or one of its
+// active variants, with chances proportional to their weights, and records the draw against the job. Only the
+// drawn variants are returned, keyed by prompt path, ready for models.GenerationOptions.PromptVariants
+func AssignPromptVariants(database *sql.DB, jobID string, toolType string) (map[string]models.PromptVariant, error) {
+	assignedVariants := make(map[string]models.PromptVariant)
+	for _, promptPath := range experimentalPrompts[toolType] {
+		variants, err := ListPromptVariants(database, promptPath, true)
+		if err != nil {
+			return nil, err
+		}
+		if len(variants) == 0 {
+			continue
+		}
+
+		var variantID any
+		if variant, drawn := drawVariant(variants, rand.Float64()); drawn {
+			assignedVariants[promptPath] = variant
+			variantID = variant.ID
+		}
+		if _, err := database.Exec("INSERT INTO prompt_assignments (job_id, prompt_path, variant_id, created_at) VALUES (?, ?, ?, ?)", jobID, promptPath, variantID, time.Now()); err != nil {
+			return nil, err
+		}
+	}
+	return assignedVariants, nil
+}
+
+// drawVariant picks the variant the draw, uniform in [0, 1), falls on; false means the prompt file was drawn
+func drawVariant(variants []models.PromptVariant, draw float64) (models.PromptVariant, bool) {
+	totalWeight := BaselineVariantWeight
+	for _, variant := range variants {
+		totalWeight += max(variant.Weight, 0)
+	}
+
+	position := draw*totalWeight - BaselineVariantWeight
+	for _, variant := range variants {
+		if position < 0 {
+			break
+		}
+		position -= max(variant.Weight, 0)
+		if position < 0 {
+			return variant, true
+		}
+	}
+	return models.PromptVariant{}, false
+}
+
+// RecordPromptOutcome stores the outcome of a job on its assignments: the tool it built, if any, and the adherence
+// scores its sections received
+func RecordPromptOutcome(database *sql.DB, jobID string, toolID string, adherenceScores []int) error {
+	scoreTotal := 0
+	for _, score := range adherenceScores {
+		scoreTotal += score
+	}
+	_, err := database.Exec(`
+		UPDATE prompt_assignments
+		SET tool_id = NULLIF(?, ''), adherence_score_total = adherence_score_total + ?, adherence_score_count = adherence_score_count + ?
+		WHERE job_id = ?
+	`, toolID, scoreTotal, len(adherenceScores), jobID)
+	return err
+}
+
+// PromptVariantStatistics compares the variants of every experimental prompt that was assigned at least once, or
+// has registered variants: assignments, average section adherence and average user rating of the built tools
+func PromptVariantStatistics(database *sql.DB, promptPath string) ([]models.PromptVariantStatistics, error) {
+	type statisticsKey struct{ promptPath, variantID string }
+	assignmentStatistics := make(map[statisticsKey]models.PromptVariantStatistics)
+
+	rows, err := database.Query(`
+		SELECT prompt_path, COALESCE(variant_id, ''), COUNT(*), SUM(adherence_score_count), SUM(adherence_score_total)
+		FROM prompt_assignments
+		WHERE ? = '' OR prompt_path = ?
+		GROUP BY prompt_path, variant_id
+	`, promptPath, promptPath)
+	if err != nil {
+		return nil, err
+	}
+	for rows.Next() {
+		var key statisticsKey
+		var statistics models.PromptVariantStatistics
+		var scoreTotal int
+		if err := rows.Scan(&key.promptPath, &key.variantID, &statistics.Assignments, &statistics.AdherenceScores, &scoreTotal); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		if statistics.AdherenceScores > 0 {
+			statistics.AverageAdherence = float64(scoreTotal) / float64(statistics.AdherenceScores)
+		}
+		assignmentStatistics[key] = statistics
+	}
+	rows.Close()
+
+	// Ratings are counted separately because a tool can be rated by several users
+	ratingRows, err := database.Query(`
+		SELECT prompt_assignments.prompt_path, COALESCE(prompt_assignments.variant_id, ''), COUNT(*), AVG(tool_feedback.rating)
+		FROM prompt_assignments
+		JOIN tool_feedback ON tool_feedback.tool_id = prompt_assignments.tool_id
+		WHERE ? = '' OR prompt_assignments.prompt_path = ?
+		GROUP BY prompt_assignments.prompt_path, prompt_assignments.variant_id
+	`, promptPath, promptPath)
+	if err != nil {
+		return nil, err
+	}
+	for ratingRows.Next() {
+		var key statisticsKey
+		var ratings int
+		var averageRating float64
+		if err := ratingRows.Scan(&key.promptPath, &key.variantID, &ratings, &averageRating); err != nil {
+			ratingRows.Close()
+			return nil, err
+		}
+		statistics := assignmentStatistics[key]
+		statistics.Ratings, statistics.AverageRating = ratings, averageRating
+		assignmentStatistics[key] = statistics
+	}
+	ratingRows.Close()
+
+	variants, err := ListPromptVariants(database, promptPath, false)
+	if err != nil {
+		return nil, err
+	}
+	var promptPaths []string
+	for key := range assignmentStatistics {
+		promptPaths = append(promptPaths, key.promptPath)
+	}
+	for _, variant := range variants {
+		promptPaths = append(promptPaths, variant.PromptPath)
+	}
+	slices.Sort(promptPaths)
+	promptPaths = slices.Compact(promptPaths)
+
+	report := []models.PromptVariantStatistics{}
+	for _, currentPromptPath := range promptPaths {
+		baseline := assignmentStatistics[statisticsKey{currentPromptPath, ""}]
+		baseline.Name, baseline.PromptPath, baseline.Active, baseline.Weight = BaselineVariantName, currentPromptPath, true, BaselineVariantWeight
+		report = append(report, baseline)
+		for _, variant := range variants {
+			if variant.PromptPath != currentPromptPath {
+				continue
+			}
+			statistics := assignmentStatistics[statisticsKey{currentPromptPath, variant.ID}]
+			statistics.VariantID, statistics.Name, statistics.PromptPath = variant.ID, variant.Name, variant.PromptPath
+			statistics.Active, statistics.Weight = variant.Active, variant.Weight
+			report = append(report, statistics)
+		}
+	}
+	return report, nil
+}
@@ -0,0 +1,84 @@
+package prompts
+
+import (
+	"path/filepath"
+	"testing"
+
+	"lectures/internal/database"
+	"lectures/internal/models"
+)
+
+func TestDrawVariant_FollowsWeights(t *testing.T) {
+	variants := []models.PromptVariant{{ID: "terse", Weight: 1}, {ID: "retired", Weight: 0}, {ID: "verbose", Weight: 2}}
+
+	// The prompt file takes the first quarter of the range, then each variant its share
+	for _, testCase := range []struct {
+		draw      float64
+		variantID string
+	}{
+		{0, ""}, {0.24, ""}, {0.25, "terse"}, {0.49, "terse"}, {0.5, "verbose"}, {0.99, "verbose"},
+	} {
+		variant, drawn := drawVariant(variants, testCase.draw)
+		if drawn != (testCase.variantID != "") || variant.ID != testCase.variantID {
+			t.Errorf("Draw %.2f: expected %q, got %q (drawn %v)", testCase.draw, testCase.variantID, variant.ID, drawn)
+		}
+	}
+}
+
+func TestPromptExperiments_RecordAndCompareVariants(t *testing.T) {
+	db, err := database.Initialize(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatalf("Failed to init DB: %v", err)
+	}
+	defer db.Close()
+
+	if err := CreatePromptVariant(db, &models.PromptVariant{PromptPath: PromptLatexInstructions, Name: "unsupported", Content: "x", Weight: 1}); err == nil {
+		t.Error("Expected variants of non-experimental prompts to be refused")
+	}
+
+	variant := models.PromptVariant{PromptPath: PromptGenerateQuiz, Name: "socratic", Content: "Quiz on {{transcript}}", Weight: 1000000}
+	if err := CreatePromptVariant(db, &variant); err != nil {
+		t.Fatalf("CreatePromptVariant failed: %v", err)
+	}
+
+	assignedVariants, err := AssignPromptVariants(db, "job-1", "quiz")
+	if err != nil {
+		t.Fatalf("AssignPromptVariants failed: %v", err)
+	}
+	if assignedVariants[PromptGenerateQuiz].ID != variant.ID {
+		t.Fatalf("Expected the heavily weighted variant to be assigned, got %+v", assignedVariants)
+	}
+	if assignedVariants, _ := AssignPromptVariants(db, "job-2", "flashcard"); len(assignedVariants) != 0 {
+		t.Errorf("Expected no variants for prompts without any, got %+v", assignedVariants)
+	}
+
+	db.Exec("INSERT INTO users (id, username, password_hash) VALUES ('user-1', 'user1', 'hash'), ('user-2', 'user2', 'hash')")
+	db.Exec("INSERT INTO exams (id, user_id, title) VALUES ('exam-1', 'user-1', 'Exam')")
+	db.Exec("INSERT INTO tools (id, exam_id, type, title, content) VALUES ('tool-1', 'exam-1', 'quiz', 'Quiz', '[]')")
+	db.Exec("INSERT INTO tool_feedback (tool_id, user_id, rating) VALUES ('tool-1', 'user-1', 4), ('tool-1', 'user-2', 2)")
+	if err := RecordPromptOutcome(db, "job-1", "tool-1", []int{80, 90}); err != nil {
+		t.Fatalf("RecordPromptOutcome failed: %v", err)
+	}
+
+	inactive := false
+	if err := UpdatePromptVariant(db, variant.ID, &inactive, nil); err != nil {
+		t.Fatalf("UpdatePromptVariant failed: %v", err)
+	}
+	if assignedVariants, _ := AssignPromptVariants(db, "job-3", "quiz"); len(assignedVariants) != 0 {
+		t.Errorf("Expected retired variants not to be assigned, got %+v", assignedVariants)
+	}
+
+	statistics, err := PromptVariantStatistics(db, PromptGenerateQuiz)
+	if err != nil {
+		t.Fatalf("PromptVariantStatistics failed: %v", err)
+	}
+	if len(statistics) != 2 || statistics[0].Name != BaselineVariantName || statistics[1].VariantID != variant.ID {
+		t.Fatalf("Expected the baseline and the variant, got %+v", statistics)
+	}
+	if statistics[0].Assignments != 0 {
+		t.Errorf("Expected no baseline assignments while no variant is active, got %+v", statistics[0])
+	}
+	if statistics[1].Assignments != 1 || statistics[1].AverageAdherence != 85 || statistics[1].Ratings != 2 || statistics[1].AverageRating != 3 || statistics[1].Active {
+		t.Errorf("Unexpected variant statistics: %+v", statistics[1])
+	}
+}
@@ -38,35 +38,50 @@ func (manager *Manager) GetPrompt(promptPath string, variables map[string]string
 
 // Prompt constants for easier access
 const (
+	PromptAnalyzeCourseStructure         = "general/analyze-course-structure.md"
 	PromptAnalyzeLectureStructure        = "general/analyze-lecture-structure.md"
 	PromptCleanDocumentTitle             = "general/clean-document-title.md"
 	PromptCleanTranscript                = "general/clean-transcript.md"
 	PromptCorrectProjectTitleDescription = "general/correct-project-title-description.md"
 	PromptCorrectUserMessage             = "general/correct-user-message.md"
+	PromptExtractSyllabus                = "general/extract-syllabus.md"
 	PromptFormatFootnotes                = "general/format-footnotes.md"
 	PromptGenerateChatQuestions          = "general/generate-chat-questions.md"
 	PromptGenerateDocumentDescription    = "general/generate-document-description.md"
 	PromptGenerateDocumentIcon           = "general/generate-document-icon.md"
+	PromptGenerateLectureRecap           = "general/generate-lecture-recap.md"
 	PromptGenerateProjectIcon            = "general/generate-project-icon.md"
 	PromptGetRelevantPages               = "general/get-relevant-pages.md"
 	PromptParseFootnotes                 = "general/parse-footnotes.md"
+	PromptPolishTranscriptSegments       = "general/polish-transcript-segments.md"
 	PromptReadingAssistantMultiChat      = "general/reading-assistant-multi-chat.md"
+	PromptRepairToolJSON                 = "general/repair-tool-json.md"
 	PromptStyleConcise                   = "general/style-concise.md"
 	PromptStyleLearning                  = "general/style-learning.md"
 	PromptStyleNormal                    = "general/style-normal.md"
 	PromptVerifySectionAdherence         = "general/verify-section-adherence.md"
 
-	PromptIngestDocumentPage  = "media/ingest-document-page.md"
-	PromptTextToSpeechSection = "media/text-to-speech-section.md"
-	PromptTranscribeRecording = "media/transcribe-recording.md"
+	PromptIngestDocumentPage     = "media/ingest-document-page.md"
+	PromptIngestDocumentPageMath = "media/ingest-document-page-math.md"
+	PromptRepairPageEquations    = "media/repair-page-equations.md"
+	PromptTextToSpeechSection    = "media/text-to-speech-section.md"
+	PromptTranscribeRecording    = "media/transcribe-recording.md"
 
 	PromptCitationInstructions              = "study-guides/citation-instructions.md"
+	PromptCourseOverviewContext             = "study-guides/course-overview-context.md"
 	PromptStudyGuideWithCitationsExample    = "study-guides/study-guide-with-citations-example.md"
 	PromptStudyGuideWithoutCitationsExample = "study-guides/study-guide-without-citations-example.md"
+	PromptForeignSourcesTranslate           = "study-guides/foreign-sources-translate.md"
+	PromptForeignSourcesVerbatim            = "study-guides/foreign-sources-verbatim.md"
 	PromptGenerateFlashcards                = "study-guides/generate-flashcards.md"
+	PromptGenerateMindmap                   = "study-guides/generate-mindmap.md"
+	PromptGenerateMockExam                  = "study-guides/generate-mock-exam.md"
 	PromptGenerateQuiz                      = "study-guides/generate-quiz.md"
+	PromptGradeFreeResponse                 = "study-guides/grade-free-response.md"
 	PromptLanguageRequirement               = "study-guides/language-requirement.md"
 	PromptLatexInstructions                 = "study-guides/latex-instructions.md"
+	PromptLocateCitationRegion              = "study-guides/locate-citation-region.md"
+	PromptSelectFlashcardMnemonics          = "study-guides/select-flashcard-mnemonics.md"
 	PromptSectionWithCitationsExample       = "study-guides/section-with-citations-example.md"
 	PromptSectionWithoutCitationsExample    = "study-guides/section-without-citations-example.md"
 	PromptStudyGuideInitialContext          = "study-guides/study-guide-initial-context.md"
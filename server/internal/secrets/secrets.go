@@ -0,0 +1,101 @@
+// Package secrets encrypts the credentials users store, such as their provider API keys, so they are not kept
+// in clear in the database or its backups.
+package secrets
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// KeySize is the size in bytes of the AES-256 encryption key
+const KeySize = 32
+
+// Cipher seals and opens secrets with AES-256-GCM
+type Cipher struct {
+	aead cipher.AEAD
+}
+
+// NewCipher creates a cipher from a KeySize bytes key
+func NewCipher(key []byte) (*Cipher, error) {
+	if len(key) != KeySize {
+		return nil, fmt.Errorf("encryption key must be %d bytes, got %d", KeySize, len(key))
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	return &Cipher{aead: aead}, nil
+}
+
+// LoadCipher creates the cipher of a deployment: from encodedKey, the base64 key of security.encryption_key,
+// or else from the key file at keyPath, which is generated on first use
+func LoadCipher(encodedKey string, keyPath string) (*Cipher, error) {
+	if encodedKey != "" {
+		key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(encodedKey))
+		if err != nil {
+			return nil, fmt.Errorf("encryption key is not valid base64: %w", err)
+		}
+		return NewCipher(key)
+	}
+
+	encodedFileKey, err := os.ReadFile(keyPath)
+	if errors.Is(err, os.ErrNotExist) {
+		key := make([]byte, KeySize)
+		if _, err := rand.Read(key); err != nil {
+			return nil, err
+		}
+		if err := os.MkdirAll(filepath.Dir(keyPath), 0755); err != nil {
+			return nil, err
+		}
+		if err := os.WriteFile(keyPath, []byte(base64.StdEncoding.EncodeToString(key)+"\n"), 0600); err != nil {
+			return nil, fmt.Errorf("failed to write encryption key: %w", err)
+		}
+		return NewCipher(key)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read encryption key: %w", err)
+	}
+	key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(encodedFileKey)))
+	if err != nil {
+		return nil, fmt.Errorf("encryption key file %s is not valid base64: %w", keyPath, err)
+	}
+	return NewCipher(key)
+}
+
+// Seal encrypts a secret, returning the base64 of a random nonce followed by the ciphertext
+func (secretCipher *Cipher) Seal(plaintext string) (string, error) {
+	nonce := make([]byte, secretCipher.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	sealed := secretCipher.aead.Seal(nonce, nonce, []byte(plaintext), nil)
+	return base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+// Open decrypts a secret sealed with the same key
+func (secretCipher *Cipher) Open(sealed string) (string, error) {
+	data, err := base64.StdEncoding.DecodeString(sealed)
+	if err != nil {
+		return "", fmt.Errorf("sealed secret is not valid base64: %w", err)
+	}
+	nonceSize := secretCipher.aead.NonceSize()
+	if len(data) < nonceSize {
+		return "", errors.New("sealed secret is too short")
+	}
+	plaintext, err := secretCipher.aead.Open(nil, data[:nonceSize], data[nonceSize:], nil)
+	if err != nil {
+		return "", errors.New("sealed secret cannot be decrypted with this key")
+	}
+	return string(plaintext), nil
+}
@@ -0,0 +1,53 @@
+package secrets
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestCipher_SealAndOpen(t *testing.T) {
+	keyPath := filepath.Join(t.TempDir(), "encryption.key")
+	secretCipher, err := LoadCipher("", keyPath)
+	if err != nil {
+		t.Fatalf("LoadCipher failed: %v", err)
+	}
+
+	sealed, err := secretCipher.Seal("sk-or-secret")
+	if err != nil {
+		t.Fatalf("Seal failed: %v", err)
+	}
+	if strings.Contains(sealed, "sk-or-secret") {
+		t.Fatalf("Expected the sealed secret not to contain the plaintext, got %s", sealed)
+	}
+	if again, _ := secretCipher.Seal("sk-or-secret"); again == sealed {
+		t.Errorf("Expected every seal to use a new nonce")
+	}
+
+	// The generated key is reused by the next start
+	reloadedCipher, err := LoadCipher("", keyPath)
+	if err != nil {
+		t.Fatalf("LoadCipher failed to reload the key: %v", err)
+	}
+	if plaintext, err := reloadedCipher.Open(sealed); err != nil || plaintext != "sk-or-secret" {
+		t.Errorf("Expected the secret back, got %q and %v", plaintext, err)
+	}
+	if info, err := os.Stat(keyPath); err != nil || info.Mode().Perm() != 0600 {
+		t.Errorf("Expected the key file to be readable by its owner only, got %v", info.Mode())
+	}
+
+	otherCipher, _ := LoadCipher("", filepath.Join(t.TempDir(), "other.key"))
+	if _, err := otherCipher.Open(sealed); err == nil {
+		t.Errorf("Expected another key to fail to open the secret")
+	}
+}
+
+func TestLoadCipher_ConfiguredKey(t *testing.T) {
+	if _, err := LoadCipher("bm90IGEga2V5", ""); err == nil {
+		t.Errorf("Expected a key of the wrong size to be refused")
+	}
+	if _, err := LoadCipher(strings.Repeat("A", 43)+"=", ""); err != nil {
+		t.Errorf("Expected a 32 bytes key to be accepted, got %v", err)
+	}
+}
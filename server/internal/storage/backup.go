@@ -0,0 +1,399 @@
+package storage
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Names inside a backup archive. Files of the data directory are stored under the name of their part, so an
+// archive restores into whatever layout the restoring server uses
+const (
+	backupManifestName  = "manifest.json"
+	backupDatabaseName  = "database.db"
+	backupLecturesPart  = "lectures"
+	backupExportsPart   = "exports"
+	backupObjectsPart   = "objects"
+	backupArchiveSuffix = ".tar.gz"
+
+	// BackupFormat is the version of the archive layout, refused by servers that only know older ones
+	BackupFormat = 1
+)
+
+// BackupManifest describes a backup archive; it is its first entry
+type BackupManifest struct {
+	Format        int       `json:"format"`
+	CreatedAt     time.Time `json:"created_at"`
+	SchemaVersion int       `json:"schema_version"`
+	Files         int       `json:"files"` // Lecture files, tool assets and local objects, the database excluded
+	Bytes         int64     `json:"bytes"` // Uncompressed size of the database and files
+}
+
+// BackupArchive is a backup archive kept in the backups directory
+type BackupArchive struct {
+	Name      string    `json:"name"`
+	Bytes     int64     `json:"bytes"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// backupPartNames are the parts of the data directory a backup archives, in archive order
+var backupPartNames = []string{backupLecturesPart, backupExportsPart, backupObjectsPart}
+
+// backupParts maps the name of each archived part to its directory
+func backupParts(layout Layout) map[string]string {
+	return map[string]string{
+		backupLecturesPart: layout.LecturesDirectory(),
+		backupExportsPart:  layout.ExportsDirectory(),
+		backupObjectsPart:  layout.ObjectsDirectory(),
+	}
+}
+
+// BackupArchiveName is the name of the archive of a backup taken at the given time
+func BackupArchiveName(createdAt time.Time) string {
+	return "backup-" + createdAt.UTC().Format("20060102-150405") + backupArchiveSuffix
+}
+
+// CreateBackupArchive writes a gzipped tarball of a database snapshot with the lecture files, tool assets and
+// local objects of the data directory. The archive is written next to its path and renamed once complete, so a crash never
+// leaves a truncated archive behind. onFile is called after each file with the files archived so far and in all
+func CreateBackupArchive(archivePath string, layout Layout, snapshotPath string, manifest BackupManifest, onFile func(archivedFiles int, totalFiles int)) (BackupManifest, error) {
+	manifest.Format = BackupFormat
+	parts := backupParts(layout)
+
+	// Files are counted first since the manifest leads the archive
+	snapshotInfo, err := os.Stat(snapshotPath)
+	if err != nil {
+		return manifest, err
+	}
+	manifest.Bytes = snapshotInfo.Size()
+	for _, partName := range backupPartNames {
+		err := walkRegularFiles(parts[partName], func(filePath string, info fs.FileInfo) error {
+			manifest.Files++
+			manifest.Bytes += info.Size()
+			return nil
+		})
+		if err != nil {
+			return manifest, err
+		}
+	}
+
+	partialPath := archivePath + ".partial"
+	archiveFile, err := os.Create(partialPath)
+	if err != nil {
+		return manifest, err
+	}
+	defer os.Remove(partialPath)
+	defer archiveFile.Close()
+
+	gzipWriter := gzip.NewWriter(archiveFile)
+	tarWriter := tar.NewWriter(gzipWriter)
+
+	manifestJSON, _ := json.MarshalIndent(manifest, "", "  ")
+	if err := tarWriter.WriteHeader(&tar.Header{Name: backupManifestName, Mode: 0644, Size: int64(len(manifestJSON)), ModTime: manifest.CreatedAt}); err != nil {
+		return manifest, err
+	}
+	if _, err := tarWriter.Write(manifestJSON); err != nil {
+		return manifest, err
+	}
+	if err := addFileToArchive(tarWriter, snapshotPath, backupDatabaseName); err != nil {
+		return manifest, fmt.Errorf("failed to archive database: %w", err)
+	}
+
+	archivedFiles := 0
+	for _, partName := range backupPartNames {
+		partDirectory := parts[partName]
+		err := walkRegularFiles(partDirectory, func(filePath string, info fs.FileInfo) error {
+			relativePath, err := filepath.Rel(partDirectory, filePath)
+			if err != nil {
+				return err
+			}
+			if err := addFileToArchive(tarWriter, filePath, path.Join(partName, filepath.ToSlash(relativePath))); err != nil {
+				return fmt.Errorf("failed to archive %s: %w", filePath, err)
+			}
+			archivedFiles++
+			if onFile != nil {
+				onFile(archivedFiles, manifest.Files)
+			}
+			return nil
+		})
+		if err != nil {
+			return manifest, err
+		}
+	}
+
+	if err := tarWriter.Close(); err != nil {
+		return manifest, err
+	}
+	if err := gzipWriter.Close(); err != nil {
+		return manifest, err
+	}
+	if err := archiveFile.Close(); err != nil {
+		return manifest, err
+	}
+	return manifest, os.Rename(partialPath, archivePath)
+}
+
+// walkRegularFiles calls visit for every regular file under a directory, which may not exist yet
+func walkRegularFiles(directory string, visit func(filePath string, info fs.FileInfo) error) error {
+	err := filepath.WalkDir(directory, func(filePath string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !entry.Type().IsRegular() {
+			return nil
+		}
+		info, err := entry.Info()
+		if err != nil {
+			return err
+		}
+		return visit(filePath, info)
+	})
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	return err
+}
+
+// addFileToArchive copies a file into the archive under the given name
+func addFileToArchive(tarWriter *tar.Writer, filePath string, name string) error {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return err
+	}
+	if err := tarWriter.WriteHeader(&tar.Header{Name: name, Mode: 0644, Size: info.Size(), ModTime: info.ModTime()}); err != nil {
+		return err
+	}
+	_, err = io.Copy(tarWriter, file)
+	return err
+}
+
+// ExtractedBackup is a backup archive unpacked next to the parts of the data directory it replaces, waiting to
+// be applied or discarded
+type ExtractedBackup struct {
+	Manifest     BackupManifest
+	DatabasePath string // Snapshot of the database, to be copied into the live one
+
+	temporaryDirectory string
+	stagedDirectories  map[string]string // Directory of the data directory, by the staged directory replacing it
+}
+
+// ExtractBackupArchive unpacks and checks a backup archive: its manifest must come first with a known format,
+// every entry must be the database or a file of a known part, and the database must be present. The files of
+// each part are staged beside their directories, so applying them is a rename
+func ExtractBackupArchive(reader io.Reader, layout Layout) (*ExtractedBackup, error) {
+	gzipReader, err := gzip.NewReader(reader)
+	if err != nil {
+		return nil, fmt.Errorf("backup is not a gzipped archive: %w", err)
+	}
+	defer gzipReader.Close()
+	tarReader := tar.NewReader(gzipReader)
+
+	header, err := tarReader.Next()
+	if err != nil || header.Name != backupManifestName {
+		return nil, fmt.Errorf("backup archive does not start with its manifest")
+	}
+	var manifest BackupManifest
+	if err := json.NewDecoder(io.LimitReader(tarReader, 1<<20)).Decode(&manifest); err != nil {
+		return nil, fmt.Errorf("backup manifest is not valid: %w", err)
+	}
+	if manifest.Format < 1 || manifest.Format > BackupFormat {
+		return nil, fmt.Errorf("backup format %d is not supported by this server (%d)", manifest.Format, BackupFormat)
+	}
+
+	if err := os.MkdirAll(layout.Root(), 0755); err != nil {
+		return nil, err
+	}
+	temporaryDirectory, err := os.MkdirTemp(layout.Root(), ".restore-")
+	if err != nil {
+		return nil, err
+	}
+	extracted := &ExtractedBackup{
+		Manifest:           manifest,
+		temporaryDirectory: temporaryDirectory,
+		stagedDirectories:  make(map[string]string),
+	}
+	parts := backupParts(layout)
+	for _, partDirectory := range parts {
+		stagedDirectory := partDirectory + ".restoring"
+		os.RemoveAll(stagedDirectory)
+		if err := os.MkdirAll(stagedDirectory, 0755); err != nil {
+			extracted.Discard()
+			return nil, err
+		}
+		extracted.stagedDirectories[stagedDirectory] = partDirectory
+	}
+
+	for {
+		header, err := tarReader.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			extracted.Discard()
+			return nil, fmt.Errorf("backup archive is corrupted: %w", err)
+		}
+		if header.Typeflag == tar.TypeDir {
+			continue
+		}
+		if header.Typeflag != tar.TypeReg {
+			extracted.Discard()
+			return nil, fmt.Errorf("backup archive entry %s is not a regular file", header.Name)
+		}
+
+		destinationPath, err := extracted.destination(header.Name, parts)
+		if err != nil {
+			extracted.Discard()
+			return nil, err
+		}
+		if err := extractFile(tarReader, destinationPath); err != nil {
+			extracted.Discard()
+			return nil, fmt.Errorf("failed to extract %s: %w", header.Name, err)
+		}
+	}
+
+	if extracted.DatabasePath == "" {
+		extracted.Discard()
+		return nil, fmt.Errorf("backup archive has no database")
+	}
+	return extracted, nil
+}
+
+// destination returns where an archive entry is extracted, refusing names outside the known parts
+func (extracted *ExtractedBackup) destination(name string, parts map[string]string) (string, error) {
+	cleanName := path.Clean(name)
+	if cleanName == backupDatabaseName {
+		extracted.DatabasePath = filepath.Join(extracted.temporaryDirectory, backupDatabaseName)
+		return extracted.DatabasePath, nil
+	}
+
+	partName, relativePath, found := strings.Cut(cleanName, "/")
+	partDirectory, known := parts[partName]
+	if !found || !known || path.IsAbs(cleanName) || relativePath == ".." || strings.HasPrefix(relativePath, "../") {
+		return "", fmt.Errorf("backup archive entry %s is outside the data directory", name)
+	}
+	return filepath.Join(partDirectory+".restoring", filepath.FromSlash(relativePath)), nil
+}
+
+// extractFile writes an archive entry to its destination
+func extractFile(reader io.Reader, destinationPath string) error {
+	if err := os.MkdirAll(filepath.Dir(destinationPath), 0755); err != nil {
+		return err
+	}
+	file, err := os.Create(destinationPath)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(file, reader); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+// ApplyFiles replaces the lecture files, tool assets and local objects of the data directory with the extracted
+// ones. The replaced directories are removed once every part is in place
+func (extracted *ExtractedBackup) ApplyFiles() error {
+	var replacedDirectories []string
+	for stagedDirectory, partDirectory := range extracted.stagedDirectories {
+		replacedDirectory := partDirectory + ".replaced"
+		os.RemoveAll(replacedDirectory)
+		if err := os.Rename(partDirectory, replacedDirectory); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return err
+		}
+		if err := os.Rename(stagedDirectory, partDirectory); err != nil {
+			os.Rename(replacedDirectory, partDirectory)
+			return err
+		}
+		replacedDirectories = append(replacedDirectories, replacedDirectory)
+	}
+	for _, replacedDirectory := range replacedDirectories {
+		os.RemoveAll(replacedDirectory)
+	}
+	extracted.stagedDirectories = nil
+	return nil
+}
+
+// Discard removes what was extracted and not applied
+func (extracted *ExtractedBackup) Discard() {
+	for stagedDirectory := range extracted.stagedDirectories {
+		os.RemoveAll(stagedDirectory)
+	}
+	os.RemoveAll(extracted.temporaryDirectory)
+}
+
+// ListBackups lists the backup archives of the backups directory, newest first
+func ListBackups(layout Layout) ([]BackupArchive, error) {
+	entries, err := os.ReadDir(layout.BackupsDirectory())
+	if errors.Is(err, fs.ErrNotExist) {
+		return []BackupArchive{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	archives := []BackupArchive{}
+	for _, entry := range entries {
+		if !entry.Type().IsRegular() || !strings.HasSuffix(entry.Name(), backupArchiveSuffix) {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			continue
+		}
+		archives = append(archives, BackupArchive{Name: entry.Name(), Bytes: info.Size(), CreatedAt: info.ModTime()})
+	}
+	sort.Slice(archives, func(first, second int) bool {
+		return archives[first].CreatedAt.After(archives[second].CreatedAt)
+	})
+	return archives, nil
+}
+
+// BackupPath returns the path of an archive of the backups directory, refusing names that are not archives
+func BackupPath(layout Layout, name string) (string, error) {
+	if name == "" || name != filepath.Base(name) || !strings.HasSuffix(name, backupArchiveSuffix) {
+		return "", fmt.Errorf("invalid backup name: %q", name)
+	}
+	return filepath.Join(layout.BackupsDirectory(), name), nil
+}
+
+// PruneBackups removes the archives beyond the newest keepCount and those older than maximumAge, 0 disabling
+// either limit. The newest archive is always kept. It returns the removed archives
+func PruneBackups(layout Layout, keepCount int, maximumAge time.Duration) ([]BackupArchive, error) {
+	archives, err := ListBackups(layout)
+	if err != nil {
+		return nil, err
+	}
+
+	var removed []BackupArchive
+	for index, archive := range archives {
+		if index == 0 {
+			continue
+		}
+		tooMany := keepCount > 0 && index >= keepCount
+		tooOld := maximumAge > 0 && time.Since(archive.CreatedAt) > maximumAge
+		if !tooMany && !tooOld {
+			continue
+		}
+		if err := os.Remove(filepath.Join(layout.BackupsDirectory(), archive.Name)); err != nil {
+			return removed, err
+		}
+		removed = append(removed, archive)
+	}
+	return removed, nil
+}
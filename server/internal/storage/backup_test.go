@@ -0,0 +1,158 @@
+package storage
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"lectures/internal/configuration"
+	"lectures/internal/database"
+)
+
+func TestBackupArchiveRoundTrip(t *testing.T) {
+	layout := NewLayout(configuration.StorageConfiguration{DataDirectory: filepath.Join(t.TempDir(), "data")})
+	if err := layout.Ensure(); err != nil {
+		t.Fatalf("Failed to create layout: %v", err)
+	}
+	os.MkdirAll(filepath.Join(layout.LecturesDirectory(), "lecture-1"), 0755)
+	os.WriteFile(filepath.Join(layout.LecturesDirectory(), "lecture-1", "slides.pdf"), []byte("slides"), 0644)
+	os.MkdirAll(layout.ToolExportDirectory("tool-1"), 0755)
+	os.WriteFile(filepath.Join(layout.ToolExportDirectory("tool-1"), "card_1.png"), []byte("png"), 0644)
+
+	db, err := database.Initialize(layout.DatabasePath())
+	if err != nil {
+		t.Fatalf("Failed to init DB: %v", err)
+	}
+	defer db.Close()
+	db.Exec("INSERT INTO users (id, username, password_hash) VALUES ('backup-user', 'tester', 'hash')")
+	db.Exec("INSERT INTO exams (id, user_id, title) VALUES ('backup-exam', 'backup-user', 'Optics')")
+
+	snapshotPath := filepath.Join(t.TempDir(), "snapshot.db")
+	if err := database.Snapshot(db, snapshotPath); err != nil {
+		t.Fatalf("Snapshot failed: %v", err)
+	}
+	archivePath := filepath.Join(layout.BackupsDirectory(), BackupArchiveName(time.Now()))
+	manifest, err := CreateBackupArchive(archivePath, layout, snapshotPath, BackupManifest{CreatedAt: time.Now(), SchemaVersion: database.LatestMigrationVersion()}, nil)
+	if err != nil {
+		t.Fatalf("CreateBackupArchive failed: %v", err)
+	}
+	if manifest.Files != 2 || manifest.Format != BackupFormat {
+		t.Errorf("Expected 2 files in a format %d manifest, got %+v", BackupFormat, manifest)
+	}
+
+	// Changes made after the backup are undone by the restore
+	db.Exec("UPDATE exams SET title = 'Acoustics' WHERE id = 'backup-exam'")
+	os.WriteFile(filepath.Join(layout.LecturesDirectory(), "lecture-1", "slides.pdf"), []byte("edited"), 0644)
+	os.MkdirAll(filepath.Join(layout.LecturesDirectory(), "lecture-2"), 0755)
+
+	archiveFile, err := os.Open(archivePath)
+	if err != nil {
+		t.Fatalf("Failed to open archive: %v", err)
+	}
+	defer archiveFile.Close()
+	extracted, err := ExtractBackupArchive(archiveFile, layout)
+	if err != nil {
+		t.Fatalf("ExtractBackupArchive failed: %v", err)
+	}
+	defer extracted.Discard()
+	if err := database.ValidateSnapshot(extracted.DatabasePath); err != nil {
+		t.Fatalf("ValidateSnapshot failed: %v", err)
+	}
+	if err := database.RestoreSnapshot(db, extracted.DatabasePath); err != nil {
+		t.Fatalf("RestoreSnapshot failed: %v", err)
+	}
+	if err := extracted.ApplyFiles(); err != nil {
+		t.Fatalf("ApplyFiles failed: %v", err)
+	}
+
+	var title string
+	db.QueryRow("SELECT title FROM exams WHERE id = 'backup-exam'").Scan(&title)
+	if title != "Optics" {
+		t.Errorf("Expected the exam title of the backup, got %q", title)
+	}
+	if content, _ := os.ReadFile(filepath.Join(layout.LecturesDirectory(), "lecture-1", "slides.pdf")); string(content) != "slides" {
+		t.Errorf("Expected the lecture file of the backup, got %q", content)
+	}
+	if isDirectory(filepath.Join(layout.LecturesDirectory(), "lecture-2")) {
+		t.Error("Expected files added after the backup to be gone")
+	}
+	if _, err := os.Stat(filepath.Join(layout.ToolExportDirectory("tool-1"), "card_1.png")); err != nil {
+		t.Errorf("Expected the tool assets of the backup: %v", err)
+	}
+	if isDirectory(layout.LecturesDirectory() + ".replaced") {
+		t.Error("Expected the replaced directories to be removed")
+	}
+}
+
+func TestExtractBackupArchive_RejectsUnsafeArchives(t *testing.T) {
+	layout := NewLayout(configuration.StorageConfiguration{DataDirectory: filepath.Join(t.TempDir(), "data")})
+
+	archiveWith := func(entries map[string]string, order ...string) *bytes.Buffer {
+		var buffer bytes.Buffer
+		gzipWriter := gzip.NewWriter(&buffer)
+		tarWriter := tar.NewWriter(gzipWriter)
+		for _, name := range order {
+			tarWriter.WriteHeader(&tar.Header{Name: name, Mode: 0644, Size: int64(len(entries[name])), Typeflag: tar.TypeReg})
+			tarWriter.Write([]byte(entries[name]))
+		}
+		tarWriter.Close()
+		gzipWriter.Close()
+		return &buffer
+	}
+	manifest := `{"format":1}`
+
+	cases := map[string]*bytes.Buffer{
+		"not gzipped":      bytes.NewBufferString("plain text"),
+		"no manifest":      archiveWith(map[string]string{"database.db": "db"}, "database.db"),
+		"future format":    archiveWith(map[string]string{"manifest.json": `{"format":99}`, "database.db": "db"}, "manifest.json", "database.db"),
+		"path traversal":   archiveWith(map[string]string{"manifest.json": manifest, "database.db": "db", "lectures/../../escape": "x"}, "manifest.json", "database.db", "lectures/../../escape"),
+		"unknown part":     archiveWith(map[string]string{"manifest.json": manifest, "database.db": "db", "models/model.bin": "x"}, "manifest.json", "database.db", "models/model.bin"),
+		"missing database": archiveWith(map[string]string{"manifest.json": manifest, "lectures/a/b.pdf": "x"}, "manifest.json", "lectures/a/b.pdf"),
+	}
+	for name, archive := range cases {
+		if _, err := ExtractBackupArchive(archive, layout); err == nil {
+			t.Errorf("Expected the %s archive to be refused", name)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(layout.Root(), "..", "escape")); err == nil {
+		t.Error("Expected nothing written outside the data directory")
+	}
+	if isDirectory(layout.LecturesDirectory() + ".restoring") {
+		t.Error("Expected staged directories of refused archives to be removed")
+	}
+}
+
+func TestPruneBackups(t *testing.T) {
+	layout := NewLayout(configuration.StorageConfiguration{DataDirectory: filepath.Join(t.TempDir(), "data")})
+	os.MkdirAll(layout.BackupsDirectory(), 0755)
+	for daysAgo := 0; daysAgo < 5; daysAgo++ {
+		createdAt := time.Now().Add(-time.Duration(daysAgo) * 24 * time.Hour)
+		archivePath := filepath.Join(layout.BackupsDirectory(), BackupArchiveName(createdAt))
+		os.WriteFile(archivePath, []byte("archive"), 0644)
+		os.Chtimes(archivePath, createdAt, createdAt)
+	}
+
+	if _, err := BackupPath(layout, "../database.db"); err == nil {
+		t.Error("Expected a name outside the backups directory to be refused")
+	}
+
+	removed, err := PruneBackups(layout, 4, 0)
+	if err != nil || len(removed) != 1 {
+		t.Fatalf("Expected the oldest archive beyond the count removed, got %v (%v)", removed, err)
+	}
+	removed, _ = PruneBackups(layout, 0, 36*time.Hour)
+	if len(removed) != 2 {
+		t.Errorf("Expected the archives older than the age limit removed, got %v", removed)
+	}
+
+	// The newest archive survives any policy
+	removed, _ = PruneBackups(layout, 0, time.Nanosecond)
+	archives, _ := ListBackups(layout)
+	if len(removed) != 1 || len(archives) != 1 {
+		t.Errorf("Expected only the newest archive kept, got %v", archives)
+	}
+}
@@ -0,0 +1,157 @@
+package storage
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+
+	"lectures/internal/configuration"
+)
+
+// defaultLayout is where each part of the data directory is kept when storage.layout does not place it
+var defaultLayout = configuration.StorageLayoutConfiguration{
+	Database: "database.db",
+	Log:      "server.log",
+	Lectures: filepath.Join("files", "lectures"),
+	Exports:  filepath.Join("files", "exports"),
+	Backups:  "backups",
+	Objects:  filepath.Join("files", "objects"),
+}
+
+// Layout builds the paths of everything the server keeps in its data directory, so the modules that read or
+// write files agree on where they are
+type Layout struct {
+	root  string
+	parts configuration.StorageLayoutConfiguration
+}
+
+// NewLayout resolves the layout of a data directory from the storage configuration
+func NewLayout(storageConfiguration configuration.StorageConfiguration) Layout {
+	root := storageConfiguration.DataDirectory
+	parts := storageConfiguration.Layout
+	resolve := func(configuredPath string, defaultPath string) string {
+		if configuredPath == "" {
+			configuredPath = defaultPath
+		}
+		if filepath.IsAbs(configuredPath) {
+			return filepath.Clean(configuredPath)
+		}
+		return filepath.Join(root, configuredPath)
+	}
+
+	return Layout{
+		root: root,
+		parts: configuration.StorageLayoutConfiguration{
+			Database: resolve(parts.Database, defaultLayout.Database),
+			Log:      resolve(parts.Log, defaultLayout.Log),
+			Lectures: resolve(parts.Lectures, defaultLayout.Lectures),
+			Exports:  resolve(parts.Exports, defaultLayout.Exports),
+			Backups:  resolve(parts.Backups, defaultLayout.Backups),
+			Objects:  resolve(parts.Objects, defaultLayout.Objects),
+		},
+	}
+}
+
+// Root returns the data directory itself
+func (layout Layout) Root() string {
+	return layout.root
+}
+
+// DatabasePath returns the path of the SQLite database
+func (layout Layout) DatabasePath() string {
+	return layout.parts.Database
+}
+
+// LogPath returns the path of the server log
+func (layout Layout) LogPath() string {
+	return layout.parts.Log
+}
+
+// LecturesDirectory returns the directory holding lecture files
+func (layout Layout) LecturesDirectory() string {
+	return layout.parts.Lectures
+}
+
+// ExportsDirectory returns the directory holding the generated assets of every tool
+func (layout Layout) ExportsDirectory() string {
+	return layout.parts.Exports
+}
+
+// ToolExportDirectory returns the permanent directory holding the generated assets of a tool
+func (layout Layout) ToolExportDirectory(toolID string) string {
+	return filepath.Join(layout.parts.Exports, toolID)
+}
+
+// BackupsDirectory returns the directory holding backup archives
+func (layout Layout) BackupsDirectory() string {
+	return layout.parts.Backups
+}
+
+// ObjectsDirectory returns the directory holding the objects of the local object store
+func (layout Layout) ObjectsDirectory() string {
+	return layout.parts.Objects
+}
+
+// RelativePath returns the form of path stored in the database: relative to the data directory, with slashes,
+// when it is inside it, and unchanged otherwise
+func (layout Layout) RelativePath(path string) string {
+	if !filepath.IsAbs(path) {
+		return path
+	}
+	root, err := filepath.Abs(layout.root)
+	if err != nil {
+		return path
+	}
+	relativePath, err := filepath.Rel(root, path)
+	if err != nil || relativePath == ".." || strings.HasPrefix(relativePath, ".."+string(filepath.Separator)) {
+		return path
+	}
+	return filepath.ToSlash(relativePath)
+}
+
+// ResolvePath returns the location on disk of a path stored in the database. Relative paths are resolved against
+// the data directory, so stored references survive the data directory moving or being mounted elsewhere, and
+// those in the lectures or exports directory of the default layout against the part wherever it is placed
+func (layout Layout) ResolvePath(storedPath string) string {
+	if storedPath == "" || filepath.IsAbs(storedPath) {
+		return storedPath
+	}
+	for _, part := range layout.movableParts() {
+		if remainder, found := strings.CutPrefix(storedPath, part.storedPrefix); found {
+			return filepath.Join(part.directory, filepath.FromSlash(remainder))
+		}
+	}
+	return filepath.Join(layout.root, filepath.FromSlash(storedPath))
+}
+
+// movablePart is a part of the data directory that file references point into, with the prefix its files are
+// stored under whatever directory the layout places it in
+type movablePart struct {
+	storedPrefix string
+	directory    string
+}
+
+func (layout Layout) movableParts() []movablePart {
+	return []movablePart{
+		{storedPrefix: filepath.ToSlash(defaultLayout.Lectures) + "/", directory: layout.parts.Lectures},
+		{storedPrefix: filepath.ToSlash(defaultLayout.Exports) + "/", directory: layout.parts.Exports},
+	}
+}
+
+// Ensure creates the data directory and the directories of its parts
+func (layout Layout) Ensure() error {
+	directories := []string{
+		layout.root,
+		filepath.Dir(layout.parts.Database),
+		filepath.Dir(layout.parts.Log),
+		layout.parts.Lectures,
+		layout.parts.Exports,
+		layout.parts.Backups,
+	}
+	for _, directory := range directories {
+		if err := os.MkdirAll(directory, 0755); err != nil {
+			return err
+		}
+	}
+	return nil
+}
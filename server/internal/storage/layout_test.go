@@ -0,0 +1,48 @@
+package storage
+
+import (
+	"path/filepath"
+	"testing"
+
+	"lectures/internal/configuration"
+)
+
+func TestNewLayout(t *testing.T) {
+	root := filepath.Join(t.TempDir(), "data")
+
+	layout := NewLayout(configuration.StorageConfiguration{DataDirectory: root})
+	if layout.DatabasePath() != filepath.Join(root, "database.db") || layout.ToolExportDirectory("tool-1") != filepath.Join(root, "files", "exports", "tool-1") {
+		t.Errorf("Expected the default layout under the data directory, got %+v", layout)
+	}
+
+	// Relative parts stay under the data directory, absolute ones are kept wherever they point
+	largeVolume := filepath.Join(t.TempDir(), "volume")
+	layout = NewLayout(configuration.StorageConfiguration{
+		DataDirectory: root,
+		Layout:        configuration.StorageLayoutConfiguration{Database: "db/lectures.db", Exports: filepath.Join(largeVolume, "exports")},
+	})
+	if layout.DatabasePath() != filepath.Join(root, "db", "lectures.db") {
+		t.Errorf("Expected a relative database path under the data directory, got %s", layout.DatabasePath())
+	}
+	if layout.ToolExportDirectory("tool-1") != filepath.Join(largeVolume, "exports", "tool-1") {
+		t.Errorf("Expected exports on the other volume, got %s", layout.ToolExportDirectory("tool-1"))
+	}
+	if resolvedPath := layout.ResolvePath("files/exports/tool-1/card_1.png"); resolvedPath != filepath.Join(largeVolume, "exports", "tool-1", "card_1.png") {
+		t.Errorf("Expected a stored export path to resolve into the exports part, got %s", resolvedPath)
+	}
+	if relativePath := layout.relativeReference(filepath.Join(largeVolume, "exports", "tool-1", "card_1.png")); relativePath != "files/exports/tool-1/card_1.png" {
+		t.Errorf("Expected a path in the exports part to be stored under its prefix, got %s", relativePath)
+	}
+	if layout.LogPath() != filepath.Join(root, "server.log") {
+		t.Errorf("Expected unset parts to keep their default, got %s", layout.LogPath())
+	}
+
+	if err := layout.Ensure(); err != nil {
+		t.Fatalf("Ensure failed: %v", err)
+	}
+	for _, directory := range []string{filepath.Join(root, "db"), layout.ExportsDirectory(), layout.LecturesDirectory()} {
+		if !isDirectory(directory) {
+			t.Errorf("Expected %s to be created", directory)
+		}
+	}
+}
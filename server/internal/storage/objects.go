@@ -0,0 +1,158 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"lectures/internal/configuration"
+)
+
+// Backends of storage.objects.backend
+const (
+	ObjectBackendDatabase = "database"
+	ObjectBackendLocal    = "local"
+	ObjectBackendS3       = "s3"
+)
+
+// ErrObjectNotFound is returned when a store has no object under a key
+var ErrObjectNotFound = errors.New("object not found")
+
+// ObjectStore keeps the bytes of lecture media, reference page images and exports outside the database. Keys
+// are slash-separated paths, as built by MediaObjectKey, PageImageObjectKey and ExportObjectKey
+type ObjectStore interface {
+	Put(ctx context.Context, key string, content []byte, contentType string) error
+	Get(ctx context.Context, key string) ([]byte, error)
+	Delete(ctx context.Context, key string) error
+	// PresignGet returns a URL downloading the object without credentials for a limited time, served under
+	// fileName as an attachment or inline. Stores that cannot hand out such URLs return an empty one, and the
+	// server streams the object itself
+	PresignGet(key string, fileName string, inline bool) (string, error)
+}
+
+// NewObjectStore opens the object store storage.objects configures, nil when the database keeps the bytes
+func NewObjectStore(storageConfiguration configuration.StorageConfiguration) (ObjectStore, error) {
+	switch storageConfiguration.Objects.Backend {
+	case "", ObjectBackendDatabase:
+		return nil, nil
+	case ObjectBackendLocal:
+		return NewLocalObjectStore(NewLayout(storageConfiguration).ObjectsDirectory()), nil
+	case ObjectBackendS3:
+		store, err := NewS3ObjectStore(storageConfiguration.Objects.S3)
+		if err != nil {
+			return nil, err
+		}
+		return store, nil
+	default:
+		return nil, fmt.Errorf("unknown object storage backend %q", storageConfiguration.Objects.Backend)
+	}
+}
+
+// MediaObjectKey is the key of a media file of a lecture
+func MediaObjectKey(lectureID string, mediaID string, extension string) string {
+	return path.Join("lectures", lectureID, "media", mediaID+extension)
+}
+
+// PageImageObjectKey is the key of the image of a page of a reference document
+func PageImageObjectKey(lectureID string, documentID string, pageNumber int) string {
+	return path.Join("lectures", lectureID, "pages", documentID, fmt.Sprintf("%d.png", pageNumber))
+}
+
+// ExportObjectKey is the key of a file exported by a job
+func ExportObjectKey(jobID string, fileName string) string {
+	return path.Join("exports", jobID, fileName)
+}
+
+// StoreContent puts content in the store when one is configured and returns what to record in its BLOB and
+// object key columns: no BLOB and the key once stored, or the content itself and no key without a store
+func StoreContent(ctx context.Context, store ObjectStore, key string, content []byte, contentType string) ([]byte, sql.NullString, error) {
+	if store == nil {
+		return content, sql.NullString{}, nil
+	}
+	if err := store.Put(ctx, key, content, contentType); err != nil {
+		return nil, sql.NullString{}, fmt.Errorf("failed to store %s: %w", key, err)
+	}
+	return nil, sql.NullString{String: key, Valid: true}, nil
+}
+
+// LoadContent returns content kept in a BLOB column or, when there is none, in the store under its object key.
+// Content of neither kind is returned empty
+func LoadContent(ctx context.Context, store ObjectStore, blob []byte, objectKey string) ([]byte, error) {
+	if len(blob) > 0 || objectKey == "" {
+		return blob, nil
+	}
+	if store == nil {
+		return nil, fmt.Errorf("%s is in object storage, which is not configured", objectKey)
+	}
+	return store.Get(ctx, objectKey)
+}
+
+// LocalObjectStore keeps objects as files under a directory
+type LocalObjectStore struct {
+	root string
+}
+
+// NewLocalObjectStore keeps objects under root
+func NewLocalObjectStore(root string) *LocalObjectStore {
+	return &LocalObjectStore{root: root}
+}
+
+// objectPath returns the file of a key, refusing keys that leave the root
+func (store *LocalObjectStore) objectPath(key string) (string, error) {
+	cleanKey := path.Clean(key)
+	if key == "" || path.IsAbs(cleanKey) || cleanKey == ".." || strings.HasPrefix(cleanKey, "../") {
+		return "", fmt.Errorf("object key %q is outside the object store", key)
+	}
+	return filepath.Join(store.root, filepath.FromSlash(cleanKey)), nil
+}
+
+func (store *LocalObjectStore) Put(ctx context.Context, key string, content []byte, contentType string) error {
+	objectPath, err := store.objectPath(key)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(objectPath), 0755); err != nil {
+		return err
+	}
+	// Written aside and renamed, so readers never see a partial object
+	partialPath := objectPath + ".partial"
+	if err := os.WriteFile(partialPath, content, 0644); err != nil {
+		os.Remove(partialPath)
+		return err
+	}
+	return os.Rename(partialPath, objectPath)
+}
+
+func (store *LocalObjectStore) Get(ctx context.Context, key string) ([]byte, error) {
+	objectPath, err := store.objectPath(key)
+	if err != nil {
+		return nil, err
+	}
+	content, err := os.ReadFile(objectPath)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, ErrObjectNotFound
+	}
+	return content, err
+}
+
+func (store *LocalObjectStore) Delete(ctx context.Context, key string) error {
+	objectPath, err := store.objectPath(key)
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(objectPath); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+// PresignGet returns no URL: local objects are served by the server
+func (store *LocalObjectStore) PresignGet(key string, fileName string, inline bool) (string, error) {
+	return "", nil
+}
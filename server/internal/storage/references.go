@@ -0,0 +1,90 @@
+package storage
+
+import (
+	"database/sql"
+	"fmt"
+	"path/filepath"
+	"strings"
+)
+
+// fileReferenceColumns are the columns holding the path of a stored file
+var fileReferenceColumns = []struct{ table, column string }{
+	{"lecture_media", "file_path"},
+	{"reference_documents", "file_path"},
+	{"reference_pages", "image_path"},
+}
+
+// defaultLayoutMarkers are the directories of the default layout, looked for in absolute paths written by a
+// server whose data directory was elsewhere, such as another mount point in Docker
+var defaultLayoutMarkers = []string{"/files/lectures/", "/files/exports/"}
+
+// MigrateFileReferences rewrites the absolute file paths stored by older versions into paths relative to the data
+// directory, returning how many rows changed. Paths outside the data directory are made relative from the first
+// directory of the default layout they contain, and left unchanged when they contain none. It is safe to run on
+// every start
+func MigrateFileReferences(database *sql.DB, layout Layout) (int64, error) {
+	type fileReference struct {
+		rowID int64
+		path  string
+	}
+
+	transaction, err := database.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer transaction.Rollback()
+
+	var migratedRows int64
+	for _, referenceColumn := range fileReferenceColumns {
+		rows, err := transaction.Query(fmt.Sprintf("SELECT rowid, %s FROM %s", referenceColumn.column, referenceColumn.table))
+		if err != nil {
+			return 0, fmt.Errorf("failed to read %s.%s: %w", referenceColumn.table, referenceColumn.column, err)
+		}
+		var absoluteReferences []fileReference
+		for rows.Next() {
+			var reference fileReference
+			if rows.Scan(&reference.rowID, &reference.path) == nil && filepath.IsAbs(reference.path) {
+				absoluteReferences = append(absoluteReferences, reference)
+			}
+		}
+		rows.Close()
+
+		for _, reference := range absoluteReferences {
+			relativePath := layout.relativeReference(reference.path)
+			if relativePath == reference.path {
+				continue
+			}
+			_, err := transaction.Exec(fmt.Sprintf("UPDATE %s SET %s = ? WHERE rowid = ?", referenceColumn.table, referenceColumn.column), relativePath, reference.rowID)
+			if err != nil {
+				return 0, fmt.Errorf("failed to migrate %s.%s: %w", referenceColumn.table, referenceColumn.column, err)
+			}
+			migratedRows++
+		}
+	}
+	return migratedRows, transaction.Commit()
+}
+
+// relativeReference returns the stored form of an absolute file path, see MigrateFileReferences. Paths in the
+// lectures or exports part are stored under the prefix of the part, which ResolvePath maps back to it
+func (layout Layout) relativeReference(path string) string {
+	for _, part := range layout.movableParts() {
+		directory, err := filepath.Abs(part.directory)
+		if err != nil {
+			continue
+		}
+		relativePath, err := filepath.Rel(directory, path)
+		if err == nil && relativePath != ".." && !strings.HasPrefix(relativePath, ".."+string(filepath.Separator)) {
+			return part.storedPrefix + filepath.ToSlash(relativePath)
+		}
+	}
+	if relativePath := layout.RelativePath(path); relativePath != path {
+		return relativePath
+	}
+	slashedPath := filepath.ToSlash(path)
+	for _, marker := range defaultLayoutMarkers {
+		if markerIndex := strings.Index(slashedPath, marker); markerIndex >= 0 {
+			return slashedPath[markerIndex+1:]
+		}
+	}
+	return path
+}
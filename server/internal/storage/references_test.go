@@ -0,0 +1,71 @@
+package storage
+
+import (
+	"path/filepath"
+	"testing"
+
+	"lectures/internal/configuration"
+	"lectures/internal/database"
+)
+
+func TestMigrateFileReferences(t *testing.T) {
+	root := filepath.Join(t.TempDir(), "data")
+	layout := NewLayout(configuration.StorageConfiguration{DataDirectory: root})
+	if err := layout.Ensure(); err != nil {
+		t.Fatalf("Ensure failed: %v", err)
+	}
+	db, err := database.Initialize(layout.DatabasePath())
+	if err != nil {
+		t.Fatalf("Failed to init DB: %v", err)
+	}
+	defer db.Close()
+
+	db.Exec("INSERT INTO users (id, username, password_hash) VALUES ('paths-user', 'tester', 'hash')")
+	db.Exec("INSERT INTO exams (id, user_id, title) VALUES ('paths-exam', 'paths-user', 'Optics')")
+	db.Exec("INSERT INTO lectures (id, exam_id, title) VALUES ('paths-lecture', 'paths-exam', 'Lenses')")
+	referencedFiles := map[string]string{
+		// Written by an older server into this data directory
+		"inside": filepath.Join(root, "files", "lectures", "paths-lecture", "inside.pdf"),
+		// Written under the Docker mount point of the same data directory
+		"mounted": "/data/files/lectures/paths-lecture/mounted.pdf",
+		// Unrelated to any data directory, so it cannot be made relative
+		"external": "/mnt/archive/external.pdf",
+		// Already stored the current way
+		"logical": "logical.pdf",
+	}
+	for documentID, filePath := range referencedFiles {
+		db.Exec("INSERT INTO reference_documents (id, lecture_id, document_type, title, file_path, page_count) VALUES (?, 'paths-lecture', 'pdf', ?, ?, 1)", documentID, documentID, filePath)
+	}
+	db.Exec("INSERT INTO reference_pages (document_id, page_number, image_path) VALUES ('inside', 1, ?)", filepath.Join(root, "files", "lectures", "paths-lecture", "page_1.png"))
+
+	migratedRows, err := MigrateFileReferences(db, layout)
+	if err != nil || migratedRows != 3 {
+		t.Fatalf("Expected three rows to be migrated, got %d (%v)", migratedRows, err)
+	}
+
+	expectedPaths := map[string]string{
+		"inside":   "files/lectures/paths-lecture/inside.pdf",
+		"mounted":  "files/lectures/paths-lecture/mounted.pdf",
+		"external": "/mnt/archive/external.pdf",
+		"logical":  "logical.pdf",
+	}
+	for documentID, expectedPath := range expectedPaths {
+		var storedPath string
+		db.QueryRow("SELECT file_path FROM reference_documents WHERE id = ?", documentID).Scan(&storedPath)
+		if storedPath != expectedPath {
+			t.Errorf("Expected %s to be stored as %q, got %q", documentID, expectedPath, storedPath)
+		}
+	}
+	var imagePath string
+	db.QueryRow("SELECT image_path FROM reference_pages WHERE document_id = 'inside'").Scan(&imagePath)
+	if imagePath != "files/lectures/paths-lecture/page_1.png" {
+		t.Errorf("Expected the page image path to be relative, got %q", imagePath)
+	}
+	if resolvedPath := layout.ResolvePath(imagePath); resolvedPath != filepath.Join(root, "files", "lectures", "paths-lecture", "page_1.png") {
+		t.Errorf("Expected the relative path to resolve into the data directory, got %s", resolvedPath)
+	}
+
+	if migratedRows, _ := MigrateFileReferences(db, layout); migratedRows != 0 {
+		t.Errorf("Expected a second run to change nothing, got %d rows", migratedRows)
+	}
+}
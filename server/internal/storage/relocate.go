@@ -0,0 +1,150 @@
+package storage
+
+import (
+	"database/sql"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// pathColumns are the text columns that may hold absolute paths into the data directory: tool content edited or
+// imported with local image paths, job payloads and results, and settings such as a local model file
+var pathColumns = []struct{ table, column string }{
+	{"tools", "content"},
+	{"tool_sections", "content"},
+	{"jobs", "payload"},
+	{"jobs", "result"},
+	{"settings", "value"},
+}
+
+// MoveDataDirectory moves the data directory from oldRoot to newRoot. It is renamed when both are on the same
+// volume, and otherwise copied and removed once the copy is complete. newRoot must not exist or be empty
+func MoveDataDirectory(oldRoot string, newRoot string) error {
+	oldRoot, newRoot, err := absolutePaths(oldRoot, newRoot)
+	if err != nil {
+		return err
+	}
+	if oldRoot == newRoot {
+		return fmt.Errorf("the data directory is already %s", newRoot)
+	}
+	if strings.HasPrefix(newRoot, oldRoot+string(filepath.Separator)) {
+		return fmt.Errorf("cannot move the data directory into itself")
+	}
+	if information, err := os.Stat(oldRoot); err != nil || !information.IsDir() {
+		return fmt.Errorf("data directory %s not found", oldRoot)
+	}
+
+	entries, err := os.ReadDir(newRoot)
+	switch {
+	case err == nil && len(entries) > 0:
+		return fmt.Errorf("%s is not empty", newRoot)
+	case err == nil:
+		if err := os.Remove(newRoot); err != nil {
+			return err
+		}
+	case !os.IsNotExist(err):
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(newRoot), 0755); err != nil {
+		return err
+	}
+
+	if os.Rename(oldRoot, newRoot) == nil {
+		return nil
+	}
+	// Renaming fails across volumes, which is the usual reason to relocate
+	if err := copyDirectory(oldRoot, newRoot); err != nil {
+		os.RemoveAll(newRoot)
+		return fmt.Errorf("failed to copy the data directory: %w", err)
+	}
+	return os.RemoveAll(oldRoot)
+}
+
+// RewriteStoredPaths replaces the absolute paths under oldRoot stored in the database with the same paths under
+// newRoot, returning how many rows changed. File references MigrateFileReferences left absolute are rewritten too
+func RewriteStoredPaths(database *sql.DB, oldRoot string, newRoot string) (int64, error) {
+	oldRoot, newRoot, err := absolutePaths(oldRoot, newRoot)
+	if err != nil {
+		return 0, err
+	}
+	oldPrefix := oldRoot + string(filepath.Separator)
+	newPrefix := newRoot + string(filepath.Separator)
+
+	transaction, err := database.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer transaction.Rollback()
+
+	var rewrittenRows int64
+	for _, pathColumn := range append(pathColumns, fileReferenceColumns...) {
+		result, err := transaction.Exec(fmt.Sprintf(
+			"UPDATE %[1]s SET %[2]s = REPLACE(%[2]s, ?, ?) WHERE instr(%[2]s, ?) > 0", pathColumn.table, pathColumn.column,
+		), oldPrefix, newPrefix, oldPrefix)
+		if err != nil {
+			return 0, fmt.Errorf("failed to rewrite paths in %s.%s: %w", pathColumn.table, pathColumn.column, err)
+		}
+		affectedRows, _ := result.RowsAffected()
+		rewrittenRows += affectedRows
+	}
+	return rewrittenRows, transaction.Commit()
+}
+
+func absolutePaths(firstPath string, secondPath string) (string, string, error) {
+	firstAbsolutePath, err := filepath.Abs(firstPath)
+	if err != nil {
+		return "", "", err
+	}
+	secondAbsolutePath, err := filepath.Abs(secondPath)
+	if err != nil {
+		return "", "", err
+	}
+	return firstAbsolutePath, secondAbsolutePath, nil
+}
+
+// copyDirectory copies a directory tree with its file modes and symbolic links
+func copyDirectory(sourceDirectory string, destinationDirectory string) error {
+	return filepath.WalkDir(sourceDirectory, func(sourcePath string, entry fs.DirEntry, walkError error) error {
+		if walkError != nil {
+			return walkError
+		}
+		relativePath, err := filepath.Rel(sourceDirectory, sourcePath)
+		if err != nil {
+			return err
+		}
+		destinationPath := filepath.Join(destinationDirectory, relativePath)
+		information, err := entry.Info()
+		if err != nil {
+			return err
+		}
+
+		switch {
+		case entry.IsDir():
+			return os.MkdirAll(destinationPath, information.Mode().Perm())
+		case information.Mode()&os.ModeSymlink != 0:
+			target, err := os.Readlink(sourcePath)
+			if err != nil {
+				return err
+			}
+			return os.Symlink(target, destinationPath)
+		}
+
+		sourceFile, err := os.Open(sourcePath)
+		if err != nil {
+			return err
+		}
+		defer sourceFile.Close()
+		destinationFile, err := os.OpenFile(destinationPath, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, information.Mode().Perm())
+		if err != nil {
+			return err
+		}
+		if _, err := io.Copy(destinationFile, sourceFile); err != nil {
+			destinationFile.Close()
+			return err
+		}
+		return destinationFile.Close()
+	})
+}
@@ -0,0 +1,94 @@
+package storage
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"lectures/internal/database"
+)
+
+func isDirectory(path string) bool {
+	information, err := os.Stat(path)
+	return err == nil && information.IsDir()
+}
+
+func TestRelocateDataDirectory(t *testing.T) {
+	oldRoot := filepath.Join(t.TempDir(), "data")
+	os.MkdirAll(filepath.Join(oldRoot, "files", "exports", "tool-1", "images"), 0755)
+	os.WriteFile(filepath.Join(oldRoot, "files", "exports", "tool-1", "images", "card_1.png"), []byte("png"), 0644)
+
+	db, err := database.Initialize(filepath.Join(oldRoot, "database.db"))
+	if err != nil {
+		t.Fatalf("Failed to init DB: %v", err)
+	}
+	db.Exec("INSERT INTO users (id, username, password_hash) VALUES ('relocate-user', 'tester', 'hash')")
+	db.Exec("INSERT INTO exams (id, user_id, title) VALUES ('relocate-exam', 'relocate-user', 'Optics')")
+	db.Exec("INSERT INTO tools (id, exam_id, type, title, content) VALUES ('tool-1', 'relocate-exam', 'guide', 'Lenses', ?)",
+		"![Lens]("+filepath.Join(oldRoot, "files", "exports", "tool-1", "images", "card_1.png")+")")
+	// A sibling directory sharing the prefix is not part of the data directory
+	db.Exec("INSERT INTO tools (id, exam_id, type, title, content) VALUES ('tool-2', 'relocate-exam', 'guide', 'Mirrors', ?)",
+		"![Mirror]("+oldRoot+"-backup/mirror.png)")
+	// A file path left absolute by an older version follows the data directory too
+	db.Exec("INSERT INTO lectures (id, exam_id, title) VALUES ('relocate-lecture', 'relocate-exam', 'Lenses')")
+	db.Exec("INSERT INTO reference_documents (id, lecture_id, document_type, title, file_path, page_count) VALUES ('relocate-document', 'relocate-lecture', 'pdf', 'Lenses', ?, 1)",
+		filepath.Join(oldRoot, "files", "lectures", "relocate-lecture", "lenses.pdf"))
+	db.Close()
+
+	newRoot := filepath.Join(t.TempDir(), "volume", "data")
+	if err := MoveDataDirectory(oldRoot, filepath.Join(oldRoot, "nested")); err == nil {
+		t.Error("Expected moving the data directory into itself to fail")
+	}
+	if err := MoveDataDirectory(oldRoot, newRoot); err != nil {
+		t.Fatalf("MoveDataDirectory failed: %v", err)
+	}
+	if isDirectory(oldRoot) {
+		t.Error("Expected the old data directory to be gone")
+	}
+	if _, err := os.Stat(filepath.Join(newRoot, "files", "exports", "tool-1", "images", "card_1.png")); err != nil {
+		t.Errorf("Expected the tool assets to move along: %v", err)
+	}
+
+	db, err = database.Initialize(filepath.Join(newRoot, "database.db"))
+	if err != nil {
+		t.Fatalf("Failed to reopen DB: %v", err)
+	}
+	defer db.Close()
+	rewrittenRows, err := RewriteStoredPaths(db, oldRoot, newRoot)
+	if err != nil || rewrittenRows != 2 {
+		t.Fatalf("Expected two rows to be rewritten, got %d (%v)", rewrittenRows, err)
+	}
+	var movedContent, siblingContent string
+	db.QueryRow("SELECT content FROM tools WHERE id = 'tool-1'").Scan(&movedContent)
+	db.QueryRow("SELECT content FROM tools WHERE id = 'tool-2'").Scan(&siblingContent)
+	if movedContent != "![Lens]("+filepath.Join(newRoot, "files", "exports", "tool-1", "images", "card_1.png")+")" {
+		t.Errorf("Expected the path to point into the new data directory, got %s", movedContent)
+	}
+	if siblingContent != "![Mirror]("+oldRoot+"-backup/mirror.png)" {
+		t.Errorf("Expected paths outside the data directory to be kept, got %s", siblingContent)
+	}
+	var documentPath string
+	db.QueryRow("SELECT file_path FROM reference_documents WHERE id = 'relocate-document'").Scan(&documentPath)
+	if documentPath != filepath.Join(newRoot, "files", "lectures", "relocate-lecture", "lenses.pdf") {
+		t.Errorf("Expected the document path to point into the new data directory, got %s", documentPath)
+	}
+}
+
+func TestCopyDirectory(t *testing.T) {
+	sourceDirectory := t.TempDir()
+	os.MkdirAll(filepath.Join(sourceDirectory, "models"), 0700)
+	os.WriteFile(filepath.Join(sourceDirectory, "models", "model.bin"), []byte("weights"), 0600)
+	os.Symlink("models/model.bin", filepath.Join(sourceDirectory, "current"))
+
+	destinationDirectory := filepath.Join(t.TempDir(), "copy")
+	if err := copyDirectory(sourceDirectory, destinationDirectory); err != nil {
+		t.Fatalf("copyDirectory failed: %v", err)
+	}
+	information, err := os.Stat(filepath.Join(destinationDirectory, "models", "model.bin"))
+	if err != nil || information.Mode().Perm() != 0600 {
+		t.Errorf("Expected the file to be copied with its mode, got %v (%v)", information, err)
+	}
+	if target, err := os.Readlink(filepath.Join(destinationDirectory, "current")); err != nil || target != "models/model.bin" {
+		t.Errorf("Expected the symbolic link to be recreated, got %q (%v)", target, err)
+	}
+}
@@ -0,0 +1,294 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"lectures/internal/configuration"
+)
+
+const (
+	s3DefaultRegion         = "us-east-1"
+	s3DefaultPresignSeconds = 900
+	s3SigningAlgorithm      = "AWS4-HMAC-SHA256"
+	s3UnsignedPayload       = "UNSIGNED-PAYLOAD"
+	// s3MaximumPresignSeconds is the longest lifetime Signature Version 4 allows a presigned URL
+	s3MaximumPresignSeconds = 7 * 24 * 60 * 60
+)
+
+// S3ObjectStore keeps objects in a bucket of an S3-compatible service, such as AWS S3 or MinIO. Requests are
+// signed with Signature Version 4
+type S3ObjectStore struct {
+	endpoint        *url.URL
+	region          string
+	bucket          string
+	prefix          string
+	accessKeyID     string
+	secretAccessKey string
+	pathStyle       bool
+	presignDuration time.Duration
+	httpClient      *http.Client
+	now             func() time.Time
+}
+
+// NewS3ObjectStore reaches the bucket of an S3 configuration
+func NewS3ObjectStore(s3Configuration configuration.S3Configuration) (*S3ObjectStore, error) {
+	if s3Configuration.Bucket == "" {
+		return nil, fmt.Errorf("storage.objects.s3.bucket is required")
+	}
+	if s3Configuration.AccessKeyID == "" || s3Configuration.SecretAccessKey == "" {
+		return nil, fmt.Errorf("storage.objects.s3.access_key_id and secret_access_key are required")
+	}
+	region := s3Configuration.Region
+	if region == "" {
+		region = s3DefaultRegion
+	}
+	endpoint := s3Configuration.Endpoint
+	if endpoint == "" {
+		endpoint = fmt.Sprintf("https://s3.%s.amazonaws.com", region)
+	}
+	endpointURL, err := url.Parse(endpoint)
+	if err != nil || endpointURL.Host == "" || (endpointURL.Scheme != "http" && endpointURL.Scheme != "https") {
+		return nil, fmt.Errorf("storage.objects.s3.endpoint %q is not an http(s) URL", endpoint)
+	}
+	presignSeconds := s3Configuration.PresignSeconds
+	if presignSeconds <= 0 {
+		presignSeconds = s3DefaultPresignSeconds
+	}
+
+	return &S3ObjectStore{
+		endpoint:        endpointURL,
+		region:          region,
+		bucket:          s3Configuration.Bucket,
+		prefix:          strings.Trim(s3Configuration.Prefix, "/"),
+		accessKeyID:     s3Configuration.AccessKeyID,
+		secretAccessKey: s3Configuration.SecretAccessKey,
+		pathStyle:       s3Configuration.PathStyle,
+		presignDuration: time.Duration(min(presignSeconds, s3MaximumPresignSeconds)) * time.Second,
+		httpClient:      &http.Client{Timeout: 10 * time.Minute},
+		now:             time.Now,
+	}, nil
+}
+
+func (store *S3ObjectStore) Put(ctx context.Context, key string, content []byte, contentType string) error {
+	headers := http.Header{}
+	if contentType != "" {
+		headers.Set("Content-Type", contentType)
+	}
+	response, err := store.do(ctx, http.MethodPut, key, content, headers)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		return s3ResponseError(response)
+	}
+	return nil
+}
+
+func (store *S3ObjectStore) Get(ctx context.Context, key string) ([]byte, error) {
+	response, err := store.do(ctx, http.MethodGet, key, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+	if response.StatusCode == http.StatusNotFound {
+		return nil, ErrObjectNotFound
+	}
+	if response.StatusCode != http.StatusOK {
+		return nil, s3ResponseError(response)
+	}
+	return io.ReadAll(response.Body)
+}
+
+func (store *S3ObjectStore) Delete(ctx context.Context, key string) error {
+	response, err := store.do(ctx, http.MethodDelete, key, nil, nil)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	// Deleting a missing object succeeds on S3, 404 is tolerated for services that report it
+	if response.StatusCode != http.StatusNoContent && response.StatusCode != http.StatusOK && response.StatusCode != http.StatusNotFound {
+		return s3ResponseError(response)
+	}
+	return nil
+}
+
+// PresignGet returns a URL downloading the object until storage.objects.s3.presign_seconds elapse
+func (store *S3ObjectStore) PresignGet(key string, fileName string, inline bool) (string, error) {
+	objectURL := store.objectURL(key)
+	signedAt := store.now().UTC()
+	amzDate := signedAt.Format("20060102T150405Z")
+	scope := store.scope(signedAt)
+
+	query := url.Values{}
+	query.Set("X-Amz-Algorithm", s3SigningAlgorithm)
+	query.Set("X-Amz-Credential", store.accessKeyID+"/"+scope)
+	query.Set("X-Amz-Date", amzDate)
+	query.Set("X-Amz-Expires", strconv.Itoa(int(store.presignDuration.Seconds())))
+	query.Set("X-Amz-SignedHeaders", "host")
+	if fileName != "" {
+		disposition := "attachment"
+		if inline {
+			disposition = "inline"
+		}
+		query.Set("response-content-disposition", mime.FormatMediaType(disposition, map[string]string{"filename": fileName}))
+		if contentType := mime.TypeByExtension(path.Ext(fileName)); contentType != "" {
+			query.Set("response-content-type", contentType)
+		}
+	}
+
+	canonicalQuery := s3CanonicalQuery(query)
+	canonicalRequest := strings.Join([]string{
+		http.MethodGet,
+		objectURL.RawPath,
+		canonicalQuery,
+		"host:" + objectURL.Host + "\n",
+		"host",
+		s3UnsignedPayload,
+	}, "\n")
+	signature := store.sign(signedAt, amzDate, scope, canonicalRequest)
+
+	objectURL.RawQuery = canonicalQuery + "&X-Amz-Signature=" + signature
+	return objectURL.String(), nil
+}
+
+// do sends a request on an object signed in its Authorization header
+func (store *S3ObjectStore) do(ctx context.Context, method string, key string, content []byte, headers http.Header) (*http.Response, error) {
+	objectURL := store.objectURL(key)
+	request, err := http.NewRequestWithContext(ctx, method, objectURL.String(), bytes.NewReader(content))
+	if err != nil {
+		return nil, err
+	}
+	for name, values := range headers {
+		request.Header[name] = values
+	}
+	request.ContentLength = int64(len(content))
+
+	signedAt := store.now().UTC()
+	amzDate := signedAt.Format("20060102T150405Z")
+	scope := store.scope(signedAt)
+	payloadHash := sha256.Sum256(content)
+	payloadHashHex := hex.EncodeToString(payloadHash[:])
+	request.Header.Set("X-Amz-Date", amzDate)
+	request.Header.Set("X-Amz-Content-Sha256", payloadHashHex)
+
+	signedHeaders := "host;x-amz-content-sha256;x-amz-date"
+	canonicalRequest := strings.Join([]string{
+		method,
+		objectURL.RawPath,
+		"",
+		"host:" + objectURL.Host + "\nx-amz-content-sha256:" + payloadHashHex + "\nx-amz-date:" + amzDate + "\n",
+		signedHeaders,
+		payloadHashHex,
+	}, "\n")
+	signature := store.sign(signedAt, amzDate, scope, canonicalRequest)
+	request.Header.Set("Authorization", fmt.Sprintf("%s Credential=%s/%s, SignedHeaders=%s, Signature=%s", s3SigningAlgorithm, store.accessKeyID, scope, signedHeaders, signature))
+
+	response, err := store.httpClient.Do(request)
+	if err != nil {
+		return nil, fmt.Errorf("object storage request failed: %w", err)
+	}
+	return response, nil
+}
+
+// objectURL returns the URL of an object, with the bucket in the host name or, path style, in the path
+func (store *S3ObjectStore) objectURL(key string) *url.URL {
+	objectKey := key
+	if store.prefix != "" {
+		objectKey = store.prefix + "/" + key
+	}
+	objectURL := *store.endpoint
+	basePath := strings.TrimSuffix(objectURL.Path, "/")
+	if store.pathStyle {
+		objectURL.Path = basePath + "/" + store.bucket + "/" + objectKey
+	} else {
+		objectURL.Host = store.bucket + "." + objectURL.Host
+		objectURL.Path = basePath + "/" + objectKey
+	}
+	// Object keys are encoded once, as S3 expects, with slashes kept
+	objectURL.RawPath = s3EscapePath(objectURL.Path)
+	objectURL.RawQuery = ""
+	return &objectURL
+}
+
+// scope is the credential scope of a signature made at a time
+func (store *S3ObjectStore) scope(signedAt time.Time) string {
+	return signedAt.Format("20060102") + "/" + store.region + "/s3/aws4_request"
+}
+
+// sign returns the hex signature of a canonical request
+func (store *S3ObjectStore) sign(signedAt time.Time, amzDate string, scope string, canonicalRequest string) string {
+	canonicalHash := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := s3SigningAlgorithm + "\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(canonicalHash[:])
+
+	signingKey := hmacSHA256([]byte("AWS4"+store.secretAccessKey), signedAt.Format("20060102"))
+	signingKey = hmacSHA256(signingKey, store.region)
+	signingKey = hmacSHA256(signingKey, "s3")
+	signingKey = hmacSHA256(signingKey, "aws4_request")
+	return hex.EncodeToString(hmacSHA256(signingKey, stringToSign))
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+// s3Escape encodes everything but the unreserved characters, as Signature Version 4 requires
+func s3Escape(value string) string {
+	var builder strings.Builder
+	for _, character := range []byte(value) {
+		if ('A' <= character && character <= 'Z') || ('a' <= character && character <= 'z') || ('0' <= character && character <= '9') ||
+			character == '-' || character == '_' || character == '.' || character == '~' {
+			builder.WriteByte(character)
+		} else {
+			fmt.Fprintf(&builder, "%%%02X", character)
+		}
+	}
+	return builder.String()
+}
+
+// s3EscapePath encodes each segment of a path
+func s3EscapePath(unescapedPath string) string {
+	segments := strings.Split(unescapedPath, "/")
+	for index, segment := range segments {
+		segments[index] = s3Escape(segment)
+	}
+	return strings.Join(segments, "/")
+}
+
+// s3CanonicalQuery encodes a query sorted by name, as signed
+func s3CanonicalQuery(query url.Values) string {
+	names := make([]string, 0, len(query))
+	for name := range query {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var parts []string
+	for _, name := range names {
+		for _, value := range query[name] {
+			parts = append(parts, s3Escape(name)+"="+s3Escape(value))
+		}
+	}
+	return strings.Join(parts, "&")
+}
+
+// s3ResponseError describes a failed response with the start of its body, where S3 explains the error
+func s3ResponseError(response *http.Response) error {
+	body, _ := io.ReadAll(io.LimitReader(response.Body, 1024))
+	return fmt.Errorf("object storage answered %s: %s", response.Status, strings.TrimSpace(string(body)))
+}
@@ -0,0 +1,62 @@
+package tools
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"os"
+	"strconv"
+
+	"lectures/internal/markdown"
+	"lectures/internal/models"
+	"lectures/internal/prompts"
+)
+
+// citationRegionMaximumArea is the share of a page above which a citation keeps the whole page image
+const citationRegionMaximumArea = 0.8
+
+// LocateCitationRegion asks the documents ingestion model where on a cited page the claim is supported. It
+// returns nil when the model finds no focused region, or one covering most of the page, so the whole page is kept
+func (generator *ToolGenerator) LocateCitationRegion(jobContext context.Context, imagePath string, pageNumber int, claim string) (*markdown.PageRegion, models.JobMetrics, error) {
+	imageData, err := os.ReadFile(imagePath)
+	if err != nil {
+		return nil, models.JobMetrics{}, fmt.Errorf("failed to read page image: %w", err)
+	}
+
+	var prompt string
+	if generator.promptManager != nil {
+		prompt, _ = generator.promptManager.GetPrompt(prompts.PromptLocateCitationRegion, map[string]string{
+			"page_number": strconv.Itoa(pageNumber),
+			"claim":       claim,
+		})
+	}
+
+	dataURL := "data:image/png;base64," + base64.StdEncoding.EncodeToString(imageData)
+	response, metrics, err := generator.callLLMWithImage(jobContext, prompt, dataURL, generator.configuration.LLM.GetModelForTask("documents_ingestion"))
+	if err != nil {
+		return nil, metrics, err
+	}
+
+	var result struct {
+		Found  bool    `json:"found"`
+		Left   float64 `json:"left"`
+		Top    float64 `json:"top"`
+		Width  float64 `json:"width"`
+		Height float64 `json:"height"`
+	}
+	if err := generator.unmarshalJSONWithFallback(response, &result); err != nil {
+		return nil, metrics, fmt.Errorf("failed to parse citation region: %w", err)
+	}
+	if !result.Found {
+		return nil, metrics, nil
+	}
+
+	region := markdown.PageRegion{Page: pageNumber, Left: result.Left, Top: result.Top, Width: result.Width, Height: result.Height}
+	if !region.Valid() {
+		return nil, metrics, fmt.Errorf("the model returned a region outside the page: %+v", region)
+	}
+	if region.Width*region.Height > citationRegionMaximumArea {
+		return nil, metrics, nil
+	}
+	return &region, metrics, nil
+}
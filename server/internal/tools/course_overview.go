@@ -0,0 +1,159 @@
+package tools
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"lectures/internal/models"
+	"lectures/internal/prompts"
+)
+
+// maximumCourseTranscriptCharacters bounds the transcripts of a course overview, shared evenly between its
+// lectures, so a long course still fits the context of the outline and section calls
+const maximumCourseTranscriptCharacters = 400_000
+
+// CourseLecture is a lecture of a course overview: its transcript and the key pages of its reference files
+type CourseLecture struct {
+	ID                 string
+	Title              string
+	Transcript         string
+	DocumentNames      []string
+	ReferenceMaterials string
+}
+
+// CourseOverlap is material repeated in several lectures of a course overview, which the overview covers once:
+// the numbers of the lectures repeating it, and the occurrence to cite, explaining it most clearly
+type CourseOverlap struct {
+	Topic          string
+	Lectures       []int
+	CitedLecture   int
+	CitedDocument  string // Empty when the clearest occurrence is in the transcript
+	CitedPage      int
+	CitedTimestamp string
+}
+
+// GenerateCourseOverview builds a study document synthesizing the lectures of a course, given in the order they
+// were taught. A global outline is drawn from every lecture, then the sections are built as those of a study
+// guide, citing the reference files of the lectures they come from. Material listed in overlaps is covered in a
+// single section citing its clearest occurrence
+func (generator *ToolGenerator) GenerateCourseOverview(
+	jobContext context.Context,
+	courseTitle string,
+	lectures []CourseLecture,
+	overlaps []CourseOverlap,
+	length string,
+	languageCode string,
+	options models.GenerationOptions,
+	updateProgress func(int, string, any, models.JobMetrics),
+) (string, string, models.JobMetrics, error) {
+	var totalMetrics models.JobMetrics
+	if len(lectures) == 0 {
+		return "", "", totalMetrics, fmt.Errorf("the course has no lectures")
+	}
+
+	transcript, materials := generator.courseSources(options, courseTitle, lectures, overlaps)
+
+	updateProgress(10, "Analyzing course structure...", models.BuildProgress{Phase: models.BuildPhaseAnalyzingStructure}, totalMetrics)
+	structure := options.ResumeOutline
+	if structure == "" {
+		analyzedStructure, metrics, err := generator.analyzeStructureWithPrompt(jobContext, prompts.PromptAnalyzeCourseStructure, transcript, materials, length, languageCode, options)
+		if err != nil {
+			return "", "", totalMetrics, fmt.Errorf("structure analysis failed: %w", err)
+		}
+		structure = analyzedStructure
+		totalMetrics.InputTokens += metrics.InputTokens
+		totalMetrics.OutputTokens += metrics.OutputTokens
+		totalMetrics.EstimatedCost += metrics.EstimatedCost
+		totalMetrics.EstimatedInputTokens += metrics.EstimatedInputTokens
+		generator.reportOutline(options, structure, courseTitle, metrics)
+	}
+
+	updateProgress(15, "Building course overview sections...", models.BuildProgress{Phase: models.BuildPhaseGeneratingSections}, totalMetrics)
+	content, title, generationMetrics, err := generator.generateSequentialStudyGuide(jobContext, models.Lecture{Title: courseTitle}, transcript, materials, structure, languageCode, options, updateProgress, totalMetrics)
+	if err != nil {
+		return "", "", totalMetrics, fmt.Errorf("sequential generation failed: %w", err)
+	}
+	totalMetrics.InputTokens += generationMetrics.InputTokens
+	totalMetrics.OutputTokens += generationMetrics.OutputTokens
+	totalMetrics.EstimatedCost += generationMetrics.EstimatedCost
+	totalMetrics.EstimatedInputTokens += generationMetrics.EstimatedInputTokens
+
+	return content, title, totalMetrics, nil
+}
+
+// courseSources lays out the lectures of a course as the transcript and reference materials of its prompts,
+// introduced as the material of a course overview along with the material they repeat
+func (generator *ToolGenerator) courseSources(options models.GenerationOptions, courseTitle string, lectures []CourseLecture, overlaps []CourseOverlap) (string, string) {
+	courseTranscript, materials := courseLecturesText(lectures)
+	if generator.promptManager != nil {
+		if prompt, err := generator.getPrompt(options, prompts.PromptCourseOverviewContext, map[string]string{
+			"lecture_count":     strconv.Itoa(len(lectures)),
+			"course_title":      courseTitle,
+			"lectures":          courseTranscript,
+			"repeated_material": courseOverlapsText(overlaps),
+		}); err == nil {
+			courseTranscript = prompt
+		}
+	}
+	return courseTranscript, materials
+}
+
+// courseLecturesText lays out the lectures of a course: each lecture under a numbered heading with the reference
+// files it may cite and its transcript, then the key pages of them all
+func courseLecturesText(lectures []CourseLecture) (string, string) {
+	transcriptBudget := maximumCourseTranscriptCharacters / max(len(lectures), 1)
+
+	var lecturesBuilder, materialsBuilder strings.Builder
+	for index, lecture := range lectures {
+		fmt.Fprintf(&lecturesBuilder, "# Lecture %d: %s\n\n", index+1, lecture.Title)
+		if len(lecture.DocumentNames) > 0 {
+			lecturesBuilder.WriteString("Reference files:")
+			for _, documentName := range lecture.DocumentNames {
+				lecturesBuilder.WriteString(" `" + documentName + "`")
+			}
+			lecturesBuilder.WriteString("\n\n")
+		}
+		transcript := strings.TrimSpace(lecture.Transcript)
+		if len(transcript) > transcriptBudget {
+			cut := transcriptBudget
+			for cut > 0 && !utf8.RuneStart(transcript[cut]) {
+				cut--
+			}
+			transcript = transcript[:cut] + " […]"
+		}
+		if transcript != "" {
+			lecturesBuilder.WriteString(transcript + "\n\n")
+		}
+
+		if lecture.ReferenceMaterials != "" {
+			materialsBuilder.WriteString(strings.TrimSpace(lecture.ReferenceMaterials) + "\n\n")
+		}
+	}
+	return lecturesBuilder.String(), strings.TrimSpace(materialsBuilder.String())
+}
+
+// courseOverlapsText lists the material repeated across the lectures of a course, each topic with the lectures
+// repeating it and the occurrence to cite, or nothing when no material is repeated
+func courseOverlapsText(overlaps []CourseOverlap) string {
+	if len(overlaps) == 0 {
+		return ""
+	}
+	var builder strings.Builder
+	builder.WriteString("# Material Repeated Across Lectures\n\n")
+	for _, overlap := range overlaps {
+		lectureNumbers := make([]string, len(overlap.Lectures))
+		for index, lectureNumber := range overlap.Lectures {
+			lectureNumbers[index] = strconv.Itoa(lectureNumber)
+		}
+		fmt.Fprintf(&builder, "- \"%s\", repeated in lectures %s: ", overlap.Topic, strings.Join(lectureNumbers, ", "))
+		if overlap.CitedDocument != "" {
+			fmt.Fprintf(&builder, "cite `%s` page %d\n", overlap.CitedDocument, overlap.CitedPage)
+		} else {
+			fmt.Fprintf(&builder, "explained most clearly in the transcript of lecture %d at %s\n", overlap.CitedLecture, overlap.CitedTimestamp)
+		}
+	}
+	return builder.String()
+}
@@ -0,0 +1,193 @@
+package tools
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"lectures/internal/llm"
+	"lectures/internal/models"
+	"lectures/internal/prompts"
+)
+
+// defaultMaximumFlashcardImages caps the image stage when image_generation.maximum_images_per_tool is unset
+const defaultMaximumFlashcardImages = 5
+
+// SetImageProvider enables the optional mnemonic image stage for flashcards
+func (generator *ToolGenerator) SetImageProvider(imageProvider llm.ImageProvider) {
+	generator.imageProvider = imageProvider
+}
+
+// ImageGenerationAvailable reports whether an image provider is configured
+func (generator *ToolGenerator) ImageGenerationAvailable() bool {
+	return generator.imageProvider != nil
+}
+
+// GenerateFlashcardImages asks the model which cards benefit from a visual mnemonic, generates an image for each
+// of them into toolDirectory/images and returns the flashcard content with the image paths filled in.
+// Failing images are skipped, so the returned content is always usable
+func (generator *ToolGenerator) GenerateFlashcardImages(jobContext context.Context, content string, toolDirectory string, options models.GenerationOptions) (string, models.JobMetrics, error) {
+	var metrics models.JobMetrics
+	if generator.imageProvider == nil {
+		return content, metrics, fmt.Errorf("image provider is not configured")
+	}
+
+	var flashcards []models.Flashcard
+	if err := json.Unmarshal([]byte(content), &flashcards); err != nil {
+		return content, metrics, fmt.Errorf("failed to parse flashcards: %w", err)
+	}
+
+	maximumImages := generator.configuration.ImageGeneration.MaximumImagesPerTool
+	if maximumImages <= 0 {
+		maximumImages = defaultMaximumFlashcardImages
+	}
+
+	var flashcardsBuilder strings.Builder
+	for index, flashcard := range flashcards {
+		fmt.Fprintf(&flashcardsBuilder, "%d. Front: %s\n   Back: %s\n", index+1, flashcard.Front, flashcard.Back)
+	}
+
+	var prompt string
+	if generator.promptManager != nil {
+		prompt, _ = generator.promptManager.GetPrompt(prompts.PromptSelectFlashcardMnemonics, map[string]string{
+			"maximum_images": fmt.Sprintf("%d", maximumImages),
+			"flashcards":     flashcardsBuilder.String(),
+		})
+	}
+
+	model := options.ModelGeneration
+	if model == "" {
+		model = generator.configuration.LLM.GetModelForTask("content_generation")
+	}
+
+	response, selectionMetrics, err := generator.callLLMWithModel(jobContext, prompt, model)
+	metrics.InputTokens += selectionMetrics.InputTokens
+	metrics.OutputTokens += selectionMetrics.OutputTokens
+	metrics.EstimatedCost += selectionMetrics.EstimatedCost
+	metrics.EstimatedInputTokens += selectionMetrics.EstimatedInputTokens
+	if err != nil {
+		return content, metrics, err
+	}
+
+	var result struct {
+		Selections []struct {
+			Card   int    `json:"card"`
+			Prompt string `json:"prompt"`
+		} `json:"selections"`
+	}
+	if err := generator.unmarshalJSONWithFallback(response, &result); err != nil {
+		return content, metrics, fmt.Errorf("failed to parse mnemonic selection: %w", err)
+	}
+
+	imagesDirectory := filepath.Join(toolDirectory, "images")
+	if err := os.MkdirAll(imagesDirectory, 0755); err != nil {
+		return content, metrics, fmt.Errorf("failed to create images directory: %w", err)
+	}
+
+	// Pick the cards first so the image limit holds, then generate their images concurrently
+	type mnemonicSelection struct {
+		card   int
+		prompt string
+	}
+	var selections []mnemonicSelection
+	selectedCards := make(map[int]bool)
+	for _, selection := range result.Selections {
+		if len(selections) >= maximumImages {
+			break
+		}
+		cardIndex := selection.Card - 1
+		if cardIndex < 0 || cardIndex >= len(flashcards) || flashcards[cardIndex].Image != "" || selectedCards[cardIndex] || strings.TrimSpace(selection.Prompt) == "" {
+			continue
+		}
+		selectedCards[cardIndex] = true
+		selections = append(selections, mnemonicSelection{card: selection.Card, prompt: selection.Prompt})
+	}
+
+	var waitGroup sync.WaitGroup
+	var resultMutex sync.Mutex
+	generatedCount := 0
+	for _, selection := range selections {
+		waitGroup.Add(1)
+		go func(selection mnemonicSelection) {
+			defer waitGroup.Done()
+
+			releaseCallSlot, err := generator.acquireCallSlot(jobContext)
+			if err != nil {
+				return
+			}
+			imageData, err := generator.imageProvider.GenerateImage(jobContext, &llm.ImageRequest{
+				Model:  generator.configuration.ImageGeneration.Model,
+				Prompt: selection.prompt,
+				Size:   generator.configuration.ImageGeneration.Size,
+			})
+			releaseCallSlot()
+			if err != nil {
+				if jobContext.Err() == nil {
+					slog.WarnContext(jobContext, "Failed to generate flashcard image", "card", selection.card, "error", err)
+				}
+				return
+			}
+
+			resultMutex.Lock()
+			defer resultMutex.Unlock()
+			metrics.EstimatedCost += generator.configuration.ImageGeneration.CostPerImage
+
+			relativePath := filepath.Join("images", fmt.Sprintf("card_%d.png", selection.card))
+			if err := os.WriteFile(filepath.Join(toolDirectory, relativePath), imageData, 0644); err != nil {
+				slog.WarnContext(jobContext, "Failed to store flashcard image", "card", selection.card, "error", err)
+				return
+			}
+
+			flashcards[selection.card-1].Image = filepath.ToSlash(relativePath)
+			generatedCount++
+		}(selection)
+	}
+	waitGroup.Wait()
+	if jobContext.Err() != nil {
+		return content, metrics, jobContext.Err()
+	}
+
+	slog.InfoContext(jobContext, "Flashcard images generated", "requested", len(result.Selections), "generated", generatedCount)
+
+	updatedContent, err := json.Marshal(flashcards)
+	if err != nil {
+		return content, metrics, err
+	}
+	return string(updatedContent), metrics, nil
+}
+
+// ResolveFlashcardImages rewrites the relative image paths stored in flashcard content to absolute paths
+// under toolDirectory, dropping references whose file no longer exists. An empty toolDirectory drops all images
+func ResolveFlashcardImages(content string, toolDirectory string) string {
+	var flashcards []models.Flashcard
+	if err := json.Unmarshal([]byte(content), &flashcards); err != nil {
+		return content
+	}
+
+	for index, flashcard := range flashcards {
+		if flashcard.Image == "" {
+			continue
+		}
+		if toolDirectory == "" {
+			flashcards[index].Image = ""
+			continue
+		}
+		// Cleaning against the root keeps stored paths from escaping the tool directory
+		imagePath := filepath.Join(toolDirectory, filepath.Clean("/"+flashcard.Image))
+		if _, err := os.Stat(imagePath); err != nil {
+			imagePath = ""
+		}
+		flashcards[index].Image = imagePath
+	}
+
+	resolvedContent, err := json.Marshal(flashcards)
+	if err != nil {
+		return content
+	}
+	return string(resolvedContent)
+}
@@ -0,0 +1,63 @@
+package tools
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"lectures/internal/models"
+	"lectures/internal/prompts"
+)
+
+// LectureRecapBulletCount is the number of bullets of a lecture recap
+const LectureRecapBulletCount = 5
+
+// GenerateLectureRecap summarizes what a lecture covered in LectureRecapBulletCount short bullets, with the cheap
+// polishing model unless options.ModelPolishing overrides it. Empty bullets are dropped and extra ones cut
+func (generator *ToolGenerator) GenerateLectureRecap(jobContext context.Context, title string, content string, languageCode string, options models.GenerationOptions) ([]string, models.JobMetrics, error) {
+	var prompt string
+	if generator.promptManager != nil {
+		languageRequirement, _ := generator.promptManager.GetPrompt(prompts.PromptLanguageRequirement, map[string]string{"language": languageCode, "language_code": languageCode})
+		prompt, _ = generator.promptManager.GetPrompt(prompts.PromptGenerateLectureRecap, map[string]string{
+			"bullet_count":         strconv.Itoa(LectureRecapBulletCount),
+			"language_requirement": languageRequirement,
+			"title":                title,
+			"content":              content,
+		})
+	}
+
+	model := options.ModelPolishing
+	if model == "" {
+		model = generator.configuration.LLM.GetModelForTask("content_polishing")
+	}
+
+	response, metrics, err := generator.callLLMWithModel(jobContext, prompt, model)
+	if err != nil {
+		return nil, metrics, err
+	}
+
+	var result struct {
+		Bullets []string `json:"bullets"`
+	}
+	if err := generator.unmarshalJSONWithFallback(response, &result); err != nil {
+		return nil, metrics, fmt.Errorf("failed to parse lecture recap: %w", err)
+	}
+
+	bullets := make([]string, 0, LectureRecapBulletCount)
+	for _, bullet := range result.Bullets {
+		bullet = strings.TrimSpace(strings.TrimLeft(strings.TrimSpace(bullet), "-*•"))
+		if bullet == "" {
+			continue
+		}
+		bullets = append(bullets, bullet)
+		if len(bullets) == LectureRecapBulletCount {
+			break
+		}
+	}
+	if len(bullets) == 0 {
+		return nil, metrics, fmt.Errorf("the model returned an empty recap")
+	}
+
+	return bullets, metrics, nil
+}
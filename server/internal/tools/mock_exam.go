@@ -0,0 +1,178 @@
+package tools
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strconv"
+
+	"lectures/internal/models"
+	"lectures/internal/prompts"
+)
+
+const mockExamSchemaDescription = `A JSON object with a "questions" array. Every question has a "type" ("multiple_choice", "short_answer" or "problem"), a "difficulty" ("easy", "medium" or "hard"), ` +
+	`a positive integer "points", the number of its "lecture", and non-empty "question", "correct_answer" and "explanation" strings. ` +
+	`A "multiple_choice" question also has an "options" array of 4 distinct strings, its "correct_answer" being the exact text of one of them.`
+
+// GenerateMockExam writes a timed mock exam drawn from the lectures of a course, given in the order they were
+// taught, shaped by the settings: the questions of each type and difficulty, their points and the duration. The
+// answers are kept with the questions, which exports print as a separate answer key
+func (generator *ToolGenerator) GenerateMockExam(
+	jobContext context.Context,
+	courseTitle string,
+	lectures []CourseLecture,
+	settings models.MockExamSettings,
+	languageCode string,
+	options models.GenerationOptions,
+	updateProgress func(int, string, any, models.JobMetrics),
+) (string, string, models.JobMetrics, error) {
+	if generator.llmProvider == nil {
+		return "", courseTitle, models.JobMetrics{}, fmt.Errorf("llm provider is nil")
+	}
+	if len(lectures) == 0 {
+		return "", courseTitle, models.JobMetrics{}, fmt.Errorf("the course has no lectures")
+	}
+
+	lectureTitles := make([]string, 0, len(lectures))
+	for _, lecture := range lectures {
+		lectureTitles = append(lectureTitles, lecture.Title)
+	}
+
+	var prompt string
+	if generator.promptManager != nil {
+		lecturesText, materials := courseLecturesText(lectures)
+		latexInstructions, _ := generator.promptManager.GetPrompt(prompts.PromptLatexInstructions, nil)
+		difficultyCounts := settings.DifficultyCounts()
+		prompt, _ = generator.getPrompt(options, prompts.PromptGenerateMockExam, map[string]string{
+			"language_requirement": generator.languageRequirement(languageCode, options),
+			"course_title":         courseTitle,
+			"lecture_count":        strconv.Itoa(len(lectures)),
+			"lectures":             lecturesText,
+			"reference_materials":  materials,
+			"latex_instructions":   latexInstructions,
+			"multiple_choice":      strconv.Itoa(settings.MultipleChoice),
+			"short_answer":         strconv.Itoa(settings.ShortAnswer),
+			"problem":              strconv.Itoa(settings.Problem),
+			"easy":                 strconv.Itoa(difficultyCounts["easy"]),
+			"medium":               strconv.Itoa(difficultyCounts["medium"]),
+			"hard":                 strconv.Itoa(difficultyCounts["hard"]),
+			"total_points":         strconv.Itoa(settings.TotalPoints),
+			"duration_minutes":     strconv.Itoa(settings.DurationMinutes),
+		})
+	}
+
+	model := options.ModelGeneration
+	if model == "" {
+		model = generator.configuration.LLM.GetModelForTask("content_generation")
+	}
+
+	content, metrics, err := generator.generateValidatedJSON(jobContext, prompt, model, "mock exam", mockExamSchemaDescription, options, reportGenerationAttempt(updateProgress, "mock exam"), func(response string) (any, []string) {
+		return ValidateMockExam(response, settings, lectureTitles)
+	})
+	if err != nil {
+		return "", "", metrics, err
+	}
+	return content, courseTitle, metrics, nil
+}
+
+// ValidateMockExam parses generated mock exam JSON into normalized questions, returning every problem found: the
+// questions themselves, then how they match the settings. The lecture of each question, given by its number, is
+// replaced by its title
+func ValidateMockExam(content string, settings models.MockExamSettings, lectureTitles []string) (models.MockExam, []string) {
+	mockExam := models.MockExam{DurationMinutes: settings.DurationMinutes, TotalPoints: settings.TotalPoints, Questions: []models.MockExamQuestion{}}
+	object, err := parseJSONObject(content)
+	if err != nil {
+		return mockExam, []string{err.Error()}
+	}
+	rawQuestions, _ := object["questions"].([]any)
+	items, err := objectsFromArray(rawQuestions)
+	if err != nil {
+		return mockExam, []string{"\"questions\": " + err.Error()}
+	}
+
+	var issues []string
+	typeCounts := make(map[string]int)
+	difficultyCounts := make(map[string]int)
+	examinedLectures := make(map[int]bool)
+	totalPoints := 0
+	for index, item := range items {
+		questionNumber := index + 1
+		questionIssueCount := len(issues)
+
+		question := models.MockExamQuestion{
+			Type:        normalizeQuizQuestionType(stringField(item, "type")),
+			Difficulty:  normalizeQuizQuestionType(stringField(item, "difficulty")),
+			Question:    stringField(item, "question"),
+			Explanation: stringField(item, "explanation"),
+		}
+		if question.Question == "" {
+			issues = append(issues, fmt.Sprintf("question %d: \"question\" is missing or empty", questionNumber))
+		}
+		if !slices.Contains(models.MockExamDifficulties, question.Difficulty) {
+			issues = append(issues, fmt.Sprintf("question %d: \"difficulty\" %q is not easy, medium or hard", questionNumber, question.Difficulty))
+		}
+		points, isNumber := numberField(item, "points")
+		if !isNumber || points < 1 || points != float64(int(points)) {
+			issues = append(issues, fmt.Sprintf("question %d: \"points\" must be a positive integer", questionNumber))
+		}
+		question.Points = int(points)
+		lectureNumber, isNumber := numberField(item, "lecture")
+		if !isNumber || lectureNumber < 1 || int(lectureNumber) > len(lectureTitles) || lectureNumber != float64(int(lectureNumber)) {
+			issues = append(issues, fmt.Sprintf("question %d: \"lecture\" must be the number of a lecture, from 1 to %d", questionNumber, len(lectureTitles)))
+		} else {
+			question.Lecture = lectureTitles[int(lectureNumber)-1]
+		}
+
+		switch question.Type {
+		case models.MockExamMultipleChoice:
+			quizQuestion := models.QuizQuestion{}
+			for _, issue := range validateMultipleChoiceQuestion(item, &quizQuestion) {
+				issues = append(issues, fmt.Sprintf("question %d: %s", questionNumber, issue))
+			}
+			question.Options, question.CorrectAnswer = quizQuestion.Options, quizQuestion.CorrectAnswer
+		case models.MockExamShortAnswer, models.MockExamProblem:
+			question.CorrectAnswer = stringField(item, "correct_answer")
+			if question.CorrectAnswer == "" {
+				issues = append(issues, fmt.Sprintf("question %d: \"correct_answer\" must hold the model answer or worked solution", questionNumber))
+			}
+		default:
+			issues = append(issues, fmt.Sprintf("question %d: \"type\" %q is not multiple_choice, short_answer or problem", questionNumber, question.Type))
+		}
+
+		if len(issues) > questionIssueCount {
+			continue
+		}
+		typeCounts[question.Type]++
+		difficultyCounts[question.Difficulty]++
+		examinedLectures[int(lectureNumber)] = true
+		totalPoints += question.Points
+		mockExam.Questions = append(mockExam.Questions, question)
+	}
+	if len(issues) > 0 {
+		return mockExam, issues
+	}
+
+	for _, questionType := range []string{models.MockExamMultipleChoice, models.MockExamShortAnswer, models.MockExamProblem} {
+		if expected := settings.TypeCounts()[questionType]; typeCounts[questionType] != expected {
+			issues = append(issues, fmt.Sprintf("the exam has %d %s questions, but needs exactly %d", typeCounts[questionType], questionType, expected))
+		}
+	}
+	// The difficulty of a question is a judgment call, so one question more or less of each is accepted
+	expectedDifficulties := settings.DifficultyCounts()
+	for _, difficulty := range models.MockExamDifficulties {
+		if expected := expectedDifficulties[difficulty]; difficultyCounts[difficulty] < expected-1 || difficultyCounts[difficulty] > expected+1 {
+			issues = append(issues, fmt.Sprintf("the exam has %d %s questions, but needs %d", difficultyCounts[difficulty], difficulty, expected))
+		}
+	}
+	if totalPoints != settings.TotalPoints {
+		issues = append(issues, fmt.Sprintf("the points add up to %d, but must add up to exactly %d", totalPoints, settings.TotalPoints))
+	}
+	if len(mockExam.Questions) >= len(lectureTitles) {
+		for lectureIndex, lectureTitle := range lectureTitles {
+			if !examinedLectures[lectureIndex+1] {
+				issues = append(issues, fmt.Sprintf("no question is drawn from lecture %d (%s)", lectureIndex+1, lectureTitle))
+			}
+		}
+	}
+	return mockExam, issues
+}
@@ -0,0 +1,167 @@
+package tools
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"strings"
+
+	"lectures/internal/models"
+	"lectures/internal/prompts"
+)
+
+// GradeQuizAnswer grades an answer to a question that needs no model: multiple choice and numeric questions score
+// 1 or 0, matching questions the share of correct matches, and ordering questions the share of item pairs placed
+// in the right relative order. Free response questions are graded by GradeQuizAttempt
+func GradeQuizAnswer(question models.QuizQuestion, answer models.QuizAnswer) models.QuizQuestionResult {
+	result := models.QuizQuestionResult{Type: question.QuestionType()}
+
+	switch result.Type {
+	case models.QuizQuestionMultipleChoice:
+		if answer.Choice != "" && normalizeComparableText(answer.Choice) == normalizeComparableText(question.CorrectAnswer) {
+			result.Score = 1
+		}
+	case models.QuizQuestionMatching:
+		chosenMatches := make(map[string]string, len(answer.Matches))
+		for left, right := range answer.Matches {
+			chosenMatches[normalizeComparableText(left)] = normalizeComparableText(right)
+		}
+		correctMatches := 0
+		for _, pair := range question.Pairs {
+			if chosenMatches[normalizeComparableText(pair.Left)] == normalizeComparableText(pair.Right) {
+				correctMatches++
+			}
+		}
+		if len(question.Pairs) > 0 {
+			result.Score = float64(correctMatches) / float64(len(question.Pairs))
+		}
+	case models.QuizQuestionOrdering:
+		result.Score = orderingScore(question.Items, answer.Order)
+	case models.QuizQuestionNumeric:
+		if answer.Number != nil && question.Answer != nil {
+			// A relative epsilon keeps answers such as 0.1 + 0.2 from failing an exact comparison
+			allowedDifference := question.Tolerance + 1e-9*math.Max(1, math.Abs(*question.Answer))
+			if math.Abs(*answer.Number-*question.Answer) <= allowedDifference {
+				result.Score = 1
+			}
+		}
+	}
+
+	result.Correct = result.Score == 1
+	return result
+}
+
+// orderingScore returns the share of item pairs that the given order places like the correct one; items missing
+// from the given order count as misplaced
+func orderingScore(correctOrder []string, givenOrder []string) float64 {
+	if len(correctOrder) < 2 {
+		return 0
+	}
+
+	givenPositions := make(map[string]int, len(givenOrder))
+	for position, item := range givenOrder {
+		givenPositions[normalizeComparableText(item)] = position
+	}
+
+	orderedPairs, totalPairs := 0, 0
+	for first := range correctOrder {
+		for second := first + 1; second < len(correctOrder); second++ {
+			totalPairs++
+			firstPosition, firstFound := givenPositions[normalizeComparableText(correctOrder[first])]
+			secondPosition, secondFound := givenPositions[normalizeComparableText(correctOrder[second])]
+			if firstFound && secondFound && firstPosition < secondPosition {
+				orderedPairs++
+			}
+		}
+	}
+	return float64(orderedPairs) / float64(totalPairs)
+}
+
+// GradeQuizAttempt grades the answers to a quiz, given in question order; missing answers score 0. Free responses
+// are graded against their rubric by the content generation model, which also explains the grade
+func (generator *ToolGenerator) GradeQuizAttempt(jobContext context.Context, questions []models.QuizQuestion, answers []models.QuizAnswer, languageCode string) ([]models.QuizQuestionResult, models.JobMetrics, error) {
+	var totalMetrics models.JobMetrics
+	results := make([]models.QuizQuestionResult, 0, len(questions))
+
+	for index, question := range questions {
+		var answer models.QuizAnswer
+		if index < len(answers) {
+			answer = answers[index]
+		}
+
+		if question.QuestionType() != models.QuizQuestionFreeResponse {
+			results = append(results, GradeQuizAnswer(question, answer))
+			continue
+		}
+
+		result, metrics, err := generator.gradeFreeResponse(jobContext, question, answer.Text, languageCode)
+		totalMetrics.InputTokens += metrics.InputTokens
+		totalMetrics.OutputTokens += metrics.OutputTokens
+		totalMetrics.EstimatedCost += metrics.EstimatedCost
+		totalMetrics.EstimatedInputTokens += metrics.EstimatedInputTokens
+		if err != nil {
+			return nil, totalMetrics, fmt.Errorf("failed to grade question %d: %w", index+1, err)
+		}
+		results = append(results, result)
+	}
+
+	return results, totalMetrics, nil
+}
+
+// gradeFreeResponse asks the model how many points of each rubric criterion the response earns. The score is the
+// share of the rubric's points awarded, with each criterion capped at its points
+func (generator *ToolGenerator) gradeFreeResponse(jobContext context.Context, question models.QuizQuestion, response string, languageCode string) (models.QuizQuestionResult, models.JobMetrics, error) {
+	result := models.QuizQuestionResult{Type: models.QuizQuestionFreeResponse}
+	response = strings.TrimSpace(response)
+	if response == "" || len(question.Rubric) == 0 {
+		return result, models.JobMetrics{}, nil
+	}
+	if generator.llmProvider == nil {
+		return result, models.JobMetrics{}, fmt.Errorf("llm provider is nil")
+	}
+
+	var rubricBuilder strings.Builder
+	totalPoints := 0
+	for index, criterion := range question.Rubric {
+		fmt.Fprintf(&rubricBuilder, "%d. %s (%d points)\n", index+1, criterion.Criterion, criterion.Points)
+		totalPoints += criterion.Points
+	}
+
+	var prompt string
+	if generator.promptManager != nil {
+		languageRequirement, _ := generator.promptManager.GetPrompt(prompts.PromptLanguageRequirement, map[string]string{"language": languageCode, "language_code": languageCode})
+		prompt, _ = generator.promptManager.GetPrompt(prompts.PromptGradeFreeResponse, map[string]string{
+			"language_requirement": languageRequirement,
+			"question":             question.Question,
+			"model_answer":         question.CorrectAnswer,
+			"rubric":               strings.TrimSpace(rubricBuilder.String()),
+			"response":             response,
+		})
+	}
+
+	model := generator.configuration.LLM.GetModelForTask("content_generation")
+	modelResponse, metrics, err := generator.callLLMWithModel(jobContext, prompt, model)
+	if err != nil {
+		return result, metrics, err
+	}
+
+	var grade struct {
+		AwardedPoints []float64 `json:"awarded_points"`
+		Feedback      string    `json:"feedback"`
+	}
+	if err := generator.unmarshalJSONWithFallback(modelResponse, &grade); err != nil {
+		return result, metrics, fmt.Errorf("failed to parse grade: %w", err)
+	}
+	if len(grade.AwardedPoints) != len(question.Rubric) {
+		return result, metrics, fmt.Errorf("the grade scores %d criteria, but the rubric has %d", len(grade.AwardedPoints), len(question.Rubric))
+	}
+
+	awardedPoints := 0.0
+	for index, points := range grade.AwardedPoints {
+		awardedPoints += math.Min(math.Max(points, 0), float64(question.Rubric[index].Points))
+	}
+	result.Score = awardedPoints / float64(totalPoints)
+	result.Correct = result.Score == 1
+	result.Feedback = strings.TrimSpace(grade.Feedback)
+	return result, metrics, nil
+}
@@ -0,0 +1,86 @@
+package tools
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"lectures/internal/models"
+	"lectures/internal/prompts"
+)
+
+// MaximumSyllabusLectures bounds the lectures scaffolded from a syllabus, more than any semester schedules
+const MaximumSyllabusLectures = 150
+
+// Syllabus is the course a syllabus describes, as read by ExtractSyllabus
+type Syllabus struct {
+	Title       string            `json:"title"`
+	Description string            `json:"description"`
+	Lectures    []SyllabusLecture `json:"lectures"`
+}
+
+// SyllabusLecture is a class session scheduled by a syllabus
+type SyllabusLecture struct {
+	Title       string     `json:"title"`
+	Description string     `json:"description,omitempty"`
+	Date        *time.Time `json:"date,omitempty"` // Nil when the syllabus does not date the session
+}
+
+// ExtractSyllabus reads the course title, description and scheduled lectures from the text of a syllabus, with
+// the outline model unless options.ModelStructure overrides it. Lectures without a title are dropped, dates
+// that are not YYYY-MM-DD are left out, and at most MaximumSyllabusLectures lectures are kept
+func (generator *ToolGenerator) ExtractSyllabus(jobContext context.Context, content string, languageCode string, options models.GenerationOptions) (Syllabus, models.JobMetrics, error) {
+	var prompt string
+	if generator.promptManager != nil {
+		languageRequirement, _ := generator.promptManager.GetPrompt(prompts.PromptLanguageRequirement, map[string]string{"language": languageCode, "language_code": languageCode})
+		prompt, _ = generator.promptManager.GetPrompt(prompts.PromptExtractSyllabus, map[string]string{
+			"language_requirement": languageRequirement,
+			"content":              content,
+		})
+	}
+
+	model := options.ModelStructure
+	if model == "" {
+		model = generator.configuration.LLM.GetModelForTask("outline_creation")
+	}
+
+	response, metrics, err := generator.callLLMWithModel(jobContext, prompt, model)
+	if err != nil {
+		return Syllabus{}, metrics, err
+	}
+
+	var result struct {
+		Title       string `json:"title"`
+		Description string `json:"description"`
+		Lectures    []struct {
+			Title       string `json:"title"`
+			Description string `json:"description"`
+			Date        string `json:"date"`
+		} `json:"lectures"`
+	}
+	if err := generator.unmarshalJSONWithFallback(response, &result); err != nil {
+		return Syllabus{}, metrics, fmt.Errorf("failed to parse syllabus: %w", err)
+	}
+
+	syllabus := Syllabus{
+		Title:       strings.TrimSpace(result.Title),
+		Description: strings.TrimSpace(result.Description),
+		Lectures:    []SyllabusLecture{},
+	}
+	for _, lecture := range result.Lectures {
+		title := strings.TrimSpace(lecture.Title)
+		if title == "" {
+			continue
+		}
+		syllabusLecture := SyllabusLecture{Title: title, Description: strings.TrimSpace(lecture.Description)}
+		if date, err := time.Parse("2006-01-02", strings.TrimSpace(lecture.Date)); err == nil {
+			syllabusLecture.Date = &date
+		}
+		syllabus.Lectures = append(syllabus.Lectures, syllabusLecture)
+		if len(syllabus.Lectures) == MaximumSyllabusLectures {
+			break
+		}
+	}
+	return syllabus, metrics, nil
+}
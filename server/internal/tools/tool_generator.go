@@ -19,18 +19,84 @@ import (
 	"lectures/internal/prompts"
 )
 
+// DefaultMaximumConcurrentCalls is used when llm.maximum_concurrent_calls is not set
+const DefaultMaximumConcurrentCalls = 3
+
 type ToolGenerator struct {
 	configuration *configuration.Configuration
 	llmProvider   llm.Provider
 	promptManager *prompts.Manager
+	imageProvider llm.ImageProvider
+	callSlots     chan struct{} // Bounds the model calls in flight across every job using this generator
 }
 
 func NewToolGenerator(configuration *configuration.Configuration, llmProvider llm.Provider, promptManager *prompts.Manager) *ToolGenerator {
+	maximumConcurrentCalls := configuration.LLM.MaximumConcurrentCalls
+	if maximumConcurrentCalls <= 0 {
+		maximumConcurrentCalls = DefaultMaximumConcurrentCalls
+	}
 	return &ToolGenerator{
 		configuration: configuration,
 		llmProvider:   llmProvider,
 		promptManager: promptManager,
+		callSlots:     make(chan struct{}, maximumConcurrentCalls),
+	}
+}
+
+// acquireCallSlot waits until fewer than llm.maximum_concurrent_calls calls are in flight and returns the
+// function releasing the slot. Slots are held for a single call only, so nested batches cannot deadlock
+func (generator *ToolGenerator) acquireCallSlot(jobContext context.Context) (func(), error) {
+	select {
+	case generator.callSlots <- struct{}{}:
+		return func() { <-generator.callSlots }, nil
+	case <-jobContext.Done():
+		return nil, jobContext.Err()
+	}
+}
+
+// maximumAttempts returns how many times a step is attempted: the options' maximum retries, or
+// safety.maximum_retries when they leave it unset. A maximum of 0 still makes the first attempt
+func (generator *ToolGenerator) maximumAttempts(options models.GenerationOptions) int {
+	if options.MaximumRetries != nil {
+		return max(*options.MaximumRetries, 1)
+	}
+	if generator.configuration.Safety.MaximumRetries > 0 {
+		return generator.configuration.Safety.MaximumRetries
+	}
+	return 3
+}
+
+// PrepareToolModels runs the preflight check and warmup for every distinct model the given tool type is about to use
+func (generator *ToolGenerator) PrepareToolModels(jobContext context.Context, toolType string, options models.GenerationOptions, onLoading func(model string)) error {
+	modelForTask := func(override string, task string) string {
+		if override != "" {
+			return override
+		}
+		return generator.configuration.LLM.GetModelForTask(task)
+	}
+
+	modelNames := []string{modelForTask(options.ModelGeneration, "content_generation")}
+	if models.ToolCitesSources(toolType) {
+		modelNames = append(modelNames,
+			modelForTask(options.ModelStructure, "outline_creation"),
+			modelForTask(options.ModelAdherence, "content_verification"),
+			modelForTask(options.ModelPolishing, "content_polishing"))
+		if options.EnableDocumentsMatching {
+			modelNames = append(modelNames, modelForTask(options.ModelDocumentsMatching, "documents_matching"))
+		}
+	}
+
+	preparedModels := make(map[string]bool)
+	for _, modelName := range modelNames {
+		if modelName == "" || preparedModels[modelName] {
+			continue
+		}
+		preparedModels[modelName] = true
+		if err := llm.PrepareModel(jobContext, generator.llmProvider, modelName, onLoading); err != nil {
+			return err
+		}
 	}
+	return nil
 }
 
 // GenerateStudyGuide implements the self-healing sequential generation pipeline
@@ -47,7 +113,7 @@ func (generator *ToolGenerator) GenerateStudyGuide(
 	var totalMetrics models.JobMetrics
 
 	// PHASE 2: Documents Matching
-	updateProgress(5, "Matching relevant reference materials...", nil, totalMetrics)
+	updateProgress(5, "Matching relevant reference materials...", models.BuildProgress{Phase: models.BuildPhaseMatchingDocuments}, totalMetrics)
 	relevantMaterials := referenceFilesContent
 	if options.EnableDocumentsMatching && referenceFilesContent != "" {
 		materials, metrics, err := generator.matchRelevantDocuments(jobContext, transcript, referenceFilesContent, options)
@@ -56,25 +122,33 @@ func (generator *ToolGenerator) GenerateStudyGuide(
 			totalMetrics.InputTokens += metrics.InputTokens
 			totalMetrics.OutputTokens += metrics.OutputTokens
 			totalMetrics.EstimatedCost += metrics.EstimatedCost
+			totalMetrics.EstimatedInputTokens += metrics.EstimatedInputTokens
 		} else {
-			slog.Warn("Documents matching failed, falling back to full content", "error", err)
+			slog.WarnContext(jobContext, "Documents matching failed, falling back to full content", "error", err)
 		}
 	}
 
 	// PHASE 3: Sequential Generation
-	updateProgress(10, "Analyzing lecture structure...", nil, totalMetrics)
+	updateProgress(10, "Analyzing lecture structure...", models.BuildProgress{Phase: models.BuildPhaseAnalyzingStructure}, totalMetrics)
 
-	// 3.1 Analyze Structure with Retries
-	structure, metrics, err := generator.analyzeStructureWithRetries(jobContext, transcript, relevantMaterials, length, languageCode, options)
-	if err != nil {
-		return "", "", fmt.Errorf("structure analysis failed: %w", err)
+	// 3.1 Analyze Structure with Retries, unless an interrupted generation left its outline
+	structure := options.ResumeOutline
+	if structure == "" {
+		analyzedStructure, metrics, err := generator.analyzeStructureWithRetries(jobContext, transcript, relevantMaterials, length, languageCode, options)
+		if err != nil {
+			return "", "", fmt.Errorf("structure analysis failed: %w", err)
+		}
+		structure = analyzedStructure
+		totalMetrics.InputTokens += metrics.InputTokens
+		totalMetrics.OutputTokens += metrics.OutputTokens
+		totalMetrics.EstimatedCost += metrics.EstimatedCost
+		totalMetrics.EstimatedInputTokens += metrics.EstimatedInputTokens
+
+		generator.reportOutline(options, structure, lecture.Title, metrics)
 	}
-	totalMetrics.InputTokens += metrics.InputTokens
-	totalMetrics.OutputTokens += metrics.OutputTokens
-	totalMetrics.EstimatedCost += metrics.EstimatedCost
 
 	// 3.2 Sequential Building
-	updateProgress(15, "Building study guide sections...", nil, totalMetrics)
+	updateProgress(15, "Building study guide sections...", models.BuildProgress{Phase: models.BuildPhaseGeneratingSections}, totalMetrics)
 	finalMarkdown, finalTitle, genMetrics, err := generator.generateSequentialStudyGuide(jobContext, lecture, transcript, relevantMaterials, structure, languageCode, options, updateProgress, totalMetrics)
 	if err != nil {
 		return "", "", fmt.Errorf("sequential generation failed: %w", err)
@@ -82,8 +156,9 @@ func (generator *ToolGenerator) GenerateStudyGuide(
 	totalMetrics.InputTokens += genMetrics.InputTokens
 	totalMetrics.OutputTokens += genMetrics.OutputTokens
 	totalMetrics.EstimatedCost += genMetrics.EstimatedCost
+	totalMetrics.EstimatedInputTokens += genMetrics.EstimatedInputTokens
 
-	updateProgress(100, "Generation complete.", nil, totalMetrics)
+	updateProgress(100, "Generation complete.", models.BuildProgress{Phase: models.BuildPhaseGeneratingSections}, totalMetrics)
 	return finalMarkdown, finalTitle, nil
 }
 
@@ -105,13 +180,7 @@ func (generator *ToolGenerator) matchRelevantDocuments(jobContext context.Contex
 		model = generator.configuration.LLM.GetModelForTask("documents_matching")
 	}
 
-	maximumRetries := options.MaximumRetries
-	if maximumRetries <= 0 {
-		maximumRetries = generator.configuration.Safety.MaximumRetries
-		if maximumRetries <= 0 {
-			maximumRetries = 3
-		}
-	}
+	maximumRetries := generator.maximumAttempts(options)
 
 	for attemptIndex := 0; attemptIndex < maximumRetries; attemptIndex++ {
 		waitGroup.Add(1)
@@ -132,6 +201,7 @@ func (generator *ToolGenerator) matchRelevantDocuments(jobContext context.Contex
 			allMetrics.InputTokens += stepMetrics.InputTokens
 			allMetrics.OutputTokens += stepMetrics.OutputTokens
 			allMetrics.EstimatedCost += stepMetrics.EstimatedCost
+			allMetrics.EstimatedInputTokens += stepMetrics.EstimatedInputTokens
 
 			if err == nil {
 				var result struct {
@@ -157,6 +227,12 @@ func (generator *ToolGenerator) matchRelevantDocuments(jobContext context.Contex
 }
 
 func (generator *ToolGenerator) analyzeStructureWithRetries(jobContext context.Context, transcript, materials, length, language string, options models.GenerationOptions) (string, models.JobMetrics, error) {
+	return generator.analyzeStructureWithPrompt(jobContext, prompts.PromptAnalyzeLectureStructure, transcript, materials, length, language, options)
+}
+
+// analyzeStructureWithPrompt outlines a study document with the given structure prompt, retrying until the
+// outline has as many sections as the length asks for
+func (generator *ToolGenerator) analyzeStructureWithPrompt(jobContext context.Context, promptPath string, transcript, materials, length, language string, options models.GenerationOptions) (string, models.JobMetrics, error) {
 	if generator.llmProvider == nil {
 		return "", models.JobMetrics{}, fmt.Errorf("llm provider is nil")
 	}
@@ -188,7 +264,7 @@ func (generator *ToolGenerator) analyzeStructureWithRetries(jobContext context.C
 		}
 		exampleTemplate, _ := generator.promptManager.GetPrompt(exampleTemplatePath, nil)
 
-		prompt, _ = generator.promptManager.GetPrompt(prompts.PromptAnalyzeLectureStructure, map[string]string{
+		prompt, _ = generator.getPrompt(options, promptPath, map[string]string{
 			"language_requirement":    fmt.Sprintf("Use language code %s", language),
 			"minimum_section_count":   strconv.Itoa(sectionCounts.minimum),
 			"maximum_section_count":   strconv.Itoa(sectionCounts.maximum),
@@ -205,15 +281,9 @@ func (generator *ToolGenerator) analyzeStructureWithRetries(jobContext context.C
 		model = generator.configuration.LLM.GetModelForTask("outline_creation")
 	}
 
-	maximumRetries := options.MaximumRetries
-	if maximumRetries <= 0 {
-		maximumRetries = generator.configuration.Safety.MaximumRetries
-		if maximumRetries <= 0 {
-			maximumRetries = 3
-		}
-	}
+	maximumRetries := generator.maximumAttempts(options)
 
-	slog.Info("Starting structure analysis", "model", model, "maximum_retries", maximumRetries)
+	slog.InfoContext(jobContext, "Starting structure analysis", "model", model, "maximum_retries", maximumRetries)
 
 	for attempt := 1; attempt <= maximumRetries; attempt++ {
 		slog.Debug("Structure analysis attempt", "attempt", attempt, "of", maximumRetries)
@@ -222,6 +292,7 @@ func (generator *ToolGenerator) analyzeStructureWithRetries(jobContext context.C
 		metrics.InputTokens += stepMetrics.InputTokens
 		metrics.OutputTokens += stepMetrics.OutputTokens
 		metrics.EstimatedCost += stepMetrics.EstimatedCost
+		metrics.EstimatedInputTokens += stepMetrics.EstimatedInputTokens
 
 		slog.Debug("LLM response received",
 			"attempt", attempt,
@@ -230,7 +301,7 @@ func (generator *ToolGenerator) analyzeStructureWithRetries(jobContext context.C
 			"cost", stepMetrics.EstimatedCost)
 
 		if err != nil {
-			slog.Error("LLM call failed", "attempt", attempt, "error", err)
+			slog.ErrorContext(jobContext, "LLM call failed", "attempt", attempt, "error", err)
 			if attempt == maximumRetries {
 				return "", metrics, err
 			}
@@ -239,14 +310,14 @@ func (generator *ToolGenerator) analyzeStructureWithRetries(jobContext context.C
 		}
 
 		sections := generator.parseStructure(response)
-		slog.Info("Structure parsed",
+		slog.InfoContext(jobContext, "Structure parsed",
 			"attempt", attempt,
 			"sections_found", len(sections),
 			"minimum_required_sections", sectionCounts.minimum,
 			"maximum_required_sections", sectionCounts.maximum)
 
 		if len(sections) >= sectionCounts.minimum && len(sections) <= sectionCounts.maximum {
-			slog.Info("Structure validation passed", "sections", len(sections))
+			slog.InfoContext(jobContext, "Structure validation passed", "sections", len(sections))
 
 			// Clean the title before returning
 			title := generator.parseTitle(response)
@@ -257,6 +328,7 @@ func (generator *ToolGenerator) analyzeStructureWithRetries(jobContext context.C
 				metrics.InputTokens += titleMetrics.InputTokens
 				metrics.OutputTokens += titleMetrics.OutputTokens
 				metrics.EstimatedCost += titleMetrics.EstimatedCost
+				metrics.EstimatedInputTokens += titleMetrics.EstimatedInputTokens
 
 				slog.Debug("Title cleaned",
 					"original", title,
@@ -268,10 +340,10 @@ func (generator *ToolGenerator) analyzeStructureWithRetries(jobContext context.C
 					response = strings.Replace(response, "# "+title, "# "+cleanedTitle, 1)
 				}
 			} else {
-				slog.Warn("Title cleaning failed", "error", err)
+				slog.WarnContext(jobContext, "Title cleaning failed", "error", err)
 			}
 
-			slog.Info("Structure analysis complete",
+			slog.InfoContext(jobContext, "Structure analysis complete",
 				"final_sections", len(sections),
 				"total_cost", metrics.EstimatedCost)
 			return response, metrics, nil
@@ -282,7 +354,7 @@ func (generator *ToolGenerator) analyzeStructureWithRetries(jobContext context.C
 		if len(preview) > 500 {
 			// preview = preview[:500] + "..."
 		}
-		slog.Warn("Structure validation failed, retrying...",
+		slog.WarnContext(jobContext, "Structure validation failed, retrying...",
 			"count", len(sections),
 			"attempt", attempt,
 			"minimum_expected_sections", sectionCounts.minimum,
@@ -310,11 +382,8 @@ func (generator *ToolGenerator) generateSequentialStudyGuide(
 
 	var initialContext string
 	if generator.promptManager != nil {
-		initialContextTemplate, _ := generator.promptManager.GetPrompt(prompts.PromptStudyGuideInitialContext, nil)
-		languageRequirement, _ := generator.promptManager.GetPrompt(prompts.PromptLanguageRequirement, map[string]string{"language": language, "language_code": language})
-
-		initialContext = generator.replacePromptVariables(initialContextTemplate, map[string]string{
-			"language_requirement": languageRequirement,
+		initialContext, _ = generator.getPrompt(options, prompts.PromptStudyGuideInitialContext, map[string]string{
+			"language_requirement": generator.languageRequirement(language, options),
 			"transcript":           transcript,
 			"reference_materials":  materials,
 			"structure_outline":    structure,
@@ -327,18 +396,12 @@ func (generator *ToolGenerator) generateSequentialStudyGuide(
 	rootNode := &markdown.Node{Type: markdown.NodeDocument}
 	rootNode.Children = append(rootNode.Children, &markdown.Node{Type: markdown.NodeHeading, Level: 1, Content: title})
 
-	threshold := options.AdherenceThreshold
-	if threshold <= 0 {
-		threshold = 70
+	threshold := 70
+	if options.AdherenceThreshold != nil {
+		threshold = *options.AdherenceThreshold
 	}
 
-	maximumRetries := options.MaximumRetries
-	if maximumRetries <= 0 {
-		maximumRetries = generator.configuration.Safety.MaximumRetries
-		if maximumRetries <= 0 {
-			maximumRetries = 3
-		}
-	}
+	maximumRetries := generator.maximumAttempts(options)
 
 	generationModel := options.ModelGeneration
 	if generationModel == "" {
@@ -350,7 +413,7 @@ func (generator *ToolGenerator) generateSequentialStudyGuide(
 		adherenceModel = generator.configuration.LLM.GetModelForTask("content_verification")
 	}
 
-	slog.Info("Starting parallel section generation",
+	slog.InfoContext(jobContext, "Starting parallel section generation",
 		"total_sections", len(sections),
 		"model", generationModel,
 		"adherence_model", adherenceModel,
@@ -366,20 +429,52 @@ func (generator *ToolGenerator) generateSequentialStudyGuide(
 
 	resultChan := make(chan sectionResult, len(sections))
 	var wg sync.WaitGroup
-	semaphore := make(chan struct{}, 3) // Concurrent LLM calls
 
+	// Updates report the tokens and cost of the sections completed so far, and the progress reached
 	completedSections := 0
+	runningMetrics := currentMetrics
+	currentProgress := 20
 	var updateMutex sync.Mutex
+	sectionProgress := func(index int, title string, attempt int) models.BuildProgress {
+		return models.BuildProgress{
+			Phase:   models.BuildPhaseGeneratingSections,
+			Section: &models.BuildSectionProgress{Index: index + 1, Title: title, Completed: completedSections, Total: len(sections)},
+			Attempt: attempt,
+		}
+	}
+
+	// Sections start as call slots free up rather than all at once, so a paused job stops before the sections
+	// it has not started and resumes them from the accepted ones
+	sectionSlots := make(chan struct{}, max(cap(generator.callSlots), 1))
 
 	for sectionIndex, section := range sections {
+		// Sections accepted before an interruption are kept as they were
+		if resumedContent, found := options.ResumeSections[sectionIndex]; found {
+			completedSections++
+			resultChan <- sectionResult{index: sectionIndex, content: resumedContent, ast: markdown.NewParser().Parse(resumedContent)}
+			continue
+		}
+
 		wg.Add(1)
 		go func(idx int, info sectionInfo) {
 			defer wg.Done()
 
+			select {
+			case sectionSlots <- struct{}{}:
+				defer func() { <-sectionSlots }()
+			case <-jobContext.Done():
+				resultChan <- sectionResult{err: jobContext.Err()}
+				return
+			}
+			if pauseError := models.CheckPause(jobContext); pauseError != nil {
+				resultChan <- sectionResult{err: pauseError}
+				return
+			}
+
 			var sectionPrompt string
 			if generator.promptManager != nil {
 				latexInstructions, _ := generator.promptManager.GetPrompt(prompts.PromptLatexInstructions, nil)
-				languageRequirement, _ := generator.promptManager.GetPrompt(prompts.PromptLanguageRequirement, map[string]string{"language": language, "language_code": language})
+				languageRequirement := generator.languageRequirement(language, options)
 
 				citationInstructions := ""
 				exampleTemplatePrompt := prompts.PromptSectionWithoutCitationsExample
@@ -389,7 +484,7 @@ func (generator *ToolGenerator) generateSequentialStudyGuide(
 				}
 				exampleTemplate, _ := generator.promptManager.GetPrompt(exampleTemplatePrompt, nil)
 
-				sectionPromptTemplate, _ := generator.promptManager.GetPrompt(prompts.PromptStudyGuideSectionGeneration, nil)
+				sectionPromptTemplate, _ := generator.getPrompt(options, prompts.PromptStudyGuideSectionGeneration, nil)
 				sectionPrompt = generator.replacePromptVariables(sectionPromptTemplate, map[string]string{
 					"language_requirement":  languageRequirement,
 					"section_title":         info.Title,
@@ -404,14 +499,19 @@ func (generator *ToolGenerator) generateSequentialStudyGuide(
 			var finalSecMetrics models.JobMetrics
 			var acceptedContent string
 			var acceptedAST *markdown.Node
+			var acceptedAttempts int
+			var acceptedScore *int
 
 			for attempt := 1; attempt <= maximumRetries; attempt++ {
-				select {
-				case semaphore <- struct{}{}:
-					defer func() { <-semaphore }()
-				case <-jobContext.Done():
+				if jobContext.Err() != nil {
+					resultChan <- sectionResult{err: jobContext.Err()}
 					return
 				}
+				if attempt > 1 {
+					updateMutex.Lock()
+					updateProgress(currentProgress, fmt.Sprintf("Retrying section %q (attempt %d/%d)...", info.Title, attempt, maximumRetries), sectionProgress(idx, info.Title, attempt), runningMetrics)
+					updateMutex.Unlock()
+				}
 
 				history := []llm.Message{
 					{Role: "user", Content: []llm.ContentPart{{Type: "text", Text: initialContext}}},
@@ -423,6 +523,7 @@ func (generator *ToolGenerator) generateSequentialStudyGuide(
 				finalSecMetrics.InputTokens += generationMetrics.InputTokens
 				finalSecMetrics.OutputTokens += generationMetrics.OutputTokens
 				finalSecMetrics.EstimatedCost += generationMetrics.EstimatedCost
+				finalSecMetrics.EstimatedInputTokens += generationMetrics.EstimatedInputTokens
 
 				if err != nil {
 					if attempt == maximumRetries {
@@ -469,19 +570,48 @@ func (generator *ToolGenerator) generateSequentialStudyGuide(
 				finalSecMetrics.InputTokens += verificationMetrics.InputTokens
 				finalSecMetrics.OutputTokens += verificationMetrics.OutputTokens
 				finalSecMetrics.EstimatedCost += verificationMetrics.EstimatedCost
+				finalSecMetrics.EstimatedInputTokens += verificationMetrics.EstimatedInputTokens
 
 				adherenceScore := generator.parseScore(verificationResponse)
 				if adherenceScore >= threshold || attempt == maximumRetries {
 					acceptedContent = response
 					acceptedAST = sectionAST
+					acceptedAttempts = attempt
+					if verificationResponse != "" {
+						acceptedScore = &adherenceScore
+					}
 					break
 				}
 			}
 
+			// Only the accepted attempt is scored, so retries do not pull the average of a variant down
+			if options.OnAdherenceScore != nil && acceptedScore != nil {
+				options.OnAdherenceScore(*acceptedScore)
+			}
+			if options.OnSectionAccepted != nil {
+				options.OnSectionAccepted(models.ToolSection{
+					Level:          2,
+					Position:       idx,
+					Title:          info.Title,
+					Coverage:       info.Coverage,
+					Content:        acceptedContent,
+					Model:          generationModel,
+					Attempts:       acceptedAttempts,
+					AdherenceScore: acceptedScore,
+					InputTokens:    finalSecMetrics.InputTokens,
+					OutputTokens:   finalSecMetrics.OutputTokens,
+					EstimatedCost:  finalSecMetrics.EstimatedCost,
+				})
+			}
+
 			updateMutex.Lock()
 			completedSections++
-			progress := 20 + int(float64(completedSections)/float64(len(sections))*75)
-			updateProgress(progress, fmt.Sprintf("Generated %d/%d sections...", completedSections, len(sections)), nil, currentMetrics)
+			runningMetrics.InputTokens += finalSecMetrics.InputTokens
+			runningMetrics.OutputTokens += finalSecMetrics.OutputTokens
+			runningMetrics.EstimatedCost += finalSecMetrics.EstimatedCost
+			runningMetrics.EstimatedInputTokens += finalSecMetrics.EstimatedInputTokens
+			currentProgress = 20 + int(float64(completedSections)/float64(len(sections))*75)
+			updateProgress(currentProgress, fmt.Sprintf("Generated %d/%d sections...", completedSections, len(sections)), sectionProgress(idx, info.Title, acceptedAttempts), runningMetrics)
 			updateMutex.Unlock()
 
 			resultChan <- sectionResult{
@@ -512,9 +642,10 @@ func (generator *ToolGenerator) generateSequentialStudyGuide(
 		metrics.InputTokens += res.metrics.InputTokens
 		metrics.OutputTokens += res.metrics.OutputTokens
 		metrics.EstimatedCost += res.metrics.EstimatedCost
+		metrics.EstimatedInputTokens += res.metrics.EstimatedInputTokens
 	}
 
-	slog.Info("Sequential generation complete",
+	slog.InfoContext(jobContext, "Sequential generation complete",
 		"total_sections", len(sections),
 		"successful_sections", len(successfulSections),
 		"total_input_tokens", metrics.InputTokens,
@@ -538,6 +669,9 @@ func (generator *ToolGenerator) ProcessFootnotesAI(jobContext context.Context, c
 		model = generator.configuration.LLM.GetModelForTask("content_polishing")
 	}
 
+	// Batches write disjoint ranges of updatedCitations, so they run concurrently within the shared call limit
+	var waitGroup sync.WaitGroup
+	var metricsMutex sync.Mutex
 	for citationIndex := 0; citationIndex < len(citations); citationIndex += 10 {
 		end := citationIndex + 10
 		if end > len(citations) {
@@ -545,14 +679,22 @@ func (generator *ToolGenerator) ProcessFootnotesAI(jobContext context.Context, c
 		}
 		batch := citations[citationIndex:end]
 
-		batchMetrics, err := generator.processFootnoteBatch(jobContext, batch, updatedCitations, citationIndex, languageCode, model, model)
-		totalMetrics.InputTokens += batchMetrics.InputTokens
-		totalMetrics.OutputTokens += batchMetrics.OutputTokens
-		totalMetrics.EstimatedCost += batchMetrics.EstimatedCost
-		if err != nil {
-			slog.Error("Footnote batch failed", "error", err)
-		}
+		waitGroup.Add(1)
+		go func(batch []markdown.ParsedCitation, offset int) {
+			defer waitGroup.Done()
+			batchMetrics, err := generator.processFootnoteBatch(jobContext, batch, updatedCitations, offset, languageCode, model, model)
+			metricsMutex.Lock()
+			totalMetrics.InputTokens += batchMetrics.InputTokens
+			totalMetrics.OutputTokens += batchMetrics.OutputTokens
+			totalMetrics.EstimatedCost += batchMetrics.EstimatedCost
+			totalMetrics.EstimatedInputTokens += batchMetrics.EstimatedInputTokens
+			metricsMutex.Unlock()
+			if err != nil {
+				slog.ErrorContext(jobContext, "Footnote batch failed", "error", err)
+			}
+		}(batch, citationIndex)
 	}
+	waitGroup.Wait()
 
 	return updatedCitations, totalMetrics, nil
 }
@@ -588,6 +730,7 @@ func (generator *ToolGenerator) processFootnoteBatch(jobContext context.Context,
 	metrics.InputTokens += parsingMetrics.InputTokens
 	metrics.OutputTokens += parsingMetrics.OutputTokens
 	metrics.EstimatedCost += parsingMetrics.EstimatedCost
+	metrics.EstimatedInputTokens += parsingMetrics.EstimatedInputTokens
 	if err != nil {
 		return metrics, err
 	}
@@ -624,6 +767,7 @@ func (generator *ToolGenerator) processFootnoteBatch(jobContext context.Context,
 	metrics.InputTokens += formattingMetrics.InputTokens
 	metrics.OutputTokens += formattingMetrics.OutputTokens
 	metrics.EstimatedCost += formattingMetrics.EstimatedCost
+	metrics.EstimatedInputTokens += formattingMetrics.EstimatedInputTokens
 
 	parser := markdown.NewParser()
 	ast := parser.Parse(formattingResponse)
@@ -690,7 +834,8 @@ func (generator *ToolGenerator) filterMaterialsByRanges(materials string, ranges
 	filteredRoot := &markdown.Node{Type: markdown.NodeDocument}
 
 	var currentFile *markdown.Node
-	pageRegex := regexp.MustCompile(`(?i)page\s*(\d+)`)
+	// Chunks spanning several pages are headed "Pages 4–6"; they are kept when any of their pages is relevant
+	pageRegex := regexp.MustCompile(`(?i)pages?\s*(\d+)(?:\s*[–-]\s*(\d+))?`)
 
 	var processNode func(*markdown.Node)
 	processNode = func(node *markdown.Node) {
@@ -706,9 +851,13 @@ func (generator *ToolGenerator) filterMaterialsByRanges(materials string, ranges
 				match := pageRegex.FindStringSubmatch(title)
 				if match != nil {
 					pageNum, _ := strconv.Atoi(match[1])
+					lastPageNum := pageNum
+					if match[2] != "" {
+						lastPageNum, _ = strconv.Atoi(match[2])
+					}
 					isRelevant := false
 					for _, pageRange := range ranges {
-						if pageNum >= pageRange.Start && pageNum <= pageRange.End {
+						if pageNum <= pageRange.End && lastPageNum >= pageRange.Start {
 							isRelevant = true
 							break
 						}
@@ -756,16 +905,41 @@ func (generator *ToolGenerator) callLLMWithHistoryAndModel(jobContext context.Co
 	messages := append(history, llm.Message{
 		Role: "user", Content: []llm.ContentPart{{Type: "text", Text: prompt}},
 	})
+	return generator.callLLMWithMessages(jobContext, messages, model)
+}
 
-	responseChannel, err := generator.llmProvider.Chat(jobContext, &llm.ChatRequest{
-		Model: model, Messages: messages, Stream: false, MaxTokens: 16384,
-	})
+// callLLMWithImage asks about an image, given as a data URL, with the prompt before it in the same message
+func (generator *ToolGenerator) callLLMWithImage(jobContext context.Context, prompt string, imageDataURL string, model string) (string, models.JobMetrics, error) {
+	if model == "" {
+		model = generator.configuration.LLM.Model
+	}
+
+	return generator.callLLMWithMessages(jobContext, []llm.Message{{
+		Role: "user", Content: []llm.ContentPart{{Type: "text", Text: prompt}, {Type: "image", ImageURL: imageDataURL}},
+	}}, model)
+}
+
+func (generator *ToolGenerator) callLLMWithMessages(jobContext context.Context, messages []llm.Message, model string) (string, models.JobMetrics, error) {
+	chatRequest := &llm.ChatRequest{Model: model, Messages: messages, Stream: false, MaxTokens: 16384}
+	chatRequest.ApplySampling(models.SamplingFromContext(jobContext))
+	estimatedTokens, err := llm.GuardRequest(jobContext, generator.llmProvider, chatRequest)
+	if err != nil {
+		return "", models.JobMetrics{}, err
+	}
+
+	releaseCallSlot, err := generator.acquireCallSlot(jobContext)
+	if err != nil {
+		return "", models.JobMetrics{}, err
+	}
+	defer releaseCallSlot()
+
+	responseChannel, err := generator.llmProvider.Chat(jobContext, chatRequest)
 	if err != nil {
 		return "", models.JobMetrics{}, err
 	}
 
 	var resultBuilder strings.Builder
-	var metrics models.JobMetrics
+	metrics := models.JobMetrics{EstimatedInputTokens: estimatedTokens}
 	for chunk := range responseChannel {
 		if chunk.Error != nil {
 			return "", models.JobMetrics{}, chunk.Error
@@ -775,6 +949,7 @@ func (generator *ToolGenerator) callLLMWithHistoryAndModel(jobContext context.Co
 		metrics.OutputTokens += chunk.OutputTokens
 		metrics.EstimatedCost += chunk.Cost
 	}
+	slog.Debug("LLM call completed", "model", model, "estimated_input_tokens", estimatedTokens, "input_tokens", metrics.InputTokens)
 
 	if generator.configuration.Safety.MaximumCostPerJob > 0 && metrics.EstimatedCost > generator.configuration.Safety.MaximumCostPerJob {
 		return "", metrics, fmt.Errorf("safety threshold exceeded: call cost $%.4f > limit $%.4f", metrics.EstimatedCost, generator.configuration.Safety.MaximumCostPerJob)
@@ -783,6 +958,56 @@ func (generator *ToolGenerator) callLLMWithHistoryAndModel(jobContext context.Co
 	return resultBuilder.String(), metrics, nil
 }
 
+// unfilledMetadataPlaceholder matches the metadata placeholders of fields the exam and lecture do not define
+var unfilledMetadataPlaceholder = regexp.MustCompile(`\{\{metadata_[a-z0-9_]+\}\}`)
+
+// getPrompt loads a prompt like the prompt manager does, unless the job was assigned a variant of it. The
+// metadata fields of the job fill their placeholders, and those of missing fields are left empty
+func (generator *ToolGenerator) getPrompt(options models.GenerationOptions, promptPath string, variables map[string]string) (string, error) {
+	allVariables := make(map[string]string, len(options.TemplateVariables)+len(variables))
+	for key, value := range options.TemplateVariables {
+		allVariables[key] = value
+	}
+	for key, value := range variables {
+		allVariables[key] = value
+	}
+
+	var prompt string
+	if variant, assigned := options.PromptVariants[promptPath]; assigned {
+		prompt = generator.replacePromptVariables(variant.Content, allVariables)
+	} else {
+		var err error
+		if prompt, err = generator.promptManager.GetPrompt(promptPath, allVariables); err != nil {
+			return "", err
+		}
+	}
+	return unfilledMetadataPlaceholder.ReplaceAllString(prompt, ""), nil
+}
+
+// languageRequirement renders the language requirement of a generation prompt, followed, when some reference
+// pages are in another language, by how to carry their content over: translated, or quoted verbatim with a
+// translation when documents.foreign_quotes is "verbatim"
+func (generator *ToolGenerator) languageRequirement(languageCode string, options models.GenerationOptions) string {
+	languageRequirement, _ := generator.promptManager.GetPrompt(prompts.PromptLanguageRequirement, map[string]string{"language": languageCode, "language_code": languageCode})
+	if len(options.SourceLanguages) == 0 {
+		return languageRequirement
+	}
+
+	foreignSourcesPrompt := prompts.PromptForeignSourcesTranslate
+	if generator.configuration != nil && generator.configuration.Documents.ForeignQuotes == "verbatim" {
+		foreignSourcesPrompt = prompts.PromptForeignSourcesVerbatim
+	}
+	foreignSources, err := generator.promptManager.GetPrompt(foreignSourcesPrompt, map[string]string{
+		"source_languages": strings.Join(options.SourceLanguages, ", "),
+		"language":         languageCode,
+		"language_code":    languageCode,
+	})
+	if err != nil {
+		return languageRequirement
+	}
+	return languageRequirement + "\n\n" + foreignSources
+}
+
 func (generator *ToolGenerator) replacePromptVariables(prompt string, variables map[string]string) string {
 	result := prompt
 	for key, value := range variables {
@@ -936,7 +1161,7 @@ func (generator *ToolGenerator) CleanDocumentTitle(jobContext context.Context, t
 			"language_requirement": languageRequirement,
 		})
 		if err != nil {
-			slog.Warn("Failed to load clean-document-title prompt, proceeding with empty prompt", "error", err)
+			slog.WarnContext(jobContext, "Failed to load clean-document-title prompt, proceeding with empty prompt", "error", err)
 			prompt = ""
 		}
 	}
@@ -970,7 +1195,7 @@ func (generator *ToolGenerator) CorrectProjectTitleDescription(jobContext contex
 	}
 
 	if generator.llmProvider == nil {
-		slog.Warn("LLM provider is nil in ToolGenerator, skipping title polishing")
+		slog.WarnContext(jobContext, "LLM provider is nil in ToolGenerator, skipping title polishing")
 		return title, description, models.JobMetrics{}, nil
 	}
 
@@ -981,7 +1206,7 @@ func (generator *ToolGenerator) CorrectProjectTitleDescription(jobContext contex
 		}
 	}
 
-	slog.Info("Polishing title and description", "title", title, "model", model)
+	slog.InfoContext(jobContext, "Polishing title and description", "title", title, "model", model)
 
 	var prompt string
 	if generator.promptManager != nil {
@@ -991,7 +1216,7 @@ func (generator *ToolGenerator) CorrectProjectTitleDescription(jobContext contex
 			"description": description,
 		})
 		if err != nil {
-			slog.Warn("Failed to load correct-project-title-description prompt, proceeding with empty prompt", "error", err)
+			slog.WarnContext(jobContext, "Failed to load correct-project-title-description prompt, proceeding with empty prompt", "error", err)
 			prompt = ""
 		}
 	}
@@ -1006,11 +1231,11 @@ func (generator *ToolGenerator) CorrectProjectTitleDescription(jobContext contex
 		Description string `json:"description"`
 	}
 	if err := generator.unmarshalJSONWithFallback(response, &result); err != nil {
-		slog.Warn("Failed to parse polished title JSON", "response", response, "error", err)
+		slog.WarnContext(jobContext, "Failed to parse polished title JSON", "response", response, "error", err)
 		return title, description, metrics, nil
 	}
 
-	slog.Info("Successfully polished title and description", "original", title, "polished", result.Title)
+	slog.InfoContext(jobContext, "Successfully polished title and description", "original", title, "polished", result.Title)
 	return result.Title, result.Description, metrics, nil
 }
 
@@ -1096,6 +1321,22 @@ type sectionInfo struct {
 	Coverage string
 }
 
+// reportGenerationAttempt returns the callback of generateValidatedJSON reporting each attempt at generating
+// flashcards or a quiz as the generating phase of the build
+func reportGenerationAttempt(updateProgress func(int, string, any, models.JobMetrics), label string) func(int, int, models.JobMetrics) {
+	return func(attempt int, maximumAttempts int, metrics models.JobMetrics) {
+		if updateProgress == nil {
+			return
+		}
+		progress := models.BuildProgress{Phase: models.BuildPhaseGenerating, Attempt: attempt}
+		if attempt == 1 {
+			updateProgress(10, "Generating "+label+"...", progress, metrics)
+			return
+		}
+		updateProgress(min(10+20*(attempt-1), 80), fmt.Sprintf("Repairing %s (attempt %d/%d)...", label, attempt, maximumAttempts), progress, metrics)
+	}
+}
+
 func (generator *ToolGenerator) GenerateFlashcards(jobContext context.Context, lecture models.Lecture, transcript string, referenceFilesContent string, languageCode string, options models.GenerationOptions, updateProgress func(int, string, any, models.JobMetrics)) (string, string, models.JobMetrics, error) {
 	if generator.llmProvider == nil {
 		return "", lecture.Title, models.JobMetrics{}, fmt.Errorf("llm provider is nil")
@@ -1104,11 +1345,8 @@ func (generator *ToolGenerator) GenerateFlashcards(jobContext context.Context, l
 	var prompt string
 	if generator.promptManager != nil {
 		latexInstructions, _ := generator.promptManager.GetPrompt(prompts.PromptLatexInstructions, nil)
-		languageRequirement, _ := generator.promptManager.GetPrompt(prompts.PromptLanguageRequirement, map[string]string{
-			"language":      languageCode,
-			"language_code": languageCode,
-		})
-		prompt, _ = generator.promptManager.GetPrompt(prompts.PromptGenerateFlashcards, map[string]string{
+		languageRequirement := generator.languageRequirement(languageCode, options)
+		prompt, _ = generator.getPrompt(options, prompts.PromptGenerateFlashcards, map[string]string{
 			"language_requirement": languageRequirement,
 			"transcript":           transcript, "reference_materials": referenceFilesContent, "latex_instructions": latexInstructions,
 		})
@@ -1119,13 +1357,13 @@ func (generator *ToolGenerator) GenerateFlashcards(jobContext context.Context, l
 		model = generator.configuration.LLM.GetModelForTask("content_generation")
 	}
 
-	response, metrics, err := generator.callLLMWithModel(jobContext, prompt, model)
+	content, metrics, err := generator.generateValidatedJSON(jobContext, prompt, model, "flashcard set", flashcardSchemaDescription, options, reportGenerationAttempt(updateProgress, "flashcards"), func(response string) (any, []string) {
+		return ValidateFlashcards(response)
+	})
 	if err != nil {
 		return "", "", metrics, err
 	}
-	// Reconstruct JSON to Markdown if needed (though typically LLM returns markdown directly)
-	// If we need to parse/reconstruct, we would use reconstructor.Language = languageCode
-	return response, lecture.Title, metrics, nil
+	return content, lecture.Title, metrics, nil
 }
 
 func (generator *ToolGenerator) GenerateQuiz(jobContext context.Context, lecture models.Lecture, transcript string, referenceFilesContent string, languageCode string, options models.GenerationOptions, updateProgress func(int, string, any, models.JobMetrics)) (string, string, models.JobMetrics, error) {
@@ -1136,13 +1374,42 @@ func (generator *ToolGenerator) GenerateQuiz(jobContext context.Context, lecture
 	var prompt string
 	if generator.promptManager != nil {
 		latexInstructions, _ := generator.promptManager.GetPrompt(prompts.PromptLatexInstructions, nil)
-		languageRequirement, _ := generator.promptManager.GetPrompt(prompts.PromptLanguageRequirement, map[string]string{
-			"language":      languageCode,
-			"language_code": languageCode,
+		languageRequirement := generator.languageRequirement(languageCode, options)
+		prompt, _ = generator.getPrompt(options, prompts.PromptGenerateQuiz, map[string]string{
+			"language_requirement": languageRequirement,
+			"transcript":           transcript, "reference_materials": referenceFilesContent, "latex_instructions": latexInstructions,
 		})
-		prompt, _ = generator.promptManager.GetPrompt(prompts.PromptGenerateQuiz, map[string]string{
+	}
+
+	model := options.ModelGeneration
+	if model == "" {
+		model = generator.configuration.LLM.GetModelForTask("content_generation")
+	}
+
+	content, metrics, err := generator.generateValidatedJSON(jobContext, prompt, model, "quiz", quizSchemaDescription, options, reportGenerationAttempt(updateProgress, "quiz"), func(response string) (any, []string) {
+		return ValidateQuiz(response)
+	})
+	if err != nil {
+		return "", "", metrics, err
+	}
+	return content, lecture.Title, metrics, nil
+}
+
+// GenerateMindmap builds the concept map of a lecture: its key concepts and the labeled relations between them,
+// stored as a graph that exports render as a Mermaid flowchart
+func (generator *ToolGenerator) GenerateMindmap(jobContext context.Context, lecture models.Lecture, transcript string, referenceFilesContent string, languageCode string, options models.GenerationOptions, updateProgress func(int, string, any, models.JobMetrics)) (string, string, models.JobMetrics, error) {
+	if generator.llmProvider == nil {
+		return "", lecture.Title, models.JobMetrics{}, fmt.Errorf("llm provider is nil")
+	}
+
+	var prompt string
+	if generator.promptManager != nil {
+		latexInstructions, _ := generator.promptManager.GetPrompt(prompts.PromptLatexInstructions, nil)
+		languageRequirement := generator.languageRequirement(languageCode, options)
+		prompt, _ = generator.getPrompt(options, prompts.PromptGenerateMindmap, map[string]string{
 			"language_requirement": languageRequirement,
 			"transcript":           transcript, "reference_materials": referenceFilesContent, "latex_instructions": latexInstructions,
+			"minimum_nodes": strconv.Itoa(minimumMindmapNodes), "maximum_nodes": strconv.Itoa(maximumMindmapNodes),
 		})
 	}
 
@@ -1151,11 +1418,13 @@ func (generator *ToolGenerator) GenerateQuiz(jobContext context.Context, lecture
 		model = generator.configuration.LLM.GetModelForTask("content_generation")
 	}
 
-	response, metrics, err := generator.callLLMWithModel(jobContext, prompt, model)
+	content, metrics, err := generator.generateValidatedJSON(jobContext, prompt, model, "mindmap", mindmapSchemaDescription, options, reportGenerationAttempt(updateProgress, "mindmap"), func(response string) (any, []string) {
+		return ValidateMindmap(response)
+	})
 	if err != nil {
 		return "", "", metrics, err
 	}
-	return response, lecture.Title, metrics, nil
+	return content, lecture.Title, metrics, nil
 }
 
 func (generator *ToolGenerator) unionAndMergeRanges(allRuns [][]struct {
@@ -1199,3 +1468,27 @@ func (generator *ToolGenerator) unionAndMergeRanges(allRuns [][]struct {
 	mergedRanges = append(mergedRanges, currentRange)
 	return mergedRanges
 }
+
+// reportOutline hands a newly analyzed outline to options.OnOutline, titled after its heading or fallbackTitle
+func (generator *ToolGenerator) reportOutline(options models.GenerationOptions, structure string, fallbackTitle string, metrics models.JobMetrics) {
+	if options.OnOutline == nil {
+		return
+	}
+	outlineTitle := generator.parseTitle(structure)
+	if outlineTitle == "" {
+		outlineTitle = fallbackTitle
+	}
+	structureModel := options.ModelStructure
+	if structureModel == "" {
+		structureModel = generator.configuration.LLM.GetModelForTask("outline_creation")
+	}
+	options.OnOutline(models.ToolSection{
+		Level:         1,
+		Title:         outlineTitle,
+		Content:       structure,
+		Model:         structureModel,
+		InputTokens:   metrics.InputTokens,
+		OutputTokens:  metrics.OutputTokens,
+		EstimatedCost: metrics.EstimatedCost,
+	})
+}
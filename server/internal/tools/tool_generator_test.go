@@ -3,9 +3,12 @@ package tools
 import (
 	"context"
 	"fmt"
+	"os"
+	"path/filepath"
 	"strings"
 	"sync"
 	"testing"
+	"time"
 
 	"lectures/internal/configuration"
 	"lectures/internal/llm"
@@ -118,10 +121,11 @@ Success 2`,
 	generator := NewToolGenerator(config, mockLLM, prompts.NewManager(""))
 	lecture := models.Lecture{Title: "Lecture Title"}
 
+	threshold, maximumRetries := 70, 3
 	options := models.GenerationOptions{
 		EnableDocumentsMatching: true,
-		AdherenceThreshold:      70,
-		MaximumRetries:          3,
+		AdherenceThreshold:      &threshold,
+		MaximumRetries:          &maximumRetries,
 	}
 
 	result, _, err := generator.GenerateStudyGuide(context.Background(), lecture, "Transcript", "References", "medium", "en", options, func(p int, m string, meta any, met models.JobMetrics) {})
@@ -142,6 +146,97 @@ Success 2`,
 	}
 }
 
+func TestToolGenerator_ResumeStudyGuide(tester *testing.T) {
+	mockLLM := &UnbreakableSequentialMock{
+		Responses: []string{
+			// Only the missing section is generated and verified
+			`## Deep Dive
+Fresh 2`,
+			`{"coverage_score": 90}`,
+		},
+	}
+	generator := NewToolGenerator(&configuration.Configuration{}, mockLLM, prompts.NewManager(""))
+
+	var acceptedSections []models.ToolSection
+	threshold, maximumRetries := 70, 3
+	options := models.GenerationOptions{
+		AdherenceThreshold: &threshold,
+		MaximumRetries:     &maximumRetries,
+		ModelGeneration:    "generation-model",
+		ResumeOutline: `# Outline
+## Intro
+Coverage: Part 1
+## Deep Dive
+Coverage: Part 2`,
+		ResumeSections: map[int]string{0: "## Intro\nStored 1"},
+		OnOutline: func(outline models.ToolSection) {
+			tester.Errorf("The stored outline should be reused, got a new one: %s", outline.Content)
+		},
+		OnSectionAccepted: func(section models.ToolSection) {
+			acceptedSections = append(acceptedSections, section)
+		},
+	}
+
+	result, title, err := generator.GenerateStudyGuide(context.Background(), models.Lecture{Title: "Lecture Title"}, "Transcript", "", "medium", "en", options, func(p int, m string, meta any, met models.JobMetrics) {})
+	if err != nil {
+		tester.Fatalf("Generation failed: %v", err)
+	}
+
+	if title != "Outline" {
+		tester.Errorf("Expected the title of the stored outline, got %q", title)
+	}
+	if !strings.Contains(result, "Stored 1") || !strings.Contains(result, "Fresh 2") || strings.Index(result, "Stored 1") > strings.Index(result, "Fresh 2") {
+		tester.Errorf("Expected the stored section followed by the new one, got:\n%s", result)
+	}
+	if mockLLM.CallIndex != 2 {
+		tester.Errorf("Expected 2 model calls for the missing section, got %d", mockLLM.CallIndex)
+	}
+	if len(acceptedSections) != 1 {
+		tester.Fatalf("Expected 1 accepted section, got %d", len(acceptedSections))
+	}
+	section := acceptedSections[0]
+	if section.Position != 1 || section.Title != "Deep Dive" || section.Attempts != 1 || section.Model != "generation-model" ||
+		section.AdherenceScore == nil || *section.AdherenceScore != 90 {
+		tester.Errorf("Unexpected accepted section %+v", section)
+	}
+}
+
+func TestToolGenerator_AdherenceScoreOfAcceptedSection(tester *testing.T) {
+	mockLLM := &UnbreakableSequentialMock{
+		Responses: []string{
+			// The first attempt falls short of the threshold and is retried
+			`## Deep Dive
+Partial 2`,
+			`{"coverage_score": 40}`,
+			`## Deep Dive
+Complete 2`,
+			`{"coverage_score": 90}`,
+		},
+	}
+	generator := NewToolGenerator(&configuration.Configuration{}, mockLLM, prompts.NewManager(""))
+
+	var scores []int
+	threshold, maximumRetries := 70, 3
+	options := models.GenerationOptions{
+		AdherenceThreshold: &threshold,
+		MaximumRetries:     &maximumRetries,
+		ResumeOutline: `# Outline
+## Intro
+Coverage: Part 1
+## Deep Dive
+Coverage: Part 2`,
+		ResumeSections:   map[int]string{0: "## Intro\nStored 1"},
+		OnAdherenceScore: func(score int) { scores = append(scores, score) },
+	}
+
+	if _, _, err := generator.GenerateStudyGuide(context.Background(), models.Lecture{Title: "Lecture Title"}, "Transcript", "", "medium", "en", options, func(p int, m string, meta any, met models.JobMetrics) {}); err != nil {
+		tester.Fatalf("Generation failed: %v", err)
+	}
+	if len(scores) != 1 || scores[0] != 90 {
+		tester.Errorf("Expected only the score of the accepted attempt, got %v", scores)
+	}
+}
+
 func TestToolGenerator_FootnoteHealing(tester *testing.T) {
 	config := &configuration.Configuration{}
 
@@ -271,3 +366,703 @@ func TestToolGenerator_FencedJSONExtraction(tester *testing.T) {
 		})
 	}
 }
+
+func TestToolGenerator_QuizValidationNormalizesAnswers(tester *testing.T) {
+	content := "Here is your quiz:\n```json\n" + `[
+		{"question": "Q1", "options": ["Nucleus", "Ribosome", "Mitochondria", "Golgi"], "correct_answer": "C", "explanation": "E1"},
+		{"question": "Q2", "options": ["Nucleus", "Ribosome", "Mitochondria", "Golgi"], "correct_answer": 2, "explanation": "E2"},
+		{"question": "Q3", "options": ["Nucleus", "Ribosome", "Mitochondria", "Golgi"], "correct_answer": " golgi ", "explanation": "E3"},
+		{"question": "Q4", "options": ["Nucleus", "Ribosome", "Mitochondria", "Golgi"], "correct_answer": "A) Nucleus", "explanation": "E4"}
+	]` + "\n```"
+
+	questions, issues := ValidateQuiz(content)
+	if len(issues) > 0 {
+		tester.Fatalf("Expected no issues, got %v", issues)
+	}
+
+	expectedAnswers := []string{"Mitochondria", "Ribosome", "Golgi", "Nucleus"}
+	for index, question := range questions {
+		if question.CorrectAnswer != expectedAnswers[index] {
+			tester.Errorf("Question %d: expected answer %q, got %q", index+1, expectedAnswers[index], question.CorrectAnswer)
+		}
+	}
+
+	_, issues = ValidateQuiz(`[{"question": "Q", "options": ["A", "B", "C"], "correct_answer": "Z", "explanation": ""}]`)
+	if len(issues) != 2 {
+		tester.Errorf("Expected missing explanation and option count issues, got %v", issues)
+	}
+}
+
+func TestToolGenerator_QuizValidationQuestionTypes(tester *testing.T) {
+	content := `[
+		{"type": "Matching", "question": "Match", "pairs": [{"left": "H2O", "right": "Water"}, {"left": "NaCl", "right": "Salt"}, {"left": "CO2", "right": "Carbon dioxide"}], "explanation": "E1"},
+		{"type": "ordering", "question": "Order", "items": ["Prophase", "Metaphase", "Anaphase", "Telophase"], "explanation": "E2"},
+		{"type": "numeric", "question": "g?", "answer": "9.81", "tolerance": 0.05, "unit": "m/s^2", "explanation": "E3"},
+		{"type": "free-response", "question": "Why?", "correct_answer": "Because.", "rubric": [{"criterion": "Names the cause", "points": 2}], "explanation": "E4"}
+	]`
+
+	questions, issues := ValidateQuiz(content)
+	if len(issues) > 0 {
+		tester.Fatalf("Expected no issues, got %v", issues)
+	}
+	expectedTypes := []string{models.QuizQuestionMatching, models.QuizQuestionOrdering, models.QuizQuestionNumeric, models.QuizQuestionFreeResponse}
+	for index, question := range questions {
+		if question.Type != expectedTypes[index] {
+			tester.Errorf("Question %d: expected type %q, got %q", index+1, expectedTypes[index], question.Type)
+		}
+	}
+	if questions[2].Answer == nil || *questions[2].Answer != 9.81 || questions[2].Tolerance != 0.05 {
+		tester.Errorf("Unexpected numeric answer %v ± %v", questions[2].Answer, questions[2].Tolerance)
+	}
+	if len(questions[3].Rubric) != 1 || questions[3].Rubric[0].Points != 2 {
+		tester.Errorf("Unexpected rubric %+v", questions[3].Rubric)
+	}
+
+	_, issues = ValidateQuiz(`[
+		{"type": "matching", "question": "Q", "pairs": [{"left": "A", "right": "1"}, {"left": "B", "right": "1"}, {"left": "C", "right": "3"}], "explanation": "E"},
+		{"type": "ordering", "question": "Q", "items": ["A", "B"], "explanation": "E"},
+		{"type": "numeric", "question": "Q", "answer": "about ten", "tolerance": -1, "explanation": "E"},
+		{"type": "free_response", "question": "Q", "rubric": [{"criterion": "C", "points": 0.5}], "explanation": "E"},
+		{"type": "essay", "question": "Q", "explanation": "E"}
+	]`)
+	if len(issues) != 7 {
+		tester.Errorf("Expected 7 issues, got %v", issues)
+	}
+}
+
+func TestToolGenerator_MindmapValidation(tester *testing.T) {
+	content := "```json\n" + `{
+		"nodes": [
+			{"id": 1, "label": "Optics", "description": "The study of light."},
+			{"id": "2", "label": "Lenses", "description": "Refract light."},
+			{"id": "3", "label": "Mirrors", "description": "Reflect light."}
+		],
+		"edges": [
+			{"from": 1, "to": "2", "label": "studies"},
+			{"from": "1", "to": "3", "label": "studies"},
+			{"from": "1", "to": "3", "label": "also studies"}
+		]
+	}` + "\n```"
+
+	mindmap, issues := ValidateMindmap(content)
+	if len(issues) > 0 {
+		tester.Fatalf("Expected no issues, got %v", issues)
+	}
+	if len(mindmap.Nodes) != 3 || mindmap.Nodes[0].ID != "1" {
+		tester.Errorf("Expected numeric identifiers read as strings, got %+v", mindmap.Nodes)
+	}
+	if len(mindmap.Edges) != 2 {
+		tester.Errorf("Expected the repeated relation dropped, got %+v", mindmap.Edges)
+	}
+
+	_, issues = ValidateMindmap(`{
+		"nodes": [{"id": "a", "label": "Optics"}, {"id": "a", "label": "optics"}, {"id": "c", "label": "Prisms"}],
+		"edges": [{"from": "a", "to": "z", "label": "x"}, {"from": "a", "to": "a", "label": "x"}]
+	}`)
+	// The repeated id and concept, the unknown node, the loop, and the two concepts linked to nothing
+	if len(issues) != 6 {
+		tester.Errorf("Expected 6 issues, got %v", issues)
+	}
+	if _, issues = ValidateMindmap("no map here"); len(issues) != 1 {
+		tester.Errorf("Expected a single issue for a response without JSON, got %v", issues)
+	}
+}
+
+func TestGradeQuizAnswer(tester *testing.T) {
+	answer, tolerance := 9.81, 0.05
+	closeNumber, farNumber := 9.8, 9.7
+	testCases := []struct {
+		name          string
+		question      models.QuizQuestion
+		answer        models.QuizAnswer
+		expectedScore float64
+	}{
+		{"Multiple choice without a type", models.QuizQuestion{Options: []string{"A", "B"}, CorrectAnswer: "B"}, models.QuizAnswer{Choice: " b "}, 1},
+		{"Wrong choice", models.QuizQuestion{Options: []string{"A", "B"}, CorrectAnswer: "B"}, models.QuizAnswer{Choice: "A"}, 0},
+		{
+			"Matching with one swap",
+			models.QuizQuestion{Type: models.QuizQuestionMatching, Pairs: []models.QuizPair{{Left: "H2O", Right: "Water"}, {Left: "NaCl", Right: "Salt"}, {Left: "CO2", Right: "Carbon dioxide"}, {Left: "O2", Right: "Oxygen"}}},
+			models.QuizAnswer{Matches: map[string]string{"H2O": "Water", "NaCl": "Salt", "CO2": "Oxygen", "O2": "Carbon dioxide"}},
+			0.5,
+		},
+		{
+			"Ordering with the last item first",
+			models.QuizQuestion{Type: models.QuizQuestionOrdering, Items: []string{"A", "B", "C", "D"}},
+			models.QuizAnswer{Order: []string{"D", "A", "B", "C"}},
+			0.5,
+		},
+		{"Numeric within the tolerance", models.QuizQuestion{Type: models.QuizQuestionNumeric, Answer: &answer, Tolerance: tolerance}, models.QuizAnswer{Number: &closeNumber}, 1},
+		{"Numeric outside the tolerance", models.QuizQuestion{Type: models.QuizQuestionNumeric, Answer: &answer, Tolerance: tolerance}, models.QuizAnswer{Number: &farNumber}, 0},
+		{"Numeric without an answer", models.QuizQuestion{Type: models.QuizQuestionNumeric, Answer: &answer}, models.QuizAnswer{}, 0},
+	}
+
+	for _, testCase := range testCases {
+		tester.Run(testCase.name, func(subTester *testing.T) {
+			result := GradeQuizAnswer(testCase.question, testCase.answer)
+			if result.Score != testCase.expectedScore || result.Correct != (testCase.expectedScore == 1) {
+				subTester.Errorf("Expected score %v, got %+v", testCase.expectedScore, result)
+			}
+		})
+	}
+}
+
+func TestToolGenerator_GradeQuizAttempt(tester *testing.T) {
+	config := &configuration.Configuration{LLM: configuration.LLMConfiguration{Model: "test-model"}}
+	mockLLM := &UnbreakableSequentialMock{
+		Responses: []string{`{"awarded_points": [2, 5], "feedback": "Names the cause, but the mechanism is vague."}`},
+		Costs:     []float64{0.01},
+	}
+	generator := NewToolGenerator(config, mockLLM, prompts.NewManager("../../prompts"))
+
+	questions := []models.QuizQuestion{
+		{Options: []string{"A", "B", "C", "D"}, CorrectAnswer: "C"},
+		{Type: models.QuizQuestionFreeResponse, Question: "Why?", CorrectAnswer: "Because.", Rubric: []models.QuizRubricCriterion{{Criterion: "Names the cause", Points: 2}, {Criterion: "Explains the mechanism", Points: 2}}},
+		{Type: models.QuizQuestionFreeResponse, Question: "How?", CorrectAnswer: "Like so.", Rubric: []models.QuizRubricCriterion{{Criterion: "Describes it", Points: 1}}},
+	}
+	answers := []models.QuizAnswer{{Choice: "C"}, {Text: "Because of the cause."}}
+
+	results, metrics, err := generator.GradeQuizAttempt(context.Background(), questions, answers, "en-US")
+	if err != nil {
+		tester.Fatalf("GradeQuizAttempt failed: %v", err)
+	}
+	if len(results) != 3 || !results[0].Correct {
+		tester.Fatalf("Unexpected results %+v", results)
+	}
+	// Points above a criterion's worth are capped, so the response earns 4 of 4
+	if results[1].Score != 1 || results[1].Feedback == "" {
+		tester.Errorf("Unexpected free response grade %+v", results[1])
+	}
+	// The unanswered question scores 0 without calling the model
+	if results[2].Score != 0 || mockLLM.CallIndex != 1 {
+		tester.Errorf("Expected the unanswered question to score 0 after a single call, got %+v after %d calls", results[2], mockLLM.CallIndex)
+	}
+	if metrics.EstimatedCost != 0.01 {
+		tester.Errorf("Expected the grading cost to be reported, got %v", metrics.EstimatedCost)
+	}
+	if prompt := mockLLM.Histories[0][len(mockLLM.Histories[0])-1].Content[0].Text; !strings.Contains(prompt, "2. Explains the mechanism (2 points)") {
+		tester.Errorf("Expected the rubric in the grading prompt, got %s", prompt)
+	}
+}
+
+func TestToolGenerator_FlashcardRepairLoop(tester *testing.T) {
+	config := &configuration.Configuration{LLM: configuration.LLMConfiguration{Model: "test-model"}}
+
+	mockLLM := &UnbreakableSequentialMock{
+		Responses: []string{
+			`[{"front": "Term", "back": ""}, {"question": "Wrong keys"}]`,
+			`[{"front": " Term ", "back": "Definition"}, {"front": "term", "back": "Duplicate"}]`,
+		},
+		Costs: []float64{0.01, 0.02},
+	}
+
+	generator := NewToolGenerator(config, mockLLM, prompts.NewManager("../../prompts"))
+	content, _, metrics, err := generator.GenerateFlashcards(context.Background(), models.Lecture{Title: "Lecture"}, "T", "", "en-US", models.GenerationOptions{}, nil)
+	if err != nil {
+		tester.Fatalf("Expected repaired flashcards, got error: %v", err)
+	}
+
+	if content != `[{"front":"Term","back":"Definition"}]` {
+		tester.Errorf("Unexpected normalized content: %s", content)
+	}
+	if metrics.EstimatedCost < 0.029 {
+		tester.Errorf("Expected metrics from both attempts, got %f", metrics.EstimatedCost)
+	}
+
+	// The repair request must carry the failed response and the issues found
+	repairHistory := mockLLM.Histories[1]
+	if len(repairHistory) != 3 || repairHistory[1].Role != "assistant" {
+		tester.Fatalf("Expected original prompt, failed response and repair request, got %d messages", len(repairHistory))
+	}
+	if !strings.Contains(repairHistory[2].Content[0].Text, `card 1: "back" is missing or empty`) {
+		tester.Errorf("Repair prompt does not list the validation issues: %s", repairHistory[2].Content[0].Text)
+	}
+}
+
+// cancellingMock fails every call, cancelling the job on the first one
+type cancellingMock struct {
+	cancel context.CancelFunc
+	calls  int
+}
+
+func (mock *cancellingMock) Chat(jobContext context.Context, chatRequest *llm.ChatRequest) (<-chan llm.ChatResponseChunk, error) {
+	mock.calls++
+	mock.cancel()
+	return nil, fmt.Errorf("provider unavailable")
+}
+
+func (mock *cancellingMock) Name() string { return "cancelling-mock" }
+
+func TestToolGenerator_RepairLoopStopsWhenCancelled(tester *testing.T) {
+	config := &configuration.Configuration{LLM: configuration.LLMConfiguration{Model: "test-model"}}
+	jobContext, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	mockLLM := &cancellingMock{cancel: cancel}
+
+	generator := NewToolGenerator(config, mockLLM, prompts.NewManager("../../prompts"))
+	started := time.Now()
+	_, _, _, err := generator.GenerateFlashcards(jobContext, models.Lecture{Title: "Lecture"}, "T", "", "en-US", models.GenerationOptions{}, nil)
+	if err != context.Canceled {
+		tester.Errorf("Expected the cancellation to end the generation, got %v", err)
+	}
+	if mockLLM.calls != 1 || time.Since(started) >= time.Second {
+		tester.Errorf("Expected no retry after the cancellation, got %d calls in %v", mockLLM.calls, time.Since(started))
+	}
+}
+
+func TestToolGenerator_GenerateMindmap(tester *testing.T) {
+	config := &configuration.Configuration{LLM: configuration.LLMConfiguration{Model: "test-model"}}
+
+	mockLLM := &UnbreakableSequentialMock{
+		Responses: []string{
+			`{"nodes": [{"id": "a", "label": "Optics"}, {"id": "b", "label": "Lenses"}], "edges": [{"from": "a", "to": "b", "label": "studies"}]}`,
+			`{"nodes": [{"id": "a", "label": "Optics"}, {"id": "b", "label": "Lenses"}, {"id": "c", "label": "Mirrors"}], "edges": [{"from": "a", "to": "b", "label": "studies"}, {"from": "a", "to": "c", "label": "studies"}]}`,
+		},
+	}
+
+	generator := NewToolGenerator(config, mockLLM, prompts.NewManager("../../prompts"))
+	content, title, _, err := generator.GenerateMindmap(context.Background(), models.Lecture{Title: "Optics"}, "T", "", "en-US", models.GenerationOptions{}, nil)
+	if err != nil {
+		tester.Fatalf("Expected a repaired mindmap, got error: %v", err)
+	}
+	if title != "Optics" || !strings.Contains(content, `"label":"Mirrors"`) {
+		tester.Errorf("Unexpected mindmap %q: %s", title, content)
+	}
+	if !strings.Contains(mockLLM.Histories[0][0].Content[0].Text, "between 3 and 40 concepts") {
+		tester.Errorf("Expected the node bounds in the prompt")
+	}
+	if !strings.Contains(mockLLM.Histories[1][2].Content[0].Text, "the map has 2 nodes") {
+		tester.Errorf("Repair prompt does not list the validation issues: %s", mockLLM.Histories[1][2].Content[0].Text)
+	}
+}
+
+func TestToolGenerator_FlashcardProgressPhases(tester *testing.T) {
+	config := &configuration.Configuration{LLM: configuration.LLMConfiguration{Model: "test-model"}}
+
+	mockLLM := &UnbreakableSequentialMock{
+		Responses: []string{
+			`[{"front": "Term", "back": ""}]`,
+			`[{"front": "Term", "back": "Definition"}]`,
+		},
+		Costs: []float64{0.01, 0.02},
+	}
+
+	var updates []models.BuildProgress
+	var costs []float64
+	generator := NewToolGenerator(config, mockLLM, prompts.NewManager("../../prompts"))
+	_, _, _, err := generator.GenerateFlashcards(context.Background(), models.Lecture{Title: "Lecture"}, "T", "", "en-US", models.GenerationOptions{}, func(progress int, message string, metadata any, metrics models.JobMetrics) {
+		buildProgress, ok := metadata.(models.BuildProgress)
+		if !ok {
+			tester.Fatalf("Expected build progress metadata, got %T", metadata)
+		}
+		updates = append(updates, buildProgress.Within(models.BuildPhases("flashcard", false), metrics))
+		costs = append(costs, metrics.EstimatedCost)
+	})
+	if err != nil {
+		tester.Fatalf("Expected repaired flashcards, got error: %v", err)
+	}
+
+	// One update per attempt, the repair reporting what the first attempt cost
+	if len(updates) != 2 {
+		tester.Fatalf("Expected an update per attempt, got %+v", updates)
+	}
+	for index, update := range updates {
+		if update.Phase != models.BuildPhaseGenerating || update.PhaseIndex != 1 || update.TotalPhases != 2 || update.Attempt != index+1 {
+			tester.Errorf("Unexpected progress of attempt %d: %+v", index+1, update)
+		}
+	}
+	if costs[0] != 0 || costs[1] < 0.009 || updates[1].EstimatedCost != costs[1] {
+		tester.Errorf("Expected the cost so far in each update, got %v and %+v", costs, updates[1])
+	}
+}
+
+// mockImageProvider returns a fixed payload and records the prompts it received
+type mockImageProvider struct {
+	Prompts []string
+}
+
+func (mock *mockImageProvider) GenerateImage(jobContext context.Context, request *llm.ImageRequest) ([]byte, error) {
+	mock.Prompts = append(mock.Prompts, request.Prompt)
+	return []byte("png-bytes"), nil
+}
+
+func (mock *mockImageProvider) Name() string { return "mock-images" }
+
+func TestToolGenerator_FlashcardMnemonicImages(tester *testing.T) {
+	config := &configuration.Configuration{
+		LLM:             configuration.LLMConfiguration{Model: "test-model"},
+		ImageGeneration: configuration.ImageGenerationConfiguration{MaximumImagesPerTool: 1, CostPerImage: 0.04},
+	}
+
+	mockLLM := &UnbreakableSequentialMock{
+		Responses: []string{`{"selections": [{"card": 2, "prompt": "A heart with four chambers"}, {"card": 1, "prompt": "Over the limit"}, {"card": 9, "prompt": "Out of range"}]}`},
+	}
+	imageProvider := &mockImageProvider{}
+
+	generator := NewToolGenerator(config, mockLLM, nil)
+	generator.SetImageProvider(imageProvider)
+
+	toolDirectory := tester.TempDir()
+	content := `[{"front":"F1","back":"B1"},{"front":"F2","back":"B2"}]`
+	updatedContent, metrics, err := generator.GenerateFlashcardImages(context.Background(), content, toolDirectory, models.GenerationOptions{})
+	if err != nil {
+		tester.Fatalf("Image stage failed: %v", err)
+	}
+
+	if len(imageProvider.Prompts) != 1 || imageProvider.Prompts[0] != "A heart with four chambers" {
+		tester.Errorf("Expected a single image for card 2, got prompts %v", imageProvider.Prompts)
+	}
+	if updatedContent != `[{"front":"F1","back":"B1"},{"front":"F2","back":"B2","image":"images/card_2.png"}]` {
+		tester.Errorf("Unexpected content: %s", updatedContent)
+	}
+	if metrics.EstimatedCost != 0.04 {
+		tester.Errorf("Expected image cost to be accounted, got %f", metrics.EstimatedCost)
+	}
+	if _, err := os.Stat(filepath.Join(toolDirectory, "images", "card_2.png")); err != nil {
+		tester.Errorf("Expected image file to be stored: %v", err)
+	}
+
+	// Stored paths resolve inside the tool directory only
+	resolvedContent := ResolveFlashcardImages(`[{"front":"F","back":"B","image":"../../etc/passwd"},{"front":"F2","back":"B2","image":"images/card_2.png"}]`, toolDirectory)
+	expectedPath := filepath.Join(toolDirectory, "images", "card_2.png")
+	if !strings.Contains(resolvedContent, expectedPath) || strings.Contains(resolvedContent, "passwd") {
+		tester.Errorf("Unexpected resolved content: %s", resolvedContent)
+	}
+}
+
+func TestToolGenerator_PolishTranscriptSegments(tester *testing.T) {
+	config := &configuration.Configuration{LLM: configuration.LLMConfiguration{Model: "test-model"}}
+
+	mockLLM := &UnbreakableSequentialMock{
+		Responses: []string{"```json\n" + `{"segments": [{"number": 2, "text": "So, the heart has four chambers."}, {"number": 1, "text": "  "}, {"number": 7, "text": "Out of range"}]}` + "\n```"},
+		Costs:     []float64{0.01},
+	}
+
+	generator := NewToolGenerator(config, mockLLM, prompts.NewManager("../../prompts"))
+	segments := []models.TranscriptSegment{{ID: 10, Text: "um okay"}, {ID: 11, Text: "so uh the heart has like four chambers"}}
+	polishedTexts, metrics, err := generator.PolishTranscriptSegments(context.Background(), segments, models.GenerationOptions{})
+	if err != nil {
+		tester.Fatalf("Polishing failed: %v", err)
+	}
+
+	if len(polishedTexts) != 1 || polishedTexts[11] != "So, the heart has four chambers." {
+		tester.Errorf("Expected only segment 11 to be polished, got %v", polishedTexts)
+	}
+	if metrics.EstimatedCost != 0.01 {
+		tester.Errorf("Expected metrics to be returned, got %f", metrics.EstimatedCost)
+	}
+	if !strings.Contains(mockLLM.Histories[0][0].Content[0].Text, "2. so uh the heart has like four chambers") {
+		tester.Errorf("Prompt does not list the numbered segments: %s", mockLLM.Histories[0][0].Content[0].Text)
+	}
+}
+
+// concurrencyTrackingMock answers after a short delay and records the highest number of calls in flight
+type concurrencyTrackingMock struct {
+	mutex       sync.Mutex
+	inFlight    int
+	maximumSeen int
+}
+
+func (mock *concurrencyTrackingMock) Chat(jobContext context.Context, chatRequest *llm.ChatRequest) (<-chan llm.ChatResponseChunk, error) {
+	mock.mutex.Lock()
+	mock.inFlight++
+	mock.maximumSeen = max(mock.maximumSeen, mock.inFlight)
+	mock.mutex.Unlock()
+
+	channel := make(chan llm.ChatResponseChunk, 1)
+	go func() {
+		defer close(channel)
+		time.Sleep(20 * time.Millisecond)
+		mock.mutex.Lock()
+		mock.inFlight--
+		mock.mutex.Unlock()
+		channel <- llm.ChatResponseChunk{Text: "Done"}
+	}()
+	return channel, nil
+}
+
+func (mock *concurrencyTrackingMock) Name() string { return "concurrency-tracking-mock" }
+
+func TestToolGenerator_BoundsConcurrentCalls(tester *testing.T) {
+	config := &configuration.Configuration{}
+	config.LLM.MaximumConcurrentCalls = 2
+	mockLLM := &concurrencyTrackingMock{}
+	generator := NewToolGenerator(config, mockLLM, nil)
+
+	// Calls issued by concurrent batches, from one job or several, share the same limit
+	var waitGroup sync.WaitGroup
+	for callIndex := 0; callIndex < 8; callIndex++ {
+		waitGroup.Add(1)
+		go func() {
+			defer waitGroup.Done()
+			if _, _, err := generator.callLLMWithModel(context.Background(), "Prompt", "model"); err != nil {
+				tester.Errorf("Call failed: %v", err)
+			}
+		}()
+	}
+	waitGroup.Wait()
+
+	if mockLLM.maximumSeen != 2 {
+		tester.Errorf("Expected at most 2 calls in flight, saw %d", mockLLM.maximumSeen)
+	}
+
+	cancelledContext, cancel := context.WithCancel(context.Background())
+	cancel()
+	generator.callSlots <- struct{}{}
+	generator.callSlots <- struct{}{}
+	if _, _, err := generator.callLLMWithModel(cancelledContext, "Prompt", "model"); err != context.Canceled {
+		tester.Errorf("Expected a cancelled job to stop waiting for a slot, got %v", err)
+	}
+}
+
+func TestToolGenerator_GenerateLectureRecap(tester *testing.T) {
+	config := &configuration.Configuration{LLM: configuration.LLMConfiguration{Model: "test-model"}}
+
+	mockLLM := &UnbreakableSequentialMock{
+		Responses: []string{`{"bullets": ["- Cells are the unit of life.", " ", "Membranes control transport.", "Mitochondria produce ATP.", "DNA is stored in the nucleus.", "Ribosomes build proteins.", "Extra bullet."]}`},
+		Costs:     []float64{0.002},
+	}
+
+	generator := NewToolGenerator(config, mockLLM, prompts.NewManager("../../prompts"))
+	bullets, metrics, err := generator.GenerateLectureRecap(context.Background(), "Cells", "Today we talk about cells.", "en-US", models.GenerationOptions{})
+	if err != nil {
+		tester.Fatalf("Recap failed: %v", err)
+	}
+
+	if len(bullets) != LectureRecapBulletCount || bullets[0] != "Cells are the unit of life." || bullets[4] != "Ribosomes build proteins." {
+		tester.Errorf("Expected five cleaned bullets, got %q", bullets)
+	}
+	if metrics.EstimatedCost != 0.002 {
+		tester.Errorf("Expected metrics to be returned, got %f", metrics.EstimatedCost)
+	}
+	prompt := mockLLM.Histories[0][0].Content[0].Text
+	if !strings.Contains(prompt, "exactly 5 bullets") || !strings.Contains(prompt, "Today we talk about cells.") {
+		tester.Errorf("Prompt does not carry the bullet count and content: %s", prompt)
+	}
+}
+
+func TestToolGenerator_LocateCitationRegion(tester *testing.T) {
+	config := &configuration.Configuration{LLM: configuration.LLMConfiguration{Model: "test-model"}}
+	imagePath := filepath.Join(tester.TempDir(), "page.png")
+	os.WriteFile(imagePath, []byte("png"), 0644)
+
+	mockLLM := &UnbreakableSequentialMock{
+		Responses: []string{
+			`{"found": true, "left": 0.1, "top": 0.4, "width": 0.8, "height": 0.2}`,
+			`{"found": true, "left": 0, "top": 0, "width": 1, "height": 0.95}`,
+			`{"found": false}`,
+			`{"found": true, "left": 0.5, "top": 0.5, "width": 0.8, "height": 0.2}`,
+		},
+		Costs: []float64{0.001, 0.001, 0.001, 0.001},
+	}
+	generator := NewToolGenerator(config, mockLLM, prompts.NewManager("../../prompts"))
+
+	region, metrics, err := generator.LocateCitationRegion(context.Background(), imagePath, 3, "Mitochondria produce ATP")
+	if err != nil || region == nil {
+		tester.Fatalf("Expected a region, got %v (%v)", region, err)
+	}
+	if region.Page != 3 || region.Top != 0.4 || region.Height != 0.2 || metrics.EstimatedCost != 0.001 {
+		tester.Errorf("Unexpected region %+v", region)
+	}
+	message := mockLLM.Histories[0][0]
+	if len(message.Content) != 2 || message.Content[1].Type != "image" || !strings.HasPrefix(message.Content[1].ImageURL, "data:image/png;base64,") {
+		tester.Errorf("Expected the page image to be sent with the prompt, got %+v", message.Content)
+	}
+	if !strings.Contains(message.Content[0].Text, "Mitochondria produce ATP") || !strings.Contains(message.Content[0].Text, "page 3") {
+		tester.Errorf("Prompt does not carry the claim and page: %s", message.Content[0].Text)
+	}
+
+	for _, expectation := range []string{"most of the page", "not found"} {
+		if region, _, err := generator.LocateCitationRegion(context.Background(), imagePath, 3, "claim"); err != nil || region != nil {
+			tester.Errorf("Expected the whole page to be kept when the region is %s, got %+v (%v)", expectation, region, err)
+		}
+	}
+	if _, _, err := generator.LocateCitationRegion(context.Background(), imagePath, 3, "claim"); err == nil {
+		tester.Errorf("Expected a region outside the page to be rejected")
+	}
+}
+
+func TestToolGenerator_PromptMetadataVariables(tester *testing.T) {
+	generator := &ToolGenerator{}
+	options := models.GenerationOptions{
+		PromptVariants: map[string]models.PromptVariant{
+			prompts.PromptGenerateQuiz: {Content: "Course {{metadata_course_code}} by {{metadata_professor}}{{metadata_semester}} on {{topic}}\n{{metadata_fields}}"},
+		},
+		TemplateVariables: models.MetadataTemplateVariables([]models.MetadataField{
+			{Name: "Professor", Value: "Dr. Lens"},
+			{Name: "Course code", Value: "PHY-201"},
+		}),
+	}
+
+	prompt, err := generator.getPrompt(options, prompts.PromptGenerateQuiz, map[string]string{"topic": "optics"})
+	if err != nil {
+		tester.Fatalf("getPrompt failed: %v", err)
+	}
+	expected := "Course PHY-201 by Dr. Lens on optics\nProfessor: Dr. Lens\nCourse code: PHY-201"
+	if prompt != expected {
+		tester.Errorf("Expected %q, got %q", expected, prompt)
+	}
+
+	// The stock outline and study guide prompts give the model the course details
+	generator = &ToolGenerator{promptManager: prompts.NewManager("../../prompts")}
+	for _, promptPath := range []string{prompts.PromptAnalyzeLectureStructure, prompts.PromptStudyGuideInitialContext} {
+		prompt, err := generator.getPrompt(options, promptPath, nil)
+		if err != nil || !strings.Contains(prompt, "Professor: Dr. Lens\nCourse code: PHY-201") || strings.Contains(prompt, "{{metadata_fields}}") {
+			tester.Errorf("Expected %s to hold the course details, got %v", promptPath, err)
+		}
+	}
+}
+
+func TestToolGenerator_ExtractSyllabus(tester *testing.T) {
+	config := &configuration.Configuration{LLM: configuration.LLMConfiguration{Model: "test-model"}}
+
+	mockLLM := &UnbreakableSequentialMock{
+		Responses: []string{`{"title": " Introduction to Optics ", "description": "Lenses and waves.", "lectures": [
+			{"title": "Reflection and refraction", "description": "Chapter 1", "date": "2026-09-14"},
+			{"title": " ", "date": "2026-09-16"},
+			{"title": "Thin lenses", "date": "Monday"}
+		]}`},
+		Costs: []float64{0.003},
+	}
+
+	generator := NewToolGenerator(config, mockLLM, prompts.NewManager("../../prompts"))
+	syllabus, metrics, err := generator.ExtractSyllabus(context.Background(), "--- Page 1 ---\nPHYS 201 Optics, Fall 2026", "en-US", models.GenerationOptions{})
+	if err != nil {
+		tester.Fatalf("Extraction failed: %v", err)
+	}
+
+	if syllabus.Title != "Introduction to Optics" || len(syllabus.Lectures) != 2 {
+		tester.Fatalf("Expected the title and two titled lectures, got %+v", syllabus)
+	}
+	if syllabus.Lectures[0].Date == nil || syllabus.Lectures[0].Date.Format("2006-01-02") != "2026-09-14" || syllabus.Lectures[0].Description != "Chapter 1" {
+		tester.Errorf("Expected the first lecture dated with its description, got %+v", syllabus.Lectures[0])
+	}
+	if syllabus.Lectures[1].Title != "Thin lenses" || syllabus.Lectures[1].Date != nil {
+		tester.Errorf("Expected the weekday to be left out as a date, got %+v", syllabus.Lectures[1])
+	}
+	if metrics.EstimatedCost != 0.003 {
+		tester.Errorf("Expected metrics to be returned, got %f", metrics.EstimatedCost)
+	}
+	prompt := mockLLM.Histories[0][0].Content[0].Text
+	if !strings.Contains(prompt, "PHYS 201 Optics, Fall 2026") || strings.Contains(prompt, "{{content}}") {
+		tester.Errorf("Prompt does not carry the syllabus: %s", prompt)
+	}
+}
+
+func TestToolGenerator_GenerateCourseOverview(tester *testing.T) {
+	config := &configuration.Configuration{LLM: configuration.LLMConfiguration{Model: "test-model"}}
+
+	mockLLM := &UnbreakableSequentialMock{
+		Responses: []string{
+			`# Optics
+
+## Light and Lenses
+
+**Coverage:** Reflection and refraction
+
+**Lectures:** Lecture 1 and lecture 2`,
+			`{"title": "Optics"}`,
+			`## Light and Lenses
+Mirrors reflect light {{{Law of reflection-mirrors.pdf-p2}}} and lenses refract it {{{Snell law-lenses.pdf-p1}}}.`,
+			`{"coverage_score": 95}`,
+		},
+		Costs: []float64{0.01, 0, 0.02, 0.005},
+	}
+	generator := NewToolGenerator(config, mockLLM, prompts.NewManager("../../prompts"))
+
+	lectures := []CourseLecture{
+		{Title: "Mirrors", Transcript: "Today we look at mirrors.", DocumentNames: []string{"mirrors.pdf"}, ReferenceMaterials: "# Reference File: `mirrors.pdf`\n\n## Page 2\n\nThe angle of incidence equals the angle of reflection."},
+		{Title: "Lenses", Transcript: "Lenses bend light.", DocumentNames: []string{"lenses.pdf"}, ReferenceMaterials: "# Reference File: `lenses.pdf`\n\n## Page 1\n\nSnell's law relates the angles."},
+	}
+	var phases []string
+	threshold, maximumRetries := 70, 1
+	content, title, metrics, err := generator.GenerateCourseOverview(context.Background(), "Physics II", lectures, []CourseOverlap{{Topic: "Refraction", Lectures: []int{1, 2}, CitedLecture: 2, CitedDocument: "lenses.pdf", CitedPage: 1}}, "short", "en", models.GenerationOptions{AdherenceThreshold: &threshold, MaximumRetries: &maximumRetries}, func(progress int, message string, metadata any, metrics models.JobMetrics) {
+		if buildProgress, ok := metadata.(models.BuildProgress); ok {
+			phases = append(phases, buildProgress.Phase)
+		}
+	})
+	if err != nil {
+		tester.Fatalf("Course overview failed: %v", err)
+	}
+
+	if title != "Optics" {
+		tester.Errorf("Expected the title of the outline, got %q", title)
+	}
+	if !strings.Contains(content, "{{{Law of reflection-mirrors.pdf-p2}}}") || !strings.Contains(content, "{{{Snell law-lenses.pdf-p1}}}") {
+		tester.Errorf("Expected the citations of both lectures to be kept, got:\n%s", content)
+	}
+	if metrics.EstimatedCost < 0.034 {
+		tester.Errorf("Expected the costs of every call, got %f", metrics.EstimatedCost)
+	}
+	if len(phases) < 2 || phases[0] != models.BuildPhaseAnalyzingStructure || phases[1] != models.BuildPhaseGeneratingSections {
+		tester.Errorf("Unexpected phases %v", phases)
+	}
+
+	outlinePrompt := mockLLM.Histories[0][0].Content[0].Text
+	for _, expected := range []string{"course overview", "# Lecture 1: Mirrors", "# Lecture 2: Lenses", "Reference files: `lenses.pdf`", "Today we look at mirrors.", "Snell's law relates the angles.", "\"Refraction\", repeated in lectures 1, 2: cite `lenses.pdf` page 1"} {
+		if !strings.Contains(outlinePrompt, expected) {
+			tester.Errorf("Outline prompt is missing %q", expected)
+		}
+	}
+	if strings.Index(outlinePrompt, "Lecture 1: Mirrors") > strings.Index(outlinePrompt, "Lecture 2: Lenses") {
+		tester.Error("Expected the lectures in the order they were given")
+	}
+
+	if _, _, _, err := generator.GenerateCourseOverview(context.Background(), "Physics II", nil, nil, "short", "en", models.GenerationOptions{}, func(int, string, any, models.JobMetrics) {}); err == nil {
+		tester.Error("Expected a course of no lectures to be rejected")
+	}
+}
+
+func TestToolGenerator_GenerateMockExam(tester *testing.T) {
+	config := &configuration.Configuration{LLM: configuration.LLMConfiguration{Model: "test-model"}}
+	settings := models.MockExamSettings{MultipleChoice: 2, Problem: 1, Difficulty: models.MockExamDifficulty{Easy: 50, Hard: 50}, DurationMinutes: 30, TotalPoints: 10}
+	if err := settings.Normalize(); err != nil {
+		tester.Fatalf("Normalize failed: %v", err)
+	}
+	if counts := settings.DifficultyCounts(); counts["easy"]+counts["hard"] != 3 || counts["medium"] != 0 {
+		tester.Fatalf("Expected the questions split between easy and hard, got %v", counts)
+	}
+
+	options := `"options": ["Light", "Sound", "Heat", "Mass"], "correct_answer": "B"`
+	mockLLM := &UnbreakableSequentialMock{
+		Responses: []string{
+			// Points adding up to 9, a short answer instead of a problem, and the second lecture never examined
+			`{"questions": [
+				{"type": "multiple_choice", "difficulty": "easy", "points": 2, "lecture": 1, "question": "Q1", ` + options + `, "explanation": "E"},
+				{"type": "multiple_choice", "difficulty": "easy", "points": 2, "lecture": 1, "question": "Q2", ` + options + `, "explanation": "E"},
+				{"type": "short_answer", "difficulty": "hard", "points": 5, "lecture": 1, "question": "Q3", "correct_answer": "A", "explanation": "E"}
+			]}`,
+			`{"questions": [
+				{"type": "multiple_choice", "difficulty": "easy", "points": 2, "lecture": 1, "question": "Q1", ` + options + `, "explanation": "E"},
+				{"type": "multiple_choice", "difficulty": "Hard", "points": 2, "lecture": 2, "question": "Q2", ` + options + `, "explanation": "E"},
+				{"type": "problem", "difficulty": "hard", "points": 6, "lecture": 2, "question": "Q3", "correct_answer": "Worked solution", "explanation": "E"}
+			]}`,
+		},
+	}
+
+	generator := NewToolGenerator(config, mockLLM, prompts.NewManager("../../prompts"))
+	lectures := []CourseLecture{{Title: "Optics", Transcript: "Light refracts."}, {Title: "Acoustics", Transcript: "Sound echoes."}}
+	content, title, _, err := generator.GenerateMockExam(context.Background(), "Physics", lectures, settings, "en-US", models.GenerationOptions{}, nil)
+	if err != nil {
+		tester.Fatalf("Expected a repaired mock exam, got error: %v", err)
+	}
+	if title != "Physics" || !strings.Contains(content, `"duration_minutes":30`) || !strings.Contains(content, `"lecture":"Acoustics"`) || !strings.Contains(content, `"correct_answer":"Sound"`) {
+		tester.Errorf("Unexpected mock exam %q: %s", title, content)
+	}
+	prompt := mockLLM.Histories[0][0].Content[0].Text
+	for _, expected := range []string{"exactly 2 `multiple_choice`, 0 `short_answer` and 1 `problem`", "up to exactly 10", "# Lecture 2: Acoustics"} {
+		if !strings.Contains(prompt, expected) {
+			tester.Errorf("Expected %q in the prompt", expected)
+		}
+	}
+	repairPrompt := mockLLM.Histories[1][2].Content[0].Text
+	for _, expected := range []string{"needs exactly 1", "add up to 9", "lecture 2 (Acoustics)"} {
+		if !strings.Contains(repairPrompt, expected) {
+			tester.Errorf("Expected %q among the issues of the repair prompt: %s", expected, repairPrompt)
+		}
+	}
+
+	invalid := models.MockExamSettings{MultipleChoice: 5, TotalPoints: 3}
+	if err := invalid.Normalize(); err == nil {
+		tester.Error("Expected fewer points than questions to be refused")
+	}
+}
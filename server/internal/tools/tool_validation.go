@@ -0,0 +1,616 @@
+package tools
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"lectures/internal/llm"
+	"lectures/internal/models"
+	"lectures/internal/prompts"
+)
+
+const (
+	flashcardSchemaDescription = `A JSON array of objects, each with a non-empty "front" string (the question or term) and a non-empty "back" string (the answer or definition).`
+	quizSchemaDescription      = `A JSON array of objects, each with a "type" string, a non-empty "question" string and a non-empty "explanation" string. ` +
+		`A "multiple_choice" question has an "options" array of exactly 4 distinct non-empty strings and a "correct_answer" string equal to the exact text of one of the options. ` +
+		`A "matching" question has a "pairs" array of 3 to 8 objects with distinct "left" and "right" strings. ` +
+		`An "ordering" question has an "items" array of 3 to 8 distinct strings in their correct order. ` +
+		`A "numeric" question has a number "answer", a non-negative number "tolerance" and an optional "unit" string. ` +
+		`A "free_response" question has a "correct_answer" string holding a model answer and a "rubric" array of 1 to 6 objects with a "criterion" string and positive integer "points".`
+	mindmapSchemaDescription = `A JSON object with a "nodes" array of 3 to 40 objects, each with a unique non-empty "id" string, a non-empty "label" string and a "description" string, ` +
+		`and an "edges" array of objects, each with "from" and "to" strings naming the ids of two different nodes and a "label" string. Every node must be the end of at least one edge.`
+
+	// maximumReportedIssues bounds how many problems are listed in a repair request
+	maximumReportedIssues = 20
+
+	// minimumQuizListLength and maximumQuizListLength bound the pairs of a matching question and the items of an
+	// ordering question
+	minimumQuizListLength = 3
+	maximumQuizListLength = 8
+
+	// maximumRubricCriteria bounds the rubric of a free response question
+	maximumRubricCriteria = 6
+
+	// minimumMindmapNodes and maximumMindmapNodes bound the concepts of a mindmap, which stops being readable as a
+	// single diagram beyond a few dozen
+	minimumMindmapNodes = 3
+	maximumMindmapNodes = 40
+)
+
+// ValidateFlashcards parses generated flashcard JSON into normalized cards, returning every problem found
+func ValidateFlashcards(content string) ([]models.Flashcard, []string) {
+	items, err := parseJSONArrayOfObjects(content)
+	if err != nil {
+		return nil, []string{err.Error()}
+	}
+
+	var flashcards []models.Flashcard
+	var issues []string
+	seenFronts := make(map[string]bool)
+
+	for index, item := range items {
+		front := stringField(item, "front")
+		back := stringField(item, "back")
+
+		if front == "" {
+			issues = append(issues, fmt.Sprintf("card %d: \"front\" is missing or empty", index+1))
+		}
+		if back == "" {
+			issues = append(issues, fmt.Sprintf("card %d: \"back\" is missing or empty", index+1))
+		}
+		if front == "" || back == "" {
+			continue
+		}
+
+		// Identical cards add nothing to a deck, so they are dropped rather than reported
+		normalizedFront := normalizeComparableText(front)
+		if seenFronts[normalizedFront] {
+			continue
+		}
+		seenFronts[normalizedFront] = true
+
+		flashcards = append(flashcards, models.Flashcard{Front: front, Back: back})
+	}
+
+	if len(issues) == 0 && len(flashcards) == 0 {
+		issues = append(issues, "the array contains no flashcards")
+	}
+
+	return flashcards, issues
+}
+
+// ValidateQuiz parses generated quiz JSON into normalized questions, returning every problem found. Questions
+// without a type are multiple choice, and their correct answer is rewritten to the exact option text when given
+// as a letter, position, or loose match
+func ValidateQuiz(content string) ([]models.QuizQuestion, []string) {
+	items, err := parseJSONArrayOfObjects(content)
+	if err != nil {
+		return nil, []string{err.Error()}
+	}
+
+	var questions []models.QuizQuestion
+	var issues []string
+
+	for index, item := range items {
+		questionNumber := index + 1
+		questionIssueCount := len(issues)
+
+		question := models.QuizQuestion{
+			Type:        normalizeQuizQuestionType(stringField(item, "type")),
+			Question:    stringField(item, "question"),
+			Explanation: stringField(item, "explanation"),
+		}
+		if question.Question == "" {
+			issues = append(issues, fmt.Sprintf("question %d: \"question\" is missing or empty", questionNumber))
+		}
+		if question.Explanation == "" {
+			issues = append(issues, fmt.Sprintf("question %d: \"explanation\" is missing or empty", questionNumber))
+		}
+
+		var typeIssues []string
+		switch question.Type {
+		case models.QuizQuestionMultipleChoice:
+			typeIssues = validateMultipleChoiceQuestion(item, &question)
+		case models.QuizQuestionMatching:
+			typeIssues = validateMatchingQuestion(item, &question)
+		case models.QuizQuestionOrdering:
+			typeIssues = validateOrderingQuestion(item, &question)
+		case models.QuizQuestionNumeric:
+			typeIssues = validateNumericQuestion(item, &question)
+		case models.QuizQuestionFreeResponse:
+			typeIssues = validateFreeResponseQuestion(item, &question)
+		default:
+			typeIssues = []string{fmt.Sprintf("\"type\" %q is not one of %s", question.Type, strings.Join(quizQuestionTypes, ", "))}
+		}
+		for _, issue := range typeIssues {
+			issues = append(issues, fmt.Sprintf("question %d: %s", questionNumber, issue))
+		}
+
+		if len(issues) > questionIssueCount {
+			continue
+		}
+		questions = append(questions, question)
+	}
+
+	if len(issues) == 0 && len(questions) == 0 {
+		issues = append(issues, "the array contains no questions")
+	}
+
+	return questions, issues
+}
+
+// ValidateMindmap parses generated mindmap JSON into a normalized graph, returning every problem found. Repeated
+// edges between the same concepts are dropped rather than reported
+func ValidateMindmap(content string) (models.Mindmap, []string) {
+	mindmap := models.Mindmap{Nodes: []models.MindmapNode{}, Edges: []models.MindmapEdge{}}
+	object, err := parseJSONObject(content)
+	if err != nil {
+		return mindmap, []string{err.Error()}
+	}
+	rawNodes, _ := object["nodes"].([]any)
+	nodes, err := objectsFromArray(rawNodes)
+	if err != nil {
+		return mindmap, []string{"\"nodes\": " + err.Error()}
+	}
+	rawEdges, _ := object["edges"].([]any)
+	edges, err := objectsFromArray(rawEdges)
+	if err != nil {
+		return mindmap, []string{"\"edges\": " + err.Error()}
+	}
+
+	var issues []string
+	if len(nodes) < minimumMindmapNodes || len(nodes) > maximumMindmapNodes {
+		issues = append(issues, fmt.Sprintf("the map has %d nodes, but needs between %d and %d", len(nodes), minimumMindmapNodes, maximumMindmapNodes))
+	}
+
+	seenIDs := make(map[string]bool)
+	seenLabels := make(map[string]bool)
+	for index, item := range nodes {
+		node := models.MindmapNode{ID: identifierField(item, "id"), Label: stringField(item, "label"), Description: stringField(item, "description")}
+		switch {
+		case node.ID == "":
+			issues = append(issues, fmt.Sprintf("node %d: \"id\" is missing or empty", index+1))
+		case seenIDs[node.ID]:
+			issues = append(issues, fmt.Sprintf("node %d: the id %q is used by another node", index+1, node.ID))
+		}
+		if node.Label == "" {
+			issues = append(issues, fmt.Sprintf("node %d: \"label\" is missing or empty", index+1))
+		} else if seenLabels[normalizeComparableText(node.Label)] {
+			issues = append(issues, fmt.Sprintf("node %d: the concept %q appears twice", index+1, node.Label))
+		}
+		seenIDs[node.ID] = true
+		seenLabels[normalizeComparableText(node.Label)] = true
+		mindmap.Nodes = append(mindmap.Nodes, node)
+	}
+
+	connectedIDs := make(map[string]bool)
+	seenEdges := make(map[[2]string]bool)
+	for index, item := range edges {
+		edge := models.MindmapEdge{From: identifierField(item, "from"), To: identifierField(item, "to"), Label: stringField(item, "label")}
+		edgeIssueCount := len(issues)
+		if !seenIDs[edge.From] {
+			issues = append(issues, fmt.Sprintf("edge %d: \"from\" %q is not the id of a node", index+1, edge.From))
+		}
+		if !seenIDs[edge.To] {
+			issues = append(issues, fmt.Sprintf("edge %d: \"to\" %q is not the id of a node", index+1, edge.To))
+		}
+		if edge.From == edge.To && edge.From != "" {
+			issues = append(issues, fmt.Sprintf("edge %d: links the node %q to itself", index+1, edge.From))
+		}
+		if len(issues) > edgeIssueCount || seenEdges[[2]string{edge.From, edge.To}] {
+			continue
+		}
+		seenEdges[[2]string{edge.From, edge.To}] = true
+		connectedIDs[edge.From], connectedIDs[edge.To] = true, true
+		mindmap.Edges = append(mindmap.Edges, edge)
+	}
+
+	for _, node := range mindmap.Nodes {
+		if node.ID != "" && !connectedIDs[node.ID] {
+			issues = append(issues, fmt.Sprintf("node %q (%s) is not linked to any other node", node.ID, node.Label))
+			// A repeated id is reported once
+			connectedIDs[node.ID] = true
+		}
+	}
+
+	return mindmap, issues
+}
+
+// quizQuestionTypes lists the question types a quiz may contain
+var quizQuestionTypes = []string{
+	models.QuizQuestionMultipleChoice,
+	models.QuizQuestionMatching,
+	models.QuizQuestionOrdering,
+	models.QuizQuestionNumeric,
+	models.QuizQuestionFreeResponse,
+}
+
+// normalizeQuizQuestionType maps spellings such as "Free response" or "multiple-choice" onto a question type,
+// defaulting to multiple choice
+func normalizeQuizQuestionType(rawType string) string {
+	if rawType == "" {
+		return models.QuizQuestionMultipleChoice
+	}
+	return strings.NewReplacer(" ", "_", "-", "_").Replace(strings.ToLower(rawType))
+}
+
+// validateMultipleChoiceQuestion reads 4 distinct options and resolves the correct answer among them
+func validateMultipleChoiceQuestion(item map[string]any, question *models.QuizQuestion) []string {
+	rawOptions, isArray := item["options"].([]any)
+	if !isArray {
+		return []string{"\"options\" must be an array of 4 strings"}
+	}
+
+	var issues []string
+	seenOptions := make(map[string]bool)
+	for _, rawOption := range rawOptions {
+		option, isString := rawOption.(string)
+		option = strings.TrimSpace(option)
+		if !isString || option == "" {
+			issues = append(issues, "every option must be a non-empty string")
+			break
+		}
+		if seenOptions[normalizeComparableText(option)] {
+			issues = append(issues, fmt.Sprintf("option %q appears more than once", option))
+			break
+		}
+		seenOptions[normalizeComparableText(option)] = true
+		question.Options = append(question.Options, option)
+	}
+	if len(rawOptions) != 4 {
+		issues = append(issues, fmt.Sprintf("expected exactly 4 options, got %d", len(rawOptions)))
+	}
+	if len(issues) > 0 {
+		return issues
+	}
+
+	question.CorrectAnswer = resolveCorrectAnswer(item["correct_answer"], question.Options)
+	if question.CorrectAnswer == "" {
+		return []string{"\"correct_answer\" does not match any of the options"}
+	}
+	return nil
+}
+
+// validateMatchingQuestion reads the pairs of a matching question; left items, and right items, must be distinct
+// so that every left item has a single match
+func validateMatchingQuestion(item map[string]any, question *models.QuizQuestion) []string {
+	rawPairs, isArray := item["pairs"].([]any)
+	if !isArray || len(rawPairs) < minimumQuizListLength || len(rawPairs) > maximumQuizListLength {
+		return []string{fmt.Sprintf("\"pairs\" must be an array of %d to %d objects with \"left\" and \"right\" strings", minimumQuizListLength, maximumQuizListLength)}
+	}
+
+	seenLeft, seenRight := make(map[string]bool), make(map[string]bool)
+	for _, rawPair := range rawPairs {
+		pairObject, isObject := rawPair.(map[string]any)
+		if !isObject {
+			return []string{"every pair must be an object with \"left\" and \"right\" strings"}
+		}
+		pair := models.QuizPair{Left: stringField(pairObject, "left"), Right: stringField(pairObject, "right")}
+		if pair.Left == "" || pair.Right == "" {
+			return []string{"every pair needs a non-empty \"left\" and \"right\""}
+		}
+		if seenLeft[normalizeComparableText(pair.Left)] || seenRight[normalizeComparableText(pair.Right)] {
+			return []string{fmt.Sprintf("the pair %q - %q repeats an item of another pair", pair.Left, pair.Right)}
+		}
+		seenLeft[normalizeComparableText(pair.Left)], seenRight[normalizeComparableText(pair.Right)] = true, true
+		question.Pairs = append(question.Pairs, pair)
+	}
+	return nil
+}
+
+// validateOrderingQuestion reads the distinct items of an ordering question, listed in their correct order
+func validateOrderingQuestion(item map[string]any, question *models.QuizQuestion) []string {
+	rawItems, isArray := item["items"].([]any)
+	if !isArray || len(rawItems) < minimumQuizListLength || len(rawItems) > maximumQuizListLength {
+		return []string{fmt.Sprintf("\"items\" must be an array of %d to %d strings in their correct order", minimumQuizListLength, maximumQuizListLength)}
+	}
+
+	seenItems := make(map[string]bool)
+	for _, rawItem := range rawItems {
+		orderedItem, _ := rawItem.(string)
+		orderedItem = strings.TrimSpace(orderedItem)
+		if orderedItem == "" {
+			return []string{"every item must be a non-empty string"}
+		}
+		if seenItems[normalizeComparableText(orderedItem)] {
+			return []string{fmt.Sprintf("item %q appears more than once", orderedItem)}
+		}
+		seenItems[normalizeComparableText(orderedItem)] = true
+		question.Items = append(question.Items, orderedItem)
+	}
+	return nil
+}
+
+// validateNumericQuestion reads the answer, tolerance and unit of a numeric question. A missing tolerance only
+// accepts the exact answer
+func validateNumericQuestion(item map[string]any, question *models.QuizQuestion) []string {
+	var issues []string
+	answer, isNumber := numberField(item, "answer")
+	if !isNumber {
+		issues = append(issues, "\"answer\" must be a number")
+	} else {
+		question.Answer = &answer
+	}
+
+	if _, hasTolerance := item["tolerance"]; hasTolerance {
+		tolerance, isNumber := numberField(item, "tolerance")
+		if !isNumber || tolerance < 0 {
+			issues = append(issues, "\"tolerance\" must be a non-negative number")
+		}
+		question.Tolerance = tolerance
+	}
+	question.Unit = stringField(item, "unit")
+	return issues
+}
+
+// validateFreeResponseQuestion reads the model answer and the rubric a free response is graded with
+func validateFreeResponseQuestion(item map[string]any, question *models.QuizQuestion) []string {
+	var issues []string
+	question.CorrectAnswer = stringField(item, "correct_answer")
+	if question.CorrectAnswer == "" {
+		issues = append(issues, "\"correct_answer\" must hold a model answer")
+	}
+
+	rawCriteria, isArray := item["rubric"].([]any)
+	if !isArray || len(rawCriteria) == 0 || len(rawCriteria) > maximumRubricCriteria {
+		return append(issues, fmt.Sprintf("\"rubric\" must be an array of 1 to %d objects with a \"criterion\" string and positive integer \"points\"", maximumRubricCriteria))
+	}
+	for _, rawCriterion := range rawCriteria {
+		criterionObject, isObject := rawCriterion.(map[string]any)
+		if !isObject {
+			return append(issues, "every rubric entry must be an object with \"criterion\" and \"points\"")
+		}
+		criterion := models.QuizRubricCriterion{Criterion: stringField(criterionObject, "criterion")}
+		points, isNumber := numberField(criterionObject, "points")
+		if criterion.Criterion == "" || !isNumber || points < 1 || points != float64(int(points)) {
+			return append(issues, "every rubric entry needs a non-empty \"criterion\" and positive integer \"points\"")
+		}
+		criterion.Points = int(points)
+		question.Rubric = append(question.Rubric, criterion)
+	}
+	return issues
+}
+
+// resolveCorrectAnswer maps the model's correct_answer onto the exact text of one of the options
+func resolveCorrectAnswer(rawAnswer any, options []string) string {
+	switch answer := rawAnswer.(type) {
+	case float64:
+		// A bare number is the position of the option, counted from 1 as models count them
+		optionNumber := int(answer)
+		if float64(optionNumber) == answer && optionNumber >= 1 && optionNumber <= len(options) {
+			return options[optionNumber-1]
+		}
+		return ""
+	case string:
+		answerText := strings.TrimSpace(answer)
+		if answerText == "" {
+			return ""
+		}
+
+		for _, option := range options {
+			if option == answerText {
+				return option
+			}
+		}
+
+		normalizedAnswer := normalizeComparableText(answerText)
+		for _, option := range options {
+			if normalizeComparableText(option) == normalizedAnswer {
+				return option
+			}
+		}
+
+		// Letter answers such as "B", "b)", "C." or "D) Golgi apparatus"
+		letter := strings.ToUpper(answerText[:1])
+		if letter >= "A" && letter <= "D" && len(options) == 4 {
+			remainder := strings.TrimSpace(strings.TrimLeft(answerText[1:], ").:"))
+			hasSeparator := len(answerText) == 1 || strings.ContainsAny(answerText[1:2], ").: ")
+			if hasSeparator {
+				option := options[letter[0]-'A']
+				if remainder == "" || normalizeComparableText(remainder) == normalizeComparableText(option) {
+					return option
+				}
+			}
+		}
+	}
+
+	return ""
+}
+
+// parseJSONArrayOfObjects extracts the array of objects from a model response, tolerating
+// surrounding prose, Markdown fences, and a single wrapping object such as {"questions": [...]}
+func parseJSONArrayOfObjects(content string) ([]map[string]any, error) {
+	trimmedContent := strings.TrimSpace(content)
+
+	var items []map[string]any
+	if err := json.Unmarshal([]byte(trimmedContent), &items); err == nil {
+		return items, nil
+	}
+
+	var wrapper map[string]any
+	if err := json.Unmarshal([]byte(trimmedContent), &wrapper); err == nil {
+		for _, value := range wrapper {
+			if wrappedItems, isArray := value.([]any); isArray {
+				return objectsFromArray(wrappedItems)
+			}
+		}
+		return nil, fmt.Errorf("the response is a JSON object, but a JSON array is required")
+	}
+
+	start := strings.Index(trimmedContent, "[")
+	end := strings.LastIndex(trimmedContent, "]")
+	if start == -1 || end <= start {
+		return nil, fmt.Errorf("the response does not contain a JSON array")
+	}
+
+	var rawItems []any
+	if err := json.Unmarshal([]byte(trimmedContent[start:end+1]), &rawItems); err != nil {
+		return nil, fmt.Errorf("the response is not valid JSON: %v", err)
+	}
+
+	return objectsFromArray(rawItems)
+}
+
+// parseJSONObject extracts the object from a model response, tolerating surrounding prose and Markdown fences
+func parseJSONObject(content string) (map[string]any, error) {
+	trimmedContent := strings.TrimSpace(content)
+
+	var object map[string]any
+	if err := json.Unmarshal([]byte(trimmedContent), &object); err == nil {
+		return object, nil
+	}
+
+	start := strings.Index(trimmedContent, "{")
+	end := strings.LastIndex(trimmedContent, "}")
+	if start == -1 || end <= start {
+		return nil, fmt.Errorf("the response does not contain a JSON object")
+	}
+	if err := json.Unmarshal([]byte(trimmedContent[start:end+1]), &object); err != nil {
+		return nil, fmt.Errorf("the response is not valid JSON: %v", err)
+	}
+	return object, nil
+}
+
+func objectsFromArray(rawItems []any) ([]map[string]any, error) {
+	items := make([]map[string]any, 0, len(rawItems))
+	for index, rawItem := range rawItems {
+		item, isObject := rawItem.(map[string]any)
+		if !isObject {
+			return nil, fmt.Errorf("item %d of the array is not a JSON object", index+1)
+		}
+		items = append(items, item)
+	}
+	return items, nil
+}
+
+func stringField(item map[string]any, key string) string {
+	value, _ := item[key].(string)
+	return strings.TrimSpace(value)
+}
+
+// identifierField reads an identifier, also accepted as a number such as 3
+func identifierField(item map[string]any, key string) string {
+	if number, isNumber := item[key].(float64); isNumber {
+		return strconv.FormatFloat(number, 'f', -1, 64)
+	}
+	return stringField(item, key)
+}
+
+// numberField reads a number, also accepted as a numeric string such as "9.81"
+func numberField(item map[string]any, key string) (float64, bool) {
+	switch value := item[key].(type) {
+	case float64:
+		return value, true
+	case string:
+		number, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+		return number, err == nil && !math.IsNaN(number) && !math.IsInf(number, 0)
+	}
+	return 0, false
+}
+
+// normalizeComparableText lowercases and collapses whitespace so near-identical strings compare equal
+func normalizeComparableText(text string) string {
+	return strings.ToLower(strings.Join(strings.Fields(text), " "))
+}
+
+// generateValidatedJSON calls the model and validates its output, asking the model to repair its own
+// response whenever validation fails. The normalized structure returned by validate is stored as compact JSON
+func (generator *ToolGenerator) generateValidatedJSON(jobContext context.Context, prompt, model, toolType, schemaDescription string, options models.GenerationOptions, onAttempt func(attempt int, maximumAttempts int, metrics models.JobMetrics), validate func(string) (any, []string)) (string, models.JobMetrics, error) {
+	var metrics models.JobMetrics
+
+	maximumRetries := generator.maximumAttempts(options)
+
+	currentPrompt := prompt
+	var history []llm.Message
+	var lastIssues []string
+
+	for attempt := 1; attempt <= maximumRetries; attempt++ {
+		if onAttempt != nil {
+			onAttempt(attempt, maximumRetries, metrics)
+		}
+		response, stepMetrics, err := generator.callLLMWithHistoryAndModel(jobContext, currentPrompt, history, model)
+		metrics.InputTokens += stepMetrics.InputTokens
+		metrics.OutputTokens += stepMetrics.OutputTokens
+		metrics.EstimatedCost += stepMetrics.EstimatedCost
+		metrics.EstimatedInputTokens += stepMetrics.EstimatedInputTokens
+
+		if err != nil {
+			slog.ErrorContext(jobContext, "LLM call failed", "tool_type", toolType, "attempt", attempt, "error", err)
+			if attempt == maximumRetries {
+				return "", metrics, err
+			}
+			// Backs off before retrying, unless the job is cancelled meanwhile
+			select {
+			case <-time.After(time.Duration(attempt) * time.Second):
+			case <-jobContext.Done():
+				return "", metrics, jobContext.Err()
+			}
+			continue
+		}
+
+		validated, issues := validate(response)
+		if len(issues) == 0 {
+			normalizedContent, err := json.Marshal(validated)
+			if err != nil {
+				return "", metrics, fmt.Errorf("failed to encode validated %s: %w", toolType, err)
+			}
+			if attempt > 1 {
+				slog.InfoContext(jobContext, "Generated JSON repaired", "tool_type", toolType, "attempt", attempt)
+			}
+			return string(normalizedContent), metrics, nil
+		}
+
+		lastIssues = issues
+		slog.WarnContext(jobContext, "Generated JSON failed validation, requesting repair...",
+			"tool_type", toolType,
+			"attempt", attempt,
+			"issues", len(issues),
+			"first_issue", issues[0])
+
+		// The repair always builds on the original request and the latest response only,
+		// so the conversation does not grow with every failed attempt
+		history = []llm.Message{
+			{Role: "user", Content: []llm.ContentPart{{Type: "text", Text: prompt}}},
+			{Role: "assistant", Content: []llm.ContentPart{{Type: "text", Text: response}}},
+		}
+		currentPrompt = generator.buildRepairPrompt(toolType, schemaDescription, issues)
+	}
+
+	return "", metrics, fmt.Errorf("failed to generate a valid %s after %d attempts: %s", toolType, maximumRetries, strings.Join(lastIssues, "; "))
+}
+
+func (generator *ToolGenerator) buildRepairPrompt(toolType, schemaDescription string, issues []string) string {
+	if generator.promptManager == nil {
+		return ""
+	}
+
+	reportedIssues := issues
+	if len(reportedIssues) > maximumReportedIssues {
+		reportedIssues = reportedIssues[:maximumReportedIssues]
+	}
+
+	var issuesBuilder strings.Builder
+	for _, issue := range reportedIssues {
+		issuesBuilder.WriteString("- " + issue + "\n")
+	}
+	if len(issues) > len(reportedIssues) {
+		issuesBuilder.WriteString(fmt.Sprintf("- ...and %d more\n", len(issues)-len(reportedIssues)))
+	}
+
+	prompt, err := generator.promptManager.GetPrompt(prompts.PromptRepairToolJSON, map[string]string{
+		"tool_type": toolType,
+		"issues":    strings.TrimSpace(issuesBuilder.String()),
+		"schema":    schemaDescription,
+	})
+	if err != nil {
+		slog.Warn("Failed to load repair-tool-json prompt, proceeding with empty prompt", "error", err)
+		return ""
+	}
+	return prompt
+}
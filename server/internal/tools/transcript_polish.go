@@ -0,0 +1,66 @@
+package tools
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"lectures/internal/models"
+	"lectures/internal/prompts"
+)
+
+// TranscriptPolishBatchSize is the number of segments sent to the model per polishing call
+const TranscriptPolishBatchSize = 40
+
+// PolishTranscriptSegments fixes punctuation, removes filler words and normalizes terminology in a batch of
+// transcript segments. It returns the polished text keyed by segment ID; segments the model skipped or
+// emptied are left out, so callers keep their raw text
+func (generator *ToolGenerator) PolishTranscriptSegments(jobContext context.Context, segments []models.TranscriptSegment, options models.GenerationOptions) (map[int]string, models.JobMetrics, error) {
+	if len(segments) == 0 {
+		return map[int]string{}, models.JobMetrics{}, nil
+	}
+
+	var segmentsBuilder strings.Builder
+	for index, segment := range segments {
+		fmt.Fprintf(&segmentsBuilder, "%d. %s\n", index+1, strings.TrimSpace(segment.Text))
+	}
+
+	var prompt string
+	if generator.promptManager != nil {
+		prompt, _ = generator.promptManager.GetPrompt(prompts.PromptPolishTranscriptSegments, map[string]string{
+			"segments": segmentsBuilder.String(),
+		})
+	}
+
+	model := options.ModelPolishing
+	if model == "" {
+		model = generator.configuration.LLM.GetModelForTask("content_polishing")
+	}
+
+	response, metrics, err := generator.callLLMWithModel(jobContext, prompt, model)
+	if err != nil {
+		return nil, metrics, err
+	}
+
+	var result struct {
+		Segments []struct {
+			Number int    `json:"number"`
+			Text   string `json:"text"`
+		} `json:"segments"`
+	}
+	if err := generator.unmarshalJSONWithFallback(response, &result); err != nil {
+		return nil, metrics, fmt.Errorf("failed to parse polished segments: %w", err)
+	}
+
+	polishedTexts := make(map[int]string, len(result.Segments))
+	for _, polishedSegment := range result.Segments {
+		index := polishedSegment.Number - 1
+		text := strings.TrimSpace(polishedSegment.Text)
+		if index < 0 || index >= len(segments) || text == "" {
+			continue
+		}
+		polishedTexts[segments[index].ID] = text
+	}
+
+	return polishedTexts, metrics, nil
+}
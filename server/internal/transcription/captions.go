@@ -0,0 +1,96 @@
+package transcription
+
+import (
+	"html"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"lectures/internal/models"
+)
+
+// captionTagPattern matches the inline markup of WebVTT cues: voice, class and timestamp tags
+var captionTagPattern = regexp.MustCompile(`<[^>]*>`)
+
+// ParseWebVTT converts WebVTT captions into transcript segments with times relative to the start of the media.
+// Cue settings, inline markup and notes are dropped, and a cue repeating the text of the previous one extends it
+// instead, since captions often split a sentence displayed for a long time into several identical cues
+func ParseWebVTT(content string) []models.TranscriptSegment {
+	content = strings.ReplaceAll(strings.ReplaceAll(content, "\r\n", "\n"), "\r", "\n")
+
+	var segments []models.TranscriptSegment
+	for _, block := range strings.Split(content, "\n\n") {
+		lines := strings.Split(strings.Trim(block, "\n"), "\n")
+
+		// The timing line may be preceded by a cue identifier; blocks without one are the header, notes and styles
+		timingLine := -1
+		for lineIndex, line := range lines {
+			if strings.Contains(line, "-->") {
+				timingLine = lineIndex
+				break
+			}
+		}
+		if timingLine < 0 {
+			continue
+		}
+
+		timing := strings.SplitN(lines[timingLine], "-->", 2)
+		startMillisecond, startValid := parseCaptionTimestamp(timing[0])
+		endFields := strings.Fields(timing[1])
+		if !startValid || len(endFields) == 0 {
+			continue
+		}
+		endMillisecond, endValid := parseCaptionTimestamp(endFields[0])
+		if !endValid || endMillisecond < startMillisecond {
+			continue
+		}
+
+		var textLines []string
+		for _, line := range lines[timingLine+1:] {
+			if text := strings.TrimSpace(html.UnescapeString(captionTagPattern.ReplaceAllString(line, ""))); text != "" {
+				textLines = append(textLines, text)
+			}
+		}
+		text := strings.Join(textLines, " ")
+		if text == "" {
+			continue
+		}
+
+		if previous := len(segments) - 1; previous >= 0 && segments[previous].Text == text {
+			segments[previous].EndMillisecond = max(segments[previous].EndMillisecond, endMillisecond)
+			segments[previous].OriginalEndMilliseconds = segments[previous].EndMillisecond
+			continue
+		}
+		segments = append(segments, models.TranscriptSegment{
+			StartMillisecond:          startMillisecond,
+			EndMillisecond:            endMillisecond,
+			OriginalStartMilliseconds: startMillisecond,
+			OriginalEndMilliseconds:   endMillisecond,
+			Text:                      text,
+		})
+	}
+	return segments
+}
+
+// parseCaptionTimestamp reads a WebVTT timestamp, hh:mm:ss.ttt or mm:ss.ttt, into milliseconds
+func parseCaptionTimestamp(timestamp string) (int64, bool) {
+	fields := strings.Split(strings.TrimSpace(timestamp), ":")
+	if len(fields) < 2 || len(fields) > 3 {
+		return 0, false
+	}
+	seconds, err := strconv.ParseFloat(strings.Replace(fields[len(fields)-1], ",", ".", 1), 64)
+	if err != nil {
+		return 0, false
+	}
+	total := seconds * 1000
+	multiplier := 60000.0
+	for fieldIndex := len(fields) - 2; fieldIndex >= 0; fieldIndex-- {
+		value, err := strconv.Atoi(fields[fieldIndex])
+		if err != nil {
+			return 0, false
+		}
+		total += float64(value) * multiplier
+		multiplier *= 60
+	}
+	return int64(total + 0.5), true
+}
@@ -0,0 +1,26 @@
+package transcription
+
+import "testing"
+
+func TestParseWebVTT(tester *testing.T) {
+	captions := "WEBVTT\r\nKind: captions\r\nLanguage: en\r\n\r\n" +
+		"NOTE uploaded by the author\r\n\r\n" +
+		"intro\r\n00:00:01.000 --> 00:00:04.500 align:start position:0%\r\n<v Lecturer>Good <c.yellow>morning</c> &amp; welcome</v>\r\n\r\n" +
+		"00:04.500 --> 00:06.000\r\nGood morning &amp; welcome\r\n\r\n" +
+		"01:00:00.000 --> 01:00:02.250\r\nToday we cover\r\nentropy.\r\n\r\n" +
+		"00:00:09.000 --> 00:00:08.000\r\nBackwards cue\r\n"
+
+	segments := ParseWebVTT(captions)
+	if len(segments) != 2 {
+		tester.Fatalf("Expected 2 segments, got %d: %+v", len(segments), segments)
+	}
+	if segments[0].Text != "Good morning & welcome" || segments[0].StartMillisecond != 1000 || segments[0].EndMillisecond != 6000 {
+		tester.Errorf("Expected the repeated cue to extend the first segment, got %+v", segments[0])
+	}
+	if segments[1].Text != "Today we cover entropy." || segments[1].StartMillisecond != 3600000 || segments[1].EndMillisecond != 3602250 {
+		tester.Errorf("Unexpected second segment: %+v", segments[1])
+	}
+	if segments[1].OriginalStartMilliseconds != segments[1].StartMillisecond {
+		tester.Errorf("Expected the original times to match the media times, got %+v", segments[1])
+	}
+}
@@ -0,0 +1,24 @@
+package transcription
+
+import (
+	"fmt"
+
+	"lectures/internal/models"
+)
+
+// ChunkStore persists the result of every transcribed chunk so that an interrupted transcription
+// can resume from the last completed chunk instead of starting over
+type ChunkStore interface {
+	// LoadChunks returns the completed chunks of a media file by chunk index. Chunks saved with a
+	// different layout were cut at other boundaries and must not be returned
+	LoadChunks(mediaID string, layout string) (map[int][]models.TranscriptSegment, error)
+
+	// SaveChunk records the final segments of a chunk, with times relative to the start of the media file
+	SaveChunk(mediaID string, layout string, chunkIndex int, segments []models.TranscriptSegment) error
+}
+
+// chunkLayout identifies how a media file was cut into chunks, so results are only reused
+// when the audio chunk length and the cleanup batch size are unchanged
+func chunkLayout(segmentDurationSeconds int, batchSize int) string {
+	return fmt.Sprintf("%ds/%d", segmentDurationSeconds, batchSize)
+}
@@ -0,0 +1,278 @@
+package transcription
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"lectures/internal/models"
+)
+
+const (
+	deepgramBaseURL = "https://api.deepgram.com/v1/listen"
+	// Pay-as-you-go price for pre-recorded audio (Nova tier), used for cost estimation
+	deepgramPricePerMinute = 0.0043
+)
+
+// DeepgramProvider transcribes audio through the Deepgram pre-recorded API
+type DeepgramProvider struct {
+	apiKey     string
+	model      string
+	baseURL    string
+	httpClient *http.Client
+}
+
+// deepgramWord is a single word with timing as returned by Deepgram
+type deepgramWord struct {
+	Word           string  `json:"word"`
+	PunctuatedWord string  `json:"punctuated_word"`
+	Start          float64 `json:"start"`
+	End            float64 `json:"end"`
+	Confidence     float64 `json:"confidence"`
+	Speaker        *int    `json:"speaker"`
+}
+
+// deepgramResponse is the subset of the Deepgram listen response used here
+type deepgramResponse struct {
+	Metadata struct {
+		Duration float64 `json:"duration"`
+	} `json:"metadata"`
+	Results struct {
+		Channels []struct {
+			DetectedLanguage string `json:"detected_language"`
+			Alternatives     []struct {
+				Transcript string         `json:"transcript"`
+				Confidence float64        `json:"confidence"`
+				Words      []deepgramWord `json:"words"`
+			} `json:"alternatives"`
+		} `json:"channels"`
+		Utterances []struct {
+			Start      float64 `json:"start"`
+			End        float64 `json:"end"`
+			Confidence float64 `json:"confidence"`
+			Transcript string  `json:"transcript"`
+			Speaker    *int    `json:"speaker"`
+		} `json:"utterances"`
+	} `json:"results"`
+}
+
+// NewDeepgramProvider creates a Deepgram transcription provider
+func NewDeepgramProvider(apiKey string, model string) *DeepgramProvider {
+	if model == "" {
+		model = "nova-2"
+	}
+	return &DeepgramProvider{
+		apiKey:     apiKey,
+		model:      model,
+		baseURL:    deepgramBaseURL,
+		httpClient: &http.Client{Timeout: 10 * time.Minute},
+	}
+}
+
+// SetPrompt is a no-op: Deepgram does not accept free-form instructions
+func (provider *DeepgramProvider) SetPrompt(prompt string) {}
+
+func (provider *DeepgramProvider) Name() string {
+	return "deepgram"
+}
+
+func (provider *DeepgramProvider) CheckDependencies() error {
+	if provider.apiKey == "" {
+		return fmt.Errorf("deepgram API key is not configured (providers.deepgram.api_key)")
+	}
+	return nil
+}
+
+func (provider *DeepgramProvider) Transcribe(jobContext context.Context, audioPath string) ([]Segment, models.JobMetrics, error) {
+	var metrics models.JobMetrics
+
+	parsedResponse, listenError := provider.listen(jobContext, audioPath, provider.transcriptionParameters(LanguageHintFromContext(jobContext)))
+	if listenError != nil {
+		return nil, metrics, listenError
+	}
+
+	metrics.EstimatedCost = parsedResponse.Metadata.Duration / 60 * deepgramPricePerMinute
+
+	segments := parsedResponse.segments()
+	if len(segments) == 0 {
+		return nil, metrics, fmt.Errorf("no transcription received from deepgram")
+	}
+
+	return segments, metrics, nil
+}
+
+// DetectLanguage transcribes the file with language detection enabled and returns the detected language along
+// with the transcription, made as Transcribe makes it
+func (provider *DeepgramProvider) DetectLanguage(jobContext context.Context, audioPath string) (LanguageDetection, models.JobMetrics, error) {
+	var metrics models.JobMetrics
+
+	parsedResponse, listenError := provider.listen(jobContext, audioPath, provider.transcriptionParameters(""))
+	if listenError != nil {
+		return LanguageDetection{}, metrics, listenError
+	}
+	metrics.EstimatedCost = parsedResponse.Metadata.Duration / 60 * deepgramPricePerMinute
+
+	for _, channel := range parsedResponse.Results.Channels {
+		if channel.DetectedLanguage != "" {
+			return LanguageDetection{Language: channel.DetectedLanguage, Segments: parsedResponse.segments()}, metrics, nil
+		}
+	}
+	return LanguageDetection{}, metrics, fmt.Errorf("deepgram did not report a detected language")
+}
+
+// transcriptionParameters are the query parameters of a transcription in a language, detected when empty
+func (provider *DeepgramProvider) transcriptionParameters(languageCode string) url.Values {
+	queryParameters := url.Values{}
+	queryParameters.Set("model", provider.model)
+	queryParameters.Set("smart_format", "true")
+	queryParameters.Set("punctuate", "true")
+	queryParameters.Set("diarize", "true")
+	queryParameters.Set("utterances", "true")
+	if languageCode != "" {
+		queryParameters.Set("language", languageCode)
+	} else {
+		queryParameters.Set("detect_language", "true")
+	}
+	return queryParameters
+}
+
+// listen uploads an audio file to the listen endpoint with the given query parameters
+func (provider *DeepgramProvider) listen(jobContext context.Context, audioPath string, queryParameters url.Values) (*deepgramResponse, error) {
+	audioFile, openingError := os.Open(audioPath)
+	if openingError != nil {
+		return nil, fmt.Errorf("failed to open audio file: %w", openingError)
+	}
+	defer audioFile.Close()
+
+	httpRequest, requestError := http.NewRequestWithContext(jobContext, http.MethodPost, provider.baseURL+"?"+queryParameters.Encode(), audioFile)
+	if requestError != nil {
+		return nil, fmt.Errorf("failed to create deepgram request: %w", requestError)
+	}
+	httpRequest.Header.Set("Authorization", "Token "+provider.apiKey)
+	httpRequest.Header.Set("Content-Type", audioContentType(audioPath))
+
+	httpResponse, responseError := provider.httpClient.Do(httpRequest)
+	if responseError != nil {
+		return nil, fmt.Errorf("deepgram request failed: %w", responseError)
+	}
+	defer httpResponse.Body.Close()
+
+	responseBody, readingError := io.ReadAll(httpResponse.Body)
+	if readingError != nil {
+		return nil, fmt.Errorf("failed to read deepgram response: %w", readingError)
+	}
+
+	if httpResponse.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("deepgram returned status %d: %s", httpResponse.StatusCode, strings.TrimSpace(string(responseBody)))
+	}
+
+	var parsedResponse deepgramResponse
+	if unmarshalingError := json.Unmarshal(responseBody, &parsedResponse); unmarshalingError != nil {
+		return nil, fmt.Errorf("failed to parse deepgram response: %w", unmarshalingError)
+	}
+	return &parsedResponse, nil
+}
+
+// segments converts the response into transcript segments, preferring diarized utterances
+// and falling back to grouping the word-level timestamps by speaker
+func (response *deepgramResponse) segments() []Segment {
+	var segments []Segment
+
+	for _, utterance := range response.Results.Utterances {
+		text := strings.TrimSpace(utterance.Transcript)
+		if text == "" {
+			continue
+		}
+		segments = append(segments, Segment{
+			Start:      utterance.Start,
+			End:        utterance.End,
+			Text:       text,
+			Confidence: utterance.Confidence,
+			Speaker:    deepgramSpeakerLabel(utterance.Speaker),
+		})
+	}
+	if len(segments) > 0 {
+		return segments
+	}
+
+	if len(response.Results.Channels) == 0 || len(response.Results.Channels[0].Alternatives) == 0 {
+		return nil
+	}
+	alternative := response.Results.Channels[0].Alternatives[0]
+
+	var current *Segment
+	var currentWords []string
+	var confidenceSum float64
+	flush := func() {
+		if current == nil {
+			return
+		}
+		current.Text = strings.Join(currentWords, " ")
+		current.Confidence = confidenceSum / float64(len(currentWords))
+		segments = append(segments, *current)
+		current, currentWords, confidenceSum = nil, nil, 0
+	}
+
+	for _, word := range alternative.Words {
+		speaker := deepgramSpeakerLabel(word.Speaker)
+		if current != nil && current.Speaker != speaker {
+			flush()
+		}
+		if current == nil {
+			current = &Segment{Start: word.Start, Speaker: speaker}
+		}
+		wordText := word.PunctuatedWord
+		if wordText == "" {
+			wordText = word.Word
+		}
+		currentWords = append(currentWords, wordText)
+		confidenceSum += word.Confidence
+		current.End = word.End
+	}
+	flush()
+
+	// No word timings at all: keep the plain transcript as a single segment
+	if len(segments) == 0 && strings.TrimSpace(alternative.Transcript) != "" {
+		segments = append(segments, Segment{
+			Text:       strings.TrimSpace(alternative.Transcript),
+			Confidence: alternative.Confidence,
+		})
+	}
+
+	return segments
+}
+
+// deepgramSpeakerLabel turns Deepgram's numeric speaker index into a display label
+func deepgramSpeakerLabel(speaker *int) string {
+	if speaker == nil {
+		return ""
+	}
+	return fmt.Sprintf("Speaker %d", *speaker+1)
+}
+
+// audioContentType derives the MIME type sent with raw audio uploads
+func audioContentType(audioPath string) string {
+	switch strings.ToLower(filepath.Ext(audioPath)) {
+	case ".mp3":
+		return "audio/mpeg"
+	case ".wav":
+		return "audio/wav"
+	case ".m4a":
+		return "audio/mp4"
+	case ".ogg":
+		return "audio/ogg"
+	case ".flac":
+		return "audio/flac"
+	case ".webm":
+		return "audio/webm"
+	default:
+		return "application/octet-stream"
+	}
+}
@@ -0,0 +1,83 @@
+package transcription
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestDeepgramProvider_Transcribe(tester *testing.T) {
+	var receivedQuery string
+	var receivedAuthorization string
+	mockServer := httptest.NewServer(http.HandlerFunc(func(responseWriter http.ResponseWriter, request *http.Request) {
+		receivedQuery = request.URL.RawQuery
+		receivedAuthorization = request.Header.Get("Authorization")
+		responseWriter.Header().Set("Content-Type", "application/json")
+		responseWriter.Write([]byte(`{
+			"metadata": {"duration": 120},
+			"results": {
+				"channels": [{"alternatives": [{"transcript": "Hello class. Hi.", "confidence": 0.9, "words": []}]}],
+				"utterances": [
+					{"start": 0.5, "end": 1.5, "confidence": 0.95, "transcript": "Hello class.", "speaker": 0},
+					{"start": 2.0, "end": 2.4, "confidence": 0.85, "transcript": "Hi.", "speaker": 1}
+				]
+			}
+		}`))
+	}))
+	defer mockServer.Close()
+
+	audioPath := filepath.Join(tester.TempDir(), "segment.mp3")
+	os.WriteFile(audioPath, []byte("fake audio"), 0644)
+
+	provider := NewDeepgramProvider("test-key", "")
+	provider.baseURL = mockServer.URL
+
+	segments, metrics, err := provider.Transcribe(WithLanguageHint(context.Background(), "it"), audioPath)
+	if err != nil {
+		tester.Fatalf("Transcribe failed: %v", err)
+	}
+
+	if receivedAuthorization != "Token test-key" {
+		tester.Errorf("Unexpected Authorization header: %q", receivedAuthorization)
+	}
+	for _, expectedParameter := range []string{"diarize=true", "language=it", "model=nova-2"} {
+		if !strings.Contains(receivedQuery, expectedParameter) {
+			tester.Errorf("Expected query to contain %q, got %q", expectedParameter, receivedQuery)
+		}
+	}
+
+	if len(segments) != 2 {
+		tester.Fatalf("Expected 2 segments, got %d", len(segments))
+	}
+	if segments[1].Speaker != "Speaker 2" || segments[1].Start != 2.0 || segments[1].Text != "Hi." {
+		tester.Errorf("Unexpected second segment: %+v", segments[1])
+	}
+	if metrics.EstimatedCost != 2*deepgramPricePerMinute {
+		tester.Errorf("Expected cost for 2 minutes, got %f", metrics.EstimatedCost)
+	}
+}
+
+func TestDeepgramResponse_WordFallback(tester *testing.T) {
+	var response deepgramResponse
+	json.Unmarshal([]byte(`{"results": {"channels": [{"alternatives": [{"words": [
+		{"word": "good", "punctuated_word": "Good", "start": 0, "end": 0.4, "confidence": 1, "speaker": 0},
+		{"word": "morning", "punctuated_word": "morning.", "start": 0.4, "end": 0.9, "confidence": 0.8, "speaker": 0},
+		{"word": "hello", "punctuated_word": "Hello.", "start": 1.2, "end": 1.6, "confidence": 0.9, "speaker": 1}
+	]}]}]}}`), &response)
+
+	segments := response.segments()
+	if len(segments) != 2 {
+		tester.Fatalf("Expected 2 speaker segments, got %d: %+v", len(segments), segments)
+	}
+	if segments[0].Text != "Good morning." || segments[0].End != 0.9 || segments[0].Confidence != 0.9 {
+		tester.Errorf("Unexpected first segment: %+v", segments[0])
+	}
+	if segments[1].Speaker != "Speaker 2" || segments[1].Start != 1.2 {
+		tester.Errorf("Unexpected second segment: %+v", segments[1])
+	}
+}
@@ -44,13 +44,61 @@ func (provider *OpenRouterTranscriptionProvider) CheckDependencies() error {
 	return nil
 }
 
+// PrepareModel runs the LLM provider's preflight check and warmup for the audio model
+func (provider *OpenRouterTranscriptionProvider) PrepareModel(jobContext context.Context, onLoading func(model string)) error {
+	return llm.PrepareModel(jobContext, provider.llmProvider, provider.model, onLoading)
+}
+
 func (provider *OpenRouterTranscriptionProvider) Transcribe(jobContext context.Context, audioPath string) ([]Segment, models.JobMetrics, error) {
+	// Build the transcription prompt
+	transcriptionPrompt := "Please transcribe this audio file. Return only the transcribed text without any additional commentary."
+	if provider.prompt != "" {
+		transcriptionPrompt = provider.prompt
+	}
+
+	transcribedText, metrics, chatError := provider.chatWithAudio(jobContext, audioPath, transcriptionPrompt, 16384)
+	if chatError != nil {
+		return nil, metrics, chatError
+	}
+	if transcribedText == "" {
+		return nil, metrics, fmt.Errorf("no transcription received from LLM")
+	}
+
+	// Return as a single segment (OpenRouter doesn't provide timestamps via chat API)
+	// The TranscribeLecture service will handle chunking if needed
+	segments := []Segment{
+		{
+			Start: 0,
+			End:   0, // Unknown duration when using chat API
+			Text:  transcribedText,
+		},
+	}
+
+	return segments, metrics, nil
+}
+
+// DetectLanguage asks the audio model which language is spoken in the file
+func (provider *OpenRouterTranscriptionProvider) DetectLanguage(jobContext context.Context, audioPath string) (LanguageDetection, models.JobMetrics, error) {
+	detectionPrompt := "Identify the main spoken language of this audio file. Answer with its ISO 639-1 code only (for example \"it\" or \"en\"), without any other text."
+
+	detectedLanguage, metrics, chatError := provider.chatWithAudio(jobContext, audioPath, detectionPrompt, 16)
+	if chatError != nil {
+		return LanguageDetection{}, metrics, chatError
+	}
+	if detectedLanguage == "" {
+		return LanguageDetection{}, metrics, fmt.Errorf("no language received from LLM")
+	}
+	return LanguageDetection{Language: detectedLanguage}, metrics, nil
+}
+
+// chatWithAudio sends the audio file together with an instruction and returns the trimmed answer
+func (provider *OpenRouterTranscriptionProvider) chatWithAudio(jobContext context.Context, audioPath string, instruction string, maximumTokens int) (string, models.JobMetrics, error) {
 	var metrics models.JobMetrics
 
 	// Read audio file
 	audioData, readingError := os.ReadFile(audioPath)
 	if readingError != nil {
-		return nil, metrics, fmt.Errorf("failed to read audio file: %w", readingError)
+		return "", metrics, fmt.Errorf("failed to read audio file: %w", readingError)
 	}
 
 	// Encode to base64
@@ -63,12 +111,6 @@ func (provider *OpenRouterTranscriptionProvider) Transcribe(jobContext context.C
 		audioFormat = "mp3" // default
 	}
 
-	// Build the transcription prompt
-	transcriptionPrompt := "Please transcribe this audio file. Return only the transcribed text without any additional commentary."
-	if provider.prompt != "" {
-		transcriptionPrompt = provider.prompt
-	}
-
 	// Create chat request with audio input
 	request := llm.ChatRequest{
 		Model: provider.model,
@@ -78,7 +120,7 @@ func (provider *OpenRouterTranscriptionProvider) Transcribe(jobContext context.C
 				Content: []llm.ContentPart{
 					{
 						Type: "text",
-						Text: transcriptionPrompt,
+						Text: instruction,
 					},
 					{
 						Type:        "input_audio",
@@ -89,41 +131,26 @@ func (provider *OpenRouterTranscriptionProvider) Transcribe(jobContext context.C
 			},
 		},
 		Stream:    false,
-		MaxTokens: 16384,
+		MaxTokens: maximumTokens,
 	}
 
 	// Call LLM
 	responseChannel, chatError := provider.llmProvider.Chat(jobContext, &request)
 	if chatError != nil {
-		return nil, metrics, fmt.Errorf("LLM chat failed: %w", chatError)
+		return "", metrics, fmt.Errorf("LLM chat failed: %w", chatError)
 	}
 
 	// Collect response
-	var transcriptionBuilder strings.Builder
+	var responseBuilder strings.Builder
 	for chunk := range responseChannel {
 		if chunk.Error != nil {
-			return nil, metrics, fmt.Errorf("LLM streaming error: %w", chunk.Error)
+			return "", metrics, fmt.Errorf("LLM streaming error: %w", chunk.Error)
 		}
-		transcriptionBuilder.WriteString(chunk.Text)
+		responseBuilder.WriteString(chunk.Text)
 		metrics.InputTokens += chunk.InputTokens
 		metrics.OutputTokens += chunk.OutputTokens
 		metrics.EstimatedCost += chunk.Cost
 	}
 
-	transcribedText := strings.TrimSpace(transcriptionBuilder.String())
-	if transcribedText == "" {
-		return nil, metrics, fmt.Errorf("no transcription received from LLM")
-	}
-
-	// Return as a single segment (OpenRouter doesn't provide timestamps via chat API)
-	// The TranscribeLecture service will handle chunking if needed
-	segments := []Segment{
-		{
-			Start: 0,
-			End:   0, // Unknown duration when using chat API
-			Text:  transcribedText,
-		},
-	}
-
-	return segments, metrics, nil
+	return strings.TrimSpace(responseBuilder.String()), metrics, nil
 }
@@ -2,6 +2,9 @@ package transcription
 
 import (
 	"context"
+	"regexp"
+	"strings"
+
 	"lectures/internal/models"
 )
 
@@ -28,3 +31,84 @@ type Provider interface {
 	// Name returns the identifier of the provider
 	Name() string
 }
+
+// LanguageDetection is the spoken language of an audio file, with its transcription when the provider transcribed
+// the whole file to tell
+type LanguageDetection struct {
+	Language string    // A language code (e.g. "it" or "it-IT"), or the name of the language
+	Segments []Segment // Empty when the language was identified without transcribing
+}
+
+// LanguageDetector is implemented by providers that can identify the spoken language of an audio file
+type LanguageDetector interface {
+	// DetectLanguage returns the spoken language of the audio, and its transcription when it came with it
+	DetectLanguage(context context.Context, audioPath string) (LanguageDetection, models.JobMetrics, error)
+}
+
+// languageCodePattern accepts ISO-639 codes with an optional region or script subtag
+var languageCodePattern = regexp.MustCompile(`^[a-z]{2,3}(-[A-Za-z0-9]{2,4})?$`)
+
+// spokenLanguageNames maps the English language names some providers report to ISO-639-1 codes
+var spokenLanguageNames = map[string]string{
+	"arabic": "ar", "chinese": "zh", "czech": "cs", "danish": "da", "dutch": "nl", "english": "en",
+	"finnish": "fi", "french": "fr", "german": "de", "greek": "el", "hebrew": "he", "hindi": "hi",
+	"hungarian": "hu", "indonesian": "id", "italian": "it", "japanese": "ja", "korean": "ko",
+	"norwegian": "no", "polish": "pl", "portuguese": "pt", "romanian": "ro", "russian": "ru",
+	"spanish": "es", "swedish": "sv", "turkish": "tr", "ukrainian": "uk", "vietnamese": "vi",
+}
+
+// NormalizeLanguageCode turns a detected language ("Italian", "it", "IT-it") into a language code,
+// returning an empty string when it cannot be recognized
+func NormalizeLanguageCode(detectedLanguage string) string {
+	candidate := strings.Trim(strings.TrimSpace(detectedLanguage), "\"'`.")
+	if code, exists := spokenLanguageNames[strings.ToLower(candidate)]; exists {
+		return code
+	}
+
+	parts := strings.SplitN(strings.ReplaceAll(candidate, "_", "-"), "-", 2)
+	candidate = strings.ToLower(parts[0])
+	if len(parts) == 2 {
+		candidate += "-" + strings.ToUpper(parts[1])
+	}
+	if !languageCodePattern.MatchString(candidate) {
+		return ""
+	}
+	return candidate
+}
+
+// ModelPreparer is implemented by providers backed by a model that may need a preflight check or warmup
+type ModelPreparer interface {
+	// PrepareModel checks the model is usable and loads it, calling onLoading before a slow load
+	PrepareModel(context context.Context, onLoading func(model string)) error
+}
+
+type languageHintKey struct{}
+
+// WithLanguageHint attaches the expected spoken language to a transcription context.
+// Providers that support language hints read it back with LanguageHintFromContext.
+func WithLanguageHint(parent context.Context, languageCode string) context.Context {
+	if languageCode == "" {
+		return parent
+	}
+	return context.WithValue(parent, languageHintKey{}, languageCode)
+}
+
+// LanguageHintFromContext returns the language hint set by WithLanguageHint, if any
+func LanguageHintFromContext(jobContext context.Context) string {
+	languageCode, _ := jobContext.Value(languageHintKey{}).(string)
+	return languageCode
+}
+
+type diarizationKey struct{}
+
+// WithDiarization requests speaker labels for a transcription context.
+// Providers that support diarization read it back with DiarizationFromContext.
+func WithDiarization(parent context.Context, enabled bool) context.Context {
+	return context.WithValue(parent, diarizationKey{}, enabled)
+}
+
+// DiarizationFromContext reports whether speaker diarization was requested with WithDiarization
+func DiarizationFromContext(jobContext context.Context) bool {
+	enabled, _ := jobContext.Value(diarizationKey{}).(bool)
+	return enabled
+}
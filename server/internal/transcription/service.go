@@ -3,6 +3,7 @@ package transcription
 import (
 	"context"
 	"fmt"
+	"log/slog"
 	"os"
 	"path/filepath"
 	"sort"
@@ -46,15 +47,43 @@ func (service *Service) CheckDependencies() error {
 	return service.provider.CheckDependencies()
 }
 
-// TranscribeLecture processes a list of media files and returns a unified list of transcript segments
-func (service *Service) TranscribeLecture(jobContext context.Context, mediaFiles []models.LectureMedia, temporaryDirectory string, updateProgress func(int, string, any)) ([]models.TranscriptSegment, models.JobMetrics, error) {
+// Prepare verifies the media tools and the provider before a transcription job starts and loads cold models,
+// calling onLoading right before a slow model load so the job can report it
+func (service *Service) Prepare(jobContext context.Context, onLoading func(model string)) error {
+	if err := service.CheckDependencies(); err != nil {
+		return err
+	}
+
+	if preparer, supportsPreparation := service.provider.(ModelPreparer); supportsPreparation {
+		if err := preparer.PrepareModel(jobContext, onLoading); err != nil {
+			return err
+		}
+	}
+
+	// The cleanup pass only runs when prompts are available
+	if service.promptManager != nil {
+		cleanupModel := service.configuration.LLM.GetModelForTask("content_polishing")
+		if cleanupModel == "" {
+			cleanupModel = service.configuration.LLM.Model
+		}
+		return llm.PrepareModel(jobContext, service.llmProvider, cleanupModel, onLoading)
+	}
+	return nil
+}
+
+// TranscribeLecture processes a list of media files and returns a unified list of transcript segments.
+// When the context carries no language hint, the spoken language is detected from the first audio chunk,
+// used for the rest of the transcription, and returned so it can become the lecture's language.
+// When a chunk store is given, completed chunks are saved as they finish and reused on the next run
+func (service *Service) TranscribeLecture(jobContext context.Context, mediaFiles []models.LectureMedia, temporaryDirectory string, chunkStore ChunkStore, updateProgress func(int, string, any)) ([]models.TranscriptSegment, string, models.JobMetrics, error) {
 	var allSegments []models.TranscriptSegment
 	var globalTimeOffsetMilliseconds int64 = 0
 	var totalMetrics models.JobMetrics
+	var detectedLanguage string
 
 	// Validate FFmpeg
 	if err := service.mediaProcessor.CheckDependencies(); err != nil {
-		return nil, totalMetrics, fmt.Errorf("ffmpeg dependency check failed: %w", err)
+		return nil, "", totalMetrics, fmt.Errorf("ffmpeg dependency check failed: %w", err)
 	}
 
 	// Load transcription instructions
@@ -78,7 +107,7 @@ func (service *Service) TranscribeLecture(jobContext context.Context, mediaFiles
 		// 1. Prepare Audio
 		audioPath := filepath.Join(temporaryDirectory, fmt.Sprintf("source_%s.mp3", media.ID))
 		if extractionError := service.mediaProcessor.ExtractAudio(media.FilePath, audioPath); extractionError != nil {
-			return nil, totalMetrics, fmt.Errorf("failed to extract audio from %s: %w", media.FilePath, extractionError)
+			return nil, "", totalMetrics, fmt.Errorf("failed to extract audio from %s: %w", media.FilePath, extractionError)
 		}
 
 		// 2. Split Audio
@@ -89,10 +118,27 @@ func (service *Service) TranscribeLecture(jobContext context.Context, mediaFiles
 		}
 		segmentFiles, splitError := service.mediaProcessor.SplitAudio(audioPath, segmentsDirectory, segmentDurationSeconds)
 		if splitError != nil {
-			return nil, totalMetrics, fmt.Errorf("failed to split audio: %w", splitError)
+			return nil, "", totalMetrics, fmt.Errorf("failed to split audio: %w", splitError)
 		}
 		sort.Strings(segmentFiles)
 
+		// Language pre-pass: identify the spoken language once, from the very first chunk. Providers that
+		// transcribe the chunk to tell return its transcription, which stands in for that of the main pass
+		var firstChunkSegments []Segment
+		if mediaIndex == 0 && len(segmentFiles) > 0 && LanguageHintFromContext(jobContext) == "" {
+			updateProgress(0, "Detecting spoken language...", mediaMetadata)
+			var languageCode string
+			var detectionMetrics models.JobMetrics
+			languageCode, firstChunkSegments, detectionMetrics = service.detectSpokenLanguage(jobContext, segmentFiles[0])
+			totalMetrics.InputTokens += detectionMetrics.InputTokens
+			totalMetrics.OutputTokens += detectionMetrics.OutputTokens
+			totalMetrics.EstimatedCost += detectionMetrics.EstimatedCost
+			if languageCode != "" {
+				detectedLanguage = languageCode
+				jobContext = WithLanguageHint(jobContext, languageCode)
+			}
+		}
+
 		var mediaSegments []models.TranscriptSegment
 
 		// 3. Transcribe Segments in chunks for cleanup
@@ -102,11 +148,32 @@ func (service *Service) TranscribeLecture(jobContext context.Context, mediaFiles
 			batchSize = 3
 		}
 
+		// Chunks completed by a previous, interrupted run of this transcription
+		layout := chunkLayout(segmentDurationSeconds, batchSize)
+		var completedChunks map[int][]models.TranscriptSegment
+		if chunkStore != nil {
+			loadedChunks, loadingError := chunkStore.LoadChunks(media.ID, layout)
+			if loadingError != nil {
+				slog.WarnContext(jobContext, "Failed to load completed transcription chunks, transcribing from the start", "media_id", media.ID, "error", loadingError)
+			} else if len(loadedChunks) > 0 {
+				slog.InfoContext(jobContext, "Resuming transcription from completed chunks", "media_id", media.ID, "completed_chunks", len(loadedChunks))
+				completedChunks = loadedChunks
+			}
+		}
+
 		for segmentChunkStart := 0; segmentChunkStart < totalSegments; segmentChunkStart += batchSize {
 			segmentChunkEnd := segmentChunkStart + batchSize
 			if segmentChunkEnd > totalSegments {
 				segmentChunkEnd = totalSegments
 			}
+			chunkIndex := segmentChunkStart / batchSize
+			currentProgress := int((float64(mediaIndex) + float64(segmentChunkEnd)/float64(totalSegments)) / float64(totalMediaFiles) * 100)
+
+			if storedSegments, completed := completedChunks[chunkIndex]; completed {
+				mediaSegments = append(mediaSegments, withTimeOffset(storedSegments, globalTimeOffsetMilliseconds)...)
+				updateProgress(currentProgress, "Resuming from previously transcribed segments...", interimMetadata(mediaMetadata, storedSegments, globalTimeOffsetMilliseconds))
+				continue
+			}
 
 			type segmentResult struct {
 				index    int
@@ -136,7 +203,15 @@ func (service *Service) TranscribeLecture(jobContext context.Context, mediaFiles
 
 					segmentFile := segmentFiles[idx]
 
-					transcriptionResults, stepMetrics, transcriptionError := service.provider.Transcribe(jobContext, segmentFile)
+					var transcriptionResults []Segment
+					var stepMetrics models.JobMetrics
+					var transcriptionError error
+					if idx == 0 && len(firstChunkSegments) > 0 {
+						// Already paid for with the language detection
+						transcriptionResults = firstChunkSegments
+					} else {
+						transcriptionResults, stepMetrics, transcriptionError = service.provider.Transcribe(jobContext, segmentFile)
+					}
 					if transcriptionError != nil {
 						resultChan <- segmentResult{err: transcriptionError}
 						return
@@ -183,7 +258,7 @@ func (service *Service) TranscribeLecture(jobContext context.Context, mediaFiles
 			var results []segmentResult
 			for res := range resultChan {
 				if res.err != nil {
-					return nil, totalMetrics, fmt.Errorf("transcription failed: %w", res.err)
+					return nil, "", totalMetrics, fmt.Errorf("transcription failed: %w", res.err)
 				}
 				results = append(results, res)
 			}
@@ -202,14 +277,13 @@ func (service *Service) TranscribeLecture(jobContext context.Context, mediaFiles
 				totalMetrics.EstimatedCost += res.metrics.EstimatedCost
 			}
 
-			// Update progress
-			currentProgress := int((float64(mediaIndex) + float64(segmentChunkEnd)/float64(totalSegments)) / float64(totalMediaFiles) * 100)
-			updateProgress(currentProgress, "Transcribing audio segments...", mediaMetadata)
+			// Update progress with the raw text, so users can check early on that the right audio is being transcribed
+			updateProgress(currentProgress, "Transcribing audio segments...", interimMetadata(mediaMetadata, chunkSegments, globalTimeOffsetMilliseconds))
 
 			// 4. LLM Cleanup for the chunk
+			var finalSegments []models.TranscriptSegment
 			if chunkTextBuilder.Len() > 0 {
-				cleanupProgress := int((float64(mediaIndex) + float64(segmentChunkEnd)/float64(totalSegments)) / float64(totalMediaFiles) * 100)
-				updateProgress(cleanupProgress, "Cleaning up and polishing transcripts...", mediaMetadata)
+				updateProgress(currentProgress, "Cleaning up and polishing transcripts...", mediaMetadata)
 
 				cleanedText, cleanupMetrics, cleanupError := service.cleanupTranscriptChunk(jobContext, chunkTextBuilder.String())
 				totalMetrics.InputTokens += cleanupMetrics.InputTokens
@@ -220,24 +294,27 @@ func (service *Service) TranscribeLecture(jobContext context.Context, mediaFiles
 					firstSegment := chunkSegments[0]
 					lastSegment := chunkSegments[len(chunkSegments)-1]
 
-					mediaSegments = append(mediaSegments, models.TranscriptSegment{
+					finalSegments = []models.TranscriptSegment{{
 						MediaID:                   media.ID,
-						StartMillisecond:          globalTimeOffsetMilliseconds + firstSegment.OriginalStartMilliseconds,
-						EndMillisecond:            globalTimeOffsetMilliseconds + lastSegment.OriginalEndMilliseconds,
 						OriginalStartMilliseconds: firstSegment.OriginalStartMilliseconds,
 						OriginalEndMilliseconds:   lastSegment.OriginalEndMilliseconds,
 						Text:                      cleanedText,
 						Confidence:                1.0,
-					})
+					}}
 				} else {
 					// Fallback to original segments if LLM fails
-					for _, segment := range chunkSegments {
-						segment.StartMillisecond = globalTimeOffsetMilliseconds + segment.OriginalStartMilliseconds
-						segment.EndMillisecond = globalTimeOffsetMilliseconds + segment.OriginalEndMilliseconds
-						mediaSegments = append(mediaSegments, segment)
-					}
+					finalSegments = chunkSegments
 				}
 			}
+
+			// 5. Checkpoint the chunk, so a failure further on does not throw this work away
+			if chunkStore != nil {
+				if savingError := chunkStore.SaveChunk(media.ID, layout, chunkIndex, finalSegments); savingError != nil {
+					slog.WarnContext(jobContext, "Failed to save transcription chunk", "media_id", media.ID, "chunk_index", chunkIndex, "error", savingError)
+				}
+			}
+
+			mediaSegments = append(mediaSegments, withTimeOffset(finalSegments, globalTimeOffsetMilliseconds)...)
 		}
 
 		allSegments = append(allSegments, mediaSegments...)
@@ -252,7 +329,78 @@ func (service *Service) TranscribeLecture(jobContext context.Context, mediaFiles
 		os.RemoveAll(segmentsDirectory)
 	}
 
-	return allSegments, totalMetrics, nil
+	return allSegments, detectedLanguage, totalMetrics, nil
+}
+
+// maximumInterimTextCharacters bounds the transcribed snippet included in progress updates
+const maximumInterimTextCharacters = 200
+
+// interimMetadata extends the progress metadata of a media file with the end of the latest transcribed text and
+// how far into the media file (and the whole lecture) the transcription has reached
+func interimMetadata(mediaMetadata map[string]any, segments []models.TranscriptSegment, offsetMilliseconds int64) map[string]any {
+	metadata := make(map[string]any, len(mediaMetadata)+3)
+	for key, value := range mediaMetadata {
+		metadata[key] = value
+	}
+	if len(segments) == 0 {
+		return metadata
+	}
+
+	var textBuilder strings.Builder
+	for _, segment := range segments {
+		if text := strings.TrimSpace(segment.Text); text != "" {
+			if textBuilder.Len() > 0 {
+				textBuilder.WriteString(" ")
+			}
+			textBuilder.WriteString(text)
+		}
+	}
+	latestText := []rune(textBuilder.String())
+	if len(latestText) > maximumInterimTextCharacters {
+		latestText = append([]rune("..."), latestText[len(latestText)-maximumInterimTextCharacters:]...)
+	}
+
+	mediaOffsetMilliseconds := segments[len(segments)-1].OriginalEndMilliseconds
+	metadata["latest_text"] = string(latestText)
+	metadata["time_offset_milliseconds"] = mediaOffsetMilliseconds
+	metadata["lecture_offset_milliseconds"] = offsetMilliseconds + mediaOffsetMilliseconds
+	return metadata
+}
+
+// withTimeOffset places segments with media-relative times on the timeline of the whole lecture
+func withTimeOffset(segments []models.TranscriptSegment, offsetMilliseconds int64) []models.TranscriptSegment {
+	placedSegments := make([]models.TranscriptSegment, 0, len(segments))
+	for _, segment := range segments {
+		segment.StartMillisecond = offsetMilliseconds + segment.OriginalStartMilliseconds
+		segment.EndMillisecond = offsetMilliseconds + segment.OriginalEndMilliseconds
+		placedSegments = append(placedSegments, segment)
+	}
+	return placedSegments
+}
+
+// detectSpokenLanguage asks the provider for the language of an audio chunk, and returns the transcription of
+// the chunk when the provider made one to tell. Detection is best effort: providers without support, failures
+// and unrecognized answers all yield an empty code
+func (service *Service) detectSpokenLanguage(jobContext context.Context, audioPath string) (string, []Segment, models.JobMetrics) {
+	detector, supportsDetection := service.provider.(LanguageDetector)
+	if !supportsDetection {
+		return "", nil, models.JobMetrics{}
+	}
+
+	detection, metrics, detectionError := detector.DetectLanguage(jobContext, audioPath)
+	if detectionError != nil {
+		slog.WarnContext(jobContext, "Spoken language detection failed, transcribing without a language hint", "provider", service.provider.Name(), "error", detectionError)
+		return "", nil, metrics
+	}
+
+	languageCode := NormalizeLanguageCode(detection.Language)
+	if languageCode == "" {
+		slog.WarnContext(jobContext, "Unrecognized spoken language, transcribing without a language hint", "provider", service.provider.Name(), "detected", detection.Language)
+		return "", detection.Segments, metrics
+	}
+
+	slog.InfoContext(jobContext, "Spoken language detected", "provider", service.provider.Name(), "language", languageCode)
+	return languageCode, detection.Segments, metrics
 }
 
 func (service *Service) cleanupTranscriptChunk(jobContext context.Context, rawText string) (string, models.JobMetrics, error) {
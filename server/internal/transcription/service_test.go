@@ -0,0 +1,250 @@
+package transcription
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"lectures/internal/configuration"
+	"lectures/internal/models"
+)
+
+// stubMediaProcessor pretends every media file is a single two-chunk recording
+type stubMediaProcessor struct{}
+
+func (processor *stubMediaProcessor) CheckDependencies() error { return nil }
+
+func (processor *stubMediaProcessor) ExtractAudio(inputPath string, outputPath string) error {
+	return os.WriteFile(outputPath, []byte("audio"), 0644)
+}
+
+func (processor *stubMediaProcessor) SplitAudio(inputPath string, outputDirectory string, segmentDuration int) ([]string, error) {
+	os.MkdirAll(outputDirectory, 0755)
+	var paths []string
+	for _, name := range []string{"segment_000.mp3", "segment_001.mp3"} {
+		path := filepath.Join(outputDirectory, name)
+		os.WriteFile(path, []byte("audio"), 0644)
+		paths = append(paths, path)
+	}
+	return paths, nil
+}
+
+func (processor *stubMediaProcessor) GetDuration(inputPath string) (float64, error) { return 60, nil }
+
+// detectingProvider records the language hint every chunk was transcribed with
+type detectingProvider struct {
+	detectedLanguage string
+	detectedSegments []Segment // Transcription returned along with the detected language
+	detectionCalls   int
+	mutex            sync.Mutex
+	hints            []string
+}
+
+func (provider *detectingProvider) Transcribe(jobContext context.Context, audioPath string) ([]Segment, models.JobMetrics, error) {
+	provider.mutex.Lock()
+	provider.hints = append(provider.hints, LanguageHintFromContext(jobContext))
+	provider.mutex.Unlock()
+	return []Segment{{Start: 0, End: 60, Text: "testo"}}, models.JobMetrics{}, nil
+}
+
+func (provider *detectingProvider) DetectLanguage(jobContext context.Context, audioPath string) (LanguageDetection, models.JobMetrics, error) {
+	provider.detectionCalls++
+	return LanguageDetection{Language: provider.detectedLanguage, Segments: provider.detectedSegments}, models.JobMetrics{EstimatedCost: 0.01}, nil
+}
+
+func (provider *detectingProvider) SetPrompt(prompt string)  {}
+func (provider *detectingProvider) CheckDependencies() error { return nil }
+func (provider *detectingProvider) Name() string             { return "detecting" }
+
+func newDetectionTestService(provider Provider) *Service {
+	service := NewService(&configuration.Configuration{}, provider, nil, nil)
+	service.SetMediaProcessor(&stubMediaProcessor{})
+	return service
+}
+
+func TestService_TranscribeLectureDetectsLanguage(tester *testing.T) {
+	provider := &detectingProvider{detectedLanguage: "Italian"}
+	service := newDetectionTestService(provider)
+
+	mediaFiles := []models.LectureMedia{{ID: "first", FilePath: "first.mp4"}, {ID: "second", FilePath: "second.mp4"}}
+	_, detectedLanguage, metrics, err := service.TranscribeLecture(context.Background(), mediaFiles, tester.TempDir(), nil, func(int, string, any) {})
+	if err != nil {
+		tester.Fatalf("TranscribeLecture failed: %v", err)
+	}
+
+	if detectedLanguage != "it" {
+		tester.Errorf("Expected detected language it, got %q", detectedLanguage)
+	}
+	if provider.detectionCalls != 1 {
+		tester.Errorf("Expected a single detection pass, got %d", provider.detectionCalls)
+	}
+	if len(provider.hints) != 4 {
+		tester.Fatalf("Expected 4 transcribed chunks, got %d", len(provider.hints))
+	}
+	for _, hint := range provider.hints {
+		if hint != "it" {
+			tester.Errorf("Expected every chunk to be transcribed with the detected language, got %q", hint)
+		}
+	}
+	if metrics.EstimatedCost != 0.01 {
+		tester.Errorf("Expected detection cost to be included, got %f", metrics.EstimatedCost)
+	}
+}
+
+func TestService_TranscribeLectureReusesDetectionTranscript(tester *testing.T) {
+	provider := &detectingProvider{detectedLanguage: "it", detectedSegments: []Segment{{Start: 0, End: 60, Text: "rilevato"}}}
+	service := newDetectionTestService(provider)
+
+	segments, _, _, err := service.TranscribeLecture(context.Background(), []models.LectureMedia{{ID: "only", FilePath: "only.mp4"}}, tester.TempDir(), nil, func(int, string, any) {})
+	if err != nil {
+		tester.Fatalf("TranscribeLecture failed: %v", err)
+	}
+
+	if len(provider.hints) != 1 {
+		tester.Errorf("Expected only the second chunk to be transcribed again, got %d transcriptions", len(provider.hints))
+	}
+	if len(segments) != 1 || !strings.HasPrefix(segments[0].Text, "rilevato ") {
+		tester.Errorf("Expected the transcript of the detection to open the lecture, got %+v", segments)
+	}
+}
+
+func TestService_TranscribeLectureKeepsExplicitLanguage(tester *testing.T) {
+	provider := &detectingProvider{detectedLanguage: "it"}
+	service := newDetectionTestService(provider)
+
+	jobContext := WithLanguageHint(context.Background(), "en-US")
+	_, detectedLanguage, _, err := service.TranscribeLecture(jobContext, []models.LectureMedia{{ID: "only", FilePath: "only.mp4"}}, tester.TempDir(), nil, func(int, string, any) {})
+	if err != nil {
+		tester.Fatalf("TranscribeLecture failed: %v", err)
+	}
+
+	if provider.detectionCalls != 0 || detectedLanguage != "" {
+		tester.Errorf("Expected no detection when the lecture language is known, got %d calls and %q", provider.detectionCalls, detectedLanguage)
+	}
+	for _, hint := range provider.hints {
+		if hint != "en-US" {
+			tester.Errorf("Expected the explicit hint to be kept, got %q", hint)
+		}
+	}
+}
+
+// memoryChunkStore keeps transcription checkpoints in memory
+type memoryChunkStore struct {
+	mutex  sync.Mutex
+	chunks map[string]map[int][]models.TranscriptSegment
+}
+
+func (store *memoryChunkStore) LoadChunks(mediaID string, layout string) (map[int][]models.TranscriptSegment, error) {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+	return store.chunks[mediaID+"|"+layout], nil
+}
+
+func (store *memoryChunkStore) SaveChunk(mediaID string, layout string, chunkIndex int, segments []models.TranscriptSegment) error {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+	if store.chunks[mediaID+"|"+layout] == nil {
+		store.chunks[mediaID+"|"+layout] = make(map[int][]models.TranscriptSegment)
+	}
+	store.chunks[mediaID+"|"+layout][chunkIndex] = segments
+	return nil
+}
+
+// flakyProvider fails every chunk of the media files it was told to fail on
+type flakyProvider struct {
+	detectingProvider
+	failingMediaID string
+	transcribed    []string
+}
+
+func (provider *flakyProvider) Transcribe(jobContext context.Context, audioPath string) ([]Segment, models.JobMetrics, error) {
+	provider.mutex.Lock()
+	provider.transcribed = append(provider.transcribed, audioPath)
+	provider.mutex.Unlock()
+	if provider.failingMediaID != "" && strings.Contains(audioPath, "segments_"+provider.failingMediaID) {
+		return nil, models.JobMetrics{}, errors.New("provider unavailable")
+	}
+	return []Segment{{Start: 0, End: 60, Text: "testo"}}, models.JobMetrics{}, nil
+}
+
+func TestService_TranscribeLectureResumesFromCompletedChunks(tester *testing.T) {
+	provider := &flakyProvider{failingMediaID: "second"}
+	service := newDetectionTestService(provider)
+	chunkStore := &memoryChunkStore{chunks: make(map[string]map[int][]models.TranscriptSegment)}
+
+	jobContext := WithLanguageHint(context.Background(), "it")
+	mediaFiles := []models.LectureMedia{{ID: "first", FilePath: "first.mp4"}, {ID: "second", FilePath: "second.mp4"}}
+	if _, _, _, err := service.TranscribeLecture(jobContext, mediaFiles, tester.TempDir(), chunkStore, func(int, string, any) {}); err == nil {
+		tester.Fatal("Expected the first run to fail on the second media file")
+	}
+
+	provider.failingMediaID = ""
+	provider.transcribed = nil
+	segments, _, _, err := service.TranscribeLecture(jobContext, mediaFiles, tester.TempDir(), chunkStore, func(int, string, any) {})
+	if err != nil {
+		tester.Fatalf("Resumed run failed: %v", err)
+	}
+
+	for _, audioPath := range provider.transcribed {
+		if strings.Contains(audioPath, "segments_first") {
+			tester.Errorf("Expected the completed media file to be skipped, but %s was transcribed again", audioPath)
+		}
+	}
+	if len(provider.transcribed) != 2 {
+		tester.Errorf("Expected only the 2 chunks of the second media file to be transcribed, got %d", len(provider.transcribed))
+	}
+
+	// Without a prompt manager every cleanup batch collapses into a single segment per media file
+	if len(segments) != 2 {
+		tester.Fatalf("Expected 2 segments, got %d", len(segments))
+	}
+	if segments[0].MediaID != "first" || segments[1].MediaID != "second" || segments[1].StartMillisecond != 60000 {
+		tester.Errorf("Expected resumed segments to keep their order and lecture timeline, got %+v", segments)
+	}
+}
+
+func TestService_TranscribeLectureReportsInterimText(tester *testing.T) {
+	service := newDetectionTestService(&detectingProvider{})
+
+	var interimUpdates []map[string]any
+	mediaFiles := []models.LectureMedia{{ID: "first", FilePath: "first.mp4"}, {ID: "second", FilePath: "second.mp4"}}
+	jobContext := WithLanguageHint(context.Background(), "it")
+	_, _, _, err := service.TranscribeLecture(jobContext, mediaFiles, tester.TempDir(), nil, func(progress int, message string, metadata any) {
+		if metadataMap, isMap := metadata.(map[string]any); isMap && metadataMap["latest_text"] != nil {
+			interimUpdates = append(interimUpdates, metadataMap)
+		}
+	})
+	if err != nil {
+		tester.Fatalf("TranscribeLecture failed: %v", err)
+	}
+
+	// The default batch size covers both chunks of a media file, so there is one update per file
+	if len(interimUpdates) != 2 {
+		tester.Fatalf("Expected 2 progress updates with interim text, got %d", len(interimUpdates))
+	}
+	last := interimUpdates[1]
+	if last["latest_text"] != "testo testo" || last["media_index"] != 2 || last["media_id"] != "second" {
+		tester.Errorf("Unexpected interim metadata: %+v", last)
+	}
+	// The second 300 second chunk ends 60 seconds in; the first media file lasted 60 seconds
+	if last["time_offset_milliseconds"] != int64(360000) || last["lecture_offset_milliseconds"] != int64(420000) {
+		tester.Errorf("Expected offsets of 360000ms in the media and 420000ms in the lecture, got %+v", last)
+	}
+}
+
+func TestInterimMetadata_TruncatesLongText(tester *testing.T) {
+	segments := []models.TranscriptSegment{{Text: strings.Repeat("a", 150), OriginalEndMilliseconds: 1000}, {Text: strings.Repeat("b", 150), OriginalEndMilliseconds: 2000}}
+	metadata := interimMetadata(map[string]any{"media_index": 1}, segments, 0)
+
+	latestText := metadata["latest_text"].(string)
+	if !strings.HasPrefix(latestText, "...") || !strings.HasSuffix(latestText, "b") || len([]rune(latestText)) != maximumInterimTextCharacters+3 {
+		tester.Errorf("Expected the tail of the text, got %q", latestText)
+	}
+	if metadata["media_index"] != 1 {
+		tester.Error("Expected the media metadata to be kept")
+	}
+}
@@ -0,0 +1,314 @@
+package transcription
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"mime/multipart"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"lectures/internal/models"
+)
+
+const (
+	whisperBaseURL = "https://api.openai.com/v1"
+	// The transcription endpoint rejects uploads above 25 MB; keep a margin for the multipart envelope
+	whisperMaximumUploadBytes = 24 * 1024 * 1024
+)
+
+// whisperPricesPerMinute lists the per-minute price of the OpenAI transcription models, used for cost estimation
+var whisperPricesPerMinute = map[string]float64{
+	"whisper-1":              0.006,
+	"gpt-4o-transcribe":      0.006,
+	"gpt-4o-mini-transcribe": 0.003,
+}
+
+// WhisperProvider transcribes audio through the OpenAI transcription API (or any compatible endpoint)
+type WhisperProvider struct {
+	apiKey         string
+	model          string
+	baseURL        string
+	httpClient     *http.Client
+	mediaProcessor MediaProcessor
+}
+
+// whisperSegment is a single timed segment of a verbose_json transcription
+type whisperSegment struct {
+	Start          float64  `json:"start"`
+	End            float64  `json:"end"`
+	Text           string   `json:"text"`
+	AverageLogProb *float64 `json:"avg_logprob"`
+	NoSpeechProb   float64  `json:"no_speech_prob"`
+}
+
+// whisperResponse is the subset of the transcription response used here
+type whisperResponse struct {
+	Text     string           `json:"text"`
+	Language string           `json:"language"` // English name of the detected language, verbose_json only
+	Duration float64          `json:"duration"`
+	Segments []whisperSegment `json:"segments"`
+}
+
+// NewWhisperProvider creates a Whisper API transcription provider; an empty baseURL targets api.openai.com
+func NewWhisperProvider(apiKey string, model string, baseURL string, binDirectory string) *WhisperProvider {
+	if model == "" {
+		model = "whisper-1"
+	}
+	if baseURL == "" {
+		baseURL = whisperBaseURL
+	}
+	return &WhisperProvider{
+		apiKey:         apiKey,
+		model:          model,
+		baseURL:        strings.TrimSuffix(baseURL, "/"),
+		httpClient:     &http.Client{Timeout: 10 * time.Minute},
+		mediaProcessor: NewFFmpeg(binDirectory),
+	}
+}
+
+// SetPrompt is a no-op: Whisper prompts are short vocabulary hints, not the LLM transcription instructions
+func (provider *WhisperProvider) SetPrompt(prompt string) {}
+
+func (provider *WhisperProvider) Name() string {
+	return "whisper-api"
+}
+
+func (provider *WhisperProvider) CheckDependencies() error {
+	if provider.apiKey == "" {
+		return fmt.Errorf("OpenAI API key is not configured (providers.openai.api_key)")
+	}
+	return nil
+}
+
+func (provider *WhisperProvider) Transcribe(jobContext context.Context, audioPath string) ([]Segment, models.JobMetrics, error) {
+	var metrics models.JobMetrics
+
+	fileInformation, statError := os.Stat(audioPath)
+	if statError != nil {
+		return nil, metrics, fmt.Errorf("failed to open audio file: %w", statError)
+	}
+
+	// Oversized files (e.g. a long audio_chunk_length_seconds) are split again so every upload fits the limit
+	chunkPaths := []string{audioPath}
+	if fileInformation.Size() > whisperMaximumUploadBytes {
+		splitPaths, splitError := provider.splitForUpload(audioPath, fileInformation.Size())
+		if splitError != nil {
+			return nil, metrics, splitError
+		}
+		chunkPaths = splitPaths
+	}
+
+	var segments []Segment
+	var offsetSeconds, totalDuration float64
+	for _, chunkPath := range chunkPaths {
+		response, requestError := provider.transcribeFile(jobContext, chunkPath)
+		if requestError != nil {
+			return nil, metrics, requestError
+		}
+
+		chunkDuration := response.Duration
+		if chunkDuration == 0 {
+			chunkDuration, _ = provider.mediaProcessor.GetDuration(chunkPath)
+		}
+
+		for _, segment := range response.toSegments(chunkDuration) {
+			segment.Start += offsetSeconds
+			segment.End += offsetSeconds
+			segments = append(segments, segment)
+		}
+
+		offsetSeconds += chunkDuration
+		totalDuration += chunkDuration
+	}
+
+	metrics.EstimatedCost = totalDuration / 60 * provider.pricePerMinute()
+
+	if len(segments) == 0 {
+		return nil, metrics, fmt.Errorf("no transcription received from whisper")
+	}
+
+	backfillConfidence(segments)
+	return segments, metrics, nil
+}
+
+// DetectLanguage transcribes the file without a language parameter and returns the language Whisper detected,
+// along with the transcription
+func (provider *WhisperProvider) DetectLanguage(jobContext context.Context, audioPath string) (LanguageDetection, models.JobMetrics, error) {
+	var metrics models.JobMetrics
+	if !provider.supportsSegments() {
+		return LanguageDetection{}, metrics, fmt.Errorf("model %s does not report the detected language", provider.model)
+	}
+
+	response, requestError := provider.transcribeFile(jobContext, audioPath)
+	if requestError != nil {
+		return LanguageDetection{}, metrics, requestError
+	}
+	metrics.EstimatedCost = response.Duration / 60 * provider.pricePerMinute()
+
+	if response.Language == "" {
+		return LanguageDetection{}, metrics, fmt.Errorf("whisper did not report a detected language")
+	}
+	chunkDuration := response.Duration
+	if chunkDuration == 0 {
+		chunkDuration, _ = provider.mediaProcessor.GetDuration(audioPath)
+	}
+	segments := response.toSegments(chunkDuration)
+	backfillConfidence(segments)
+	return LanguageDetection{Language: response.Language, Segments: segments}, metrics, nil
+}
+
+// transcribeFile uploads a single audio file to the transcription endpoint
+func (provider *WhisperProvider) transcribeFile(jobContext context.Context, audioPath string) (*whisperResponse, error) {
+	audioFile, openingError := os.Open(audioPath)
+	if openingError != nil {
+		return nil, fmt.Errorf("failed to open audio file: %w", openingError)
+	}
+	defer audioFile.Close()
+
+	var requestBody bytes.Buffer
+	multipartWriter := multipart.NewWriter(&requestBody)
+	filePart, partError := multipartWriter.CreateFormFile("file", filepath.Base(audioPath))
+	if partError != nil {
+		return nil, partError
+	}
+	if _, copyError := io.Copy(filePart, audioFile); copyError != nil {
+		return nil, fmt.Errorf("failed to read audio file: %w", copyError)
+	}
+
+	multipartWriter.WriteField("model", provider.model)
+	// Only whisper-1 returns timed segments; the gpt-4o models answer with plain JSON text
+	if provider.supportsSegments() {
+		multipartWriter.WriteField("response_format", "verbose_json")
+		multipartWriter.WriteField("timestamp_granularities[]", "segment")
+	} else {
+		multipartWriter.WriteField("response_format", "json")
+	}
+	if languageCode := LanguageHintFromContext(jobContext); languageCode != "" {
+		// The API expects ISO-639-1 codes ("it"), not full BCP-47 tags ("it-IT")
+		multipartWriter.WriteField("language", strings.ToLower(strings.SplitN(languageCode, "-", 2)[0]))
+	}
+	if closeError := multipartWriter.Close(); closeError != nil {
+		return nil, closeError
+	}
+
+	httpRequest, requestError := http.NewRequestWithContext(jobContext, http.MethodPost, provider.baseURL+"/audio/transcriptions", &requestBody)
+	if requestError != nil {
+		return nil, fmt.Errorf("failed to create whisper request: %w", requestError)
+	}
+	httpRequest.Header.Set("Authorization", "Bearer "+provider.apiKey)
+	httpRequest.Header.Set("Content-Type", multipartWriter.FormDataContentType())
+
+	httpResponse, responseError := provider.httpClient.Do(httpRequest)
+	if responseError != nil {
+		return nil, fmt.Errorf("whisper request failed: %w", responseError)
+	}
+	defer httpResponse.Body.Close()
+
+	responseBody, readingError := io.ReadAll(httpResponse.Body)
+	if readingError != nil {
+		return nil, fmt.Errorf("failed to read whisper response: %w", readingError)
+	}
+	if httpResponse.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("whisper returned status %d: %s", httpResponse.StatusCode, strings.TrimSpace(string(responseBody)))
+	}
+
+	var parsedResponse whisperResponse
+	if unmarshalingError := json.Unmarshal(responseBody, &parsedResponse); unmarshalingError != nil {
+		return nil, fmt.Errorf("failed to parse whisper response: %w", unmarshalingError)
+	}
+	return &parsedResponse, nil
+}
+
+// splitForUpload cuts an oversized file into pieces proportionally small enough to be uploaded
+func (provider *WhisperProvider) splitForUpload(audioPath string, fileSize int64) ([]string, error) {
+	duration, durationError := provider.mediaProcessor.GetDuration(audioPath)
+	if durationError != nil {
+		return nil, fmt.Errorf("failed to measure oversized audio file: %w", durationError)
+	}
+
+	pieceCount := int(math.Ceil(float64(fileSize) / float64(whisperMaximumUploadBytes)))
+	pieceDuration := int(duration / float64(pieceCount))
+	if pieceDuration <= 0 {
+		pieceDuration = 1
+	}
+
+	outputDirectory := strings.TrimSuffix(audioPath, filepath.Ext(audioPath)) + "_upload"
+	piecePaths, splitError := provider.mediaProcessor.SplitAudio(audioPath, outputDirectory, pieceDuration)
+	if splitError != nil {
+		return nil, fmt.Errorf("failed to split oversized audio file: %w", splitError)
+	}
+	sort.Strings(piecePaths)
+	return piecePaths, nil
+}
+
+func (provider *WhisperProvider) supportsSegments() bool {
+	return !strings.HasPrefix(provider.model, "gpt-4o")
+}
+
+func (provider *WhisperProvider) pricePerMinute() float64 {
+	if price, exists := whisperPricesPerMinute[provider.model]; exists {
+		return price
+	}
+	return whisperPricesPerMinute["whisper-1"]
+}
+
+// toSegments converts the response into transcript segments; responses without timings become a
+// single segment spanning the whole chunk
+func (response *whisperResponse) toSegments(chunkDuration float64) []Segment {
+	var segments []Segment
+	for _, whisperSegment := range response.Segments {
+		text := strings.TrimSpace(whisperSegment.Text)
+		if text == "" {
+			continue
+		}
+
+		segment := Segment{Start: whisperSegment.Start, End: whisperSegment.End, Text: text}
+		if whisperSegment.AverageLogProb != nil {
+			segment.Confidence = logProbabilityToConfidence(*whisperSegment.AverageLogProb, whisperSegment.NoSpeechProb)
+		}
+		segments = append(segments, segment)
+	}
+
+	if len(segments) == 0 && strings.TrimSpace(response.Text) != "" {
+		segments = append(segments, Segment{End: chunkDuration, Text: strings.TrimSpace(response.Text)})
+	}
+	return segments
+}
+
+// logProbabilityToConfidence maps Whisper's average token log-probability to a 0..1 confidence,
+// discounted by the probability that the segment contains no speech at all
+func logProbabilityToConfidence(averageLogProbability float64, noSpeechProbability float64) float64 {
+	confidence := math.Exp(averageLogProbability) * (1 - noSpeechProbability)
+	return math.Max(0, math.Min(1, confidence))
+}
+
+// backfillConfidence gives segments without a confidence score the mean of the scored ones,
+// so downstream consumers never mistake "unknown" for "zero confidence"
+func backfillConfidence(segments []Segment) {
+	var confidenceSum float64
+	var scoredCount int
+	for _, segment := range segments {
+		if segment.Confidence > 0 {
+			confidenceSum += segment.Confidence
+			scoredCount++
+		}
+	}
+	if scoredCount == 0 || scoredCount == len(segments) {
+		return
+	}
+
+	meanConfidence := confidenceSum / float64(scoredCount)
+	for index := range segments {
+		if segments[index].Confidence == 0 {
+			segments[index].Confidence = meanConfidence
+		}
+	}
+}
@@ -0,0 +1,220 @@
+package transcription
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"lectures/internal/media"
+	"lectures/internal/models"
+)
+
+// WhisperLocalProvider transcribes audio on this machine through the whisperX CLI, which adds
+// word alignment and optional pyannote speaker diarization on top of faster-whisper
+type WhisperLocalProvider struct {
+	model            string
+	device           string
+	huggingFaceToken string
+	binDirectory     string
+}
+
+// whisperLocalWord is a single aligned word of a whisperX transcription
+type whisperLocalWord struct {
+	Word  string   `json:"word"`
+	Score *float64 `json:"score"`
+}
+
+// whisperLocalSegment is a single timed segment of a whisperX transcription
+type whisperLocalSegment struct {
+	Start   float64            `json:"start"`
+	End     float64            `json:"end"`
+	Text    string             `json:"text"`
+	Speaker string             `json:"speaker"`
+	Words   []whisperLocalWord `json:"words"`
+}
+
+// whisperLocalOutput is the JSON file written by whisperX
+type whisperLocalOutput struct {
+	Language string                `json:"language"`
+	Segments []whisperLocalSegment `json:"segments"`
+}
+
+// NewWhisperLocalProvider creates a local whisperX transcription provider; the Hugging Face token is only
+// needed for diarization, and an empty device lets whisperX pick one
+func NewWhisperLocalProvider(model string, device string, huggingFaceToken string, binDirectory string) *WhisperLocalProvider {
+	if model == "" {
+		model = "large-v3"
+	}
+	return &WhisperLocalProvider{
+		model:            model,
+		device:           device,
+		huggingFaceToken: huggingFaceToken,
+		binDirectory:     binDirectory,
+	}
+}
+
+// SetPrompt is a no-op: whisperX does not accept free-form instructions
+func (provider *WhisperLocalProvider) SetPrompt(prompt string) {}
+
+func (provider *WhisperLocalProvider) Name() string {
+	return "whisper-local"
+}
+
+func (provider *WhisperLocalProvider) CheckDependencies() error {
+	binaryPath := media.ResolveBinaryPath("whisperx", provider.binDirectory)
+	if _, lookError := exec.LookPath(binaryPath); lookError != nil {
+		return fmt.Errorf("whisperx not found (install it with `pip install whisperx`)")
+	}
+	return nil
+}
+
+func (provider *WhisperLocalProvider) Transcribe(jobContext context.Context, audioPath string) ([]Segment, models.JobMetrics, error) {
+	// Local inference has no per-minute price
+	var metrics models.JobMetrics
+
+	output, runError := provider.run(jobContext, audioPath)
+	if runError != nil {
+		return nil, metrics, runError
+	}
+
+	segments := output.toSegments()
+	if len(segments) == 0 {
+		return nil, metrics, fmt.Errorf("no transcription received from whisperx")
+	}
+
+	backfillConfidence(segments)
+	return segments, metrics, nil
+}
+
+// DetectLanguage runs whisperX without a language and returns the language it detected, along with the
+// transcription, diarized when the job asks for speakers as Transcribe does
+func (provider *WhisperLocalProvider) DetectLanguage(jobContext context.Context, audioPath string) (LanguageDetection, models.JobMetrics, error) {
+	var metrics models.JobMetrics
+
+	output, runError := provider.run(jobContext, audioPath)
+	if runError != nil {
+		return LanguageDetection{}, metrics, runError
+	}
+	if output.Language == "" {
+		return LanguageDetection{}, metrics, fmt.Errorf("whisperx did not report a detected language")
+	}
+	segments := output.toSegments()
+	backfillConfidence(segments)
+	return LanguageDetection{Language: output.Language, Segments: segments}, metrics, nil
+}
+
+// run invokes whisperX on a single audio file and parses its JSON output
+func (provider *WhisperLocalProvider) run(jobContext context.Context, audioPath string) (*whisperLocalOutput, error) {
+	outputDirectory := strings.TrimSuffix(audioPath, filepath.Ext(audioPath)) + "_whisperx"
+	if mkdirError := os.MkdirAll(outputDirectory, 0755); mkdirError != nil {
+		return nil, fmt.Errorf("failed to create whisperx output directory: %w", mkdirError)
+	}
+	defer os.RemoveAll(outputDirectory)
+
+	binaryPath := media.ResolveBinaryPath("whisperx", provider.binDirectory)
+	command := exec.CommandContext(jobContext, binaryPath, provider.buildArguments(jobContext, audioPath, outputDirectory)...)
+	if DiarizationFromContext(jobContext) && provider.huggingFaceToken != "" {
+		// Passed in the environment, which other local users cannot read as they can the command line
+		command.Env = append(os.Environ(), "HF_TOKEN="+provider.huggingFaceToken)
+	}
+	var stderr bytes.Buffer
+	command.Stderr = &stderr
+	if executionError := command.Run(); executionError != nil {
+		if jobContext.Err() != nil {
+			return nil, jobContext.Err()
+		}
+		return nil, fmt.Errorf("whisperx failed: %v, stderr: %s", executionError, strings.TrimSpace(stderr.String()))
+	}
+
+	outputPath := filepath.Join(outputDirectory, strings.TrimSuffix(filepath.Base(audioPath), filepath.Ext(audioPath))+".json")
+	outputData, readingError := os.ReadFile(outputPath)
+	if readingError != nil {
+		return nil, fmt.Errorf("failed to read whisperx output: %w", readingError)
+	}
+
+	var output whisperLocalOutput
+	if unmarshalingError := json.Unmarshal(outputData, &output); unmarshalingError != nil {
+		return nil, fmt.Errorf("failed to parse whisperx output: %w", unmarshalingError)
+	}
+	return &output, nil
+}
+
+// buildArguments assembles the whisperX command line for a single audio file
+func (provider *WhisperLocalProvider) buildArguments(jobContext context.Context, audioPath string, outputDirectory string) []string {
+	arguments := []string{
+		audioPath,
+		"--model", provider.model,
+		"--output_dir", outputDirectory,
+		"--output_format", "json",
+	}
+	if provider.device != "" {
+		arguments = append(arguments, "--device", provider.device)
+		// The default float16 compute type is unavailable on CPUs
+		if provider.device == "cpu" {
+			arguments = append(arguments, "--compute_type", "int8")
+		}
+	}
+	if languageCode := LanguageHintFromContext(jobContext); languageCode != "" {
+		// whisperX expects ISO-639-1 codes ("it"), not full BCP-47 tags ("it-IT")
+		arguments = append(arguments, "--language", strings.ToLower(strings.SplitN(languageCode, "-", 2)[0]))
+	}
+	if DiarizationFromContext(jobContext) {
+		if provider.huggingFaceToken == "" {
+			slog.WarnContext(jobContext, "Diarization requested but providers.huggingface.token is not configured, transcribing without speaker labels")
+		} else {
+			// The token reaches the diarization models through HF_TOKEN, set by run
+			arguments = append(arguments, "--diarize")
+		}
+	}
+	return arguments
+}
+
+// toSegments converts the whisperX output into transcript segments. Speaker labels are only
+// consistent within one audio chunk, since every chunk is diarized on its own
+func (output *whisperLocalOutput) toSegments() []Segment {
+	var segments []Segment
+	for _, localSegment := range output.Segments {
+		text := strings.TrimSpace(localSegment.Text)
+		if text == "" {
+			continue
+		}
+
+		segment := Segment{
+			Start:   localSegment.Start,
+			End:     localSegment.End,
+			Text:    text,
+			Speaker: whisperLocalSpeakerLabel(localSegment.Speaker),
+		}
+
+		var scoreSum float64
+		var scoredWords int
+		for _, word := range localSegment.Words {
+			if word.Score != nil {
+				scoreSum += *word.Score
+				scoredWords++
+			}
+		}
+		if scoredWords > 0 {
+			segment.Confidence = scoreSum / float64(scoredWords)
+		}
+
+		segments = append(segments, segment)
+	}
+	return segments
+}
+
+// whisperLocalSpeakerLabel turns pyannote's "SPEAKER_00" labels into the "Speaker 1" display labels used elsewhere
+func whisperLocalSpeakerLabel(speaker string) string {
+	speakerNumber, parseError := strconv.Atoi(strings.TrimPrefix(speaker, "SPEAKER_"))
+	if speaker == "" || parseError != nil {
+		return speaker
+	}
+	return fmt.Sprintf("Speaker %d", speakerNumber+1)
+}
@@ -0,0 +1,104 @@
+package transcription
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+// writeFakeWhisperX installs a whisperx stand-in that records its arguments and writes a canned JSON result
+func writeFakeWhisperX(tester *testing.T, binDirectory string, argumentsPath string) {
+	script := `#!/bin/sh
+echo "$@" "HF_TOKEN=$HF_TOKEN" > "` + argumentsPath + `"
+audio="$1"
+while [ "$#" -gt 0 ]; do
+	if [ "$1" = "--output_dir" ]; then output="$2"; fi
+	shift
+done
+name=$(basename "$audio")
+cat > "$output/${name%.*}.json" <<'EOF'
+{"segments": [
+	{"start": 0.0, "end": 3.0, "text": " Good morning everyone.", "speaker": "SPEAKER_00",
+	 "words": [{"word": "Good", "score": 0.9}, {"word": "morning", "score": 0.7}, {"word": "everyone.", "score": 0.8}]},
+	{"start": 3.0, "end": 5.5, "text": " Can you repeat that?", "speaker": "SPEAKER_01", "words": [{"word": "Can"}]},
+	{"start": 5.5, "end": 6.0, "text": " "}
+]}
+EOF
+`
+	if err := os.WriteFile(filepath.Join(binDirectory, "whisperx"), []byte(script), 0755); err != nil {
+		tester.Fatalf("Failed to write fake whisperx: %v", err)
+	}
+}
+
+func TestWhisperLocalProvider_TranscribeWithDiarization(tester *testing.T) {
+	if runtime.GOOS == "windows" {
+		tester.Skip("fake whisperx is a shell script")
+	}
+
+	temporaryDirectory := tester.TempDir()
+	argumentsPath := filepath.Join(temporaryDirectory, "arguments.txt")
+	writeFakeWhisperX(tester, temporaryDirectory, argumentsPath)
+
+	audioPath := filepath.Join(temporaryDirectory, "segment_000.mp3")
+	os.WriteFile(audioPath, []byte("fake audio"), 0644)
+
+	provider := NewWhisperLocalProvider("", "cpu", "hf_test", temporaryDirectory)
+	if err := provider.CheckDependencies(); err != nil {
+		tester.Fatalf("Expected fake whisperx to be found: %v", err)
+	}
+
+	jobContext := WithDiarization(WithLanguageHint(context.Background(), "en-US"), true)
+	segments, metrics, err := provider.Transcribe(jobContext, audioPath)
+	if err != nil {
+		tester.Fatalf("Transcribe failed: %v", err)
+	}
+
+	arguments, _ := os.ReadFile(argumentsPath)
+	for _, expected := range []string{"--model large-v3", "--language en", "--diarize", "--compute_type int8", "HF_TOKEN=hf_test"} {
+		if !strings.Contains(string(arguments), expected) {
+			tester.Errorf("Expected %q in whisperx arguments, got %q", expected, string(arguments))
+		}
+	}
+	if strings.Contains(string(arguments), "--hf_token") {
+		tester.Errorf("Expected the token to stay off the command line, got %q", string(arguments))
+	}
+
+	if len(segments) != 2 {
+		tester.Fatalf("Expected 2 non-empty segments, got %d", len(segments))
+	}
+	if segments[0].Speaker != "Speaker 1" || segments[1].Speaker != "Speaker 2" {
+		tester.Errorf("Expected speaker labels Speaker 1/Speaker 2, got %q/%q", segments[0].Speaker, segments[1].Speaker)
+	}
+	if segments[0].Confidence < 0.79 || segments[0].Confidence > 0.81 {
+		tester.Errorf("Expected mean word score 0.8, got %f", segments[0].Confidence)
+	}
+	if segments[1].Confidence != segments[0].Confidence {
+		tester.Errorf("Expected unscored segment to be backfilled, got %f", segments[1].Confidence)
+	}
+	if metrics.EstimatedCost != 0 {
+		tester.Errorf("Expected no cost for local transcription, got %f", metrics.EstimatedCost)
+	}
+}
+
+func TestWhisperLocalProvider_DiarizationToggle(tester *testing.T) {
+	provider := NewWhisperLocalProvider("small", "", "hf_test", "")
+
+	arguments := strings.Join(provider.buildArguments(context.Background(), "audio.mp3", "out"), " ")
+	if strings.Contains(arguments, "--diarize") {
+		tester.Errorf("Expected no diarization unless requested, got %q", arguments)
+	}
+
+	arguments = strings.Join(provider.buildArguments(WithDiarization(context.Background(), false), "audio.mp3", "out"), " ")
+	if strings.Contains(arguments, "--diarize") {
+		tester.Errorf("Expected diarization to stay off when disabled, got %q", arguments)
+	}
+
+	withoutToken := NewWhisperLocalProvider("small", "", "", "")
+	arguments = strings.Join(withoutToken.buildArguments(WithDiarization(context.Background(), true), "audio.mp3", "out"), " ")
+	if strings.Contains(arguments, "--diarize") {
+		tester.Errorf("Expected diarization to be skipped without a Hugging Face token, got %q", arguments)
+	}
+}
@@ -0,0 +1,125 @@
+package transcription
+
+import (
+	"context"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestWhisperProvider_Transcribe(tester *testing.T) {
+	receivedFields := make(map[string]string)
+	var receivedAuthorization string
+	mockServer := httptest.NewServer(http.HandlerFunc(func(responseWriter http.ResponseWriter, request *http.Request) {
+		receivedAuthorization = request.Header.Get("Authorization")
+		if err := request.ParseMultipartForm(1 << 20); err != nil {
+			tester.Errorf("Expected multipart upload: %v", err)
+		}
+		for key, values := range request.MultipartForm.Value {
+			receivedFields[key] = values[0]
+		}
+		if _, _, err := request.FormFile("file"); err != nil {
+			tester.Errorf("Expected audio file in upload: %v", err)
+		}
+
+		responseWriter.Header().Set("Content-Type", "application/json")
+		responseWriter.Write([]byte(`{
+			"text": "Buongiorno a tutti. Oggi parliamo di genetica.",
+			"duration": 90,
+			"segments": [
+				{"start": 0.0, "end": 2.5, "text": " Buongiorno a tutti.", "avg_logprob": -0.1, "no_speech_prob": 0.0},
+				{"start": 2.5, "end": 6.0, "text": " Oggi parliamo di genetica.", "no_speech_prob": 0.0},
+				{"start": 6.0, "end": 7.0, "text": " ", "avg_logprob": -2.0, "no_speech_prob": 0.9}
+			]
+		}`))
+	}))
+	defer mockServer.Close()
+
+	audioPath := filepath.Join(tester.TempDir(), "segment.mp3")
+	os.WriteFile(audioPath, []byte("fake audio"), 0644)
+
+	provider := NewWhisperProvider("test-key", "", mockServer.URL, "")
+
+	segments, metrics, err := provider.Transcribe(WithLanguageHint(context.Background(), "it-IT"), audioPath)
+	if err != nil {
+		tester.Fatalf("Transcribe failed: %v", err)
+	}
+
+	if receivedAuthorization != "Bearer test-key" {
+		tester.Errorf("Unexpected Authorization header: %q", receivedAuthorization)
+	}
+	if receivedFields["model"] != "whisper-1" || receivedFields["language"] != "it" || receivedFields["response_format"] != "verbose_json" {
+		tester.Errorf("Unexpected request fields: %v", receivedFields)
+	}
+
+	if len(segments) != 2 {
+		tester.Fatalf("Expected blank segments to be dropped, got %d segments", len(segments))
+	}
+	if segments[0].Text != "Buongiorno a tutti." || segments[1].Start != 2.5 {
+		tester.Errorf("Unexpected segments: %+v", segments)
+	}
+
+	expectedConfidence := math.Exp(-0.1)
+	if math.Abs(segments[0].Confidence-expectedConfidence) > 1e-9 {
+		tester.Errorf("Expected confidence %f, got %f", expectedConfidence, segments[0].Confidence)
+	}
+	// The segment without avg_logprob is backfilled with the mean of the scored ones
+	if segments[1].Confidence != segments[0].Confidence {
+		tester.Errorf("Expected backfilled confidence %f, got %f", segments[0].Confidence, segments[1].Confidence)
+	}
+
+	if math.Abs(metrics.EstimatedCost-1.5*whisperPricesPerMinute["whisper-1"]) > 1e-9 {
+		tester.Errorf("Unexpected estimated cost: %f", metrics.EstimatedCost)
+	}
+}
+
+func TestWhisperProvider_DetectLanguage(tester *testing.T) {
+	var receivedLanguage string
+	mockServer := httptest.NewServer(http.HandlerFunc(func(responseWriter http.ResponseWriter, request *http.Request) {
+		request.ParseMultipartForm(1 << 20)
+		receivedLanguage = request.FormValue("language")
+		responseWriter.Write([]byte(`{"text": "Buongiorno.", "language": "italian", "duration": 60, "segments": []}`))
+	}))
+	defer mockServer.Close()
+
+	audioPath := filepath.Join(tester.TempDir(), "segment.mp3")
+	os.WriteFile(audioPath, []byte("fake audio"), 0644)
+
+	provider := NewWhisperProvider("test-key", "", mockServer.URL, "")
+	detection, metrics, err := provider.DetectLanguage(context.Background(), audioPath)
+	if err != nil {
+		tester.Fatalf("DetectLanguage failed: %v", err)
+	}
+	if receivedLanguage != "" {
+		tester.Errorf("Expected no language parameter during detection, got %q", receivedLanguage)
+	}
+	if NormalizeLanguageCode(detection.Language) != "it" {
+		tester.Errorf("Expected italian to normalize to it, got %q", detection.Language)
+	}
+	if len(detection.Segments) != 1 || detection.Segments[0].Text != "Buongiorno." || detection.Segments[0].End != 60 {
+		tester.Errorf("Expected the transcription to come with the language, got %+v", detection.Segments)
+	}
+	if metrics.EstimatedCost == 0 {
+		tester.Error("Expected detection cost to be reported")
+	}
+}
+
+func TestNormalizeLanguageCode(tester *testing.T) {
+	cases := map[string]string{
+		"Italian":   "it",
+		"it":        "it",
+		" \"EN\". ": "en",
+		"pt_br":     "pt-BR",
+		"de-DE":     "de-DE",
+		"Klingon":   "",
+		"":          "",
+	}
+	for input, expected := range cases {
+		if actual := NormalizeLanguageCode(input); actual != expected {
+			tester.Errorf("NormalizeLanguageCode(%q) = %q, expected %q", input, actual, expected)
+		}
+	}
+}
@@ -0,0 +1,413 @@
+// Package webhooks posts the events of the server, such as completed jobs and ready lectures, to the URLs users
+// register, so external systems can react without polling. Deliveries are queued in the database, signed with
+// the secret of their webhook and retried with a growing delay until the receiver accepts them.
+package webhooks
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+
+	"lectures/internal/configuration"
+	"lectures/internal/database"
+	"lectures/internal/models"
+	"lectures/internal/netguard"
+	"lectures/internal/secrets"
+
+	gonanoid "github.com/matoous/go-nanoid/v2"
+)
+
+// Events lists the events webhooks can subscribe to. The ping event is only sent on request, to test a webhook
+var Events = []string{
+	models.WebhookEventJobCompleted,
+	models.WebhookEventJobFailed,
+	models.WebhookEventJobCancelled,
+	models.WebhookEventLectureReady,
+	models.WebhookEventToolCreated,
+}
+
+// AllEvents subscribes a webhook to every event, including those added later
+const AllEvents = "*"
+
+// Bounds of the retries of a delivery: the first retry waits retryDelay, and each following one twice as long
+const (
+	retryDelay        = time.Minute
+	maximumRetryDelay = 6 * time.Hour
+)
+
+// Delivery loop timing: due deliveries are looked for every pollInterval, or as soon as one is queued, at most
+// batchSize at a time
+const (
+	pollInterval = 5 * time.Second
+	batchSize    = 20
+)
+
+// errPrivateAddress rejects connections to loopback, private and link-local addresses
+var errPrivateAddress = errors.New("webhooks cannot be posted to private network addresses")
+
+// Dispatcher queues the events of users for their webhooks and delivers them in the background
+type Dispatcher struct {
+	database      *sql.DB
+	configuration configuration.WebhooksConfiguration
+	cipher        *secrets.Cipher
+	client        *http.Client
+	wake          chan struct{}
+	startOnce     sync.Once
+}
+
+// NewDispatcher creates a dispatcher. The cipher opens the secrets deliveries are signed with
+func NewDispatcher(db *sql.DB, webhooksConfiguration configuration.WebhooksConfiguration, cipher *secrets.Cipher) *Dispatcher {
+	if webhooksConfiguration.MaximumAttempts <= 0 {
+		webhooksConfiguration.MaximumAttempts = 8
+	}
+	if webhooksConfiguration.TimeoutSeconds <= 0 {
+		webhooksConfiguration.TimeoutSeconds = 10
+	}
+	if webhooksConfiguration.DeliveryRetentionDays == 0 {
+		webhooksConfiguration.DeliveryRetentionDays = 30
+	}
+
+	dialer := &net.Dialer{Timeout: time.Duration(webhooksConfiguration.TimeoutSeconds) * time.Second}
+	transport := netguard.Transport(dialer, webhooksConfiguration.AllowPrivateNetworks)
+
+	return &Dispatcher{
+		database:      db,
+		configuration: webhooksConfiguration,
+		cipher:        cipher,
+		client: &http.Client{
+			Transport: transport,
+			Timeout:   time.Duration(webhooksConfiguration.TimeoutSeconds) * time.Second,
+			// A redirect is answered as a failure rather than followed, as it could lead to a private address
+			CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse },
+		},
+		wake: make(chan struct{}, 1),
+	}
+}
+
+// ValidateURL checks that a webhook URL is an absolute HTTP or HTTPS URL, and not a literal private address
+// unless they are allowed
+func (dispatcher *Dispatcher) ValidateURL(rawURL string) error {
+	parsed, err := url.Parse(rawURL)
+	if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Hostname() == "" {
+		return errors.New("url must be an absolute http or https URL")
+	}
+	if parsed.User != nil {
+		return errors.New("url must not contain credentials")
+	}
+	if dispatcher.configuration.AllowPrivateNetworks {
+		return nil
+	}
+	if netguard.CheckURL(parsed) != nil {
+		return errPrivateAddress
+	}
+	return nil
+}
+
+// Subscribed reports whether a webhook subscribed to an event
+func Subscribed(events []string, event string) bool {
+	return slices.Contains(events, event) || slices.Contains(events, AllEvents)
+}
+
+// Emit queues an event of a user for each of their active webhooks subscribed to it
+func (dispatcher *Dispatcher) Emit(userID string, event string, data any) {
+	targets, err := database.ListWebhookTargets(dispatcher.database, userID, "")
+	if err != nil {
+		slog.Error("Failed to list webhooks", "userID", userID, "event", event, "error", err)
+		return
+	}
+	for _, target := range targets {
+		if !Subscribed(target.Events, event) {
+			continue
+		}
+		if _, err := dispatcher.queue(target.ID, event, data); err != nil {
+			slog.Error("Failed to queue webhook delivery", "webhookID", target.ID, "event", event, "error", err)
+		}
+	}
+}
+
+// Ping queues a ping event for a webhook of a user, whatever events it subscribed to, returning the delivery
+func (dispatcher *Dispatcher) Ping(userID string, webhookID string) (models.WebhookDelivery, error) {
+	targets, err := database.ListWebhookTargets(dispatcher.database, userID, webhookID)
+	if err != nil {
+		return models.WebhookDelivery{}, err
+	}
+	if len(targets) == 0 {
+		return models.WebhookDelivery{}, sql.ErrNoRows
+	}
+	return dispatcher.queue(webhookID, models.WebhookEventPing, map[string]any{"webhook_id": webhookID})
+}
+
+// queue records a delivery of an event to a webhook and wakes the delivery loop
+func (dispatcher *Dispatcher) queue(webhookID string, event string, data any) (models.WebhookDelivery, error) {
+	deliveryID, err := gonanoid.New()
+	if err != nil {
+		return models.WebhookDelivery{}, err
+	}
+	now := time.Now()
+	payload, err := json.Marshal(map[string]any{
+		"id":         deliveryID,
+		"event":      event,
+		"created_at": now.UTC().Format(time.RFC3339),
+		"data":       data,
+	})
+	if err != nil {
+		return models.WebhookDelivery{}, err
+	}
+	delivery := models.WebhookDelivery{
+		ID:            deliveryID,
+		WebhookID:     webhookID,
+		Event:         event,
+		Payload:       string(payload),
+		Status:        models.WebhookDeliveryStatusPending,
+		NextAttemptAt: &now,
+		CreatedAt:     now,
+	}
+	if err := database.QueueWebhookDelivery(dispatcher.database, delivery); err != nil {
+		return delivery, err
+	}
+	dispatcher.Wake()
+	return delivery, nil
+}
+
+// Wake has the delivery loop look for due deliveries right away
+func (dispatcher *Dispatcher) Wake() {
+	select {
+	case dispatcher.wake <- struct{}{}:
+	default:
+	}
+}
+
+// JobUpdated emits the event of a job that completed, failed or was cancelled. Completed BUILD_MATERIAL jobs
+// also emit tool.created with the tool they built
+func (dispatcher *Dispatcher) JobUpdated(job *models.Job, status string) {
+	events := map[string]string{
+		models.JobStatusCompleted: models.WebhookEventJobCompleted,
+		models.JobStatusFailed:    models.WebhookEventJobFailed,
+		models.JobStatusCancelled: models.WebhookEventJobCancelled,
+	}
+	event, found := events[status]
+	if !found {
+		return
+	}
+	data := map[string]any{
+		"job_id":     job.ID,
+		"type":       job.Type,
+		"status":     status,
+		"course_id":  job.CourseID,
+		"lecture_id": job.LectureID,
+	}
+	// A result that is not JSON is sent as a string rather than failing the encoding of the whole event
+	if json.Valid([]byte(job.Result)) {
+		data["result"] = json.RawMessage(job.Result)
+	} else if job.Result != "" {
+		data["result"] = job.Result
+	}
+	if job.Error != "" {
+		data["error"] = job.Error
+	}
+	dispatcher.Emit(job.UserID, event, data)
+
+	if status != models.JobStatusCompleted || job.Type != models.JobTypeBuildMaterial {
+		return
+	}
+	var result struct {
+		ToolID string `json:"tool_id"`
+	}
+	if json.Unmarshal([]byte(job.Result), &result) != nil || result.ToolID == "" {
+		return
+	}
+	var examID, toolType, title string
+	var lectureID, languageCode sql.NullString
+	err := dispatcher.database.QueryRow(
+		"SELECT exam_id, lecture_id, type, title, language_code FROM tools WHERE id = ?", result.ToolID,
+	).Scan(&examID, &lectureID, &toolType, &title, &languageCode)
+	if err != nil {
+		slog.Warn("Failed to load the tool of a webhook event", "toolID", result.ToolID, "error", err)
+		return
+	}
+	dispatcher.Emit(job.UserID, models.WebhookEventToolCreated, map[string]any{
+		"tool_id":       result.ToolID,
+		"exam_id":       examID,
+		"lecture_id":    lectureID.String,
+		"type":          toolType,
+		"title":         title,
+		"language_code": languageCode.String,
+		"job_id":        job.ID,
+	})
+}
+
+// LectureReady emits lecture.ready to the owner of the exam of a lecture that just became ready
+func (dispatcher *Dispatcher) LectureReady(lectureID string) {
+	var userID, examID, title string
+	err := dispatcher.database.QueryRow(`
+		SELECT exams.user_id, lectures.exam_id, lectures.title
+		FROM lectures
+		JOIN exams ON lectures.exam_id = exams.id
+		WHERE lectures.id = ?
+	`, lectureID).Scan(&userID, &examID, &title)
+	if err != nil {
+		slog.Warn("Failed to load the lecture of a webhook event", "lectureID", lectureID, "error", err)
+		return
+	}
+	dispatcher.Emit(userID, models.WebhookEventLectureReady, map[string]any{
+		"lecture_id": lectureID,
+		"exam_id":    examID,
+		"title":      title,
+	})
+}
+
+// Start runs the delivery loop in the background. Deliveries no longer pending are pruned every hour once
+// older than the retention period
+func (dispatcher *Dispatcher) Start(ctx context.Context) {
+	dispatcher.startOnce.Do(func() {
+		go dispatcher.run(ctx)
+		slog.Info("Webhook dispatcher started")
+	})
+}
+
+// run delivers due deliveries until the context is done
+func (dispatcher *Dispatcher) run(ctx context.Context) {
+	pollTicker := time.NewTicker(pollInterval)
+	defer pollTicker.Stop()
+	pruneTicker := time.NewTicker(time.Hour)
+	defer pruneTicker.Stop()
+
+	dispatcher.prune()
+	for {
+		dispatcher.DeliverDue(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-dispatcher.wake:
+		case <-pollTicker.C:
+		case <-pruneTicker.C:
+			dispatcher.prune()
+		}
+	}
+}
+
+// prune removes the deliveries older than the retention period
+func (dispatcher *Dispatcher) prune() {
+	if dispatcher.configuration.DeliveryRetentionDays < 0 {
+		return
+	}
+	before := time.Now().AddDate(0, 0, -dispatcher.configuration.DeliveryRetentionDays)
+	prunedCount, err := database.PruneWebhookDeliveries(dispatcher.database, before)
+	if err != nil {
+		slog.Error("Failed to prune webhook deliveries", "error", err)
+	} else if prunedCount > 0 {
+		slog.Info("Pruned webhook deliveries", "count", prunedCount)
+	}
+}
+
+// DeliverDue attempts the deliveries that are due, until none is left
+func (dispatcher *Dispatcher) DeliverDue(ctx context.Context) {
+	// A claimed delivery is not attempted again by another process before its attempt times out
+	lease := 2 * time.Duration(dispatcher.configuration.TimeoutSeconds) * time.Second
+	for ctx.Err() == nil {
+		deliveries, err := database.ClaimDueWebhookDeliveries(dispatcher.database, time.Now(), lease, batchSize)
+		if err != nil {
+			slog.Error("Failed to claim webhook deliveries", "error", err)
+			return
+		}
+		if len(deliveries) == 0 {
+			return
+		}
+		var group sync.WaitGroup
+		for _, delivery := range deliveries {
+			group.Add(1)
+			go func() {
+				defer group.Done()
+				dispatcher.attempt(ctx, delivery)
+			}()
+		}
+		group.Wait()
+	}
+}
+
+// attempt posts a delivery and records its outcome, scheduling a retry when it fails with attempts left
+func (dispatcher *Dispatcher) attempt(ctx context.Context, delivery database.DueWebhookDelivery) {
+	attempts := delivery.Attempts + 1
+	responseStatus, err := dispatcher.post(ctx, delivery)
+
+	var nextAttemptAt *time.Time
+	attemptError := ""
+	if err != nil {
+		attemptError = err.Error()
+		if attempts < dispatcher.configuration.MaximumAttempts {
+			retryAt := time.Now().Add(retryBackoff(attempts))
+			nextAttemptAt = &retryAt
+		} else {
+			slog.Warn("Webhook delivery given up", "deliveryID", delivery.ID, "webhookID", delivery.WebhookID, "attempts", attempts, "error", err)
+		}
+	}
+	if err := database.RecordWebhookAttempt(dispatcher.database, delivery.ID, attempts, responseStatus, attemptError, err == nil, nextAttemptAt); err != nil {
+		slog.Error("Failed to record webhook delivery attempt", "deliveryID", delivery.ID, "error", err)
+	}
+}
+
+// retryBackoff is the delay before the retry following a delivery's attempts-th failed attempt
+func retryBackoff(attempts int) time.Duration {
+	if attempts > 20 {
+		return maximumRetryDelay
+	}
+	return min(retryDelay<<(attempts-1), maximumRetryDelay)
+}
+
+// post sends a delivery to its webhook, returning the status the receiver answered. Only 2xx statuses accept it
+func (dispatcher *Dispatcher) post(ctx context.Context, delivery database.DueWebhookDelivery) (int, error) {
+	if dispatcher.cipher == nil {
+		return 0, errors.New("webhook secrets cannot be opened without an encryption key")
+	}
+	secret, err := dispatcher.cipher.Open(delivery.SealedSecret)
+	if err != nil {
+		return 0, fmt.Errorf("failed to open the webhook secret: %w", err)
+	}
+
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, delivery.URL, bytes.NewReader([]byte(delivery.Payload)))
+	if err != nil {
+		return 0, err
+	}
+	request.Header.Set("Content-Type", "application/json")
+	request.Header.Set("User-Agent", "LecturesAssistant-Webhooks")
+	request.Header.Set("X-Webhook-Event", delivery.Event)
+	request.Header.Set("X-Webhook-Delivery", delivery.ID)
+	request.Header.Set("X-Webhook-Timestamp", timestamp)
+	request.Header.Set("X-Webhook-Signature", "sha256="+Sign(secret, timestamp, []byte(delivery.Payload)))
+
+	response, err := dispatcher.client.Do(request)
+	if err != nil {
+		return 0, err
+	}
+	defer response.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(response.Body, 64*1024))
+	if response.StatusCode < 200 || response.StatusCode > 299 {
+		return response.StatusCode, fmt.Errorf("receiver answered %s", response.Status)
+	}
+	return response.StatusCode, nil
+}
+
+// Sign returns the hex HMAC-SHA256, keyed with the webhook secret, of the timestamp of a delivery, a dot and
+// its body. Receivers compute it to check a delivery came from this server and was not replayed later
+func Sign(secret string, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp + "."))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}